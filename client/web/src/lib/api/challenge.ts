@@ -0,0 +1,84 @@
+// Registration challenge: a proof-of-work puzzle solved here, or an
+// hCaptcha or Turnstile widget whose token the server verifies.
+
+export interface RegistrationChallenge {
+	type: 'off' | 'pow' | 'hcaptcha' | 'turnstile';
+	site_key?: string;
+	challenge?: string;
+	difficulty?: number;
+}
+
+export interface ChallengeSolution {
+	token?: string;
+	challenge?: string;
+	nonce?: string;
+}
+
+export async function getRegistrationChallenge(): Promise<RegistrationChallenge> {
+	const response = await fetch('/api/auth/challenge', { cache: 'no-store' });
+	if (!response.ok) return { type: 'off' };
+	const body = await response.json();
+	return body.data;
+}
+
+function leadingZeroBits(hash: Uint8Array): number {
+	let zeros = 0;
+	for (const byte of hash) {
+		if (byte !== 0) return zeros + Math.clz32(byte) - 24;
+		zeros += 8;
+	}
+	return zeros;
+}
+
+// Finds a nonce such that SHA-256(challenge + nonce) starts with
+// difficulty zero bits, as the server checks
+export async function solveProofOfWork(challenge: string, difficulty: number): Promise<ChallengeSolution> {
+	const encoder = new TextEncoder();
+	for (let nonce = 0; ; nonce++) {
+		const hash = await crypto.subtle.digest('SHA-256', encoder.encode(challenge + nonce));
+		if (leadingZeroBits(new Uint8Array(hash)) >= difficulty) {
+			return { challenge, nonce: String(nonce) };
+		}
+	}
+}
+
+const captchaScripts = {
+	hcaptcha: 'https://js.hcaptcha.com/1/api.js?render=explicit',
+	turnstile: 'https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit'
+};
+
+interface CaptchaAPI {
+	render(container: HTMLElement, options: { sitekey: string; callback: (token: string) => void }): unknown;
+}
+
+// Renders the CAPTCHA widget into container; onToken gets each response
+// token the user earns
+export function renderCaptcha(
+	type: 'hcaptcha' | 'turnstile',
+	siteKey: string,
+	container: HTMLElement,
+	onToken: (token: string) => void
+): Promise<void> {
+	return new Promise((resolve, reject) => {
+		const render = () => {
+			const api = (window as unknown as Record<string, CaptchaAPI | undefined>)[type];
+			if (!api) return false;
+			api.render(container, { sitekey: siteKey, callback: onToken });
+			resolve();
+			return true;
+		};
+		if (render()) return;
+
+		const script = document.createElement('script');
+		script.src = captchaScripts[type];
+		script.async = true;
+		script.onload = () => {
+			// The API object can appear a moment after the script loads
+			const timer = setInterval(() => {
+				if (render()) clearInterval(timer);
+			}, 50);
+		};
+		script.onerror = () => reject(new Error(`Failed to load ${type}`));
+		document.head.appendChild(script);
+	});
+}
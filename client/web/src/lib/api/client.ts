@@ -8,7 +8,8 @@ import type {
 	User,
 	Server,
 	Channel,
-	Message
+	Message,
+	UserPolicies
 } from '$lib/types';
 import { getStorageItem, setStorageItem, removeStorageItem } from '$lib/utils';
 
@@ -150,6 +151,19 @@ class ApiClient {
 		});
 	}
 
+	// Terms of service and rules the user has to acknowledge before posting
+	async getUserPolicies(): Promise<UserPolicies> {
+		const response = await this.request<{ success: boolean; data: UserPolicies }>('/api/user/policies');
+		return response.data;
+	}
+
+	async acknowledgePolicy(kind: string, version: number): Promise<void> {
+		await this.request<void>(`/api/user/policies/${kind}/acknowledge`, {
+			method: 'POST',
+			body: JSON.stringify({ version })
+		});
+	}
+
 	// Server endpoints
 	async getServers(): Promise<Server[]> {
 		const response = await this.request<{ servers: Server[] }>('/api/servers');
@@ -28,6 +28,9 @@ class WebSocketClient {
 	private isManualDisconnect = false;
 	private isConnectingState = false;
 	private eventHandlers = new Map<string, EventHandler[]>();
+	// Milliseconds to add to Date.now() for the server's time
+	private clockOffset = 0;
+	private clockRoundTrip: number | null = null;
 
 	constructor(options: WebSocketOptions = {}) {
 		this.url = options.url || WS_BASE_URL;
@@ -61,6 +64,7 @@ class WebSocketClient {
 					console.log('WebSocket connection opened successfully');
 					this.isConnectingState = false;
 					this.reconnectAttempts = 0;
+					this.clockRoundTrip = null;
 					this.startHeartbeat();
 					this.emit('connected', { timestamp: new Date() });
 					resolve();
@@ -232,8 +236,13 @@ class WebSocketClient {
 					break;
 				
 				case 'pong':
-					// Handle pong response (heartbeat reply)
-					console.log('💓 [WEBSOCKET] Pong received');
+					// The pong echoes our send time, so the round trip is known
+					this.syncClock(data.data?.server_time, data.data?.client_time);
+					break;
+
+				case 'hello':
+				case 'clock':
+					this.syncClock(data.data?.server_time);
 					break;
 				
 				default:
@@ -252,11 +261,34 @@ class WebSocketClient {
 		this.stopHeartbeat();
 		this.heartbeatTimer = setInterval(() => {
 			if (this.ws?.readyState === WebSocket.OPEN) {
-				this.ws.send(JSON.stringify({ type: 'heartbeat' }));
+				this.ws.send(JSON.stringify({ type: 'heartbeat', data: { client_time: Date.now() } }));
 			}
 		}, this.heartbeatInterval);
 	}
 
+	// Estimates the server clock's offset. Pongs measure the round trip and
+	// assume the server answered halfway through it; the best round trip
+	// seen wins. Frames without one only set the offset until a pong does.
+	private syncClock(serverTime?: number, clientTime?: number): void {
+		if (!serverTime) return;
+		const now = Date.now();
+		if (clientTime) {
+			const roundTrip = now - clientTime;
+			if (this.clockRoundTrip === null || roundTrip <= this.clockRoundTrip) {
+				this.clockRoundTrip = roundTrip;
+				this.clockOffset = serverTime - (clientTime + roundTrip / 2);
+			}
+		} else if (this.clockRoundTrip === null) {
+			this.clockOffset = serverTime - now;
+		}
+	}
+
+	// The server's current time in milliseconds, for ordering and expiring
+	// things by server timestamps
+	serverNow(): number {
+		return Date.now() + this.clockOffset;
+	}
+
 	private stopHeartbeat(): void {
 		if (this.heartbeatTimer) {
 			clearInterval(this.heartbeatTimer);
@@ -33,6 +33,7 @@ export interface Channel {
 	type: 'text' | 'voice';
 	serverId: number;
 	position: number;
+	nsfw?: boolean;
 	createdAt: Date;
 	updatedAt?: Date;
 }
@@ -46,6 +47,7 @@ export interface Message {
 	createdAt: Date;
 	updatedAt?: Date;
 	isEdited: boolean;
+	editCount?: number;
 	// Enhanced chat features
 	replyToId?: number;
 	replyTo?: Message;
@@ -103,6 +105,25 @@ export interface AuthResponse {
 	expiresAt?: Date;
 }
 
+// Terms of service and rules, acknowledged before posting
+export interface PolicyDocument {
+	kind: 'terms' | 'rules';
+	version: number;
+	title: string;
+	content: string;
+	created_at: string;
+}
+
+export interface UserPolicies {
+	policies: {
+		policy: PolicyDocument;
+		acknowledged: boolean;
+		acknowledged_at?: string;
+		previously_acknowledged?: boolean;
+	}[];
+	outstanding: number;
+}
+
 // WebSocket event types
 export interface WebSocketEvent {
 	type: string;
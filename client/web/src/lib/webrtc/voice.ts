@@ -144,7 +144,7 @@ class VoiceClient {
 			console.log('Connecting to voice WebSocket...');
 			
 			// Use relative URL in browser to leverage Vite's proxy
-			const wsUrl = typeof window !== 'undefined' ? `/voice?token=${encodeURIComponent(token)}` : `${serverUrl.replace('https://', 'wss://').replace('http://', 'ws://')}/voice?token=${encodeURIComponent(token)}`;
+			const wsUrl = typeof window !== 'undefined' ? `/ws/voice?token=${encodeURIComponent(token)}` : `${serverUrl.replace('https://', 'wss://').replace('http://', 'ws://')}/ws/voice?token=${encodeURIComponent(token)}`;
 			console.log('WebSocket URL:', wsUrl);
 			console.log('Window object available:', typeof window !== 'undefined');
 			
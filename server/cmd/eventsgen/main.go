@@ -0,0 +1,24 @@
+// Command eventsgen writes the TypeScript event types of the web client
+// from the event catalog in internal/events. Run it from the server
+// directory after changing an event:
+//
+//	go run ./cmd/eventsgen
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"fethur/internal/events"
+)
+
+func main() {
+	out := flag.String("out", "../client/web/src/lib/api/events.gen.ts", "file to write")
+	flag.Parse()
+
+	if err := os.WriteFile(*out, []byte(events.TypeScript()), 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	log.Printf("Wrote %s", *out)
+}
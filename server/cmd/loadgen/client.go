@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "fethur/pkg/client"
+
+	"github.com/gorilla/websocket"
+)
+
+// messagePrefix marks messages sent by the load generator; the send time
+// follows so any receiver can compute the delivery latency
+const messagePrefix = "loadgen"
+
+// client is one simulated user: a chat WebSocket, periodic typing and
+// messages, and optionally a voice signaling connection
+type client struct {
+	config   *config
+	stats    *recorder
+	rooms    *voiceRooms
+	api      *sdk.Client
+	rng      *rand.Rand
+	username string
+
+	userID        int
+	textChannels  []int
+	voiceChannels []int
+
+	stream *sdk.Stream
+}
+
+func (c *client) login(ctx context.Context) error {
+	started := time.Now()
+	user, err := c.api.Login(ctx, c.username, c.config.password)
+	if err != nil {
+		return err
+	}
+	c.stats.observe("login", time.Since(started))
+	c.userID = user.ID
+	return nil
+}
+
+// discoverChannels lists the text and voice channels of the user's servers
+func (c *client) discoverChannels(ctx context.Context) error {
+	servers, err := c.api.Servers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, server := range servers {
+		started := time.Now()
+		channels, err := c.api.Channels(ctx, server.ID)
+		if err != nil {
+			return err
+		}
+		c.stats.observe("channels.list", time.Since(started))
+
+		for _, channel := range channels {
+			if channel.ChannelType == "voice" {
+				c.voiceChannels = append(c.voiceChannels, channel.ID)
+			} else {
+				c.textChannels = append(c.textChannels, channel.ID)
+			}
+		}
+	}
+
+	if len(c.textChannels) == 0 {
+		return fmt.Errorf("user %s is not a member of any server with text channels", c.username)
+	}
+
+	// Each client is active in a random subset of its channels
+	c.rng.Shuffle(len(c.textChannels), func(i, j int) {
+		c.textChannels[i], c.textChannels[j] = c.textChannels[j], c.textChannels[i]
+	})
+	if len(c.textChannels) > c.config.channelsPerClient {
+		c.textChannels = c.textChannels[:c.config.channelsPerClient]
+	}
+	return nil
+}
+
+// dial opens an authenticated voice signaling WebSocket on path
+func (c *client) dial(ctx context.Context, path string) (*websocket.Conn, error) {
+	target, err := url.Parse(c.config.baseURL + path)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "https" {
+		target.Scheme = "wss"
+	} else {
+		target.Scheme = "ws"
+	}
+	target.RawQuery = url.Values{"token": {c.api.Token()}}.Encode()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, target.String(), nil)
+	return conn, err
+}
+
+// connect opens the event stream and subscribes to the client's channels,
+// again after every reconnect. Generated messages have their delivery
+// latency recorded and every other event is counted.
+func (c *client) connect(ctx context.Context) error {
+	c.stream = c.api.Stream()
+	c.stream.OnConnect(func() {
+		for _, channelID := range c.textChannels {
+			if err := c.stream.Subscribe(channelID); err != nil {
+				c.stats.count("ws.errors")
+			}
+		}
+	})
+	c.stream.OnAny(func(sdk.Event) {
+		c.stats.count("ws.received")
+	})
+	c.stream.OnMessage(func(message sdk.Message) {
+		fields := strings.Fields(message.Content)
+		if len(fields) < 2 || fields[0] != messagePrefix {
+			return
+		}
+		sent, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return
+		}
+		latency := time.Since(time.Unix(0, sent))
+		if message.UserID == c.userID {
+			c.stats.observe("message.echo", latency)
+		} else {
+			c.stats.observe("message.fanout", latency)
+		}
+	})
+	c.stream.On(sdk.EventTyping, func(sdk.Event) {
+		c.stats.count("typing.received")
+	})
+	c.stream.On(sdk.EventError, func(sdk.Event) {
+		c.stats.count("ws.errors")
+	})
+
+	started := time.Now()
+	if err := c.stream.Connect(ctx); err != nil {
+		return err
+	}
+	c.stats.observe("ws.connect", time.Since(started))
+	return nil
+}
+
+// nextDelay returns an exponentially distributed delay for a per-minute
+// rate, so clients act independently like real users
+func nextDelay(rng *rand.Rand, perMinute float64) time.Duration {
+	if perMinute <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(rng.ExpFloat64() / perMinute * float64(time.Minute))
+}
+
+func (c *client) sendMessage(ctx context.Context) {
+	channelID := c.textChannels[c.rng.Intn(len(c.textChannels))]
+
+	// Type for a moment first, like a person would
+	if err := c.stream.Typing(channelID, true); err == nil {
+		c.stats.count("typing.sent")
+	}
+
+	started := time.Now()
+	content := fmt.Sprintf("%s %d message from %s", messagePrefix, started.UnixNano(), c.username)
+	if _, err := c.api.SendMessage(ctx, channelID, content); err != nil {
+		if ctx.Err() == nil {
+			c.stats.count("message.errors")
+		}
+		return
+	}
+	c.stats.observe("message.send", time.Since(started))
+	c.stats.count("message.sent")
+	_ = c.stream.Typing(channelID, false)
+}
+
+func (c *client) typeWithoutSending() {
+	channelID := c.textChannels[c.rng.Intn(len(c.textChannels))]
+	if err := c.stream.Typing(channelID, true); err == nil {
+		c.stats.count("typing.sent")
+	}
+	time.AfterFunc(2*time.Second, func() {
+		_ = c.stream.Typing(channelID, false)
+	})
+}
+
+// run logs in, connects and generates traffic until ctx is done
+func (c *client) run(ctx context.Context, voice bool) error {
+	if err := c.login(ctx); err != nil {
+		return fmt.Errorf("login as %s: %w", c.username, err)
+	}
+	if err := c.discoverChannels(ctx); err != nil {
+		return err
+	}
+	if err := c.connect(ctx); err != nil {
+		return fmt.Errorf("connect %s: %w", c.username, err)
+	}
+	c.stats.count("clients.connected")
+	go func() {
+		if err := c.stream.Run(ctx); err != nil {
+			c.stats.count("ws.errors")
+		}
+	}()
+
+	if voice {
+		if len(c.voiceChannels) == 0 {
+			c.stats.count("voice.no_channel")
+		} else {
+			go c.runVoice(ctx, rand.New(rand.NewSource(c.rng.Int63())))
+		}
+	}
+
+	messageTimer := time.NewTimer(nextDelay(c.rng, c.config.messageRate))
+	typingTimer := time.NewTimer(nextDelay(c.rng, c.config.typingRate))
+	defer messageTimer.Stop()
+	defer typingTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-messageTimer.C:
+			c.sendMessage(ctx)
+			messageTimer.Reset(nextDelay(c.rng, c.config.messageRate))
+		case <-typingTimer.C:
+			c.typeWithoutSending()
+			typingTimer.Reset(nextDelay(c.rng, c.config.typingRate))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Command loadgen simulates chat and voice clients against a running
+// server and reports latency percentiles. Point it at a database filled by
+// `fethur seed`, whose users share one password.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"fethur/internal/seed"
+	sdk "fethur/pkg/client"
+)
+
+// config holds the command line options
+type config struct {
+	baseURL           string
+	clients           int
+	voiceClients      int
+	userOffset        int
+	password          string
+	duration          time.Duration
+	rampUp            time.Duration
+	messageRate       float64 // messages per client per minute
+	typingRate        float64 // typing bursts without a message, per client per minute
+	signalRate        float64 // voice pings and signaling messages per voice client per minute
+	channelsPerClient int
+	seed              int64
+}
+
+func main() {
+	defaults := seed.DefaultOptions()
+	cfg := &config{}
+	flag.StringVar(&cfg.baseURL, "url", "http://localhost:8081", "server base URL")
+	flag.IntVar(&cfg.clients, "clients", 20, "number of simulated users, each a seeded account")
+	flag.IntVar(&cfg.voiceClients, "voice", 5, "how many of the clients also join a voice channel")
+	flag.IntVar(&cfg.userOffset, "user-offset", 0, "index of the first seeded user to log in as")
+	flag.StringVar(&cfg.password, "password", defaults.Password, "password of the seeded users")
+	flag.DurationVar(&cfg.duration, "duration", time.Minute, "how long to generate load after ramp-up")
+	flag.DurationVar(&cfg.rampUp, "ramp", 10*time.Second, "time over which clients connect")
+	flag.Float64Var(&cfg.messageRate, "message-rate", 6, "messages per client per minute")
+	flag.Float64Var(&cfg.typingRate, "typing-rate", 6, "typing bursts without a message per client per minute")
+	flag.Float64Var(&cfg.signalRate, "signal-rate", 30, "voice pings and signaling messages per voice client per minute")
+	flag.IntVar(&cfg.channelsPerClient, "channels", 3, "text channels each client joins")
+	flag.Int64Var(&cfg.seed, "seed", 1, "random seed for client behavior")
+	flag.Parse()
+
+	cfg.baseURL = strings.TrimRight(cfg.baseURL, "/")
+	if cfg.clients < 1 || cfg.channelsPerClient < 1 {
+		log.Fatal("-clients and -channels must be positive")
+	}
+	if cfg.voiceClients > cfg.clients {
+		cfg.voiceClients = cfg.clients
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, cfg.rampUp+cfg.duration)
+	defer cancelTimeout()
+
+	stats := newRecorder()
+	rooms := newVoiceRooms()
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	rng := rand.New(rand.NewSource(cfg.seed))
+
+	log.Printf("Starting %d clients (%d with voice) against %s for %s", cfg.clients, cfg.voiceClients, cfg.baseURL, cfg.rampUp+cfg.duration)
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.clients; i++ {
+		c := &client{
+			config:   cfg,
+			stats:    stats,
+			rooms:    rooms,
+			api:      sdk.New(cfg.baseURL, sdk.WithHTTPClient(httpClient)),
+			rng:      rand.New(rand.NewSource(rng.Int63())),
+			username: seed.Username(cfg.userOffset + i),
+		}
+		voice := i < cfg.voiceClients
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.run(ctx, voice); err != nil && ctx.Err() == nil {
+				stats.count("clients.failed")
+				log.Printf("Client failed: %v", err)
+			}
+		}()
+
+		// Spread connections over the ramp-up period
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.rampUp / time.Duration(cfg.clients)):
+		}
+	}
+
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-progress.C:
+			counters := stats.counterValues()
+			log.Printf("%s: %d clients, %d voice, %d messages sent, %d errors",
+				time.Since(started).Round(time.Second), counters["clients.connected"], counters["voice.connected"],
+				counters["message.sent"], counters["message.errors"]+counters["clients.failed"]+counters["voice.errors"])
+		case <-done:
+			stats.print(os.Stdout, time.Since(started))
+			if counters := stats.counterValues(); counters["clients.connected"] == 0 {
+				fmt.Fprintln(os.Stderr, "No client connected; is the server running and seeded?")
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
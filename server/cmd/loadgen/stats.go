@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recorder collects latency samples and event counters from every client
+type recorder struct {
+	mutex    sync.Mutex
+	samples  map[string][]time.Duration
+	counters map[string]int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		samples:  make(map[string][]time.Duration),
+		counters: make(map[string]int64),
+	}
+}
+
+// observe records one latency sample
+func (r *recorder) observe(name string, latency time.Duration) {
+	r.mutex.Lock()
+	r.samples[name] = append(r.samples[name], latency)
+	r.mutex.Unlock()
+}
+
+// count increments an event counter
+func (r *recorder) count(name string) {
+	r.mutex.Lock()
+	r.counters[name]++
+	r.mutex.Unlock()
+}
+
+// latencySummary is the distribution of one latency metric
+type latencySummary struct {
+	Name  string
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// summaries returns the latency distributions ordered by name
+func (r *recorder) summaries() []latencySummary {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	summaries := make([]latencySummary, 0, len(r.samples))
+	for name, samples := range r.samples {
+		sorted := append([]time.Duration(nil), samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		summaries = append(summaries, latencySummary{
+			Name:  name,
+			Count: len(sorted),
+			P50:   percentile(sorted, 50),
+			P90:   percentile(sorted, 90),
+			P99:   percentile(sorted, 99),
+			Max:   sorted[len(sorted)-1],
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// counterValues returns a copy of the event counters
+func (r *recorder) counterValues() map[string]int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	values := make(map[string]int64, len(r.counters))
+	for name, value := range r.counters {
+		values[name] = value
+	}
+	return values
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// print writes the final report
+func (r *recorder) print(w io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(w, "\n%-22s %8s %10s %10s %10s %10s\n", "latency", "count", "p50", "p90", "p99", "max")
+	for _, s := range r.summaries() {
+		fmt.Fprintf(w, "%-22s %8d %10s %10s %10s %10s\n", s.Name, s.Count,
+			round(s.P50), round(s.P90), round(s.P99), round(s.Max))
+	}
+
+	counters := r.counterValues()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "\n%-22s %8s %10s\n", "event", "count", "per sec")
+	for _, name := range names {
+		fmt.Fprintf(w, "%-22s %8d %10.1f\n", name, counters[name], float64(counters[name])/elapsed.Seconds())
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
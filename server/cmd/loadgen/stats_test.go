@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.expected {
+			t.Errorf("percentile(%v) = %v, expected %v", tt.p, got, tt.expected)
+		}
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("Expected 0 for no samples, got %v", got)
+	}
+	if got := percentile([]time.Duration{7}, 99); got != 7 {
+		t.Errorf("Expected the only sample, got %v", got)
+	}
+}
+
+func TestRecorderSummaries(t *testing.T) {
+	r := newRecorder()
+	for _, ms := range []int{30, 10, 20} {
+		r.observe("message.send", time.Duration(ms)*time.Millisecond)
+	}
+	r.observe("voice.ping", time.Millisecond)
+	r.count("message.sent")
+	r.count("message.sent")
+
+	summaries := r.summaries()
+	if len(summaries) != 2 || summaries[0].Name != "message.send" {
+		t.Fatalf("Unexpected summaries: %+v", summaries)
+	}
+	if summaries[0].Count != 3 || summaries[0].P50 != 20*time.Millisecond || summaries[0].Max != 30*time.Millisecond {
+		t.Errorf("Unexpected distribution: %+v", summaries[0])
+	}
+	if counters := r.counterValues(); counters["message.sent"] != 2 {
+		t.Errorf("Expected 2 sent messages, got %d", counters["message.sent"])
+	}
+}
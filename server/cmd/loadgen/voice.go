@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// voiceRooms tracks which simulated users are in which voice channel, so
+// signaling can be addressed to peers that are actually connected
+type voiceRooms struct {
+	mutex sync.RWMutex
+	rooms map[int]map[int]bool // channel ID -> user IDs
+}
+
+func newVoiceRooms() *voiceRooms {
+	return &voiceRooms{rooms: make(map[int]map[int]bool)}
+}
+
+func (r *voiceRooms) join(channelID, userID int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.rooms[channelID] == nil {
+		r.rooms[channelID] = make(map[int]bool)
+	}
+	r.rooms[channelID][userID] = true
+}
+
+func (r *voiceRooms) leave(channelID, userID int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.rooms[channelID], userID)
+}
+
+// peers returns the other users in a channel
+func (r *voiceRooms) peers(channelID, userID int) []int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	peers := make([]int, 0, len(r.rooms[channelID]))
+	for peer := range r.rooms[channelID] {
+		if peer != userID {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// voiceFrame is the subset of the voice signaling message the generator uses
+type voiceFrame struct {
+	Type      string                 `json:"type"`
+	ChannelID int                    `json:"channel_id"`
+	UserID    int                    `json:"user_id,omitempty"`
+	TargetID  *int                   `json:"target_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// runVoice joins a voice channel and exchanges pings and WebRTC signaling
+// with other simulated users in it until ctx is done. It has its own rng
+// because rand.Rand is not safe for concurrent use.
+func (c *client) runVoice(ctx context.Context, rng *rand.Rand) {
+	started := time.Now()
+	conn, err := c.dial(ctx, "/ws/voice")
+	if err != nil {
+		c.stats.count("voice.errors")
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var writeMutex sync.Mutex
+	write := func(frame voiceFrame) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(frame)
+	}
+
+	channelID := c.voiceChannels[rng.Intn(len(c.voiceChannels))]
+	joined := make(chan struct{})
+	pings := make(chan time.Time, 16)
+
+	go func() {
+		defer func() {
+			_ = conn.Close()
+		}()
+		joinedOnce := false
+		for {
+			var frame voiceFrame
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			switch frame.Type {
+			case "connected":
+				// Registered with the hub; joining before this is dropped
+				if err := write(voiceFrame{Type: "join-channel", ChannelID: channelID}); err != nil {
+					return
+				}
+			case "channel-joined":
+				if !joinedOnce {
+					joinedOnce = true
+					c.stats.observe("voice.join", time.Since(started))
+					close(joined)
+				}
+			case "ping":
+				_ = write(voiceFrame{Type: "pong", ChannelID: channelID})
+			case "pong":
+				select {
+				case sent := <-pings:
+					c.stats.observe("voice.ping", time.Since(sent))
+				default:
+				}
+			case "offer", "answer", "ice-candidate":
+				if sent, ok := frame.Data["sent"].(float64); ok {
+					c.stats.observe("voice.relay", time.Since(time.Unix(0, int64(sent))))
+				}
+				c.stats.count("voice.signals_received")
+				if frame.Type == "offer" && frame.UserID != 0 {
+					// Answer like a browser would
+					target := frame.UserID
+					if err := write(voiceFrame{
+						Type:      "answer",
+						ChannelID: channelID,
+						TargetID:  &target,
+						Data:      map[string]interface{}{"sdp": "v=0 loadgen", "sent": time.Now().UnixNano()},
+					}); err == nil {
+						c.stats.count("voice.signals_sent")
+					}
+				}
+			case "error":
+				c.stats.count("voice.errors")
+			}
+		}
+	}()
+
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		return
+	case <-time.After(10 * time.Second):
+		c.stats.count("voice.join_timeouts")
+		return
+	}
+	c.rooms.join(channelID, c.userID)
+	defer c.rooms.leave(channelID, c.userID)
+	c.stats.count("voice.connected")
+
+	ticker := time.NewTimer(nextDelay(rng, c.config.signalRate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = write(voiceFrame{Type: "leave-channel", ChannelID: channelID})
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		case <-ticker.C:
+			ticker.Reset(nextDelay(rng, c.config.signalRate))
+
+			// Alternate keepalive pings with signaling towards a random peer
+			if rng.Intn(2) == 0 {
+				select {
+				case pings <- time.Now():
+					if err := write(voiceFrame{Type: "ping", ChannelID: channelID}); err != nil {
+						return
+					}
+				default:
+				}
+				continue
+			}
+
+			peers := c.rooms.peers(channelID, c.userID)
+			if len(peers) == 0 {
+				continue
+			}
+			target := peers[rng.Intn(len(peers))]
+			kind := "ice-candidate"
+			if rng.Intn(4) == 0 {
+				kind = "offer"
+			}
+			if err := write(voiceFrame{
+				Type:      kind,
+				ChannelID: channelID,
+				TargetID:  &target,
+				Data:      map[string]interface{}{"sdp": "v=0 loadgen", "sent": time.Now().UnixNano()},
+			}); err != nil {
+				return
+			}
+			c.stats.count("voice.signals_sent")
+		}
+	}
+}
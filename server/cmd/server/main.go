@@ -1,41 +1,194 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"fethur/internal/auth"
 	"fethur/internal/database"
+	"fethur/internal/doctor"
+	"fethur/internal/mail"
+	"fethur/internal/plugins"
+	"fethur/internal/push"
 	"fethur/internal/server"
+	"fethur/internal/service"
+	"fethur/internal/snowflake"
+	"fethur/internal/storage"
+	"fethur/internal/update"
+	"fethur/internal/xmpp"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
+	// Get port from environment or use default
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	// `fethur version` prints the build's version
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Println(update.Version)
+		return
+	}
+
+	// `fethur update` installs the latest release
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		runUpdate(os.Args[2:])
+		return
+	}
+
+	// `fethur doctor` only reports on the configuration
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		results := doctor.Run(doctorConfig(port))
+		doctor.Print(os.Stdout, results)
+		if doctor.Failed(results) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// `fethur recover` rebuilds a damaged database from the last good backup
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		runRecover(os.Args[2:])
+		return
+	}
+
+	// `fethur promote` makes a stopped standby the primary
+	if len(os.Args) > 1 && os.Args[1] == "promote" {
+		runPromote(os.Args[2:])
+		return
+	}
+
+	// `fethur seed` fills the database with demo data
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeed(os.Args[2:])
+		return
+	}
+
+	// Refuse to start with a configuration that would fail at runtime
+	results := doctor.Run(doctorConfig(port))
+	for _, result := range results {
+		switch result.Status {
+		case doctor.StatusWarn:
+			log.Printf("Warning: %s: %s", result.Name, result.Message)
+		case doctor.StatusFail:
+			log.Printf("Startup check failed: %s: %s (fix: %s)", result.Name, result.Message, result.Fix)
+		}
+	}
+	if doctor.Failed(results) {
+		log.Fatal("Refusing to start; run `fethur doctor` for a full report")
+	}
+
 	// Initialize database
 	db, err := database.Init()
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+	if missing, err := db.MissingTables(); err != nil || len(missing) > 0 {
+		log.Fatalf("Database schema is incomplete after initialization (missing %v): %v", missing, err)
+	}
 	defer func() {
 		if err := db.Close(); err != nil {
 			log.Printf("Error closing database: %v", err)
 		}
 	}()
 
-	// Initialize auth service
-	authService := auth.NewService()
+	// Optional read replicas, as a comma-separated list of database paths
+	if replicas := os.Getenv("FETHUR_DB_REPLICAS"); replicas != "" {
+		for i, dsn := range strings.Split(replicas, ",") {
+			name := fmt.Sprintf("replica-%d", i+1)
+			if err := db.AddReplica(name, strings.TrimSpace(dsn)); err != nil {
+				log.Fatal("Failed to add read replica:", err)
+			}
+		}
+		db.StartReplicaHealthChecks(context.Background(), 15*time.Second)
+	}
+
+	// Instances sharing a database need different snowflake nodes
+	if node := os.Getenv("FETHUR_NODE_ID"); node != "" {
+		id, err := strconv.Atoi(node)
+		if err == nil {
+			err = snowflake.SetNode(id)
+		}
+		if err != nil {
+			log.Fatalf("Invalid FETHUR_NODE_ID %q: must be between 0 and %d", node, snowflake.MaxNode)
+		}
+	}
+
+	// Initialize auth service, optionally overriding the JWT issuer/audience
+	authOptions := auth.DefaultOptions()
+	if issuer := os.Getenv("FETHUR_JWT_ISSUER"); issuer != "" {
+		authOptions.Issuer = issuer
+	}
+	if audience := os.Getenv("FETHUR_JWT_AUDIENCE"); audience != "" {
+		authOptions.Audience = audience
+	}
+	// A configured secret is used as is; otherwise signing keys are generated
+	// once and kept in the data directory so restarts keep sessions valid
+	if secret := os.Getenv("FETHUR_JWT_SECRET"); secret != "" {
+		authOptions.Secret = []byte(secret)
+	} else {
+		keys, err := auth.LoadKeyStore(jwtKeysFile())
+		if err != nil {
+			log.Fatal("Failed to load JWT signing keys:", err)
+		}
+		authOptions.Keys = keys
+	}
+	authService := auth.NewServiceWithOptions(authOptions)
+
+	// Business rules shared by the API and realtime handlers
+	services := service.New(db, authService)
+
+	// Initialize plugin manager
+	pluginManager, err := plugins.NewManager(plugins.DefaultConfig(), plugins.NewStdLogger(), plugins.NewSQLDatabase(db.DB))
+	if err != nil {
+		log.Fatal("Failed to initialize plugin manager:", err)
+	}
+
+	// Initialize attachment storage
+	storageBackend, err := newStorageBackend()
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+
+	// Initialize email delivery
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatal("Failed to initialize mail:", err)
+	}
+
+	// Initialize mobile push notifications
+	pushGateway, err := newPushGateway()
+	if err != nil {
+		log.Fatal("Failed to initialize push notifications:", err)
+	}
 
 	// Initialize server
-	srv := server.New(db, authService)
+	srv := server.New(db, authService, services, pluginManager, storageBackend, mailer, pushGateway)
 
-	// Get port from environment or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+	// Bridge channels to XMPP when a component connection is configured
+	if addr := os.Getenv("FETHUR_XMPP_COMPONENT_ADDR"); addr != "" {
+		if err := srv.EnableXMPP(xmpp.Config{
+			Addr:   addr,
+			Domain: os.Getenv("FETHUR_XMPP_DOMAIN"),
+			Secret: os.Getenv("FETHUR_XMPP_SECRET"),
+		}); err != nil {
+			log.Fatal("Failed to initialize XMPP gateway:", err)
+		}
 	}
 
 	// Create HTTP server with timeouts
+	certFile, keyFile := os.Getenv("FETHUR_TLS_CERT"), os.Getenv("FETHUR_TLS_KEY")
 	httpServer := &http.Server{
 		Addr:         ":" + port,
 		Handler:      srv.Router(),
@@ -44,6 +197,170 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// HTTP/2 is negotiated over TLS by default; in plain HTTP behind a
+	// proxy it is served as h2c. WebSockets stay on HTTP/1.1 either way.
+	switch {
+	case os.Getenv("FETHUR_HTTP2") == "false":
+		httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	case certFile == "":
+		httpServer.Handler = h2c.NewHandler(httpServer.Handler, &http2.Server{IdleTimeout: httpServer.IdleTimeout})
+	}
+
 	log.Printf("Starting server on port %s", port)
+	if certFile != "" {
+		log.Fatal(httpServer.ListenAndServeTLS(certFile, keyFile))
+	}
 	log.Fatal(httpServer.ListenAndServe())
 }
+
+// doctorConfig collects the configuration validated by the startup checks
+func doctorConfig(port string) doctor.Config {
+	config := doctor.Config{
+		Port:        port,
+		CheckPort:   true,
+		JWTSecret:   os.Getenv("FETHUR_JWT_SECRET"),
+		JWTKeysFile: jwtKeysFile(),
+		CORSOrigins: server.CORSOrigins(),
+		// Reverse proxy deployment
+		TrustedProxies: server.TrustedProxies(),
+		BasePath:       server.BasePath(),
+		PublicURL:      server.PublicURL(),
+		Compression:    server.WebSocketCompression(),
+		Compaction:     os.Getenv("FETHUR_WS_COMPACTION"),
+		// Release checks and self-update
+		UpdateURL:       os.Getenv("FETHUR_UPDATE_URL"),
+		UpdatePublicKey: os.Getenv("FETHUR_UPDATE_PUBLIC_KEY"),
+		TLSCertFile:     os.Getenv("FETHUR_TLS_CERT"),
+		TLSKeyFile:      os.Getenv("FETHUR_TLS_KEY"),
+		StorageDir:      storageDir(),
+	}
+	if os.Getenv("FETHUR_STORAGE") == "s3" {
+		s3Config := s3ConfigFromEnv()
+		config.S3 = &s3Config
+	}
+	return config
+}
+
+func jwtKeysFile() string {
+	if path := os.Getenv("FETHUR_JWT_KEYS_FILE"); path != "" {
+		return path
+	}
+	return database.DataDir + "/jwt_keys.json"
+}
+
+func storageDir() string {
+	if dir := os.Getenv("FETHUR_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "./data/attachments"
+}
+
+func s3ConfigFromEnv() storage.S3Config {
+	return storage.S3Config{
+		Endpoint:  os.Getenv("FETHUR_S3_ENDPOINT"),
+		Region:    os.Getenv("FETHUR_S3_REGION"),
+		Bucket:    os.Getenv("FETHUR_S3_BUCKET"),
+		AccessKey: os.Getenv("FETHUR_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("FETHUR_S3_SECRET_KEY"),
+		PathStyle: os.Getenv("FETHUR_S3_PATH_STYLE") == "true",
+	}
+}
+
+// newStorageBackend selects the attachment storage backend from the
+// environment: local disk by default, or S3/MinIO with FETHUR_STORAGE=s3
+func newStorageBackend() (storage.Backend, error) {
+	if os.Getenv("FETHUR_STORAGE") != "s3" {
+		return storage.NewLocalBackend(storageDir())
+	}
+	return storage.NewS3Backend(s3ConfigFromEnv())
+}
+
+// newMailer selects the email provider from the environment. Without
+// FETHUR_MAIL_PROVIDER emails are written to the log.
+func newMailer() (*mail.Mailer, error) {
+	config := mail.Config{
+		From:     os.Getenv("FETHUR_MAIL_FROM"),
+		SiteName: os.Getenv("FETHUR_SITE_NAME"),
+		BaseURL:  server.PublicURL(),
+	}
+	if config.From == "" {
+		config.From = "Fethur <noreply@localhost>"
+	}
+
+	var provider mail.Provider
+	var err error
+	switch os.Getenv("FETHUR_MAIL_PROVIDER") {
+	case "", "log":
+		provider = mail.LogProvider{}
+	case "smtp":
+		port, _ := strconv.Atoi(os.Getenv("FETHUR_SMTP_PORT"))
+		provider, err = mail.NewSMTPProvider(mail.SMTPConfig{
+			Host:     os.Getenv("FETHUR_SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("FETHUR_SMTP_USERNAME"),
+			Password: os.Getenv("FETHUR_SMTP_PASSWORD"),
+			Security: os.Getenv("FETHUR_SMTP_SECURITY"),
+		})
+	case "sendgrid":
+		provider, err = mail.NewSendGridProvider(os.Getenv("FETHUR_SENDGRID_API_KEY"), os.Getenv("FETHUR_SENDGRID_ENDPOINT"))
+	case "ses":
+		provider, err = mail.NewSESProvider(mail.SESConfig{
+			Region:    os.Getenv("FETHUR_SES_REGION"),
+			AccessKey: os.Getenv("FETHUR_SES_ACCESS_KEY"),
+			SecretKey: os.Getenv("FETHUR_SES_SECRET_KEY"),
+			Endpoint:  os.Getenv("FETHUR_SES_ENDPOINT"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown FETHUR_MAIL_PROVIDER %q", os.Getenv("FETHUR_MAIL_PROVIDER"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mail.New(provider, config)
+}
+
+// newPushGateway configures FCM, APNs, ntfy and Gotify from the
+// environment. Platforms without configuration are left out; with none,
+// push is disabled.
+func newPushGateway() (*push.Gateway, error) {
+	var providers []push.Provider
+	if path := os.Getenv("FETHUR_FCM_CREDENTIALS"); path != "" {
+		credentials, err := push.LoadFCMCredentials(path)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := push.NewFCMProvider(credentials)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if keyFile := os.Getenv("FETHUR_APNS_KEY_FILE"); keyFile != "" {
+		provider, err := push.NewAPNsProvider(push.APNsConfig{
+			KeyFile: keyFile,
+			KeyID:   os.Getenv("FETHUR_APNS_KEY_ID"),
+			TeamID:  os.Getenv("FETHUR_APNS_TEAM_ID"),
+			Topic:   os.Getenv("FETHUR_APNS_TOPIC"),
+			Sandbox: os.Getenv("FETHUR_APNS_SANDBOX") == "true",
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if baseURL := os.Getenv("FETHUR_NTFY_URL"); baseURL != "" {
+		provider, err := push.NewNtfyProvider(baseURL, os.Getenv("FETHUR_NTFY_TOKEN"))
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if baseURL := os.Getenv("FETHUR_GOTIFY_URL"); baseURL != "" {
+		provider, err := push.NewGotifyProvider(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return push.NewGateway(providers...), nil
+}
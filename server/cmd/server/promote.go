@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"fethur/internal/database"
+	"fethur/internal/server"
+)
+
+// runPromote implements `fethur promote`, turning a stopped standby into
+// a primary after restoring the newest backup the old primary shipped
+func runPromote(args []string) {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	source := flags.String("source", server.StandbySource(), "where the primary shipped its backups")
+	_ = flags.Parse(args)
+
+	if database.Promoted() {
+		fmt.Fprintf(os.Stdout, "Already promoted; remove %s to follow a primary again\n", database.PromotedPath)
+		return
+	}
+	db, err := database.Init()
+	if err != nil {
+		log.Fatalf("Failed to open the database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	ctx := context.Background()
+	if *source != "" {
+		standby := database.NewStandby(db, *source)
+		if err := standby.Promote(ctx); err != nil {
+			log.Fatalf("Promotion failed: %v", err)
+		}
+		if applied := standby.Status().Applied; applied != nil {
+			fmt.Fprintf(os.Stdout, "Restored the backup from %s (%s)\n", applied.CreatedAt.Format("2006-01-02 15:04:05"), applied.Path)
+		}
+	} else if err := database.MarkPromoted(); err != nil {
+		log.Fatalf("Promotion failed: %v", err)
+	}
+
+	result, err := db.CheckIntegrity(ctx, true)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stdout, "Integrity check failed to run: %v\n", err)
+	case !result.OK:
+		fmt.Fprintf(os.Stdout, "Integrity check found %d problems; run `fethur recover`\n", len(result.Problems))
+	default:
+		fmt.Fprintln(os.Stdout, "Integrity check passed")
+	}
+	fmt.Fprintln(os.Stdout, "Promoted to primary; the next start takes writes even with FETHUR_STANDBY_SOURCE set")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"fethur/internal/database"
+)
+
+// runRecover implements `fethur recover`, rebuilding a damaged database
+// from the last good backup. The server must be stopped first.
+func runRecover(args []string) {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	backup := flags.String("backup", "", "backup to restore instead of the newest in "+database.BackupDir)
+	checkOnly := flags.Bool("check", false, "only run a full integrity check")
+	force := flags.Bool("force", false, "restore the backup even if the database checks out")
+	_ = flags.Parse(args)
+
+	intact := false
+	if db, err := database.OpenExisting(); err != nil {
+		fmt.Fprintf(os.Stdout, "Cannot open %s: %v\n", database.Path, err)
+	} else {
+		result, err := db.CheckIntegrity(context.Background(), true)
+		_ = db.Close()
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stdout, "Integrity check failed to run: %v\n", err)
+		case result.OK:
+			intact = true
+			fmt.Fprintln(os.Stdout, "Integrity check passed")
+		default:
+			fmt.Fprintf(os.Stdout, "Integrity check found %d problems:\n", len(result.Problems))
+			for _, problem := range result.Problems {
+				fmt.Fprintf(os.Stdout, "  %s\n", problem)
+			}
+		}
+	}
+	if *checkOnly {
+		if !intact {
+			os.Exit(1)
+		}
+		return
+	}
+	if intact && !*force {
+		fmt.Fprintln(os.Stdout, "Nothing to recover; pass -force to restore the backup anyway")
+		return
+	}
+
+	report, err := database.Recover(database.Path, *backup)
+	if report.DamagedPath != "" {
+		fmt.Fprintf(os.Stdout, "Moved the damaged database to %s\n", report.DamagedPath)
+	}
+	if err != nil {
+		log.Fatalf("Recovery failed: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "Restored the backup from %s (%s)\n", report.Backup.CreatedAt.Format("2006-01-02 15:04:05"), report.Backup.Path)
+
+	tables := make([]string, 0, len(report.RowsRecovered))
+	for table := range report.RowsRecovered {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Fprintf(os.Stdout, "  recovered %d newer rows of %s\n", report.RowsRecovered[table], table)
+	}
+	skipped := make([]string, 0, len(report.TablesSkipped))
+	for table := range report.TablesSkipped {
+		skipped = append(skipped, table)
+	}
+	sort.Strings(skipped)
+	for _, table := range skipped {
+		fmt.Fprintf(os.Stdout, "  could not read %s: %s\n", table, report.TablesSkipped[table])
+	}
+	if !report.Integrity.OK {
+		log.Fatalf("The rebuilt database still has %d problems; try an older backup with -backup", len(report.Integrity.Problems))
+	}
+	fmt.Fprintln(os.Stdout, "The rebuilt database checks out; start the server again")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"fethur/internal/auth"
+	"fethur/internal/database"
+	"fethur/internal/seed"
+)
+
+// runSeed implements `fethur seed`, filling the database with demo data
+func runSeed(args []string) {
+	options := seed.DefaultOptions()
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.IntVar(&options.Users, "users", options.Users, "number of users")
+	flags.IntVar(&options.Servers, "servers", options.Servers, "number of servers")
+	flags.IntVar(&options.ChannelsPerServer, "channels", options.ChannelsPerServer, "text channels per server")
+	flags.IntVar(&options.Messages, "messages", options.Messages, "number of messages")
+	flags.IntVar(&options.Days, "days", options.Days, "days of history to spread messages over")
+	flags.Int64Var(&options.Seed, "seed", options.Seed, "random seed; the same seed produces the same data")
+	flags.StringVar(&options.Password, "password", options.Password, "password for every seeded user")
+	until := flags.String("until", options.Until.Format("2006-01-02"), "date of the newest message (YYYY-MM-DD)")
+	_ = flags.Parse(args)
+
+	parsed, err := time.Parse("2006-01-02", *until)
+	if err != nil {
+		log.Fatalf("Invalid -until date: %v", err)
+	}
+	options.Until = parsed
+
+	db, err := database.Init()
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}()
+
+	hash, err := auth.NewService().HashPassword(options.Password)
+	if err != nil {
+		log.Fatal("Failed to hash password:", err)
+	}
+
+	started := time.Now()
+	summary, err := seed.Run(context.Background(), db.DB, hash, options)
+	if err != nil {
+		log.Fatal("Failed to seed database:", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "Seeded %d users, %d servers, %d text and %d voice channels, %d memberships and %d messages in %s\n",
+		summary.Users, summary.Servers, summary.Channels, summary.VoiceChannels, summary.Members, summary.Messages, time.Since(started).Round(time.Millisecond))
+	fmt.Fprintf(os.Stdout, "Every seeded user (alice_1, bob_2, ...) has the password %q\n", options.Password)
+}
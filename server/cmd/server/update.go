@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fethur/internal/update"
+)
+
+// runUpdate implements `fethur update`, replacing this binary with the
+// latest verified release
+func runUpdate(args []string) {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	checkOnly := flags.Bool("check", false, "only report whether an update is available")
+	rollback := flags.Bool("rollback", false, "restore the binary replaced by the last update")
+	force := flags.Bool("force", false, "install the latest release even if it is not newer")
+	_ = flags.Parse(args)
+
+	path, err := executablePath()
+	if err != nil {
+		log.Fatalf("Cannot locate the running binary: %v", err)
+	}
+	if *rollback {
+		if err := update.Rollback(path); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Println("Previous binary restored; restart the service to run it")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	client := &http.Client{Timeout: 10 * time.Minute}
+	release, err := update.Fetch(ctx, client, os.Getenv("FETHUR_UPDATE_URL"))
+	if err != nil {
+		log.Fatalf("Update check failed: %v", err)
+	}
+	if !update.Newer(release.Version, update.Version) && !*force {
+		fmt.Printf("Fethur %s is up to date (latest release %s)\n", update.Version, release.Version)
+		return
+	}
+	fmt.Printf("Fethur %s is available (running %s)\n", release.Version, update.Version)
+	if *checkOnly {
+		return
+	}
+
+	// Releases are only installed when signed with the configured key
+	key, err := update.ParsePublicKey(os.Getenv("FETHUR_UPDATE_PUBLIC_KEY"))
+	if err != nil {
+		log.Fatalf("Cannot verify releases: %v; set FETHUR_UPDATE_PUBLIC_KEY", err)
+	}
+	if err := update.Apply(ctx, client, release, key, path, startsAs(release.Version)); err != nil {
+		log.Fatalf("Update failed: %v", err)
+	}
+	fmt.Printf("Installed %s; restart the service to run it. `fethur update -rollback` restores %s.\n", release.Version, update.Version)
+}
+
+// executablePath returns the running binary with symlinks resolved
+func executablePath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// startsAs checks that an installed binary starts and reports version
+func startsAs(version string) func(path string) error {
+	return func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, path, "version").Output()
+		if err != nil {
+			return err
+		}
+		if reported := strings.TrimSpace(string(output)); reported != version {
+			return fmt.Errorf("it reports version %q", reported)
+		}
+		return nil
+	}
+}
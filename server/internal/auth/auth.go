@@ -4,97 +4,229 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	jwtSecret []byte
+	keys    *KeyStore
+	options Options
+
+	passwordPolicy PasswordPolicy
+	hashAlgorithm  string
+	argon2Params   Argon2Params
+	mutex          sync.RWMutex
 }
 
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	DeviceID string `json:"device_id,omitempty"`
+
+	// TokenVersion must match the user's token_version for the token to be
+	// accepted; bumping it invalidates every token issued before
+	TokenVersion int `json:"token_version"`
+
+	// Purpose marks tokens that only allow one step, like finishing a
+	// two-factor sign-in; ValidateToken refuses them
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Options configures token issuing and validation
+type Options struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration // tolerated clock skew when checking exp/nbf/iat
+
+	// Secret signs tokens; empty falls back to the development secret
+	Secret []byte
+
+	// Keys, when set, replaces Secret with rotatable signing keys
+	Keys *KeyStore
+}
+
+// MinSecretLength is the shortest signing secret considered safe
+const MinSecretLength = 32
+
+// ChallengeTokenTTL is how long a user has to enter a two-factor code
+// after giving the right password
+const ChallengeTokenTTL = 5 * time.Minute
+
+const twoFactorPurpose = "two_factor"
+
+// developmentSecret keeps tokens valid across restarts in development. It
+// is public, so production deployments must set their own secret.
+var developmentSecret = []byte("fethur-development-secret-key-2024")
+
+// DefaultOptions returns the token options used by NewService
+func DefaultOptions() Options {
+	return Options{
+		Issuer:   "fethur",
+		Audience: "fethur-clients",
+		Leeway:   30 * time.Second,
+	}
+}
+
 func NewService() *Service {
-	// For development, use a fixed secret so tokens remain valid across restarts
-	// In production, this should come from environment variables
-	secret := []byte("fethur-development-secret-key-2024")
+	return NewServiceWithOptions(DefaultOptions())
+}
+
+// NewServiceWithOptions creates an auth service with custom token options
+func NewServiceWithOptions(options Options) *Service {
+	keys := options.Keys
+	if keys == nil {
+		secret := options.Secret
+		if len(secret) == 0 {
+			secret = developmentSecret
+		}
+		keys = StaticKeyStore(secret)
+	}
 	return &Service{
-		jwtSecret: secret,
+		keys:           keys,
+		options:        options,
+		passwordPolicy: DefaultPasswordPolicy(),
+		hashAlgorithm:  HashBcrypt,
+		argon2Params:   DefaultArgon2Params(),
 	}
 }
 
-// HashPassword creates a bcrypt hash of the password
-func (s *Service) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// UsingDevelopmentSecret reports whether tokens are signed with the
+// built-in development secret
+func (s *Service) UsingDevelopmentSecret() bool {
+	return string(s.keys.Current().Secret) == string(developmentSecret)
 }
 
-// CheckPassword compares a password with its hash
-func (s *Service) CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// Keys returns the signing keys
+func (s *Service) Keys() *KeyStore {
+	return s.keys
 }
 
-// ValidatePassword checks if password meets requirements
+// ValidatePassword checks if password meets the configured policy
 func (s *Service) ValidatePassword(password string) error {
-	if len(password) < 9 {
-		return fmt.Errorf("password must be at least 9 characters long")
-	}
-
-	hasNumber := false
-	hasSpecial := false
+	return s.ValidatePasswordForUser(password, "")
+}
 
-	for _, char := range password {
-		if char >= '0' && char <= '9' {
-			hasNumber = true
-		}
-		if (char >= '!' && char <= '/') || (char >= ':' && char <= '@') || (char >= '[' && char <= '`') || (char >= '{' && char <= '~') {
-			hasSpecial = true
-		}
-	}
+// ValidatePasswordForUser checks a password against the configured policy,
+// including rules that depend on the account's username
+func (s *Service) ValidatePasswordForUser(password, username string) error {
+	return s.PasswordPolicy().Validate(password, username)
+}
 
-	if !hasNumber {
-		return fmt.Errorf("password must contain at least one number")
-	}
-	if !hasSpecial {
-		return fmt.Errorf("password must contain at least one special character")
-	}
+// PasswordPolicy returns the active password policy
+func (s *Service) PasswordPolicy() PasswordPolicy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.passwordPolicy
+}
 
-	return nil
+// SetPasswordPolicy replaces the active password policy
+func (s *Service) SetPasswordPolicy(policy PasswordPolicy) {
+	s.mutex.Lock()
+	s.passwordPolicy = policy
+	s.mutex.Unlock()
 }
 
 // GenerateToken creates a JWT token for a user with role
 func (s *Service) GenerateToken(userID int, username, role string) (string, error) {
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+	return s.GenerateDeviceToken(userID, username, role, "", 0)
+}
+
+// GenerateDeviceToken creates a JWT token bound to a known login device,
+// so the session can be revoked by revoking the device
+func (s *Service) GenerateDeviceToken(userID int, username, role, deviceID string, tokenVersion int) (string, error) {
+	return s.generateToken(Claims{
+		UserID:       userID,
+		Username:     username,
+		Role:         role,
+		DeviceID:     deviceID,
+		TokenVersion: tokenVersion,
+	}, AccessTokenTTL)
+}
+
+// GenerateChallengeToken creates a short-lived token for a user who gave
+// the right password but still has to enter a two-factor code. It only
+// works with ValidateChallengeToken.
+func (s *Service) GenerateChallengeToken(userID, tokenVersion int) (string, error) {
+	return s.generateToken(Claims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		Purpose:      twoFactorPurpose,
+	}, ChallengeTokenTTL)
+}
+
+func (s *Service) generateToken(claims Claims, ttl time.Duration) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    s.options.Issuer,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now()),
 	}
 
+	if s.options.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.options.Audience}
+	}
+
+	key := s.keys.Current()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Secret)
 }
 
 // ValidateToken validates a JWT token and returns the claims
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("token is only valid for %s", claims.Purpose)
+	}
+	return claims, nil
+}
+
+// ValidateChallengeToken validates a token from GenerateChallengeToken
+func (s *Service) ValidateChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != twoFactorPurpose {
+		return nil, fmt.Errorf("not a two-factor challenge token")
+	}
+	return claims, nil
+}
+
+func (s *Service) parseToken(tokenString string) (*Claims, error) {
+	parserOptions := []jwt.ParserOption{
+		jwt.WithLeeway(s.options.Leeway),
+		jwt.WithIssuedAt(),
+	}
+	if s.options.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(s.options.Issuer))
+	}
+	if s.options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(s.options.Audience))
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return s.jwtSecret, nil
-	})
+		// Tokens from before key IDs were signed with the current key
+		id, _ := token.Header["kid"].(string)
+		if id == "" {
+			return s.keys.Current().Secret, nil
+		}
+		key, ok := s.keys.Lookup(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", id)
+		}
+		return key.Secret, nil
+	}, parserOptions...)
 
 	if err != nil {
 		return nil, err
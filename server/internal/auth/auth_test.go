@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -42,6 +47,65 @@ func TestCheckPassword(t *testing.T) {
 	}
 }
 
+func TestArgon2idHashing(t *testing.T) {
+	service := NewService()
+	bcryptHash, err := service.HashPassword("testpassword123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if service.NeedsRehash(bcryptHash) {
+		t.Error("A bcrypt hash should not need rehashing while bcrypt is configured")
+	}
+
+	if err := service.SetPasswordHashing("md5", DefaultArgon2Params()); !errors.Is(err, ErrUnknownHashAlgorithm) {
+		t.Errorf("Unknown algorithm returned %v", err)
+	}
+	if err := service.SetPasswordHashing(HashArgon2id, Argon2Params{}); err == nil {
+		t.Error("Zero argon2id parameters should be refused")
+	}
+
+	params := Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	if err := service.SetPasswordHashing(HashArgon2id, params); err != nil {
+		t.Fatalf("Failed to select argon2id: %v", err)
+	}
+	hash, err := service.HashPassword("testpassword123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Unexpected argon2id hash %q", hash)
+	}
+	if !service.CheckPassword("testpassword123", hash) || service.CheckPassword("wrongpassword", hash) {
+		t.Error("CheckPassword disagrees with the argon2id hash")
+	}
+
+	// Old bcrypt hashes still check, and are due for an upgrade
+	if !service.CheckPassword("testpassword123", bcryptHash) {
+		t.Error("bcrypt hash no longer checks after switching to argon2id")
+	}
+	if !service.NeedsRehash(bcryptHash) {
+		t.Error("bcrypt hash should need rehashing once argon2id is configured")
+	}
+	if service.NeedsRehash(hash) {
+		t.Error("A current argon2id hash should not need rehashing")
+	}
+
+	// Raising the cost makes existing argon2id hashes due too
+	params.Iterations = 2
+	if err := service.SetPasswordHashing(HashArgon2id, params); err != nil {
+		t.Fatal(err)
+	}
+	if !service.NeedsRehash(hash) {
+		t.Error("A cheaper argon2id hash should need rehashing")
+	}
+
+	for _, malformed := range []string{"$argon2id$v=19$m=1024,t=0,p=1$AAAAAAAAAAAAAAAAAAAAAA$AAAA", "$argon2id$v=18$m=1024,t=1,p=1$AAAA$AAAA", "$argon2id$broken"} {
+		if service.CheckPassword("testpassword123", malformed) {
+			t.Errorf("Malformed hash %q accepted", malformed)
+		}
+	}
+}
+
 func TestGenerateToken(t *testing.T) {
 	service := NewService()
 	userID := 1
@@ -111,3 +175,213 @@ func TestGenerateRandomString(t *testing.T) {
 		t.Error("Generated short random string should not be empty")
 	}
 }
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	tests := []struct {
+		password string
+		username string
+		valid    bool
+	}{
+		{"short1!", "", false},
+		{"nonumbers!!", "", false},
+		{"nospecial123", "", false},
+		{"correct-horse-9", "", true},
+		{"alice-rocks-9", "alice", false},
+		{"Alice-Rocks-9", "ALICE", false},
+		{"Password123!", "", false},
+		{"p@ssw0rd1", "", false},
+	}
+
+	for _, tt := range tests {
+		err := policy.Validate(tt.password, tt.username)
+		if tt.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", tt.password, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected %q to be rejected", tt.password)
+		}
+	}
+
+	policy.RequireUpper = true
+	if err := policy.Validate("correct-horse-9", ""); err == nil {
+		t.Error("Expected password without uppercase letter to be rejected")
+	}
+
+	service := NewService()
+	service.SetPasswordPolicy(PasswordPolicy{MinLength: 4})
+	if err := service.ValidatePassword("abcd"); err != nil {
+		t.Errorf("Expected relaxed policy to accept password, got %v", err)
+	}
+}
+
+func TestTokenIssuerAndAudience(t *testing.T) {
+	issuer := NewServiceWithOptions(Options{Issuer: "fethur", Audience: "web", Leeway: time.Minute})
+
+	token, err := issuer.GenerateDeviceToken(1, "testuser", "user", "7", 3)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := issuer.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if claims.TokenVersion != 3 || claims.DeviceID != "7" {
+		t.Errorf("Unexpected claims: version %d, device %q", claims.TokenVersion, claims.DeviceID)
+	}
+
+	otherAudience := NewServiceWithOptions(Options{Issuer: "fethur", Audience: "mobile"})
+	if _, err := otherAudience.ValidateToken(token); err == nil {
+		t.Error("Expected token for another audience to be rejected")
+	}
+
+	otherIssuer := NewServiceWithOptions(Options{Issuer: "elsewhere", Audience: "web"})
+	if _, err := otherIssuer.ValidateToken(token); err == nil {
+		t.Error("Expected token from another issuer to be rejected")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	service := NewService()
+
+	signature := service.Sign("attachment", "12:1700000000")
+	if !service.VerifySignature("attachment", "12:1700000000", signature) {
+		t.Error("Expected signature to verify")
+	}
+	if service.VerifySignature("attachment", "13:1700000000", signature) {
+		t.Error("Expected signature for another value to be rejected")
+	}
+	if service.VerifySignature("avatar", "12:1700000000", signature) {
+		t.Error("Expected signature for another purpose to be rejected")
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	service := NewService()
+
+	first, err := service.GenerateRefreshToken()
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	second, _ := service.GenerateRefreshToken()
+	if first == second {
+		t.Error("Expected refresh tokens to be unique")
+	}
+	if HashRefreshToken(first) != HashRefreshToken(first) || HashRefreshToken(first) == HashRefreshToken(second) {
+		t.Error("Expected hashes to be stable and distinct")
+	}
+	if HashRefreshToken(first) == first {
+		t.Error("Expected the hash to differ from the token")
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "jwt_keys.json")
+	keys, err := LoadKeyStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the first key to be saved privately, got %v (%v)", info, err)
+	}
+	service := NewServiceWithOptions(Options{Keys: keys})
+	if service.UsingDevelopmentSecret() {
+		t.Error("Expected a generated key instead of the development secret")
+	}
+
+	oldToken, _ := service.GenerateToken(1, "ann", "user")
+	oldSignature := service.Sign("attachment", "1")
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	newToken, _ := service.GenerateToken(1, "ann", "user")
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := service.ValidateToken(token); err != nil {
+			t.Errorf("Expected tokens of both keys to validate, got %v", err)
+		}
+	}
+	if !service.VerifySignature("attachment", "1", oldSignature) {
+		t.Error("Expected signatures of the retired key to verify")
+	}
+
+	// A restart keeps the keys
+	reloaded, err := LoadKeyStore(path)
+	if err != nil || reloaded.Current().ID != keys.Current().ID || len(reloaded.Active()) != 2 {
+		t.Fatalf("Expected the keys to be reloaded, got %+v (%v)", reloaded.Info(), err)
+	}
+	if _, err := NewServiceWithOptions(Options{Keys: reloaded}).ValidateToken(newToken); err != nil {
+		t.Errorf("Expected tokens to survive a restart, got %v", err)
+	}
+
+	// Keys retired for longer than a token lives are dropped
+	retired := time.Now().Add(-keyRetention - time.Minute)
+	keys.keys[1].RetiredAt = &retired
+	if _, err := service.ValidateToken(oldToken); err == nil {
+		t.Error("Expected tokens of an expired key to be rejected")
+	}
+
+	if _, err := StaticKeyStore([]byte("fixed")).Rotate(); err != ErrStaticKey {
+		t.Errorf("Expected a configured key not to rotate, got %v", err)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 test vectors, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		if got, err := TOTPCode(secret, time.Unix(unix, 0)); err != nil || got != want {
+			t.Errorf("TOTPCode at %d = %q (%v), want %q", unix, got, err, want)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	step, ok := ValidateTOTP(secret, "081804", now.Add(30*time.Second), 0)
+	if !ok || step != 1111111109/30 {
+		t.Errorf("Expected the previous step's code to be accepted, got %d %v", step, ok)
+	}
+	if _, ok := ValidateTOTP(secret, "081804", now, step); ok {
+		t.Error("Expected a used step to be refused")
+	}
+	if _, ok := ValidateTOTP(secret, "081804", now.Add(2*time.Minute), 0); ok {
+		t.Error("Expected an old code to be refused")
+	}
+
+	generated, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	code, _ := TOTPCode(generated, now)
+	if _, ok := ValidateTOTP(generated, code, now, 0); !ok {
+		t.Error("Expected a generated secret's code to validate")
+	}
+}
+
+func TestBackupCodes(t *testing.T) {
+	codes, err := GenerateBackupCodes()
+	if err != nil || len(codes) != BackupCodeCount {
+		t.Fatalf("Expected %d backup codes, got %v (%v)", BackupCodeCount, codes, err)
+	}
+	if HashBackupCode(codes[0]) != HashBackupCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("Expected hashes to ignore case, spaces and dashes")
+	}
+}
+
+func TestChallengeToken(t *testing.T) {
+	service := NewService()
+	challenge, err := service.GenerateChallengeToken(1, 0)
+	if err != nil {
+		t.Fatalf("Failed to generate challenge token: %v", err)
+	}
+	if _, err := service.ValidateToken(challenge); err == nil {
+		t.Error("Expected a challenge token not to work as a session")
+	}
+	if claims, err := service.ValidateChallengeToken(challenge); err != nil || claims.UserID != 1 {
+		t.Errorf("Expected the challenge token to validate, got %v", err)
+	}
+	session, _ := service.GenerateToken(1, "ann", "user")
+	if _, err := service.ValidateChallengeToken(session); err == nil {
+		t.Error("Expected a session token not to pass as a challenge")
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Signing keys. Tokens carry the ID of the key that signed them in their
+// "kid" header. Rotating makes a new key current; retired keys keep
+// verifying tokens for as long as those could still be valid, then are
+// dropped.
+
+// keyRetention is how long a retired key still verifies tokens
+const keyRetention = AccessTokenTTL + time.Hour
+
+// ErrStaticKey is returned when rotating a key that comes from configuration
+var ErrStaticKey = errors.New("the signing key is set by FETHUR_JWT_SECRET; change it there to rotate")
+
+// SigningKey is one key of a KeyStore
+type SigningKey struct {
+	ID        string     `json:"id"`
+	Secret    []byte     `json:"secret"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyInfo describes a signing key without its secret
+type KeyInfo struct {
+	ID        string     `json:"id"`
+	Current   bool       `json:"current"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyStore holds the current signing key and the retired ones still in use
+type KeyStore struct {
+	mutex sync.RWMutex
+	path  string       // file the keys are kept in; empty for a fixed key
+	keys  []SigningKey // the current key first
+}
+
+// StaticKeyStore holds a single key that cannot be rotated
+func StaticKeyStore(secret []byte) *KeyStore {
+	sum := sha256.Sum256(secret)
+	return &KeyStore{keys: []SigningKey{{ID: hex.EncodeToString(sum[:4]), Secret: secret}}}
+}
+
+// LoadKeyStore reads the signing keys kept in a file, generating and saving
+// the first key on first boot
+func LoadKeyStore(path string) (*KeyStore, error) {
+	store := &KeyStore{path: path}
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from configuration
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+	if err == nil {
+		var file struct {
+			Keys []SigningKey `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse signing keys in %s: %w", path, err)
+		}
+		store.keys = file.Keys
+	}
+	if len(store.keys) == 0 {
+		if _, err := store.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Current returns the key new tokens are signed with
+func (k *KeyStore) Current() SigningKey {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.keys[0]
+}
+
+// Lookup finds a key that may still verify tokens by its ID
+func (k *KeyStore) Lookup(id string) (SigningKey, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	for _, key := range k.active(time.Now()) {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// Active returns the keys that may still verify tokens, the current one first
+func (k *KeyStore) Active() []SigningKey {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active(time.Now())
+}
+
+func (k *KeyStore) active(now time.Time) []SigningKey {
+	keys := make([]SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.RetiredAt == nil || now.Sub(*key.RetiredAt) < keyRetention {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Info describes the keys that may still verify tokens
+func (k *KeyStore) Info() []KeyInfo {
+	keys := k.Active()
+	info := make([]KeyInfo, 0, len(keys))
+	for i, key := range keys {
+		info = append(info, KeyInfo{ID: key.ID, Current: i == 0, CreatedAt: key.CreatedAt, RetiredAt: key.RetiredAt})
+	}
+	return info
+}
+
+// Rotate generates a new current key and retires the previous one, dropping
+// keys retired long enough ago that no token they signed is still valid
+func (k *KeyStore) Rotate() (SigningKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.path == "" {
+		return SigningKey{}, ErrStaticKey
+	}
+
+	secret := make([]byte, 64)
+	id := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return SigningKey{}, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, err
+	}
+	now := time.Now().UTC()
+	key := SigningKey{ID: hex.EncodeToString(id), Secret: secret, CreatedAt: now}
+
+	keys := []SigningKey{key}
+	for _, previous := range k.active(now) {
+		if previous.RetiredAt == nil {
+			retired := now
+			previous.RetiredAt = &retired
+		}
+		keys = append(keys, previous)
+	}
+	if err := saveKeys(k.path, keys); err != nil {
+		return SigningKey{}, err
+	}
+	k.keys = keys
+	return key, nil
+}
+
+// saveKeys writes the keys readable only by the server, replacing the file
+// in one step so a crash cannot leave it half written
+func saveKeys(path string, keys []SigningKey) error {
+	data, err := json.MarshalIndent(struct {
+		Keys []SigningKey `json:"keys"`
+	}{keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create signing key directory: %w", err)
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	return nil
+}
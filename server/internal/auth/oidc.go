@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Sign-in with an OpenID Connect provider, using the authorization code
+// flow with PKCE. The provider's endpoints come from its discovery
+// document and ID tokens are checked against its published keys.
+
+const (
+	// oidcMetadataMaxAge is how long discovery documents and keys are reused
+	oidcMetadataMaxAge = time.Hour
+	// oidcKeyRefetchInterval limits refetching keys for unknown key IDs
+	oidcKeyRefetchInterval = time.Minute
+	// maxOIDCResponseSize caps what is read from the provider
+	maxOIDCResponseSize = 1 << 20
+)
+
+// OIDCConfig is a provider registered with a client ID and secret
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// OIDCIdentity is who the provider says signed in
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with one provider. Its discovery document
+// and keys are fetched when first needed and cached.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mutex         sync.Mutex
+	metadata      *oidcMetadata
+	metadataAt    time.Time
+	keys          map[string]interface{}
+	keysAt        time.Time
+	keysCheckedAt time.Time
+}
+
+// NewOIDCProvider returns a provider for a configuration
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Config returns the provider's configuration
+func (p *OIDCProvider) Config() OIDCConfig {
+	return p.config
+}
+
+// NewOIDCState creates the random state, nonce and PKCE verifier for one
+// sign-in attempt
+func NewOIDCState() (state, nonce, verifier string, err error) {
+	values := make([]string, 3)
+	for i := range values {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return "", "", "", err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// pkceChallenge is the S256 code challenge for a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where to send the browser to sign in
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", "openid profile email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the code from the callback for an ID token and returns
+// the identity in it
+func (p *OIDCProvider) Exchange(ctx context.Context, redirectURL, code, verifier, nonce string) (*OIDCIdentity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.fetchJSON(req, &response)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if status != http.StatusOK || response.IDToken == "" {
+		if response.Error != "" {
+			return nil, fmt.Errorf("token request refused: %s %s", response.Error, response.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token request returned %d without an ID token", status)
+	}
+	return p.VerifyIDToken(ctx, response.IDToken, nonce)
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string      `json:"nonce"`
+	AuthorizedParty   string      `json:"azp"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	PreferredUsername string      `json:"preferred_username"`
+	Name              string      `json:"name"`
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oidcClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, metadata, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, errors.New("invalid ID token: issued to another client")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+
+	// Some providers send email_verified as a string
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	return &OIDCIdentity{
+		Issuer:            metadata.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     verified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+// discover fetches the provider's discovery document
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mutex.Lock()
+	if p.metadata != nil && time.Since(p.metadataAt) < oidcMetadataMaxAge {
+		metadata := p.metadata
+		p.mutex.Unlock()
+		return metadata, nil
+	}
+	p.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata oidcMetadata
+	status, err := p.fetchJSON(req, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned %d", status)
+	}
+	if strings.TrimRight(metadata.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.mutex.Lock()
+	p.metadata, p.metadataAt = &metadata, time.Now()
+	p.mutex.Unlock()
+	return &metadata, nil
+}
+
+// key returns the provider key for a key ID, refetching the key set when
+// the ID is unknown since the provider may have rotated
+func (p *OIDCProvider) key(ctx context.Context, metadata *oidcMetadata, kid string) (interface{}, error) {
+	p.mutex.Lock()
+	keys := p.keys
+	fresh := keys != nil && time.Since(p.keysAt) < oidcMetadataMaxAge
+	recent := time.Since(p.keysCheckedAt) < oidcKeyRefetchInterval
+	p.mutex.Unlock()
+
+	if key := lookupOIDCKey(keys, kid); key != nil && fresh {
+		return key, nil
+	}
+	if !recent || !fresh {
+		fetched, err := p.fetchKeys(ctx, metadata)
+		if err != nil {
+			return nil, err
+		}
+		keys = fetched
+	}
+	if key := lookupOIDCKey(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupOIDCKey finds a key by ID; tokens without one may use the only key
+func lookupOIDCKey(keys map[string]interface{}, kid string) interface{} {
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context, metadata *oidcMetadata) (map[string]interface{}, error) {
+	p.mutex.Lock()
+	p.keysCheckedAt = time.Now()
+	p.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	status, err := p.fetchJSON(req, &set)
+	if err != nil {
+		return nil, fmt.Errorf("fetching signing keys failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing keys returned %d", status)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys of other types may be published alongside
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	p.mutex.Lock()
+	p.keys, p.keysAt = keys, time.Now()
+	p.mutex.Unlock()
+	return keys, nil
+}
+
+func (p *OIDCProvider) fetchJSON(req *http.Request, target interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOIDCResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, target); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// jsonWebKey is a public key from a provider's key set (RFC 7517)
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(value string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil || len(raw) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var exchange ecdh.Curve
+		switch k.Curve {
+		case "P-256":
+			curve, exchange = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, exchange = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, exchange = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parsing the uncompressed point checks that it is on the curve
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x.Bytes()) > size || len(y.Bytes()) > size {
+			return nil, errors.New("invalid EC key")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		if _, err := exchange.NewPublicKey(point); err != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
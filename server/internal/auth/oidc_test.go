@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+
+	var issuer string
+	keySets := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		keySets++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+			{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		}})
+	})
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	issuer = provider.URL
+
+	oidc := NewOIDCProvider(OIDCConfig{Issuer: issuer + "/", ClientID: "fethur", ClientSecret: "secret"})
+	ctx := context.Background()
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, change func(jwt.MapClaims)) string {
+		claims := jwt.MapClaims{
+			"iss":            issuer,
+			"sub":            "subject-1",
+			"aud":            "fethur",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          "nonce-1",
+			"email":          "ada@example.com",
+			"email_verified": "true",
+		}
+		if change != nil {
+			change(claims)
+		}
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	identity, err := oidc.VerifyIDToken(ctx, sign(jwt.SigningMethodRS256, "rsa", rsaKey, nil), "nonce-1")
+	if err != nil {
+		t.Fatalf("Expected the RSA token to verify: %v", err)
+	}
+	if identity.Subject != "subject-1" || identity.Issuer != issuer || !identity.EmailVerified {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	if _, err := oidc.VerifyIDToken(ctx, sign(jwt.SigningMethodES256, "ec", ecKey, nil), "nonce-1"); err != nil {
+		t.Errorf("Expected the EC token to verify: %v", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for name, token := range map[string]string{
+		"wrong nonce":    sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { c["nonce"] = "nonce-2" }),
+		"other client":   sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { c["aud"] = "someone-else" }),
+		"other issuer":   sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }),
+		"expired":        sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"no subject":     sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { delete(c, "sub") }),
+		"shared party":   sign(jwt.SigningMethodRS256, "rsa", rsaKey, func(c jwt.MapClaims) { c["aud"] = []string{"fethur", "other"} }),
+		"forged key":     sign(jwt.SigningMethodRS256, "rsa", other, nil),
+		"unknown key ID": sign(jwt.SigningMethodRS256, "gone", rsaKey, nil),
+		"symmetric":      sign(jwt.SigningMethodHS256, "hmac", []byte("secret"), nil),
+	} {
+		if _, err := oidc.VerifyIDToken(ctx, token, "nonce-1"); err == nil {
+			t.Errorf("Expected a token with %s to be refused", name)
+		}
+	}
+	if keySets > 2 {
+		t.Errorf("Expected unknown key IDs not to refetch keys every time, fetched %d times", keySets)
+	}
+
+	url, err := oidc.AuthCodeURL(ctx, "https://chat.example/api/auth/oidc/callback", "state-1", "nonce-1", "verifier")
+	if err != nil {
+		t.Fatalf("Failed to build the sign-in URL: %v", err)
+	}
+	if !strings.HasPrefix(url, issuer+"/authorize?") || !strings.Contains(url, "code_challenge="+pkceChallenge("verifier")) {
+		t.Errorf("Unexpected sign-in URL %s", url)
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing. New hashes use the configured algorithm, while
+// CheckPassword accepts any supported one, so stored hashes can be moved to
+// another algorithm one sign-in at a time with NeedsRehash.
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// ErrUnknownHashAlgorithm is returned for an unsupported hashing algorithm
+var ErrUnknownHashAlgorithm = errors.New("unknown password hashing algorithm")
+
+// Argon2Params are the cost parameters of Argon2id hashes
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the RFC 9106 second recommendation: 64 MiB
+// of memory over three passes
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+var argon2Encoding = base64.RawStdEncoding
+
+// hashArgon2id encodes a hash in the PHC string format used by the
+// reference implementation, e.g. $argon2id$v=19$m=65536,t=3,p=4$salt$key
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key),
+	), nil
+}
+
+// parseArgon2id splits an Argon2id hash into its parameters, salt and key
+func parseArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, errors.New("invalid argon2 parameters")
+	}
+	salt, err := argon2Encoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := argon2Encoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+	params.SaltLength = len(salt)
+	params.KeyLength = uint32(len(key)) // #nosec G115 -- decoded from a short stored hash
+	return params, salt, key, nil
+}
+
+// hashAlgorithm returns the algorithm a stored hash was made with
+func hashAlgorithm(hash string) string {
+	if strings.HasPrefix(hash, "$"+HashArgon2id+"$") {
+		return HashArgon2id
+	}
+	return HashBcrypt
+}
+
+// SetPasswordHashing selects the algorithm, and for Argon2id the cost, of
+// new password hashes
+func (s *Service) SetPasswordHashing(algorithm string, params Argon2Params) error {
+	if algorithm != HashBcrypt && algorithm != HashArgon2id {
+		return fmt.Errorf("%w: %q", ErrUnknownHashAlgorithm, algorithm)
+	}
+	if algorithm == HashArgon2id && (params.Iterations < 1 || params.Parallelism < 1 ||
+		params.Memory < 8*uint32(params.Parallelism) || params.SaltLength < 8 || params.KeyLength < 16) {
+		return fmt.Errorf("invalid argon2id parameters: %+v", params)
+	}
+	s.mutex.Lock()
+	s.hashAlgorithm = algorithm
+	s.argon2Params = params
+	s.mutex.Unlock()
+	return nil
+}
+
+// PasswordHashing returns the algorithm and Argon2id cost of new hashes
+func (s *Service) PasswordHashing() (string, Argon2Params) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.hashAlgorithm, s.argon2Params
+}
+
+// HashPassword hashes a password with the configured algorithm
+func (s *Service) HashPassword(password string) (string, error) {
+	algorithm, params := s.PasswordHashing()
+	if algorithm == HashArgon2id {
+		return hashArgon2id(password, params)
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(bytes), err
+}
+
+// CheckPassword compares a password with its hash, whichever supported
+// algorithm made it
+func (s *Service) CheckPassword(password, hash string) bool {
+	if hashAlgorithm(hash) == HashArgon2id {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NeedsRehash reports whether a stored hash was made with another algorithm
+// or a weaker cost than new hashes get. Callers rehash the password once it
+// has been checked.
+func (s *Service) NeedsRehash(hash string) bool {
+	algorithm, params := s.PasswordHashing()
+	if hashAlgorithm(hash) != algorithm {
+		return true
+	}
+	if algorithm == HashArgon2id {
+		stored, _, _, err := parseArgon2id(hash)
+		return err != nil || stored.Memory < params.Memory || stored.Iterations < params.Iterations ||
+			stored.Parallelism != params.Parallelism || stored.KeyLength < params.KeyLength
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < bcrypt.DefaultCost
+}
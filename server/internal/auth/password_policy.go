@@ -0,0 +1,94 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+// commonPasswords is the embedded list of banned passwords, lowercased
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordsList, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}()
+
+// PasswordPolicy describes the requirements a new password must meet
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	RequireNumber    bool `json:"require_number"`
+	RequireSpecial   bool `json:"require_special"`
+	RequireUpper     bool `json:"require_upper"`
+	RequireLower     bool `json:"require_lower"`
+	DisallowUsername bool `json:"disallow_username"`
+	BlockCommon      bool `json:"block_common"`
+}
+
+// DefaultPasswordPolicy returns the policy used when none is configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:        9,
+		RequireNumber:    true,
+		RequireSpecial:   true,
+		DisallowUsername: true,
+		BlockCommon:      true,
+	}
+}
+
+// Validate checks a password against the policy. The username may be empty
+// when it is not known yet.
+func (p PasswordPolicy) Validate(password, username string) error {
+	if len(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+
+	hasNumber := false
+	hasSpecial := false
+	hasUpper := false
+	hasLower := false
+
+	for _, char := range password {
+		switch {
+		case unicode.IsDigit(char):
+			hasNumber = true
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char):
+			hasSpecial = true
+		}
+	}
+
+	if p.RequireNumber && !hasNumber {
+		return fmt.Errorf("password must contain at least one number")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		return fmt.Errorf("password must contain at least one special character")
+	}
+	if p.RequireUpper && !hasUpper {
+		return fmt.Errorf("password must contain at least one uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return fmt.Errorf("password must contain at least one lowercase letter")
+	}
+
+	lowered := strings.ToLower(password)
+	if p.DisallowUsername && len(username) >= 3 && strings.Contains(lowered, strings.ToLower(username)) {
+		return fmt.Errorf("password must not contain the username")
+	}
+	if p.BlockCommon && commonPasswords[lowered] {
+		return fmt.Errorf("password is too common")
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// AccessTokenTTL is how long a JWT from GenerateToken is valid
+const AccessTokenTTL = 24 * time.Hour
+
+// RefreshTokenTTL is how long a refresh token can be used to get a new JWT
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// GenerateRefreshToken creates an opaque refresh token. Only its hash,
+// from HashRefreshToken, should be stored.
+func (s *Service) GenerateRefreshToken() (string, error) {
+	return s.GenerateRandomString(32)
+}
+
+// HashRefreshToken returns the stored form of a refresh token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
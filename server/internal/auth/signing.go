@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Sign returns an HMAC signature of value, for URLs that must be usable
+// without a session such as signed attachment links. The purpose keeps
+// signatures for one use from being valid for another.
+func (s *Service) Sign(purpose, value string) string {
+	return sign(s.keys.Current().Secret, purpose, value)
+}
+
+// VerifySignature checks a signature produced by Sign in constant time,
+// accepting signatures by keys retired within the retention period
+func (s *Service) VerifySignature(purpose, value, signature string) bool {
+	for _, key := range s.keys.Active() {
+		if hmac.Equal([]byte(sign(key.Secret, purpose, value)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(secret []byte, purpose, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
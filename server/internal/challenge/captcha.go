@@ -0,0 +1,85 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Verification endpoints of the supported CAPTCHA services
+const (
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+// Captcha is a CAPTCHA solved in the browser and verified with the
+// service's siteverify endpoint, which hCaptcha and Turnstile share
+type Captcha struct {
+	name      string
+	verifyURL string
+	siteKey   string
+	secret    string
+	client    *http.Client
+}
+
+// NewHCaptcha verifies hCaptcha responses
+func NewHCaptcha(siteKey, secret string, client *http.Client) *Captcha {
+	return &Captcha{name: "hcaptcha", verifyURL: HCaptchaVerifyURL, siteKey: siteKey, secret: secret, client: client}
+}
+
+// NewTurnstile verifies Cloudflare Turnstile responses
+func NewTurnstile(siteKey, secret string, client *http.Client) *Captcha {
+	return &Captcha{name: "turnstile", verifyURL: TurnstileVerifyURL, siteKey: siteKey, secret: secret, client: client}
+}
+
+func (c *Captcha) Name() string {
+	return c.name
+}
+
+// Issue returns the site key the browser widget is rendered with
+func (c *Captcha) Issue(time.Time) (map[string]interface{}, error) {
+	return map[string]interface{}{"site_key": c.siteKey}, nil
+}
+
+// Verify asks the service whether the token is a solved CAPTCHA
+func (c *Captcha) Verify(ctx context.Context, solution Solution, remoteIP string) error {
+	if solution.Token == "" {
+		return ErrFailed
+	}
+	form := url.Values{"secret": {c.secret}, "response": {solution.Token}, "sitekey": {c.siteKey}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrUnavailable, c.name, resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Package challenge keeps scripts from signing up: a CAPTCHA the provider
+// verifies (hCaptcha or Cloudflare Turnstile), or a proof-of-work puzzle
+// the server issues and checks itself.
+package challenge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrFailed is returned for solutions that do not solve the challenge
+var ErrFailed = errors.New("challenge failed")
+
+// ErrUnavailable is returned when the provider cannot be asked
+var ErrUnavailable = errors.New("challenge provider unavailable")
+
+// Solution is what a client sends back. CAPTCHAs fill in Token; proofs of
+// work the Challenge they were issued and the Nonce that solves it.
+type Solution struct {
+	Token     string `json:"token,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+}
+
+// Provider is a kind of challenge
+type Provider interface {
+	// Name identifies the provider to clients, e.g. "turnstile"
+	Name() string
+
+	// Issue returns what a client needs to present or solve the challenge
+	Issue(now time.Time) (map[string]interface{}, error)
+
+	// Verify checks a solution sent from remoteIP
+	Verify(ctx context.Context, solution Solution, remoteIP string) error
+}
@@ -0,0 +1,108 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSigner struct{}
+
+func (testSigner) Sign(purpose, value string) string {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(purpose + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s testSigner) VerifySignature(purpose, value, signature string) bool {
+	return hmac.Equal([]byte(s.Sign(purpose, value)), []byte(signature))
+}
+
+// solve finds a nonce by brute force, as a client would
+func solve(challenge string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		if Solves(challenge, strconv.Itoa(nonce), difficulty) {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	pow := NewProofOfWork(testSigner{}, 12, NewSpent())
+	issued, err := pow.Issue(time.Now())
+	if err != nil {
+		t.Fatalf("Failed to issue: %v", err)
+	}
+	challenge := issued["challenge"].(string)
+	nonce := solve(challenge, 12)
+
+	wrong := "x"
+	for Solves(challenge, wrong, 12) {
+		wrong += "x"
+	}
+	if err := pow.Verify(context.Background(), Solution{Challenge: challenge, Nonce: wrong}, ""); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected a wrong nonce to fail, got %v", err)
+	}
+	if err := pow.Verify(context.Background(), Solution{Challenge: challenge, Nonce: nonce}, ""); err != nil {
+		t.Fatalf("Expected the solution to pass, got %v", err)
+	}
+	if err := pow.Verify(context.Background(), Solution{Challenge: challenge, Nonce: nonce}, ""); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected a spent puzzle to fail, got %v", err)
+	}
+
+	// Lowering the difficulty in the challenge breaks its signature
+	parts := strings.Split(challenge, ".")
+	parts[2] = "1"
+	forged := strings.Join(parts, ".")
+	if err := pow.Verify(context.Background(), Solution{Challenge: forged, Nonce: solve(forged, 1)}, ""); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected a forged puzzle to fail, got %v", err)
+	}
+
+	// Expired puzzles fail even when solved
+	issued, _ = pow.Issue(time.Now().Add(-proofOfWorkTTL - time.Second))
+	expired := issued["challenge"].(string)
+	if err := pow.Verify(context.Background(), Solution{Challenge: expired, Nonce: solve(expired, 12)}, ""); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected an expired puzzle to fail, got %v", err)
+	}
+}
+
+func TestCaptcha(t *testing.T) {
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "shh" || r.FormValue("remoteip") != "203.0.113.7" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("response") == "solved" {
+			_, _ = w.Write([]byte(`{"success": true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer verifier.Close()
+
+	captcha := NewTurnstile("site", "shh", verifier.Client())
+	captcha.verifyURL = verifier.URL
+
+	if err := captcha.Verify(context.Background(), Solution{Token: "solved"}, "203.0.113.7"); err != nil {
+		t.Errorf("Expected a solved CAPTCHA to pass, got %v", err)
+	}
+	if err := captcha.Verify(context.Background(), Solution{Token: "guessed"}, "203.0.113.7"); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected an unsolved CAPTCHA to fail, got %v", err)
+	}
+	if err := captcha.Verify(context.Background(), Solution{}, "203.0.113.7"); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected a missing token to fail, got %v", err)
+	}
+
+	verifier.Close()
+	if err := captcha.Verify(context.Background(), Solution{Token: "solved"}, "203.0.113.7"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected an unreachable service to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proof-of-work difficulty, in leading zero bits of the hash
+const (
+	DefaultDifficulty = 18
+	MinDifficulty     = 8
+	MaxDifficulty     = 28
+)
+
+// proofOfWorkTTL is how long an issued puzzle can be solved
+const proofOfWorkTTL = 10 * time.Minute
+
+const proofOfWorkPurpose = "registration-pow"
+
+// Signer signs issued puzzles so the server need not store them
+type Signer interface {
+	Sign(purpose, value string) string
+	VerifySignature(purpose, value, signature string) bool
+}
+
+// ProofOfWork makes clients find a nonce such that the SHA-256 of the
+// challenge followed by the nonce starts with Difficulty zero bits. It
+// costs a browser a second or two and a script registering in bulk much
+// more.
+type ProofOfWork struct {
+	signer     Signer
+	difficulty int
+	spent      *Spent
+}
+
+// NewProofOfWork issues puzzles of a difficulty, remembering solved ones
+// in spent
+func NewProofOfWork(signer Signer, difficulty int, spent *Spent) *ProofOfWork {
+	return &ProofOfWork{signer: signer, difficulty: difficulty, spent: spent}
+}
+
+func (p *ProofOfWork) Name() string {
+	return "pow"
+}
+
+// Issue returns a signed puzzle. The challenge carries its difficulty and
+// expiry, so changing the difficulty does not spoil puzzles in flight.
+func (p *ProofOfWork) Issue(now time.Time) (map[string]interface{}, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(proofOfWorkTTL)
+	value := strings.Join([]string{hex.EncodeToString(random), strconv.FormatInt(expiresAt.Unix(), 10), strconv.Itoa(p.difficulty)}, ".")
+	return map[string]interface{}{
+		"challenge":  value + "." + p.signer.Sign(proofOfWorkPurpose, value),
+		"difficulty": p.difficulty,
+		"algorithm":  "sha256",
+		"expires_at": expiresAt.UTC(),
+	}, nil
+}
+
+// Verify checks the puzzle was issued here, is unexpired and unspent, and
+// that the nonce solves it
+func (p *ProofOfWork) Verify(_ context.Context, solution Solution, _ string) error {
+	parts := strings.Split(solution.Challenge, ".")
+	if len(parts) != 4 || solution.Nonce == "" || len(solution.Nonce) > 64 {
+		return ErrFailed
+	}
+	value := strings.Join(parts[:3], ".")
+	if !p.signer.VerifySignature(proofOfWorkPurpose, value, parts[3]) {
+		return ErrFailed
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrFailed
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return ErrFailed
+	}
+	expiresAt := time.Unix(expires, 0)
+	if !time.Now().Before(expiresAt) || !Solves(solution.Challenge, solution.Nonce, difficulty) {
+		return ErrFailed
+	}
+	if !p.spent.spend(solution.Challenge, expiresAt) {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Solves reports whether the SHA-256 of challenge+nonce starts with
+// difficulty zero bits
+func Solves(challenge, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
+
+// Spent remembers solved puzzles until they expire, so each is used once
+type Spent struct {
+	mutex sync.Mutex
+	until map[string]time.Time
+}
+
+// NewSpent creates an empty set of spent puzzles
+func NewSpent() *Spent {
+	return &Spent{until: make(map[string]time.Time)}
+}
+
+// spend marks a puzzle used, reporting false if it already was
+func (s *Spent) spend(challenge string, expiresAt time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, until := range s.until {
+		if !now.Before(until) {
+			delete(s.until, key)
+		}
+	}
+	if _, ok := s.until[challenge]; ok {
+		return false
+	}
+	s.until[challenge] = expiresAt
+	return true
+}
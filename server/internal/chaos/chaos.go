@@ -0,0 +1,104 @@
+// Package chaos injects faults for resilience testing: slow database
+// queries, dropped WebSocket frames and plugin calls that time out. It only
+// does anything in binaries built with `-tags chaos`, which must never run
+// in production. Faults are off until configured with Set.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// MaxDBLatency caps the delay added to a database query
+const MaxDBLatency = 5 * time.Second
+
+// ErrUnavailable is returned by Set in builds without fault injection
+var ErrUnavailable = errors.New("fault injection is not available in this build")
+
+// Config sets how often each fault happens, as a percentage of chances
+type Config struct {
+	// DBLatencyPercent of database queries wait up to DBLatencyMaxMs first
+	DBLatencyPercent int `json:"db_latency_percent"`
+	DBLatencyMaxMs   int `json:"db_latency_max_ms"`
+	// WSDropPercent of outgoing WebSocket frames are silently dropped
+	WSDropPercent int `json:"ws_drop_percent"`
+	// PluginTimeoutPercent of plugin calls hang until their deadline
+	PluginTimeoutPercent int `json:"plugin_timeout_percent"`
+}
+
+// Validate checks that every percentage and the latency are in range
+func (c Config) Validate() error {
+	for name, percent := range map[string]int{
+		"db_latency_percent":     c.DBLatencyPercent,
+		"ws_drop_percent":        c.WSDropPercent,
+		"plugin_timeout_percent": c.PluginTimeoutPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+	if c.DBLatencyMaxMs < 0 || time.Duration(c.DBLatencyMaxMs)*time.Millisecond > MaxDBLatency {
+		return fmt.Errorf("db_latency_max_ms must be between 0 and %d", MaxDBLatency.Milliseconds())
+	}
+	return nil
+}
+
+var (
+	mutex   sync.RWMutex
+	current Config
+)
+
+// Available reports whether this build can inject faults
+func Available() bool {
+	return enabled
+}
+
+// Set replaces the fault configuration; the zero Config turns every fault off
+func Set(config Config) error {
+	if !enabled {
+		return ErrUnavailable
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	mutex.Lock()
+	current = config
+	mutex.Unlock()
+	return nil
+}
+
+// Current returns the fault configuration
+func Current() Config {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return current
+}
+
+// roll reports whether a fault with the given chance happens
+func roll(percent int) bool {
+	return percent > 0 && rand.Intn(100) < percent // #nosec G404 -- fault injection, not security
+}
+
+// DBLatency delays a database query when the fault comes up
+func DBLatency() {
+	if !enabled {
+		return
+	}
+	config := Current()
+	if config.DBLatencyMaxMs > 0 && roll(config.DBLatencyPercent) {
+		time.Sleep(time.Duration(rand.Intn(config.DBLatencyMaxMs)+1) * time.Millisecond) // #nosec G404
+	}
+}
+
+// DropFrame reports whether to drop an outgoing WebSocket frame
+func DropFrame() bool {
+	return enabled && roll(Current().WSDropPercent)
+}
+
+// PluginTimeout reports whether a plugin call should hang until it times out
+func PluginTimeout() bool {
+	return enabled && roll(Current().PluginTimeoutPercent)
+}
@@ -0,0 +1,32 @@
+package chaos
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	valid := Config{DBLatencyPercent: 10, DBLatencyMaxMs: 200, WSDropPercent: 100, PluginTimeoutPercent: 0}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid, got %v", valid, err)
+	}
+	for _, config := range []Config{
+		{DBLatencyPercent: 101},
+		{WSDropPercent: -1},
+		{PluginTimeoutPercent: 200},
+		{DBLatencyMaxMs: 60000},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be refused", config)
+		}
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	if Available() {
+		t.Skip("built with fault injection")
+	}
+	if err := Set(Config{WSDropPercent: 100}); err != ErrUnavailable {
+		t.Errorf("Expected Set to fail without the chaos tag, got %v", err)
+	}
+	if DropFrame() || PluginTimeout() {
+		t.Error("Expected no faults without the chaos tag")
+	}
+}
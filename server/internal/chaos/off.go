@@ -0,0 +1,7 @@
+//go:build !chaos
+
+package chaos
+
+// enabled is set by building with `-tags chaos`; this build has no fault
+// injection and every hook is a no-op
+const enabled = false
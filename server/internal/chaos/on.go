@@ -0,0 +1,6 @@
+//go:build chaos
+
+package chaos
+
+// enabled is set by building with `-tags chaos`
+const enabled = true
@@ -0,0 +1,24 @@
+//go:build chaos
+
+package chaos
+
+import "testing"
+
+func TestFaults(t *testing.T) {
+	defer func() {
+		_ = Set(Config{})
+	}()
+
+	if err := Set(Config{WSDropPercent: 100}); err != nil {
+		t.Fatalf("Failed to configure faults: %v", err)
+	}
+	if !DropFrame() || PluginTimeout() {
+		t.Error("Expected every frame dropped and no plugin timeouts")
+	}
+	if err := Set(Config{WSDropPercent: 150}); err == nil {
+		t.Error("Expected an invalid configuration to be refused")
+	}
+	if Current().WSDropPercent != 100 {
+		t.Error("Expected a refused configuration to leave the current one")
+	}
+}
@@ -0,0 +1,246 @@
+// Package chatimport reads chat exports from other platforms into one
+// model of a server: channels, roles, authors and message history. Each
+// source has an adapter: Discord exports made with DiscordChatExporter,
+// Slack workspace exports and Matrix rooms exported from Element.
+package chatimport
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sources
+const (
+	SourceDiscord = "discord"
+	SourceSlack   = "slack"
+	SourceMatrix  = "matrix"
+)
+
+// ErrUnknownSource is returned by Open for a source without an adapter
+var ErrUnknownSource = errors.New("unknown import source")
+
+// ErrNotExport is returned when an archive holds nothing the adapter
+// recognises
+var ErrNotExport = errors.New("not an export of this source")
+
+// ErrMediaNotFound is returned for attachments whose file is not in the
+// archive, usually because the export was made without downloading media
+var ErrMediaNotFound = errors.New("attachment file is not in the archive")
+
+// Archive is an opened export
+type Archive struct {
+	Source          string
+	Name            string     // name of the exported server, if known
+	Roles           []Role     // highest first
+	Channels        []*Channel // by name
+	SkippedChannels []string   // channels of types that are not imported
+	SkippedMessages int        // system messages such as joins and pins
+}
+
+// Role is a role authors can hold
+type Role struct {
+	ID       string
+	Name     string
+	Color    string // #rrggbb or empty
+	Position int
+}
+
+// Channel is an imported channel. Kind is a Fethur channel type.
+type Channel struct {
+	ID       string
+	Name     string
+	Kind     string
+	Private  bool
+	Messages []Message // oldest first
+}
+
+// Author is the user who sent a message. Name is their account name,
+// DisplayName what they were shown as.
+type Author struct {
+	ID          string
+	Name        string
+	DisplayName string
+	Roles       []string // role IDs
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	FileName string
+	URL      string // where the file lived, kept as a link when it was not exported
+	Size     int64
+
+	file *zip.File
+}
+
+// Exported reports whether the file itself is in the archive
+func (a Attachment) Exported() bool {
+	return a.file != nil
+}
+
+// Open reads an exported file
+func (a Attachment) Open() (io.ReadCloser, int64, error) {
+	if a.file == nil {
+		return nil, 0, ErrMediaNotFound
+	}
+	r, err := a.file.Open()
+	return r, int64(a.file.UncompressedSize64), err
+}
+
+// Message is an imported message. IDs are unique within the archive.
+type Message struct {
+	ID          string
+	Timestamp   time.Time
+	Edited      *time.Time
+	Content     string
+	Author      Author
+	Attachments []Attachment
+	ReplyTo     string // ID of the message this answers
+}
+
+// Open reads a zipped export of the given source
+func Open(source string, r io.ReaderAt, size int64) (*Archive, error) {
+	var adapter func(files) (*Archive, error)
+	switch source {
+	case SourceDiscord:
+		adapter = openDiscord
+	case SourceSlack:
+		adapter = openSlack
+	case SourceMatrix:
+		adapter = openMatrix
+	default:
+		return nil, ErrUnknownSource
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+	zipped := make(files)
+	for _, file := range reader.File {
+		if !file.FileInfo().IsDir() {
+			zipped[path.Clean(file.Name)] = file
+		}
+	}
+
+	archive, err := adapter(zipped)
+	if err != nil {
+		return nil, err
+	}
+	if len(archive.Channels) == 0 && len(archive.SkippedChannels) == 0 {
+		return nil, ErrNotExport
+	}
+	archive.Source = source
+	sort.SliceStable(archive.Roles, func(i, j int) bool {
+		return archive.Roles[i].Position > archive.Roles[j].Position
+	})
+	sort.SliceStable(archive.Channels, func(i, j int) bool {
+		return archive.Channels[i].Name < archive.Channels[j].Name
+	})
+	for _, channel := range archive.Channels {
+		messages := channel.Messages
+		sort.SliceStable(messages, func(i, j int) bool {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		})
+	}
+	return archive, nil
+}
+
+// files are the files of an archive by cleaned path
+type files map[string]*zip.File
+
+// names lists the files with an extension, sorted, optionally below a
+// directory
+func (f files) names(dir, ext string) []string {
+	var names []string
+	for name := range f {
+		if dir != "" && path.Dir(name) != dir {
+			continue
+		}
+		if strings.EqualFold(path.Ext(name), ext) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decode reads a JSON file
+func (f files) decode(name string, v interface{}) error {
+	file, ok := f[name]
+	if !ok {
+		return fmt.Errorf("%s is missing", name)
+	}
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("%s: invalid JSON: %w", name, err)
+	}
+	return nil
+}
+
+// Summary describes what an import of the archive would create
+type Summary struct {
+	Source          string   `json:"source"`
+	Name            string   `json:"name"`
+	Channels        int      `json:"channels"`
+	Roles           int      `json:"roles"`
+	Authors         int      `json:"authors"`
+	Messages        int      `json:"messages"`
+	Attachments     int      `json:"attachments"`
+	MissingMedia    int      `json:"missing_media"`
+	SkippedChannels []string `json:"skipped_channels"`
+	SkippedMessages int      `json:"skipped_messages"`
+	Warnings        []string `json:"warnings"`
+}
+
+// Summarize counts what an import would create and notes what it would
+// leave out
+func (a *Archive) Summarize() Summary {
+	summary := Summary{
+		Source:          a.Source,
+		Name:            a.Name,
+		Channels:        len(a.Channels),
+		Roles:           len(a.Roles),
+		SkippedChannels: append([]string{}, a.SkippedChannels...),
+		SkippedMessages: a.SkippedMessages,
+		Warnings:        []string{},
+	}
+	authors := make(map[string]bool)
+	for _, channel := range a.Channels {
+		for _, message := range channel.Messages {
+			summary.Messages++
+			authors[message.Author.ID] = true
+			for _, attachment := range message.Attachments {
+				if attachment.Exported() {
+					summary.Attachments++
+				} else {
+					summary.MissingMedia++
+				}
+			}
+		}
+	}
+	summary.Authors = len(authors)
+
+	if len(summary.SkippedChannels) > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d threads or other channels of unsupported types are skipped", len(summary.SkippedChannels)))
+	}
+	if summary.MissingMedia > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d attachments were not included in the export and are kept as links", summary.MissingMedia))
+	}
+	if summary.Messages == 0 {
+		summary.Warnings = append(summary.Warnings, "The export holds no messages")
+	}
+	return summary
+}
@@ -0,0 +1,187 @@
+package chatimport
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Discord exports are zips of the JSON channel exports DiscordChatExporter
+// writes, optionally with the media it downloaded next to them. Exports of
+// the same channel, as made when splitting by date, are merged.
+
+type discordGuild struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type discordChannel struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type discordRole struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Position int    `json:"position"`
+}
+
+type discordAuthor struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Discriminator string        `json:"discriminator"`
+	Nickname      string        `json:"nickname"`
+	Roles         []discordRole `json:"roles"`
+}
+
+type discordAttachment struct {
+	URL      string `json:"url"`
+	FileName string `json:"fileName"`
+	Size     int64  `json:"fileSizeBytes"`
+}
+
+type discordMessage struct {
+	ID              string              `json:"id"`
+	Type            string              `json:"type"`
+	Timestamp       time.Time           `json:"timestamp"`
+	TimestampEdited *time.Time          `json:"timestampEdited"`
+	Content         string              `json:"content"`
+	Author          discordAuthor       `json:"author"`
+	Attachments     []discordAttachment `json:"attachments"`
+	Reference       *struct {
+		MessageID string `json:"messageId"`
+	} `json:"reference"`
+}
+
+type discordExport struct {
+	Guild    discordGuild     `json:"guild"`
+	Channel  discordChannel   `json:"channel"`
+	Messages []discordMessage `json:"messages"`
+}
+
+// discordChannelKind maps a Discord channel type to a Fethur channel type;
+// ok is false for types that are not imported, such as threads
+func discordChannelKind(discordType string) (kind string, ok bool) {
+	switch discordType {
+	case "GuildTextChat", "GuildNews", "GuildAnnouncement", "GuildForum":
+		return "text", true
+	case "GuildVoiceChat", "GuildStageVoice":
+		return "voice", true
+	}
+	return "", false
+}
+
+func openDiscord(zipped files) (*Archive, error) {
+	archive := &Archive{}
+	var guild discordGuild
+	channels := make(map[string]*Channel)
+	roles := make(map[string]bool)
+	skipped := make(map[string]bool)
+	for _, name := range zipped.names("", ".json") {
+		// Downloaded media may include JSON files of its own
+		if strings.HasSuffix(path.Dir(name), "_Files") {
+			continue
+		}
+		var export discordExport
+		if err := zipped.decode(name, &export); err != nil {
+			return nil, err
+		}
+		if export.Guild.ID == "" || export.Channel.ID == "" {
+			continue
+		}
+		if guild.ID == "" {
+			guild = export.Guild
+		} else if export.Guild.ID != guild.ID {
+			return nil, fmt.Errorf("%s: the archive holds exports of more than one server", name)
+		}
+
+		kind, ok := discordChannelKind(export.Channel.Type)
+		if !ok {
+			if !skipped[export.Channel.ID] {
+				skipped[export.Channel.ID] = true
+				archive.SkippedChannels = append(archive.SkippedChannels, export.Channel.Name)
+			}
+			continue
+		}
+		channel, ok := channels[export.Channel.ID]
+		if !ok {
+			channel = &Channel{ID: export.Channel.ID, Name: export.Channel.Name, Kind: kind}
+			channels[channel.ID] = channel
+			archive.Channels = append(archive.Channels, channel)
+		}
+
+		for _, message := range export.Messages {
+			// Joins, pins and other system messages
+			if message.Type != "Default" && message.Type != "Reply" {
+				archive.SkippedMessages++
+				continue
+			}
+			author := Author{ID: message.Author.ID, Name: message.Author.Name, DisplayName: message.Author.Nickname}
+			if d := message.Author.Discriminator; d != "" && d != "0" && d != "0000" {
+				author.Name += "#" + d
+			}
+			if author.DisplayName == "" {
+				author.DisplayName = message.Author.Name
+			}
+			for _, role := range message.Author.Roles {
+				if role.Name == "@everyone" || role.ID == guild.ID {
+					continue
+				}
+				author.Roles = append(author.Roles, role.ID)
+				if !roles[role.ID] {
+					roles[role.ID] = true
+					archive.Roles = append(archive.Roles, Role{ID: role.ID, Name: role.Name, Color: role.Color, Position: role.Position})
+				}
+			}
+
+			imported := Message{
+				ID:        message.ID,
+				Timestamp: message.Timestamp,
+				Edited:    message.TimestampEdited,
+				Content:   message.Content,
+				Author:    author,
+			}
+			if message.Reference != nil {
+				imported.ReplyTo = message.Reference.MessageID
+			}
+			for _, attachment := range message.Attachments {
+				imported.Attachments = append(imported.Attachments, Attachment{
+					FileName: attachment.FileName,
+					URL:      attachment.URL,
+					Size:     attachment.Size,
+					file:     discordMedia(zipped, path.Dir(name), attachment.URL),
+				})
+			}
+			channel.Messages = append(channel.Messages, imported)
+		}
+	}
+	archive.Name = guild.Name
+	return archive, nil
+}
+
+// discordMedia finds the downloaded file of an attachment, whose URL is
+// relative to the channel export and may be escaped. Attachments pointing
+// elsewhere, e.g. at Discord's CDN, have none.
+func discordMedia(zipped files, dir, link string) *zip.File {
+	if strings.Contains(link, "://") {
+		return nil
+	}
+	candidates := []string{link}
+	if unescaped, err := url.PathUnescape(link); err == nil && unescaped != link {
+		candidates = append(candidates, unescaped)
+	}
+	for _, candidate := range candidates {
+		candidate = strings.ReplaceAll(candidate, "\\", "/")
+		for _, name := range []string{path.Join(dir, candidate), path.Clean(candidate)} {
+			if file, ok := zipped[name]; ok {
+				return file
+			}
+		}
+	}
+	return nil
+}
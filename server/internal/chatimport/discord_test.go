@@ -0,0 +1,139 @@
+package chatimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const guild = `"guild": {"id": "100", "name": "Homelab"}`
+
+// Two halves of #general, as exported when splitting by date, a thread and
+// a file downloaded for one attachment
+var discordFiles = map[string]string{
+	"Homelab - general [200] part 2.json": `{` + guild + `,
+		"channel": {"id": "200", "type": "GuildTextChat", "name": "general"},
+		"messages": [
+			{"id": "303", "type": "Reply", "timestamp": "2024-03-02T10:00:00+00:00", "content": "Nice",
+				"author": {"id": "401", "name": "bob", "discriminator": "0000", "roles": []},
+				"reference": {"messageId": "302", "channelId": "200"}},
+			{"id": "304", "type": "ChannelPinnedMessage", "timestamp": "2024-03-02T11:00:00+00:00", "content": "",
+				"author": {"id": "400", "name": "alice", "discriminator": "0000"}}
+		]}`,
+	"Homelab - general [200].json": `{` + guild + `,
+		"channel": {"id": "200", "type": "GuildTextChat", "name": "general"},
+		"messages": [
+			{"id": "302", "type": "Default", "timestamp": "2024-03-01T09:00:00+00:00", "content": "My rack",
+				"author": {"id": "400", "name": "alice", "discriminator": "0000", "roles": [
+					{"id": "100", "name": "@everyone", "position": 0},
+					{"id": "500", "name": "Admins", "color": "#FF0000", "position": 2},
+					{"id": "501", "name": "Members", "position": 1}]},
+				"attachments": [
+					{"id": "600", "url": "Homelab%20-%20general%20%5B200%5D.json_Files/rack-1A2B.png", "fileName": "rack.png", "fileSizeBytes": 4},
+					{"id": "601", "url": "https://cdn.discordapp.com/attachments/200/601/notes.txt", "fileName": "notes.txt", "fileSizeBytes": 10}]}
+		]}`,
+	"Homelab - general [200].json_Files/rack-1A2B.png": "\x89PNG",
+	"Homelab - help [210].json": `{` + guild + `,
+		"channel": {"id": "210", "type": "GuildPublicThread", "name": "help"},
+		"messages": [{"id": "310", "type": "Default", "timestamp": "2024-03-01T09:00:00+00:00", "content": "?",
+			"author": {"id": "401", "name": "bob", "discriminator": "0000"}}]}`,
+	"Homelab - general [200].json_Files/settings-99.json": "{not an export",
+}
+
+// archiveOf zips files by name
+func archiveOf(t *testing.T, files map[string]string) ([]byte, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+func open(t *testing.T, source string, files map[string]string) (*Archive, error) {
+	t.Helper()
+	data, err := archiveOf(t, files)
+	if err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+	return Open(source, bytes.NewReader(data), int64(len(data)))
+}
+
+func TestOpenDiscord(t *testing.T) {
+	archive, err := open(t, SourceDiscord, discordFiles)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	if archive.Name != "Homelab" || len(archive.Channels) != 1 || len(archive.SkippedChannels) != 1 {
+		t.Fatalf("Expected Homelab with 1 channel and a skipped thread, got %q with %d", archive.Name, len(archive.Channels))
+	}
+	general := archive.Channels[0]
+	if general.Name != "general" || len(general.Messages) != 2 || general.Messages[0].ID != "302" {
+		t.Fatalf("Expected the parts of #general merged oldest first, got %+v", general.Messages)
+	}
+	if archive.SkippedMessages != 1 {
+		t.Errorf("Expected the pin to be skipped, got %d", archive.SkippedMessages)
+	}
+	if reply := general.Messages[1]; reply.ReplyTo != "302" || reply.Author.Name != "bob" {
+		t.Errorf("Expected bob's reply to 302, got %+v", reply)
+	}
+
+	if len(archive.Roles) != 2 || archive.Roles[0].Name != "Admins" || archive.Roles[1].Name != "Members" {
+		t.Errorf("Expected Admins and Members without @everyone, got %+v", archive.Roles)
+	}
+	if roles := general.Messages[0].Author.Roles; len(roles) != 2 {
+		t.Errorf("Expected alice to hold 2 roles, got %v", roles)
+	}
+
+	attachments := general.Messages[0].Attachments
+	if !attachments[0].Exported() || attachments[1].Exported() {
+		t.Errorf("Expected the downloaded file found for an escaped URL and none for a CDN link")
+	}
+	if r, size, err := attachments[0].Open(); err != nil || size != 4 {
+		t.Errorf("Expected to read the downloaded file, got %d bytes: %v", size, err)
+	} else {
+		_ = r.Close()
+	}
+	if _, _, err := attachments[1].Open(); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("Expected a CDN link to have no file, got %v", err)
+	}
+
+	summary := archive.Summarize()
+	if summary.Channels != 1 || summary.Messages != 2 || summary.Authors != 2 || summary.Roles != 2 {
+		t.Errorf("Expected 1 channel, 2 messages, 2 authors and 2 roles, got %+v", summary)
+	}
+	if summary.Attachments != 1 || summary.MissingMedia != 1 || len(summary.Warnings) != 2 {
+		t.Errorf("Expected 1 attachment, 1 missing and 2 warnings, got %+v", summary)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	if _, err := Open(SourceDiscord, strings.NewReader("plain text"), 10); err == nil {
+		t.Error("Expected a non-zip upload to be refused")
+	}
+	if _, err := open(t, "irc", discordFiles); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("Expected an unknown source to be refused, got %v", err)
+	}
+	for _, source := range []string{SourceDiscord, SourceSlack, SourceMatrix} {
+		if _, err := open(t, source, map[string]string{"notes.txt": "hi"}); !errors.Is(err, ErrNotExport) {
+			t.Errorf("Expected a zip without %s exports to be refused, got %v", source, err)
+		}
+	}
+	other := map[string]string{
+		"a.json": discordFiles["Homelab - general [200].json"],
+		"b.json": `{"guild": {"id": "999", "name": "Other"}, "channel": {"id": "900", "type": "GuildTextChat", "name": "x"}, "messages": []}`,
+	}
+	if _, err := open(t, SourceDiscord, other); err == nil {
+		t.Error("Expected exports of two servers to be refused")
+	}
+}
@@ -0,0 +1,233 @@
+package chatimport
+
+import (
+	"archive/zip"
+	"path"
+	"strings"
+	"time"
+)
+
+// Matrix exports are zips of rooms exported from Element as JSON, one file
+// per room, with attachments under files/ when they were included. Each
+// room becomes a channel. Power levels map to Admins (100) and Moderators
+// (50).
+
+type matrixContent struct {
+	MsgType     string         `json:"msgtype"`
+	Body        string         `json:"body"`
+	FileName    string         `json:"filename"`
+	URL         string         `json:"url"`
+	Displayname string         `json:"displayname"`
+	Users       map[string]int `json:"users"`
+	Info        struct {
+		Size int64 `json:"size"`
+	} `json:"info"`
+	NewContent *matrixContent `json:"m.new_content"`
+	RelatesTo  *struct {
+		RelType   string `json:"rel_type"`
+		EventID   string `json:"event_id"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+}
+
+type matrixEvent struct {
+	Type           string        `json:"type"`
+	EventID        string        `json:"event_id"`
+	Sender         string        `json:"sender"`
+	OriginServerTS int64         `json:"origin_server_ts"`
+	StateKey       *string       `json:"state_key"`
+	Content        matrixContent `json:"content"`
+}
+
+type matrixRoom struct {
+	RoomID   string        `json:"room_id"`
+	RoomName string        `json:"room_name"`
+	Messages []matrixEvent `json:"messages"`
+}
+
+// Roles for power levels
+const (
+	matrixAdminRole     = "admins"
+	matrixModeratorRole = "moderators"
+)
+
+func openMatrix(zipped files) (*Archive, error) {
+	archive := &Archive{}
+	levels := make(map[string]int)
+	for _, name := range zipped.names("", ".json") {
+		if strings.HasPrefix(name, "files/") {
+			continue
+		}
+		var room matrixRoom
+		if err := zipped.decode(name, &room); err != nil {
+			return nil, err
+		}
+		if room.RoomName == "" && room.RoomID == "" {
+			continue
+		}
+		channel := &Channel{ID: room.RoomID, Name: room.RoomName, Kind: "text"}
+		if channel.ID == "" {
+			channel.ID = room.RoomName
+		}
+		if channel.Name == "" {
+			channel.Name = room.RoomID
+		}
+
+		names := make(map[string]string)
+		index := make(map[string]int) // event ID -> position in channel.Messages
+		for _, event := range room.Messages {
+			content := event.Content
+			switch {
+			case event.Type == "m.room.member" && event.StateKey != nil:
+				if content.Displayname != "" {
+					names[*event.StateKey] = content.Displayname
+				}
+				continue
+			case event.Type == "m.room.power_levels":
+				for user, level := range content.Users {
+					levels[user] = level
+				}
+				continue
+			case event.Type != "m.room.message" || content.MsgType == "":
+				// State changes, reactions and redacted messages
+				archive.SkippedMessages++
+				continue
+			}
+			timestamp := time.UnixMilli(event.OriginServerTS).UTC()
+
+			relation := content.RelatesTo
+			if relation != nil && relation.RelType == "m.replace" && content.NewContent != nil {
+				if i, ok := index[relation.EventID]; ok {
+					channel.Messages[i].Content = matrixBody(*content.NewContent, names[event.Sender])
+					channel.Messages[i].Edited = &timestamp
+				}
+				continue
+			}
+
+			author := Author{ID: event.Sender, Name: matrixLocalpart(event.Sender), DisplayName: names[event.Sender]}
+			if author.DisplayName == "" {
+				author.DisplayName = author.Name
+			}
+			message := Message{
+				ID:        event.EventID,
+				Timestamp: timestamp,
+				Content:   matrixBody(content, author.DisplayName),
+				Author:    author,
+			}
+			if relation != nil {
+				switch {
+				case relation.InReplyTo != nil:
+					message.ReplyTo = relation.InReplyTo.EventID
+					message.Content = stripReplyFallback(message.Content)
+				case relation.RelType == "m.thread":
+					message.ReplyTo = relation.EventID
+				}
+			}
+			if content.URL != "" {
+				fileName := content.FileName
+				if fileName == "" {
+					fileName = content.Body
+				}
+				message.Attachments = []Attachment{{
+					FileName: fileName,
+					URL:      matrixMediaURL(content.URL),
+					Size:     content.Info.Size,
+					file:     matrixMedia(zipped, fileName),
+				}}
+				message.Content = ""
+			}
+			index[message.ID] = len(channel.Messages)
+			channel.Messages = append(channel.Messages, message)
+		}
+		archive.Channels = append(archive.Channels, channel)
+	}
+	if len(archive.Channels) == 1 {
+		archive.Name = archive.Channels[0].Name
+	}
+
+	// Power levels apply to the whole history, as of the export
+	used := make(map[string]bool)
+	for _, channel := range archive.Channels {
+		for i := range channel.Messages {
+			author := &channel.Messages[i].Author
+			switch level := levels[author.ID]; {
+			case level >= 100:
+				author.Roles = []string{matrixAdminRole}
+			case level >= 50:
+				author.Roles = []string{matrixModeratorRole}
+			}
+			for _, role := range author.Roles {
+				used[role] = true
+			}
+		}
+	}
+	if used[matrixAdminRole] {
+		archive.Roles = append(archive.Roles, Role{ID: matrixAdminRole, Name: "Admins", Position: 2})
+	}
+	if used[matrixModeratorRole] {
+		archive.Roles = append(archive.Roles, Role{ID: matrixModeratorRole, Name: "Moderators", Position: 1})
+	}
+	return archive, nil
+}
+
+// matrixBody is the text of a message; emotes read as "* name does"
+func matrixBody(content matrixContent, displayName string) string {
+	if content.MsgType == "m.emote" {
+		return "* " + displayName + " " + content.Body
+	}
+	return content.Body
+}
+
+// stripReplyFallback drops the quote of the answered message Matrix
+// clients put at the start of a reply's body
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
+
+// matrixLocalpart returns alice for @alice:example.org
+func matrixLocalpart(userID string) string {
+	localpart, _, _ := strings.Cut(strings.TrimPrefix(userID, "@"), ":")
+	return localpart
+}
+
+// matrixMediaURL turns an mxc:// URI into a download link on its homeserver
+func matrixMediaURL(uri string) string {
+	rest, ok := strings.CutPrefix(uri, "mxc://")
+	if !ok {
+		return uri
+	}
+	server, _, _ := strings.Cut(rest, "/")
+	return "https://" + server + "/_matrix/media/v3/download/" + rest
+}
+
+// matrixMedia finds an attachment under files/. Element adds a suffix to
+// names it has seen before, so files/photo-1.png also matches photo.png.
+func matrixMedia(zipped files, fileName string) *zip.File {
+	if fileName == "" {
+		return nil
+	}
+	if file, ok := zipped[path.Join("files", fileName)]; ok {
+		return file
+	}
+	ext := path.Ext(fileName)
+	stem := strings.TrimSuffix(fileName, ext)
+	for _, name := range zipped.names("files", ext) {
+		if strings.HasPrefix(path.Base(name), stem+"-") {
+			return zipped[name]
+		}
+	}
+	return nil
+}
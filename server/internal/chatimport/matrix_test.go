@@ -0,0 +1,62 @@
+package chatimport
+
+import (
+	"testing"
+)
+
+var matrixFiles = map[string]string{
+	"export.json": `{"room_id": "!abc:example.org", "room_name": "Lobby", "messages": [
+		{"type": "m.room.power_levels", "sender": "@alice:example.org", "state_key": "", "origin_server_ts": 1709283600000,
+			"content": {"users": {"@alice:example.org": 100, "@bob:example.org": 50}}},
+		{"type": "m.room.member", "sender": "@bob:example.org", "state_key": "@bob:example.org", "origin_server_ts": 1709283600000,
+			"content": {"membership": "join", "displayname": "Bobby"}},
+		{"type": "m.room.message", "event_id": "$1", "sender": "@alice:example.org", "origin_server_ts": 1709287200000,
+			"content": {"msgtype": "m.text", "body": "Welcome"}},
+		{"type": "m.room.message", "event_id": "$2", "sender": "@bob:example.org", "origin_server_ts": 1709287300000,
+			"content": {"msgtype": "m.text", "body": "> <@alice:example.org> Welcome\n\nThanks",
+				"m.relates_to": {"m.in_reply_to": {"event_id": "$1"}}}},
+		{"type": "m.room.message", "event_id": "$3", "sender": "@alice:example.org", "origin_server_ts": 1709287400000,
+			"content": {"msgtype": "m.text", "body": "* Welcome all", "m.new_content": {"msgtype": "m.text", "body": "Welcome all"},
+				"m.relates_to": {"rel_type": "m.replace", "event_id": "$1"}}},
+		{"type": "m.room.message", "event_id": "$4", "sender": "@bob:example.org", "origin_server_ts": 1709287500000,
+			"content": {"msgtype": "m.image", "body": "photo.png", "url": "mxc://example.org/xyz", "info": {"size": 4}}},
+		{"type": "m.room.message", "event_id": "$5", "sender": "@carol:example.org", "origin_server_ts": 1709287600000,
+			"content": {"msgtype": "m.emote", "body": "waves"}},
+		{"type": "m.reaction", "event_id": "$6", "sender": "@carol:example.org", "origin_server_ts": 1709287700000, "content": {}}]}`,
+	"files/photo-1709287500000.png": "\x89PNG",
+}
+
+func TestOpenMatrix(t *testing.T) {
+	archive, err := open(t, SourceMatrix, matrixFiles)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	if archive.Name != "Lobby" || len(archive.Channels) != 1 {
+		t.Fatalf("Expected the Lobby room as a channel, got %+v", archive)
+	}
+	messages := archive.Channels[0].Messages
+	if len(messages) != 4 || archive.SkippedMessages != 1 {
+		t.Fatalf("Expected 4 messages and the reaction skipped, got %d and %d", len(messages), archive.SkippedMessages)
+	}
+
+	welcome, reply, photo, emote := messages[0], messages[1], messages[2], messages[3]
+	if welcome.Content != "Welcome all" || welcome.Edited == nil || welcome.Author.Name != "alice" {
+		t.Errorf("Expected alice's edited welcome, got %+v", welcome)
+	}
+	if reply.ReplyTo != "$1" || reply.Content != "Thanks" || reply.Author.DisplayName != "Bobby" {
+		t.Errorf("Expected Bobby's reply without the quote, got %+v", reply)
+	}
+	if len(photo.Attachments) != 1 || !photo.Attachments[0].Exported() || photo.Content != "" {
+		t.Errorf("Expected the photo found under files/, got %+v", photo.Attachments)
+	}
+	if photo.Attachments[0].URL != "https://example.org/_matrix/media/v3/download/example.org/xyz" {
+		t.Errorf("Expected an mxc URI turned into a link, got %s", photo.Attachments[0].URL)
+	}
+	if emote.Content != "* carol waves" {
+		t.Errorf("Expected the emote with its sender, got %q", emote.Content)
+	}
+
+	if len(archive.Roles) != 2 || welcome.Author.Roles[0] != matrixAdminRole || reply.Author.Roles[0] != matrixModeratorRole || len(emote.Author.Roles) != 0 {
+		t.Errorf("Expected power levels mapped to roles, got %+v", archive.Roles)
+	}
+}
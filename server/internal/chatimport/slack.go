@@ -0,0 +1,235 @@
+package chatimport
+
+import (
+	"archive/zip"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slack exports hold channels.json, groups.json for private channels,
+// users.json and a directory per channel with a JSON file of messages per
+// day. Standard exports link files rather than include them; files found
+// at __uploads/<file id>/<name>, where slackdump puts them, are imported.
+
+type slackChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsAdmin bool   `json:"is_admin"`
+	IsOwner bool   `json:"is_owner"`
+	Profile struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+type slackFile struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	URLPrivate string `json:"url_private"`
+	Size       int64  `json:"size"`
+	Mode       string `json:"mode"`
+}
+
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	TS       string `json:"ts"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	ThreadTS string `json:"thread_ts"`
+	Edited   *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []slackFile `json:"files"`
+}
+
+// slackAdminRole is the role given to workspace admins and owners, the
+// only roles Slack exports carry
+const slackAdminRole = "admins"
+
+// slackImported lists the message subtypes people wrote; the rest are
+// joins, topic changes and the like
+var slackImported = map[string]bool{
+	"":                 true,
+	"bot_message":      true,
+	"file_share":       true,
+	"me_message":       true,
+	"thread_broadcast": true,
+}
+
+func openSlack(zipped files) (*Archive, error) {
+	var channels []slackChannel
+	if _, ok := zipped["channels.json"]; !ok {
+		return nil, ErrNotExport
+	}
+	if err := zipped.decode("channels.json", &channels); err != nil {
+		return nil, err
+	}
+	public := len(channels)
+	if _, ok := zipped["groups.json"]; ok {
+		var groups []slackChannel
+		if err := zipped.decode("groups.json", &groups); err != nil {
+			return nil, err
+		}
+		channels = append(channels, groups...)
+	}
+	users := make(map[string]slackUser)
+	if _, ok := zipped["users.json"]; ok {
+		var list []slackUser
+		if err := zipped.decode("users.json", &list); err != nil {
+			return nil, err
+		}
+		for _, user := range list {
+			users[user.ID] = user
+		}
+	}
+	channelNames := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		channelNames[channel.ID] = channel.Name
+	}
+
+	archive := &Archive{}
+	hasAdmins := false
+	for i, source := range channels {
+		channel := &Channel{ID: source.ID, Name: source.Name, Kind: "text", Private: i >= public}
+		for _, name := range zipped.names(source.Name, ".json") {
+			var messages []slackMessage
+			if err := zipped.decode(name, &messages); err != nil {
+				return nil, err
+			}
+			for _, message := range messages {
+				if message.Type != "message" || !slackImported[message.Subtype] {
+					archive.SkippedMessages++
+					continue
+				}
+				imported := Message{
+					ID:        slackMessageID(source.ID, message.TS),
+					Timestamp: slackTime(message.TS),
+					Content:   slackText(message.Text, users, channelNames),
+					Author:    slackAuthor(message, users),
+				}
+				if message.ThreadTS != "" && message.ThreadTS != message.TS {
+					imported.ReplyTo = slackMessageID(source.ID, message.ThreadTS)
+				}
+				if message.Edited != nil {
+					edited := slackTime(message.Edited.TS)
+					imported.Edited = &edited
+				}
+				if len(imported.Author.Roles) > 0 {
+					hasAdmins = true
+				}
+				for _, file := range message.Files {
+					// Deleted files and ones beyond the free plan's limit
+					if file.Mode == "tombstone" || file.Mode == "hidden_by_limit" {
+						continue
+					}
+					imported.Attachments = append(imported.Attachments, Attachment{
+						FileName: file.Name,
+						URL:      file.URLPrivate,
+						Size:     file.Size,
+						file:     slackMedia(zipped, file),
+					})
+				}
+				channel.Messages = append(channel.Messages, imported)
+			}
+		}
+		archive.Channels = append(archive.Channels, channel)
+	}
+	if hasAdmins {
+		archive.Roles = []Role{{ID: slackAdminRole, Name: "Admins", Position: 1}}
+	}
+	return archive, nil
+}
+
+// slackMessageID makes a message ID unique across channels; Slack's are
+// timestamps unique within one
+func slackMessageID(channelID, ts string) string {
+	return channelID + "/" + ts
+}
+
+// slackTime parses a Slack timestamp such as 1700000000.000100
+func slackTime(ts string) time.Time {
+	seconds, fraction, _ := strings.Cut(ts, ".")
+	sec, _ := strconv.ParseInt(seconds, 10, 64)
+	micro, _ := strconv.ParseInt((fraction + "000000")[:6], 10, 64)
+	return time.Unix(sec, micro*1000).UTC()
+}
+
+func slackAuthor(message slackMessage, users map[string]slackUser) Author {
+	user, ok := users[message.User]
+	if !ok {
+		// Bots and integrations post under a name of their own
+		id := message.User
+		if id == "" {
+			id = "bot:" + message.BotID + ":" + message.Username
+		}
+		name := message.Username
+		if name == "" {
+			name = message.User
+		}
+		return Author{ID: id, Name: name, DisplayName: name}
+	}
+	author := Author{ID: user.ID, Name: user.Name, DisplayName: user.Profile.DisplayName}
+	if author.DisplayName == "" {
+		author.DisplayName = user.Profile.RealName
+	}
+	if author.DisplayName == "" {
+		author.DisplayName = user.Name
+	}
+	if user.IsAdmin || user.IsOwner {
+		author.Roles = []string{slackAdminRole}
+	}
+	return author
+}
+
+// slackMarkup matches Slack's <...> references to users, channels and links
+var slackMarkup = regexp.MustCompile(`<([^<>]+)>`)
+
+// slackText turns Slack's markup into plain text: mentions become @name and
+// #channel, links their URL
+func slackText(text string, users map[string]slackUser, channels map[string]string) string {
+	text = slackMarkup.ReplaceAllStringFunc(text, func(match string) string {
+		ref, label, _ := strings.Cut(match[1:len(match)-1], "|")
+		switch {
+		case strings.HasPrefix(ref, "@"):
+			if user, ok := users[ref[1:]]; ok {
+				return "@" + user.Name
+			}
+			if label != "" {
+				return "@" + label
+			}
+		case strings.HasPrefix(ref, "#"):
+			if name, ok := channels[ref[1:]]; ok {
+				return "#" + name
+			}
+			if label != "" {
+				return "#" + label
+			}
+		case strings.HasPrefix(ref, "!"):
+			// @here, @channel and @everyone
+			name, _, _ := strings.Cut(ref[1:], "^")
+			return "@" + name
+		case label != "" && label != ref:
+			return label + " (" + ref + ")"
+		}
+		return ref
+	})
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
+
+func slackMedia(zipped files, file slackFile) *zip.File {
+	if file.ID == "" {
+		return nil
+	}
+	return zipped[path.Join("__uploads", file.ID, file.Name)]
+}
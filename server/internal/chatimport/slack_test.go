@@ -0,0 +1,63 @@
+package chatimport
+
+import (
+	"testing"
+	"time"
+)
+
+var slackFiles = map[string]string{
+	"channels.json": `[{"id": "C1", "name": "general", "topic": {"value": "Everything"}}, {"id": "C2", "name": "random", "purpose": {"value": "Off topic"}}]`,
+	"groups.json":   `[{"id": "G1", "name": "staff"}]`,
+	"users.json": `[
+		{"id": "U1", "name": "alice", "is_admin": true, "profile": {"display_name": "Alice"}},
+		{"id": "U2", "name": "bob", "profile": {"real_name": "Bob B"}}]`,
+	"general/2024-03-01.json": `[
+		{"type": "message", "subtype": "channel_join", "ts": "1709283600.000100", "user": "U2", "text": "<@U2> has joined the channel"},
+		{"type": "message", "ts": "1709287200.000200", "user": "U1", "text": "Hi <@U2>, see <#C2|random> and <https://example.com|the docs> &amp; <!here>",
+			"thread_ts": "1709287200.000200", "edited": {"user": "U1", "ts": "1709287260.000000"},
+			"files": [{"id": "F1", "name": "rack.png", "url_private": "https://files.slack.com/F1/rack.png", "size": 4},
+				{"id": "F2", "name": "gone.txt", "mode": "tombstone"}]}]`,
+	"general/2024-03-02.json": `[
+		{"type": "message", "ts": "1709373600.000300", "user": "U2", "text": "Thanks", "thread_ts": "1709287200.000200"},
+		{"type": "message", "subtype": "bot_message", "ts": "1709373700.000400", "bot_id": "B1", "username": "deploybot", "text": "Deployed"}]`,
+	"staff/2024-03-01.json": `[{"type": "message", "ts": "1709283600.000500", "user": "U1", "text": "Private"}]`,
+	"__uploads/F1/rack.png": "\x89PNG",
+}
+
+func TestOpenSlack(t *testing.T) {
+	archive, err := open(t, SourceSlack, slackFiles)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	if len(archive.Channels) != 3 || archive.SkippedMessages != 1 {
+		t.Fatalf("Expected 3 channels and the join skipped, got %d and %d", len(archive.Channels), archive.SkippedMessages)
+	}
+	general, staff := archive.Channels[0], archive.Channels[2]
+	if general.Private || !staff.Private {
+		t.Errorf("Expected only the channel from groups.json to be private")
+	}
+	if len(general.Messages) != 3 {
+		t.Fatalf("Expected 3 messages in #general, got %d", len(general.Messages))
+	}
+
+	first, reply, bot := general.Messages[0], general.Messages[1], general.Messages[2]
+	want := "Hi @bob, see #random and the docs (https://example.com) & @here"
+	if first.Content != want {
+		t.Errorf("Expected %q, got %q", want, first.Content)
+	}
+	if !first.Timestamp.Equal(time.Date(2024, 3, 1, 10, 0, 0, 200000, time.UTC)) || first.Edited == nil {
+		t.Errorf("Expected the timestamp and edit from the ts fields, got %v %v", first.Timestamp, first.Edited)
+	}
+	if first.Author.DisplayName != "Alice" || len(first.Author.Roles) != 1 || len(archive.Roles) != 1 {
+		t.Errorf("Expected Alice as an admin, got %+v and roles %+v", first.Author, archive.Roles)
+	}
+	if len(first.Attachments) != 1 || !first.Attachments[0].Exported() {
+		t.Errorf("Expected the uploaded file without the deleted one, got %+v", first.Attachments)
+	}
+	if reply.ReplyTo != first.ID || reply.Author.DisplayName != "Bob B" {
+		t.Errorf("Expected Bob's reply in the thread, got %+v", reply)
+	}
+	if bot.Author.Name != "deploybot" || bot.ReplyTo != "" {
+		t.Errorf("Expected the bot to post under its own name, got %+v", bot.Author)
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"fmt"
 	"log"
 	"os"
-
-	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"strings"
 )
 
 type Database struct {
 	*sql.DB
+
+	replicas *replicaSet
+}
+
+// Close closes the primary and any read replicas
+func (db *Database) Close() error {
+	db.closeReplicas()
+	return db.DB.Close()
 }
 
 // IsFirstTime checks if this is the first time running the application
@@ -65,13 +73,33 @@ func (db *Database) GetAllSettings() (map[string]string, error) {
 	return settings, nil
 }
 
+// Data locations
+const (
+	DataDir = "./data"
+	Path    = DataDir + "/fethur.db"
+)
+
+// SchemaVersion is stored in the database's user_version once createTables
+// has brought it up to date. Bump it whenever the schema changes.
+const SchemaVersion = 51
+
+// Init opens the server's database at Path, creating and migrating it as
+// needed
 func Init() (*Database, error) {
+	return Open(Path)
+}
+
+// Open opens the database at path, creating and migrating it as needed.
+// Tests use it to work on a database of their own.
+func Open(path string) (*Database, error) {
 	// Ensure data directory exists
-	if err := os.MkdirAll("./data", 0750); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", "./data/fethur.db")
+	// WAL lets readers run during writes and maintenance; new databases use
+	// incremental auto-vacuum so free pages can be released in small steps
+	db, err := sql.Open(driverName, path+"?_journal_mode=WAL&_auto_vacuum=incremental&_busy_timeout=5000&_loc=UTC")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -85,12 +113,107 @@ func Init() (*Database, error) {
 	if err := createTables(db); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return nil, fmt.Errorf("failed to record schema version: %w", err)
+	}
 
 	log.Println("Database initialized successfully")
-	return &Database{db}, nil
+	return &Database{DB: db, replicas: &replicaSet{}}, nil
+}
+
+// OpenExisting opens the database read-only without creating or migrating
+// anything, for diagnostics
+func OpenExisting() (*Database, error) {
+	if _, err := os.Stat(Path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, "file:"+Path+"?mode=ro&_loc=UTC")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Database{DB: db, replicas: &replicaSet{}}, nil
+}
+
+// StoredSchemaVersion returns the schema version recorded in the database
+func (db *Database) StoredSchemaVersion() (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// ExpectedTables lists the tables the current schema defines, by building
+// it in a scratch in-memory database
+func ExpectedTables() ([]string, error) {
+	scratch, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = scratch.Close()
+	}()
+	// Every pooled connection would get its own empty in-memory database
+	scratch.SetMaxOpenConns(1)
+
+	if err := createTables(scratch); err != nil {
+		return nil, err
+	}
+	return tableNames(scratch)
+}
+
+// MissingTables returns expected tables that do not exist in the database
+func (db *Database) MissingTables() ([]string, error) {
+	expected, err := ExpectedTables()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := tableNames(db.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		present[name] = true
+	}
+	missing := make([]string, 0)
+	for _, name := range expected {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+func tableNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
 }
 
 func createTables(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
 	// Users table with role support
 	usersTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -98,7 +221,8 @@ func createTables(db *sql.DB) error {
 		username TEXT UNIQUE NOT NULL,
 		email TEXT,
 		password_hash TEXT NOT NULL,
-		role TEXT DEFAULT 'user' CHECK (role IN ('super_admin', 'admin', 'user')),
+		role TEXT DEFAULT 'user' REFERENCES instance_roles (name),
+		token_version INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -199,7 +323,947 @@ func createTables(db *sql.DB) error {
 		FOREIGN KEY (admin_id) REFERENCES users (id) ON DELETE CASCADE
 	);`
 
-	tables := []string{usersTable, settingsTable, serversTable, channelsTable, messagesTable, serverMembersTable, userBansTable, userMutesTable, auditLogsTable}
+	// Offline events table: references to events queued for users with no active session
+	offlineEventsTable := `
+	CREATE TABLE IF NOT EXISTS offline_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		channel_id INTEGER NOT NULL,
+		message_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(user_id, event_type, message_id)
+	);`
+
+	// User notifications table: notifications for users who were offline
+	// when they were raised, delivered on their next connect
+	userNotificationsTable := `
+	CREATE TABLE IF NOT EXISTS user_notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		content TEXT NOT NULL,
+		data TEXT NOT NULL DEFAULT '{}',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Idempotency keys for deduplicating retried message sends
+	messageIdempotencyTable := `
+	CREATE TABLE IF NOT EXISTS message_idempotency (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		message_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+		UNIQUE(user_id, idempotency_key)
+	);`
+
+	// Resource versions back conditional GET support (ETag / Last-Modified)
+	resourceVersionsTable := `
+	CREATE TABLE IF NOT EXISTS resource_versions (
+		resource TEXT PRIMARY KEY,
+		version INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Admin capabilities table for granular admin panel permissions
+	adminCapabilitiesTable := `
+	CREATE TABLE IF NOT EXISTS admin_capabilities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		capability TEXT NOT NULL,
+		granted_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(user_id, capability)
+	);`
+
+	// Instance roles: the built-in super_admin, admin, user and guest, and
+	// custom roles defined by super admins
+	instanceRolesTable := `
+	CREATE TABLE IF NOT EXISTS instance_roles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		built_in BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Permissions held by each instance role
+	instanceRolePermissionsTable := `
+	CREATE TABLE IF NOT EXISTS instance_role_permissions (
+		role TEXT NOT NULL,
+		permission TEXT NOT NULL,
+		PRIMARY KEY (role, permission),
+		FOREIGN KEY (role) REFERENCES instance_roles (name) ON DELETE CASCADE
+	);`
+
+	// Invite codes for the invite_only registration mode; max_uses 0 means
+	// unlimited
+	registrationInvitesTable := `
+	CREATE TABLE IF NOT EXISTS registration_invites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		org_id INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER,
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// User devices table for new-device detection and session revocation
+	userDevicesTable := `
+	CREATE TABLE IF NOT EXISTS user_devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		fingerprint TEXT NOT NULL,
+		user_agent TEXT,
+		ip_prefix TEXT,
+		first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME,
+		last_ip TEXT,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(user_id, fingerprint)
+	);`
+
+	// Pending changes to super-sensitive settings awaiting a second admin
+	settingChangeRequestsTable := `
+	CREATE TABLE IF NOT EXISTS setting_change_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		requested_by INTEGER NOT NULL,
+		status TEXT DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+		decided_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decided_at DATETIME,
+		FOREIGN KEY (requested_by) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Attachments table: uploaded files, linked to a message once sent
+	attachmentsTable := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		uploader_id INTEGER NOT NULL,
+		message_id INTEGER,
+		storage_key TEXT UNIQUE NOT NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		status TEXT DEFAULT 'pending' CHECK (status IN ('pending', 'ready')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME,
+		FOREIGN KEY (uploader_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE SET NULL
+	);`
+
+	// Attachment blobs table: one stored object per unique content hash,
+	// shared by every attachment with the same content
+	attachmentBlobsTable := `
+	CREATE TABLE IF NOT EXISTS attachment_blobs (
+		hash TEXT PRIMARY KEY,
+		storage_key TEXT UNIQUE NOT NULL,
+		size INTEGER NOT NULL,
+		ref_count INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Mail deliveries table: one row per email sent or attempted
+	mailDeliveriesTable := `
+	CREATE TABLE IF NOT EXISTS mail_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipient TEXT NOT NULL,
+		template TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+		error TEXT,
+		user_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL
+	);`
+
+	// Push devices table: mobile push tokens registered by each user
+	pushDevicesTable := `
+	CREATE TABLE IF NOT EXISTS push_devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		platform TEXT NOT NULL CHECK (platform IN ('fcm', 'apns')),
+		token TEXT UNIQUE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// XMPP links table: the XMPP account each user chatted from, proven by
+	// sending a signed link code to the gateway
+	xmppLinksTable := `
+	CREATE TABLE IF NOT EXISTS xmpp_links (
+		user_id INTEGER PRIMARY KEY,
+		jid TEXT UNIQUE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Command webhooks table: slash commands handled by external endpoints
+	commandWebhooksTable := `
+	CREATE TABLE IF NOT EXISTS command_webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		command TEXT UNIQUE NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		previous_secret TEXT NOT NULL DEFAULT '',
+		secret_rotated_at DATETIME,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+
+	// API keys table: personal access tokens for bots, scripts and
+	// automation platforms; only a hash of each key is stored. scopes is a
+	// comma-separated list, "*" for every scope.
+	apiKeysTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '*',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		expires_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Automation hooks table: REST hook subscriptions created through an
+	// API key, removed with the key
+	automationHooksTable := `
+	CREATE TABLE IF NOT EXISTS automation_hooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		api_key_id INTEGER NOT NULL,
+		event TEXT NOT NULL CHECK (event IN ('message.created', 'raid_mode.changed')),
+		channel_id INTEGER,
+		target_url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_status INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		previous_secret TEXT NOT NULL DEFAULT '',
+		secret_rotated_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys (id) ON DELETE CASCADE,
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE
+	);`
+
+	// Incoming webhooks table: URLs external services post to, formatted
+	// by a built-in transformer into the chosen channel
+	incomingWebhooksTable := `
+	CREATE TABLE IF NOT EXISTS incoming_webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		format TEXT NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		secret TEXT NOT NULL DEFAULT '',
+		created_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		previous_secret TEXT NOT NULL DEFAULT '',
+		secret_rotated_at DATETIME,
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Webhook deliveries table: recent requests accepted by signed incoming
+	// webhooks, so a captured request cannot be replayed
+	webhookDeliveriesTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		webhook_id INTEGER NOT NULL,
+		delivery TEXT NOT NULL,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (webhook_id, delivery),
+		FOREIGN KEY (webhook_id) REFERENCES incoming_webhooks (id) ON DELETE CASCADE
+	);`
+
+	// Alert states table: the last status seen for each alert fingerprint
+	// per incoming webhook, used to drop repeats and pair resolutions
+	alertStatesTable := `
+	CREATE TABLE IF NOT EXISTS alert_states (
+		webhook_id INTEGER NOT NULL,
+		fingerprint TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('firing', 'resolved')),
+		message_id INTEGER,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (webhook_id, fingerprint),
+		FOREIGN KEY (webhook_id) REFERENCES incoming_webhooks (id) ON DELETE CASCADE
+	);`
+
+	// Channel calendars table: ICS feeds a channel subscribes to, with
+	// reminder lead times as comma-separated minutes
+	channelCalendarsTable := `
+	CREATE TABLE IF NOT EXISTS channel_calendars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		lead_minutes TEXT NOT NULL DEFAULT '60',
+		created_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_fetched_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Calendar events table: upcoming occurrences from the last fetch of
+	// each calendar, replaced on every refresh
+	calendarEventsTable := `
+	CREATE TABLE IF NOT EXISTS calendar_events (
+		calendar_id INTEGER NOT NULL,
+		event_key TEXT NOT NULL,
+		uid TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		location TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		all_day INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (calendar_id, event_key),
+		FOREIGN KEY (calendar_id) REFERENCES channel_calendars (id) ON DELETE CASCADE
+	);`
+
+	// Calendar reminders table: reminders already posted, so each lead
+	// time fires once per occurrence
+	calendarRemindersTable := `
+	CREATE TABLE IF NOT EXISTS calendar_reminders (
+		calendar_id INTEGER NOT NULL,
+		event_key TEXT NOT NULL,
+		lead_minutes INTEGER NOT NULL,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (calendar_id, event_key, lead_minutes),
+		FOREIGN KEY (calendar_id) REFERENCES channel_calendars (id) ON DELETE CASCADE
+	);`
+
+	// Server events table: scheduled community events, moved from
+	// scheduled to active to ended by the server
+	serverEventsTable := `
+	CREATE TABLE IF NOT EXISTS server_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		voice_channel_id INTEGER,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'active', 'ended', 'cancelled')),
+		reminded INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (voice_channel_id) REFERENCES channels (id) ON DELETE SET NULL,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Server event RSVPs table
+	serverEventRSVPsTable := `
+	CREATE TABLE IF NOT EXISTS server_event_rsvps (
+		event_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('going', 'interested')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (event_id, user_id),
+		FOREIGN KEY (event_id) REFERENCES server_events (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Server roles table: named roles members can hold alongside their
+	// owner, admin or member rank
+	serverRolesTable := `
+	CREATE TABLE IF NOT EXISTS server_roles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		UNIQUE(server_id, name)
+	);`
+
+	// Member roles table
+	memberRolesTable := `
+	CREATE TABLE IF NOT EXISTS member_roles (
+		server_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, role_id),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES server_roles (id) ON DELETE CASCADE
+	);`
+
+	// Channel members table: who besides owners, admins and the channel's
+	// role can see a private channel
+	channelMembersTable := `
+	CREATE TABLE IF NOT EXISTS channel_members (
+		channel_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, user_id),
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Ticket settings table: one row per server using tickets
+	ticketSettingsTable := `
+	CREATE TABLE IF NOT EXISTS ticket_settings (
+		server_id INTEGER PRIMARY KEY,
+		support_role_id INTEGER,
+		transcript_channel_id INTEGER,
+		max_open INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (support_role_id) REFERENCES server_roles (id) ON DELETE SET NULL,
+		FOREIGN KEY (transcript_channel_id) REFERENCES channels (id) ON DELETE SET NULL
+	);`
+
+	// Tickets table: private support threads; the channel is removed on
+	// close and the transcript kept
+	ticketsTable := `
+	CREATE TABLE IF NOT EXISTS tickets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		number INTEGER NOT NULL,
+		channel_id INTEGER,
+		opened_by INTEGER NOT NULL,
+		subject TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'closed')),
+		claimed_by INTEGER,
+		first_response_at DATETIME,
+		closed_by INTEGER,
+		close_reason TEXT NOT NULL DEFAULT '',
+		closed_at DATETIME,
+		transcript TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (opened_by) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(server_id, number),
+		UNIQUE(channel_id)
+	);`
+
+	// Message reactions table
+	messageReactionsTable := `
+	CREATE TABLE IF NOT EXISTS message_reactions (
+		message_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		emoji TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_id, user_id, emoji),
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Starboard settings table: one row per server with a starboard
+	starboardSettingsTable := `
+	CREATE TABLE IF NOT EXISTS starboard_settings (
+		server_id INTEGER PRIMARY KEY,
+		channel_id INTEGER NOT NULL,
+		emoji TEXT NOT NULL DEFAULT '⭐',
+		threshold INTEGER NOT NULL DEFAULT 3,
+		allow_self INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE
+	);`
+
+	// Starboard entries table: links a starred message to its repost
+	starboardEntriesTable := `
+	CREATE TABLE IF NOT EXISTS starboard_entries (
+		message_id INTEGER PRIMARY KEY,
+		server_id INTEGER NOT NULL,
+		starboard_message_id INTEGER,
+		stars INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		UNIQUE(starboard_message_id)
+	);`
+
+	// Member XP table: activity points per member and server
+	memberXPTable := `
+	CREATE TABLE IF NOT EXISTS member_xp (
+		server_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		xp INTEGER NOT NULL DEFAULT 0,
+		messages INTEGER NOT NULL DEFAULT 0,
+		voice_minutes INTEGER NOT NULL DEFAULT 0,
+		message_xp_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (server_id, user_id),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// XP settings table: a server's XP rates and level curve; servers
+	// without a row use the defaults
+	xpSettingsTable := `
+	CREATE TABLE IF NOT EXISTS xp_settings (
+		server_id INTEGER PRIMARY KEY,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		message_xp_min INTEGER NOT NULL DEFAULT 15,
+		message_xp_max INTEGER NOT NULL DEFAULT 25,
+		message_cooldown_seconds INTEGER NOT NULL DEFAULT 60,
+		voice_xp_per_minute INTEGER NOT NULL DEFAULT 10,
+		curve_a INTEGER NOT NULL DEFAULT 5,
+		curve_b INTEGER NOT NULL DEFAULT 50,
+		curve_c INTEGER NOT NULL DEFAULT 100,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// XP role rewards table: roles granted when a member reaches a level
+	xpRoleRewardsTable := `
+	CREATE TABLE IF NOT EXISTS xp_role_rewards (
+		server_id INTEGER NOT NULL,
+		level INTEGER NOT NULL,
+		role_id INTEGER NOT NULL,
+		PRIMARY KEY (server_id, level, role_id),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES server_roles (id) ON DELETE CASCADE
+	);`
+
+	// Reaction roles table: an emoji on a message that grants or removes
+	// a role when members react with it
+	reactionRolesTable := `
+	CREATE TABLE IF NOT EXISTS reaction_roles (
+		message_id INTEGER NOT NULL,
+		emoji TEXT NOT NULL,
+		server_id INTEGER NOT NULL,
+		role_id INTEGER NOT NULL,
+		mode TEXT NOT NULL DEFAULT 'toggle' CHECK (mode IN ('toggle', 'grant', 'remove')),
+		created_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_id, emoji),
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES server_roles (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id)
+	);`
+
+	// Auto roles table: roles every new member of a server receives
+	serverAutoRolesTable := `
+	CREATE TABLE IF NOT EXISTS server_auto_roles (
+		server_id INTEGER NOT NULL,
+		role_id INTEGER NOT NULL,
+		PRIMARY KEY (server_id, role_id),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES server_roles (id) ON DELETE CASCADE
+	);`
+
+	// Channel integrations table: the webhooks, commands and bots listed in
+	// a channel's allowlist or denylist
+	channelIntegrationsTable := `
+	CREATE TABLE IF NOT EXISTS channel_integrations (
+		channel_id INTEGER NOT NULL,
+		integration TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (channel_id, integration),
+		FOREIGN KEY (channel_id) REFERENCES channels (id) ON DELETE CASCADE
+	);`
+
+	// Organizations table: isolated communities hosted on one deployment.
+	// Limits of 0 mean unlimited.
+	organizationsTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		slug TEXT UNIQUE NOT NULL,
+		domain TEXT UNIQUE,
+		max_users INTEGER NOT NULL DEFAULT 0,
+		max_servers INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Organization settings table: per-organization overrides of instance
+	// settings
+	organizationSettingsTable := `
+	CREATE TABLE IF NOT EXISTS organization_settings (
+		org_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (org_id, key),
+		FOREIGN KEY (org_id) REFERENCES organizations (id) ON DELETE CASCADE
+	);`
+
+	// Server quotas table: per-server overrides of the default limits set
+	// by super admins. NULL keeps the default.
+	serverQuotasTable := `
+	CREATE TABLE IF NOT EXISTS server_quotas (
+		server_id INTEGER PRIMARY KEY,
+		max_channels INTEGER,
+		max_members INTEGER,
+		max_webhooks INTEGER,
+		upload_quota_mb INTEGER,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Thread follows table: users notified of replies to a thread. An
+	// explicit unfollow keeps the row with following = 0 so participating
+	// does not follow the thread again.
+	threadFollowsTable := `
+	CREATE TABLE IF NOT EXISTS thread_follows (
+		user_id INTEGER NOT NULL,
+		message_id INTEGER NOT NULL,
+		following INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, message_id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+	);`
+
+	// Member imports table: bulk member imports worked through by the job
+	// queue. payload holds the parsed rows; processed is where a restart
+	// resumes.
+	memberImportsTable := `
+	CREATE TABLE IF NOT EXISTS member_imports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		created_by INTEGER NOT NULL,
+		create_accounts INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+		payload TEXT NOT NULL,
+		total INTEGER NOT NULL,
+		processed INTEGER NOT NULL DEFAULT 0,
+		added INTEGER NOT NULL DEFAULT 0,
+		created INTEGER NOT NULL DEFAULT 0,
+		skipped INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		errors TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Discord imports table: Discord exports imported into new servers by
+	// the job queue. The archive stays in storage until the import ends.
+	discordImportsTable := `
+	CREATE TABLE IF NOT EXISTS discord_imports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_by INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+		server_id INTEGER,
+		summary TEXT NOT NULL,
+		messages_imported INTEGER NOT NULL DEFAULT 0,
+		attachments_imported INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Discord import IDs table: what a running import already created, by
+	// Discord ID, so an interrupted import resumes without duplicates
+	discordImportIDsTable := `
+	CREATE TABLE IF NOT EXISTS discord_import_ids (
+		import_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		discord_id TEXT NOT NULL,
+		local_id INTEGER NOT NULL,
+		PRIMARY KEY (import_id, kind, discord_id),
+		FOREIGN KEY (import_id) REFERENCES discord_imports (id) ON DELETE CASCADE
+	);`
+
+	// Server directory table: servers listed by their owners in the
+	// instance's public directory. Unlisting keeps the row so a listing
+	// hidden by an admin stays hidden when it is listed again.
+	serverDirectoryTable := `
+	CREATE TABLE IF NOT EXISTS server_directory (
+		server_id INTEGER PRIMARY KEY,
+		listed INTEGER NOT NULL DEFAULT 1,
+		featured INTEGER NOT NULL DEFAULT 0,
+		hidden INTEGER NOT NULL DEFAULT 0,
+		listed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Server directory tags table: what a listing can be found by
+	serverDirectoryTagsTable := `
+	CREATE TABLE IF NOT EXISTS server_directory_tags (
+		server_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (server_id, tag),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Server directory reports table: listings reported to admins. A user
+	// has at most one open report per server.
+	serverDirectoryReportsTable := `
+	CREATE TABLE IF NOT EXISTS server_directory_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		reporter_id INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		resolved_at DATETIME,
+		resolved_by INTEGER,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Raid settings table: a server's raid mode and what triggers it
+	// automatically. activated_by is NULL when a join spike turned it on;
+	// expires_at is NULL while it lasts until turned off.
+	raidSettingsTable := `
+	CREATE TABLE IF NOT EXISTS raid_settings (
+		server_id INTEGER PRIMARY KEY,
+		auto INTEGER NOT NULL DEFAULT 0,
+		join_threshold INTEGER NOT NULL DEFAULT 10,
+		window_seconds INTEGER NOT NULL DEFAULT 60,
+		duration_minutes INTEGER NOT NULL DEFAULT 30,
+		active INTEGER NOT NULL DEFAULT 0,
+		activated_at DATETIME,
+		activated_by INTEGER,
+		expires_at DATETIME,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Server join requests table: joins held for approval while raid mode
+	// is on
+	serverJoinRequestsTable := `
+	CREATE TABLE IF NOT EXISTS server_join_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decided_by INTEGER,
+		decided_at DATETIME,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Moderation cases table: related moderation actions against one user,
+	// numbered per server
+	moderationCasesTable := `
+	CREATE TABLE IF NOT EXISTS moderation_cases (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER NOT NULL,
+		number INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		moderator_id INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+		appeal_status TEXT NOT NULL DEFAULT 'none' CHECK (appeal_status IN ('none', 'pending', 'accepted', 'rejected')),
+		appeal_reason TEXT,
+		appeal_decided_by INTEGER,
+		appeal_decided_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (server_id, number),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Moderation case actions table: the warnings, mutes, quarantines, kicks
+	// and bans of a case. Active mutes and bans keep the user from posting in
+	// or joining the server until they expire or are lifted; an active
+	// quarantine hides the user's new messages from everyone but them and
+	// the server's moderators.
+	moderationCaseActionsTable := `
+	CREATE TABLE IF NOT EXISTS moderation_case_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		case_id INTEGER NOT NULL,
+		action TEXT NOT NULL CHECK (action IN ('warn', 'mute', 'quarantine', 'kick', 'ban')),
+		reason TEXT NOT NULL DEFAULT '',
+		moderator_id INTEGER NOT NULL,
+		expires_at DATETIME,
+		lifted_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (case_id) REFERENCES moderation_cases (id) ON DELETE CASCADE
+	);`
+
+	// Moderation case notes table: moderators' notes on a case
+	moderationCaseNotesTable := `
+	CREATE TABLE IF NOT EXISTS moderation_case_notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		case_id INTEGER NOT NULL,
+		author_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (case_id) REFERENCES moderation_cases (id) ON DELETE CASCADE
+	);`
+
+	// Moderation case evidence table: messages linked to a case
+	moderationCaseEvidenceTable := `
+	CREATE TABLE IF NOT EXISTS moderation_case_evidence (
+		case_id INTEGER NOT NULL,
+		message_id INTEGER NOT NULL,
+		added_by INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (case_id, message_id),
+		FOREIGN KEY (case_id) REFERENCES moderation_cases (id) ON DELETE CASCADE
+	);`
+
+	// Moderation case audit logs table: the audit log entries a case's
+	// actions and decisions wrote
+	moderationCaseAuditLogsTable := `
+	CREATE TABLE IF NOT EXISTS moderation_case_audit_logs (
+		case_id INTEGER NOT NULL,
+		audit_log_id INTEGER NOT NULL,
+		PRIMARY KEY (case_id, audit_log_id),
+		FOREIGN KEY (case_id) REFERENCES moderation_cases (id) ON DELETE CASCADE,
+		FOREIGN KEY (audit_log_id) REFERENCES audit_logs (id) ON DELETE CASCADE
+	);`
+
+	// Refresh tokens table: hashes of the long-lived tokens that renew a
+	// session's JWT. Each use replaces the token with a new one of the same
+	// family, and reusing a replaced token revokes the whole family.
+	refreshTokensTable := `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		family TEXT NOT NULL,
+		device_id INTEGER,
+		token_version INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (device_id) REFERENCES user_devices (id) ON DELETE CASCADE
+	);`
+
+	// Backup codes table: hashes of single-use two-factor codes
+	backupCodesTable := `
+	CREATE TABLE IF NOT EXISTS user_backup_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(user_id, code_hash)
+	);`
+
+	// User identities table: accounts at OpenID Connect providers that
+	// sign in as a user, matched by issuer and subject
+	userIdentitiesTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		UNIQUE(issuer, subject)
+	);`
+
+	// Alert rules table: thresholds on server health the alert checker
+	// evaluates every minute, and where it sends alerts when they trip
+	alertRulesTable := `
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		comparison TEXT NOT NULL CHECK (comparison IN ('>', '<')),
+		threshold REAL NOT NULL,
+		channels TEXT NOT NULL DEFAULT 'admin',
+		webhook_url TEXT NOT NULL DEFAULT '',
+		webhook_secret TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		state TEXT NOT NULL DEFAULT 'ok' CHECK (state IN ('ok', 'firing')),
+		last_value REAL,
+		last_checked_at DATETIME,
+		fired_at DATETIME,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+
+	// Policy documents table: each published version of the instance's
+	// terms of service and rules, in markdown
+	policyDocumentsTable := `
+	CREATE TABLE IF NOT EXISTS policy_documents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL CHECK (kind IN ('terms', 'rules')),
+		version INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		retired_at DATETIME,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL,
+		UNIQUE(kind, version)
+	);`
+
+	// Policy acknowledgments table: which versions each user accepted,
+	// and when
+	policyAcknowledgmentsTable := `
+	CREATE TABLE IF NOT EXISTS policy_acknowledgments (
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		acknowledged_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, kind, version),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	);`
+
+	// Content filters table: per server, the languages whose word lists
+	// apply and what happens to messages using profanity or slurs
+	contentFiltersTable := `
+	CREATE TABLE IF NOT EXISTS content_filters (
+		server_id INTEGER PRIMARY KEY,
+		languages TEXT NOT NULL DEFAULT '',
+		profanity_action TEXT NOT NULL DEFAULT 'off',
+		slur_action TEXT NOT NULL DEFAULT 'off',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE
+	);`
+
+	// Content filter words table: words servers add to the shipped lists
+	contentFilterWordsTable := `
+	CREATE TABLE IF NOT EXISTS content_filter_words (
+		server_id INTEGER NOT NULL,
+		word TEXT NOT NULL,
+		category TEXT NOT NULL CHECK (category IN ('profanity', 'slur')),
+		created_by INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (server_id, word),
+		FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+
+	// Message revisions table: the earlier versions of edited messages,
+	// each with when it was written and who replaced it
+	messageRevisionsTable := `
+	CREATE TABLE IF NOT EXISTS message_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		embeds TEXT,
+		written_at DATETIME NOT NULL,
+		replaced_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		replaced_by INTEGER,
+		FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+		FOREIGN KEY (replaced_by) REFERENCES users (id) ON DELETE SET NULL
+	);`
+
+	tables := []string{usersTable, settingsTable, serversTable, channelsTable, messagesTable, serverMembersTable, userBansTable, userMutesTable, auditLogsTable, offlineEventsTable, userNotificationsTable, messageIdempotencyTable, resourceVersionsTable, adminCapabilitiesTable, instanceRolesTable, instanceRolePermissionsTable, registrationInvitesTable, userDevicesTable, settingChangeRequestsTable, attachmentsTable, attachmentBlobsTable, mailDeliveriesTable, pushDevicesTable, xmppLinksTable, commandWebhooksTable, apiKeysTable, automationHooksTable, incomingWebhooksTable, webhookDeliveriesTable, alertStatesTable, channelCalendarsTable, calendarEventsTable, calendarRemindersTable, serverEventsTable, serverEventRSVPsTable, serverRolesTable, memberRolesTable, channelMembersTable, ticketSettingsTable, ticketsTable, messageReactionsTable, starboardSettingsTable, starboardEntriesTable, memberXPTable, xpSettingsTable, xpRoleRewardsTable, reactionRolesTable, serverAutoRolesTable, channelIntegrationsTable, organizationsTable, organizationSettingsTable, serverQuotasTable, threadFollowsTable, memberImportsTable, discordImportsTable, discordImportIDsTable, serverDirectoryTable, serverDirectoryTagsTable, serverDirectoryReportsTable, raidSettingsTable, serverJoinRequestsTable, moderationCasesTable, moderationCaseActionsTable, moderationCaseNotesTable, moderationCaseEvidenceTable, moderationCaseAuditLogsTable, refreshTokensTable, backupCodesTable, userIdentitiesTable, alertRulesTable, policyDocumentsTable, policyAcknowledgmentsTable, contentFiltersTable, contentFilterWordsTable, messageRevisionsTable}
 
 	for _, table := range tables {
 		if _, err := db.Exec(table); err != nil {
@@ -207,5 +1271,340 @@ func createTables(db *sql.DB) error {
 		}
 	}
 
+	// Columns added after the initial schema
+	if err := addColumnIfMissing(db, "users", "token_version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "upload_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "blob_hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "processing", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "original_key", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "last_seen_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "digest_opt_out", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "digest_sent_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "digest_unsubscribe_token", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "notify_mobile", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "notify_relay", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "notify_relay_target", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "bot_name", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "embeds", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "edited_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "private", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "access_role_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "temp_owner_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "generator_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "integration_mode", "TEXT NOT NULL DEFAULT 'open'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "user_limit", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "org_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "servers", "org_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "reply_to_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "thread_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "auto_follow_threads", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "channels", "public", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Imports from Slack and Matrix share the Discord import tables
+	if err := addColumnIfMissing(db, "discord_imports", "source", "TEXT NOT NULL DEFAULT 'discord'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "quarantined", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Two-factor sign-in: the authenticator secret, set up before it is
+	// enabled, and the last code step used so codes cannot be replayed
+	if err := addColumnIfMissing(db, "users", "totp_secret", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "server_roles", "position", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "server_roles", "manage_roles", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "api_keys", "scopes", "TEXT NOT NULL DEFAULT '*'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "api_keys", "expires_at", "DATETIME"); err != nil {
+		return err
+	}
+	for _, table := range []string{"command_webhooks", "automation_hooks", "incoming_webhooks"} {
+		if err := addColumnIfMissing(db, table, "previous_secret", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(db, table, "secret_rotated_at", "DATETIME"); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfMissing(db, "user_devices", "last_ip", "TEXT"); err != nil {
+		return err
+	}
+	// Edit versions for optimistic concurrency
+	for _, table := range []string{"servers", "channels", "server_roles"} {
+		if err := addColumnIfMissing(db, table, "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+	}
+
+	// Ephemeral guest accounts expire and are deleted
+	if err := addColumnIfMissing(db, "users", "guest_expires_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// Email verification
+	if err := addColumnIfMissing(db, "users", "email_verified", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "verification_sent_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// Registrations waiting for an admin's approval
+	if err := addColumnIfMissing(db, "users", "pending_approval", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Where admins acted from, and the country a device signed in from
+	if err := addColumnIfMissing(db, "audit_logs", "ip", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "user_devices", "country", "TEXT"); err != nil {
+		return err
+	}
+
+	// Age gate: NSFW channels, and whether a user is under the age to see
+	// them. The date of birth itself is never stored.
+	if err := addColumnIfMissing(db, "channels", "nsfw", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "under_age", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "birth_year_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "edit_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Constraints changed after the initial schema
+	for _, check := range []string{
+		"CHECK (role IN ('super_admin', 'admin', 'user'))",
+		"CHECK (role IN ('super_admin', 'admin', 'user', 'guest'))",
+	} {
+		if err := rewriteTableIfOutdated(db, "users", check, "REFERENCES instance_roles (name)"); err != nil {
+			return err
+		}
+	}
+	if err := rebuildTableIfOutdated(db, "automation_hooks", "'raid_mode.changed'", automationHooksTable); err != nil {
+		return err
+	}
+	if err := rebuildTableIfOutdated(db, "moderation_case_actions", "'quarantine'", moderationCaseActionsTable); err != nil {
+		return err
+	}
+
+	if err := seedInstanceRoles(db); err != nil {
+		return err
+	}
+
+	if version < timestampsVersion {
+		if err := normalizeTimestamps(db); err != nil {
+			return err
+		}
+	}
+
+	// Release blob references whenever an attachment row is deleted, so
+	// counts stay correct however the row goes away
+	if _, err := db.Exec(`
+	CREATE TRIGGER IF NOT EXISTS attachments_release_blob
+	AFTER DELETE ON attachments
+	WHEN OLD.blob_hash IS NOT NULL
+	BEGIN
+		UPDATE attachment_blobs SET ref_count = ref_count - 1 WHERE hash = OLD.blob_hash;
+	END;`); err != nil {
+		return fmt.Errorf("failed to create trigger: %w", err)
+	}
+
+	return nil
+}
+
+// builtInRoles are the instance roles every database has. New admins are
+// granted the admin role's permissions, which start as these.
+var builtInRoles = []struct {
+	name        string
+	description string
+	permissions []string
+}{
+	{"super_admin", "Holds every permission", nil},
+	{"admin", "Holds the permissions granted to them", []string{"manage_users", "moderate_content", "view_metrics"}},
+	{"user", "A regular account", nil},
+	{"guest", "An ephemeral, read-only guest account", nil},
+}
+
+// seedInstanceRoles creates the built-in roles that are missing, with
+// their default permissions
+func seedInstanceRoles(db *sql.DB) error {
+	for _, role := range builtInRoles {
+		result, err := db.Exec(
+			"INSERT OR IGNORE INTO instance_roles (name, description, built_in) VALUES (?, ?, 1)",
+			role.name, role.description,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create role %s: %w", role.name, err)
+		}
+		if created, _ := result.RowsAffected(); created == 0 {
+			continue
+		}
+		for _, permission := range role.permissions {
+			if _, err := db.Exec(
+				"INSERT OR IGNORE INTO instance_role_permissions (role, permission) VALUES (?, ?)", role.name, permission,
+			); err != nil {
+				return fmt.Errorf("failed to grant %s to role %s: %w", permission, role.name, err)
+			}
+		}
+	}
 	return nil
 }
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// rebuildTableIfOutdated recreates a table from its current definition,
+// keeping its rows, when the stored definition lacks marker. SQLite cannot
+// alter constraints, so this is how a CHECK gains new values.
+func rebuildTableIfOutdated(db *sql.DB, table, marker, definition string) error {
+	var stored string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if strings.Contains(stored, marker) {
+		return nil
+	}
+	return rebuildTable(db, table, definition)
+}
+
+// rewriteTableIfOutdated rebuilds a table whose stored definition contains
+// old with new in its place. Unlike rebuildTableIfOutdated it keeps the
+// columns added since the table was created, for tables that grew many.
+func rewriteTableIfOutdated(db *sql.DB, table, old, new string) error {
+	var stored string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if !strings.Contains(stored, old) {
+		return nil
+	}
+	return rebuildTable(db, table, strings.Replace(stored, old, new, 1))
+}
+
+// rebuildTable recreates a table from a definition with the same columns,
+// copying its rows
+func rebuildTable(db *sql.DB, table, definition string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	rebuilt := table + "_rebuilt"
+	create := strings.Replace(definition, "CREATE TABLE IF NOT EXISTS "+table+" ", "CREATE TABLE "+table+" ", 1)
+	create = strings.Replace(create, "CREATE TABLE "+table+" ", "CREATE TABLE "+rebuilt+" ", 1)
+	for _, statement := range []string{
+		create,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", rebuilt, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuilt, table),
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to rebuild table %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"database/sql"
+	"path/filepath"
 	"testing"
 )
 
@@ -29,7 +31,7 @@ func TestInit(t *testing.T) {
 }
 
 func TestDatabaseConnection(t *testing.T) {
-	db, err := Init()
+	db, err := Open(filepath.Join(t.TempDir(), "fethur.db"))
 	if err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -63,3 +65,67 @@ func TestDatabaseConnection(t *testing.T) {
 		t.Errorf("Expected result 1, got %d", result)
 	}
 }
+
+func TestRebuildTableIfOutdated(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE TABLE hooks (id INTEGER PRIMARY KEY, event TEXT NOT NULL CHECK (event IN ('a')))"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO hooks (event) VALUES ('a')"); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+	definition := "CREATE TABLE IF NOT EXISTS hooks (id INTEGER PRIMARY KEY, event TEXT NOT NULL CHECK (event IN ('a', 'b')))"
+	for i := 0; i < 2; i++ {
+		if err := rebuildTableIfOutdated(db, "hooks", "'b'", definition); err != nil {
+			t.Fatalf("Failed to rebuild table: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO hooks (event) VALUES ('b')"); err != nil {
+		t.Errorf("Expected the new constraint, got %v", err)
+	}
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM hooks").Scan(&rows); err != nil || rows != 2 {
+		t.Errorf("Expected the existing row to be kept, got %d", rows)
+	}
+}
+
+func TestRewriteTableIfOutdated(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS people (id INTEGER PRIMARY KEY, role TEXT CHECK (role IN ('a')))",
+		"ALTER TABLE people ADD COLUMN added TEXT",
+		"INSERT INTO people (role, added) VALUES ('a', 'kept')",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to set up table: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := rewriteTableIfOutdated(db, "people", "CHECK (role IN ('a'))", "CHECK (role IN ('a', 'b'))"); err != nil {
+			t.Fatalf("Failed to rewrite table: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO people (role, added) VALUES ('b', 'new')"); err != nil {
+		t.Errorf("Expected the new constraint, got %v", err)
+	}
+	var added string
+	if err := db.QueryRow("SELECT added FROM people WHERE role = 'a'").Scan(&added); err != nil || added != "kept" {
+		t.Errorf("Expected the added column to be kept, got %q (%v)", added, err)
+	}
+}
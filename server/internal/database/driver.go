@@ -0,0 +1,16 @@
+//go:build !chaos
+
+package database
+
+import (
+	"database/sql"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// driverName is the SQL driver the database is opened with
+const driverName = "sqlite3_utc"
+
+func init() {
+	sql.Register(driverName, utcDriver{&sqlite3.SQLiteDriver{}})
+}
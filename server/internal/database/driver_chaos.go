@@ -0,0 +1,90 @@
+//go:build chaos
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"fethur/internal/chaos"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// driverName is the SQL driver the database is opened with. Fault injection
+// builds wrap SQLite so queries can be slowed down.
+const driverName = "sqlite3_chaos"
+
+func init() {
+	sql.Register(driverName, chaosDriver{utcDriver{&sqlite3.SQLiteDriver{}}})
+}
+
+// chaosDriver opens SQLite connections that add injected latency
+type chaosDriver struct {
+	driver.Driver
+}
+
+func (d chaosDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return chaosConn{conn}, nil
+}
+
+// chaosConn delays each statement before handing it to SQLite
+type chaosConn struct {
+	driver.Conn
+}
+
+// Unwrap returns the wrapped connection, for the standby's online backup
+func (c chaosConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+// CheckNamedValue keeps storing times in UTC
+func (c chaosConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c chaosConn) Prepare(query string) (driver.Stmt, error) {
+	chaos.DBLatency()
+	return c.Conn.Prepare(query)
+}
+
+func (c chaosConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	chaos.DBLatency()
+	if prepare, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return prepare.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c chaosConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	chaos.DBLatency()
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c chaosConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	chaos.DBLatency()
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c chaosConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if begin, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return begin.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck // fallback for drivers without BeginTx
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupDir is where maintenance keeps backups of databases that passed
+// a full integrity check
+const BackupDir = DataDir + "/backups"
+
+// maxIntegrityProblems bounds the problems an integrity check reports
+const maxIntegrityProblems = 20
+
+// backupLayout names backups so they sort by age
+const backupLayout = "20060102-150405"
+
+// IntegrityResult is the outcome of an integrity check
+type IntegrityResult struct {
+	Full       bool      `json:"full"` // integrity_check rather than quick_check
+	OK         bool      `json:"ok"`
+	Problems   []string  `json:"problems,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// CheckIntegrity runs PRAGMA quick_check, or the slower integrity_check
+// that also verifies indexes against their tables when full is set
+func (db *Database) CheckIntegrity(ctx context.Context, full bool) (IntegrityResult, error) {
+	result := IntegrityResult{Full: full, CheckedAt: time.Now()}
+	pragma := "quick_check"
+	if full {
+		pragma = "integrity_check"
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA %s(%d)", pragma, maxIntegrityProblems))
+	if err != nil {
+		return result, fmt.Errorf("failed to run %s: %w", pragma, err)
+	}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			_ = rows.Close()
+			return result, err
+		}
+		if line != "ok" {
+			result.Problems = append(result.Problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return result, fmt.Errorf("failed to run %s: %w", pragma, err)
+	}
+	if err := rows.Close(); err != nil {
+		return result, err
+	}
+	result.OK = len(result.Problems) == 0
+	result.DurationMS = time.Since(result.CheckedAt).Milliseconds()
+	return result, nil
+}
+
+// BackupInfo describes a backup file
+type BackupInfo struct {
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+// Backup writes a consistent copy of the database into dir and returns it
+func (db *Database) Backup(ctx context.Context, dir string) (BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	now := time.Now()
+	path := filepath.Join(dir, "fethur-"+now.UTC().Format(backupLayout)+".db")
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to back up the database: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return BackupInfo{Path: path, CreatedAt: now, SizeBytes: info.Size()}, nil
+}
+
+// Backups lists the backups in dir, newest first
+func Backups(dir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "fethur-") || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		created, err := time.Parse(backupLayout, strings.TrimSuffix(strings.TrimPrefix(name, "fethur-"), ".db"))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Path: filepath.Join(dir, name), CreatedAt: created, SizeBytes: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest keep backups in dir
+func pruneBackups(dir string, keep int) error {
+	backups, err := Backups(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoveryReport describes a database rebuilt by Recover
+type RecoveryReport struct {
+	Backup        BackupInfo       // the backup the database was rebuilt from
+	DamagedPath   string           // where the damaged database was moved
+	RowsRecovered map[string]int64 // rows newer than the backup, by table
+	TablesSkipped map[string]string
+	Integrity     IntegrityResult
+}
+
+// Recover rebuilds the database at path from a backup, by default the
+// newest in BackupDir. The damaged database and its write-ahead log are
+// moved aside first. Opening them replays the log, and every row that is
+// still readable and missing from the backup is copied over. Rows that
+// changed after the backup keep their backed-up contents. The server must
+// not be running.
+func Recover(path, backupPath string) (RecoveryReport, error) {
+	report := RecoveryReport{RowsRecovered: make(map[string]int64), TablesSkipped: make(map[string]string)}
+	if backupPath == "" {
+		backups, err := Backups(BackupDir)
+		if err != nil {
+			return report, err
+		}
+		if len(backups) == 0 {
+			return report, fmt.Errorf("no backups in %s", BackupDir)
+		}
+		report.Backup = backups[0]
+	} else {
+		info, err := os.Stat(backupPath)
+		if err != nil {
+			return report, err
+		}
+		report.Backup = BackupInfo{Path: backupPath, CreatedAt: info.ModTime(), SizeBytes: info.Size()}
+	}
+
+	// Move the damaged database aside with its log, so they stay a pair
+	report.DamagedPath = fmt.Sprintf("%s.damaged-%s", path, time.Now().UTC().Format(backupLayout))
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(path+suffix, report.DamagedPath+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, fmt.Errorf("failed to move the damaged database aside: %w", err)
+		}
+	}
+	if err := copyFile(report.Backup.Path, path); err != nil {
+		return report, fmt.Errorf("failed to restore the backup: %w", err)
+	}
+
+	db, err := sql.Open(driverName, path+"?_journal_mode=WAL&_busy_timeout=5000&_loc=UTC")
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	if _, err := os.Stat(report.DamagedPath); err == nil {
+		if err := salvageRows(db, report.DamagedPath, &report); err != nil {
+			return report, err
+		}
+	}
+
+	restored := &Database{DB: db, replicas: &replicaSet{}}
+	report.Integrity, err = restored.CheckIntegrity(context.Background(), true)
+	return report, err
+}
+
+// salvageRows copies the readable rows of the damaged database that the
+// restored one lacks, table by table
+func salvageRows(db *sql.DB, damagedPath string, report *RecoveryReport) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS damaged", damagedPath); err != nil {
+		return fmt.Errorf("failed to open the damaged database: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, "DETACH DATABASE damaged")
+	}()
+
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM main.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		result, err := conn.ExecContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO main.%q SELECT * FROM damaged.%q", table, table))
+		if err != nil {
+			report.TablesSkipped[table] = err.Error()
+			continue
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			report.RowsRecovered[table] = count
+		}
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+	target, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		_ = target.Close()
+		return err
+	}
+	return target.Close()
+}
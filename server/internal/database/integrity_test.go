@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceChecksIntegrityAndKeepsBackups(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Logf("Error closing database: %v", err)
+		}
+	}()
+	ctx := context.Background()
+
+	if result, err := db.CheckIntegrity(ctx, false); err != nil || !result.OK {
+		t.Fatalf("Expected a healthy database, got %+v (%v)", result, err)
+	}
+
+	config := DefaultMaintenanceConfig()
+	config.BackupDir = t.TempDir()
+	config.BackupKeep = 1
+	maintainer := NewMaintainer(db, config)
+	for i := 0; i < 2; i++ {
+		run, err := maintainer.Run(ctx, "test", 0)
+		if err != nil {
+			t.Fatalf("Maintenance failed: %v", err)
+		}
+		if run.Backup == "" {
+			t.Fatal("Expected a backup after a passing integrity check")
+		}
+		// Backups are named by the second
+		time.Sleep(time.Second)
+	}
+	backups, err := Backups(config.BackupDir)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Expected old backups to be pruned, got %d (%v)", len(backups), err)
+	}
+	status := maintainer.Status()
+	if status.Integrity == nil || !status.Integrity.OK || !status.Integrity.Full {
+		t.Errorf("Expected the full check in the status, got %+v", status.Integrity)
+	}
+	if status.LastBackup == nil || status.LastBackup.Path != backups[0].Path {
+		t.Errorf("Expected the newest backup in the status, got %+v", status.LastBackup)
+	}
+}
+
+func TestRecoverFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chat.db")
+	raw, err := sql.Open(driverName, path+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db := &Database{DB: raw, replicas: &replicaSet{}}
+	ctx := context.Background()
+	if _, err := db.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO notes (id, body) VALUES (1, 'old'), (2, 'kept')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	backup, err := db.Backup(ctx, filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// A row added after the backup is recovered; a changed row is not
+	if _, err := db.Exec("INSERT INTO notes (id, body) VALUES (3, 'new')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if _, err := db.Exec("UPDATE notes SET body = 'changed' WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	report, err := Recover(path, backup.Path)
+	if err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+	if _, err := os.Stat(report.DamagedPath); err != nil {
+		t.Errorf("Expected the damaged database to be kept aside: %v", err)
+	}
+	if report.RowsRecovered["notes"] != 1 || !report.Integrity.OK {
+		t.Errorf("Expected the new row to be recovered, got %+v", report)
+	}
+
+	restored, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatalf("Failed to open the rebuilt database: %v", err)
+	}
+	defer func() {
+		_ = restored.Close()
+	}()
+	bodies := make(map[int]string)
+	rows, err := restored.Query("SELECT id, body FROM notes")
+	if err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	for rows.Next() {
+		var id int
+		var body string
+		if err := rows.Scan(&id, &body); err == nil {
+			bodies[id] = body
+		}
+	}
+	_ = rows.Close()
+	if len(bodies) != 3 || bodies[1] != "old" || bodies[3] != "new" {
+		t.Errorf("Expected the backup plus the new row, got %v", bodies)
+	}
+
+	if _, err := Recover(filepath.Join(dir, "missing.db"), filepath.Join(dir, "none.db")); err == nil {
+		t.Error("Expected recovery without a backup to fail")
+	}
+}
@@ -0,0 +1,449 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats describes the on-disk state of the database
+type Stats struct {
+	PageSize      int64   `json:"page_size"`
+	PageCount     int64   `json:"page_count"`
+	FreelistCount int64   `json:"freelist_count"`
+	SizeBytes     int64   `json:"size_bytes"`
+	FreeBytes     int64   `json:"free_bytes"`
+	Fragmentation float64 `json:"fragmentation"` // share of pages on the freelist
+	AutoVacuum    string  `json:"auto_vacuum"`
+	JournalMode   string  `json:"journal_mode"`
+}
+
+var autoVacuumModes = map[int]string{0: "none", 1: "full", 2: "incremental"}
+
+// Stats reads page and freelist counts
+func (db *Database) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	var autoVacuum int
+	pragmas := []struct {
+		name string
+		dest interface{}
+	}{
+		{"page_size", &stats.PageSize},
+		{"page_count", &stats.PageCount},
+		{"freelist_count", &stats.FreelistCount},
+		{"auto_vacuum", &autoVacuum},
+		{"journal_mode", &stats.JournalMode},
+	}
+	for _, pragma := range pragmas {
+		if err := db.QueryRowContext(ctx, "PRAGMA "+pragma.name).Scan(pragma.dest); err != nil {
+			return Stats{}, fmt.Errorf("failed to read %s: %w", pragma.name, err)
+		}
+	}
+
+	stats.SizeBytes = stats.PageSize * stats.PageCount
+	stats.FreeBytes = stats.PageSize * stats.FreelistCount
+	if stats.PageCount > 0 {
+		stats.Fragmentation = float64(stats.FreelistCount) / float64(stats.PageCount)
+	}
+	stats.AutoVacuum = autoVacuumModes[autoVacuum]
+	return stats, nil
+}
+
+// Checkpoint copies the write-ahead log into the database. With truncate
+// the WAL file is also reset to zero bytes. It returns the number of
+// frames in the log and how many were checkpointed.
+func (db *Database) Checkpoint(ctx context.Context, truncate bool) (int, int, error) {
+	mode := "PASSIVE"
+	if truncate {
+		mode = "TRUNCATE"
+	}
+	var busy, logFrames, checkpointed int
+	err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+mode+")").Scan(&busy, &logFrames, &checkpointed)
+	return logFrames, checkpointed, err
+}
+
+// MaintenanceConfig controls when maintenance runs
+type MaintenanceConfig struct {
+	Interval               time.Duration // run at least this often
+	CheckInterval          time.Duration // how often thresholds are checked
+	GrowthThreshold        float64       // run early when the file grew by this share
+	FragmentationThreshold float64       // run early when this share of pages is free
+	VacuumBatchPages       int           // pages released per incremental vacuum step
+	IntegrityInterval      time.Duration // how often quick_check runs between passes
+	BackupDir              string        // where passes that check out keep backups
+	BackupKeep             int           // backups kept; 0 disables them
+}
+
+// DefaultMaintenanceConfig returns the default maintenance schedule
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		Interval:               24 * time.Hour,
+		CheckInterval:          5 * time.Minute,
+		GrowthThreshold:        0.25,
+		FragmentationThreshold: 0.10,
+		VacuumBatchPages:       500,
+		IntegrityInterval:      time.Hour,
+		BackupDir:              BackupDir,
+		BackupKeep:             3,
+	}
+}
+
+// MaintenanceRun is the outcome of one maintenance pass
+type MaintenanceRun struct {
+	Reason      string    `json:"reason"`
+	TriggeredBy int       `json:"triggered_by,omitempty"` // admin user ID for manual runs
+	Phase       string    `json:"phase"`
+	Progress    float64   `json:"progress"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	Before      Stats     `json:"before"`
+	After       Stats     `json:"after"`
+	PagesFreed  int64     `json:"pages_freed"`
+	WALFrames   int       `json:"wal_frames"`
+	FullVacuum  bool      `json:"full_vacuum"`
+	Backup      string    `json:"backup,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// MaintenanceStatus reports the scheduler state for health checks
+type MaintenanceStatus struct {
+	Running bool            `json:"running"`
+	Current *MaintenanceRun `json:"current,omitempty"`
+	LastRun *MaintenanceRun `json:"last_run,omitempty"`
+	NextDue time.Time       `json:"next_due"`
+
+	// Integrity is the latest integrity check, quick or full
+	Integrity  *IntegrityResult `json:"integrity,omitempty"`
+	LastBackup *BackupInfo      `json:"last_backup,omitempty"`
+}
+
+// Maintainer runs integrity checks, ANALYZE, incremental vacuum, WAL
+// checkpoints and backups on a schedule or when size and fragmentation
+// thresholds are crossed
+type Maintainer struct {
+	db     *Database
+	config MaintenanceConfig
+
+	mutex        sync.Mutex
+	current      *MaintenanceRun
+	last         *MaintenanceRun
+	lastRunSize  int64
+	nextDue      time.Time
+	integrity    *IntegrityResult
+	lastBackup   *BackupInfo
+	onCorruption func(IntegrityResult)
+}
+
+// NewMaintainer creates a maintainer; call Start to schedule it
+func NewMaintainer(db *Database, config MaintenanceConfig) *Maintainer {
+	m := &Maintainer{
+		db:      db,
+		config:  config,
+		nextDue: time.Now().Add(config.Interval),
+	}
+	if backups, err := Backups(config.BackupDir); err == nil && len(backups) > 0 {
+		m.lastBackup = &backups[0]
+	}
+	return m
+}
+
+// SetCorruptionHandler installs a function called when an integrity check
+// finds problems after the previous one found none
+func (m *Maintainer) SetCorruptionHandler(handler func(IntegrityResult)) {
+	m.mutex.Lock()
+	m.onCorruption = handler
+	m.mutex.Unlock()
+}
+
+// Start checks thresholds periodically until the context is cancelled
+func (m *Maintainer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.config.CheckInterval)
+		defer ticker.Stop()
+		lastCheck := time.Now()
+		if m.config.IntegrityInterval > 0 {
+			if _, err := m.CheckIntegrity(ctx, false); err != nil {
+				log.Printf("Database integrity check failed to run: %v", err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if m.config.IntegrityInterval > 0 && time.Since(lastCheck) >= m.config.IntegrityInterval {
+					lastCheck = time.Now()
+					if _, err := m.CheckIntegrity(ctx, false); err != nil {
+						log.Printf("Database integrity check failed to run: %v", err)
+					}
+				}
+				if reason := m.due(ctx); reason != "" {
+					if _, err := m.Run(ctx, reason, 0); err != nil {
+						log.Printf("Database maintenance failed: %v", err)
+					}
+				} else if _, _, err := m.db.Checkpoint(ctx, false); err != nil {
+					log.Printf("WAL checkpoint failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// due returns why maintenance should run now, or an empty string
+func (m *Maintainer) due(ctx context.Context) string {
+	m.mutex.Lock()
+	nextDue, baseline := m.nextDue, m.lastRunSize
+	m.mutex.Unlock()
+
+	if time.Now().After(nextDue) {
+		return "schedule"
+	}
+
+	stats, err := m.db.Stats(ctx)
+	if err != nil {
+		log.Printf("Failed to read database stats: %v", err)
+		return ""
+	}
+	if stats.Fragmentation >= m.config.FragmentationThreshold {
+		return fmt.Sprintf("fragmentation %.0f%%", stats.Fragmentation*100)
+	}
+	if baseline > 0 && float64(stats.SizeBytes-baseline)/float64(baseline) >= m.config.GrowthThreshold {
+		return fmt.Sprintf("size grew from %d to %d bytes", baseline, stats.SizeBytes)
+	}
+	if baseline == 0 {
+		m.mutex.Lock()
+		m.lastRunSize = stats.SizeBytes
+		m.mutex.Unlock()
+	}
+	return ""
+}
+
+// ErrMaintenanceRunning is returned when a pass is already in progress
+var ErrMaintenanceRunning = fmt.Errorf("database maintenance is already running")
+
+// Run performs a maintenance pass now. triggeredBy records the admin who
+// started a manual run, 0 for scheduled runs.
+func (m *Maintainer) Run(ctx context.Context, reason string, triggeredBy int) (*MaintenanceRun, error) {
+	m.mutex.Lock()
+	if m.current != nil {
+		m.mutex.Unlock()
+		return nil, ErrMaintenanceRunning
+	}
+	run := &MaintenanceRun{Reason: reason, StartedAt: time.Now(), Phase: "starting", TriggeredBy: triggeredBy}
+	m.current = run
+	m.mutex.Unlock()
+
+	log.Printf("Database maintenance started (%s)", reason)
+	err := m.run(ctx, run)
+
+	m.mutex.Lock()
+	run.FinishedAt = time.Now()
+	run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	run.Phase = "done"
+	if err != nil {
+		run.Error = err.Error()
+		run.Phase = "failed"
+	}
+	m.current = nil
+	m.last = run
+	m.lastRunSize = run.After.SizeBytes
+	m.nextDue = time.Now().Add(m.config.Interval)
+	m.mutex.Unlock()
+
+	if err == nil {
+		log.Printf("Database maintenance finished in %dms, freed %d pages", run.DurationMS, run.PagesFreed)
+	}
+	return run, err
+}
+
+func (m *Maintainer) setPhase(run *MaintenanceRun, phase string, progress float64) {
+	m.mutex.Lock()
+	run.Phase = phase
+	run.Progress = progress
+	m.mutex.Unlock()
+}
+
+// CheckIntegrity runs an integrity check and records its result
+func (m *Maintainer) CheckIntegrity(ctx context.Context, full bool) (IntegrityResult, error) {
+	result, err := m.db.CheckIntegrity(ctx, full)
+	if err != nil {
+		return result, err
+	}
+
+	m.mutex.Lock()
+	wasOK := m.integrity == nil || m.integrity.OK
+	m.integrity = &result
+	handler := m.onCorruption
+	m.mutex.Unlock()
+
+	if !result.OK {
+		log.Printf("Database integrity check found %d problems: %s", len(result.Problems), strings.Join(result.Problems, "; "))
+		if wasOK && handler != nil {
+			handler(result)
+		}
+	}
+	return result, nil
+}
+
+func (m *Maintainer) run(ctx context.Context, run *MaintenanceRun) error {
+	before, err := m.db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	run.Before = before
+
+	// A damaged database is left alone: vacuuming could spread the damage
+	// and backing it up would replace a good backup
+	m.setPhase(run, "integrity", 0.02)
+	integrity, err := m.CheckIntegrity(ctx, true)
+	if err != nil {
+		return err
+	}
+	if !integrity.OK {
+		return fmt.Errorf("integrity check found %d problems", len(integrity.Problems))
+	}
+
+	m.setPhase(run, "checkpoint", 0.05)
+	if before.JournalMode == "wal" {
+		frames, _, err := m.db.Checkpoint(ctx, true)
+		if err != nil {
+			return fmt.Errorf("checkpoint failed: %w", err)
+		}
+		run.WALFrames = frames
+	}
+
+	m.setPhase(run, "analyze", 0.15)
+	if _, err := m.db.ExecContext(ctx, "ANALYZE"); err != nil {
+		return fmt.Errorf("analyze failed: %w", err)
+	}
+
+	m.setPhase(run, "vacuum", 0.30)
+	if before.AutoVacuum == "incremental" {
+		if err := m.incrementalVacuum(ctx, run, before.FreelistCount); err != nil {
+			return err
+		}
+	} else if before.Fragmentation >= m.config.FragmentationThreshold {
+		// Switching to incremental mode needs one full VACUUM; after that
+		// free pages can be released in small steps
+		run.FullVacuum = true
+		if err := m.convertToIncremental(ctx); err != nil {
+			return err
+		}
+	}
+
+	after, err := m.db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	run.After = after
+	if freed := before.PageCount - after.PageCount; freed > 0 {
+		run.PagesFreed = freed
+	}
+
+	if m.config.BackupKeep > 0 {
+		m.setPhase(run, "backup", 0.95)
+		backup, err := m.db.Backup(ctx, m.config.BackupDir)
+		if err != nil {
+			return err
+		}
+		run.Backup = backup.Path
+		m.mutex.Lock()
+		m.lastBackup = &backup
+		m.mutex.Unlock()
+		if err := pruneBackups(m.config.BackupDir, m.config.BackupKeep); err != nil {
+			log.Printf("Failed to prune old backups: %v", err)
+		}
+	}
+	m.setPhase(run, "done", 1)
+	return nil
+}
+
+// convertToIncremental switches the database to incremental auto-vacuum.
+// The pragma only takes effect through a VACUUM on the same connection.
+func (m *Maintainer) convertToIncremental(ctx context.Context) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing maintenance connection: %v", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("failed to enable incremental vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("vacuum failed: %w", err)
+	}
+	return nil
+}
+
+// incrementalVacuum releases free pages in batches so writers are never
+// blocked for long, updating progress between batches
+func (m *Maintainer) incrementalVacuum(ctx context.Context, run *MaintenanceRun, freePages int64) error {
+	if freePages == 0 {
+		return nil
+	}
+	batch := m.config.VacuumBatchPages
+	for released := int64(0); released < freePages; released += int64(batch) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.vacuumStep(ctx, batch); err != nil {
+			return fmt.Errorf("incremental vacuum failed: %w", err)
+		}
+		done := float64(released+int64(batch)) / float64(freePages)
+		if done > 1 {
+			done = 1
+		}
+		m.setPhase(run, "vacuum", 0.30+0.60*done)
+	}
+	return nil
+}
+
+// vacuumStep releases up to pages free pages. SQLite frees one page per
+// statement step, so the result rows must be drained; Exec stops after one.
+func (m *Maintainer) vacuumStep(ctx context.Context, pages int) error {
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", pages))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+// Status returns a snapshot of the scheduler state
+func (m *Maintainer) Status() MaintenanceStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := MaintenanceStatus{Running: m.current != nil, NextDue: m.nextDue}
+	if m.current != nil {
+		current := *m.current
+		status.Current = &current
+	}
+	if m.last != nil {
+		last := *m.last
+		status.LastRun = &last
+	}
+	if m.integrity != nil {
+		integrity := *m.integrity
+		status.Integrity = &integrity
+	}
+	if m.lastBackup != nil {
+		backup := *m.lastBackup
+		status.LastBackup = &backup
+	}
+	return status
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceReleasesFreePages(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Logf("Error closing database: %v", err)
+		}
+	}()
+	ctx := context.Background()
+
+	// Grow the file, then free the pages again
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS maintenance_scratch (data TEXT)"); err != nil {
+		t.Fatalf("Failed to create scratch table: %v", err)
+	}
+	padding := strings.Repeat("x", 4000)
+	for i := 0; i < 200; i++ {
+		if _, err := db.Exec("INSERT INTO maintenance_scratch (data) VALUES (?)", padding); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if _, err := db.Exec("DROP TABLE maintenance_scratch"); err != nil {
+		t.Fatalf("Failed to drop scratch table: %v", err)
+	}
+
+	before, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read stats: %v", err)
+	}
+	if before.FreelistCount == 0 {
+		t.Fatal("Expected free pages after dropping the table")
+	}
+
+	config := DefaultMaintenanceConfig()
+	config.VacuumBatchPages = 50
+	maintainer := NewMaintainer(db, config)
+	if reason := maintainer.due(ctx); reason == "" {
+		t.Error("Expected fragmentation to make maintenance due")
+	}
+
+	run, err := maintainer.Run(ctx, "test", 1)
+	if err != nil {
+		t.Fatalf("Maintenance failed: %v", err)
+	}
+	if run.After.FreelistCount != 0 {
+		t.Errorf("Expected free pages to be released, %d left", run.After.FreelistCount)
+	}
+	if run.PagesFreed <= 0 || run.Progress != 1 {
+		t.Errorf("Unexpected run result: freed %d pages, progress %v", run.PagesFreed, run.Progress)
+	}
+
+	status := maintainer.Status()
+	if status.Running || status.LastRun == nil || status.LastRun.Reason != "test" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaStatus reports the health of a read replica
+type ReplicaStatus struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LatencyMS int64     `json:"latency_ms"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type replica struct {
+	db *sql.DB
+
+	mutex  sync.RWMutex
+	status ReplicaStatus
+}
+
+// replicaSet routes reads across healthy replicas in turn
+type replicaSet struct {
+	mutex    sync.RWMutex
+	replicas []*replica
+	next     uint32
+}
+
+// AddReplica opens a read-only connection pool for a replica, such as a
+// LiteFS or Litestream copy of the database file. Replicas start unhealthy
+// and take reads once a health check passes.
+func (db *Database) AddReplica(name, dsn string) error {
+	// Replicas are read-only and read times in UTC like the primary
+	for _, param := range []string{"mode=ro", "_loc=UTC"} {
+		if key, _, _ := strings.Cut(param, "="); strings.Contains(dsn, key+"=") {
+			continue
+		}
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + param
+	}
+
+	conn, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open replica %s: %w", name, err)
+	}
+
+	db.replicas.mutex.Lock()
+	db.replicas.replicas = append(db.replicas.replicas, &replica{
+		db:     conn,
+		status: ReplicaStatus{Name: name},
+	})
+	db.replicas.mutex.Unlock()
+	return nil
+}
+
+// Reader returns the pool to run a read-only query on. It picks a healthy
+// replica, or the primary when none is healthy or the caller needs to see
+// its own recent writes.
+func (db *Database) Reader(requirePrimary bool) *sql.DB {
+	if requirePrimary || db.replicas == nil {
+		return db.DB
+	}
+
+	db.replicas.mutex.RLock()
+	defer db.replicas.mutex.RUnlock()
+
+	count := len(db.replicas.replicas)
+	start := atomic.AddUint32(&db.replicas.next, 1)
+	for i := 0; i < count; i++ {
+		candidate := db.replicas.replicas[(int(start)+i)%count]
+		candidate.mutex.RLock()
+		healthy := candidate.status.Healthy
+		candidate.mutex.RUnlock()
+		if healthy {
+			return candidate.db
+		}
+	}
+	return db.DB
+}
+
+// CheckReplicas probes every replica and updates its health
+func (db *Database) CheckReplicas(ctx context.Context) {
+	db.replicas.mutex.RLock()
+	replicas := append([]*replica(nil), db.replicas.replicas...)
+	db.replicas.mutex.RUnlock()
+
+	for _, r := range replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		// Reading a real table catches replicas that are reachable but empty
+		var count int
+		err := r.db.QueryRowContext(checkCtx, "SELECT COUNT(*) FROM settings").Scan(&count)
+		cancel()
+
+		r.mutex.Lock()
+		wasHealthy := r.status.Healthy
+		r.status.LastCheck = time.Now()
+		r.status.LatencyMS = time.Since(start).Milliseconds()
+		r.status.Healthy = err == nil
+		r.status.LastError = ""
+		if err != nil {
+			r.status.LastError = err.Error()
+		}
+		name := r.status.Name
+		r.mutex.Unlock()
+
+		if wasHealthy && err != nil {
+			log.Printf("Read replica %s is unhealthy, routing reads to the primary: %v", name, err)
+		} else if !wasHealthy && err == nil {
+			log.Printf("Read replica %s is healthy", name)
+		}
+	}
+}
+
+// StartReplicaHealthChecks checks replicas now and then on an interval
+// until the context is cancelled
+func (db *Database) StartReplicaHealthChecks(ctx context.Context, interval time.Duration) {
+	db.CheckReplicas(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				db.CheckReplicas(ctx)
+			}
+		}
+	}()
+}
+
+// ReplicaStatuses returns the health of every replica
+func (db *Database) ReplicaStatuses() []ReplicaStatus {
+	db.replicas.mutex.RLock()
+	defer db.replicas.mutex.RUnlock()
+
+	statuses := make([]ReplicaStatus, 0, len(db.replicas.replicas))
+	for _, r := range db.replicas.replicas {
+		r.mutex.RLock()
+		statuses = append(statuses, r.status)
+		r.mutex.RUnlock()
+	}
+	return statuses
+}
+
+func (db *Database) closeReplicas() {
+	db.replicas.mutex.Lock()
+	defer db.replicas.mutex.Unlock()
+	for _, r := range db.replicas.replicas {
+		if err := r.db.Close(); err != nil {
+			log.Printf("Error closing replica %s: %v", r.status.Name, err)
+		}
+	}
+	db.replicas.replicas = nil
+}
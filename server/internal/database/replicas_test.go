@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestReaderRoutesToHealthyReplicas(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Logf("Error closing database: %v", err)
+		}
+	}()
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.db")
+	seed, err := sql.Open("sqlite3", good)
+	if err != nil {
+		t.Fatalf("Failed to create replica: %v", err)
+	}
+	if _, err := seed.Exec("CREATE TABLE settings (key TEXT, value TEXT)"); err != nil {
+		t.Fatalf("Failed to seed replica: %v", err)
+	}
+	if err := seed.Close(); err != nil {
+		t.Fatalf("Failed to close replica: %v", err)
+	}
+
+	if err := db.AddReplica("good", good); err != nil {
+		t.Fatalf("Failed to add replica: %v", err)
+	}
+	// An empty file has no schema and must never take reads
+	if err := db.AddReplica("empty", filepath.Join(dir, "empty.db")+"?mode=rwc"); err != nil {
+		t.Fatalf("Failed to add replica: %v", err)
+	}
+
+	if db.Reader(false) != db.DB {
+		t.Error("Expected reads on the primary before replicas are checked")
+	}
+
+	db.CheckReplicas(context.Background())
+	statuses := db.ReplicaStatuses()
+	if len(statuses) != 2 || !statuses[0].Healthy || statuses[1].Healthy {
+		t.Fatalf("Unexpected replica health: %+v", statuses)
+	}
+
+	for i := 0; i < 4; i++ {
+		if reader := db.Reader(false); reader == db.DB {
+			t.Fatal("Expected reads to go to the healthy replica")
+		}
+	}
+	if db.Reader(true) != db.DB {
+		t.Error("Expected strong reads on the primary")
+	}
+}
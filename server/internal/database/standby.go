@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ErrNotStandby is returned when promoting a database that already was
+var ErrNotStandby = errors.New("not a standby")
+
+// PromotedPath marks a standby that has been promoted, so it stays a
+// primary after restarts even while a standby source is still configured
+const PromotedPath = DataDir + "/promoted"
+
+// Promoted reports whether this database was promoted from a standby
+func Promoted() bool {
+	_, err := os.Stat(PromotedPath)
+	return err == nil
+}
+
+// MarkPromoted records that this database is now a primary
+func MarkPromoted() error {
+	return os.WriteFile(PromotedPath, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0640)
+}
+
+// StandbyStatus is what a standby last did
+type StandbyStatus struct {
+	Source    string      `json:"source"`
+	Active    bool        `json:"active"` // false once promoted
+	Applied   *BackupInfo `json:"applied,omitempty"`
+	AppliedAt *time.Time  `json:"applied_at,omitempty"`
+	CheckedAt *time.Time  `json:"checked_at,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// Standby keeps a database a copy of a primary's by restoring each newer
+// backup the primary ships to source. Source is either a directory of
+// backups named as Backup names them, or a single database file that
+// another tool keeps up to date.
+type Standby struct {
+	db     *Database
+	source string
+	onSync func()
+
+	mutex     sync.Mutex
+	status    StandbyStatus
+	promoting bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewStandby follows the backups shipped to source
+func NewStandby(db *Database, source string) *Standby {
+	return &Standby{db: db, source: source, status: StandbyStatus{Source: source, Active: true}}
+}
+
+// SetSyncHandler sets a function called after each restored backup, for
+// reloading state cached from the database
+func (s *Standby) SetSyncHandler(handler func()) {
+	s.onSync = handler
+}
+
+// Active reports whether the database is still following the primary
+func (s *Standby) Active() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.status.Active
+}
+
+// Status returns what the standby last did
+func (s *Standby) Status() StandbyStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.status
+}
+
+// Start syncs now and then every interval until Promote or ctx ends
+func (s *Standby) Start(ctx context.Context, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mutex.Lock()
+	s.cancel, s.done = cancel, make(chan struct{})
+	s.mutex.Unlock()
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Standby sync from %s failed: %v", s.source, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// latest finds the newest backup in the source
+func (s *Standby) latest() (BackupInfo, error) {
+	info, err := os.Stat(s.source)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if !info.IsDir() {
+		return BackupInfo{Path: s.source, CreatedAt: info.ModTime(), SizeBytes: info.Size()}, nil
+	}
+	backups, err := Backups(s.source)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if len(backups) == 0 {
+		return BackupInfo{}, fmt.Errorf("no backups in %s", s.source)
+	}
+	return backups[0], nil
+}
+
+// Sync restores the newest backup in the source if it is newer than the
+// one last restored, and reports whether it did
+func (s *Standby) Sync(ctx context.Context) (bool, error) {
+	now := time.Now()
+	backup, err := s.latest()
+	if err == nil {
+		s.mutex.Lock()
+		applied := s.status.Applied
+		s.mutex.Unlock()
+		if applied != nil && applied.Path == backup.Path && !backup.CreatedAt.After(applied.CreatedAt) {
+			s.record(now, nil, nil)
+			return false, nil
+		}
+		err = s.restore(ctx, backup.Path)
+	}
+	if err != nil {
+		s.record(now, nil, err)
+		return false, err
+	}
+
+	s.record(now, &backup, nil)
+	if s.onSync != nil {
+		s.onSync()
+	}
+	return true, nil
+}
+
+func (s *Standby) record(checkedAt time.Time, applied *BackupInfo, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.CheckedAt = &checkedAt
+	s.status.Error = ""
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+	if applied != nil {
+		s.status.Applied, s.status.AppliedAt = applied, &checkedAt
+	}
+}
+
+// restore checks a backup and copies it page by page over the database
+// with SQLite's online backup, so open connections see the new contents
+func (s *Standby) restore(ctx context.Context, path string) error {
+	source, err := sql.Open(driverName, "file:"+path+"?mode=ro&_loc=UTC")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	// A backup caught mid-copy or from another release is not applied
+	shipped := &Database{DB: source, replicas: &replicaSet{}}
+	integrity, err := shipped.CheckIntegrity(ctx, false)
+	if err != nil {
+		return err
+	}
+	if !integrity.OK {
+		return fmt.Errorf("backup %s failed its integrity check: %v", path, integrity.Problems)
+	}
+	version, err := shipped.StoredSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("backup %s has schema version %d, this server needs %d", path, version, SchemaVersion)
+	}
+
+	from, err := source.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = from.Close()
+	}()
+	to, err := s.db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = to.Close()
+	}()
+
+	return to.Raw(func(toConn interface{}) error {
+		return from.Raw(func(fromConn interface{}) error {
+			dst, src := sqliteConn(toConn), sqliteConn(fromConn)
+			if dst == nil || src == nil {
+				return errors.New("standby needs SQLite connections")
+			}
+			backup, err := dst.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			done, err := backup.Step(-1)
+			if finishErr := backup.Finish(); err == nil {
+				err = finishErr
+			}
+			if err == nil && !done {
+				err = errors.New("backup did not finish")
+			}
+			return err
+		})
+	})
+}
+
+// sqliteConn finds the SQLite connection under the driver's wrappers
+func sqliteConn(conn interface{}) *sqlite3.SQLiteConn {
+	for {
+		switch c := conn.(type) {
+		case *sqlite3.SQLiteConn:
+			return c
+		case utcConn:
+			return c.SQLiteConn
+		case interface{ Unwrap() driver.Conn }:
+			conn = c.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Promote stops following the primary, after one last sync when the
+// source is still reachable, and marks the database a primary
+func (s *Standby) Promote(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.status.Active || s.promoting {
+		s.mutex.Unlock()
+		return ErrNotStandby
+	}
+	s.promoting = true
+	cancel, done := s.cancel, s.done
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.promoting = false
+		s.mutex.Unlock()
+	}()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	if _, err := s.Sync(ctx); err != nil {
+		log.Printf("Promoting without a final sync from %s: %v", s.source, err)
+	}
+	if err := MarkPromoted(); err != nil {
+		return fmt.Errorf("failed to mark the database promoted: %w", err)
+	}
+	s.mutex.Lock()
+	s.status.Active = false
+	s.mutex.Unlock()
+	return nil
+}
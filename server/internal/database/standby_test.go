@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStandbyFollowsShippedBackups(t *testing.T) {
+	dir := t.TempDir()
+	shipped := filepath.Join(dir, "shipped")
+	open := func(name string) *Database {
+		raw, err := sql.Open(driverName, filepath.Join(dir, name)+"?_journal_mode=WAL&_loc=UTC")
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", name, err)
+		}
+		if _, err := raw.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+			t.Fatalf("Failed to set the schema version: %v", err)
+		}
+		return &Database{DB: raw, replicas: &replicaSet{}}
+	}
+	primary, replica := open("primary.db"), open("standby.db")
+	defer func() {
+		_ = primary.Close()
+		_ = replica.Close()
+	}()
+	ctx := context.Background()
+
+	if _, err := primary.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := primary.Exec("INSERT INTO notes (body) VALUES ('first')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	synced := 0
+	standby := NewStandby(replica, shipped)
+	standby.SetSyncHandler(func() { synced++ })
+	if _, err := standby.Sync(ctx); err == nil || standby.Status().Error == "" {
+		t.Error("Expected a sync with nothing shipped to fail")
+	}
+
+	count := func() int {
+		var n int
+		if err := replica.QueryRow("SELECT COUNT(*) FROM notes").Scan(&n); err != nil {
+			t.Fatalf("Failed to count notes on the standby: %v", err)
+		}
+		return n
+	}
+	if _, err := primary.Backup(ctx, shipped); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if restored, err := standby.Sync(ctx); err != nil || !restored || count() != 1 {
+		t.Fatalf("Expected the backup to be restored, got %v (%v)", restored, err)
+	}
+	if restored, err := standby.Sync(ctx); err != nil || restored {
+		t.Errorf("Expected an unchanged source to be skipped, got %v (%v)", restored, err)
+	}
+
+	// Backups are named by the second
+	time.Sleep(time.Second)
+	if _, err := primary.Exec("INSERT INTO notes (body) VALUES ('second')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if _, err := primary.Backup(ctx, shipped); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if restored, err := standby.Sync(ctx); err != nil || !restored || count() != 2 || synced != 2 {
+		t.Fatalf("Expected the newer backup to be restored, got %v with %d syncs (%v)", restored, synced, err)
+	}
+
+	if err := os.MkdirAll(DataDir, 0750); err != nil {
+		t.Fatalf("Failed to create the data directory: %v", err)
+	}
+	defer func() {
+		_ = os.Remove(PromotedPath)
+	}()
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	if standby.Active() || !Promoted() {
+		t.Error("Expected the standby to be promoted")
+	}
+	if _, err := replica.Exec("INSERT INTO notes (body) VALUES ('local')"); err != nil {
+		t.Errorf("Expected the promoted database to take writes: %v", err)
+	}
+}
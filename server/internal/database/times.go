@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TimeLayout is how timestamps are stored: in UTC and in the form of
+// CURRENT_TIMESTAMP and datetime('now'), so stored and computed times
+// compare correctly as text. Fractions of a second are kept.
+const TimeLayout = "2006-01-02 15:04:05.999999999"
+
+// timestampsVersion is the schema version from which every stored
+// timestamp is in TimeLayout
+const timestampsVersion = 42
+
+// ParseTime reads a stored timestamp. Columns of type DATETIME are read as
+// times, but SQL expressions such as MAX(created_at) return text.
+func ParseTime(value string) (time.Time, error) {
+	return time.ParseInLocation(TimeLayout, value, time.UTC)
+}
+
+// utcDriver opens SQLite connections that store times in TimeLayout.
+// SQLite itself would keep the offset of the time it was given, so times
+// from the server's local clock would not compare with CURRENT_TIMESTAMP.
+type utcDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d utcDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// utcConn is a SQLite connection that converts time arguments to UTC
+type utcConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// CheckNamedValue converts arguments as database/sql would, then formats
+// times in TimeLayout
+func (c utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC().Format(TimeLayout)
+	}
+	nv.Value = value
+	return nil
+}
+
+// normalizeTimestamps rewrites the timestamps older versions stored with
+// an offset or in ISO 8601 form to UTC in TimeLayout
+func normalizeTimestamps(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	var normalized int64
+	for _, table := range tables {
+		columns, err := timestampColumns(db, table)
+		if err != nil {
+			return err
+		}
+		for _, column := range columns {
+			// datetime() understands offsets and converts to UTC; the
+			// fraction of a second survives where there is one
+			result, err := db.Exec(fmt.Sprintf(`
+				UPDATE %[1]q SET %[2]q = CASE WHEN %[2]q GLOB '*:[0-9][0-9].[0-9]*'
+					THEN strftime('%%Y-%%m-%%d %%H:%%M:%%f', %[2]q) ELSE datetime(%[2]q) END
+				WHERE typeof(%[2]q) = 'text' AND datetime(%[2]q) IS NOT NULL
+					AND (%[2]q GLOB '*T*' OR %[2]q GLOB '*Z' OR %[2]q GLOB '*[+-][0-9][0-9]:[0-9][0-9]')`,
+				table, column,
+			))
+			if err != nil {
+				return fmt.Errorf("failed to normalize %s.%s: %w", table, column, err)
+			}
+			count, _ := result.RowsAffected()
+			normalized += count
+		}
+	}
+	if normalized > 0 {
+		log.Printf("Converted %d stored timestamps to UTC", normalized)
+	}
+	return nil
+}
+
+// timestampColumns returns the DATETIME and TIMESTAMP columns of a table
+func timestampColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%q)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var columns []string
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if columnType := strings.ToUpper(columnType); columnType == "DATETIME" || columnType == "TIMESTAMP" {
+			columns = append(columns, name)
+		}
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimestampsAreStoredInUTC(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "times.db")+"?_loc=UTC")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	if _, err := db.Exec("CREATE TABLE stamps (id INTEGER PRIMARY KEY, at DATETIME)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	// Times in any zone are stored in UTC and compare with SQLite's clock
+	berlin := time.FixedZone("CEST", 2*60*60)
+	local := time.Date(2025, 7, 28, 22, 0, 0, 0, berlin)
+	if _, err := db.Exec("INSERT INTO stamps (id, at) VALUES (1, ?)", local); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	var raw string
+	var before bool
+	if err := db.QueryRow("SELECT CAST(at AS TEXT), at < datetime('2025-07-28 20:30:00') FROM stamps WHERE id = 1").Scan(&raw, &before); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if raw != "2025-07-28 20:00:00" || !before {
+		t.Errorf("Expected the time stored in UTC, got %q", raw)
+	}
+	var read time.Time
+	if err := db.QueryRow("SELECT at FROM stamps WHERE id = 1").Scan(&read); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !read.Equal(local) || read.Location() != time.UTC {
+		t.Errorf("Expected %v in UTC, got %v", local, read)
+	}
+
+	// Older rows with offsets or in ISO 8601 form are converted
+	for id, value := range map[int]interface{}{
+		2: "2025-07-28 22:00:00+02:00",
+		3: "2025-07-28T20:00:00Z",
+		4: "2025-07-28 22:00:00.5+02:00",
+		5: "2025-07-28 20:00:00",
+		6: 12345,
+	} {
+		if _, err := db.Exec("INSERT INTO stamps (id, at) VALUES (?, ?)", id, value); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if err := normalizeTimestamps(db); err != nil {
+		t.Fatalf("Failed to normalize timestamps: %v", err)
+	}
+	expected := map[int]string{
+		2: "2025-07-28 20:00:00",
+		3: "2025-07-28 20:00:00",
+		4: "2025-07-28 20:00:00.500",
+		5: "2025-07-28 20:00:00",
+		6: "12345",
+	}
+	for id, want := range expected {
+		if err := db.QueryRow("SELECT CAST(at AS TEXT) FROM stamps WHERE id = ?", id).Scan(&raw); err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if raw != want {
+			t.Errorf("Expected row %d to hold %q, got %q", id, want, raw)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Package doctor validates the environment and configuration before the
+// server starts, reporting actionable problems instead of runtime failures.
+package doctor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fethur/internal/auth"
+	"fethur/internal/chaos"
+	"fethur/internal/database"
+	"fethur/internal/storage"
+	"fethur/internal/update"
+	"fethur/internal/websocket"
+	"fethur/internal/wscompress"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of one check with a hint on how to fix it
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// Config is the configuration to validate
+type Config struct {
+	Port        string
+	CheckPort   bool // skip when the server is already listening
+	JWTSecret   string
+	JWTKeysFile string // generated signing keys, used without JWTSecret
+	CORSOrigins []string
+	// Reverse proxy deployment
+	TrustedProxies []string
+	BasePath       string
+	PublicURL      string
+	Compression    wscompress.Config
+	Compaction     string // FETHUR_WS_COMPACTION
+	// Release checks and self-update
+	UpdateURL       string
+	UpdatePublicKey string
+	TLSCertFile     string
+	TLSKeyFile      string
+	StorageDir      string            // local attachment storage
+	S3              *storage.S3Config // set when attachments are stored in S3
+}
+
+// Run performs every check
+func Run(config Config) []Result {
+	results := []Result{
+		checkDataDir(),
+	}
+	results = append(results, checkDatabase()...)
+	if config.CheckPort {
+		results = append(results, checkPort(config.Port))
+	}
+	results = append(results,
+		checkJWTSecret(config.JWTSecret, config.JWTKeysFile),
+		checkCORS(config.CORSOrigins),
+		checkTrustedProxies(config.TrustedProxies),
+		checkPublicURL(config.PublicURL, config.BasePath),
+		checkCompression(config.Compression),
+		checkCompaction(config.Compaction),
+		checkUpdates(config.UpdateURL, config.UpdatePublicKey),
+		checkTLS(config.TLSCertFile, config.TLSKeyFile),
+		checkStorage(config),
+		checkFaultInjection(),
+	)
+	return results
+}
+
+// Failed reports whether any check failed
+func Failed(results []Result) bool {
+	for _, result := range results {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes a human-readable report
+func Print(w io.Writer, results []Result) {
+	labels := map[Status]string{StatusOK: "[ OK ]", StatusWarn: "[WARN]", StatusFail: "[FAIL]"}
+	for _, result := range results {
+		fmt.Fprintf(w, "%s %-12s %s\n", labels[result.Status], result.Name, result.Message)
+		if result.Fix != "" && result.Status != StatusOK {
+			fmt.Fprintf(w, "       %-12s fix: %s\n", "", result.Fix)
+		}
+	}
+}
+
+func ok(name, message string) Result {
+	return Result{Name: name, Status: StatusOK, Message: message}
+}
+
+func warn(name, message, fix string) Result {
+	return Result{Name: name, Status: StatusWarn, Message: message, Fix: fix}
+}
+
+func fail(name, message, fix string) Result {
+	return Result{Name: name, Status: StatusFail, Message: message, Fix: fix}
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+func checkDataDir() Result {
+	dir, _ := filepath.Abs(database.DataDir)
+	if err := checkWritable(database.DataDir); err != nil {
+		return fail("data dir", fmt.Sprintf("%s is not writable: %v", dir, err),
+			"run fethur from a directory it can write to, or fix the permissions of "+dir)
+	}
+	return ok("data dir", dir+" is writable")
+}
+
+func checkDatabase() []Result {
+	if _, err := os.Stat(database.Path); os.IsNotExist(err) {
+		return []Result{ok("database", "no database yet; it will be created on first start")}
+	}
+
+	db, err := database.OpenExisting()
+	if err != nil {
+		return []Result{fail("database", fmt.Sprintf("cannot open %s: %v", database.Path, err),
+			"check the file permissions, or restore the database from a backup")}
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var integrity string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&integrity); err != nil || integrity != "ok" {
+		if err != nil {
+			integrity = err.Error()
+		}
+		return []Result{fail("database", "integrity check failed: "+integrity,
+			"restore the database from a backup")}
+	}
+
+	version, err := db.StoredSchemaVersion()
+	if err != nil {
+		return []Result{fail("database", fmt.Sprintf("cannot read schema version: %v", err), "")}
+	}
+
+	results := make([]Result, 0, 2)
+	switch {
+	case version > database.SchemaVersion:
+		results = append(results, fail("schema", fmt.Sprintf("database schema v%d is newer than this binary supports (v%d)", version, database.SchemaVersion),
+			"upgrade fethur, or restore a backup taken before the upgrade"))
+	case version < database.SchemaVersion:
+		results = append(results, warn("schema", fmt.Sprintf("database schema v%d will be migrated to v%d on start", version, database.SchemaVersion),
+			"back up "+database.Path+" before starting the server"))
+	default:
+		results = append(results, ok("schema", fmt.Sprintf("database schema is up to date (v%d)", version)))
+	}
+
+	missing, err := db.MissingTables()
+	switch {
+	case err != nil:
+		results = append(results, fail("tables", fmt.Sprintf("cannot list tables: %v", err), ""))
+	case len(missing) > 0 && version >= database.SchemaVersion:
+		// Marked current but incomplete: handlers using these tables would fail
+		results = append(results, fail("tables", "missing tables: "+strings.Join(missing, ", "),
+			"restore the database from a backup, or reset its user_version to 0 so the tables are recreated"))
+	case len(missing) > 0:
+		results = append(results, warn("tables", "tables to be created on start: "+strings.Join(missing, ", "), ""))
+	default:
+		results = append(results, ok("tables", "all tables present"))
+	}
+	return results
+}
+
+func checkPort(port string) Result {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fail("port", fmt.Sprintf("port %s is not available: %v", port, err),
+			"stop the process using it, or choose another port with PORT")
+	}
+	_ = listener.Close()
+	return ok("port", "port "+port+" is available")
+}
+
+func checkJWTSecret(secret, keysFile string) Result {
+	switch {
+	case secret == "":
+		data, err := os.ReadFile(keysFile) // #nosec G304 -- path comes from configuration
+		if os.IsNotExist(err) {
+			return ok("jwt secret", "a signing key will be generated in "+keysFile+" on first start")
+		}
+		var keys struct {
+			Keys []auth.SigningKey `json:"keys"`
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &keys)
+		}
+		if err != nil {
+			return fail("jwt secret", fmt.Sprintf("cannot read signing keys from %s: %v", keysFile, err),
+				"fix or remove the file (removing it signs everyone out), or set FETHUR_JWT_SECRET")
+		}
+		return ok("jwt secret", fmt.Sprintf("%d signing key(s) in %s", len(keys.Keys), keysFile))
+	case len(secret) < auth.MinSecretLength:
+		return fail("jwt secret", fmt.Sprintf("FETHUR_JWT_SECRET is only %d characters", len(secret)),
+			fmt.Sprintf("use at least %d random characters", auth.MinSecretLength))
+	}
+	return ok("jwt secret", "FETHUR_JWT_SECRET is set")
+}
+
+func checkCORS(origins []string) Result {
+	if len(origins) == 0 {
+		return fail("cors", "no CORS origins are configured; browsers cannot reach the API",
+			"set FETHUR_CORS_ORIGINS to the web client's origin, e.g. https://chat.example.com")
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			// Credentials are allowed, which browsers refuse with a wildcard
+			return fail("cors", "wildcard origin is not allowed because the API sends credentials",
+				"list the web client origins explicitly in FETHUR_CORS_ORIGINS")
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			(parsed.Path != "" && parsed.Path != "/") {
+			return fail("cors", fmt.Sprintf("invalid origin %q", origin),
+				"origins are scheme://host[:port] without a path, e.g. https://chat.example.com")
+		}
+	}
+	return ok("cors", fmt.Sprintf("%d origins allowed", len(origins)))
+}
+
+func checkTrustedProxies(proxies []string) Result {
+	if len(proxies) == 0 {
+		return ok("proxies", "no trusted proxies; client IPs are taken from the connection")
+	}
+	for _, proxy := range proxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fail("proxies", fmt.Sprintf("invalid trusted proxy %q", proxy),
+				"list IPs or CIDRs in FETHUR_TRUSTED_PROXIES, e.g. 10.0.0.0/8,127.0.0.1")
+		}
+	}
+	return ok("proxies", fmt.Sprintf("client IPs read from X-Forwarded-For behind %d trusted proxies", len(proxies)))
+}
+
+func checkPublicURL(publicURL, basePath string) Result {
+	if strings.ContainsAny(basePath, "?#") {
+		return fail("public url", fmt.Sprintf("invalid base path %q", basePath),
+			"set FETHUR_BASE_PATH to a plain path such as /fethur")
+	}
+	if publicURL == "" {
+		if basePath != "" {
+			return ok("public url", "serving under "+basePath+"; links are relative")
+		}
+		return ok("public url", "FETHUR_PUBLIC_URL is not set; links are relative")
+	}
+	parsed, err := url.Parse(publicURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fail("public url", fmt.Sprintf("invalid public URL %q", publicURL),
+			"set FETHUR_PUBLIC_URL to scheme://host[:port][/base path], e.g. https://example.com/fethur")
+	}
+	if strings.TrimRight(parsed.Path, "/") != basePath {
+		return warn("public url", fmt.Sprintf("%s does not end in the base path %q", publicURL, basePath),
+			"include FETHUR_BASE_PATH at the end of FETHUR_PUBLIC_URL, or drop it if the proxy strips the prefix")
+	}
+	return ok("public url", "links point to "+publicURL)
+}
+
+func checkFaultInjection() Result {
+	if chaos.Available() {
+		return warn("faults", "built with fault injection; admins can slow queries, drop frames and time out plugins",
+			"use this build only for resilience testing; production builds must not use -tags chaos")
+	}
+	return ok("faults", "fault injection is not built in")
+}
+
+func checkCompression(config wscompress.Config) Result {
+	if !config.Enabled {
+		return ok("compression", "WebSocket compression is disabled")
+	}
+	if err := config.Validate(); err != nil {
+		return fail("compression", err.Error(),
+			"set FETHUR_WS_COMPRESSION_LEVEL to 1-9 and FETHUR_WS_COMPRESSION_THRESHOLD to a byte count, or FETHUR_WS_COMPRESSION=false")
+	}
+	return ok("compression", fmt.Sprintf("WebSocket messages of %d bytes or more compressed at level %d", config.Threshold, config.Level))
+}
+
+func checkCompaction(value string) Result {
+	rules, err := websocket.ParseCompaction(value)
+	if err != nil {
+		return fail("compaction", err.Error(),
+			"set FETHUR_WS_COMPACTION to type=threshold/window rules such as reaction_add=20/1s, or off")
+	}
+	if value == "" {
+		return ok("compaction", "bursts of reactions and presence are compacted past 20 events a second per channel")
+	}
+	if len(rules) == 0 {
+		return ok("compaction", "WebSocket event compaction is disabled")
+	}
+	return ok("compaction", fmt.Sprintf("bursts of %d event types are compacted", len(rules)))
+}
+
+func checkUpdates(updateURL, publicKey string) Result {
+	if updateURL == "" {
+		return ok("updates", "FETHUR_UPDATE_URL is not set; new releases are not checked for")
+	}
+	parsed, err := url.Parse(updateURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fail("updates", fmt.Sprintf("invalid update URL %q", updateURL),
+			"set FETHUR_UPDATE_URL to the release manifest, e.g. https://example.com/fethur/latest.json")
+	}
+	if publicKey == "" {
+		return warn("updates", "releases are checked but `fethur update` cannot verify them",
+			"set FETHUR_UPDATE_PUBLIC_KEY to the release signing key to enable self-update")
+	}
+	if _, err := update.ParsePublicKey(publicKey); err != nil {
+		return fail("updates", err.Error(), "copy the base64 public key published with the releases")
+	}
+	if parsed.Scheme != "https" {
+		return warn("updates", "the update URL is not HTTPS", "serve the release manifest over HTTPS")
+	}
+	return ok("updates", "checking "+updateURL+" for signed releases")
+}
+
+func checkTLS(certFile, keyFile string) Result {
+	if certFile == "" && keyFile == "" {
+		return ok("tls", "serving plain HTTP; terminate TLS at a reverse proxy in production")
+	}
+	if certFile == "" || keyFile == "" {
+		return fail("tls", "only one of FETHUR_TLS_CERT and FETHUR_TLS_KEY is set", "set both, or neither")
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fail("tls", fmt.Sprintf("cannot load certificate: %v", err),
+			"check that the files exist, are readable, and that the key matches the certificate")
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fail("tls", fmt.Sprintf("cannot parse certificate: %v", err), "")
+	}
+
+	remaining := time.Until(leaf.NotAfter)
+	switch {
+	case remaining <= 0:
+		return fail("tls", fmt.Sprintf("certificate expired on %s", leaf.NotAfter.Format("2006-01-02")), "renew the certificate")
+	case remaining < 14*24*time.Hour:
+		return warn("tls", fmt.Sprintf("certificate expires on %s", leaf.NotAfter.Format("2006-01-02")), "renew the certificate soon")
+	}
+	return ok("tls", fmt.Sprintf("certificate valid until %s", leaf.NotAfter.Format("2006-01-02")))
+}
+
+func checkStorage(config Config) Result {
+	if config.S3 != nil {
+		if _, err := storage.NewS3Backend(*config.S3); err != nil {
+			return fail("storage", err.Error(), "set FETHUR_S3_BUCKET, FETHUR_S3_ACCESS_KEY and FETHUR_S3_SECRET_KEY")
+		}
+		return ok("storage", "S3 bucket "+config.S3.Bucket)
+	}
+	if err := checkWritable(config.StorageDir); err != nil {
+		return fail("storage", fmt.Sprintf("attachment directory %s is not writable: %v", config.StorageDir, err),
+			"fix its permissions or point FETHUR_STORAGE_DIR elsewhere")
+	}
+	return ok("storage", "attachments stored in "+config.StorageDir)
+}
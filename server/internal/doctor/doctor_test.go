@@ -0,0 +1,210 @@
+package doctor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/database"
+)
+
+func TestCheckCORS(t *testing.T) {
+	tests := []struct {
+		origins []string
+		status  Status
+	}{
+		{[]string{"https://chat.example.com", "http://localhost:5173"}, StatusOK},
+		{nil, StatusFail},
+		{[]string{"*"}, StatusFail},
+		{[]string{"chat.example.com"}, StatusFail},
+		{[]string{"https://chat.example.com/app"}, StatusFail},
+	}
+	for _, tt := range tests {
+		if result := checkCORS(tt.origins); result.Status != tt.status {
+			t.Errorf("checkCORS(%v) = %s (%s), expected %s", tt.origins, result.Status, result.Message, tt.status)
+		}
+	}
+}
+
+func TestCheckReverseProxy(t *testing.T) {
+	proxies := []struct {
+		proxies []string
+		status  Status
+	}{
+		{nil, StatusOK},
+		{[]string{"127.0.0.1", "10.0.0.0/8", "fd00::/8"}, StatusOK},
+		{[]string{"nginx"}, StatusFail},
+		{[]string{"10.0.0.0/33"}, StatusFail},
+	}
+	for _, tt := range proxies {
+		if result := checkTrustedProxies(tt.proxies); result.Status != tt.status {
+			t.Errorf("checkTrustedProxies(%v) = %s (%s), expected %s", tt.proxies, result.Status, result.Message, tt.status)
+		}
+	}
+
+	urls := []struct {
+		publicURL, basePath string
+		status              Status
+	}{
+		{"", "", StatusOK},
+		{"", "/fethur", StatusOK},
+		{"https://example.com", "", StatusOK},
+		{"https://example.com/fethur", "/fethur", StatusOK},
+		{"https://example.com", "/fethur", StatusWarn},
+		{"example.com/fethur", "/fethur", StatusFail},
+		{"", "/fethur?x", StatusFail},
+	}
+	for _, tt := range urls {
+		if result := checkPublicURL(tt.publicURL, tt.basePath); result.Status != tt.status {
+			t.Errorf("checkPublicURL(%q, %q) = %s (%s), expected %s", tt.publicURL, tt.basePath, result.Status, result.Message, tt.status)
+		}
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	key := "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	tests := []struct {
+		url, key string
+		status   Status
+	}{
+		{"", "", StatusOK},
+		{"https://example.com/latest.json", key, StatusOK},
+		{"https://example.com/latest.json", "", StatusWarn},
+		{"http://example.com/latest.json", key, StatusWarn},
+		{"https://example.com/latest.json", "not-a-key", StatusFail},
+		{"example.com/latest.json", key, StatusFail},
+	}
+	for _, tt := range tests {
+		if result := checkUpdates(tt.url, tt.key); result.Status != tt.status {
+			t.Errorf("checkUpdates(%q, %q) = %s (%s), expected %s", tt.url, tt.key, result.Status, result.Message, tt.status)
+		}
+	}
+}
+
+func TestCheckJWTSecret(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "jwt_keys.json")
+	if result := checkJWTSecret("", keysFile); result.Status != StatusOK {
+		t.Errorf("Expected a key to be generated without a secret, got %s", result.Status)
+	}
+	if err := os.WriteFile(keysFile, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkJWTSecret("", keysFile); result.Status != StatusFail {
+		t.Errorf("Expected an unreadable key file to fail, got %s", result.Status)
+	}
+	if result := checkJWTSecret("short", keysFile); result.Status != StatusFail {
+		t.Errorf("Expected short secret to fail, got %s", result.Status)
+	}
+	if result := checkJWTSecret(strings.Repeat("x", 48), keysFile); result.Status != StatusOK {
+		t.Errorf("Expected long secret to pass, got %s", result.Status)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "valid", time.Now().Add(90*24*time.Hour))
+	expiredCert, expiredKey := writeCertificate(t, dir, "expired", time.Now().Add(-time.Hour))
+	soonCert, soonKey := writeCertificate(t, dir, "soon", time.Now().Add(48*time.Hour))
+
+	tests := []struct {
+		name           string
+		cert, key      string
+		expectedStatus Status
+	}{
+		{"plain HTTP", "", "", StatusOK},
+		{"valid", certFile, keyFile, StatusOK},
+		{"expired", expiredCert, expiredKey, StatusFail},
+		{"expiring soon", soonCert, soonKey, StatusWarn},
+		{"key missing", certFile, "", StatusFail},
+		{"mismatched key", certFile, expiredKey, StatusFail},
+		{"missing file", filepath.Join(dir, "missing.pem"), keyFile, StatusFail},
+	}
+	for _, tt := range tests {
+		if result := checkTLS(tt.cert, tt.key); result.Status != tt.expectedStatus {
+			t.Errorf("%s: got %s (%s), expected %s", tt.name, result.Status, result.Message, tt.expectedStatus)
+		}
+	}
+}
+
+func TestCheckDatabaseSchema(t *testing.T) {
+	db, err := database.Init()
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if results := checkDatabase(); Failed(results) {
+		t.Fatalf("Expected fresh database to pass, got %+v", results)
+	}
+
+	// A database written by a newer release must not be opened
+	if _, err := db.Exec("PRAGMA user_version = 999"); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	if results := checkDatabase(); !Failed(results) {
+		t.Errorf("Expected newer schema to fail, got %+v", results)
+	}
+
+	// Current version but missing a table
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", database.SchemaVersion)); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE IF EXISTS audit_logs"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if results := checkDatabase(); !Failed(results) {
+		t.Errorf("Expected missing table to fail, got %+v", results)
+	}
+
+	// Older versions are migrated on start
+	if _, err := db.Exec("PRAGMA user_version = 0"); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	if results := checkDatabase(); Failed(results) {
+		t.Errorf("Expected old schema to be migratable, got %+v", results)
+	}
+}
+
+func writeCertificate(t *testing.T, dir, name string, notAfter time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,71 @@
+// Package events defines the events exchanged over the chat and voice
+// WebSockets and delivered to plugins. Every frame on either socket is an
+// Event envelope; the payload in its data field depends on the type and
+// is one of the structs in payloads.go where it has been typed. Catalog
+// lists every event, and Schema describes them as JSON Schema so client
+// SDKs can be generated from this package.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the version of the event format sent in every frame's "v".
+// Bump it when an event changes in a way old clients would misread.
+const Version = 1
+
+// Type identifies an event
+type Type string
+
+// Event is the envelope every event is sent in. Fields an event does not
+// use are left out of the JSON.
+type Event struct {
+	Type      Type        `json:"type"`
+	Version   int         `json:"v,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	ServerID  int64       `json:"server_id,omitempty"`
+	ChannelID int64       `json:"channel_id,omitempty"`
+	UserID    int64       `json:"user_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TargetID  *int64      `json:"target_id,omitempty"`
+	Content   string      `json:"content,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Encode serializes an event stamped with the current version, with its
+// timestamp in UTC
+func Encode(event Event) ([]byte, error) {
+	event.Version = Version
+	event.Timestamp = event.Timestamp.UTC()
+	return json.Marshal(event)
+}
+
+// Decode parses a frame sent by a client. Anything but a JSON object with
+// a type is refused, as are events from a newer version than this server
+// speaks. Frames without a version are taken to be the current one.
+func Decode(data []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return Event{}, err
+	}
+	if event.Type == "" {
+		return Event{}, errors.New("missing message type")
+	}
+	if event.Version > Version {
+		return Event{}, fmt.Errorf("unsupported event version %d", event.Version)
+	}
+	return event, nil
+}
+
+// DecodeData converts an event's loosely decoded data into a payload struct
+func DecodeData(data interface{}, payload interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, payload)
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	target := int64(9)
+	sent := Event{
+		Type:      TypeOffer,
+		ChannelID: 5,
+		UserID:    2,
+		Username:  "ada",
+		TargetID:  &target,
+		Data:      map[string]interface{}{"sdp": "v=0"},
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	frame, err := Encode(sent)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	got, err := Decode(frame)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", frame, err)
+	}
+	sent.Version = Version
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("Expected %+v, got %+v", sent, got)
+	}
+
+	// Fields an event does not use stay off the wire
+	var raw map[string]interface{}
+	_ = json.Unmarshal(frame, &raw)
+	for _, field := range []string{"server_id", "content", "request_id"} {
+		if _, ok := raw[field]; ok {
+			t.Errorf("Expected %s to be left out of %s", field, frame)
+		}
+	}
+
+	for name, frame := range map[string]string{
+		"no type":       `{"channel_id":1}`,
+		"newer version": `{"type":"text","v":2}`,
+		"not an object": `"text"`,
+	} {
+		if _, err := Decode([]byte(frame)); err == nil {
+			t.Errorf("Expected a frame with %s to be refused", name)
+		}
+	}
+	if event, err := Decode([]byte(`{"type":"ping"}`)); err != nil || event.Type != TypePing {
+		t.Errorf("Expected a frame without a version to be accepted, got %+v (%v)", event, err)
+	}
+}
+
+func TestDecodeData(t *testing.T) {
+	event, err := Decode([]byte(`{"type":"activity","data":{"kind":"uploading","active":true}}`))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	var activity Activity
+	if err := DecodeData(event.Data, &activity); err != nil || activity.Kind != "uploading" || !activity.Active {
+		t.Errorf("Expected an upload, got %+v (%v)", activity, err)
+	}
+	var settings Settings
+	if err := DecodeData(map[string]interface{}{"events": "typing"}, &settings); err == nil {
+		t.Errorf("Expected events that are not a list to be refused")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	type key struct {
+		transport Transport
+		eventType Type
+	}
+	seen := make(map[key]bool)
+	for _, definition := range Catalog {
+		k := key{definition.Transport, definition.Type}
+		if seen[k] {
+			t.Errorf("Event %s is listed twice on %s", definition.Type, definition.Transport)
+		}
+		seen[k] = true
+		if definition.Description == "" {
+			t.Errorf("Event %s on %s has no description", definition.Type, definition.Transport)
+		}
+	}
+
+	if definition, ok := Lookup(TransportVoice, TypeError); !ok || definition.Data != (ErrorData{}) {
+		t.Errorf("Expected voice errors to carry ErrorData, got %+v", definition)
+	}
+	if _, ok := Lookup(TransportPlugin, TypeOffer); ok {
+		t.Errorf("Expected offers not to reach plugins")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatalf("Failed to serialize schema: %v", err)
+	}
+
+	defs := schema["$defs"].(map[string]interface{})
+	for _, name := range []string{"Event", "ChannelJoined", "VoiceParticipant", "SubscriptionAck"} {
+		if _, ok := defs[name]; !ok {
+			t.Errorf("Expected %s in $defs", name)
+		}
+	}
+
+	event := defs["Event"].(map[string]interface{})
+	properties := event["properties"].(map[string]interface{})
+	if properties["timestamp"].(map[string]interface{})["format"] != "date-time" {
+		t.Errorf("Expected timestamps to be date-times, got %v", properties["timestamp"])
+	}
+	if required := event["required"].([]string); !reflect.DeepEqual(required, []string{"type", "timestamp"}) {
+		t.Errorf("Expected only type and timestamp to be required, got %v", required)
+	}
+
+	if events := schema["events"].([]map[string]interface{}); len(events) != len(Catalog) {
+		t.Errorf("Expected %d events, got %d", len(Catalog), len(events))
+	}
+}
+
+func TestTypeScriptUpToDate(t *testing.T) {
+	generated, err := os.ReadFile("../../../client/web/src/lib/api/events.gen.ts")
+	if os.IsNotExist(err) {
+		t.Skip("Web client is not checked out")
+	}
+	if err != nil {
+		t.Fatalf("Failed to read generated types: %v", err)
+	}
+	if string(generated) != TypeScript() {
+		t.Errorf("client/web/src/lib/api/events.gen.ts is out of date; run go run ./cmd/eventsgen from server/")
+	}
+}
@@ -0,0 +1,138 @@
+package events
+
+// Activity is the data of an activity event
+type Activity struct {
+	Kind   string `json:"kind"`
+	Active bool   `json:"active"`
+	TTL    int    `json:"ttl,omitempty"` // seconds until the activity expires unless repeated
+}
+
+// Compacted is the data of a compacted event, which stands for a burst of
+// events of related types in one channel. Types lists the event types
+// folded in and Count how many events there were. Reactions and presence
+// carry their net change; other events only their count.
+type Compacted struct {
+	Types     []string        `json:"types"`
+	Count     int             `json:"count"`
+	Reactions []ReactionDelta `json:"reactions,omitempty"`
+	Joined    []int64         `json:"joined,omitempty"`
+	Left      []int64         `json:"left,omitempty"`
+}
+
+// Reaction is the data of reaction_add and reaction_remove
+type Reaction struct {
+	MessageID string `json:"message_id"`
+	ChannelID int    `json:"channel_id"`
+	UserID    int    `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReactionDelta is the net change of one emoji on one message
+type ReactionDelta struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Delta     int    `json:"delta"`
+}
+
+// MemberRequest is the data of request_members. Each range asks for the
+// members at positions Start to End, inclusive, of the server's sorted
+// member list.
+type MemberRequest struct {
+	ServerID int64         `json:"server_id"`
+	Ranges   []MemberRange `json:"ranges"`
+}
+
+// MemberRange is a range of positions in a member list
+type MemberRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// MemberChunk is the data of member_chunk: the members from position
+// Offset on, with how many members and online members the server has.
+// Error is set instead when the request was refused.
+type MemberChunk struct {
+	ServerID int64    `json:"server_id"`
+	Offset   int      `json:"offset"`
+	Total    int      `json:"total"`
+	Online   int      `json:"online"`
+	Members  []Member `json:"members"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Member is an entry of a member list
+type Member struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Online   bool   `json:"online"`
+}
+
+// SubscriptionAck is the data of subscribe_ack and unsubscribe_ack
+type SubscriptionAck struct {
+	ChannelID  int    `json:"channel_id"`
+	Subscribed bool   `json:"subscribed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Subscriptions lists the channels restored after reconnecting
+type Subscriptions struct {
+	Channels []int `json:"channels"`
+}
+
+// Settings is the data of settings and settings_ack. Events lists the
+// event categories the connection does not want to receive.
+type Settings struct {
+	Events []string `json:"events"`
+}
+
+// Clock is the data of hello, clock and pong, for clients to work out how
+// far their clock is from the server's. ServerTime is when the server wrote
+// the frame, in Unix milliseconds. A heartbeat may carry the client's own
+// time in ClientTime, which its pong echoes so the round trip can be
+// measured. Interval is the seconds between clock events.
+type Clock struct {
+	ServerTime int64 `json:"server_time,omitempty"`
+	ClientTime int64 `json:"client_time,omitempty"`
+	Interval   int   `json:"interval,omitempty"`
+}
+
+// ErrorData explains why the voice server refused a request
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// VoiceParticipant is someone in a voice channel
+type VoiceParticipant struct {
+	UserID     int64  `json:"user_id"`
+	Username   string `json:"username"`
+	IsMuted    bool   `json:"is_muted"`
+	IsDeafened bool   `json:"is_deafened"`
+	IsSpeaking bool   `json:"is_speaking"`
+}
+
+// ChannelJoined is the data of channel-joined
+type ChannelJoined struct {
+	ChannelID   int64              `json:"channel_id"`
+	ServerID    int64              `json:"server_id"`
+	ChannelName string             `json:"channel_name"`
+	Clients     []VoiceParticipant `json:"clients"`
+}
+
+// IdleWarning is the data of idle-warning
+type IdleWarning struct {
+	Reason           string `json:"reason"`
+	DisconnectInSecs int    `json:"disconnect_in_secs"`
+}
+
+// IdleDisconnect is the data of idle-disconnect
+type IdleDisconnect struct {
+	Reason string `json:"reason"`
+}
+
+// ForceDisconnect is the data of force-disconnect
+type ForceDisconnect struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
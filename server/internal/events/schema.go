@@ -0,0 +1,95 @@
+package events
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema describes the envelope, every payload and every event in the
+// catalog as a JSON Schema document. Payloads are referenced from $defs by
+// their Go type name so generated SDKs get one type per payload.
+func Schema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	schemaFor(reflect.TypeOf(Event{}), defs)
+
+	list := make([]map[string]interface{}, 0, len(Catalog))
+	for _, definition := range Catalog {
+		entry := map[string]interface{}{
+			"type":        definition.Type,
+			"transport":   definition.Transport,
+			"direction":   definition.Direction,
+			"description": definition.Description,
+		}
+		if definition.Data != nil {
+			entry["data"] = schemaFor(reflect.TypeOf(definition.Data), defs)
+		}
+		list = append(list, entry)
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Fethur events",
+		"version": Version,
+		"$ref":    "#/$defs/Event",
+		"$defs":   defs,
+		"events":  list,
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes a Go type, adding named structs to defs and
+// referring to them
+func schemaFor(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return schemaFor(t.Elem(), defs)
+	case t.Kind() == reflect.Interface:
+		return map[string]interface{}{}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case t.Kind() != reflect.Struct:
+		return map[string]interface{}{}
+	}
+
+	ref := map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	if _, ok := defs[t.Name()]; ok {
+		return ref
+	}
+
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, defs)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	defs[t.Name()] = map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+	return ref
+}
@@ -0,0 +1,193 @@
+package events
+
+// Chat WebSocket (/ws) events
+const (
+	TypeText           Type = "text"
+	TypeJoin           Type = "join"
+	TypeLeave          Type = "leave"
+	TypeTyping         Type = "typing"
+	TypeStopTyping     Type = "stop_typing"
+	TypeCatchUp        Type = "catch_up"
+	TypeSubscribe      Type = "subscribe"
+	TypeUnsubscribe    Type = "unsubscribe"
+	TypeSubscribeAck   Type = "subscribe_ack"
+	TypeUnsubscribeAck Type = "unsubscribe_ack"
+	TypeSubscriptions  Type = "subscriptions"
+	TypeSettings       Type = "settings"
+	TypeSettingsAck    Type = "settings_ack"
+	TypeActivity       Type = "activity"
+	TypeHeartbeat      Type = "heartbeat"
+	TypeMessageUpdate  Type = "message_update"
+	TypeMessageDelete  Type = "message_delete"
+	TypeReactionAdd    Type = "reaction_add"
+	TypeReactionRemove Type = "reaction_remove"
+	TypeNotification   Type = "notification"
+	TypeRaidMode       Type = "raid_mode"
+	TypeJoinRequest    Type = "join_request"
+	TypeJoinDecided    Type = "join_request_decided"
+	TypeModeration     Type = "moderation_action"
+	TypeCaseAppeal     Type = "case_appeal"
+	TypeAppealDecided  Type = "case_appeal_decided"
+	TypeCompacted      Type = "compacted"
+	TypeRequestMembers Type = "request_members"
+	TypeMemberChunk    Type = "member_chunk"
+	TypeHello          Type = "hello"
+	TypeClock          Type = "clock"
+)
+
+// Events on both sockets
+const (
+	TypeError Type = "error"
+	TypePing  Type = "ping"
+	TypePong  Type = "pong"
+)
+
+// Voice signaling WebSocket (/api/voice/ws) events
+const (
+	TypeConnected       Type = "connected"
+	TypeJoinChannel     Type = "join-channel"
+	TypeLeaveChannel    Type = "leave-channel"
+	TypeChannelJoined   Type = "channel-joined"
+	TypeUserJoined      Type = "user-joined"
+	TypeUserLeft        Type = "user-left"
+	TypeOffer           Type = "offer"
+	TypeAnswer          Type = "answer"
+	TypeICECandidate    Type = "ice-candidate"
+	TypeMute            Type = "mute"
+	TypeUnmute          Type = "unmute"
+	TypeDeafen          Type = "deafen"
+	TypeUndeafen        Type = "undeafen"
+	TypeSpeaking        Type = "speaking"
+	TypeIdleWarning     Type = "idle-warning"
+	TypeIdleDisconnect  Type = "idle-disconnect"
+	TypeForceDisconnect Type = "force-disconnect"
+)
+
+// Plugin events, delivered to event listener plugins
+const (
+	TypeMessageCreate  Type = "message.create"
+	TypeMessageEdit    Type = "message.update"
+	TypeMessageRemove  Type = "message.delete"
+	TypeUserJoin       Type = "user.join"
+	TypeUserLeave      Type = "user.leave"
+	TypeChannelCreate  Type = "channel.create"
+	TypeChannelUpdate  Type = "channel.update"
+	TypeChannelDelete  Type = "channel.delete"
+	TypeServerUpdate   Type = "server.update"
+	TypeUserKicked     Type = "user.kicked"
+	TypeUserBanned     Type = "user.banned"
+	TypePluginLoaded   Type = "plugin.loaded"
+	TypePluginUnloaded Type = "plugin.unloaded"
+	TypePluginError    Type = "plugin.error"
+)
+
+// Transport is where an event travels
+type Transport string
+
+const (
+	TransportChat   Transport = "chat"
+	TransportVoice  Transport = "voice"
+	TransportPlugin Transport = "plugin"
+)
+
+// Direction is who sends an event
+type Direction string
+
+const (
+	FromClient Direction = "client"
+	FromServer Direction = "server"
+	FromBoth   Direction = "both"
+)
+
+// Definition describes one event. Data is a zero value of its payload, or
+// nil when the payload is not typed yet.
+type Definition struct {
+	Type        Type
+	Transport   Transport
+	Direction   Direction
+	Description string
+	Data        interface{}
+}
+
+// Catalog lists every event
+var Catalog = []Definition{
+	{TypeText, TransportChat, FromBoth, "A chat message; clients send content, the server adds the stored message in data", nil},
+	{TypeMessageUpdate, TransportChat, FromServer, "A message was edited", nil},
+	{TypeMessageDelete, TransportChat, FromServer, "A message was deleted", nil},
+	{TypeReactionAdd, TransportChat, FromServer, "A user reacted to a message", Reaction{}},
+	{TypeReactionRemove, TransportChat, FromServer, "A user took back a reaction", Reaction{}},
+	{TypeJoin, TransportChat, FromBoth, "Subscribe to a channel without an acknowledgment; the server announces who joined", nil},
+	{TypeLeave, TransportChat, FromBoth, "Unsubscribe from a channel; the server announces who left", nil},
+	{TypeTyping, TransportChat, FromBoth, "A user started typing in channel_id", nil},
+	{TypeStopTyping, TransportChat, FromBoth, "A user stopped typing in channel_id", nil},
+	{TypeSubscribe, TransportChat, FromClient, "Subscribe to channel_id", nil},
+	{TypeUnsubscribe, TransportChat, FromClient, "Unsubscribe from channel_id", nil},
+	{TypeSubscribeAck, TransportChat, FromServer, "Answer to subscribe", SubscriptionAck{}},
+	{TypeUnsubscribeAck, TransportChat, FromServer, "Answer to unsubscribe", SubscriptionAck{}},
+	{TypeSubscriptions, TransportChat, FromServer, "Channels restored after reconnecting", Subscriptions{}},
+	{TypeSettings, TransportChat, FromClient, "Change which event categories this connection receives", Settings{}},
+	{TypeSettingsAck, TransportChat, FromServer, "Answer to settings, with an error in content", Settings{}},
+	{TypeActivity, TransportChat, FromBoth, "Start, refresh or stop an activity such as uploading", Activity{}},
+	{TypeCompacted, TransportChat, FromServer, "A burst of events in channel_id folded into one frame", Compacted{}},
+	{TypeRequestMembers, TransportChat, FromClient, "Ask for ranges of a server's member list", MemberRequest{}},
+	{TypeMemberChunk, TransportChat, FromServer, "One range of a server's member list, in answer to request_members", MemberChunk{}},
+	{TypeCatchUp, TransportChat, FromServer, "Summary of what happened while the user was offline", nil},
+	{TypeNotification, TransportChat, FromServer, "A notification for this user", nil},
+	{TypeRaidMode, TransportChat, FromServer, "Raid mode changed on a server the user moderates", nil},
+	{TypeJoinRequest, TransportChat, FromServer, "Someone asked to join a server the user moderates", nil},
+	{TypeJoinDecided, TransportChat, FromServer, "The user's join request was decided", nil},
+	{TypeModeration, TransportChat, FromServer, "A moderator acted against the user", nil},
+	{TypeCaseAppeal, TransportChat, FromServer, "A moderation case was appealed on a server the user moderates", nil},
+	{TypeAppealDecided, TransportChat, FromServer, "The user's appeal was decided", nil},
+	{TypeHello, TransportChat, FromServer, "First frame on a connection, with the server's clock", Clock{}},
+	{TypeClock, TransportChat, FromServer, "The server's clock, sent with every keepalive ping", Clock{}},
+	{TypeHeartbeat, TransportChat, FromClient, "Keep the connection alive, optionally with the client's clock; answered with pong", Clock{}},
+	{TypePong, TransportChat, FromServer, "Answer to heartbeat with the server's clock", Clock{}},
+	{TypeError, TransportChat, FromServer, "A frame was refused; the reason is in content", nil},
+
+	{TypeConnected, TransportVoice, FromServer, "The voice connection is registered", nil},
+	{TypeJoinChannel, TransportVoice, FromClient, "Join voice channel channel_id", nil},
+	{TypeLeaveChannel, TransportVoice, FromClient, "Leave the current voice channel", nil},
+	{TypeChannelJoined, TransportVoice, FromServer, "The channel the user joined and who is in it", ChannelJoined{}},
+	{TypeUserJoined, TransportVoice, FromServer, "Someone joined the voice channel", nil},
+	{TypeUserLeft, TransportVoice, FromServer, "Someone left the voice channel", nil},
+	{TypeOffer, TransportVoice, FromBoth, "WebRTC offer for target_id, relayed as is", nil},
+	{TypeAnswer, TransportVoice, FromBoth, "WebRTC answer for target_id, relayed as is", nil},
+	{TypeICECandidate, TransportVoice, FromBoth, "WebRTC ICE candidate for target_id, relayed as is", nil},
+	{TypeMute, TransportVoice, FromBoth, "A user muted", nil},
+	{TypeUnmute, TransportVoice, FromBoth, "A user unmuted", nil},
+	{TypeDeafen, TransportVoice, FromBoth, "A user deafened", nil},
+	{TypeUndeafen, TransportVoice, FromBoth, "A user undeafened", nil},
+	{TypeSpeaking, TransportVoice, FromBoth, "Whether a user is speaking; data is a boolean", false},
+	{TypePing, TransportVoice, FromBoth, "Connection check, answered with pong", nil},
+	{TypePong, TransportVoice, FromBoth, "Answer to ping", nil},
+	{TypeIdleWarning, TransportVoice, FromServer, "The user will be disconnected for being idle", IdleWarning{}},
+	{TypeIdleDisconnect, TransportVoice, FromServer, "The user was disconnected for being idle", IdleDisconnect{}},
+	{TypeForceDisconnect, TransportVoice, FromServer, "A moderator removed the user from voice", ForceDisconnect{}},
+	{TypeError, TransportVoice, FromServer, "A request was refused", ErrorData{}},
+
+	{TypeMessageCreate, TransportPlugin, FromServer, "A message was sent", nil},
+	{TypeMessageEdit, TransportPlugin, FromServer, "A message was edited", nil},
+	{TypeMessageRemove, TransportPlugin, FromServer, "A message was deleted", nil},
+	{TypeUserJoin, TransportPlugin, FromServer, "A user joined a server", nil},
+	{TypeUserLeave, TransportPlugin, FromServer, "A user left a server", nil},
+	{TypeChannelCreate, TransportPlugin, FromServer, "A channel was created", nil},
+	{TypeChannelUpdate, TransportPlugin, FromServer, "A channel was changed", nil},
+	{TypeChannelDelete, TransportPlugin, FromServer, "A channel was deleted", nil},
+	{TypeServerUpdate, TransportPlugin, FromServer, "A server was changed", nil},
+	{TypeUserKicked, TransportPlugin, FromServer, "A user was kicked", nil},
+	{TypeUserBanned, TransportPlugin, FromServer, "A user was banned", nil},
+	{TypePluginLoaded, TransportPlugin, FromServer, "A plugin was loaded", nil},
+	{TypePluginUnloaded, TransportPlugin, FromServer, "A plugin was unloaded", nil},
+	{TypePluginError, TransportPlugin, FromServer, "A plugin failed", nil},
+}
+
+// Lookup finds an event by where it travels and its type
+func Lookup(transport Transport, eventType Type) (Definition, bool) {
+	for _, definition := range Catalog {
+		if definition.Transport == transport && definition.Type == eventType {
+			return definition, true
+		}
+	}
+	return Definition{}, false
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TypeScript renders the envelope, the payloads and the catalog as a
+// TypeScript module for the web client and other TypeScript tools. The
+// output is deterministic so the generated file can be checked in and
+// compared in tests.
+func TypeScript() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by `go run ./cmd/eventsgen` from server/internal/events; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "export const EVENT_VERSION = %d;\n\n", Version)
+
+	// Payload interfaces, in the order the catalog first uses them
+	var payloads []reflect.Type
+	seen := map[reflect.Type]bool{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || t == timeType || seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			collect(t.Field(i).Type)
+		}
+		payloads = append(payloads, t)
+	}
+	for _, definition := range Catalog {
+		if definition.Data != nil {
+			collect(reflect.TypeOf(definition.Data))
+		}
+	}
+
+	b.WriteString("/** The envelope every event is sent in */\n")
+	b.WriteString("export interface Event<T extends string = EventType, D = unknown> {\n")
+	event := reflect.TypeOf(Event{})
+	for i := 0; i < event.NumField(); i++ {
+		field := event.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		optional := ""
+		if strings.Contains(options, "omitempty") {
+			optional = "?"
+		}
+		switch field.Name {
+		case "Type":
+			fmt.Fprintf(&b, "\t%s: T;\n", name)
+		case "Data":
+			fmt.Fprintf(&b, "\t%s%s: D;\n", name, optional)
+		default:
+			fmt.Fprintf(&b, "\t%s%s: %s;\n", name, optional, typeScriptType(field.Type))
+		}
+	}
+	b.WriteString("}\n")
+
+	for _, t := range payloads {
+		fmt.Fprintf(&b, "\nexport interface %s {\n", t.Name())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			optional := ""
+			if strings.Contains(options, "omitempty") {
+				optional = "?"
+			}
+			fmt.Fprintf(&b, "\t%s%s: %s;\n", name, optional, typeScriptType(field.Type))
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, transport := range []Transport{TransportChat, TransportVoice, TransportPlugin} {
+		prefix := strings.ToUpper(string(transport[:1])) + string(transport[1:])
+
+		var types []string
+		for _, definition := range Catalog {
+			if definition.Transport == transport {
+				types = append(types, fmt.Sprintf("'%s'", definition.Type))
+			}
+		}
+		fmt.Fprintf(&b, "\nexport type %sEventType =\n\t| %s;\n", prefix, strings.Join(types, "\n\t| "))
+
+		fmt.Fprintf(&b, "\n/** Payload of each %s event */\nexport interface %sEventData {\n", transport, prefix)
+		for _, definition := range Catalog {
+			if definition.Transport != transport {
+				continue
+			}
+			data := "unknown"
+			if definition.Data != nil {
+				data = typeScriptType(reflect.TypeOf(definition.Data))
+			}
+			fmt.Fprintf(&b, "\t/** %s */\n\t%s: %s;\n", definition.Description, typeScriptKey(string(definition.Type)), data)
+		}
+		b.WriteString("}\n")
+
+		fmt.Fprintf(&b, "\nexport type %[1]sEvent<T extends %[1]sEventType = %[1]sEventType> = Event<T, %[1]sEventData[T]>;\n", prefix)
+	}
+
+	b.WriteString("\nexport type EventType = ChatEventType | VoiceEventType | PluginEventType;\n")
+	b.WriteString(`
+/** Narrows a chat frame to one event type */
+export function isChatEvent<T extends ChatEventType>(
+	event: Event<string>,
+	type: T
+): event is ChatEvent<T> {
+	return event.type === type;
+}
+
+/** Narrows a voice frame to one event type */
+export function isVoiceEvent<T extends VoiceEventType>(
+	event: Event<string>,
+	type: T
+): event is VoiceEvent<T> {
+	return event.type === type;
+}
+`)
+	return b.String()
+}
+
+// typeScriptKey quotes a property name only where TypeScript needs it
+func typeScriptKey(name string) string {
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "'" + name + "'"
+		}
+	}
+	return name
+}
+
+// typeScriptType names the TypeScript type of a Go type
+func typeScriptType(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "string"
+	case t.Kind() == reflect.Ptr:
+		return typeScriptType(t.Elem())
+	case t.Kind() == reflect.Bool:
+		return "boolean"
+	case t.Kind() == reflect.String:
+		if t.Name() == "Type" {
+			return "EventType"
+		}
+		return "string"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		return "number"
+	case t.Kind() == reflect.Slice:
+		return typeScriptType(t.Elem()) + "[]"
+	case t.Kind() == reflect.Map:
+		return "Record<string, " + typeScriptType(t.Elem()) + ">"
+	case t.Kind() == reflect.Struct:
+		return t.Name()
+	}
+	return "unknown"
+}
@@ -0,0 +1,406 @@
+// Package fetch sends the HTTP requests the server makes on its own to
+// URLs that users and admins supply: slash command endpoints, automation
+// hooks, calendar feeds and plugin requests.
+//
+// Such URLs can point back into the server's own network, so every
+// connection is checked after DNS resolution and dialed to the address
+// that was checked; a name that resolves to a public address once and an
+// internal one later cannot slip through, and neither can a redirect.
+// Loopback, private, link-local and other internal ranges are refused
+// unless allowlisted. Responses are size-limited, and a destination that
+// keeps failing is given a rest by a circuit breaker instead of tying up
+// workers.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fethur/internal/resilience"
+)
+
+// Errors returned through the clients of a Fetcher
+var (
+	ErrBlocked     = errors.New("fetch: destination is not allowed")
+	ErrTooLarge    = errors.New("fetch: response body too large")
+	ErrCircuitOpen = errors.New("fetch: destination is failing, try again later")
+)
+
+// Config controls which destinations may be reached and how
+type Config struct {
+	// AllowPrivate allows internal addresses everywhere, for servers that
+	// only talk to their own network
+	AllowPrivate bool
+	// Allowlist holds host names, IP addresses and CIDR ranges that may be
+	// reached even though they are internal. "*.lan" allows every
+	// subdomain of lan.
+	Allowlist []string
+
+	MaxBodySize  int64 // bytes of a response body before ErrTooLarge
+	MaxRedirects int
+
+	// FailureThreshold consecutive failures open a destination's circuit
+	// for Cooldown, after which a single request may try again
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// Default refuses internal addresses, reads at most 10 MB and rests a
+// destination for a minute after five failures in a row
+func Default() Config {
+	return Config{
+		MaxBodySize:      10 << 20,
+		MaxRedirects:     5,
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+	}
+}
+
+// ParseAllowlist splits a comma- or space-separated allowlist
+func ParseAllowlist(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
+
+// blockedPrefixes are internal ranges the netip predicates do not cover
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, which can reach internal IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),       // 6to4, likewise
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site-local
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4-translated
+}
+
+// Internal reports whether an address is loopback, private, link-local or
+// otherwise not on the public internet
+func Internal(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetcher is an http.RoundTripper for untrusted destinations. It is safe
+// for concurrent use; share one per server so circuits are shared too.
+type Fetcher struct {
+	config    Config
+	hosts     map[string]bool
+	suffixes  []string
+	prefixes  []netip.Prefix
+	dialer    *net.Dialer
+	resolver  *net.Resolver
+	transport *http.Transport
+
+	mutex    sync.Mutex
+	breakers map[string]*resilience.Breaker // destinations that failed lately
+}
+
+// New creates a fetcher. Allowlist entries that are neither addresses nor
+// ranges are taken as host names.
+func New(config Config) *Fetcher {
+	f := &Fetcher{
+		config:   config,
+		hosts:    make(map[string]bool),
+		dialer:   &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+		resolver: net.DefaultResolver,
+		breakers: make(map[string]*resilience.Breaker),
+	}
+	for _, entry := range config.Allowlist {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			f.prefixes = append(f.prefixes, prefix.Masked())
+		} else if ip, err := netip.ParseAddr(entry); err == nil {
+			f.prefixes = append(f.prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+		} else if strings.HasPrefix(entry, "*.") {
+			f.suffixes = append(f.suffixes, entry[1:])
+		} else if entry != "" {
+			f.hosts[entry] = true
+		}
+	}
+
+	f.transport = &http.Transport{
+		// A proxy would connect to internal hosts on the fetcher's behalf
+		Proxy:                 nil,
+		DialContext:           f.dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          32,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return f
+}
+
+// Client returns an HTTP client that sends every request, including
+// redirects, through the fetcher
+func (f *Fetcher) Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: f,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= f.config.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			return nil
+		},
+	}
+}
+
+// CheckURL reports whether a URL may be fetched as far as can be told
+// without resolving it: an absolute http or https URL whose host, if it
+// is an address, is allowed. Use it to validate URLs when they are saved;
+// names are checked when they are connected to.
+func (f *Fetcher) CheckURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	return f.checkHost(parsed.Hostname())
+}
+
+// checkHost refuses hosts that are internal addresses or obviously local
+// names
+func (f *Fetcher) checkHost(host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return f.checkAddr(host, ip)
+	}
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return f.checkAddr(host, netip.IPv6Loopback())
+	}
+	return nil
+}
+
+// checkAddr refuses an internal address a host resolved to, unless it is
+// allowlisted
+func (f *Fetcher) checkAddr(host string, ip netip.Addr) error {
+	ip = ip.Unmap()
+	if f.config.AllowPrivate || !Internal(ip) || f.allowedHost(host) {
+		return nil
+	}
+	for _, prefix := range f.prefixes {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	if host == ip.String() {
+		return fmt.Errorf("%w: %s is an internal address", ErrBlocked, ip)
+	}
+	return fmt.Errorf("%w: %s resolves to internal address %s", ErrBlocked, host, ip)
+}
+
+func (f *Fetcher) allowedHost(host string) bool {
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if f.hosts[name] {
+		return true
+	}
+	for _, suffix := range f.suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext resolves a host and connects to the first allowed address,
+// so the address checked is the address used
+func (f *Fetcher) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := f.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	for _, ip := range addrs {
+		if err := f.checkAddr(host, ip); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		conn, err := f.dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		firstErr = err
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no addresses for %s", host)
+	}
+	return nil, firstErr
+}
+
+// RoundTrip sends a request unless its destination is refused or resting
+func (f *Fetcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrBlocked, req.URL.Scheme)
+	}
+	if err := f.checkHost(req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	destination := destinationOf(req.URL)
+	if !f.acquire(destination) {
+		return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, destination)
+	}
+
+	resp, err := f.transport.RoundTrip(req)
+	if err != nil {
+		// Refused destinations and abandoned requests say nothing about
+		// the destination's health
+		if errors.Is(err, ErrBlocked) || errors.Is(err, context.Canceled) {
+			f.release(destination)
+		} else {
+			f.finish(destination, true)
+		}
+		return nil, err
+	}
+	f.finish(destination, resp.StatusCode >= 500)
+
+	if f.config.MaxBodySize > 0 {
+		if resp.ContentLength > f.config.MaxBodySize {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+		}
+		resp.Body = &limitedBody{body: resp.Body, remaining: f.config.MaxBodySize}
+	}
+	return resp, nil
+}
+
+// destinationOf names the host and port a circuit covers
+func destinationOf(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// acquire reports whether a request may go to a destination. Once an open
+// circuit has rested, one request at a time probes it.
+func (f *Fetcher) acquire(destination string) bool {
+	if f.config.FailureThreshold <= 0 {
+		return true
+	}
+	f.mutex.Lock()
+	b := f.breakers[destination]
+	f.mutex.Unlock()
+	return b == nil || b.Allow()
+}
+
+// finish records the outcome of a request
+func (f *Fetcher) finish(destination string, failed bool) {
+	if f.config.FailureThreshold <= 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	b := f.breakers[destination]
+	if !failed {
+		if b != nil {
+			b.Success()
+			delete(f.breakers, destination)
+		}
+		return
+	}
+	if b == nil {
+		// Forget healthy destinations before tracking another
+		if len(f.breakers) >= maxBreakers {
+			for name, tracked := range f.breakers {
+				if state, _, _ := tracked.State(); state == resilience.StateClosed {
+					delete(f.breakers, name)
+				}
+			}
+		}
+		b = resilience.NewBreaker(f.config.FailureThreshold, f.config.Cooldown)
+		f.breakers[destination] = b
+	}
+	b.Failure()
+}
+
+// release ends a probe without an outcome
+func (f *Fetcher) release(destination string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if b := f.breakers[destination]; b != nil {
+		b.Release()
+	}
+}
+
+// Health reports the destinations that failed lately, by host and port
+func (f *Fetcher) Health() []resilience.Health {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	health := make([]resilience.Health, 0, len(f.breakers))
+	for destination, b := range f.breakers {
+		health = append(health, resilience.BreakerHealth(destination, b))
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Name < health[j].Name
+	})
+	return health
+}
+
+// maxBreakers bounds how many failing destinations are tracked
+const maxBreakers = 1024
+
+// limitedBody fails reads past the size limit
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n - 1, ErrTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
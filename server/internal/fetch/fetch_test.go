@@ -0,0 +1,175 @@
+package fetch
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fethur/internal/resilience"
+)
+
+func TestInternal(t *testing.T) {
+	for address, internal := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.10":     true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"::ffff:10.0.0.1":  true,
+		"64:ff9b::a00:1":   true,
+		"1.1.1.1":          false,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+		"::ffff:8.8.8.8":   false,
+		"2a00:1450::200e":  false,
+		"172.32.0.1":       false,
+		"192.169.0.1":      false,
+		"100.128.0.1":      false,
+		"198.20.0.1":       false,
+		"2001:4860::8888":  false,
+		"2001:0:1234::1":   true,
+		"255.255.255.255":  true,
+		"239.255.255.250":  true,
+		"2001:db8::1":      true,
+		"fec0::1":          true,
+		"::":               true,
+		"64:ff9b:1::1":     true,
+		"2002:c0a8:101::1": true,
+	} {
+		if got := Internal(netip.MustParseAddr(address)); got != internal {
+			t.Errorf("Internal(%s) = %v, want %v", address, got, internal)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	f := New(Config{Allowlist: []string{"10.0.0.0/8", "ci.lan", "*.home.arpa"}})
+	for raw, allowed := range map[string]bool{
+		"https://example.com/hook":        true,
+		"http://10.2.3.4:8080/hook":       true,
+		"http://127.0.0.1/":               false,
+		"http://[::1]/":                   false,
+		"http://169.254.169.254/latest":   false,
+		"http://localhost:9000/":          false,
+		"http://api.localhost/":           false,
+		"ftp://example.com/":              false,
+		"/relative":                       false,
+		"http://192.168.1.1/":             false,
+		"http://ci.lan/hook":              true,
+		"http://grafana.home.arpa/alerts": true,
+	} {
+		if err := f.CheckURL(raw); (err == nil) != allowed {
+			t.Errorf("CheckURL(%s) = %v, want allowed %v", raw, err, allowed)
+		}
+	}
+}
+
+func TestFetcherRefusesInternalAddresses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer backend.Close()
+
+	// Names are checked after resolution, when the connection is made
+	url := strings.Replace(backend.URL, "127.0.0.1", "localhost.", 1)
+	if _, err := New(Default()).Client(time.Second).Get(url); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected a name resolving to loopback to be refused, got %v", err)
+	}
+
+	// So are redirects
+	redirector := httptest.NewServer(http.RedirectHandler(backend.URL, http.StatusFound))
+	defer redirector.Close()
+	allowRedirector := New(Config{Allowlist: []string{"localhost"}, MaxRedirects: 5})
+	if _, err := allowRedirector.Client(time.Second).Get(strings.Replace(redirector.URL, "127.0.0.1", "localhost", 1)); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected a redirect to an internal address to be refused, got %v", err)
+	}
+
+	config := Default()
+	config.Allowlist = []string{"127.0.0.1"}
+	resp, err := New(config).Client(time.Second).Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Expected an allowlisted address to be reached, got %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "internal" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestFetcherLimitsBodies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush() // stream, so the length is not known up front
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer backend.Close()
+
+	f := New(Config{AllowPrivate: true, MaxBodySize: 64})
+	resp, err := f.Client(time.Second).Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrTooLarge) || len(body) != 64 {
+		t.Errorf("Expected the body to stop at 64 bytes, got %d (%v)", len(body), err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer backend.Close()
+
+	f := New(Config{AllowPrivate: true, FailureThreshold: 2, Cooldown: 50 * time.Millisecond})
+	client := f.Client(time.Second)
+	get := func(path string) error {
+		resp, err := client.Get(backend.URL + path)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	_ = get("/down")
+	_ = get("/down")
+	if err := get("/"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the circuit to open after two failures, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected an open circuit to send nothing, got %d calls", calls.Load())
+	}
+	if health := f.Health(); len(health) != 1 || health[0].State != resilience.StateOpen || health[0].ConsecutiveFailures != 2 {
+		t.Errorf("Expected the destination to be reported open, got %+v", health)
+	}
+
+	// After the cooldown a successful probe closes it again
+	time.Sleep(60 * time.Millisecond)
+	if err := get("/"); err != nil {
+		t.Fatalf("Expected a probe after the cooldown, got %v", err)
+	}
+	if err := get("/"); err != nil {
+		t.Errorf("Expected the circuit to close, got %v", err)
+	}
+	if health := f.Health(); len(health) != 0 {
+		t.Errorf("Expected a recovered destination to be forgotten, got %+v", health)
+	}
+}
@@ -0,0 +1,93 @@
+// Package geoip enriches IP addresses with their country and network
+// from local MaxMind DB files, such as the free GeoLite2 Country and ASN
+// databases. Lookups never leave the machine.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Location is what the databases know about an address
+type Location struct {
+	CountryCode  string `json:"country_code,omitempty"`
+	Country      string `json:"country,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// Known reports whether any database had the address
+func (l Location) Known() bool {
+	return l.CountryCode != "" || l.ASN != 0
+}
+
+// Databases looks addresses up in several MaxMind DBs and merges what
+// they find, so a Country or City database and an ASN database can be
+// used together
+type Databases struct {
+	readers []*Reader
+	paths   []string
+}
+
+// Load opens the MaxMind DB files at paths
+func Load(paths []string) (*Databases, error) {
+	d := &Databases{}
+	for _, path := range paths {
+		reader, err := Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		d.readers = append(d.readers, reader)
+		d.paths = append(d.paths, path)
+	}
+	return d, nil
+}
+
+// Paths returns the files the databases were loaded from
+func (d *Databases) Paths() []string {
+	if d == nil {
+		return nil
+	}
+	return d.paths
+}
+
+// Lookup returns the location of an address. It is empty for invalid,
+// private and unknown addresses, or when d is nil.
+func (d *Databases) Lookup(address string) Location {
+	var location Location
+	if d == nil {
+		return location
+	}
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return location
+	}
+
+	for _, reader := range d.readers {
+		fields, err := reader.Lookup(ip)
+		if err != nil || fields == nil {
+			continue
+		}
+		if location.CountryCode == "" {
+			// Anycast and satellite networks only have the country
+			// they are registered in
+			for _, key := range []string{"country", "registered_country"} {
+				country, _ := fields[key].(map[string]interface{})
+				if code, _ := country["iso_code"].(string); code != "" {
+					location.CountryCode = code
+					names, _ := country["names"].(map[string]interface{})
+					location.Country, _ = names["en"].(string)
+					break
+				}
+			}
+		}
+		if location.ASN == 0 {
+			if asn, ok := fields["autonomous_system_number"].(uint64); ok {
+				location.ASN = uint(asn)
+				location.Organization, _ = fields["autonomous_system_organization"].(string)
+			}
+		}
+	}
+	return location
+}
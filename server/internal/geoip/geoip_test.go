@@ -0,0 +1,188 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// encode writes a value in the MaxMind DB data format
+func encode(value interface{}) []byte {
+	// Sizes from 29 to 284 take one more byte, which is all the tests need
+	control := func(kind, size int) []byte {
+		var extra []byte
+		if size >= 29 {
+			size, extra = 29, []byte{byte(size - 29)}
+		}
+		if kind > 7 {
+			return append([]byte{byte(size), byte(kind - 7)}, extra...)
+		}
+		return append([]byte{byte(kind<<5 | size)}, extra...)
+	}
+	switch v := value.(type) {
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint64:
+		var b []byte
+		for ; v > 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		return append(control(typeUint64, len(b)), b...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out := control(typeMap, len(v))
+		for _, key := range keys {
+			out = append(out, encode(key)...)
+			out = append(out, encode(v[key])...)
+		}
+		return out
+	}
+	panic("unsupported value")
+}
+
+// network is a prefix of an address and the record stored for it
+type network struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// build writes a MaxMind DB with 24-bit records holding networks
+func build(t *testing.T, ipVersion int, networks []network) string {
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	var data []byte
+	offsets := map[int]int{}
+	for i, n := range networks {
+		_, prefix, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatalf("Invalid network %s: %v", n.cidr, err)
+		}
+		address := prefix.IP.To16()
+		ones, _ := prefix.Mask.Size()
+		if v4 := prefix.IP.To4(); v4 != nil {
+			if ipVersion == 4 {
+				address = v4
+			} else {
+				address, ones = append(make([]byte, 12), v4...), ones+96
+			}
+		}
+		offsets[i] = len(data)
+		data = append(data, encode(n.record)...)
+
+		node := 0
+		for bit := 0; bit < ones; bit++ {
+			side := int(address[bit/8] >> (7 - uint(bit%8)) & 1)
+			if bit == ones-1 {
+				nodes[node][side] = -2 - i
+				break
+			}
+			if nodes[node][side] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+	}
+
+	var file []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == empty:
+				value = len(nodes)
+			case record < empty:
+				value = len(nodes) + dataSeparator + offsets[-2-record]
+			}
+			file = append(file, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	file = append(file, make([]byte, dataSeparator)...)
+	file = append(file, data...)
+	file = append(file, metadataMarker...)
+	file = append(file, encode(map[string]interface{}{
+		"node_count":    uint64(len(nodes)),
+		"record_size":   uint64(24),
+		"ip_version":    uint64(ipVersion),
+		"database_type": "Test",
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file, 0600); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+	return path
+}
+
+func TestLookupMergesDatabases(t *testing.T) {
+	country := build(t, 6, []network{
+		{"81.2.69.0/24", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "GB", "names": map[string]interface{}{"en": "United Kingdom", "de": "Vereinigtes Königreich"}},
+		}},
+		{"2001:db8::/32", map[string]interface{}{
+			"registered_country": map[string]interface{}{"iso_code": "SE", "names": map[string]interface{}{"en": "Sweden"}},
+		}},
+	})
+	asn := build(t, 4, []network{
+		{"81.2.0.0/16", map[string]interface{}{"autonomous_system_number": uint64(64500), "autonomous_system_organization": "Example Net"}},
+	})
+
+	databases, err := Load([]string{country, asn})
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if got := databases.Lookup("81.2.69.160"); got != (Location{CountryCode: "GB", Country: "United Kingdom", ASN: 64500, Organization: "Example Net"}) {
+		t.Errorf("Expected both databases merged, got %+v", got)
+	}
+	if got := databases.Lookup("81.2.70.1"); got.CountryCode != "" || got.ASN != 64500 {
+		t.Errorf("Expected only the network for an address outside the country range, got %+v", got)
+	}
+	if got := databases.Lookup("2001:db8::1"); got.CountryCode != "SE" || got.ASN != 0 {
+		t.Errorf("Expected the registered country for IPv6, got %+v", got)
+	}
+	for _, address := range []string{"192.0.2.1", "10.0.0.1", "127.0.0.1", "not an ip", ""} {
+		if got := databases.Lookup(address); got.Known() {
+			t.Errorf("Expected nothing for %q, got %+v", address, got)
+		}
+	}
+
+	var none *Databases
+	if none.Lookup("81.2.69.160").Known() {
+		t.Error("Expected no location without databases")
+	}
+	if _, err := Load([]string{filepath.Join(t.TempDir(), "missing.mmdb")}); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+func TestDecodePointersAndExtendedTypes(t *testing.T) {
+	// A map whose value points back to a string earlier in the section,
+	// then a boolean, which is an extended type
+	data := append(encode("shared"), 0xE2)
+	data = append(data, encode("name")...)
+	data = append(data, 0x20, 0x00)
+	data = append(data, encode("flag")...)
+	data = append(data, 0x01, 0x07)
+
+	value, _, err := (&decoder{buffer: data}).decode(7, 0)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	fields, _ := value.(map[string]interface{})
+	if fields["name"] != "shared" || fields["flag"] != true {
+		t.Errorf("Expected the pointer followed and the flag set, got %v", value)
+	}
+
+	if _, err := New([]byte("not a database")); err == nil {
+		t.Error("Expected a file without metadata to fail")
+	}
+	if _, _, err := (&decoder{buffer: []byte{0x5F}}).decode(0, 0); err == nil {
+		t.Error("Expected truncated data to fail")
+	}
+}
@@ -0,0 +1,331 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSeparator is the gap between the search tree and the data section
+const dataSeparator = 16
+
+// ErrInvalidDatabase is returned for files that are not MaxMind DBs
+var ErrInvalidDatabase = errors.New("not a MaxMind DB file")
+
+// Metadata describes a MaxMind DB
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	NodeCount    uint
+	RecordSize   int
+	BuildEpoch   uint64
+}
+
+// Reader looks up addresses in one MaxMind DB file, such as GeoLite2
+// Country, City or ASN. The file is read into memory once and never
+// changes, so a Reader is safe for concurrent use.
+type Reader struct {
+	Metadata Metadata
+	tree     []byte
+	data     []byte
+	ipv4Node uint
+}
+
+// Open reads a MaxMind DB file
+func Open(path string) (*Reader, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(buffer)
+}
+
+// New reads a MaxMind DB from its contents
+func New(buffer []byte) (*Reader, error) {
+	start := bytes.LastIndex(buffer, metadataMarker)
+	if start < 0 {
+		return nil, ErrInvalidDatabase
+	}
+	decoded, _, err := (&decoder{buffer: buffer[start+len(metadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	fields, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	number := func(key string) uint64 {
+		value, _ := fields[key].(uint64)
+		return value
+	}
+	metadata := Metadata{
+		IPVersion:  int(number("ip_version")),
+		NodeCount:  uint(number("node_count")),
+		RecordSize: int(number("record_size")),
+		BuildEpoch: number("build_epoch"),
+	}
+	metadata.DatabaseType, _ = fields["database_type"].(string)
+	if metadata.RecordSize != 24 && metadata.RecordSize != 28 && metadata.RecordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidDatabase, metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", ErrInvalidDatabase, metadata.IPVersion)
+	}
+
+	treeSize := metadata.NodeCount * uint(metadata.RecordSize) / 4
+	if treeSize+dataSeparator > uint(start) {
+		return nil, fmt.Errorf("%w: search tree is larger than the file", ErrInvalidDatabase)
+	}
+	r := &Reader{
+		Metadata: metadata,
+		tree:     buffer[:treeSize],
+		data:     buffer[treeSize+dataSeparator : start],
+	}
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree
+	if metadata.IPVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Node < metadata.NodeCount; i++ {
+			r.ipv4Node = r.record(r.ipv4Node, 0)
+		}
+	}
+	return r, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a node
+func (r *Reader) record(node uint, bit int) uint {
+	switch r.Metadata.RecordSize {
+	case 24:
+		b := r.tree[node*6+uint(bit)*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+uint(bit)*4:]))
+	}
+}
+
+// Lookup returns the record for an address, or nil when the database has
+// none
+func (r *Reader) Lookup(ip net.IP) (map[string]interface{}, error) {
+	address, node := ip.To4(), uint(0)
+	switch {
+	case address != nil && r.Metadata.IPVersion == 6:
+		node = r.ipv4Node
+	case address == nil && r.Metadata.IPVersion == 4:
+		return nil, nil
+	case address == nil:
+		address = ip.To16()
+		if address == nil {
+			return nil, fmt.Errorf("invalid IP %q", ip)
+		}
+	}
+
+	for i := 0; i < len(address)*8 && node < r.Metadata.NodeCount; i++ {
+		node = r.record(node, int(address[i/8]>>(7-uint(i%8))&1))
+	}
+	if node == r.Metadata.NodeCount {
+		return nil, nil
+	}
+	if node < r.Metadata.NodeCount {
+		return nil, errors.New("search tree is deeper than the address")
+	}
+
+	offset := node - r.Metadata.NodeCount - dataSeparator
+	value, _, err := (&decoder{buffer: r.data}).decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := value.(map[string]interface{})
+	return fields, nil
+}
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting, so a corrupt file cannot recurse forever
+const maxDepth = 32
+
+// decoder reads values from a data section
+type decoder struct {
+	buffer []byte
+}
+
+func (d *decoder) byteAt(offset uint) (byte, error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, errors.New("unexpected end of data")
+	}
+	return d.buffer[offset], nil
+}
+
+func (d *decoder) slice(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buffer)) || offset+size < offset {
+		return nil, errors.New("unexpected end of data")
+	}
+	return d.buffer[offset : offset+size], nil
+}
+
+// decode reads the value at offset and returns it with the offset after it.
+// Maps become map[string]interface{}, arrays []interface{}, unsigned
+// integers uint64, signed ones int64 and 128-bit ones *big.Int.
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	control, err := d.byteAt(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	kind := int(control >> 5)
+	if kind == typePointer {
+		return d.decodePointer(control, offset, depth)
+	}
+	if kind == typeExtended {
+		next, err := d.byteAt(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind, offset = 7+int(next), offset+1
+	}
+
+	size := uint(control & 0x1F)
+	if size >= 29 {
+		extra := size - 28
+		b, err := d.slice(offset, extra)
+		if err != nil {
+			return nil, 0, err
+		}
+		var n uint
+		for _, c := range b {
+			n = n<<8 | uint(c)
+		}
+		size, offset = []uint{29, 285, 65821}[extra-1]+n, offset+extra
+	}
+
+	switch kind {
+	case typeMap:
+		fields := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			fields[name], offset = value, next
+		}
+		return fields, offset, nil
+	case typeArray:
+		values := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values, offset = append(values, value), next
+		}
+		return values, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := d.slice(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double is not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float is not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("integer is too long")
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("integer is too long")
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+// decodePointer follows a pointer to a value stored once and shared
+func (d *decoder) decodePointer(control byte, offset uint, depth int) (interface{}, uint, error) {
+	size := uint(control>>3&0x3) + 1
+	b, err := d.slice(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	var target uint
+	if size < 4 {
+		target = uint(control & 0x7)
+	}
+	for _, c := range b {
+		target = target<<8 | uint(c)
+	}
+	target += []uint{0, 2048, 526336, 0}[size-1]
+
+	value, _, err := d.decode(target, depth+1)
+	return value, offset + size, err
+}
@@ -0,0 +1,347 @@
+// Package ics parses iCalendar (RFC 5545) feeds into concrete event
+// occurrences. It covers what public calendars use in practice: time
+// zones, all-day events, RRULE recurrence, EXDATE and modified instances.
+package ics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Feeds name their zones by TZID; the container image has no zoneinfo
+	_ "time/tzdata"
+)
+
+// ErrNotCalendar is returned when data is not an iCalendar feed
+var ErrNotCalendar = errors.New("not an iCalendar feed")
+
+// maxOccurrences bounds how many occurrences one recurring event expands to
+const maxOccurrences = 1000
+
+// Event is one occurrence of a calendar event
+type Event struct {
+	UID         string    `json:"uid"`
+	Summary     string    `json:"summary"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AllDay      bool      `json:"all_day"`
+}
+
+// Key identifies an occurrence; recurring events share a UID
+func (e Event) Key() string {
+	return e.UID + "@" + strconv.FormatInt(e.Start.Unix(), 10)
+}
+
+// Calendar is a parsed feed
+type Calendar struct {
+	Name   string
+	events []*vevent
+}
+
+// vevent is a VEVENT component before recurrence expansion
+type vevent struct {
+	Event
+	duration     time.Duration
+	rule         *rule
+	exdates      map[int64]bool
+	recurrenceID time.Time
+	cancelled    bool
+}
+
+// property is one content line, e.g. DTSTART;TZID=Europe/Berlin:20250102T100000
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads an iCalendar feed
+func Parse(data []byte) (*Calendar, error) {
+	lines := unfold(data)
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	calendar := &Calendar{}
+	var current *vevent
+	depth := 0 // nesting inside a VEVENT, e.g. VALARM
+	for _, line := range lines {
+		prop, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			current = &vevent{exdates: map[int64]bool{}}
+			depth = 0
+			continue
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if current != nil && current.UID != "" && !current.Start.IsZero() {
+				current.finish()
+				calendar.events = append(calendar.events, current)
+			}
+			current = nil
+			continue
+		case current != nil && prop.name == "BEGIN":
+			depth++
+			continue
+		case current != nil && prop.name == "END":
+			depth--
+			continue
+		}
+
+		if current == nil {
+			if prop.name == "X-WR-CALNAME" {
+				calendar.Name = unescape(prop.value)
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		if err := current.set(prop); err != nil {
+			return nil, fmt.Errorf("event %q: %w", current.UID, err)
+		}
+	}
+	return calendar, nil
+}
+
+// unfold joins continuation lines, which start with a space or tab
+func unfold(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseLine splits a content line into name, parameters and value
+func parseLine(line string) (property, bool) {
+	// The value starts at the first colon outside a quoted parameter
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := property{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return prop, true
+}
+
+// unescape decodes TEXT values
+func unescape(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+func (e *vevent) set(prop property) error {
+	switch prop.name {
+	case "UID":
+		e.UID = prop.value
+	case "SUMMARY":
+		e.Summary = unescape(prop.value)
+	case "DESCRIPTION":
+		e.Description = unescape(prop.value)
+	case "LOCATION":
+		e.Location = unescape(prop.value)
+	case "URL":
+		e.URL = prop.value
+	case "STATUS":
+		e.cancelled = strings.EqualFold(prop.value, "CANCELLED")
+	case "DTSTART":
+		start, allDay, err := parseTime(prop)
+		if err != nil {
+			return err
+		}
+		e.Start, e.AllDay = start, allDay
+	case "DTEND":
+		end, _, err := parseTime(prop)
+		if err != nil {
+			return err
+		}
+		e.End = end
+	case "DURATION":
+		duration, err := parseDuration(prop.value)
+		if err != nil {
+			return err
+		}
+		e.duration = duration
+	case "RRULE":
+		r, err := parseRule(prop.value)
+		if err != nil {
+			return err
+		}
+		e.rule = r
+	case "EXDATE":
+		for _, value := range strings.Split(prop.value, ",") {
+			exdate, _, err := parseTime(property{params: prop.params, value: value})
+			if err != nil {
+				return err
+			}
+			e.exdates[exdate.Unix()] = true
+		}
+	case "RECURRENCE-ID":
+		id, _, err := parseTime(prop)
+		if err != nil {
+			return err
+		}
+		e.recurrenceID = id
+	}
+	return nil
+}
+
+// finish derives the duration from DTEND or the defaults
+func (e *vevent) finish() {
+	switch {
+	case !e.End.IsZero() && e.End.After(e.Start):
+		e.duration = e.End.Sub(e.Start)
+	case e.duration == 0 && e.AllDay:
+		e.duration = 24 * time.Hour
+	}
+	e.End = e.Start.Add(e.duration)
+}
+
+// parseTime reads DATE and DATE-TIME values. Times without a zone are
+// treated as UTC; unknown TZIDs (such as Windows zone names) fall back
+// to UTC too.
+func parseTime(prop property) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.UTC)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	location := time.UTC
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if loaded, err := time.LoadLocation(tzid); err == nil {
+			location = loaded
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	return t, false, err
+}
+
+// parseDuration reads values such as PT1H30M, P1D or -PT15M
+func parseDuration(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+	}
+	value = strings.TrimLeft(value, "+-")
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var total time.Duration
+	number := 0
+	for _, r := range value[1:] {
+		if r >= '0' && r <= '9' {
+			number = number*10 + int(r-'0')
+			continue
+		}
+		switch r {
+		case 'W':
+			total += time.Duration(number) * 7 * 24 * time.Hour
+		case 'D':
+			total += time.Duration(number) * 24 * time.Hour
+		case 'H':
+			total += time.Duration(number) * time.Hour
+		case 'M':
+			total += time.Duration(number) * time.Minute
+		case 'S':
+			total += time.Duration(number) * time.Second
+		case 'T':
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = 0
+	}
+	return sign * total, nil
+}
+
+// Occurrences returns the occurrences starting in [from, to), ordered by
+// start time. Cancelled events and excluded dates are left out, and
+// modified instances replace the occurrence they override.
+func (c *Calendar) Occurrences(from, to time.Time) []Event {
+	overrides := make(map[string]*vevent)
+	for _, e := range c.events {
+		if !e.recurrenceID.IsZero() {
+			overrides[e.UID+"@"+strconv.FormatInt(e.recurrenceID.Unix(), 10)] = e
+		}
+	}
+
+	var events []Event
+	add := func(e *vevent, start time.Time) {
+		if e.cancelled || start.Before(from) || !start.Before(to) {
+			return
+		}
+		occurrence := e.Event
+		occurrence.Start = start
+		occurrence.End = start.Add(e.duration)
+		events = append(events, occurrence)
+	}
+
+	for _, e := range c.events {
+		if !e.recurrenceID.IsZero() {
+			// A moved instance replaces the original occurrence
+			add(e, e.Start)
+			continue
+		}
+		if e.rule == nil {
+			add(e, e.Start)
+			continue
+		}
+		for _, start := range e.rule.expand(e.Start, from, to) {
+			if e.exdates[start.Unix()] {
+				continue
+			}
+			if _, overridden := overrides[e.UID+"@"+strconv.FormatInt(start.Unix(), 10)]; overridden {
+				continue
+			}
+			add(e, start)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
@@ -0,0 +1,111 @@
+package ics
+
+import (
+	"testing"
+	"time"
+)
+
+const feed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"X-WR-CALNAME:Community\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:game-night\r\n" +
+	"SUMMARY:Game night\\, online\r\n" +
+	"DESCRIPTION:Bring snacks.\\nVoice channel opens early.\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20250106T200000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20250106T220000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=4\r\n" +
+	"EXDATE;TZID=Europe/Berlin:20250113T200000\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Alarm text\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:game-night\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Berlin:20250120T200000\r\n" +
+	"SUMMARY:Game night (moved)\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20250121T200000\r\n" +
+	"DURATION:PT2H\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meetup\r\n" +
+	"SUMMARY:Monthly meetup with a very long title that is folded across\r\n" +
+	"  two lines\r\n" +
+	"DTSTART:20250101T180000Z\r\n" +
+	"RRULE:FREQ=MONTHLY;BYDAY=-1FR\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20250201\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:cancelled\r\n" +
+	"SUMMARY:Cancelled\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART:20250110T180000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestOccurrences(t *testing.T) {
+	calendar, err := Parse([]byte(feed))
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if calendar.Name != "Community" {
+		t.Errorf("Unexpected calendar name %q", calendar.Name)
+	}
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := calendar.Occurrences(from, from.AddDate(0, 2, 0))
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Start.UTC().Format("2006-01-02 15:04")+" "+event.Summary)
+	}
+	want := []string{
+		"2025-01-06 19:00 Game night, online",
+		"2025-01-21 19:00 Game night (moved)",
+		"2025-01-27 19:00 Game night, online",
+		"2025-01-31 18:00 Monthly meetup with a very long title that is folded across two lines",
+		"2025-02-01 00:00 Holiday",
+		"2025-02-28 18:00 Monthly meetup with a very long title that is folded across two lines",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d occurrences, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Occurrence %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	first := events[0]
+	if first.End.Sub(first.Start) != 2*time.Hour || first.Description != "Bring snacks.\nVoice channel opens early." {
+		t.Errorf("Unexpected first occurrence %+v", first)
+	}
+	if !events[4].AllDay || events[4].End.Sub(events[4].Start) != 24*time.Hour {
+		t.Errorf("Expected an all-day event, got %+v", events[4])
+	}
+	if events[0].Key() == events[2].Key() {
+		t.Error("Expected occurrences of a recurring event to have distinct keys")
+	}
+}
+
+func TestOccurrencesSkipToWindow(t *testing.T) {
+	calendar, err := Parse([]byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:standup\nDTSTART:20000103T090000Z\nRRULE:FREQ=DAILY\nEND:VEVENT\nEND:VCALENDAR\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	from := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	events := calendar.Occurrences(from, from.AddDate(0, 0, 7))
+	if len(events) != 7 || !events[0].Start.Equal(time.Date(2030, 6, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected occurrences of an old daily event: %+v", events)
+	}
+}
+
+func TestParseRejectsOtherData(t *testing.T) {
+	if _, err := Parse([]byte("<html>not a calendar</html>")); err != ErrNotCalendar {
+		t.Errorf("Expected ErrNotCalendar, got %v", err)
+	}
+}
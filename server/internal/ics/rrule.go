@@ -0,0 +1,311 @@
+package ics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rule is a parsed RRULE. BYDAY entries carry an ordinal for monthly and
+// yearly rules ("2TU" is the second Tuesday, "-1FR" the last Friday).
+type rule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+}
+
+type weekdayNum struct {
+	ordinal int
+	weekday time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRule(value string) (*rule, error) {
+	r := &rule{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(val)
+		case "INTERVAL":
+			interval, err := strconv.Atoi(val)
+			if err != nil || interval < 1 {
+				return nil, fmt.Errorf("invalid RRULE interval %q", val)
+			}
+			r.interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(val)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid RRULE count %q", val)
+			}
+			r.count = count
+		case "UNTIL":
+			until, _, err := parseTime(property{value: val})
+			if err != nil {
+				return nil, fmt.Errorf("invalid RRULE until %q", val)
+			}
+			r.until = until
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				day = strings.ToUpper(strings.TrimSpace(day))
+				if len(day) < 2 {
+					return nil, fmt.Errorf("invalid RRULE day %q", day)
+				}
+				weekday, ok := weekdays[day[len(day)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid RRULE day %q", day)
+				}
+				ordinal := 0
+				if prefix := day[:len(day)-2]; prefix != "" {
+					n, err := strconv.Atoi(prefix)
+					if err != nil {
+						return nil, fmt.Errorf("invalid RRULE day %q", day)
+					}
+					ordinal = n
+				}
+				r.byDay = append(r.byDay, weekdayNum{ordinal: ordinal, weekday: weekday})
+			}
+		case "BYMONTHDAY":
+			days, err := parseInts(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RRULE month day %q", val)
+			}
+			r.byMonthDay = days
+		case "BYMONTH":
+			months, err := parseInts(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RRULE month %q", val)
+			}
+			r.byMonth = months
+		}
+	}
+
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported RRULE frequency %q", r.freq)
+}
+
+func parseInts(value string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// maxPeriods bounds the periods walked for one rule
+const maxPeriods = 50000
+
+// expand returns the rule's occurrence starts in [from, end). COUNT is
+// applied from dtstart, so occurrences before the window still count;
+// without COUNT, periods before the window are skipped.
+func (r *rule) expand(dtstart, from, end time.Time) []time.Time {
+	var starts []time.Time
+	emitted := 0
+	first := 0
+	if r.count == 0 && from.After(dtstart) {
+		first = r.periodsBefore(dtstart, from)
+	}
+	for period := first; period < first+maxPeriods; period++ {
+		candidates := r.period(dtstart, period)
+		if len(candidates) == 0 && r.periodStart(dtstart, period).After(end) {
+			break
+		}
+		for _, candidate := range candidates {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && candidate.After(r.until) {
+				return starts
+			}
+			if !candidate.Before(end) {
+				return starts
+			}
+			emitted++
+			if r.count > 0 && emitted > r.count {
+				return starts
+			}
+			if candidate.Before(from) {
+				continue
+			}
+			starts = append(starts, candidate)
+			if len(starts) >= maxOccurrences {
+				return starts
+			}
+		}
+	}
+	return starts
+}
+
+// periodsBefore returns a period index safely before the one containing
+// t, so expansion can start there
+func (r *rule) periodsBefore(dtstart, t time.Time) int {
+	var periods int
+	switch r.freq {
+	case "DAILY":
+		periods = int(t.Sub(dtstart).Hours() / 24)
+	case "WEEKLY":
+		periods = int(t.Sub(dtstart).Hours() / (24 * 7))
+	case "MONTHLY":
+		periods = (t.Year()-dtstart.Year())*12 + int(t.Month()-dtstart.Month())
+	default:
+		periods = t.Year() - dtstart.Year()
+	}
+	if periods = periods/r.interval - 1; periods < 0 {
+		return 0
+	}
+	return periods
+}
+
+// periodStart returns the first day of the nth period
+func (r *rule) periodStart(dtstart time.Time, n int) time.Time {
+	step := n * r.interval
+	switch r.freq {
+	case "DAILY":
+		return dtstart.AddDate(0, 0, step)
+	case "WEEKLY":
+		// Weeks start on Monday (the RFC default WKST)
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		return dtstart.AddDate(0, 0, step*7-offset)
+	case "MONTHLY":
+		return time.Date(dtstart.Year(), dtstart.Month()+time.Month(step), 1, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+	default:
+		return time.Date(dtstart.Year()+step, 1, 1, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+	}
+}
+
+// period returns the candidate starts in the nth period, in order
+func (r *rule) period(dtstart time.Time, n int) []time.Time {
+	start := r.periodStart(dtstart, n)
+	var candidates []time.Time
+
+	switch r.freq {
+	case "DAILY":
+		if r.matchesMonth(start.Month()) && r.matchesWeekday(start.Weekday()) {
+			candidates = append(candidates, start)
+		}
+
+	case "WEEKLY":
+		days := r.byDay
+		if len(days) == 0 {
+			days = []weekdayNum{{weekday: dtstart.Weekday()}}
+		}
+		for _, day := range days {
+			offset := (int(day.weekday) + 6) % 7
+			candidate := start.AddDate(0, 0, offset)
+			if r.matchesMonth(candidate.Month()) {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+	case "MONTHLY":
+		if r.matchesMonth(start.Month()) {
+			candidates = r.monthDays(start, dtstart)
+		}
+
+	case "YEARLY":
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []int{int(dtstart.Month())}
+		}
+		for _, month := range months {
+			first := time.Date(start.Year(), time.Month(month), 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+			candidates = append(candidates, r.monthDays(first, dtstart)...)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+	return candidates
+}
+
+// monthDays returns the days in the month starting at first selected by
+// BYDAY or BYMONTHDAY, or dtstart's day of the month
+func (r *rule) monthDays(first, dtstart time.Time) []time.Time {
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	var days []int
+
+	switch {
+	case len(r.byDay) > 0:
+		for _, day := range r.byDay {
+			var matching []int
+			for d := 1; d <= daysInMonth; d++ {
+				if first.AddDate(0, 0, d-1).Weekday() == day.weekday {
+					matching = append(matching, d)
+				}
+			}
+			switch {
+			case day.ordinal == 0:
+				days = append(days, matching...)
+			case day.ordinal > 0 && day.ordinal <= len(matching):
+				days = append(days, matching[day.ordinal-1])
+			case day.ordinal < 0 && -day.ordinal <= len(matching):
+				days = append(days, matching[len(matching)+day.ordinal])
+			}
+		}
+	case len(r.byMonthDay) > 0:
+		for _, d := range r.byMonthDay {
+			if d < 0 {
+				d = daysInMonth + d + 1
+			}
+			if d >= 1 && d <= daysInMonth {
+				days = append(days, d)
+			}
+		}
+	default:
+		// Months without this day (e.g. the 31st) are skipped
+		if dtstart.Day() <= daysInMonth {
+			days = append(days, dtstart.Day())
+		}
+	}
+
+	candidates := make([]time.Time, 0, len(days))
+	for _, d := range days {
+		candidates = append(candidates, first.AddDate(0, 0, d-1))
+	}
+	return candidates
+}
+
+func (r *rule) matchesMonth(month time.Month) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, m := range r.byMonth {
+		if time.Month(m) == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesWeekday(weekday time.Weekday) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, day := range r.byDay {
+		if day.weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
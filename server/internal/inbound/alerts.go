@@ -0,0 +1,240 @@
+package inbound
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Alert statuses
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// maxEmbeds is how many embeds one message carries
+const maxEmbeds = 10
+
+// Alert is one alert from an alerting webhook. The receiver uses the
+// fingerprint to drop repeats and to pair resolve notifications with the
+// message that announced the alert.
+type Alert struct {
+	Fingerprint string
+	Status      string
+	Name        string
+	Embed       Embed
+}
+
+// alertPayload is the Alertmanager webhook body. Grafana sends the same
+// shape with a few extra fields.
+type alertPayload struct {
+	Status      string            `json:"status"`
+	ExternalURL string            `json:"externalURL"`
+	CommonLabel map[string]string `json:"commonLabels"`
+	Alerts      []struct {
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		StartsAt     string            `json:"startsAt"`
+		EndsAt       string            `json:"endsAt"`
+		GeneratorURL string            `json:"generatorURL"`
+		Fingerprint  string            `json:"fingerprint"`
+		SilenceURL   string            `json:"silenceURL"`   // Grafana
+		DashboardURL string            `json:"dashboardURL"` // Grafana
+		ValueString  string            `json:"valueString"`  // Grafana
+	} `json:"alerts"`
+}
+
+// alertmanagerFormatter renders Prometheus Alertmanager notifications.
+// Alertmanager authenticates with http_config, so the secret is expected
+// as a bearer token.
+type alertmanagerFormatter struct{}
+
+func (alertmanagerFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	return bearerMatches(header, secret)
+}
+
+func (alertmanagerFormatter) Format(header http.Header, body []byte) (*Message, error) {
+	return formatAlerts(body, false)
+}
+
+// grafanaFormatter renders Grafana alerting notifications. Grafana can
+// send the secret as an Authorization header or sign the body with it.
+type grafanaFormatter struct{}
+
+func (grafanaFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	if signature := header.Get("X-Grafana-Alerting-Signature"); signature != "" {
+		return subtle.ConstantTimeCompare([]byte(signature), []byte(hmacSHA256(secret, body))) == 1
+	}
+	return bearerMatches(header, secret)
+}
+
+func (grafanaFormatter) Format(header http.Header, body []byte) (*Message, error) {
+	return formatAlerts(body, true)
+}
+
+// bearerMatches checks an Authorization header of "Bearer <secret>"
+func bearerMatches(header http.Header, secret string) bool {
+	token := strings.TrimPrefix(header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+func formatAlerts(body []byte, grafana bool) (*Message, error) {
+	var p alertPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	if len(p.Alerts) == 0 {
+		return nil, ErrIgnored
+	}
+
+	alerts := make([]Alert, 0, len(p.Alerts))
+	for _, a := range p.Alerts {
+		status := AlertFiring
+		if a.Status == AlertResolved {
+			status = AlertResolved
+		}
+		name := a.Labels["alertname"]
+		if name == "" {
+			name = "Alert"
+		}
+		fingerprint := a.Fingerprint
+		if fingerprint == "" {
+			fingerprint = labelsFingerprint(a.Labels)
+		}
+
+		embed := Embed{
+			Title:       "[" + strings.ToUpper(status) + "] " + name,
+			URL:         a.GeneratorURL,
+			Description: truncate(firstNonEmpty(a.Annotations["summary"], a.Annotations["description"], a.Annotations["message"]), 500),
+			Color:       severityColor(status, a.Labels["severity"]),
+			Timestamp:   a.StartsAt,
+		}
+		for _, key := range sortedKeys(a.Labels) {
+			if key == "alertname" || len(embed.Fields) == 8 {
+				continue
+			}
+			embed.Fields = append(embed.Fields, Field{Name: key, Value: truncate(a.Labels[key], 100), Inline: true})
+		}
+		if a.ValueString != "" && status == AlertFiring {
+			embed.Fields = append(embed.Fields, Field{Name: "Values", Value: truncate(a.ValueString, 200)})
+		}
+
+		var links []string
+		if a.DashboardURL != "" {
+			links = append(links, "[Dashboard]("+a.DashboardURL+")")
+		}
+		if status == AlertFiring {
+			silence := a.SilenceURL
+			if silence == "" && !grafana && p.ExternalURL != "" {
+				silence = silenceURL(p.ExternalURL, a.Labels)
+			}
+			if silence != "" {
+				links = append(links, "[Silence]("+silence+")")
+			}
+		}
+		if len(links) > 0 {
+			embed.Fields = append(embed.Fields, Field{Name: "Links", Value: strings.Join(links, " · ")})
+		}
+		if status == AlertResolved && a.EndsAt != "" {
+			embed.Timestamp = a.EndsAt
+		}
+
+		alerts = append(alerts, Alert{Fingerprint: fingerprint, Status: status, Name: name, Embed: embed})
+	}
+
+	message := AlertMessage(alerts)
+	message.Alerts = alerts
+	return message, nil
+}
+
+// AlertMessage renders alerts as one message, summarizing counts in the
+// content and keeping the first alerts as embeds
+func AlertMessage(alerts []Alert) *Message {
+	var firing, resolved []string
+	embeds := make([]Embed, 0, maxEmbeds)
+	for _, alert := range alerts {
+		if alert.Status == AlertResolved {
+			resolved = append(resolved, alert.Name)
+		} else {
+			firing = append(firing, alert.Name)
+		}
+		if len(embeds) < maxEmbeds {
+			embeds = append(embeds, alert.Embed)
+		}
+	}
+
+	var parts []string
+	if len(firing) > 0 {
+		parts = append(parts, fmt.Sprintf("[FIRING:%d] %s", len(firing), strings.Join(uniqueNames(firing), ", ")))
+	}
+	if len(resolved) > 0 {
+		parts = append(parts, fmt.Sprintf("[RESOLVED:%d] %s", len(resolved), strings.Join(uniqueNames(resolved), ", ")))
+	}
+	content := strings.Join(parts, " ")
+	if hidden := len(alerts) - len(embeds); hidden > 0 {
+		content += fmt.Sprintf(" (%d more not shown)", hidden)
+	}
+	return &Message{Content: truncate(content, 2000), Embeds: embeds}
+}
+
+// severityColor maps the conventional severity label to an embed color
+func severityColor(status, severity string) int {
+	if status == AlertResolved {
+		return ColorGreen
+	}
+	switch strings.ToLower(severity) {
+	case "warning", "warn":
+		return ColorOrange
+	case "info", "informational", "none":
+		return ColorBlue
+	}
+	return ColorRed
+}
+
+// silenceURL links to a prefilled Alertmanager silence for the labels
+func silenceURL(externalURL string, labels map[string]string) string {
+	matchers := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", key, labels[key]))
+	}
+	filter := "{" + strings.Join(matchers, ",") + "}"
+	return strings.TrimSuffix(externalURL, "/") + "/#/silences/new?filter=" + url.QueryEscape(filter)
+}
+
+// labelsFingerprint identifies an alert by its label set when the sender
+// does not provide a fingerprint
+func labelsFingerprint(labels map[string]string) string {
+	hash := sha256.New()
+	for _, key := range sortedKeys(labels) {
+		hash.Write([]byte(key + "\x00" + labels[key] + "\x00"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,361 @@
+package inbound
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// githubPayload covers the fields of GitHub events that are rendered.
+// Gitea sends the same shapes with a few renamed fields.
+type githubPayload struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	Compare    string `json:"compare"`
+	CompareURL string `json:"compare_url"` // Gitea
+	Number     int    `json:"number"`
+	Zen        string `json:"zen"`
+	Commits    []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender githubUser `json:"sender"`
+	Pusher struct {
+		Name     string `json:"name"`
+		Login    string `json:"login"`    // Gitea
+		Username string `json:"username"` // Gitea
+	} `json:"pusher"`
+	PullRequest *struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		HTMLURL string     `json:"html_url"`
+		Body    string     `json:"body"`
+		Merged  bool       `json:"merged"`
+		User    githubUser `json:"user"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		HTMLURL string     `json:"html_url"`
+		Body    string     `json:"body"`
+		User    githubUser `json:"user"`
+	} `json:"issue"`
+	Comment *struct {
+		HTMLURL string     `json:"html_url"`
+		Body    string     `json:"body"`
+		User    githubUser `json:"user"`
+	} `json:"comment"`
+	Release *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+		Body    string `json:"body"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+		HeadBranch string `json:"head_branch"`
+	} `json:"workflow_run"`
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+// githubFormatter renders GitHub webhooks; events come from X-GitHub-Event
+type githubFormatter struct{}
+
+func (githubFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	expected := "sha256=" + hmacSHA256(secret, body)
+	return subtle.ConstantTimeCompare([]byte(header.Get("X-Hub-Signature-256")), []byte(expected)) == 1
+}
+
+func (githubFormatter) Format(header http.Header, body []byte) (*Message, error) {
+	return formatGitHubEvent(header.Get("X-GitHub-Event"), body)
+}
+
+// giteaFormatter renders Gitea and Forgejo webhooks
+type giteaFormatter struct{}
+
+func (giteaFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitea-Signature")), []byte(hmacSHA256(secret, body))) == 1
+}
+
+func (giteaFormatter) Format(header http.Header, body []byte) (*Message, error) {
+	event := header.Get("X-Gitea-Event")
+	if event == "" {
+		event = header.Get("X-Forgejo-Event")
+	}
+	return formatGitHubEvent(event, body)
+}
+
+// formatGitHubEvent renders the GitHub-compatible events
+func formatGitHubEvent(event string, body []byte) (*Message, error) {
+	var p githubPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	repo := p.Repository.FullName
+
+	switch event {
+	case "ping":
+		return &Message{Content: "Webhook connected for " + repo}, nil
+
+	case "push":
+		if len(p.Commits) == 0 {
+			return nil, ErrIgnored
+		}
+		pusher := firstNonEmpty(p.Pusher.Name, p.Pusher.Login, p.Pusher.Username, p.Sender.Login)
+		compare := firstNonEmpty(p.Compare, p.CompareURL)
+		commits := make([]commitLine, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, commitLine{ID: c.ID, Message: c.Message, URL: c.URL, Author: c.Author.Name})
+		}
+		return pushEmbed(repo, p.Ref, compare, pusher, commits, len(commits)), nil
+
+	case "pull_request":
+		pr := p.PullRequest
+		if pr == nil {
+			return nil, ErrIgnored
+		}
+		action, color := p.Action, ColorGreen
+		switch {
+		case action == "closed" && pr.Merged:
+			action, color = "merged", ColorPurple
+		case action == "closed":
+			color = ColorRed
+		case action == "opened", action == "reopened", action == "ready_for_review":
+		default:
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("Pull request %s: #%d %s", action, pr.Number, pr.Title)
+		return itemEmbed(repo, title+" by "+p.Sender.Login, title, pr.HTMLURL, p.Sender.Login, openedBody(action, pr.Body), color), nil
+
+	case "issues":
+		issue := p.Issue
+		if issue == nil {
+			return nil, ErrIgnored
+		}
+		color := ColorGreen
+		switch p.Action {
+		case "closed":
+			color = ColorRed
+		case "opened", "reopened":
+		default:
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("Issue %s: #%d %s", p.Action, issue.Number, issue.Title)
+		return itemEmbed(repo, title+" by "+p.Sender.Login, title, issue.HTMLURL, p.Sender.Login, openedBody(p.Action, issue.Body), color), nil
+
+	case "issue_comment":
+		if p.Action != "created" || p.Issue == nil || p.Comment == nil {
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("New comment on #%d %s", p.Issue.Number, p.Issue.Title)
+		return itemEmbed(repo, title+" by "+p.Comment.User.Login, title, p.Comment.HTMLURL, p.Comment.User.Login, p.Comment.Body, ColorGray), nil
+
+	case "release":
+		if p.Action != "published" || p.Release == nil {
+			return nil, ErrIgnored
+		}
+		name := firstNonEmpty(p.Release.Name, p.Release.TagName)
+		title := "Release published: " + name
+		return itemEmbed(repo, title, title, p.Release.HTMLURL, p.Sender.Login, p.Release.Body, ColorBlue), nil
+
+	case "workflow_run":
+		run := p.WorkflowRun
+		if p.Action != "completed" || run == nil {
+			return nil, ErrIgnored
+		}
+		color := ColorRed
+		switch run.Conclusion {
+		case "success":
+			color = ColorGreen
+		case "cancelled", "skipped", "neutral":
+			color = ColorGray
+		}
+		title := fmt.Sprintf("%s %s on %s", run.Name, run.Conclusion, run.HeadBranch)
+		return itemEmbed(repo, title, title, run.HTMLURL, p.Sender.Login, "", color), nil
+	}
+	return nil, ErrIgnored
+}
+
+// openedBody only shows descriptions when something is opened
+func openedBody(action, text string) string {
+	if action == "opened" {
+		return text
+	}
+	return ""
+}
+
+// gitlabPayload covers the fields of GitLab events that are rendered
+type gitlabPayload struct {
+	ObjectKind        string `json:"object_kind"`
+	Ref               string `json:"ref"`
+	Before            string `json:"before"`
+	After             string `json:"after"`
+	UserName          string `json:"user_name"`
+	TotalCommitsCount int    `json:"total_commits_count"`
+	User              struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	ObjectAttributes struct {
+		ID           int    `json:"id"`
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		Description  string `json:"description"`
+		URL          string `json:"url"`
+		Action       string `json:"action"`
+		State        string `json:"state"`
+		Status       string `json:"status"`
+		Ref          string `json:"ref"`
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	Issue *struct {
+		IID   int    `json:"iid"`
+		Title string `json:"title"`
+	} `json:"issue"`
+	MergeRequest *struct {
+		IID   int    `json:"iid"`
+		Title string `json:"title"`
+	} `json:"merge_request"`
+	// Release hooks carry these at the top level
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	Tag         string `json:"tag"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+// gitlabFormatter renders GitLab webhooks, keyed on object_kind
+type gitlabFormatter struct{}
+
+func (gitlabFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+}
+
+func (gitlabFormatter) Format(header http.Header, data []byte) (*Message, error) {
+	var p gitlabPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	repo := p.Project.PathWithNamespace
+	attrs := p.ObjectAttributes
+	author := firstNonEmpty(p.User.Username, p.UserName)
+
+	switch p.ObjectKind {
+	case "push", "tag_push":
+		if len(p.Commits) == 0 {
+			return nil, ErrIgnored
+		}
+		compare := p.Project.WebURL + "/-/compare/" + p.Before + "..." + p.After
+		commits := make([]commitLine, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, commitLine{ID: c.ID, Message: c.Message, URL: c.URL, Author: c.Author.Name})
+		}
+		return pushEmbed(repo, p.Ref, compare, p.UserName, commits, p.TotalCommitsCount), nil
+
+	case "merge_request":
+		action, color := attrs.Action, ColorGreen
+		switch action {
+		case "open":
+			action = "opened"
+		case "reopen":
+			action = "reopened"
+		case "close":
+			action, color = "closed", ColorRed
+		case "merge":
+			action, color = "merged", ColorPurple
+		default:
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("Merge request %s: !%d %s", action, attrs.IID, attrs.Title)
+		return itemEmbed(repo, title+" by "+author, title, attrs.URL, author, openedBody(action, attrs.Description), color), nil
+
+	case "issue":
+		action, color := attrs.Action, ColorGreen
+		switch action {
+		case "open":
+			action = "opened"
+		case "reopen":
+			action = "reopened"
+		case "close":
+			action, color = "closed", ColorRed
+		default:
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("Issue %s: #%d %s", action, attrs.IID, attrs.Title)
+		return itemEmbed(repo, title+" by "+author, title, attrs.URL, author, openedBody(action, attrs.Description), color), nil
+
+	case "note":
+		var target string
+		switch {
+		case attrs.NoteableType == "Issue" && p.Issue != nil:
+			target = fmt.Sprintf("#%d %s", p.Issue.IID, p.Issue.Title)
+		case attrs.NoteableType == "MergeRequest" && p.MergeRequest != nil:
+			target = fmt.Sprintf("!%d %s", p.MergeRequest.IID, p.MergeRequest.Title)
+		default:
+			return nil, ErrIgnored
+		}
+		title := "New comment on " + target
+		return itemEmbed(repo, title+" by "+author, title, attrs.URL, author, attrs.Note, ColorGray), nil
+
+	case "pipeline":
+		color := ColorRed
+		switch attrs.Status {
+		case "success":
+			color = ColorGreen
+		case "failed":
+		case "canceled", "skipped":
+			color = ColorGray
+		default:
+			// Running and pending updates would flood the channel
+			return nil, ErrIgnored
+		}
+		title := fmt.Sprintf("Pipeline #%d %s on %s", attrs.ID, attrs.Status, attrs.Ref)
+		url := fmt.Sprintf("%s/-/pipelines/%d", p.Project.WebURL, attrs.ID)
+		return itemEmbed(repo, title, title, url, author, "", color), nil
+
+	case "release":
+		if p.Action != "create" {
+			return nil, ErrIgnored
+		}
+		title := "Release published: " + firstNonEmpty(p.Name, p.Tag)
+		return itemEmbed(repo, title, title, p.URL, author, p.Description, ColorBlue), nil
+	}
+	return nil, ErrIgnored
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+// Package inbound turns webhook payloads sent by external services into
+// chat messages, so they can be posted without writing a plugin.
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"fethur/pkg/webhook"
+)
+
+// ErrIgnored is returned for events a formatter deliberately skips, such
+// as label changes; the sender should still get a success response
+var ErrIgnored = errors.New("event ignored")
+
+// Embed colors
+const (
+	ColorGreen  = 0x2ea043
+	ColorRed    = 0xcf222e
+	ColorPurple = 0x8957e5
+	ColorBlue   = 0x0969da
+	ColorGray   = 0x6e7781
+	ColorOrange = 0xd4a72c
+)
+
+// Message is a formatted chat message. Content is a plain-text summary
+// for clients that do not render embeds.
+type Message struct {
+	Content string  `json:"content"`
+	Embeds  []Embed `json:"embeds,omitempty"`
+
+	// Alerts is set by alerting formats so the receiver can deduplicate
+	// them and rebuild the message with AlertMessage
+	Alerts []Alert `json:"-"`
+}
+
+// Embed is a rich block attached to a message
+type Embed struct {
+	Title       string  `json:"title,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Color       int     `json:"color,omitempty"`
+	Author      string  `json:"author,omitempty"`
+	Fields      []Field `json:"fields,omitempty"`
+	Footer      string  `json:"footer,omitempty"`
+	Timestamp   string  `json:"timestamp,omitempty"`
+}
+
+// Field is a name/value pair in an embed
+type Field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Formatter verifies and formats one service's payloads
+type Formatter interface {
+	// Verify checks the request signature with the webhook's secret. It
+	// is only called when a secret is configured.
+	Verify(header http.Header, body []byte, secret string) bool
+	Format(header http.Header, body []byte) (*Message, error)
+}
+
+var formatters = map[string]Formatter{
+	"plain":  plainFormatter{},
+	"github": githubFormatter{},
+	"gitea":  giteaFormatter{},
+	"gitlab": gitlabFormatter{},
+
+	"alertmanager": alertmanagerFormatter{},
+	"grafana":      grafanaFormatter{},
+}
+
+// Lookup returns the formatter for a format name
+func Lookup(format string) (Formatter, bool) {
+	formatter, ok := formatters[format]
+	return formatter, ok
+}
+
+// Formats returns the supported format names
+func Formats() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// plainFormatter accepts {"content": "...", "embeds": [...]}; "text" is
+// accepted as an alias for Slack-style senders. Signed requests use the
+// timestamped signature Fethur sends its own webhooks with.
+type plainFormatter struct{}
+
+func (plainFormatter) Verify(header http.Header, body []byte, secret string) bool {
+	return webhook.Verify(header.Get(webhook.TimestampHeader), header.Get(webhook.SignatureHeader), body, webhook.DefaultTolerance, secret) == nil
+}
+
+func (plainFormatter) Format(header http.Header, body []byte) (*Message, error) {
+	var payload struct {
+		Content string  `json:"content"`
+		Text    string  `json:"text"`
+		Embeds  []Embed `json:"embeds"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.New("body must be JSON with content or text")
+	}
+	if payload.Content == "" {
+		payload.Content = payload.Text
+	}
+	if payload.Content == "" && len(payload.Embeds) == 0 {
+		return nil, errors.New("content or embeds are required")
+	}
+	if len(payload.Embeds) > maxEmbeds {
+		payload.Embeds = payload.Embeds[:maxEmbeds]
+	}
+	return &Message{Content: payload.Content, Embeds: payload.Embeds}, nil
+}
+
+// hmacSHA256 returns the hex HMAC-SHA256 of body
+func hmacSHA256(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncate shortens text to limit runes, marking the cut
+func truncate(text string, limit int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return strings.TrimSpace(string([]rune(text)[:limit-1])) + "…"
+}
+
+// firstLine returns the first line of a commit message
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return truncate(text, 80)
+}
+
+// shortSHA returns the abbreviated commit hash
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// branchName strips refs/heads/ or refs/tags/ from a ref
+func branchName(ref string) string {
+	ref = strings.TrimPrefix(ref, "refs/heads/")
+	return strings.TrimPrefix(ref, "refs/tags/")
+}
+
+// commitLine is one commit in a push summary
+type commitLine struct {
+	ID      string
+	Message string
+	URL     string
+	Author  string
+}
+
+// pushEmbed renders a list of pushed commits
+func pushEmbed(repo, ref, compareURL, pusher string, commits []commitLine, total int) *Message {
+	branch := branchName(ref)
+	if total == 0 {
+		total = len(commits)
+	}
+	noun := "commits"
+	if total == 1 {
+		noun = "commit"
+	}
+
+	lines := make([]string, 0, 5)
+	for i, commit := range commits {
+		if i == 5 {
+			lines = append(lines, "…")
+			break
+		}
+		line := "[`" + shortSHA(commit.ID) + "`](" + commit.URL + ") " + firstLine(commit.Message)
+		if commit.Author != "" {
+			line += " — " + commit.Author
+		}
+		lines = append(lines, line)
+	}
+
+	title := "[" + repo + ":" + branch + "] " + strconv.Itoa(total) + " new " + noun
+	return &Message{
+		Content: pusher + " pushed " + strconv.Itoa(total) + " " + noun + " to " + repo + ":" + branch,
+		Embeds: []Embed{{
+			Title:       title,
+			URL:         compareURL,
+			Description: strings.Join(lines, "\n"),
+			Color:       ColorBlue,
+			Author:      pusher,
+		}},
+	}
+}
+
+// itemEmbed renders a pull request, issue, release or pipeline event
+func itemEmbed(repo, summary, title, url, author, body string, color int) *Message {
+	return &Message{
+		Content: "[" + repo + "] " + summary,
+		Embeds: []Embed{{
+			Title:       "[" + repo + "] " + title,
+			URL:         url,
+			Description: truncate(body, 500),
+			Color:       color,
+			Author:      author,
+		}},
+	}
+}
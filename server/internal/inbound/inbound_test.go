@@ -0,0 +1,190 @@
+package inbound
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fethur/pkg/webhook"
+)
+
+func header(pairs ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestGitHubPush(t *testing.T) {
+	body := []byte(`{
+		"ref": "refs/heads/main",
+		"compare": "https://github.com/acme/app/compare/abc...def",
+		"repository": {"full_name": "acme/app"},
+		"pusher": {"name": "alice"},
+		"commits": [
+			{"id": "0123456789abcdef", "message": "Fix login\n\nLonger description", "url": "https://github.com/acme/app/commit/0123456", "author": {"name": "Alice"}},
+			{"id": "fedcba9876543210", "message": "Add tests", "url": "https://github.com/acme/app/commit/fedcba9", "author": {"name": "Bob"}}
+		]
+	}`)
+	formatter, _ := Lookup("github")
+	message, err := formatter.Format(header("X-GitHub-Event", "push"), body)
+	if err != nil {
+		t.Fatalf("Failed to format push: %v", err)
+	}
+	if message.Content != "alice pushed 2 commits to acme/app:main" {
+		t.Errorf("Unexpected content %q", message.Content)
+	}
+	embed := message.Embeds[0]
+	if embed.URL != "https://github.com/acme/app/compare/abc...def" || embed.Color != ColorBlue {
+		t.Errorf("Unexpected embed %+v", embed)
+	}
+	if !strings.Contains(embed.Description, "`0123456`") || strings.Contains(embed.Description, "Longer description") {
+		t.Errorf("Unexpected commit list %q", embed.Description)
+	}
+}
+
+func TestGitHubPullRequest(t *testing.T) {
+	formatter, _ := Lookup("github")
+	merged := []byte(`{
+		"action": "closed",
+		"repository": {"full_name": "acme/app"},
+		"sender": {"login": "bob"},
+		"pull_request": {"number": 7, "title": "Add dark mode", "html_url": "https://github.com/acme/app/pull/7", "merged": true}
+	}`)
+	message, err := formatter.Format(header("X-GitHub-Event", "pull_request"), merged)
+	if err != nil {
+		t.Fatalf("Failed to format pull request: %v", err)
+	}
+	if message.Embeds[0].Color != ColorPurple || !strings.Contains(message.Content, "Pull request merged: #7 Add dark mode") {
+		t.Errorf("Unexpected message %+v", message)
+	}
+
+	labeled := []byte(`{"action": "labeled", "pull_request": {"number": 7}}`)
+	if _, err := formatter.Format(header("X-GitHub-Event", "pull_request"), labeled); err != ErrIgnored {
+		t.Errorf("Expected label changes to be ignored, got %v", err)
+	}
+	if _, err := formatter.Format(header("X-GitHub-Event", "star"), []byte(`{}`)); err != ErrIgnored {
+		t.Errorf("Expected unknown events to be ignored, got %v", err)
+	}
+}
+
+func TestGitLabEvents(t *testing.T) {
+	formatter, _ := Lookup("gitlab")
+	pipeline := []byte(`{
+		"object_kind": "pipeline",
+		"user": {"username": "carol"},
+		"project": {"path_with_namespace": "group/app", "web_url": "https://gitlab.com/group/app"},
+		"object_attributes": {"id": 42, "status": "failed", "ref": "main"}
+	}`)
+	message, err := formatter.Format(http.Header{}, pipeline)
+	if err != nil {
+		t.Fatalf("Failed to format pipeline: %v", err)
+	}
+	embed := message.Embeds[0]
+	if embed.Color != ColorRed || embed.URL != "https://gitlab.com/group/app/-/pipelines/42" {
+		t.Errorf("Unexpected embed %+v", embed)
+	}
+
+	running := []byte(`{"object_kind": "pipeline", "object_attributes": {"status": "running"}}`)
+	if _, err := formatter.Format(http.Header{}, running); err != ErrIgnored {
+		t.Errorf("Expected running pipelines to be ignored, got %v", err)
+	}
+
+	mr := []byte(`{
+		"object_kind": "merge_request",
+		"user": {"username": "carol"},
+		"project": {"path_with_namespace": "group/app"},
+		"object_attributes": {"iid": 3, "title": "Refactor", "action": "open", "description": "Details", "url": "https://gitlab.com/group/app/-/merge_requests/3"}
+	}`)
+	message, err = formatter.Format(http.Header{}, mr)
+	if err != nil {
+		t.Fatalf("Failed to format merge request: %v", err)
+	}
+	if message.Embeds[0].Description != "Details" || !strings.Contains(message.Content, "Merge request opened: !3 Refactor") {
+		t.Errorf("Unexpected message %+v", message)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"zen": "Keep it logically awesome."}`)
+	secret := "s3cret"
+	signature := hmacSHA256(secret, body)
+
+	github, _ := Lookup("github")
+	if !github.Verify(header("X-Hub-Signature-256", "sha256="+signature), body, secret) {
+		t.Error("Expected valid GitHub signature to verify")
+	}
+	if github.Verify(header("X-Hub-Signature-256", "sha256="+signature), body, "other") {
+		t.Error("Expected GitHub signature with wrong secret to fail")
+	}
+
+	gitea, _ := Lookup("gitea")
+	if !gitea.Verify(header("X-Gitea-Signature", signature), body, secret) {
+		t.Error("Expected valid Gitea signature to verify")
+	}
+
+	gitlab, _ := Lookup("gitlab")
+	if !gitlab.Verify(header("X-Gitlab-Token", secret), body, secret) || gitlab.Verify(http.Header{}, body, secret) {
+		t.Error("Unexpected GitLab token verification result")
+	}
+
+	plain, _ := Lookup("plain")
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	webhook.SignRequest(req, body, secret)
+	if !plain.Verify(req.Header, body, secret) || plain.Verify(req.Header, body, "other") || plain.Verify(http.Header{}, body, secret) {
+		t.Error("Unexpected plain signature verification result")
+	}
+}
+
+func TestPlainFormatter(t *testing.T) {
+	formatter, _ := Lookup("plain")
+	message, err := formatter.Format(http.Header{}, []byte(`{"text": "Backup finished"}`))
+	if err != nil || message.Content != "Backup finished" {
+		t.Errorf("Unexpected result %+v (%v)", message, err)
+	}
+	if _, err := formatter.Format(http.Header{}, []byte(`{}`)); err == nil {
+		t.Error("Expected empty payload to be rejected")
+	}
+}
+
+func TestAlertmanagerAlerts(t *testing.T) {
+	body := []byte(`{
+		"version": "4",
+		"status": "firing",
+		"externalURL": "http://alertmanager:9093",
+		"alerts": [
+			{"status": "firing", "fingerprint": "aaa", "labels": {"alertname": "HighCPU", "severity": "warning", "instance": "nas"},
+			 "annotations": {"summary": "CPU above 90%"}, "startsAt": "2025-01-02T03:04:05Z", "generatorURL": "http://prometheus/graph"},
+			{"status": "resolved", "labels": {"alertname": "DiskFull", "severity": "critical"}, "endsAt": "2025-01-02T04:00:00Z"}
+		]
+	}`)
+	formatter, _ := Lookup("alertmanager")
+	message, err := formatter.Format(http.Header{}, body)
+	if err != nil {
+		t.Fatalf("Failed to format alerts: %v", err)
+	}
+	if message.Content != "[FIRING:1] HighCPU [RESOLVED:1] DiskFull" {
+		t.Errorf("Unexpected content %q", message.Content)
+	}
+	if len(message.Alerts) != 2 || message.Alerts[0].Fingerprint != "aaa" || message.Alerts[1].Fingerprint == "" {
+		t.Fatalf("Unexpected alerts %+v", message.Alerts)
+	}
+
+	firing := message.Embeds[0]
+	if firing.Color != ColorOrange || firing.Description != "CPU above 90%" || firing.URL != "http://prometheus/graph" {
+		t.Errorf("Unexpected firing embed %+v", firing)
+	}
+	links := firing.Fields[len(firing.Fields)-1]
+	if links.Name != "Links" || !strings.Contains(links.Value, "http://alertmanager:9093/#/silences/new?filter=") {
+		t.Errorf("Expected a silence link, got %+v", links)
+	}
+	if resolved := message.Embeds[1]; resolved.Color != ColorGreen || resolved.Timestamp != "2025-01-02T04:00:00Z" {
+		t.Errorf("Unexpected resolved embed %+v", resolved)
+	}
+
+	if !formatter.Verify(header("Authorization", "Bearer token"), body, "token") || formatter.Verify(http.Header{}, body, "token") {
+		t.Error("Unexpected bearer token verification result")
+	}
+}
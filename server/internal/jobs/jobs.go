@@ -0,0 +1,120 @@
+// Package jobs runs background work such as media processing outside of
+// request handlers.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a job cannot be queued without blocking
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrStopped is returned when enqueuing on a stopped queue
+var ErrStopped = errors.New("job queue is stopped")
+
+// Job is a named unit of background work
+type Job struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Queue is an in-process worker pool. Jobs are not persisted, so callers
+// that need work to survive restarts must record it themselves and
+// requeue it on startup.
+type Queue struct {
+	jobs    chan Job
+	workers int
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mutex   sync.RWMutex
+	stopped bool
+}
+
+// NewQueue creates a queue with the given number of workers, buffered
+// jobs and per-job timeout
+func NewQueue(workers, size int, timeout time.Duration) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		jobs:    make(chan Job, size),
+		workers: workers,
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start launches the workers
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Enqueue queues a job without blocking
+func (q *Queue) Enqueue(name string, run func(ctx context.Context) error) error {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case q.jobs <- Job{Name: name, Run: run}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop cancels running jobs and waits for the workers to exit. Jobs still
+// queued are dropped.
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	if q.stopped {
+		q.mutex.Unlock()
+		return
+	}
+	q.stopped = true
+	close(q.jobs)
+	q.mutex.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		if q.ctx.Err() != nil {
+			continue
+		}
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job Job) {
+	ctx, cancel := context.WithTimeout(q.ctx, q.timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", job.Name, r)
+		}
+	}()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Printf("Job %s failed after %v: %v", job.Name, time.Since(start), err)
+		return
+	}
+	log.Printf("Job %s finished in %v", job.Name, time.Since(start))
+}
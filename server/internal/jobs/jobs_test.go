@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueRunsJobs(t *testing.T) {
+	queue := NewQueue(2, 10, time.Second)
+	queue.Start()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	ran := make(map[int]bool)
+	for i := 0; i < 5; i++ {
+		i := i
+		wg.Add(1)
+		err := queue.Enqueue("test", func(ctx context.Context) error {
+			defer wg.Done()
+			mutex.Lock()
+			ran[i] = true
+			mutex.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to enqueue job: %v", err)
+		}
+	}
+
+	// A panicking job must not take down its worker
+	wg.Add(1)
+	if err := queue.Enqueue("panics", func(ctx context.Context) error {
+		defer wg.Done()
+		panic("boom")
+	}); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	wg.Wait()
+	queue.Stop()
+
+	if len(ran) != 5 {
+		t.Errorf("Expected 5 jobs to run, got %d", len(ran))
+	}
+	if err := queue.Enqueue("late", func(ctx context.Context) error { return nil }); err != ErrStopped {
+		t.Errorf("Expected ErrStopped after Stop, got %v", err)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// Not started, so nothing drains the buffer
+	queue := NewQueue(1, 1, time.Second)
+	noop := func(ctx context.Context) error { return nil }
+
+	if err := queue.Enqueue("first", noop); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	if err := queue.Enqueue("second", noop); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+}
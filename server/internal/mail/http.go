@@ -0,0 +1,213 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// SendGridProvider sends messages through the SendGrid v3 HTTP API
+type SendGridProvider struct {
+	apiKey   string
+	endpoint string
+	client   *http.Client
+}
+
+// NewSendGridProvider creates a SendGrid provider. An empty endpoint uses
+// the public API.
+func NewSendGridProvider(apiKey, endpoint string) (*SendGridProvider, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("SendGrid API key is required")
+	}
+	if endpoint == "" {
+		endpoint = "https://api.sendgrid.com"
+	}
+	return &SendGridProvider{
+		apiKey:   apiKey,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name returns the provider name
+func (p *SendGridProvider) Name() string {
+	return "sendgrid"
+}
+
+// Send delivers a message
+func (p *SendGridProvider) Send(ctx context.Context, from string, message Message) error {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+
+	type address struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	payload := struct {
+		Personalizations []map[string][]address `json:"personalizations"`
+		From             address                `json:"from"`
+		Subject          string                 `json:"subject"`
+		Content          []content              `json:"content"`
+	}{
+		Personalizations: []map[string][]address{{"to": {{Email: message.To}}}},
+		From:             address{Email: sender.Address, Name: sender.Name},
+		Subject:          message.Subject,
+		Content:          []content{{Type: "text/plain", Value: message.Text}},
+	}
+	if message.HTML != "" {
+		payload.Content = append(payload.Content, content{Type: "text/html", Value: message.HTML})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	return doMailRequest(p.client, req, "sendgrid")
+}
+
+// SESConfig configures delivery through the Amazon SES v2 HTTP API
+type SESConfig struct {
+	Region    string
+	AccessKey string
+	SecretKey string
+	Endpoint  string // defaults to https://email.<region>.amazonaws.com
+}
+
+// SESProvider sends messages through the Amazon SES v2 HTTP API
+type SESProvider struct {
+	config SESConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewSESProvider creates an SES provider
+func NewSESProvider(config SESConfig) (*SESProvider, error) {
+	if config.Region == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("SES region, access key and secret key are required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://email." + config.Region + ".amazonaws.com"
+	}
+	config.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	return &SESProvider{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+// Name returns the provider name
+func (p *SESProvider) Name() string {
+	return "ses"
+}
+
+// Send delivers a message
+func (p *SESProvider) Send(ctx context.Context, from string, message Message) error {
+	type text struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	body := map[string]*text{"Text": {Data: message.Text, Charset: "UTF-8"}}
+	if message.HTML != "" {
+		body["Html"] = &text{Data: message.HTML, Charset: "UTF-8"}
+	}
+	payload := map[string]interface{}{
+		"FromEmailAddress": from,
+		"Destination":      map[string][]string{"ToAddresses": {message.To}},
+		"Content": map[string]interface{}{
+			"Simple": map[string]interface{}{
+				"Subject": text{Data: message.Subject, Charset: "UTF-8"},
+				"Body":    body,
+			},
+		},
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint+"/v2/email/outbound-emails", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.sign(req, encoded)
+	return doMailRequest(p.client, req, "ses")
+}
+
+// sign adds an AWS Signature Version 4 Authorization header
+func (p *SESProvider) sign(req *http.Request, body []byte) {
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/ses/aws4_request", now.Format("20060102"), p.config.Region)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	payloadHash := sha256.Sum256(body)
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type") + "\n" +
+			"host:" + req.URL.Host + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+p.config.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, p.config.Region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// doMailRequest performs an API request and turns error statuses into errors
+func doMailRequest(client *http.Client, req *http.Request, provider string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", provider, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", provider, resp.Status, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package mail renders and delivers transactional email through pluggable
+// providers: SMTP, the SendGrid and Amazon SES HTTP APIs, or the log.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"strings"
+)
+
+// ErrInvalidAddress is returned for recipients that are not valid addresses
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Message is a rendered email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string // optional; sent as an alternative to Text
+}
+
+// Provider delivers messages
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, from string, message Message) error
+}
+
+// Config holds what every message needs regardless of the provider
+type Config struct {
+	From     string // "Fethur <noreply@example.com>"
+	SiteName string // shown in subjects and footers
+	BaseURL  string // public URL used to build links
+}
+
+// Mailer renders templates and sends them through a provider
+type Mailer struct {
+	provider Provider
+	config   Config
+}
+
+// New creates a mailer
+func New(provider Provider, config Config) (*Mailer, error) {
+	if _, err := netmail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+	if config.SiteName == "" {
+		config.SiteName = "Fethur"
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &Mailer{provider: provider, config: config}, nil
+}
+
+// Provider returns the name of the delivery provider
+func (m *Mailer) Provider() string {
+	return m.provider.Name()
+}
+
+// From returns the sender address
+func (m *Mailer) From() string {
+	return m.config.From
+}
+
+// URL returns an absolute link to path on the public site
+func (m *Mailer) URL(path string) string {
+	return m.config.BaseURL + path
+}
+
+// Send delivers a rendered message
+func (m *Mailer) Send(ctx context.Context, message Message) error {
+	address, err := netmail.ParseAddress(message.To)
+	if err != nil {
+		return ErrInvalidAddress
+	}
+	message.To = address.Address
+	return m.provider.Send(ctx, m.config.From, message)
+}
+
+// Render renders a named template. The site name and base URL are added
+// to data as SiteName and BaseURL.
+func (m *Mailer) Render(name, to string, data map[string]interface{}) (Message, error) {
+	values := map[string]interface{}{
+		"SiteName": m.config.SiteName,
+		"BaseURL":  m.config.BaseURL,
+	}
+	for key, value := range data {
+		values[key] = value
+	}
+	return render(name, to, values)
+}
+
+// SendTemplate renders a named template and delivers it. The rendered
+// message is returned even when delivery fails, for delivery logs.
+func (m *Mailer) SendTemplate(ctx context.Context, name, to string, data map[string]interface{}) (Message, error) {
+	message, err := m.Render(name, to, data)
+	if err != nil {
+		return message, err
+	}
+	return message, m.Send(ctx, message)
+}
+
+// LogProvider writes messages to the log instead of sending them, so
+// email flows work in development without a mail server
+type LogProvider struct{}
+
+// Name returns the provider name
+func (LogProvider) Name() string {
+	return "log"
+}
+
+// Send logs the message
+func (LogProvider) Send(ctx context.Context, from string, message Message) error {
+	log.Printf("Email to %s from %s: %s\n%s", message.To, from, message.Subject, message.Text)
+	return nil
+}
@@ -0,0 +1,211 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingProvider keeps sent messages in memory
+type recordingProvider struct {
+	sent []Message
+}
+
+func (p *recordingProvider) Name() string { return "recording" }
+
+func (p *recordingProvider) Send(ctx context.Context, from string, message Message) error {
+	p.sent = append(p.sent, message)
+	return nil
+}
+
+func TestRenderTemplates(t *testing.T) {
+	provider := &recordingProvider{}
+	mailer, err := New(provider, Config{From: "Fethur <noreply@example.com>", BaseURL: "https://chat.example.com/"})
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"Username":       "<script>alice</script>",
+		"VerifyURL":      "https://chat.example.com/verify?token=abc",
+		"ResetURL":       "https://chat.example.com/reset?token=abc",
+		"UnsubscribeURL": "https://chat.example.com/unsubscribe",
+		"ExpiresIn":      "1 hour",
+		"SentBy":         "root",
+		"Provider":       "recording",
+		"Mentions": []map[string]string{
+			{"Author": "bob", "Channel": "general", "Excerpt": "hey @alice"},
+		},
+		"Servers":   []map[string]interface{}{{"Name": "Homelab", "Messages": 12}},
+		"CheckedAt": "2025-07-28 20:00 UTC",
+		"Problems":  []string{"row 12 missing from index idx_messages_channel"},
+	}
+	for _, name := range TemplateNames() {
+		message, err := mailer.Render(name, "alice@example.com", data)
+		if err != nil {
+			t.Fatalf("Failed to render %s: %v", name, err)
+		}
+		if message.Subject == "" || message.Text == "" || message.HTML == "" {
+			t.Errorf("Template %s rendered an empty part: %+v", name, message)
+		}
+		if strings.Contains(message.HTML, "<script>") {
+			t.Errorf("Template %s did not escape HTML", name)
+		}
+		if !strings.Contains(message.HTML, "https://chat.example.com") {
+			t.Errorf("Template %s is missing the base URL", name)
+		}
+	}
+
+	digest, _ := mailer.Render(TemplateDigest, "alice@example.com", data)
+	if digest.Subject != "Your week on Fethur: 1 mentions" {
+		t.Errorf("Unexpected digest subject %q", digest.Subject)
+	}
+
+	if _, err := mailer.Render("missing", "alice@example.com", nil); err != ErrUnknownTemplate {
+		t.Errorf("Expected ErrUnknownTemplate, got %v", err)
+	}
+}
+
+func TestMailerValidatesAddresses(t *testing.T) {
+	if _, err := New(&recordingProvider{}, Config{From: "not an address"}); err == nil {
+		t.Error("Expected invalid sender to be rejected")
+	}
+
+	provider := &recordingProvider{}
+	mailer, _ := New(provider, Config{From: "noreply@example.com"})
+	if err := mailer.Send(context.Background(), Message{To: "nobody", Subject: "x", Text: "x"}); err != ErrInvalidAddress {
+		t.Errorf("Expected ErrInvalidAddress, got %v", err)
+	}
+	if err := mailer.Send(context.Background(), Message{To: "Alice <alice@example.com>", Subject: "x", Text: "x"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if len(provider.sent) != 1 || provider.sent[0].To != "alice@example.com" {
+		t.Errorf("Expected bare recipient address, got %+v", provider.sent)
+	}
+}
+
+func TestBuildMIME(t *testing.T) {
+	raw, err := buildMIME("Fethur <noreply@example.com>", Message{
+		To:      "alice@example.com",
+		Subject: "Grüße from Fethur",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+
+	parsed, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Grüße from Fethur" {
+		t.Errorf("Unexpected subject %q (%v)", subject, err)
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Unexpected Message-ID %q", parsed.Header.Get("Message-ID"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected content type %q", parsed.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, string(body))
+	}
+	if len(bodies) != 2 || bodies[0] != "plain body" || bodies[1] != "<p>html body</p>" {
+		t.Errorf("Unexpected parts: %q", bodies)
+	}
+}
+
+func TestSendGridProvider(t *testing.T) {
+	var payload struct {
+		Personalizations []struct {
+			To []struct {
+				Email string `json:"email"`
+			} `json:"to"`
+		} `json:"personalizations"`
+		From struct {
+			Email string `json:"email"`
+			Name  string `json:"name"`
+		} `json:"from"`
+		Content []struct {
+			Type string `json:"type"`
+		} `json:"content"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/mail/send" || r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	provider, err := NewSendGridProvider("key", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	err = provider.Send(context.Background(), "Fethur <noreply@example.com>", Message{
+		To: "alice@example.com", Subject: "Hi", Text: "text", HTML: "<p>html</p>",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if payload.Personalizations[0].To[0].Email != "alice@example.com" || payload.From.Name != "Fethur" || len(payload.Content) != 2 {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+
+	bad, _ := NewSendGridProvider("wrong", server.URL)
+	if err := bad.Send(context.Background(), "noreply@example.com", Message{To: "alice@example.com"}); err == nil {
+		t.Error("Expected rejected API key to fail")
+	}
+}
+
+func TestSESProviderSignsRequests(t *testing.T) {
+	var authorization, amzDate string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		amzDate = r.Header.Get("X-Amz-Date")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"MessageId":"1"}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewSESProvider(SESConfig{Region: "eu-west-1", AccessKey: "AKID", SecretKey: "secret", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	if err := provider.Send(context.Background(), "noreply@example.com", Message{To: "alice@example.com", Subject: "Hi", Text: "text"}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if amzDate != "20250102T030405Z" {
+		t.Errorf("Unexpected X-Amz-Date %q", amzDate)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKID/20250102/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=") {
+		t.Errorf("Unexpected Authorization header %q", authorization)
+	}
+}
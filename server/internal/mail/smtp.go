@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP security modes
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection, usually port 587
+	SMTPTLS      = "tls"      // implicit TLS, usually port 465
+	SMTPPlain    = "none"     // no encryption, for local relays only
+)
+
+// SMTPConfig configures delivery through an SMTP server
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security string // SMTPStartTLS (default), SMTPTLS or SMTPPlain
+	Timeout  time.Duration
+}
+
+// SMTPProvider sends messages through an SMTP server
+type SMTPProvider struct {
+	config SMTPConfig
+}
+
+// NewSMTPProvider creates an SMTP provider
+func NewSMTPProvider(config SMTPConfig) (*SMTPProvider, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if config.Security == "" {
+		config.Security = SMTPStartTLS
+	}
+	if config.Security != SMTPStartTLS && config.Security != SMTPTLS && config.Security != SMTPPlain {
+		return nil, fmt.Errorf("unknown SMTP security mode %q", config.Security)
+	}
+	if config.Port == 0 {
+		config.Port = 587
+		if config.Security == SMTPTLS {
+			config.Port = 465
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &SMTPProvider{config: config}, nil
+}
+
+// Name returns the provider name
+func (p *SMTPProvider) Name() string {
+	return "smtp"
+}
+
+// Send delivers a message
+func (p *SMTPProvider) Send(ctx context.Context, from string, message Message) error {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+	body, err := buildMIME(from, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(p.config.Host, strconv.Itoa(p.config.Port))
+	tlsConfig := &tls.Config{ServerName: p.config.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	if p.config.Security == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, p.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if p.config.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", p.config.Host)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if p.config.Username != "" {
+		// PlainAuth refuses to send credentials over unencrypted
+		// connections to anything but localhost
+		auth := smtp.PlainAuth("", p.config.Username, p.config.Password, p.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return fmt.Errorf("smtp RCPT TO: %w", err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return client.Quit()
+}
+
+// buildMIME renders a message as RFC 5322 text with a plain text part and,
+// when present, an HTML alternative
+func buildMIME(from string, message Message, now time.Time) ([]byte, error) {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndexByte(sender.Address, '@'); at >= 0 {
+		domain = sender.Address[at+1:]
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", sender.String())
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain))
+	header("MIME-Version", "1.0")
+
+	if message.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, message.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(writer, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(text)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
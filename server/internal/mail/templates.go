@@ -0,0 +1,194 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// ErrUnknownTemplate is returned when rendering a template that does not exist
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Template names
+const (
+	TemplateVerification  = "verification"
+	TemplatePasswordReset = "password_reset"
+	TemplateDigest        = "digest"
+	TemplateTest          = "test"
+	TemplateCorruption    = "db_corruption"
+	TemplateAlert         = "alert"
+	TemplateRegistration  = "registration_reviewed"
+	TemplateNewDevice     = "new_device_login"
+)
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+const htmlLayout = `<!DOCTYPE html>
+<html><body style="font-family: -apple-system, 'Segoe UI', sans-serif; color: #1f2328; max-width: 560px; margin: 0 auto; padding: 24px;">
+{{template "body" .}}
+<p style="color: #6e7781; font-size: 12px; margin-top: 32px;">Sent by {{.SiteName}}{{if .BaseURL}} · <a href="{{.BaseURL}}" style="color: #6e7781;">{{.BaseURL}}</a>{{end}}</p>
+</body></html>`
+
+var templates = map[string]emailTemplate{
+	TemplateVerification: newTemplate(
+		`Verify your {{.SiteName}} email address`,
+		`Hi {{.Username}},
+
+Confirm your email address by opening this link:
+{{.VerifyURL}}
+
+The link expires in {{.ExpiresIn}}. If you did not sign up for {{.SiteName}}, ignore this email.`,
+		`<p>Hi {{.Username}},</p>
+<p>Confirm your email address to finish setting up your account.</p>
+<p><a href="{{.VerifyURL}}" style="background: #5865f2; color: #fff; padding: 10px 16px; border-radius: 6px; text-decoration: none;">Verify email</a></p>
+<p>The link expires in {{.ExpiresIn}}. If you did not sign up for {{.SiteName}}, ignore this email.</p>`,
+	),
+	TemplatePasswordReset: newTemplate(
+		`Reset your {{.SiteName}} password`,
+		`Hi {{.Username}},
+
+Someone asked to reset your password. To choose a new one, open:
+{{.ResetURL}}
+
+The link expires in {{.ExpiresIn}}. If this wasn't you, ignore this email; your password stays the same.`,
+		`<p>Hi {{.Username}},</p>
+<p>Someone asked to reset your password.</p>
+<p><a href="{{.ResetURL}}" style="background: #5865f2; color: #fff; padding: 10px 16px; border-radius: 6px; text-decoration: none;">Choose a new password</a></p>
+<p>The link expires in {{.ExpiresIn}}. If this wasn't you, ignore this email; your password stays the same.</p>`,
+	),
+	TemplateDigest: newTemplate(
+		`Your week on {{.SiteName}}{{if .Mentions}}: {{len .Mentions}} mentions{{end}}`,
+		`Hi {{.Username}},
+
+Here is what happened while you were away.{{if .Unread}} There are {{.Unread}} new messages in your servers.{{end}}
+{{if .Mentions}}
+Mentions:
+{{range .Mentions}}- {{.Author}} in #{{.Channel}}: {{.Excerpt}}
+{{end}}{{end}}{{if .Replies}}
+Replies in threads you follow:
+{{range .Replies}}- {{.Author}} in #{{.Channel}}: {{.Excerpt}}
+{{end}}{{end}}{{if .Highlights}}
+Busiest channels:
+{{range .Highlights}}- #{{.Channel}} in {{.Server}}: {{.Messages}} messages
+{{end}}{{end}}{{if .Servers}}
+Activity:
+{{range .Servers}}- {{.Name}}: {{.Messages}} new messages
+{{end}}{{end}}
+Catch up at {{.BaseURL}}
+
+To stop these emails, open {{.UnsubscribeURL}}`,
+		`<p>Hi {{.Username}},</p>
+<p>Here is what happened while you were away.{{if .Unread}} There are <strong>{{.Unread}}</strong> new messages in your servers.{{end}}</p>
+{{if .Mentions}}<h3>Mentions</h3><ul>{{range .Mentions}}<li><strong>{{.Author}}</strong> in #{{.Channel}}: {{.Excerpt}}</li>{{end}}</ul>{{end}}
+{{if .Replies}}<h3>Replies in threads you follow</h3><ul>{{range .Replies}}<li><strong>{{.Author}}</strong> in #{{.Channel}}: {{.Excerpt}}</li>{{end}}</ul>{{end}}
+{{if .Highlights}}<h3>Busiest channels</h3><ul>{{range .Highlights}}<li>#{{.Channel}} in {{.Server}}: {{.Messages}} messages</li>{{end}}</ul>{{end}}
+{{if .Servers}}<h3>Activity</h3><ul>{{range .Servers}}<li>{{.Name}}: {{.Messages}} new messages</li>{{end}}</ul>{{end}}
+<p><a href="{{.BaseURL}}">Catch up on {{.SiteName}}</a></p>
+<p style="font-size: 12px;"><a href="{{.UnsubscribeURL}}">Stop these emails</a></p>`,
+	),
+	TemplateTest: newTemplate(
+		`{{.SiteName}} test email`,
+		`This is a test email sent by {{.SentBy}} to check the mail configuration.
+
+Provider: {{.Provider}}`,
+		`<p>This is a test email sent by <strong>{{.SentBy}}</strong> to check the mail configuration.</p>
+<p>Provider: {{.Provider}}</p>`,
+	),
+	TemplateCorruption: newTemplate(
+		`{{.SiteName}} database integrity check failed`,
+		`Hi {{.Username}},
+
+The database integrity check at {{.CheckedAt}} found problems:
+{{range .Problems}}- {{.}}
+{{end}}
+Stop the server, run "fethur recover" to rebuild the database from the last good backup, and start it again.`,
+		`<p>Hi {{.Username}},</p>
+<p>The database integrity check at {{.CheckedAt}} found problems:</p>
+<ul>{{range .Problems}}<li><code>{{.}}</code></li>{{end}}</ul>
+<p>Stop the server, run <code>fethur recover</code> to rebuild the database from the last good backup, and start it again.</p>`,
+	),
+	TemplateAlert: newTemplate(
+		`{{if .Test}}[test] {{end}}{{.SiteName}} alert {{if .Firing}}firing{{else}}resolved{{end}}: {{.Rule}}`,
+		`Hi {{.Username}},
+
+{{if .Firing}}The alert rule "{{.Rule}}" is firing: {{.Metric}} is {{.Value}}, {{.Comparison}} {{.Threshold}}.{{else}}The alert rule "{{.Rule}}" resolved: {{.Metric}} is back at {{.Value}}.{{end}}
+{{if .Test}}
+This is a test sent from the alert rule settings.{{end}}`,
+		`<p>Hi {{.Username}},</p>
+{{if .Firing}}<p>The alert rule <strong>{{.Rule}}</strong> is firing: <code>{{.Metric}}</code> is {{.Value}}, {{.Comparison}} {{.Threshold}}.</p>{{else}}<p>The alert rule <strong>{{.Rule}}</strong> resolved: <code>{{.Metric}}</code> is back at {{.Value}}.</p>{{end}}
+{{if .Test}}<p>This is a test sent from the alert rule settings.</p>{{end}}`,
+	),
+	TemplateRegistration: newTemplate(
+		`Your {{.SiteName}} registration was {{if .Approved}}approved{{else}}declined{{end}}`,
+		`Hi {{.Username}},
+
+{{if .Approved}}An admin approved your account. You can sign in at {{.BaseURL}}{{else}}An admin declined your registration and the account was removed.{{if .Reason}}
+
+Reason: {{.Reason}}{{end}}{{end}}`,
+		`<p>Hi {{.Username}},</p>
+{{if .Approved}}<p>An admin approved your account.</p>
+<p><a href="{{.BaseURL}}" style="background: #5865f2; color: #fff; padding: 10px 16px; border-radius: 6px; text-decoration: none;">Sign in</a></p>{{else}}<p>An admin declined your registration and the account was removed.</p>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}{{end}}`,
+	),
+	TemplateNewDevice: newTemplate(
+		`New login to your {{.SiteName}} account`,
+		`Hi {{.Username}},
+
+Your account was just signed in to from a device we have not seen before:
+- Device: {{.Device}}
+- Network: {{.Network}}{{if .Country}}
+- Country: {{.Country}}{{end}}
+
+If this was you, there is nothing to do. If not, change your password and revoke the session from your account settings at {{.BaseURL}}`,
+		`<p>Hi {{.Username}},</p>
+<p>Your account was just signed in to from a device we have not seen before:</p>
+<ul><li>Device: {{.Device}}</li><li>Network: {{.Network}}</li>{{if .Country}}<li>Country: {{.Country}}</li>{{end}}</ul>
+<p>If this was you, there is nothing to do. If not, change your password and revoke the session from your account settings.</p>
+<p><a href="{{.BaseURL}}" style="background: #5865f2; color: #fff; padding: 10px 16px; border-radius: 6px; text-decoration: none;">Review your sessions</a></p>`,
+	),
+}
+
+func newTemplate(subject, text, html string) emailTemplate {
+	layout := htmltemplate.Must(htmltemplate.New("layout").Parse(htmlLayout))
+	return emailTemplate{
+		subject: texttemplate.Must(texttemplate.New("subject").Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New("text").Parse(text)),
+		html:    htmltemplate.Must(layout.New("body").Parse(html)),
+	}
+}
+
+// TemplateNames lists the available templates
+func TemplateNames() []string {
+	return []string{TemplateVerification, TemplatePasswordReset, TemplateDigest, TemplateTest, TemplateCorruption, TemplateAlert, TemplateRegistration, TemplateNewDevice}
+}
+
+func render(name, to string, data map[string]interface{}) (Message, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return Message{}, ErrUnknownTemplate
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()),
+		HTML:    html.String(),
+	}, nil
+}
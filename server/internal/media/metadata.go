@@ -0,0 +1,299 @@
+// Package media processes uploaded images, removing metadata that can
+// identify where and with what a photo was taken.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported is returned for formats the package cannot process
+var ErrUnsupported = errors.New("unsupported image format")
+
+// StripMetadata removes EXIF, XMP, IPTC and text metadata from JPEG, PNG
+// and WebP images. JPEG orientation is kept so photos still display upright.
+// It reports whether anything was removed.
+func StripMetadata(contentType string, data []byte) ([]byte, bool, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return nil, false, ErrUnsupported
+}
+
+// JPEG markers
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAPPD = 0xED // Photoshop/IPTC
+	markerCOM  = 0xFE
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+func stripJPEG(data []byte) ([]byte, bool, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, false, fmt.Errorf("not a JPEG image")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	orientation := 1
+	stripped := false
+	insertAt := out.Len()
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, false, fmt.Errorf("malformed JPEG segment at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			// Entropy-coded data follows; copy the rest unchanged
+			out.Write(data[pos:])
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, false, fmt.Errorf("malformed JPEG segment at offset %d", pos)
+		}
+		payload := data[pos+4 : end]
+
+		switch {
+		case marker == markerAPP1 || marker == markerAPPD || marker == markerCOM:
+			if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+				if value := exifOrientation(payload[len(exifHeader):]); value > 1 {
+					orientation = value
+				}
+			}
+			stripped = true
+		default:
+			out.Write(data[pos:end])
+			if marker == markerAPP0 {
+				// Orientation goes after JFIF, which must come first
+				insertAt = out.Len()
+			}
+		}
+		pos = end
+	}
+
+	if !stripped {
+		return data, false, nil
+	}
+
+	result := out.Bytes()
+	if orientation > 1 {
+		result = insertSegment(result, insertAt, orientationSegment(orientation))
+	}
+	return result, true, nil
+}
+
+// JPEGOrientation returns the EXIF orientation of a JPEG, 1 if unset
+func JPEGOrientation(data []byte) int {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == markerSOS {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		payload := data[pos+4 : end]
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			if value := exifOrientation(payload[len(exifHeader):]); value > 0 {
+				return value
+			}
+		}
+		pos = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment builds a minimal APP1 EXIF segment holding only the
+// orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big-endian header, IFD0 at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // orientation, SHORT, count 1
+		0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+
+	segment := []byte{0xFF, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// SetJPEGOrientation adds an orientation tag to a JPEG without EXIF data,
+// such as one produced by re-encoding
+func SetJPEGOrientation(data []byte, orientation int) []byte {
+	if orientation <= 1 || len(data) < 2 {
+		return data
+	}
+	insertAt := 2
+	if len(data) >= 6 && data[2] == 0xFF && data[3] == markerAPP0 {
+		insertAt = 4 + int(binary.BigEndian.Uint16(data[4:6]))
+	}
+	return insertSegment(data, insertAt, orientationSegment(orientation))
+}
+
+func insertSegment(data []byte, at int, segment []byte) []byte {
+	result := make([]byte, 0, len(data)+len(segment))
+	result = append(result, data[:at]...)
+	result = append(result, segment...)
+	return append(result, data[at:]...)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks hold EXIF, text comments (which often carry XMP) and
+// modification times
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, false, fmt.Errorf("not a PNG image")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	stripped := false
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, false, fmt.Errorf("malformed PNG chunk at offset %d", pos)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + length // length, type, data, CRC
+		if length < 0 || end > len(data) {
+			return nil, false, fmt.Errorf("malformed PNG chunk at offset %d", pos)
+		}
+
+		if pngMetadataChunks[chunkType] {
+			stripped = true
+		} else {
+			out.Write(data[pos:end])
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	if !stripped {
+		return data, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// VP8X flags announcing metadata chunks
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, bool, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false, fmt.Errorf("not a WebP image")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	stripped := false
+	flagsAt := -1
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkType := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2 // chunks are padded to even length
+		if size < 0 || end > len(data) {
+			return nil, false, fmt.Errorf("malformed WebP chunk at offset %d", pos)
+		}
+
+		if chunkType == "EXIF" || chunkType == "XMP " {
+			stripped = true
+		} else {
+			if chunkType == "VP8X" && size >= 1 {
+				flagsAt = out.Len() + 8
+			}
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	if !stripped {
+		return data, false, nil
+	}
+
+	result := out.Bytes()
+	if flagsAt >= 0 {
+		result[flagsAt] &^= webpFlagEXIF | webpFlagXMP
+	}
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	return result, true, nil
+}
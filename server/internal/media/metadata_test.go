@@ -0,0 +1,152 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		img.Set(x, x, color.RGBA{R: 200, A: 255})
+	}
+	return img
+}
+
+// exifWithGPS builds an APP1 segment with an orientation tag and a fake
+// GPS marker string standing in for location data
+func exifWithGPS(orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00,
+		0x01, 0x00,
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, byte(orientation), 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	payload := append(append([]byte("Exif\x00\x00"), tiff...), []byte("GPS 52.5200N 13.4050E")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestStripJPEGKeepsOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	original := insertSegment(buf.Bytes(), 2, exifWithGPS(6))
+
+	stripped, changed, err := StripMetadata("image/jpeg", original)
+	if err != nil {
+		t.Fatalf("Failed to strip JPEG: %v", err)
+	}
+	if !changed {
+		t.Fatal("Expected metadata to be removed")
+	}
+	if bytes.Contains(stripped, []byte("GPS")) {
+		t.Error("Expected GPS data to be removed")
+	}
+	if got := JPEGOrientation(stripped); got != 6 {
+		t.Errorf("Expected orientation 6 to be kept, got %d", got)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("Stripped JPEG does not decode: %v", err)
+	}
+
+	// Clean images are returned unchanged
+	again, changed, err := StripMetadata("image/jpeg", buf.Bytes())
+	if err != nil || changed || !bytes.Equal(again, buf.Bytes()) {
+		t.Errorf("Expected clean JPEG to be unchanged, changed=%v err=%v", changed, err)
+	}
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	crc := crc32.ChecksumIEEE(chunk[4:])
+	return binary.BigEndian.AppendUint32(chunk, crc)
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	// Insert a text chunk right after IHDR (8 byte signature + 25 byte IHDR)
+	original := insertSegment(buf.Bytes(), 33, pngChunk("tEXt", []byte("Author\x00Jane at 52.52N")))
+
+	stripped, changed, err := StripMetadata("image/png", original)
+	if err != nil || !changed {
+		t.Fatalf("Expected PNG text chunk to be stripped, changed=%v err=%v", changed, err)
+	}
+	if bytes.Contains(stripped, []byte("Jane")) {
+		t.Error("Expected text metadata to be removed")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("Stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(chunkType string, data []byte) []byte {
+		out := append([]byte(chunkType), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+		out = append(out, data...)
+		if len(data)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 7, 0, 0, 7, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2F, 1, 2, 3, 4})...)
+	body = append(body, chunk("EXIF", []byte("GPS data"))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	original := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(original[4:], uint32(len(body)))
+
+	stripped, changed, err := StripMetadata("image/webp", original)
+	if err != nil || !changed {
+		t.Fatalf("Expected WebP metadata to be stripped, changed=%v err=%v", changed, err)
+	}
+	if bytes.Contains(stripped, []byte("GPS")) || bytes.Contains(stripped, []byte("xmpmeta")) {
+		t.Error("Expected EXIF and XMP chunks to be removed")
+	}
+	if flags := stripped[20]; flags&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Errorf("Expected metadata flags to be cleared, got %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(stripped[4:8]); int(size) != len(stripped)-8 {
+		t.Errorf("Expected RIFF size %d, got %d", len(stripped)-8, size)
+	}
+}
+
+func TestReEncode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	original := insertSegment(buf.Bytes(), 2, exifWithGPS(3))
+
+	encoded, contentType, err := ReEncode("image/jpeg", original, FormatJPEG, 60)
+	if err != nil {
+		t.Fatalf("Failed to re-encode: %v", err)
+	}
+	if contentType != "image/jpeg" || bytes.Contains(encoded, []byte("GPS")) {
+		t.Errorf("Unexpected re-encode result: %s", contentType)
+	}
+	if got := JPEGOrientation(encoded); got != 3 {
+		t.Errorf("Expected orientation 3 after re-encoding, got %d", got)
+	}
+
+	if _, _, err := ReEncode("image/jpeg", original, FormatWebP, 60); err == nil {
+		t.Error("Expected WebP encoding to be reported as unavailable")
+	}
+}
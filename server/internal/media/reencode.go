@@ -0,0 +1,57 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	// Register decoders for formats accepted as attachments
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Output formats for re-encoding. WebP and AVIF need encoders that are not
+// part of the standard library, so they are reported as unsupported until
+// one is added.
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// SupportedFormats lists the formats ReEncode can produce
+func SupportedFormats() []string {
+	return []string{FormatJPEG}
+}
+
+// ReEncode decodes a JPEG and encodes it again in the given format and
+// quality (1-100). The result carries no metadata except orientation.
+// Only JPEG input is re-encoded so transparency is never lost.
+func ReEncode(contentType string, data []byte, format string, quality int) ([]byte, string, error) {
+	if contentType != "image/jpeg" {
+		return nil, "", ErrUnsupported
+	}
+	if quality < 1 || quality > 100 {
+		return nil, "", fmt.Errorf("quality must be between 1 and 100")
+	}
+
+	switch format {
+	case FormatJPEG:
+	case FormatWebP, FormatAVIF:
+		return nil, "", fmt.Errorf("%s encoding is not available in this build: %w", format, ErrUnsupported)
+	default:
+		return nil, "", fmt.Errorf("unknown image format %q: %w", format, ErrUnsupported)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return SetJPEGOrientation(out.Bytes(), JPEGOrientation(data)), "image/jpeg", nil
+}
@@ -2,7 +2,11 @@ package plugins
 
 import (
 	"context"
+	"net/http"
+	"strconv"
 	"time"
+
+	"fethur/internal/events"
 )
 
 // Plugin represents the base interface that all plugins must implement
@@ -126,6 +130,10 @@ type PluginConfig struct {
 	Permissions []Permission           `json:"permissions"`
 	Logger      Logger                 `json:"-"`
 	Database    Database               `json:"-"`
+	// HTTP is set for plugins with network:access. It refuses internal
+	// addresses the server's outbound policy does not allow; plugins must
+	// not make requests any other way.
+	HTTP *http.Client `json:"-"`
 }
 
 // PluginHealth represents the health status of a plugin
@@ -259,19 +267,47 @@ type Event struct {
 	Timestamp time.Time              `json:"timestamp"`
 }
 
+// NewEvent converts a shared event envelope to the shape plugins receive,
+// where IDs are strings and data is always an object
+func NewEvent(event events.Event) Event {
+	converted := Event{
+		Type:      event.Type,
+		Timestamp: event.Timestamp,
+	}
+	if event.UserID != 0 {
+		converted.UserID = strconv.FormatInt(event.UserID, 10)
+	}
+	if event.ChannelID != 0 {
+		converted.ChannelID = strconv.FormatInt(event.ChannelID, 10)
+	}
+	if event.ServerID != 0 {
+		converted.ServerID = strconv.FormatInt(event.ServerID, 10)
+	}
+	if data, ok := event.Data.(map[string]interface{}); ok {
+		converted.Data = data
+	} else if event.Data != nil {
+		if err := events.DecodeData(event.Data, &converted.Data); err != nil {
+			converted.Data = map[string]interface{}{"value": event.Data}
+		}
+	}
+	return converted
+}
+
 // EventType represents the type of event
-type EventType string
+type EventType = events.Type
 
 const (
-	EventMessageCreate EventType = "message.create"
-	EventMessageUpdate EventType = "message.update"
-	EventMessageDelete EventType = "message.delete"
-	EventUserJoin      EventType = "user.join"
-	EventUserLeave     EventType = "user.leave"
-	EventChannelCreate EventType = "channel.create"
-	EventChannelUpdate EventType = "channel.update"
-	EventChannelDelete EventType = "channel.delete"
-	EventServerUpdate  EventType = "server.update"
+	EventMessageCreate = events.TypeMessageCreate
+	EventMessageUpdate = events.TypeMessageEdit
+	EventMessageDelete = events.TypeMessageRemove
+	EventUserJoin      = events.TypeUserJoin
+	EventUserLeave     = events.TypeUserLeave
+	EventChannelCreate = events.TypeChannelCreate
+	EventChannelUpdate = events.TypeChannelUpdate
+	EventChannelDelete = events.TypeChannelDelete
+	EventServerUpdate  = events.TypeServerUpdate
+	EventUserKicked    = events.TypeUserKicked
+	EventUserBanned    = events.TypeUserBanned
 )
 
 // DirectMessage represents a direct message to a bot
@@ -3,12 +3,16 @@ package plugins
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"fethur/internal/chaos"
+	"fethur/internal/events"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -22,6 +26,7 @@ type Manager struct {
 	config    *Config
 	logger    Logger
 	database  Database
+	http      *http.Client
 	mu        sync.RWMutex
 	ctx       context.Context
 	cancel    context.CancelFunc
@@ -37,6 +42,24 @@ type Config struct {
 	UpdateInterval time.Duration  `json:"update_interval" yaml:"update_interval"`
 }
 
+// DefaultConfig returns the plugin manager configuration used when none is provided
+func DefaultConfig() *Config {
+	return &Config{
+		PluginDir:  "./plugins",
+		MaxPlugins: 50,
+		DefaultLimits: ResourceLimits{
+			MaxMemoryMB:      64,
+			MaxCPUPercent:    10.0,
+			MaxExecutionTime: 10 * time.Second,
+			MaxGoroutines:    20,
+			MaxConnections:   10,
+		},
+		EnableSandbox:  true,
+		SecurityPolicy: string(SecurityLevelModerate),
+		UpdateInterval: time.Minute,
+	}
+}
+
 // NewManager creates a new plugin manager
 func NewManager(config *Config, logger Logger, database Database) (*Manager, error) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,6 +87,14 @@ func NewManager(config *Config, logger Logger, database Database) (*Manager, err
 	return manager, nil
 }
 
+// SetHTTPClient sets the client plugins with network:access make requests
+// with. It applies to plugins loaded afterwards.
+func (m *Manager) SetHTTPClient(client *http.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.http = client
+}
+
 // LoadPlugin loads a plugin from the specified path
 func (m *Manager) LoadPlugin(pluginPath string) error {
 	m.mu.Lock()
@@ -100,6 +131,9 @@ func (m *Manager) LoadPlugin(pluginPath string) error {
 		Logger:      NewPluginLogger(m.logger, manifest.Name),
 		Database:    NewPluginDatabase(m.database, manifest.Permissions),
 	}
+	if hasPermission(manifest.Permissions, PermissionNetworkAccess) {
+		config.HTTP = m.http
+	}
 
 	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
 	defer cancel()
@@ -212,6 +246,12 @@ func (m *Manager) ProcessMessage(ctx context.Context, msg *Message) (*Message, e
 
 	result := msg
 	for _, processor := range processors {
+		if err := injectedTimeout(ctx); err != nil {
+			m.logger.Error("Message processing error",
+				"plugin", processor.Name(),
+				"error", err)
+			continue
+		}
 		processed, err := processor.ProcessMessage(ctx, result)
 		if err != nil {
 			m.logger.Error("Message processing error",
@@ -243,6 +283,9 @@ func (m *Manager) HandleCommand(ctx context.Context, cmd *Command) (*Response, e
 					ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 					defer cancel()
 
+					if err := injectedTimeout(ctx); err != nil {
+						return nil, err
+					}
 					return handler.HandleCommand(ctx, cmd)
 				}
 			}
@@ -263,7 +306,11 @@ func (m *Manager) EmitEvent(ctx context.Context, event Event) {
 			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 			defer cancel()
 
-			if err := l.HandleEvent(ctx, event); err != nil {
+			err := injectedTimeout(ctx)
+			if err == nil {
+				err = l.HandleEvent(ctx, event)
+			}
+			if err != nil {
 				m.logger.Error("Event handling error",
 					"plugin", l.Name(),
 					"event", event.Type,
@@ -273,6 +320,19 @@ func (m *Manager) EmitEvent(ctx context.Context, event Event) {
 	}
 }
 
+// injectedTimeout makes a plugin call hang until its deadline when fault
+// injection calls for it, as a stuck plugin would
+func injectedTimeout(ctx context.Context) error {
+	if !chaos.PluginTimeout() {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return context.DeadlineExceeded
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 // Shutdown gracefully shuts down all plugins
 func (m *Manager) Shutdown(ctx context.Context) error {
 	m.cancel()
@@ -465,7 +525,7 @@ const (
 
 // Event types for plugin lifecycle
 const (
-	EventPluginLoaded   EventType = "plugin.loaded"
-	EventPluginUnloaded EventType = "plugin.unloaded"
-	EventPluginError    EventType = "plugin.error"
+	EventPluginLoaded   = events.TypePluginLoaded
+	EventPluginUnloaded = events.TypePluginUnloaded
+	EventPluginError    = events.TypePluginError
 )
@@ -2,7 +2,9 @@ package plugins
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"log"
 	"regexp"
 	"runtime"
 	"sync"
@@ -246,7 +248,12 @@ func (pd *PluginDatabase) Exec(query string, args ...interface{}) (Result, error
 }
 
 func (pd *PluginDatabase) hasPermission(perm Permission) bool {
-	for _, p := range pd.permissions {
+	return hasPermission(pd.permissions, perm)
+}
+
+// hasPermission reports whether perm is among permissions
+func hasPermission(permissions []Permission, perm Permission) bool {
+	for _, p := range permissions {
 		if p == perm {
 			return true
 		}
@@ -472,3 +479,49 @@ func (pr *PluginRegistry) Unregister(name string) error {
 	delete(pr.plugins, name)
 	return nil
 }
+
+// StdLogger implements Logger on top of the standard library log package
+type StdLogger struct{}
+
+// NewStdLogger creates a logger that writes through log.Printf
+func NewStdLogger() *StdLogger {
+	return &StdLogger{}
+}
+
+func (l *StdLogger) Info(msg string, fields ...interface{}) {
+	log.Printf("[PLUGINS] INFO %s %v", msg, fields)
+}
+
+func (l *StdLogger) Warn(msg string, fields ...interface{}) {
+	log.Printf("[PLUGINS] WARN %s %v", msg, fields)
+}
+
+func (l *StdLogger) Error(msg string, fields ...interface{}) {
+	log.Printf("[PLUGINS] ERROR %s %v", msg, fields)
+}
+
+func (l *StdLogger) Debug(msg string, fields ...interface{}) {
+	log.Printf("[PLUGINS] DEBUG %s %v", msg, fields)
+}
+
+// SQLDatabase adapts a *sql.DB to the plugin Database interface
+type SQLDatabase struct {
+	db *sql.DB
+}
+
+// NewSQLDatabase wraps a *sql.DB for use by the plugin manager
+func NewSQLDatabase(db *sql.DB) *SQLDatabase {
+	return &SQLDatabase{db: db}
+}
+
+func (sd *SQLDatabase) Query(query string, args ...interface{}) (Rows, error) {
+	return sd.db.Query(query, args...)
+}
+
+func (sd *SQLDatabase) QueryRow(query string, args ...interface{}) Row {
+	return sd.db.QueryRow(query, args...)
+}
+
+func (sd *SQLDatabase) Exec(query string, args ...interface{}) (Result, error) {
+	return sd.db.Exec(query, args...)
+}
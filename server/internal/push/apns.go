@@ -0,0 +1,166 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// APNsConfig configures token-based authentication with APNs
+type APNsConfig struct {
+	KeyFile string // .p8 signing key downloaded from the Apple developer portal
+	KeyID   string
+	TeamID  string
+	Topic   string // the app bundle ID
+	Sandbox bool
+}
+
+// APNsProvider sends notifications through the APNs HTTP/2 API
+type APNsProvider struct {
+	config   APNsConfig
+	key      *ecdsa.PrivateKey
+	endpoint string
+	client   *http.Client
+
+	mutex   sync.Mutex
+	bearer  string
+	expires time.Time
+}
+
+// NewAPNsProvider creates an APNs provider from a signing key
+func NewAPNsProvider(config APNsConfig) (*APNsProvider, error) {
+	if config.KeyID == "" || config.TeamID == "" || config.Topic == "" {
+		return nil, fmt.Errorf("APNs needs a key ID, team ID and topic")
+	}
+	data, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs signing key: %w", err)
+	}
+
+	endpoint := "https://api.push.apple.com"
+	if config.Sandbox {
+		endpoint = "https://api.sandbox.push.apple.com"
+	}
+	return &APNsProvider{
+		config:   config,
+		key:      key,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform name
+func (p *APNsProvider) Platform() string {
+	return PlatformAPNs
+}
+
+// token returns the provider authentication token. APNs rejects tokens
+// older than an hour and throttles ones refreshed more than every twenty
+// minutes, so it is reused for fifty.
+func (p *APNsProvider) token() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.bearer != "" && time.Now().Before(p.expires) {
+		return p.bearer, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.config.TeamID,
+		"iat": time.Now().Unix(),
+	})
+	token.Header["kid"] = p.config.KeyID
+	bearer, err := token.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	p.bearer = bearer
+	p.expires = time.Now().Add(50 * time.Minute)
+	return bearer, nil
+}
+
+// Send delivers a notification to one device token
+func (p *APNsProvider) Send(ctx context.Context, token string, notification Notification) error {
+	bearer, err := p.token()
+	if err != nil {
+		return err
+	}
+
+	aps := map[string]interface{}{
+		"alert": map[string]string{
+			"title": notification.Title,
+			"body":  notification.Body,
+		},
+		"sound": "default",
+	}
+	if notification.Badge >= 0 {
+		aps["badge"] = notification.Badge
+	}
+	if notification.CollapseKey != "" {
+		aps["thread-id"] = notification.CollapseKey
+	}
+	payload := map[string]interface{}{"aps": aps}
+	for key, value := range notification.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+bearer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", p.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	if notification.CollapseKey != "" {
+		req.Header.Set("apns-collapse-id", notification.CollapseKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		failure.Reason == "BadDeviceToken",
+		failure.Reason == "Unregistered",
+		failure.Reason == "DeviceTokenNotForTopic":
+		return ErrInvalidToken
+	case failure.Reason == "ExpiredProviderToken":
+		p.mutex.Lock()
+		p.bearer = ""
+		p.mutex.Unlock()
+	}
+	return fmt.Errorf("%s: %s", resp.Status, failure.Reason)
+}
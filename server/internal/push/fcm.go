@@ -0,0 +1,223 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// FCMCredentials is the subset of a Google service account key file used
+// to authorize against the FCM HTTP v1 API
+type FCMCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// LoadFCMCredentials reads a service account key file
+func LoadFCMCredentials(path string) (FCMCredentials, error) {
+	var credentials FCMCredentials
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return credentials, err
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return credentials, fmt.Errorf("invalid service account file: %w", err)
+	}
+	return credentials, nil
+}
+
+// FCMProvider sends notifications through the FCM HTTP v1 API
+type FCMProvider struct {
+	credentials FCMCredentials
+	key         *rsa.PrivateKey
+	endpoint    string
+	client      *http.Client
+
+	mutex       sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+// NewFCMProvider creates an FCM provider from service account credentials
+func NewFCMProvider(credentials FCMCredentials) (*FCMProvider, error) {
+	if credentials.ProjectID == "" || credentials.ClientEmail == "" || credentials.PrivateKey == "" {
+		return nil, fmt.Errorf("FCM credentials need project_id, client_email and private_key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(credentials.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+	if credentials.TokenURI == "" {
+		credentials.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &FCMProvider{
+		credentials: credentials,
+		key:         key,
+		endpoint:    "https://fcm.googleapis.com",
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform name
+func (p *FCMProvider) Platform() string {
+	return PlatformFCM
+}
+
+// token returns a cached OAuth access token, exchanging a signed service
+// account assertion for a new one when it is about to expire
+func (p *FCMProvider) token(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.accessToken != "" && time.Until(p.expires) > time.Minute {
+		return p.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.credentials.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud":   p.credentials.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.credentials.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token exchange failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	p.accessToken = result.AccessToken
+	p.expires = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return p.accessToken, nil
+}
+
+// Send delivers a notification to one registration token
+func (p *FCMProvider) Send(ctx context.Context, token string, notification Notification) error {
+	accessToken, err := p.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	android := map[string]interface{}{"priority": "high"}
+	androidNotification := map[string]interface{}{}
+	if notification.CollapseKey != "" {
+		android["collapse_key"] = notification.CollapseKey
+		androidNotification["tag"] = notification.CollapseKey
+	}
+	if notification.Badge >= 0 {
+		androidNotification["notification_count"] = notification.Badge
+	}
+	android["notification"] = androidNotification
+
+	message := map[string]interface{}{
+		"token": token,
+		"notification": map[string]string{
+			"title": notification.Title,
+			"body":  notification.Body,
+		},
+		"android": android,
+	}
+	if len(notification.Data) > 0 {
+		message["data"] = notification.Data
+	}
+	// FCM forwards to APNs for iOS apps registered with Firebase
+	aps := map[string]interface{}{}
+	if notification.Badge >= 0 {
+		aps["badge"] = notification.Badge
+	}
+	apns := map[string]interface{}{"payload": map[string]interface{}{"aps": aps}}
+	if notification.CollapseKey != "" {
+		apns["headers"] = map[string]string{"apns-collapse-id": notification.CollapseKey}
+	}
+	message["apns"] = apns
+
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.endpoint+"/v1/projects/"+url.PathEscape(p.credentials.ProjectID)+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var failure struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+	for _, detail := range failure.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" || (detail.ErrorCode == "INVALID_ARGUMENT" && strings.Contains(failure.Error.Message, "token")) {
+			return ErrInvalidToken
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrInvalidToken
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Force a new access token on the next send
+		p.mutex.Lock()
+		p.accessToken = ""
+		p.mutex.Unlock()
+	}
+	return fmt.Errorf("%s: %s %s", resp.Status, failure.Error.Status, failure.Error.Message)
+}
@@ -0,0 +1,131 @@
+// Package push delivers mobile notifications through Firebase Cloud
+// Messaging and the Apple Push Notification service, or through self-hosted
+// ntfy and Gotify servers.
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Platforms
+const (
+	PlatformFCM    = "fcm"
+	PlatformAPNs   = "apns"
+	PlatformNtfy   = "ntfy"
+	PlatformGotify = "gotify"
+)
+
+// ErrInvalidToken is returned when the push service reports that a device
+// token is no longer valid; the token should be forgotten
+var ErrInvalidToken = errors.New("device token is no longer valid")
+
+// ErrUnsupportedPlatform is returned for platforms without a provider
+var ErrUnsupportedPlatform = errors.New("push platform is not configured")
+
+// Notification is a platform-independent alert
+type Notification struct {
+	Title string
+	Body  string
+
+	// CollapseKey lets the device replace an older undelivered
+	// notification with the same key instead of stacking them
+	CollapseKey string
+
+	// Badge is the app icon badge count; negative leaves it unchanged
+	Badge int
+
+	Data map[string]string
+}
+
+// Provider sends notifications to one platform
+type Provider interface {
+	Platform() string
+	Send(ctx context.Context, token string, notification Notification) error
+}
+
+// Stats counts deliveries for one platform since startup
+type Stats struct {
+	Sent        int64 `json:"sent"`
+	Failed      int64 `json:"failed"`
+	Invalidated int64 `json:"invalidated"`
+}
+
+// Gateway routes notifications to the provider of each device's platform
+type Gateway struct {
+	providers map[string]Provider
+
+	mutex sync.Mutex
+	stats map[string]*Stats
+}
+
+// NewGateway creates a gateway for the given providers
+func NewGateway(providers ...Provider) *Gateway {
+	g := &Gateway{
+		providers: make(map[string]Provider),
+		stats:     make(map[string]*Stats),
+	}
+	for _, provider := range providers {
+		g.providers[provider.Platform()] = provider
+		g.stats[provider.Platform()] = &Stats{}
+	}
+	return g
+}
+
+// Supports reports whether a platform has a provider
+func (g *Gateway) Supports(platform string) bool {
+	_, ok := g.providers[platform]
+	return ok
+}
+
+// Platforms returns the configured platforms
+func (g *Gateway) Platforms() []string {
+	platforms := make([]string, 0, len(g.providers))
+	for _, platform := range []string{PlatformFCM, PlatformAPNs, PlatformNtfy, PlatformGotify} {
+		if g.Supports(platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+// Send delivers a notification to one device
+func (g *Gateway) Send(ctx context.Context, platform, token string, notification Notification) error {
+	provider, ok := g.providers[platform]
+	if !ok {
+		return ErrUnsupportedPlatform
+	}
+
+	err := provider.Send(ctx, token, notification)
+
+	g.mutex.Lock()
+	stats := g.stats[platform]
+	switch {
+	case err == nil:
+		stats.Sent++
+	case errors.Is(err, ErrInvalidToken):
+		stats.Invalidated++
+	default:
+		stats.Failed++
+	}
+	g.mutex.Unlock()
+
+	if err != nil && !errors.Is(err, ErrInvalidToken) {
+		return fmt.Errorf("%s: %w", platform, err)
+	}
+	return err
+}
+
+// Stats returns delivery counts per platform
+func (g *Gateway) Stats() map[string]Stats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stats := make(map[string]Stats, len(g.stats))
+	for platform, counts := range g.stats {
+		stats[platform] = *counts
+	}
+	return stats
+}
@@ -0,0 +1,257 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubProvider returns a fixed error for every send
+type stubProvider struct {
+	platform string
+	err      error
+}
+
+func (p *stubProvider) Platform() string { return p.platform }
+
+func (p *stubProvider) Send(ctx context.Context, token string, notification Notification) error {
+	return p.err
+}
+
+func TestGatewayStats(t *testing.T) {
+	fcm := &stubProvider{platform: PlatformFCM}
+	apns := &stubProvider{platform: PlatformAPNs, err: ErrInvalidToken}
+	gateway := NewGateway(fcm, apns)
+
+	if got := gateway.Platforms(); len(got) != 2 || got[0] != PlatformFCM || got[1] != PlatformAPNs {
+		t.Fatalf("Unexpected platforms: %v", got)
+	}
+
+	ctx := context.Background()
+	if err := gateway.Send(ctx, PlatformFCM, "a", Notification{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := gateway.Send(ctx, PlatformAPNs, "b", Notification{}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token, got %v", err)
+	}
+	fcm.err = errors.New("boom")
+	if err := gateway.Send(ctx, PlatformFCM, "a", Notification{}); err == nil || !strings.HasPrefix(err.Error(), "fcm:") {
+		t.Errorf("Expected wrapped provider error, got %v", err)
+	}
+	if err := gateway.Send(ctx, "webpush", "c", Notification{}); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Errorf("Expected unsupported platform, got %v", err)
+	}
+
+	stats := gateway.Stats()
+	if stats[PlatformFCM] != (Stats{Sent: 1, Failed: 1}) {
+		t.Errorf("Unexpected FCM stats: %+v", stats[PlatformFCM])
+	}
+	if stats[PlatformAPNs] != (Stats{Invalidated: 1}) {
+		t.Errorf("Unexpected APNs stats: %+v", stats[PlatformAPNs])
+	}
+}
+
+func TestFCMProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	tokenRequests := 0
+	var message map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+				t.Errorf("Unexpected token request: %v", r.Form)
+			}
+			_, _ = w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+		case "/v1/projects/demo/messages:send":
+			if r.Header.Get("Authorization") != "Bearer access" {
+				t.Errorf("Unexpected authorization %q", r.Header.Get("Authorization"))
+			}
+			var body map[string]map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			message = body["message"]
+			if message["token"] == "stale" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"status":"NOT_FOUND","message":"Requested entity was not found.","details":[{"errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"projects/demo/messages/1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewFCMProvider(FCMCredentials{
+		ProjectID:   "demo",
+		ClientEmail: "push@demo.iam.gserviceaccount.com",
+		PrivateKey:  string(keyPEM),
+		TokenURI:    server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.endpoint = server.URL
+
+	notification := Notification{Title: "bob in #general", Body: "hey @alice", CollapseKey: "channel-3", Badge: 4, Data: map[string]string{"channel_id": "3"}}
+	if err := provider.Send(context.Background(), "device", notification); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	android := message["android"].(map[string]interface{})
+	if android["collapse_key"] != "channel-3" {
+		t.Errorf("Expected collapse key, got %v", android)
+	}
+	if count := android["notification"].(map[string]interface{})["notification_count"]; count != float64(4) {
+		t.Errorf("Expected notification count 4, got %v", count)
+	}
+	if message["data"].(map[string]interface{})["channel_id"] != "3" {
+		t.Errorf("Expected data payload, got %v", message["data"])
+	}
+
+	if err := provider.Send(context.Background(), "stale", notification); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token, got %v", err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("Expected the access token to be cached, got %d exchanges", tokenRequests)
+	}
+}
+
+func TestAPNsProvider(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "AuthKey.p8")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var headers http.Header
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		switch r.URL.Path {
+		case "/3/device/gone":
+			w.WriteHeader(http.StatusGone)
+			_, _ = w.Write([]byte(`{"reason":"Unregistered"}`))
+		case "/3/device/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"reason":"BadDeviceToken"}`))
+		case "/3/device/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"reason":"TooManyRequests"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewAPNsProvider(APNsConfig{KeyFile: keyFile, KeyID: "ABC123", TeamID: "TEAM", Topic: "chat.fethur.app", Sandbox: true})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.endpoint = server.URL
+
+	notification := Notification{Title: "bob", Body: "hey", CollapseKey: "channel-3", Badge: 2, Data: map[string]string{"channel_id": "3"}}
+	if err := provider.Send(context.Background(), "device", notification); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if headers.Get("apns-topic") != "chat.fethur.app" || headers.Get("apns-collapse-id") != "channel-3" || headers.Get("apns-push-type") != "alert" {
+		t.Errorf("Unexpected headers: %v", headers)
+	}
+	if !strings.HasPrefix(headers.Get("Authorization"), "bearer ") {
+		t.Errorf("Expected bearer token, got %q", headers.Get("Authorization"))
+	}
+	aps := payload["aps"].(map[string]interface{})
+	if aps["badge"] != float64(2) || payload["channel_id"] != "3" {
+		t.Errorf("Unexpected payload: %v", payload)
+	}
+
+	for _, token := range []string{"gone", "bad"} {
+		if err := provider.Send(context.Background(), token, notification); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected invalid token for %s, got %v", token, err)
+		}
+	}
+	if err := provider.Send(context.Background(), "busy", notification); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a temporary failure, got %v", err)
+	}
+}
+
+func TestRelayProviders(t *testing.T) {
+	var title, body, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alerts":
+			title, auth = r.Header.Get("Title"), r.Header.Get("Authorization")
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+		case "/message":
+			if r.Header.Get("X-Gotify-Key") != "app-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var message map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&message)
+			title, body = message["title"].(string), message["message"].(string)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	notification := Notification{Title: "bob in #general", Body: "hey @alice"}
+
+	ntfy, err := NewNtfyProvider(server.URL+"/", "tk_secret")
+	if err != nil {
+		t.Fatalf("Failed to create ntfy provider: %v", err)
+	}
+	if err := ntfy.Send(context.Background(), "alerts", notification); err != nil {
+		t.Fatalf("ntfy send failed: %v", err)
+	}
+	if title != notification.Title || body != notification.Body || auth != "Bearer tk_secret" {
+		t.Errorf("Unexpected ntfy publish: %q %q %q", title, body, auth)
+	}
+	if err := ntfy.Send(context.Background(), "private", notification); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a forbidden topic to be invalid, got %v", err)
+	}
+
+	gotify, err := NewGotifyProvider(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create Gotify provider: %v", err)
+	}
+	title, body = "", ""
+	if err := gotify.Send(context.Background(), "app-token", notification); err != nil {
+		t.Fatalf("Gotify send failed: %v", err)
+	}
+	if title != notification.Title || body != notification.Body {
+		t.Errorf("Unexpected Gotify message: %q %q", title, body)
+	}
+	if err := gotify.Send(context.Background(), "revoked", notification); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a revoked token to be invalid, got %v", err)
+	}
+
+	if _, err := NewNtfyProvider("ntfy.example.com", ""); err == nil {
+		t.Error("Expected a URL without scheme to be rejected")
+	}
+}
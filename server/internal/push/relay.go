@@ -0,0 +1,125 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NtfyProvider publishes notifications to topics on an ntfy server. The
+// token passed to Send is the user's topic.
+type NtfyProvider struct {
+	baseURL     string
+	accessToken string
+	client      *http.Client
+}
+
+// NewNtfyProvider creates an ntfy provider. accessToken is optional and
+// only needed when the server restricts publishing.
+func NewNtfyProvider(baseURL, accessToken string) (*NtfyProvider, error) {
+	baseURL, err := relayBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &NtfyProvider{
+		baseURL:     baseURL,
+		accessToken: accessToken,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform name
+func (p *NtfyProvider) Platform() string {
+	return PlatformNtfy
+}
+
+// Send publishes a notification to one topic
+func (p *NtfyProvider) Send(ctx context.Context, topic string, notification Notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/"+url.PathEscape(topic), strings.NewReader(notification.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", notification.Title)
+	req.Header.Set("Tags", "speech_balloon")
+	if p.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.accessToken)
+	}
+	return relayResponse(p.client.Do(req))
+}
+
+// GotifyProvider publishes notifications to a Gotify server. The token
+// passed to Send is the application token the user created for Fethur.
+type GotifyProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewGotifyProvider creates a Gotify provider
+func NewGotifyProvider(baseURL string) (*GotifyProvider, error) {
+	baseURL, err := relayBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &GotifyProvider{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform name
+func (p *GotifyProvider) Platform() string {
+	return PlatformGotify
+}
+
+// Send publishes a notification with one application token
+func (p *GotifyProvider) Send(ctx context.Context, token string, notification Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    notification.Title,
+		"message":  notification.Body,
+		"priority": 5,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", token)
+	return relayResponse(p.client.Do(req))
+}
+
+// relayBaseURL validates a self-hosted server URL
+func relayBaseURL(value string) (string, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q", value)
+	}
+	return strings.TrimRight(value, "/"), nil
+}
+
+// relayResponse maps a publish response to an error. A rejected token or
+// topic means the user's configuration is stale.
+func relayResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
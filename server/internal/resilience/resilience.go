@@ -0,0 +1,448 @@
+// Package resilience keeps the external services the server depends on
+// (mail providers, push gateways, webhook receivers, bridges) from taking
+// it down with them.
+//
+// A Guard wraps the calls to one service. Its bulkhead bounds how many
+// calls may be in flight so a slow service ties up a few workers rather
+// than all of them; its circuit breaker stops calling a service that keeps
+// failing until it has rested; and failed calls are retried with jittered
+// exponential backoff. A Registry holds the guards of every integration so
+// their health can be reported together.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors returned by guarded calls that were not attempted
+var (
+	ErrCircuitOpen  = errors.New("circuit open")
+	ErrBulkheadFull = errors.New("too many calls in flight")
+)
+
+// permanentError wraps an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error that retrying cannot fix, such as a request the
+// service rejected. The service did answer, so it does not count against
+// its circuit.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Backoff is an exponential backoff with jitter
+type Backoff struct {
+	Base time.Duration // ceiling of the first wait
+	Max  time.Duration // longest wait
+}
+
+// Delay returns the wait before retry n, counting from 0. The ceiling
+// doubles with every retry up to Max; half of it is waited for certain and
+// half at random, so callers that failed together do not retry together.
+func (b Backoff) Delay(n int) time.Duration {
+	ceiling := b.Base
+	for i := 0; i < n && (b.Max <= 0 || ceiling < b.Max); i++ {
+		ceiling *= 2
+	}
+	if b.Max > 0 && ceiling > b.Max {
+		ceiling = b.Max
+	}
+	if ceiling <= 1 {
+		return ceiling
+	}
+	half := ceiling / 2
+	return half + time.Duration(rand.Int63n(int64(ceiling-half)))
+}
+
+// Retry calls fn up to attempts times, waiting with backoff in between. It
+// stops at the first success or permanent error, or when ctx is done, and
+// returns the last error.
+func Retry(ctx context.Context, attempts int, backoff Backoff, fn func(ctx context.Context) error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff.Delay(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		if err = fn(ctx); err == nil || IsPermanent(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Circuit states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// Breaker is a circuit breaker. After threshold consecutive failures it
+// opens and refuses calls for the cooldown, then lets one probe through;
+// the probe's outcome closes it or opens it again. A breaker without a
+// threshold never opens. It is safe for concurrent use.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker creates a closed breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go ahead. A call that is allowed must
+// be finished with Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a call that worked and closes the circuit
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a call that failed, opening the circuit at the threshold
+func (b *Breaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	b.probing = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Release ends a call without an outcome, such as one the caller abandoned
+func (b *Breaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// State returns the circuit state, the consecutive failures and, while
+// the circuit is open, when it lets a probe through
+func (b *Breaker) State() (string, int, time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case b.threshold <= 0 || b.failures < b.threshold:
+		return StateClosed, b.failures, time.Time{}
+	case b.probing || !time.Now().Before(b.openUntil):
+		return StateHalfOpen, b.failures, time.Time{}
+	default:
+		return StateOpen, b.failures, b.openUntil
+	}
+}
+
+// Bulkhead bounds how many calls run at once. Calls beyond the limit are
+// refused rather than queued. A nil Bulkhead admits every call.
+type Bulkhead struct {
+	slots chan struct{}
+}
+
+// NewBulkhead creates a bulkhead for limit concurrent calls, or nil for no
+// limit
+func NewBulkhead(limit int) *Bulkhead {
+	if limit <= 0 {
+		return nil
+	}
+	return &Bulkhead{slots: make(chan struct{}, limit)}
+}
+
+// Acquire takes a slot if one is free
+func (b *Bulkhead) Acquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken with Acquire
+func (b *Bulkhead) Release() {
+	if b != nil {
+		<-b.slots
+	}
+}
+
+// InFlight returns the number of slots taken
+func (b *Bulkhead) InFlight() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.slots)
+}
+
+// Limit returns the number of slots, 0 for none
+func (b *Bulkhead) Limit() int {
+	if b == nil {
+		return 0
+	}
+	return cap(b.slots)
+}
+
+// Policy configures a Guard. Zero fields switch the matching protection
+// off: one attempt, no circuit breaker, no concurrency limit.
+type Policy struct {
+	Attempts         int           // calls per Do, counting the first
+	Backoff          Backoff       // wait between attempts
+	FailureThreshold int           // consecutive failures that open the circuit
+	Cooldown         time.Duration // how long an open circuit rests
+	MaxConcurrent    int           // calls in flight at once
+}
+
+// Guard protects the calls to one service. It is safe for concurrent use.
+type Guard struct {
+	name     string
+	policy   Policy
+	breaker  *Breaker
+	bulkhead *Bulkhead
+
+	mutex       sync.Mutex
+	calls       int64
+	failures    int64
+	rejected    int64
+	lastError   string
+	lastFailure time.Time
+	lastSuccess time.Time
+}
+
+// NewGuard creates a guard for the named service
+func NewGuard(name string, policy Policy) *Guard {
+	return &Guard{
+		name:     name,
+		policy:   policy,
+		breaker:  NewBreaker(policy.FailureThreshold, policy.Cooldown),
+		bulkhead: NewBulkhead(policy.MaxConcurrent),
+	}
+}
+
+// Name returns the service name
+func (g *Guard) Name() string {
+	return g.name
+}
+
+// Do calls fn under the guard's policy. Without a free slot or with an
+// open circuit it returns ErrBulkheadFull or ErrCircuitOpen without
+// calling fn; otherwise it returns fn's last error, unwrapped if it was
+// marked Permanent.
+func (g *Guard) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !g.bulkhead.Acquire() {
+		g.reject()
+		return ErrBulkheadFull
+	}
+	defer g.bulkhead.Release()
+
+	attempted := false
+	err := Retry(ctx, g.policy.Attempts, g.policy.Backoff, func(ctx context.Context) error {
+		if !g.breaker.Allow() {
+			return Permanent(ErrCircuitOpen)
+		}
+		attempted = true
+		err := fn(ctx)
+		switch {
+		case err == nil || IsPermanent(err):
+			g.breaker.Success()
+		case errors.Is(err, context.Canceled):
+			// Abandoned calls say nothing about the service
+			g.breaker.Release()
+		default:
+			g.breaker.Failure()
+		}
+		return err
+	})
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		err = permanent.err
+	}
+	if !attempted {
+		g.reject()
+		return err
+	}
+	g.record(err)
+	return err
+}
+
+// Record reports the outcome of work done outside Do, such as a
+// connection that was established or dropped, to the guard's circuit and
+// statistics
+func (g *Guard) Record(err error) {
+	if err == nil {
+		g.breaker.Success()
+	} else {
+		g.breaker.Failure()
+	}
+	g.record(err)
+}
+
+func (g *Guard) record(err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.calls++
+	if err == nil {
+		g.lastSuccess = time.Now()
+		return
+	}
+	g.failures++
+	g.lastError = err.Error()
+	g.lastFailure = time.Now()
+}
+
+func (g *Guard) reject() {
+	g.mutex.Lock()
+	g.rejected++
+	g.mutex.Unlock()
+}
+
+// Health is a snapshot of a guard or circuit
+type Health struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	Calls               int64      `json:"calls"`
+	Failures            int64      `json:"failures"`
+	Rejected            int64      `json:"rejected"`
+	InFlight            int        `json:"in_flight"`
+	MaxConcurrent       int        `json:"max_concurrent,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+}
+
+// Health returns a snapshot of the guard
+func (g *Guard) Health() Health {
+	health := BreakerHealth(g.name, g.breaker)
+	health.InFlight = g.bulkhead.InFlight()
+	health.MaxConcurrent = g.bulkhead.Limit()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	health.Calls = g.calls
+	health.Failures = g.failures
+	health.Rejected = g.rejected
+	health.LastError = g.lastError
+	health.LastFailure = timePointer(g.lastFailure)
+	health.LastSuccess = timePointer(g.lastSuccess)
+	return health
+}
+
+// BreakerHealth describes a lone breaker, such as one of many per-host
+// circuits
+func BreakerHealth(name string, b *Breaker) Health {
+	state, failures, retryAt := b.State()
+	return Health{
+		Name:                name,
+		State:               state,
+		ConsecutiveFailures: failures,
+		RetryAt:             timePointer(retryAt),
+	}
+}
+
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// Registry holds guards by name. It is safe for concurrent use.
+type Registry struct {
+	mutex  sync.Mutex
+	guards map[string]*Guard
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{guards: make(map[string]*Guard)}
+}
+
+// Guard returns the named guard, creating it with policy on first use
+func (r *Registry) Guard(name string, policy Policy) *Guard {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if guard, ok := r.guards[name]; ok {
+		return guard
+	}
+	guard := NewGuard(name, policy)
+	r.guards[name] = guard
+	return guard
+}
+
+// Health returns a snapshot of every guard, by name
+func (r *Registry) Health() []Health {
+	r.mutex.Lock()
+	guards := make([]*Guard, 0, len(r.guards))
+	for _, guard := range r.guards {
+		guards = append(guards, guard)
+	}
+	r.mutex.Unlock()
+
+	health := make([]Health, 0, len(guards))
+	for _, guard := range guards {
+		health = append(health, guard.Health())
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Name < health[j].Name
+	})
+	return health
+}
@@ -0,0 +1,176 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("service down")
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Base: 100 * time.Millisecond, Max: time.Second}
+	for n, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if delay := backoff.Delay(n); delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("Delay(%d) = %v, want between %v and %v", n, delay, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	backoff := Backoff{Base: time.Millisecond, Max: time.Millisecond}
+
+	calls := 0
+	err := Retry(context.Background(), 3, backoff, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errDown
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), 3, backoff, func(ctx context.Context) error {
+		calls++
+		return Permanent(errDown)
+	})
+	if !errors.Is(err, errDown) || calls != 1 {
+		t.Errorf("Expected a permanent error to stop retries, got %v after %d calls", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = Retry(ctx, 3, Backoff{Base: time.Hour}, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errDown
+	})
+	if !errors.Is(err, errDown) || calls != 1 {
+		t.Errorf("Expected cancellation to stop retries, got %v after %d calls", err, calls)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, 20*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatal("Expected a closed breaker to allow calls")
+		}
+		b.Failure()
+	}
+	if state, failures, retryAt := b.State(); state != StateOpen || failures != 2 || retryAt.IsZero() {
+		t.Fatalf("Expected the breaker to open, got %s with %d failures", state, failures)
+	}
+	if b.Allow() {
+		t.Fatal("Expected an open breaker to refuse calls")
+	}
+
+	// After the cooldown exactly one probe goes through
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() || b.Allow() {
+		t.Fatal("Expected a single probe after the cooldown")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("Expected a failed probe to reopen the breaker")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Expected another probe after the cooldown")
+	}
+	b.Success()
+	if state, _, _ := b.State(); state != StateClosed || !b.Allow() {
+		t.Errorf("Expected a successful probe to close the breaker, got %s", state)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	g := NewGuard("mail", Policy{
+		Attempts:         2,
+		Backoff:          Backoff{Base: time.Millisecond},
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+	})
+
+	calls := 0
+	if err := g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return Permanent(errDown)
+	}); err != errDown {
+		t.Errorf("Expected the permanent error unwrapped, got %v", err)
+	}
+
+	// Two failed attempts open the circuit, and the next call is refused
+	calls = 0
+	if err := g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errDown
+	}); !errors.Is(err, errDown) || calls != 2 {
+		t.Errorf("Expected two attempts, got %v after %d calls", err, calls)
+	}
+	if err := g.Do(context.Background(), func(ctx context.Context) error {
+		t.Error("Expected an open circuit not to call the service")
+		return nil
+	}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	health := g.Health()
+	if health.Name != "mail" || health.State != StateOpen || health.Calls != 2 || health.Failures != 2 ||
+		health.Rejected != 1 || health.LastError != errDown.Error() || health.RetryAt == nil {
+		t.Errorf("Unexpected health %+v", health)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	g := NewGuard("push:fcm", Policy{MaxConcurrent: 1})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = g.Do(context.Background(), func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	if err := g.Do(context.Background(), func(ctx context.Context) error { return nil }); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Expected a full bulkhead to refuse the call, got %v", err)
+	}
+	if health := g.Health(); health.InFlight != 1 || health.MaxConcurrent != 1 {
+		t.Errorf("Unexpected health %+v", health)
+	}
+
+	close(release)
+	wg.Wait()
+	if err := g.Do(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("Expected the freed slot to be reused, got %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	mail := r.Guard("mail", Policy{})
+	if r.Guard("mail", Policy{Attempts: 5}) != mail {
+		t.Error("Expected the registry to return the existing guard")
+	}
+	r.Guard("bridge:xmpp", Policy{}).Record(errDown)
+
+	health := r.Health()
+	if len(health) != 2 || health[0].Name != "bridge:xmpp" || health[0].Failures != 1 || health[1].Name != "mail" {
+		t.Errorf("Unexpected health %+v", health)
+	}
+}
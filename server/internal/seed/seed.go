@@ -0,0 +1,277 @@
+// Package seed fills a database with realistic demo data for development
+// and load testing. The same seed always produces the same data.
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"fethur/internal/snowflake"
+)
+
+// Options controls how much data is generated
+type Options struct {
+	Users             int
+	Servers           int
+	ChannelsPerServer int
+	Messages          int
+	Seed              int64
+	Password          string    // shared by every seeded user
+	Days              int       // messages are spread over this many days
+	Until             time.Time // timestamp of the newest message
+}
+
+// DefaultOptions returns the options used by `fethur seed` without flags
+func DefaultOptions() Options {
+	return Options{
+		Users:             50,
+		Servers:           5,
+		ChannelsPerServer: 4,
+		Messages:          10000,
+		Seed:              1,
+		Password:          "fethur-demo-1",
+		Days:              30,
+		Until:             time.Now().UTC().Truncate(24 * time.Hour),
+	}
+}
+
+// Summary reports what was inserted
+type Summary struct {
+	Users         int `json:"users"`
+	Servers       int `json:"servers"`
+	Channels      int `json:"channels"`
+	VoiceChannels int `json:"voice_channels"`
+	Members       int `json:"members"`
+	Messages      int `json:"messages"`
+}
+
+const timestampLayout = "2006-01-02 15:04:05"
+
+var (
+	firstNames = []string{
+		"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy",
+		"mallory", "niaj", "olivia", "peggy", "quinn", "rupert", "sybil", "trent", "uma", "victor",
+		"wendy", "xavier", "yara", "zoe",
+	}
+	serverNames = []string{
+		"Rust Enjoyers", "Weekend Hikers", "Indie Game Dev", "Homelab", "Book Club",
+		"Synth Nerds", "Coffee Snobs", "Speedrunning", "Photography", "Open Source Lounge",
+	}
+	channelNames = []string{"random", "help", "showcase", "off-topic", "announcements", "dev", "memes", "links"}
+
+	openers  = []string{"honestly", "ok so", "quick question:", "fwiw", "lol", "update:", "heads up,", "hmm,", "TIL", "not gonna lie,"}
+	subjects = []string{"the new build", "my setup", "that PR", "the meetup", "this weekend", "the docs", "the last release", "your screenshot", "the benchmark", "the playlist"}
+	verbs    = []string{"looks great", "is broken again", "finally works", "needs another pass", "is way faster now", "made my day", "confuses me", "is worth a try", "got merged", "is on my list"}
+	closers  = []string{"", "", "", " :)", " 🎉", "!", "?", " — thoughts?", " brb", " thanks!"}
+)
+
+// generator derives every value from a single random source, so the data
+// only depends on the seed and the order of calls
+type generator struct {
+	rng *rand.Rand
+}
+
+func newGenerator(seed int64) *generator {
+	return &generator{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (g *generator) pick(values []string) string {
+	return values[g.rng.Intn(len(values))]
+}
+
+// Username returns the name of the i-th seeded user (zero-based)
+func Username(i int) string {
+	return fmt.Sprintf("%s_%d", firstNames[i%len(firstNames)], i+1)
+}
+
+func serverName(i int) string {
+	name := serverNames[i%len(serverNames)]
+	if i >= len(serverNames) {
+		name = fmt.Sprintf("%s %d", name, i/len(serverNames)+1)
+	}
+	return name
+}
+
+func (g *generator) message() string {
+	text := fmt.Sprintf("%s %s %s%s", g.pick(openers), g.pick(subjects), g.pick(verbs), g.pick(closers))
+	if g.rng.Intn(10) == 0 {
+		text += fmt.Sprintf(" (see #%d)", g.rng.Intn(500)+1)
+	}
+	return text
+}
+
+// Run inserts the generated data in a single transaction. Usernames are
+// fixed for a given index, so seeding a database twice fails instead of
+// duplicating data.
+func Run(ctx context.Context, db *sql.DB, passwordHash string, options Options) (Summary, error) {
+	var summary Summary
+	if options.Users < 1 || options.Servers < 1 || options.ChannelsPerServer < 1 || options.Messages < 0 || options.Days < 1 {
+		return summary, fmt.Errorf("users, servers, channels and days must be positive")
+	}
+
+	g := newGenerator(options.Seed)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return summary, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	start := options.Until.Add(-time.Duration(options.Days) * 24 * time.Hour)
+	timestamp := func(t time.Time) string {
+		return t.UTC().Format(timestampLayout)
+	}
+
+	// Users join over the first part of the period
+	userIDs := make([]int64, options.Users)
+	for i := range userIDs {
+		joined := start.Add(time.Duration(i) * time.Minute)
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO users (username, email, password_hash, role, created_at, updated_at) VALUES (?, ?, ?, 'user', ?, ?)",
+			Username(i), Username(i)+"@example.com", passwordHash, timestamp(joined), timestamp(joined),
+		)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				return summary, fmt.Errorf("user %s already exists; seed an empty database", Username(i))
+			}
+			return summary, fmt.Errorf("failed to insert user: %w", err)
+		}
+		userIDs[i], _ = result.LastInsertId()
+		summary.Users++
+	}
+
+	type channel struct {
+		id      int64
+		members []int64
+		weight  int
+	}
+	channels := make([]channel, 0, options.Servers*options.ChannelsPerServer)
+	totalWeight := 0
+
+	for i := 0; i < options.Servers; i++ {
+		owner := userIDs[g.rng.Intn(len(userIDs))]
+		created := start.Add(time.Hour * time.Duration(i+1))
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO servers (name, description, owner_id, created_at) VALUES (?, ?, ?, ?)",
+			serverName(i), "Seeded demo server", owner, timestamp(created),
+		)
+		if err != nil {
+			return summary, fmt.Errorf("failed to insert server: %w", err)
+		}
+		serverID, _ := result.LastInsertId()
+		summary.Servers++
+
+		// The owner plus a random share of the other users, at least one
+		members := []int64{owner}
+		share := 0.3 + g.rng.Float64()*0.5
+		for _, userID := range userIDs {
+			if userID != owner && g.rng.Float64() < share {
+				members = append(members, userID)
+			}
+		}
+		for j, userID := range members {
+			role := "member"
+			if j == 0 {
+				role = "owner"
+			}
+			joined := created.Add(time.Duration(j) * time.Minute)
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO server_members (user_id, server_id, role, joined_at) VALUES (?, ?, ?, ?)",
+				userID, serverID, role, timestamp(joined),
+			); err != nil {
+				return summary, fmt.Errorf("failed to insert member: %w", err)
+			}
+			summary.Members++
+		}
+
+		for j := 0; j < options.ChannelsPerServer; j++ {
+			name := "general"
+			if j > 0 {
+				name = channelNames[(j-1)%len(channelNames)]
+				if j > len(channelNames) {
+					name = fmt.Sprintf("%s-%d", name, (j-1)/len(channelNames)+1)
+				}
+			}
+			result, err := tx.ExecContext(ctx,
+				"INSERT INTO channels (name, server_id, channel_type, created_at) VALUES (?, ?, 'text', ?)",
+				name, serverID, timestamp(created),
+			)
+			if err != nil {
+				return summary, fmt.Errorf("failed to insert channel: %w", err)
+			}
+			channelID, _ := result.LastInsertId()
+			summary.Channels++
+
+			// A few busy channels and a long tail of quiet ones, like real servers
+			weight := 1 + g.rng.Intn(10)
+			if j == 0 {
+				weight += 10
+			}
+			channels = append(channels, channel{id: channelID, members: members, weight: weight})
+			totalWeight += weight
+		}
+
+		// One voice channel per server for voice signaling load tests
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO channels (name, server_id, channel_type, created_at) VALUES ('lounge', ?, 'voice', ?)",
+			serverID, timestamp(created),
+		); err != nil {
+			return summary, fmt.Errorf("failed to insert voice channel: %w", err)
+		}
+		summary.VoiceChannels++
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO messages (id, content, user_id, channel_id, created_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return summary, err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	// Messages are evenly spread with jitter, in chronological order so
+	// IDs grow with time as they do in production. IDs are snowflakes made
+	// at each message's time, so they are the same on every run and stay
+	// clear of the server's; auto-increment IDs would follow the largest
+	// snowflake and collide with the next one generated.
+	ids, err := snowflake.NewGenerator(0)
+	if err != nil {
+		return summary, err
+	}
+	messageStart := start.Add(time.Duration(options.Servers+1) * time.Hour)
+	step := options.Until.Sub(messageStart) / time.Duration(options.Messages+1)
+	if step < 0 {
+		step = 0
+	}
+	for i := 0; i < options.Messages; i++ {
+		target := g.rng.Intn(totalWeight)
+		selected := channels[0]
+		for _, ch := range channels {
+			if target < ch.weight {
+				selected = ch
+				break
+			}
+			target -= ch.weight
+		}
+
+		author := selected.members[g.rng.Intn(len(selected.members))]
+		sent := messageStart.Add(step * time.Duration(i))
+		if step > time.Second {
+			sent = sent.Add(time.Duration(g.rng.Int63n(int64(step))))
+		}
+		if _, err := stmt.ExecContext(ctx, int64(ids.NextAt(sent)), g.message(), author, selected.id, timestamp(sent)); err != nil {
+			return summary, fmt.Errorf("failed to insert message: %w", err)
+		}
+		summary.Messages++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
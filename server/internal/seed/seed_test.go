@@ -0,0 +1,117 @@
+package seed
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fethur/internal/database"
+)
+
+func TestRunIsDeterministic(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	options := Options{
+		Users:             12,
+		Servers:           3,
+		ChannelsPerServer: 3,
+		Messages:          400,
+		Seed:              42,
+		Password:          "unused",
+		Days:              7,
+		Until:             time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	reset(t, db.DB)
+	summary, err := Run(context.Background(), db.DB, "hash", options)
+	if err != nil {
+		t.Fatalf("Failed to seed: %v", err)
+	}
+	if summary.Users != 12 || summary.Servers != 3 || summary.Channels != 9 || summary.VoiceChannels != 3 || summary.Messages != 400 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	first := digest(t, db.DB)
+
+	// Seeding twice must not duplicate users
+	if _, err := Run(context.Background(), db.DB, "hash", options); err == nil {
+		t.Error("Expected seeding a seeded database to fail")
+	}
+
+	reset(t, db.DB)
+	if _, err := Run(context.Background(), db.DB, "hash", options); err != nil {
+		t.Fatalf("Failed to seed again: %v", err)
+	}
+	if second := digest(t, db.DB); second != first {
+		t.Error("Expected the same seed to produce the same data")
+	}
+
+	reset(t, db.DB)
+	options.Seed = 43
+	if _, err := Run(context.Background(), db.DB, "hash", options); err != nil {
+		t.Fatalf("Failed to seed with another seed: %v", err)
+	}
+	if third := digest(t, db.DB); third == first {
+		t.Error("Expected another seed to produce different data")
+	}
+
+	// Every message author must be a member of the channel's server
+	var strangers int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM messages m
+		JOIN channels c ON c.id = m.channel_id
+		LEFT JOIN server_members sm ON sm.server_id = c.server_id AND sm.user_id = m.user_id
+		WHERE sm.id IS NULL`).Scan(&strangers); err != nil {
+		t.Fatalf("Failed to check authors: %v", err)
+	}
+	if strangers != 0 {
+		t.Errorf("Expected all authors to be members, found %d messages from non-members", strangers)
+	}
+}
+
+func reset(t *testing.T, db *sql.DB) {
+	t.Helper()
+	for _, table := range []string{"messages", "channels", "server_members", "servers", "users"} {
+		if _, err := db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatalf("Failed to clear %s: %v", table, err)
+		}
+		if _, err := db.Exec("DELETE FROM sqlite_sequence WHERE name = ?", table); err != nil {
+			t.Fatalf("Failed to reset %s sequence: %v", table, err)
+		}
+	}
+}
+
+func digest(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT m.id, m.content, u.username, c.name, m.created_at
+		FROM messages m JOIN users u ON u.id = m.user_id JOIN channels c ON c.id = m.channel_id
+		ORDER BY m.id`)
+	if err != nil {
+		t.Fatalf("Failed to read messages: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	hash := sha256.New()
+	for rows.Next() {
+		var id int
+		var content, username, channel, createdAt string
+		if err := rows.Scan(&id, &content, &username, &channel, &createdAt); err != nil {
+			t.Fatalf("Failed to scan message: %v", err)
+		}
+		fmt.Fprintf(hash, "%d|%s|%s|%s|%s\n", id, content, username, channel, createdAt)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Age gate. With age_gate on, registration asks for a date of birth and
+// refuses anyone younger than minimum_age. Accounts younger than
+// nsfw_minimum_age are flagged under age and do not see NSFW channels; see
+// channelVisibleSQL. The date itself is never stored: only the flag and a
+// salted hash of the birth year, from which the flag is reassessed at
+// sign-in as the user grows up or the threshold changes.
+
+// ageGateModes are the values of the age_gate setting: off asks nothing,
+// optional asks for a date of birth and required insists on one
+var ageGateModes = map[string]bool{"off": true, "optional": true, "required": true}
+
+const (
+	// maxAge is the oldest age a date of birth may give
+	maxAge = 120
+
+	defaultMinimumAge     = 13
+	defaultNSFWMinimumAge = 18
+
+	// dateOfBirthLayout is how dates of birth are written
+	dateOfBirthLayout = "2006-01-02"
+)
+
+// ageGateMode returns the age_gate setting, off when unset or invalid
+func (s *Server) ageGateMode() string {
+	mode, _ := s.db.GetSetting("age_gate")
+	if !ageGateModes[mode] {
+		return "off"
+	}
+	return mode
+}
+
+// nsfwMinimumAge is the age below which NSFW channels are hidden
+func (s *Server) nsfwMinimumAge() int {
+	return s.getIntSetting("nsfw_minimum_age", defaultNSFWMinimumAge)
+}
+
+// parseDateOfBirth reads a YYYY-MM-DD date of birth, refusing dates in the
+// future or more than maxAge years ago
+func parseDateOfBirth(value string, now time.Time) (time.Time, error) {
+	dob, err := time.Parse(dateOfBirthLayout, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, errors.New("date_of_birth must be a date like 2001-04-30")
+	}
+	if dob.After(now) || ageOn(dob, now) > maxAge {
+		return time.Time{}, errors.New("date_of_birth is not a plausible date of birth")
+	}
+	return dob, nil
+}
+
+// ageOn returns how old someone born on dob is on the day of now
+func ageOn(dob, now time.Time) int {
+	age := now.Year() - dob.Year()
+	if now.Month() < dob.Month() || (now.Month() == dob.Month() && now.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+// hashBirthYear returns a birth year hashed with a random salt, written as
+// "salt:hash" in hex. The salt keeps users born the same year from sharing
+// a hash.
+func hashBirthYear(year int) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(birthYearSum(salt, year)), nil
+}
+
+func birthYearSum(salt []byte, year int) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), strconv.Itoa(year)...))
+	return sum[:]
+}
+
+// birthYear finds the year a hash from hashBirthYear was made from, trying
+// every year a user could have been born in
+func birthYear(hash string, now time.Time) (int, bool) {
+	saltHex, sumHex, found := strings.Cut(hash, ":")
+	if !found {
+		return 0, false
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return 0, false
+	}
+	sum, err := hex.DecodeString(sumHex)
+	if err != nil {
+		return 0, false
+	}
+	for year := now.Year(); year >= now.Year()-maxAge-1; year-- {
+		if subtle.ConstantTimeCompare(birthYearSum(salt, year), sum) == 1 {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// checkAgeGate validates the date of birth given at registration. It
+// answers and returns false when the date is missing but required,
+// invalid, or makes the user younger than minimum_age. The date is nil
+// when none was given or the age gate is off.
+func (s *Server) checkAgeGate(c *gin.Context, value string) (*time.Time, bool) {
+	mode := s.ageGateMode()
+	if mode == "off" {
+		return nil, true
+	}
+	if strings.TrimSpace(value) == "" {
+		if mode == "required" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A date of birth is required", "code": "date_of_birth_required"})
+			return nil, false
+		}
+		return nil, true
+	}
+	now := time.Now()
+	dob, err := parseDateOfBirth(value, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if minimum := s.getIntSetting("minimum_age", defaultMinimumAge); ageOn(dob, now) < minimum {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("You must be at least %d to register", minimum),
+			"code":  "under_minimum_age",
+		})
+		return nil, false
+	}
+	return &dob, true
+}
+
+// recordAge stores whether a new user is under the NSFW age, and the hash
+// of their birth year. The date of birth is then forgotten.
+func (s *Server) recordAge(userID int, dob time.Time) error {
+	hash, err := hashBirthYear(dob.Year())
+	if err != nil {
+		return err
+	}
+	underAge := ageOn(dob, time.Now()) < s.nsfwMinimumAge()
+	_, err = s.db.Exec("UPDATE users SET under_age = ?, birth_year_hash = ? WHERE id = ?", underAge, hash, userID)
+	return err
+}
+
+// reassessAge updates the under age flag of a user who gave their date of
+// birth, from their birth year alone. Someone born in a year is one of two
+// ages today; the flag only changes when both are on the same side of
+// nsfw_minimum_age, so a user is let in the year after they come of age.
+func (s *Server) reassessAge(userID int) {
+	var underAge sql.NullBool
+	var hash string
+	if err := s.db.QueryRow(
+		"SELECT under_age, birth_year_hash FROM users WHERE id = ?", userID,
+	).Scan(&underAge, &hash); err != nil || hash == "" {
+		return
+	}
+	now := time.Now()
+	year, ok := birthYear(hash, now)
+	if !ok {
+		return
+	}
+
+	threshold := s.nsfwMinimumAge()
+	youngest, oldest := now.Year()-year-1, now.Year()-year
+	switch {
+	case underAge.Bool && youngest >= threshold:
+		underAge.Bool = false
+	case !underAge.Bool && oldest < threshold:
+		underAge.Bool = true
+	default:
+		return
+	}
+	if _, err := s.db.Exec("UPDATE users SET under_age = ? WHERE id = ?", underAge.Bool, userID); err != nil {
+		log.Printf("Failed to reassess the age of user %d: %v", userID, err)
+	}
+}
+
+// handleGetAgeReport counts the accounts over and under the NSFW age, and
+// those that never gave a date of birth, in the admin's organization. With
+// status set to over, under or unknown it also lists those accounts.
+func (s *Server) handleGetAgeReport(c *gin.Context) {
+	orgID := c.GetInt("org_id")
+	statuses := map[string]string{
+		"over":    "under_age = 0",
+		"under":   "under_age = 1",
+		"unknown": "under_age IS NULL",
+	}
+
+	counts := gin.H{}
+	for status, condition := range statuses {
+		var count int
+		if err := s.db.QueryRow(
+			"SELECT COUNT(*) FROM users WHERE role != 'guest' AND (? = 0 OR org_id = ?) AND "+condition, orgID, orgID,
+		).Scan(&count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get age report"})
+			return
+		}
+		counts[status] = count
+	}
+	var nsfwChannels int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM channels c JOIN servers sv ON sv.id = c.server_id
+		WHERE c.nsfw = 1 AND (? = 0 OR sv.org_id = ?)`, orgID, orgID,
+	).Scan(&nsfwChannels); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get age report"})
+		return
+	}
+
+	report := gin.H{
+		"age_gate":         s.ageGateMode(),
+		"minimum_age":      s.getIntSetting("minimum_age", defaultMinimumAge),
+		"nsfw_minimum_age": s.nsfwMinimumAge(),
+		"accounts":         counts,
+		"nsfw_channels":    nsfwChannels,
+	}
+
+	if status := c.Query("status"); status != "" {
+		condition, ok := statuses[status]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be over, under or unknown"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit < 1 || limit > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		rows, err := s.db.Query(`
+			SELECT id, username, created_at FROM users
+			WHERE role != 'guest' AND (? = 0 OR org_id = ?) AND `+condition+`
+			ORDER BY id LIMIT ? OFFSET ?`, orgID, orgID, limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get age report"})
+			return
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		users := make([]gin.H, 0)
+		for rows.Next() {
+			var id int
+			var username string
+			var createdAt time.Time
+			if err := rows.Scan(&id, &username, &createdAt); err != nil {
+				continue
+			}
+			users = append(users, gin.H{"id": id, "username": username, "created_at": createdAt})
+		}
+		report["status"], report["users"] = status, users
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAgeOn(t *testing.T) {
+	dob := time.Date(2008, time.June, 15, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		now  time.Time
+		want int
+	}{
+		{time.Date(2026, time.June, 14, 0, 0, 0, 0, time.UTC), 17},
+		{time.Date(2026, time.June, 15, 0, 0, 0, 0, time.UTC), 18},
+		{time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC), 18},
+	} {
+		if got := ageOn(dob, tc.now); got != tc.want {
+			t.Errorf("ageOn(%s) = %d, want %d", tc.now.Format(dateOfBirthLayout), got, tc.want)
+		}
+	}
+
+	hash, err := hashBirthYear(2008)
+	if err != nil {
+		t.Fatalf("Failed to hash birth year: %v", err)
+	}
+	if strings.Contains(hash, "2008") {
+		t.Errorf("Expected the birth year hashed, got %s", hash)
+	}
+	if year, ok := birthYear(hash, time.Now()); !ok || year != 2008 {
+		t.Errorf("Expected the hash to match 2008, got %d, %t", year, ok)
+	}
+}
+
+func TestAgeGate(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"auth_mode", "email_verification", "registration_challenge", "registration_approval", "age_gate", "minimum_age", "nsfw_minimum_age"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("auth_mode", "public", settingDescriptions["auth_mode"])
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+	_ = db.SetSetting("registration_challenge", "off", settingDescriptions["registration_challenge"])
+	_ = db.SetSetting("registration_approval", "false", settingDescriptions["registration_approval"])
+	_ = db.SetSetting("age_gate", "required", settingDescriptions["age_gate"])
+	_ = db.SetSetting("minimum_age", "13", settingDescriptions["minimum_age"])
+	_ = db.SetSetting("nsfw_minimum_age", "18", settingDescriptions["nsfw_minimum_age"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", s.handleRegister)
+	router.GET("/admin/age-report", func(c *gin.Context) { c.Set("org_id", 0) }, s.handleGetAgeReport)
+	register := func(username, dob string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"username":%q,"password":"Sturdy-Passw0rd!","date_of_birth":%q}`, username, dob)
+		r := httptest.NewRequest("POST", "/auth/register", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+	bornYearsAgo := func(years int) string {
+		return time.Now().AddDate(-years, 0, -1).Format(dateOfBirthLayout)
+	}
+	suffix := time.Now().UnixNano()
+
+	if w := register(fmt.Sprintf("nodob_%d", suffix), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing date of birth to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := register(fmt.Sprintf("child_%d", suffix), bornYearsAgo(10)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "under_minimum_age") {
+		t.Errorf("Expected a 10 year old to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	userID := func(username, dob string) int {
+		w := register(username, dob)
+		var registered struct {
+			User struct {
+				ID int `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &registered); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("Failed to register %s, got %d: %s", username, w.Code, w.Body.String())
+		}
+		return registered.User.ID
+	}
+	teenID := userID(fmt.Sprintf("teen_%d", suffix), bornYearsAgo(15))
+	adultID := userID(fmt.Sprintf("adult_%d", suffix), bornYearsAgo(30))
+
+	var underAge bool
+	var hash string
+	if err := db.QueryRow("SELECT under_age, birth_year_hash FROM users WHERE id = ?", teenID).Scan(&underAge, &hash); err != nil {
+		t.Fatalf("Failed to load the teen: %v", err)
+	}
+	if !underAge || hash == "" || strings.Contains(hash, strconv.Itoa(time.Now().Year()-15)) {
+		t.Errorf("Expected the teen flagged under age with a hashed birth year, got %t %q", underAge, hash)
+	}
+
+	// Both join a server with an NSFW channel
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Age gate %d", suffix), adultID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'member'), (?, ?, 'admin')", adultID, serverID, teenID, serverID); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+	result, err = db.Exec("INSERT INTO channels (name, server_id, channel_type, nsfw) VALUES ('after-dark', ?, 'text', 1)", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+
+	if _, err := s.lookupChannelForUser(adultID, int(channelID)); err != nil {
+		t.Errorf("Expected the adult to see the NSFW channel: %v", err)
+	}
+	// Even as a server admin
+	if err := s.validateTextChannel(teenID, int(channelID)); err != errChannelNotFound {
+		t.Errorf("Expected the NSFW channel hidden from the teen, got %v", err)
+	}
+
+	// Once of age, the flag is lifted at sign-in
+	grownUp, err := hashBirthYear(time.Now().Year() - 20)
+	if err != nil {
+		t.Fatalf("Failed to hash birth year: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET birth_year_hash = ? WHERE id = ?", grownUp, teenID); err != nil {
+		t.Fatalf("Failed to age the teen: %v", err)
+	}
+	s.reassessAge(teenID)
+	if _, err := s.lookupChannelForUser(teenID, int(channelID)); err != nil {
+		t.Errorf("Expected the NSFW channel visible once of age: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/age-report?status=over", nil))
+	var report struct {
+		Data struct {
+			Accounts map[string]int `json:"accounts"`
+			Users    []struct {
+				ID int `json:"id"`
+			} `json:"users"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Failed to get the age report, got %d: %s", w.Code, w.Body.String())
+	}
+	if report.Data.Accounts["over"] < 2 || strings.Contains(w.Body.String(), "birth") {
+		t.Errorf("Expected both accounts counted over age without birth data, got %s", w.Body.String())
+	}
+}
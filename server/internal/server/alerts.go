@@ -0,0 +1,604 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fethur/internal/database"
+	"fethur/internal/events"
+	"fethur/internal/mail"
+	"fethur/internal/plugins"
+	"fethur/internal/webhooks"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operator alerting. Admins define rules on a few health metrics; a
+// checker evaluates them every minute and alerts the rule's channels when
+// a rule starts or stops breaching its threshold, so small deployments get
+// alerts without running Prometheus.
+
+// alertCheckInterval is how often alert rules are evaluated
+const alertCheckInterval = time.Minute
+
+// errorRateWindow is the span the error rate is measured over, and
+// minErrorRateRequests the traffic below which it reads as 0, so one
+// failed request on a quiet server does not make a 100% error rate
+const (
+	errorRateWindow      = 5 * time.Minute
+	minErrorRateRequests = 20
+)
+
+// alertMetrics are the metrics rules can watch, with the comparison a rule
+// uses unless it names one
+var alertMetrics = map[string]string{
+	"db_latency_ms":      ">", // time to read the settings table
+	"disk_free_mb":       "<", // free space where the database lives
+	"disk_free_percent":  "<",
+	"error_rate_percent": ">", // 5xx responses among authenticated API requests
+	"unhealthy_plugins":  ">", // plugins whose health check fails
+}
+
+// alertChannels are where a rule's alerts can go
+var alertChannels = map[string]bool{
+	"admin":   true, // a notification to connected admins who can view metrics
+	"email":   true, // an email to super admins
+	"webhook": true, // a signed POST to the rule's webhook URL
+}
+
+// requestCounter counts API responses and server errors per minute for
+// the error rate
+type requestCounter struct {
+	mutex   sync.Mutex
+	buckets [5]struct {
+		minute           int64
+		requests, errors int64
+	}
+}
+
+// record counts a response with the given status
+func (r *requestCounter) record(now time.Time, status int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	minute := now.Unix() / 60
+	bucket := &r.buckets[minute%int64(len(r.buckets))]
+	if bucket.minute != minute {
+		bucket.minute, bucket.requests, bucket.errors = minute, 0, 0
+	}
+	bucket.requests++
+	if status >= 500 {
+		bucket.errors++
+	}
+}
+
+// errorRate returns the percentage of server errors over errorRateWindow
+func (r *requestCounter) errorRate(now time.Time) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	first := now.Unix()/60 - int64(errorRateWindow/time.Minute) + 1
+	var requests, errors int64
+	for _, bucket := range r.buckets {
+		if bucket.minute >= first {
+			requests += bucket.requests
+			errors += bucket.errors
+		}
+	}
+	if requests < minErrorRateRequests {
+		return 0
+	}
+	return float64(errors) * 100 / float64(requests)
+}
+
+// collectAlertMetrics reads the current value of each metric. Metrics
+// that cannot be read are left out, and rules on them are not evaluated.
+func (s *Server) collectAlertMetrics(ctx context.Context) map[string]float64 {
+	values := make(map[string]float64)
+
+	started := time.Now()
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM settings").Scan(&count); err == nil {
+		values["db_latency_ms"] = float64(time.Since(started).Microseconds()) / 1000
+	}
+
+	if free, total, err := diskSpace(database.DataDir); err == nil && total > 0 {
+		values["disk_free_mb"] = float64(free) / (1 << 20)
+		values["disk_free_percent"] = float64(free) * 100 / float64(total)
+	}
+
+	values["error_rate_percent"] = s.requests.errorRate(time.Now())
+
+	if s.plugins != nil {
+		unhealthy := 0
+		for _, plugin := range s.plugins.ListPlugins() {
+			if plugin.Health.Status == plugins.HealthStatusUnhealthy {
+				unhealthy++
+			}
+		}
+		values["unhealthy_plugins"] = float64(unhealthy)
+	}
+	return values
+}
+
+// alertRule is a stored alert rule
+type alertRule struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Metric        string     `json:"metric"`
+	Comparison    string     `json:"comparison"`
+	Threshold     float64    `json:"threshold"`
+	Channels      []string   `json:"channels"`
+	WebhookURL    string     `json:"webhook_url,omitempty"`
+	Enabled       bool       `json:"enabled"`
+	State         string     `json:"state"`
+	LastValue     *float64   `json:"last_value"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	FiredAt       *time.Time `json:"fired_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	webhookSecret string
+}
+
+// breached reports whether a value is past the rule's threshold
+func (r alertRule) breached(value float64) bool {
+	if r.Comparison == "<" {
+		return value < r.Threshold
+	}
+	return value > r.Threshold
+}
+
+const alertRuleColumns = `id, name, metric, comparison, threshold, channels, webhook_url, webhook_secret,
+	enabled, state, last_value, last_checked_at, fired_at, created_at`
+
+func scanAlertRule(row interface{ Scan(...interface{}) error }) (alertRule, error) {
+	var rule alertRule
+	var channels string
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Metric, &rule.Comparison, &rule.Threshold, &channels, &rule.WebhookURL, &rule.webhookSecret,
+		&rule.Enabled, &rule.State, &rule.LastValue, &rule.LastCheckedAt, &rule.FiredAt, &rule.CreatedAt)
+	rule.Channels = strings.Split(channels, ",")
+	return rule, err
+}
+
+// loadAlertRules returns the alert rules, only the enabled ones if asked
+func (s *Server) loadAlertRules(ctx context.Context, enabledOnly bool) ([]alertRule, error) {
+	query := "SELECT " + alertRuleColumns + " FROM alert_rules"
+	if enabledOnly {
+		query += " WHERE enabled = 1"
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	rules := make([]alertRule, 0)
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// startAlertChecker evaluates the alert rules every minute
+func (s *Server) startAlertChecker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(alertCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.checkAlertRules(ctx)
+			}
+		}
+	}()
+}
+
+// checkAlertRules evaluates every enabled rule, alerting on the rules
+// that started or stopped breaching their threshold
+func (s *Server) checkAlertRules(ctx context.Context) {
+	rules, err := s.loadAlertRules(ctx, true)
+	if err != nil {
+		log.Printf("Failed to load alert rules: %v", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	values := s.collectAlertMetrics(ctx)
+	now := time.Now()
+	for _, rule := range rules {
+		value, ok := values[rule.Metric]
+		if !ok {
+			continue
+		}
+		state := "ok"
+		if rule.breached(value) {
+			state = "firing"
+		}
+
+		if state == rule.State {
+			_, err = s.db.ExecContext(ctx, "UPDATE alert_rules SET last_value = ?, last_checked_at = ? WHERE id = ?", value, now, rule.ID)
+		} else {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE alert_rules SET last_value = ?, last_checked_at = ?, state = ?,
+					fired_at = CASE WHEN ? = 'firing' THEN ? ELSE fired_at END
+				WHERE id = ?`, value, now, state, state, now, rule.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to update alert rule %d: %v", rule.ID, err)
+			continue
+		}
+		if state != rule.State {
+			s.sendAlert(rule, value, state == "firing", false)
+		}
+	}
+}
+
+// alertPayload describes an alert to each channel
+func alertPayload(rule alertRule, value float64, firing, test bool) gin.H {
+	state := "resolved"
+	if firing {
+		state = "firing"
+	}
+	return gin.H{
+		"rule_id":    rule.ID,
+		"name":       rule.Name,
+		"metric":     rule.Metric,
+		"comparison": rule.Comparison,
+		"threshold":  rule.Threshold,
+		"value":      value,
+		"state":      state,
+		"test":       test,
+		"at":         time.Now().UTC(),
+	}
+}
+
+// alertSummary is the one-line description of an alert
+func alertSummary(rule alertRule, value float64, firing bool) string {
+	if firing {
+		return fmt.Sprintf("%s: %s is %.2f (%s %g)", rule.Name, rule.Metric, value, rule.Comparison, rule.Threshold)
+	}
+	return fmt.Sprintf("%s resolved: %s is %.2f", rule.Name, rule.Metric, value)
+}
+
+// sendAlert delivers an alert to each of the rule's channels
+func (s *Server) sendAlert(rule alertRule, value float64, firing, test bool) {
+	payload := alertPayload(rule, value, firing, test)
+	summary := alertSummary(rule, value, firing)
+	for _, channel := range rule.Channels {
+		switch channel {
+		case "admin":
+			data := gin.H{"kind": "alert"}
+			for key, field := range payload {
+				data[key] = field
+			}
+			s.notifyOperators(&websocket.Message{
+				Type:      string(events.TypeNotification),
+				Content:   summary,
+				Timestamp: time.Now(),
+				Data:      data,
+			})
+		case "email":
+			if s.mailer == nil {
+				continue
+			}
+			for _, admin := range s.operatorAdmins() {
+				if admin.role != "super_admin" || admin.email == "" {
+					continue
+				}
+				go func(admin operatorAdmin) {
+					_ = s.sendEmail(context.Background(), admin.id, admin.email, mail.TemplateAlert, map[string]interface{}{
+						"Username":   admin.username,
+						"Rule":       rule.Name,
+						"Metric":     rule.Metric,
+						"Value":      fmt.Sprintf("%.2f", value),
+						"Comparison": rule.Comparison,
+						"Threshold":  rule.Threshold,
+						"Firing":     firing,
+						"Test":       test,
+					})
+				}(admin)
+			}
+		case "webhook":
+			if rule.WebhookURL != "" {
+				s.deliverAlertWebhook(rule, payload)
+			}
+		}
+	}
+}
+
+// deliverAlertWebhook queues a signed POST of an alert to the rule's URL
+func (s *Server) deliverAlertWebhook(rule alertRule, payload gin.H) {
+	jobID := fmt.Sprintf("alert-%d-%d", rule.ID, time.Now().UnixNano())
+	err := s.jobs.Enqueue(jobID, func(ctx context.Context) error {
+		client := &webhooks.Client{HTTP: s.fetcher.Client(10 * time.Second)}
+		return s.integrations.Guard(integrationWebhooks, webhookPolicy).Do(ctx, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			_, err := client.Post(ctx, rule.WebhookURL, []string{rule.webhookSecret}, gin.H{"event": "alert", "data": payload})
+			return webhookError(err)
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to queue the webhook for alert rule %d: %v", rule.ID, err)
+	}
+}
+
+// operatorAdmin is an admin who can see system health
+type operatorAdmin struct {
+	id       int
+	username string
+	email    string
+	role     string
+}
+
+// operatorAdmins lists the admins who can view metrics
+func (s *Server) operatorAdmins() []operatorAdmin {
+	rows, err := s.db.Query("SELECT id, username, COALESCE(email, ''), role FROM users WHERE role NOT IN ('user', 'guest')")
+	if err != nil {
+		log.Printf("Failed to load admins: %v", err)
+		return nil
+	}
+	var admins []operatorAdmin
+	for rows.Next() {
+		var a operatorAdmin
+		if err := rows.Scan(&a.id, &a.username, &a.email, &a.role); err == nil {
+			admins = append(admins, a)
+		}
+	}
+	_ = rows.Close()
+
+	operators := admins[:0]
+	for _, a := range admins {
+		if s.hasCapability(a.id, capViewMetrics) {
+			operators = append(operators, a)
+		}
+	}
+	return operators
+}
+
+// notifyOperators sends a message to the connected admins who can view
+// metrics
+func (s *Server) notifyOperators(message *websocket.Message) {
+	for _, a := range s.operatorAdmins() {
+		s.clientsMux.RLock()
+		client, ok := s.clients[a.id]
+		s.clientsMux.RUnlock()
+		if ok {
+			client.Send(message)
+		}
+	}
+}
+
+// alertRuleRequest is the body for creating or changing a rule; fields
+// left out keep their value
+type alertRuleRequest struct {
+	Name       *string   `json:"name"`
+	Metric     *string   `json:"metric"`
+	Comparison *string   `json:"comparison"`
+	Threshold  *float64  `json:"threshold"`
+	Channels   *[]string `json:"channels"`
+	WebhookURL *string   `json:"webhook_url"`
+	Enabled    *bool     `json:"enabled"`
+}
+
+// applyAlertRuleRequest validates the request and copies it onto a rule
+func (s *Server) applyAlertRuleRequest(rule *alertRule, req alertRuleRequest) error {
+	if req.Name != nil {
+		rule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Metric != nil {
+		rule.Metric = *req.Metric
+	}
+	if req.Comparison != nil {
+		rule.Comparison = *req.Comparison
+	}
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.Channels != nil {
+		rule.Channels = *req.Channels
+	}
+	if req.WebhookURL != nil {
+		rule.WebhookURL = strings.TrimSpace(*req.WebhookURL)
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	defaultComparison, ok := alertMetrics[rule.Metric]
+	switch {
+	case rule.Name == "" || len(rule.Name) > 100:
+		return errors.New("name must be 1 to 100 characters")
+	case !ok:
+		return fmt.Errorf("unknown metric %q", rule.Metric)
+	}
+	if rule.Comparison == "" {
+		rule.Comparison = defaultComparison
+	}
+	if rule.Comparison != ">" && rule.Comparison != "<" {
+		return errors.New("comparison must be > or <")
+	}
+	if len(rule.Channels) == 0 {
+		return errors.New("at least one channel is required")
+	}
+	webhook := false
+	for _, channel := range rule.Channels {
+		if !alertChannels[channel] {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+		webhook = webhook || channel == "webhook"
+	}
+	if webhook {
+		if rule.WebhookURL == "" {
+			return errors.New("the webhook channel needs a webhook_url")
+		}
+		if err := s.webhookURL(rule.WebhookURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGetAlertRules lists the alert rules with the current value of
+// every metric
+func (s *Server) handleGetAlertRules(c *gin.Context) {
+	rules, err := s.loadAlertRules(c.Request.Context(), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get alert rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"rules":   rules,
+			"metrics": s.collectAlertMetrics(c.Request.Context()),
+		},
+	})
+}
+
+// handleCreateAlertRule adds a rule. A webhook secret is generated for
+// signing its deliveries and only returned here.
+func (s *Server) handleCreateAlertRule(c *gin.Context) {
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := alertRule{Channels: []string{"admin"}, Enabled: true, State: "ok"}
+	if err := s.applyAlertRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	adminID := c.GetInt("user_id")
+	result, err := s.db.Exec(`
+		INSERT INTO alert_rules (name, metric, comparison, threshold, channels, webhook_url, webhook_secret, enabled, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Metric, rule.Comparison, rule.Threshold, strings.Join(rule.Channels, ","), rule.WebhookURL, secret, rule.Enabled, adminID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert rule"})
+		return
+	}
+	rule.ID, _ = result.LastInsertId()
+	rule.CreatedAt = time.Now()
+
+	s.logAdminAction(adminID, "create_alert_rule", fmt.Sprintf("Created alert rule %q: %s %s %g", rule.Name, rule.Metric, rule.Comparison, rule.Threshold))
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"rule":           rule,
+			"webhook_secret": secret,
+		},
+	})
+}
+
+// alertRuleByID loads the rule named in the URL, answering and returning
+// false when there is none
+func (s *Server) alertRuleByID(c *gin.Context) (alertRule, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return alertRule{}, false
+	}
+	rule, err := scanAlertRule(s.db.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return alertRule{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get alert rule"})
+		return alertRule{}, false
+	}
+	return rule, true
+}
+
+// handleUpdateAlertRule changes a rule. Changing what it watches starts
+// it over as not firing.
+func (s *Server) handleUpdateAlertRule(c *gin.Context) {
+	rule, ok := s.alertRuleByID(c)
+	if !ok {
+		return
+	}
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	watched := fmt.Sprint(rule.Metric, rule.Comparison, rule.Threshold, rule.Enabled)
+	if err := s.applyAlertRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if fmt.Sprint(rule.Metric, rule.Comparison, rule.Threshold, rule.Enabled) != watched {
+		rule.State = "ok"
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE alert_rules SET name = ?, metric = ?, comparison = ?, threshold = ?, channels = ?, webhook_url = ?, enabled = ?, state = ?
+		WHERE id = ?`,
+		rule.Name, rule.Metric, rule.Comparison, rule.Threshold, strings.Join(rule.Channels, ","), rule.WebhookURL, rule.Enabled, rule.State, rule.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert rule"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "update_alert_rule", fmt.Sprintf("Updated alert rule %q: %s %s %g", rule.Name, rule.Metric, rule.Comparison, rule.Threshold))
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": rule})
+}
+
+// handleDeleteAlertRule removes a rule
+func (s *Server) handleDeleteAlertRule(c *gin.Context) {
+	rule, ok := s.alertRuleByID(c)
+	if !ok {
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM alert_rules WHERE id = ?", rule.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert rule"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "delete_alert_rule", fmt.Sprintf("Deleted alert rule %q", rule.Name))
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Alert rule deleted successfully"})
+}
+
+// handleTestAlertRule sends a test alert with the metric's current value
+// to each of the rule's channels
+func (s *Server) handleTestAlertRule(c *gin.Context) {
+	rule, ok := s.alertRuleByID(c)
+	if !ok {
+		return
+	}
+	value, ok := s.collectAlertMetrics(c.Request.Context())[rule.Metric]
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The metric " + rule.Metric + " cannot be read on this server"})
+		return
+	}
+	s.sendAlert(rule, value, true, true)
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Test alert sent"})
+}
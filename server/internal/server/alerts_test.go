@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAlertRuleFiresAndResolves(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+	s.requests = &requestCounter{}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/alerts", s.handleCreateAlertRule)
+	create := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/alerts", strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"name":"cpu","metric":"cpu_percent","threshold":90}`,
+		`{"name":"errors","metric":"error_rate_percent","threshold":5,"comparison":">="}`,
+		`{"name":"errors","metric":"error_rate_percent","threshold":5,"channels":["webhook"]}`,
+	} {
+		if w := create(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	name := fmt.Sprintf("errors_%d", time.Now().UnixNano())
+	w := create(fmt.Sprintf(`{"name":%q,"metric":"error_rate_percent","threshold":10}`, name))
+	var created struct {
+		Data struct {
+			Rule alertRule `json:"rule"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create the rule, got %d: %s", w.Code, w.Body.String())
+	}
+	rule := created.Data.Rule
+	if rule.Comparison != ">" || len(rule.Channels) != 1 || rule.Channels[0] != "admin" {
+		t.Errorf("Expected the metric's comparison and the admin channel by default, got %+v", rule)
+	}
+	defer func() {
+		_, _ = db.Exec("DELETE FROM alert_rules WHERE id = ?", rule.ID)
+	}()
+
+	state := func() string {
+		var state string
+		var firedAt *time.Time
+		if err := db.QueryRow("SELECT state, fired_at FROM alert_rules WHERE id = ?", rule.ID).Scan(&state, &firedAt); err != nil {
+			t.Fatalf("Failed to read the rule: %v", err)
+		}
+		if state == "firing" && firedAt == nil {
+			t.Error("Expected a firing rule to record when it fired")
+		}
+		return state
+	}
+
+	// A few failures on a quiet server are not an error rate
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.requests.record(now, http.StatusInternalServerError)
+	}
+	s.checkAlertRules(context.Background())
+	if got := state(); got != "ok" {
+		t.Errorf("Expected too little traffic to stay ok, got %s", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		s.requests.record(now, http.StatusOK)
+	}
+	s.checkAlertRules(context.Background())
+	if got := state(); got != "firing" {
+		t.Errorf("Expected a 20%% error rate to fire, got %s", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.requests.record(now, http.StatusOK)
+	}
+	s.checkAlertRules(context.Background())
+	if got := state(); got != "ok" {
+		t.Errorf("Expected the rule to resolve, got %s", got)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API key scopes. A key may only be used for what its scopes allow, on top
+// of whatever its user may do.
+const (
+	// scopeRead allows GET requests to the API and the chat WebSocket
+	scopeRead = "read"
+	// scopeWrite allows every other request method
+	scopeWrite = "write"
+	// scopeAdmin allows admin endpoints, if the user is an admin
+	scopeAdmin = "admin"
+	// scopeAutomation allows the automation API
+	scopeAutomation = "automation"
+	// scopeAll is every scope; keys created before scopes existed have it
+	scopeAll = "*"
+)
+
+var apiKeyScopes = map[string]bool{
+	scopeRead:       true,
+	scopeWrite:      true,
+	scopeAdmin:      true,
+	scopeAutomation: true,
+}
+
+// apiKeyHeader carries a personal API key, as an alternative to a bearer
+// token
+const apiKeyHeader = "X-API-Key"
+
+// maxAPIKeyDays bounds how far in the future a key may expire
+const maxAPIKeyDays = 365
+
+// apiKey is a key that authenticated a request
+type apiKey struct {
+	id       int64
+	userID   int
+	username string
+	scopes   []string
+}
+
+// allows reports whether the key has a scope
+func (k *apiKey) allows(scope string) bool {
+	for _, held := range k.scopes {
+		if held == scope || held == scopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes validates requested scopes and returns their stored form
+func parseScopes(scopes []string) (string, error) {
+	if scopes == nil {
+		return scopeAll, nil
+	}
+	seen := make(map[string]bool)
+	var valid []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !apiKeyScopes[scope] {
+			return "", fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+	if len(valid) == 0 {
+		return "", fmt.Errorf("at least one scope is required")
+	}
+	return strings.Join(valid, ","), nil
+}
+
+// apiKeyFromRequest returns the API key a request carries in X-API-Key or
+// as a bearer token, or "" if it carries none
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		return key
+	}
+	if bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); strings.HasPrefix(bearer, apiKeyPrefix) {
+		return bearer
+	}
+	return ""
+}
+
+// lookupAPIKey finds an unexpired key and records that it was used
+func (s *Server) lookupAPIKey(key string) (*apiKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, fmt.Errorf("not an API key")
+	}
+
+	found := &apiKey{}
+	var scopes string
+	err := s.db.QueryRow(`
+		SELECT k.id, u.id, u.username, k.scopes FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ? AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)`, hashAPIKey(key),
+	).Scan(&found.id, &found.userID, &found.username, &scopes)
+	if err != nil {
+		return nil, err
+	}
+	found.scopes = strings.Split(scopes, ",")
+
+	if _, err := s.db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", found.id); err != nil {
+		log.Printf("Failed to record API key use: %v", err)
+	}
+	return found, nil
+}
+
+// authenticateAPIKey authenticates a request to the main API by API key.
+// GET requests need the read scope and everything else the write scope.
+func (s *Server) authenticateAPIKey(c *gin.Context, key string) {
+	found, err := s.lookupAPIKey(key)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid API key"})
+		c.Abort()
+		return
+	}
+	if s.isUserBanned(found.userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+		c.Abort()
+		return
+	}
+
+	scope := scopeWrite
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		scope = scopeRead
+	}
+	if !found.allows(scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key lacks the %s scope", scope)})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", found.userID)
+	c.Set("username", found.username)
+	c.Set("api_key", found)
+	c.Set("api_key_id", found.id)
+	c.Next()
+}
+
+// requestAPIKey returns the key that authenticated the request, or nil for
+// sessions
+func requestAPIKey(c *gin.Context) *apiKey {
+	if value, ok := c.Get("api_key"); ok {
+		if key, ok := value.(*apiKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
+// apiKeyAllows reports whether a request may use a scope: sessions may use
+// any, keys only their own
+func apiKeyAllows(c *gin.Context, scope string) bool {
+	key := requestAPIKey(c)
+	return key == nil || key.allows(scope)
+}
+
+// sessionOnly refuses API keys on routes that manage credentials, so a
+// leaked key cannot mint more keys, end sessions or turn off two-factor
+// sign-in
+func sessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requestAPIKey(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used for this endpoint"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
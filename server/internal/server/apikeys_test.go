@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScopedAPIKeys(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	username := fmt.Sprintf("scripted_%d", time.Now().UnixNano())
+	result, err := db.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, 'x', 'super_admin')", username)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/tokens", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		s.handleCreateAPIKey(c)
+	})
+	api := router.Group("/api", s.authMiddleware())
+	api.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id"), "username": c.GetString("username")})
+	})
+	api.POST("/messages", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+	api.GET("/admin/stats", s.superAdminMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+	api.DELETE("/auth/tokens/:id", sessionOnly(), s.handleDeleteAPIKey)
+	router.GET("/automation/v1/me", s.apiKeyMiddleware(), s.handleAutomationMe)
+
+	request := func(method, path, key, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	create := func(body string) (string, float64) {
+		code, response := request(http.MethodPost, "/auth/tokens", "", body)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected to create a key from %s, got %d %v", body, code, response)
+		}
+		data := response["data"].(map[string]interface{})
+		return data["key"].(string), data["id"].(float64)
+	}
+
+	for _, body := range []string{
+		`{"name":"bot","scopes":["everything"]}`,
+		`{"name":"bot","scopes":[]}`,
+		`{"name":"bot","expires_in_days":1000}`,
+	} {
+		if code, _ := request(http.MethodPost, "/auth/tokens", "", body); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused, got %d", body, code)
+		}
+	}
+
+	// A read-only key can read as its user and nothing else
+	readOnly, _ := create(`{"name":"dashboard","scopes":["read"],"expires_in_days":30}`)
+	if code, response := request(http.MethodGet, "/api/me", readOnly, ""); code != http.StatusOK || response["username"] != username {
+		t.Errorf("Expected the key to read as %s, got %d %v", username, code, response)
+	}
+	if code, _ := request(http.MethodPost, "/api/messages", readOnly, "{}"); code != http.StatusForbidden {
+		t.Errorf("Expected a read-only key to be refused writes, got %d", code)
+	}
+	if code, _ := request(http.MethodGet, "/api/admin/stats", readOnly, ""); code != http.StatusForbidden {
+		t.Errorf("Expected a key without the admin scope to be refused admin routes, got %d", code)
+	}
+	if code, _ := request(http.MethodGet, "/automation/v1/me", readOnly, ""); code != http.StatusForbidden {
+		t.Errorf("Expected a key without the automation scope to be refused automation, got %d", code)
+	}
+
+	// A key without scopes has them all, like keys from before scopes
+	full, fullID := create(`{"name":"ops"}`)
+	for _, path := range []string{"/api/admin/stats", "/automation/v1/me"} {
+		if code, _ := request(http.MethodGet, path, full, ""); code != http.StatusOK {
+			t.Errorf("Expected an unscoped key to reach %s, got %d", path, code)
+		}
+	}
+	if code, _ := request(http.MethodPost, "/api/messages", full, "{}"); code != http.StatusOK {
+		t.Errorf("Expected an unscoped key to write, got %d", code)
+	}
+
+	// Keys cannot manage keys, however they are scoped
+	if code, _ := request(http.MethodDelete, fmt.Sprintf("/api/auth/tokens/%.0f", fullID), full, ""); code != http.StatusForbidden {
+		t.Errorf("Expected a key to be refused key management, got %d", code)
+	}
+
+	// Expired and unknown keys are refused
+	if _, err := db.Exec("UPDATE api_keys SET expires_at = datetime('now', '-1 minute') WHERE id = ?", fullID); err != nil {
+		t.Fatalf("Failed to expire key: %v", err)
+	}
+	if code, _ := request(http.MethodGet, "/api/me", full, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected an expired key to be refused, got %d", code)
+	}
+	if code, _ := request(http.MethodGet, "/api/me", apiKeyPrefix+"unknown", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be refused, got %d", code)
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fethur/internal/snowflake"
+	"fethur/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAttachmentMaxSizeMB = 25
+	attachmentUploadExpiry     = 15 * time.Minute
+	attachmentDownloadExpiry   = 5 * time.Minute
+)
+
+// allowedAttachmentTypes lists accepted MIME types; entries ending in "/"
+// accept a whole top-level type
+var allowedAttachmentTypes = []string{
+	"image/", "video/", "audio/",
+	"text/plain", "text/markdown",
+	"application/pdf", "application/zip", "application/json",
+}
+
+var errAttachmentNotFound = errors.New("attachment not found")
+
+// attachment is a row of the attachments table
+type attachment struct {
+	ID          int64
+	UploaderID  int
+	MessageID   sql.NullInt64
+	StorageKey  string // the shared blob's key once deduplicated
+	Filename    string
+	ContentType string
+	Size        int64
+	Status      string
+	UploadID    sql.NullString // set while a multipart upload is in progress
+	BlobHash    sql.NullString // SHA-256 of the content once completed
+	CompletedAt sql.NullTime
+	Processing  bool // waiting in the image pipeline
+}
+
+// attachmentJSON describes an attachment for clients
+func (s *Server) attachmentJSON(a *attachment) gin.H {
+	return gin.H{
+		"id":           snowflake.ID(a.ID),
+		"filename":     a.Filename,
+		"content_type": a.ContentType,
+		"size":         a.Size,
+		"url":          s.externalURL(fmt.Sprintf("/api/attachments/%d", a.ID)),
+	}
+}
+
+// baseMediaType strips parameters such as charset from a MIME type
+func baseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+func isAllowedAttachmentType(contentType string) bool {
+	mediaType := baseMediaType(contentType)
+	for _, allowed := range allowedAttachmentTypes {
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffedTypeMatches checks the sniffed content of an upload against its
+// declared type. Images must match exactly, other types must not sniff as
+// an unrelated allowed type.
+func sniffedTypeMatches(declared, sniffed string) bool {
+	declared = baseMediaType(declared)
+	sniffed = baseMediaType(sniffed)
+	if declared == sniffed {
+		return true
+	}
+	if strings.HasPrefix(declared, "image/") || strings.HasPrefix(sniffed, "image/") {
+		return false
+	}
+	// The sniffer cannot identify every format; generic results are accepted
+	return sniffed == "application/octet-stream" || sniffed == "text/plain"
+}
+
+func (s *Server) attachmentMaxSize() int64 {
+	return int64(s.getIntSetting("attachment_max_size_mb", defaultAttachmentMaxSizeMB)) * 1024 * 1024
+}
+
+func newStorageKey(userID int) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("attachments/%d/%s", userID, hex.EncodeToString(random)), nil
+}
+
+func (s *Server) getAttachment(id interface{}) (*attachment, error) {
+	a := &attachment{}
+	err := s.db.QueryRow(`
+		SELECT a.id, a.uploader_id, a.message_id, COALESCE(b.storage_key, a.storage_key),
+			a.filename, a.content_type, a.size, a.status, a.upload_id, a.blob_hash, a.completed_at, a.processing
+		FROM attachments a
+		LEFT JOIN attachment_blobs b ON b.hash = a.blob_hash
+		WHERE a.id = ?`,
+		id,
+	).Scan(&a.ID, &a.UploaderID, &a.MessageID, &a.StorageKey, &a.Filename, &a.ContentType, &a.Size, &a.Status, &a.UploadID, &a.BlobHash, &a.CompletedAt, &a.Processing)
+	if err == sql.ErrNoRows {
+		return nil, errAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// canViewAttachment reports whether a user may download an attachment:
+// the uploader always can, everyone else needs access to its channel
+func (s *Server) canViewAttachment(userID int, a *attachment) bool {
+	if a.UploaderID == userID {
+		return true
+	}
+	if !a.MessageID.Valid {
+		return false
+	}
+
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM messages m
+			JOIN channels c ON c.id = m.channel_id
+			JOIN server_members sm ON sm.server_id = c.server_id
+			WHERE m.id = ? AND sm.user_id = ?
+		)`,
+		a.MessageID.Int64, userID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// handleCreateAttachment registers an upload and tells the client where to send the bytes
+func (s *Server) handleCreateAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Filename    string `json:"filename" binding:"required"`
+		ContentType string `json:"content_type" binding:"required"`
+		Size        int64  `json:"size" binding:"required"`
+		Multipart   bool   `json:"multipart"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Size <= 0 || req.Size > s.attachmentMaxSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments must be between 1 byte and %d MB", s.attachmentMaxSize()/1024/1024)})
+		return
+	}
+	if !isAllowedAttachmentType(req.ContentType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File type is not allowed"})
+		return
+	}
+
+	filename := filepath.Base(strings.ReplaceAll(req.Filename, "\\", "/"))
+	key, err := newStorageKey(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create attachment"})
+		return
+	}
+
+	id := int64(snowflake.Next())
+	_, err = s.db.Exec(
+		"INSERT INTO attachments (id, uploader_id, storage_key, filename, content_type, size) VALUES (?, ?, ?, ?, ?, ?)",
+		id, userID, key, filename, req.ContentType, req.Size,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create attachment"})
+		return
+	}
+
+	if req.Multipart {
+		s.startMultipartAttachment(c, id, key, req.ContentType, req.Size)
+		return
+	}
+
+	// Presigning backends take the upload directly; otherwise it goes through us
+	upload := &storage.PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     s.externalURL(fmt.Sprintf("/api/attachments/%d/upload", id)),
+		Headers: http.Header{"Content-Type": []string{req.ContentType}},
+		Expires: time.Now().Add(attachmentUploadExpiry),
+	}
+	if presigner, ok := s.storage.(storage.Presigner); ok {
+		upload, err = presigner.PresignPut(key, req.ContentType, req.Size, attachmentUploadExpiry)
+		if err != nil {
+			log.Printf("Failed to presign upload for attachment %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload URL"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":           snowflake.ID(id),
+			"upload":       upload,
+			"complete_url": s.externalURL(fmt.Sprintf("/api/attachments/%d/complete", id)),
+		},
+	})
+}
+
+// handleUploadAttachment receives upload bytes for backends without presigning
+func (s *Server) handleUploadAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.UploaderID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if a.Status != "pending" || a.Processing {
+		c.JSON(http.StatusConflict, gin.H{"error": "Attachment was already uploaded"})
+		return
+	}
+	if a.UploadID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload this attachment in parts"})
+		return
+	}
+	if _, ok := s.storage.(storage.Presigner); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload directly to the storage URL"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, a.Size)
+	if err := s.storage.Put(c.Request.Context(), a.StorageKey, body, a.Size, a.ContentType); err != nil {
+		log.Printf("Failed to store attachment %d: %v", a.ID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload did not match the declared size"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Upload received",
+	})
+}
+
+// handleCompleteAttachment validates an uploaded object before it can be attached to a message
+func (s *Server) handleCompleteAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.UploaderID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if a.Status == "ready" {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": s.attachmentJSON(a)})
+		return
+	}
+	if a.Processing {
+		s.respondAttachmentProcessing(c, a)
+		return
+	}
+
+	if a.UploadID.Valid {
+		if err := s.completeMultipartAttachment(c, a); err != nil {
+			// Missing parts can still be uploaded, so the upload is kept
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := s.validateUploadedObject(c, a); err != nil {
+		// Throw away objects that fail validation so they are never served
+		if err := s.storage.Delete(c.Request.Context(), a.StorageKey); err != nil {
+			log.Printf("Failed to delete rejected attachment %d: %v", a.ID, err)
+		}
+		if _, err := s.db.Exec("DELETE FROM attachments WHERE id = ?", a.ID); err != nil {
+			log.Printf("Failed to delete rejected attachment %d: %v", a.ID, err)
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Images are cleaned in the background and become ready when done
+	if s.needsImageProcessing(a) {
+		if err := s.queueAttachmentProcessing(a.ID); err != nil {
+			log.Printf("Failed to queue processing for attachment %d: %v", a.ID, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, complete the upload again later"})
+			return
+		}
+		s.respondAttachmentProcessing(c, a)
+		return
+	}
+
+	// Store identical content once; on failure the attachment keeps its own copy
+	if err := s.deduplicateAttachment(c.Request.Context(), a); err != nil {
+		log.Printf("Failed to deduplicate attachment %d: %v", a.ID, err)
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE attachments SET status = 'ready', completed_at = CURRENT_TIMESTAMP WHERE id = ?",
+		a.ID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete attachment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.attachmentJSON(a),
+	})
+}
+
+// validateUploadedObject checks the stored object's size and sniffed MIME type
+func (s *Server) validateUploadedObject(c *gin.Context, a *attachment) error {
+	info, err := s.storage.Stat(c.Request.Context(), a.StorageKey)
+	if err == storage.ErrNotFound {
+		return errors.New("Upload not found")
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to check upload")
+	}
+	if info.Size != a.Size {
+		return fmt.Errorf("Upload size %d does not match declared size %d", info.Size, a.Size)
+	}
+
+	reader, err := s.storage.Open(c.Request.Context(), a.StorageKey)
+	if err != nil {
+		return fmt.Errorf("Failed to read upload")
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing attachment reader: %v", err)
+		}
+	}()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("Failed to read upload")
+	}
+
+	sniffed := http.DetectContentType(head[:n])
+	if !sniffedTypeMatches(a.ContentType, sniffed) {
+		return fmt.Errorf("File content (%s) does not match declared type %s", baseMediaType(sniffed), baseMediaType(a.ContentType))
+	}
+	return nil
+}
+
+// handleGetAttachment serves or redirects to an attachment's content
+func (s *Server) handleGetAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.Status != "ready" || !s.canViewAttachment(userID, a) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	// Redirects point at short-lived URLs, so they must not be cached
+	if presigner, ok := s.storage.(storage.Presigner); ok {
+		download, err := presigner.PresignGet(a.StorageKey, attachmentDownloadExpiry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download URL"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, download.URL)
+		return
+	}
+
+	if s.signedURLsEnabled() {
+		signed, _ := s.signedAttachmentURL(a, time.Now())
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, signed)
+		return
+	}
+
+	s.serveAttachment(c, a, fmt.Sprintf("private, max-age=%d, immutable", int(attachmentCacheMaxAge.Seconds())))
+}
+
+// claimAttachments validates that attachments can be linked to a new
+// message by this user
+func (s *Server) claimAttachments(userID int, ids []int64) ([]*attachment, error) {
+	attachments := make([]*attachment, 0, len(ids))
+	for _, id := range ids {
+		a, err := s.getAttachment(id)
+		if err != nil || a.UploaderID != userID {
+			return nil, fmt.Errorf("Attachment %d not found", id)
+		}
+		if a.Status != "ready" {
+			return nil, fmt.Errorf("Attachment %d has not finished uploading", id)
+		}
+		if a.MessageID.Valid {
+			return nil, fmt.Errorf("Attachment %d is already attached to a message", id)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// linkAttachments attaches claimed attachments to a message
+func (s *Server) linkAttachments(messageID int64, attachments []*attachment) {
+	for _, a := range attachments {
+		if _, err := s.db.Exec("UPDATE attachments SET message_id = ? WHERE id = ?", messageID, a.ID); err != nil {
+			log.Printf("Failed to link attachment %d to message %d: %v", a.ID, messageID, err)
+		}
+	}
+}
+
+// messageAttachments returns the attachments of the given messages, keyed by message ID
+func (s *Server) messageAttachments(messageIDs []int64) map[int64][]gin.H {
+	result := make(map[int64][]gin.H)
+	if len(messageIDs) == 0 {
+		return result
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT id, message_id, filename, content_type, size
+		FROM attachments
+		WHERE status = 'ready' AND message_id IN (%s)
+		ORDER BY id`, strings.Join(placeholders, ", ")),
+		args...,
+	)
+	if err != nil {
+		log.Printf("Failed to load message attachments: %v", err)
+		return result
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var a attachment
+		var messageID int64
+		if err := rows.Scan(&a.ID, &messageID, &a.Filename, &a.ContentType, &a.Size); err != nil {
+			continue
+		}
+		result[messageID] = append(result[messageID], s.attachmentJSON(&a))
+	}
+	return result
+}
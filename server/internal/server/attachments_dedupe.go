@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"fethur/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hashStoredObject computes the SHA-256 of a stored object
+func (s *Server) hashStoredObject(ctx context.Context, key string) (string, error) {
+	reader, err := s.storage.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing attachment reader: %v", err)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// deduplicateAttachment links a completed upload to the blob for its
+// content. When the content is already stored, the new copy is deleted and
+// the attachment points at the existing object instead.
+func (s *Server) deduplicateAttachment(ctx context.Context, a *attachment) error {
+	hash, err := s.hashStoredObject(ctx, a.StorageKey)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Either registers this upload as the blob or takes a reference on the
+	// existing one, in a single statement so concurrent uploads agree
+	if _, err := tx.Exec(`
+		INSERT INTO attachment_blobs (hash, storage_key, size, ref_count) VALUES (?, ?, ?, 1)
+		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1`,
+		hash, a.StorageKey, a.Size,
+	); err != nil {
+		return err
+	}
+
+	var blobKey string
+	if err := tx.QueryRow("SELECT storage_key FROM attachment_blobs WHERE hash = ?", hash).Scan(&blobKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE attachments SET blob_hash = ? WHERE id = ?", hash, a.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if blobKey != a.StorageKey {
+		if err := s.storage.Delete(ctx, a.StorageKey); err != nil && err != storage.ErrNotFound {
+			log.Printf("Failed to delete duplicate upload for attachment %d: %v", a.ID, err)
+		}
+		log.Printf("Attachment %d deduplicated against blob %s", a.ID, hash[:12])
+	}
+	a.StorageKey = blobKey
+	a.BlobHash.String, a.BlobHash.Valid = hash, true
+	return nil
+}
+
+// collectBlob deletes a blob's object once nothing references it. The
+// conditional delete keeps a concurrent upload of the same content from
+// losing its object.
+func (s *Server) collectBlob(ctx context.Context, hash string) {
+	var key string
+	err := s.db.QueryRow("SELECT storage_key FROM attachment_blobs WHERE hash = ? AND ref_count <= 0", hash).Scan(&key)
+	if err != nil {
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM attachment_blobs WHERE hash = ? AND ref_count <= 0", hash)
+	if err != nil {
+		log.Printf("Failed to delete blob %s: %v", hash, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return
+	}
+
+	if err := s.storage.Delete(ctx, key); err != nil && err != storage.ErrNotFound {
+		log.Printf("Failed to delete blob object %s: %v", key, err)
+	}
+}
+
+// handleGetStorageReport summarizes attachment storage use and how much
+// deduplication saves
+func (s *Server) handleGetStorageReport(c *gin.Context) {
+	var attachments, pendingUploads, blobs int
+	var logicalBytes, blobBytes, undedupedBytes, pendingBytes int64
+
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(size), 0),
+			COALESCE(SUM(CASE WHEN blob_hash IS NULL THEN size ELSE 0 END), 0)
+		FROM attachments WHERE status = 'ready'`,
+	).Scan(&attachments, &logicalBytes, &undedupedBytes)
+	if err == nil {
+		err = s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM attachments WHERE status = 'pending'").Scan(&pendingUploads, &pendingBytes)
+	}
+	if err == nil {
+		err = s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM attachment_blobs WHERE ref_count > 0").Scan(&blobs, &blobBytes)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get storage report"})
+		return
+	}
+
+	// Attachments from before deduplication each keep their own object
+	storedBytes := blobBytes + undedupedBytes
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"backend":         s.storage.Name(),
+			"attachments":     attachments,
+			"unique_blobs":    blobs,
+			"logical_bytes":   logicalBytes,
+			"stored_bytes":    storedBytes,
+			"dedupe_saved":    logicalBytes - storedBytes,
+			"pending_uploads": pendingUploads,
+			"pending_bytes":   pendingBytes,
+		},
+	})
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/storage"
+)
+
+func TestAttachmentDeduplication(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	backend, err := storage.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage backend: %v", err)
+	}
+	s.storage = backend
+	ctx := context.Background()
+	content := fmt.Sprintf("same meme %d", time.Now().UnixNano())
+
+	upload := func(key string) *attachment {
+		if err := backend.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			t.Fatalf("Failed to store upload: %v", err)
+		}
+		result, err := db.Exec(
+			"INSERT INTO attachments (uploader_id, storage_key, filename, content_type, size, status) VALUES (1, ?, 'meme.txt', 'text/plain', ?, 'ready')",
+			key, len(content),
+		)
+		if err != nil {
+			t.Fatalf("Failed to insert attachment: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		a, err := s.getAttachment(id)
+		if err != nil {
+			t.Fatalf("Failed to load attachment: %v", err)
+		}
+		if err := s.deduplicateAttachment(ctx, a); err != nil {
+			t.Fatalf("Failed to deduplicate attachment: %v", err)
+		}
+		return a
+	}
+
+	suffix := time.Now().UnixNano()
+	first := upload(fmt.Sprintf("attachments/1/first-%d", suffix))
+	second := upload(fmt.Sprintf("attachments/1/second-%d", suffix))
+
+	if second.StorageKey != first.StorageKey {
+		t.Fatalf("Expected both attachments to share %s, got %s", first.StorageKey, second.StorageKey)
+	}
+	if _, err := backend.Stat(ctx, fmt.Sprintf("attachments/1/second-%d", suffix)); err != storage.ErrNotFound {
+		t.Errorf("Expected duplicate upload to be deleted, got %v", err)
+	}
+
+	// Dropping one reference keeps the shared object
+	s.discardAttachmentRow(first.ID)
+	s.collectBlob(ctx, first.BlobHash.String)
+	if _, err := backend.Stat(ctx, first.StorageKey); err != nil {
+		t.Fatalf("Expected shared blob to survive, got %v", err)
+	}
+
+	// Dropping the last reference deletes it
+	s.discardAttachmentRow(second.ID)
+	s.collectBlob(ctx, second.BlobHash.String)
+	if _, err := backend.Stat(ctx, first.StorageKey); err != storage.ErrNotFound {
+		t.Errorf("Expected unreferenced blob to be deleted, got %v", err)
+	}
+}
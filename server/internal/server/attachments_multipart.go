@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/snowflake"
+	"fethur/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attachmentPartSize is the size of every part but the last. S3 requires
+// at least 5 MB per part.
+const attachmentPartSize int64 = 8 * 1024 * 1024
+
+func attachmentPartCount(size int64) int {
+	return int((size + attachmentPartSize - 1) / attachmentPartSize)
+}
+
+// attachmentPartLength returns the expected size of a part, so every part
+// can be checked on its own and a resumed upload cannot drift
+func attachmentPartLength(size int64, number int) int64 {
+	if number == attachmentPartCount(size) {
+		return size - int64(number-1)*attachmentPartSize
+	}
+	return attachmentPartSize
+}
+
+func (s *Server) multipartStorage() (storage.MultipartBackend, bool) {
+	backend, ok := s.storage.(storage.MultipartBackend)
+	return backend, ok
+}
+
+// startMultipartAttachment opens a multipart upload for a new attachment
+func (s *Server) startMultipartAttachment(c *gin.Context, id int64, key, contentType string, size int64) {
+	backend, ok := s.multipartStorage()
+	if !ok {
+		s.discardAttachmentRow(id)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Storage backend does not support multipart uploads"})
+		return
+	}
+
+	uploadID, err := backend.CreateMultipart(c.Request.Context(), key, contentType)
+	if err != nil {
+		log.Printf("Failed to start multipart upload for attachment %d: %v", id, err)
+		s.discardAttachmentRow(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start upload"})
+		return
+	}
+
+	if _, err := s.db.Exec("UPDATE attachments SET upload_id = ? WHERE id = ?", uploadID, id); err != nil {
+		if err := backend.AbortMultipart(c.Request.Context(), key, uploadID); err != nil {
+			log.Printf("Failed to abort multipart upload for attachment %d: %v", id, err)
+		}
+		s.discardAttachmentRow(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start upload"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":           snowflake.ID(id),
+			"multipart":    true,
+			"part_size":    attachmentPartSize,
+			"part_count":   attachmentPartCount(size),
+			"parts_url":    s.externalURL(fmt.Sprintf("/api/attachments/%d/parts", id)),
+			"complete_url": s.externalURL(fmt.Sprintf("/api/attachments/%d/complete", id)),
+		},
+	})
+}
+
+func (s *Server) discardAttachmentRow(id int64) {
+	if _, err := s.db.Exec("DELETE FROM attachments WHERE id = ?", id); err != nil {
+		log.Printf("Failed to delete attachment %d: %v", id, err)
+	}
+}
+
+// pendingMultipartAttachment loads the caller's in-progress multipart
+// attachment, writing an error response when there is none
+func (s *Server) pendingMultipartAttachment(c *gin.Context) (*attachment, storage.MultipartBackend, bool) {
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.UploaderID != c.GetInt("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return nil, nil, false
+	}
+	backend, ok := s.multipartStorage()
+	if a.Status != "pending" || !a.UploadID.Valid || !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Attachment has no multipart upload in progress"})
+		return nil, nil, false
+	}
+	return a, backend, true
+}
+
+// attachmentPartNumber parses the :number parameter of a part route
+func attachmentPartNumber(c *gin.Context, a *attachment) (int, bool) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil || number < 1 || number > attachmentPartCount(a.Size) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Part number must be between 1 and %d", attachmentPartCount(a.Size))})
+		return 0, false
+	}
+	return number, true
+}
+
+// handleGetAttachmentParts lists uploaded parts so an interrupted upload can resume
+func (s *Server) handleGetAttachmentParts(c *gin.Context) {
+	a, backend, ok := s.pendingMultipartAttachment(c)
+	if !ok {
+		return
+	}
+
+	parts, err := backend.ListParts(c.Request.Context(), a.StorageKey, a.UploadID.String)
+	if err != nil {
+		log.Printf("Failed to list parts for attachment %d: %v", a.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list uploaded parts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"part_size":  attachmentPartSize,
+			"part_count": attachmentPartCount(a.Size),
+			"parts":      parts,
+		},
+	})
+}
+
+// handleCreateAttachmentPartURL tells the client where to send one part
+func (s *Server) handleCreateAttachmentPartURL(c *gin.Context) {
+	a, _, ok := s.pendingMultipartAttachment(c)
+	if !ok {
+		return
+	}
+	number, ok := attachmentPartNumber(c, a)
+	if !ok {
+		return
+	}
+	length := attachmentPartLength(a.Size, number)
+
+	upload := &storage.PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     s.externalURL(fmt.Sprintf("/api/attachments/%d/parts/%d", a.ID, number)),
+		Expires: time.Now().Add(attachmentUploadExpiry),
+	}
+	if presigner, ok := s.storage.(storage.PartPresigner); ok {
+		var err error
+		upload, err = presigner.PresignPart(a.StorageKey, a.UploadID.String, number, length, attachmentUploadExpiry)
+		if err != nil {
+			log.Printf("Failed to presign part %d of attachment %d: %v", number, a.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload URL"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"number": number,
+			"size":   length,
+			"upload": upload,
+		},
+	})
+}
+
+// handleUploadAttachmentPart receives one part for backends without presigning
+func (s *Server) handleUploadAttachmentPart(c *gin.Context) {
+	a, backend, ok := s.pendingMultipartAttachment(c)
+	if !ok {
+		return
+	}
+	if _, ok := s.storage.(storage.PartPresigner); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload directly to the storage URL"})
+		return
+	}
+	number, ok := attachmentPartNumber(c, a)
+	if !ok {
+		return
+	}
+	length := attachmentPartLength(a.Size, number)
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, length)
+	part, err := backend.UploadPart(c.Request.Context(), a.StorageKey, a.UploadID.String, number, body, length)
+	if err != nil {
+		log.Printf("Failed to store part %d of attachment %d: %v", number, a.ID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Part %d must be exactly %d bytes", number, length)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    part,
+	})
+}
+
+// completeMultipartAttachment assembles the uploaded parts once all are present
+func (s *Server) completeMultipartAttachment(c *gin.Context, a *attachment) error {
+	backend, ok := s.multipartStorage()
+	if !ok {
+		return errors.New("Storage backend does not support multipart uploads")
+	}
+
+	parts, err := backend.ListParts(c.Request.Context(), a.StorageKey, a.UploadID.String)
+	if err != nil {
+		log.Printf("Failed to list parts for attachment %d: %v", a.ID, err)
+		return errors.New("Failed to list uploaded parts")
+	}
+
+	count := attachmentPartCount(a.Size)
+	if len(parts) != count {
+		return fmt.Errorf("Uploaded %d of %d parts", len(parts), count)
+	}
+	for i, part := range parts {
+		if part.Number != i+1 {
+			return fmt.Errorf("Part %d is missing", i+1)
+		}
+		if part.Size != attachmentPartLength(a.Size, part.Number) {
+			return fmt.Errorf("Part %d has the wrong size", part.Number)
+		}
+	}
+
+	if err := backend.CompleteMultipart(c.Request.Context(), a.StorageKey, a.UploadID.String, parts); err != nil {
+		log.Printf("Failed to complete multipart upload for attachment %d: %v", a.ID, err)
+		return errors.New("Failed to assemble upload")
+	}
+
+	if _, err := s.db.Exec("UPDATE attachments SET upload_id = NULL WHERE id = ?", a.ID); err != nil {
+		log.Printf("Failed to clear upload ID of attachment %d: %v", a.ID, err)
+	}
+	a.UploadID.Valid = false
+	return nil
+}
+
+// handleDeleteAttachment cancels an upload that has not been sent in a message
+func (s *Server) handleDeleteAttachment(c *gin.Context) {
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.UploaderID != c.GetInt("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if a.MessageID.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Attachment is part of a message"})
+		return
+	}
+
+	if a.UploadID.Valid {
+		if backend, ok := s.multipartStorage(); ok {
+			if err := backend.AbortMultipart(c.Request.Context(), a.StorageKey, a.UploadID.String); err != nil {
+				log.Printf("Failed to abort multipart upload for attachment %d: %v", a.ID, err)
+			}
+		}
+		s.discardAttachmentRow(a.ID)
+	} else if a.BlobHash.Valid {
+		// The blob may be shared; it is only deleted with its last reference
+		s.discardAttachmentRow(a.ID)
+		s.collectBlob(c.Request.Context(), a.BlobHash.String)
+	} else {
+		if err := s.storage.Delete(c.Request.Context(), a.StorageKey); err != nil && err != storage.ErrNotFound {
+			log.Printf("Failed to delete attachment %d: %v", a.ID, err)
+		}
+		s.discardAttachmentRow(a.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Attachment deleted",
+	})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/media"
+	"fethur/internal/snowflake"
+	"fethur/internal/storage"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAttachmentImageQuality = 82
+
+// imageProcessingSettings controls how uploaded images are cleaned up
+type imageProcessingSettings struct {
+	StripMetadata bool
+	Format        string // re-encode target, empty keeps the original encoding
+	Quality       int
+	KeepOriginals bool
+}
+
+func (s *Server) imageProcessingSettings() imageProcessingSettings {
+	format, err := s.db.GetSetting("attachment_image_format")
+	if err != nil {
+		format = ""
+	}
+	return imageProcessingSettings{
+		StripMetadata: s.getBoolSetting("attachment_strip_metadata", true),
+		Format:        format,
+		Quality:       s.getIntSetting("attachment_image_quality", defaultAttachmentImageQuality),
+		KeepOriginals: s.getBoolSetting("attachment_keep_originals", false),
+	}
+}
+
+// needsImageProcessing reports whether an upload goes through the image
+// pipeline before it becomes ready
+func (s *Server) needsImageProcessing(a *attachment) bool {
+	switch baseMediaType(a.ContentType) {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return false
+	}
+	settings := s.imageProcessingSettings()
+	return settings.StripMetadata || settings.Format != ""
+}
+
+// queueAttachmentProcessing marks an attachment as processing and hands it
+// to the job queue. The flag is stored so work lost to a restart is requeued.
+func (s *Server) queueAttachmentProcessing(id int64) error {
+	if _, err := s.db.Exec("UPDATE attachments SET processing = 1 WHERE id = ?", id); err != nil {
+		return err
+	}
+	return s.jobs.Enqueue(fmt.Sprintf("process-attachment-%d", id), func(ctx context.Context) error {
+		return s.processAttachment(ctx, id)
+	})
+}
+
+// requeueAttachmentProcessing resumes processing interrupted by a restart
+func (s *Server) requeueAttachmentProcessing() {
+	rows, err := s.db.Query("SELECT id FROM attachments WHERE processing = 1 AND status = 'pending'")
+	if err != nil {
+		log.Printf("Failed to load attachments awaiting processing: %v", err)
+		return
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	for _, id := range ids {
+		if err := s.queueAttachmentProcessing(id); err != nil {
+			log.Printf("Failed to requeue attachment %d: %v", id, err)
+		}
+	}
+}
+
+// processAttachment strips metadata from an uploaded image, optionally
+// re-encodes it, and then makes it ready
+func (s *Server) processAttachment(ctx context.Context, id int64) error {
+	a, err := s.getAttachment(id)
+	if err == errAttachmentNotFound {
+		return nil // deleted while queued
+	}
+	if err != nil {
+		return err
+	}
+	if !a.Processing || a.Status != "pending" {
+		return nil
+	}
+
+	if err := s.cleanAttachmentImage(ctx, a, s.imageProcessingSettings()); err != nil {
+		s.failAttachmentProcessing(ctx, a, err)
+		return err
+	}
+
+	if err := s.deduplicateAttachment(ctx, a); err != nil {
+		log.Printf("Failed to deduplicate attachment %d: %v", a.ID, err)
+	}
+	if _, err := s.db.Exec(
+		"UPDATE attachments SET status = 'ready', processing = 0, completed_at = CURRENT_TIMESTAMP WHERE id = ?",
+		a.ID,
+	); err != nil {
+		return err
+	}
+
+	s.notifyAttachmentProcessed(a.UploaderID, "attachment_ready", gin.H{"attachment": s.attachmentJSON(a)})
+	return nil
+}
+
+// cleanAttachmentImage replaces the stored image with a cleaned copy. The
+// original is deleted unless the server is configured to keep originals.
+func (s *Server) cleanAttachmentImage(ctx context.Context, a *attachment, settings imageProcessingSettings) error {
+	reader, err := s.storage.Open(ctx, a.StorageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, a.Size+1))
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	contentType := baseMediaType(a.ContentType)
+	processed, changed := data, false
+
+	if settings.Format != "" {
+		// Re-encoding drops all metadata except orientation on its own
+		encoded, _, err := media.ReEncode(contentType, data, settings.Format, settings.Quality)
+		switch {
+		case err == nil && len(encoded) < len(data):
+			processed, changed = encoded, true
+		case err != nil && !errors.Is(err, media.ErrUnsupported):
+			return err
+		}
+	}
+	if settings.StripMetadata && !changed {
+		processed, changed, err = media.StripMetadata(contentType, data)
+		if err != nil {
+			return fmt.Errorf("failed to strip metadata: %w", err)
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	key, err := newStorageKey(a.UploaderID)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.Put(ctx, key, bytes.NewReader(processed), int64(len(processed)), a.ContentType); err != nil {
+		return fmt.Errorf("failed to store processed image: %w", err)
+	}
+
+	var originalKey interface{}
+	if settings.KeepOriginals {
+		originalKey = a.StorageKey
+	}
+	result, err := s.db.Exec(
+		"UPDATE attachments SET storage_key = ?, size = ?, original_key = ? WHERE id = ? AND processing = 1",
+		key, len(processed), originalKey, a.ID,
+	)
+	if affected, _ := result.RowsAffected(); err != nil || affected == 0 {
+		// Deleted while processing
+		if err := s.storage.Delete(ctx, key); err != nil && err != storage.ErrNotFound {
+			log.Printf("Failed to delete processed image %s: %v", key, err)
+		}
+		if err != nil {
+			return err
+		}
+		return errAttachmentNotFound
+	}
+
+	if !settings.KeepOriginals {
+		if err := s.storage.Delete(ctx, a.StorageKey); err != nil && err != storage.ErrNotFound {
+			log.Printf("Failed to delete original of attachment %d: %v", a.ID, err)
+		}
+	}
+
+	log.Printf("Processed attachment %d: %d -> %d bytes", a.ID, a.Size, len(processed))
+	a.StorageKey = key
+	a.Size = int64(len(processed))
+	return nil
+}
+
+// failAttachmentProcessing discards an upload the pipeline could not clean,
+// since serving it could leak the metadata it was meant to remove
+func (s *Server) failAttachmentProcessing(ctx context.Context, a *attachment, cause error) {
+	if errors.Is(cause, errAttachmentNotFound) {
+		return
+	}
+	if err := s.storage.Delete(ctx, a.StorageKey); err != nil && err != storage.ErrNotFound {
+		log.Printf("Failed to delete unprocessable attachment %d: %v", a.ID, err)
+	}
+	s.discardAttachmentRow(a.ID)
+	s.notifyAttachmentProcessed(a.UploaderID, "attachment_failed", gin.H{
+		"attachment_id": snowflake.ID(a.ID),
+		"error":         "The image could not be processed",
+	})
+}
+
+func (s *Server) notifyAttachmentProcessed(userID int, kind string, data gin.H) {
+	s.clientsMux.RLock()
+	client, ok := s.clients[userID]
+	s.clientsMux.RUnlock()
+	if !ok {
+		return
+	}
+
+	data["kind"] = kind
+	client.Send(&websocket.Message{
+		Type:      string(events.TypeNotification),
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+}
+
+// respondAttachmentProcessing tells the client the attachment will be
+// ready once processed; an attachment_ready notification follows
+func (s *Server) respondAttachmentProcessing(c *gin.Context, a *attachment) {
+	data := s.attachmentJSON(a)
+	data["processing"] = true
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Attachment content never changes, so clients may cache it indefinitely
+	attachmentCacheMaxAge = 365 * 24 * time.Hour
+
+	// Signed URLs are issued per window so every request in a window gets
+	// the same URL and a CDN can serve it from cache
+	signedURLWindow  = time.Hour
+	signedURLPurpose = "attachment"
+)
+
+// attachmentETag is a strong validator: the content hash when known,
+// otherwise the attachment ID, since stored content is immutable
+func attachmentETag(a *attachment) string {
+	if a.BlobHash.Valid {
+		return `"` + a.BlobHash.String + `"`
+	}
+	return fmt.Sprintf(`"attachment-%d"`, a.ID)
+}
+
+func (s *Server) signedURLsEnabled() bool {
+	return s.getBoolSetting("attachment_signed_urls", false)
+}
+
+// signedAttachmentURL returns a URL serving the attachment without a
+// session. It stays valid until the end of the following window.
+func (s *Server) signedAttachmentURL(a *attachment, now time.Time) (string, time.Time) {
+	expires := now.Truncate(signedURLWindow).Add(2 * signedURLWindow)
+	value := fmt.Sprintf("%d:%d", a.ID, expires.Unix())
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", s.auth.Sign(signedURLPurpose, value))
+	return s.externalURL(fmt.Sprintf("/api/files/%d?%s", a.ID, query.Encode())), expires
+}
+
+// serveAttachment streams an attachment with validators, cache headers and
+// byte-range support so audio and video are seekable
+func (s *Server) serveAttachment(c *gin.Context, a *attachment, cacheControl string) {
+	reader, err := s.storage.Open(c.Request.Context(), a.StorageKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing attachment reader: %v", err)
+		}
+	}()
+
+	header := c.Writer.Header()
+	header.Set("ETag", attachmentETag(a))
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Content-Type", a.ContentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	// Seekable readers get full Range, If-Range and conditional handling
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		var modified time.Time
+		if a.CompletedAt.Valid {
+			modified = a.CompletedAt.Time
+		}
+		http.ServeContent(c.Writer, c.Request, "", modified, seeker)
+		return
+	}
+
+	if etagMatches(c.GetHeader("If-None-Match"), attachmentETag(a)) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	header.Set("Accept-Ranges", "none")
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, reader, nil)
+}
+
+// handleGetSignedAttachment serves an attachment through a signed URL. It
+// needs no session, so responses are publicly cacheable until the URL expires.
+func (s *Server) handleGetSignedAttachment(c *gin.Context) {
+	if !s.signedURLsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	remaining := time.Until(time.Unix(expires, 0))
+	value := fmt.Sprintf("%s:%d", c.Param("id"), expires)
+	if err != nil || remaining <= 0 || !s.auth.VerifySignature(signedURLPurpose, value, c.Query("sig")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	a, err := s.getAttachment(c.Param("id"))
+	if err != nil || a.Status != "ready" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	s.serveAttachment(c, a, fmt.Sprintf("public, max-age=%d, immutable", int(remaining.Seconds())))
+}
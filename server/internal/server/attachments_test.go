@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+)
+
+func TestAttachmentTypeChecks(t *testing.T) {
+	allowed := map[string]bool{
+		"image/png":                 true,
+		"video/mp4":                 true,
+		"text/plain; charset=utf-8": true,
+		"application/pdf":           true,
+		"application/x-msdownload":  false,
+		"text/html":                 false,
+	}
+	for contentType, want := range allowed {
+		if got := isAllowedAttachmentType(contentType); got != want {
+			t.Errorf("isAllowedAttachmentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+
+	tests := []struct {
+		declared string
+		sniffed  string
+		want     bool
+	}{
+		{"image/png", "image/png", true},
+		{"image/png", "text/html; charset=utf-8", false},
+		{"application/zip", "image/gif", false},
+		{"application/json", "text/plain; charset=utf-8", true},
+		{"audio/flac", "application/octet-stream", true},
+	}
+	for _, tt := range tests {
+		if got := sniffedTypeMatches(tt.declared, tt.sniffed); got != tt.want {
+			t.Errorf("sniffedTypeMatches(%q, %q) = %v, want %v", tt.declared, tt.sniffed, got, tt.want)
+		}
+	}
+}
+
+func TestAttachmentPartLayout(t *testing.T) {
+	size := 2*attachmentPartSize + 100
+
+	if got := attachmentPartCount(size); got != 3 {
+		t.Fatalf("Expected 3 parts, got %d", got)
+	}
+	if got := attachmentPartLength(size, 1); got != attachmentPartSize {
+		t.Errorf("Expected full first part, got %d", got)
+	}
+	if got := attachmentPartLength(size, 3); got != 100 {
+		t.Errorf("Expected 100 byte last part, got %d", got)
+	}
+	if got := attachmentPartCount(attachmentPartSize); got != 1 {
+		t.Errorf("Expected exactly one part for a part-sized file, got %d", got)
+	}
+}
+
+func TestSignedAttachmentURL(t *testing.T) {
+	s := &Server{auth: auth.NewService()}
+	a := &attachment{ID: 12}
+	now := time.Date(2025, 3, 1, 10, 5, 0, 0, time.UTC)
+
+	first, expires := s.signedAttachmentURL(a, now)
+	second, _ := s.signedAttachmentURL(a, now.Add(40*time.Minute))
+	if first != second {
+		t.Errorf("Expected the same URL within a window for CDN caching, got %s and %s", first, second)
+	}
+	if expires.Sub(now) < signedURLWindow {
+		t.Errorf("Expected URL to stay valid for at least a window, expires %v", expires)
+	}
+
+	parsed, err := url.Parse(first)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL: %v", err)
+	}
+	query := parsed.Query()
+	if !s.auth.VerifySignature(signedURLPurpose, "12:"+query.Get("expires"), query.Get("sig")) {
+		t.Error("Expected signature to verify")
+	}
+	if s.auth.VerifySignature(signedURLPurpose, "13:"+query.Get("expires"), query.Get("sig")) {
+		t.Error("Expected signature not to cover another attachment")
+	}
+}
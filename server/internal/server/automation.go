@@ -0,0 +1,654 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/snowflake"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The automation API is a small, stable surface for no-code platforms
+// such as Zapier and n8n: API-key auth, channel listing, posting, cursor
+// polling and REST hook subscriptions. Its payloads are versioned by the
+// /api/automation/v1 prefix and must not change shape.
+
+// apiKeyPrefix marks Fethur API keys so they are recognizable in configs
+const apiKeyPrefix = "fk_"
+
+// maxAPIKeysPerUser bounds how many keys one account may create
+const maxAPIKeysPerUser = 20
+
+// maxHookFailures is how many consecutive failed deliveries remove a hook
+const maxHookFailures = 25
+
+// Automation hook events
+const (
+	// hookEventMessageCreated fires for every new channel message
+	hookEventMessageCreated = "message.created"
+	// hookEventRaidMode fires when a server's raid mode turns on or off;
+	// only owners and admins receive it
+	hookEventRaidMode = "raid_mode.changed"
+)
+
+// automationMessage is the stable message shape of the automation API
+type automationMessage struct {
+	ID        snowflake.ID     `json:"id"`
+	ChannelID int              `json:"channel_id"`
+	ServerID  int              `json:"server_id"`
+	Author    automationAuthor `json:"author"`
+	BotName   string           `json:"bot_name,omitempty"`
+	Content   string           `json:"content"`
+	CreatedAt string           `json:"created_at"`
+}
+
+type automationAuthor struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// hashAPIKey returns the stored form of an API key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyMiddleware authenticates automation requests by X-API-Key, or a
+// bearer token that is an API key with the automation scope
+func (s *Server) apiKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, err := s.lookupAPIKey(apiKeyFromRequest(c))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid API key"})
+			c.Abort()
+			return
+		}
+		if s.isUserBanned(key.userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+			c.Abort()
+			return
+		}
+		if !key.allows(scopeAutomation) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the automation scope"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", key.userID)
+		c.Set("username", key.username)
+		c.Set("api_key", key)
+		c.Set("api_key_id", key.id)
+		c.Next()
+	}
+}
+
+// handleGetAPIKeys lists the user's API keys without the secrets
+func (s *Server) handleGetAPIKeys(c *gin.Context) {
+	rows, err := s.db.Query(
+		"SELECT id, name, prefix, scopes, created_at, last_used_at, expires_at FROM api_keys WHERE user_id = ? ORDER BY id",
+		c.GetInt("user_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	keys := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var name, prefix, scopes, createdAt string
+		var lastUsedAt, expiresAt *string
+		if err := rows.Scan(&id, &name, &prefix, &scopes, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+			continue
+		}
+		keys = append(keys, gin.H{
+			"id":           id,
+			"name":         name,
+			"prefix":       prefix,
+			"scopes":       strings.Split(scopes, ","),
+			"created_at":   createdAt,
+			"last_used_at": lastUsedAt,
+			"expires_at":   expiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    keys,
+	})
+}
+
+// handleCreateAPIKey creates an API key; the key is only shown once.
+// Without scopes the key may do anything its user may, and without
+// expires_in_days it never expires.
+func (s *Server) handleCreateAPIKey(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-64 characters"})
+		return
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in_days must be 0-%d", maxAPIKeyDays)})
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		expiry := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays).Truncate(time.Second)
+		expiresAt = &expiry
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE user_id = ?", userID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("At most %d API keys can be created", maxAPIKeysPerUser)})
+		return
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(random)
+	prefix := key[:len(apiKeyPrefix)+6]
+
+	result, err := s.db.Exec(
+		"INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, req.Name, prefix, hashAPIKey(key), scopes, expiresAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	log.Printf("User %d created API key %d (%s)", userID, id, req.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":         id,
+			"name":       req.Name,
+			"prefix":     prefix,
+			"scopes":     strings.Split(scopes, ","),
+			"expires_at": expiresAt,
+			"key":        key,
+		},
+	})
+}
+
+// handleDeleteAPIKey revokes an API key and its hook subscriptions
+func (s *Server) handleDeleteAPIKey(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM api_keys WHERE id = ? AND user_id = ?", keyID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM automation_hooks WHERE api_key_id = ?", keyID); err != nil {
+		log.Printf("Failed to remove hooks of API key %d: %v", keyID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "API key revoked successfully",
+	})
+}
+
+// handleAutomationMe identifies the key's user; platforms use it to test
+// a connection
+func (s *Server) handleAutomationMe(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":       c.GetInt("user_id"),
+			"username": c.GetString("username"),
+		},
+	})
+}
+
+// handleAutomationChannels lists the text channels the key can read and
+// post to
+func (s *Server) handleAutomationChannels(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT c.id, c.name, s.id, s.name FROM channels c
+		JOIN servers s ON s.id = c.server_id
+		JOIN server_members sm ON sm.server_id = c.server_id
+		WHERE sm.user_id = ? AND c.channel_type != 'voice' AND `+channelVisibleSQL+`
+		ORDER BY s.name, c.name`, c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get channels"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	channels := make([]gin.H, 0)
+	for rows.Next() {
+		var channelID, serverID int
+		var channelName, serverName string
+		if err := rows.Scan(&channelID, &channelName, &serverID, &serverName); err != nil {
+			continue
+		}
+		channels = append(channels, gin.H{
+			"id":          channelID,
+			"name":        channelName,
+			"server_id":   serverID,
+			"server_name": serverName,
+			"label":       serverName + " #" + channelName,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    channels,
+	})
+}
+
+// handleAutomationPostMessage posts a message as the key's user, or under
+// bot_name when given
+func (s *Server) handleAutomationPostMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	username := c.GetString("username")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+		BotName string `json:"bot_name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.BotName = strings.TrimSpace(req.BotName)
+	if len(req.BotName) > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bot_name must be at most 32 characters"})
+		return
+	}
+
+	channel, err := s.lookupChannelForUser(userID, channelID)
+	if err != nil || channel.ChannelType == "voice" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if !s.integrationAllowed(channelID, botIntegration(userID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This bot may not post in this channel"})
+		return
+	}
+	// Bots often relay what people wrote, so the content filter applies
+	verdict, ok := s.checkContent(c, channel.ServerID, req.Content)
+	if !ok {
+		return
+	}
+	req.Content = verdict.Content
+
+	messageID, err := s.postChannelMessage(channelID, userID, username, req.BotName, req.Content, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	s.recordMessageUsage(userID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": automationMessage{
+			ID:        snowflake.ID(messageID),
+			ChannelID: channelID,
+			ServerID:  channel.ServerID,
+			Author:    automationAuthor{ID: userID, Username: username},
+			BotName:   req.BotName,
+			Content:   req.Content,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// handleAutomationPollMessages returns messages newer than cursor, oldest
+// first, across the key's channels or in one channel. Without a cursor it
+// returns the latest messages, so platforms can sample the trigger.
+func (s *Server) handleAutomationPollMessages(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	var cursor int64
+	if value := c.Query("cursor"); value != "" {
+		if cursor, err = strconv.ParseInt(value, 10, 64); err != nil || cursor < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	query := `
+		SELECT m.id, m.channel_id, c.server_id, m.user_id, u.username, COALESCE(m.bot_name, ''), m.content, m.created_at
+		FROM messages m
+		JOIN channels c ON c.id = m.channel_id
+		JOIN server_members sm ON sm.server_id = c.server_id AND sm.user_id = ?
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id > ? AND m.quarantined = 0`
+	args := []interface{}{userID, cursor}
+	if value := c.Query("channel_id"); value != "" {
+		channelID, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+		query += " AND m.channel_id = ?"
+		args = append(args, channelID)
+	}
+	if c.Query("cursor") == "" {
+		query += " ORDER BY m.id DESC LIMIT ?"
+	} else {
+		query += " ORDER BY m.id ASC LIMIT ?"
+	}
+	args = append(args, limit)
+
+	rows, err := s.reader(c).Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	messages := make([]automationMessage, 0)
+	for rows.Next() {
+		var m automationMessage
+		if err := rows.Scan(&m.ID, &m.ChannelID, &m.ServerID, &m.Author.ID, &m.Author.Username, &m.BotName, &m.Content, &m.CreatedAt); err != nil {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	if c.Query("cursor") == "" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	nextCursor := cursor
+	if len(messages) > 0 {
+		nextCursor = int64(messages[len(messages)-1].ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"data":        messages,
+		"next_cursor": strconv.FormatInt(nextCursor, 10),
+		"has_more":    c.Query("cursor") != "" && len(messages) == limit,
+	})
+}
+
+// handleGetAutomationHooks lists the hooks created with this API key
+func (s *Server) handleGetAutomationHooks(c *gin.Context) {
+	rows, err := s.db.Query(
+		"SELECT id, event, channel_id, target_url, failures, last_status, created_at FROM automation_hooks WHERE api_key_id = ? ORDER BY id",
+		c.GetInt64("api_key_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get hooks"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	hooks := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var event, targetURL, createdAt string
+		var channelID, lastStatus *int
+		var failures int
+		if err := rows.Scan(&id, &event, &channelID, &targetURL, &failures, &lastStatus, &createdAt); err != nil {
+			continue
+		}
+		hooks = append(hooks, gin.H{
+			"id":          id,
+			"event":       event,
+			"channel_id":  channelID,
+			"target_url":  targetURL,
+			"failures":    failures,
+			"last_status": lastStatus,
+			"created_at":  createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    hooks,
+	})
+}
+
+// handleCreateAutomationHook subscribes a URL to an event, the REST hook
+// pattern Zapier uses for instant triggers
+func (s *Server) handleCreateAutomationHook(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Event     string `json:"event" binding:"required"`
+		TargetURL string `json:"target_url" binding:"required"`
+		ChannelID *int   `json:"channel_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Event != hookEventMessageCreated && req.Event != hookEventRaidMode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event must be " + hookEventMessageCreated + " or " + hookEventRaidMode})
+		return
+	}
+	if req.Event == hookEventRaidMode && req.ChannelID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel_id only applies to " + hookEventMessageCreated})
+		return
+	}
+	if err := s.webhookURL(req.TargetURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ChannelID != nil {
+		if _, err := s.lookupChannelForUser(userID, *req.ChannelID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO automation_hooks (user_id, api_key_id, event, channel_id, target_url, secret) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, c.GetInt64("api_key_id"), req.Event, req.ChannelID, req.TargetURL, secret,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create hook"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":         id,
+			"event":      req.Event,
+			"channel_id": req.ChannelID,
+			"target_url": req.TargetURL,
+			"secret":     secret,
+		},
+	})
+}
+
+// handleDeleteAutomationHook unsubscribes a hook
+func (s *Server) handleDeleteAutomationHook(c *gin.Context) {
+	hookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hook ID"})
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM automation_hooks WHERE id = ? AND api_key_id = ?", hookID, c.GetInt64("api_key_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete hook"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hook not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Hook deleted successfully",
+	})
+}
+
+// notifyMessageHooks builds the hook payload for a new message and
+// dispatches it
+func (s *Server) notifyMessageHooks(channelID int, messageID int64, userID int, username, botName, content string) {
+	var serverID int
+	if err := s.db.QueryRow("SELECT server_id FROM channels WHERE id = ?", channelID).Scan(&serverID); err != nil {
+		return
+	}
+	s.dispatchMessageHooks(automationMessage{
+		ID:        snowflake.ID(messageID),
+		ChannelID: channelID,
+		ServerID:  serverID,
+		Author:    automationAuthor{ID: userID, Username: username},
+		BotName:   botName,
+		Content:   content,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// dispatchMessageHooks delivers a new message to every subscribed hook
+// whose owner can read the channel
+func (s *Server) dispatchMessageHooks(message automationMessage) {
+	rows, err := s.db.Query(`
+		SELECT h.id, h.target_url FROM automation_hooks h
+		JOIN server_members sm ON sm.user_id = h.user_id AND sm.server_id = ?
+		WHERE h.event = ? AND (h.channel_id IS NULL OR h.channel_id = ?)`,
+		message.ServerID, hookEventMessageCreated, message.ChannelID,
+	)
+	if err != nil {
+		log.Printf("Failed to load automation hooks: %v", err)
+		return
+	}
+	type hook struct {
+		id        int64
+		targetURL string
+	}
+	hooks := make([]hook, 0)
+	for rows.Next() {
+		var h hook
+		if err := rows.Scan(&h.id, &h.targetURL); err == nil {
+			hooks = append(hooks, h)
+		}
+	}
+	_ = rows.Close()
+
+	payload := gin.H{"event": hookEventMessageCreated, "data": message}
+	for _, h := range hooks {
+		s.deliverAutomationHook(h.id, h.targetURL, fmt.Sprintf("automation-hook-%d-%d", h.id, message.ID), payload)
+	}
+}
+
+// deliverAutomationHook queues one delivery of a payload to a hook. A 410
+// Gone response unsubscribes the hook, as do repeated failures. Secrets
+// are read when the delivery runs, so retries use a rotated secret.
+func (s *Server) deliverAutomationHook(hookID int64, targetURL, jobID string, payload gin.H) {
+	err := s.jobs.Enqueue(jobID, func(ctx context.Context) error {
+		var secret, previousSecret string
+		err := s.db.QueryRow(`
+			SELECT secret, CASE WHEN secret_rotated_at > datetime('now', ?) THEN previous_secret ELSE '' END
+			FROM automation_hooks WHERE id = ?`, secretRotationGrace, hookID,
+		).Scan(&secret, &previousSecret)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Unsubscribed since the delivery was queued
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		client := &webhooks.Client{HTTP: s.fetcher.Client(10 * time.Second)}
+		err = s.integrations.Guard(integrationWebhooks, webhookPolicy).Do(ctx, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			_, err := client.Post(ctx, targetURL, signingSecrets(secret, previousSecret), payload)
+			return webhookError(err)
+		})
+
+		var statusErr *webhooks.StatusError
+		switch {
+		case err == nil:
+			_, _ = s.db.Exec("UPDATE automation_hooks SET failures = 0, last_status = 200 WHERE id = ?", hookID)
+			return nil
+		case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGone:
+			log.Printf("Automation hook %d unsubscribed with 410 Gone", hookID)
+			_, _ = s.db.Exec("DELETE FROM automation_hooks WHERE id = ?", hookID)
+			return nil
+		case errors.As(err, &statusErr):
+			_, _ = s.db.Exec("UPDATE automation_hooks SET failures = failures + 1, last_status = ? WHERE id = ?", statusErr.StatusCode, hookID)
+		default:
+			_, _ = s.db.Exec("UPDATE automation_hooks SET failures = failures + 1, last_status = NULL WHERE id = ?", hookID)
+		}
+		if _, err := s.db.Exec("DELETE FROM automation_hooks WHERE id = ? AND failures >= ?", hookID, maxHookFailures); err != nil {
+			log.Printf("Failed to prune automation hook %d: %v", hookID, err)
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to queue automation hook %d: %v", hookID, err)
+	}
+}
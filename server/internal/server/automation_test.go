@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/fetch"
+	"fethur/internal/jobs"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAutomationAPI(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.fetcher = fetch.New(fetch.Config{AllowPrivate: true})
+	s.jobs = queue
+
+	suffix := time.Now().UnixNano()
+	username := fmt.Sprintf("zapier_%d", suffix)
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", username)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Automation %d", suffix), userID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'alerts')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/user/api-keys", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		s.handleCreateAPIKey(c)
+	})
+	automation := router.Group("/automation/v1", s.apiKeyMiddleware())
+	automation.GET("/channels", s.handleAutomationChannels)
+	automation.POST("/channels/:channelId/messages", s.handleAutomationPostMessage)
+	automation.GET("/messages", s.handleAutomationPollMessages)
+	automation.POST("/hooks", s.handleCreateAutomationHook)
+
+	request := func(method, path, key, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := request(http.MethodPost, "/user/api-keys", "", `{"name":"Zapier"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Failed to create API key: %d %v", code, response)
+	}
+	key := response["data"].(map[string]interface{})["key"].(string)
+
+	if code, _ := request(http.MethodGet, "/automation/v1/channels", "fk_wrong", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be rejected, got %d", code)
+	}
+	code, response = request(http.MethodGet, "/automation/v1/channels", key, "")
+	if code != http.StatusOK || len(response["data"].([]interface{})) != 1 {
+		t.Fatalf("Expected one channel, got %d %v", code, response)
+	}
+
+	// Subscribe a hook that verifies signatures, then unsubscribes itself
+	delivered := make(chan string, 4)
+	var secret string
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhooks.Verify(secret, r.Header.Get(webhooks.TimestampHeader), r.Header.Get(webhooks.SignatureHeader), body, time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload struct {
+			Event string            `json:"event"`
+			Data  automationMessage `json:"data"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		delivered <- payload.Event + ":" + payload.Data.Content
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer hookServer.Close()
+
+	code, response = request(http.MethodPost, "/automation/v1/hooks", key,
+		fmt.Sprintf(`{"event":"message.created","target_url":%q,"channel_id":%d}`, hookServer.URL, channelID))
+	if code != http.StatusCreated {
+		t.Fatalf("Failed to create hook: %d %v", code, response)
+	}
+	secret = response["data"].(map[string]interface{})["secret"].(string)
+
+	path := fmt.Sprintf("/automation/v1/channels/%d/messages", channelID)
+	for _, content := range []string{"first", "second", "third"} {
+		if code, response := request(http.MethodPost, path, key, fmt.Sprintf(`{"content":%q,"bot_name":"Zap"}`, content)); code != http.StatusCreated {
+			t.Fatalf("Failed to post message: %d %v", code, response)
+		}
+	}
+
+	select {
+	case got := <-delivered:
+		if got != "message.created:first" {
+			t.Errorf("Unexpected hook delivery %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the hook delivery")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var hooks int
+		_ = db.QueryRow("SELECT COUNT(*) FROM automation_hooks WHERE user_id = ?", userID).Scan(&hooks)
+		if hooks == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a 410 response to unsubscribe the hook")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Polling without a cursor samples the latest messages, oldest first
+	query := fmt.Sprintf("/automation/v1/messages?channel_id=%d&limit=2", channelID)
+	code, response = request(http.MethodGet, query, key, "")
+	messages := response["data"].([]interface{})
+	if code != http.StatusOK || len(messages) != 2 || messages[1].(map[string]interface{})["content"] != "third" {
+		t.Fatalf("Unexpected sample: %d %v", code, response)
+	}
+	if messages[0].(map[string]interface{})["bot_name"] != "Zap" {
+		t.Errorf("Expected bot_name in messages, got %v", messages[0])
+	}
+
+	// IDs are strings; snowflakes do not fit in a JSON number
+	firstID, err := strconv.ParseInt(messages[0].(map[string]interface{})["id"].(string), 10, 64)
+	if err != nil {
+		t.Fatalf("Message ID is not a decimal string: %v", messages[0])
+	}
+	first := strconv.FormatInt(firstID-1, 10)
+	code, response = request(http.MethodGet, query+"&cursor="+first, key, "")
+	messages = response["data"].([]interface{})
+	if code != http.StatusOK || len(messages) != 2 || response["has_more"] != true {
+		t.Fatalf("Unexpected page: %d %v", code, response)
+	}
+	code, response = request(http.MethodGet, query+"&cursor="+response["next_cursor"].(string), key, "")
+	if code != http.StatusOK || len(response["data"].([]interface{})) != 0 || response["has_more"] != false {
+		t.Errorf("Expected an empty final page, got %d %v", code, response)
+	}
+}
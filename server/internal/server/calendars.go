@@ -0,0 +1,715 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fethur/internal/ics"
+	"fethur/internal/inbound"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sqliteTimeLayout stores times in UTC, comparable with CURRENT_TIMESTAMP
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// calendarHorizon is how far ahead occurrences are cached
+const calendarHorizon = 60 * 24 * time.Hour
+
+// calendarFetchTimeout bounds one feed download
+const calendarFetchTimeout = 15 * time.Second
+
+// maxCalendarSize bounds a feed download
+const maxCalendarSize = 5 << 20
+
+// maxCalendarEvents bounds the occurrences cached per calendar
+const maxCalendarEvents = 500
+
+// maxCalendarsPerChannel bounds the feeds one channel can subscribe to
+const maxCalendarsPerChannel = 5
+
+// maxLeadMinutes is the earliest a reminder can be posted, one week ahead
+const maxLeadMinutes = 7 * 24 * 60
+
+// reminderGrace is how late a reminder may still be posted, e.g. after a
+// restart; older reminders are skipped rather than posted out of date
+const reminderGrace = 15 * time.Minute
+
+// calendarInfo is a channel's calendar subscription
+type calendarInfo struct {
+	ID          int64
+	ChannelID   int
+	Name        string
+	LeadMinutes []int
+	CreatedBy   int
+}
+
+// parseLeadMinutes reads the stored comma-separated lead times
+func parseLeadMinutes(value string) []int {
+	var leads []int
+	for _, part := range strings.Split(value, ",") {
+		if minutes, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			leads = append(leads, minutes)
+		}
+	}
+	return leads
+}
+
+// normalizeLeadMinutes validates lead times and returns them sorted and
+// deduplicated, longest first
+func normalizeLeadMinutes(leads []int) ([]int, error) {
+	if len(leads) == 0 || len(leads) > 5 {
+		return nil, errors.New("lead_minutes must have 1-5 entries")
+	}
+	seen := make(map[int]bool, len(leads))
+	normalized := make([]int, 0, len(leads))
+	for _, minutes := range leads {
+		if minutes < 0 || minutes > maxLeadMinutes {
+			return nil, fmt.Errorf("lead_minutes must be between 0 and %d", maxLeadMinutes)
+		}
+		if !seen[minutes] {
+			seen[minutes] = true
+			normalized = append(normalized, minutes)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(normalized)))
+	return normalized, nil
+}
+
+func formatLeadMinutes(leads []int) string {
+	parts := make([]string, 0, len(leads))
+	for _, minutes := range leads {
+		parts = append(parts, strconv.Itoa(minutes))
+	}
+	return strings.Join(parts, ",")
+}
+
+// calendarURL checks a feed URL; webcal:// links are fetched over https
+func (s *Server) calendarURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(strings.ToLower(value), "webcal://") {
+		value = "https://" + value[len("webcal://"):]
+	}
+	if err := s.webhookURL(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// fetchCalendar downloads and parses a feed
+func (s *Server) fetchCalendar(ctx context.Context, feedURL string) (*ics.Calendar, error) {
+	ctx, cancel := context.WithTimeout(ctx, calendarFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	req.Header.Set("User-Agent", "Fethur-Calendar/1.0")
+	resp, err := s.fetcher.Client(calendarFetchTimeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCalendarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCalendarSize {
+		return nil, errors.New("feed is larger than 5 MB")
+	}
+	return ics.Parse(data)
+}
+
+// storeCalendarEvents replaces a calendar's cached occurrences
+func (s *Server) storeCalendarEvents(calendarID int64, calendar *ics.Calendar, now time.Time) error {
+	events := calendar.Occurrences(now.Add(-24*time.Hour), now.Add(calendarHorizon))
+	if len(events) > maxCalendarEvents {
+		events = events[:maxCalendarEvents]
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec("DELETE FROM calendar_events WHERE calendar_id = ?", calendarID); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO calendar_events
+				(calendar_id, event_key, uid, summary, description, location, url, starts_at, ends_at, all_day)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			calendarID, event.Key(), event.UID, event.Summary, event.Description, event.Location, event.URL,
+			event.Start.UTC().Format(sqliteTimeLayout), event.End.UTC().Format(sqliteTimeLayout), event.AllDay,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// refreshCalendar fetches a feed and updates its cached occurrences. On
+// failure the previous occurrences are kept and the error is recorded.
+func (s *Server) refreshCalendar(ctx context.Context, calendarID int64, feedURL string) error {
+	calendar, err := s.fetchCalendar(ctx, feedURL)
+	if err == nil {
+		err = s.storeCalendarEvents(calendarID, calendar, time.Now())
+	}
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	if _, dbErr := s.db.Exec(
+		"UPDATE channel_calendars SET last_fetched_at = CURRENT_TIMESTAMP, last_error = ? WHERE id = ?",
+		lastError, calendarID,
+	); dbErr != nil {
+		log.Printf("Failed to update calendar %d: %v", calendarID, dbErr)
+	}
+	return err
+}
+
+// startCalendarScheduler refreshes due calendars and posts reminders
+// every minute
+func (s *Server) startCalendarScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refreshDueCalendars(ctx)
+				s.sendCalendarReminders(time.Now())
+			}
+		}
+	}()
+}
+
+// refreshDueCalendars refreshes calendars not fetched within
+// calendar_refresh_minutes
+func (s *Server) refreshDueCalendars(ctx context.Context) {
+	minutes := s.getIntSetting("calendar_refresh_minutes", 30)
+	rows, err := s.db.Query(`
+		SELECT id, url FROM channel_calendars
+		WHERE last_fetched_at IS NULL OR last_fetched_at <= datetime('now', ?)`,
+		fmt.Sprintf("-%d minutes", minutes),
+	)
+	if err != nil {
+		log.Printf("Failed to list calendars: %v", err)
+		return
+	}
+	type due struct {
+		id  int64
+		url string
+	}
+	var calendars []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.url); err == nil {
+			calendars = append(calendars, d)
+		}
+	}
+	_ = rows.Close()
+
+	for _, calendar := range calendars {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.refreshCalendar(ctx, calendar.id, calendar.url); err != nil {
+			log.Printf("Failed to refresh calendar %d: %v", calendar.id, err)
+		}
+	}
+
+	if _, err := s.db.Exec("DELETE FROM calendar_reminders WHERE sent_at < datetime('now', '-90 days')"); err != nil {
+		log.Printf("Failed to prune calendar reminders: %v", err)
+	}
+}
+
+// sendCalendarReminders posts each reminder whose lead time has been
+// reached, once per occurrence and lead time
+func (s *Server) sendCalendarReminders(now time.Time) {
+	calendars, err := s.listCalendars()
+	if err != nil {
+		log.Printf("Failed to list calendars: %v", err)
+		return
+	}
+
+	for _, calendar := range calendars {
+		for _, lead := range calendar.LeadMinutes {
+			// The reminder is due when start - lead has passed, but not
+			// by more than the grace period
+			latest := now.Add(time.Duration(lead) * time.Minute)
+			earliest := latest.Add(-reminderGrace)
+			rows, err := s.db.Query(`
+				SELECT event_key, summary, description, location, url, starts_at, all_day
+				FROM calendar_events
+				WHERE calendar_id = ? AND starts_at > ? AND starts_at <= ?
+				ORDER BY starts_at`,
+				calendar.ID, earliest.UTC().Format(sqliteTimeLayout), latest.UTC().Format(sqliteTimeLayout),
+			)
+			if err != nil {
+				log.Printf("Failed to read events for calendar %d: %v", calendar.ID, err)
+				continue
+			}
+			var events []calendarEvent
+			for rows.Next() {
+				var event calendarEvent
+				if err := rows.Scan(&event.Key, &event.Summary, &event.Description, &event.Location, &event.URL, &event.Start, &event.AllDay); err != nil {
+					continue
+				}
+				events = append(events, event)
+			}
+			_ = rows.Close()
+
+			for _, event := range events {
+				result, err := s.db.Exec(
+					"INSERT OR IGNORE INTO calendar_reminders (calendar_id, event_key, lead_minutes) VALUES (?, ?, ?)",
+					calendar.ID, event.Key, lead,
+				)
+				if err != nil {
+					log.Printf("Failed to record reminder for calendar %d: %v", calendar.ID, err)
+					continue
+				}
+				if inserted, _ := result.RowsAffected(); inserted == 0 {
+					continue
+				}
+				s.postCalendarReminder(calendar, event, lead)
+			}
+		}
+	}
+}
+
+// calendarEvent is a cached occurrence
+type calendarEvent struct {
+	Key         string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start       time.Time
+	AllDay      bool
+}
+
+// postCalendarReminder posts a reminder under the calendar's name,
+// attributed to the user who subscribed the channel
+func (s *Server) postCalendarReminder(calendar calendarInfo, event calendarEvent, lead int) {
+	channel, err := s.lookupChannelForUser(calendar.CreatedBy, calendar.ChannelID)
+	if err != nil || channel.ChannelType == "voice" {
+		return
+	}
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", calendar.CreatedBy).Scan(&username); err != nil {
+		return
+	}
+
+	content := fmt.Sprintf("Reminder: %s starts in %s", event.Summary, describeLead(lead))
+	if lead == 0 {
+		content = fmt.Sprintf("Starting now: %s", event.Summary)
+	}
+	embed := inbound.Embed{
+		Title:       event.Summary,
+		URL:         event.URL,
+		Description: truncateRunes(event.Description, 500),
+		Color:       inbound.ColorBlue,
+		Timestamp:   event.Start.UTC().Format(time.RFC3339),
+	}
+	// All-day events are dates, not times, and need no timezone
+	when := "All day, " + event.Start.Format("Mon 2 Jan 2006")
+	if !event.AllDay {
+		when = event.Start.In(s.serverLocation()).Format("Mon 2 Jan 2006, 15:04 MST")
+	}
+	embed.Fields = append(embed.Fields, inbound.Field{Name: "When", Value: when, Inline: true})
+	if event.Location != "" {
+		embed.Fields = append(embed.Fields, inbound.Field{Name: "Where", Value: truncateRunes(event.Location, 200), Inline: true})
+	}
+
+	if _, err := s.postChannelMessage(calendar.ChannelID, calendar.CreatedBy, username, calendar.Name, content, []inbound.Embed{embed}); err != nil {
+		log.Printf("Failed to post reminder for calendar %d: %v", calendar.ID, err)
+	}
+}
+
+// describeLead renders a lead time such as "1 hour" or "2 days"
+func describeLead(minutes int) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return strconv.Itoa(n) + " " + unit + "s"
+	}
+	switch {
+	case minutes%(24*60) == 0:
+		return plural(minutes/(24*60), "day")
+	case minutes%60 == 0:
+		return plural(minutes/60, "hour")
+	}
+	return plural(minutes, "minute")
+}
+
+// truncateRunes shortens text to limit runes
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit-1]) + "…"
+}
+
+// listCalendars loads every calendar subscription
+func (s *Server) listCalendars() ([]calendarInfo, error) {
+	rows, err := s.db.Query("SELECT id, channel_id, name, lead_minutes, created_by FROM channel_calendars ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var calendars []calendarInfo
+	for rows.Next() {
+		var calendar calendarInfo
+		var leads string
+		if err := rows.Scan(&calendar.ID, &calendar.ChannelID, &calendar.Name, &leads, &calendar.CreatedBy); err != nil {
+			continue
+		}
+		calendar.LeadMinutes = parseLeadMinutes(leads)
+		calendars = append(calendars, calendar)
+	}
+	return calendars, nil
+}
+
+// handleGetChannelEvents returns the channel's upcoming calendar events,
+// including ones in progress
+func (s *Server) handleGetChannelEvents(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if _, err := s.lookupChannelForUser(userID, channelID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+
+	days := 30
+	if value := c.Query("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > 60 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 60"})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	rows, err := s.reader(c).Query(`
+		SELECT e.calendar_id, cal.name, e.uid, e.summary, e.description, e.location, e.url, e.starts_at, e.ends_at, e.all_day
+		FROM calendar_events e
+		JOIN channel_calendars cal ON cal.id = e.calendar_id
+		WHERE cal.channel_id = ? AND e.ends_at > ? AND e.starts_at < ?
+		ORDER BY e.starts_at
+		LIMIT 200`,
+		channelID, now.Format(sqliteTimeLayout), now.AddDate(0, 0, days).Format(sqliteTimeLayout),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get events"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	events := make([]gin.H, 0)
+	for rows.Next() {
+		var calendarID int64
+		var calendarName, uid, summary, description, location, eventURL string
+		var start, end time.Time
+		var allDay bool
+		if err := rows.Scan(&calendarID, &calendarName, &uid, &summary, &description, &location, &eventURL, &start, &end, &allDay); err != nil {
+			continue
+		}
+		events = append(events, gin.H{
+			"calendar_id":   calendarID,
+			"calendar_name": calendarName,
+			"uid":           uid,
+			"summary":       summary,
+			"description":   description,
+			"location":      location,
+			"url":           eventURL,
+			"start":         start.UTC().Format(time.RFC3339),
+			"end":           end.UTC().Format(time.RFC3339),
+			"all_day":       allDay,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    events,
+	})
+}
+
+// handleGetChannelCalendars lists a channel's feeds for its managers;
+// feed URLs often embed private tokens, so members do not see them
+func (s *Server) handleGetChannelCalendars(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, name, url, lead_minutes, created_at, last_fetched_at, last_error,
+			(SELECT COUNT(*) FROM calendar_events e WHERE e.calendar_id = channel_calendars.id)
+		FROM channel_calendars
+		WHERE channel_id = ?
+		ORDER BY id`, channelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendars"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	calendars := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var events int
+		var name, feedURL, leads, createdAt, lastError string
+		var lastFetchedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &feedURL, &leads, &createdAt, &lastFetchedAt, &lastError, &events); err != nil {
+			continue
+		}
+		calendar := gin.H{
+			"id":              id,
+			"name":            name,
+			"url":             feedURL,
+			"lead_minutes":    parseLeadMinutes(leads),
+			"created_at":      createdAt,
+			"last_fetched_at": nil,
+			"last_error":      lastError,
+			"events":          events,
+		}
+		if lastFetchedAt.Valid {
+			calendar["last_fetched_at"] = lastFetchedAt.Time
+		}
+		calendars = append(calendars, calendar)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    calendars,
+	})
+}
+
+// handleCreateChannelCalendar subscribes a channel to an ICS feed. The
+// feed is fetched right away so a bad URL is reported to the caller.
+func (s *Server) handleCreateChannelCalendar(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	channel, err := s.lookupChannelForUser(userID, channelID)
+	if err != nil || channel.ChannelType == "voice" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req struct {
+		URL         string `json:"url" binding:"required"`
+		Name        string `json:"name"`
+		LeadMinutes []int  `json:"lead_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	feedURL, err := s.calendarURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.LeadMinutes == nil {
+		req.LeadMinutes = []int{60}
+	}
+	leads, err := normalizeLeadMinutes(req.LeadMinutes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM channel_calendars WHERE channel_id = ?", channelID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check calendars"})
+		return
+	}
+	if count >= maxCalendarsPerChannel {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A channel can subscribe to at most %d calendars", maxCalendarsPerChannel)})
+		return
+	}
+
+	calendar, err := s.fetchCalendar(c.Request.Context(), feedURL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to load calendar: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = strings.TrimSpace(calendar.Name)
+	}
+	if name == "" {
+		name = "Calendar"
+	}
+	name = truncateRunes(name, 32)
+
+	result, err := s.db.Exec(
+		"INSERT INTO channel_calendars (channel_id, name, url, lead_minutes, created_by, last_fetched_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		channelID, name, feedURL, formatLeadMinutes(leads), userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to calendar"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	if err := s.storeCalendarEvents(id, calendar, time.Now()); err != nil {
+		log.Printf("Failed to store events for calendar %d: %v", id, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":           id,
+			"name":         name,
+			"url":          feedURL,
+			"lead_minutes": leads,
+		},
+	})
+}
+
+// handleUpdateChannelCalendar changes a subscription's name or lead times
+func (s *Server) handleUpdateChannelCalendar(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req struct {
+		Name        *string `json:"name"`
+		LeadMinutes []int   `json:"lead_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var name, leads string
+	err = s.db.QueryRow(
+		"SELECT name, lead_minutes FROM channel_calendars WHERE id = ? AND channel_id = ?", c.Param("id"), channelID,
+	).Scan(&name, &leads)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	if req.Name != nil {
+		if name = truncateRunes(strings.TrimSpace(*req.Name), 32); name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+	}
+	if req.LeadMinutes != nil {
+		normalized, err := normalizeLeadMinutes(req.LeadMinutes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		leads = formatLeadMinutes(normalized)
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE channel_calendars SET name = ?, lead_minutes = ? WHERE id = ?", name, leads, c.Param("id"),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"name":         name,
+			"lead_minutes": parseLeadMinutes(leads),
+		},
+	})
+}
+
+// handleDeleteChannelCalendar unsubscribes a channel from a feed
+func (s *Server) handleDeleteChannelCalendar(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM channel_calendars WHERE id = ? AND channel_id = ?", c.Param("id"), channelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete calendar"})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+	for _, table := range []string{"calendar_events", "calendar_reminders"} {
+		if _, err := s.db.Exec("DELETE FROM "+table+" WHERE calendar_id = ?", c.Param("id")); err != nil {
+			log.Printf("Failed to clean up %s for calendar %s: %v", table, c.Param("id"), err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Calendar removed successfully",
+	})
+}
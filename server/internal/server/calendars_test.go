@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/fetch"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChannelCalendar(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	s.fetcher = fetch.New(fetch.Config{AllowPrivate: true})
+
+	soon := time.Now().UTC().Add(55 * time.Minute).Truncate(time.Minute)
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = fmt.Fprintf(w, "BEGIN:VCALENDAR\r\nX-WR-CALNAME:Community events\r\n"+
+			"BEGIN:VEVENT\r\nUID:raid\r\nSUMMARY:Raid night\r\nLOCATION:Voice\r\nDTSTART:%s\r\nDURATION:PT2H\r\nEND:VEVENT\r\n"+
+			"BEGIN:VEVENT\r\nUID:past\r\nSUMMARY:Old event\r\nDTSTART:20200101T100000Z\r\nEND:VEVENT\r\n"+
+			"END:VCALENDAR\r\n", soon.Format("20060102T150405Z"))
+	}))
+	defer feed.Close()
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("calowner_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	ownerID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("calmember_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	memberID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Calendar %d", suffix), ownerID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'events')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner'), (?, ?, 'member')", ownerID, serverID, memberID, serverID); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(userID int64, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(userID))
+		})
+		router.GET("/channels/:channelId/events", s.handleGetChannelEvents)
+		router.POST("/channels/:channelId/calendars", s.handleCreateChannelCalendar)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	calendarsPath := fmt.Sprintf("/channels/%d/calendars", channelID)
+	subscribe := fmt.Sprintf(`{"url":%q,"lead_minutes":[60,1440,60]}`, feed.URL)
+
+	if w := request(memberID, http.MethodPost, calendarsPath, subscribe); w.Code != http.StatusForbidden {
+		t.Errorf("Expected members to be refused, got %d", w.Code)
+	}
+	w := request(ownerID, http.MethodPost, calendarsPath, subscribe)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to subscribe: %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data struct {
+			Name        string `json:"name"`
+			LeadMinutes []int  `json:"lead_minutes"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Data.Name != "Community events" || len(created.Data.LeadMinutes) != 2 || created.Data.LeadMinutes[0] != 1440 {
+		t.Errorf("Unexpected subscription %+v", created.Data)
+	}
+
+	w = request(memberID, http.MethodGet, fmt.Sprintf("/channels/%d/events", channelID), "")
+	var events struct {
+		Data []struct {
+			Summary  string `json:"summary"`
+			Start    string `json:"start"`
+			Location string `json:"location"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &events)
+	if w.Code != http.StatusOK || len(events.Data) != 1 || events.Data[0].Summary != "Raid night" || events.Data[0].Start != soon.Format(time.RFC3339) {
+		t.Fatalf("Unexpected events: %d %s", w.Code, w.Body.String())
+	}
+
+	// The 60-minute reminder is due; the one-day reminder was due before
+	// the subscription existed and is skipped
+	s.sendCalendarReminders(time.Now())
+	s.sendCalendarReminders(time.Now())
+	var count int
+	var content, botName string
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE channel_id = ?", channelID).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected exactly one reminder, got %d (%v)", count, err)
+	}
+	if err := db.QueryRow("SELECT content, bot_name FROM messages WHERE channel_id = ?", channelID).Scan(&content, &botName); err != nil {
+		t.Fatalf("Failed to read reminder: %v", err)
+	}
+	if content != "Reminder: Raid night starts in 1 hour" || botName != "Community events" {
+		t.Errorf("Unexpected reminder %q by %q", content, botName)
+	}
+}
+
+func TestDescribeLead(t *testing.T) {
+	for minutes, want := range map[int]string{1: "1 minute", 15: "15 minutes", 60: "1 hour", 120: "2 hours", 90: "90 minutes", 1440: "1 day", 10080: "7 days"} {
+		if got := describeLead(minutes); got != want {
+			t.Errorf("describeLead(%d) = %q, want %q", minutes, got, want)
+		}
+	}
+}
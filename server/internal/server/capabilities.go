@@ -0,0 +1,355 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permissions, called capabilities when granted to an admin.
+// super_admins hold every permission implicitly and admins hold the ones
+// granted to them in admin_capabilities. Any other role, custom roles
+// included, holds the permissions mapped to it in
+// instance_role_permissions.
+const (
+	capManageUsers    = "manage_users"
+	capModerate       = "moderate_content"
+	capViewMetrics    = "view_metrics"
+	capManagePlugins  = "manage_plugins"
+	capManageSettings = "manage_settings"
+)
+
+var allCapabilities = []string{capManageUsers, capModerate, capViewMetrics, capManagePlugins, capManageSettings}
+
+func isValidCapability(capability string) bool {
+	for _, valid := range allCapabilities {
+		if capability == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// userCapabilities returns the role and effective permissions of a user.
+// Users of an organization only keep the permissions that apply inside it.
+func (s *Server) userCapabilities(userID interface{}) (string, []string, error) {
+	var role string
+	var orgID int
+	if err := s.db.QueryRow("SELECT role, org_id FROM users WHERE id = ?", userID).Scan(&role, &orgID); err != nil {
+		return "", nil, err
+	}
+
+	var rows *sql.Rows
+	var err error
+	switch role {
+	case "super_admin":
+		return role, append([]string(nil), allCapabilities...), nil
+	case "admin":
+		rows, err = s.db.Query("SELECT capability FROM admin_capabilities WHERE user_id = ? ORDER BY capability", userID)
+	default:
+		rows, err = s.db.Query("SELECT permission FROM instance_role_permissions WHERE role = ? ORDER BY permission", role)
+	}
+	if err != nil {
+		return role, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	capabilities := make([]string, 0)
+	for rows.Next() {
+		var capability string
+		if err := rows.Scan(&capability); err == nil && (orgID == 0 || orgCapabilities[capability]) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return role, capabilities, nil
+}
+
+// hasCapability reports whether a user holds a permission
+func (s *Server) hasCapability(userID int, capability string) bool {
+	_, capabilities, err := s.userCapabilities(userID)
+	if err != nil {
+		return false
+	}
+	for _, held := range capabilities {
+		if held == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission only lets through users whose role grants them the
+// given permission, and API keys only with the admin scope
+func (s *Server) requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !apiKeyAllows(c, scopeAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the admin scope"})
+			c.Abort()
+			return
+		}
+
+		_, permissions, err := s.userCapabilities(c.GetInt("user_id"))
+		if err != nil || len(permissions) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can access this endpoint"})
+			c.Abort()
+			return
+		}
+
+		for _, held := range permissions {
+			if held == permission {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Missing permission: %s", permission)})
+		c.Abort()
+	}
+}
+
+// superAdminMiddleware only lets through super_admins, and API keys only
+// with the admin scope
+func (s *Server) superAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !apiKeyAllows(c, scopeAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the admin scope"})
+			c.Abort()
+			return
+		}
+		if !s.isSuperAdmin(c.GetInt("user_id")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can access this endpoint"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// privilegedTargetMiddleware keeps routes acting on a user's account, such
+// as setting their password or resetting their 2FA, to super admins when
+// the user holds a role above user; otherwise an admin could take over a
+// super admin's account
+func (s *Server) privilegedTargetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role string
+		err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", c.Param("id")).Scan(&role)
+		if err == nil && role != "user" && role != "guest" && !s.isSuperAdmin(c.GetInt("user_id")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can manage admins and users with custom roles"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) isSuperAdmin(userID int) bool {
+	var role string
+	err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	return err == nil && role == "super_admin"
+}
+
+// setCapabilities replaces the capabilities granted to an admin
+func (s *Server) setCapabilities(userID interface{}, capabilities []string, grantedBy int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM admin_capabilities WHERE user_id = ?", userID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, capability := range capabilities {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO admin_capabilities (user_id, capability, granted_by) VALUES (?, ?, ?)",
+			userID, capability, grantedBy,
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// syncRoleCapabilities keeps capability grants consistent with a role
+// change: new admins get the admin role's permissions, non-admins lose
+// their grants.
+func (s *Server) syncRoleCapabilities(userID interface{}, role string, grantedBy int) {
+	if role == "admin" {
+		var count int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM admin_capabilities WHERE user_id = ?", userID).Scan(&count); err == nil && count > 0 {
+			return
+		}
+		defaults, err := s.rolePermissions("admin")
+		if err != nil {
+			log.Printf("Failed to load the admin role's permissions: %v", err)
+			return
+		}
+		if err := s.setCapabilities(userID, defaults, grantedBy); err != nil {
+			log.Printf("Failed to grant default capabilities to user %v: %v", userID, err)
+		}
+		return
+	}
+
+	if _, err := s.db.Exec("DELETE FROM admin_capabilities WHERE user_id = ?", userID); err != nil {
+		log.Printf("Failed to clear capabilities for user %v: %v", userID, err)
+	}
+}
+
+// migrateAdminCapabilities grants every capability to admins that predate
+// capability tracking, so existing admins keep their access after upgrade.
+func (s *Server) migrateAdminCapabilities() {
+	if migrated, err := s.db.GetSetting("admin_capabilities_migrated"); err == nil && migrated == "true" {
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id FROM users
+		WHERE role = 'admin' AND id NOT IN (SELECT user_id FROM admin_capabilities)`)
+	if err != nil {
+		log.Printf("Failed to load admins for capability migration: %v", err)
+		return
+	}
+
+	var adminIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			adminIDs = append(adminIDs, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	for _, id := range adminIDs {
+		if err := s.setCapabilities(id, allCapabilities, 0); err != nil {
+			log.Printf("Failed to migrate capabilities for admin %d: %v", id, err)
+			return
+		}
+	}
+
+	if err := s.db.SetSetting("admin_capabilities_migrated", "true", "Existing admins were granted all capabilities"); err != nil {
+		log.Printf("Failed to record capability migration: %v", err)
+	}
+}
+
+func (s *Server) handleGetCapabilities(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT id, username, role FROM users
+		WHERE role NOT IN ('user', 'guest')
+		ORDER BY username`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get admins"})
+		return
+	}
+
+	type adminRow struct {
+		ID       int
+		Username string
+		Role     string
+	}
+	var admins []adminRow
+	for rows.Next() {
+		var admin adminRow
+		if err := rows.Scan(&admin.ID, &admin.Username, &admin.Role); err == nil {
+			admins = append(admins, admin)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	data := make([]gin.H, 0, len(admins))
+	for _, admin := range admins {
+		_, capabilities, err := s.userCapabilities(admin.ID)
+		if err != nil {
+			continue
+		}
+		data = append(data, gin.H{
+			"id":           admin.ID,
+			"username":     admin.Username,
+			"role":         admin.Role,
+			"capabilities": capabilities,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"available": allCapabilities,
+			"admins":    data,
+		},
+	})
+}
+
+func (s *Server) handleGetUserCapabilities(c *gin.Context) {
+	userID := c.Param("id")
+
+	role, capabilities, err := s.userCapabilities(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"role":         role,
+			"capabilities": capabilities,
+		},
+	})
+}
+
+func (s *Server) handleUpdateUserCapabilities(c *gin.Context) {
+	userID := c.Param("id")
+	var req struct {
+		Capabilities []string `json:"capabilities"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, capability := range req.Capabilities {
+		if !isValidCapability(capability) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid capability: %s", capability)})
+			return
+		}
+	}
+
+	var role string
+	if err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if role != "admin" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Capabilities can only be assigned to admins"})
+		return
+	}
+
+	if err := s.setCapabilities(userID, req.Capabilities, c.GetInt("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update capabilities"})
+		return
+	}
+
+	granted := append([]string(nil), req.Capabilities...)
+	sort.Strings(granted)
+	s.logAdminAction(c.GetInt("user_id"), "update_capabilities", fmt.Sprintf("Set capabilities of user ID %s to [%s]", userID, strings.Join(granted, ", ")))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Capabilities updated successfully",
+	})
+}
@@ -0,0 +1,824 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/service"
+	"fethur/internal/snowflake"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Moderation cases. Owners and admins group the warnings, mutes,
+// quarantines, kicks and bans they hand a user into cases numbered per
+// server, with notes and links to the messages that prompted them. Kicks
+// and bans remove the user from the server, and active bans keep them out;
+// active mutes keep them from posting. An active quarantine is a shadow
+// mute: the user's new messages reach only them and the moderators, and
+// the user is not told. The user can appeal a case once, and an accepted
+// appeal lifts its mutes, quarantines and bans. Every action and decision
+// writes an audit log entry linked to the case.
+
+const (
+	maxCaseReasonLength    = 500
+	maxCaseNoteLength      = 2000
+	maxCaseEvidence        = 20
+	maxCaseDurationMinutes = 525600
+	maxCasePageSize        = 100
+)
+
+var (
+	caseActions        = map[string]bool{"warn": true, "mute": true, "quarantine": true, "kick": true, "ban": true}
+	caseStatuses       = map[string]bool{"open": true, "closed": true}
+	caseAppealStatuses = map[string]bool{"none": true, "pending": true, "accepted": true, "rejected": true}
+	memberRankOrder    = map[string]int{"member": 0, "admin": 1, "owner": 2}
+)
+
+// caseAction is one moderation action of a case
+type caseAction struct {
+	ID          int64      `json:"id"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason"`
+	ModeratorID int        `json:"moderator_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LiftedAt    *time.Time `json:"lifted_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// caseActionRequest is the body of a new moderation action
+type caseActionRequest struct {
+	Action             string         `json:"action" binding:"required"`
+	Reason             string         `json:"reason"`
+	DurationMinutes    int            `json:"duration_minutes"` // mutes, quarantines and bans only; 0 is permanent
+	EvidenceMessageIDs []snowflake.ID `json:"evidence_message_ids"`
+}
+
+// serverSanctioned reports whether a user has an active mute, quarantine
+// or ban in a server
+func (s *Server) serverSanctioned(serverID, userID int, action string) bool {
+	return s.services.Moderation.ServerSanctioned(serverID, userID, action)
+}
+
+// validateCaseAction checks a moderator may take an action against a user,
+// returning the status and message to refuse it with. Only bans reach users
+// who are not members.
+func (s *Server) validateCaseAction(serverID, moderatorID, userID int, req caseActionRequest) (int, string) {
+	if !caseActions[req.Action] {
+		return http.StatusBadRequest, "action must be warn, mute, quarantine, kick or ban"
+	}
+	if len(req.Reason) > maxCaseReasonLength {
+		return http.StatusBadRequest, fmt.Sprintf("reason must be at most %d characters", maxCaseReasonLength)
+	}
+	if req.DurationMinutes != 0 && (req.Action == "warn" || req.Action == "kick") {
+		return http.StatusBadRequest, "Only mutes, quarantines and bans have a duration"
+	}
+	if req.DurationMinutes < 0 || req.DurationMinutes > maxCaseDurationMinutes {
+		return http.StatusBadRequest, fmt.Sprintf("duration_minutes must be between 0 and %d", maxCaseDurationMinutes)
+	}
+	if len(req.EvidenceMessageIDs) > maxCaseEvidence {
+		return http.StatusBadRequest, fmt.Sprintf("A case can link at most %d messages", maxCaseEvidence)
+	}
+	if userID == moderatorID {
+		return http.StatusBadRequest, "You cannot moderate yourself"
+	}
+
+	moderatorRole, _ := s.serverRole(moderatorID, serverID)
+	userRole, member := s.serverRole(userID, serverID)
+	if member && memberRankOrder[userRole] >= memberRankOrder[moderatorRole] {
+		return http.StatusForbidden, "You can only moderate members of a lower rank"
+	}
+	if !member {
+		var orgID int
+		err := s.db.QueryRow("SELECT org_id FROM users WHERE id = ?", userID).Scan(&orgID)
+		if err != nil || orgID != s.serverOrgID(serverID) || req.Action != "ban" {
+			return http.StatusNotFound, "Member not found"
+		}
+	}
+	for _, messageID := range req.EvidenceMessageIDs {
+		if !s.serverMessageExists(serverID, int64(messageID)) {
+			return http.StatusBadRequest, fmt.Sprintf("Message %d is not in this server", messageID)
+		}
+	}
+	return 0, ""
+}
+
+// serverMessageExists reports whether a message was posted in a server
+func (s *Server) serverMessageExists(serverID int, messageID int64) bool {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM messages m JOIN channels ch ON ch.id = m.channel_id
+			WHERE m.id = ? AND ch.server_id = ?
+		)`, messageID, serverID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// logCaseAction writes an audit log entry and links it to a case
+func (s *Server) logCaseAction(caseID int64, adminID int, action, details string) {
+	auditLogID := s.logAdminAction(adminID, action, details)
+	if auditLogID == 0 {
+		return
+	}
+	if _, err := s.db.Exec(
+		"INSERT INTO moderation_case_audit_logs (case_id, audit_log_id) VALUES (?, ?)", caseID, auditLogID,
+	); err != nil {
+		log.Printf("Failed to link audit log %d to case %d: %v", auditLogID, caseID, err)
+	}
+}
+
+// takeCaseAction records a validated action on a case and carries it out:
+// kicks and bans remove the user from the server. The case reopens if it
+// was closed, and the user hears about every action but a quarantine.
+func (s *Server) takeCaseAction(serverID int, caseID int64, number, userID, moderatorID int, req caseActionRequest) (caseAction, error) {
+	action := caseAction{Action: req.Action, Reason: req.Reason, ModeratorID: moderatorID, CreatedAt: time.Now().UTC()}
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	if duration > 0 {
+		expires := action.CreatedAt.Add(duration)
+		action.ExpiresAt = &expires
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO moderation_case_actions (case_id, action, reason, moderator_id, expires_at) VALUES (?, ?, ?, ?, ?)",
+		caseID, req.Action, req.Reason, moderatorID, service.ModerationExpiry(duration),
+	)
+	if err != nil {
+		return caseAction{}, err
+	}
+	action.ID, _ = result.LastInsertId()
+	if _, err := s.db.Exec(
+		"UPDATE moderation_cases SET status = 'open', updated_at = CURRENT_TIMESTAMP WHERE id = ?", caseID,
+	); err != nil {
+		return caseAction{}, err
+	}
+	for _, messageID := range req.EvidenceMessageIDs {
+		if _, err := s.db.Exec(
+			"INSERT OR IGNORE INTO moderation_case_evidence (case_id, message_id, added_by) VALUES (?, ?, ?)",
+			caseID, int64(messageID), moderatorID,
+		); err != nil {
+			return caseAction{}, err
+		}
+	}
+
+	if req.Action == "kick" || req.Action == "ban" {
+		if err := s.removeServerMember(serverID, userID); err != nil {
+			return caseAction{}, err
+		}
+	}
+	if req.Action == "ban" {
+		if _, err := s.db.Exec(
+			"UPDATE server_join_requests SET status = 'rejected', decided_by = ?, decided_at = CURRENT_TIMESTAMP WHERE server_id = ? AND user_id = ? AND status = 'pending'",
+			moderatorID, serverID, userID,
+		); err != nil {
+			log.Printf("Failed to reject join requests of banned user %d: %v", userID, err)
+		}
+	}
+
+	details := fmt.Sprintf("Server %d case #%d: %s user %d", serverID, number, req.Action, userID)
+	if req.DurationMinutes > 0 {
+		details += fmt.Sprintf(" for %d minutes", req.DurationMinutes)
+	}
+	s.logCaseAction(caseID, moderatorID, "case_"+req.Action, details+". Reason: "+req.Reason)
+
+	if req.Action == "quarantine" {
+		return action, nil
+	}
+	s.clientsMux.RLock()
+	if client, ok := s.clients[userID]; ok {
+		client.Send(&websocket.Message{
+			Type:      string(events.TypeModeration),
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"server_id":   serverID,
+				"case_number": number,
+				"action":      req.Action,
+				"reason":      req.Reason,
+				"expires_at":  action.ExpiresAt,
+			},
+		})
+	}
+	s.clientsMux.RUnlock()
+	return action, nil
+}
+
+// caseByNumber parses the :number case number of a server and returns the
+// case's ID and user
+func (s *Server) caseByNumber(c *gin.Context, serverID int) (caseID int64, number, userID int, ok bool) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid case number"})
+		return 0, 0, 0, false
+	}
+	err = s.db.QueryRow(
+		"SELECT id, user_id FROM moderation_cases WHERE server_id = ? AND number = ?", serverID, number,
+	).Scan(&caseID, &userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Case not found"})
+		return 0, 0, 0, false
+	}
+	return caseID, number, userID, true
+}
+
+// handleCreateCase opens a case against a user with its first action
+func (s *Server) handleCreateCase(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		UserID int `json:"user_id" binding:"required"`
+		caseActionRequest
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	moderatorID := c.GetInt("user_id")
+	if status, message := s.validateCaseAction(serverID, moderatorID, req.UserID, req.caseActionRequest); status != 0 {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	// Numbering in the insert keeps concurrent cases from sharing a number
+	result, err := s.db.Exec(`
+		INSERT INTO moderation_cases (server_id, number, user_id, moderator_id)
+		SELECT ?, COALESCE(MAX(number), 0) + 1, ?, ? FROM moderation_cases WHERE server_id = ?`,
+		serverID, req.UserID, moderatorID, serverID,
+	)
+	if err != nil {
+		log.Printf("Failed to open case in server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open case"})
+		return
+	}
+	caseID, _ := result.LastInsertId()
+	var number int
+	if err := s.db.QueryRow("SELECT number FROM moderation_cases WHERE id = ?", caseID).Scan(&number); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open case"})
+		return
+	}
+	action, err := s.takeCaseAction(serverID, caseID, number, req.UserID, moderatorID, req.caseActionRequest)
+	if err != nil {
+		log.Printf("Failed to take action on case %d: %v", caseID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take action"})
+		return
+	}
+	s.markWrite(moderatorID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"number":  number,
+			"user_id": req.UserID,
+			"status":  "open",
+			"actions": []caseAction{action},
+		},
+	})
+}
+
+// handleAddCaseAction adds a follow-up action to a case, such as a ban
+// after earlier warnings
+func (s *Server) handleAddCaseAction(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, number, userID, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	var req caseActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	moderatorID := c.GetInt("user_id")
+	if status, message := s.validateCaseAction(serverID, moderatorID, userID, req); status != 0 {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	action, err := s.takeCaseAction(serverID, caseID, number, userID, moderatorID, req)
+	if err != nil {
+		log.Printf("Failed to take action on case %d: %v", caseID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take action"})
+		return
+	}
+	s.markWrite(moderatorID)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": action})
+}
+
+// handleGetCases lists a server's cases, newest first. Cases can be
+// filtered by user, moderator, action, status and appeal status.
+func (s *Server) handleGetCases(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxCasePageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxCasePageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	conditions := []string{"mc.server_id = ?"}
+	args := []interface{}{serverID}
+	for _, filter := range []string{"user_id", "moderator_id"} {
+		if value := c.Query(filter); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + filter})
+				return
+			}
+			conditions = append(conditions, "mc."+filter+" = ?")
+			args = append(args, id)
+		}
+	}
+	if action := c.Query("action"); action != "" {
+		if !caseActions[action] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be warn, mute, quarantine, kick or ban"})
+			return
+		}
+		conditions = append(conditions, "EXISTS(SELECT 1 FROM moderation_case_actions a WHERE a.case_id = mc.id AND a.action = ?)")
+		args = append(args, action)
+	}
+	if status := c.Query("status"); status != "" {
+		if !caseStatuses[status] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open or closed"})
+			return
+		}
+		conditions = append(conditions, "mc.status = ?")
+		args = append(args, status)
+	}
+	if appeal := c.Query("appeal_status"); appeal != "" {
+		if !caseAppealStatuses[appeal] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "appeal_status must be none, pending, accepted or rejected"})
+			return
+		}
+		conditions = append(conditions, "mc.appeal_status = ?")
+		args = append(args, appeal)
+	}
+
+	rows, err := s.reader(c).Query(`
+		SELECT mc.number, mc.user_id, COALESCE(u.username, ''), mc.moderator_id, mc.status, mc.appeal_status,
+			(SELECT COUNT(*) FROM moderation_case_actions a WHERE a.case_id = mc.id),
+			COALESCE((SELECT a.action FROM moderation_case_actions a WHERE a.case_id = mc.id ORDER BY a.id DESC LIMIT 1), ''),
+			mc.created_at, mc.updated_at
+		FROM moderation_cases mc LEFT JOIN users u ON u.id = mc.user_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY mc.number DESC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...,
+	)
+	if err != nil {
+		log.Printf("Failed to get cases of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cases"})
+		return
+	}
+	defer rows.Close()
+
+	cases := make([]gin.H, 0)
+	for rows.Next() {
+		var number, userID, moderatorID, actionCount int
+		var username, status, appealStatus, lastAction string
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&number, &userID, &username, &moderatorID, &status, &appealStatus,
+			&actionCount, &lastAction, &createdAt, &updatedAt); err != nil {
+			continue
+		}
+		cases = append(cases, gin.H{
+			"number":        number,
+			"user_id":       userID,
+			"username":      username,
+			"moderator_id":  moderatorID,
+			"status":        status,
+			"appeal_status": appealStatus,
+			"action_count":  actionCount,
+			"last_action":   lastAction,
+			"created_at":    createdAt,
+			"updated_at":    updatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": cases})
+}
+
+// handleGetCase shows a case with its actions, notes, evidence and audit
+// log entries
+func (s *Server) handleGetCase(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, number, userID, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	reader := s.reader(c)
+
+	var moderatorID int
+	var status, appealStatus string
+	var appealReason sql.NullString
+	var appealDecidedBy sql.NullInt64
+	var appealDecidedAt sql.NullTime
+	var createdAt, updatedAt time.Time
+	if err := reader.QueryRow(`
+		SELECT moderator_id, status, appeal_status, appeal_reason, appeal_decided_by, appeal_decided_at, created_at, updated_at
+		FROM moderation_cases WHERE id = ?`, caseID,
+	).Scan(&moderatorID, &status, &appealStatus, &appealReason, &appealDecidedBy, &appealDecidedAt, &createdAt, &updatedAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get case"})
+		return
+	}
+	appeal := gin.H{"status": appealStatus, "reason": nil, "decided_by": nullIntPtr(appealDecidedBy), "decided_at": nil}
+	if appealReason.Valid {
+		appeal["reason"] = appealReason.String
+	}
+	if appealDecidedAt.Valid {
+		appeal["decided_at"] = appealDecidedAt.Time
+	}
+
+	actions := make([]caseAction, 0)
+	rows, err := reader.Query(
+		"SELECT id, action, reason, moderator_id, expires_at, lifted_at, created_at FROM moderation_case_actions WHERE case_id = ? ORDER BY id", caseID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get case"})
+		return
+	}
+	for rows.Next() {
+		var action caseAction
+		var expiresAt, liftedAt sql.NullTime
+		if err := rows.Scan(&action.ID, &action.Action, &action.Reason, &action.ModeratorID, &expiresAt, &liftedAt, &action.CreatedAt); err != nil {
+			continue
+		}
+		if expiresAt.Valid {
+			action.ExpiresAt = &expiresAt.Time
+		}
+		if liftedAt.Valid {
+			action.LiftedAt = &liftedAt.Time
+		}
+		actions = append(actions, action)
+	}
+	_ = rows.Close()
+
+	notes := make([]gin.H, 0)
+	rows, err = reader.Query("SELECT id, author_id, body, created_at FROM moderation_case_notes WHERE case_id = ? ORDER BY id", caseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get case"})
+		return
+	}
+	for rows.Next() {
+		var id, authorID int
+		var body string
+		var noteCreatedAt time.Time
+		if err := rows.Scan(&id, &authorID, &body, &noteCreatedAt); err == nil {
+			notes = append(notes, gin.H{"id": id, "author_id": authorID, "body": body, "created_at": noteCreatedAt})
+		}
+	}
+	_ = rows.Close()
+
+	// Linked messages stay listed after they are deleted, without content
+	evidence := make([]gin.H, 0)
+	rows, err = reader.Query(`
+		SELECT e.message_id, e.added_by, m.channel_id, m.user_id, m.content, m.created_at
+		FROM moderation_case_evidence e LEFT JOIN messages m ON m.id = e.message_id
+		WHERE e.case_id = ? ORDER BY e.created_at, e.message_id`, caseID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get case"})
+		return
+	}
+	for rows.Next() {
+		var messageID int64
+		var addedBy int
+		var channelID, authorID sql.NullInt64
+		var content sql.NullString
+		var postedAt sql.NullTime
+		if err := rows.Scan(&messageID, &addedBy, &channelID, &authorID, &content, &postedAt); err != nil {
+			continue
+		}
+		entry := gin.H{"message_id": snowflake.ID(messageID), "added_by": addedBy, "deleted": !channelID.Valid}
+		if channelID.Valid {
+			entry["channel_id"] = channelID.Int64
+			entry["author_id"] = authorID.Int64
+			entry["content"] = content.String
+			entry["created_at"] = postedAt.Time
+		}
+		evidence = append(evidence, entry)
+	}
+	_ = rows.Close()
+
+	auditLogs := make([]gin.H, 0)
+	rows, err = reader.Query(`
+		SELECT al.id, al.admin_id, al.action, al.details, al.created_at
+		FROM moderation_case_audit_logs l JOIN audit_logs al ON al.id = l.audit_log_id
+		WHERE l.case_id = ? ORDER BY al.id`, caseID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get case"})
+		return
+	}
+	for rows.Next() {
+		var id, adminID int
+		var action, details string
+		var loggedAt time.Time
+		if err := rows.Scan(&id, &adminID, &action, &details, &loggedAt); err == nil {
+			auditLogs = append(auditLogs, gin.H{"id": id, "admin_id": adminID, "action": action, "details": details, "created_at": loggedAt})
+		}
+	}
+	_ = rows.Close()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"number":       number,
+			"user_id":      userID,
+			"moderator_id": moderatorID,
+			"status":       status,
+			"appeal":       appeal,
+			"actions":      actions,
+			"notes":        notes,
+			"evidence":     evidence,
+			"audit_logs":   auditLogs,
+			"created_at":   createdAt,
+			"updated_at":   updatedAt,
+		},
+	})
+}
+
+// handleUpdateCase closes or reopens a case. Closing a case leaves its
+// mutes, quarantines and bans in place.
+func (s *Server) handleUpdateCase(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, number, _, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !caseStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open or closed"})
+		return
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE moderation_cases SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status != ?", req.Status, caseID, req.Status,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update case"})
+		return
+	}
+	moderatorID := c.GetInt("user_id")
+	if changed, _ := result.RowsAffected(); changed > 0 {
+		action := "case_closed"
+		if req.Status == "open" {
+			action = "case_reopened"
+		}
+		s.logCaseAction(caseID, moderatorID, action, fmt.Sprintf("Server %d case #%d: %s", serverID, number, req.Status))
+	}
+	s.markWrite(moderatorID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"number": number, "status": req.Status}})
+}
+
+// handleAddCaseNote adds a moderator's note to a case
+func (s *Server) handleAddCaseNote(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, _, _, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	var req struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" || len(req.Body) > maxCaseNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("body must be 1-%d characters", maxCaseNoteLength)})
+		return
+	}
+
+	authorID := c.GetInt("user_id")
+	result, err := s.db.Exec("INSERT INTO moderation_case_notes (case_id, author_id, body) VALUES (?, ?, ?)", caseID, authorID, req.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add note"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	_, _ = s.db.Exec("UPDATE moderation_cases SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", caseID)
+	s.markWrite(authorID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    gin.H{"id": id, "author_id": authorID, "body": req.Body},
+	})
+}
+
+// handleAddCaseEvidence links a message of the server to a case
+func (s *Server) handleAddCaseEvidence(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, _, _, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	var req struct {
+		MessageID snowflake.ID `json:"message_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.serverMessageExists(serverID, int64(req.MessageID)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Message %d is not in this server", req.MessageID)})
+		return
+	}
+	var linked int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM moderation_case_evidence WHERE case_id = ?", caseID).Scan(&linked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add evidence"})
+		return
+	}
+	if linked >= maxCaseEvidence {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A case can link at most %d messages", maxCaseEvidence)})
+		return
+	}
+
+	moderatorID := c.GetInt("user_id")
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO moderation_case_evidence (case_id, message_id, added_by) VALUES (?, ?, ?)", caseID, int64(req.MessageID), moderatorID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add evidence"})
+		return
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message is already linked to this case"})
+		return
+	}
+	_, _ = s.db.Exec("UPDATE moderation_cases SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", caseID)
+	s.markWrite(moderatorID)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": gin.H{"message_id": req.MessageID}})
+}
+
+// handleAppealCase files the user's appeal of their case. Kicked and banned
+// users are no longer members, so only the case's user is checked. A case
+// can be appealed once.
+func (s *Server) handleAppealCase(c *gin.Context) {
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxCaseNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be 1-%d characters", maxCaseNoteLength)})
+		return
+	}
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid case number"})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	var caseID int64
+	var appealStatus string
+	err = s.db.QueryRow(
+		"SELECT id, appeal_status FROM moderation_cases WHERE server_id = ? AND number = ? AND user_id = ?", serverID, number, userID,
+	).Scan(&caseID, &appealStatus)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Case not found"})
+		return
+	}
+	if appealStatus != "none" {
+		c.JSON(http.StatusConflict, gin.H{"error": "This case has already been appealed"})
+		return
+	}
+	if _, err := s.db.Exec(
+		"UPDATE moderation_cases SET appeal_status = 'pending', appeal_reason = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", req.Reason, caseID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to appeal case"})
+		return
+	}
+	s.markWrite(userID)
+	s.notifyServerModerators(serverID, &websocket.Message{
+		Type:      string(events.TypeCaseAppeal),
+		Timestamp: time.Now(),
+		Data:      gin.H{"server_id": serverID, "case_number": number, "user_id": userID},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    gin.H{"number": number, "appeal_status": "pending"},
+	})
+}
+
+// handleDecideCaseAppeal accepts or rejects a pending appeal. Accepting it
+// lifts the case's mutes, quarantines and bans and closes the case; the
+// user hears the decision either way.
+func (s *Server) handleDecideCaseAppeal(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	caseID, number, userID, ok := s.caseByNumber(c, serverID)
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != "accepted" && req.Status != "rejected" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be accepted or rejected"})
+		return
+	}
+
+	moderatorID := c.GetInt("user_id")
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide appeal"})
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	result, err := tx.Exec(`
+		UPDATE moderation_cases SET appeal_status = ?, appeal_decided_by = ?, appeal_decided_at = CURRENT_TIMESTAMP,
+			status = CASE WHEN ? = 'accepted' THEN 'closed' ELSE status END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND appeal_status = 'pending'`,
+		req.Status, moderatorID, req.Status, caseID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide appeal"})
+		return
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This case has no pending appeal"})
+		return
+	}
+	if req.Status == "accepted" {
+		if _, err := tx.Exec(
+			"UPDATE moderation_case_actions SET lifted_at = CURRENT_TIMESTAMP WHERE case_id = ? AND action IN ('mute', 'quarantine', 'ban') AND lifted_at IS NULL",
+			caseID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide appeal"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide appeal"})
+		return
+	}
+	s.logCaseAction(caseID, moderatorID, "case_appeal_"+req.Status,
+		fmt.Sprintf("Server %d case #%d: appeal of user %d %s", serverID, number, userID, req.Status))
+	s.markWrite(moderatorID)
+
+	decision := gin.H{"server_id": serverID, "case_number": number, "appeal_status": req.Status}
+	s.clientsMux.RLock()
+	if client, ok := s.clients[userID]; ok {
+		client.Send(&websocket.Message{Type: string(events.TypeAppealDecided), Timestamp: time.Now(), Data: decision})
+	}
+	s.clientsMux.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": decision})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestModerationCases(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "admin", "troll", "member"} {
+		users[name] = createTestUser(t, db, fmt.Sprintf("case%s_%d", name, suffix))
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Cases %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	for name, rank := range map[string]string{"owner": "owner", "admin": "admin", "troll": "member", "member": "member"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'general')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'rude')", channelID, users["troll"])
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	messageID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+		c.Set("org_id", 0)
+	})
+	router.GET("/servers/:id/cases", s.handleGetCases)
+	router.POST("/servers/:id/cases", s.handleCreateCase)
+	router.GET("/servers/:id/cases/:number", s.handleGetCase)
+	router.PATCH("/servers/:id/cases/:number", s.handleUpdateCase)
+	router.POST("/servers/:id/cases/:number/actions", s.handleAddCaseAction)
+	router.POST("/servers/:id/cases/:number/notes", s.handleAddCaseNote)
+	router.POST("/servers/:id/cases/:number/appeal", s.handleAppealCase)
+	router.PUT("/servers/:id/cases/:number/appeal", s.handleDecideCaseAppeal)
+	router.POST("/servers/:id/members", s.handleAddServerMember)
+	router.POST("/channels/:channelId/messages", s.handleSendMessage)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	casesPath := fmt.Sprintf("/servers/%d/cases", serverID)
+	casePath := casesPath + "/1"
+
+	if w := request("POST", casesPath, "admin", fmt.Sprintf(`{"user_id":%d,"action":"warn"}`, users["owner"])); w.Code != http.StatusForbidden {
+		t.Errorf("Expected admins not to moderate owners, got %d", w.Code)
+	}
+	if w := request("POST", casesPath, "member", fmt.Sprintf(`{"user_id":%d,"action":"warn"}`, users["troll"])); w.Code != http.StatusForbidden {
+		t.Errorf("Expected members not to open cases, got %d", w.Code)
+	}
+	if w := request("POST", casesPath, "admin", fmt.Sprintf(`{"user_id":%d,"action":"kick","duration_minutes":5}`, users["troll"])); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected kicks not to take a duration, got %d", w.Code)
+	}
+	w := request("POST", casesPath, "admin", fmt.Sprintf(`{"user_id":%d,"action":"warn","reason":"Be nice","evidence_message_ids":[%d]}`, users["troll"], messageID))
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"number":1`) {
+		t.Fatalf("Expected case #1 to open, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", casesPath, "owner", fmt.Sprintf(`{"user_id":%d,"action":"warn"}`, users["member"])); !strings.Contains(w.Body.String(), `"number":2`) {
+		t.Errorf("Expected the next case to be #2, got %s", w.Body.String())
+	}
+
+	// A mute on the same case keeps the user from posting
+	if w := request("POST", casePath+"/actions", "admin", `{"action":"mute","reason":"Again","duration_minutes":60}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the mute to be added, got %d: %s", w.Code, w.Body.String())
+	}
+	w = request("POST", fmt.Sprintf("/channels/%d/messages", channelID), "troll", `{"content":"still rude"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"muted"`) {
+		t.Errorf("Expected the muted member not to post, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var list struct {
+		Data []struct {
+			Number     int    `json:"number"`
+			LastAction string `json:"last_action"`
+		} `json:"data"`
+	}
+	w = request("GET", casesPath+"?action=mute", "admin", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Number != 1 || list.Data[0].LastAction != "mute" {
+		t.Errorf("Expected the action filter to find case #1, got %s", w.Body.String())
+	}
+	w = request("GET", fmt.Sprintf("%s?user_id=%d", casesPath, users["member"]), "admin", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Number != 2 {
+		t.Errorf("Expected the user filter to find case #2, got %s", w.Body.String())
+	}
+	if w := request("GET", casesPath+"?appeal_status=maybe", "admin", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown appeal status to be refused, got %d", w.Code)
+	}
+
+	// The ban removes the member and keeps them out
+	if w := request("POST", casePath+"/actions", "admin", `{"action":"ban","reason":"Enough"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the ban to be added, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, member := s.serverRole(users["troll"], serverID); member {
+		t.Error("Expected the banned user to be removed")
+	}
+	addPath := fmt.Sprintf("/servers/%d/members", serverID)
+	if w := request("POST", addPath, "admin", fmt.Sprintf(`{"user_id":%d}`, users["troll"])); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the banned user not to be added back, got %d", w.Code)
+	}
+	request("POST", casePath+"/notes", "owner", `{"body":"Discussed in the mod channel"}`)
+
+	var detail struct {
+		Data struct {
+			Actions   []caseAction             `json:"actions"`
+			Notes     []map[string]interface{} `json:"notes"`
+			Evidence  []map[string]interface{} `json:"evidence"`
+			AuditLogs []struct {
+				Action string `json:"action"`
+			} `json:"audit_logs"`
+		} `json:"data"`
+	}
+	w = request("GET", casePath, "admin", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &detail)
+	if len(detail.Data.Actions) != 3 || len(detail.Data.Notes) != 1 || len(detail.Data.Evidence) != 1 {
+		t.Errorf("Expected three actions, a note and the evidence, got %s", w.Body.String())
+	}
+	if len(detail.Data.AuditLogs) != 3 || detail.Data.AuditLogs[2].Action != "case_ban" {
+		t.Errorf("Expected the case's audit log entries, got %+v", detail.Data.AuditLogs)
+	}
+
+	// Only the case's user appeals, once; accepting lifts the ban
+	if w := request("POST", casePath+"/appeal", "member", `{"reason":"Not me"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected others not to appeal the case, got %d", w.Code)
+	}
+	if w := request("POST", casePath+"/appeal", "troll", `{"reason":"Sorry"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the appeal to be filed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", casePath+"/appeal", "troll", `{"reason":"Please"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected a second appeal to conflict, got %d", w.Code)
+	}
+	if w := request("PUT", casePath+"/appeal", "admin", `{"status":"accepted"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the appeal to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if s.serverSanctioned(serverID, users["troll"], "ban") || s.serverSanctioned(serverID, users["troll"], "mute") {
+		t.Error("Expected the accepted appeal to lift the ban and mute")
+	}
+	if w := request("GET", casesPath+"?status=closed&appeal_status=accepted", "admin", ""); !strings.Contains(w.Body.String(), `"number":1`) {
+		t.Errorf("Expected the case to be closed, got %s", w.Body.String())
+	}
+	if w := request("POST", addPath, "admin", fmt.Sprintf(`{"user_id":%d}`, users["troll"])); w.Code != http.StatusCreated {
+		t.Errorf("Expected the user to be added back, got %d: %s", w.Code, w.Body.String())
+	}
+}
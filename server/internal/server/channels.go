@@ -0,0 +1,128 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"fethur/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errChannelNotFound is returned when a channel does not exist or the user is not a member of its server
+var errChannelNotFound = errors.New("channel not found")
+
+// channelInfo is the subset of a channel row needed for access checks
+type channelInfo struct {
+	ID          int
+	ServerID    int
+	ChannelType string
+}
+
+// channelVisibleSQL limits channels c to those the server member sm can
+// see; the stores own the rule
+const channelVisibleSQL = store.ChannelVisibleSQL
+
+// lookupChannelForUser loads a channel the user can access through server membership.
+// Both text and voice channel IDs live in the same channels table.
+func (s *Server) lookupChannelForUser(userID, channelID int) (*channelInfo, error) {
+	var info channelInfo
+	err := s.db.QueryRow(`
+		SELECT c.id, c.server_id, c.channel_type
+		FROM channels c
+		JOIN server_members sm ON c.server_id = sm.server_id
+		WHERE c.id = ? AND sm.user_id = ? AND `+channelVisibleSQL,
+		channelID, userID).Scan(&info.ID, &info.ServerID, &info.ChannelType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errChannelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up channel: %w", err)
+	}
+	return &info, nil
+}
+
+// serverRole returns the user's role in a server; ok is false for
+// non-members
+func (s *Server) serverRole(userID, serverID int) (role string, ok bool) {
+	return s.services.Servers.Role(userID, serverID)
+}
+
+// memberServerID parses the :id server ID and checks the user is a
+// member, returning their role
+func (s *Server) memberServerID(c *gin.Context) (serverID int, role string, ok bool) {
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return 0, "", false
+	}
+	role, member := s.serverRole(c.GetInt("user_id"), serverID)
+	if !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return 0, "", false
+	}
+	return serverID, role, true
+}
+
+// deleteChannel removes a channel with its messages and everything
+// attached to it. Foreign keys are not enforced, so dependent rows are
+// deleted here; uploaded files stay with their uploaders.
+func (s *Server) deleteChannel(channelID int) error {
+	cleanup := []string{
+		"DELETE FROM offline_events WHERE channel_id = ?",
+		"DELETE FROM message_reactions WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM reaction_roles WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM starboard_entries WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM starboard_entries WHERE starboard_message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM message_idempotency WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM message_revisions WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"UPDATE attachments SET message_id = NULL WHERE message_id IN (SELECT id FROM messages WHERE channel_id = ?)",
+		"DELETE FROM messages WHERE channel_id = ?",
+		"DELETE FROM automation_hooks WHERE channel_id = ?",
+		"DELETE FROM incoming_webhooks WHERE channel_id = ?",
+		"DELETE FROM calendar_events WHERE calendar_id IN (SELECT id FROM channel_calendars WHERE channel_id = ?)",
+		"DELETE FROM calendar_reminders WHERE calendar_id IN (SELECT id FROM channel_calendars WHERE channel_id = ?)",
+		"DELETE FROM channel_calendars WHERE channel_id = ?",
+		"UPDATE server_events SET voice_channel_id = NULL WHERE voice_channel_id = ?",
+		"UPDATE ticket_settings SET transcript_channel_id = NULL WHERE transcript_channel_id = ?",
+		"DELETE FROM starboard_settings WHERE channel_id = ?",
+		"DELETE FROM channel_members WHERE channel_id = ?",
+		"DELETE FROM channel_integrations WHERE channel_id = ?",
+		"UPDATE channels SET generator_id = NULL WHERE generator_id = ?",
+		"DELETE FROM channels WHERE id = ?",
+	}
+	for _, query := range cleanup {
+		if _, err := s.db.Exec(query, channelID); err != nil {
+			return fmt.Errorf("failed to delete channel %d: %w", channelID, err)
+		}
+	}
+	return nil
+}
+
+// canManageChannel reports whether the user is an owner or admin of the
+// channel's server
+func (s *Server) canManageChannel(userID, channelID int) bool {
+	var role string
+	err := s.db.QueryRow(`
+		SELECT sm.role
+		FROM channels c
+		JOIN server_members sm ON c.server_id = sm.server_id
+		WHERE c.id = ? AND sm.user_id = ?
+	`, channelID, userID).Scan(&role)
+	return err == nil && (role == "owner" || role == "admin")
+}
+
+func (s *Server) handleGetSubscriptions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"channels":  s.hub.Subscriptions(userID, c.GetString("device_id")),
+			"connected": s.hub.IsConnected(userID),
+		},
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fethur/internal/snowflake"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// commandTimeout is how long a command endpoint has to answer
+const commandTimeout = 5 * time.Second
+
+// maxCommandReply bounds the text posted back from a command endpoint
+const maxCommandReply = 4000
+
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// commandPayload is posted to a command endpoint
+type commandPayload struct {
+	Command   string `json:"command"`
+	Text      string `json:"text"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	ChannelID int    `json:"channel_id"`
+	ServerID  int    `json:"server_id"`
+	Timestamp string `json:"timestamp"`
+}
+
+// commandReply is what a command endpoint may answer with. A plain-text
+// body is treated as an ephemeral reply.
+type commandReply struct {
+	Text         string `json:"text"`
+	ResponseType string `json:"response_type"` // in_channel or ephemeral
+}
+
+// webhookURL checks that a URL can be used as an outgoing endpoint
+func (s *Server) webhookURL(value string) error {
+	return s.fetcher.CheckURL(value)
+}
+
+// handleRunCommand forwards a slash command to its endpoint. In-channel
+// replies are posted as a message under the command's name; ephemeral
+// replies are only returned to the caller.
+func (s *Server) handleRunCommand(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	username := c.GetString("username")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	var req struct {
+		Command string `json:"command" binding:"required"`
+		Text    string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	command := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Command), "/"))
+
+	channel, err := s.lookupChannelForUser(userID, channelID)
+	if err != nil || channel.ChannelType == "voice" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+
+	var endpoint, secret, previousSecret string
+	if err := s.db.QueryRow(`
+		SELECT url, secret, CASE WHEN secret_rotated_at > datetime('now', ?) THEN previous_secret ELSE '' END
+		FROM command_webhooks WHERE command = ?`, secretRotationGrace, command,
+	).Scan(&endpoint, &secret, &previousSecret); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown command /" + command})
+		return
+	}
+	if !s.integrationAllowed(channelID, commandIntegration(command)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "/" + command + " is not allowed in this channel"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), commandTimeout)
+	defer cancel()
+	client := &webhooks.Client{HTTP: s.fetcher.Client(commandTimeout)}
+	resp, err := client.Post(ctx, endpoint, signingSecrets(secret, previousSecret), commandPayload{
+		Command:   command,
+		Text:      req.Text,
+		UserID:    userID,
+		Username:  username,
+		ChannelID: channelID,
+		ServerID:  channel.ServerID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Command /%s failed: %v", command, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The /" + command + " command did not respond"})
+		return
+	}
+
+	reply := commandReply{ResponseType: "ephemeral"}
+	if strings.HasPrefix(resp.ContentType, "application/json") {
+		if err := json.Unmarshal(resp.Body, &reply); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "The /" + command + " command returned invalid JSON"})
+			return
+		}
+	} else {
+		reply.Text = string(resp.Body)
+	}
+	reply.Text = strings.TrimSpace(reply.Text)
+	if utf8.RuneCountInString(reply.Text) > maxCommandReply {
+		reply.Text = string([]rune(reply.Text)[:maxCommandReply])
+	}
+
+	data := gin.H{
+		"command":       command,
+		"text":          reply.Text,
+		"response_type": "ephemeral",
+	}
+	if reply.ResponseType == "in_channel" && reply.Text != "" {
+		messageID, err := s.postChannelMessage(channelID, userID, username, command, reply.Text, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post command reply"})
+			return
+		}
+		data["response_type"] = "in_channel"
+		data["message_id"] = snowflake.ID(messageID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// handleGetCommands lists the slash commands users can run
+func (s *Server) handleGetCommands(c *gin.Context) {
+	rows, err := s.db.Query("SELECT command, description FROM command_webhooks ORDER BY command")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get commands"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	commands := make([]gin.H, 0)
+	for rows.Next() {
+		var command, description string
+		if err := rows.Scan(&command, &description); err == nil {
+			commands = append(commands, gin.H{"command": command, "description": description})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    commands,
+	})
+}
+
+// handleGetCommandWebhooks lists registered command endpoints for admins
+func (s *Server) handleGetCommandWebhooks(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT w.id, w.command, w.url, w.description, COALESCE(u.username, ''), w.created_at
+		FROM command_webhooks w
+		LEFT JOIN users u ON u.id = w.created_by
+		ORDER BY w.command`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get command webhooks"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	webhooksList := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var command, endpoint, description, createdBy, createdAt string
+		if err := rows.Scan(&id, &command, &endpoint, &description, &createdBy, &createdAt); err != nil {
+			continue
+		}
+		webhooksList = append(webhooksList, gin.H{
+			"id":          id,
+			"command":     command,
+			"url":         endpoint,
+			"description": description,
+			"created_by":  createdBy,
+			"created_at":  createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    webhooksList,
+	})
+}
+
+// handleCreateCommandWebhook registers an endpoint for a slash command.
+// The signing secret is only returned here.
+func (s *Server) handleCreateCommandWebhook(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+
+	var req struct {
+		Command     string `json:"command" binding:"required"`
+		URL         string `json:"url" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	command := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Command), "/"))
+	if !commandNamePattern.MatchString(command) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "command must be 1-32 lowercase letters, digits, - or _"})
+		return
+	}
+	if err := s.webhookURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Description) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "description must be at most 200 characters"})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO command_webhooks (command, url, secret, description, created_by) VALUES (?, ?, ?, ?, ?)",
+		command, req.URL, secret, req.Description, adminID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "/" + command + " is already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register command"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	s.logAdminAction(adminID, "create_command_webhook", fmt.Sprintf("Registered /%s -> %s", command, req.URL))
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":      id,
+			"command": command,
+			"url":     req.URL,
+			"secret":  secret,
+		},
+	})
+}
+
+// handleDeleteCommandWebhook removes a command endpoint
+func (s *Server) handleDeleteCommandWebhook(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid command ID"})
+		return
+	}
+
+	var command string
+	if err := s.db.QueryRow("SELECT command FROM command_webhooks WHERE id = ?", id).Scan(&command); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM command_webhooks WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete command"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM channel_integrations WHERE integration = ?", commandIntegration(command)); err != nil {
+		log.Printf("Failed to delete channel integrations for /%s: %v", command, err)
+	}
+
+	s.logAdminAction(adminID, "delete_command_webhook", "Removed /"+command)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Command removed successfully",
+	})
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/fetch"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRunCommand(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	s.fetcher = fetch.New(fetch.Config{AllowPrivate: true})
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhooks.Verify("whsec_test", r.Header.Get(webhooks.TimestampHeader), r.Header.Get(webhooks.SignatureHeader), body, time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload commandPayload
+		_ = json.Unmarshal(body, &payload)
+		if payload.Text == "quiet" {
+			_, _ = w.Write([]byte("only you can see this"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(commandReply{Text: "Weather in " + payload.Text + ": sunny", ResponseType: "in_channel"})
+	}))
+	defer endpoint.Close()
+
+	suffix := time.Now().UnixNano()
+	command := fmt.Sprintf("weather%d", suffix%100000)
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("cmduser_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Commands %d", suffix), userID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'general')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO command_webhooks (command, url, secret) VALUES (?, ?, 'whsec_test')", command, endpoint.URL); err != nil {
+		t.Fatalf("Failed to register command: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/channels/:channelId/commands", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		c.Set("username", "cmduser")
+		s.handleRunCommand(c)
+	})
+	run := func(name, text string) (int, map[string]interface{}) {
+		body := fmt.Sprintf(`{"command":%q,"text":%q}`, name, text)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/channels/%d/commands", channelID), strings.NewReader(body)))
+		var response struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Data
+	}
+
+	code, data := run("/"+command, "Berlin")
+	if code != http.StatusOK || data["response_type"] != "in_channel" {
+		t.Fatalf("Expected an in-channel reply, got %d %v", code, data)
+	}
+	var content, botName string
+	if err := db.QueryRow("SELECT content, bot_name FROM messages WHERE channel_id = ?", channelID).Scan(&content, &botName); err != nil {
+		t.Fatalf("Expected the reply to be posted: %v", err)
+	}
+	if content != "Weather in Berlin: sunny" || botName != command {
+		t.Errorf("Unexpected posted reply %q by %q", content, botName)
+	}
+
+	code, data = run(command, "quiet")
+	if code != http.StatusOK || data["response_type"] != "ephemeral" || data["text"] != "only you can see this" {
+		t.Errorf("Expected an ephemeral reply, got %d %v", code, data)
+	}
+
+	if code, _ := run("nosuchcommand", ""); code != http.StatusNotFound {
+		t.Errorf("Expected unknown command to return 404, got %d", code)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Optimistic concurrency for edits. Servers, channels and roles carry a
+// version that every edit bumps. An edit names the version it was made
+// against, in an If-Match header or a version field, and is refused with
+// the current state when someone else changed the row in between.
+
+// versionETag is the strong ETag of a row version
+func versionETag(version int64) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// ifMatchVersion reports whether an If-Match header names the version.
+// Weak tags never match, as If-Match requires strong comparison.
+func ifMatchVersion(header string, version int64) bool {
+	want := versionETag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVersion refuses an edit not made against the current version. It
+// answers 428 when the request names no version and 409 with the current
+// state when it names an older one. Handlers return early when it fails.
+func checkVersion(c *gin.Context, requested *int64, version int64, current interface{}) bool {
+	match := c.GetHeader("If-Match")
+	switch {
+	case match != "":
+		if ifMatchVersion(match, version) {
+			return true
+		}
+	case requested != nil:
+		if *requested == version {
+			return true
+		}
+	default:
+		c.Header("ETag", versionETag(version))
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "Send the version you are editing in If-Match or version",
+			"code":  "version_required",
+			"data":  current,
+		})
+		return false
+	}
+	versionConflict(c, version, current)
+	return false
+}
+
+// versionConflict answers 409 with the current state, for the client to
+// merge and retry
+func versionConflict(c *gin.Context, version int64, current interface{}) {
+	c.Header("ETag", versionETag(version))
+	c.JSON(http.StatusConflict, gin.H{
+		"error": "This was changed by someone else; review the current version and try again",
+		"code":  "version_conflict",
+		"data":  current,
+	})
+}
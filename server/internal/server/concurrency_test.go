@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOptimisticConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"alice", "bob"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("oc%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, description, owner_id) VALUES (?, '', ?)", fmt.Sprintf("Concurrency %d", suffix), users["alice"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, role := range map[string]string{"alice": "owner", "bob": "admin"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, role); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, 'general', 'text')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO server_roles (server_id, name) VALUES (?, 'Helpers')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	roleID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", user)
+		})
+		router.PATCH("/servers/:id", s.handleUpdateServer)
+		router.PATCH("/channels/:channelId", s.handleUpdateChannel)
+		router.PUT("/servers/:id/roles/:roleId", s.handleUpdateServerRole)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+	current := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Data
+	}
+
+	// Edits must say which version they were made against
+	channelPath := fmt.Sprintf("/channels/%d", channelID)
+	if w := request("alice", "PATCH", channelPath, "", `{"name":"lobby"}`); w.Code != http.StatusPreconditionRequired || current(w)["name"] != "general" {
+		t.Fatalf("Expected an edit without a version to be refused with the current state, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Both admins load version 1; the first edit wins
+	w := request("alice", "PATCH", channelPath, `"v1"`, `{"name":"lobby"}`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"v2"` || current(w)["version"] != float64(2) {
+		t.Fatalf("Expected the edit to apply as version 2, got %d %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	w = request("bob", "PATCH", channelPath, "", `{"name":"hangout","version":1}`)
+	if w.Code != http.StatusConflict || current(w)["name"] != "lobby" || w.Header().Get("ETag") != `"v2"` {
+		t.Fatalf("Expected the stale edit to conflict with the current state, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("bob", "PATCH", channelPath, `W/"v2"`, `{"name":"hangout"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected a weak If-Match not to match, got %d", w.Code)
+	}
+	// Retrying against the version from the conflict succeeds
+	if w := request("bob", "PATCH", channelPath, `"v1", "v2"`, `{"name":"hangout"}`); w.Code != http.StatusOK || current(w)["name"] != "hangout" {
+		t.Fatalf("Expected the retried edit to apply, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Roles
+	rolePath := fmt.Sprintf("/servers/%d/roles/%d", serverID, roleID)
+	if w := request("alice", "PUT", rolePath, "", `{"color":"#ff0000","version":1}`); w.Code != http.StatusOK || current(w)["version"] != float64(2) {
+		t.Fatalf("Expected the role edit to apply, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("bob", "PUT", rolePath, "", `{"name":"Mods","version":1}`); w.Code != http.StatusConflict || current(w)["color"] != "#ff0000" {
+		t.Fatalf("Expected the stale role edit to conflict, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Servers
+	serverPath := fmt.Sprintf("/servers/%d", serverID)
+	if w := request("alice", "PATCH", serverPath, "", `{"description":"Ours"}`); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected a server edit without a version to be refused, got %d", w.Code)
+	}
+	if w := request("alice", "PATCH", serverPath, "*", `{"description":"Ours"}`); w.Code != http.StatusOK || current(w)["description"] != "Ours" {
+		t.Fatalf("Expected If-Match: * to apply the edit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("bob", "PATCH", serverPath, "", `{"name":"Theirs","version":1}`); w.Code != http.StatusConflict || current(w)["description"] != "Ours" {
+		t.Fatalf("Expected the stale server edit to conflict, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("bob", "PATCH", serverPath, "", `{"name":" ","version":2}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a blank name to be rejected, got %d", w.Code)
+	}
+	var name string
+	var version int64
+	if err := db.QueryRow("SELECT name, version FROM servers WHERE id = ?", serverID).Scan(&name, &version); err != nil || version != 2 || !strings.HasPrefix(name, "Concurrency") {
+		t.Errorf("Expected refused edits to leave the server alone, got %q v%d (%v)", name, version, err)
+	}
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"fethur/internal/wordfilter"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Content filters. Owners and admins pick the languages whose shipped
+// profanity and slur lists apply to their server, add words of their own,
+// and choose for each category whether messages using those words are let
+// through with a warning to the sender, censored, or blocked. Censoring
+// happens before a message is stored, so history, automation hooks, XMPP
+// and the starboard only ever see the asterisks.
+
+const (
+	filterActionOff    = "off"
+	filterActionWarn   = "warn"
+	filterActionCensor = "censor"
+	filterActionBlock  = "block"
+
+	// maxFilterWords is how many words a server may add to the lists
+	maxFilterWords = 500
+
+	// maxFilterWordLength is the longest word a server may add
+	maxFilterWordLength = 64
+)
+
+// filterSeverity ranks the filter actions, the most severe highest
+var filterSeverity = map[string]int{
+	filterActionOff:    0,
+	filterActionWarn:   1,
+	filterActionCensor: 2,
+	filterActionBlock:  3,
+}
+
+// contentFilterSettings is a server's content filter
+type contentFilterSettings struct {
+	Languages       []string `json:"languages"`
+	ProfanityAction string   `json:"profanity_action"`
+	SlurAction      string   `json:"slur_action"`
+}
+
+// action is what the server does about words of a category
+func (settings contentFilterSettings) action(category wordfilter.Category) string {
+	if category == wordfilter.Slur {
+		return settings.SlurAction
+	}
+	return settings.ProfanityAction
+}
+
+// filterWord is a word a server added to the lists
+type filterWord struct {
+	Word      string              `json:"word"`
+	Category  wordfilter.Category `json:"category"`
+	CreatedBy *int                `json:"created_by"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// filterMatch is a listed word found in a message, with what the server
+// does about it
+type filterMatch struct {
+	wordfilter.Match
+	Action string `json:"action"`
+}
+
+// contentVerdict is what a server's filter made of a message
+type contentVerdict struct {
+	// Action is the most severe action a word called for; off when no
+	// word matched
+	Action string
+	// Content is the message as it may be posted, with censored words
+	// replaced by asterisks
+	Content string
+	// Matches are the words that called for an action
+	Matches []filterMatch
+}
+
+// words lists the matched words that called for an action, as written
+func (verdict *contentVerdict) words(action string) []string {
+	words := make([]string, 0)
+	for _, match := range verdict.Matches {
+		if match.Action == action {
+			words = append(words, match.Word)
+		}
+	}
+	return words
+}
+
+// report describes the verdict in responses, or is nil when nothing matched
+func (verdict *contentVerdict) report() gin.H {
+	if verdict.Action == filterActionOff {
+		return nil
+	}
+	return gin.H{
+		"action":   verdict.Action,
+		"warned":   verdict.words(filterActionWarn),
+		"censored": verdict.words(filterActionCensor),
+	}
+}
+
+// loadContentFilterSettings returns a server's content filter, off when
+// it has none
+func (s *Server) loadContentFilterSettings(serverID int) (contentFilterSettings, error) {
+	settings := contentFilterSettings{Languages: []string{}, ProfanityAction: filterActionOff, SlurAction: filterActionOff}
+	var languages string
+	err := s.db.QueryRow(
+		"SELECT languages, profanity_action, slur_action FROM content_filters WHERE server_id = ?", serverID,
+	).Scan(&languages, &settings.ProfanityAction, &settings.SlurAction)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	for _, language := range strings.Split(languages, ",") {
+		if language != "" {
+			settings.Languages = append(settings.Languages, language)
+		}
+	}
+	return settings, nil
+}
+
+// loadFilterWords returns the words a server added, alphabetically
+func (s *Server) loadFilterWords(serverID int) ([]filterWord, error) {
+	rows, err := s.db.Query(
+		"SELECT word, category, created_by, created_at FROM content_filter_words WHERE server_id = ? ORDER BY word", serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	words := make([]filterWord, 0)
+	for rows.Next() {
+		var word filterWord
+		var createdBy sql.NullInt64
+		if err := rows.Scan(&word.Word, &word.Category, &createdBy, &word.CreatedAt); err != nil {
+			return nil, err
+		}
+		word.CreatedBy = nullIntPtr(createdBy)
+		words = append(words, word)
+	}
+	return words, rows.Err()
+}
+
+// filterContent runs a message through a server's content filter
+func (s *Server) filterContent(serverID int, content string) (*contentVerdict, error) {
+	verdict := &contentVerdict{Action: filterActionOff, Content: content}
+	settings, err := s.loadContentFilterSettings(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ProfanityAction == filterActionOff && settings.SlurAction == filterActionOff {
+		return verdict, nil
+	}
+
+	var entries []wordfilter.Entry
+	for _, language := range settings.Languages {
+		entries = append(entries, wordfilter.PackEntries(language)...)
+	}
+	custom, err := s.loadFilterWords(serverID)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range custom {
+		entries = append(entries, wordfilter.Entry{Word: word.Word, Category: word.Category})
+	}
+
+	var censored []wordfilter.Match
+	for _, match := range wordfilter.New(entries).Find(content) {
+		action := settings.action(match.Category)
+		if action == filterActionOff {
+			continue
+		}
+		verdict.Matches = append(verdict.Matches, filterMatch{Match: match, Action: action})
+		if filterSeverity[action] > filterSeverity[verdict.Action] {
+			verdict.Action = action
+		}
+		if action == filterActionCensor {
+			censored = append(censored, match)
+		}
+	}
+	verdict.Content = wordfilter.Censor(content, censored)
+	return verdict, nil
+}
+
+// checkContent runs a message being posted or edited through the server's
+// content filter. It answers and returns false when the message is
+// blocked; otherwise the verdict carries the content to post.
+func (s *Server) checkContent(c *gin.Context, serverID int, content string) (*contentVerdict, bool) {
+	verdict, err := s.filterContent(serverID, content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check message"})
+		return nil, false
+	}
+	if verdict.Action == filterActionBlock {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This server does not allow some of the words in your message",
+			"code":  "content_blocked",
+			"words": verdict.words(filterActionBlock),
+		})
+		return nil, false
+	}
+	return verdict, true
+}
+
+// handleGetFilterPacks lists the word lists shipped per language
+func (s *Server) handleGetFilterPacks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": wordfilter.Packs()})
+}
+
+// handleGetContentFilter returns a server's content filter with the words
+// it added; owners and admins only
+func (s *Server) handleGetContentFilter(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	settings, err := s.loadContentFilterSettings(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get content filter"})
+		return
+	}
+	words, err := s.loadFilterWords(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get content filter"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"settings": settings, "words": words},
+	})
+}
+
+// handleUpdateContentFilter sets the languages and actions of a server's
+// content filter
+func (s *Server) handleUpdateContentFilter(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	var req contentFilterSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, action := range []*string{&req.ProfanityAction, &req.SlurAction} {
+		if *action == "" {
+			*action = filterActionOff
+		}
+		if _, known := filterSeverity[*action]; !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Actions must be off, warn, censor or block"})
+			return
+		}
+	}
+	languages := make([]string, 0, len(req.Languages))
+	seen := make(map[string]bool)
+	for _, language := range req.Languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !wordfilter.HasPack(language) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No word list for language " + language})
+			return
+		}
+		if !seen[language] {
+			seen[language] = true
+			languages = append(languages, language)
+		}
+	}
+	req.Languages = languages
+
+	if _, err := s.db.Exec(`
+		INSERT INTO content_filters (server_id, languages, profanity_action, slur_action, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (server_id) DO UPDATE SET languages = excluded.languages, profanity_action = excluded.profanity_action,
+			slur_action = excluded.slur_action, updated_at = CURRENT_TIMESTAMP`,
+		serverID, strings.Join(languages, ","), req.ProfanityAction, req.SlurAction,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update content filter"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": req})
+}
+
+// handleAddFilterWord adds a word to a server's lists, or moves it to
+// another category
+func (s *Server) handleAddFilterWord(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		Word     string              `json:"word" binding:"required"`
+		Category wordfilter.Category `json:"category"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Category == "" {
+		req.Category = wordfilter.Profanity
+	}
+	if !wordfilter.Categories[req.Category] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category must be profanity or slur"})
+		return
+	}
+	word := wordfilter.NormalizeWord(req.Word)
+	if word == "" || len(word) > maxFilterWordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "word must be a single word of up to 64 letters and digits, optionally ending in *"})
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM content_filter_words WHERE server_id = ? AND word != ?", serverID, word).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add word"})
+		return
+	}
+	if count >= maxFilterWords {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A server can add at most 500 words"})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	if _, err := s.db.Exec(`
+		INSERT INTO content_filter_words (server_id, word, category, created_by) VALUES (?, ?, ?, ?)
+		ON CONFLICT (server_id, word) DO UPDATE SET category = excluded.category`,
+		serverID, word, req.Category, userID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add word"})
+		return
+	}
+	s.markWrite(userID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    gin.H{"word": word, "category": req.Category},
+	})
+}
+
+// handleDeleteFilterWord removes a word a server added
+func (s *Server) handleDeleteFilterWord(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	result, err := s.db.Exec(
+		"DELETE FROM content_filter_words WHERE server_id = ? AND word = ?", serverID, wordfilter.NormalizeWord(c.Param("word")),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove word"})
+		return
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Word not found"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Word removed"})
+}
+
+// handleTestContentFilter shows what the server's filter makes of a
+// message without posting it
+func (s *Server) handleTestContentFilter(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	verdict, err := s.filterContent(serverID, req.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check message"})
+		return
+	}
+	matches := verdict.Matches
+	if matches == nil {
+		matches = []filterMatch{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"action": verdict.Action, "content": verdict.Content, "matches": matches},
+	})
+}
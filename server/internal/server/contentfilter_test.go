@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestContentFilter(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "member"} {
+		users[name] = createTestUser(t, db, fmt.Sprintf("filter%s_%d", name, suffix))
+	}
+	serverID, channelID := createTestCommunity(t, db, users["owner"], users["member"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+	})
+	router.GET("/servers/:id/content-filter", s.handleGetContentFilter)
+	router.PUT("/servers/:id/content-filter", s.handleUpdateContentFilter)
+	router.POST("/servers/:id/content-filter/words", s.handleAddFilterWord)
+	router.DELETE("/servers/:id/content-filter/words/:word", s.handleDeleteFilterWord)
+	router.POST("/servers/:id/content-filter/test", s.handleTestContentFilter)
+	router.POST("/channels/:channelId/messages", s.handleSendMessage)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	filterPath := fmt.Sprintf("/servers/%d/content-filter", serverID)
+	send := func(content string) *httptest.ResponseRecorder {
+		return request("POST", fmt.Sprintf("/channels/%d/messages", channelID), "member", fmt.Sprintf(`{"content":%q}`, content))
+	}
+	var sent struct {
+		Data struct {
+			Content string `json:"content"`
+		} `json:"data"`
+		ContentFilter *struct {
+			Action string   `json:"action"`
+			Warned []string `json:"warned"`
+		} `json:"content_filter"`
+	}
+
+	if w := request("PUT", filterPath, "member", `{"languages":["en"],"profanity_action":"censor"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected members to be refused, got %d", w.Code)
+	}
+	if w := request("PUT", filterPath, "owner", `{"languages":["xx"],"profanity_action":"censor"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown language to be refused, got %d", w.Code)
+	}
+	if w := request("PUT", filterPath, "owner", `{"languages":["en"],"profanity_action":"censor","slur_action":"block"}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set the filter, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", filterPath+"/words", "owner", `{"word":"two words"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a phrase to be refused, got %d", w.Code)
+	}
+	if w := request("POST", filterPath+"/words", "owner", `{"word":"Zorg","category":"slur"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to add a word, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Censored words are stored censored
+	w := send("well fuck, that broke")
+	if err := json.Unmarshal(w.Body.Bytes(), &sent); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Failed to send, got %d: %s", w.Code, w.Body.String())
+	}
+	if sent.Data.Content != "well ****, that broke" || sent.ContentFilter == nil || sent.ContentFilter.Action != "censor" {
+		t.Errorf("Expected the message censored, got %s", w.Body.String())
+	}
+	var stored string
+	if err := db.QueryRow("SELECT content FROM messages WHERE channel_id = ? ORDER BY id DESC LIMIT 1", channelID).Scan(&stored); err != nil || stored != "well ****, that broke" {
+		t.Errorf("Expected the censored content stored, got %q (%v)", stored, err)
+	}
+
+	// Blocked words keep the message out
+	if w := send("you z0rg"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "content_blocked") {
+		t.Errorf("Expected the slur to be blocked, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A warning lets the message through as written
+	if w := request("PUT", filterPath, "owner", `{"languages":["en"],"profanity_action":"warn","slur_action":"block"}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set the filter, got %d: %s", w.Code, w.Body.String())
+	}
+	sent.ContentFilter = nil
+	w = send("well shit")
+	if err := json.Unmarshal(w.Body.Bytes(), &sent); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Failed to send, got %d: %s", w.Code, w.Body.String())
+	}
+	if sent.Data.Content != "well shit" || sent.ContentFilter == nil || sent.ContentFilter.Action != "warn" || len(sent.ContentFilter.Warned) != 1 {
+		t.Errorf("Expected the message posted with a warning, got %s", w.Body.String())
+	}
+
+	if w := request("POST", filterPath+"/test", "owner", `{"content":"zorg and shit"}`); !strings.Contains(w.Body.String(), `"action":"block"`) {
+		t.Errorf("Expected the test to report a block, got %s", w.Body.String())
+	}
+	if w := request("DELETE", filterPath+"/words/zorg", "owner", ""); w.Code != http.StatusOK {
+		t.Errorf("Failed to remove the word, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("zorg"); w.Code != http.StatusOK {
+		t.Errorf("Expected a removed word to pass, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Debug capture. A super admin turns it on for a while to record a sample
+// of API requests, with the start of their bodies, in a ring buffer. Secrets
+// in bodies, query strings and paths are redacted before anything is kept.
+// Nothing is written to disk, and capture is off again after a restart.
+
+const (
+	debugCaptureSize           = 200
+	maxDebugCaptureBody        = 4096
+	defaultDebugCaptureMinutes = 60
+	maxDebugCaptureMinutes     = 1440
+	debugRedacted              = "[REDACTED]"
+)
+
+var (
+	// debugSecretName matches the names of fields that hold secrets
+	debugSecretName = regexp.MustCompile(`(?i)password|token|secret|api_?key|authorization|signature|sig$`)
+	// debugSecretJSON matches JSON string fields with secret names, even
+	// in truncated bodies
+	debugSecretJSON = regexp.MustCompile(`(?i)("[^"]*(?:password|token|secret|api_?key|authorization|signature)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*("|$)`)
+)
+
+// capturedRequest is one sampled request
+type capturedRequest struct {
+	ID            int64     `json:"id"`
+	Time          time.Time `json:"time"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Route         string    `json:"route"`
+	Status        int       `json:"status"`
+	DurationMs    float64   `json:"duration_ms"`
+	UserID        int       `json:"user_id,omitempty"`
+	ClientIP      string    `json:"client_ip"`
+	UserAgent     string    `json:"user_agent"`
+	RequestBody   string    `json:"request_body"`
+	ResponseBody  string    `json:"response_body"`
+	BodyTruncated bool      `json:"body_truncated"`
+}
+
+// debugCapture samples requests into a ring buffer
+type debugCapture struct {
+	mutex     sync.Mutex
+	percent   int
+	expiresAt time.Time
+	entries   []capturedRequest
+	next      int
+	lastID    int64
+}
+
+func newDebugCapture() *debugCapture {
+	return &debugCapture{entries: make([]capturedRequest, 0, debugCaptureSize)}
+}
+
+// sampled decides whether to capture a request
+func (d *debugCapture) sampled(now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.percent == 0 || now.After(d.expiresAt) {
+		return false
+	}
+	return d.percent == 100 || rand.Intn(100) < d.percent // #nosec G404 -- sampling, not security
+}
+
+// configure turns capture on for a duration at a sample rate, or off with
+// a zero percent
+func (d *debugCapture) configure(percent int, until time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.percent = percent
+	d.expiresAt = until
+}
+
+// status returns the sample rate and when capture stops, if it is on
+func (d *debugCapture) status(now time.Time) (int, *time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.percent == 0 || now.After(d.expiresAt) {
+		return 0, nil
+	}
+	until := d.expiresAt
+	return d.percent, &until
+}
+
+func (d *debugCapture) add(entry capturedRequest) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lastID++
+	entry.ID = d.lastID
+	if len(d.entries) < debugCaptureSize {
+		d.entries = append(d.entries, entry)
+		return
+	}
+	d.entries[d.next] = entry
+	d.next = (d.next + 1) % debugCaptureSize
+}
+
+// list returns the captured requests, newest first
+func (d *debugCapture) list() []capturedRequest {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entries := make([]capturedRequest, 0, len(d.entries))
+	for i := len(d.entries) - 1; i >= 0; i-- {
+		entries = append(entries, d.entries[(d.next+i)%len(d.entries)])
+	}
+	return entries
+}
+
+func (d *debugCapture) clear() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.entries = d.entries[:0]
+	d.next = 0
+}
+
+// captureWriter keeps the start of a response body
+type captureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(data string) (int, error) {
+	w.keep([]byte(data))
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w *captureWriter) keep(data []byte) {
+	room := maxDebugCaptureBody - w.body.Len()
+	if len(data) > room {
+		data = data[:room]
+		w.truncated = true
+	}
+	w.body.Write(data)
+}
+
+// redactBody hides secret fields of a JSON or form body
+func redactBody(contentType, body string) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return redactQuery(body)
+	}
+	return debugSecretJSON.ReplaceAllString(body, `$1"`+debugRedacted+`$2`)
+}
+
+// redactQuery hides the values of secret query parameters
+func redactQuery(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return debugRedacted
+	}
+	for name := range values {
+		if debugSecretName.MatchString(name) {
+			values[name] = []string{debugRedacted}
+		}
+	}
+	return values.Encode()
+}
+
+// capturableBody reports whether a content type is text worth keeping
+func capturableBody(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "text/")
+}
+
+// debugCaptureMiddleware records a sample of requests while capture is on
+func (s *Server) debugCaptureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The capture endpoints would otherwise fill the buffer with itself
+		if s.debug == nil || strings.HasSuffix(c.FullPath(), "/admin/debug/requests") || !s.debug.sampled(time.Now()) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+
+		var requestBody string
+		truncated := false
+		requestType := c.ContentType()
+		if c.Request.Body != nil && c.Request.ContentLength != 0 {
+			if capturableBody(requestType) {
+				head, _ := io.ReadAll(io.LimitReader(c.Request.Body, maxDebugCaptureBody+1))
+				// The handler still reads the whole body
+				c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(head), c.Request.Body), c.Request.Body}
+				if len(head) > maxDebugCaptureBody {
+					head = head[:maxDebugCaptureBody]
+					truncated = true
+				}
+				requestBody = redactBody(requestType, string(head))
+			} else {
+				requestBody = fmt.Sprintf("[%s body not captured]", requestType)
+			}
+		}
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		responseBody := ""
+		if responseType := writer.Header().Get("Content-Type"); capturableBody(responseType) {
+			responseBody = redactBody(responseType, writer.body.String())
+		} else if writer.body.Len() > 0 {
+			responseBody = fmt.Sprintf("[%s body not captured]", responseType)
+		}
+		path := c.Request.URL.Path
+		for _, param := range c.Params {
+			if debugSecretName.MatchString(param.Key) && param.Value != "" {
+				path = strings.Replace(path, "/"+param.Value, "/"+debugRedacted, 1)
+			}
+		}
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + redactQuery(c.Request.URL.RawQuery)
+		}
+		s.debug.add(capturedRequest{
+			Time:          start.UTC(),
+			Method:        c.Request.Method,
+			Path:          path,
+			Route:         c.FullPath(),
+			Status:        writer.Status(),
+			DurationMs:    float64(time.Since(start).Microseconds()) / 1000,
+			UserID:        c.GetInt("user_id"),
+			ClientIP:      c.ClientIP(),
+			UserAgent:     c.Request.UserAgent(),
+			RequestBody:   requestBody,
+			ResponseBody:  responseBody,
+			BodyTruncated: truncated || writer.truncated,
+		})
+	}
+}
+
+// readCloser reads from a replayed body and closes the original
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// handleGetDebugRequests lists the captured requests, newest first.
+// user_id and min_status narrow the list.
+func (s *Server) handleGetDebugRequests(c *gin.Context) {
+	var userID, minStatus int
+	for name, target := range map[string]*int{"user_id": &userID, "min_status": &minStatus} {
+		if value := c.Query(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	percent, until := s.debug.status(time.Now())
+	requests := make([]capturedRequest, 0)
+	for _, entry := range s.debug.list() {
+		if (userID == 0 || entry.UserID == userID) && entry.Status >= minStatus {
+			requests = append(requests, entry)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"sample_percent": percent,
+			"expires_at":     until,
+			"capacity":       debugCaptureSize,
+			"requests":       requests,
+		},
+	})
+}
+
+// handleUpdateDebugCapture turns capture on at a sample rate for a while,
+// or off with a zero sample rate
+func (s *Server) handleUpdateDebugCapture(c *gin.Context) {
+	var req struct {
+		SamplePercent   *int `json:"sample_percent" binding:"required"`
+		DurationMinutes int  `json:"duration_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.SamplePercent < 0 || *req.SamplePercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sample_percent must be between 0 and 100"})
+		return
+	}
+	if req.DurationMinutes == 0 {
+		req.DurationMinutes = defaultDebugCaptureMinutes
+	}
+	if req.DurationMinutes < 1 || req.DurationMinutes > maxDebugCaptureMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration_minutes must be between 1 and %d", maxDebugCaptureMinutes)})
+		return
+	}
+
+	s.debug.configure(*req.SamplePercent, time.Now().Add(time.Duration(req.DurationMinutes)*time.Minute))
+	details := "Turned debug capture off"
+	if *req.SamplePercent > 0 {
+		details = fmt.Sprintf("Turned debug capture on for %d%% of requests for %d minutes", *req.SamplePercent, req.DurationMinutes)
+	}
+	s.logAdminAction(c.GetInt("user_id"), "debug_capture", details)
+
+	percent, until := s.debug.status(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"sample_percent": percent, "expires_at": until},
+	})
+}
+
+// handleClearDebugRequests empties the capture buffer
+func (s *Server) handleClearDebugRequests(c *gin.Context) {
+	s.debug.clear()
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Captured requests cleared"})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"application/json", `{"username":"ann","password":"hunter2"}`, `{"username":"ann","password":"[REDACTED]"}`},
+		{"application/json", `{"data":{"access_token":"a\"b","id":1}}`, `{"data":{"access_token":"[REDACTED]","id":1}}`},
+		{"application/json", `{"api_key":"abc`, `{"api_key":"[REDACTED]`},
+		{"application/x-www-form-urlencoded", "user=ann&client_secret=s3", "client_secret=%5BREDACTED%5D&user=ann"},
+	}
+	for _, test := range tests {
+		if got := redactBody(test.contentType, test.body); got != test.want {
+			t.Errorf("redactBody(%q) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestDebugCapture(t *testing.T) {
+	s := &Server{debug: newDebugCapture()}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(s.debugCaptureMiddleware(), func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+	})
+	router.POST("/login", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "bad login", "echo": len(body)})
+	})
+	router.POST("/webhooks/:id/:token", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/admin/debug/requests", s.handleGetDebugRequests)
+	router.PUT("/admin/debug/requests", s.handleUpdateDebugCapture)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", "7")
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Nothing is kept while capture is off
+	request("POST", "/login", `{"password":"hunter2"}`)
+	if len(s.debug.list()) != 0 {
+		t.Fatal("Expected nothing captured while capture is off")
+	}
+
+	s.debug.configure(100, time.Now().Add(time.Minute))
+	w := request("POST", "/login", `{"username":"ann","password":"hunter2"}`)
+	if !strings.Contains(w.Body.String(), `"echo":39`) {
+		t.Errorf("Expected the handler to still read the whole body, got %s", w.Body.String())
+	}
+	request("POST", "/webhooks/3/abcdef?sig=xyz&x=1", "")
+
+	var listed struct {
+		Data struct {
+			SamplePercent int               `json:"sample_percent"`
+			Requests      []capturedRequest `json:"requests"`
+		} `json:"data"`
+	}
+	w = request("GET", "/admin/debug/requests", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if listed.Data.SamplePercent != 100 || len(listed.Data.Requests) != 2 {
+		t.Fatalf("Expected two captured requests, got %s", w.Body.String())
+	}
+	hook, login := listed.Data.Requests[0], listed.Data.Requests[1]
+	if hook.Path != "/webhooks/3/[REDACTED]?sig=%5BREDACTED%5D&x=1" || hook.Route != "/webhooks/:id/:token" {
+		t.Errorf("Expected the webhook token and signature to be redacted, got %q", hook.Path)
+	}
+	if login.Status != http.StatusUnauthorized || login.UserID != 7 || strings.Contains(login.RequestBody, "hunter2") ||
+		!strings.Contains(login.ResponseBody, "bad login") {
+		t.Errorf("Expected the login to be captured without its password, got %+v", login)
+	}
+
+	w = request("GET", "/admin/debug/requests?min_status=400", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Data.Requests) != 1 || listed.Data.Requests[0].ID != login.ID {
+		t.Errorf("Expected only the failed login, got %s", w.Body.String())
+	}
+
+	if w := request("PUT", "/admin/debug/requests", `{"sample_percent":101}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an out of range sample rate to be refused, got %d", w.Code)
+	}
+
+	// The buffer keeps only the newest requests
+	for i := 0; i < debugCaptureSize+5; i++ {
+		s.debug.add(capturedRequest{Path: strconv.Itoa(i)})
+	}
+	entries := s.debug.list()
+	if len(entries) != debugCaptureSize || entries[0].Path != strconv.Itoa(debugCaptureSize+4) || entries[len(entries)-1].Path != "5" {
+		t.Errorf("Expected the oldest requests to be dropped, got %d from %q to %q", len(entries), entries[0].Path, entries[len(entries)-1].Path)
+	}
+
+	// Capture stops on its own
+	s.debug.configure(100, time.Now().Add(-time.Second))
+	s.debug.clear()
+	request("POST", "/login", "{}")
+	if len(s.debug.list()) != 0 {
+		t.Error("Expected capture to stop once it expires")
+	}
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/geoip"
+	"fethur/internal/mail"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTouchInterval is how stale a session's last_seen may get before a
+// request refreshes it, as a SQLite modifier, so that busy clients do not
+// write on every request
+const sessionTouchInterval = "-1 minute"
+
+// loginDevice is a device a user has logged in from
+type loginDevice struct {
+	ID        int64
+	UserAgent string
+	IPPrefix  string
+	Location  *geoip.Location
+	// NewCountry is set when the user has not logged in from the
+	// device's country before
+	NewCountry bool
+}
+
+// ipPrefix reduces an IP to its network prefix (/24 for IPv4, /48 for
+// IPv6) so that address churn within a network is not a new device.
+func ipPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// deviceFingerprint derives a stable device fingerprint from the user agent and IP prefix
+func deviceFingerprint(userAgent, prefix string) string {
+	sum := sha256.Sum256([]byte(userAgent + "|" + prefix))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordLoginDevice upserts the device a login comes from. It reports
+// whether the user should be notified: the device is unknown (or was
+// revoked) and the user has logged in from other devices before.
+func (s *Server) recordLoginDevice(userID int, c *gin.Context) (*loginDevice, bool, error) {
+	device := &loginDevice{
+		UserAgent: c.Request.UserAgent(),
+		IPPrefix:  ipPrefix(c.ClientIP()),
+		Location:  s.locate(c.ClientIP()),
+	}
+	var country sql.NullString
+	if device.Location != nil && device.Location.CountryCode != "" {
+		country = sql.NullString{String: device.Location.CountryCode, Valid: true}
+	}
+	fingerprint := deviceFingerprint(device.UserAgent, device.IPPrefix)
+
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT id, revoked_at FROM user_devices WHERE user_id = ? AND fingerprint = ?",
+		userID, fingerprint,
+	).Scan(&device.ID, &revokedAt)
+
+	switch {
+	case err == nil:
+		if _, err := s.db.Exec(
+			"UPDATE user_devices SET last_seen = CURRENT_TIMESTAMP, last_ip = ?, country = COALESCE(?, country), revoked_at = NULL WHERE id = ?",
+			c.ClientIP(), country, device.ID,
+		); err != nil {
+			return nil, false, err
+		}
+		// Logging back in on a revoked device counts as a new device
+		return device, revokedAt.Valid, nil
+
+	case err == sql.ErrNoRows:
+		var known, knownInCountry int
+		if err := s.db.QueryRow(
+			"SELECT COUNT(*), COUNT(CASE WHEN country = ? THEN 1 END) FROM user_devices WHERE user_id = ?",
+			country, userID,
+		).Scan(&known, &knownInCountry); err != nil {
+			return nil, false, err
+		}
+		device.NewCountry = country.Valid && known > 0 && knownInCountry == 0
+
+		result, err := s.db.Exec(
+			"INSERT INTO user_devices (user_id, fingerprint, user_agent, ip_prefix, last_ip, country) VALUES (?, ?, ?, ?, ?, ?)",
+			userID, fingerprint, device.UserAgent, device.IPPrefix, c.ClientIP(), country,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		device.ID, _ = result.LastInsertId()
+		return device, known > 0, nil
+
+	default:
+		return nil, false, err
+	}
+}
+
+// notifyNewDevice tells the user about a login from a new device: their
+// connected sessions at once, or on their next connect when they have
+// none, and by email when mail is configured
+func (s *Server) notifyNewDevice(userID int, device *loginDevice) {
+	content := "New login to your account from an unrecognized device"
+	data := gin.H{
+		"kind":       "new_device_login",
+		"device_id":  device.ID,
+		"user_agent": device.UserAgent,
+		"ip_prefix":  device.IPPrefix,
+	}
+	if device.Location != nil {
+		data["location"] = device.Location
+		data["new_country"] = device.NewCountry
+	}
+	if device.NewCountry {
+		log.Printf("New device login for user %d from %s in %s, a new country (%s)", userID, device.IPPrefix, device.Location.CountryCode, device.UserAgent)
+		content = "New login to your account from a device in a country you have not logged in from before"
+		if device.Location.Country != "" {
+			content = "New login to your account from a device in " + device.Location.Country + ", where you have not logged in before"
+		}
+	} else {
+		log.Printf("New device login for user %d from %s (%s)", userID, device.IPPrefix, device.UserAgent)
+	}
+
+	message := &websocket.Message{
+		Type:      string(events.TypeNotification),
+		Content:   content,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	s.clientsMux.RLock()
+	client, ok := s.clients[userID]
+	s.clientsMux.RUnlock()
+	if ok {
+		client.Send(message)
+	} else {
+		s.queueNotification(userID, "new_device_login", message)
+	}
+
+	if s.mailer == nil {
+		return
+	}
+	var username, email string
+	if err := s.db.QueryRow("SELECT username, COALESCE(email, '') FROM users WHERE id = ?", userID).Scan(&username, &email); err != nil || email == "" {
+		return
+	}
+	country := ""
+	if device.Location != nil {
+		country = device.Location.Country
+	}
+	go func() {
+		_ = s.sendEmail(context.Background(), userID, email, mail.TemplateNewDevice, map[string]interface{}{
+			"Username": username,
+			"Device":   device.UserAgent,
+			"Network":  device.IPPrefix,
+			"Country":  country,
+		})
+	}()
+}
+
+// isDeviceRevoked reports whether a token's device has been revoked
+func (s *Server) isDeviceRevoked(userID int, deviceID string) bool {
+	var revoked bool
+	err := s.db.QueryRow(
+		"SELECT revoked_at IS NOT NULL FROM user_devices WHERE id = ? AND user_id = ?",
+		deviceID, userID,
+	).Scan(&revoked)
+	if err != nil {
+		// Unknown device, e.g. deleted with the account
+		return true
+	}
+	return revoked
+}
+
+// touchSession records that a session was just used, and from where
+func (s *Server) touchSession(userID int, deviceID, ip string) {
+	if _, err := s.db.Exec(`
+		UPDATE user_devices SET last_seen = CURRENT_TIMESTAMP, last_ip = ?
+		WHERE id = ? AND user_id = ?
+			AND (last_seen < datetime('now', ?) OR last_ip IS NOT ?)`,
+		ip, deviceID, userID, sessionTouchInterval, ip,
+	); err != nil {
+		log.Printf("Failed to update session %s of user %d: %v", deviceID, userID, err)
+	}
+}
+
+// disconnectSession closes the realtime connections a user opened with one
+// session, leaving their other devices connected
+func (s *Server) disconnectSession(userID int, deviceID string, reason string) {
+	s.clientsMux.Lock()
+	if client, exists := s.clients[userID]; exists && client.Session() == deviceID {
+		if err := client.Close(); err != nil {
+			log.Printf("Error closing websocket for user %d: %v", userID, err)
+		}
+		delete(s.clients, userID)
+	}
+	s.clientsMux.Unlock()
+	s.hub.ForgetSubscriptions(userID, deviceID)
+
+	s.voiceHub.DisconnectSession(int64(userID), deviceID, "logout", reason)
+}
+
+func (s *Server) handleGetSessions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	currentDevice := c.GetString("device_id")
+
+	// The session holding the user's chat socket, if any
+	var connected string
+	s.clientsMux.RLock()
+	if client, exists := s.clients[userID]; exists {
+		connected = client.Session()
+	}
+	s.clientsMux.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT id, user_agent, ip_prefix, last_ip, country, first_seen, last_seen, revoked_at
+		FROM user_devices WHERE user_id = ?
+		ORDER BY last_seen DESC`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	sessions := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var userAgent, prefix, lastIP, country sql.NullString
+		var firstSeen, lastSeen time.Time
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&id, &userAgent, &prefix, &lastIP, &country, &firstSeen, &lastSeen, &revokedAt); err != nil {
+			continue
+		}
+
+		session := gin.H{
+			"id":         id,
+			"user_agent": userAgent.String,
+			"ip_prefix":  prefix.String,
+			"ip":         lastIP.String,
+			"country":    country.String,
+			"first_seen": firstSeen.Format(time.RFC3339),
+			"last_seen":  lastSeen.Format(time.RFC3339),
+			"current":    strconv.FormatInt(id, 10) == currentDevice,
+			"connected":  strconv.FormatInt(id, 10) == connected && !revokedAt.Valid,
+			"revoked":    revokedAt.Valid,
+		}
+		if revokedAt.Valid {
+			session["revoked_at"] = revokedAt.Time.Format(time.RFC3339)
+		}
+		sessions = append(sessions, session)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+func (s *Server) handleRevokeSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	deviceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE user_devices SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		deviceID, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	log.Printf("User %d revoked device %d", userID, deviceID)
+
+	// Its tokens are refused from now on; drop the sockets they opened
+	s.disconnectSession(userID, strconv.FormatInt(deviceID, 10), "Session revoked")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session revoked successfully",
+	})
+}
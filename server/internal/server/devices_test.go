@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/mail"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestIPPrefix(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.23":         "192.168.1.0/24",
+		"10.0.0.255":           "10.0.0.0/24",
+		"2001:db8:abcd:12::1":  "2001:db8:abcd::/48",
+		"::ffff:192.168.1.200": "192.168.1.0/24",
+		"not-an-ip":            "not-an-ip",
+	}
+
+	for ip, want := range tests {
+		if got := ipPrefix(ip); got != want {
+			t.Errorf("ipPrefix(%q) = %q, want %q", ip, got, want)
+		}
+	}
+
+	if deviceFingerprint("Firefox", "192.168.1.0/24") == deviceFingerprint("Firefox", "192.168.2.0/24") {
+		t.Error("Expected different networks to produce different fingerprints")
+	}
+}
+
+func TestRevokeSessionDisconnects(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	username := fmt.Sprintf("sessions_%d", time.Now().UnixNano())
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	if _, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', ?)", username, hash); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", s.handleLogin)
+	router.GET("/ws", s.authMiddleware(), s.handleWebSocket)
+	router.GET("/user/sessions", s.authMiddleware(), s.handleGetSessions)
+	router.DELETE("/user/sessions/:id", s.authMiddleware(), s.handleRevokeSession)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// Two devices, told apart by their user agents
+	login := func(userAgent string) string {
+		req, _ := http.NewRequest("POST", srv.URL+"/auth/login", strings.NewReader(fmt.Sprintf(`{"username":%q,"password":"correct-horse-battery"}`, username)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var got struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got.Token == "" {
+			t.Fatalf("Expected a token, got %d (%v)", resp.StatusCode, err)
+		}
+		return got.Token
+	}
+	laptop := login("Laptop")
+	phone := login("Phone")
+	laptopClaims, _ := s.auth.ValidateToken(laptop)
+
+	conn, resp, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+laptop, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	_ = resp.Body.Close()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	request := func(method, path, token string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var body json.RawMessage
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	code, body := request("GET", "/user/sessions", phone)
+	var listed struct {
+		Data []struct {
+			ID        int64  `json:"id"`
+			UserAgent string `json:"user_agent"`
+			IP        string `json:"ip"`
+			Current   bool   `json:"current"`
+			Connected bool   `json:"connected"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listed); err != nil || code != http.StatusOK || len(listed.Data) != 2 {
+		t.Fatalf("Expected two sessions, got %d: %s", code, body)
+	}
+	for _, session := range listed.Data {
+		laptopSession := fmt.Sprint(session.ID) == laptopClaims.DeviceID
+		if session.IP != "127.0.0.1" || session.Current == laptopSession || session.Connected != laptopSession {
+			t.Errorf("Unexpected session %+v", session)
+		}
+	}
+
+	// Revoking the laptop from the phone closes the laptop's socket
+	if code, body := request("DELETE", "/user/sessions/"+laptopClaims.DeviceID, phone); code != http.StatusOK {
+		t.Fatalf("Expected the session to be revoked, got %d: %s", code, body)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+				t.Fatal("Expected the revoked session's socket to be closed")
+			}
+			break
+		}
+	}
+	if code, _ := request("GET", "/user/sessions", laptop); code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked session's token to be refused, got %d", code)
+	}
+	if code, _ := request("GET", "/user/sessions", phone); code != http.StatusOK {
+		t.Errorf("Expected the other session to keep working, got %d", code)
+	}
+}
+
+func TestNewDeviceNotifiesOfflineUser(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	mailer, err := mail.New(&recordingMail{}, mail.Config{From: "noreply@example.com", BaseURL: "https://chat.example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+	s.mailer = mailer
+
+	username := fmt.Sprintf("away_device_%d", time.Now().UnixNano())
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, ?, 'x')", username, username+"@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	userID := int(id)
+
+	// With no session connected, the warning is kept and emailed
+	s.notifyNewDevice(userID, &loginDevice{ID: 42, UserAgent: "Strange Browser", IPPrefix: "203.0.113.0/24"})
+	var queued int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_notifications WHERE user_id = ? AND kind = 'new_device_login'", userID).Scan(&queued); err != nil || queued != 1 {
+		t.Fatalf("Expected the notification kept for the offline user, got %d (%v)", queued, err)
+	}
+	emailed := false
+	for deadline := time.Now().Add(2 * time.Second); !emailed && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var status string
+		if db.QueryRow("SELECT status FROM mail_deliveries WHERE user_id = ? AND template = ?", userID, mail.TemplateNewDevice).Scan(&status) == nil {
+			emailed = status == "sent"
+		}
+	}
+	if !emailed {
+		t.Error("Expected the new device login to be emailed")
+	}
+
+	// The next connect delivers it and clears it
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", s.authMiddleware(), s.handleWebSocket)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	token, err := s.auth.GenerateToken(userID, username, "user")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	conn, resp, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+token, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	_ = resp.Body.Close()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected the new device notification on connect: %v", err)
+		}
+		var message struct {
+			Type string `json:"type"`
+			Data struct {
+				Kind      string `json:"kind"`
+				UserAgent string `json:"user_agent"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(data, &message) == nil && message.Type == "notification" {
+			if message.Data.Kind != "new_device_login" || message.Data.UserAgent != "Strange Browser" {
+				t.Errorf("Unexpected notification %s", data)
+			}
+			break
+		}
+	}
+	cleared := false
+	for deadline := time.Now().Add(2 * time.Second); !cleared && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		_ = db.QueryRow("SELECT COUNT(*) FROM user_notifications WHERE user_id = ?", userID).Scan(&queued)
+		cleared = queued == 0
+	}
+	if !cleared {
+		t.Error("Expected the delivered notification to be cleared")
+	}
+}
+
+func TestAuditLogRecordsSessionIP(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	username := fmt.Sprintf("audited_%d", time.Now().UnixNano())
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', 'admin')", username)
+	if err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	adminID, _ := result.LastInsertId()
+	for i, ip := range []string{"198.51.100.7", "203.0.113.9"} {
+		if _, err := db.Exec(
+			"INSERT INTO user_devices (user_id, fingerprint, last_ip, last_seen) VALUES (?, ?, ?, datetime('now', ?))",
+			adminID, fmt.Sprintf("device-%d", i), ip, fmt.Sprintf("-%d minutes", 10-i),
+		); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+	}
+
+	var ip string
+	id := s.logAdminAction(int(adminID), "test_action", "Tested auditing")
+	if err := db.QueryRow("SELECT COALESCE(ip, '') FROM audit_logs WHERE id = ?", id).Scan(&ip); err != nil || ip != "203.0.113.9" {
+		t.Errorf("Expected the latest session's IP, got %q (%v)", ip, err)
+	}
+	id = s.logAdminAction(0, "test_action", "Tested auditing without an admin")
+	if err := db.QueryRow("SELECT COALESCE(ip, '') FROM audit_logs WHERE id = ?", id).Scan(&ip); err != nil || ip != "" {
+		t.Errorf("Expected no IP for system actions, got %q (%v)", ip, err)
+	}
+}
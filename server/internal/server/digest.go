@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"fethur/internal/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+const digestUnsubscribePurpose = "digest-unsubscribe"
+
+// digestRun prevents overlapping digest runs
+var digestRun sync.Mutex
+
+// digestSummary counts the outcome of a digest run
+type digestSummary struct {
+	Sent    int `json:"sent"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// digestCandidate is an inactive user due for a digest
+type digestCandidate struct {
+	ID       int
+	Username string
+	Email    string
+	Since    string // activity after this timestamp is summarized
+}
+
+// touchLastSeen records that a user connected
+func (s *Server) touchLastSeen(userID int) {
+	if _, err := s.db.Exec("UPDATE users SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?", userID); err != nil {
+		log.Printf("Failed to update last seen for user %d: %v", userID, err)
+	}
+}
+
+// startDigestScheduler checks hourly for users due a digest, sending
+// during the digest_hour of the server's timezone
+func (s *Server) startDigestScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !s.getBoolSetting("digest_enabled", false) || !s.digestHourNow(now) {
+					continue
+				}
+				summary, err := s.sendDigests(ctx)
+				if err != nil {
+					log.Printf("Digest run failed: %v", err)
+				} else if summary.Sent+summary.Failed > 0 {
+					log.Printf("Digest run: %d sent, %d skipped, %d failed", summary.Sent, summary.Skipped, summary.Failed)
+				}
+			}
+		}
+	}()
+}
+
+// sendDigests emails a summary to every user who has been away for
+// digest_inactive_days and has not had one in digest_interval_days
+func (s *Server) sendDigests(ctx context.Context) (digestSummary, error) {
+	var summary digestSummary
+	if !digestRun.TryLock() {
+		return summary, fmt.Errorf("a digest run is already in progress")
+	}
+	defer digestRun.Unlock()
+
+	// Connected users are active even if they connected long ago
+	s.clientsMux.RLock()
+	connected := make([]int, 0, len(s.clients))
+	for userID := range s.clients {
+		connected = append(connected, userID)
+	}
+	s.clientsMux.RUnlock()
+	for _, userID := range connected {
+		if s.hub.IsConnected(userID) {
+			s.touchLastSeen(userID)
+		}
+	}
+
+	candidates, err := s.digestCandidates()
+	if err != nil {
+		return summary, err
+	}
+
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		if s.isUserBanned(candidate.ID) {
+			summary.Skipped++
+			continue
+		}
+
+		data, err := s.digestData(candidate)
+		if err != nil {
+			log.Printf("Failed to build digest for user %d: %v", candidate.ID, err)
+			summary.Failed++
+			continue
+		}
+		if data == nil {
+			// Nothing happened; don't send an empty email
+			summary.Skipped++
+			continue
+		}
+
+		if err := s.sendEmail(ctx, candidate.ID, candidate.Email, mail.TemplateDigest, data); err != nil {
+			summary.Failed++
+			continue
+		}
+		if _, err := s.db.Exec("UPDATE users SET digest_sent_at = CURRENT_TIMESTAMP WHERE id = ?", candidate.ID); err != nil {
+			log.Printf("Failed to record digest for user %d: %v", candidate.ID, err)
+		}
+		summary.Sent++
+	}
+	return summary, nil
+}
+
+// digestCandidates returns users with an email address who opted in, have
+// been inactive long enough and have not had a digest recently
+func (s *Server) digestCandidates() ([]digestCandidate, error) {
+	inactiveDays := s.getIntSetting("digest_inactive_days", 7)
+	intervalDays := s.getIntSetting("digest_interval_days", 7)
+
+	rows, err := s.db.Query(`
+		SELECT id, username, email,
+			MAX(COALESCE(digest_sent_at, ''), COALESCE(last_seen_at, ''), datetime('now', ?))
+		FROM users
+		WHERE email IS NOT NULL AND email != '' AND digest_opt_out = 0
+			AND COALESCE(last_seen_at, created_at) < datetime('now', ?)
+			AND (digest_sent_at IS NULL OR digest_sent_at < datetime('now', ?))
+		ORDER BY id`,
+		fmt.Sprintf("-%d days", intervalDays),
+		fmt.Sprintf("-%d days", inactiveDays),
+		fmt.Sprintf("-%d days", intervalDays),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	candidates := make([]digestCandidate, 0)
+	for rows.Next() {
+		var candidate digestCandidate
+		if err := rows.Scan(&candidate.ID, &candidate.Username, &candidate.Email, &candidate.Since); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// digestEvents lists the latest queued events of one type since the
+// candidate's last visit or digest
+func (s *Server) digestEvents(candidate digestCandidate, eventType string) ([]map[string]string, error) {
+	events := make([]map[string]string, 0)
+	rows, err := s.db.Query(`
+		SELECT u.username, c.name, m.content
+		FROM offline_events oe
+		JOIN messages m ON m.id = oe.message_id
+		JOIN users u ON u.id = m.user_id
+		JOIN channels c ON c.id = m.channel_id
+		WHERE oe.user_id = ? AND oe.event_type = ? AND m.created_at > ?
+		ORDER BY m.id DESC LIMIT 5`,
+		candidate.ID, eventType, candidate.Since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var author, channel, content string
+		if err := rows.Scan(&author, &channel, &content); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		events = append(events, map[string]string{"Author": author, "Channel": channel, "Excerpt": excerpt(content, 140)})
+	}
+	return events, rows.Close()
+}
+
+// digestData collects mentions, replies in followed threads and server
+// activity since the candidate's last visit or digest. It returns nil when
+// there is nothing to report.
+func (s *Server) digestData(candidate digestCandidate) (map[string]interface{}, error) {
+	mentions, err := s.digestEvents(candidate, offlineEventMention)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := s.digestEvents(candidate, offlineEventThreadReply)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages by others, per server and per channel
+	rows, err := s.db.Query(`
+		SELECT s.name, c.name, COUNT(m.id)
+		FROM server_members sm
+		JOIN servers s ON s.id = sm.server_id
+		JOIN channels c ON c.server_id = s.id
+		JOIN messages m ON m.channel_id = c.id
+		WHERE sm.user_id = ? AND m.user_id != ? AND m.created_at > ? AND `+channelVisibleSQL+`
+		GROUP BY c.id
+		ORDER BY COUNT(m.id) DESC`,
+		candidate.ID, candidate.ID, candidate.Since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	unread := 0
+	serverOrder := make([]string, 0)
+	serverCounts := make(map[string]int)
+	highlights := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var serverName, channelName string
+		var count int
+		if err := rows.Scan(&serverName, &channelName, &count); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		unread += count
+		if _, ok := serverCounts[serverName]; !ok {
+			serverOrder = append(serverOrder, serverName)
+		}
+		serverCounts[serverName] += count
+		if len(highlights) < 3 {
+			highlights = append(highlights, map[string]interface{}{"Server": serverName, "Channel": channelName, "Messages": count})
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if unread == 0 && len(mentions) == 0 && len(replies) == 0 {
+		return nil, nil
+	}
+
+	servers := make([]map[string]interface{}, 0, len(serverOrder))
+	for _, name := range serverOrder {
+		servers = append(servers, map[string]interface{}{"Name": name, "Messages": serverCounts[name]})
+	}
+	if len(servers) > 5 {
+		servers = servers[:5]
+	}
+	unsubscribeURL, err := s.digestUnsubscribeURL(candidate.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"Username":       candidate.Username,
+		"Mentions":       mentions,
+		"Replies":        replies,
+		"Unread":         unread,
+		"Highlights":     highlights,
+		"Servers":        servers,
+		"UnsubscribeURL": unsubscribeURL,
+	}, nil
+}
+
+// excerpt shortens text to at most limit characters
+func excerpt(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit-1]) + "…"
+}
+
+// digestUnsubscribeURL returns a link that opts the user out of digests
+// without logging in. It carries a token kept with the user rather than a
+// signature, since signing keys are dropped soon after a rotation and the
+// link has to keep working for as long as the email is kept.
+func (s *Server) digestUnsubscribeURL(userID int) (string, error) {
+	token, err := s.digestUnsubscribeToken(userID)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("user", strconv.Itoa(userID))
+	query.Set("token", token)
+	return s.mailer.URL("/api/digest/unsubscribe?" + query.Encode()), nil
+}
+
+// digestUnsubscribeToken returns a user's unsubscribe token, creating it
+// the first time a digest is sent to them
+func (s *Server) digestUnsubscribeToken(userID int) (string, error) {
+	token, err := s.auth.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.db.Exec(
+		"UPDATE users SET digest_unsubscribe_token = ? WHERE id = ? AND digest_unsubscribe_token = ''", token, userID,
+	); err != nil {
+		return "", err
+	}
+	err = s.db.QueryRow("SELECT digest_unsubscribe_token FROM users WHERE id = ?", userID).Scan(&token)
+	return token, err
+}
+
+// digestUnsubscribeAllowed checks an unsubscribe link. Links sent before
+// tokens were kept are signed instead, and work while their key is active.
+func (s *Server) digestUnsubscribeAllowed(c *gin.Context, userID int) bool {
+	if token := c.Query("token"); token != "" {
+		var expected string
+		err := s.db.QueryRow("SELECT digest_unsubscribe_token FROM users WHERE id = ?", userID).Scan(&expected)
+		return err == nil && expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+	}
+	return s.auth.VerifySignature(digestUnsubscribePurpose, strconv.Itoa(userID), c.Query("sig"))
+}
+
+// handleDigestUnsubscribe opts a user out through an email link
+func (s *Server) handleDigestUnsubscribe(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user"))
+	if err != nil || !s.digestUnsubscribeAllowed(c, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid unsubscribe link"})
+		return
+	}
+
+	if _, err := s.db.Exec("UPDATE users SET digest_opt_out = 1 WHERE id = ?", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "You will no longer receive digest emails",
+	})
+}
+
+// handleGetDigestPreference returns whether the user receives digests
+func (s *Server) handleGetDigestPreference(c *gin.Context) {
+	var optOut bool
+	if err := s.db.QueryRow("SELECT digest_opt_out FROM users WHERE id = ?", c.GetInt("user_id")).Scan(&optOut); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get digest preference"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"enabled":        !optOut,
+			"server_enabled": s.getBoolSetting("digest_enabled", false),
+		},
+	})
+}
+
+// handleUpdateDigestPreference opts the user in or out of digests
+func (s *Server) handleUpdateDigestPreference(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := s.db.Exec("UPDATE users SET digest_opt_out = ? WHERE id = ?", !*req.Enabled, c.GetInt("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update digest preference"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"enabled": *req.Enabled},
+	})
+}
+
+// handleRunDigests sends due digests now instead of waiting for the
+// hourly check
+func (s *Server) handleRunDigests(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+	if !s.getBoolSetting("digest_enabled", false) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Digest emails are disabled"})
+		return
+	}
+
+	go func() {
+		summary, err := s.sendDigests(context.Background())
+		if err != nil {
+			log.Printf("Manual digest run failed: %v", err)
+			return
+		}
+		log.Printf("Manual digest run: %d sent, %d skipped, %d failed", summary.Sent, summary.Skipped, summary.Failed)
+	}()
+
+	s.logAdminAction(adminID, "run_digests", "Started sending digest emails")
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Sending digest emails",
+	})
+}
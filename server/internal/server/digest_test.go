@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+	"fethur/internal/mail"
+	"fethur/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingMail keeps sent emails in memory
+type recordingMail struct {
+	sent []mail.Message
+}
+
+func (p *recordingMail) Name() string { return "recording" }
+
+func (p *recordingMail) Send(ctx context.Context, from string, message mail.Message) error {
+	p.sent = append(p.sent, message)
+	return nil
+}
+
+func TestDigests(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	provider := &recordingMail{}
+	mailer, err := mail.New(provider, mail.Config{From: "noreply@example.com", BaseURL: "https://chat.example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+	s.mailer = mailer
+
+	suffix := time.Now().UnixNano()
+	createUser := func(name, email, lastSeen string) int {
+		result, err := db.Exec(
+			"INSERT INTO users (username, email, password_hash, last_seen_at) VALUES (?, ?, 'x', datetime('now', ?))",
+			fmt.Sprintf("%s_%d", name, suffix), email, lastSeen,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		return int(id)
+	}
+	away := createUser("away", fmt.Sprintf("away%d@example.com", suffix), "-10 days")
+	active := createUser("active", fmt.Sprintf("active%d@example.com", suffix), "-1 minutes")
+	quiet := createUser("quiet", "", "-10 days")
+	optedOut := createUser("optedout", fmt.Sprintf("optedout%d@example.com", suffix), "-10 days")
+	if _, err := db.Exec("UPDATE users SET digest_opt_out = 1 WHERE id = ?", optedOut); err != nil {
+		t.Fatalf("Failed to opt out: %v", err)
+	}
+
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Homelab %d", suffix), active)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for _, userID := range []int{away, active, quiet, optedOut} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (name, server_id) VALUES ('general', ?)", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+
+	for i := 0; i < 3; i++ {
+		result, err := db.Exec("INSERT INTO messages (content, user_id, channel_id) VALUES (?, ?, ?)",
+			fmt.Sprintf("hey @away_%d number %d", suffix, i), active, channelID)
+		if err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		messageID, _ := result.LastInsertId()
+		if i == 0 {
+			s.queueOfflineEvent(away, offlineEventMention, int(channelID), messageID)
+		}
+	}
+
+	summary, err := s.sendDigests(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to send digests: %v", err)
+	}
+	if summary.Sent < 1 {
+		t.Fatalf("Expected a digest to be sent, got %+v", summary)
+	}
+
+	var digest *mail.Message
+	for i, message := range provider.sent {
+		switch message.To {
+		case fmt.Sprintf("away%d@example.com", suffix):
+			digest = &provider.sent[i]
+		case fmt.Sprintf("active%d@example.com", suffix), fmt.Sprintf("optedout%d@example.com", suffix):
+			t.Errorf("Unexpected digest to %s", message.To)
+		}
+	}
+	if digest == nil {
+		t.Fatal("Expected a digest for the inactive user")
+	}
+	if !strings.Contains(digest.Text, "3 new messages") || !strings.Contains(digest.Text, "number 0") {
+		t.Errorf("Digest is missing activity or mentions:\n%s", digest.Text)
+	}
+
+	// Digests are not repeated within the interval
+	before := len(provider.sent)
+	if _, err := s.sendDigests(context.Background()); err != nil {
+		t.Fatalf("Failed to send digests: %v", err)
+	}
+	for _, message := range provider.sent[before:] {
+		if message.To == digest.To {
+			t.Error("Expected no second digest within the interval")
+		}
+	}
+
+	// The unsubscribe link works without logging in, and only with the
+	// user's own token
+	unsubscribeURL, err := s.digestUnsubscribeURL(away)
+	if err != nil {
+		t.Fatalf("Failed to create unsubscribe URL: %v", err)
+	}
+	link, err := url.Parse(unsubscribeURL)
+	if err != nil {
+		t.Fatalf("Failed to parse unsubscribe URL: %v", err)
+	}
+	unsubscribe := func(query string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/digest/unsubscribe?"+query, nil)
+		s.handleDigestUnsubscribe(c)
+		return w.Code
+	}
+	if code := unsubscribe(fmt.Sprintf("user=%d&token=%s", active, link.Query().Get("token"))); code != http.StatusForbidden {
+		t.Errorf("Expected a token for another user to be rejected, got %d", code)
+	}
+	if again, err := s.digestUnsubscribeURL(away); err != nil || again != unsubscribeURL {
+		t.Errorf("Expected every digest to carry the same link, got %s (%v)", again, err)
+	}
+	if code := unsubscribe(link.RawQuery); code != http.StatusOK {
+		t.Errorf("Expected unsubscribe to succeed, got %d", code)
+	}
+	var optOut bool
+	if err := db.QueryRow("SELECT digest_opt_out FROM users WHERE id = ?", away).Scan(&optOut); err != nil || !optOut {
+		t.Errorf("Expected user to be opted out (%v)", err)
+	}
+}
+
+func TestDigestUnsubscribeAfterKeyRotation(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	path := filepath.Join(t.TempDir(), "signing-keys.json")
+	keys, err := auth.LoadKeyStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load signing keys: %v", err)
+	}
+	withKeys := func(keys *auth.KeyStore) *auth.Service {
+		options := auth.DefaultOptions()
+		options.Keys = keys
+		return auth.NewServiceWithOptions(options)
+	}
+	mailer, err := mail.New(&recordingMail{}, mail.Config{From: "noreply@example.com", BaseURL: "https://chat.example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+	s.auth = withKeys(keys)
+	s.mailer = mailer
+	s.services = service.New(s.db, s.auth)
+
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, ?, 'x')",
+		fmt.Sprintf("rotated_%d", time.Now().UnixNano()), "rotated@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	userID := int(id)
+	unsubscribeURL, err := s.digestUnsubscribeURL(userID)
+	if err != nil {
+		t.Fatalf("Failed to create unsubscribe URL: %v", err)
+	}
+	link, err := url.Parse(unsubscribeURL)
+	if err != nil {
+		t.Fatalf("Failed to parse unsubscribe URL: %v", err)
+	}
+	signed := s.auth.Sign(digestUnsubscribePurpose, strconv.Itoa(userID))
+
+	// Rotate, then age the retired key past its retention so it is dropped
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate keys: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read signing keys: %v", err)
+	}
+	var file struct {
+		Keys []auth.SigningKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Failed to parse signing keys: %v", err)
+	}
+	longAgo := time.Now().Add(-30 * 24 * time.Hour)
+	for i := range file.Keys {
+		if file.Keys[i].RetiredAt != nil {
+			file.Keys[i].RetiredAt = &longAgo
+		}
+	}
+	data, _ = json.Marshal(file)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write signing keys: %v", err)
+	}
+	if keys, err = auth.LoadKeyStore(path); err != nil {
+		t.Fatalf("Failed to reload signing keys: %v", err)
+	}
+	s.auth = withKeys(keys)
+	if s.auth.VerifySignature(digestUnsubscribePurpose, strconv.Itoa(userID), signed) {
+		t.Fatal("Expected the dropped key's signatures to be refused")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/digest/unsubscribe?"+link.RawQuery, nil)
+	s.handleDigestUnsubscribe(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the link to outlive the key it was sent under, got %d: %s", w.Code, w.Body.String())
+	}
+	var optOut bool
+	if err := db.QueryRow("SELECT digest_opt_out FROM users WHERE id = ?", userID).Scan(&optOut); err != nil || !optOut {
+		t.Errorf("Expected user to be opted out (%v)", err)
+	}
+}
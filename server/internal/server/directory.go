@@ -0,0 +1,568 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server directory. With server_directory_enabled set, owners and admins
+// can list a server in the directory of its organization, which anyone can
+// browse without signing in. Listings carry only what the server chose to
+// publish: name, description, tags, member count and public channels.
+// Signed-in users of the organization join a listed server from the
+// directory, so a listing works as a standing invite; in raid mode joining
+// files a join request instead. Users report listings
+// to admins; enough open reports hide a listing until an admin reviews it.
+
+const (
+	maxDirectoryTags         = 5
+	maxDirectoryPageSize     = 50
+	maxDirectoryReportLength = 1000
+
+	// directoryReportThreshold open reports hide a listing
+	directoryReportThreshold = 3
+)
+
+var directoryTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,23}$`)
+
+// directoryEntry is a listing as the public directory shows it
+type directoryEntry struct {
+	ServerID       int                `json:"server_id"`
+	Name           string             `json:"name"`
+	Description    string             `json:"description"`
+	Tags           []string           `json:"tags"`
+	MemberCount    int                `json:"member_count"`
+	Featured       bool               `json:"featured"`
+	PublicChannels []directoryChannel `json:"public_channels"`
+	ListedAt       string             `json:"listed_at"`
+}
+
+type directoryChannel struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// directoryVisible is the condition for a listing to appear in the
+// directory and be joinable from it
+var directoryVisible = fmt.Sprintf(`d.listed = 1 AND d.hidden = 0 AND (
+	SELECT COUNT(*) FROM server_directory_reports r WHERE r.server_id = d.server_id AND r.resolved_at IS NULL
+) < %d`, directoryReportThreshold)
+
+func (s *Server) directoryEnabled() bool {
+	return s.getBoolSetting("server_directory_enabled", false)
+}
+
+// normalizeDirectoryTags lowercases, deduplicates and validates tags
+func normalizeDirectoryTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !directoryTagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tags must be 1-24 letters, digits or dashes")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxDirectoryTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxDirectoryTags)
+	}
+	return normalized, nil
+}
+
+// handleGetDirectory searches the directory of the organization the request
+// is addressed to. q matches names and descriptions, tag filters by tag.
+// Featured listings come first, then the largest servers.
+func (s *Server) handleGetDirectory(c *gin.Context) {
+	if !s.directoryEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The server directory is disabled"})
+		return
+	}
+	orgID, ok := s.requestOrgID(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxDirectoryPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDirectoryPageSize)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	tag := strings.ToLower(strings.TrimSpace(c.Query("tag")))
+
+	reader := s.reader(c)
+	filter := `FROM server_directory d JOIN servers sv ON sv.id = d.server_id
+		WHERE sv.org_id = ? AND ` + directoryVisible + `
+		AND (? = '' OR instr(lower(sv.name), ?) > 0 OR instr(lower(COALESCE(sv.description, '')), ?) > 0)
+		AND (? = '' OR EXISTS(SELECT 1 FROM server_directory_tags t WHERE t.server_id = d.server_id AND t.tag = ?))`
+	args := []interface{}{orgID, query, query, query, tag, tag}
+
+	var total int
+	if err := reader.QueryRow("SELECT COUNT(*) "+filter, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count directory listings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory"})
+		return
+	}
+	rows, err := reader.Query(`
+		SELECT d.server_id, sv.name, COALESCE(sv.description, ''), d.featured, d.listed_at,
+			(SELECT COUNT(*) FROM server_members m WHERE m.server_id = d.server_id) AS members
+		`+filter+`
+		ORDER BY d.featured DESC, members DESC, d.server_id
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...,
+	)
+	if err != nil {
+		log.Printf("Failed to get directory listings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory"})
+		return
+	}
+	entries := make([]directoryEntry, 0)
+	for rows.Next() {
+		var e directoryEntry
+		if err := rows.Scan(&e.ServerID, &e.Name, &e.Description, &e.Featured, &e.ListedAt, &e.MemberCount); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+	for i := range entries {
+		entries[i].Tags = s.directoryTags(reader, entries[i].ServerID)
+		entries[i].PublicChannels = s.directoryChannels(reader, entries[i].ServerID)
+	}
+
+	c.Header("Cache-Control", "public, max-age=60")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"servers": entries,
+			"total":   total,
+		},
+	})
+}
+
+func (s *Server) directoryTags(reader *sql.DB, serverID int) []string {
+	tags := make([]string, 0)
+	rows, err := reader.Query("SELECT tag FROM server_directory_tags WHERE server_id = ? ORDER BY tag", serverID)
+	if err != nil {
+		log.Printf("Failed to get directory tags of server %d: %v", serverID, err)
+		return tags
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// directoryChannels lists a server's public channels, which have read-only
+// views under /public/channels
+func (s *Server) directoryChannels(reader *sql.DB, serverID int) []directoryChannel {
+	channels := make([]directoryChannel, 0)
+	rows, err := reader.Query(`
+		SELECT id, name FROM channels
+		WHERE server_id = ? AND public = 1 AND private = 0 AND nsfw = 0 AND channel_type = 'text'
+		ORDER BY id`, serverID)
+	if err != nil {
+		log.Printf("Failed to get public channels of server %d: %v", serverID, err)
+		return channels
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ch directoryChannel
+		if err := rows.Scan(&ch.ID, &ch.Name); err == nil {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+// directoryServerID reads the listed server from the URL. It writes a 404
+// unless the listing is visible to the user's organization.
+func (s *Server) directoryServerID(c *gin.Context) (int, bool) {
+	if !s.directoryEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The server directory is disabled"})
+		return 0, false
+	}
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return 0, false
+	}
+	var visible bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM server_directory d JOIN servers sv ON sv.id = d.server_id
+			WHERE d.server_id = ? AND sv.org_id = ? AND `+directoryVisible+`
+		)`, serverID, c.GetInt("org_id"),
+	).Scan(&visible)
+	if err != nil || !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return 0, false
+	}
+	return serverID, true
+}
+
+// handleJoinDirectoryServer adds the user to a server listed in the
+// directory
+func (s *Server) handleJoinDirectoryServer(c *gin.Context) {
+	serverID, ok := s.directoryServerID(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("user_id")
+	if s.isUserBanned(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is banned"})
+		return
+	}
+	if s.serverSanctioned(serverID, userID, "ban") {
+		c.JSON(http.StatusForbidden, gin.H{"error": errServerBanned.Error()})
+		return
+	}
+	if s.loadRaidSettings(serverID).Active {
+		s.requestToJoin(c, serverID, userID)
+		return
+	}
+
+	added, err := s.addServerMember(serverID, userID, "member")
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		respondQuotaExceeded(c, exceeded)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to join server %d from the directory: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join server"})
+		return
+	}
+	if !added {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already a member"})
+		return
+	}
+	s.markWrite(userID)
+	s.recordDirectoryJoin(serverID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    gin.H{"server_id": serverID, "role": "member"},
+	})
+}
+
+// handleReportDirectoryServer reports a listing to the admins
+func (s *Server) handleReportDirectoryServer(c *gin.Context) {
+	serverID, ok := s.directoryServerID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || utf8.RuneCountInString(req.Reason) > maxDirectoryReportLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be 1-%d characters", maxDirectoryReportLength)})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	result, err := s.db.Exec(`
+		INSERT INTO server_directory_reports (server_id, reporter_id, reason)
+		SELECT ?, ?, ? WHERE NOT EXISTS(
+			SELECT 1 FROM server_directory_reports
+			WHERE server_id = ? AND reporter_id = ? AND resolved_at IS NULL
+		)`, serverID, userID, req.Reason, serverID, userID,
+	)
+	if err != nil {
+		log.Printf("Failed to report server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report server"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reported this server"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	s.markWrite(userID)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": gin.H{"id": id}})
+}
+
+// serverListing is a server's directory listing as its owners and admins
+// see it
+func (s *Server) serverListing(serverID int) (gin.H, error) {
+	var listed, featured, hidden bool
+	err := s.db.QueryRow(
+		"SELECT listed, featured, hidden FROM server_directory WHERE server_id = ?", serverID,
+	).Scan(&listed, &featured, &hidden)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return gin.H{
+		"listed":   listed,
+		"featured": featured,
+		"hidden":   hidden,
+		"tags":     s.directoryTags(s.db.DB, serverID),
+	}, nil
+}
+
+// handleGetServerListing shows a server's directory listing to its owners
+// and admins
+func (s *Server) handleGetServerListing(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	listing, err := s.serverListing(serverID)
+	if err != nil {
+		log.Printf("Failed to get directory listing of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory listing"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": listing})
+}
+
+// handleUpdateServerListing lists or unlists a server in the directory and
+// sets its tags
+func (s *Server) handleUpdateServerListing(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	if !s.directoryEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The server directory is disabled"})
+		return
+	}
+	var req struct {
+		Listed *bool    `json:"listed" binding:"required"`
+		Tags   []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := normalizeDirectoryTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update directory listing"})
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	// Listing again moves the server to the top of new listings
+	if _, err := tx.Exec(`
+		INSERT INTO server_directory (server_id, listed) VALUES (?, ?)
+		ON CONFLICT (server_id) DO UPDATE SET listed = excluded.listed,
+			listed_at = CASE WHEN excluded.listed = 1 AND server_directory.listed = 0 THEN CURRENT_TIMESTAMP ELSE server_directory.listed_at END`,
+		serverID, *req.Listed,
+	); err != nil {
+		log.Printf("Failed to update directory listing of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update directory listing"})
+		return
+	}
+	if req.Tags != nil {
+		if _, err := tx.Exec("DELETE FROM server_directory_tags WHERE server_id = ?", serverID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update directory listing"})
+			return
+		}
+		for _, tag := range tags {
+			if _, err := tx.Exec("INSERT INTO server_directory_tags (server_id, tag) VALUES (?, ?)", serverID, tag); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update directory listing"})
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update directory listing"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	listing, err := s.serverListing(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory listing"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": listing})
+}
+
+// handleGetDirectoryListings lists every listing of the admin's
+// organization for curation, hidden ones included, most reported first
+func (s *Server) handleGetDirectoryListings(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT d.server_id, sv.name, d.listed, d.featured, d.hidden, d.listed_at,
+			(SELECT COUNT(*) FROM server_directory_reports r WHERE r.server_id = d.server_id AND r.resolved_at IS NULL) AS reports
+		FROM server_directory d JOIN servers sv ON sv.id = d.server_id
+		WHERE sv.org_id = ?
+		ORDER BY reports DESC, d.listed_at DESC`, c.GetInt("org_id"),
+	)
+	if err != nil {
+		log.Printf("Failed to get directory listings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory listings"})
+		return
+	}
+	defer rows.Close()
+
+	listings := make([]gin.H, 0)
+	for rows.Next() {
+		var serverID, reports int
+		var name, listedAt string
+		var listed, featured, hidden bool
+		if err := rows.Scan(&serverID, &name, &listed, &featured, &hidden, &listedAt, &reports); err != nil {
+			continue
+		}
+		listings = append(listings, gin.H{
+			"server_id":    serverID,
+			"name":         name,
+			"listed":       listed,
+			"featured":     featured,
+			"hidden":       hidden,
+			"open_reports": reports,
+			"visible":      listed && !hidden && reports < directoryReportThreshold,
+			"listed_at":    listedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": listings})
+}
+
+// adminListingServerID reads a listed server of the admin's organization
+// from the URL
+func (s *Server) adminListingServerID(c *gin.Context) (int, bool) {
+	serverID, err := strconv.Atoi(c.Param("serverId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return 0, false
+	}
+	var exists bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM server_directory d JOIN servers sv ON sv.id = d.server_id
+			WHERE d.server_id = ? AND sv.org_id = ?
+		)`, serverID, c.GetInt("org_id"),
+	).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Listing not found"})
+		return 0, false
+	}
+	return serverID, true
+}
+
+// handleGetDirectoryReports lists the reports of a listing, open ones first
+func (s *Server) handleGetDirectoryReports(c *gin.Context) {
+	serverID, ok := s.adminListingServerID(c)
+	if !ok {
+		return
+	}
+	rows, err := s.db.Query(`
+		SELECT r.id, r.reporter_id, u.username, r.reason, r.created_at, r.resolved_at
+		FROM server_directory_reports r JOIN users u ON u.id = r.reporter_id
+		WHERE r.server_id = ?
+		ORDER BY r.resolved_at IS NOT NULL, r.id DESC`, serverID,
+	)
+	if err != nil {
+		log.Printf("Failed to get directory reports of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reports"})
+		return
+	}
+	defer rows.Close()
+
+	reports := make([]gin.H, 0)
+	for rows.Next() {
+		var id, reporterID int
+		var username, reason, createdAt string
+		var resolvedAt sql.NullString
+		if err := rows.Scan(&id, &reporterID, &username, &reason, &createdAt, &resolvedAt); err != nil {
+			continue
+		}
+		var resolved interface{}
+		if resolvedAt.Valid {
+			resolved = resolvedAt.String
+		}
+		reports = append(reports, gin.H{
+			"id":          id,
+			"reporter_id": reporterID,
+			"reporter":    username,
+			"reason":      reason,
+			"created_at":  createdAt,
+			"resolved_at": resolved,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": reports})
+}
+
+// handleCurateDirectoryListing features or hides a listing and resolves
+// its open reports
+func (s *Server) handleCurateDirectoryListing(c *gin.Context) {
+	serverID, ok := s.adminListingServerID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Featured       *bool `json:"featured"`
+		Hidden         *bool `json:"hidden"`
+		ResolveReports bool  `json:"resolve_reports"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt("user_id")
+	if _, err := s.db.Exec(`
+		UPDATE server_directory SET featured = COALESCE(?, featured), hidden = COALESCE(?, hidden)
+		WHERE server_id = ?`, req.Featured, req.Hidden, serverID,
+	); err != nil {
+		log.Printf("Failed to curate directory listing of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update listing"})
+		return
+	}
+	var resolved int64
+	if req.ResolveReports {
+		result, err := s.db.Exec(`
+			UPDATE server_directory_reports SET resolved_at = CURRENT_TIMESTAMP, resolved_by = ?
+			WHERE server_id = ? AND resolved_at IS NULL`, adminID, serverID,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve reports"})
+			return
+		}
+		resolved, _ = result.RowsAffected()
+	}
+	s.markWrite(adminID)
+
+	listing, err := s.serverListing(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get directory listing"})
+		return
+	}
+	s.logAdminAction(adminID, "curate_directory_listing", fmt.Sprintf(
+		"Server %d: featured=%t hidden=%t, resolved %d reports", serverID, listing["featured"], listing["hidden"], resolved,
+	))
+	listing["resolved_reports"] = resolved
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": listing})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerDirectory(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	defer func() { _ = db.SetSetting("server_directory_enabled", "false", "") }()
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "joiner", "r1", "r2", "r3"} {
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, ?, 'x')",
+			fmt.Sprintf("dir%s_%d", name, suffix), fmt.Sprintf("dir%s_%d@example.com", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[name] = int(id)
+	}
+	// A unique word keeps other tests' servers out of the search
+	word := fmt.Sprintf("homelab%d", suffix)
+	result, err := db.Exec("INSERT INTO servers (name, description, owner_id) VALUES (?, 'Self-hosting help', ?)", "The "+word, users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", users["owner"], serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO channels (server_id, name, channel_type, public) VALUES (?, 'announcements', 'text', 1)", serverID); err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/directory", s.handleGetDirectory)
+	protected := router.Group("/", func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+		c.Set("org_id", 0)
+	})
+	protected.PUT("/servers/:id/directory", s.handleUpdateServerListing)
+	protected.POST("/directory/:id/join", s.handleJoinDirectoryServer)
+	protected.POST("/directory/:id/report", s.handleReportDirectoryServer)
+	protected.GET("/admin/directory", s.handleGetDirectoryListings)
+	protected.PUT("/admin/directory/:serverId", s.handleCurateDirectoryListing)
+	protected.GET("/admin/directory/:serverId/reports", s.handleGetDirectoryReports)
+	request := func(method, path string, userID int, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", strconv.Itoa(userID))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	search := func(query string) []directoryEntry {
+		t.Helper()
+		w := request("GET", "/directory?"+query, 0, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the directory, got %d: %s", w.Code, w.Body.String())
+		}
+		var body struct {
+			Data struct {
+				Servers []directoryEntry `json:"servers"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return body.Data.Servers
+	}
+	listingPath := fmt.Sprintf("/servers/%d/directory", serverID)
+
+	if w := request("GET", "/directory", 0, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the directory to be off by default, got %d", w.Code)
+	}
+	if err := db.SetSetting("server_directory_enabled", "true", ""); err != nil {
+		t.Fatalf("Failed to enable the directory: %v", err)
+	}
+	if w := request("PUT", listingPath, users["joiner"], `{"listed":true}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected only members to manage the listing, got %d", w.Code)
+	}
+	if w := request("PUT", listingPath, users["owner"], `{"listed":true,"tags":["bad tag"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid tags to be refused, got %d", w.Code)
+	}
+	if w := request("PUT", listingPath, users["owner"], `{"listed":true,"tags":["SelfHosting","linux","linux"]}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the server to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	found := search("q=" + strings.ToUpper(word))
+	if len(found) != 1 {
+		t.Fatalf("Expected the server to be found by name, got %+v", found)
+	}
+	entry := found[0]
+	if entry.MemberCount != 1 || len(entry.Tags) != 2 || entry.Tags[0] != "linux" || len(entry.PublicChannels) != 1 {
+		t.Errorf("Expected member count, tags and public channels, got %+v", entry)
+	}
+	if len(search("q="+word+"&tag=selfhosting")) != 1 || len(search("q="+word+"&tag=gaming")) != 0 {
+		t.Error("Expected tags to filter listings")
+	}
+
+	joinPath := fmt.Sprintf("/directory/%d/join", serverID)
+	if w := request("POST", joinPath, users["joiner"], ""); w.Code != http.StatusCreated {
+		t.Fatalf("Expected to join from the directory, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", joinPath, users["joiner"], ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected a second join to conflict, got %d", w.Code)
+	}
+	if found := search("q=" + word); len(found) != 1 || found[0].MemberCount != 2 {
+		t.Errorf("Expected the member count to include the new member, got %+v", found)
+	}
+
+	// Enough open reports hide the listing until an admin reviews it
+	reportPath := fmt.Sprintf("/directory/%d/report", serverID)
+	for _, reporter := range []string{"r1", "r2", "r3"} {
+		if w := request("POST", reportPath, users[reporter], `{"reason":"spam"}`); w.Code != http.StatusCreated {
+			t.Fatalf("Expected the report to be filed, got %d: %s", w.Code, w.Body.String())
+		}
+		if reporter == "r1" {
+			if w := request("POST", reportPath, users[reporter], `{"reason":"spam"}`); w.Code != http.StatusConflict {
+				t.Errorf("Expected one open report per user, got %d", w.Code)
+			}
+		}
+	}
+	if len(search("q="+word)) != 0 {
+		t.Error("Expected the reported listing to be hidden")
+	}
+	if w := request("POST", joinPath, users["r1"], ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected hidden listings not to be joinable, got %d", w.Code)
+	}
+	w := request("GET", fmt.Sprintf("/admin/directory/%d/reports", serverID), users["owner"], "")
+	if !strings.Contains(w.Body.String(), `"reason":"spam"`) {
+		t.Errorf("Expected admins to see the reports, got %s", w.Body.String())
+	}
+
+	adminPath := fmt.Sprintf("/admin/directory/%d", serverID)
+	if w := request("PUT", adminPath, users["owner"], `{"featured":true,"resolve_reports":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the listing to be curated, got %d: %s", w.Code, w.Body.String())
+	}
+	if found := search("q=" + word); len(found) != 1 || !found[0].Featured {
+		t.Errorf("Expected the reviewed listing back and featured, got %+v", found)
+	}
+	request("PUT", adminPath, users["owner"], `{"hidden":true}`)
+	// Listing again does not undo an admin's hide
+	request("PUT", listingPath, users["owner"], `{"listed":false}`)
+	request("PUT", listingPath, users["owner"], `{"listed":true}`)
+	if len(search("q="+word)) != 0 {
+		t.Error("Expected the hidden listing to stay hidden")
+	}
+}
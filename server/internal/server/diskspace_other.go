@@ -0,0 +1,11 @@
+//go:build !unix
+
+package server
+
+import "errors"
+
+// diskSpace is not implemented on this platform, so disk alert rules
+// never fire
+func diskSpace(string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
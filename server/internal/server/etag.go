@@ -0,0 +1,130 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versioned resources. Each write to a resource bumps its version so that
+// polling clients can revalidate with If-None-Match / If-Modified-Since.
+func channelsResource(serverID interface{}) string {
+	return fmt.Sprintf("server:%v:channels", serverID)
+}
+
+func membersResource(serverID interface{}) string {
+	return fmt.Sprintf("server:%v:members", serverID)
+}
+
+func messagesResource(channelID interface{}) string {
+	return fmt.Sprintf("channel:%v:messages", channelID)
+}
+
+// bumpResourceVersion records a write to a resource
+func (s *Server) bumpResourceVersion(resource string) {
+	_, err := s.db.Exec(`
+		INSERT INTO resource_versions (resource, version, updated_at) VALUES (?, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT(resource) DO UPDATE SET version = version + 1, updated_at = CURRENT_TIMESTAMP`,
+		resource,
+	)
+	if err != nil {
+		log.Printf("Failed to bump version for %s: %v", resource, err)
+	}
+}
+
+// bumpUserMemberships bumps the member lists of every server a user belongs to
+func (s *Server) bumpUserMemberships(userID interface{}) {
+	rows, err := s.db.Query("SELECT server_id FROM server_members WHERE user_id = ?", userID)
+	if err != nil {
+		log.Printf("Failed to load memberships for user %v: %v", userID, err)
+		return
+	}
+
+	var serverIDs []int
+	for rows.Next() {
+		var serverID int
+		if err := rows.Scan(&serverID); err == nil {
+			serverIDs = append(serverIDs, serverID)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	for _, serverID := range serverIDs {
+		s.bumpResourceVersion(membersResource(serverID))
+	}
+}
+
+// resourceVersion returns a resource's current version and last write time.
+// Resources never written since versioning was added report version 0.
+func (s *Server) resourceVersion(reader *sql.DB, resource string) (int64, time.Time) {
+	var version int64
+	var updatedAt time.Time
+	err := reader.QueryRow(
+		"SELECT version, updated_at FROM resource_versions WHERE resource = ?",
+		resource,
+	).Scan(&version, &updatedAt)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	return version, updatedAt
+}
+
+// resourceETag builds a weak ETag for a resource version. Extra parts are
+// folded in for state not covered by the version, such as presence.
+func resourceETag(resource string, version int64, extra ...string) string {
+	tag := fmt.Sprintf("%s:v%d", resource, version)
+	for _, part := range extra {
+		tag += ":" + part
+	}
+	return fmt.Sprintf(`W/"%s"`, tag)
+}
+
+// checkNotModified sets validator headers and answers 304 Not Modified when
+// the client's cached copy is current. Handlers return early when it does.
+// A zero lastModified leaves out Last-Modified, for responses carrying state
+// the write time does not cover; only the ETag validates those.
+func checkNotModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if etagMatches(match, etag) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatches compares an If-None-Match header against an ETag using weak comparison
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	etag := resourceETag(channelsResource(3), 7)
+	lastModified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no validators", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": etag}, true},
+		{"strong form of weak etag", map[string]string{"If-None-Match": `"server:3:channels:v7"`}, true},
+		{"etag in list", map[string]string{"If-None-Match": `"other", ` + etag}, true},
+		{"stale etag", map[string]string{"If-None-Match": resourceETag(channelsResource(3), 6)}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{"If-Modified-Since": lastModified.Add(-time.Minute).Format(http.TimeFormat)}, false},
+		{"etag wins over date", map[string]string{
+			"If-None-Match":     `"stale"`,
+			"If-Modified-Since": lastModified.Format(http.TimeFormat),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.headers {
+				c.Request.Header.Set(key, value)
+			}
+
+			if got := checkNotModified(c, etag, lastModified); got != tt.want {
+				t.Errorf("checkNotModified() = %v, want %v", got, tt.want)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("Expected ETag header %s, got %s", etag, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	// Without a write time, dates validate nothing
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	if checkNotModified(c, etag, time.Time{}) || w.Header().Get("Last-Modified") != "" {
+		t.Errorf("Expected no Last-Modified and no 304 without a write time, got %q", w.Header().Get("Last-Modified"))
+	}
+}
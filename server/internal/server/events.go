@@ -0,0 +1,561 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fethur/internal/events"
+	"fethur/internal/push"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server event statuses
+const (
+	eventScheduled = "scheduled"
+	eventActive    = "active"
+	eventEnded     = "ended"
+	eventCancelled = "cancelled"
+)
+
+// RSVP statuses
+const (
+	rsvpGoing      = "going"
+	rsvpInterested = "interested"
+)
+
+// eventReminderLead is how long before the start attendees are reminded
+const eventReminderLead = 15 * time.Minute
+
+// defaultEventDuration applies when an event has no end time
+const defaultEventDuration = time.Hour
+
+// maxEventDuration bounds how long an event can run
+const maxEventDuration = 7 * 24 * time.Hour
+
+// serverEvent is a scheduled community event
+type serverEvent struct {
+	ID             int64     `json:"id"`
+	ServerID       int       `json:"server_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	VoiceChannelID *int      `json:"voice_channel_id,omitempty"`
+	StartsAt       time.Time `json:"starts_at"`
+	EndsAt         time.Time `json:"ends_at"`
+	Status         string    `json:"status"`
+	CreatedBy      int       `json:"created_by"`
+	Going          int       `json:"going"`
+	Interested     int       `json:"interested"`
+	MyRSVP         string    `json:"my_rsvp,omitempty"`
+}
+
+// serverEventColumns selects a serverEvent, with RSVP counts and the
+// viewer's RSVP (bind the viewer's user ID first)
+const serverEventColumns = `
+	e.id, e.server_id, e.name, e.description, e.voice_channel_id, e.starts_at, e.ends_at, e.status, e.created_by,
+	(SELECT COUNT(*) FROM server_event_rsvps r WHERE r.event_id = e.id AND r.status = 'going'),
+	(SELECT COUNT(*) FROM server_event_rsvps r WHERE r.event_id = e.id AND r.status = 'interested'),
+	COALESCE((SELECT r.status FROM server_event_rsvps r WHERE r.event_id = e.id AND r.user_id = ?), '')`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanServerEvent(row rowScanner) (*serverEvent, error) {
+	var event serverEvent
+	var voiceChannelID sql.NullInt64
+	if err := row.Scan(&event.ID, &event.ServerID, &event.Name, &event.Description, &voiceChannelID,
+		&event.StartsAt, &event.EndsAt, &event.Status, &event.CreatedBy,
+		&event.Going, &event.Interested, &event.MyRSVP); err != nil {
+		return nil, err
+	}
+	if voiceChannelID.Valid {
+		id := int(voiceChannelID.Int64)
+		event.VoiceChannelID = &id
+	}
+	event.StartsAt = event.StartsAt.UTC()
+	event.EndsAt = event.EndsAt.UTC()
+	return &event, nil
+}
+
+// loadServerEvent loads an event in a server as seen by viewerID
+func (s *Server) loadServerEvent(serverID int, eventID string, viewerID int) (*serverEvent, error) {
+	return scanServerEvent(s.db.QueryRow(
+		"SELECT "+serverEventColumns+" FROM server_events e WHERE e.id = ? AND e.server_id = ?",
+		viewerID, eventID, serverID,
+	))
+}
+
+// notifyServerMembers sends a message to every connected member of a server
+func (s *Server) notifyServerMembers(serverID int, message *websocket.Message) {
+	rows, err := s.db.Query("SELECT user_id FROM server_members WHERE server_id = ?", serverID)
+	if err != nil {
+		log.Printf("Failed to list members of server %d: %v", serverID, err)
+		return
+	}
+	var members []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			members = append(members, userID)
+		}
+	}
+	_ = rows.Close()
+
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	for _, userID := range members {
+		if client, ok := s.clients[userID]; ok {
+			client.Send(message)
+		}
+	}
+}
+
+// broadcastEventLifecycle tells a server's members an event was created,
+// is starting or has ended
+func (s *Server) broadcastEventLifecycle(messageType string, event *serverEvent) {
+	event.MyRSVP = "" // the viewer's RSVP is not the recipients'
+	s.notifyServerMembers(event.ServerID, &websocket.Message{
+		Type:      messageType,
+		Timestamp: time.Now(),
+		Data:      event,
+	})
+}
+
+// startEventScheduler reminds attendees and moves events through their
+// lifecycle every minute
+func (s *Server) startEventScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.advanceServerEvents(time.Now())
+			}
+		}
+	}()
+}
+
+// advanceServerEvents queues due reminders, then starts and ends events
+// whose time has come
+func (s *Server) advanceServerEvents(now time.Time) {
+	remindBefore := now.Add(eventReminderLead).UTC().Format(sqliteTimeLayout)
+	for _, id := range s.serverEventIDs("status = 'scheduled' AND reminded = 0 AND starts_at <= ?", remindBefore) {
+		// Claim the reminder so it is queued once
+		result, err := s.db.Exec("UPDATE server_events SET reminded = 1 WHERE id = ? AND reminded = 0", id)
+		if err != nil {
+			continue
+		}
+		if claimed, _ := result.RowsAffected(); claimed == 0 {
+			continue
+		}
+		eventID := id
+		if err := s.jobs.Enqueue(fmt.Sprintf("event-reminder-%d", eventID), func(ctx context.Context) error {
+			return s.sendEventReminders(eventID)
+		}); err != nil {
+			log.Printf("Failed to queue reminders for event %d: %v", eventID, err)
+		}
+	}
+
+	current := now.UTC().Format(sqliteTimeLayout)
+	for _, id := range s.serverEventIDs("status = 'scheduled' AND starts_at <= ?", current) {
+		s.transitionServerEvent(id, eventScheduled, eventActive, "event_starting")
+	}
+	for _, id := range s.serverEventIDs("status = 'active' AND ends_at <= ?", current) {
+		s.transitionServerEvent(id, eventActive, eventEnded, "event_ended")
+	}
+}
+
+// serverEventIDs returns the IDs of events matching a condition
+func (s *Server) serverEventIDs(condition string, args ...interface{}) []int64 {
+	rows, err := s.db.Query("SELECT id FROM server_events WHERE "+condition+" ORDER BY starts_at", args...)
+	if err != nil {
+		log.Printf("Failed to query server events: %v", err)
+		return nil
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// transitionServerEvent moves an event from one status to another and
+// announces it; it does nothing if the event already moved on
+func (s *Server) transitionServerEvent(eventID int64, from, to, messageType string) bool {
+	result, err := s.db.Exec("UPDATE server_events SET status = ? WHERE id = ? AND status = ?", to, eventID, from)
+	if err != nil {
+		log.Printf("Failed to update event %d: %v", eventID, err)
+		return false
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return false
+	}
+
+	event, err := scanServerEvent(s.db.QueryRow("SELECT "+serverEventColumns+" FROM server_events e WHERE e.id = ?", 0, eventID))
+	if err != nil {
+		log.Printf("Failed to load event %d: %v", eventID, err)
+		return true
+	}
+	s.broadcastEventLifecycle(messageType, event)
+	return true
+}
+
+// sendEventReminders notifies everyone who RSVP'd: connected users over
+// their socket, everyone else by push
+func (s *Server) sendEventReminders(eventID int64) error {
+	event, err := scanServerEvent(s.db.QueryRow("SELECT "+serverEventColumns+" FROM server_events e WHERE e.id = ?", 0, eventID))
+	if err != nil {
+		return err
+	}
+	if event.Status != eventScheduled {
+		return nil
+	}
+
+	rows, err := s.db.Query("SELECT user_id FROM server_event_rsvps WHERE event_id = ?", eventID)
+	if err != nil {
+		return err
+	}
+	var attendees []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			attendees = append(attendees, userID)
+		}
+	}
+	_ = rows.Close()
+
+	body := "Starting now"
+	if minutes := int(time.Until(event.StartsAt).Round(time.Minute).Minutes()); minutes > 0 {
+		body = fmt.Sprintf("Starts in %d minutes, at %s", minutes, s.localClock(event.StartsAt))
+	}
+	for _, userID := range attendees {
+		s.clientsMux.RLock()
+		client, online := s.clients[userID]
+		s.clientsMux.RUnlock()
+		if online && s.hub.IsConnected(userID) {
+			client.Send(&websocket.Message{
+				Type:      string(events.TypeNotification),
+				Timestamp: time.Now(),
+				Data: gin.H{
+					"kind":  "event_reminder",
+					"event": event,
+				},
+			})
+			continue
+		}
+		s.sendPush(userID, push.Notification{
+			Title:       event.Name,
+			Body:        body,
+			CollapseKey: "event-" + strconv.FormatInt(eventID, 10),
+			Badge:       -1,
+			Data: map[string]string{
+				"type":      "event_reminder",
+				"server_id": strconv.Itoa(event.ServerID),
+				"event_id":  strconv.FormatInt(eventID, 10),
+			},
+		})
+	}
+	return nil
+}
+
+// handleGetServerEvents lists a server's scheduled and running events
+func (s *Server) handleGetServerEvents(c *gin.Context) {
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+
+	rows, err := s.db.Query(
+		"SELECT "+serverEventColumns+" FROM server_events e WHERE e.server_id = ? AND e.status IN ('scheduled', 'active') ORDER BY e.starts_at LIMIT 100",
+		c.GetInt("user_id"), serverID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get events"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	events := make([]*serverEvent, 0)
+	for rows.Next() {
+		if event, err := scanServerEvent(rows); err == nil {
+			events = append(events, event)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    events,
+	})
+}
+
+// handleGetServerEvent returns one event with the members who RSVP'd
+func (s *Server) handleGetServerEvent(c *gin.Context) {
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	event, err := s.loadServerEvent(serverID, c.Param("eventId"), c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, r.status
+		FROM server_event_rsvps r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.event_id = ?
+		ORDER BY r.created_at`, event.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get RSVPs"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	rsvps := make([]gin.H, 0)
+	for rows.Next() {
+		var userID int
+		var username, status string
+		if err := rows.Scan(&userID, &username, &status); err == nil {
+			rsvps = append(rsvps, gin.H{"user_id": userID, "username": username, "status": status})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"event": event,
+			"rsvps": rsvps,
+		},
+	})
+}
+
+// handleCreateServerEvent schedules an event; owners and admins only
+func (s *Server) handleCreateServerEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	if role != "owner" && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req struct {
+		Name           string     `json:"name" binding:"required"`
+		Description    string     `json:"description"`
+		StartsAt       time.Time  `json:"starts_at" binding:"required"`
+		EndsAt         *time.Time `json:"ends_at"`
+		VoiceChannelID *int       `json:"voice_channel_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-100 characters"})
+		return
+	}
+	if utf8.RuneCountInString(req.Description) > 2000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "description must be at most 2000 characters"})
+		return
+	}
+	now := time.Now()
+	if !req.StartsAt.After(now) || req.StartsAt.After(now.AddDate(1, 0, 0)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be in the next year"})
+		return
+	}
+	endsAt := req.StartsAt.Add(defaultEventDuration)
+	if req.EndsAt != nil {
+		endsAt = *req.EndsAt
+		if !endsAt.After(req.StartsAt) || endsAt.Sub(req.StartsAt) > maxEventDuration {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at and at most 7 days later"})
+			return
+		}
+	}
+
+	var voiceChannel sql.NullInt64
+	if req.VoiceChannelID != nil {
+		var channelType string
+		err := s.db.QueryRow(
+			"SELECT channel_type FROM channels WHERE id = ? AND server_id = ?", *req.VoiceChannelID, serverID,
+		).Scan(&channelType)
+		if err != nil || channelType != "voice" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "voice_channel_id must be a voice channel in this server"})
+			return
+		}
+		voiceChannel = sql.NullInt64{Int64: int64(*req.VoiceChannelID), Valid: true}
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO server_events (server_id, name, description, voice_channel_id, starts_at, ends_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		serverID, req.Name, req.Description, voiceChannel,
+		req.StartsAt.UTC().Format(sqliteTimeLayout), endsAt.UTC().Format(sqliteTimeLayout), userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	// The creator is going by default
+	if _, err := s.db.Exec("INSERT INTO server_event_rsvps (event_id, user_id, status) VALUES (?, ?, ?)", id, userID, rsvpGoing); err != nil {
+		log.Printf("Failed to RSVP creator to event %d: %v", id, err)
+	}
+
+	event, err := s.loadServerEvent(serverID, strconv.FormatInt(id, 10), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load event"})
+		return
+	}
+	s.markWrite(userID)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    event,
+	})
+
+	announced := *event
+	s.broadcastEventLifecycle("event_created", &announced)
+}
+
+// handleDeleteServerEvent cancels a scheduled event or ends a running one
+func (s *Server) handleDeleteServerEvent(c *gin.Context) {
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	event, err := s.loadServerEvent(serverID, c.Param("eventId"), c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if role != "owner" && role != "admin" && event.CreatedBy != c.GetInt("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var changed bool
+	message := "Event ended successfully"
+	switch event.Status {
+	case eventScheduled:
+		changed = s.transitionServerEvent(event.ID, eventScheduled, eventCancelled, "event_ended")
+		message = "Event cancelled successfully"
+	case eventActive:
+		changed = s.transitionServerEvent(event.ID, eventActive, eventEnded, "event_ended")
+	}
+	if !changed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event has already ended"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+	})
+}
+
+// handleRSVPServerEvent records that the user is going or interested
+func (s *Server) handleRSVPServerEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != rsvpGoing && req.Status != rsvpInterested {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be going or interested"})
+		return
+	}
+
+	event, err := s.loadServerEvent(serverID, c.Param("eventId"), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if event.Status == eventEnded || event.Status == eventCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event has already ended"})
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO server_event_rsvps (event_id, user_id, status) VALUES (?, ?, ?)
+		ON CONFLICT (event_id, user_id) DO UPDATE SET status = excluded.status`,
+		event.ID, userID, req.Status,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save RSVP"})
+		return
+	}
+
+	s.respondServerEvent(c, serverID, event.ID, userID)
+}
+
+// handleDeleteRSVPServerEvent withdraws the user's RSVP
+func (s *Server) handleDeleteRSVPServerEvent(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	event, err := s.loadServerEvent(serverID, c.Param("eventId"), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM server_event_rsvps WHERE event_id = ? AND user_id = ?", event.ID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove RSVP"})
+		return
+	}
+
+	s.respondServerEvent(c, serverID, event.ID, userID)
+}
+
+// respondServerEvent returns an event with fresh RSVP counts
+func (s *Server) respondServerEvent(c *gin.Context, serverID int, eventID int64, userID int) {
+	event, err := s.loadServerEvent(serverID, strconv.FormatInt(eventID, 10), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load event"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    event,
+	})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerEvents(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	s.jobs = queue
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("evowner_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	ownerID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("evmember_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	memberID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Events %d", suffix), ownerID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, 'stage', 'voice')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	voiceID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner'), (?, ?, 'member')", ownerID, serverID, memberID, serverID); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(userID int64, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(userID))
+		})
+		router.GET("/servers/:id/events", s.handleGetServerEvents)
+		router.POST("/servers/:id/events", s.handleCreateServerEvent)
+		router.DELETE("/servers/:id/events/:eventId", s.handleDeleteServerEvent)
+		router.PUT("/servers/:id/events/:eventId/rsvp", s.handleRSVPServerEvent)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	eventsPath := fmt.Sprintf("/servers/%d/events", serverID)
+
+	startsAt := time.Now().UTC().Add(10 * time.Minute).Truncate(time.Second)
+	create := fmt.Sprintf(`{"name":"Game night","starts_at":%q,"voice_channel_id":%d}`, startsAt.Format(time.RFC3339), voiceID)
+
+	// Only owners and admins schedule events
+	if w := request(memberID, http.MethodPost, eventsPath, create); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected member create to be forbidden, got %d", w.Code)
+	}
+	past := fmt.Sprintf(`{"name":"Too late","starts_at":%q}`, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	if w := request(ownerID, http.MethodPost, eventsPath, past); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected past start to be rejected, got %d", w.Code)
+	}
+
+	w := request(ownerID, http.MethodPost, eventsPath, create)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data serverEvent `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	event := created.Data
+	if event.Status != eventScheduled || event.Going != 1 || event.MyRSVP != rsvpGoing {
+		t.Fatalf("Expected a scheduled event with the creator going, got %+v", event)
+	}
+	if !event.EndsAt.Equal(startsAt.Add(defaultEventDuration)) {
+		t.Fatalf("Expected the default duration, got %v to %v", event.StartsAt, event.EndsAt)
+	}
+
+	rsvpPath := fmt.Sprintf("%s/%d/rsvp", eventsPath, event.ID)
+	if w := request(memberID, http.MethodPut, rsvpPath, `{"status":"maybe"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected invalid RSVP to be rejected, got %d", w.Code)
+	}
+	w = request(memberID, http.MethodPut, rsvpPath, `{"status":"interested"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var rsvp struct {
+		Data serverEvent `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rsvp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if rsvp.Data.Going != 1 || rsvp.Data.Interested != 1 || rsvp.Data.MyRSVP != rsvpInterested {
+		t.Fatalf("Expected one going and one interested, got %+v", rsvp.Data)
+	}
+
+	// The reminder is claimed once the event is within the lead time
+	s.advanceServerEvents(time.Now())
+	var reminded int
+	var status string
+	if err := db.QueryRow("SELECT reminded, status FROM server_events WHERE id = ?", event.ID).Scan(&reminded, &status); err != nil {
+		t.Fatalf("Failed to load event: %v", err)
+	}
+	if reminded != 1 || status != eventScheduled {
+		t.Fatalf("Expected the reminder to be claimed before the start, got reminded=%d status=%s", reminded, status)
+	}
+
+	s.advanceServerEvents(startsAt.Add(time.Minute))
+	if err := db.QueryRow("SELECT status FROM server_events WHERE id = ?", event.ID).Scan(&status); err != nil {
+		t.Fatalf("Failed to load event: %v", err)
+	}
+	if status != eventActive {
+		t.Fatalf("Expected the event to start, got %s", status)
+	}
+
+	// Members cannot end someone else's event
+	eventPath := fmt.Sprintf("%s/%d", eventsPath, event.ID)
+	if w := request(memberID, http.MethodDelete, eventPath, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected member delete to be forbidden, got %d", w.Code)
+	}
+
+	s.advanceServerEvents(event.EndsAt.Add(time.Minute))
+	if err := db.QueryRow("SELECT status FROM server_events WHERE id = ?", event.ID).Scan(&status); err != nil {
+		t.Fatalf("Failed to load event: %v", err)
+	}
+	if status != eventEnded {
+		t.Fatalf("Expected the event to end, got %s", status)
+	}
+	if w := request(ownerID, http.MethodDelete, eventPath, ""); w.Code != http.StatusConflict {
+		t.Fatalf("Expected ending an ended event to conflict, got %d", w.Code)
+	}
+
+	// Ended events drop off the list
+	w = request(memberID, http.MethodGet, eventsPath, "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Game night") {
+		t.Fatalf("Expected no upcoming events, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"fethur/internal/chaos"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetFaults shows the fault injection settings of a chaos build
+func (s *Server) handleGetFaults(c *gin.Context) {
+	if !chaos.Available() {
+		c.JSON(http.StatusNotFound, gin.H{"error": chaos.ErrUnavailable.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chaos.Current(),
+	})
+}
+
+// handleUpdateFaults changes the fault injection settings of a chaos build
+func (s *Server) handleUpdateFaults(c *gin.Context) {
+	var config chaos.Config
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := chaos.Set(config); err != nil {
+		if errors.Is(err, chaos.ErrUnavailable) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "update_faults", fmt.Sprintf(
+		"Set fault injection: %d%% DB latency up to %dms, %d%% WebSocket drops, %d%% plugin timeouts",
+		config.DBLatencyPercent, config.DBLatencyMaxMs, config.WSDropPercent, config.PluginTimeoutPercent,
+	))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chaos.Current(),
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"os"
+	"strings"
+
+	"fethur/internal/geoip"
+)
+
+// GeoIPDatabases returns the MaxMind DB files used to show the country and
+// network of client IPs to admins, from the comma-separated paths in
+// FETHUR_GEOIP_DB, e.g. GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb
+func GeoIPDatabases() []string {
+	paths := make([]string, 0)
+	for _, path := range strings.Split(os.Getenv("FETHUR_GEOIP_DB"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// locate returns where an IP is, or nil when no database knows it or none
+// is loaded, so views can leave it out
+func (s *Server) locate(ip string) *geoip.Location {
+	location := s.geo.Lookup(ip)
+	if !location.Known() {
+		return nil
+	}
+	return &location
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Guests are ephemeral accounts created by guest sign-in. They hold the
+// guest role, may only read the channels listed in the guest_channels
+// setting, and are deleted once they expire or have been gone a while.
+const (
+	// guestTTL is how long a guest account lasts at most
+	guestTTL = 24 * time.Hour
+
+	// guestIdleGrace is how long a guest may stay disconnected before
+	// their account is deleted
+	guestIdleGrace = 10 * time.Minute
+
+	// guestSweepInterval is how often expired guests are looked for
+	guestSweepInterval = time.Minute
+)
+
+// guestNameAlphabet leaves out characters that are easily confused
+const guestNameAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// guestRoutes are the requests guests may make, by method and route
+var guestRoutes = map[string]bool{
+	"GET /ws":                           true,
+	"GET /auth/me":                      true,
+	"GET /user/profile":                 true,
+	"GET /servers":                      true,
+	"GET /servers/:id/channels":         true,
+	"GET /channels/:channelId/messages": true,
+	"GET /channels/:channelId/messages/:messageId/context": true,
+	"GET /attachments/:id":                                 true,
+}
+
+// generateGuestName returns a random guest name such as guest-k3m9qz
+func generateGuestName() (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	name := []byte("guest-")
+	for _, b := range random {
+		name = append(name, guestNameAlphabet[int(b)%len(guestNameAlphabet)])
+	}
+	return string(name), nil
+}
+
+// guestChannels returns the channels guests may read
+func (s *Server) guestChannels() map[int]bool {
+	channels := make(map[int]bool)
+	value, _ := s.db.GetSetting("guest_channels")
+	for _, part := range strings.Split(value, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			channels[id] = true
+		}
+	}
+	return channels
+}
+
+// parseGuestChannels checks that every channel exists and is a public text
+// channel, and returns them as the guest_channels setting
+func (s *Server) parseGuestChannels(ids []int) (string, bool) {
+	sort.Ints(ids)
+	parts := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		var ok bool
+		err := s.db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM channels WHERE id = ? AND channel_type = 'text' AND private = 0)", id,
+		).Scan(&ok)
+		if err != nil || !ok {
+			return "", false
+		}
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ","), true
+}
+
+// isGuest reports whether a user is a guest
+func (s *Server) isGuest(userID int) bool {
+	var role string
+	if err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		return false
+	}
+	return role == "guest"
+}
+
+// allowGuest confines guests to guestRoutes and the guest channels. It
+// answers and returns false when the request is refused.
+func (s *Server) allowGuest(c *gin.Context, userID int) bool {
+	if !s.isGuest(userID) {
+		return true
+	}
+	route := c.FullPath()
+	if i := strings.Index(route, "/api/"); i >= 0 {
+		route = route[i+len("/api"):]
+	} else if i := strings.LastIndex(route, "/ws"); i >= 0 {
+		route = route[i:]
+	}
+	if !guestRoutes[c.Request.Method+" "+route] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Guests cannot do this; sign up for an account", "code": "guest_restricted"})
+		c.Abort()
+		return false
+	}
+	if channelID := c.Param("channelId"); channelID != "" {
+		id, _ := strconv.Atoi(channelID)
+		if !s.guestChannels()[id] {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			c.Abort()
+			return false
+		}
+	}
+	c.Set("guest", true)
+	return true
+}
+
+// joinGuestServers makes a new guest a member of the servers of the guest
+// channels in their organization
+func (s *Server) joinGuestServers(userID, orgID int) error {
+	channels := s.guestChannels()
+	if len(channels) == 0 {
+		return nil
+	}
+	rows, err := s.db.Query(`
+		SELECT DISTINCT c.id, c.server_id FROM channels c JOIN servers sv ON sv.id = c.server_id
+		WHERE sv.org_id = ?`, orgID,
+	)
+	if err != nil {
+		return err
+	}
+	var serverIDs []int
+	for rows.Next() {
+		var channelID, serverID int
+		if err := rows.Scan(&channelID, &serverID); err == nil && channels[channelID] {
+			serverIDs = append(serverIDs, serverID)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, serverID := range serverIDs {
+		if _, err := s.db.Exec(
+			"INSERT OR IGNORE INTO server_members (user_id, server_id, role) VALUES (?, ?, 'member')", userID, serverID,
+		); err != nil {
+			return err
+		}
+		s.bumpResourceVersion(membersResource(serverID))
+	}
+	return nil
+}
+
+// startGuestSweeper deletes guests that expired or have been disconnected
+// for guestIdleGrace
+func (s *Server) startGuestSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(guestSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if deleted := s.sweepGuests(now); deleted > 0 {
+					log.Printf("Deleted %d guest accounts", deleted)
+				}
+			}
+		}
+	}()
+}
+
+// sweepGuests deletes expired and departed guests and returns how many.
+// Connected guests count as seen, so the grace runs from their disconnect.
+func (s *Server) sweepGuests(now time.Time) int {
+	rows, err := s.db.Query(`
+		SELECT id, guest_expires_at, created_at, last_seen_at
+		FROM users WHERE role = 'guest'`)
+	if err != nil {
+		log.Printf("Failed to list guest accounts: %v", err)
+		return 0
+	}
+	var expired, connected []int
+	for rows.Next() {
+		var id int
+		var expiresAt, createdAt time.Time
+		var seenAt sql.NullTime
+		if err := rows.Scan(&id, &expiresAt, &createdAt, &seenAt); err != nil {
+			continue
+		}
+		lastSeen := createdAt
+		if seenAt.Valid && seenAt.Time.After(createdAt) {
+			lastSeen = seenAt.Time
+		}
+		switch {
+		case !now.Before(expiresAt):
+			expired = append(expired, id)
+		case s.hub.IsConnected(id):
+			connected = append(connected, id)
+		case now.Sub(lastSeen) >= guestIdleGrace:
+			expired = append(expired, id)
+		}
+	}
+	_ = rows.Close()
+
+	for _, id := range connected {
+		s.touchLastSeen(id)
+	}
+	deleted := 0
+	for _, id := range expired {
+		if err := s.deleteGuest(id); err != nil {
+			log.Printf("Failed to delete guest %d: %v", id, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted
+}
+
+// deleteGuest removes a guest account with its memberships and sessions
+// and disconnects it
+func (s *Server) deleteGuest(userID int) error {
+	s.bumpUserMemberships(userID)
+	for _, query := range []string{
+		"DELETE FROM server_members WHERE user_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ? AND role = 'guest'",
+	} {
+		if _, err := s.db.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	s.disconnectUser(userID, "delete", "Guest session ended")
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGuestAccounts(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"guest_mode_enabled", "guest_channels"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("gsowner_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	ownerID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Guests %d", suffix), ownerID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	channels := make(map[string]int64)
+	for _, name := range []string{"lobby", "staff"} {
+		result, err := db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, ?, 'text')", serverID, name)
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[name], _ = result.LastInsertId()
+	}
+	if _, ok := s.parseGuestChannels([]int{int(channels["lobby"]), 999999999}); ok {
+		t.Error("Expected unknown channels to be refused as guest channels")
+	}
+	setting, ok := s.parseGuestChannels([]int{int(channels["lobby"]), int(channels["lobby"])})
+	if !ok || setting != fmt.Sprint(channels["lobby"]) {
+		t.Fatalf("Expected the lobby as guest channel, got %q", setting)
+	}
+	_ = db.SetSetting("guest_channels", setting, settingDescriptions["guest_channels"])
+	_ = db.SetSetting("guest_mode_enabled", "true", settingDescriptions["guest_mode_enabled"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api")
+	api.POST("/auth/guest", s.handleGuestLogin)
+	protected := api.Group("/")
+	protected.Use(s.authMiddleware())
+	protected.GET("/servers/:id/channels", s.handleGetChannels)
+	protected.GET("/channels/:channelId/messages", s.handleGetMessages)
+	protected.POST("/channels/:channelId/messages", s.handleSendMessage)
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"content":"hi"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Each guest sign-in creates a fresh guest under a generated name
+	login := func() (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/auth/guest", nil))
+		var response struct {
+			Token string `json:"token"`
+			User  struct {
+				ID       int    `json:"id"`
+				Username string `json:"username"`
+				Role     string `json:"role"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Guest login failed with %d: %s", w.Code, w.Body.String())
+		}
+		if response.User.Role != "guest" || !strings.HasPrefix(response.User.Username, "guest-") {
+			t.Fatalf("Expected a generated guest, got %+v", response.User)
+		}
+		return response.User.ID, response.Token
+	}
+	guestID, token := login()
+	if otherID, _ := login(); otherID == guestID {
+		t.Fatal("Expected every guest sign-in to create a new user")
+	}
+
+	// Guests read the guest channels and nothing else
+	w := request("GET", fmt.Sprintf("/api/servers/%d/channels", serverID), token)
+	var listed struct {
+		Channels []struct {
+			ID int64 `json:"id"`
+		} `json:"channels"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if w.Code != http.StatusOK || len(listed.Channels) != 1 || listed.Channels[0].ID != channels["lobby"] {
+		t.Fatalf("Expected guests to see only the lobby, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("GET", fmt.Sprintf("/api/channels/%d/messages", channels["lobby"]), token); w.Code != http.StatusOK {
+		t.Errorf("Expected guests to read the lobby, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("GET", fmt.Sprintf("/api/channels/%d/messages", channels["staff"]), token); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the staff channel to be hidden from guests, got %d", w.Code)
+	}
+	if w := request("POST", fmt.Sprintf("/api/channels/%d/messages", channels["lobby"]), token); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "guest_restricted") {
+		t.Errorf("Expected guests not to post, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := s.validateTextChannel(guestID, int(channels["staff"])); err == nil {
+		t.Error("Expected guests not to subscribe to other channels")
+	}
+
+	// Disconnected guests are deleted after the grace period
+	if deleted := s.sweepGuests(time.Now()); deleted != 0 {
+		t.Errorf("Expected fresh guests to be kept, deleted %d", deleted)
+	}
+	s.sweepGuests(time.Now().Add(guestIdleGrace + time.Minute))
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", guestID).Scan(&remaining); err != nil || remaining != 0 {
+		t.Errorf("Expected the guest to be deleted, %d left (%v)", remaining, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM server_members WHERE user_id = ?", guestID).Scan(&remaining); err != nil || remaining != 0 {
+		t.Errorf("Expected the guest's memberships to be deleted, %d left (%v)", remaining, err)
+	}
+	if w := request("GET", fmt.Sprintf("/api/servers/%d/channels", serverID), token); w.Code == http.StatusOK {
+		t.Error("Expected the deleted guest's token to stop working")
+	}
+}
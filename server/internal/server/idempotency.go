@@ -0,0 +1,94 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyWindow is how long a retried send with the same key returns
+// the original message instead of creating a new one.
+const idempotencyWindow = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+var errInvalidIdempotencyKey = errors.New("Idempotency key must be at most 255 characters")
+
+// idempotencyKey returns the client-supplied key from the Idempotency-Key
+// header, falling back to the client_nonce body field.
+func idempotencyKey(c *gin.Context, clientNonce string) (string, error) {
+	key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	if key == "" {
+		key = strings.TrimSpace(clientNonce)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return "", errInvalidIdempotencyKey
+	}
+	return key, nil
+}
+
+func idempotencyCutoff() string {
+	return time.Now().Add(-idempotencyWindow).UTC().Format("2006-01-02 15:04:05")
+}
+
+// findIdempotentMessage returns the message previously created by this user
+// with the same key, if it is still inside the dedupe window.
+func (s *Server) findIdempotentMessage(userID int, key string) (gin.H, bool) {
+	var id int64
+	var channelID, authorID int
+	var username, content string
+	var createdAt time.Time
+	err := s.db.QueryRow(`
+		SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
+		FROM message_idempotency k
+		JOIN messages m ON m.id = k.message_id
+		JOIN users u ON u.id = m.user_id
+		WHERE k.user_id = ? AND k.idempotency_key = ? AND k.created_at > ?`,
+		userID, key, idempotencyCutoff(),
+	).Scan(&id, &channelID, &authorID, &username, &content, &createdAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to look up idempotency key for user %d: %v", userID, err)
+		}
+		return nil, false
+	}
+
+	return gin.H{
+		"id":         snowflake.ID(id),
+		"channel_id": channelID,
+		"user_id":    authorID,
+		"username":   username,
+		"content":    content,
+		"created_at": createdAt.Format(time.RFC3339),
+	}, true
+}
+
+// claimIdempotencyKey binds a key to a newly created message. It returns
+// false if a concurrent request with the same key claimed it first.
+func (s *Server) claimIdempotencyKey(userID int, key string, messageID int64) bool {
+	// Expired keys may be reused
+	if _, err := s.db.Exec(
+		"DELETE FROM message_idempotency WHERE created_at <= ?",
+		idempotencyCutoff(),
+	); err != nil {
+		log.Printf("Failed to prune idempotency keys: %v", err)
+	}
+
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO message_idempotency (user_id, idempotency_key, message_id) VALUES (?, ?, ?)",
+		userID, key, messageID,
+	)
+	if err != nil {
+		log.Printf("Failed to record idempotency key for user %d: %v", userID, err)
+		return true
+	}
+
+	affected, _ := result.RowsAffected()
+	return affected > 0
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotentSends(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "member"} {
+		users[name] = createTestUser(t, db, fmt.Sprintf("retry%s_%d", name, suffix))
+	}
+	_, channelID := createTestCommunity(t, db, users["owner"], users["member"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+	})
+	router.POST("/channels/:channelId/messages", s.handleSendMessage)
+	// send posts content with an Idempotency-Key header, or with key in the
+	// client_nonce field when header is false, and returns the message ID
+	send := func(user, key string, header bool, content string) (*httptest.ResponseRecorder, string) {
+		body := fmt.Sprintf(`{"content":%q}`, content)
+		if !header {
+			body = fmt.Sprintf(`{"content":%q,"client_nonce":%q}`, content, key)
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", fmt.Sprintf("/channels/%d/messages", channelID), strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		if header {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(w, r)
+		var sent struct {
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &sent)
+		return w, sent.Data.ID
+	}
+	count := func(content string) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE channel_id = ? AND content = ?", channelID, content).Scan(&n); err != nil {
+			t.Fatalf("Failed to count messages: %v", err)
+		}
+		return n
+	}
+
+	// A retry within the window returns the first message instead of
+	// posting it again, whether the key comes as a header or a nonce
+	key := fmt.Sprintf("retry-%d", suffix)
+	w, first := send("member", key, true, "hello once")
+	if w.Code != http.StatusOK || first == "" || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected the first send to post, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, header := range []bool{true, false} {
+		w, again := send("member", key, header, "hello once")
+		if w.Code != http.StatusOK || again != first || w.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("Expected the retry to replay message %s, got %d: %s", first, w.Code, w.Body.String())
+		}
+	}
+	if n := count("hello once"); n != 1 {
+		t.Errorf("Expected one message after retries, got %d", n)
+	}
+
+	// Keys belong to the user who sent them
+	if w, other := send("owner", key, true, "hello once"); w.Code != http.StatusOK || other == first {
+		t.Errorf("Expected another user's send with the same key to post, got %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := send("member", strings.Repeat("k", maxIdempotencyKeyLength+1), true, "too long"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an overlong key to be refused, got %d", w.Code)
+	}
+
+	// Once the window has passed, the key posts a new message
+	if _, err := db.Exec("UPDATE message_idempotency SET created_at = datetime('now', '-25 hours') WHERE user_id = ? AND idempotency_key = ?",
+		users["member"], key); err != nil {
+		t.Fatalf("Failed to age key: %v", err)
+	}
+	w, renewed := send("member", key, true, "hello once")
+	if w.Code != http.StatusOK || renewed == first || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected an expired key to post a new message, got %d: %s", w.Code, w.Body.String())
+	}
+	if w, again := send("member", key, true, "hello once"); again != renewed || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the reused key to replay the new message %s, got %s", renewed, again)
+	}
+
+	// A concurrent retry that claims the key between the lookup and the
+	// claim wins: the trigger plays that retry, claiming the key for its
+	// own message as soon as this send's message is inserted
+	raceKey := fmt.Sprintf("race-%d", suffix)
+	// An explicit snowflake keeps the row clear of the IDs the handler
+	// generates, which an auto-increment ID could land on
+	winner := int64(snowflake.Next())
+	if _, err := db.Exec("INSERT INTO messages (id, channel_id, user_id, content) VALUES (?, ?, ?, 'racing')", winner, channelID, users["member"]); err != nil {
+		t.Fatalf("Failed to insert the winning message: %v", err)
+	}
+	trigger := fmt.Sprintf("idempotency_race_%d", suffix)
+	if _, err := db.Exec(fmt.Sprintf(`
+		CREATE TRIGGER %s AFTER INSERT ON messages WHEN NEW.content = 'racing' AND NEW.id != %d
+		BEGIN
+			INSERT OR IGNORE INTO message_idempotency (user_id, idempotency_key, message_id) VALUES (NEW.user_id, '%s', %d);
+		END`, trigger, winner, raceKey, winner)); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	defer func() {
+		_, _ = db.Exec("DROP TRIGGER IF EXISTS " + trigger)
+	}()
+
+	w, returned := send("member", raceKey, true, "racing")
+	if w.Code != http.StatusOK || returned != strconv.FormatInt(winner, 10) || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the losing send to return the winner %d, got %d: %s", winner, w.Code, w.Body.String())
+	}
+	if n := count("racing"); n != 1 {
+		t.Errorf("Expected the losing message to be deleted, got %d messages", n)
+	}
+}
@@ -0,0 +1,783 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/chatimport"
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Imports from other chat platforms. An instance admin uploads an export
+// from Discord, Slack or Matrix and either gets a dry-run summary back or
+// has the job queue turn it into a new server they own: channels, roles, a
+// placeholder user per author and the message history with its replies
+// and exported attachments. Everything created is recorded by its ID in
+// the export, so an import cut short by the job timeout or a restart
+// resumes without duplicates.
+
+const (
+	maxChatImportSize   = 2 << 30
+	chatImportMargin    = 30 * time.Second // kept before a job's deadline to save progress
+	chatImportSaveEvery = 100              // messages between progress updates
+)
+
+// Kinds of discord_import_ids rows
+const (
+	importedRole    = "role"
+	importedChannel = "channel"
+	importedUser    = "user"
+	importedMessage = "message"
+)
+
+// errChatImportPaused stops an import that is about to run out of time
+var errChatImportPaused = errors.New("import paused")
+
+// placeholderPasswordHash is the password hash of users created for the
+// authors of an export, e.g. !slack. It matches no password.
+func placeholderPasswordHash(source string) string {
+	return "!" + source
+}
+
+// sourceTitle names a source in messages and default server names
+func sourceTitle(source string) string {
+	switch source {
+	case chatimport.SourceDiscord:
+		return "Discord"
+	case chatimport.SourceSlack:
+		return "Slack"
+	case chatimport.SourceMatrix:
+		return "Matrix"
+	}
+	return source
+}
+
+// queueChatImport hands an import to the job queue
+func (s *Server) queueChatImport(id int64) error {
+	return s.jobs.Enqueue(fmt.Sprintf("chat-import-%d", id), func(ctx context.Context) error {
+		return s.runChatImport(ctx, id)
+	})
+}
+
+// requeueChatImports resumes imports interrupted by a restart
+func (s *Server) requeueChatImports() {
+	rows, err := s.db.Query("SELECT id FROM discord_imports WHERE status IN ('queued', 'running')")
+	if err != nil {
+		log.Printf("Failed to load unfinished imports: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	for _, id := range ids {
+		if err := s.queueChatImport(id); err != nil {
+			log.Printf("Failed to requeue import %d: %v", id, err)
+		}
+	}
+}
+
+// chatImporter carries one run of an import
+type chatImporter struct {
+	s         *Server
+	id        int64
+	ownerID   int
+	orgID     int
+	serverID  int
+	archive   *chatimport.Archive
+	ids       map[string]map[string]int64 // kind -> ID in the export -> local ID
+	messages  int
+	files     int
+	deadline  time.Time
+	hasExpiry bool
+}
+
+// runChatImport works through an import until it finishes or its job is
+// about to time out, in which case it queues itself to carry on
+func (s *Server) runChatImport(ctx context.Context, id int64) error {
+	var ownerID int
+	var source, name, storageKey, status string
+	var serverID sql.NullInt64
+	var messages, files int
+	err := s.db.QueryRow(
+		"SELECT created_by, source, name, storage_key, status, server_id, messages_imported, attachments_imported FROM discord_imports WHERE id = ?", id,
+	).Scan(&ownerID, &source, &name, &storageKey, &status, &serverID, &messages, &files)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != "queued" && status != "running" {
+		return nil
+	}
+	if _, err := s.db.Exec("UPDATE discord_imports SET status = 'running' WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	archive, cleanup, err := s.openImportArchive(ctx, source, storageKey)
+	if err != nil {
+		s.failChatImport(id, storageKey, err)
+		return err
+	}
+	defer cleanup()
+
+	imp := &chatImporter{
+		s:        s,
+		id:       id,
+		ownerID:  ownerID,
+		orgID:    s.userOrgID(ownerID),
+		serverID: int(serverID.Int64),
+		archive:  archive,
+		messages: messages,
+		files:    files,
+	}
+	imp.deadline, imp.hasExpiry = ctx.Deadline()
+	if err := imp.loadIDs(); err != nil {
+		return err
+	}
+
+	err = imp.run(ctx, name)
+	if errors.Is(err, errChatImportPaused) {
+		if err := imp.saveProgress(); err != nil {
+			return err
+		}
+		if err := s.queueChatImport(id); err != nil {
+			// Picked up again on the next start
+			log.Printf("Failed to queue the rest of import %d: %v", id, err)
+		}
+		return nil
+	}
+	if err != nil {
+		s.failChatImport(id, storageKey, err)
+		return err
+	}
+
+	if _, err := s.db.Exec(`
+		UPDATE discord_imports SET status = 'completed', messages_imported = ?, attachments_imported = ?, finished_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, imp.messages, imp.files, id,
+	); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM discord_import_ids WHERE import_id = ?", id); err != nil {
+		log.Printf("Failed to clean up import %d: %v", id, err)
+	}
+	s.deleteImportArchive(storageKey)
+	s.bumpResourceVersion(channelsResource(imp.serverID))
+	s.bumpResourceVersion(membersResource(imp.serverID))
+	log.Printf("%s import %d finished: server %d, %d messages, %d attachments", sourceTitle(source), id, imp.serverID, imp.messages, imp.files)
+	return nil
+}
+
+// openImportArchive copies a stored archive to a temporary file, since
+// zip needs random access, and opens it
+func (s *Server) openImportArchive(ctx context.Context, source, key string) (*chatimport.Archive, func(), error) {
+	src, err := s.storage.Open(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	tmp, err := os.CreateTemp("", "fethur-import-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	archive, err := chatimport.Open(source, tmp, size)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return archive, cleanup, nil
+}
+
+// failChatImport records why an import stopped and drops its archive.
+// Whatever was imported so far stays in place.
+func (s *Server) failChatImport(id int64, storageKey string, cause error) {
+	if _, err := s.db.Exec(
+		"UPDATE discord_imports SET status = 'failed', error = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?", cause.Error(), id,
+	); err != nil {
+		log.Printf("Failed to mark import %d failed: %v", id, err)
+	}
+	if _, err := s.db.Exec("DELETE FROM discord_import_ids WHERE import_id = ?", id); err != nil {
+		log.Printf("Failed to clean up import %d: %v", id, err)
+	}
+	s.deleteImportArchive(storageKey)
+}
+
+func (s *Server) deleteImportArchive(key string) {
+	if err := s.storage.Delete(context.Background(), key); err != nil {
+		log.Printf("Failed to delete import archive %s: %v", key, err)
+	}
+}
+
+// loadIDs reads what earlier runs of the import created
+func (imp *chatImporter) loadIDs() error {
+	imp.ids = map[string]map[string]int64{
+		importedRole:    {},
+		importedChannel: {},
+		importedUser:    {},
+		importedMessage: {},
+	}
+	rows, err := imp.s.db.Query("SELECT kind, discord_id, local_id FROM discord_import_ids WHERE import_id = ?", imp.id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var kind, sourceID string
+		var localID int64
+		if err := rows.Scan(&kind, &sourceID, &localID); err != nil {
+			return err
+		}
+		if ids, ok := imp.ids[kind]; ok {
+			ids[sourceID] = localID
+		}
+	}
+	return rows.Err()
+}
+
+// remember records what an ID in the export was imported as
+func (imp *chatImporter) remember(kind, sourceID string, localID int64) error {
+	if _, err := imp.s.db.Exec(
+		"INSERT OR REPLACE INTO discord_import_ids (import_id, kind, discord_id, local_id) VALUES (?, ?, ?, ?)",
+		imp.id, kind, sourceID, localID,
+	); err != nil {
+		return err
+	}
+	imp.ids[kind][sourceID] = localID
+	return nil
+}
+
+// outOfTime reports whether the job should stop and save its progress
+func (imp *chatImporter) outOfTime(ctx context.Context) bool {
+	return ctx.Err() != nil || (imp.hasExpiry && time.Until(imp.deadline) < chatImportMargin)
+}
+
+func (imp *chatImporter) saveProgress() error {
+	_, err := imp.s.db.Exec(
+		"UPDATE discord_imports SET messages_imported = ?, attachments_imported = ? WHERE id = ?", imp.messages, imp.files, imp.id,
+	)
+	return err
+}
+
+// run creates the server, its roles and channels, then the messages
+func (imp *chatImporter) run(ctx context.Context, name string) error {
+	if imp.serverID == 0 {
+		if err := imp.createServer(name); err != nil {
+			return err
+		}
+	}
+	for _, role := range imp.archive.Roles {
+		if err := imp.importRole(role); err != nil {
+			return fmt.Errorf("role %s: %w", role.Name, err)
+		}
+	}
+	for _, channel := range imp.archive.Channels {
+		if err := imp.importChannel(channel); err != nil {
+			return fmt.Errorf("channel %s: %w", channel.Name, err)
+		}
+	}
+
+	for _, channel := range imp.archive.Channels {
+		channelID := int(imp.ids[importedChannel][channel.ID])
+		for _, message := range channel.Messages {
+			if _, done := imp.ids[importedMessage][message.ID]; done {
+				continue
+			}
+			if imp.outOfTime(ctx) {
+				return errChatImportPaused
+			}
+			if err := imp.importMessage(ctx, channel, channelID, message); err != nil {
+				return fmt.Errorf("message %s in %s: %w", message.ID, channel.Name, err)
+			}
+			if imp.messages%chatImportSaveEvery == 0 {
+				if err := imp.saveProgress(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// createServer makes the server the import fills, owned by the importer
+func (imp *chatImporter) createServer(name string) error {
+	if err := imp.s.checkOrgLimit(imp.orgID, "servers"); err != nil {
+		return errors.New("the organization has reached its server limit")
+	}
+	tx, err := imp.s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.Exec(
+		"INSERT INTO servers (name, description, owner_id, org_id) VALUES (?, ?, ?, ?)",
+		name, "Imported from "+sourceTitle(imp.archive.Source), imp.ownerID, imp.orgID,
+	)
+	if err != nil {
+		return err
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := tx.Exec(
+		"INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", imp.ownerID, serverID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE discord_imports SET server_id = ? WHERE id = ?", serverID, imp.id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	imp.serverID = int(serverID)
+	return nil
+}
+
+// importRole creates a server role. Names that clash, which Discord
+// allows, get a number appended.
+func (imp *chatImporter) importRole(role chatimport.Role) error {
+	if _, done := imp.ids[importedRole][role.ID]; done {
+		return nil
+	}
+	color := ""
+	if roleColorPattern.MatchString(role.Color) {
+		color = strings.ToLower(role.Color)
+	}
+	position := role.Position
+	if position < 0 {
+		position = 0
+	}
+	if position > maxRolePosition {
+		position = maxRolePosition
+	}
+	name := strings.TrimSpace(role.Name)
+	if name == "" {
+		name = "Role"
+	}
+
+	for attempt := 1; ; attempt++ {
+		candidate := name
+		if attempt > 1 {
+			candidate = fmt.Sprintf("%s %d", name, attempt)
+		}
+		var exists bool
+		if err := imp.s.db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM server_roles WHERE server_id = ? AND name = ?)", imp.serverID, candidate,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		result, err := imp.s.db.Exec(
+			"INSERT INTO server_roles (server_id, name, color, position) VALUES (?, ?, ?, ?)",
+			imp.serverID, candidate, color, position,
+		)
+		if err != nil {
+			return err
+		}
+		roleID, _ := result.LastInsertId()
+		return imp.remember(importedRole, role.ID, roleID)
+	}
+}
+
+// importChannel creates a channel. Private channels are opened to their
+// authors as their messages are imported.
+func (imp *chatImporter) importChannel(channel *chatimport.Channel) error {
+	if _, done := imp.ids[importedChannel][channel.ID]; done {
+		return nil
+	}
+	name := strings.TrimSpace(channel.Name)
+	if name == "" {
+		name = channel.ID
+	}
+	result, err := imp.s.db.Exec(
+		"INSERT INTO channels (name, server_id, channel_type, private) VALUES (?, ?, ?, ?)",
+		name, imp.serverID, channel.Kind, channel.Private,
+	)
+	if err != nil {
+		return err
+	}
+	channelID, _ := result.LastInsertId()
+	return imp.remember(importedChannel, channel.ID, channelID)
+}
+
+// author returns the placeholder user for an author, creating it and its
+// membership on first use. When the organization has no room for another
+// user the importer stands in and botName carries the author's name, as
+// for webhook messages.
+func (imp *chatImporter) author(author chatimport.Author) (userID int, botName string, err error) {
+	if id, ok := imp.ids[importedUser][author.ID]; ok {
+		if id == 0 {
+			return imp.ownerID, author.DisplayName, nil
+		}
+		return int(id), "", nil
+	}
+	if err := imp.s.checkOrgLimit(imp.orgID, "users"); err != nil {
+		return imp.ownerID, author.DisplayName, imp.remember(importedUser, author.ID, 0)
+	}
+
+	var id int64
+	for _, username := range placeholderUsernames(imp.archive.Source, author) {
+		var exists bool
+		if err := imp.s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&exists); err != nil {
+			return 0, "", err
+		}
+		if exists {
+			continue
+		}
+		result, err := imp.s.db.Exec(
+			"INSERT INTO users (username, email, password_hash, role, org_id) VALUES (?, '', ?, 'user', ?)",
+			username, placeholderPasswordHash(imp.archive.Source), imp.orgID,
+		)
+		if err != nil {
+			return 0, "", err
+		}
+		id, _ = result.LastInsertId()
+		break
+	}
+	if id == 0 {
+		return 0, "", fmt.Errorf("no free username for %s", author.Name)
+	}
+
+	if _, err := imp.s.db.Exec(
+		"INSERT OR IGNORE INTO server_members (user_id, server_id, role) VALUES (?, ?, 'member')", id, imp.serverID,
+	); err != nil {
+		return 0, "", err
+	}
+	for _, role := range author.Roles {
+		if roleID, ok := imp.ids[importedRole][role]; ok {
+			if _, err := imp.s.grantMemberRole(imp.serverID, int(id), roleID); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	return int(id), "", imp.remember(importedUser, author.ID, id)
+}
+
+// placeholderUsernames lists usernames to try for an author's placeholder,
+// their name on the other platform first
+func placeholderUsernames(source string, author chatimport.Author) []string {
+	name := strings.TrimSpace(author.Name)
+	if len(name) > 48 {
+		name = name[:48]
+	}
+	if name == "" {
+		name = source
+	}
+	suffix := author.ID
+	if len(suffix) > 4 {
+		suffix = suffix[len(suffix)-4:]
+	}
+	return []string{name, name + " (" + sourceTitle(source) + ")", name + "-" + suffix, source + "-" + author.ID}
+}
+
+// importMessage stores one message with its attachments. Attachments that
+// were not exported are kept as links in the content.
+func (imp *chatImporter) importMessage(ctx context.Context, channel *chatimport.Channel, channelID int, message chatimport.Message) error {
+	userID, botName, err := imp.author(message.Author)
+	if err != nil {
+		return err
+	}
+	if channel.Private {
+		if _, err := imp.s.db.Exec(
+			"INSERT OR IGNORE INTO channel_members (channel_id, user_id) VALUES (?, ?)", channelID, userID,
+		); err != nil {
+			return err
+		}
+	}
+
+	content := message.Content
+	var exported []chatimport.Attachment
+	for _, attachment := range message.Attachments {
+		if !attachment.Exported() {
+			if attachment.URL != "" {
+				content = strings.TrimSpace(content + "\n" + attachment.URL)
+			}
+			continue
+		}
+		exported = append(exported, attachment)
+	}
+
+	var replyTo, threadID interface{}
+	if message.ReplyTo != "" {
+		if target, ok := imp.ids[importedMessage][message.ReplyTo]; ok {
+			if root, err := imp.s.replyThread(channelID, target); err == nil {
+				replyTo, threadID = target, root
+			}
+		}
+	}
+	var editedAt interface{}
+	if message.Edited != nil {
+		editedAt = importTimestamp(*message.Edited)
+	}
+	var bot interface{}
+	if botName != "" {
+		bot = botName
+	}
+
+	messageID := int64(snowflake.Next())
+	_, err = imp.s.db.Exec(`
+		INSERT INTO messages (id, channel_id, user_id, content, created_at, edited_at, bot_name, reply_to_id, thread_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		messageID, channelID, userID, content, importTimestamp(message.Timestamp), editedAt, bot, replyTo, threadID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range exported {
+		if err := imp.importAttachment(ctx, userID, messageID, attachment); err != nil {
+			return fmt.Errorf("attachment %s: %w", attachment.FileName, err)
+		}
+	}
+	imp.messages++
+	return imp.remember(importedMessage, message.ID, messageID)
+}
+
+// importAttachment copies an exported file into storage as a ready
+// attachment of the message. Files of types uploads may not have are left
+// out.
+func (imp *chatImporter) importAttachment(ctx context.Context, uploaderID int, messageID int64, attachment chatimport.Attachment) error {
+	filename := path.Base(attachment.FileName)
+	contentType := mime.TypeByExtension(strings.ToLower(path.Ext(filename)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if !isAllowedAttachmentType(contentType) {
+		return nil
+	}
+
+	key, err := newStorageKey(uploaderID)
+	if err != nil {
+		return err
+	}
+	r, size, err := attachment.Open()
+	if err != nil {
+		return err
+	}
+	err = imp.s.storage.Put(ctx, key, r, size, contentType)
+	_ = r.Close()
+	if err != nil {
+		return err
+	}
+
+	attachmentID := int64(snowflake.Next())
+	_, err = imp.s.db.Exec(`
+		INSERT INTO attachments (id, uploader_id, message_id, storage_key, filename, content_type, size, status, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'ready', CURRENT_TIMESTAMP)`,
+		attachmentID, uploaderID, messageID, key, filename, baseMediaType(contentType), size,
+	)
+	if err != nil {
+		return err
+	}
+	imp.files++
+
+	if a, err := imp.s.getAttachment(attachmentID); err == nil {
+		if err := imp.s.deduplicateAttachment(ctx, a); err != nil {
+			log.Printf("Failed to deduplicate attachment %d: %v", attachmentID, err)
+		}
+	}
+	return nil
+}
+
+// importTimestamp formats a time the way SQLite's CURRENT_TIMESTAMP does,
+// so imported messages sort with new ones
+func importTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// chatImportJSON describes an import's progress
+func (s *Server) chatImportJSON(id int64) (gin.H, int, error) {
+	var createdBy, messages, files int
+	var size int64
+	var source, name, status, summary, importError string
+	var serverID sql.NullInt64
+	var createdAt time.Time
+	var finishedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT created_by, source, name, size, status, server_id, summary, messages_imported, attachments_imported, error, created_at, finished_at
+		FROM discord_imports WHERE id = ?`, id,
+	).Scan(&createdBy, &source, &name, &size, &status, &serverID, &summary, &messages, &files, &importError, &createdAt, &finishedAt)
+	if err != nil {
+		return nil, 0, err
+	}
+	data := gin.H{
+		"id":                   id,
+		"source":               source,
+		"name":                 name,
+		"size":                 size,
+		"status":               status,
+		"server_id":            nil,
+		"summary":              json.RawMessage(summary),
+		"messages_imported":    messages,
+		"attachments_imported": files,
+		"error":                importError,
+		"created_at":           createdAt,
+		"finished_at":          nil,
+	}
+	if serverID.Valid {
+		data["server_id"] = serverID.Int64
+	}
+	if finishedAt.Valid {
+		data["finished_at"] = finishedAt.Time
+	}
+	return data, createdBy, nil
+}
+
+// handleImportChat takes a zipped export from the source in the path in the
+// file field of a multipart form. With dry_run=true it only reports what
+// would be imported; otherwise the import is queued and its progress
+// returned. name overrides the exported server's name.
+func (s *Server) handleImportChat(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	source := c.Param("source")
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the export as the file field"})
+		return
+	}
+	if header.Size > maxChatImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Export is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	archive, err := chatimport.Open(source, file, header.Size)
+	if errors.Is(err, chatimport.ErrUnknownSource) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Imports are supported from discord, slack and matrix"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	summary := archive.Summarize()
+	if dryRun, _ := strconv.ParseBool(c.PostForm("dry_run")); dryRun {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"dry_run": true, "summary": summary}})
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = strings.TrimSpace(archive.Name)
+	}
+	if name == "" {
+		name = sourceTitle(source) + " import"
+	}
+	if len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Server name must be at most 100 characters"})
+		return
+	}
+	if err := s.checkOrgLimit(s.userOrgID(userID), "servers"); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization has reached its server limit"})
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store export"})
+		return
+	}
+	key := "imports/" + source + "/" + hex.EncodeToString(random) + ".zip"
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store export"})
+		return
+	}
+	if err := s.storage.Put(c.Request.Context(), key, file, header.Size, "application/zip"); err != nil {
+		log.Printf("Failed to store %s export: %v", sourceTitle(source), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store export"})
+		return
+	}
+
+	summaryJSON, _ := json.Marshal(summary)
+	result, err := s.db.Exec(
+		"INSERT INTO discord_imports (created_by, source, name, storage_key, size, summary) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, source, name, key, header.Size, string(summaryJSON),
+	)
+	if err != nil {
+		s.deleteImportArchive(key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	if err := s.queueChatImport(id); err != nil {
+		if _, err := s.db.Exec("DELETE FROM discord_imports WHERE id = ?", id); err != nil {
+			log.Printf("Failed to remove import %d: %v", id, err)
+		}
+		s.deleteImportArchive(key)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too much background work; try again shortly"})
+		return
+	}
+	s.logAdminAction(userID, source+"_import", fmt.Sprintf("Started %s import %d of %s", sourceTitle(source), id, name))
+
+	data, _, err := s.chatImportJSON(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load import"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": data})
+}
+
+// handleGetChatImport reports an import's progress to the admin who
+// started it
+func (s *Server) handleGetChatImport(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+	data, createdBy, err := s.chatImportJSON(id)
+	if err != nil || (createdBy != c.GetInt("user_id") && !s.isSuperAdmin(c.GetInt("user_id"))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
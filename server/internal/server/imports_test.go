@@ -0,0 +1,253 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fethur/internal/jobs"
+	"fethur/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatImport(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+	backend, err := storage.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.jobs = queue
+	s.storage = backend
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', 'admin')", fmt.Sprintf("dcadmin_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	adminID, _ := result.LastInsertId()
+
+	// A channel with a reply and a downloaded image, and a thread that is
+	// skipped
+	author := func(id, name string) string {
+		return fmt.Sprintf(`{"id": %q, "name": "%s_%d", "discriminator": "0000", "roles": [{"id": "500", "name": "Admins", "color": "#ff0000", "position": 1}]}`, id, name, suffix)
+	}
+	files := map[string]string{
+		"general.json": `{"guild": {"id": "100", "name": "Homelab"}, "channel": {"id": "200", "type": "GuildTextChat", "name": "general"}, "messages": [
+			{"id": "301", "type": "Default", "timestamp": "2024-03-01T09:00:00Z", "content": "My rack", "author": ` + author("400", "alice") + `,
+				"attachments": [{"id": "600", "url": "general.json_Files/rack.png", "fileName": "rack.png", "fileSizeBytes": 4},
+					{"id": "601", "url": "https://cdn.example.com/notes.txt", "fileName": "notes.txt", "fileSizeBytes": 4}]},
+			{"id": "302", "type": "Reply", "timestamp": "2024-03-01T10:00:00Z", "timestampEdited": "2024-03-01T10:05:00Z", "content": "Nice",
+				"author": ` + author("401", "bob") + `, "reference": {"messageId": "301", "channelId": "200"}},
+			{"id": "303", "type": "GuildMemberJoin", "timestamp": "2024-03-01T11:00:00Z", "content": "", "author": ` + author("402", "carol") + `}]}`,
+		"general.json_Files/rack.png": "\x89PNG\r\n\x1a\n",
+		"help.json":                   `{"guild": {"id": "100", "name": "Homelab"}, "channel": {"id": "210", "type": "GuildPublicThread", "name": "help"}, "messages": []}`,
+	}
+	zipOf := func(files map[string]string) []byte {
+		var archive bytes.Buffer
+		zw := zip.NewWriter(&archive)
+		for name, content := range files {
+			f, _ := zw.Create(name)
+			_, _ = f.Write([]byte(content))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Failed to build archive: %v", err)
+		}
+		return archive.Bytes()
+	}
+	archive := zipOf(files)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", int(adminID))
+	})
+	router.POST("/admin/imports/:source", s.handleImportChat)
+	router.GET("/admin/imports/:id", s.handleGetChatImport)
+	upload := func(source string, fields map[string]string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, value := range fields {
+			_ = mw.WriteField(name, value)
+		}
+		part, _ := mw.CreateFormFile("file", "export.zip")
+		_, _ = part.Write(content)
+		_ = mw.Close()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/admin/imports/"+source, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		router.ServeHTTP(w, r)
+		return w
+	}
+	type progress struct {
+		ID          int64  `json:"id"`
+		Status      string `json:"status"`
+		ServerID    int64  `json:"server_id"`
+		Messages    int    `json:"messages_imported"`
+		Attachments int    `json:"attachments_imported"`
+		Error       string `json:"error"`
+		Summary     struct {
+			Channels     int `json:"channels"`
+			Messages     int `json:"messages"`
+			Authors      int `json:"authors"`
+			MissingMedia int `json:"missing_media"`
+		} `json:"summary"`
+	}
+
+	if w := upload("discord", nil, []byte("not a zip")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a broken upload to be refused, got %d", w.Code)
+	}
+
+	// A dry run reports without importing
+	w := upload("discord", map[string]string{"dry_run": "true"}, archive)
+	var dry struct {
+		Data struct {
+			Summary struct {
+				Channels        int      `json:"channels"`
+				Messages        int      `json:"messages"`
+				SkippedChannels []string `json:"skipped_channels"`
+			} `json:"summary"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &dry)
+	if w.Code != http.StatusOK || dry.Data.Summary.Channels != 1 || dry.Data.Summary.Messages != 2 || len(dry.Data.Summary.SkippedChannels) != 1 {
+		t.Errorf("Expected a summary of 1 channel and 2 messages, got %d: %s", w.Code, w.Body.String())
+	}
+	var imports int
+	_ = db.QueryRow("SELECT COUNT(*) FROM discord_imports WHERE created_by = ?", adminID).Scan(&imports)
+	if imports != 0 {
+		t.Errorf("Expected a dry run to queue nothing")
+	}
+
+	// wait starts an import and polls its progress until it finishes
+	wait := func(source string, fields map[string]string, content []byte) progress {
+		t.Helper()
+		w := upload(source, fields, content)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected the import to be queued, got %d: %s", w.Code, w.Body.String())
+		}
+		var started struct {
+			Data progress `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &started)
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/admin/imports/%d", started.Data.ID), nil))
+			var current struct {
+				Data progress `json:"data"`
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &current)
+			if current.Data.Status == "completed" || current.Data.Status == "failed" {
+				return current.Data
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for the import")
+		return progress{}
+	}
+
+	name := fmt.Sprintf("Imported %d", suffix)
+	done := wait("discord", map[string]string{"name": name}, archive)
+	if done.Status != "completed" || done.Messages != 2 || done.Attachments != 1 || done.Summary.Authors != 2 {
+		t.Fatalf("Expected 2 messages and 1 attachment imported, got %+v", done)
+	}
+
+	var serverName string
+	var ownerID int64
+	_ = db.QueryRow("SELECT name, owner_id FROM servers WHERE id = ?", done.ServerID).Scan(&serverName, &ownerID)
+	if serverName != name || ownerID != adminID {
+		t.Errorf("Expected %s owned by the importer, got %s owned by %d", name, serverName, ownerID)
+	}
+	var members, roleHolders int
+	_ = db.QueryRow("SELECT COUNT(*) FROM server_members WHERE server_id = ?", done.ServerID).Scan(&members)
+	_ = db.QueryRow(`
+		SELECT COUNT(*) FROM member_roles mr JOIN server_roles r ON r.id = mr.role_id
+		WHERE r.server_id = ? AND r.name = 'Admins' AND r.color = '#ff0000'`, done.ServerID).Scan(&roleHolders)
+	if members != 3 || roleHolders != 2 {
+		t.Errorf("Expected the owner and 2 placeholder members holding Admins, got %d members and %d holders", members, roleHolders)
+	}
+	var hash string
+	_ = db.QueryRow("SELECT password_hash FROM users WHERE username = ?", fmt.Sprintf("alice_%d", suffix)).Scan(&hash)
+	if hash != placeholderPasswordHash("discord") {
+		t.Errorf("Expected a placeholder account without a password, got %q", hash)
+	}
+
+	rows, err := db.Query(`
+		SELECT m.id, m.content, m.created_at, m.edited_at IS NOT NULL, m.reply_to_id, COUNT(a.id)
+		FROM messages m JOIN channels c ON c.id = m.channel_id LEFT JOIN attachments a ON a.message_id = m.id
+		WHERE c.server_id = ? GROUP BY m.id ORDER BY m.id`, done.ServerID)
+	if err != nil {
+		t.Fatalf("Failed to load messages: %v", err)
+	}
+	type imported struct {
+		id, replyTo, attachments int64
+		content                  string
+		createdAt                time.Time
+		edited                   bool
+	}
+	var messages []imported
+	for rows.Next() {
+		var m imported
+		var replyTo *int64
+		if err := rows.Scan(&m.id, &m.content, &m.createdAt, &m.edited, &replyTo, &m.attachments); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if replyTo != nil {
+			m.replyTo = *replyTo
+		}
+		messages = append(messages, m)
+	}
+	_ = rows.Close()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %+v", messages)
+	}
+	if messages[0].content != "My rack\nhttps://cdn.example.com/notes.txt" || messages[0].attachments != 1 || !messages[0].createdAt.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the first message with its image, link and timestamp, got %+v", messages[0])
+	}
+	if messages[1].replyTo != messages[0].id || !messages[1].edited {
+		t.Errorf("Expected the edited reply to point at the first message, got %+v", messages[1])
+	}
+	var leftover int
+	_ = db.QueryRow("SELECT COUNT(*) FROM discord_import_ids WHERE import_id = ?", done.ID).Scan(&leftover)
+	if leftover != 0 {
+		t.Errorf("Expected the ID mapping to be dropped when done, got %d rows", leftover)
+	}
+
+	// Slack imports share the same machinery; private channels are opened
+	// to the people who wrote in them
+	if w := upload("irc", nil, archive); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown source to be refused, got %d", w.Code)
+	}
+	slack := zipOf(map[string]string{
+		"channels.json":           `[{"id": "C1", "name": "general"}]`,
+		"groups.json":             `[{"id": "G1", "name": "staff"}]`,
+		"users.json":              fmt.Sprintf(`[{"id": "U1", "name": "dave_%d", "is_owner": true}]`, suffix),
+		"general/2024-03-01.json": `[{"type": "message", "ts": "1709283600.000100", "user": "U1", "text": "Hello"}]`,
+		"staff/2024-03-01.json":   `[{"type": "message", "ts": "1709283700.000100", "user": "U1", "text": "Secret"}]`,
+	})
+	done = wait("slack", nil, slack)
+	if done.Status != "completed" || done.Messages != 2 {
+		t.Fatalf("Expected 2 Slack messages imported, got %+v", done)
+	}
+	var slackServer string
+	var openedTo int
+	_ = db.QueryRow("SELECT name FROM servers WHERE id = ?", done.ServerID).Scan(&slackServer)
+	_ = db.QueryRow(`
+		SELECT COUNT(*) FROM channel_members cm JOIN channels c ON c.id = cm.channel_id
+		WHERE c.server_id = ? AND c.name = 'staff' AND c.private = 1`, done.ServerID).Scan(&openedTo)
+	if slackServer != "Slack import" || openedTo != 1 {
+		t.Errorf("Expected a default name and the private channel opened to its author, got %q and %d", slackServer, openedTo)
+	}
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"fethur/internal/inbound"
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxInboundBody bounds incoming webhook payloads; forge push events with
+// many commits stay well below this
+const maxInboundBody = 1 << 20
+
+// maxInboundContent bounds the plain-text part of a formatted message
+const maxInboundContent = 4000
+
+// alertStateRetention is how long resolved alerts are remembered, so
+// Alertmanager repeating them in later group notifications is ignored
+const alertStateRetention = "-1 day"
+
+// deliveryRetention is how long signed deliveries are remembered to refuse
+// replays. Formats without a timestamp can only be replayed after this.
+const deliveryRetention = "-1 day"
+
+// incomingWebhookPath is the URL path external services post to
+func incomingWebhookPath(id int64, token string) string {
+	return fmt.Sprintf("/api/webhooks/%d/%s", id, token)
+}
+
+// handleIncomingWebhook formats a payload from an external service and
+// posts it to the webhook's channel. The token in the URL authenticates
+// the sender; a configured secret is additionally checked the way the
+// service signs its requests, and signed requests are only accepted once.
+func (s *Server) handleIncomingWebhook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	var channelID, createdBy int
+	var name, format, token, secret, previousSecret, username string
+	err = s.db.QueryRow(`
+		SELECT w.channel_id, w.created_by, w.name, w.format, w.token, w.secret,
+			CASE WHEN w.secret_rotated_at > datetime('now', ?) THEN w.previous_secret ELSE '' END, u.username
+		FROM incoming_webhooks w
+		JOIN users u ON u.id = w.created_by
+		WHERE w.id = ?`, secretRotationGrace, id,
+	).Scan(&channelID, &createdBy, &name, &format, &token, &secret, &previousSecret, &username)
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Param("token")), []byte(token)) != 1 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	formatter, ok := inbound.Lookup(format)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Webhook format is no longer supported"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+		return
+	}
+	var delivery string
+	if secret != "" {
+		if !formatter.Verify(c.Request.Header, body, secret) &&
+			(previousSecret == "" || !formatter.Verify(c.Request.Header, body, previousSecret)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+			return
+		}
+		delivery = deliveryKey(c.Request.Header, body)
+		if !s.recordDelivery(id, delivery) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Duplicate delivery ignored",
+			})
+			return
+		}
+	}
+
+	message, err := formatter.Format(c.Request.Header, body)
+	if errors.Is(err, inbound.ErrIgnored) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Event ignored",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(message.Alerts) > 0 {
+		alerts := s.filterAlerts(id, message.Alerts)
+		if len(alerts) == 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "No alert changes",
+			})
+			return
+		}
+		message = inbound.AlertMessage(alerts)
+		message.Alerts = alerts
+	}
+	if utf8.RuneCountInString(message.Content) > maxInboundContent {
+		message.Content = string([]rune(message.Content)[:maxInboundContent])
+	}
+
+	// The creator may have left the server since; stop posting for them
+	channel, err := s.lookupChannelForUser(createdBy, channelID)
+	if err != nil || channel.ChannelType == "voice" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if !s.integrationAllowed(channelID, webhookIntegration(id)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This webhook may not post in its channel"})
+		return
+	}
+
+	messageID, err := s.postChannelMessage(channelID, createdBy, username, name, message.Content, message.Embeds)
+	if err != nil {
+		log.Printf("Failed to post incoming webhook %d: %v", id, err)
+		// Let the sender retry the delivery
+		s.forgetDelivery(id, delivery)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	s.recordAlerts(id, message.Alerts, messageID)
+	if _, err := s.db.Exec("UPDATE incoming_webhooks SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		log.Printf("Failed to update incoming webhook %d: %v", id, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"message_id": snowflake.ID(messageID)},
+	})
+}
+
+// filterAlerts drops alerts whose status has not changed since the last
+// notification, and links resolutions to the message that announced them
+func (s *Server) filterAlerts(webhookID int64, alerts []inbound.Alert) []inbound.Alert {
+	if _, err := s.db.Exec(
+		"DELETE FROM alert_states WHERE webhook_id = ? AND status = 'resolved' AND updated_at < datetime('now', ?)",
+		webhookID, alertStateRetention,
+	); err != nil {
+		log.Printf("Failed to prune alert states for webhook %d: %v", webhookID, err)
+	}
+
+	kept := make([]inbound.Alert, 0, len(alerts))
+	seen := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		if seen[alert.Fingerprint] {
+			continue
+		}
+		seen[alert.Fingerprint] = true
+
+		var status string
+		var messageID sql.NullInt64
+		err := s.db.QueryRow(
+			"SELECT status, message_id FROM alert_states WHERE webhook_id = ? AND fingerprint = ?",
+			webhookID, alert.Fingerprint,
+		).Scan(&status, &messageID)
+		if err == nil && status == alert.Status {
+			continue
+		}
+		if alert.Status == inbound.AlertResolved && status == inbound.AlertFiring && messageID.Valid {
+			alert.Embed.Fields = append(alert.Embed.Fields, inbound.Field{
+				Name:  "Firing message",
+				Value: "#" + strconv.FormatInt(messageID.Int64, 10),
+			})
+		}
+		kept = append(kept, alert)
+	}
+	return kept
+}
+
+// recordAlerts remembers the status each alert was last announced with
+func (s *Server) recordAlerts(webhookID int64, alerts []inbound.Alert, messageID int64) {
+	for _, alert := range alerts {
+		if _, err := s.db.Exec(`
+			INSERT INTO alert_states (webhook_id, fingerprint, status, message_id, updated_at)
+			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT (webhook_id, fingerprint) DO UPDATE SET
+				status = excluded.status, message_id = excluded.message_id, updated_at = excluded.updated_at`,
+			webhookID, alert.Fingerprint, alert.Status, messageID,
+		); err != nil {
+			log.Printf("Failed to record alert %s for webhook %d: %v", alert.Fingerprint, webhookID, err)
+		}
+	}
+}
+
+// handleGetIncomingWebhooks lists incoming webhooks for admins
+func (s *Server) handleGetIncomingWebhooks(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT w.id, w.channel_id, COALESCE(ch.name, ''), w.name, w.format, w.token, w.secret != '',
+			COALESCE(u.username, ''), w.created_at, w.last_used_at
+		FROM incoming_webhooks w
+		LEFT JOIN channels ch ON ch.id = w.channel_id
+		LEFT JOIN users u ON u.id = w.created_by
+		ORDER BY w.id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	webhooksList := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var channelID int
+		var signed bool
+		var channelName, name, format, token, createdBy, createdAt string
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&id, &channelID, &channelName, &name, &format, &token, &signed, &createdBy, &createdAt, &lastUsedAt); err != nil {
+			continue
+		}
+		entry := gin.H{
+			"id":           id,
+			"channel_id":   channelID,
+			"channel_name": channelName,
+			"name":         name,
+			"format":       format,
+			"url":          s.externalURL(incomingWebhookPath(id, token)),
+			"signed":       signed,
+			"created_by":   createdBy,
+			"created_at":   createdAt,
+		}
+		if lastUsedAt.Valid {
+			entry["last_used_at"] = lastUsedAt.Time
+		}
+		webhooksList = append(webhooksList, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"webhooks": webhooksList,
+			"formats":  inbound.Formats(),
+		},
+	})
+}
+
+// handleCreateIncomingWebhook creates a webhook URL that posts into a
+// channel. The secret is optional and is what the sending service is
+// configured to sign with (GitHub, Gitea) or send as a token (GitLab).
+func (s *Server) handleCreateIncomingWebhook(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+
+	var req struct {
+		ChannelID int    `json:"channel_id" binding:"required"`
+		Name      string `json:"name" binding:"required"`
+		Format    string `json:"format" binding:"required"`
+		Secret    string `json:"secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-32 characters"})
+		return
+	}
+	if _, ok := inbound.Lookup(req.Format); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of " + strings.Join(inbound.Formats(), ", ")})
+		return
+	}
+	if len(req.Secret) > 256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret must be at most 256 characters"})
+		return
+	}
+
+	channel, err := s.lookupChannelForUser(adminID, req.ChannelID)
+	if err != nil || channel.ChannelType == "voice" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if !respondQuotaCheck(c, s.checkServerQuota(channel.ServerID, quotaWebhooks, 1)) {
+		return
+	}
+
+	token, err := s.auth.GenerateRandomString(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO incoming_webhooks (channel_id, name, format, token, secret, created_by) VALUES (?, ?, ?, ?, ?, ?)",
+		req.ChannelID, req.Name, req.Format, token, req.Secret, adminID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	s.logAdminAction(adminID, "create_incoming_webhook", fmt.Sprintf("Created %s webhook %q for channel %d", req.Format, req.Name, req.ChannelID))
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":         id,
+			"channel_id": req.ChannelID,
+			"name":       req.Name,
+			"format":     req.Format,
+			"url":        s.externalURL(incomingWebhookPath(id, token)),
+			"signed":     req.Secret != "",
+		},
+	})
+}
+
+// handleDeleteIncomingWebhook removes an incoming webhook; its URL stops
+// working immediately
+func (s *Server) handleDeleteIncomingWebhook(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	var name string
+	if err := s.db.QueryRow("SELECT name FROM incoming_webhooks WHERE id = ?", id).Scan(&name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM incoming_webhooks WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM alert_states WHERE webhook_id = ?", id); err != nil {
+		log.Printf("Failed to delete alert states for webhook %d: %v", id, err)
+	}
+	if _, err := s.db.Exec("DELETE FROM channel_integrations WHERE integration = ?", webhookIntegration(id)); err != nil {
+		log.Printf("Failed to delete channel integrations for webhook %d: %v", id, err)
+	}
+
+	s.logAdminAction(adminID, "delete_incoming_webhook", fmt.Sprintf("Deleted webhook %q", name))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook deleted successfully",
+	})
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIncomingWebhook(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("hookowner_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Inbound %d", suffix), userID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'ci')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	token := fmt.Sprintf("token%d", suffix)
+	result, err = db.Exec(
+		"INSERT INTO incoming_webhooks (channel_id, name, format, token, secret, created_by) VALUES (?, 'GitHub', 'github', ?, 'hooksecret', ?)",
+		channelID, token, userID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	hookID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/webhooks/:id/:token", s.handleIncomingWebhook)
+	send := func(path, event, body, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	path := incomingWebhookPath(hookID, token)
+	push := `{"ref":"refs/heads/main","repository":{"full_name":"acme/app"},"pusher":{"name":"alice"},
+		"commits":[{"id":"0123456789","message":"Fix build","url":"https://example.com/c/0123456","author":{"name":"Alice"}}]}`
+
+	if code := send(incomingWebhookPath(hookID, "wrong"), "push", push, "hooksecret"); code != http.StatusNotFound {
+		t.Errorf("Expected wrong token to be rejected, got %d", code)
+	}
+	if code := send(path, "push", push, "other"); code != http.StatusUnauthorized {
+		t.Errorf("Expected bad signature to be rejected, got %d", code)
+	}
+	if code := send(path, "star", `{}`, "hooksecret"); code != http.StatusOK {
+		t.Errorf("Expected ignored event to succeed, got %d", code)
+	}
+	if code := send(path, "push", push, "hooksecret"); code != http.StatusOK {
+		t.Fatalf("Expected push to be posted, got %d", code)
+	}
+
+	var count int
+	var content, botName, embeds string
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE channel_id = ?", channelID).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected exactly one message, got %d (%v)", count, err)
+	}
+	err = db.QueryRow("SELECT content, bot_name, embeds FROM messages WHERE channel_id = ?", channelID).Scan(&content, &botName, &embeds)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if content != "alice pushed 1 commit to acme/app:main" || botName != "GitHub" || !strings.Contains(embeds, "Fix build") {
+		t.Errorf("Unexpected message %q by %q with embeds %s", content, botName, embeds)
+	}
+
+	// A replayed delivery is acknowledged but not posted again
+	if code := send(path, "push", push, "hooksecret"); code != http.StatusOK {
+		t.Errorf("Expected a replay to be acknowledged, got %d", code)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE channel_id = ?", channelID).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the replay not to be posted, got %d messages (%v)", count, err)
+	}
+
+	// After a rotation both secrets verify until the grace period ends
+	router.POST("/admin/webhooks/:id/secret", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		s.handleRotateIncomingWebhookSecret(c)
+	})
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/webhooks/%d/secret", hookID), strings.NewReader(`{"secret":"rotated"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "previous_expires_at") {
+		t.Fatalf("Expected the secret to rotate, got %d %s", w.Code, w.Body.String())
+	}
+	for i, secret := range []string{"rotated", "hooksecret"} {
+		tag := fmt.Sprintf(`{"ref":"refs/tags/v%d","ref_type":"tag","repository":{"full_name":"acme/app"},"sender":{"login":"alice"}}`, i)
+		if code := send(path, "create", tag, secret); code != http.StatusOK {
+			t.Errorf("Expected %s to verify after rotation, got %d", secret, code)
+		}
+	}
+	if _, err := db.Exec("UPDATE incoming_webhooks SET secret_rotated_at = datetime('now', '-2 days') WHERE id = ?", hookID); err != nil {
+		t.Fatalf("Failed to age rotation: %v", err)
+	}
+	if code := send(path, "create", `{"ref":"v9"}`, "hooksecret"); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old secret to stop working after the grace period, got %d", code)
+	}
+}
+
+func TestIncomingAlertDeduplication(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("alertowner_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Alerts %d", suffix), userID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'alerts')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	token := fmt.Sprintf("alerts%d", suffix)
+	result, err = db.Exec(
+		"INSERT INTO incoming_webhooks (channel_id, name, format, token, created_by) VALUES (?, 'Alertmanager', 'alertmanager', ?, ?)",
+		channelID, token, userID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	hookID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/webhooks/:id/:token", s.handleIncomingWebhook)
+	send := func(status string) {
+		body := fmt.Sprintf(`{"status":%q,"alerts":[{"status":%q,"fingerprint":"f1","labels":{"alertname":"NodeDown"}}]}`, status, status)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, incomingWebhookPath(hookID, token), strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %s notification to succeed, got %d: %s", status, w.Code, w.Body.String())
+		}
+	}
+	messages := func() []string {
+		rows, err := db.Query("SELECT id, embeds FROM messages WHERE channel_id = ? ORDER BY id", channelID)
+		if err != nil {
+			t.Fatalf("Failed to read messages: %v", err)
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		var list []string
+		for rows.Next() {
+			var id int64
+			var embeds string
+			_ = rows.Scan(&id, &embeds)
+			list = append(list, fmt.Sprintf("%d %s", id, embeds))
+		}
+		return list
+	}
+
+	send("firing")
+	send("firing") // repeat_interval resend
+	if list := messages(); len(list) != 1 {
+		t.Fatalf("Expected repeated firing alert to be dropped, got %d messages", len(list))
+	}
+	firingID := strings.Fields(messages()[0])[0]
+
+	send("resolved")
+	send("resolved")
+	list := messages()
+	if len(list) != 2 {
+		t.Fatalf("Expected one resolve notification, got %d messages", len(list))
+	}
+	if !strings.Contains(list[1], `"Firing message","value":"#`+firingID+`"`) {
+		t.Errorf("Expected resolution to reference message %s, got %s", firingID, list[1])
+	}
+
+	send("firing")
+	if list := messages(); len(list) != 3 {
+		t.Errorf("Expected alert firing again to be posted, got %d messages", len(list))
+	}
+}
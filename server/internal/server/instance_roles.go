@@ -0,0 +1,345 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// instanceRoleNamePattern is the form of custom instance role names
+var instanceRoleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// instanceRoleRequest creates or changes a custom instance role. Fields
+// left out are not changed.
+type instanceRoleRequest struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// validate normalizes the name and checks every field present
+func (r *instanceRoleRequest) validate() error {
+	if r.Name != nil {
+		*r.Name = strings.ToLower(strings.TrimSpace(*r.Name))
+		if !instanceRoleNamePattern.MatchString(*r.Name) {
+			return errors.New("name must be 2 to 32 lowercase letters, digits or underscores, starting with a letter")
+		}
+	}
+	if r.Description != nil {
+		*r.Description = strings.TrimSpace(*r.Description)
+		if len(*r.Description) > 200 {
+			return errors.New("description must be at most 200 characters")
+		}
+	}
+	if r.Permissions != nil {
+		for _, permission := range *r.Permissions {
+			if !isValidCapability(permission) {
+				return fmt.Errorf("invalid permission: %s", permission)
+			}
+		}
+	}
+	return nil
+}
+
+// rolePermissions returns the permissions mapped to an instance role
+func (s *Server) rolePermissions(role string) ([]string, error) {
+	rows, err := s.db.Query("SELECT permission FROM instance_role_permissions WHERE role = ? ORDER BY permission", role)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	permissions := make([]string, 0)
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err == nil {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, rows.Err()
+}
+
+// setRolePermissions replaces the permissions mapped to an instance role
+func (s *Server) setRolePermissions(tx *sql.Tx, role string, permissions []string) error {
+	if _, err := tx.Exec("DELETE FROM instance_role_permissions WHERE role = ?", role); err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO instance_role_permissions (role, permission) VALUES (?, ?)", role, permission,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleAssignable reports whether users may be given a role. Guests are
+// only created by guest sign-in.
+func (s *Server) roleAssignable(role string) bool {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM instance_roles WHERE name = ?)", role).Scan(&exists)
+	return err == nil && exists && role != "guest"
+}
+
+// loadInstanceRole returns a role with its permissions and how many users
+// hold it
+func (s *Server) loadInstanceRole(name string) (gin.H, error) {
+	var description string
+	var builtIn bool
+	var users int
+	err := s.db.QueryRow(`
+		SELECT r.description, r.built_in, (SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		FROM instance_roles r WHERE r.name = ?`, name,
+	).Scan(&description, &builtIn, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.rolePermissions(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "super_admin" {
+		permissions = append([]string(nil), allCapabilities...)
+	}
+	return gin.H{
+		"name":        name,
+		"description": description,
+		"built_in":    builtIn,
+		"permissions": permissions,
+		"users":       users,
+	}, nil
+}
+
+// handleGetInstanceRoles lists the instance roles with their permissions;
+// super admins only
+func (s *Server) handleGetInstanceRoles(c *gin.Context) {
+	rows, err := s.db.Query("SELECT name FROM instance_roles ORDER BY built_in DESC, name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	roles := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		role, err := s.loadInstanceRole(name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+			return
+		}
+		roles = append(roles, role)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"available": allCapabilities,
+			"roles":     roles,
+		},
+	})
+}
+
+// handleCreateInstanceRole defines a custom role; super admins only
+func (s *Server) handleCreateInstanceRole(c *gin.Context) {
+	var req instanceRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var description string
+	var permissions []string
+	if req.Description != nil {
+		description = *req.Description
+	}
+	if req.Permissions != nil {
+		permissions = *req.Permissions
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec(
+		"INSERT INTO instance_roles (name, description) VALUES (?, ?)", *req.Name, description,
+	); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+		return
+	}
+	if err := s.setRolePermissions(tx, *req.Name, permissions); err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	sorted := append([]string(nil), permissions...)
+	sort.Strings(sorted)
+	s.logAdminAction(c.GetInt("user_id"), "create_role", fmt.Sprintf("Created role %s with permissions [%s]", *req.Name, strings.Join(sorted, ", ")))
+
+	role, err := s.loadInstanceRole(*req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load role"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": role})
+}
+
+// handleUpdateInstanceRole changes a role's description or permissions;
+// super admins only. The admin role's permissions are what new admins are
+// granted; the other built-in roles cannot be changed.
+func (s *Server) handleUpdateInstanceRole(c *gin.Context) {
+	name := c.Param("name")
+	var req instanceRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Roles cannot be renamed"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var builtIn bool
+	if err := s.db.QueryRow("SELECT built_in FROM instance_roles WHERE name = ?", name).Scan(&builtIn); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if builtIn && name != "admin" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles other than admin cannot be changed"})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if req.Description != nil {
+		if _, err := tx.Exec("UPDATE instance_roles SET description = ? WHERE name = ?", *req.Description, name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+	}
+	if req.Permissions != nil {
+		if err := s.setRolePermissions(tx, name, *req.Permissions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	if req.Permissions != nil {
+		sorted := append([]string(nil), *req.Permissions...)
+		sort.Strings(sorted)
+		s.logAdminAction(c.GetInt("user_id"), "update_role_permissions", fmt.Sprintf("Set permissions of role %s to [%s]", name, strings.Join(sorted, ", ")))
+	}
+
+	role, err := s.loadInstanceRole(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load role"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": role})
+}
+
+// handleDeleteInstanceRole deletes a custom role, making its users regular
+// users; super admins only
+func (s *Server) handleDeleteInstanceRole(c *gin.Context) {
+	name := c.Param("name")
+	var builtIn bool
+	if err := s.db.QueryRow("SELECT built_in FROM instance_roles WHERE name = ?", name).Scan(&builtIn); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if builtIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+
+	rows, err := s.db.Query("SELECT id FROM users WHERE role = ?", name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	var holders []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			holders = append(holders, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, query := range []string{
+		"UPDATE users SET role = 'user', updated_at = CURRENT_TIMESTAMP WHERE role = ?",
+		"DELETE FROM instance_role_permissions WHERE role = ?",
+		"DELETE FROM instance_roles WHERE name = ?",
+	} {
+		if _, err := tx.Exec(query, name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	for _, id := range holders {
+		s.bumpUserMemberships(id)
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "delete_role", fmt.Sprintf("Deleted role %s; %d users became regular users", name, len(holders)))
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role deleted successfully"})
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCustomInstanceRoles(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"super", "target"} {
+		role := "user"
+		if name == "super" {
+			role = "super_admin"
+		}
+		result, err := db.Exec(
+			"INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', ?)",
+			fmt.Sprintf("rbac%s_%d", name, suffix), role,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[name] = int(id)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		protected := router.Group("/")
+		protected.Use(func(c *gin.Context) {
+			c.Set("user_id", users[user])
+		})
+		protected.POST("/admin/roles", s.superAdminMiddleware(), s.handleCreateInstanceRole)
+		protected.PUT("/admin/roles/:name", s.superAdminMiddleware(), s.handleUpdateInstanceRole)
+		protected.DELETE("/admin/roles/:name", s.superAdminMiddleware(), s.handleDeleteInstanceRole)
+		protected.POST("/admin/users/:id/role", s.requirePermission(capManageUsers), s.handleUpdateUserRole)
+		protected.GET("/admin/metrics", s.requirePermission(capViewMetrics), func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	// Built-in roles are seeded, and new admins get the admin role's permissions
+	if permissions, err := s.rolePermissions("admin"); err != nil || len(permissions) == 0 {
+		t.Fatalf("Expected the admin role to have default permissions, got %v (%v)", permissions, err)
+	}
+	if w := request("super", "PUT", "/admin/roles/user", `{"permissions":["view_metrics"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the user role to be fixed, got %d", w.Code)
+	}
+	if w := request("super", "DELETE", "/admin/roles/admin", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected built-in roles not to be deleted, got %d", w.Code)
+	}
+
+	// A custom role grants exactly its permissions
+	role := fmt.Sprintf("auditor_%d", suffix%1000000)
+	w := request("super", "POST", "/admin/roles", fmt.Sprintf(`{"name":"%s","permissions":["view_metrics"]}`, role))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the role to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("super", "POST", "/admin/roles", `{"name":"Bad Name!"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid names to be refused, got %d", w.Code)
+	}
+	if w := request("super", "POST", "/admin/roles", `{"name":"spies","permissions":["read_minds"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown permissions to be refused, got %d", w.Code)
+	}
+	if w := request("target", "GET", "/admin/metrics", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected regular users to be refused, got %d", w.Code)
+	}
+	path := fmt.Sprintf("/admin/users/%d/role", users["target"])
+	if w := request("super", "POST", path, `{"role":"guest"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the guest role not to be assignable, got %d", w.Code)
+	}
+	if w := request("super", "POST", path, fmt.Sprintf(`{"role":"%s"}`, role)); w.Code != http.StatusOK {
+		t.Fatalf("Expected the custom role to be assigned, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("target", "GET", "/admin/metrics", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the custom role to grant view_metrics, got %d", w.Code)
+	}
+	if w := request("target", "POST", path, `{"role":"user"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the custom role not to grant manage_users, got %d", w.Code)
+	}
+
+	// Changing the role's permissions applies to its users at once
+	if w := request("super", "PUT", "/admin/roles/"+role, `{"permissions":["moderate_content"]}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the role to be updated, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, permissions, _ := s.userCapabilities(users["target"]); !reflect.DeepEqual(permissions, []string{capModerate}) {
+		t.Errorf("Expected the new permissions to apply, got %v", permissions)
+	}
+
+	// Deleting the role makes its users regular users
+	if w := request("super", "DELETE", "/admin/roles/"+role, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the role to be deleted, got %d: %s", w.Code, w.Body.String())
+	}
+	if r, permissions, _ := s.userCapabilities(users["target"]); r != "user" || len(permissions) != 0 {
+		t.Errorf("Expected a regular user without permissions, got %s %v", r, permissions)
+	}
+}
+
+func TestPrivilegedTargets(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, role := range []string{"super_admin", "admin", "user"} {
+		result, err := db.Exec(
+			"INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', ?)",
+			fmt.Sprintf("target%s_%d", role, suffix), role,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[role] = int(id)
+	}
+	s.syncRoleCapabilities(users["admin"], "admin", users["super_admin"])
+
+	// A custom role that may only moderate
+	moderatorRole := fmt.Sprintf("moderator_%d", suffix%1000000)
+	if _, err := db.Exec("INSERT INTO instance_roles (name) VALUES (?)", moderatorRole); err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO instance_role_permissions (role, permission) VALUES (?, ?)", moderatorRole, capModerate); err != nil {
+		t.Fatalf("Failed to grant permission: %v", err)
+	}
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', ?)",
+		fmt.Sprintf("targetmoderator_%d", suffix), moderatorRole)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	users["moderator"] = int(id)
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		admin := router.Group("/admin")
+		admin.Use(func(c *gin.Context) {
+			c.Set("user_id", users[user])
+		})
+		manageUsers := s.requirePermission(capManageUsers)
+		privileged := s.privilegedTargetMiddleware()
+		admin.PUT("/users/:id", manageUsers, privileged, s.handleUpdateUser)
+		admin.DELETE("/users/:id", manageUsers, privileged, s.handleDeleteUser)
+		admin.DELETE("/users/:id/2fa", manageUsers, privileged, s.handleAdminResetTwoFactor)
+		admin.POST("/users/:id/logout", manageUsers, privileged, s.handleAdminLogoutUser)
+		moderate := s.requirePermission(capModerate)
+		admin.POST("/users/:id/kick", moderate, privileged, s.handleKickUser)
+		admin.POST("/users/:id/ban", moderate, privileged, s.handleBanUser)
+		admin.POST("/users/:id/mute", moderate, privileged, s.handleMuteUser)
+		admin.POST("/users/:id/unban", moderate, privileged, s.handleUnbanUser)
+		admin.POST("/users/:id/unmute", moderate, privileged, s.handleUnmuteUser)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+	superPath := fmt.Sprintf("/admin/users/%d", users["super_admin"])
+	password := `{"password":"Takeover-Passw0rd!"}`
+
+	// An admin can neither take over a super admin's account nor remove it
+	for _, tc := range []struct{ method, path, body string }{
+		{"PUT", superPath, password},
+		{"DELETE", superPath + "/2fa", ""},
+		{"POST", superPath + "/logout", ""},
+		{"DELETE", superPath, ""},
+	} {
+		if w := request("admin", tc.method, tc.path, tc.body); w.Code != http.StatusForbidden {
+			t.Errorf("Expected %s %s by an admin to be refused, got %d: %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", users["super_admin"]).Scan(&hash); err != nil || hash != "x" {
+		t.Errorf("Expected the super admin's password unchanged, got %q (%v)", hash, err)
+	}
+
+	// Plain users are still theirs to manage, and admins are a super admin's
+	if w := request("admin", "DELETE", fmt.Sprintf("/admin/users/%d/2fa", users["user"]), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected an admin to reset a user's 2FA, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("super_admin", "PUT", fmt.Sprintf("/admin/users/%d", users["admin"]), password); w.Code != http.StatusOK {
+		t.Errorf("Expected a super admin to set an admin's password, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Moderators cannot kick, ban or mute admins either
+	for _, target := range []string{"admin", "super_admin"} {
+		for _, action := range []string{"kick", "ban", "mute", "unban", "unmute"} {
+			path := fmt.Sprintf("/admin/users/%d/%s", users[target], action)
+			if w := request("moderator", "POST", path, `{"reason":"test","duration":1}`); w.Code != http.StatusForbidden {
+				t.Errorf("Expected a moderator to be refused %s of the %s, got %d: %s", action, target, w.Code, w.Body.String())
+			}
+		}
+	}
+	var bans int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_bans WHERE user_id = ?", users["admin"]).Scan(&bans); err != nil || bans != 0 {
+		t.Errorf("Expected the admin not to be banned, got %d bans (%v)", bans, err)
+	}
+	if w := request("moderator", "POST", fmt.Sprintf("/admin/users/%d/ban", users["user"]), `{"reason":"spam"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected a moderator to ban a user, got %d: %s", w.Code, w.Body.String())
+	}
+}
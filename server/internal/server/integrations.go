@@ -0,0 +1,293 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Channel integration modes. In an open channel every integration may
+// post; an allowlist admits only the listed ones and a denylist refuses
+// them.
+const (
+	integrationModeOpen      = "open"
+	integrationModeAllowlist = "allowlist"
+	integrationModeDenylist  = "denylist"
+)
+
+// maxChannelIntegrations bounds a channel's integration list
+const maxChannelIntegrations = 100
+
+// Integrations are named by kind and ID: an incoming webhook by its ID, a
+// slash command by its name and an automation API bot by its user ID.
+func webhookIntegration(id int64) string {
+	return "webhook:" + strconv.FormatInt(id, 10)
+}
+
+func commandIntegration(command string) string {
+	return "command:" + command
+}
+
+func botIntegration(userID int) string {
+	return "bot:" + strconv.Itoa(userID)
+}
+
+// validIntegration checks the kind:id form of an integration entry
+func validIntegration(entry string) bool {
+	kind, id, ok := strings.Cut(entry, ":")
+	if !ok {
+		return false
+	}
+	switch kind {
+	case "webhook", "bot":
+		n, err := strconv.ParseInt(id, 10, 64)
+		return err == nil && n > 0 && strconv.FormatInt(n, 10) == id
+	case "command":
+		return commandNamePattern.MatchString(id)
+	}
+	return false
+}
+
+// integrationAllowed reports whether an integration may post in a channel
+func (s *Server) integrationAllowed(channelID int, integration string) bool {
+	var mode string
+	if err := s.db.QueryRow("SELECT integration_mode FROM channels WHERE id = ?", channelID).Scan(&mode); err != nil {
+		return false
+	}
+	if mode != integrationModeAllowlist && mode != integrationModeDenylist {
+		return true
+	}
+	var listed bool
+	if err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM channel_integrations WHERE channel_id = ? AND integration = ?)",
+		channelID, integration,
+	).Scan(&listed); err != nil {
+		log.Printf("Failed to check integrations for channel %d: %v", channelID, err)
+		return false
+	}
+	return listed == (mode == integrationModeAllowlist)
+}
+
+// loadChannelIntegrations returns a channel's integration mode and list
+func (s *Server) loadChannelIntegrations(channelID int) (string, []string, error) {
+	var mode string
+	if err := s.db.QueryRow("SELECT integration_mode FROM channels WHERE id = ?", channelID).Scan(&mode); err != nil {
+		return "", nil, err
+	}
+	rows, err := s.db.Query(
+		"SELECT integration FROM channel_integrations WHERE channel_id = ? ORDER BY integration", channelID,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	integrations := make([]string, 0)
+	for rows.Next() {
+		var integration string
+		if err := rows.Scan(&integration); err == nil {
+			integrations = append(integrations, integration)
+		}
+	}
+	return mode, integrations, rows.Err()
+}
+
+// handleGetChannelIntegrations returns which integrations may post in a
+// channel; server owners and admins only
+func (s *Server) handleGetChannelIntegrations(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	mode, integrations, err := s.loadChannelIntegrations(channelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get integrations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"integration_mode": mode,
+			"integrations":     integrations,
+		},
+	})
+}
+
+// channelSettings returns a channel as PATCH /channels/:channelId edits it,
+// with its version
+func (s *Server) channelSettings(channelID int) (gin.H, int64, error) {
+	var serverID int
+	var version int64
+	var name, channelType, mode string
+	var public, nsfw bool
+	if err := s.db.QueryRow(
+		"SELECT server_id, name, channel_type, integration_mode, public, nsfw, version FROM channels WHERE id = ?", channelID,
+	).Scan(&serverID, &name, &channelType, &mode, &public, &nsfw, &version); err != nil {
+		return nil, 0, err
+	}
+	_, integrations, err := s.loadChannelIntegrations(channelID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return gin.H{
+		"id":               channelID,
+		"name":             name,
+		"server_id":        serverID,
+		"channel_type":     channelType,
+		"integration_mode": mode,
+		"integrations":     integrations,
+		"public":           public,
+		"nsfw":             nsfw,
+		"version":          version,
+	}, version, nil
+}
+
+// handleUpdateChannel renames a channel, changes which integrations may
+// post in it, publishes it read-only or marks it NSFW; server owners and
+// admins only. All
+// fields are optional, and integrations replaces the whole list. The edit
+// must name the version it was made against; see checkVersion.
+func (s *Server) handleUpdateChannel(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	if !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req struct {
+		Name            *string   `json:"name"`
+		IntegrationMode *string   `json:"integration_mode"`
+		Integrations    *[]string `json:"integrations"`
+		Public          *bool     `json:"public"`
+		NSFW            *bool     `json:"nsfw"`
+		Version         *int64    `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var serverID int
+	var version int64
+	var name, channelType, mode string
+	var private, public, nsfw bool
+	if err := s.db.QueryRow(
+		"SELECT server_id, name, channel_type, integration_mode, private, public, nsfw, version FROM channels WHERE id = ?", channelID,
+	).Scan(&serverID, &name, &channelType, &mode, &private, &public, &nsfw, &version); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" || utf8.RuneCountInString(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-100 characters"})
+			return
+		}
+	}
+	if req.IntegrationMode != nil {
+		mode = *req.IntegrationMode
+		if mode != integrationModeOpen && mode != integrationModeAllowlist && mode != integrationModeDenylist {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "integration_mode must be open, allowlist or denylist"})
+			return
+		}
+	}
+	if req.Public != nil {
+		public = *req.Public
+		if public && (channelType != "text" || private) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only text channels that are not private can be public"})
+			return
+		}
+	}
+	if req.NSFW != nil {
+		nsfw = *req.NSFW
+	}
+	// Anyone can read a public channel, so its age cannot be checked
+	if public && nsfw {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An NSFW channel cannot be public"})
+		return
+	}
+	if req.Integrations != nil {
+		if len(*req.Integrations) > maxChannelIntegrations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At most 100 integrations per channel"})
+			return
+		}
+		for _, entry := range *req.Integrations {
+			if !validIntegration(entry) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Integrations must look like webhook:<id>, command:<name> or bot:<user id>"})
+				return
+			}
+		}
+	}
+
+	current, _, err := s.channelSettings(channelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+	if !checkVersion(c, req.Version, version, current) {
+		return
+	}
+
+	// The version guard catches an edit that landed since the check
+	result, err := s.db.Exec(
+		"UPDATE channels SET name = ?, integration_mode = ?, public = ?, nsfw = ?, version = version + 1 WHERE id = ? AND version = ?",
+		name, mode, public, nsfw, channelID, version,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		if current, version, err := s.channelSettings(channelID); err == nil {
+			versionConflict(c, version, current)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if req.Integrations != nil {
+		if _, err := s.db.Exec("DELETE FROM channel_integrations WHERE channel_id = ?", channelID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update integrations"})
+			return
+		}
+		for _, entry := range *req.Integrations {
+			if _, err := s.db.Exec(
+				"INSERT OR IGNORE INTO channel_integrations (channel_id, integration) VALUES (?, ?)", channelID, entry,
+			); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update integrations"})
+				return
+			}
+		}
+	}
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.markWrite(userID)
+
+	updated, version, err := s.channelSettings(channelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load channel"})
+		return
+	}
+	c.Header("ETag", versionETag(version))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    updated,
+	})
+}
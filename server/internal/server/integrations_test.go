@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChannelIntegrationLists(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "bot", "member"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("ci%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Integrations %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, id := range users {
+		role := "member"
+		if name == "owner" {
+			role = "owner"
+		}
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", id, serverID, role); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, 'alerts', 'text')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	command := fmt.Sprintf("ci%d", suffix%1000000)
+	if _, err := db.Exec(
+		"INSERT INTO command_webhooks (command, url, secret) VALUES (?, 'http://127.0.0.1:1/command', 'x')", command,
+	); err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", user)
+		})
+		router.PATCH("/channels/:channelId", s.handleUpdateChannel)
+		router.POST("/channels/:channelId/commands", s.handleRunCommand)
+		router.POST("/automation/v1/channels/:channelId/messages", s.handleAutomationPostMessage)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	channelPath := fmt.Sprintf("/channels/%d", channelID)
+	post := func() int {
+		return request("bot", "POST", "/automation/v1"+channelPath+"/messages", `{"content":"hi","bot_name":"Alerts"}`).Code
+	}
+
+	// Channels start open
+	if code := post(); code != http.StatusCreated {
+		t.Fatalf("Expected bots to post in an open channel, got %d", code)
+	}
+	if w := request("member", "PATCH", channelPath, `{"integration_mode":"denylist"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to change the channel, got %d", w.Code)
+	}
+	if w := request("owner", "PATCH", channelPath, `{"integrations":["bot:abc"]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a malformed entry to be rejected, got %d", w.Code)
+	}
+	if w := request("owner", "PATCH", channelPath, `{"integration_mode":"closed"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown mode to be rejected, got %d", w.Code)
+	}
+
+	// A denylist refuses the listed bot and command
+	body := fmt.Sprintf(`{"integration_mode":"denylist","integrations":["bot:%d","command:%s"],"version":1}`, users["bot"], command)
+	if w := request("owner", "PATCH", channelPath, body); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update channel: %d %s", w.Code, w.Body.String())
+	}
+	if code := post(); code != http.StatusForbidden {
+		t.Fatalf("Expected a denied bot to be refused, got %d", code)
+	}
+	if w := request("member", "POST", channelPath+"/commands", fmt.Sprintf(`{"command":"/%s"}`, command)); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a denied command to be refused, got %d", w.Code)
+	}
+	if !s.integrationAllowed(int(channelID), webhookIntegration(1)) {
+		t.Fatal("Expected unlisted webhooks to post under a denylist")
+	}
+
+	// An allowlist admits only the listed ones
+	body = fmt.Sprintf(`{"integration_mode":"allowlist","integrations":["bot:%d"],"version":2}`, users["bot"])
+	if w := request("owner", "PATCH", channelPath, body); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update channel: %d %s", w.Code, w.Body.String())
+	}
+	if code := post(); code != http.StatusCreated {
+		t.Fatalf("Expected an allowed bot to post, got %d", code)
+	}
+	if s.integrationAllowed(int(channelID), webhookIntegration(1)) || s.integrationAllowed(int(channelID), commandIntegration(command)) {
+		t.Fatal("Expected unlisted integrations to be refused under an allowlist")
+	}
+
+	// Renaming keeps the list
+	if w := request("owner", "PATCH", channelPath, `{"name":"ops","version":3}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to rename channel: %d", w.Code)
+	}
+	mode, integrations, err := s.loadChannelIntegrations(int(channelID))
+	if err != nil || mode != integrationModeAllowlist || len(integrations) != 1 {
+		t.Fatalf("Expected the allowlist to survive a rename, got %s %v (%v)", mode, integrations, err)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registration invites let people sign up while the auth mode is
+// invite_only. Each code belongs to the organization of the admin who
+// created it.
+const (
+	// inviteCodeLength makes codes too many to guess
+	inviteCodeLength = 12
+
+	// maxInviteHours bounds how long an invite may stay valid
+	maxInviteHours = 30 * 24
+)
+
+// generateInviteCode returns a random code in the readable alphabet of
+// guest names
+func generateInviteCode() (string, error) {
+	random := make([]byte, inviteCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, inviteCodeLength)
+	for _, b := range random {
+		code = append(code, guestNameAlphabet[int(b)%len(guestNameAlphabet)])
+	}
+	return string(code), nil
+}
+
+// handleGetInvites lists the invites of the caller's organization
+func (s *Server) handleGetInvites(c *gin.Context) {
+	rows, err := s.db.Query(`
+		SELECT i.id, i.code, i.max_uses, i.uses, i.expires_at, i.created_at, COALESCE(u.username, '')
+		FROM registration_invites i LEFT JOIN users u ON u.id = i.created_by
+		WHERE i.org_id = ?
+		ORDER BY i.id DESC`, c.GetInt("org_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invites"})
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	invites := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var code, createdBy string
+		var maxUses, uses int
+		var expiresAt sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(&id, &code, &maxUses, &uses, &expiresAt, &createdAt, &createdBy); err != nil {
+			continue
+		}
+		invite := gin.H{
+			"id":         id,
+			"code":       code,
+			"max_uses":   maxUses,
+			"uses":       uses,
+			"expires_at": nil,
+			"created_by": createdBy,
+			"created_at": createdAt,
+			"usable":     (maxUses == 0 || uses < maxUses) && (!expiresAt.Valid || expiresAt.Time.After(now)),
+		}
+		if expiresAt.Valid {
+			invite["expires_at"] = expiresAt.Time
+		}
+		invites = append(invites, invite)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": invites})
+}
+
+// handleCreateInvite creates an invite code for the caller's organization
+func (s *Server) handleCreateInvite(c *gin.Context) {
+	req := struct {
+		MaxUses        int `json:"max_uses"`
+		ExpiresInHours int `json:"expires_in_hours"`
+	}{MaxUses: 1}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must not be negative"})
+		return
+	}
+	if req.ExpiresInHours < 0 || req.ExpiresInHours > maxInviteHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in_hours must be 0-%d", maxInviteHours)})
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInHours > 0 {
+		expiry := time.Now().UTC().Add(time.Duration(req.ExpiresInHours) * time.Hour).Truncate(time.Second)
+		expiresAt = &expiry
+	}
+
+	code, err := generateInviteCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+	adminID := c.GetInt("user_id")
+	result, err := s.db.Exec(
+		"INSERT INTO registration_invites (code, org_id, created_by, max_uses, expires_at) VALUES (?, ?, ?, ?, ?)",
+		code, c.GetInt("org_id"), adminID, req.MaxUses, expiresAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	s.logAdminAction(adminID, "create_invite", fmt.Sprintf("Created invite %d for %d uses", id, req.MaxUses))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":         id,
+			"code":       code,
+			"max_uses":   req.MaxUses,
+			"uses":       0,
+			"expires_at": expiresAt,
+		},
+	})
+}
+
+// handleDeleteInvite revokes an invite of the caller's organization
+func (s *Server) handleDeleteInvite(c *gin.Context) {
+	result, err := s.db.Exec(
+		"DELETE FROM registration_invites WHERE id = ? AND org_id = ?", c.Param("id"), c.GetInt("org_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	s.logAdminAction(c.GetInt("user_id"), "delete_invite", fmt.Sprintf("Revoked invite %s", c.Param("id")))
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Invite revoked"})
+}
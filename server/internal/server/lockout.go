@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login lockout. Failed sign-ins are counted per username and per client
+// IP. Each failure doubles the wait before the next attempt is accepted,
+// and a username or IP that reaches its threshold is locked out for a
+// while. Counts live in memory, so a restart forgets them.
+
+const (
+	defaultLoginBackoffSeconds     = 1
+	defaultLoginLockoutThreshold   = 5
+	defaultLoginLockoutIPThreshold = 20
+	defaultLoginLockoutMinutes     = 15
+
+	// maxLoginFailureKeys bounds how many usernames and IPs are tracked
+	// before expired ones are dropped
+	maxLoginFailureKeys = 10000
+
+	loginFailureUserPrefix = "user:"
+	loginFailureIPPrefix   = "ip:"
+)
+
+// loginLockoutPolicy is how failed sign-ins are slowed down and locked out.
+// A zero threshold or backoff turns that part off; a zero lockout forgets
+// failures at once, turning off both.
+type loginLockoutPolicy struct {
+	Backoff     time.Duration
+	Threshold   int
+	IPThreshold int
+	Lockout     time.Duration
+}
+
+type loginFailure struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard tracks failed sign-ins. A nil guard allows everything.
+type loginGuard struct {
+	mu       sync.Mutex
+	policy   loginLockoutPolicy
+	failures map[string]*loginFailure
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{
+		policy: loginLockoutPolicy{
+			Backoff:     defaultLoginBackoffSeconds * time.Second,
+			Threshold:   defaultLoginLockoutThreshold,
+			IPThreshold: defaultLoginLockoutIPThreshold,
+			Lockout:     defaultLoginLockoutMinutes * time.Minute,
+		},
+		failures: make(map[string]*loginFailure),
+	}
+}
+
+// setPolicy replaces the policy; counts so far are kept
+func (g *loginGuard) setPolicy(policy loginLockoutPolicy) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = policy
+}
+
+// threshold returns the failures that lock out a key
+func (g *loginGuard) threshold(key string) int {
+	if strings.HasPrefix(key, loginFailureIPPrefix) {
+		return g.policy.IPThreshold
+	}
+	return g.policy.Threshold
+}
+
+// expired reports whether a key's failures no longer count, because its
+// lockout is over or it has been quiet for a lockout period
+func (g *loginGuard) expired(f *loginFailure, now time.Time) bool {
+	if !f.lockedUntil.IsZero() {
+		return !now.Before(f.lockedUntil)
+	}
+	return now.Sub(f.last) >= g.policy.Lockout
+}
+
+// wait returns how long a key must wait before its next attempt, and
+// whether that is because it is locked out rather than backing off
+func (g *loginGuard) wait(key string, now time.Time) (time.Duration, bool) {
+	if g == nil {
+		return 0, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.failures[key]
+	if !ok {
+		return 0, false
+	}
+	if g.expired(f, now) {
+		delete(g.failures, key)
+		return 0, false
+	}
+	if !f.lockedUntil.IsZero() {
+		return f.lockedUntil.Sub(now), true
+	}
+	if g.policy.Backoff <= 0 {
+		return 0, false
+	}
+	delay := g.policy.Backoff
+	for i := 1; i < f.count && delay < g.policy.Lockout; i++ {
+		delay *= 2
+	}
+	delay = min(delay, g.policy.Lockout)
+	if remaining := f.last.Add(delay).Sub(now); remaining > 0 {
+		return remaining, false
+	}
+	return 0, false
+}
+
+// fail counts a failed attempt for a key and reports whether it locked the
+// key out, along with the failures so far
+func (g *loginGuard) fail(key string, now time.Time) (bool, int) {
+	if g == nil {
+		return false, 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.failures[key]
+	if ok && g.expired(f, now) {
+		ok = false
+	}
+	if !ok {
+		if len(g.failures) >= maxLoginFailureKeys {
+			for other, failure := range g.failures {
+				if g.expired(failure, now) {
+					delete(g.failures, other)
+				}
+			}
+		}
+		f = &loginFailure{}
+		g.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if threshold := g.threshold(key); threshold > 0 && f.count >= threshold && f.lockedUntil.IsZero() {
+		f.lockedUntil = now.Add(g.policy.Lockout)
+		return true, f.count
+	}
+	return false, f.count
+}
+
+// reset forgets a key's failures
+func (g *loginGuard) reset(key string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, key)
+}
+
+// lockout returns how long a lockout lasts
+func (g *loginGuard) lockout() time.Duration {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.policy.Lockout
+}
+
+// applyLoginLockoutPolicy loads the lockout settings into the login guard
+func (s *Server) applyLoginLockoutPolicy() {
+	s.loginFailures.setPolicy(loginLockoutPolicy{
+		Backoff:     time.Duration(s.getIntSetting("login_backoff_seconds", defaultLoginBackoffSeconds)) * time.Second,
+		Threshold:   s.getIntSetting("login_lockout_threshold", defaultLoginLockoutThreshold),
+		IPThreshold: s.getIntSetting("login_lockout_ip_threshold", defaultLoginLockoutIPThreshold),
+		Lockout:     time.Duration(s.getIntSetting("login_lockout_minutes", defaultLoginLockoutMinutes)) * time.Minute,
+	})
+}
+
+// allowLoginAttempt refuses a sign-in while the username or the client's IP
+// is backing off or locked out
+func (s *Server) allowLoginAttempt(c *gin.Context, username string) bool {
+	now := time.Now()
+	var wait time.Duration
+	var locked bool
+	for _, key := range []string{loginFailureUserPrefix + username, loginFailureIPPrefix + c.ClientIP()} {
+		w, l := s.loginFailures.wait(key, now)
+		wait = max(wait, w)
+		locked = locked || l
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	if locked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign-in attempts, try again later", "code": "login_locked"})
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign-in attempts, try again later", "code": "login_backoff"})
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed sign-in against the username and the
+// client's IP, writing an audit entry for each one it locks out
+func (s *Server) recordLoginFailure(c *gin.Context, username string) {
+	now := time.Now()
+	ip := c.ClientIP()
+	minutes := int(s.loginFailures.lockout().Minutes())
+
+	if locked, count := s.loginFailures.fail(loginFailureUserPrefix+username, now); locked {
+		// Entries name the account when it exists; attempts on unknown
+		// usernames are logged without one
+		var userID int
+		if err := s.db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err != nil {
+			userID = 0
+		}
+		log.Printf("Locked sign-in for user %q for %d minutes after %d failed attempts", username, minutes, count)
+		s.logAdminAction(userID, "login_lockout", fmt.Sprintf(
+			"Locked sign-in for user %q for %d minutes after %d failed attempts (last from %s)", username, minutes, count, ip,
+		))
+	}
+	if locked, count := s.loginFailures.fail(loginFailureIPPrefix+ip, now); locked {
+		log.Printf("Locked sign-in from %s for %d minutes after %d failed attempts", ip, minutes, count)
+		s.logAdminAction(0, "login_lockout", fmt.Sprintf(
+			"Locked sign-in from %s for %d minutes after %d failed attempts", ip, minutes, count,
+		))
+	}
+}
+
+// resetLoginFailures clears a username's failures after it signs in. The
+// IP's count stays so one good account cannot launder guesses at others.
+func (s *Server) resetLoginFailures(username string) {
+	s.loginFailures.reset(loginFailureUserPrefix + username)
+}
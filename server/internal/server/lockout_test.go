@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginGuard(t *testing.T) {
+	g := newLoginGuard()
+	g.setPolicy(loginLockoutPolicy{Backoff: time.Second, Threshold: 4, IPThreshold: 10, Lockout: 10 * time.Minute})
+	now := time.Now()
+
+	// Each failure doubles the wait
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if locked, _ := g.fail("user:alice", now); locked {
+			t.Fatalf("Failure %d locked the user out", i+1)
+		}
+		if wait, locked := g.wait("user:alice", now); wait != want || locked {
+			t.Errorf("After %d failures wait is %v (locked %v), want %v", i+1, wait, locked, want)
+		}
+	}
+	if wait, _ := g.wait("user:alice", now.Add(5*time.Second)); wait != 0 {
+		t.Errorf("Wait after the backoff passed is %v, want 0", wait)
+	}
+
+	// The threshold locks the key out for the lockout period
+	if locked, count := g.fail("user:alice", now); !locked || count != 4 {
+		t.Fatalf("Fourth failure returned locked %v after %d, want a lockout", locked, count)
+	}
+	if wait, locked := g.wait("user:alice", now.Add(time.Minute)); !locked || wait != 9*time.Minute {
+		t.Errorf("Locked user waits %v (locked %v), want 9m", wait, locked)
+	}
+	if locked, _ := g.fail("user:alice", now.Add(time.Minute)); locked {
+		t.Error("Failures during a lockout should not lock out again")
+	}
+	if wait, _ := g.wait("user:alice", now.Add(10*time.Minute)); wait != 0 {
+		t.Errorf("Wait after the lockout is %v, want 0", wait)
+	}
+
+	// IPs have their own threshold
+	for i := 0; i < 4; i++ {
+		g.fail("ip:192.0.2.1", now)
+	}
+	if _, locked := g.wait("ip:192.0.2.1", now); locked {
+		t.Error("IP was locked out at the username threshold")
+	}
+
+	g.reset("ip:192.0.2.1")
+	if wait, _ := g.wait("ip:192.0.2.1", now); wait != 0 {
+		t.Errorf("Wait after reset is %v, want 0", wait)
+	}
+
+	// A nil guard allows everything
+	var none *loginGuard
+	if locked, _ := none.fail("user:alice", now); locked {
+		t.Error("Nil guard locked out")
+	}
+	if wait, _ := none.wait("user:alice", now); wait != 0 {
+		t.Error("Nil guard made the user wait")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	s.loginFailures.setPolicy(loginLockoutPolicy{Threshold: 3, IPThreshold: 100, Lockout: 15 * time.Minute})
+
+	username := fmt.Sprintf("lockout_%d", time.Now().UnixNano())
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', ?)", username, hash)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", s.handleLogin)
+	login := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/auth/login", strings.NewReader(fmt.Sprintf(`{"username":%q,"password":%q}`, username, password)))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// A successful sign-in forgets earlier failures
+	login("wrong")
+	login("wrong")
+	if w := login("correct-horse-battery"); w.Code != http.StatusOK {
+		t.Fatalf("Correct password returned %d: %s", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Failure %d returned %d, want 401", i+1, w.Code)
+		}
+	}
+
+	// Locked out, even with the right password
+	w := login("correct-horse-battery")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Locked sign-in returned %d, want 429", w.Code)
+	}
+	var got struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Code != "login_locked" {
+		t.Errorf("Locked sign-in code is %q (%v), want login_locked", got.Code, err)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Locked sign-in has no Retry-After")
+	}
+
+	var details string
+	err = db.QueryRow(
+		"SELECT details FROM audit_logs WHERE admin_id = ? AND action = 'login_lockout' ORDER BY id DESC LIMIT 1", userID,
+	).Scan(&details)
+	if err != nil || !strings.Contains(details, username) {
+		t.Errorf("Lockout audit entry is %q (%v)", details, err)
+	}
+}
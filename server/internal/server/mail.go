@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"fethur/internal/mail"
+	"fethur/internal/resilience"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sendEmail renders a template, delivers it and records the attempt in
+// mail_deliveries. Brief provider failures are retried; while the provider
+// keeps failing, mail fails fast. userID is the recipient account, or 0
+// for none.
+func (s *Server) sendEmail(ctx context.Context, userID int, to, template string, data map[string]interface{}) error {
+	message, err := s.mailer.Render(template, to, data)
+	if err == nil {
+		err = s.integrations.Guard(integrationMail, mailPolicy).Do(ctx, func(ctx context.Context) error {
+			err := s.mailer.Send(ctx, message)
+			if errors.Is(err, mail.ErrInvalidAddress) {
+				return resilience.Permanent(err)
+			}
+			return err
+		})
+	}
+
+	status, errorText := "sent", ""
+	if err != nil {
+		status, errorText = "failed", err.Error()
+		log.Printf("Failed to send %s email to user %d: %v", template, userID, err)
+	}
+
+	var recipient sql.NullInt64
+	if userID != 0 {
+		recipient = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	if _, logErr := s.db.Exec(
+		"INSERT INTO mail_deliveries (recipient, template, subject, provider, status, error, user_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		to, template, message.Subject, s.mailer.Provider(), status, errorText, recipient,
+	); logErr != nil {
+		log.Printf("Failed to record email delivery: %v", logErr)
+	}
+	return err
+}
+
+// handleGetMail reports the mail configuration and recent deliveries
+func (s *Server) handleGetMail(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	query := "SELECT id, recipient, template, subject, provider, status, COALESCE(error, ''), created_at FROM mail_deliveries"
+	args := []interface{}{}
+	if status := c.Query("status"); status != "" {
+		if status != "sent" && status != "failed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be sent or failed"})
+			return
+		}
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mail deliveries"})
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	deliveries := make([]gin.H, 0)
+	for rows.Next() {
+		var id int
+		var recipient, template, subject, provider, status, errorText, createdAt string
+		if err := rows.Scan(&id, &recipient, &template, &subject, &provider, &status, &errorText, &createdAt); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, gin.H{
+			"id":         id,
+			"recipient":  recipient,
+			"template":   template,
+			"subject":    subject,
+			"provider":   provider,
+			"status":     status,
+			"error":      errorText,
+			"created_at": createdAt,
+		})
+	}
+
+	var sent, failed int
+	if err := s.db.QueryRow(`
+		SELECT COALESCE(SUM(status = 'sent'), 0), COALESCE(SUM(status = 'failed'), 0)
+		FROM mail_deliveries WHERE created_at > datetime('now', '-1 day')`).Scan(&sent, &failed); err != nil {
+		log.Printf("Failed to count mail deliveries: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"provider":   s.mailer.Provider(),
+			"from":       s.mailer.From(),
+			"templates":  mail.TemplateNames(),
+			"last_24h":   gin.H{"sent": sent, "failed": failed},
+			"deliveries": deliveries,
+		},
+	})
+}
+
+// handleSendTestEmail sends the test template, to the admin by default
+func (s *Server) handleSendTestEmail(c *gin.Context) {
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt("user_id")
+	username := c.GetString("username")
+	if req.To == "" {
+		var email sql.NullString
+		if err := s.db.QueryRow("SELECT email FROM users WHERE id = ?", adminID).Scan(&email); err != nil || email.String == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your account has no email address; specify a recipient"})
+			return
+		}
+		req.To = email.String
+	}
+
+	err := s.sendEmail(c.Request.Context(), 0, req.To, mail.TemplateTest, map[string]interface{}{
+		"SentBy":   username,
+		"Provider": s.mailer.Provider(),
+	})
+	s.logAdminAction(adminID, "send_test_email", "Sent a test email to "+req.To)
+	if err == mail.ErrInvalidAddress {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Email delivery failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Test email sent to " + req.To,
+	})
+}
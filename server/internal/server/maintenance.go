@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"fethur/internal/database"
+	"fethur/internal/events"
+	"fethur/internal/mail"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceConfig reads the maintenance schedule from settings
+func (s *Server) maintenanceConfig() database.MaintenanceConfig {
+	config := database.DefaultMaintenanceConfig()
+	if hours := s.getIntSetting("db_maintenance_interval_hours", 0); hours > 0 {
+		config.Interval = time.Duration(hours) * time.Hour
+	}
+	if percent := s.getIntSetting("db_maintenance_fragmentation_percent", 0); percent > 0 {
+		config.FragmentationThreshold = float64(percent) / 100
+	}
+	if percent := s.getIntSetting("db_maintenance_growth_percent", 0); percent > 0 {
+		config.GrowthThreshold = float64(percent) / 100
+	}
+	if minutes := s.getIntSetting("db_integrity_check_minutes", 0); minutes > 0 {
+		config.IntegrityInterval = time.Duration(minutes) * time.Minute
+	}
+	if keep := s.getIntSetting("db_backup_keep", -1); keep >= 0 {
+		config.BackupKeep = keep
+	}
+	return config
+}
+
+// alertDatabaseCorruption tells the admins who can see system health that
+// the database is damaged: connected ones at once, super admins by email
+func (s *Server) alertDatabaseCorruption(result database.IntegrityResult) {
+	s.notifyOperators(&websocket.Message{
+		Type:      string(events.TypeNotification),
+		Content:   "The database integrity check found problems",
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"kind":       "db_corruption",
+			"problems":   result.Problems,
+			"checked_at": result.CheckedAt,
+		},
+	})
+	for _, a := range s.operatorAdmins() {
+		if a.role == "super_admin" && a.email != "" && s.mailer != nil {
+			go func(a operatorAdmin) {
+				_ = s.sendEmail(context.Background(), a.id, a.email, mail.TemplateCorruption, map[string]interface{}{
+					"Username":  a.username,
+					"CheckedAt": result.CheckedAt.UTC().Format("2006-01-02 15:04 MST"),
+					"Problems":  result.Problems,
+				})
+			}(a)
+		}
+	}
+}
+
+// handleGetMaintenance reports database stats and maintenance progress
+func (s *Server) handleGetMaintenance(c *gin.Context) {
+	stats, err := s.db.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read database stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"database":    stats,
+			"maintenance": s.maintenance.Status(),
+		},
+	})
+}
+
+// handleRunMaintenance starts a maintenance pass in the background
+func (s *Server) handleRunMaintenance(c *gin.Context) {
+	adminID := c.GetInt("user_id")
+	if s.maintenance.Status().Running {
+		c.JSON(http.StatusConflict, gin.H{"error": database.ErrMaintenanceRunning.Error()})
+		return
+	}
+
+	go func() {
+		if _, err := s.maintenance.Run(context.Background(), "manual", adminID); err != nil {
+			log.Printf("Manual database maintenance failed: %v", err)
+		}
+	}()
+
+	s.logAdminAction(adminID, "run_db_maintenance", "Started database maintenance")
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Database maintenance started",
+	})
+}
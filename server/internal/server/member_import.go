@@ -0,0 +1,549 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk member imports and exports. Imports are parsed and checked when
+// they are uploaded, then worked through by the job queue in batches so a
+// large one neither holds the request nor outlives a job's timeout.
+// Progress is stored, so a restart resumes where the import stopped.
+
+const (
+	maxImportRows     = 5000
+	maxImportBody     = 4 << 20
+	maxImportErrors   = 100 // row errors kept for the progress endpoint
+	memberImportBatch = 200 // rows per job
+)
+
+// importedPasswordHash is the password hash of accounts created by an
+// import. It matches no password; an admin sets one before the account can
+// sign in.
+const importedPasswordHash = "!imported"
+
+// Row outcomes
+const (
+	importAdded   = "added"
+	importCreated = "created"
+	importSkipped = "skipped"
+)
+
+// importRow is one member to import. Roles are server role names.
+type importRow struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// importError explains why a row was not imported. Row counts from 1.
+type importError struct {
+	Row      int    `json:"row"`
+	Username string `json:"username"`
+	Error    string `json:"error"`
+}
+
+// parseImportCSV reads rows from CSV with a header naming a username
+// column and optionally email and roles, the roles separated by ;. Other
+// columns are ignored, so an export can be imported elsewhere as is.
+func parseImportCSV(body []byte) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV must start with a header row")
+	}
+	columns := map[string]int{"username": -1, "email": -1, "roles": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := columns[name]; ok {
+			columns[name] = i
+		}
+	}
+	if columns["username"] < 0 {
+		return nil, errors.New("CSV header must include a username column")
+	}
+	field := func(record []string, column string) string {
+		if i := columns[column]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]importRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		row := importRow{Username: field(record, "username"), Email: field(record, "email")}
+		for _, role := range strings.Split(field(record, "roles"), ";") {
+			if role = strings.TrimSpace(role); role != "" {
+				row.Roles = append(row.Roles, role)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// validateImportRows checks the rows before anything is queued
+func validateImportRows(rows []importRow) error {
+	if len(rows) == 0 {
+		return errors.New("No members to import")
+	}
+	if len(rows) > maxImportRows {
+		return fmt.Errorf("At most %d members can be imported at once", maxImportRows)
+	}
+	seen := make(map[string]bool, len(rows))
+	for i := range rows {
+		rows[i].Username = strings.TrimSpace(rows[i].Username)
+		rows[i].Email = strings.TrimSpace(rows[i].Email)
+		name := rows[i].Username
+		if name == "" || len(name) > 64 {
+			return fmt.Errorf("Row %d: username must be 1-64 characters", i+1)
+		}
+		if seen[name] {
+			return fmt.Errorf("Row %d: %s appears more than once", i+1, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// hasActiveMemberImport reports whether a server has an import waiting or
+// running
+func (s *Server) hasActiveMemberImport(serverID int) (bool, error) {
+	var active bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM member_imports WHERE server_id = ? AND status IN ('queued', 'running'))", serverID,
+	).Scan(&active)
+	return active, err
+}
+
+// queueMemberImport hands the next batch of an import to the job queue
+func (s *Server) queueMemberImport(id int64) error {
+	return s.jobs.Enqueue(fmt.Sprintf("member-import-%d", id), func(ctx context.Context) error {
+		return s.runMemberImport(ctx, id)
+	})
+}
+
+// requeueMemberImports resumes imports interrupted by a restart
+func (s *Server) requeueMemberImports() {
+	rows, err := s.db.Query("SELECT id FROM member_imports WHERE status IN ('queued', 'running')")
+	if err != nil {
+		log.Printf("Failed to load unfinished member imports: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+
+	for _, id := range ids {
+		if err := s.queueMemberImport(id); err != nil {
+			log.Printf("Failed to requeue member import %d: %v", id, err)
+		}
+	}
+}
+
+// runMemberImport imports the next batch of rows and queues the batch
+// after it
+func (s *Server) runMemberImport(ctx context.Context, id int64) error {
+	var serverID, actorID, processed, total int
+	var createAccounts bool
+	var status, payload, errorsJSON string
+	err := s.db.QueryRow(
+		"SELECT server_id, created_by, create_accounts, status, payload, processed, total, errors FROM member_imports WHERE id = ?", id,
+	).Scan(&serverID, &actorID, &createAccounts, &status, &payload, &processed, &total, &errorsJSON)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != "queued" && status != "running" {
+		return nil
+	}
+
+	// The importer must still be allowed to manage members
+	if rank, _ := s.serverRole(actorID, serverID); rank != "owner" && rank != "admin" {
+		_, err := s.db.Exec(
+			"UPDATE member_imports SET status = 'failed', finished_at = CURRENT_TIMESTAMP WHERE id = ?", id,
+		)
+		return err
+	}
+
+	var rows []importRow
+	if err := json.Unmarshal([]byte(payload), &rows); err != nil {
+		return err
+	}
+	rowErrors := make([]importError, 0)
+	if err := json.Unmarshal([]byte(errorsJSON), &rowErrors); err != nil {
+		rowErrors = make([]importError, 0)
+	}
+	roles, err := s.serverRoleNames(serverID)
+	if err != nil {
+		return err
+	}
+	orgID := s.serverOrgID(serverID)
+
+	counts := map[string]int{}
+	failed := 0
+	end := processed + memberImportBatch
+	if end > len(rows) {
+		end = len(rows)
+	}
+	for ; processed < end && ctx.Err() == nil; processed++ {
+		row := rows[processed]
+		outcome, err := s.importMember(serverID, actorID, orgID, createAccounts, roles, row)
+		if err != nil {
+			failed++
+			if len(rowErrors) < maxImportErrors {
+				rowErrors = append(rowErrors, importError{Row: processed + 1, Username: row.Username, Error: err.Error()})
+			}
+			continue
+		}
+		counts[outcome]++
+	}
+
+	encoded, _ := json.Marshal(rowErrors)
+	status = "running"
+	var finishedAt interface{}
+	if processed >= total {
+		status, finishedAt = "completed", time.Now().UTC()
+	}
+	if _, err := s.db.Exec(`
+		UPDATE member_imports SET status = ?, processed = ?, added = added + ?, created = created + ?,
+			skipped = skipped + ?, failed = failed + ?, errors = ?, finished_at = ?
+		WHERE id = ?`,
+		status, processed, counts[importAdded], counts[importCreated], counts[importSkipped], failed, string(encoded), finishedAt, id,
+	); err != nil {
+		return err
+	}
+
+	if status == "running" {
+		if err := s.queueMemberImport(id); err != nil {
+			// Picked up again on the next start
+			log.Printf("Failed to queue the next batch of member import %d: %v", id, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// serverRoleNames maps the lowercased names of a server's roles to their
+// IDs
+func (s *Server) serverRoleNames(serverID int) (map[string]int64, error) {
+	rows, err := s.db.Query("SELECT id, name FROM server_roles WHERE server_id = ?", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	roles := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		roles[strings.ToLower(name)] = id
+	}
+	return roles, rows.Err()
+}
+
+// importMember adds one row's user to the server, creating the account
+// first when allowed, and gives them the row's roles. A row with an
+// unknown role is not imported at all.
+func (s *Server) importMember(serverID, actorID, orgID int, createAccounts bool, roles map[string]int64, row importRow) (string, error) {
+	roleIDs := make([]int64, 0, len(row.Roles))
+	for _, name := range row.Roles {
+		roleID, ok := roles[strings.ToLower(name)]
+		if !ok {
+			return "", fmt.Errorf("Unknown role %s", name)
+		}
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	outcome := importAdded
+	var userID, userOrg int
+	err := s.db.QueryRow("SELECT id, org_id FROM users WHERE username = ?", row.Username).Scan(&userID, &userOrg)
+	switch {
+	case err == sql.ErrNoRows && createAccounts:
+		if err := s.checkOrgLimit(orgID, "users"); err != nil {
+			return "", errors.New("This organization has reached its user limit")
+		}
+		result, err := s.db.Exec(
+			"INSERT INTO users (username, email, password_hash, role, org_id) VALUES (?, ?, ?, 'user', ?)",
+			row.Username, row.Email, importedPasswordHash, orgID,
+		)
+		if err != nil {
+			return "", err
+		}
+		id, _ := result.LastInsertId()
+		userID, outcome = int(id), importCreated
+	case err == sql.ErrNoRows || (err == nil && userOrg != orgID):
+		// Users of another organization are invisible here
+		return "", errors.New("User not found")
+	case err != nil:
+		return "", err
+	}
+	if s.isUserBanned(userID) {
+		return "", errors.New("User is banned")
+	}
+
+	added, err := s.addServerMember(serverID, userID, "member")
+	if err != nil {
+		return "", err
+	}
+	if !added && outcome == importAdded {
+		outcome = importSkipped
+	}
+	for _, roleID := range roleIDs {
+		if reason, allowed := s.checkRoleAssignment(serverID, actorID, userID, roleID); !allowed {
+			return "", errors.New(reason)
+		}
+		if _, err := s.grantMemberRole(serverID, userID, roleID); err != nil {
+			return "", err
+		}
+	}
+	return outcome, nil
+}
+
+// memberImportJSON describes an import's progress
+func (s *Server) memberImportJSON(id int64) (gin.H, error) {
+	var serverID, total, processed, added, created, skipped, failed int
+	var createAccounts bool
+	var status, errorsJSON string
+	var createdAt time.Time
+	var finishedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT server_id, create_accounts, status, total, processed, added, created, skipped, failed, errors, created_at, finished_at
+		FROM member_imports WHERE id = ?`, id,
+	).Scan(&serverID, &createAccounts, &status, &total, &processed, &added, &created, &skipped, &failed, &errorsJSON, &createdAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	data := gin.H{
+		"id":              id,
+		"server_id":       serverID,
+		"create_accounts": createAccounts,
+		"status":          status,
+		"total":           total,
+		"processed":       processed,
+		"added":           added,
+		"created":         created,
+		"skipped":         skipped,
+		"failed":          failed,
+		"errors":          json.RawMessage(errorsJSON),
+		"created_at":      createdAt,
+		"finished_at":     nil,
+	}
+	if finishedAt.Valid {
+		data["finished_at"] = finishedAt.Time
+	}
+	return data, nil
+}
+
+// handleImportMembers queues a bulk member import. The body is JSON,
+// {"create_accounts": false, "members": [{"username": ..., "email": ...,
+// "roles": [...]}]}, or CSV with Content-Type text/csv and create_accounts
+// as a query parameter. Only instance admins with manage_users may create
+// accounts.
+func (s *Server) handleImportMembers(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import is too large"})
+		return
+	}
+	var rows []importRow
+	var createAccounts bool
+	if baseMediaType(c.GetHeader("Content-Type")) == "text/csv" {
+		if rows, err = parseImportCSV(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		createAccounts, _ = strconv.ParseBool(c.Query("create_accounts"))
+	} else {
+		var req struct {
+			CreateAccounts bool        `json:"create_accounts"`
+			Members        []importRow `json:"members"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+		rows, createAccounts = req.Members, req.CreateAccounts
+	}
+	if err := validateImportRows(rows); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if createAccounts && !s.hasCapability(userID, capManageUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Creating accounts requires the manage_users capability"})
+		return
+	}
+	if active, err := s.hasActiveMemberImport(serverID); err != nil || active {
+		c.JSON(http.StatusConflict, gin.H{"error": "An import is already running for this server"})
+		return
+	}
+
+	payload, _ := json.Marshal(rows)
+	result, err := s.db.Exec(
+		"INSERT INTO member_imports (server_id, created_by, create_accounts, payload, total) VALUES (?, ?, ?, ?, ?)",
+		serverID, userID, createAccounts, string(payload), len(rows),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	if err := s.queueMemberImport(id); err != nil {
+		if _, err := s.db.Exec("DELETE FROM member_imports WHERE id = ?", id); err != nil {
+			log.Printf("Failed to remove member import %d: %v", id, err)
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too much background work; try again shortly"})
+		return
+	}
+
+	data, err := s.memberImportJSON(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load import"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": data})
+}
+
+// handleGetMemberImport reports an import's progress
+func (s *Server) handleGetMemberImport(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("importId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+	data, err := s.memberImportJSON(id)
+	if err != nil || data["server_id"] != serverID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
+
+// handleExportMembers returns the member list with ranks, roles and join
+// dates as JSON or, with format=csv, as a CSV file the import accepts
+func (s *Server) handleExportMembers(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	roles := make(map[int][]string)
+	rows, err := s.db.Query(`
+		SELECT mr.user_id, r.name FROM member_roles mr
+		JOIN server_roles r ON r.id = mr.role_id
+		WHERE mr.server_id = ?
+		ORDER BY r.position DESC, r.name`, serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export members"})
+		return
+	}
+	for rows.Next() {
+		var userID int
+		var name string
+		if err := rows.Scan(&userID, &name); err == nil {
+			roles[userID] = append(roles[userID], name)
+		}
+	}
+	_ = rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT u.id, u.username, sm.role, sm.joined_at FROM server_members sm
+		JOIN users u ON u.id = sm.user_id
+		WHERE sm.server_id = ?
+		ORDER BY sm.joined_at, u.id`, serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export members"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	members := make([]gin.H, 0)
+	for rows.Next() {
+		var userID int
+		var username, rank string
+		var joinedAt time.Time
+		if err := rows.Scan(&userID, &username, &rank, &joinedAt); err != nil {
+			continue
+		}
+		memberRoles := roles[userID]
+		if memberRoles == nil {
+			memberRoles = []string{}
+		}
+		members = append(members, gin.H{
+			"user_id":   userID,
+			"username":  username,
+			"rank":      rank,
+			"roles":     memberRoles,
+			"joined_at": joinedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": members})
+		return
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"username", "roles", "rank", "joined_at"})
+	for _, member := range members {
+		_ = writer.Write([]string{
+			member["username"].(string),
+			strings.Join(member["roles"].([]string), ";"),
+			member["rank"].(string),
+			member["joined_at"].(string),
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="members-%d.csv"`, serverID))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemberImportExport(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.jobs = queue
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	names := make(map[string]string)
+	for _, name := range []string{"owner", "admin", "existing", "banned"} {
+		names[name] = fmt.Sprintf("imp%s_%d", name, suffix)
+		role := "user"
+		if name == "admin" {
+			role = "admin"
+		}
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash, role) VALUES (?, '', 'x', ?)", names[name], role)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	if err := s.setCapabilities(users["admin"], []string{capManageUsers}, 0); err != nil {
+		t.Fatalf("Failed to grant capabilities: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO user_bans (user_id, banned_by, reason) VALUES (?, ?, 'spam')", users["banned"], users["admin"]); err != nil {
+		t.Fatalf("Failed to ban user: %v", err)
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Imports %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, rank := range map[string]string{"owner": "owner", "admin": "admin"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO server_roles (server_id, name, position) VALUES (?, 'Moderators', 5)", serverID); err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, contentType, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.POST("/servers/:id/members/import", s.handleImportMembers)
+		router.GET("/servers/:id/members/imports/:importId", s.handleGetMemberImport)
+		router.GET("/servers/:id/members/export", s.handleExportMembers)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		router.ServeHTTP(w, r)
+		return w
+	}
+	type progress struct {
+		ID        int64  `json:"id"`
+		Status    string `json:"status"`
+		Processed int    `json:"processed"`
+		Added     int    `json:"added"`
+		Created   int    `json:"created"`
+		Skipped   int    `json:"skipped"`
+		Failed    int    `json:"failed"`
+		Errors    []struct {
+			Row   int    `json:"row"`
+			Error string `json:"error"`
+		} `json:"errors"`
+	}
+	importPath := fmt.Sprintf("/servers/%d/members/import", serverID)
+	// wait starts an import and polls its progress until it finishes
+	wait := func(user, query, contentType, body string) progress {
+		t.Helper()
+		w := request(user, "POST", importPath+query, contentType, body)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected the import to be queued, got %d: %s", w.Code, w.Body.String())
+		}
+		var started struct {
+			Data progress `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &started)
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			w := request(user, "GET", fmt.Sprintf("/servers/%d/members/imports/%d", serverID, started.Data.ID), "", "")
+			var current struct {
+				Data progress `json:"data"`
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &current)
+			if current.Data.Status == "completed" || current.Data.Status == "failed" {
+				return current.Data
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for the import")
+		return progress{}
+	}
+
+	// Existing accounts are added with their roles; missing ones need
+	// create_accounts
+	body := fmt.Sprintf(`{"members":[{"username":%q,"roles":["moderators"]},{"username":"ghost_%d"},{"username":%q},{"username":%q}]}`,
+		names["existing"], suffix, names["banned"], names["owner"])
+	done := wait("owner", "", "application/json", body)
+	if done.Status != "completed" || done.Processed != 4 || done.Added != 1 || done.Skipped != 1 || done.Failed != 2 {
+		t.Errorf("Expected 1 added, 1 skipped and 2 failed, got %+v", done)
+	}
+	if len(done.Errors) != 2 || done.Errors[0].Row != 2 || done.Errors[1].Row != 3 {
+		t.Errorf("Expected errors for rows 2 and 3, got %+v", done.Errors)
+	}
+	if roles, _ := s.serverRoleNames(int(serverID)); !s.hasServerRole(int(users["existing"]), roles["moderators"]) {
+		t.Errorf("Expected the imported member to hold the Moderators role")
+	}
+
+	// Creating accounts takes the manage_users capability
+	csvBody := fmt.Sprintf("username,email,roles\nnew_%d,new@example.com,Moderators;Missing\nfresh_%d,,\n", suffix, suffix)
+	if w := request("owner", "POST", importPath+"?create_accounts=true", "text/csv", csvBody); w.Code != http.StatusForbidden {
+		t.Errorf("Expected account creation to need manage_users, got %d", w.Code)
+	}
+	done = wait("admin", "", "text/csv", csvBody)
+	if done.Created != 0 || done.Failed != 2 {
+		t.Errorf("Expected missing accounts to fail without create_accounts, got %+v", done)
+	}
+	// A row with an unknown role is not imported at all
+	done = wait("admin", "?create_accounts=true", "text/csv; charset=utf-8", csvBody)
+	if done.Created != 1 || done.Failed != 1 || len(done.Errors) != 1 || done.Errors[0].Error != "Unknown role Missing" {
+		t.Errorf("Expected one account created and one unknown role, got %+v", done)
+	}
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE username = ?", fmt.Sprintf("fresh_%d", suffix)).Scan(&hash); err != nil || hash != importedPasswordHash {
+		t.Errorf("Expected the account to be created without a usable password, got %q (%v)", hash, err)
+	}
+
+	if w := request("owner", "POST", importPath, "application/json", `{"members":[{"username":"a"},{"username":"a"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected duplicate rows to be refused, got %d", w.Code)
+	}
+
+	// The CSV export lists members with their roles
+	w := request("owner", "GET", fmt.Sprintf("/servers/%d/members/export?format=csv", serverID), "", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV export, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	exported, err := parseImportCSV(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Expected the export to parse as an import: %v", err)
+	}
+	found := false
+	for _, row := range exported {
+		if row.Username == names["existing"] {
+			found = len(row.Roles) == 1 && row.Roles[0] == "Moderators"
+		}
+	}
+	if len(exported) != 4 || !found {
+		t.Errorf("Expected 4 members including %s with Moderators, got %+v", names["existing"], exported)
+	}
+}
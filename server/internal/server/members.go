@@ -0,0 +1,438 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fethur/internal/plugins"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errServerBanned is returned when a user banned from a server is added
+var errServerBanned = errors.New("User is banned from this server")
+
+// addServerMember adds a user to a server with a rank and reports whether
+// they were new. New members receive the server's auto roles, and plugins
+// hear about the join. A full server returns a *quotaError and a server the
+// user is banned from returns errServerBanned.
+func (s *Server) addServerMember(serverID, userID int, rank string) (bool, error) {
+	if s.serverSanctioned(serverID, userID, "ban") {
+		return false, errServerBanned
+	}
+	var exists bool
+	if err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = ? AND user_id = ?)", serverID, userID,
+	).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	if err := s.checkServerQuota(serverID, quotaMembers, 1); err != nil {
+		return false, err
+	}
+
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", userID, serverID, rank,
+	)
+	if err != nil {
+		return false, err
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		return false, nil
+	}
+
+	s.applyAutoRoles(serverID, userID)
+	s.bumpResourceVersion(membersResource(serverID))
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.emitPluginEvent(plugins.EventUserJoin, userID, map[string]interface{}{
+		"server_id": serverID,
+	})
+	return true, nil
+}
+
+// removeServerMember takes a user out of a server with their roles and
+// private channel access there, and lets plugins hear they left
+func (s *Server) removeServerMember(serverID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, query := range []string{
+		"DELETE FROM member_roles WHERE user_id = ? AND role_id IN (SELECT id FROM server_roles WHERE server_id = ?)",
+		"DELETE FROM channel_members WHERE user_id = ? AND channel_id IN (SELECT id FROM channels WHERE server_id = ?)",
+		"DELETE FROM server_members WHERE user_id = ? AND server_id = ?",
+	} {
+		if _, err := tx.Exec(query, userID, serverID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.bumpResourceVersion(membersResource(serverID))
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.emitPluginEvent(plugins.EventUserLeave, userID, map[string]interface{}{
+		"server_id": serverID,
+	})
+	return nil
+}
+
+// applyAutoRoles gives a new member the server's auto roles
+func (s *Server) applyAutoRoles(serverID, userID int) {
+	roles, err := s.loadAutoRoles(serverID)
+	if err != nil {
+		log.Printf("Failed to load auto roles for server %d: %v", serverID, err)
+		return
+	}
+	for _, role := range roles {
+		if _, err := s.grantMemberRole(serverID, userID, role.ID); err != nil {
+			log.Printf("Failed to grant auto role %d to user %d: %v", role.ID, userID, err)
+		}
+	}
+}
+
+// loadAutoRoles lists the roles new members of a server receive
+func (s *Server) loadAutoRoles(serverID int) ([]serverRoleInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.name, r.color, r.position, r.manage_roles
+		FROM server_auto_roles ar
+		JOIN server_roles r ON r.id = ar.role_id
+		WHERE ar.server_id = ?
+		ORDER BY r.position DESC, r.name`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	roles := make([]serverRoleInfo, 0)
+	for rows.Next() {
+		var role serverRoleInfo
+		if err := rows.Scan(&role.ID, &role.Name, &role.Color, &role.Position, &role.ManageRoles); err == nil {
+			roles = append(roles, role)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// handleAddServerMember adds an existing user to the server; owners and
+// admins only
+func (s *Server) handleAddServerMember(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Users of another organization are invisible here
+	var username string
+	var orgID int
+	err := s.db.QueryRow("SELECT username, org_id FROM users WHERE id = ?", req.UserID).Scan(&username, &orgID)
+	if err != nil || orgID != s.serverOrgID(serverID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if s.isUserBanned(req.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is banned"})
+		return
+	}
+
+	added, err := s.addServerMember(serverID, req.UserID, "member")
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		respondQuotaExceeded(c, exceeded)
+		return
+	}
+	if errors.Is(err, errServerBanned) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+	if !added {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"user_id":  req.UserID,
+			"username": username,
+			"role":     "member",
+		},
+	})
+}
+
+// errLastOwner is returned when a change would leave a server without an
+// owner
+var errLastOwner = errors.New("a server must keep at least one owner")
+
+// memberRanks are the ranks a server member can hold. Owners have equal
+// rights; admins manage the server but not its owners.
+var memberRanks = map[string]bool{"owner": true, "admin": true, "member": true}
+
+// serverOwnerIDs lists a server's owners, longest standing first
+func (s *Server) serverOwnerIDs(serverID int) ([]int, error) {
+	rows, err := s.db.Query(
+		"SELECT user_id FROM server_members WHERE server_id = ? AND role = 'owner' ORDER BY joined_at, id", serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	owners := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		owners = append(owners, id)
+	}
+	return owners, rows.Err()
+}
+
+// setMemberRank changes a member's rank. Demoting the last owner returns
+// errLastOwner. When the owner recorded on the server steps down, the
+// longest standing remaining owner takes their place there.
+func (s *Server) setMemberRank(serverID, userID int, rank string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var current string
+	if err := tx.QueryRow(
+		"SELECT role FROM server_members WHERE server_id = ? AND user_id = ?", serverID, userID,
+	).Scan(&current); err != nil {
+		return err
+	}
+	if current == "owner" && rank != "owner" {
+		var owners int
+		if err := tx.QueryRow(
+			"SELECT COUNT(*) FROM server_members WHERE server_id = ? AND role = 'owner'", serverID,
+		).Scan(&owners); err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return errLastOwner
+		}
+	}
+	if _, err := tx.Exec(
+		"UPDATE server_members SET role = ? WHERE server_id = ? AND user_id = ?", rank, serverID, userID,
+	); err != nil {
+		return err
+	}
+	if rank != "owner" {
+		if _, err := tx.Exec(`
+			UPDATE servers SET owner_id = (
+				SELECT user_id FROM server_members WHERE server_id = ? AND role = 'owner' ORDER BY joined_at, id LIMIT 1
+			) WHERE id = ? AND owner_id = ?`, serverID, serverID, userID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// handleUpdateMemberRank promotes or demotes a member between member, admin
+// and owner. Only owners change ranks, their own included, and a server
+// always keeps at least one owner.
+func (s *Server) handleUpdateMemberRank(c *gin.Context) {
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	if role != "owner" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change member ranks"})
+		return
+	}
+	targetID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Rank string `json:"rank" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !memberRanks[req.Rank] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rank must be owner, admin or member"})
+		return
+	}
+	if _, member := s.serverRole(targetID, serverID); !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	err = s.setMemberRank(serverID, targetID, req.Rank)
+	if errors.Is(err, errLastOwner) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A server must keep at least one owner"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to change rank of user %d in server %d: %v", targetID, serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change rank"})
+		return
+	}
+	// Ranks decide who sees private channels
+	s.bumpResourceVersion(membersResource(serverID))
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"user_id": targetID,
+			"rank":    req.Rank,
+		},
+	})
+}
+
+// handleGetAutoRoles lists the roles new members receive
+func (s *Server) handleGetAutoRoles(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	roles, err := s.loadAutoRoles(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get auto roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    roles,
+	})
+}
+
+// handleUpdateAutoRoles replaces the roles new members receive. Existing
+// members are not changed. Roles that manage roles cannot be auto roles.
+func (s *Server) handleUpdateAutoRoles(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		RoleIDs []int64 `json:"role_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, roleID := range req.RoleIDs {
+		var manage bool
+		err := s.db.QueryRow(
+			"SELECT manage_roles FROM server_roles WHERE id = ? AND server_id = ?", roleID, serverID,
+		).Scan(&manage)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role_ids must be roles in this server"})
+			return
+		}
+		if manage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Roles that manage roles cannot be auto roles"})
+			return
+		}
+	}
+
+	if _, err := s.db.Exec("DELETE FROM server_auto_roles WHERE server_id = ?", serverID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update auto roles"})
+		return
+	}
+	for _, roleID := range req.RoleIDs {
+		if _, err := s.db.Exec(
+			"INSERT OR IGNORE INTO server_auto_roles (server_id, role_id) VALUES (?, ?)", serverID, roleID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update auto roles"})
+			return
+		}
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	roles, _ := s.loadAutoRoles(serverID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    roles,
+	})
+}
+
+// memberListRanks orders the role groups of a member list
+var memberListRanks = map[string]int{"owner": 0, "admin": 1, "member": 2}
+
+// listServerMembers is the chat hub's member lister. Online members come
+// first, grouped by rank from owners down, then everyone offline; each
+// group is sorted by username.
+func (s *Server) listServerMembers(userID, serverID int) ([]websocket.Member, error) {
+	if _, ok := s.services.Servers.Role(userID, serverID); !ok {
+		return nil, errors.New("not a member of this server")
+	}
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, sm.role
+		FROM server_members sm JOIN users u ON u.id = sm.user_id
+		WHERE sm.server_id = ?`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	members := make([]websocket.Member, 0)
+	for rows.Next() {
+		var member websocket.Member
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+		_, member.Online = s.clients[int(member.UserID)]
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.Online != b.Online {
+			return a.Online
+		}
+		if a.Online && memberListRanks[a.Role] != memberListRanks[b.Role] {
+			return memberListRanks[a.Role] < memberListRanks[b.Role]
+		}
+		if name, other := strings.ToLower(a.Username), strings.ToLower(b.Username); name != other {
+			return name < other
+		}
+		return a.UserID < b.UserID
+	})
+	return members, nil
+}
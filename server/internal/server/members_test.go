@@ -0,0 +1,248 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAutoRolesAndRoleHierarchy(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "admin", "mod", "member", "newbie"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("ar%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Onboarding %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, rank := range map[string]string{"owner": "owner", "admin": "admin", "mod": "member", "member": "member"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	roles := make(map[string]int64)
+	for name, position := range map[string]int{"Senior": 20, "Mod": 10, "Verified": 5} {
+		result, err := db.Exec(
+			"INSERT INTO server_roles (server_id, name, position, manage_roles) VALUES (?, ?, ?, ?)", serverID, name, position, name == "Mod",
+		)
+		if err != nil {
+			t.Fatalf("Failed to create role: %v", err)
+		}
+		roles[name], _ = result.LastInsertId()
+	}
+	if _, err := s.grantMemberRole(int(serverID), int(users["mod"]), roles["Mod"]); err != nil {
+		t.Fatalf("Failed to grant role: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.PUT("/servers/:id/auto-roles", s.handleUpdateAutoRoles)
+		router.POST("/servers/:id/members", s.handleAddServerMember)
+		router.PUT("/servers/:id/members/:userId/roles/:roleId", s.handleGrantMemberRole)
+		router.DELETE("/servers/:id/members/:userId/roles/:roleId", s.handleRevokeMemberRole)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	base := fmt.Sprintf("/servers/%d", serverID)
+	roleOf := func(member, role string) string {
+		return fmt.Sprintf("%s/members/%d/roles/%d", base, users[member], roles[role])
+	}
+
+	// Auto roles
+	if w := request("owner", "PUT", base+"/auto-roles", fmt.Sprintf(`{"role_ids":[%d]}`, roles["Mod"])); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a role managing role to be refused, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/auto-roles", fmt.Sprintf(`{"role_ids":[%d]}`, roles["Verified"])); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set auto roles: %d %s", w.Code, w.Body.String())
+	}
+	body := fmt.Sprintf(`{"user_id":%d}`, users["newbie"])
+	if w := request("mod", "POST", base+"/members", body); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to add members, got %d", w.Code)
+	}
+	if w := request("owner", "POST", base+"/members", body); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to add member: %d %s", w.Code, w.Body.String())
+	}
+	if w := request("owner", "POST", base+"/members", body); w.Code != http.StatusConflict {
+		t.Fatalf("Expected adding a member twice to conflict, got %d", w.Code)
+	}
+	if !s.hasServerRole(int(users["newbie"]), roles["Verified"]) {
+		t.Fatal("Expected the new member to receive the auto role")
+	}
+
+	// Hierarchy
+	cases := []struct {
+		actor, method, path string
+		code                int
+	}{
+		{"member", "PUT", roleOf("member", "Verified"), http.StatusForbidden},
+		{"mod", "PUT", roleOf("member", "Verified"), http.StatusOK},
+		{"mod", "PUT", roleOf("member", "Senior"), http.StatusForbidden},
+		{"mod", "PUT", roleOf("member", "Mod"), http.StatusForbidden},
+		{"mod", "PUT", roleOf("admin", "Verified"), http.StatusForbidden},
+		{"admin", "DELETE", roleOf("owner", "Verified"), http.StatusForbidden},
+		{"admin", "PUT", roleOf("member", "Senior"), http.StatusOK},
+		{"mod", "DELETE", roleOf("member", "Verified"), http.StatusForbidden},
+		{"mod", "DELETE", roleOf("newbie", "Verified"), http.StatusOK},
+	}
+	for _, tc := range cases {
+		if w := request(tc.actor, tc.method, tc.path, ""); w.Code != tc.code {
+			t.Errorf("%s %s %s: expected %d, got %d %s", tc.actor, tc.method, tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMemberRanks(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"founder", "cofounder", "admin", "member", "outsider"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("rk%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Ranks %d", suffix), users["founder"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for _, member := range []struct{ name, rank string }{
+		{"founder", "owner"}, {"cofounder", "admin"}, {"admin", "admin"}, {"member", "member"},
+	} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[member.name], serverID, member.rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.PUT("/servers/:id/members/:userId/rank", s.handleUpdateMemberRank)
+		router.DELETE("/admin/users/:id", s.handleDeleteUser)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	rankPath := func(user string) string {
+		return fmt.Sprintf("/servers/%d/members/%d/rank", serverID, users[user])
+	}
+	rankOf := func(user string) string {
+		rank, _ := s.serverRole(int(users[user]), int(serverID))
+		return rank
+	}
+
+	if w := request("admin", "PUT", rankPath("member"), `{"rank":"admin"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected admins not to change ranks, got %d", w.Code)
+	}
+	if w := request("founder", "PUT", rankPath("member"), `{"rank":"moderator"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown ranks to be refused, got %d", w.Code)
+	}
+	if w := request("founder", "PUT", rankPath("outsider"), `{"rank":"admin"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected non-members to be refused, got %d", w.Code)
+	}
+	if w := request("founder", "PUT", rankPath("cofounder"), `{"rank":"owner"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the admin to be made an owner, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Co-owners have the same rights as the founder
+	if w := request("cofounder", "PUT", rankPath("admin"), `{"rank":"member"}`); w.Code != http.StatusOK || rankOf("admin") != "member" {
+		t.Errorf("Expected the co-owner to demote an admin, got %d", w.Code)
+	}
+	if reason, ok := s.checkRoleAssignment(int(serverID), int(users["cofounder"]), int(users["member"]), 0); !ok {
+		t.Errorf("Expected the co-owner to manage roles, got %q", reason)
+	}
+	if w := request("cofounder", "PUT", rankPath("founder"), `{"rank":"admin"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the co-owner to demote the founder, got %d", w.Code)
+	}
+	var ownerID int64
+	if err := db.QueryRow("SELECT owner_id FROM servers WHERE id = ?", serverID).Scan(&ownerID); err != nil || ownerID != users["cofounder"] {
+		t.Errorf("Expected the server's owner to move to the co-owner, got %d", ownerID)
+	}
+
+	// The last owner can neither step down nor be deleted
+	if w := request("cofounder", "PUT", rankPath("cofounder"), `{"rank":"member"}`); w.Code != http.StatusConflict || rankOf("cofounder") != "owner" {
+		t.Errorf("Expected the last owner to stay, got %d", w.Code)
+	}
+	if w := request("admin", "DELETE", fmt.Sprintf("/admin/users/%d", users["cofounder"]), ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected deleting the last owner to be refused, got %d", w.Code)
+	}
+	if w := request("cofounder", "PUT", rankPath("founder"), `{"rank":"owner"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the founder to be made an owner again, got %d", w.Code)
+	}
+	if w := request("founder", "PUT", rankPath("cofounder"), `{"rank":"member"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected an owner to step down while another remains, got %d", w.Code)
+	}
+}
+
+func TestMemberListOrder(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"zed", "amy", "bob", "carl", "dana", "outsider"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("ml%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Members %d", suffix), users["zed"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, rank := range map[string]string{"zed": "owner", "amy": "member", "bob": "admin", "carl": "member", "dana": "owner"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	for _, name := range []string{"zed", "amy", "bob"} {
+		s.clients[int(users[name])] = nil
+	}
+
+	// Online members by rank, then everyone offline by name
+	members, err := s.listServerMembers(int(users["amy"]), int(serverID))
+	if err != nil {
+		t.Fatalf("Failed to list members: %v", err)
+	}
+	var order []string
+	for _, member := range members {
+		order = append(order, strings.TrimSuffix(strings.TrimPrefix(member.Username, "ml"), fmt.Sprintf("_%d", suffix)))
+	}
+	if got := strings.Join(order, ","); got != "zed,bob,amy,carl,dana" {
+		t.Errorf("Unexpected member list order: %s", got)
+	}
+	if !members[0].Online || members[0].Role != "owner" || members[4].Online {
+		t.Errorf("Unexpected member entries: %+v", members)
+	}
+
+	if _, err := s.listServerMembers(int(users["outsider"]), int(serverID)); err == nil {
+		t.Error("Expected non-members to be refused the member list")
+	}
+}
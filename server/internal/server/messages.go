@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/inbound"
+	"fethur/internal/snowflake"
+	"fethur/internal/store"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postChannelMessage stores a message from a bridge or integration and
+// delivers it like one sent through the API. botName, when set, is shown
+// instead of the author's username; userID stays the user responsible.
+// Embeds are optional rich blocks rendered under the content.
+func (s *Server) postChannelMessage(channelID, userID int, username, botName, content string, embeds []inbound.Embed) (int64, error) {
+	var bot, embedsJSON sql.NullString
+	if botName != "" {
+		bot = sql.NullString{String: botName, Valid: true}
+	}
+	if len(embeds) > 0 {
+		encoded, err := json.Marshal(embeds)
+		if err != nil {
+			return 0, err
+		}
+		embedsJSON = sql.NullString{String: string(encoded), Valid: true}
+	}
+	messageID := int64(snowflake.Next())
+	_, err := s.db.Exec(
+		"INSERT INTO messages (id, channel_id, user_id, content, bot_name, embeds, created_at) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		messageID, channelID, userID, content, bot, embedsJSON,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	s.bumpResourceVersion(messagesResource(channelID))
+	s.markWrite(userID)
+
+	displayName := username
+	data := gin.H{
+		"id":          snowflake.ID(messageID),
+		"channel_id":  strconv.Itoa(channelID),
+		"user_id":     userID,
+		"username":    username,
+		"content":     content,
+		"created_at":  time.Now().Format(time.RFC3339),
+		"attachments": []gin.H{},
+	}
+	if botName != "" {
+		displayName = botName
+		data["bot_name"] = botName
+	}
+	if len(embeds) > 0 {
+		data["embeds"] = embeds
+	}
+	s.hub.BroadcastMessage(&websocket.Message{
+		Type:      string(events.TypeText),
+		ChannelID: channelID,
+		Content:   content,
+		UserID:    userID,
+		Username:  displayName,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	s.queueOfflineMentions(channelID, messageID, userID, content)
+	s.relayToXMPP(channelID, messageID, displayName, content)
+	s.notifyMessageHooks(channelID, messageID, userID, username, botName, content)
+	return messageID, nil
+}
+
+// updateChannelMessage replaces a message's content and embeds and tells
+// the channel, returning the message's edit count. An edit by editorID
+// keeps the replaced version for moderators; editorID 0 is the server
+// refreshing a message of its own, such as a starboard repost, which does
+// not show as edited.
+func (s *Server) updateChannelMessage(channelID int, messageID int64, editorID int, content string, embeds []inbound.Embed) (int, error) {
+	var embedsJSON sql.NullString
+	if len(embeds) > 0 {
+		encoded, err := json.Marshal(embeds)
+		if err != nil {
+			return 0, err
+		}
+		embedsJSON = sql.NullString{String: string(encoded), Valid: true}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	editCount, err := store.New(tx).Messages.Update(context.Background(), messageID, editorID, content, embedsJSON)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.bumpResourceVersion(messagesResource(channelID))
+
+	data := gin.H{
+		"id":         snowflake.ID(messageID),
+		"channel_id": strconv.Itoa(channelID),
+		"content":    content,
+		"edit_count": editCount,
+	}
+	if editorID != 0 {
+		data["edited_at"] = time.Now().Format(time.RFC3339)
+	}
+	if len(embeds) > 0 {
+		data["embeds"] = embeds
+	}
+	s.hub.BroadcastMessage(&websocket.Message{
+		Type:      string(events.TypeMessageUpdate),
+		ChannelID: channelID,
+		Content:   content,
+		Timestamp: time.Now(),
+		Data:      data,
+		Audience:  s.messageAudience(messageID),
+	})
+	return editCount, nil
+}
+
+// deleteChannelMessage removes a message and what hangs off it and tells
+// the channel. Foreign keys are not enforced, so dependent rows go first.
+func (s *Server) deleteChannelMessage(channelID int, messageID int64) error {
+	cleanup := []string{
+		"DELETE FROM offline_events WHERE message_id = ?",
+		"DELETE FROM message_idempotency WHERE message_id = ?",
+		"UPDATE attachments SET message_id = NULL WHERE message_id = ?",
+		"DELETE FROM message_reactions WHERE message_id = ?",
+		"DELETE FROM reaction_roles WHERE message_id = ?",
+		"DELETE FROM thread_follows WHERE message_id = ?",
+		"DELETE FROM message_revisions WHERE message_id = ?",
+		"DELETE FROM messages WHERE id = ?",
+	}
+	audience := s.messageAudience(messageID)
+	for _, query := range cleanup {
+		if _, err := s.db.Exec(query, messageID); err != nil {
+			return err
+		}
+	}
+	s.bumpResourceVersion(messagesResource(channelID))
+
+	s.hub.BroadcastMessage(&websocket.Message{
+		Type:      string(events.TypeMessageDelete),
+		ChannelID: channelID,
+		Timestamp: time.Now(),
+		Audience:  audience,
+		Data: gin.H{
+			"id":         snowflake.ID(messageID),
+			"channel_id": strconv.Itoa(channelID),
+		},
+	})
+
+	// Starred messages take their repost with them
+	s.starboardMessageDeleted(messageID)
+	return nil
+}
+
+// messageForUser loads the channel, author and bot name of a message the
+// user can see. Quarantined messages are visible to their author and the
+// server's moderators only.
+func (s *Server) messageForUser(c *gin.Context) (messageID int64, channel *channelInfo, authorID int, botName string, ok bool) {
+	messageID, err := strconv.ParseInt(c.Param("messageId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return 0, nil, 0, "", false
+	}
+	var channelID int
+	var quarantined bool
+	if err := s.db.QueryRow(
+		"SELECT channel_id, user_id, COALESCE(bot_name, ''), quarantined FROM messages WHERE id = ?", messageID,
+	).Scan(&channelID, &authorID, &botName, &quarantined); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return 0, nil, 0, "", false
+	}
+	userID := c.GetInt("user_id")
+	channel, err = s.lookupChannelForUser(userID, channelID)
+	if err != nil || (quarantined && authorID != userID && !s.canManageChannel(userID, channelID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return 0, nil, 0, "", false
+	}
+	return messageID, channel, authorID, botName, true
+}
+
+// handleEditMessage lets authors change the text of their messages
+func (s *Server) handleEditMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	messageID, channel, authorID, botName, ok := s.messageForUser(c)
+	if !ok {
+		return
+	}
+	// Integration messages belong to their integration, not the user
+	if authorID != userID || botName != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own messages"})
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Edits go through the content filter like new messages
+	verdict, ok := s.checkContent(c, channel.ServerID, req.Content)
+	if !ok {
+		return
+	}
+	req.Content = verdict.Content
+
+	editCount, err := s.updateChannelMessage(channel.ID, messageID, userID, req.Content, nil)
+	if err != nil {
+		log.Printf("Failed to edit message %d: %v", messageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+	s.markWrite(userID)
+	s.starboardMessageEdited(messageID)
+
+	response := gin.H{
+		"success": true,
+		"message": "Message updated successfully",
+		"data": gin.H{
+			"id":         snowflake.ID(messageID),
+			"channel_id": strconv.Itoa(channel.ID),
+			"content":    req.Content,
+			"edited_at":  time.Now().Format(time.RFC3339),
+			"edit_count": editCount,
+		},
+	}
+	if report := verdict.report(); report != nil {
+		response["content_filter"] = report
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleDeleteMessage deletes a message; authors can delete their own and
+// server owners and admins any
+func (s *Server) handleDeleteMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	messageID, channel, authorID, botName, ok := s.messageForUser(c)
+	if !ok {
+		return
+	}
+	if (authorID != userID || botName != "") && !s.canManageChannel(userID, channel.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	if err := s.deleteChannelMessage(channel.ID, messageID); err != nil {
+		log.Printf("Failed to delete message %d: %v", messageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+	s.markWrite(userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message deleted successfully",
+	})
+}
+
+// handleGetMessageRevisions lists the earlier versions of a message, newest
+// first, for those who can manage its channel
+func (s *Server) handleGetMessageRevisions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	messageID, channel, _, _, ok := s.messageForUser(c)
+	if !ok {
+		return
+	}
+	if !s.canManageChannel(userID, channel.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	list, err := store.New(s.reader(c)).Messages.Revisions(c.Request.Context(), messageID)
+	if err != nil {
+		log.Printf("Failed to get revisions of message %d: %v", messageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message revisions"})
+		return
+	}
+	revisions := make([]gin.H, 0, len(list))
+	for _, revision := range list {
+		entry := gin.H{
+			"id":          revision.ID,
+			"content":     revision.Content,
+			"written_at":  revision.WrittenAt,
+			"replaced_at": revision.ReplacedAt,
+			"replaced_by": nullIntPtr(revision.ReplacedBy),
+		}
+		if revision.ReplacedByName != "" {
+			entry["replaced_by_username"] = revision.ReplacedByName
+		}
+		if revision.Embeds != "" {
+			entry["embeds"] = json.RawMessage(revision.Embeds)
+		}
+		revisions = append(revisions, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"message_id": snowflake.ID(messageID),
+			"revisions":  revisions,
+		},
+	})
+}
+
+// queryMessages loads messages of a channel as history entries, with their
+// attachments and reactions. clause follows the channel condition, e.g.
+// "AND m.id < ? ORDER BY m.id DESC LIMIT ?". Quarantined messages are only
+// loaded for their author and, flagged, for the server's moderators.
+func (s *Server) queryMessages(reader *sql.DB, channelID, viewerID int, clause string, args ...interface{}) ([]gin.H, error) {
+	moderator := s.canManageChannel(viewerID, channelID)
+	rows, err := reader.Query(`
+		SELECT m.id, m.content, m.created_at, m.user_id, u.username, COALESCE(m.bot_name, ''), COALESCE(m.embeds, ''), m.edited_at, m.edit_count, m.reply_to_id, m.thread_id, m.quarantined
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.channel_id = ? AND (m.quarantined = 0 OR m.user_id = ? OR ?) `+clause,
+		append([]interface{}{channelID, viewerID, moderator}, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	messages := make([]gin.H, 0) // Initialize as empty slice, not nil
+	messageIDs := make([]int64, 0)
+	for rows.Next() {
+		var message struct {
+			ID        int64  `json:"id"`
+			Content   string `json:"content"`
+			CreatedAt string `json:"created_at"`
+			UserID    int    `json:"user_id"`
+			Username  string `json:"username"`
+			BotName   string `json:"bot_name"`
+			Embeds    string `json:"embeds"`
+		}
+		var editedAt sql.NullString
+		var editCount int
+		var replyToID, threadID sql.NullInt64
+		var quarantined bool
+
+		err := rows.Scan(&message.ID, &message.Content, &message.CreatedAt, &message.UserID, &message.Username, &message.BotName, &message.Embeds, &editedAt, &editCount, &replyToID, &threadID, &quarantined)
+		if err != nil {
+			continue
+		}
+
+		messageIDs = append(messageIDs, message.ID)
+		entry := gin.H{
+			"id":        snowflake.ID(message.ID),
+			"content":   message.Content,
+			"createdAt": message.CreatedAt,
+			"authorId":  message.UserID,
+			"channelId": channelID,
+			"editCount": editCount,
+			"author": gin.H{
+				"id":       message.UserID,
+				"username": message.Username,
+			},
+		}
+		// Integration messages show the integration's name; the author is
+		// the user responsible for them
+		if message.BotName != "" {
+			entry["botName"] = message.BotName
+		}
+		if message.Embeds != "" {
+			entry["embeds"] = json.RawMessage(message.Embeds)
+		}
+		if editedAt.Valid {
+			entry["isEdited"] = true
+			entry["updatedAt"] = editedAt.String
+		}
+		if replyToID.Valid {
+			entry["replyToId"] = snowflake.ID(replyToID.Int64)
+			entry["threadId"] = snowflake.ID(threadID.Int64)
+		}
+		if quarantined && moderator {
+			entry["quarantined"] = true
+		}
+		messages = append(messages, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attachments := s.messageAttachments(messageIDs)
+	reactions := s.messageReactions(messageIDs)
+	for i, id := range messageIDs {
+		if list, ok := attachments[id]; ok {
+			messages[i]["attachments"] = list
+		}
+		if list, ok := reactions[id]; ok {
+			messages[i]["reactions"] = list
+		}
+	}
+	return messages, nil
+}
+
+// maxContextMessages bounds each side of a message context
+const maxContextMessages = 100
+
+// handleGetMessageContext returns a message with the messages around it,
+// newest first like history, so clients can jump to a pinned message or a
+// search result. before and after default to 25 each.
+func (s *Server) handleGetMessageContext(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	messageID, err := strconv.ParseInt(c.Param("messageId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	counts := map[string]int{"before": 25, "after": 25}
+	for name := range counts {
+		if value, ok := c.GetQuery(name); ok {
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 || count > maxContextMessages {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be between 0 and 100"})
+				return
+			}
+			counts[name] = count
+		}
+	}
+
+	channel, err := s.lookupChannelForUser(userID, channelID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+
+	reader := s.reader(c)
+	target, err := s.queryMessages(reader, channelID, userID, "AND m.id = ?", messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	if len(target) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	// One extra message on each side tells whether there is more
+	older, err := s.queryMessages(reader, channelID, userID, "AND m.id < ? ORDER BY m.id DESC LIMIT ?", messageID, counts["before"]+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	newer, err := s.queryMessages(reader, channelID, userID, "AND m.id > ? ORDER BY m.id LIMIT ?", messageID, counts["after"]+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	hasOlder, hasNewer := len(older) > counts["before"], len(newer) > counts["after"]
+	if hasOlder {
+		older = older[:counts["before"]]
+	}
+	if hasNewer {
+		newer = newer[:counts["after"]]
+	}
+
+	messages := make([]gin.H, 0, len(newer)+1+len(older))
+	for i := len(newer) - 1; i >= 0; i-- {
+		messages = append(messages, newer[i])
+	}
+	messages = append(messages, target[0])
+	messages = append(messages, older...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":  messages,
+		"messageId": snowflake.ID(messageID),
+		"channelId": channelID,
+		"serverId":  channel.ServerID,
+		"hasOlder":  hasOlder,
+		"hasNewer":  hasNewer,
+	})
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMessageContext(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"member", "outsider"} {
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", fmt.Sprintf("ctx%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Context %d", suffix), users["member"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", users["member"], serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	channels := make([]int64, 2)
+	for i := range channels {
+		result, err := db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, ?, 'text')", serverID, fmt.Sprintf("c%d", i))
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[i], _ = result.LastInsertId()
+	}
+	messages := make([]int64, 10)
+	for i := range messages {
+		result, err := db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, ?)", channels[0], users["member"], fmt.Sprintf("message %d", i))
+		if err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+		messages[i], _ = result.LastInsertId()
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, path string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.GET("/channels/:channelId/messages/:messageId/context", s.handleGetMessageContext)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	contextPath := func(channelID, messageID int64, query string) string {
+		return fmt.Sprintf("/channels/%d/messages/%d/context%s", channelID, messageID, query)
+	}
+
+	w := request("member", contextPath(channels[0], messages[5], "?before=2&after=3"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the context, got %d: %s", w.Code, w.Body.String())
+	}
+	var context struct {
+		Messages []struct {
+			ID snowflake.ID `json:"id"`
+		} `json:"messages"`
+		HasOlder bool `json:"hasOlder"`
+		HasNewer bool `json:"hasNewer"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &context)
+	want := []int64{messages[8], messages[7], messages[6], messages[5], messages[4], messages[3]}
+	if len(context.Messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(context.Messages))
+	}
+	for i, id := range want {
+		if int64(context.Messages[i].ID) != id {
+			t.Errorf("Expected message %d at %d, got %d", id, i, context.Messages[i].ID)
+		}
+	}
+	if !context.HasOlder || !context.HasNewer {
+		t.Errorf("Expected more messages on both sides, got %+v", context)
+	}
+
+	w = request("member", contextPath(channels[0], messages[8], ""))
+	_ = json.Unmarshal(w.Body.Bytes(), &context)
+	if len(context.Messages) != 10 || context.HasOlder || context.HasNewer {
+		t.Errorf("Expected the whole channel with nothing more, got %d messages %+v", len(context.Messages), context)
+	}
+
+	// Messages are only found in their own channel, by members
+	if w := request("member", contextPath(channels[1], messages[5], "")); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a message of another channel to be missing, got %d", w.Code)
+	}
+	if w := request("outsider", contextPath(channels[0], messages[5], "")); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the channel to be hidden from non-members, got %d", w.Code)
+	}
+	if w := request("member", contextPath(channels[0], messages[5], "?before=500")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an oversized context to be refused, got %d", w.Code)
+	}
+}
+
+func TestMessageRevisions(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"author", "moderator"} {
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", fmt.Sprintf("rev%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[name] = int(id)
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Revisions %d", suffix), users["moderator"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'member'), (?, ?, 'admin')",
+		users["author"], serverID, users["moderator"], serverID); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, 'general', 'text')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'first')", channelID, users["author"])
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	messageID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+	})
+	router.PUT("/messages/:messageId", s.handleEditMessage)
+	router.GET("/messages/:messageId/revisions", s.handleGetMessageRevisions)
+	router.GET("/channels/:channelId/messages", s.handleGetMessages)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	messagePath := fmt.Sprintf("/messages/%d", messageID)
+
+	for i, content := range []string{"second", "third"} {
+		w := request("PUT", messagePath, "author", fmt.Sprintf(`{"content":%q}`, content))
+		var edited struct {
+			Data struct {
+				EditCount int `json:"edit_count"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &edited); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Failed to edit, got %d: %s", w.Code, w.Body.String())
+		}
+		if edited.Data.EditCount != i+1 {
+			t.Errorf("Expected edit count %d, got %d", i+1, edited.Data.EditCount)
+		}
+	}
+
+	// History carries the count so clients can show the message as edited
+	w := request("GET", fmt.Sprintf("/channels/%d/messages", channelID), "author", "")
+	var history struct {
+		Messages []struct {
+			Content   string `json:"content"`
+			IsEdited  bool   `json:"isEdited"`
+			EditCount int    `json:"editCount"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil || len(history.Messages) != 1 {
+		t.Fatalf("Failed to get history, got %d: %s", w.Code, w.Body.String())
+	}
+	if message := history.Messages[0]; message.Content != "third" || !message.IsEdited || message.EditCount != 2 {
+		t.Errorf("Expected the message edited twice, got %+v", message)
+	}
+
+	// Only moderators see what the message used to say
+	if w := request("GET", messagePath+"/revisions", "author", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the author to be refused, got %d", w.Code)
+	}
+	w = request("GET", messagePath+"/revisions", "moderator", "")
+	var revisions struct {
+		Data struct {
+			Revisions []struct {
+				Content    string `json:"content"`
+				ReplacedBy int    `json:"replaced_by"`
+			} `json:"revisions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Failed to get revisions, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := revisions.Data.Revisions; len(got) != 2 || got[0].Content != "second" || got[1].Content != "first" || got[0].ReplacedBy != users["author"] {
+		t.Errorf("Expected the two earlier versions newest first, got %+v", got)
+	}
+
+	// A refresh by the server keeps no history
+	if _, err := s.updateChannelMessage(int(channelID), messageID, 0, "refreshed", nil); err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM message_revisions WHERE message_id = ?", messageID).Scan(&count); err != nil || count != 2 {
+		t.Errorf("Expected no revision for a refresh, got %d (%v)", count, err)
+	}
+
+	// Revisions go with the message
+	if err := s.deleteChannelMessage(int(channelID), messageID); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM message_revisions WHERE message_id = ?", messageID).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected the revisions deleted, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/plugins"
+)
+
+// disconnectUser closes every realtime connection a user holds: the main
+// WebSocket and their voice session. action and reason are forwarded to the
+// voice client so it can tell the user why they were dropped.
+func (s *Server) disconnectUser(userID int, action, reason string) {
+	s.clientsMux.Lock()
+	if client, exists := s.clients[userID]; exists {
+		if err := client.Close(); err != nil {
+			log.Printf("Error closing websocket for user %d: %v", userID, err)
+		}
+		delete(s.clients, userID)
+	}
+	s.clientsMux.Unlock()
+
+	s.voiceHub.DisconnectUser(int64(userID), action, reason)
+}
+
+// isUserBanned reports whether a user currently has an active ban
+func (s *Server) isUserBanned(userID int) bool {
+	return s.services.Moderation.IsBanned(userID)
+}
+
+// emitPluginEvent forwards a moderation or lifecycle event to plugin listeners
+func (s *Server) emitPluginEvent(eventType plugins.EventType, userID int, data map[string]interface{}) {
+	if s.plugins == nil {
+		return
+	}
+
+	s.plugins.EmitEvent(context.Background(), plugins.NewEvent(events.Event{
+		Type:      eventType,
+		UserID:    int64(userID),
+		Data:      data,
+		Timestamp: time.Now(),
+	}))
+}
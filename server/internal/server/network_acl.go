@@ -0,0 +1,243 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// networkListSettings hold the network ACL, each a comma-separated list of
+// IPs and CIDRs. The admin lists apply to admin and settings routes on top
+// of the others.
+var networkListSettings = []string{"ip_allowlist", "ip_denylist", "admin_ip_allowlist", "admin_ip_denylist"}
+
+// ipList is a list of networks; a single IP is a network of one address
+type ipList []*net.IPNet
+
+// parseIPList reads a comma-separated list of IPs and CIDRs
+func parseIPList(value string) (ipList, error) {
+	list := make(ipList, 0)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String writes the list back in the form it is stored in, with single
+// IPs without their mask
+func (l ipList) String() string {
+	entries := make([]string, 0, len(l))
+	for _, network := range l {
+		if ones, bits := network.Mask.Size(); ones == bits {
+			entries = append(entries, network.IP.String())
+		} else {
+			entries = append(entries, network.String())
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// networkACL decides which client IPs may reach the server. Denylists win
+// over allowlists, and an empty allowlist allows everyone.
+type networkACL struct {
+	allow, deny           ipList
+	adminAllow, adminDeny ipList
+}
+
+// newNetworkACL builds the ACL from the values of the network list settings.
+// Lists that do not parse are left empty, so a bad value cannot lock
+// everyone out.
+func newNetworkACL(values map[string]string) networkACL {
+	lists := make(map[string]ipList, len(networkListSettings))
+	for _, key := range networkListSettings {
+		list, err := parseIPList(values[key])
+		if err != nil {
+			log.Printf("Ignoring invalid %s: %v", key, err)
+		}
+		lists[key] = list
+	}
+	return networkACL{
+		allow:      lists["ip_allowlist"],
+		deny:       lists["ip_denylist"],
+		adminAllow: lists["admin_ip_allowlist"],
+		adminDeny:  lists["admin_ip_denylist"],
+	}
+}
+
+// allows reports whether a client IP may reach a route, applying the admin
+// lists too for admin routes
+func (acl networkACL) allows(ip net.IP, admin bool) bool {
+	if ip == nil {
+		return len(acl.allow) == 0 && (!admin || len(acl.adminAllow) == 0)
+	}
+	if acl.deny.contains(ip) || len(acl.allow) > 0 && !acl.allow.contains(ip) {
+		return false
+	}
+	if admin && (acl.adminDeny.contains(ip) || len(acl.adminAllow) > 0 && !acl.adminAllow.contains(ip)) {
+		return false
+	}
+	return true
+}
+
+// networkLists reads the network list settings
+func (s *Server) networkLists() map[string]string {
+	values := make(map[string]string, len(networkListSettings))
+	rows, err := s.db.Query("SELECT key, value FROM settings WHERE key IN (?, ?, ?, ?)",
+		networkListSettings[0], networkListSettings[1], networkListSettings[2], networkListSettings[3])
+	if err != nil {
+		log.Printf("Failed to read network lists: %v", err)
+		return values
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err == nil {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// clientIP parses a client address, which for WebSocket clients may still
+// carry the peer's port
+func clientIP(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
+// isAdminRoute reports whether a matched route is an admin or settings route
+func (s *Server) isAdminRoute(route string) bool {
+	api := s.basePath + "/api"
+	return strings.HasPrefix(route, api+"/admin/") || route == api+"/settings" || strings.HasPrefix(route, api+"/settings/")
+}
+
+// networkACLMiddleware refuses clients outside the network ACL before any
+// authentication. The health check stays reachable for load balancers.
+func (s *Server) networkACLMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == s.basePath+"/health" {
+			c.Next()
+			return
+		}
+		if !newNetworkACL(s.networkLists()).allows(clientIP(c.ClientIP()), s.isAdminRoute(route)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access from your network is not allowed", "code": "ip_blocked"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleBanClientIP adds the IP a connected user's WebSocket comes from to
+// the denylist and disconnects every client from it
+func (s *Server) handleBanClientIP(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.clientsMux.RLock()
+	client, connected := s.clients[userID]
+	var address string
+	if connected {
+		address = client.GetRemoteIP()
+	}
+	s.clientsMux.RUnlock()
+	if !connected {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not connected"})
+		return
+	}
+	ip := clientIP(address)
+	if ip == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The client's IP is unknown"})
+		return
+	}
+	if ip.Equal(clientIP(c.ClientIP())) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refusing to ban your own IP"})
+		return
+	}
+
+	current, _ := s.db.GetSetting("ip_denylist")
+	denylist, err := parseIPList(current)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "ip_denylist is invalid: " + err.Error()})
+		return
+	}
+	adminID := c.GetInt("user_id")
+	if !denylist.contains(ip) {
+		single, _ := parseIPList(ip.String())
+		denylist = append(denylist, single...)
+		if err := s.applySettingChange(adminID, settingChange{Key: "ip_denylist", Old: current, New: denylist.String()}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ip_denylist"})
+			return
+		}
+	}
+
+	// Everyone connected from the IP goes, not only the user it was seen on
+	s.clientsMux.RLock()
+	banned := make([]int, 0)
+	for id, other := range s.clients {
+		if ip.Equal(clientIP(other.GetRemoteIP())) {
+			banned = append(banned, id)
+		}
+	}
+	s.clientsMux.RUnlock()
+	for _, id := range banned {
+		s.disconnectUser(id, "ban", req.Reason)
+	}
+
+	s.logAdminAction(adminID, "ban_ip", fmt.Sprintf("Banned IP %s seen on user ID %d, disconnecting %d clients. Reason: %s", ip, userID, len(banned), req.Reason))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"ip":           ip.String(),
+			"disconnected": len(banned),
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNetworkACL(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range networkListSettings {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+		_ = db.SetSetting(key, "", settingDescriptions[key])
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(s.networkACLMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/health", ok)
+	router.GET("/api/probe", ok)
+	router.GET("/api/admin/probe", ok)
+	router.POST("/api/admin/users/:id/ban-ip", s.handleBanClientIP)
+	request := func(method, path, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = net.JoinHostPort(ip, "41000")
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Without lists everyone gets through
+	if w := request("GET", "/api/admin/probe", "203.0.113.7"); w.Code != http.StatusOK {
+		t.Errorf("Expected no ACL without lists, got %d", w.Code)
+	}
+
+	// Denylists win over allowlists
+	_ = db.SetSetting("ip_allowlist", "203.0.113.0/24,2001:db8::/32", settingDescriptions["ip_allowlist"])
+	_ = db.SetSetting("ip_denylist", "203.0.113.66", settingDescriptions["ip_denylist"])
+	for ip, want := range map[string]int{
+		"203.0.113.7":  http.StatusOK,
+		"2001:db8::1":  http.StatusOK,
+		"203.0.113.66": http.StatusForbidden,
+		"198.51.100.1": http.StatusForbidden,
+	} {
+		if w := request("GET", "/api/probe", ip); w.Code != want {
+			t.Errorf("Expected %d for %s, got %d", want, ip, w.Code)
+		}
+	}
+	if w := request("GET", "/api/probe", "198.51.100.1"); !strings.Contains(w.Body.String(), "ip_blocked") {
+		t.Errorf("Expected the ip_blocked code, got %s", w.Body.String())
+	}
+	if w := request("GET", "/health", "198.51.100.1"); w.Code != http.StatusOK {
+		t.Errorf("Expected the health check to stay reachable, got %d", w.Code)
+	}
+
+	// Admin lists narrow admin routes only
+	_ = db.SetSetting("admin_ip_allowlist", "203.0.113.0/28", settingDescriptions["admin_ip_allowlist"])
+	if w := request("GET", "/api/admin/probe", "203.0.113.7"); w.Code != http.StatusOK {
+		t.Errorf("Expected admins inside the admin allowlist through, got %d", w.Code)
+	}
+	if w := request("GET", "/api/admin/probe", "203.0.113.99"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected admin routes refused outside the admin allowlist, got %d", w.Code)
+	}
+	if w := request("GET", "/api/probe", "203.0.113.99"); w.Code != http.StatusOK {
+		t.Errorf("Expected other routes through outside the admin allowlist, got %d", w.Code)
+	}
+
+	// Only connected users' IPs can be banned
+	if w := request("POST", "/api/admin/users/424242/ban-ip", "203.0.113.7"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a user who is not connected to be refused, got %d", w.Code)
+	}
+
+	// Lists are stored in their canonical form and bad entries are refused
+	list, err := parseIPList(" 10.0.0.1, 10.1.2.3/16 ,::1")
+	if err != nil || list.String() != "10.0.0.1,10.1.0.0/16,::1" {
+		t.Errorf("Expected the canonical list, got %q (%v)", list, err)
+	}
+	if _, err := parseIPList("10.0.0.1,example.com"); err == nil {
+		t.Error("Expected a host name to be refused")
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/snowflake"
+	"fethur/internal/websocket"
+)
+
+// maxOfflineEventsPerUser bounds the per-user offline queue; the oldest
+// entries are dropped first.
+const maxOfflineEventsPerUser = 200
+
+// Offline event types
+const (
+	offlineEventMention     = "mention"
+	offlineEventThreadReply = "thread_reply"
+)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9_.-]+)`)
+
+// extractMentions returns the unique usernames mentioned in a message
+func extractMentions(content string) []string {
+	seen := make(map[string]bool)
+	mentions := make([]string, 0)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(match[1], ".")
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		mentions = append(mentions, name)
+	}
+	return mentions
+}
+
+// queueOfflineMentions records a mention for every mentioned channel member
+// that has no active session, so it can be summarized on their next connect.
+func (s *Server) queueOfflineMentions(channelID int, messageID int64, senderID int, content string) {
+	for _, name := range extractMentions(content) {
+		var userID int
+		err := s.db.QueryRow(`
+			SELECT u.id FROM users u
+			JOIN server_members sm ON sm.user_id = u.id
+			JOIN channels c ON c.server_id = sm.server_id
+			WHERE u.username = ? COLLATE NOCASE AND c.id = ? AND `+channelVisibleSQL,
+			name, channelID,
+		).Scan(&userID)
+		if err != nil || userID == senderID || s.hub.IsConnected(userID) {
+			continue
+		}
+
+		s.queueOfflineEvent(userID, offlineEventMention, channelID, messageID)
+		s.sendMentionPush(userID, channelID, messageID, senderID, content)
+	}
+}
+
+// queueOfflineEvent stores an event reference and trims the user's queue
+func (s *Server) queueOfflineEvent(userID int, eventType string, channelID int, messageID int64) {
+	if _, err := s.db.Exec(
+		"INSERT OR IGNORE INTO offline_events (user_id, event_type, channel_id, message_id) VALUES (?, ?, ?, ?)",
+		userID, eventType, channelID, messageID,
+	); err != nil {
+		log.Printf("Failed to queue offline event for user %d: %v", userID, err)
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		DELETE FROM offline_events WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM offline_events WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)`,
+		userID, userID, maxOfflineEventsPerUser,
+	); err != nil {
+		log.Printf("Failed to trim offline queue for user %d: %v", userID, err)
+	}
+}
+
+// deliverCatchUp sends a compact summary of queued events to a freshly
+// connected client and clears the delivered entries. Clients use the
+// message IDs to fetch exactly what changed.
+func (s *Server) deliverCatchUp(client *websocket.Client, userID int) {
+	rows, err := s.db.Query(
+		"SELECT id, event_type, channel_id, message_id FROM offline_events WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		log.Printf("Failed to load offline events for user %d: %v", userID, err)
+		return
+	}
+
+	type channelSummary struct {
+		ChannelID  int            `json:"channel_id"`
+		EventType  string         `json:"event_type"`
+		MessageIDs []snowflake.ID `json:"message_ids"`
+	}
+
+	var lastID int64
+	total := 0
+	summaries := make([]*channelSummary, 0)
+	index := make(map[string]*channelSummary)
+	for rows.Next() {
+		var id, messageID int64
+		var eventType string
+		var channelID int
+		if err := rows.Scan(&id, &eventType, &channelID, &messageID); err != nil {
+			continue
+		}
+
+		key := eventType + ":" + strconv.Itoa(channelID)
+		summary, ok := index[key]
+		if !ok {
+			summary = &channelSummary{ChannelID: channelID, EventType: eventType}
+			index[key] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.MessageIDs = append(summary.MessageIDs, snowflake.ID(messageID))
+		lastID = id
+		total++
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing offline event rows: %v", err)
+	}
+
+	if total == 0 {
+		return
+	}
+
+	client.Send(&websocket.Message{
+		Type:      websocket.MessageTypeCatchUp,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"events":    summaries,
+			"total":     total,
+			"truncated": total >= maxOfflineEventsPerUser,
+		},
+	})
+
+	if _, err := s.db.Exec("DELETE FROM offline_events WHERE user_id = ? AND id <= ?", userID, lastID); err != nil {
+		log.Printf("Failed to clear offline events for user %d: %v", userID, err)
+	}
+}
+
+// queueNotification stores a notification for a user with no active
+// session, to be delivered on their next connect
+func (s *Server) queueNotification(userID int, kind string, message *websocket.Message) {
+	data, err := json.Marshal(message.Data)
+	if err != nil {
+		log.Printf("Failed to encode notification for user %d: %v", userID, err)
+		return
+	}
+	if _, err := s.db.Exec(
+		"INSERT INTO user_notifications (user_id, kind, content, data) VALUES (?, ?, ?, ?)",
+		userID, kind, message.Content, string(data),
+	); err != nil {
+		log.Printf("Failed to queue notification for user %d: %v", userID, err)
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		DELETE FROM user_notifications WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM user_notifications WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)`,
+		userID, userID, maxOfflineEventsPerUser,
+	); err != nil {
+		log.Printf("Failed to trim notifications for user %d: %v", userID, err)
+	}
+}
+
+// deliverNotifications sends the notifications queued while the user was
+// offline to a freshly connected client and clears them
+func (s *Server) deliverNotifications(client *websocket.Client, userID int) {
+	rows, err := s.db.Query(
+		"SELECT id, content, data, created_at FROM user_notifications WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		log.Printf("Failed to load notifications for user %d: %v", userID, err)
+		return
+	}
+
+	var lastID int64
+	messages := make([]*websocket.Message, 0)
+	for rows.Next() {
+		var id int64
+		var content, data string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &content, &data, &createdAt); err != nil {
+			continue
+		}
+		var fields map[string]interface{}
+		_ = json.Unmarshal([]byte(data), &fields)
+		messages = append(messages, &websocket.Message{
+			Type:      string(events.TypeNotification),
+			Content:   content,
+			Timestamp: createdAt,
+			Data:      fields,
+		})
+		lastID = id
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing notification rows: %v", err)
+	}
+
+	for _, message := range messages {
+		client.Send(message)
+	}
+	if lastID != 0 {
+		if _, err := s.db.Exec("DELETE FROM user_notifications WHERE user_id = ? AND id <= ?", userID, lastID); err != nil {
+			log.Printf("Failed to clear notifications for user %d: %v", userID, err)
+		}
+	}
+}
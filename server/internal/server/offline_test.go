@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestOfflineEvents(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	previous, _ := db.GetSetting("email_verification")
+	defer func() {
+		_ = db.SetSetting("email_verification", previous, settingDescriptions["email_verification"])
+	}()
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"sender", "away", "outsider", "busy"} {
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", fmt.Sprintf("%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[name] = int(id)
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Offline %d", suffix), users["sender"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for _, name := range []string{"sender", "away", "busy"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", users[name], serverID); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	channels := make([]int, 2)
+	for i := range channels {
+		result, err := db.Exec("INSERT INTO channels (name, server_id) VALUES (?, ?)", fmt.Sprintf("offline-%d", i), serverID)
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		channels[i] = int(id)
+	}
+	post := func(channelID int, content string) int64 {
+		result, err := db.Exec("INSERT INTO messages (content, user_id, channel_id) VALUES (?, ?, ?)", content, users["sender"], channelID)
+		if err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	queued := func(userID int) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM offline_events WHERE user_id = ?", userID).Scan(&n); err != nil {
+			t.Fatalf("Failed to count offline events: %v", err)
+		}
+		return n
+	}
+
+	// Mentions are queued once per message for offline members of the
+	// channel, whatever the case, and never for the sender or outsiders
+	content := fmt.Sprintf("@away_%d look, @AWAY_%d again. @outsider_%d @sender_%d", suffix, suffix, suffix, suffix)
+	first := post(channels[0], content)
+	s.queueOfflineMentions(channels[0], first, users["sender"], content)
+	second := post(channels[0], content)
+	s.queueOfflineMentions(channels[0], second, users["sender"], content)
+	s.queueOfflineMentions(channels[0], second, users["sender"], content)
+	if n := queued(users["away"]); n != 2 {
+		t.Errorf("Expected one mention per message for the offline member, got %d", n)
+	}
+	if queued(users["outsider"]) != 0 || queued(users["sender"]) != 0 {
+		t.Error("Expected no mentions queued for the outsider or the sender")
+	}
+	reply := post(channels[1], "in a thread")
+	s.queueOfflineEvent(users["away"], offlineEventThreadReply, channels[1], reply)
+
+	// The queue keeps only the newest entries
+	for i := 1; i <= maxOfflineEventsPerUser+5; i++ {
+		s.queueOfflineEvent(users["busy"], offlineEventMention, channels[0], int64(i))
+	}
+	if n := queued(users["busy"]); n != maxOfflineEventsPerUser {
+		t.Errorf("Expected the queue trimmed to %d, got %d", maxOfflineEventsPerUser, n)
+	}
+	var oldest int64
+	if err := db.QueryRow("SELECT MIN(message_id) FROM offline_events WHERE user_id = ?", users["busy"]).Scan(&oldest); err != nil || oldest != 6 {
+		t.Errorf("Expected the oldest entries dropped first, got %d (%v)", oldest, err)
+	}
+
+	// Connecting delivers a summary grouped by channel and event type, then
+	// clears what was delivered
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", s.authMiddleware(), s.handleWebSocket)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	type catchUp struct {
+		Events []struct {
+			ChannelID  int      `json:"channel_id"`
+			EventType  string   `json:"event_type"`
+			MessageIDs []string `json:"message_ids"`
+		} `json:"events"`
+		Total     int  `json:"total"`
+		Truncated bool `json:"truncated"`
+	}
+	connect := func(name string) catchUp {
+		token, err := s.auth.GenerateToken(users[name], fmt.Sprintf("%s_%d", name, suffix), "user")
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		conn, resp, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+token, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		_ = resp.Body.Close()
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("Expected a catch-up summary for %s: %v", name, err)
+			}
+			var message struct {
+				Type string  `json:"type"`
+				Data catchUp `json:"data"`
+			}
+			if json.Unmarshal(data, &message) == nil && message.Type == websocket.MessageTypeCatchUp {
+				return message.Data
+			}
+		}
+	}
+	cleared := func(userID int) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if queued(userID) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	summary := connect("away")
+	if summary.Total != 3 || summary.Truncated || len(summary.Events) != 2 {
+		t.Fatalf("Expected three events in two groups, got %+v", summary)
+	}
+	mentions, replies := summary.Events[0], summary.Events[1]
+	if mentions.ChannelID != channels[0] || mentions.EventType != offlineEventMention ||
+		strings.Join(mentions.MessageIDs, ",") != strconv.FormatInt(first, 10)+","+strconv.FormatInt(second, 10) {
+		t.Errorf("Unexpected mention summary %+v", mentions)
+	}
+	if replies.ChannelID != channels[1] || replies.EventType != offlineEventThreadReply ||
+		len(replies.MessageIDs) != 1 || replies.MessageIDs[0] != strconv.FormatInt(reply, 10) {
+		t.Errorf("Unexpected thread reply summary %+v", replies)
+	}
+	if !cleared(users["away"]) {
+		t.Error("Expected the delivered events to be cleared")
+	}
+
+	// A full queue is flagged as possibly missing older events
+	if summary := connect("busy"); summary.Total != maxOfflineEventsPerUser || !summary.Truncated {
+		t.Errorf("Expected a truncated summary of %d events, got total %d", maxOfflineEventsPerUser, summary.Total)
+	}
+	if !cleared(users["busy"]) {
+		t.Error("Expected the delivered events to be cleared")
+	}
+}
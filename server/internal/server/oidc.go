@@ -0,0 +1,434 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fethur/internal/auth"
+	"fethur/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Single sign-on with an OpenID Connect provider. /api/auth/oidc/login
+// sends the browser to the provider, which sends it back to
+// /api/auth/oidc/callback. The callback finds the account linked to the
+// provider's subject, links or creates one if allowed, and hands the
+// tokens to the web client in the URL fragment.
+
+const (
+	oidcCookie       = "fethur_oidc"
+	oidcCookieMaxAge = 10 * 60
+)
+
+// oidcCache keeps the provider built from the current settings, so its
+// discovery document and keys are not fetched again on every sign-in
+type oidcCache struct {
+	mutex    sync.Mutex
+	provider *auth.OIDCProvider
+}
+
+var (
+	errOIDCNoAccount = errors.New("no account is linked to this sign-in")
+	errOIDCOtherOrg  = errors.New("the linked account belongs to another organization")
+)
+
+// oidcProvider returns the configured provider, or nil when single sign-on
+// is off or incomplete
+func (s *Server) oidcProvider() *auth.OIDCProvider {
+	if !s.getBoolSetting("oidc_enabled", false) {
+		return nil
+	}
+	issuer, _ := s.db.GetSetting("oidc_issuer")
+	clientID, _ := s.db.GetSetting("oidc_client_id")
+	clientSecret, _ := s.db.GetSetting("oidc_client_secret")
+	if issuer == "" || clientID == "" {
+		return nil
+	}
+	config := auth.OIDCConfig{Issuer: strings.TrimRight(issuer, "/"), ClientID: clientID, ClientSecret: clientSecret}
+
+	s.oidc.mutex.Lock()
+	defer s.oidc.mutex.Unlock()
+	if s.oidc.provider == nil || s.oidc.provider.Config() != config {
+		s.oidc.provider = auth.NewOIDCProvider(config)
+	}
+	return s.oidc.provider
+}
+
+// oidcRedirectURL is the callback URL registered with the provider
+func (s *Server) oidcRedirectURL(c *gin.Context) string {
+	base := s.publicURL
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host + s.basePath
+	}
+	return base + "/api/auth/oidc/callback"
+}
+
+// oidcDone sends the browser back to the web client with the outcome in
+// the URL fragment, which is never sent to a server
+func (s *Server) oidcDone(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, s.externalURL("/")+"#"+values.Encode())
+}
+
+func (s *Server) oidcFailed(c *gin.Context, message string) {
+	s.oidcDone(c, url.Values{"oidc_error": {message}})
+}
+
+func (s *Server) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || strings.HasPrefix(s.oidcRedirectURL(c), "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, value, maxAge, s.basePath+"/api/auth/oidc", "", secure, true)
+}
+
+// handleGetOIDC tells the login page whether to offer single sign-on
+func (s *Server) handleGetOIDC(c *gin.Context) {
+	displayName, err := s.db.GetSetting("oidc_display_name")
+	if err != nil || displayName == "" {
+		displayName = "Single sign-on"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"enabled":      s.oidcProvider() != nil,
+			"display_name": displayName,
+		},
+	})
+}
+
+// handleOIDCLogin starts a sign-in at the provider
+func (s *Server) handleOIDCLogin(c *gin.Context) {
+	provider := s.oidcProvider()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	state, nonce, verifier, err := auth.NewOIDCState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+	target, err := provider.AuthCodeURL(c.Request.Context(), s.oidcRedirectURL(c), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Failed to reach OpenID Connect provider: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The sign-in provider is unavailable"})
+		return
+	}
+
+	// The browser brings these back on the callback, tying it to this
+	// attempt; an invite code comes along for creating the account
+	invite := base64.RawURLEncoding.EncodeToString([]byte(c.Query("invite")))
+	s.setOIDCCookie(c, strings.Join([]string{state, nonce, verifier, invite}, "."), oidcCookieMaxAge)
+	c.Redirect(http.StatusFound, target)
+}
+
+// handleOIDCCallback finishes a sign-in the provider sent back
+func (s *Server) handleOIDCCallback(c *gin.Context) {
+	cookie, _ := c.Cookie(oidcCookie)
+	s.setOIDCCookie(c, "", -1)
+
+	provider := s.oidcProvider()
+	if provider == nil {
+		s.oidcFailed(c, "Single sign-on is not configured")
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		log.Printf("OpenID Connect provider refused sign-in: %s %s", reason, c.Query("error_description"))
+		s.oidcFailed(c, "Sign-in was cancelled or refused by the provider")
+		return
+	}
+
+	parts := strings.Split(cookie, ".")
+	state := c.Query("state")
+	if len(parts) != 4 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		s.oidcFailed(c, "Sign-in expired, please try again")
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+	invite, _ := base64.RawURLEncoding.DecodeString(parts[3])
+
+	identity, err := provider.Exchange(c.Request.Context(), s.oidcRedirectURL(c), c.Query("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("OpenID Connect sign-in failed: %v", err)
+		s.oidcFailed(c, "Sign-in with the provider failed")
+		return
+	}
+
+	orgID, ok := s.requestOrgID(c)
+	if !ok {
+		return
+	}
+	userID, err := s.oidcUser(identity, orgID, string(invite))
+	if errors.Is(err, errOIDCNoAccount) || errors.Is(err, errOIDCOtherOrg) {
+		s.oidcFailed(c, "No account is linked to this sign-in")
+		return
+	}
+	if errors.Is(err, service.ErrRegistrationClosed) {
+		s.oidcFailed(c, "No account is linked to this sign-in, and registration is closed")
+		return
+	}
+	if errors.Is(err, service.ErrInviteInvalid) {
+		s.oidcFailed(c, "No account is linked to this sign-in; a valid invite code is needed to create one")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find account for %s at %s: %v", identity.Subject, identity.Issuer, err)
+		s.oidcFailed(c, "Failed to sign in")
+		return
+	}
+
+	var username, role string
+	var tokenVersion int
+	var twoFactor, pending bool
+	err = s.db.QueryRow(
+		"SELECT username, role, token_version, totp_enabled, pending_approval FROM users WHERE id = ?", userID,
+	).Scan(&username, &role, &tokenVersion, &twoFactor, &pending)
+	if err != nil {
+		s.oidcFailed(c, "Failed to sign in")
+		return
+	}
+	if s.isUserBanned(userID) {
+		s.oidcFailed(c, "Account is banned")
+		return
+	}
+	if pending {
+		s.oidcFailed(c, "Your account is waiting for an admin's approval")
+		return
+	}
+
+	// Unverified users get a new verification link instead of a session
+	if s.emailVerificationMode() == "required" && s.needsVerification(userID) {
+		_ = s.sendVerificationEmail(c.Request.Context(), userID)
+		s.oidcFailed(c, "Verify your email address to sign in")
+		return
+	}
+
+	// Accounts with two-factor sign-in still need their code
+	if twoFactor {
+		challenge, err := s.auth.GenerateChallengeToken(userID, tokenVersion)
+		if err != nil {
+			s.oidcFailed(c, "Failed to sign in")
+			return
+		}
+		s.oidcDone(c, url.Values{
+			"two_factor_required": {"true"},
+			"challenge_token":     {challenge},
+			"expires_in":          {strconv.Itoa(int(auth.ChallengeTokenTTL.Seconds()))},
+		})
+		return
+	}
+
+	session, err := s.startSession(c, userID, username, role, tokenVersion)
+	if err != nil {
+		s.oidcFailed(c, "Failed to sign in")
+		return
+	}
+	s.oidcDone(c, url.Values{
+		"token":         {session.Token},
+		"refresh_token": {session.RefreshToken},
+		"expires_in":    {strconv.Itoa(int(auth.AccessTokenTTL.Seconds()))},
+		"new_device":    {strconv.FormatBool(session.NewDevice)},
+	})
+}
+
+// oidcUser returns the account a provider identity signs in as. Identities
+// are matched by issuer and subject; an unknown one is linked to the
+// account with the same verified email when oidc_link_email is on, or gets
+// a new account when oidc_auto_create is on and the organization's
+// auth_mode lets people register themselves: in invite_only mode the new
+// account uses up invite, and in admin_only or open_registration mode,
+// whose shared password the provider cannot vouch for, none is created.
+func (s *Server) oidcUser(identity *auth.OIDCIdentity, orgID int, invite string) (int, error) {
+	var userID, userOrgID int
+	err := s.db.QueryRow(`
+		SELECT users.id, users.org_id FROM user_identities
+		JOIN users ON users.id = user_identities.user_id
+		WHERE user_identities.issuer = ? AND user_identities.subject = ?
+	`, identity.Issuer, identity.Subject).Scan(&userID, &userOrgID)
+	if err == nil {
+		if orgID != 0 && userOrgID != orgID {
+			return 0, errOIDCOtherOrg
+		}
+		if _, err := s.db.Exec(
+			"UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = ? WHERE issuer = ? AND subject = ?",
+			identity.Email, identity.Issuer, identity.Subject,
+		); err != nil {
+			log.Printf("Failed to update identity of user %d: %v", userID, err)
+		}
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if identity.EmailVerified && identity.Email != "" && s.getBoolSetting("oidc_link_email", false) {
+		err := s.db.QueryRow(
+			"SELECT id FROM users WHERE LOWER(email) = LOWER(?) AND org_id = ?", identity.Email, orgID,
+		).Scan(&userID)
+		if err == nil {
+			if err := s.linkIdentity(s.db, userID, identity); err != nil {
+				return 0, err
+			}
+			// The provider vouched for the address the accounts share
+			if _, err := s.db.Exec("UPDATE users SET email_verified = 1 WHERE id = ?", userID); err != nil {
+				return 0, err
+			}
+			log.Printf("Linked %s at %s to user %d by email", identity.Subject, identity.Issuer, userID)
+			return userID, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
+	if !s.getBoolSetting("oidc_auto_create", true) {
+		return 0, errOIDCNoAccount
+	}
+	authMode, err := s.orgSetting(orgID, "auth_mode")
+	if err != nil {
+		authMode = "public"
+	}
+	if authMode == "admin_only" || authMode == "open_registration" {
+		return 0, service.ErrRegistrationClosed
+	}
+	if authMode == "invite_only" && invite == "" {
+		return 0, service.ErrInviteInvalid
+	}
+	if err := s.checkOrgLimit(orgID, "users"); err != nil {
+		return 0, errOIDCNoAccount
+	}
+	if authMode != "invite_only" {
+		invite = ""
+	}
+	return s.createOIDCUser(identity, orgID, invite)
+}
+
+// linkIdentity records that a provider identity signs in as a user
+func (s *Server) linkIdentity(db execer, userID int, identity *auth.OIDCIdentity) error {
+	_, err := db.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		userID, identity.Issuer, identity.Subject, identity.Email,
+	)
+	return err
+}
+
+// createOIDCUser creates an account for a provider identity, using up
+// invite unless it is empty. It has a random password, so it can only sign
+// in through the provider until one is set.
+func (s *Server) createOIDCUser(identity *auth.OIDCIdentity, orgID int, invite string) (int, error) {
+	password, err := s.auth.GenerateRandomString(32)
+	if err != nil {
+		return 0, err
+	}
+	passwordHash, err := s.auth.HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	email := ""
+	if identity.EmailVerified {
+		email = identity.Email
+	}
+	approval, _ := s.orgSetting(orgID, "registration_approval")
+	pending := approval == "true"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if invite != "" {
+		if err := service.UseInvite(tx, orgID, invite); err != nil {
+			return 0, err
+		}
+	}
+
+	base := oidcUsername(identity)
+	var result sql.Result
+	for attempt := 1; attempt <= 20; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = fmt.Sprintf("%s%d", base, attempt)
+		}
+		var taken bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&taken); err != nil {
+			return 0, err
+		}
+		if taken {
+			continue
+		}
+		result, err = tx.Exec(
+			"INSERT INTO users (username, email, password_hash, role, org_id, email_verified, pending_approval) VALUES (?, ?, ?, 'user', ?, ?, ?)",
+			username, email, passwordHash, orgID, email != "", pending,
+		)
+		if err != nil {
+			return 0, err
+		}
+		break
+	}
+	if result == nil {
+		return 0, fmt.Errorf("no free username like %q", base)
+	}
+
+	id, _ := result.LastInsertId()
+	if err := s.linkIdentity(tx, int(id), identity); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	log.Printf("Created user %d for %s at %s", id, identity.Subject, identity.Issuer)
+	if pending {
+		s.notifyPendingRegistration(int(id), orgID)
+	}
+	return int(id), nil
+}
+
+var oidcUsernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oidcUsername picks a username from the provider's claims
+func oidcUsername(identity *auth.OIDCIdentity) string {
+	for _, candidate := range []string{
+		identity.PreferredUsername,
+		strings.SplitN(identity.Email, "@", 2)[0],
+		strings.ReplaceAll(identity.Name, " ", "."),
+	} {
+		name := strings.Trim(oidcUsernameInvalid.ReplaceAllString(candidate, ""), ".-")
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		if len(name) >= 3 {
+			return name
+		}
+	}
+	return "user"
+}
+
+// validOIDCIssuer requires https, except for a provider on this machine
+func validOIDCIssuer(issuer string) bool {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
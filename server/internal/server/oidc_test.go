@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+	"fethur/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDCProvider issues ID tokens for whoever the test says signs in
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	subject   string
+	username  string
+	nonce     string
+	challenge string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	provider := &fakeOIDCProvider{key: key}
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"jwks_uri":               provider.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if id != "fethur" || secret != "shh" || r.FormValue("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != provider.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                provider.URL,
+			"sub":                provider.subject,
+			"aud":                "fethur",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              provider.nonce,
+			"preferred_username": provider.username,
+			"email":              provider.username + "@example.com",
+			"email_verified":     true,
+		})
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(key)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	provider.Server = httptest.NewServer(mux)
+	return provider
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	provider := newFakeOIDCProvider(t)
+	defer provider.Close()
+
+	for key, value := range map[string]string{
+		"oidc_enabled":       "true",
+		"oidc_issuer":        provider.URL,
+		"oidc_client_id":     "fethur",
+		"oidc_client_secret": "shh",
+		"oidc_auto_create":   "true",
+		"oidc_link_email":    "false",
+	} {
+		if err := db.SetSetting(key, value, ""); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	defer func() {
+		_ = db.SetSetting("oidc_enabled", "false", "")
+	}()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/auth/oidc/login", s.handleOIDCLogin)
+	router.GET("/api/auth/oidc/callback", s.handleOIDCCallback)
+
+	// signIn goes through the provider as a subject and returns the
+	// fragment the web client is sent back with
+	signIn := func(subject, username string, tamper bool) url.Values {
+		provider.subject, provider.username = subject, username
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/oidc/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect to the provider, got %d: %s", w.Code, w.Body.String())
+		}
+		authorize, _ := url.Parse(w.Header().Get("Location"))
+		query := authorize.Query()
+		provider.nonce, provider.challenge = query.Get("nonce"), query.Get("code_challenge")
+		if query.Get("client_id") != "fethur" || query.Get("redirect_uri") != "http://example.com/api/auth/oidc/callback" {
+			t.Errorf("Unexpected authorization request %s", authorize)
+		}
+
+		state := query.Get("state")
+		if tamper {
+			state = "forged"
+		}
+		r := httptest.NewRequest("GET", "/api/auth/oidc/callback?code=good-code&state="+url.QueryEscape(state), nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		location, _ := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || location.Path != "/" {
+			t.Fatalf("Expected a redirect back to the client, got %d: %s", w.Code, w.Header().Get("Location"))
+		}
+		fragment, _ := url.ParseQuery(location.Fragment)
+		return fragment
+	}
+
+	subject := "sub-" + time.Now().Format("150405.000000000")
+	username := "oidc" + strings.ReplaceAll(time.Now().Format("150405.000000"), ".", "")
+	first := signIn(subject, username, false)
+	claims, err := s.auth.ValidateToken(first.Get("token"))
+	if err != nil || first.Get("refresh_token") == "" {
+		t.Fatalf("Expected tokens in the fragment, got %v (%v)", first, err)
+	}
+	if claims.Username != username {
+		t.Errorf("Expected an account named %s, got %s", username, claims.Username)
+	}
+
+	// The same subject signs in to the same account, even if its name changed
+	second := signIn(subject, username+"x", false)
+	again, err := s.auth.ValidateToken(second.Get("token"))
+	if err != nil || again.UserID != claims.UserID {
+		t.Errorf("Expected the linked account %d, got %+v (%v)", claims.UserID, again, err)
+	}
+
+	if got := signIn(subject, username, true); got.Get("token") != "" || got.Get("oidc_error") == "" {
+		t.Errorf("Expected a forged state to be refused, got %v", got)
+	}
+
+	// Without automatic creation, unknown subjects are turned away
+	_ = db.SetSetting("oidc_auto_create", "false", "")
+	if got := signIn(subject+"-new", username+"new", false); got.Get("token") != "" || got.Get("oidc_error") == "" {
+		t.Errorf("Expected an unlinked subject to be refused, got %v", got)
+	}
+}
+
+func TestOIDCAutoCreateFollowsAuthMode(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"oidc_auto_create", "oidc_link_email", "registration_approval"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("oidc_auto_create", "true", settingDescriptions["oidc_auto_create"])
+	_ = db.SetSetting("oidc_link_email", "false", settingDescriptions["oidc_link_email"])
+	_ = db.SetSetting("registration_approval", "false", settingDescriptions["registration_approval"])
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO organizations (name, slug) VALUES ('OIDC', ?)", fmt.Sprintf("oidc-%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create organization: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	orgID := int(id)
+	invite := fmt.Sprintf("oidc%d", suffix)
+	if _, err := db.Exec("INSERT INTO registration_invites (code, org_id, max_uses) VALUES (?, ?, 1)", invite, orgID); err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+
+	for _, tc := range []struct {
+		mode    string
+		invite  string
+		wantErr error
+	}{
+		{"public", "", nil},
+		{"admin_only", "", service.ErrRegistrationClosed},
+		{"open_registration", "", service.ErrRegistrationClosed},
+		{"invite_only", "", service.ErrInviteInvalid},
+		{"invite_only", "wrong", service.ErrInviteInvalid},
+		{"invite_only", invite, nil},
+		// The invite allowed a single use
+		{"invite_only", invite, service.ErrInviteInvalid},
+	} {
+		if _, err := db.Exec(
+			"INSERT OR REPLACE INTO organization_settings (org_id, key, value) VALUES (?, 'auth_mode', ?)", orgID, tc.mode,
+		); err != nil {
+			t.Fatalf("Failed to set auth_mode: %v", err)
+		}
+		identity := &auth.OIDCIdentity{
+			Issuer:            "https://id.example.com",
+			Subject:           fmt.Sprintf("sub-%d", time.Now().UnixNano()),
+			PreferredUsername: fmt.Sprintf("oidcmode%d", time.Now().UnixNano()),
+		}
+		userID, err := s.oidcUser(identity, orgID, tc.invite)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s with invite %q: expected %v, got %v", tc.mode, tc.invite, tc.wantErr, err)
+			continue
+		}
+		var accounts int
+		_ = db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE subject = ?", identity.Subject).Scan(&accounts)
+		if created := tc.wantErr == nil; created != (accounts == 1) || created != (userID != 0) {
+			t.Errorf("%s with invite %q: expected an account created to be %t, got user %d", tc.mode, tc.invite, created, userID)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fethur/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// organization is an isolated community hosted on the deployment. Users and
+// servers outside every organization belong to the instance itself, which
+// is org ID 0.
+type organization struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	Domain     string `json:"domain"`
+	MaxUsers   int    `json:"max_users"`
+	MaxServers int    `json:"max_servers"`
+	Users      int    `json:"users"`
+	Servers    int    `json:"servers"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// orgHeader selects an organization by slug for clients that do not reach
+// it through its own domain
+const orgHeader = "X-Fethur-Org"
+
+// orgCapabilities are the admin capabilities that apply inside an
+// organization. Everything else is instance-wide and reserved for instance
+// admins.
+var orgCapabilities = map[string]bool{
+	capManageUsers: true,
+	capModerate:    true,
+}
+
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// requestOrgID resolves the organization a request is addressed to, from
+// the org header or the request's host. Requests to any other host belong
+// to the instance. It writes the error response when a named organization
+// does not exist.
+func (s *Server) requestOrgID(c *gin.Context) (int, bool) {
+	var orgID int
+	if slug := strings.TrimSpace(c.GetHeader(orgHeader)); slug != "" {
+		err := s.db.QueryRow("SELECT id FROM organizations WHERE slug = ?", strings.ToLower(slug)).Scan(&orgID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return 0, false
+		}
+		return orgID, true
+	}
+
+	host := c.Request.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if host == "" {
+		return 0, true
+	}
+	err := s.db.QueryRow("SELECT id FROM organizations WHERE domain = ?", strings.ToLower(host)).Scan(&orgID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to resolve organization for host %s: %v", host, err)
+	}
+	return orgID, true
+}
+
+// orgMiddleware scopes an authenticated request to the user's organization
+// and stores its ID as org_id. A token is refused on another
+// organization's domain.
+func (s *Server) orgMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested, ok := s.requestOrgID(c)
+		if !ok {
+			return
+		}
+		orgID := s.userOrgID(c.GetInt("user_id"))
+		if requested != 0 && requested != orgID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account belongs to another organization"})
+			c.Abort()
+			return
+		}
+		c.Set("org_id", orgID)
+		c.Next()
+	}
+}
+
+// orgTargetMiddleware hides users of other organizations from org admins
+// on routes addressing a user by ID
+func (s *Server) orgTargetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := c.GetInt("org_id")
+		if orgID == 0 {
+			c.Next()
+			return
+		}
+		var targetOrgID int
+		err := s.db.QueryRow("SELECT org_id FROM users WHERE id = ?", c.Param("id")).Scan(&targetOrgID)
+		if err != nil || targetOrgID != orgID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// userOrgID returns the organization a user belongs to
+func (s *Server) userOrgID(userID int) int {
+	var orgID int
+	if err := s.db.QueryRow("SELECT org_id FROM users WHERE id = ?", userID).Scan(&orgID); err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load organization of user %d: %v", userID, err)
+	}
+	return orgID
+}
+
+// serverOrgID returns the organization a server belongs to
+func (s *Server) serverOrgID(serverID int) int {
+	var orgID int
+	if err := s.db.QueryRow("SELECT org_id FROM servers WHERE id = ?", serverID).Scan(&orgID); err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load organization of server %d: %v", serverID, err)
+	}
+	return orgID
+}
+
+// orgSetting returns a setting for an organization, falling back to the
+// instance setting when the organization does not override it
+func (s *Server) orgSetting(orgID int, key string) (string, error) {
+	return s.services.Orgs.Setting(orgID, key)
+}
+
+// checkOrgLimit returns service.ErrOrgLimit when an organization already
+// has as many users or servers as it is allowed
+func (s *Server) checkOrgLimit(orgID int, resource string) error {
+	return s.services.Orgs.CheckLimit(orgID, resource)
+}
+
+// loadOrganization returns an organization with its usage
+func (s *Server) loadOrganization(orgID int) (*organization, error) {
+	var org organization
+	var domain sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, name, slug, domain, max_users, max_servers, created_at,
+		       (SELECT COUNT(*) FROM users WHERE org_id = organizations.id),
+		       (SELECT COUNT(*) FROM servers WHERE org_id = organizations.id)
+		FROM organizations WHERE id = ?`, orgID,
+	).Scan(&org.ID, &org.Name, &org.Slug, &domain, &org.MaxUsers, &org.MaxServers, &org.CreatedAt, &org.Users, &org.Servers)
+	if err != nil {
+		return nil, err
+	}
+	org.Domain = domain.String
+	return &org, nil
+}
+
+// orgRequest is the body for creating or updating an organization
+type orgRequest struct {
+	Name       *string `json:"name"`
+	Slug       *string `json:"slug"`
+	Domain     *string `json:"domain"`
+	MaxUsers   *int    `json:"max_users"`
+	MaxServers *int    `json:"max_servers"`
+}
+
+// validate normalizes the slug and domain and checks every field present
+func (r *orgRequest) validate() error {
+	if r.Name != nil {
+		*r.Name = strings.TrimSpace(*r.Name)
+		if *r.Name == "" || len(*r.Name) > 100 {
+			return errors.New("name must be 1 to 100 characters")
+		}
+	}
+	if r.Slug != nil {
+		*r.Slug = strings.ToLower(strings.TrimSpace(*r.Slug))
+		if !orgSlugPattern.MatchString(*r.Slug) {
+			return errors.New("slug must be 2 to 32 lowercase letters, digits or hyphens")
+		}
+	}
+	if r.Domain != nil {
+		*r.Domain = strings.ToLower(strings.TrimSpace(*r.Domain))
+		if strings.ContainsAny(*r.Domain, "/:@ ") {
+			return errors.New("domain must be a bare hostname")
+		}
+	}
+	if (r.MaxUsers != nil && *r.MaxUsers < 0) || (r.MaxServers != nil && *r.MaxServers < 0) {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// nullableDomain stores an empty domain as NULL so several organizations
+// can go without one
+func nullableDomain(domain string) interface{} {
+	if domain == "" {
+		return nil
+	}
+	return domain
+}
+
+// handleGetOrganizations lists every organization; super admins only
+func (s *Server) handleGetOrganizations(c *gin.Context) {
+	rows, err := s.db.Query("SELECT id FROM organizations ORDER BY name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organizations"})
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	_ = rows.Close()
+
+	orgs := make([]*organization, 0, len(ids))
+	for _, id := range ids {
+		if org, err := s.loadOrganization(id); err == nil {
+			orgs = append(orgs, org)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": orgs})
+}
+
+// handleCreateOrganization adds an organization; super admins only
+func (s *Server) handleCreateOrganization(c *gin.Context) {
+	var req orgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil || req.Slug == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and slug are required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var domain string
+	var maxUsers, maxServers int
+	if req.Domain != nil {
+		domain = *req.Domain
+	}
+	if req.MaxUsers != nil {
+		maxUsers = *req.MaxUsers
+	}
+	if req.MaxServers != nil {
+		maxServers = *req.MaxServers
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO organizations (name, slug, domain, max_users, max_servers) VALUES (?, ?, ?, ?, ?)",
+		*req.Name, *req.Slug, nullableDomain(domain), maxUsers, maxServers,
+	)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Slug or domain is already in use"})
+		return
+	}
+	orgID, _ := result.LastInsertId()
+	s.logAdminAction(c.GetInt("user_id"), "create_organization", fmt.Sprintf("Created organization %s", *req.Slug))
+
+	org, err := s.loadOrganization(int(orgID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load organization"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": org})
+}
+
+// handleUpdateOrganization changes an organization's name, slug, domain or
+// limits; super admins only
+func (s *Server) handleUpdateOrganization(c *gin.Context) {
+	orgID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var req orgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := []string{}
+	args := []interface{}{}
+	if req.Name != nil {
+		updates = append(updates, "name = ?")
+		args = append(args, *req.Name)
+	}
+	if req.Slug != nil {
+		updates = append(updates, "slug = ?")
+		args = append(args, *req.Slug)
+	}
+	if req.Domain != nil {
+		updates = append(updates, "domain = ?")
+		args = append(args, nullableDomain(*req.Domain))
+	}
+	if req.MaxUsers != nil {
+		updates = append(updates, "max_users = ?")
+		args = append(args, *req.MaxUsers)
+	}
+	if req.MaxServers != nil {
+		updates = append(updates, "max_servers = ?")
+		args = append(args, *req.MaxServers)
+	}
+
+	if _, err := s.loadOrganization(orgID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if len(updates) > 0 {
+		args = append(args, orgID)
+		if _, err := s.db.Exec("UPDATE organizations SET "+strings.Join(updates, ", ")+" WHERE id = ?", args...); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Slug or domain is already in use"})
+			return
+		}
+		s.logAdminAction(c.GetInt("user_id"), "update_organization", fmt.Sprintf("Updated organization %d", orgID))
+	}
+
+	org, err := s.loadOrganization(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load organization"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": org})
+}
+
+// handleDeleteOrganization removes an organization that no longer has
+// users or servers; super admins only
+func (s *Server) handleDeleteOrganization(c *gin.Context) {
+	orgID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	org, err := s.loadOrganization(orgID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if org.Users > 0 || org.Servers > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Organization still has users or servers"})
+		return
+	}
+
+	if _, err := s.db.Exec("DELETE FROM organization_settings WHERE org_id = ?", orgID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM organizations WHERE id = ?", orgID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
+		return
+	}
+	s.logAdminAction(c.GetInt("user_id"), "delete_organization", fmt.Sprintf("Deleted organization %s", org.Slug))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleGetOwnOrganization returns the caller's organization
+func (s *Server) handleGetOwnOrganization(c *gin.Context) {
+	orgID := c.GetInt("org_id")
+	org, err := s.loadOrganization(orgID)
+	if orgID == 0 || err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not a member of an organization"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": org})
+}
+
+// settingsOrgID is the organization whose settings a request addresses: the
+// one in the path for super admins, otherwise the caller's own
+func (s *Server) settingsOrgID(c *gin.Context) (int, bool) {
+	orgID := c.GetInt("org_id")
+	if param := c.Param("id"); param != "" {
+		orgID, _ = strconv.Atoi(param)
+	}
+	if orgID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return 0, false
+	}
+	if _, err := s.loadOrganization(orgID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return 0, false
+	}
+	return orgID, true
+}
+
+// handleGetOrganizationSettings lists an organization's overrides
+func (s *Server) handleGetOrganizationSettings(c *gin.Context) {
+	orgID, ok := s.settingsOrgID(c)
+	if !ok {
+		return
+	}
+	rows, err := s.db.Query("SELECT key, value FROM organization_settings WHERE org_id = ?", orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization settings"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err == nil {
+			settings[key] = value
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": settings})
+}
+
+// handleUpdateOrganizationSettings sets an organization's overrides. An
+// empty value removes the override so the instance setting applies again.
+func (s *Server) handleUpdateOrganizationSettings(c *gin.Context) {
+	orgID, ok := s.settingsOrgID(c)
+	if !ok {
+		return
+	}
+	var req map[string]string
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for key, value := range req {
+		if !service.OrgSettingKeys[key] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s cannot be set per organization", key)})
+			return
+		}
+		switch {
+		case value == "":
+		case key == "auth_mode" && !service.AuthModes[value]:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auth mode"})
+			return
+		case (key == "guest_mode_enabled" || key == "registration_approval") && value != "true" && value != "false":
+			c.JSON(http.StatusBadRequest, gin.H{"error": key + " must be true or false"})
+			return
+		}
+	}
+
+	for key, value := range req {
+		var err error
+		if value == "" {
+			_, err = s.db.Exec("DELETE FROM organization_settings WHERE org_id = ? AND key = ?", orgID, key)
+		} else {
+			_, err = s.db.Exec(`
+				INSERT INTO organization_settings (org_id, key, value) VALUES (?, ?, ?)
+				ON CONFLICT (org_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`,
+				orgID, key, value)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save organization settings"})
+			return
+		}
+		s.logAdminAction(c.GetInt("user_id"), "update_organization_setting", fmt.Sprintf("Set %s for organization %d", key, orgID))
+	}
+
+	s.handleGetOrganizationSettings(c)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOrganizationIsolation(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	orgs := make(map[string]int64)
+	for _, name := range []string{"alpha", "beta"} {
+		result, err := db.Exec(
+			"INSERT INTO organizations (name, slug, domain, max_servers) VALUES (?, ?, ?, 1)",
+			name, fmt.Sprintf("%s-%d", name, suffix), fmt.Sprintf("%s-%d.example.com", name, suffix),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create organization: %v", err)
+		}
+		orgs[name], _ = result.LastInsertId()
+	}
+	users := make(map[string]int64)
+	for name, org := range map[string]string{"admin": "alpha", "member": "alpha", "other": "beta"} {
+		role := "user"
+		if name == "admin" {
+			role = "admin"
+		}
+		result, err := db.Exec(
+			"INSERT INTO users (username, email, password_hash, role, org_id) VALUES (?, '', 'x', ?, ?)",
+			fmt.Sprintf("org%s_%d", name, suffix), role, orgs[org],
+		)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	// Instance-wide capabilities granted to an organization admin do not apply
+	if err := s.setCapabilities(users["admin"], []string{capManageUsers, capViewMetrics}, 0); err != nil {
+		t.Fatalf("Failed to grant capabilities: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, host, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		protected := router.Group("/")
+		protected.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", user)
+		}, s.orgMiddleware())
+		protected.POST("/servers", s.handleCreateServer)
+		protected.GET("/servers", s.handleGetServers)
+		protected.POST("/servers/:id/members", s.handleAddServerMember)
+		protected.GET("/admin/users", s.requirePermission(capManageUsers), s.handleGetUsers)
+		protected.PUT("/admin/users/:id", s.requirePermission(capManageUsers), s.orgTargetMiddleware(), s.handleUpdateUser)
+		protected.GET("/admin/metrics", s.requirePermission(capViewMetrics), func(c *gin.Context) { c.Status(http.StatusOK) })
+		protected.PUT("/org/settings", s.requirePermission(capManageUsers), s.handleUpdateOrganizationSettings)
+		router.POST("/auth/register", s.handleRegister)
+		router.POST("/auth/login", s.handleLogin)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Host = host
+		router.ServeHTTP(w, r)
+		return w
+	}
+	alphaHost := fmt.Sprintf("alpha-%d.example.com:443", suffix)
+	betaHost := fmt.Sprintf("beta-%d.example.com", suffix)
+
+	// Tokens are refused on another organization's domain
+	if w := request("member", "GET", "/servers", betaHost, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 on another organization's domain, got %d", w.Code)
+	}
+	if w := request("member", "GET", "/servers", alphaHost, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 on the organization's own domain, got %d", w.Code)
+	}
+
+	// Servers are created in the organization up to its limit
+	w := request("admin", "POST", "/servers", "", `{"name":"Alpha HQ"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the server to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID int `json:"id"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	if orgID := s.serverOrgID(created.ID); orgID != int(orgs["alpha"]) {
+		t.Errorf("Expected the server to belong to organization %d, got %d", orgs["alpha"], orgID)
+	}
+	if w := request("admin", "POST", "/servers", "", `{"name":"Alpha Annex"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the server limit to apply, got %d", w.Code)
+	}
+
+	// Members come from the same organization only
+	membersPath := fmt.Sprintf("/servers/%d/members", created.ID)
+	if w := request("admin", "POST", membersPath, "", fmt.Sprintf(`{"user_id":%d}`, users["other"])); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a user of another organization to be hidden, got %d", w.Code)
+	}
+	if w := request("admin", "POST", membersPath, "", fmt.Sprintf(`{"user_id":%d}`, users["member"])); w.Code != http.StatusCreated {
+		t.Errorf("Expected a user of the same organization to be added, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Organization admins see and manage their own users only
+	w = request("admin", "GET", "/admin/users", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the organization admin to list users, got %d", w.Code)
+	}
+	var listed struct {
+		Data []struct {
+			ID    int `json:"id"`
+			OrgID int `json:"org_id"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Data) != 2 {
+		t.Errorf("Expected the two users of the organization, got %d", len(listed.Data))
+	}
+	for _, user := range listed.Data {
+		if user.OrgID != int(orgs["alpha"]) {
+			t.Errorf("Expected only users of organization %d, got user %d of %d", orgs["alpha"], user.ID, user.OrgID)
+		}
+	}
+	if w := request("admin", "PUT", fmt.Sprintf("/admin/users/%d", users["other"]), "", `{"email":"x@example.com"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected users of another organization to be hidden from admins, got %d", w.Code)
+	}
+	if w := request("admin", "GET", "/admin/metrics", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected instance-wide endpoints to be closed to organization admins, got %d", w.Code)
+	}
+
+	// Registration follows the organization's settings, not the instance's
+	if w := request("admin", "PUT", "/org/settings", "", `{"auth_mode":"admin_only"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the organization admin to change settings, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("admin", "PUT", "/org/settings", "", `{"jwt_secret":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected instance-only settings to be rejected, got %d", w.Code)
+	}
+	register := fmt.Sprintf(`{"username":"orgnew_%d","password":"Correct-Horse-42"}`, suffix)
+	if w := request("", "POST", "/auth/register", alphaHost, register); w.Code != http.StatusForbidden {
+		t.Errorf("Expected registration to be closed for the organization, got %d", w.Code)
+	}
+	if w := request("", "POST", "/auth/register", betaHost, register); w.Code != http.StatusCreated {
+		t.Fatalf("Expected registration on the other organization, got %d: %s", w.Code, w.Body.String())
+	}
+	var registeredOrg int
+	if err := db.QueryRow("SELECT org_id FROM users WHERE username = ?", fmt.Sprintf("orgnew_%d", suffix)).Scan(&registeredOrg); err != nil || registeredOrg != int(orgs["beta"]) {
+		t.Errorf("Expected the new user in organization %d, got %d (%v)", orgs["beta"], registeredOrg, err)
+	}
+
+	// Accounts do not exist on another organization's domain
+	login := fmt.Sprintf(`{"username":"orgnew_%d","password":"Correct-Horse-42"}`, suffix)
+	if w := request("", "POST", "/auth/login", alphaHost, login); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected login on another organization's domain to fail, got %d", w.Code)
+	}
+}
+
+func TestOrgRequestValidate(t *testing.T) {
+	text := func(value string) *string { return &value }
+	number := func(value int) *int { return &value }
+	tests := []struct {
+		req   orgRequest
+		valid bool
+	}{
+		{orgRequest{Name: text("Acme"), Slug: text(" Acme-Co ")}, true},
+		{orgRequest{Slug: text("a")}, false},
+		{orgRequest{Slug: text("acme_co")}, false},
+		{orgRequest{Name: text("  ")}, false},
+		{orgRequest{Domain: text("chat.acme.example")}, true},
+		{orgRequest{Domain: text("https://chat.acme.example")}, false},
+		{orgRequest{MaxUsers: number(-1)}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.req.validate(); (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, expected valid=%v", tt.req, err, tt.valid)
+		}
+	}
+}
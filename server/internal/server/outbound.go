@@ -0,0 +1,23 @@
+package server
+
+import (
+	"os"
+	"time"
+
+	"fethur/internal/fetch"
+)
+
+// pluginHTTPTimeout bounds each request a plugin makes
+const pluginHTTPTimeout = 15 * time.Second
+
+// OutboundConfig returns the policy for requests the server sends to URLs
+// users and admins supply. Internal addresses are refused unless listed in
+// FETHUR_OUTBOUND_ALLOWLIST (host names, addresses and CIDR ranges, comma
+// separated) or FETHUR_OUTBOUND_ALLOW_PRIVATE is true, for homelab servers
+// whose integrations all live on the local network.
+func OutboundConfig() fetch.Config {
+	config := fetch.Default()
+	config.Allowlist = fetch.ParseAllowlist(os.Getenv("FETHUR_OUTBOUND_ALLOWLIST"))
+	config.AllowPrivate = os.Getenv("FETHUR_OUTBOUND_ALLOW_PRIVATE") == "true"
+	return config
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"fethur/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passwordPolicySettings maps a password policy to its settings keys
+func passwordPolicySettings(policy auth.PasswordPolicy) map[string]string {
+	return map[string]string{
+		"password_min_length":        strconv.Itoa(policy.MinLength),
+		"password_require_number":    strconv.FormatBool(policy.RequireNumber),
+		"password_require_special":   strconv.FormatBool(policy.RequireSpecial),
+		"password_require_upper":     strconv.FormatBool(policy.RequireUpper),
+		"password_require_lower":     strconv.FormatBool(policy.RequireLower),
+		"password_disallow_username": strconv.FormatBool(policy.DisallowUsername),
+		"password_block_common":      strconv.FormatBool(policy.BlockCommon),
+	}
+}
+
+// getBoolSetting reads a boolean setting, falling back to a default when unset or invalid
+func (s *Server) getBoolSetting(key string, fallback bool) bool {
+	value, err := s.db.GetSetting(key)
+	if err != nil {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+// applyPasswordPolicy loads the password policy and hashing from settings
+// into the auth service
+func (s *Server) applyPasswordPolicy() {
+	defaults := auth.DefaultPasswordPolicy()
+	s.auth.SetPasswordPolicy(auth.PasswordPolicy{
+		MinLength:        s.getIntSetting("password_min_length", defaults.MinLength),
+		RequireNumber:    s.getBoolSetting("password_require_number", defaults.RequireNumber),
+		RequireSpecial:   s.getBoolSetting("password_require_special", defaults.RequireSpecial),
+		RequireUpper:     s.getBoolSetting("password_require_upper", defaults.RequireUpper),
+		RequireLower:     s.getBoolSetting("password_require_lower", defaults.RequireLower),
+		DisallowUsername: s.getBoolSetting("password_disallow_username", defaults.DisallowUsername),
+		BlockCommon:      s.getBoolSetting("password_block_common", defaults.BlockCommon),
+	})
+
+	// Existing hashes move to the configured algorithm as users sign in
+	params := auth.DefaultArgon2Params()
+	params.Memory = uint32(s.getIntSetting("password_argon2_memory_kib", int(params.Memory)))         // #nosec G115 -- admin setting
+	params.Iterations = uint32(s.getIntSetting("password_argon2_iterations", int(params.Iterations))) // #nosec G115 -- admin setting
+	algorithm, err := s.db.GetSetting("password_hash_algorithm")
+	if err != nil || algorithm == "" {
+		algorithm = auth.HashBcrypt
+	}
+	if err := s.auth.SetPasswordHashing(algorithm, params); err != nil {
+		log.Printf("Keeping bcrypt password hashing: %v", err)
+		_ = s.auth.SetPasswordHashing(auth.HashBcrypt, params)
+	}
+}
+
+// handleGetPasswordPolicy exposes the active policy so clients can show the rules up front
+func (s *Server) handleGetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.auth.PasswordPolicy(),
+	})
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/service"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Terms of service and rules. Super admins publish versions of each
+// policy in markdown; users acknowledge the current versions before they
+// can post, and again whenever a new version is published.
+
+// policyKind reads the kind from the route, answering 404 for unknown ones
+func policyKind(c *gin.Context) (string, bool) {
+	kind := c.Param("kind")
+	if !service.PolicyKinds[kind] {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown policy; use terms or rules"})
+		return "", false
+	}
+	return kind, true
+}
+
+// handleGetPolicies returns the policies in force. It needs no sign-in,
+// so the register page can link to them.
+func (s *Server) handleGetPolicies(c *gin.Context) {
+	docs, err := s.services.Policies.CurrentAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policies"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": docs})
+}
+
+// handleGetUserPolicies returns the policies in force and whether the
+// user has acknowledged their current versions
+func (s *Server) handleGetUserPolicies(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	docs, err := s.services.Policies.CurrentAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policies"})
+		return
+	}
+
+	policies := make([]gin.H, 0, len(docs))
+	outstanding := 0
+	for _, doc := range docs {
+		acknowledged, err := s.services.Policies.Acknowledged(userID, doc.Kind)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policies"})
+			return
+		}
+		policy := gin.H{"policy": doc, "acknowledged": false}
+		if at, ok := acknowledged[doc.Version]; ok {
+			policy["acknowledged"], policy["acknowledged_at"] = true, at
+		} else {
+			outstanding++
+			// Users who accepted an earlier version are asked again
+			policy["previously_acknowledged"] = len(acknowledged) > 0
+		}
+		policies = append(policies, policy)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"policies": policies, "outstanding": outstanding},
+	})
+}
+
+// handleAcknowledgePolicy records that the user accepted a version of a
+// policy. The version must be the current one, so an acknowledgment is
+// always for the text the user was shown.
+func (s *Server) handleAcknowledgePolicy(c *gin.Context) {
+	kind, ok := policyKind(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Version int `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
+		return
+	}
+
+	err := s.services.Policies.Acknowledge(c.GetInt("user_id"), kind, req.Version)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No " + kind + " are in force"})
+		return
+	case errors.Is(err, service.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "A newer version has been published; read it and acknowledge that", "code": "policy_changed"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record acknowledgment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"kind": kind, "version": req.Version, "acknowledged_at": time.Now().UTC()},
+	})
+}
+
+// handleGetPolicyHistory lists every version of a policy, newest first,
+// with how many users acknowledged each
+func (s *Server) handleGetPolicyHistory(c *gin.Context) {
+	kind, ok := policyKind(c)
+	if !ok {
+		return
+	}
+	docs, err := s.services.Policies.History(kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy history"})
+		return
+	}
+
+	counts := make(map[int]int)
+	rows, err := s.db.Query("SELECT version, COUNT(*) FROM policy_acknowledgments WHERE kind = ? GROUP BY version", kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy history"})
+		return
+	}
+	for rows.Next() {
+		var version, count int
+		if err := rows.Scan(&version, &count); err == nil {
+			counts[version] = count
+		}
+	}
+	_ = rows.Close()
+
+	current := 0
+	if doc, err := s.services.Policies.Current(kind); err == nil {
+		current = doc.Version
+	}
+	versions := make([]gin.H, 0, len(docs))
+	for _, doc := range docs {
+		versions = append(versions, gin.H{
+			"policy":          doc,
+			"current":         doc.Version == current,
+			"acknowledgments": counts[doc.Version],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": versions})
+}
+
+// handleGetPolicyAcknowledgments lists who acknowledged a version of a
+// policy and when, newest first. The version defaults to the current one.
+func (s *Server) handleGetPolicyAcknowledgments(c *gin.Context) {
+	kind, ok := policyKind(c)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(c.Query("version"))
+	if c.Query("version") == "" {
+		doc, currentErr := s.services.Policies.Current(kind)
+		if currentErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No " + kind + " are in force"})
+			return
+		}
+		version, err = doc.Version, nil
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT a.user_id, u.username, a.acknowledged_at
+		FROM policy_acknowledgments a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.kind = ? AND a.version = ?
+		ORDER BY a.acknowledged_at DESC, a.user_id
+		LIMIT ? OFFSET ?`, kind, version, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get acknowledgments"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	acknowledgments := make([]gin.H, 0)
+	for rows.Next() {
+		var userID int
+		var username string
+		var at time.Time
+		if err := rows.Scan(&userID, &username, &at); err != nil {
+			continue
+		}
+		acknowledgments = append(acknowledgments, gin.H{"user_id": userID, "username": username, "acknowledged_at": at})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"kind": kind, "version": version, "acknowledgments": acknowledgments},
+	})
+}
+
+// handlePublishPolicy makes a new version of a policy current and asks
+// every user to acknowledge it
+func (s *Server) handlePublishPolicy(c *gin.Context) {
+	kind, ok := policyKind(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt("user_id")
+	doc, err := s.services.Policies.Publish(kind, req.Title, req.Content, adminID)
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish policy"})
+		return
+	}
+	s.logAdminAction(adminID, "publish_policy", fmt.Sprintf("Published version %d of the %s: %s", doc.Version, kind, doc.Title))
+	s.notifyPolicyChanged(doc)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": doc})
+}
+
+// handleRetirePolicy stops asking users to acknowledge a policy, until a
+// new version is published
+func (s *Server) handleRetirePolicy(c *gin.Context) {
+	kind, ok := policyKind(c)
+	if !ok {
+		return
+	}
+	if err := s.services.Policies.Retire(kind); errors.Is(err, service.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No " + kind + " are in force"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retire policy"})
+		return
+	}
+	s.logAdminAction(c.GetInt("user_id"), "retire_policy", "Retired the "+kind)
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Policy retired"})
+}
+
+// notifyPolicyChanged tells connected users that a new version needs
+// their acknowledgment before they post again
+func (s *Server) notifyPolicyChanged(doc *service.PolicyDocument) {
+	message := &websocket.Message{
+		Type:      string(events.TypeNotification),
+		Content:   fmt.Sprintf("The %s have changed; please read and accept them", doc.Kind),
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"kind":    "policy_updated",
+			"policy":  doc.Kind,
+			"version": doc.Version,
+			"title":   doc.Title,
+		},
+	}
+	s.clientsMux.RLock()
+	clients := make([]*websocket.Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMux.RUnlock()
+	for _, client := range clients {
+		client.Send(message)
+	}
+	log.Printf("Published version %d of the %s to %d connected users", doc.Version, doc.Kind, len(clients))
+}
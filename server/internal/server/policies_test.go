@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPolicyAcknowledgmentFlow(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+	// Policies are instance-wide; retire them so other tests can post
+	defer func() {
+		for kind := range service.PolicyKinds {
+			_ = s.services.Policies.Retire(kind)
+		}
+	}()
+
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", fmt.Sprintf("policy_%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	userID := int(id)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/policies", s.handleGetPolicies)
+	signedIn := router.Group("/", func(c *gin.Context) { c.Set("user_id", userID) })
+	signedIn.GET("/user/policies", s.handleGetUserPolicies)
+	signedIn.POST("/user/policies/:kind/acknowledge", s.handleAcknowledgePolicy)
+	signedIn.GET("/admin/policies/:kind", s.handleGetPolicyHistory)
+	signedIn.POST("/admin/policies/:kind", s.handlePublishPolicy)
+	signedIn.GET("/admin/policies/:kind/acknowledgments", s.handleGetPolicyAcknowledgments)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+	outstanding := func() int {
+		w := request("GET", "/user/policies", "")
+		var status struct {
+			Data struct {
+				Outstanding int `json:"outstanding"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Failed to get the user's policies, got %d: %s", w.Code, w.Body.String())
+		}
+		return status.Data.Outstanding
+	}
+
+	if w := request("POST", "/admin/policies/privacy", `{"title":"Privacy","content":"Text"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown kind to be refused, got %d", w.Code)
+	}
+	if w := request("POST", "/admin/policies/terms", `{"title":"Terms","content":""}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected empty terms to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w := request("POST", "/admin/policies/terms", `{"title":"Terms of Service","content":"# Terms\n\nBe excellent."}`)
+	var published struct {
+		Data service.PolicyDocument `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &published); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Failed to publish, got %d: %s", w.Code, w.Body.String())
+	}
+	version := published.Data.Version
+
+	if w := request("GET", "/policies", ""); !strings.Contains(w.Body.String(), "Be excellent.") {
+		t.Errorf("Expected the terms listed publicly, got %s", w.Body.String())
+	}
+	if n := outstanding(); n != 1 {
+		t.Fatalf("Expected the terms outstanding, got %d", n)
+	}
+	if w := request("POST", "/user/policies/terms/acknowledge", fmt.Sprintf(`{"version":%d}`, version+1)); w.Code != http.StatusConflict {
+		t.Errorf("Expected a version other than the current one to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", "/user/policies/terms/acknowledge", fmt.Sprintf(`{"version":%d}`, version)); w.Code != http.StatusOK {
+		t.Fatalf("Failed to acknowledge, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := outstanding(); n != 0 {
+		t.Errorf("Expected nothing outstanding after acknowledging, got %d", n)
+	}
+	if w := request("GET", "/admin/policies/terms/acknowledgments", ""); !strings.Contains(w.Body.String(), fmt.Sprintf(`"user_id":%d`, userID)) {
+		t.Errorf("Expected the acknowledgment listed, got %s", w.Body.String())
+	}
+
+	// Publishing a new version asks again
+	if w := request("POST", "/admin/policies/terms", `{"title":"Terms of Service","content":"# Terms\n\nBe excellent to each other."}`); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to publish, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := outstanding(); n != 1 {
+		t.Errorf("Expected the new version outstanding, got %d", n)
+	}
+	w = request("GET", "/admin/policies/terms", "")
+	var history struct {
+		Data []struct {
+			Policy          service.PolicyDocument `json:"policy"`
+			Current         bool                   `json:"current"`
+			Acknowledgments int                    `json:"acknowledgments"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil || len(history.Data) < 2 {
+		t.Fatalf("Failed to get the history, got %d: %s", w.Code, w.Body.String())
+	}
+	if !history.Data[0].Current || history.Data[0].Acknowledgments != 0 || history.Data[1].Acknowledgments < 1 {
+		t.Errorf("Expected the new version current and the old one acknowledged, got %+v", history.Data[:2])
+	}
+}
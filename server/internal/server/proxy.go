@@ -0,0 +1,48 @@
+package server
+
+import (
+	"os"
+	"strings"
+)
+
+// TrustedProxies returns the proxies whose X-Forwarded-For and X-Real-IP
+// headers are believed, from the comma-separated IPs and CIDRs in
+// FETHUR_TRUSTED_PROXIES. Without it no proxy is trusted and the client IP
+// is the connection's peer.
+func TrustedProxies() []string {
+	proxies := make([]string, 0)
+	for _, proxy := range strings.Split(os.Getenv("FETHUR_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// BasePath returns the path prefix the server is served under, from
+// FETHUR_BASE_PATH, e.g. "/fethur" when a reverse proxy forwards
+// https://example.com/fethur/ to it without stripping the prefix. It is
+// empty when the server is served from the root.
+func BasePath() string {
+	path := strings.Trim(strings.TrimSpace(os.Getenv("FETHUR_BASE_PATH")), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// PublicURL returns the server's public URL including any base path, from
+// FETHUR_PUBLIC_URL, e.g. https://example.com/fethur
+func PublicURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("FETHUR_PUBLIC_URL")), "/")
+}
+
+// externalURL returns the link clients should use for a server path: an
+// absolute URL when the public URL is configured, or the path under the
+// base path otherwise
+func (s *Server) externalURL(path string) string {
+	if s.publicURL != "" {
+		return s.publicURL + path
+	}
+	return s.basePath + path
+}
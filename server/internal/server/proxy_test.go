@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReverseProxyConfiguration(t *testing.T) {
+	t.Setenv("FETHUR_BASE_PATH", "/fethur/")
+	t.Setenv("FETHUR_PUBLIC_URL", "https://example.com/fethur/")
+	t.Setenv("FETHUR_TRUSTED_PROXIES", "10.0.0.0/8, 127.0.0.1")
+
+	if path := BasePath(); path != "/fethur" {
+		t.Errorf("Expected /fethur, got %q", path)
+	}
+	s := &Server{basePath: BasePath()}
+	if link := s.externalURL("/api/attachments/1"); link != "/fethur/api/attachments/1" {
+		t.Errorf("Expected a link under the base path, got %q", link)
+	}
+	s.publicURL = PublicURL()
+	if link := s.externalURL("/api/attachments/1"); link != "https://example.com/fethur/api/attachments/1" {
+		t.Errorf("Expected an absolute link, got %q", link)
+	}
+
+	// Forwarded addresses are only believed from trusted proxies
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(TrustedProxies()); err != nil {
+		t.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	router.GET("/ip", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+	clientIP := func(remoteAddr string) string {
+		request := httptest.NewRequest("GET", "/ip", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w.Body.String()
+	}
+	if ip := clientIP("10.1.2.3:4000"); ip != "203.0.113.7" {
+		t.Errorf("Expected the forwarded address behind a trusted proxy, got %s", ip)
+	}
+	if ip := clientIP("198.51.100.9:4000"); ip != "198.51.100.9" {
+		t.Errorf("Expected the peer address from an untrusted proxy, got %s", ip)
+	}
+}
+
+func TestWebSocketAuthUnderBasePath(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+	t.Setenv("FETHUR_BASE_PATH", "/fethur")
+	s.basePath = BasePath()
+
+	previous, _ := db.GetSetting("email_verification")
+	defer func() {
+		_ = db.SetSetting("email_verification", previous, settingDescriptions["email_verification"])
+	}()
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+
+	username := fmt.Sprintf("proxied_%d", time.Now().UnixNano())
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", username)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	token, err := s.auth.GenerateToken(int(id), username, "user")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// Sockets cannot send headers from the browser, so they pass the token
+	// in the query even when served under the base path
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	root := router.Group(s.basePath)
+	for _, path := range []string{"/ws", "/ws/voice", "/voice"} {
+		root.GET(path, s.authMiddleware(), func(c *gin.Context) {
+			c.String(http.StatusOK, "%d", c.GetInt("user_id"))
+		})
+	}
+	for _, path := range []string{"/fethur/ws", "/fethur/ws/voice", "/fethur/voice"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path+"?token="+token, nil))
+		if w.Code != http.StatusOK || w.Body.String() != fmt.Sprint(id) {
+			t.Errorf("Expected %s to accept the token, got %d: %s", path, w.Code, w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path+"?token=forged", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s to refuse a forged token, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Public channels. Server owners and admins can publish a text channel,
+// which makes a read-only view of it available at /public/channels/:id
+// without signing in: HTML for browsers, JSON with format=json or an Accept
+// header asking for it. The view shows channel and server names, message
+// content and times and the names authors post under, nothing else about
+// them.
+
+const (
+	publicPageSize       = 50
+	publicRequestsPerMin = 60
+)
+
+type publicAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url,omitempty"` // only with signed URLs enabled
+}
+
+type publicMessage struct {
+	ID          snowflake.ID       `json:"id"`
+	Author      string             `json:"author"`
+	Content     string             `json:"content"`
+	CreatedAt   time.Time          `json:"created_at"`
+	EditedAt    *time.Time         `json:"edited_at,omitempty"`
+	EditCount   int                `json:"edit_count"`
+	ReplyToID   *snowflake.ID      `json:"reply_to_id,omitempty"`
+	Attachments []publicAttachment `json:"attachments"`
+}
+
+// publicChannelPage is rendered for browsers
+var publicChannelPage = template.Must(template.New("public").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>#{{.Channel}} · {{.Server}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1rem; }
+article { padding: 0.75rem 0; border-bottom: 1px solid #eaeef2; }
+.meta { color: #656d76; font-size: 0.85rem; }
+.content { white-space: pre-wrap; overflow-wrap: anywhere; margin-top: 0.25rem; }
+</style>
+</head>
+<body>
+<header>
+<h1>#{{.Channel}}</h1>
+<p class="meta">{{.Server}} · read-only view</p>
+</header>
+{{range .Messages}}<article id="m{{.ID}}">
+<div class="meta"><strong>{{.Author}}</strong> · <time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2 Jan 2006 15:04"}} UTC</time>{{if .EditedAt}} · edited{{end}}</div>
+<div class="content">{{.Content}}</div>
+{{range .Attachments}}<div class="meta">📎 {{if .URL}}<a href="{{.URL}}">{{.Filename}}</a>{{else}}{{.Filename}}{{end}}</div>
+{{end}}</article>
+{{else}}<p>No messages yet.</p>
+{{end}}{{if .Older}}<p><a href="?before={{.Older}}">Older messages</a></p>{{end}}
+</body>
+</html>
+`))
+
+// handlePublicChannel serves the read-only view of a public channel, newest
+// messages first. before pages back from a message ID.
+func (s *Server) handlePublicChannel(c *gin.Context) {
+	channelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	before, err := strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil || before < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "before must be a message ID"})
+		return
+	}
+
+	reader := s.reader(c)
+	var channelName, serverName string
+	err = reader.QueryRow(`
+		SELECT c.name, sv.name FROM channels c JOIN servers sv ON sv.id = c.server_id
+		WHERE c.id = ? AND c.public = 1 AND c.private = 0 AND c.nsfw = 0 AND c.channel_type = 'text'`, channelID,
+	).Scan(&channelName, &serverName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+
+	messages, err := s.publicMessages(reader, channelID, before)
+	if err != nil {
+		log.Printf("Failed to load public channel %d: %v", channelID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	var older int64
+	if len(messages) > publicPageSize {
+		messages = messages[:publicPageSize]
+		older = int64(messages[publicPageSize-1].ID)
+	}
+
+	c.Header("Cache-Control", "public, max-age=60")
+	if c.Query("format") == "json" || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		var next interface{}
+		if older != 0 {
+			next = snowflake.ID(older)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"channel":  gin.H{"id": channelID, "name": channelName, "server": serverName},
+				"messages": messages,
+				"before":   next,
+			},
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := publicChannelPage.Execute(c.Writer, gin.H{
+		"Channel":  channelName,
+		"Server":   serverName,
+		"Messages": messages,
+		"Older":    older,
+	}); err != nil {
+		log.Printf("Failed to render public channel %d: %v", channelID, err)
+	}
+}
+
+// publicMessages loads one page of a channel plus one message to tell
+// whether there are older ones
+func (s *Server) publicMessages(reader *sql.DB, channelID int, before int64) ([]publicMessage, error) {
+	rows, err := reader.Query(`
+		SELECT m.id, COALESCE(m.bot_name, u.username), m.content, m.created_at, m.edited_at, m.edit_count, m.reply_to_id
+		FROM messages m JOIN users u ON u.id = m.user_id
+		WHERE m.channel_id = ? AND m.quarantined = 0 AND (? = 0 OR m.id < ?)
+		ORDER BY m.id DESC LIMIT ?`,
+		channelID, before, before, publicPageSize+1,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]publicMessage, 0)
+	for rows.Next() {
+		var m publicMessage
+		var editedAt sql.NullTime
+		var replyTo sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Author, &m.Content, &m.CreatedAt, &editedAt, &m.EditCount, &replyTo); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		if editedAt.Valid {
+			m.EditedAt = &editedAt.Time
+		}
+		if replyTo.Valid {
+			replyToID := snowflake.ID(replyTo.Int64)
+			m.ReplyToID = &replyToID
+		}
+		m.Attachments = make([]publicAttachment, 0)
+		messages = append(messages, m)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	signed := s.signedURLsEnabled()
+	now := time.Now()
+	for i := range messages {
+		rows, err := reader.Query(
+			"SELECT id FROM attachments WHERE message_id = ? AND status = 'ready' ORDER BY id", int64(messages[i].ID),
+		)
+		if err != nil {
+			return nil, err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			a, err := s.getAttachment(id)
+			if err != nil {
+				continue
+			}
+			attachment := publicAttachment{Filename: a.Filename, ContentType: a.ContentType, Size: a.Size}
+			if signed {
+				attachment.URL, _ = s.signedAttachmentURL(a, now)
+			}
+			messages[i].Attachments = append(messages[i].Attachments, attachment)
+		}
+	}
+	return messages, nil
+}
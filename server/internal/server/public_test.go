@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublicChannel(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	owner := fmt.Sprintf("pubowner_%d", suffix)
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, ?, 'x')", owner, fmt.Sprintf("%d@example.com", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	ownerID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Public %d", suffix), ownerID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", ownerID, serverID); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	channels := make(map[string]int64)
+	for name, kind := range map[string]string{"news": "text", "lounge": "voice"} {
+		result, err := db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, ?, ?)", serverID, name, kind)
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[name], _ = result.LastInsertId()
+	}
+	for i := 0; i < publicPageSize+5; i++ {
+		if _, err := db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, ?)", channels["news"], ownerID, fmt.Sprintf("Update %d", i)); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO messages (channel_id, user_id, content, bot_name) VALUES (?, ?, '<script>alert(1)</script>', 'Releases')", channels["news"], ownerID); err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/channels/:channelId", func(c *gin.Context) {
+		c.Set("user_id", int(ownerID))
+	}, s.handleUpdateChannel)
+	router.GET("/public/channels/:id", rateLimit(newRateLimiter(5, time.Minute)), s.handlePublicChannel)
+	request := func(method, path, accept, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Accept", accept)
+		router.ServeHTTP(w, r)
+		return w
+	}
+	newsPath := fmt.Sprintf("/public/channels/%d", channels["news"])
+
+	if w := request("GET", newsPath, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected channels to be private until published, got %d", w.Code)
+	}
+	if w := request("PATCH", fmt.Sprintf("/channels/%d", channels["lounge"]), "", `{"public":true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected voice channels not to be publishable, got %d", w.Code)
+	}
+	if w := request("PATCH", fmt.Sprintf("/channels/%d", channels["news"]), "", `{"public":true,"version":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the channel to be published, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := request("GET", newsPath, "application/json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the public view, got %d: %s", w.Code, w.Body.String())
+	}
+	var page struct {
+		Data struct {
+			Messages []struct {
+				ID      snowflake.ID `json:"id"`
+				Author  string       `json:"author"`
+				Content string       `json:"content"`
+			} `json:"messages"`
+			Before snowflake.ID `json:"before"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Data.Messages) != publicPageSize || page.Data.Before == 0 {
+		t.Fatalf("Expected a full page with older messages, got %d", len(page.Data.Messages))
+	}
+	if newest := page.Data.Messages[0]; newest.Author != "Releases" {
+		t.Errorf("Expected messages newest first under the bot's name, got %+v", newest)
+	}
+	if strings.Contains(w.Body.String(), "example.com") || strings.Contains(w.Body.String(), "user_id") {
+		t.Errorf("Expected no personal data in the public view: %s", w.Body.String())
+	}
+
+	w = request("GET", fmt.Sprintf("%s?format=json&before=%d", newsPath, page.Data.Before), "", "")
+	page.Data.Before = 0
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Data.Messages) != 6 || page.Data.Before != 0 {
+		t.Errorf("Expected the last 6 messages on the second page, got %d", len(page.Data.Messages))
+	}
+
+	// Browsers get escaped HTML
+	w = request("GET", newsPath, "text/html", "")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), "&lt;script&gt;") || !strings.Contains(w.Body.String(), "Older messages") {
+		t.Errorf("Expected an escaped HTML page with paging, got %s", w.Body.String())
+	}
+
+	// The limiter allows 5 requests
+	if w := request("GET", newsPath, "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the fifth request to be allowed, got %d", w.Code)
+	}
+	if w := request("GET", newsPath, "", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the sixth request to be limited, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Minute)
+	now := time.Now()
+	if !limiter.allow("a", now) || !limiter.allow("a", now) || limiter.allow("a", now) {
+		t.Error("Expected 2 requests per window")
+	}
+	if !limiter.allow("b", now) {
+		t.Error("Expected clients to be limited separately")
+	}
+	if !limiter.allow("a", now.Add(time.Minute)) {
+		t.Error("Expected the limit to reset with the window")
+	}
+}
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fethur/internal/push"
+	"fethur/internal/resilience"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPushDevicesPerUser bounds how many push tokens one account may hold
+const maxPushDevicesPerUser = 10
+
+// pushEnabled reports whether any push platform is configured
+func (s *Server) pushEnabled() bool {
+	return s.push != nil && len(s.push.Platforms()) > 0
+}
+
+// sendPush delivers a notification to every device the user registered
+// and to their ntfy or Gotify relay, following their notification
+// settings. Delivery runs as a background job; tokens the push service
+// reports as invalid are deleted, and a rejected relay is switched off.
+func (s *Server) sendPush(userID int, notification push.Notification) {
+	if !s.pushEnabled() {
+		return
+	}
+
+	var mobile bool
+	var relay, relayTarget string
+	if err := s.db.QueryRow(
+		"SELECT notify_mobile, notify_relay, notify_relay_target FROM users WHERE id = ?", userID,
+	).Scan(&mobile, &relay, &relayTarget); err != nil {
+		log.Printf("Failed to load notification settings for user %d: %v", userID, err)
+		return
+	}
+
+	// device id 0 marks the user's relay
+	type device struct {
+		id       int64
+		platform string
+		token    string
+	}
+	devices := make([]device, 0)
+	if mobile {
+		rows, err := s.db.Query("SELECT id, platform, token FROM push_devices WHERE user_id = ?", userID)
+		if err != nil {
+			log.Printf("Failed to load push devices for user %d: %v", userID, err)
+			return
+		}
+		for rows.Next() {
+			var d device
+			if err := rows.Scan(&d.id, &d.platform, &d.token); err == nil && s.push.Supports(d.platform) {
+				devices = append(devices, d)
+			}
+		}
+		_ = rows.Close()
+	}
+	if relay != "" && relayTarget != "" && s.push.Supports(relay) {
+		devices = append(devices, device{platform: relay, token: relayTarget})
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	err := s.jobs.Enqueue(fmt.Sprintf("push-user-%d", userID), func(ctx context.Context) error {
+		var failed error
+		for _, d := range devices {
+			err := s.integrations.Guard(pushIntegration(d.platform), pushPolicy).Do(ctx, func(ctx context.Context) error {
+				err := s.push.Send(ctx, d.platform, d.token, notification)
+				if errors.Is(err, push.ErrInvalidToken) {
+					return resilience.Permanent(err)
+				}
+				return err
+			})
+			switch {
+			case err == nil:
+				if d.id != 0 {
+					_, _ = s.db.Exec("UPDATE push_devices SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", d.id)
+				}
+			case errors.Is(err, push.ErrInvalidToken) && d.id == 0:
+				log.Printf("Disabling %s notifications for user %d: the server rejected the configured target", d.platform, userID)
+				_, _ = s.db.Exec("UPDATE users SET notify_relay = '', notify_relay_target = '' WHERE id = ? AND notify_relay_target = ?", userID, d.token)
+			case errors.Is(err, push.ErrInvalidToken):
+				log.Printf("Removing invalid %s push token %d for user %d", d.platform, d.id, userID)
+				_, _ = s.db.Exec("DELETE FROM push_devices WHERE id = ?", d.id)
+			default:
+				failed = err
+			}
+		}
+		return failed
+	})
+	if err != nil {
+		log.Printf("Failed to queue push notification for user %d: %v", userID, err)
+	}
+}
+
+// sendMentionPush notifies an offline user that they were mentioned. The
+// collapse key groups mentions per channel and the badge is the number of
+// events waiting for the user's next connect.
+func (s *Server) sendMentionPush(userID, channelID int, messageID int64, senderID int, content string) {
+	if !s.pushEnabled() {
+		return
+	}
+
+	var sender, channel string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", senderID).Scan(&sender); err != nil {
+		return
+	}
+	if err := s.db.QueryRow("SELECT name FROM channels WHERE id = ?", channelID).Scan(&channel); err != nil {
+		return
+	}
+	var badge int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM offline_events WHERE user_id = ?", userID).Scan(&badge); err != nil {
+		badge = -1
+	}
+
+	s.sendPush(userID, push.Notification{
+		Title:       fmt.Sprintf("%s in #%s", sender, channel),
+		Body:        excerpt(content, 140),
+		CollapseKey: "channel-" + strconv.Itoa(channelID),
+		Badge:       badge,
+		Data: map[string]string{
+			"type":       offlineEventMention,
+			"channel_id": strconv.Itoa(channelID),
+			"message_id": strconv.FormatInt(messageID, 10),
+		},
+	})
+}
+
+// handleGetPushDevices lists the current user's push registrations
+func (s *Server) handleGetPushDevices(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	rows, err := s.db.Query(
+		"SELECT id, platform, created_at, last_used_at FROM push_devices WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load devices"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	devices := make([]gin.H, 0)
+	for rows.Next() {
+		var id int64
+		var platform string
+		var createdAt string
+		var lastUsedAt *string
+		if err := rows.Scan(&id, &platform, &createdAt, &lastUsedAt); err != nil {
+			continue
+		}
+		devices = append(devices, gin.H{
+			"id":           id,
+			"platform":     platform,
+			"created_at":   createdAt,
+			"last_used_at": lastUsedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    devices,
+	})
+}
+
+// handleRegisterPushDevice stores a device token for the current user. A
+// token already registered to another account moves to this one, since
+// the device changed hands or signed in again.
+func (s *Server) handleRegisterPushDevice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Platform string `json:"platform" binding:"required"`
+		Token    string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Platform = strings.ToLower(req.Platform)
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Platform != push.PlatformFCM && req.Platform != push.PlatformAPNs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "platform must be fcm or apns"})
+		return
+	}
+	if s.push == nil || !s.push.Supports(req.Platform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Push notifications are not configured for " + req.Platform})
+		return
+	}
+	if req.Token == "" || len(req.Token) > 4096 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device token"})
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM push_devices WHERE user_id = ? AND token != ?", userID, req.Token,
+	).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+	if count >= maxPushDevicesPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("At most %d devices can be registered", maxPushDevicesPerUser)})
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO push_devices (user_id, platform, token) VALUES (?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET user_id = excluded.user_id, platform = excluded.platform, created_at = CURRENT_TIMESTAMP`,
+		userID, req.Platform, req.Token,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	var id int64
+	if err := s.db.QueryRow("SELECT id FROM push_devices WHERE token = ?", req.Token).Scan(&id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":       id,
+			"platform": req.Platform,
+		},
+	})
+}
+
+// handleDeletePushDevice unregisters one of the current user's devices
+func (s *Server) handleDeletePushDevice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	deviceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM push_devices WHERE id = ? AND user_id = ?", deviceID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove device"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Device removed successfully",
+	})
+}
+
+// ntfyTopicPattern matches the topic names ntfy accepts
+var ntfyTopicPattern = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// handleGetNotificationSettings returns where the user's notifications
+// are delivered. Gotify application tokens are never echoed back.
+func (s *Server) handleGetNotificationSettings(c *gin.Context) {
+	var mobile, autoFollow bool
+	var relay, relayTarget string
+	if err := s.db.QueryRow(
+		"SELECT notify_mobile, notify_relay, notify_relay_target, auto_follow_threads FROM users WHERE id = ?", c.GetInt("user_id"),
+	).Scan(&mobile, &relay, &relayTarget, &autoFollow); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification settings"})
+		return
+	}
+
+	data := gin.H{
+		"mobile":              mobile,
+		"relay":               relay,
+		"auto_follow_threads": autoFollow,
+		"available":           []string{},
+	}
+	if relay == push.PlatformNtfy {
+		data["topic"] = relayTarget
+	}
+	if s.push != nil {
+		data["available"] = s.push.Platforms()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// handleUpdateNotificationSettings chooses mobile push, an ntfy topic or a
+// Gotify application, or any combination of mobile push and one relay.
+// Choosing ntfy without a topic generates an unguessable one.
+// auto_follow_threads decides whether replying to or starting a thread
+// follows it.
+func (s *Server) handleUpdateNotificationSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Mobile            *bool   `json:"mobile"`
+		Relay             *string `json:"relay"`
+		Topic             string  `json:"topic"`
+		Token             string  `json:"token"`
+		AutoFollowThreads *bool   `json:"auto_follow_threads"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Mobile != nil {
+		if _, err := s.db.Exec("UPDATE users SET notify_mobile = ? WHERE id = ?", *req.Mobile, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification settings"})
+			return
+		}
+	}
+
+	if req.AutoFollowThreads != nil {
+		if _, err := s.db.Exec("UPDATE users SET auto_follow_threads = ? WHERE id = ?", *req.AutoFollowThreads, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification settings"})
+			return
+		}
+	}
+
+	if req.Relay != nil {
+		relay, target := *req.Relay, ""
+		switch relay {
+		case "":
+		case push.PlatformNtfy:
+			target = req.Topic
+			if target == "" {
+				generated, err := s.auth.GenerateRandomString(18)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate topic"})
+					return
+				}
+				target = "fethur-" + generated
+			}
+			if !ntfyTopicPattern.MatchString(target) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "topic may only contain letters, digits, - and _ (at most 64)"})
+				return
+			}
+		case push.PlatformGotify:
+			target = strings.TrimSpace(req.Token)
+			if target == "" || len(target) > 256 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "token must be a Gotify application token"})
+				return
+			}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "relay must be ntfy, gotify or empty"})
+			return
+		}
+		if relay != "" && (s.push == nil || !s.push.Supports(relay)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notifications are not configured for " + relay})
+			return
+		}
+
+		if _, err := s.db.Exec(
+			"UPDATE users SET notify_relay = ?, notify_relay_target = ? WHERE id = ?", relay, target, userID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification settings"})
+			return
+		}
+	}
+
+	s.handleGetNotificationSettings(c)
+}
+
+// handleGetPushStats reports push configuration, registered devices and
+// delivery counts since startup
+func (s *Server) handleGetPushStats(c *gin.Context) {
+	devices := map[string]int{push.PlatformFCM: 0, push.PlatformAPNs: 0, push.PlatformNtfy: 0, push.PlatformGotify: 0}
+	rows, err := s.db.Query(`
+		SELECT platform, COUNT(*) FROM push_devices GROUP BY platform
+		UNION ALL
+		SELECT notify_relay, COUNT(*) FROM users WHERE notify_relay != '' GROUP BY notify_relay`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load push stats"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var platform string
+		var count int
+		if err := rows.Scan(&platform, &count); err == nil {
+			devices[platform] = count
+		}
+	}
+
+	platforms := []string{}
+	deliveries := map[string]push.Stats{}
+	if s.push != nil {
+		platforms = s.push.Platforms()
+		deliveries = s.push.Stats()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"platforms":  platforms,
+			"devices":    devices,
+			"deliveries": deliveries,
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fethur/internal/jobs"
+	"fethur/internal/push"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingPush records notifications and rejects the tokens in invalid
+type recordingPush struct {
+	platform string
+	mutex    sync.Mutex
+	sent     []push.Notification
+	invalid  map[string]bool
+}
+
+func (p *recordingPush) Platform() string { return p.platform }
+
+func (p *recordingPush) Send(ctx context.Context, token string, notification push.Notification) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.invalid[token] {
+		return push.ErrInvalidToken
+	}
+	p.sent = append(p.sent, notification)
+	return nil
+}
+
+func TestPushDevices(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	provider := &recordingPush{platform: push.PlatformFCM, invalid: map[string]bool{}}
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.jobs = queue
+	s.push = push.NewGateway(provider)
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("pushuser_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/devices", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		s.handleRegisterPushDevice(c)
+	})
+	register := func(platform, token string) int {
+		body := fmt.Sprintf(`{"platform":%q,"token":%q}`, platform, token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/devices", strings.NewReader(body)))
+		return w.Code
+	}
+
+	good, stale := fmt.Sprintf("good-%d", suffix), fmt.Sprintf("stale-%d", suffix)
+	if code := register("fcm", good); code != http.StatusCreated {
+		t.Fatalf("Expected device to register, got %d", code)
+	}
+	if code := register("fcm", stale); code != http.StatusCreated {
+		t.Fatalf("Expected device to register, got %d", code)
+	}
+	if code := register("fcm", good); code != http.StatusCreated {
+		t.Fatalf("Expected re-registering a token to succeed, got %d", code)
+	}
+	if code := register("apns", "other"); code != http.StatusBadRequest {
+		t.Errorf("Expected unconfigured platform to be rejected, got %d", code)
+	}
+
+	provider.invalid[stale] = true
+	s.sendPush(int(userID), push.Notification{Title: "hello", Badge: 1})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM push_devices WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatalf("Failed to count devices: %v", err)
+		}
+		if count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the invalid token to be removed, %d devices left", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if sent := provider.count(); sent != 1 {
+		t.Errorf("Expected one delivered notification, got %d", sent)
+	}
+	if stats := s.push.Stats()[push.PlatformFCM]; stats.Sent != 1 || stats.Invalidated != 1 {
+		t.Errorf("Unexpected delivery stats: %+v", stats)
+	}
+}
+
+func TestNotificationRelay(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	mobile := &recordingPush{platform: push.PlatformFCM, invalid: map[string]bool{}}
+	ntfy := &recordingPush{platform: push.PlatformNtfy, invalid: map[string]bool{}}
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.jobs = queue
+	s.push = push.NewGateway(mobile, ntfy)
+
+	suffix := time.Now().UnixNano()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("relayuser_%d", suffix))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO push_devices (user_id, platform, token) VALUES (?, 'fcm', ?)", userID, fmt.Sprintf("phone-%d", suffix)); err != nil {
+		t.Fatalf("Failed to register device: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/notifications", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		s.handleUpdateNotificationSettings(c)
+	})
+	update := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/notifications", strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	if code, _ := update(`{"relay":"gotify","token":"abc"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected unconfigured relay to be rejected, got %d", code)
+	}
+	if code, _ := update(`{"relay":"ntfy","topic":"no spaces"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected invalid topic to be rejected, got %d", code)
+	}
+	code, body := update(`{"mobile":false,"relay":"ntfy"}`)
+	if code != http.StatusOK || !strings.Contains(body, `"topic":"fethur-`) {
+		t.Fatalf("Expected a generated topic, got %d %s", code, body)
+	}
+
+	s.sendPush(int(userID), push.Notification{Title: "hello", Badge: -1})
+	deadline := time.Now().Add(5 * time.Second)
+	for ntfy.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the notification to be published to ntfy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mobile.count() != 0 {
+		t.Error("Expected mobile push to be skipped when disabled")
+	}
+}
+
+func (p *recordingPush) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.sent)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quarantine, a shadow mute handed out through moderation cases. Messages a
+// quarantined member sends are stored flagged and delivered only to them
+// and the server's owners and admins. Moderators see the flag; the member
+// sees nothing different, so spammers are contained without being tipped
+// off. Flagged messages stay hidden after the quarantine ends.
+
+// quarantineVisibleSQL is a condition keeping the messages aliased alias
+// that the viewer may see: unflagged ones, their own, and every message on
+// servers they moderate. It takes the viewer's ID twice.
+func quarantineVisibleSQL(alias string) string {
+	return fmt.Sprintf(`(%[1]s.quarantined = 0 OR %[1]s.user_id = ? OR EXISTS (
+		SELECT 1 FROM channels qc JOIN server_members qm ON qm.server_id = qc.server_id
+		WHERE qc.id = %[1]s.channel_id AND qm.user_id = ? AND qm.role IN ('owner', 'admin')))`, alias)
+}
+
+// serverModeratorIDs lists a server's owners and admins
+func (s *Server) serverModeratorIDs(serverID int) []int {
+	rows, err := s.db.Query(
+		"SELECT user_id FROM server_members WHERE server_id = ? AND role IN ('owner', 'admin')", serverID,
+	)
+	if err != nil {
+		log.Printf("Failed to list moderators of server %d: %v", serverID, err)
+		return nil
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	moderators := make([]int, 0)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			moderators = append(moderators, userID)
+		}
+	}
+	return moderators
+}
+
+// quarantineAudience is who sees a quarantined member's messages: the
+// member and the server's moderators
+func (s *Server) quarantineAudience(serverID, authorID int) map[int]bool {
+	audience := map[int]bool{authorID: true}
+	for _, userID := range s.serverModeratorIDs(serverID) {
+		audience[userID] = true
+	}
+	return audience
+}
+
+// messageAudience returns who may hear about changes to a message: nil for
+// everyone in the channel, or the quarantine audience of a flagged message
+func (s *Server) messageAudience(messageID int64) map[int]bool {
+	var quarantined bool
+	var authorID, serverID int
+	err := s.db.QueryRow(`
+		SELECT m.quarantined, m.user_id, ch.server_id
+		FROM messages m JOIN channels ch ON ch.id = m.channel_id
+		WHERE m.id = ?`, messageID,
+	).Scan(&quarantined, &authorID, &serverID)
+	if err != nil || !quarantined {
+		return nil
+	}
+	return s.quarantineAudience(serverID, authorID)
+}
+
+// deliverQuarantined sends a quarantined member's new message to them as
+// usual and to the server's moderators flagged as quarantined
+func (s *Server) deliverQuarantined(message *websocket.Message, data gin.H, serverID, authorID int) {
+	own := *message
+	own.Audience = map[int]bool{authorID: true}
+	s.hub.BroadcastMessage(&own)
+
+	moderators := s.quarantineAudience(serverID, authorID)
+	delete(moderators, authorID)
+	if len(moderators) == 0 {
+		return
+	}
+	flagged := gin.H{"quarantined": true}
+	for key, value := range data {
+		flagged[key] = value
+	}
+	moderated := *message
+	moderated.Data = flagged
+	moderated.Audience = moderators
+	s.hub.BroadcastMessage(&moderated)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuarantine(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"admin", "spammer", "member"} {
+		users[name] = createTestUser(t, db, fmt.Sprintf("quarantine%s_%d", name, suffix))
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Quarantine %d", suffix), users["admin"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	for name, rank := range map[string]string{"admin": "admin", "spammer": "member", "member": "member"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'general')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+		c.Set("org_id", 0)
+	})
+	router.POST("/servers/:id/cases", s.handleCreateCase)
+	router.PUT("/servers/:id/cases/:number/appeal", s.handleDecideCaseAppeal)
+	router.POST("/servers/:id/cases/:number/appeal", s.handleAppealCase)
+	router.GET("/channels/:channelId/messages", s.handleGetMessages)
+	router.POST("/channels/:channelId/messages", s.handleSendMessage)
+	router.PUT("/messages/:messageId", s.handleEditMessage)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	messagesPath := fmt.Sprintf("/channels/%d/messages", channelID)
+	history := func(user string) []map[string]interface{} {
+		t.Helper()
+		w := request("GET", messagesPath, user, "")
+		var body struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to read history: %v", err)
+		}
+		return body.Messages
+	}
+	// Messages sent in the same second have no set order
+	find := func(messages []map[string]interface{}, content string) map[string]interface{} {
+		for _, message := range messages {
+			if message["content"] == content {
+				return message
+			}
+		}
+		return nil
+	}
+
+	w := request("POST", fmt.Sprintf("/servers/%d/cases", serverID), "admin",
+		fmt.Sprintf(`{"user_id":%d,"action":"quarantine","reason":"Spam links","duration_minutes":60}`, users["spammer"]))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the quarantine, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The spammer posts as usual and sees nothing different
+	w = request("POST", messagesPath, "spammer", `{"content":"cheap pills"}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "quarantined") {
+		t.Fatalf("Expected the message to look sent, got %d: %s", w.Code, w.Body.String())
+	}
+	var sent struct {
+		Data struct {
+			ID snowflake.ID `json:"id"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &sent)
+	request("POST", messagesPath, "member", `{"content":"hello"}`)
+
+	if own := history("spammer"); len(own) != 2 || find(own, "cheap pills")["quarantined"] != nil {
+		t.Errorf("Expected the spammer to see their message unflagged, got %+v", own)
+	}
+	if others := history("member"); len(others) != 1 || find(others, "hello") == nil {
+		t.Errorf("Expected other members not to see the message, got %+v", others)
+	}
+	if moderated := history("admin"); len(moderated) != 2 || find(moderated, "cheap pills")["quarantined"] != true {
+		t.Errorf("Expected moderators to see the message flagged, got %+v", moderated)
+	}
+	if audience := s.messageAudience(int64(sent.Data.ID)); len(audience) != 2 || !audience[users["admin"]] || audience[users["member"]] {
+		t.Errorf("Expected changes to reach the spammer and moderators only, got %v", audience)
+	}
+	if w := request("PUT", fmt.Sprintf("/messages/%d", sent.Data.ID), "member", `{"content":"x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the message to be hidden from other members, got %d", w.Code)
+	}
+
+	// Followed threads neither list flagged roots nor count flagged replies
+	// for other members
+	var hello int64
+	if err := db.QueryRow("SELECT id FROM messages WHERE channel_id = ? AND content = 'hello'", channelID).Scan(&hello); err != nil {
+		t.Fatalf("Failed to find the thread: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO messages (id, channel_id, user_id, content, thread_id, quarantined) VALUES (?, ?, ?, 'buy now', ?, 1)",
+		int64(snowflake.Next()), channelID, users["spammer"], hello); err != nil {
+		t.Fatalf("Failed to insert the flagged reply: %v", err)
+	}
+	for _, name := range []string{"member", "admin"} {
+		if _, err := db.Exec("INSERT INTO thread_follows (user_id, message_id) VALUES (?, ?), (?, ?)",
+			users[name], hello, users[name], int64(sent.Data.ID)); err != nil {
+			t.Fatalf("Failed to follow threads: %v", err)
+		}
+	}
+	router.GET("/user/threads", s.handleGetFollowedThreads)
+	followed := func(user string) map[string]float64 {
+		t.Helper()
+		var body struct {
+			Data []struct {
+				ThreadID snowflake.ID `json:"thread_id"`
+				Replies  float64      `json:"replies"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(request("GET", "/user/threads", user, "").Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to read followed threads: %v", err)
+		}
+		replies := make(map[string]float64)
+		for _, thread := range body.Data {
+			replies[thread.ThreadID.String()] = thread.Replies
+		}
+		return replies
+	}
+	helloID, pillsID := snowflake.ID(hello).String(), sent.Data.ID.String()
+	if threads := followed("member"); len(threads) != 1 || threads[helloID] != 0 {
+		t.Errorf("Expected the member to follow one thread with no visible replies, got %v", threads)
+	}
+	if threads := followed("admin"); len(threads) != 2 || threads[helloID] != 1 {
+		t.Errorf("Expected moderators to see both threads and the flagged reply, got %v", threads)
+	}
+	if _, ok := followed("admin")[pillsID]; !ok {
+		t.Error("Expected moderators to see the flagged thread")
+	}
+
+	// Ticket transcripts leave out other members' flagged messages
+	channel := int(channelID)
+	for user, want := range map[string]bool{"member": false, "spammer": true} {
+		transcript, err := s.ticketTranscript(&ticketInfo{Number: 1, Subject: "Help", ChannelID: &channel, OpenedBy: users[user]}, "admin", "")
+		if err != nil {
+			t.Fatalf("Failed to build transcript: %v", err)
+		}
+		if strings.Contains(transcript, "cheap pills") != want || !strings.Contains(transcript, "hello") {
+			t.Errorf("Expected flagged messages in %s's transcript to be %v, got:\n%s", user, want, transcript)
+		}
+	}
+
+	// Lifting the quarantine lets new messages through; flagged ones stay hidden
+	request("POST", fmt.Sprintf("/servers/%d/cases/1/appeal", serverID), "spammer", `{"reason":"Sorry"}`)
+	if w := request("PUT", fmt.Sprintf("/servers/%d/cases/1/appeal", serverID), "admin", `{"status":"accepted"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the appeal to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	request("POST", messagesPath, "spammer", `{"content":"sorry everyone"}`)
+	if others := history("member"); len(others) != 2 || find(others, "sorry everyone") == nil {
+		t.Errorf("Expected only the new message to be visible, got %+v", others)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server quotas. Each has a default in settings and may be overridden per
+// server by super admins; a limit of 0 is unlimited.
+const (
+	quotaChannels = "channels"
+	quotaMembers  = "members"
+	quotaWebhooks = "webhooks"
+	quotaUploads  = "uploads"
+)
+
+var allQuotas = []string{quotaChannels, quotaMembers, quotaWebhooks, quotaUploads}
+
+// quotaColumns are the server_quotas columns holding each override
+var quotaColumns = map[string]string{
+	quotaChannels: "max_channels",
+	quotaMembers:  "max_members",
+	quotaWebhooks: "max_webhooks",
+	quotaUploads:  "upload_quota_mb",
+}
+
+// quotaSettings are the settings holding each default
+var quotaSettings = map[string]string{
+	quotaChannels: "server_max_channels",
+	quotaMembers:  "server_max_members",
+	quotaWebhooks: "server_max_webhooks",
+	quotaUploads:  "server_upload_quota_mb",
+}
+
+// quotaError is returned when an action would take a server over a limit.
+// Upload limits and usage are in bytes.
+type quotaError struct {
+	Quota string
+	Limit int64
+	Used  int64
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("This server has reached its %s limit", e.Quota)
+}
+
+// respondQuotaExceeded reports a quota error with the numbers clients need
+// to explain it
+func respondQuotaExceeded(c *gin.Context, err *quotaError) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": err.Error(),
+		"code":  "quota_exceeded",
+		"quota": err.Quota,
+		"limit": err.Limit,
+		"used":  err.Used,
+	})
+}
+
+// serverQuotaLimits returns the effective limits of a server and which of
+// them are overridden. Upload limits are in bytes.
+func (s *Server) serverQuotaLimits(serverID interface{}) (map[string]int64, map[string]bool, error) {
+	limits := make(map[string]int64, len(allQuotas))
+	for _, quota := range allQuotas {
+		limits[quota] = int64(s.getIntSetting(quotaSettings[quota], 0))
+	}
+
+	overrides := make(map[string]bool)
+	values := make([]sql.NullInt64, len(allQuotas))
+	err := s.db.QueryRow(
+		"SELECT max_channels, max_members, max_webhooks, upload_quota_mb FROM server_quotas WHERE server_id = ?", serverID,
+	).Scan(&values[0], &values[1], &values[2], &values[3])
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+	for i, quota := range allQuotas {
+		if values[i].Valid {
+			limits[quota] = values[i].Int64
+			overrides[quota] = true
+		}
+	}
+	limits[quotaUploads] <<= 20
+	return limits, overrides, nil
+}
+
+// serverQuotaUsage counts what a server uses of one quota
+func (s *Server) serverQuotaUsage(serverID interface{}, quota string) (int64, error) {
+	var query string
+	switch quota {
+	case quotaChannels:
+		query = "SELECT COUNT(*) FROM channels WHERE server_id = ?"
+	case quotaMembers:
+		query = "SELECT COUNT(*) FROM server_members WHERE server_id = ?"
+	case quotaWebhooks:
+		query = "SELECT COUNT(*) FROM incoming_webhooks w JOIN channels ch ON ch.id = w.channel_id WHERE ch.server_id = ?"
+	case quotaUploads:
+		query = `
+			SELECT COALESCE(SUM(a.size), 0) FROM attachments a
+			JOIN messages m ON m.id = a.message_id
+			JOIN channels ch ON ch.id = m.channel_id
+			WHERE ch.server_id = ?`
+	default:
+		return 0, fmt.Errorf("unknown quota %s", quota)
+	}
+	var used int64
+	err := s.db.QueryRow(query, serverID).Scan(&used)
+	return used, err
+}
+
+// checkServerQuota returns a *quotaError when adding to a server's usage of
+// a quota would exceed its limit. adding is a count, or bytes for uploads.
+func (s *Server) checkServerQuota(serverID interface{}, quota string, adding int64) error {
+	limits, _, err := s.serverQuotaLimits(serverID)
+	if err != nil {
+		return err
+	}
+	limit := limits[quota]
+	if limit <= 0 {
+		return nil
+	}
+	used, err := s.serverQuotaUsage(serverID, quota)
+	if err != nil {
+		return err
+	}
+	if used+adding > limit {
+		return &quotaError{Quota: quota, Limit: limit, Used: used}
+	}
+	return nil
+}
+
+// respondQuotaCheck writes the response for a failed quota check and
+// reports whether the action may go ahead
+func respondQuotaCheck(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		respondQuotaExceeded(c, exceeded)
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check server quota"})
+	}
+	return false
+}
+
+// serverQuotasJSON describes a server's limits and usage for server
+// payloads
+func (s *Server) serverQuotasJSON(serverID interface{}) gin.H {
+	limits, overrides, err := s.serverQuotaLimits(serverID)
+	if err != nil {
+		return gin.H{}
+	}
+	quotas := gin.H{}
+	for _, quota := range allQuotas {
+		used, err := s.serverQuotaUsage(serverID, quota)
+		if err != nil {
+			continue
+		}
+		quotas[quota] = gin.H{
+			"limit":      limits[quota],
+			"used":       used,
+			"overridden": overrides[quota],
+		}
+	}
+	return quotas
+}
+
+// handleGetServerQuotas returns a server's limits and usage; super admins
+// only
+func (s *Server) handleGetServerQuotas(c *gin.Context) {
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM servers WHERE id = ?)", serverID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.serverQuotasJSON(serverID)})
+}
+
+// handleUpdateServerQuotas overrides a server's limits; super admins only.
+// The body maps max_channels, max_members, max_webhooks and upload_quota_mb
+// to a limit, or to null to go back to the default.
+func (s *Server) handleUpdateServerQuotas(c *gin.Context) {
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM servers WHERE id = ?)", serverID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	var req map[string]*int
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	valid := make(map[string]bool, len(quotaColumns))
+	for _, column := range quotaColumns {
+		valid[column] = true
+	}
+	for column, value := range req {
+		if !valid[column] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown quota %s", column)})
+			return
+		}
+		if value != nil && *value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must not be negative", column)})
+			return
+		}
+	}
+
+	if _, err := s.db.Exec("INSERT OR IGNORE INTO server_quotas (server_id) VALUES (?)", serverID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quotas"})
+		return
+	}
+	for column, value := range req {
+		var limit interface{}
+		if value != nil {
+			limit = *value
+		}
+		if _, err := s.db.Exec(
+			fmt.Sprintf("UPDATE server_quotas SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE server_id = ?", column),
+			limit, serverID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quotas"})
+			return
+		}
+	}
+	s.logAdminAction(c.GetInt("user_id"), "update_server_quotas", fmt.Sprintf("Updated quotas of server %d", serverID))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.serverQuotasJSON(serverID)})
+}
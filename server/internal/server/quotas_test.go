@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerQuotas(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "first", "second", "root"} {
+		role := "user"
+		if name == "root" {
+			role = "super_admin"
+		}
+		result, err := db.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, 'x', ?)", fmt.Sprintf("q%s_%d", name, suffix), role)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Quotas %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", users["owner"], serverID); err != nil {
+		t.Fatalf("Failed to add owner: %v", err)
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, 'general', 'text')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+
+	// The default applies until a super admin overrides it
+	if err := db.SetSetting("server_max_channels", "1", ""); err != nil {
+		t.Fatalf("Failed to set default: %v", err)
+	}
+	defer func() {
+		_ = db.SetSetting("server_max_channels", "0", "")
+	}()
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", user)
+		})
+		router.POST("/servers/:id/channels", s.handleCreateChannel)
+		router.POST("/servers/:id/members", s.handleAddServerMember)
+		router.PUT("/admin/servers/:id/quotas", s.superAdminMiddleware(), s.handleUpdateServerQuotas)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	channelsPath := fmt.Sprintf("/servers/%d/channels", serverID)
+	quotasPath := fmt.Sprintf("/admin/servers/%d/quotas", serverID)
+
+	w := request("owner", "POST", channelsPath, `{"name":"random"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the channel limit to apply, got %d", w.Code)
+	}
+	var refused struct {
+		Code  string `json:"code"`
+		Quota string `json:"quota"`
+		Limit int64  `json:"limit"`
+		Used  int64  `json:"used"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &refused)
+	if refused.Code != "quota_exceeded" || refused.Quota != quotaChannels || refused.Limit != 1 || refused.Used != 1 {
+		t.Errorf("Expected a quota error for 1 of 1 channels, got %+v", refused)
+	}
+
+	if w := request("owner", "PUT", quotasPath, `{"max_channels":2}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected only super admins to override quotas, got %d", w.Code)
+	}
+	if w := request("root", "PUT", quotasPath, `{"max_channels":2,"max_members":2}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the override to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("owner", "POST", channelsPath, `{"name":"random"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected the override to allow a second channel, got %d", w.Code)
+	}
+
+	// Members
+	membersPath := fmt.Sprintf("/servers/%d/members", serverID)
+	if w := request("owner", "POST", membersPath, fmt.Sprintf(`{"user_id":%d}`, users["first"])); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the member to be added, got %d", w.Code)
+	}
+	if w := request("owner", "POST", membersPath, fmt.Sprintf(`{"user_id":%d}`, users["first"])); w.Code != http.StatusConflict {
+		t.Errorf("Expected an existing member to be reported before the limit, got %d", w.Code)
+	}
+	if w := request("owner", "POST", membersPath, fmt.Sprintf(`{"user_id":%d}`, users["second"])); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the member limit to apply, got %d", w.Code)
+	}
+
+	// Uploads count attachments of messages in the server
+	if w := request("root", "PUT", quotasPath, `{"max_channels":null,"upload_quota_mb":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the override to be saved, got %d", w.Code)
+	}
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'file')", channelID, users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	messageID, _ := result.LastInsertId()
+	if _, err := db.Exec(
+		"INSERT INTO attachments (uploader_id, message_id, storage_key, filename, content_type, size, status) VALUES (?, ?, ?, 'a.bin', 'application/octet-stream', ?, 'ready')",
+		users["owner"], messageID, fmt.Sprintf("quota-%d", suffix), 900<<10,
+	); err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	if err := s.checkServerQuota(serverID, quotaUploads, 100<<10); err != nil {
+		t.Errorf("Expected 1000 KiB to fit in 1 MiB, got %v", err)
+	}
+	var exceeded *quotaError
+	if err := s.checkServerQuota(serverID, quotaUploads, 200<<10); !errors.As(err, &exceeded) || exceeded.Limit != 1<<20 {
+		t.Errorf("Expected 1100 KiB to exceed 1 MiB, got %v", err)
+	}
+
+	quotas := s.serverQuotasJSON(serverID)
+	channels, _ := quotas[quotaChannels].(gin.H)
+	if channels["limit"] != int64(1) || channels["used"] != int64(2) || channels["overridden"] != false {
+		t.Errorf("Expected the channel default back with 2 used, got %v", channels)
+	}
+	members, _ := quotas[quotaMembers].(gin.H)
+	if members["limit"] != int64(2) || members["overridden"] != true {
+		t.Errorf("Expected the member override in the payload, got %v", members)
+	}
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Raid mode. Owners and admins turn it on for a server, or it turns on by
+// itself for a while when joins from the directory spike. While it is on,
+// joining from the directory files a join request for moderators to
+// approve instead, and members who joined in the last few minutes cannot
+// post. Owners and admins hear about every change over the WebSocket and
+// through raid_mode.changed automation hooks.
+
+const (
+	// raidHoldBackMinutes is how long new members wait before posting
+	// while raid mode is on
+	raidHoldBackMinutes = 10
+
+	maxRaidJoinThreshold   = 1000
+	maxRaidWindowSeconds   = 3600
+	maxRaidDurationMinutes = 1440
+)
+
+// raidSettings is a server's raid mode and its automatic trigger
+type raidSettings struct {
+	Auto            bool       `json:"auto"`
+	JoinThreshold   int        `json:"join_threshold"`
+	WindowSeconds   int        `json:"window_seconds"`
+	DurationMinutes int        `json:"duration_minutes"`
+	Active          bool       `json:"active"`
+	ActivatedAt     *time.Time `json:"activated_at"`
+	ActivatedBy     *int       `json:"activated_by"` // nil when a join spike turned it on
+	ExpiresAt       *time.Time `json:"expires_at"`
+}
+
+// joinTracker counts recent joins per server to spot spikes
+type joinTracker struct {
+	mutex sync.Mutex
+	joins map[int][]time.Time
+}
+
+func newJoinTracker() *joinTracker {
+	return &joinTracker{joins: make(map[int][]time.Time)}
+}
+
+// record counts a join and returns how many the server had in the window
+func (t *joinTracker) record(serverID int, now time.Time, window time.Duration) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	recent := t.joins[serverID][:0]
+	for _, at := range t.joins[serverID] {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	t.joins[serverID] = recent
+	return len(recent)
+}
+
+// loadRaidSettings returns a server's raid settings or the defaults. An
+// expired raid mode reads as off.
+func (s *Server) loadRaidSettings(serverID int) raidSettings {
+	settings := raidSettings{JoinThreshold: 10, WindowSeconds: 60, DurationMinutes: 30}
+	var activatedAt, expiresAt sql.NullTime
+	var activatedBy sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT auto, join_threshold, window_seconds, duration_minutes, active, activated_at, activated_by, expires_at
+		FROM raid_settings WHERE server_id = ?`, serverID,
+	).Scan(&settings.Auto, &settings.JoinThreshold, &settings.WindowSeconds, &settings.DurationMinutes,
+		&settings.Active, &activatedAt, &activatedBy, &expiresAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to load raid settings of server %d: %v", serverID, err)
+		}
+		return settings
+	}
+	if expiresAt.Valid && !expiresAt.Time.After(time.Now()) {
+		settings.Active = false
+	}
+	if settings.Active {
+		if activatedAt.Valid {
+			settings.ActivatedAt = &activatedAt.Time
+		}
+		settings.ActivatedBy = nullIntPtr(activatedBy)
+		if expiresAt.Valid {
+			settings.ExpiresAt = &expiresAt.Time
+		}
+	}
+	return settings
+}
+
+// setRaidMode turns raid mode on or off and tells the server's owners and
+// admins. actorID is nil when a join spike turns it on; a zero duration
+// lasts until it is turned off.
+func (s *Server) setRaidMode(serverID int, active bool, actorID *int, duration time.Duration) (raidSettings, error) {
+	var activatedAt, expiresAt interface{}
+	if active {
+		now := time.Now().UTC()
+		activatedAt = now
+		if duration > 0 {
+			expiresAt = now.Add(duration)
+		}
+	}
+	if _, err := s.db.Exec(`
+		INSERT INTO raid_settings (server_id, active, activated_at, activated_by, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (server_id) DO UPDATE SET active = excluded.active, activated_at = excluded.activated_at,
+			activated_by = excluded.activated_by, expires_at = excluded.expires_at`,
+		serverID, active, activatedAt, actorID, expiresAt,
+	); err != nil {
+		return raidSettings{}, err
+	}
+	settings := s.loadRaidSettings(serverID)
+	s.notifyRaidMode(serverID, settings)
+	return settings, nil
+}
+
+// notifyRaidMode sends a raid mode change to the server's connected owners
+// and admins and to their raid_mode.changed hooks
+func (s *Server) notifyRaidMode(serverID int, settings raidSettings) {
+	data := gin.H{"server_id": serverID, "raid_mode": settings}
+	s.notifyServerModerators(serverID, &websocket.Message{
+		Type:      string(events.TypeRaidMode),
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+
+	rows, err := s.db.Query(`
+		SELECT h.id, h.target_url FROM automation_hooks h
+		JOIN server_members sm ON sm.user_id = h.user_id AND sm.server_id = ?
+		WHERE h.event = ? AND sm.role IN ('owner', 'admin')`,
+		serverID, hookEventRaidMode,
+	)
+	if err != nil {
+		log.Printf("Failed to load raid mode hooks: %v", err)
+		return
+	}
+	type hook struct {
+		id        int64
+		targetURL string
+	}
+	hooks := make([]hook, 0)
+	for rows.Next() {
+		var h hook
+		if err := rows.Scan(&h.id, &h.targetURL); err == nil {
+			hooks = append(hooks, h)
+		}
+	}
+	_ = rows.Close()
+
+	payload := gin.H{"event": hookEventRaidMode, "data": data}
+	now := time.Now().UnixNano()
+	for _, h := range hooks {
+		s.deliverAutomationHook(h.id, h.targetURL, fmt.Sprintf("raid-hook-%d-%d", h.id, now), payload)
+	}
+}
+
+// notifyServerModerators sends a message to a server's connected owners and
+// admins
+func (s *Server) notifyServerModerators(serverID int, message *websocket.Message) {
+	recipients := s.serverModeratorIDs(serverID)
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	for _, userID := range recipients {
+		if client, ok := s.clients[userID]; ok {
+			client.Send(message)
+		}
+	}
+}
+
+// recordDirectoryJoin counts a join from the directory and turns raid mode
+// on when joins spike past the server's threshold
+func (s *Server) recordDirectoryJoin(serverID int) {
+	if s.joinRates == nil {
+		return
+	}
+	settings := s.loadRaidSettings(serverID)
+	joins := s.joinRates.record(serverID, time.Now(), time.Duration(settings.WindowSeconds)*time.Second)
+	if !settings.Auto || settings.Active || joins < settings.JoinThreshold {
+		return
+	}
+	log.Printf("Raid mode on for server %d after %d joins in %d seconds", serverID, joins, settings.WindowSeconds)
+	if _, err := s.setRaidMode(serverID, true, nil, time.Duration(settings.DurationMinutes)*time.Minute); err != nil {
+		log.Printf("Failed to turn on raid mode for server %d: %v", serverID, err)
+	}
+}
+
+// raidHoldsBack reports whether raid mode keeps a member from posting:
+// members who joined in the last few minutes wait, owners and admins don't
+func (s *Server) raidHoldsBack(serverID, userID int) bool {
+	if !s.loadRaidSettings(serverID).Active {
+		return false
+	}
+	var recent bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM server_members
+			WHERE server_id = ? AND user_id = ? AND role = 'member' AND joined_at > datetime('now', ?)
+		)`, serverID, userID, fmt.Sprintf("-%d minutes", raidHoldBackMinutes),
+	).Scan(&recent)
+	if err != nil {
+		log.Printf("Failed to check raid mode for user %d: %v", userID, err)
+		return false
+	}
+	return recent
+}
+
+// requestToJoin files a join request for a server in raid mode
+func (s *Server) requestToJoin(c *gin.Context, serverID, userID int) {
+	if _, member := s.serverRole(userID, serverID); member {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already a member"})
+		return
+	}
+	var id int64
+	err := s.db.QueryRow(
+		"SELECT id FROM server_join_requests WHERE server_id = ? AND user_id = ? AND status = 'pending'", serverID, userID,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		result, insertErr := s.db.Exec("INSERT INTO server_join_requests (server_id, user_id) VALUES (?, ?)", serverID, userID)
+		if insertErr == nil {
+			id, _ = result.LastInsertId()
+			s.notifyServerModerators(serverID, &websocket.Message{
+				Type:      string(events.TypeJoinRequest),
+				Timestamp: time.Now(),
+				Data:      gin.H{"server_id": serverID, "request_id": id, "user_id": userID},
+			})
+		}
+		err = insertErr
+	}
+	if err != nil {
+		log.Printf("Failed to request to join server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join server"})
+		return
+	}
+	s.markWrite(userID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "The server is in raid mode; a moderator must approve your request to join",
+		"data":    gin.H{"server_id": serverID, "request_id": id, "status": "pending"},
+	})
+}
+
+// handleGetRaidMode shows a server's raid mode to its owners and admins
+func (s *Server) handleGetRaidMode(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.loadRaidSettings(serverID)})
+}
+
+// handleUpdateRaidMode turns raid mode on or off and configures its
+// automatic trigger. All fields are optional. Turned on by hand, raid mode
+// lasts until it is turned off.
+func (s *Server) handleUpdateRaidMode(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		Active          *bool `json:"active"`
+		Auto            *bool `json:"auto"`
+		JoinThreshold   *int  `json:"join_threshold"`
+		WindowSeconds   *int  `json:"window_seconds"`
+		DurationMinutes *int  `json:"duration_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, limit := range []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"join_threshold", req.JoinThreshold, 2, maxRaidJoinThreshold},
+		{"window_seconds", req.WindowSeconds, 10, maxRaidWindowSeconds},
+		{"duration_minutes", req.DurationMinutes, 1, maxRaidDurationMinutes},
+	} {
+		if limit.value != nil && (*limit.value < limit.min || *limit.value > limit.max) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be between %d and %d", limit.name, limit.min, limit.max)})
+			return
+		}
+	}
+
+	userID := c.GetInt("user_id")
+	if _, err := s.db.Exec(`
+		INSERT INTO raid_settings (server_id, auto, join_threshold, window_seconds, duration_minutes)
+		VALUES (?, COALESCE(?, 0), COALESCE(?, 10), COALESCE(?, 60), COALESCE(?, 30))
+		ON CONFLICT (server_id) DO UPDATE SET
+			auto = COALESCE(?, auto), join_threshold = COALESCE(?, join_threshold),
+			window_seconds = COALESCE(?, window_seconds), duration_minutes = COALESCE(?, duration_minutes)`,
+		serverID, req.Auto, req.JoinThreshold, req.WindowSeconds, req.DurationMinutes,
+		req.Auto, req.JoinThreshold, req.WindowSeconds, req.DurationMinutes,
+	); err != nil {
+		log.Printf("Failed to update raid settings of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update raid mode"})
+		return
+	}
+	settings := s.loadRaidSettings(serverID)
+	if req.Active != nil && *req.Active != settings.Active {
+		var err error
+		settings, err = s.setRaidMode(serverID, *req.Active, &userID, 0)
+		if err != nil {
+			log.Printf("Failed to set raid mode of server %d: %v", serverID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update raid mode"})
+			return
+		}
+	}
+	s.markWrite(userID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": settings})
+}
+
+// handleGetJoinRequests lists a server's pending join requests, oldest
+// first
+func (s *Server) handleGetJoinRequests(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	rows, err := s.reader(c).Query(`
+		SELECT r.id, r.user_id, u.username, u.created_at, r.created_at
+		FROM server_join_requests r JOIN users u ON u.id = r.user_id
+		WHERE r.server_id = ? AND r.status = 'pending'
+		ORDER BY r.id`, serverID,
+	)
+	if err != nil {
+		log.Printf("Failed to get join requests of server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get join requests"})
+		return
+	}
+	defer rows.Close()
+
+	requests := make([]gin.H, 0)
+	for rows.Next() {
+		var id, userID int
+		var username, accountCreatedAt, createdAt string
+		if err := rows.Scan(&id, &userID, &username, &accountCreatedAt, &createdAt); err != nil {
+			continue
+		}
+		requests = append(requests, gin.H{
+			"id":                 id,
+			"user_id":            userID,
+			"username":           username,
+			"account_created_at": accountCreatedAt,
+			"created_at":         createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": requests})
+}
+
+// handleDecideJoinRequest approves or rejects a pending join request.
+// Approving adds the user as a member and the user hears the decision.
+func (s *Server) handleDecideJoinRequest(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID, ok := s.roleServerID(c, true)
+		if !ok {
+			return
+		}
+		requestID, err := strconv.ParseInt(c.Param("requestId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+			return
+		}
+		var userID int
+		err = s.db.QueryRow(
+			"SELECT user_id FROM server_join_requests WHERE id = ? AND server_id = ? AND status = 'pending'", requestID, serverID,
+		).Scan(&userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found"})
+			return
+		}
+
+		status := "rejected"
+		if approve {
+			status = "approved"
+			_, err := s.addServerMember(serverID, userID, "member")
+			var exceeded *quotaError
+			if errors.As(err, &exceeded) {
+				respondQuotaExceeded(c, exceeded)
+				return
+			}
+			if errors.Is(err, errServerBanned) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to approve join request %d: %v", requestID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve join request"})
+				return
+			}
+		}
+		moderatorID := c.GetInt("user_id")
+		if _, err := s.db.Exec(
+			"UPDATE server_join_requests SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ?",
+			status, moderatorID, requestID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update join request"})
+			return
+		}
+		s.markWrite(moderatorID)
+
+		decision := gin.H{"server_id": serverID, "request_id": requestID, "status": status}
+		s.clientsMux.RLock()
+		if client, ok := s.clients[userID]; ok {
+			client.Send(&websocket.Message{Type: string(events.TypeJoinDecided), Timestamp: time.Now(), Data: decision})
+		}
+		s.clientsMux.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": decision})
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/fetch"
+	"fethur/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRaidMode(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	queue := jobs.NewQueue(1, 16, time.Minute)
+	queue.Start()
+	defer queue.Stop()
+	s.fetcher = fetch.New(fetch.Config{AllowPrivate: true})
+	s.jobs = queue
+	s.joinRates = newJoinTracker()
+	if err := db.SetSetting("server_directory_enabled", "true", ""); err != nil {
+		t.Fatalf("Failed to enable the directory: %v", err)
+	}
+	defer func() { _ = db.SetSetting("server_directory_enabled", "false", "") }()
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "admin", "r1", "r2", "r3", "r4"} {
+		users[name] = createTestUser(t, db, fmt.Sprintf("raid%s_%d", name, suffix))
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Raided %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	for name, rank := range map[string]string{"owner": "owner", "admin": "admin"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", users[name], serverID, rank); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'general')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO server_directory (server_id) VALUES (?)", serverID); err != nil {
+		t.Fatalf("Failed to list server: %v", err)
+	}
+
+	// The owner's automation hook hears about raid mode
+	delivered := make(chan string, 4)
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- string(body)
+	}))
+	defer hookServer.Close()
+	if _, err := db.Exec(
+		"INSERT INTO automation_hooks (user_id, api_key_id, event, target_url, secret) VALUES (?, 0, 'raid_mode.changed', ?, 'secret')",
+		users["owner"], hookServer.URL,
+	); err != nil {
+		t.Fatalf("Failed to create hook: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+		c.Set("org_id", 0)
+	})
+	router.PUT("/servers/:id/raid-mode", s.handleUpdateRaidMode)
+	router.GET("/servers/:id/join-requests", s.handleGetJoinRequests)
+	router.POST("/servers/:id/join-requests/:requestId/approve", s.handleDecideJoinRequest(true))
+	router.POST("/servers/:id/join-requests/:requestId/reject", s.handleDecideJoinRequest(false))
+	router.POST("/directory/:id/join", s.handleJoinDirectoryServer)
+	router.POST("/channels/:channelId/messages", s.handleSendMessage)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	raidPath := fmt.Sprintf("/servers/%d/raid-mode", serverID)
+	joinPath := fmt.Sprintf("/directory/%d/join", serverID)
+	requestsPath := fmt.Sprintf("/servers/%d/join-requests", serverID)
+
+	if w := request("PUT", raidPath, "owner", `{"auto":true,"join_threshold":1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a threshold below 2 to be refused, got %d", w.Code)
+	}
+	if w := request("PUT", raidPath, "owner", `{"auto":true,"join_threshold":2,"duration_minutes":5}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the trigger to be configured, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The second join in the window turns raid mode on for a while
+	for _, user := range []string{"r1", "r2"} {
+		if w := request("POST", joinPath, user, ""); w.Code != http.StatusCreated {
+			t.Fatalf("Expected %s to join, got %d: %s", user, w.Code, w.Body.String())
+		}
+	}
+	settings := s.loadRaidSettings(serverID)
+	if !settings.Active || settings.ActivatedBy != nil || settings.ExpiresAt == nil {
+		t.Fatalf("Expected raid mode to turn on automatically with an expiry, got %+v", settings)
+	}
+	select {
+	case body := <-delivered:
+		if !strings.Contains(body, `"event":"raid_mode.changed"`) || !strings.Contains(body, `"active":true`) {
+			t.Errorf("Unexpected hook payload %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the raid mode hook")
+	}
+
+	// New members wait before posting; staff do not
+	w := request("POST", fmt.Sprintf("/channels/%d/messages", channelID), "r2", `{"content":"spam"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"raid_mode"`) {
+		t.Errorf("Expected the new member to be held back, got %d: %s", w.Code, w.Body.String())
+	}
+	if s.raidHoldsBack(serverID, users["admin"]) {
+		t.Error("Expected admins to post during raid mode")
+	}
+
+	// Joins wait for approval
+	for _, user := range []string{"r3", "r4"} {
+		if w := request("POST", joinPath, user, ""); w.Code != http.StatusAccepted {
+			t.Fatalf("Expected %s's join to wait for approval, got %d: %s", user, w.Code, w.Body.String())
+		}
+	}
+	if w := request("POST", joinPath, "r3", ""); w.Code != http.StatusAccepted {
+		t.Errorf("Expected a repeated join to keep the pending request, got %d", w.Code)
+	}
+	if w := request("GET", requestsPath, "r1", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected members not to see join requests, got %d", w.Code)
+	}
+	var pending struct {
+		Data []struct {
+			ID     int `json:"id"`
+			UserID int `json:"user_id"`
+		} `json:"data"`
+	}
+	w = request("GET", requestsPath, "admin", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &pending)
+	if len(pending.Data) != 2 || pending.Data[0].UserID != users["r3"] {
+		t.Fatalf("Expected two pending requests, oldest first, got %s", w.Body.String())
+	}
+	if w := request("POST", fmt.Sprintf("%s/%d/approve", requestsPath, pending.Data[0].ID), "admin", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the request to be approved, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", fmt.Sprintf("%s/%d/reject", requestsPath, pending.Data[1].ID), "admin", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the request to be rejected, got %d", w.Code)
+	}
+	if w := request("POST", fmt.Sprintf("%s/%d/approve", requestsPath, pending.Data[1].ID), "admin", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a decided request to be gone, got %d", w.Code)
+	}
+	if _, member := s.serverRole(users["r3"], serverID); !member {
+		t.Error("Expected the approved user to be a member")
+	}
+	if _, member := s.serverRole(users["r4"], serverID); member {
+		t.Error("Expected the rejected user not to be a member")
+	}
+
+	// Turned off by hand, joins go through again
+	if w := request("PUT", raidPath, "owner", `{"active":false,"auto":false}`); w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"active":true`) {
+		t.Fatalf("Expected raid mode to turn off, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", joinPath, "r4", ""); w.Code != http.StatusCreated {
+		t.Errorf("Expected the join to go through, got %d", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateLimitClients bounds how many clients a limiter tracks before it
+// drops the ones whose window has passed
+const maxRateLimitClients = 10000
+
+// rateLimiter allows each client a number of requests per fixed window
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, clients: make(map[string]*rateWindow)}
+}
+
+// allow counts a request from a client and reports whether it is within
+// the limit
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		if !ok && len(l.clients) >= maxRateLimitClients {
+			for key, other := range l.clients {
+				if now.Sub(other.start) >= l.window {
+					delete(l.clients, key)
+				}
+			}
+		}
+		l.clients[client] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// rateLimit refuses clients, by IP, once they exceed the limiter's rate
+func rateLimit(l *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.allow(c.ClientIP(), time.Now()) {
+			c.Header("Retry-After", strconv.Itoa(int(l.window.Seconds())))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReactionRoles bounds the bindings on one message; each needs its own
+// emoji, so it matches the reaction limit
+const maxReactionRoles = maxReactionEmojis
+
+// Reaction role modes: toggle grants on react and removes on unreact,
+// grant and remove only act on react
+const (
+	reactionRoleToggle = "toggle"
+	reactionRoleGrant  = "grant"
+	reactionRoleRemove = "remove"
+)
+
+// reactionRole binds an emoji on a message to a role
+type reactionRole struct {
+	MessageID snowflake.ID `json:"message_id"`
+	ChannelID int          `json:"channel_id"`
+	Emoji     string       `json:"emoji"`
+	RoleID    int64        `json:"role_id"`
+	RoleName  string       `json:"role_name"`
+	Mode      string       `json:"mode"`
+}
+
+// applyReactionRole grants or removes the role bound to a reaction
+func (s *Server) applyReactionRole(event reactionEvent) {
+	var roleID int64
+	var mode string
+	err := s.db.QueryRow(
+		"SELECT role_id, mode FROM reaction_roles WHERE message_id = ? AND emoji = ?", event.MessageID, event.Emoji,
+	).Scan(&roleID, &mode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load reaction role for message %d: %v", event.MessageID, err)
+		return
+	}
+
+	var grant bool
+	switch {
+	case event.Added:
+		grant = mode != reactionRoleRemove
+	case mode != reactionRoleToggle:
+		// Only toggles act when a reaction is taken back
+		return
+	}
+	if grant {
+		_, err = s.grantMemberRole(event.ServerID, event.UserID, roleID)
+	} else {
+		_, err = s.revokeMemberRole(event.ServerID, event.UserID, roleID)
+	}
+	if err != nil {
+		log.Printf("Failed to apply reaction role %d to user %d: %v", roleID, event.UserID, err)
+	}
+}
+
+// queryReactionRoles lists bindings matching a condition on rr
+func (s *Server) queryReactionRoles(where string, args ...interface{}) ([]reactionRole, error) {
+	rows, err := s.db.Query(`
+		SELECT rr.message_id, m.channel_id, rr.emoji, rr.role_id, r.name, rr.mode
+		FROM reaction_roles rr
+		JOIN messages m ON m.id = rr.message_id
+		JOIN server_roles r ON r.id = rr.role_id
+		WHERE `+where+`
+		ORDER BY rr.message_id, rr.created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	bindings := make([]reactionRole, 0)
+	for rows.Next() {
+		var binding reactionRole
+		if err := rows.Scan(&binding.MessageID, &binding.ChannelID, &binding.Emoji, &binding.RoleID, &binding.RoleName, &binding.Mode); err == nil {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, rows.Err()
+}
+
+// reactionRoleMessage is messageForUser for owners and admins of the
+// message's server
+func (s *Server) reactionRoleMessage(c *gin.Context) (int64, *channelInfo, bool) {
+	messageID, channel, _, _, ok := s.messageForUser(c)
+	if !ok {
+		return 0, nil, false
+	}
+	if !s.canManageChannel(c.GetInt("user_id"), channel.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return 0, nil, false
+	}
+	return messageID, channel, true
+}
+
+// handleGetServerReactionRoles lists every reaction role in the server
+func (s *Server) handleGetServerReactionRoles(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	bindings, err := s.queryReactionRoles("rr.server_id = ?", serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reaction roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    bindings,
+	})
+}
+
+// handleGetReactionRoles lists the reaction roles on a message
+func (s *Server) handleGetReactionRoles(c *gin.Context) {
+	messageID, _, ok := s.reactionRoleMessage(c)
+	if !ok {
+		return
+	}
+	bindings, err := s.queryReactionRoles("rr.message_id = ?", messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reaction roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    bindings,
+	})
+}
+
+// handleSetReactionRole binds an emoji on a message to a role, replacing
+// any earlier binding of the emoji. Reactions already on the message are
+// left alone.
+func (s *Server) handleSetReactionRole(c *gin.Context) {
+	messageID, channel, ok := s.reactionRoleMessage(c)
+	if !ok {
+		return
+	}
+	emoji := c.Param("emoji")
+	if !validReactionEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emoji"})
+		return
+	}
+
+	var req struct {
+		RoleID int64  `json:"role_id" binding:"required"`
+		Mode   string `json:"mode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = reactionRoleToggle
+	}
+	if req.Mode != reactionRoleToggle && req.Mode != reactionRoleGrant && req.Mode != reactionRoleRemove {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be toggle, grant or remove"})
+		return
+	}
+	if _, found := s.findServerRole(channel.ServerID, strconv.FormatInt(req.RoleID, 10)); !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role_id must be a role in this server"})
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM reaction_roles WHERE message_id = ? AND emoji != ?", messageID, emoji,
+	).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set reaction role"})
+		return
+	}
+	if count >= maxReactionRoles {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message has too many reaction roles"})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	if _, err := s.db.Exec(`
+		INSERT INTO reaction_roles (message_id, emoji, server_id, role_id, mode, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, emoji) DO UPDATE SET role_id = excluded.role_id, mode = excluded.mode`,
+		messageID, emoji, channel.ServerID, req.RoleID, req.Mode, userID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set reaction role"})
+		return
+	}
+	s.markWrite(userID)
+
+	bindings, _ := s.queryReactionRoles("rr.message_id = ? AND rr.emoji = ?", messageID, emoji)
+	var binding interface{}
+	if len(bindings) > 0 {
+		binding = bindings[0]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    binding,
+	})
+}
+
+// handleDeleteReactionRole unbinds an emoji; members keep roles they got
+func (s *Server) handleDeleteReactionRole(c *gin.Context) {
+	messageID, _, ok := s.reactionRoleMessage(c)
+	if !ok {
+		return
+	}
+	result, err := s.db.Exec("DELETE FROM reaction_roles WHERE message_id = ? AND emoji = ?", messageID, c.Param("emoji"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reaction role"})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reaction role not found"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Reaction role deleted successfully",
+	})
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReactionRoles(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "member"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("rr%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Roles %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'roles')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channelID, _ := result.LastInsertId()
+	for name, id := range users {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", id, serverID, name); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO server_roles (server_id, name) VALUES (?, 'Gamer')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	roleID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'Pick your roles')", channelID, users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	messageID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.DELETE("/servers/:id/roles/:roleId", s.handleDeleteServerRole)
+		router.DELETE("/messages/:messageId", s.handleDeleteMessage)
+		router.PUT("/messages/:messageId/reactions/:emoji", s.handleAddReaction)
+		router.DELETE("/messages/:messageId/reactions/:emoji", s.handleRemoveReaction)
+		router.PUT("/messages/:messageId/reaction-roles/:emoji", s.handleSetReactionRole)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	game, stop := url.PathEscape("🎮"), url.PathEscape("🛑")
+	base := fmt.Sprintf("/messages/%d", messageID)
+	binding := fmt.Sprintf(`{"role_id":%d}`, roleID)
+	holds := func() bool {
+		return s.hasServerRole(int(users["member"]), roleID)
+	}
+
+	if w := request("member", "PUT", base+"/reaction-roles/"+game, binding); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to bind roles, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/reaction-roles/"+game, `{"role_id":999999}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a foreign role to be rejected, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/reaction-roles/"+game, fmt.Sprintf(`{"role_id":%d,"mode":"bogus"}`, roleID)); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown mode to be rejected, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/reaction-roles/"+game, binding); w.Code != http.StatusOK {
+		t.Fatalf("Failed to bind role: %d %s", w.Code, w.Body.String())
+	}
+	if w := request("owner", "PUT", base+"/reaction-roles/"+stop, fmt.Sprintf(`{"role_id":%d,"mode":"remove"}`, roleID)); w.Code != http.StatusOK {
+		t.Fatalf("Failed to bind role: %d %s", w.Code, w.Body.String())
+	}
+
+	// Toggle: react grants, unreact removes
+	request("member", "PUT", base+"/reactions/"+game, "")
+	if !holds() {
+		t.Fatal("Expected reacting to grant the role")
+	}
+	request("member", "DELETE", base+"/reactions/"+game, "")
+	if holds() {
+		t.Fatal("Expected unreacting to remove the role")
+	}
+
+	// Remove: react removes, unreact does nothing
+	request("member", "PUT", base+"/reactions/"+game, "")
+	request("member", "PUT", base+"/reactions/"+stop, "")
+	if holds() {
+		t.Fatal("Expected the remove reaction to take the role")
+	}
+	request("member", "DELETE", base+"/reactions/"+stop, "")
+	if holds() {
+		t.Fatal("Expected unreacting a remove binding to leave roles alone")
+	}
+
+	// Deleting the role drops its bindings
+	if w := request("owner", "DELETE", fmt.Sprintf("/servers/%d/roles/%d", serverID, roleID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Failed to delete role: %d", w.Code)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM reaction_roles WHERE message_id = ?", messageID).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("Expected role deletion to drop bindings, got %d (%v)", count, err)
+	}
+
+	// Deleting the message drops its bindings
+	result, err = db.Exec("INSERT INTO server_roles (server_id, name) VALUES (?, 'Artist')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	roleID, _ = result.LastInsertId()
+	if w := request("owner", "PUT", base+"/reaction-roles/"+game, fmt.Sprintf(`{"role_id":%d}`, roleID)); w.Code != http.StatusOK {
+		t.Fatalf("Failed to bind role: %d %s", w.Code, w.Body.String())
+	}
+	if w := request("owner", "DELETE", base, ""); w.Code != http.StatusOK {
+		t.Fatalf("Failed to delete message: %d", w.Code)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM reaction_roles WHERE message_id = ?", messageID).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("Expected message deletion to drop bindings, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"fethur/internal/events"
+	"fethur/internal/snowflake"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReactionEmojis bounds the different emojis on one message
+const maxReactionEmojis = 20
+
+// reactionEvent is one reaction added to or removed from a message
+type reactionEvent struct {
+	ServerID  int
+	ChannelID int
+	MessageID int64
+	UserID    int
+	Emoji     string
+	Added     bool
+}
+
+// validReactionEmoji accepts a Unicode emoji sequence or a :custom_name:
+func validReactionEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 64 {
+		return false
+	}
+	if strings.HasPrefix(emoji, ":") && strings.HasSuffix(emoji, ":") && len(emoji) > 2 {
+		for _, r := range emoji[1 : len(emoji)-1] {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+				return false
+			}
+		}
+		return true
+	}
+	for _, r := range emoji {
+		if r < 0x80 || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// messageReactions returns the reactions on a set of messages, keyed by
+// message ID, each with its count and the users who reacted
+func (s *Server) messageReactions(messageIDs []int64) map[int64][]gin.H {
+	result := make(map[int64][]gin.H)
+	if len(messageIDs) == 0 {
+		return result
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT message_id, emoji, user_id
+		FROM message_reactions
+		WHERE message_id IN (%s)
+		ORDER BY message_id, created_at`, strings.Join(placeholders, ", ")),
+		args...,
+	)
+	if err != nil {
+		log.Printf("Failed to load message reactions: %v", err)
+		return result
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	index := make(map[int64]map[string]int)
+	for rows.Next() {
+		var messageID int64
+		var userID int
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			continue
+		}
+		if index[messageID] == nil {
+			index[messageID] = make(map[string]int)
+		}
+		i, ok := index[messageID][emoji]
+		if !ok {
+			i = len(result[messageID])
+			index[messageID][emoji] = i
+			result[messageID] = append(result[messageID], gin.H{"emoji": emoji, "count": 0, "users": []int{}})
+		}
+		reaction := result[messageID][i]
+		reaction["count"] = reaction["count"].(int) + 1
+		reaction["users"] = append(reaction["users"].([]int), userID)
+	}
+	return result
+}
+
+// handleAddReaction reacts to a message with an emoji
+func (s *Server) handleAddReaction(c *gin.Context) {
+	s.updateReaction(c, true)
+}
+
+// handleRemoveReaction takes the user's reaction back
+func (s *Server) handleRemoveReaction(c *gin.Context) {
+	s.updateReaction(c, false)
+}
+
+func (s *Server) updateReaction(c *gin.Context, add bool) {
+	userID := c.GetInt("user_id")
+	messageID, channel, _, _, ok := s.messageForUser(c)
+	if !ok {
+		return
+	}
+	emoji := c.Param("emoji")
+	if !validReactionEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emoji"})
+		return
+	}
+
+	var changed int64
+	if add {
+		var emojis int
+		if err := s.db.QueryRow(
+			"SELECT COUNT(DISTINCT emoji) FROM message_reactions WHERE message_id = ? AND emoji != ?", messageID, emoji,
+		).Scan(&emojis); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+			return
+		}
+		if emojis >= maxReactionEmojis {
+			c.JSON(http.StatusConflict, gin.H{"error": "Message has too many different reactions"})
+			return
+		}
+		result, err := s.db.Exec(
+			"INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji) VALUES (?, ?, ?)", messageID, userID, emoji,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+			return
+		}
+		changed, _ = result.RowsAffected()
+	} else {
+		result, err := s.db.Exec(
+			"DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+			return
+		}
+		changed, _ = result.RowsAffected()
+	}
+
+	if changed > 0 {
+		s.bumpResourceVersion(messagesResource(channel.ID))
+		s.markWrite(userID)
+		s.dispatchReaction(reactionEvent{
+			ServerID:  channel.ServerID,
+			ChannelID: channel.ID,
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+			Added:     add,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.messageReactions([]int64{messageID})[messageID],
+	})
+}
+
+// dispatchReaction tells the channel about a reaction and runs the
+// features that act on reactions
+func (s *Server) dispatchReaction(event reactionEvent) {
+	messageType := events.TypeReactionAdd
+	if !event.Added {
+		messageType = events.TypeReactionRemove
+	}
+	s.hub.BroadcastMessage(&websocket.Message{
+		Type:      string(messageType),
+		ChannelID: event.ChannelID,
+		UserID:    event.UserID,
+		Timestamp: time.Now(),
+		Data: events.Reaction{
+			MessageID: snowflake.ID(event.MessageID).String(),
+			ChannelID: event.ChannelID,
+			UserID:    event.UserID,
+			Emoji:     event.Emoji,
+		},
+		Audience: s.messageAudience(event.MessageID),
+	})
+
+	s.updateStarboard(event)
+	s.applyReactionRole(event)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Refresh tokens. Sign-ins return a long-lived refresh token next to the
+// JWT, and POST /api/auth/refresh trades it for a new pair before the JWT
+// expires. A refresh token works once: using it replaces it with the next
+// token of its family, and presenting a replaced token again means it was
+// stolen, so the whole family is revoked.
+
+// execer is satisfied by both the database and a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// issueRefreshToken creates a refresh token for a session. An empty
+// family starts a new one.
+func (s *Server) issueRefreshToken(db execer, userID int, deviceID string, tokenVersion int, family string) (string, error) {
+	token, err := s.auth.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	if family == "" {
+		if family, err = s.auth.GenerateRandomString(12); err != nil {
+			return "", err
+		}
+	}
+	var device interface{}
+	if id, err := strconv.Atoi(deviceID); err == nil {
+		device = id
+	}
+
+	// Expired tokens are of no use to anyone
+	if _, err := db.Exec("DELETE FROM refresh_tokens WHERE user_id = ? AND expires_at < ?", userID, time.Now().UTC()); err != nil {
+		log.Printf("Failed to prune refresh tokens of user %d: %v", userID, err)
+	}
+	_, err = db.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, family, device_id, token_version, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, auth.HashRefreshToken(token), family, device, tokenVersion, time.Now().UTC().Add(auth.RefreshTokenTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// revokeRefreshFamily revokes every token descended from the same sign-in
+func (s *Server) revokeRefreshFamily(family string) {
+	if _, err := s.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family = ? AND revoked_at IS NULL", family,
+	); err != nil {
+		log.Printf("Failed to revoke refresh token family: %v", err)
+	}
+}
+
+// revokeRefreshTokens revokes all of a user's refresh tokens
+func (s *Server) revokeRefreshTokens(userID int) error {
+	_, err := s.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID,
+	)
+	return err
+}
+
+// handleRefreshToken trades a refresh token for a new JWT and refresh token
+func (s *Server) handleRefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var id int64
+	var userID, tokenVersion int
+	var family string
+	var deviceID sql.NullInt64
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(
+		`SELECT id, user_id, family, device_id, token_version, expires_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = ?`, auth.HashRefreshToken(req.RefreshToken),
+	).Scan(&id, &userID, &family, &deviceID, &tokenVersion, &expiresAt, &revokedAt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if revokedAt.Valid {
+		log.Printf("Revoked refresh token reused for user %d, revoking its family", userID)
+		s.revokeRefreshFamily(family)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has expired"})
+		return
+	}
+
+	// The session must still be one the user's JWTs would be accepted for
+	var username, role string
+	var currentVersion int
+	if err := s.db.QueryRow(
+		"SELECT username, role, token_version FROM users WHERE id = ?", userID,
+	).Scan(&username, &role, &currentVersion); err != nil || currentVersion != tokenVersion {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+		return
+	}
+	if s.isUserBanned(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+		return
+	}
+	device := ""
+	if deviceID.Valid {
+		device = strconv.FormatInt(deviceID.Int64, 10)
+		if s.isDeviceRevoked(userID, device) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			return
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	result, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Another request used the token first
+		_ = tx.Rollback()
+		s.revokeRefreshFamily(family)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	refreshToken, err := s.issueRefreshToken(tx, userID, device, tokenVersion, family)
+	if err != nil {
+		log.Printf("Failed to rotate refresh token for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	token, err := s.auth.GenerateDeviceToken(userID, username, role, device, tokenVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+	})
+}
+
+// handleLogout revokes the session's refresh token, so it cannot renew the
+// JWT once that expires. Unknown tokens are ignored.
+func (s *Server) handleLogout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var family string
+	err := s.db.QueryRow(
+		"SELECT family FROM refresh_tokens WHERE token_hash = ?", auth.HashRefreshToken(req.RefreshToken),
+	).Scan(&family)
+	if err == nil {
+		s.revokeRefreshFamily(family)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Signed out",
+	})
+}
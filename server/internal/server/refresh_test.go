@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	username := fmt.Sprintf("refresh_%d", time.Now().UnixNano())
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', ?)", username, hash)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", s.handleLogin)
+	router.POST("/auth/refresh", s.handleRefreshToken)
+	router.POST("/auth/logout", s.handleLogout)
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	post := func(path, body string) (*httptest.ResponseRecorder, tokens) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		var got tokens
+		_ = json.Unmarshal(w.Body.Bytes(), &got)
+		return w, got
+	}
+	refresh := func(token string) (*httptest.ResponseRecorder, tokens) {
+		return post("/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, token))
+	}
+	login := func() tokens {
+		w, got := post("/auth/login", fmt.Sprintf(`{"username":%q,"password":"correct-horse-battery"}`, username))
+		if w.Code != http.StatusOK || got.RefreshToken == "" {
+			t.Fatalf("Expected login to return a refresh token, got %d: %s", w.Code, w.Body.String())
+		}
+		return got
+	}
+
+	first := login()
+	w, second := refresh(first.RefreshToken)
+	if w.Code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("Expected the refresh token to be rotated, got %d: %s", w.Code, w.Body.String())
+	}
+	claims, err := s.auth.ValidateToken(second.Token)
+	if err != nil || claims.UserID != int(userID) || claims.DeviceID == "" {
+		t.Errorf("Expected a JWT for the same user and device, got %+v (%v)", claims, err)
+	}
+
+	// Reusing a replaced token revokes the whole family
+	if w, _ := refresh(first.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used refresh token to be refused, got %d", w.Code)
+	}
+	if w, _ := refresh(second.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected reuse to revoke the rest of the family, got %d", w.Code)
+	}
+
+	// Signing out revokes only that session
+	other, kept := login(), login()
+	if w, _ := post("/auth/logout", fmt.Sprintf(`{"refresh_token":%q}`, other.RefreshToken)); w.Code != http.StatusOK {
+		t.Errorf("Expected logout to succeed, got %d", w.Code)
+	}
+	if w, _ := refresh(other.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a signed out refresh token to be refused, got %d", w.Code)
+	}
+	w, kept = refresh(kept.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected other sessions to keep working, got %d", w.Code)
+	}
+
+	// Signing out everywhere ends every session
+	if err := s.invalidateTokens(int(userID), "test"); err != nil {
+		t.Fatalf("Failed to invalidate tokens: %v", err)
+	}
+	if w, _ := refresh(kept.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refresh tokens to be revoked everywhere, got %d", w.Code)
+	}
+}
+
+func TestKickSignsOut(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	previous, _ := db.GetSetting("email_verification")
+	defer func() {
+		_ = db.SetSetting("email_verification", previous, settingDescriptions["email_verification"])
+	}()
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+
+	username := fmt.Sprintf("kicked_%d", time.Now().UnixNano())
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', ?)", username, hash)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", s.handleLogin)
+	router.POST("/auth/refresh", s.handleRefreshToken)
+	router.GET("/probe", s.authMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/admin/users/:id/kick", s.handleKickUser)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/auth/login", strings.NewReader(fmt.Sprintf(`{"username":%q,"password":"correct-horse-battery"}`, username)))
+	r.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, r)
+	var session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil || w.Code != http.StatusOK || session.Token == "" {
+		t.Fatalf("Expected login to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	probe := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/probe", nil)
+		r.Header.Set("Authorization", "Bearer "+session.Token)
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("Expected the token to work before the kick, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/admin/users/%d/kick", userID), strings.NewReader(`{"reason":"cool off"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the kick to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The kicked user cannot carry on with the tokens they held
+	if code := probe(); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old token to be refused after a kick, got %d", code)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token":%q}`, session.RefreshToken)))
+	r.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the refresh token to be revoked after a kick, got %d", w.Code)
+	}
+}
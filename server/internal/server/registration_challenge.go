@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"fethur/internal/challenge"
+
+	"github.com/gin-gonic/gin"
+)
+
+// challengeVerifyTimeout bounds each request to a CAPTCHA service
+const challengeVerifyTimeout = 10 * time.Second
+
+// registrationChallengeTypes are the values of registration_challenge
+var registrationChallengeTypes = map[string]bool{
+	"off":       true,
+	"pow":       true,
+	"hcaptcha":  true,
+	"turnstile": true,
+}
+
+// captchaChallenge reports whether a challenge type is verified by an
+// outside service with a site key and secret
+func captchaChallenge(kind string) bool {
+	return kind == "hcaptcha" || kind == "turnstile"
+}
+
+// registrationChallenge returns the challenge self-registration has to
+// pass, or nil when registration_challenge is off
+func (s *Server) registrationChallenge() challenge.Provider {
+	kind, _ := s.db.GetSetting("registration_challenge")
+	siteKey, _ := s.db.GetSetting("registration_challenge_site_key")
+	secret, _ := s.db.GetSetting("registration_challenge_secret")
+	switch kind {
+	case "pow":
+		return challenge.NewProofOfWork(s.auth, s.getIntSetting("registration_pow_difficulty", challenge.DefaultDifficulty), s.challenges)
+	case "hcaptcha":
+		return challenge.NewHCaptcha(siteKey, secret, s.fetcher.Client(challengeVerifyTimeout))
+	case "turnstile":
+		return challenge.NewTurnstile(siteKey, secret, s.fetcher.Client(challengeVerifyTimeout))
+	}
+	return nil
+}
+
+// handleGetRegistrationChallenge tells the register form which challenge
+// to show: a CAPTCHA's site key, or a fresh proof-of-work puzzle
+func (s *Server) handleGetRegistrationChallenge(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	provider := s.registrationChallenge()
+	if provider == nil {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"type": "off"}})
+		return
+	}
+
+	issued, err := provider.Issue(time.Now())
+	if err != nil {
+		log.Printf("Failed to issue a %s challenge: %v", provider.Name(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue a challenge"})
+		return
+	}
+	issued["type"] = provider.Name()
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": issued})
+}
+
+// checkRegistrationChallenge verifies the solution sent with a
+// registration, answering and returning false when it does not pass
+func (s *Server) checkRegistrationChallenge(c *gin.Context, solution *challenge.Solution) bool {
+	provider := s.registrationChallenge()
+	if provider == nil {
+		return true
+	}
+	if solution == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Complete the challenge to register", "code": "challenge_required"})
+		return false
+	}
+
+	err := provider.Verify(c.Request.Context(), *solution, c.ClientIP())
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, challenge.ErrFailed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "The challenge was not solved", "code": "challenge_failed"})
+	default:
+		log.Printf("Failed to verify a %s challenge: %v", provider.Name(), err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The challenge could not be checked, try again later"})
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/challenge"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegistrationChallenge(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+	s.challenges = challenge.NewSpent()
+
+	for _, key := range []string{"auth_mode", "email_verification", "registration_challenge", "registration_pow_difficulty"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("auth_mode", "public", settingDescriptions["auth_mode"])
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+	_ = db.SetSetting("registration_challenge", "pow", settingDescriptions["registration_challenge"])
+	_ = db.SetSetting("registration_pow_difficulty", "8", settingDescriptions["registration_pow_difficulty"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/auth/challenge", s.handleGetRegistrationChallenge)
+	router.POST("/auth/register", s.handleRegister)
+	register := func(solution string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"username":"pow_%d","password":"Sturdy-Passw0rd!"%s}`, time.Now().UnixNano(), solution)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/auth/register", strings.NewReader(body)))
+		return w
+	}
+
+	if w := register(""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "challenge_required") {
+		t.Errorf("Expected registering without a solution to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/challenge", nil))
+	var issued struct {
+		Data struct {
+			Type       string `json:"type"`
+			Challenge  string `json:"challenge"`
+			Difficulty int    `json:"difficulty"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil || issued.Data.Type != "pow" || issued.Data.Difficulty != 8 {
+		t.Fatalf("Expected a proof-of-work puzzle, got %d: %s", w.Code, w.Body.String())
+	}
+
+	nonce := 0
+	for !challenge.Solves(issued.Data.Challenge, strconv.Itoa(nonce), issued.Data.Difficulty) {
+		nonce++
+	}
+	solution := fmt.Sprintf(`,"challenge":{"challenge":%q,"nonce":"%d"}`, issued.Data.Challenge, nonce)
+	if w := register(solution); w.Code != http.StatusCreated {
+		t.Fatalf("Expected a solved puzzle to register, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := register(solution); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "challenge_failed") {
+		t.Errorf("Expected a puzzle to work only once, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_ = db.SetSetting("registration_challenge", "off", settingDescriptions["registration_challenge"])
+	if w := register(""); w.Code != http.StatusCreated {
+		t.Errorf("Expected registration without a challenge when off, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/mail"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registration approval. With registration_approval on, accounts that sign
+// up, or are created at first sign-in with an OpenID Connect provider,
+// cannot sign in until an admin who manages users approves them. Rejecting
+// a registration deletes the account, freeing its username.
+
+// pendingRegistration is an account waiting for approval
+type pendingRegistration struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	OrgID     int       `json:"org_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// notifyRegistrationReviewers sends a message to the connected admins
+// who can approve registrations in an organization
+func (s *Server) notifyRegistrationReviewers(orgID int, message *websocket.Message) {
+	rows, err := s.db.Query("SELECT id FROM users WHERE role NOT IN ('user', 'guest') AND (org_id = 0 OR org_id = ?)", orgID)
+	if err != nil {
+		log.Printf("Failed to load registration reviewers: %v", err)
+		return
+	}
+	var reviewers []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			reviewers = append(reviewers, id)
+		}
+	}
+	_ = rows.Close()
+
+	for _, id := range reviewers {
+		s.clientsMux.RLock()
+		client, ok := s.clients[id]
+		s.clientsMux.RUnlock()
+		if ok && s.hasCapability(id, capManageUsers) {
+			client.Send(message)
+		}
+	}
+}
+
+// notifyPendingRegistration tells reviewers about a new registration
+func (s *Server) notifyPendingRegistration(userID, orgID int) {
+	var registration pendingRegistration
+	err := s.db.QueryRow(
+		"SELECT id, username, COALESCE(email, ''), org_id, created_at FROM users WHERE id = ?", userID,
+	).Scan(&registration.ID, &registration.Username, &registration.Email, &registration.OrgID, &registration.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to load pending registration %d: %v", userID, err)
+		return
+	}
+	s.notifyRegistrationReviewers(orgID, &websocket.Message{
+		Type:      string(events.TypeNotification),
+		Content:   registration.Username + " is waiting for approval",
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"kind":         "registration_pending",
+			"registration": registration,
+		},
+	})
+}
+
+// handleGetPendingRegistrations lists the registrations waiting for
+// approval, oldest first, in the admin's organization
+func (s *Server) handleGetPendingRegistrations(c *gin.Context) {
+	orgID := c.GetInt("org_id")
+	rows, err := s.db.Query(`
+		SELECT id, username, COALESCE(email, ''), org_id, created_at FROM users
+		WHERE pending_approval = 1 AND (? = 0 OR org_id = ?)
+		ORDER BY created_at, id`, orgID, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get registrations"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	registrations := make([]pendingRegistration, 0)
+	for rows.Next() {
+		var r pendingRegistration
+		if err := rows.Scan(&r.ID, &r.Username, &r.Email, &r.OrgID, &r.CreatedAt); err != nil {
+			continue
+		}
+		registrations = append(registrations, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": registrations})
+}
+
+// handleReviewRegistration approves or rejects a pending registration.
+// The applicant is told by email when they gave an address.
+func (s *Server) handleReviewRegistration(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+
+		var username, email string
+		var orgID int
+		err = s.db.QueryRow(
+			"SELECT username, COALESCE(email, ''), org_id FROM users WHERE id = ? AND pending_approval = 1", userID,
+		).Scan(&username, &email, &orgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Registration not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get registration"})
+			return
+		}
+
+		// Only a registration still pending is decided, so two admins
+		// deciding at once cannot both act
+		query, action := "UPDATE users SET pending_approval = 0 WHERE id = ? AND pending_approval = 1", "approve_registration"
+		if !approve {
+			query, action = "DELETE FROM users WHERE id = ? AND pending_approval = 1", "reject_registration"
+		}
+		result, err := s.db.Exec(query, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review registration"})
+			return
+		}
+		if decided, _ := result.RowsAffected(); decided == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Registration not found"})
+			return
+		}
+
+		details := fmt.Sprintf("Approved the registration of %s (ID: %d)", username, userID)
+		if !approve {
+			details = fmt.Sprintf("Rejected the registration of %s (ID: %d)", username, userID)
+			if req.Reason != "" {
+				details += ": " + req.Reason
+			}
+		}
+		s.logAdminAction(c.GetInt("user_id"), action, details)
+
+		if email != "" && s.mailer != nil {
+			// The account is gone after a rejection, so the delivery is
+			// recorded without a user
+			recipient := userID
+			if !approve {
+				recipient = 0
+			}
+			go func() {
+				_ = s.sendEmail(context.Background(), recipient, email, mail.TemplateRegistration, map[string]interface{}{
+					"Username": username,
+					"Approved": approve,
+					"Reason":   req.Reason,
+				})
+			}()
+		}
+
+		// Other reviewers drop the registration from their queue
+		s.notifyRegistrationReviewers(orgID, &websocket.Message{
+			Type:      string(events.TypeNotification),
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"kind":     "registration_reviewed",
+				"user_id":  userID,
+				"approved": approve,
+			},
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    gin.H{"user_id": userID, "username": username, "approved": approve},
+		})
+	}
+}
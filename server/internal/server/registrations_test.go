@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegistrationApproval(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"auth_mode", "email_verification", "registration_challenge", "registration_approval"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("auth_mode", "public", settingDescriptions["auth_mode"])
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+	_ = db.SetSetting("registration_challenge", "off", settingDescriptions["registration_challenge"])
+	_ = db.SetSetting("registration_approval", "true", settingDescriptions["registration_approval"])
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", s.handleRegister)
+	router.POST("/auth/login", s.handleLogin)
+	admin := router.Group("/admin", func(c *gin.Context) {
+		c.Set("user_id", 0)
+		c.Set("org_id", 0)
+	})
+	admin.GET("/registrations", s.handleGetPendingRegistrations)
+	admin.POST("/registrations/:id/approve", s.handleReviewRegistration(true))
+	admin.POST("/registrations/:id/reject", s.handleReviewRegistration(false))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+	credentials := func(username string) string {
+		return fmt.Sprintf(`{"username":%q,"password":"Sturdy-Passw0rd!"}`, username)
+	}
+	register := func(username string) int {
+		w := request("POST", "/auth/register", credentials(username))
+		var registered struct {
+			ApprovalRequired bool   `json:"approval_required"`
+			Token            string `json:"token"`
+			User             struct {
+				ID int `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &registered); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("Failed to register %s, got %d: %s", username, w.Code, w.Body.String())
+		}
+		if !registered.ApprovalRequired || registered.Token != "" {
+			t.Errorf("Expected %s to wait for approval without a token: %s", username, w.Body.String())
+		}
+		return registered.User.ID
+	}
+	pending := func(userID int) bool {
+		w := request("GET", "/admin/registrations", "")
+		var listed struct {
+			Data []pendingRegistration `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Failed to list registrations, got %d: %s", w.Code, w.Body.String())
+		}
+		for _, r := range listed.Data {
+			if r.ID == userID {
+				return true
+			}
+		}
+		return false
+	}
+
+	approved := fmt.Sprintf("pending_%d", time.Now().UnixNano())
+	userID := register(approved)
+	if w := request("POST", "/auth/login", credentials(approved)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "approval_pending") {
+		t.Errorf("Expected a pending account to be refused sign-in, got %d: %s", w.Code, w.Body.String())
+	}
+	if !pending(userID) {
+		t.Fatal("Expected the registration in the queue")
+	}
+	if w := request("POST", fmt.Sprintf("/admin/registrations/%d/approve", userID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Failed to approve, got %d: %s", w.Code, w.Body.String())
+	}
+	if pending(userID) {
+		t.Error("Expected an approved registration to leave the queue")
+	}
+	if w := request("POST", fmt.Sprintf("/admin/registrations/%d/approve", userID), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a decided registration to be gone, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", "/auth/login", credentials(approved)); w.Code != http.StatusOK {
+		t.Errorf("Expected an approved account to sign in, got %d: %s", w.Code, w.Body.String())
+	}
+
+	rejected := fmt.Sprintf("pending_%d", time.Now().UnixNano())
+	userID = register(rejected)
+	if w := request("POST", fmt.Sprintf("/admin/registrations/%d/reject", userID), `{"reason":"spam"}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to reject, got %d: %s", w.Code, w.Body.String())
+	}
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", rejected).Scan(&count)
+	if count != 0 {
+		t.Error("Expected a rejected registration to be deleted")
+	}
+
+	_ = db.SetSetting("registration_approval", "false", settingDescriptions["registration_approval"])
+	open := fmt.Sprintf("open_%d", time.Now().UnixNano())
+	if w := request("POST", "/auth/register", credentials(open)); w.Code != http.StatusCreated || strings.Contains(w.Body.String(), `"approval_required":true`) {
+		t.Errorf("Expected registration without approval when off, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readAfterWriteWindow is how long a user's reads stay on the primary after
+// they write, covering replication lag so they see their own messages
+const readAfterWriteWindow = 10 * time.Second
+
+// readConsistencyHeader lets a client force a read onto the primary with
+// "X-Read-Consistency: strong"
+const readConsistencyHeader = "X-Read-Consistency"
+
+// recentWriters remembers users who wrote recently
+type recentWriters struct {
+	mutex sync.Mutex
+	until map[int]time.Time
+}
+
+func newRecentWriters() *recentWriters {
+	return &recentWriters{until: make(map[int]time.Time)}
+}
+
+func (w *recentWriters) mark(userID int) {
+	now := time.Now()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.until[userID] = now.Add(readAfterWriteWindow)
+	// Drop expired entries so the map stays bounded by active writers
+	for id, until := range w.until {
+		if now.After(until) {
+			delete(w.until, id)
+		}
+	}
+}
+
+func (w *recentWriters) recent(userID int) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return time.Now().Before(w.until[userID])
+}
+
+// markWrite sends the user's reads to the primary for a short while
+func (s *Server) markWrite(userID int) {
+	if s.recentWrites != nil {
+		s.recentWrites.mark(userID)
+	}
+}
+
+// reader returns the pool for a read-only handler's queries: a replica
+// unless the request asks for strong consistency or the user just wrote
+func (s *Server) reader(c *gin.Context) *sql.DB {
+	requirePrimary := strings.EqualFold(c.GetHeader(readConsistencyHeader), "strong")
+	if s.recentWrites != nil && s.recentWrites.recent(c.GetInt("user_id")) {
+		requirePrimary = true
+	}
+	return s.db.Reader(requirePrimary)
+}
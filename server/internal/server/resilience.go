@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"fethur/internal/fetch"
+	"fethur/internal/resilience"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Integration names, as reported by the integrations health endpoint
+const (
+	integrationMail     = "mail"
+	integrationWebhooks = "webhooks"
+	integrationXMPP     = "bridge:xmpp"
+)
+
+// pushIntegration names the integration of one push platform
+func pushIntegration(platform string) string {
+	return "push:" + platform
+}
+
+// Policies for the external services the server calls
+var (
+	// Email is mostly sent while a request waits, so retries are brief
+	mailPolicy = resilience.Policy{
+		Attempts:         3,
+		Backoff:          resilience.Backoff{Base: 500 * time.Millisecond, Max: 2 * time.Second},
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+		MaxConcurrent:    8,
+	}
+
+	// Push notifications are sent by background jobs
+	pushPolicy = resilience.Policy{
+		Attempts:         3,
+		Backoff:          resilience.Backoff{Base: time.Second, Max: 10 * time.Second},
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+		MaxConcurrent:    4,
+	}
+
+	// Webhook receivers each have a circuit in the fetcher, so that one
+	// failing receiver does not hold up deliveries to the others
+	webhookPolicy = resilience.Policy{
+		Attempts:      3,
+		Backoff:       resilience.Backoff{Base: 2 * time.Second, Max: 30 * time.Second},
+		MaxConcurrent: 8,
+	}
+
+	// The bridge reconnects by itself; its circuit reflects the connection
+	xmppPolicy = resilience.Policy{
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+	}
+)
+
+// webhookError marks the webhook failures that retrying cannot fix: a
+// receiver that rejected the request, and a destination that is refused
+// or resting
+func webhookError(err error) error {
+	var statusErr *webhooks.StatusError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &statusErr):
+		if statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusRequestTimeout && statusErr.StatusCode != http.StatusTooManyRequests {
+			return resilience.Permanent(err)
+		}
+	case errors.Is(err, fetch.ErrBlocked), errors.Is(err, fetch.ErrCircuitOpen), errors.Is(err, fetch.ErrTooLarge):
+		return resilience.Permanent(err)
+	}
+	return err
+}
+
+// registerIntegrations creates the guards of the configured integrations
+// up front, so they are reported before their first call
+func (s *Server) registerIntegrations() {
+	if s.mailer != nil {
+		s.integrations.Guard(integrationMail, mailPolicy)
+	}
+	if s.push != nil {
+		for _, platform := range s.push.Platforms() {
+			s.integrations.Guard(pushIntegration(platform), pushPolicy)
+		}
+	}
+	s.integrations.Guard(integrationWebhooks, webhookPolicy)
+}
+
+// handleGetIntegrationsHealth reports the circuit, retries and load of
+// every external integration, and the webhook destinations that have been
+// failing
+func (s *Server) handleGetIntegrationsHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"integrations": s.integrations.Health(),
+			"destinations": s.fetcher.Health(),
+		},
+	})
+}
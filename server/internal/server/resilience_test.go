@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fethur/internal/fetch"
+	"fethur/internal/resilience"
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookError(t *testing.T) {
+	for err, permanent := range map[error]bool{
+		&webhooks.StatusError{StatusCode: 400}:       true,
+		&webhooks.StatusError{StatusCode: 404}:       true,
+		&webhooks.StatusError{StatusCode: 429}:       false,
+		&webhooks.StatusError{StatusCode: 503}:       false,
+		fmt.Errorf("%w: 10.0.0.1", fetch.ErrBlocked): true,
+		fetch.ErrCircuitOpen:                         true,
+		errors.New("connection reset"):               false,
+	} {
+		if got := resilience.IsPermanent(webhookError(err)); got != permanent {
+			t.Errorf("webhookError(%v) permanent = %v, want %v", err, got, permanent)
+		}
+	}
+}
+
+func TestIntegrationsHealth(t *testing.T) {
+	s := &Server{fetcher: fetch.New(fetch.Config{}), integrations: resilience.NewRegistry()}
+	s.registerIntegrations()
+	s.integrations.Guard(integrationXMPP, xmppPolicy).Record(errors.New("connection refused"))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin/integrations/health", s.handleGetIntegrationsHealth)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/integrations/health", nil))
+
+	var resp struct {
+		Data struct {
+			Integrations []resilience.Health `json:"integrations"`
+			Destinations []resilience.Health `json:"destinations"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected health, got %d: %s", w.Code, w.Body.String())
+	}
+	integrations := resp.Data.Integrations
+	if len(integrations) != 2 || integrations[0].Name != integrationXMPP || integrations[1].Name != integrationWebhooks {
+		t.Fatalf("Expected the bridge and webhooks, got %+v", integrations)
+	}
+	if bridge := integrations[0]; bridge.State != resilience.StateClosed || bridge.Failures != 1 || bridge.LastError != "connection refused" {
+		t.Errorf("Unexpected bridge health %+v", bridge)
+	}
+	if integrations[1].MaxConcurrent != webhookPolicy.MaxConcurrent || resp.Data.Destinations == nil {
+		t.Errorf("Unexpected health %s", w.Body.String())
+	}
+}
@@ -0,0 +1,477 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roleColorPattern matches the optional #rrggbb role color
+var roleColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// maxServerRoles bounds the roles one server can define
+const maxServerRoles = 100
+
+// maxRolePosition bounds role positions
+const maxRolePosition = 1000
+
+// serverRoleInfo is a named server role. Roles with a higher position
+// outrank lower ones; manage_roles lets holders hand out lower roles.
+// Version is the edit version, where loaded.
+type serverRoleInfo struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Position    int    `json:"position"`
+	ManageRoles bool   `json:"manage_roles"`
+	Members     int    `json:"members"`
+	Version     int64  `json:"version,omitempty"`
+}
+
+// loadServerRole loads a role with its version
+func (s *Server) loadServerRole(roleID int64) (serverRoleInfo, error) {
+	var role serverRoleInfo
+	err := s.db.QueryRow(
+		"SELECT id, name, color, position, manage_roles, version FROM server_roles WHERE id = ?", roleID,
+	).Scan(&role.ID, &role.Name, &role.Color, &role.Position, &role.ManageRoles, &role.Version)
+	return role, err
+}
+
+// hasServerRole reports whether the user holds a role
+func (s *Server) hasServerRole(userID int, roleID int64) bool {
+	var exists bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM member_roles WHERE user_id = ? AND role_id = ?)", userID, roleID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// grantMemberRole gives a member a role and reports whether they lacked it
+func (s *Server) grantMemberRole(serverID, userID int, roleID int64) (bool, error) {
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO member_roles (server_id, user_id, role_id) VALUES (?, ?, ?)", serverID, userID, roleID,
+	)
+	if err != nil {
+		return false, err
+	}
+	changed, _ := result.RowsAffected()
+	if changed > 0 {
+		s.memberRolesChanged(serverID)
+	}
+	return changed > 0, nil
+}
+
+// revokeMemberRole takes a role from a member and reports whether they had it
+func (s *Server) revokeMemberRole(serverID, userID int, roleID int64) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM member_roles WHERE user_id = ? AND role_id = ?", userID, roleID)
+	if err != nil {
+		return false, err
+	}
+	changed, _ := result.RowsAffected()
+	if changed > 0 {
+		s.memberRolesChanged(serverID)
+	}
+	return changed > 0, nil
+}
+
+// memberRolesChanged invalidates what depends on who holds which role;
+// roles can open private channels
+func (s *Server) memberRolesChanged(serverID int) {
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.bumpResourceVersion(membersResource(serverID))
+}
+
+// findServerRole checks a role ID belongs to a server
+func (s *Server) findServerRole(serverID int, roleID string) (int64, bool) {
+	var id int64
+	err := s.db.QueryRow("SELECT id FROM server_roles WHERE id = ? AND server_id = ?", roleID, serverID).Scan(&id)
+	return id, err == nil
+}
+
+// memberTopRole returns the highest position among a member's roles, -1
+// without any, and whether any of their roles manages roles
+func (s *Server) memberTopRole(serverID, userID int) (top int, manage bool) {
+	err := s.db.QueryRow(`
+		SELECT COALESCE(MAX(r.position), -1), COALESCE(MAX(r.manage_roles), 0)
+		FROM member_roles mr
+		JOIN server_roles r ON r.id = mr.role_id
+		WHERE mr.server_id = ? AND mr.user_id = ?`, serverID, userID,
+	).Scan(&top, &manage)
+	if err != nil {
+		return -1, false
+	}
+	return top, manage
+}
+
+// checkRoleAssignment applies the role hierarchy to granting or revoking a
+// role, returning why it is refused. Owners change anyone's roles and
+// admins anyone's but owners' and other admins'. Other members need a
+// role that manages roles, and then only handle roles below their highest
+// one, for themselves or for members whose highest role is below it too.
+func (s *Server) checkRoleAssignment(serverID, actorID, targetID int, roleID int64) (string, bool) {
+	actorRank, _ := s.serverRole(actorID, serverID)
+	targetRank, _ := s.serverRole(targetID, serverID)
+	switch actorRank {
+	case "owner":
+		return "", true
+	case "admin":
+		if targetID != actorID && (targetRank == "owner" || targetRank == "admin") {
+			return "Cannot change the roles of owners or other admins", false
+		}
+		return "", true
+	}
+
+	top, manage := s.memberTopRole(serverID, actorID)
+	if !manage {
+		return "Insufficient permissions", false
+	}
+	var position int
+	if err := s.db.QueryRow("SELECT position FROM server_roles WHERE id = ?", roleID).Scan(&position); err != nil || position >= top {
+		return "Role must be below your highest role", false
+	}
+	if targetID != actorID {
+		if targetRank != "member" {
+			return "Cannot change the roles of owners or admins", false
+		}
+		if targetTop, _ := s.memberTopRole(serverID, targetID); targetTop >= top {
+			return "Member's highest role must be below yours", false
+		}
+	}
+	return "", true
+}
+
+// roleServerID is memberServerID, restricted to owners and admins when
+// manage is set
+func (s *Server) roleServerID(c *gin.Context, manage bool) (int, bool) {
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return 0, false
+	}
+	if manage && role != "owner" && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return 0, false
+	}
+	return serverID, true
+}
+
+// handleGetServerRoles lists a server's roles with how many members hold each
+func (s *Server) handleGetServerRoles(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, false)
+	if !ok {
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT r.id, r.name, r.color, r.position, r.manage_roles, (SELECT COUNT(*) FROM member_roles mr WHERE mr.role_id = r.id), r.version
+		FROM server_roles r
+		WHERE r.server_id = ?
+		ORDER BY r.position DESC, r.name`, serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	roles := make([]serverRoleInfo, 0)
+	for rows.Next() {
+		var role serverRoleInfo
+		if err := rows.Scan(&role.ID, &role.Name, &role.Color, &role.Position, &role.ManageRoles, &role.Members, &role.Version); err == nil {
+			roles = append(roles, role)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    roles,
+	})
+}
+
+// handleCreateServerRole adds a named role; owners and admins only
+func (s *Server) handleCreateServerRole(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Color       string `json:"color"`
+		Position    int    `json:"position"`
+		ManageRoles bool   `json:"manage_roles"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-50 characters"})
+		return
+	}
+	if req.Color != "" && !roleColorPattern.MatchString(req.Color) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "color must be #rrggbb"})
+		return
+	}
+	if req.Position < 0 || req.Position > maxRolePosition {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must be between 0 and 1000"})
+		return
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM server_roles WHERE server_id = ?", serverID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+	if count >= maxServerRoles {
+		c.JSON(http.StatusConflict, gin.H{"error": "Server has too many roles"})
+		return
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO server_roles (server_id, name, color, position, manage_roles) VALUES (?, ?, ?, ?, ?)",
+		serverID, req.Name, req.Color, req.Position, req.ManageRoles,
+	)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    serverRoleInfo{ID: id, Name: req.Name, Color: req.Color, Position: req.Position, ManageRoles: req.ManageRoles, Version: 1},
+	})
+}
+
+// handleUpdateServerRole renames, recolors or moves a role; owners and
+// admins only. The edit must name the version it was made against.
+func (s *Server) handleUpdateServerRole(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	roleID, found := s.findServerRole(serverID, c.Param("roleId"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	role, err := s.loadServerRole(roleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	var req struct {
+		Name        *string `json:"name"`
+		Color       *string `json:"color"`
+		Position    *int    `json:"position"`
+		ManageRoles *bool   `json:"manage_roles"`
+		Version     *int64  `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		role.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Color != nil {
+		role.Color = *req.Color
+	}
+	if req.Position != nil {
+		role.Position = *req.Position
+	}
+	if req.ManageRoles != nil {
+		role.ManageRoles = *req.ManageRoles
+	}
+	if role.Name == "" || utf8.RuneCountInString(role.Name) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-50 characters"})
+		return
+	}
+	if role.Color != "" && !roleColorPattern.MatchString(role.Color) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "color must be #rrggbb"})
+		return
+	}
+	if role.Position < 0 || role.Position > maxRolePosition {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must be between 0 and 1000"})
+		return
+	}
+	if role.ManageRoles {
+		var auto bool
+		if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM server_auto_roles WHERE role_id = ?)", roleID).Scan(&auto); err == nil && auto {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Auto roles cannot manage roles"})
+			return
+		}
+	}
+
+	if !checkVersion(c, req.Version, role.Version, role) {
+		return
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE server_roles SET name = ?, color = ?, position = ?, manage_roles = ?, version = version + 1 WHERE id = ? AND version = ?",
+		role.Name, role.Color, role.Position, role.ManageRoles, roleID, role.Version,
+	)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		if current, err := s.loadServerRole(roleID); err == nil {
+			versionConflict(c, current.Version, current)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	role.Version++
+	s.memberRolesChanged(serverID)
+	s.markWrite(c.GetInt("user_id"))
+
+	c.Header("ETag", versionETag(role.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    role,
+	})
+}
+
+// handleDeleteServerRole removes a role from the server and its members
+func (s *Server) handleDeleteServerRole(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	roleID, found := s.findServerRole(serverID, c.Param("roleId"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	// Foreign keys are not enforced; clear references by hand
+	cleanup := []string{
+		"DELETE FROM member_roles WHERE role_id = ?",
+		"UPDATE channels SET access_role_id = NULL WHERE access_role_id = ?",
+		"UPDATE ticket_settings SET support_role_id = NULL WHERE support_role_id = ?",
+		"DELETE FROM xp_role_rewards WHERE role_id = ?",
+		"DELETE FROM reaction_roles WHERE role_id = ?",
+		"DELETE FROM server_auto_roles WHERE role_id = ?",
+		"DELETE FROM server_roles WHERE id = ?",
+	}
+	for _, query := range cleanup {
+		if _, err := s.db.Exec(query, roleID); err != nil {
+			log.Printf("Failed to delete role %d: %v", roleID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+	}
+	s.memberRolesChanged(serverID)
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Role deleted successfully",
+	})
+}
+
+// handleGrantMemberRole gives a member a role
+func (s *Server) handleGrantMemberRole(c *gin.Context) {
+	s.updateMemberRole(c, true)
+}
+
+// handleRevokeMemberRole takes a role away from a member
+func (s *Server) handleRevokeMemberRole(c *gin.Context) {
+	s.updateMemberRole(c, false)
+}
+
+func (s *Server) updateMemberRole(c *gin.Context, grant bool) {
+	serverID, ok := s.roleServerID(c, false)
+	if !ok {
+		return
+	}
+	roleID, found := s.findServerRole(serverID, c.Param("roleId"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, member := s.serverRole(memberID, serverID); !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if reason, allowed := s.checkRoleAssignment(serverID, c.GetInt("user_id"), memberID, roleID); !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		return
+	}
+
+	if grant {
+		_, err = s.grantMemberRole(serverID, memberID, roleID)
+	} else {
+		_, err = s.revokeMemberRole(serverID, memberID, roleID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member roles"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Member roles updated successfully",
+	})
+}
+
+// handleGetMemberRoles lists the roles a member holds, highest first
+func (s *Server) handleGetMemberRoles(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, false)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, member := s.serverRole(memberID, serverID); !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT r.id, r.name, r.color, r.position, r.manage_roles
+		FROM member_roles mr
+		JOIN server_roles r ON r.id = mr.role_id
+		WHERE mr.server_id = ? AND mr.user_id = ?
+		ORDER BY r.position DESC, r.name`, serverID, memberID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get member roles"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	roles := make([]serverRoleInfo, 0)
+	for rows.Next() {
+		var role serverRoleInfo
+		if err := rows.Scan(&role.ID, &role.Name, &role.Color, &role.Position, &role.ManageRoles); err == nil {
+			roles = append(roles, role)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    roles,
+	})
+}
@@ -1,47 +1,180 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"fethur/internal/auth"
+	"fethur/internal/challenge"
 	"fethur/internal/database"
+	"fethur/internal/events"
+	"fethur/internal/fetch"
+	"fethur/internal/geoip"
+	"fethur/internal/jobs"
+	"fethur/internal/mail"
+	"fethur/internal/media"
+	"fethur/internal/plugins"
+	"fethur/internal/push"
+	"fethur/internal/resilience"
+	"fethur/internal/service"
+	"fethur/internal/snowflake"
+	"fethur/internal/storage"
+	"fethur/internal/store"
+	"fethur/internal/update"
 	"fethur/internal/voice"
 	"fethur/internal/websocket"
+	"fethur/internal/webui"
+	"fethur/internal/wscompress"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 type Server struct {
-	db         *database.Database
-	auth       *auth.Service
-	hub        *websocket.Hub
-	voiceHub   *voice.VoiceHub
-	router     *gin.Engine
-	clients    map[int]*websocket.Client
-	clientsMux sync.RWMutex
+	db            *database.Database
+	auth          *auth.Service
+	plugins       *plugins.Manager
+	storage       storage.Backend
+	fetcher       *fetch.Fetcher
+	integrations  *resilience.Registry
+	services      *service.Container
+	jobs          *jobs.Queue
+	mailer        *mail.Mailer
+	push          *push.Gateway
+	xmpp          *xmppGateway
+	recentWrites  *recentWriters
+	joinRates     *joinTracker
+	totpAttempts  *rateLimiter
+	loginFailures *loginGuard
+	challenges    *challenge.Spent
+	usage         *usageTracker
+	requests      *requestCounter
+	debug         *debugCapture
+	oidc          oidcCache
+	maintenance   *database.Maintainer
+	standby       *database.Standby
+	geo           *geoip.Databases
+	updates       *update.Checker
+	hub           *websocket.Hub
+	voiceHub      *voice.VoiceHub
+	router        *gin.Engine
+	basePath      string
+	publicURL     string
+	clients       map[int]*websocket.Client
+	clientsMux    sync.RWMutex
 }
 
-func New(db *database.Database, auth *auth.Service) *Server {
+func New(db *database.Database, auth *auth.Service, services *service.Container, pluginManager *plugins.Manager, storageBackend storage.Backend, mailer *mail.Mailer, pushGateway *push.Gateway) *Server {
 	hub := websocket.NewHub()
 	voiceHub := voice.NewVoiceHub()
 
 	server := &Server{
-		db:       db,
-		auth:     auth,
-		hub:      hub,
-		voiceHub: voiceHub,
-		router:   gin.Default(),
-		clients:  make(map[int]*websocket.Client),
+		db:            db,
+		auth:          auth,
+		plugins:       pluginManager,
+		storage:       storageBackend,
+		fetcher:       fetch.New(OutboundConfig()),
+		integrations:  resilience.NewRegistry(),
+		services:      services,
+		jobs:          jobs.NewQueue(2, 256, 5*time.Minute),
+		mailer:        mailer,
+		push:          pushGateway,
+		recentWrites:  newRecentWriters(),
+		joinRates:     newJoinTracker(),
+		totpAttempts:  newRateLimiter(maxTwoFactorAttempts, twoFactorWindow),
+		loginFailures: newLoginGuard(),
+		challenges:    challenge.NewSpent(),
+		usage:         newUsageTracker(),
+		requests:      &requestCounter{},
+		debug:         newDebugCapture(),
+		updates:       newUpdateChecker(),
+		hub:           hub,
+		voiceHub:      voiceHub,
+		router:        gin.Default(),
+		basePath:      BasePath(),
+		publicURL:     PublicURL(),
+		clients:       make(map[int]*websocket.Client),
 	}
 
 	server.setupRoutes()
+	server.registerIntegrations()
+
+	// Plugins with network access make requests through the same outbound
+	// policy as webhooks
+	if pluginManager != nil {
+		pluginManager.SetHTTPClient(server.fetcher.Client(pluginHTTPTimeout))
+	}
+
+	// Compress large WebSocket messages unless configured otherwise
+	compression := WebSocketCompression()
+	hub.SetCompression(compression)
+	voiceHub.SetCompression(compression)
+
+	// Fold bursts of optional events into one frame per channel
+	if compaction, err := WebSocketCompaction(); err != nil {
+		log.Printf("Ignoring FETHUR_WS_COMPACTION: %v", err)
+	} else {
+		hub.SetCompaction(compaction)
+	}
+
+	// Validate realtime channel IDs against the channels table
+	hub.SetChannelAuthorizer(server.validateTextChannel)
+
+	// Large servers send their member list in chunks over the socket
+	hub.SetMemberLister(server.listServerMembers)
+	voiceHub.SetChannelValidator(server.validateVoiceChannel)
+
+	// Join-to-create channels hand out temporary voice channels, deleted
+	// when they empty
+	voiceHub.SetJoinRedirect(server.routeVoiceJoin)
+	voiceHub.SetChannelEmptiedHandler(server.voiceChannelEmptied)
+
+	// Client IPs are located offline when GeoIP databases are configured
+	if paths := GeoIPDatabases(); len(paths) > 0 {
+		if geo, err := geoip.Load(paths); err != nil {
+			log.Printf("Ignoring FETHUR_GEOIP_DB: %v", err)
+		} else {
+			server.geo = geo
+		}
+	}
+
+	// Load the password policy from settings
+	server.applyPasswordPolicy()
+
+	// Load the failed sign-in lockout policy from settings
+	server.applyLoginLockoutPolicy()
+
+	// Apply voice idle policy from settings
+	server.applyVoiceIdlePolicy()
+
+	server.maintenance = database.NewMaintainer(db, server.maintenanceConfig())
+	server.maintenance.SetCorruptionHandler(server.alertDatabaseCorruption)
+
+	// Look for new releases
+	server.startUpdateChecker(context.Background())
+
+	// A standby serves a primary's shipped backups read-only and leaves
+	// the background work that writes to the primary until promoted
+	if source := StandbySource(); source != "" && !database.Promoted() {
+		interval, err := StandbyInterval()
+		if err != nil {
+			log.Printf("Ignoring FETHUR_STANDBY_INTERVAL: %v", err)
+		}
+		server.standby = database.NewStandby(db, source)
+		server.standby.SetSyncHandler(server.standbySynced)
+		server.standby.Start(context.Background(), interval)
+		log.Printf("Running as a read-only standby of %s", source)
+	} else {
+		server.startBackgroundWork(context.Background())
+	}
 
 	// Start the WebSocket hub
 	go hub.Run()
@@ -52,18 +185,127 @@ func New(db *database.Database, auth *auth.Service) *Server {
 	return server
 }
 
+// stores returns the stores on the primary database
+func (s *Server) stores() *store.Stores {
+	return store.New(s.db)
+}
+
+// startBackgroundWork starts the schedulers and jobs a primary runs
+func (s *Server) startBackgroundWork(ctx context.Context) {
+	// Temporary voice channels left from before a restart are gone already
+	s.cleanupTempVoiceChannels()
+
+	// Keep pre-capability admins working
+	s.migrateAdminCapabilities()
+
+	// Schedule database maintenance
+	s.maintenance.Start(ctx)
+
+	// Email digests to inactive users
+	s.startDigestScheduler(ctx)
+
+	// Delete guest accounts once they expire or leave
+	s.startGuestSweeper(ctx)
+
+	// Refresh channel calendars and post event reminders
+	s.startCalendarScheduler(ctx)
+
+	// Remind attendees and start and end server events
+	s.startEventScheduler(ctx)
+
+	// Award XP for time spent talking in voice
+	s.startXPScheduler(ctx)
+
+	// Evaluate the operators' alert rules
+	s.startAlertChecker(ctx)
+
+	// Start background jobs and resume work interrupted by a restart
+	s.jobs.Start()
+	s.requeueAttachmentProcessing()
+	s.requeueMemberImports()
+	s.requeueChatImports()
+}
+
+// CORSOrigins returns the origins allowed to call the API, from the
+// comma-separated FETHUR_CORS_ORIGINS or the local development defaults
+func CORSOrigins() []string {
+	if value := os.Getenv("FETHUR_CORS_ORIGINS"); value != "" {
+		origins := make([]string, 0)
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		return origins
+	}
+	return []string{"http://localhost:5173", "https://localhost:5173", "http://127.0.0.1:5173", "https://127.0.0.1:5173", "http://192.168.1.23:5173", "https://192.168.1.23:5173"}
+}
+
+// WebSocketCompression returns the WebSocket compression settings:
+// FETHUR_WS_COMPRESSION=false disables it, FETHUR_WS_COMPRESSION_LEVEL sets
+// the flate level and FETHUR_WS_COMPRESSION_THRESHOLD the smallest message
+// in bytes worth compressing. Malformed numbers fail validation.
+func WebSocketCompression() wscompress.Config {
+	config := wscompress.Default()
+	if os.Getenv("FETHUR_WS_COMPRESSION") == "false" {
+		config.Enabled = false
+	}
+	if value := os.Getenv("FETHUR_WS_COMPRESSION_LEVEL"); value != "" {
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			level = 0
+		}
+		config.Level = level
+	}
+	if value := os.Getenv("FETHUR_WS_COMPRESSION_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil {
+			threshold = -1
+		}
+		config.Threshold = threshold
+	}
+	return config
+}
+
+// WebSocketCompaction returns the event compaction rules: the defaults
+// unless FETHUR_WS_COMPACTION lists type=threshold/window rules, or is off
+func WebSocketCompaction() (map[string]websocket.CompactionRule, error) {
+	value := os.Getenv("FETHUR_WS_COMPACTION")
+	if value == "" {
+		return websocket.DefaultCompaction(), nil
+	}
+	return websocket.ParseCompaction(value)
+}
+
 func (s *Server) setupRoutes() {
 	// Add CORS middleware
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:5173", "https://localhost:5173", "http://127.0.0.1:5173", "https://127.0.0.1:5173", "http://192.168.1.23:5173", "https://192.168.1.23:5173"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowOrigins = CORSOrigins()
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "If-Match", "If-None-Match", "If-Modified-Since", "Range", "If-Range", "X-Read-Consistency", orgHeader, apiKeyHeader}
+	config.ExposeHeaders = []string{"ETag", "Last-Modified", "Idempotent-Replayed", "Accept-Ranges", "Content-Range", "Content-Length"}
 	config.AllowCredentials = true
 
 	s.router.Use(cors.New(config))
 
+	// Client IPs come from forwarding headers only behind trusted proxies
+	if err := s.router.SetTrustedProxies(TrustedProxies()); err != nil {
+		log.Printf("Invalid FETHUR_TRUSTED_PROXIES, trusting no proxies: %v", err)
+		_ = s.router.SetTrustedProxies(nil)
+	}
+
+	// Client IPs outside the network ACL are refused before anything else
+	s.router.Use(s.networkACLMiddleware())
+
+	// A standby only serves reads until it is promoted
+	s.router.Use(s.standbyMiddleware())
+
+	// Every route is served under the base path
+	root := s.router.Group(s.basePath)
+
 	// API routes
-	api := s.router.Group("/api")
+	api := root.Group("/api")
+	api.Use(s.debugCaptureMiddleware())
 	{
 		// Setup routes (no auth required)
 		setup := api.Group("/setup")
@@ -72,95 +314,457 @@ func (s *Server) setupRoutes() {
 			setup.POST("/configure", s.handleSetupConfigure)
 		}
 
+		// Signed attachment links (signature checked instead of auth)
+		api.GET("/files/:id", s.handleGetSignedAttachment)
+
+		// Digest unsubscribe links from emails
+		api.GET("/digest/unsubscribe", s.handleDigestUnsubscribe)
+
+		// Server directory, browsable without signing in
+		api.GET("/directory", rateLimit(newRateLimiter(publicRequestsPerMin, time.Minute)), s.handleGetDirectory)
+
+		// The server's clock, for clients to sync with before connecting
+		api.GET("/time", s.handleGetTime)
+
+		// JSON Schema of WebSocket and plugin events, for generating SDKs
+		api.GET("/schema/events", s.handleEventSchema)
+
+		// Terms of service and rules, readable before signing up
+		api.GET("/policies", s.handleGetPolicies)
+
+		// Incoming webhooks (token in the URL checked instead of auth)
+		api.POST("/webhooks/:id/:token", s.handleIncomingWebhook)
+
 		// Auth routes
 		auth := api.Group("/auth")
 		{
 			auth.POST("/register", s.handleRegister)
 			auth.POST("/login", s.handleLogin)
+			auth.POST("/refresh", s.handleRefreshToken)
+			auth.POST("/logout", s.handleLogout)
 			auth.GET("/me", s.authMiddleware(), s.handleGetCurrentUser)
 			auth.POST("/guest", s.handleGuestLogin)
+			auth.GET("/password-policy", s.handleGetPasswordPolicy)
+			auth.GET("/challenge", s.handleGetRegistrationChallenge)
+
+			// Email verification; the link in the email opens verify
+			auth.GET("/verify", s.handleVerifyEmail)
+			auth.POST("/verify/send", s.authMiddleware(), s.handleSendVerification)
+
+			// Single sign-on with an OpenID Connect provider
+			auth.GET("/oidc", s.handleGetOIDC)
+			auth.GET("/oidc/login", s.handleOIDCLogin)
+			auth.GET("/oidc/callback", s.handleOIDCCallback)
+
+			// Two-factor sign-in; verify finishes a login that needs a code
+			auth.POST("/2fa/verify", s.handleVerifyTwoFactor)
+			auth.GET("/2fa", s.authMiddleware(), s.handleGetTwoFactor)
+			auth.POST("/2fa/setup", s.authMiddleware(), sessionOnly(), s.handleSetupTwoFactor)
+			auth.POST("/2fa/enable", s.authMiddleware(), sessionOnly(), s.handleEnableTwoFactor)
+			auth.POST("/2fa/disable", s.authMiddleware(), sessionOnly(), s.handleDisableTwoFactor)
+			auth.POST("/2fa/backup-codes", s.authMiddleware(), sessionOnly(), s.handleRegenerateBackupCodes)
+
+			// Personal access tokens for bots and scripts, sent as X-API-Key
+			auth.GET("/tokens", s.authMiddleware(), sessionOnly(), s.handleGetAPIKeys)
+			auth.POST("/tokens", s.authMiddleware(), sessionOnly(), s.handleCreateAPIKey)
+			auth.DELETE("/tokens/:id", s.authMiddleware(), sessionOnly(), s.handleDeleteAPIKey)
+		}
+
+		// Automation API for no-code platforms, authenticated by API key
+		automation := api.Group("/automation/v1")
+		automation.Use(s.apiKeyMiddleware(), s.usageMiddleware())
+		{
+			automation.GET("/me", s.handleAutomationMe)
+			automation.GET("/channels", s.handleAutomationChannels)
+			automation.POST("/channels/:channelId/messages", s.handleAutomationPostMessage)
+			automation.GET("/messages", s.handleAutomationPollMessages)
+			automation.GET("/hooks", s.handleGetAutomationHooks)
+			automation.POST("/hooks", s.handleCreateAutomationHook)
+			automation.DELETE("/hooks/:id", s.handleDeleteAutomationHook)
+			automation.POST("/hooks/:id/secret", s.handleRotateAutomationHookSecret)
+
+			// Role management for onboarding bots, under the same hierarchy
+			// as people
+			automation.GET("/servers/:id/roles", s.handleGetServerRoles)
+			automation.GET("/servers/:id/members/:userId/roles", s.handleGetMemberRoles)
+			automation.PUT("/servers/:id/members/:userId/roles/:roleId", s.handleGrantMemberRole)
+			automation.DELETE("/servers/:id/members/:userId/roles/:roleId", s.handleRevokeMemberRole)
 		}
 
 		// Protected routes
 		protected := api.Group("/")
-		protected.Use(s.authMiddleware())
+		protected.Use(s.authMiddleware(), s.orgMiddleware(), s.usageMiddleware())
 		{
 			// User routes
 			protected.GET("/user/profile", s.handleGetProfile)
+			protected.GET("/user/subscriptions", s.handleGetSubscriptions)
+			protected.GET("/user/sessions", sessionOnly(), s.handleGetSessions)
+			protected.DELETE("/user/sessions/:id", sessionOnly(), s.handleRevokeSession)
+			protected.POST("/user/logout-all", sessionOnly(), s.handleLogoutEverywhere)
+			protected.GET("/user/policies", s.handleGetUserPolicies)
+			protected.POST("/user/policies/:kind/acknowledge", sessionOnly(), s.handleAcknowledgePolicy)
+			protected.GET("/user/digest", s.handleGetDigestPreference)
+			protected.PUT("/user/digest", s.handleUpdateDigestPreference)
+			protected.GET("/user/devices", s.handleGetPushDevices)
+			protected.POST("/user/devices", s.handleRegisterPushDevice)
+			protected.DELETE("/user/devices/:id", s.handleDeletePushDevice)
+			protected.GET("/user/notifications", s.handleGetNotificationSettings)
+			protected.PUT("/user/notifications", s.handleUpdateNotificationSettings)
+			protected.GET("/user/threads", s.handleGetFollowedThreads)
+			protected.GET("/user/xmpp", s.handleGetXMPPLink)
+			protected.POST("/user/xmpp", s.handleCreateXMPPLinkCode)
+			protected.DELETE("/user/xmpp", s.handleDeleteXMPPLink)
+
+			// The caller's organization and its settings
+			protected.GET("/org", s.handleGetOwnOrganization)
+			protected.GET("/org/settings", s.requirePermission(capManageUsers), s.handleGetOrganizationSettings)
+			protected.PUT("/org/settings", s.requirePermission(capManageUsers), s.handleUpdateOrganizationSettings)
 
 			// Settings routes (admin only)
-			protected.GET("/settings", s.adminMiddleware(), s.handleGetSettings)
-			protected.POST("/settings", s.adminMiddleware(), s.handleUpdateSettings)
+			protected.GET("/settings", s.requirePermission(capManageSettings), s.handleGetSettings)
+			protected.POST("/settings", s.requirePermission(capManageSettings), s.handleUpdateSettings)
+			protected.GET("/settings/changes", s.requirePermission(capManageSettings), s.handleGetSettingChangeRequests)
+			protected.POST("/settings/changes/:id/approve", s.requirePermission(capManageSettings), s.handleDecideSettingChange(true))
+			protected.POST("/settings/changes/:id/reject", s.requirePermission(capManageSettings), s.handleDecideSettingChange(false))
 
-			// Admin routes (admin only)
+			// Admin routes, each gated by a permission
 			admin := protected.Group("/admin")
-			admin.Use(s.adminMiddleware())
 			{
-				// User management
-				admin.GET("/users", s.handleGetUsers)
-				admin.POST("/users", s.handleCreateUser)
-				admin.PUT("/users/:id", s.handleUpdateUser)
-				admin.DELETE("/users/:id", s.handleDeleteUser)
-				admin.POST("/users/:id/role", s.handleUpdateUserRole)
+				// User management, limited to their own organization for
+				// organization admins
+				manageUsers := s.requirePermission(capManageUsers)
+				sameOrg := s.orgTargetMiddleware()
+				privileged := s.privilegedTargetMiddleware()
+				admin.GET("/users", manageUsers, s.handleGetUsers)
+				admin.POST("/users", manageUsers, s.handleCreateUser)
+				admin.PUT("/users/:id", manageUsers, sameOrg, privileged, s.handleUpdateUser)
+				admin.DELETE("/users/:id", manageUsers, sameOrg, privileged, s.handleDeleteUser)
+				admin.DELETE("/users/:id/2fa", manageUsers, sameOrg, privileged, s.handleAdminResetTwoFactor)
+				admin.POST("/users/:id/role", manageUsers, sameOrg, s.handleUpdateUserRole)
+				admin.POST("/users/:id/logout", manageUsers, sameOrg, privileged, s.handleAdminLogoutUser)
+				admin.GET("/invites", manageUsers, s.handleGetInvites)
+				admin.POST("/invites", manageUsers, s.handleCreateInvite)
+				admin.DELETE("/invites/:id", manageUsers, s.handleDeleteInvite)
+				admin.GET("/registrations", manageUsers, s.handleGetPendingRegistrations)
+				admin.POST("/registrations/:id/approve", manageUsers, sameOrg, s.handleReviewRegistration(true))
+				admin.POST("/registrations/:id/reject", manageUsers, sameOrg, s.handleReviewRegistration(false))
+				admin.GET("/age-report", manageUsers, s.handleGetAgeReport)
+				admin.POST("/imports/:source", manageUsers, s.handleImportChat)
+				admin.GET("/imports/:id", manageUsers, s.handleGetChatImport)
 
 				// Moderation
-				admin.POST("/users/:id/kick", s.handleKickUser)
-				admin.POST("/users/:id/ban", s.handleBanUser)
-				admin.POST("/users/:id/mute", s.handleMuteUser)
-				admin.POST("/users/:id/unban", s.handleUnbanUser)
-				admin.POST("/users/:id/unmute", s.handleUnmuteUser)
+				moderate := s.requirePermission(capModerate)
+				admin.POST("/users/:id/kick", moderate, sameOrg, privileged, s.handleKickUser)
+				admin.POST("/users/:id/ban", moderate, sameOrg, privileged, s.handleBanUser)
+				admin.POST("/users/:id/mute", moderate, sameOrg, privileged, s.handleMuteUser)
+				admin.POST("/users/:id/unban", moderate, sameOrg, privileged, s.handleUnbanUser)
+				admin.POST("/users/:id/unmute", moderate, sameOrg, privileged, s.handleUnmuteUser)
+				admin.POST("/users/:id/ban-ip", s.requirePermission(capManageSettings), privileged, s.handleBanClientIP)
+				admin.GET("/directory", moderate, s.handleGetDirectoryListings)
+				admin.PUT("/directory/:serverId", moderate, s.handleCurateDirectoryListing)
+				admin.GET("/directory/:serverId/reports", moderate, s.handleGetDirectoryReports)
 
 				// System health
-				admin.GET("/health", s.handleAdminHealth)
-				admin.GET("/metrics", s.handleGetMetrics)
-				admin.GET("/users/online", s.handleGetOnlineUsers)
-				admin.GET("/users/latency", s.handleGetUserLatency)
+				viewMetrics := s.requirePermission(capViewMetrics)
+				admin.GET("/health", viewMetrics, s.handleAdminHealth)
+				admin.GET("/version", viewMetrics, s.handleAdminVersion)
+				admin.GET("/metrics", viewMetrics, s.handleGetMetrics)
+				admin.GET("/users/online", viewMetrics, s.handleGetOnlineUsers)
+				admin.GET("/users/latency", viewMetrics, s.handleGetUserLatency)
+				admin.GET("/voice", viewMetrics, s.handleGetVoiceStats)
+				admin.GET("/storage", viewMetrics, s.handleGetStorageReport)
+				admin.GET("/maintenance", viewMetrics, s.handleGetMaintenance)
+				admin.GET("/push", viewMetrics, s.handleGetPushStats)
+				admin.GET("/integrations/health", viewMetrics, s.handleGetIntegrationsHealth)
+				admin.GET("/users/:id/usage", viewMetrics, sameOrg, s.handleGetUserUsage)
+				admin.GET("/usage/top", viewMetrics, s.handleGetTopUsers)
+				admin.POST("/maintenance", s.requirePermission(capManageSettings), s.handleRunMaintenance)
+
+				// Disaster recovery: a standby's sync status, and promoting
+				// it to primary
+				admin.GET("/standby", viewMetrics, s.handleGetStandby)
+				admin.POST("/standby/promote", s.superAdminMiddleware(), s.handlePromoteStandby)
+
+				// Alert rules on server health
+				admin.GET("/alerts", viewMetrics, s.handleGetAlertRules)
+				admin.POST("/alerts", s.requirePermission(capManageSettings), s.handleCreateAlertRule)
+				admin.PUT("/alerts/:id", s.requirePermission(capManageSettings), s.handleUpdateAlertRule)
+				admin.DELETE("/alerts/:id", s.requirePermission(capManageSettings), s.handleDeleteAlertRule)
+				admin.POST("/alerts/:id/test", s.requirePermission(capManageSettings), s.handleTestAlertRule)
+
+				// Email delivery
+				admin.GET("/mail", s.requirePermission(capManageSettings), s.handleGetMail)
+				admin.POST("/mail/test", s.requirePermission(capManageSettings), s.handleSendTestEmail)
+				admin.POST("/digests/run", s.requirePermission(capManageSettings), s.handleRunDigests)
+
+				// Integrations
+				managePlugins := s.requirePermission(capManagePlugins)
+				admin.GET("/commands", managePlugins, s.handleGetCommandWebhooks)
+				admin.POST("/commands", managePlugins, s.handleCreateCommandWebhook)
+				admin.DELETE("/commands/:id", managePlugins, s.handleDeleteCommandWebhook)
+				admin.POST("/commands/:id/secret", managePlugins, s.handleRotateCommandSecret)
+				admin.GET("/webhooks", managePlugins, s.handleGetIncomingWebhooks)
+				admin.POST("/webhooks", managePlugins, s.handleCreateIncomingWebhook)
+				admin.DELETE("/webhooks/:id", managePlugins, s.handleDeleteIncomingWebhook)
+				admin.POST("/webhooks/:id/secret", managePlugins, s.handleRotateIncomingWebhookSecret)
 
 				// Audit logs
-				admin.GET("/logs", s.handleGetAuditLogs)
+				admin.GET("/logs", viewMetrics, s.handleGetAuditLogs)
+
+				// Capability management (super admin only)
+				admin.GET("/capabilities", s.superAdminMiddleware(), s.handleGetCapabilities)
+				admin.GET("/users/:id/capabilities", s.superAdminMiddleware(), s.handleGetUserCapabilities)
+				admin.PUT("/users/:id/capabilities", s.superAdminMiddleware(), s.handleUpdateUserCapabilities)
+
+				// Instance roles and their permissions (super admin only)
+				admin.GET("/roles", s.superAdminMiddleware(), s.handleGetInstanceRoles)
+				admin.POST("/roles", s.superAdminMiddleware(), s.handleCreateInstanceRole)
+				admin.PUT("/roles/:name", s.superAdminMiddleware(), s.handleUpdateInstanceRole)
+				admin.DELETE("/roles/:name", s.superAdminMiddleware(), s.handleDeleteInstanceRole)
+
+				// JWT signing keys (super admin only)
+				admin.GET("/jwt-keys", s.superAdminMiddleware(), s.handleGetSigningKeys)
+				admin.POST("/jwt-keys/rotate", s.superAdminMiddleware(), s.handleRotateSigningKey)
+
+				// Terms of service and rules (super admin only)
+				admin.GET("/policies/:kind", s.superAdminMiddleware(), s.handleGetPolicyHistory)
+				admin.POST("/policies/:kind", s.superAdminMiddleware(), s.handlePublishPolicy)
+				admin.DELETE("/policies/:kind", s.superAdminMiddleware(), s.handleRetirePolicy)
+				admin.GET("/policies/:kind/acknowledgments", s.superAdminMiddleware(), s.handleGetPolicyAcknowledgments)
+
+				// Server quota overrides (super admin only)
+				admin.GET("/servers/:id/quotas", s.superAdminMiddleware(), s.handleGetServerQuotas)
+				admin.PUT("/servers/:id/quotas", s.superAdminMiddleware(), s.handleUpdateServerQuotas)
+
+				// Debug request capture, across every organization (super admin only)
+				admin.GET("/debug/requests", s.superAdminMiddleware(), s.handleGetDebugRequests)
+				admin.PUT("/debug/requests", s.superAdminMiddleware(), s.handleUpdateDebugCapture)
+				admin.DELETE("/debug/requests", s.superAdminMiddleware(), s.handleClearDebugRequests)
+
+				// Fault injection, in builds made with -tags chaos (super admin only)
+				admin.GET("/chaos", s.superAdminMiddleware(), s.handleGetFaults)
+				admin.PUT("/chaos", s.superAdminMiddleware(), s.handleUpdateFaults)
+
+				// Organizations (super admin only)
+				admin.GET("/orgs", s.superAdminMiddleware(), s.handleGetOrganizations)
+				admin.POST("/orgs", s.superAdminMiddleware(), s.handleCreateOrganization)
+				admin.PUT("/orgs/:id", s.superAdminMiddleware(), s.handleUpdateOrganization)
+				admin.DELETE("/orgs/:id", s.superAdminMiddleware(), s.handleDeleteOrganization)
+				admin.GET("/orgs/:id/settings", s.superAdminMiddleware(), s.handleGetOrganizationSettings)
+				admin.PUT("/orgs/:id/settings", s.superAdminMiddleware(), s.handleUpdateOrganizationSettings)
 			}
 
 			// Server routes
 			protected.POST("/servers", s.handleCreateServer)
 			protected.GET("/servers", s.handleGetServers)
 			protected.GET("/servers/:id", s.handleGetServer)
+			protected.PATCH("/servers/:id", s.handleUpdateServer)
 
 			// Channel routes
 			protected.POST("/servers/:id/channels", s.handleCreateChannel)
 			protected.GET("/servers/:id/channels", s.handleGetChannels)
+			protected.PATCH("/channels/:channelId", s.handleUpdateChannel)
+			protected.GET("/channels/:channelId/integrations", s.handleGetChannelIntegrations)
+			protected.PUT("/channels/:channelId/voice-room", s.handleUpdateVoiceRoom)
 
 			// Server users route
 			protected.GET("/servers/:id/users", s.handleGetServerUsers)
 
+			// Server directory listings
+			protected.GET("/servers/:id/directory", s.handleGetServerListing)
+			protected.PUT("/servers/:id/directory", s.handleUpdateServerListing)
+			protected.POST("/directory/:id/join", s.handleJoinDirectoryServer)
+			protected.POST("/directory/:id/report", s.handleReportDirectoryServer)
+
+			// Raid mode and the join requests it holds for approval
+			protected.GET("/servers/:id/raid-mode", s.handleGetRaidMode)
+			protected.PUT("/servers/:id/raid-mode", s.handleUpdateRaidMode)
+			protected.GET("/servers/:id/join-requests", s.handleGetJoinRequests)
+			protected.POST("/servers/:id/join-requests/:requestId/approve", s.handleDecideJoinRequest(true))
+			protected.POST("/servers/:id/join-requests/:requestId/reject", s.handleDecideJoinRequest(false))
+
+			// Moderation cases; the case's user files appeals
+			protected.GET("/servers/:id/cases", s.handleGetCases)
+			protected.POST("/servers/:id/cases", s.handleCreateCase)
+			protected.GET("/servers/:id/cases/:number", s.handleGetCase)
+			protected.PATCH("/servers/:id/cases/:number", s.handleUpdateCase)
+			protected.POST("/servers/:id/cases/:number/actions", s.handleAddCaseAction)
+			protected.POST("/servers/:id/cases/:number/notes", s.handleAddCaseNote)
+			protected.POST("/servers/:id/cases/:number/evidence", s.handleAddCaseEvidence)
+			protected.POST("/servers/:id/cases/:number/appeal", s.handleAppealCase)
+			protected.PUT("/servers/:id/cases/:number/appeal", s.handleDecideCaseAppeal)
+
+			// Scheduled server events
+			protected.GET("/servers/:id/events", s.handleGetServerEvents)
+			protected.POST("/servers/:id/events", s.handleCreateServerEvent)
+			protected.GET("/servers/:id/events/:eventId", s.handleGetServerEvent)
+			protected.DELETE("/servers/:id/events/:eventId", s.handleDeleteServerEvent)
+			protected.PUT("/servers/:id/events/:eventId/rsvp", s.handleRSVPServerEvent)
+			protected.DELETE("/servers/:id/events/:eventId/rsvp", s.handleDeleteRSVPServerEvent)
+
+			// Server roles
+			protected.GET("/servers/:id/roles", s.handleGetServerRoles)
+			protected.POST("/servers/:id/roles", s.handleCreateServerRole)
+			protected.PUT("/servers/:id/roles/:roleId", s.handleUpdateServerRole)
+			protected.DELETE("/servers/:id/roles/:roleId", s.handleDeleteServerRole)
+			protected.GET("/servers/:id/auto-roles", s.handleGetAutoRoles)
+			protected.PUT("/servers/:id/auto-roles", s.handleUpdateAutoRoles)
+			protected.POST("/servers/:id/members", s.handleAddServerMember)
+			protected.PUT("/servers/:id/members/:userId/rank", s.handleUpdateMemberRank)
+			protected.POST("/servers/:id/members/import", s.handleImportMembers)
+			protected.GET("/servers/:id/members/imports/:importId", s.handleGetMemberImport)
+			protected.GET("/servers/:id/members/export", s.handleExportMembers)
+			protected.GET("/servers/:id/members/:userId/roles", s.handleGetMemberRoles)
+			protected.PUT("/servers/:id/members/:userId/roles/:roleId", s.handleGrantMemberRole)
+			protected.DELETE("/servers/:id/members/:userId/roles/:roleId", s.handleRevokeMemberRole)
+			protected.GET("/servers/:id/reaction-roles", s.handleGetServerReactionRoles)
+
+			// Support tickets
+			protected.GET("/servers/:id/tickets", s.handleGetTickets)
+			protected.POST("/servers/:id/tickets", s.handleOpenTicket)
+			protected.GET("/servers/:id/tickets/settings", s.handleGetTicketSettings)
+			protected.PUT("/servers/:id/tickets/settings", s.handleUpdateTicketSettings)
+			protected.GET("/servers/:id/tickets/metrics", s.handleGetTicketMetrics)
+			protected.GET("/servers/:id/tickets/:ticketId", s.handleGetTicket)
+			protected.POST("/servers/:id/tickets/:ticketId/claim", s.handleClaimTicket)
+			protected.POST("/servers/:id/tickets/:ticketId/close", s.handleCloseTicket)
+			protected.GET("/servers/:id/tickets/:ticketId/transcript", s.handleGetTicketTranscript)
+
+			// Starboard
+			protected.GET("/servers/:id/starboard", s.handleGetStarboard)
+			protected.PUT("/servers/:id/starboard", s.handleUpdateStarboard)
+			protected.DELETE("/servers/:id/starboard", s.handleDeleteStarboard)
+			protected.GET("/content-filter/packs", s.handleGetFilterPacks)
+			protected.GET("/servers/:id/content-filter", s.handleGetContentFilter)
+			protected.PUT("/servers/:id/content-filter", s.handleUpdateContentFilter)
+			protected.POST("/servers/:id/content-filter/words", s.handleAddFilterWord)
+			protected.DELETE("/servers/:id/content-filter/words/:word", s.handleDeleteFilterWord)
+			protected.POST("/servers/:id/content-filter/test", s.handleTestContentFilter)
+
+			// Levels
+			protected.GET("/servers/:id/leaderboard", s.handleGetLeaderboard)
+			protected.GET("/servers/:id/xp/settings", s.handleGetXPSettings)
+			protected.PUT("/servers/:id/xp/settings", s.handleUpdateXPSettings)
+			protected.GET("/servers/:id/xp/rewards", s.handleGetXPRewards)
+			protected.PUT("/servers/:id/xp/rewards", s.handleUpdateXPRewards)
+			protected.DELETE("/servers/:id/xp/members/:userId", s.handleResetMemberXP)
+
 			// Message routes
 			protected.GET("/channels/:channelId/messages", s.handleGetMessages)
+			protected.GET("/channels/:channelId/messages/:messageId/context", s.handleGetMessageContext)
 			protected.POST("/channels/:channelId/messages", s.handleSendMessage)
+			protected.PUT("/messages/:messageId", s.handleEditMessage)
+			protected.DELETE("/messages/:messageId", s.handleDeleteMessage)
+			protected.GET("/messages/:messageId/revisions", s.handleGetMessageRevisions)
+			protected.PUT("/messages/:messageId/reactions/:emoji", s.handleAddReaction)
+			protected.DELETE("/messages/:messageId/reactions/:emoji", s.handleRemoveReaction)
+			protected.PUT("/messages/:messageId/follow", s.handleFollowThread)
+			protected.DELETE("/messages/:messageId/follow", s.handleUnfollowThread)
+			protected.GET("/messages/:messageId/reaction-roles", s.handleGetReactionRoles)
+			protected.PUT("/messages/:messageId/reaction-roles/:emoji", s.handleSetReactionRole)
+			protected.DELETE("/messages/:messageId/reaction-roles/:emoji", s.handleDeleteReactionRole)
+
+			// API keys; the same keys as /api/auth/tokens
+			protected.GET("/user/api-keys", sessionOnly(), s.handleGetAPIKeys)
+			protected.POST("/user/api-keys", sessionOnly(), s.handleCreateAPIKey)
+			protected.DELETE("/user/api-keys/:id", sessionOnly(), s.handleDeleteAPIKey)
+
+			// Slash commands handled by external endpoints
+			protected.GET("/commands", s.handleGetCommands)
+			protected.POST("/channels/:channelId/commands", s.handleRunCommand)
+
+			// Calendar subscriptions and event reminders
+			protected.GET("/channels/:channelId/events", s.handleGetChannelEvents)
+			protected.GET("/channels/:channelId/calendars", s.handleGetChannelCalendars)
+			protected.POST("/channels/:channelId/calendars", s.handleCreateChannelCalendar)
+			protected.PUT("/channels/:channelId/calendars/:id", s.handleUpdateChannelCalendar)
+			protected.DELETE("/channels/:channelId/calendars/:id", s.handleDeleteChannelCalendar)
+
+			// Attachment routes
+			protected.POST("/attachments", s.handleCreateAttachment)
+			protected.PUT("/attachments/:id/upload", s.handleUploadAttachment)
+			protected.POST("/attachments/:id/complete", s.handleCompleteAttachment)
+			protected.GET("/attachments/:id", s.handleGetAttachment)
+			protected.DELETE("/attachments/:id", s.handleDeleteAttachment)
+			protected.GET("/attachments/:id/parts", s.handleGetAttachmentParts)
+			protected.POST("/attachments/:id/parts/:number/upload-url", s.handleCreateAttachmentPartURL)
+			protected.PUT("/attachments/:id/parts/:number", s.handleUploadAttachmentPart)
 		}
 	}
 
 	// WebSocket endpoint
-	s.router.GET("/ws", s.authMiddleware(), s.handleWebSocket)
+	root.GET("/ws", s.authMiddleware(), s.orgMiddleware(), s.handleWebSocket)
+
+	// Voice signaling WebSocket endpoint
+	root.GET("/ws/voice", s.authMiddleware(), s.orgMiddleware(), s.voiceHub.HandleWebSocket)
 
-	// Voice WebSocket endpoint
-	s.router.GET("/voice", s.authMiddleware(), s.voiceHub.HandleWebSocket)
+	// Deprecated: legacy voice path kept for older clients
+	root.GET("/voice", s.authMiddleware(), s.orgMiddleware(), s.voiceHub.HandleWebSocket)
+
+	// Read-only views of public channels, no auth required
+	public := root.Group("/public")
+	public.Use(rateLimit(newRateLimiter(publicRequestsPerMin, time.Minute)))
+	{
+		public.GET("/channels/:id", s.handlePublicChannel)
+	}
 
 	// Health check
-	s.router.GET("/health", s.handleHealth)
+	root.GET("/health", s.handleHealth)
+
+	// Binaries built with the web client serve it for every other path
+	if client, ok := webui.Embedded(); ok && os.Getenv("FETHUR_SERVE_CLIENT") != "false" {
+		s.serveClient(webui.New(client))
+	}
 }
 
 func (s *Server) Router() http.Handler {
 	return s.router
 }
 
+// applyVoiceIdlePolicy loads the voice idle disconnect settings into the voice hub
+func (s *Server) applyVoiceIdlePolicy() {
+	policy := voice.DefaultIdlePolicy()
+	policy.AloneTimeout = time.Duration(s.getIntSetting("voice_alone_timeout_minutes", 0)) * time.Minute
+	policy.MutedIdleTimeout = time.Duration(s.getIntSetting("voice_muted_idle_timeout_minutes", 0)) * time.Minute
+	s.voiceHub.SetIdlePolicy(policy)
+}
+
+// getIntSetting reads an integer setting, falling back to a default when unset or invalid
+func (s *Server) getIntSetting(key string, fallback int) int {
+	value, err := s.db.GetSetting(key)
+	if err != nil {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
 func (s *Server) handleHealth(c *gin.Context) {
 	// Add CORS headers for health endpoint
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 	c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
 
+	// A failed integrity check degrades health until a later check passes
+	status, databaseHealth := "healthy", gin.H{"integrity": "unchecked"}
+	if s.maintenance != nil {
+		if integrity := s.maintenance.Status().Integrity; integrity != nil {
+			databaseHealth = gin.H{"integrity": "ok", "checked_at": integrity.CheckedAt}
+			if !integrity.OK {
+				status, databaseHealth["integrity"] = "degraded", "failed"
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"message": "Fethur Server is running",
+		"status":   status,
+		"message":  "Fethur Server is running",
+		"database": databaseHealth,
+		"standby":  s.inStandby(),
 	})
 }
 
@@ -168,25 +772,28 @@ func (s *Server) handleGetCurrentUser(c *gin.Context) {
 	userID := c.GetInt("user_id")
 	username := c.GetString("username")
 
-	// Get user details from database
-	var email, role string
-	err := s.db.QueryRow(
-		"SELECT email, role FROM users WHERE id = ?",
-		userID,
-	).Scan(&email, &role)
-
+	user, err := s.stores().Users.Get(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 		return
 	}
 
+	// Let the admin panel hide sections the user cannot use
+	_, capabilities, err := s.userCapabilities(userID)
+	if err != nil {
+		capabilities = []string{}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"id":       userID,
-			"username": username,
-			"email":    email,
-			"role":     role,
+			"id":             userID,
+			"username":       username,
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"role":           user.Role,
+			"org_id":         user.OrgID,
+			"capabilities":   capabilities,
 		},
 	})
 }
@@ -232,7 +839,7 @@ func (s *Server) handleSetupConfigure(c *gin.Context) {
 	}
 
 	// Validate admin password
-	if err := s.auth.ValidatePassword(req.Admin.Password); err != nil {
+	if err := s.auth.ValidatePasswordForUser(req.Admin.Password, req.Admin.Username); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -262,10 +869,8 @@ func (s *Server) handleSetupConfigure(c *gin.Context) {
 		return
 	}
 
-	_, err = s.db.Exec(
-		"INSERT INTO users (username, email, password_hash, role) VALUES (?, ?, ?, ?)",
-		req.Admin.Username, "", hashedPassword, "super_admin",
-	)
+	users := s.stores().Users
+	_, err = users.Create(c.Request.Context(), store.NewUser{Username: req.Admin.Username, PasswordHash: hashedPassword, Role: "super_admin"})
 	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Admin user already exists"})
 		return
@@ -273,7 +878,7 @@ func (s *Server) handleSetupConfigure(c *gin.Context) {
 
 	// Create normal user if provided
 	if req.User.Username != "" && req.User.Password != "" {
-		if err := s.auth.ValidatePassword(req.User.Password); err != nil {
+		if err := s.auth.ValidatePasswordForUser(req.User.Password, req.User.Username); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "User password: " + err.Error()})
 			return
 		}
@@ -284,10 +889,7 @@ func (s *Server) handleSetupConfigure(c *gin.Context) {
 			return
 		}
 
-		_, err = s.db.Exec(
-			"INSERT INTO users (username, email, password_hash, role) VALUES (?, ?, ?, ?)",
-			req.User.Username, "", userHashedPassword, "user",
-		)
+		_, err = users.Create(c.Request.Context(), store.NewUser{Username: req.User.Username, PasswordHash: userHashedPassword, Role: "user"})
 		if err != nil {
 			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
 			return
@@ -304,71 +906,139 @@ func (s *Server) handleRegister(c *gin.Context) {
 		Username             string `json:"username" binding:"required"`
 		Password             string `json:"password" binding:"required,min=6"`
 		RegistrationPassword string `json:"registrationPassword"`
+		InviteCode           string `json:"inviteCode"`
+		Email                string `json:"email"`
+
+		// YYYY-MM-DD, asked for when the age gate is on; never stored
+		DateOfBirth string `json:"date_of_birth"`
+
+		// Solution to the registration challenge, when one is enabled
+		Challenge *challenge.Solution `json:"challenge"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !s.checkRegistrationChallenge(c, req.Challenge) {
+		return
+	}
+	dob, ok := s.checkAgeGate(c, req.DateOfBirth)
+	if !ok {
+		return
+	}
 
-	// Check authentication mode
-	authMode, err := s.db.GetSetting("auth_mode")
-	if err != nil {
-		authMode = "public" // Default to public
+	// The email is optional unless it has to be verified
+	verification := s.emailVerificationMode()
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" && !validEmail(req.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+	if req.Email == "" && verification != "off" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An email address is required"})
+		return
 	}
 
-	// Validate registration based on auth mode
-	switch authMode {
-	case "admin_only":
+	// Register into the organization the request is addressed to
+	orgID, ok := s.requestOrgID(c)
+	if !ok {
+		return
+	}
+
+	user, err := s.services.Auth.Register(orgID, req.Username, req.Password, req.RegistrationPassword, req.InviteCode)
+	var invalid *service.ValidationError
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrRegistrationClosed):
 		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled. Only admins can create accounts."})
 		return
-	case "open_registration":
-		regPassword, err := s.db.GetSetting("registration_password")
-		if err != nil || regPassword != req.RegistrationPassword {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid registration password"})
+	case errors.Is(err, service.ErrRegistrationPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid registration password"})
+		return
+	case errors.Is(err, service.ErrInviteInvalid):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invite code"})
+		return
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrOrgLimit):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization has reached its user limit"})
+		return
+	case errors.Is(err, service.ErrUsernameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+		return
+	default:
+		log.Printf("Failed to register user %q: %v", req.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+	userID := user.ID
+
+	if dob != nil {
+		if err := s.recordAge(userID, *dob); err != nil {
+			log.Printf("Failed to record the age of user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
 			return
 		}
 	}
 
-	// Validate password
-	if err := s.auth.ValidatePassword(req.Password); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	if req.Email != "" {
+		if err := s.stores().Users.SetEmail(c.Request.Context(), userID, req.Email); err != nil {
+			log.Printf("Failed to set email of user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+		// Failed deliveries are logged and recorded by sendEmail
+		if s.mailer != nil {
+			go func() {
+				_ = s.sendVerificationEmail(context.Background(), userID)
+			}()
+		}
 	}
 
-	// Hash password
-	hashedPassword, err := s.auth.HashPassword(req.Password)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+	// Unapproved and unverified users cannot sign in yet
+	if user.Pending {
+		s.notifyPendingRegistration(userID, orgID)
+	}
+	if user.Pending || verification == "required" {
+		c.JSON(http.StatusCreated, gin.H{
+			"approval_required":     user.Pending,
+			"verification_required": verification == "required",
+			"user": gin.H{
+				"id":       userID,
+				"username": req.Username,
+				"email":    req.Email,
+				"role":     "user",
+				"org_id":   orgID,
+			},
+		})
 		return
 	}
 
-	// Insert user into database
-	result, err := s.db.Exec(
-		"INSERT INTO users (username, email, password_hash, role) VALUES (?, ?, ?, ?)",
-		req.Username, "", hashedPassword, "user",
-	)
+	// Generate token
+	token, err := s.auth.GenerateToken(userID, req.Username, "user")
 	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
-
-	userID, _ := result.LastInsertId()
-
-	// Generate token
-	token, err := s.auth.GenerateToken(int(userID), req.Username, "user")
+	refreshToken, err := s.issueRefreshToken(s.db, userID, "", 0, "")
 	if err != nil {
+		log.Printf("Failed to issue refresh token for user %d: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
 		"user": gin.H{
 			"id":       userID,
 			"username": req.Username,
-			"email":    "", // Email is now optional
+			"email":    req.Email,
 			"role":     "user",
+			"org_id":   orgID,
 		},
 	})
 }
@@ -384,81 +1054,194 @@ func (s *Server) handleLogin(c *gin.Context) {
 		return
 	}
 
-	// Get user from database
-	var userID int
-	var username, email, passwordHash, role string
-	err := s.db.QueryRow(
-		"SELECT id, username, email, password_hash, role FROM users WHERE username = ?",
-		req.Username,
-	).Scan(&userID, &username, &email, &passwordHash, &role)
+	requestedOrgID, ok := s.requestOrgID(c)
+	if !ok {
+		return
+	}
+
+	// Repeated failures slow down and then lock out the username and IP
+	if !s.allowLoginAttempt(c, req.Username) {
+		return
+	}
 
+	user, err := s.services.Auth.Authenticate(req.Username, req.Password, requestedOrgID)
+	if errors.Is(err, service.ErrBanned) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+		return
+	}
+	if errors.Is(err, service.ErrPendingApproval) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is waiting for an admin's approval", "code": "approval_pending"})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		s.recordLoginFailure(c, req.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
 	if err != nil {
+		log.Printf("Failed to authenticate %q: %v", req.Username, err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
+	s.resetLoginFailures(req.Username)
 
-	// Check password
-	if !s.auth.CheckPassword(req.Password, passwordHash) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	// With two-factor sign-in on, the password only earns a challenge to
+	// finish at /api/auth/2fa/verify
+	if user.TwoFactor {
+		challenge, err := s.auth.GenerateChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int(auth.ChallengeTokenTTL.Seconds()),
+		})
 		return
 	}
 
-	// Generate token
-	token, err := s.auth.GenerateToken(userID, username, role)
+	s.completeLogin(c, user.ID, user.Username, user.Email, user.Role, user.TokenVersion, user.OrgID)
+}
+
+// completeLogin signs in a user whose credentials have been checked,
+// recording the device and issuing tokens
+func (s *Server) completeLogin(c *gin.Context, userID int, username, email, role string, tokenVersion, orgID int) {
+	// Unverified users get a new verification link instead of a session
+	if s.emailVerificationMode() == "required" && s.needsVerification(userID) {
+		err := s.sendVerificationEmail(c.Request.Context(), userID)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Verify your email address to sign in",
+			"code":       "email_unverified",
+			"email_sent": err == nil,
+		})
+		return
+	}
+
+	session, err := s.startSession(c, userID, username, role, tokenVersion)
+	if errors.Is(err, errRecordLogin) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":         session.Token,
+		"refresh_token": session.RefreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+		"new_device":    session.NewDevice,
 		"user": gin.H{
 			"id":       userID,
 			"username": username,
 			"email":    email,
 			"role":     role,
+			"org_id":   orgID,
 		},
 	})
 }
 
+// loginSession is the tokens of a new sign-in
+type loginSession struct {
+	Token        string
+	RefreshToken string
+	NewDevice    bool
+}
+
+var errRecordLogin = errors.New("failed to record login")
+
+// startSession records the device a user signs in from and issues their
+// tokens
+func (s *Server) startSession(c *gin.Context, userID int, username, role string, tokenVersion int) (*loginSession, error) {
+	s.touchLastSeen(userID)
+	s.reassessAge(userID)
+
+	// Remember the device and warn the user about unrecognized ones
+	device, isNewDevice, err := s.recordLoginDevice(userID, c)
+	if err != nil {
+		log.Printf("Failed to record login device for user %d: %v", userID, err)
+		return nil, errRecordLogin
+	}
+	if isNewDevice {
+		s.notifyNewDevice(userID, device)
+	}
+
+	// Generate token
+	deviceID := strconv.FormatInt(device.ID, 10)
+	token, err := s.auth.GenerateDeviceToken(userID, username, role, deviceID, tokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.issueRefreshToken(s.db, userID, deviceID, tokenVersion, "")
+	if err != nil {
+		log.Printf("Failed to issue refresh token for user %d: %v", userID, err)
+		return nil, err
+	}
+	return &loginSession{Token: token, RefreshToken: refreshToken, NewDevice: isNewDevice}, nil
+}
+
 func (s *Server) handleGuestLogin(c *gin.Context) {
+	orgID, ok := s.requestOrgID(c)
+	if !ok {
+		return
+	}
+
 	// Check if guest mode is enabled
-	guestModeEnabled, err := s.db.GetSetting("guest_mode_enabled")
+	guestModeEnabled, err := s.orgSetting(orgID, "guest_mode_enabled")
 	if err != nil || guestModeEnabled != "true" {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Guest mode is not enabled"})
 		return
 	}
+	if err := s.checkOrgLimit(orgID, "users"); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization has reached its user limit"})
+		return
+	}
 
-	// Create a temporary guest user
-	guestUsername := fmt.Sprintf("guest_%d", time.Now().Unix())
-
-	// Insert guest user into database
-	result, err := s.db.Exec(
-		"INSERT INTO users (username, email, password_hash, role, created_at) VALUES (?, ?, ?, ?, ?)",
-		guestUsername, "", "$2a$10$guest.user.password.hash.placeholder", "user", time.Now(),
-	)
-	if err != nil {
+	// Create an ephemeral guest user under a generated name; the password
+	// hash matches no password, so nobody can sign in as them
+	expiresAt := time.Now().UTC().Add(guestTTL)
+	var guestUsername string
+	var userID int64
+	for attempt := 0; attempt < 5 && userID == 0; attempt++ {
+		if guestUsername, err = generateGuestName(); err != nil {
+			break
+		}
+		userID, err = s.stores().Users.CreateGuest(c.Request.Context(), guestUsername, orgID, expiresAt)
+	}
+	if userID == 0 {
 		log.Printf("Guest user creation error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest user"})
 		return
 	}
-
-	userID, _ := result.LastInsertId()
+	if err := s.joinGuestServers(int(userID), orgID); err != nil {
+		log.Printf("Failed to add guest %d to the guest channels' servers: %v", userID, err)
+	}
 
 	// Generate token
-	token, err := s.auth.GenerateToken(int(userID), guestUsername, "user")
+	token, err := s.auth.GenerateToken(int(userID), guestUsername, "guest")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	refreshToken, err := s.issueRefreshToken(s.db, int(userID), "", 0, "")
 	if err != nil {
+		log.Printf("Failed to issue refresh token for user %d: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
 		"user": gin.H{
-			"id":       userID,
-			"username": guestUsername,
-			"email":    "",
-			"role":     "user",
+			"id":         userID,
+			"username":   guestUsername,
+			"email":      "",
+			"role":       "guest",
+			"expires_at": expiresAt,
 		},
 	})
 }
@@ -485,38 +1268,21 @@ func (s *Server) handleCreateServer(c *gin.Context) {
 	}
 
 	userID := c.GetInt("user_id")
-
-	// Create server
-	result, err := s.db.Exec(
-		"INSERT INTO servers (name, description, owner_id) VALUES (?, ?, ?)",
-		req.Name, req.Description, userID,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server"})
+	created, err := s.services.Servers.Create(userID, c.GetInt("org_id"), req.Name, req.Description)
+	if errors.Is(err, service.ErrOrgLimit) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization has reached its server limit"})
 		return
 	}
-
-	serverID, _ := result.LastInsertId()
-
-	// Add owner as member
-	_, err = s.db.Exec(
-		"INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)",
-		userID, serverID, "owner",
-	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add server member"})
+		log.Printf("Failed to create server for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server"})
 		return
 	}
+	serverID := created.ID
 
-	// Create default general channel
-	_, err = s.db.Exec(
-		"INSERT INTO channels (name, server_id, channel_type) VALUES (?, ?, ?)",
-		"general", serverID, "text",
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create default channel"})
-		return
-	}
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.bumpResourceVersion(membersResource(serverID))
+	s.markWrite(userID)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"id":          serverID,
@@ -529,38 +1295,14 @@ func (s *Server) handleCreateServer(c *gin.Context) {
 func (s *Server) handleGetServers(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	rows, err := s.db.Query(`
-		SELECT s.id, s.name, s.description, s.owner_id, s.created_at
-		FROM servers s
-		JOIN server_members sm ON s.id = sm.server_id
-		WHERE sm.user_id = ?
-		ORDER BY s.created_at DESC
-	`, userID)
+	list, err := store.New(s.reader(c)).Servers.ListForUser(c.Request.Context(), userID, c.GetInt("org_id"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get servers"})
 		return
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
 
 	var servers []gin.H
-	for rows.Next() {
-		var server struct {
-			ID          int    `json:"id"`
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			OwnerID     int    `json:"owner_id"`
-			CreatedAt   string `json:"created_at"`
-		}
-
-		err := rows.Scan(&server.ID, &server.Name, &server.Description, &server.OwnerID, &server.CreatedAt)
-		if err != nil {
-			continue
-		}
-
+	for _, server := range list {
 		servers = append(servers, gin.H{
 			"id":          server.ID,
 			"name":        server.Name,
@@ -569,146 +1311,222 @@ func (s *Server) handleGetServers(c *gin.Context) {
 			"created_at":  server.CreatedAt,
 		})
 	}
+	for _, server := range servers {
+		server["quotas"] = s.serverQuotasJSON(server["id"])
+	}
 
 	c.JSON(http.StatusOK, gin.H{"servers": servers})
 }
 
 func (s *Server) handleGetServer(c *gin.Context) {
-	serverID := c.Param("id")
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
 	userID := c.GetInt("user_id")
 
 	// Check if user is member of server
-	var exists bool
-	err := s.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM server_members WHERE user_id = ? AND server_id = ?)",
-		userID, serverID,
-	).Scan(&exists)
-
+	exists, err := s.stores().Servers.IsMember(c.Request.Context(), userID, serverID)
 	if err != nil || !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	// Get server details
-	var server struct {
-		ID          int    `json:"id"`
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		OwnerID     int    `json:"owner_id"`
-		CreatedAt   string `json:"created_at"`
-	}
-
-	err = s.db.QueryRow(
-		"SELECT id, name, description, owner_id, created_at FROM servers WHERE id = ?",
-		serverID,
-	).Scan(&server.ID, &server.Name, &server.Description, &server.OwnerID, &server.CreatedAt)
-
+	server, err := store.New(s.reader(c)).Servers.Get(c.Request.Context(), serverID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
+	owners, err := s.serverOwnerIDs(server.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get server owners"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":          server.ID,
 		"name":        server.Name,
 		"description": server.Description,
 		"owner_id":    server.OwnerID,
+		"owner_ids":   owners,
 		"created_at":  server.CreatedAt,
+		"quotas":      s.serverQuotasJSON(server.ID),
+		"version":     server.Version,
 	})
 }
 
-func (s *Server) handleCreateChannel(c *gin.Context) {
-	serverID := c.Param("id")
-	userID := c.GetInt("user_id")
+// serverSettingsJSON describes a server as PATCH /servers/:id edits it
+func serverSettingsJSON(server *service.Server) gin.H {
+	return gin.H{
+		"id":          server.ID,
+		"name":        server.Name,
+		"description": server.Description,
+		"owner_id":    server.OwnerID,
+		"version":     server.Version,
+	}
+}
 
+// handleUpdateServer renames a server or changes its description; owners
+// and admins only. Omitted fields keep their values. The edit must name
+// the version it was made against; see checkVersion.
+func (s *Server) handleUpdateServer(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		ChannelType string `json:"channel_type"`
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Version     *int64  `json:"version"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if req.ChannelType == "" {
-		req.ChannelType = "text"
+	current, err := s.services.Servers.Get(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
 	}
-
-	// Check if user is owner or has permission
-	var role string
-	err := s.db.QueryRow(
-		"SELECT role FROM server_members WHERE user_id = ? AND server_id = ?",
-		userID, serverID,
-	).Scan(&role)
-
-	if err != nil || (role != "owner" && role != "admin") {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !checkVersion(c, req.Version, current.Version, serverSettingsJSON(current)) {
 		return
 	}
+	name, description := current.Name, current.Description
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.Description != nil {
+		description = *req.Description
+	}
 
-	// Create channel
-	result, err := s.db.Exec(
-		"INSERT INTO channels (name, server_id, channel_type) VALUES (?, ?, ?)",
-		req.Name, serverID, req.ChannelType,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
+	updated, err := s.services.Servers.Update(serverID, name, description, current.Version)
+	var invalid *service.ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	case errors.Is(err, service.ErrVersionConflict):
+		if latest, err := s.services.Servers.Get(serverID); err == nil {
+			versionConflict(c, latest.Version, serverSettingsJSON(latest))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
+	default:
+		log.Printf("Failed to update server %d: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
 		return
 	}
+	s.markWrite(c.GetInt("user_id"))
 
-	channelID, _ := result.LastInsertId()
-
-	c.JSON(http.StatusCreated, gin.H{
+	c.Header("ETag", versionETag(updated.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    serverSettingsJSON(updated),
+	})
+}
+
+func (s *Server) handleCreateChannel(c *gin.Context) {
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		ChannelType string `json:"channel_type"`
+		NSFW        bool   `json:"nsfw"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ChannelType == "" {
+		req.ChannelType = "text"
+	}
+
+	// Check if user is owner or has permission
+	stores := s.stores()
+	role, err := stores.Servers.MemberRole(c.Request.Context(), userID, serverID)
+	if err != nil || (role != "owner" && role != "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+	if !respondQuotaCheck(c, s.checkServerQuota(serverID, quotaChannels, 1)) {
+		return
+	}
+
+	// Create channel
+	channelID, err := stores.Channels.Create(c.Request.Context(), store.NewChannel{
+		ServerID:    serverID,
+		Name:        req.Name,
+		ChannelType: req.ChannelType,
+		NSFW:        req.NSFW,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
+		return
+	}
+
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.markWrite(userID)
+
+	c.JSON(http.StatusCreated, gin.H{
 		"id":           channelID,
 		"name":         req.Name,
 		"server_id":    serverID,
 		"channel_type": req.ChannelType,
+		"nsfw":         req.NSFW,
 	})
 }
 
 func (s *Server) handleGetChannels(c *gin.Context) {
-	serverID := c.Param("id")
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
 	userID := c.GetInt("user_id")
 
 	// Check if user is member of server
-	var exists bool
-	err := s.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM server_members WHERE user_id = ? AND server_id = ?)",
-		userID, serverID,
-	).Scan(&exists)
-
+	exists, err := s.stores().Servers.IsMember(c.Request.Context(), userID, serverID)
 	if err != nil || !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	// Get channels
-	rows, err := s.db.Query(
-		"SELECT id, name, channel_type, created_at FROM channels WHERE server_id = ? ORDER BY created_at ASC",
-		serverID,
-	)
+	// Reads may be served by a replica; the version comes from the same one
+	reader := s.reader(c)
+	resource := channelsResource(serverID)
+	version, updatedAt := s.resourceVersion(reader, resource)
+	var guestChannels map[int]bool
+	var guestTag []string
+	if c.GetBool("guest") {
+		guestChannels = s.guestChannels()
+		setting, _ := s.db.GetSetting("guest_channels")
+		guestTag = append(guestTag, "guest="+setting)
+	}
+	if checkNotModified(c, resourceETag(resource, version, guestTag...), updatedAt) {
+		return
+	}
+
+	// Get the channels this member can see; guests only the guest channels
+	list, err := store.New(reader).Channels.ListVisible(c.Request.Context(), userID, serverID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get channels"})
 		return
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
 
 	var channels []gin.H
-	for rows.Next() {
-		var channel struct {
-			ID          int    `json:"id"`
-			Name        string `json:"name"`
-			ChannelType string `json:"channel_type"`
-			CreatedAt   string `json:"created_at"`
-		}
-
-		err := rows.Scan(&channel.ID, &channel.Name, &channel.ChannelType, &channel.CreatedAt)
-		if err != nil {
+	for _, channel := range list {
+		if guestChannels != nil && !guestChannels[channel.ID] {
 			continue
 		}
 
@@ -716,7 +1534,13 @@ func (s *Server) handleGetChannels(c *gin.Context) {
 			"id":           channel.ID,
 			"name":         channel.Name,
 			"channel_type": channel.ChannelType,
+			"private":      channel.Private,
+			"public":       channel.Public,
+			"nsfw":         channel.NSFW,
+			"user_limit":   channel.UserLimit,
+			"owner_id":     nullIntPtr(channel.OwnerID),
 			"created_at":   channel.CreatedAt,
+			"version":      channel.Version,
 		})
 	}
 
@@ -735,65 +1559,23 @@ func (s *Server) handleGetMessages(c *gin.Context) {
 	}
 
 	// Check if user has access to channel
-	var exists bool
-	err = s.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM channels c
-			JOIN server_members sm ON c.server_id = sm.server_id
-			WHERE c.id = ? AND sm.user_id = ?
-		)
-	`, channelIDInt, userID).Scan(&exists)
-
+	exists, err := s.stores().Channels.CanView(c.Request.Context(), userID, channelIDInt)
 	if err != nil || !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
 		return
 	}
 
-	// Get messages
-	rows, err := s.db.Query(`
-		SELECT m.id, m.content, m.created_at, m.user_id, u.username
-		FROM messages m
-		JOIN users u ON m.user_id = u.id
-		WHERE m.channel_id = ?
-		ORDER BY m.created_at DESC
-		LIMIT 50
-	`, channelIDInt)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+	reader := s.reader(c)
+	resource := messagesResource(channelIDInt)
+	version, updatedAt := s.resourceVersion(reader, resource)
+	if checkNotModified(c, resourceETag(resource, version), updatedAt) {
 		return
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
-
-	messages := make([]gin.H, 0) // Initialize as empty slice, not nil
-	for rows.Next() {
-		var message struct {
-			ID        int    `json:"id"`
-			Content   string `json:"content"`
-			CreatedAt string `json:"created_at"`
-			UserID    int    `json:"user_id"`
-			Username  string `json:"username"`
-		}
 
-		err := rows.Scan(&message.ID, &message.Content, &message.CreatedAt, &message.UserID, &message.Username)
-		if err != nil {
-			continue
-		}
-
-		messages = append(messages, gin.H{
-			"id":        message.ID,
-			"content":   message.Content,
-			"createdAt": message.CreatedAt,
-			"authorId":  message.UserID,
-			"channelId": channelIDInt,
-			"author": gin.H{
-				"id":       message.UserID,
-				"username": message.Username,
-			},
-		})
+	messages, err := s.queryMessages(reader, channelIDInt, userID, "ORDER BY m.created_at DESC LIMIT 50")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
 	}
 
 	log.Printf("Returning %d messages for channel %d", len(messages), channelIDInt)
@@ -809,7 +1591,10 @@ func (s *Server) handleSendMessage(c *gin.Context) {
 	log.Printf("📤 [SERVER] Received message request - Channel: %s, User: %s (ID: %d)", channelID, username, userID)
 
 	var req struct {
-		Content string `json:"content" binding:"required"`
+		Content       string         `json:"content" binding:"required"`
+		ClientNonce   string         `json:"client_nonce"`
+		AttachmentIDs []snowflake.ID `json:"attachment_ids"`
+		ReplyToID     *snowflake.ID  `json:"reply_to_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -818,22 +1603,128 @@ func (s *Server) handleSendMessage(c *gin.Context) {
 		return
 	}
 
+	key, err := idempotencyKey(c, req.ClientNonce)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A retried request returns the message created by the first attempt
+	if key != "" {
+		if original, ok := s.findIdempotentMessage(userID, key); ok {
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Message sent successfully",
+				"data":    original,
+			})
+			return
+		}
+	}
+
 	log.Printf("📝 [SERVER] Message content: %s", req.Content)
 
-	// Insert message into database
-	result, err := s.db.Exec(
-		"INSERT INTO messages (channel_id, user_id, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
-		channelID, userID, req.Content,
-	)
+	// Check the channel exists and the user can post in it
+	channelIDParsed, err := strconv.Atoi(channelID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	channel, err := s.lookupChannelForUser(userID, channelIDParsed)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+	if s.raidHoldsBack(channel.ServerID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "New members cannot post while the server is in raid mode", "code": "raid_mode"})
+		return
+	}
+	quarantined, err := s.services.Messages.CheckPost(channel.ServerID, userID)
+	if errors.Is(err, service.ErrMuted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are muted in this server", "code": "muted"})
+		return
+	}
+	if errors.Is(err, service.ErrPolicyNotAccepted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Accept the current terms and rules before posting", "code": "policy_not_accepted"})
+		return
+	}
 	if err != nil {
-		log.Printf("❌ [SERVER] Failed to insert message into database: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
 	}
+	// The server's content filter may censor the message or refuse it;
+	// nothing past this point sees the original
+	verdict, ok := s.checkContent(c, channel.ServerID, req.Content)
+	if !ok {
+		return
+	}
+	req.Content = verdict.Content
 
-	messageID, _ := result.LastInsertId()
+	// Replies join the thread of the message they answer
+	message := service.Message{ChannelID: channel.ID, UserID: userID, Content: req.Content, Quarantined: quarantined}
+	if req.ReplyToID != nil {
+		root, err := s.services.Messages.ReplyThread(channel.ID, int64(*req.ReplyToID))
+		if errors.Is(err, service.ErrReplyTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		message.ReplyToID, message.ThreadID = int64(*req.ReplyToID), root
+	}
+
+	attachments, err := s.claimAttachments(userID, snowflake.Int64s(req.AttachmentIDs))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(attachments) > 0 {
+		var size int64
+		for _, a := range attachments {
+			size += a.Size
+		}
+		if !respondQuotaCheck(c, s.checkServerQuota(channel.ServerID, quotaUploads, size)) {
+			return
+		}
+	}
+
+	messageID, err := s.services.Messages.Create(message)
+	if err != nil {
+		log.Printf("❌ [SERVER] Failed to insert message into database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
 	log.Printf("✅ [SERVER] Message inserted into database with ID: %d", messageID)
 
+	if key != "" && !s.claimIdempotencyKey(userID, key, messageID) {
+		// A concurrent retry won the race; drop our copy and return theirs
+		if err := s.stores().Messages.Delete(c.Request.Context(), messageID); err != nil {
+			log.Printf("Failed to remove duplicate message %d: %v", messageID, err)
+		}
+		if original, ok := s.findIdempotentMessage(userID, key); ok {
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Message sent successfully",
+				"data":    original,
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Duplicate request in progress"})
+		return
+	}
+	s.linkAttachments(messageID, attachments)
+	attachmentData := make([]gin.H, 0, len(attachments))
+	for _, a := range attachments {
+		attachmentData = append(attachmentData, s.attachmentJSON(a))
+	}
+
+	s.bumpResourceVersion(messagesResource(channelIDParsed))
+	// Keep the sender's reads on the primary until replicas have the message
+	s.markWrite(userID)
+
 	// Convert channelID to int for WebSocket message
 	channelIDInt := 0
 	if _, err := fmt.Sscanf(channelID, "%d", &channelIDInt); err != nil {
@@ -841,174 +1732,674 @@ func (s *Server) handleSendMessage(c *gin.Context) {
 		channelIDInt = 0
 	}
 
+	responseData := gin.H{
+		"id":          snowflake.ID(messageID),
+		"channel_id":  channelID,
+		"user_id":     userID,
+		"username":    username,
+		"content":     req.Content,
+		"created_at":  time.Now().Format(time.RFC3339),
+		"attachments": attachmentData,
+	}
+	if req.ReplyToID != nil {
+		responseData["reply_to_id"] = snowflake.ID(message.ReplyToID)
+		responseData["thread_id"] = snowflake.ID(message.ThreadID)
+	}
+
 	// Broadcast message to all connected clients via WebSocket
 	wsMessage := &websocket.Message{
-		Type:      "text",
+		Type:      string(events.TypeText),
 		ChannelID: channelIDInt,
 		Content:   req.Content,
 		UserID:    userID,
 		Username:  username,
 		Timestamp: time.Now(),
-		Data: gin.H{
-			"id":         messageID,
-			"channel_id": channelID,
-			"user_id":    userID,
-			"username":   username,
-			"content":    req.Content,
-			"created_at": time.Now().Format(time.RFC3339),
-		},
+		Data:      responseData,
 	}
 
-	log.Printf("📡 [SERVER] Broadcasting message to channel %d: %s", channelIDInt, req.Content)
-	s.hub.BroadcastMessage(wsMessage)
+	if quarantined {
+		// Only the sender and moderators see it, and nothing else hears
+		// about it
+		s.deliverQuarantined(wsMessage, responseData, channel.ServerID, userID)
+	} else {
+		log.Printf("📡 [SERVER] Broadcasting message to channel %d: %s", channelIDInt, req.Content)
+		s.hub.BroadcastMessage(wsMessage)
 
-	responseData := gin.H{
-		"id":         messageID,
-		"channel_id": channelID,
-		"user_id":    userID,
-		"username":   username,
-		"content":    req.Content,
-		"created_at": time.Now().Format(time.RFC3339),
+		// Queue mentions for users who are not connected
+		s.queueOfflineMentions(channelIDInt, messageID, userID, req.Content)
+
+		// Tell thread followers about replies
+		if req.ReplyToID != nil {
+			s.recordReply(channelIDInt, message.ThreadID, messageID, userID, req.Content)
+		}
+
+		// Mirror the message to XMPP room occupants
+		s.relayToXMPP(channelIDInt, messageID, username, req.Content)
+
+		// Deliver to automation hooks
+		s.notifyMessageHooks(channelIDInt, messageID, userID, username, "", req.Content)
+
+		// Track support response times in ticket channels
+		s.recordTicketResponse(channelIDInt, userID)
+
+		// Award XP toward the sender's level
+		s.awardMessageXP(channel.ServerID, userID)
+	}
+	s.recordMessageUsage(userID)
+
+	log.Printf("✅ [SERVER] Sending response to client: %+v", responseData)
+	response := gin.H{
+		"success": true,
+		"message": "Message sent successfully",
+		"data":    responseData,
+	}
+	if report := verdict.report(); report != nil {
+		response["content_filter"] = report
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleEventSchema serves the JSON Schema of every event. It is a plain
+// schema document rather than the usual envelope so generators can read it.
+func (s *Server) handleEventSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, events.Schema())
+}
+
+func (s *Server) handleWebSocket(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	username := c.GetString("username")
+
+	// Optional event categories the client wants, e.g. ?events=typing,presence
+	var wantedEvents []string
+	if events, ok := c.GetQuery("events"); ok {
+		parsed, err := websocket.ParseEventCategories(events)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		wantedEvents = parsed
+	}
+
+	// Upgrade HTTP connection to WebSocket
+	conn, err := s.hub.Upgrade(c.Writer, c.Request)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	s.touchLastSeen(userID)
+
+	// Create new client
+	client := websocket.NewClient(conn, s.hub, userID, username)
+	client.SetRemoteIP(c.ClientIP())
+	client.SetSession(c.GetString("device_id"))
+	client.SetReadOnly(c.GetBool("guest") || c.GetBool("read_only") || s.inStandby())
+	if wantedEvents != nil {
+		if err := client.SetEventFilter(wantedEvents); err != nil {
+			log.Printf("Failed to apply event filter for user %s: %v", username, err)
+		}
+	}
+
+	// Register client
+	s.clientsMux.Lock()
+	s.clients[userID] = client
+	s.clientsMux.Unlock()
+
+	// Start client
+	client.Start()
+
+	// Summarize what the user missed while offline
+	s.deliverCatchUp(client, userID)
+	s.deliverNotifications(client, userID)
+}
+
+func (s *Server) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Bots and scripts authenticate with a personal API key instead of
+		// a session, on the REST API and the sockets alike
+		if key := apiKeyFromRequest(c); key != "" {
+			s.authenticateAPIKey(c, key)
+			return
+		}
+
+		// Handle WebSocket authentication for the chat and voice socket
+		// routes, which sit under the base path behind a reverse proxy
+		route := c.FullPath()
+		if route == s.basePath+"/ws" || route == s.basePath+"/ws/voice" || route == s.basePath+"/voice" {
+			// Extract token from query parameter for WebSocket
+			token := c.Query("token")
+			if token == "" {
+				log.Printf("WebSocket auth failed: no token provided for path %s", c.Request.URL.Path)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := s.auth.ValidateToken(token)
+			if err != nil {
+				log.Printf("WebSocket auth failed: token validation error for path %s: %v", c.Request.URL.Path, err)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			if s.isUserBanned(claims.UserID) {
+				log.Printf("WebSocket auth failed: user %d is banned", claims.UserID)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+
+			if claims.DeviceID != "" && s.isDeviceRevoked(claims.UserID, claims.DeviceID) {
+				log.Printf("WebSocket auth failed: device %s of user %d is revoked", claims.DeviceID, claims.UserID)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			if !s.tokenVersionValid(claims.UserID, claims.TokenVersion) {
+				log.Printf("WebSocket auth failed: stale token version for user %d", claims.UserID)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			if claims.DeviceID != "" {
+				s.touchSession(claims.UserID, claims.DeviceID, c.ClientIP())
+			}
+
+			if !s.allowGuest(c, claims.UserID) || !s.allowUnverified(c, claims.UserID) {
+				return
+			}
+
+			log.Printf("WebSocket auth successful: user %d (%s) for path %s", claims.UserID, claims.Username, c.Request.URL.Path)
+			c.Set("user_id", claims.UserID)
+			c.Set("username", claims.Username)
+			c.Set("device_id", claims.DeviceID)
+			c.Next()
+			return
+		}
+
+		// Extract token from Authorization header
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid authorization header"})
+			c.Abort()
+			return
+		}
+
+		token := authHeader[7:]
+		claims, err := s.auth.ValidateToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if s.isUserBanned(claims.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+			c.Abort()
+			return
+		}
+
+		if claims.DeviceID != "" && s.isDeviceRevoked(claims.UserID, claims.DeviceID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		if !s.tokenVersionValid(claims.UserID, claims.TokenVersion) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		if claims.DeviceID != "" {
+			s.touchSession(claims.UserID, claims.DeviceID, c.ClientIP())
+		}
+
+		// Guests only read the guest channels
+		if !s.allowGuest(c, claims.UserID) {
+			return
+		}
+
+		// Unverified users may be held back by the email_verification setting
+		if !s.allowUnverified(c, claims.UserID) {
+			return
+		}
+
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("device_id", claims.DeviceID)
+		c.Next()
+	}
+}
+
+func (s *Server) handleGetSettings(c *gin.Context) {
+	settings, err := s.db.GetAllSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+
+	// Secrets are write-only; only whether they are set is shown
+	for key := range secretSettings {
+		if settings[key] != "" {
+			settings[key] = "[redacted]"
+		}
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func (s *Server) handleUpdateSettings(c *gin.Context) {
+	var req struct {
+		GuestModeEnabled *bool  `json:"guest_mode_enabled"`
+		AutoLoginEnabled *bool  `json:"auto_login_enabled"`
+		DefaultUsername  string `json:"default_username"`
+		DefaultPassword  string `json:"default_password"`
+
+		AuthMode             *string `json:"auth_mode"`
+		RegistrationPassword *string `json:"registration_password"`
+		DualApprovalEnabled  *bool   `json:"settings_dual_approval_enabled"`
+		EmailVerification    *string `json:"email_verification"`
+		RegistrationApproval *bool   `json:"registration_approval"`
+
+		// Date of birth at registration, and the ages it is checked against
+		AgeGate        *string `json:"age_gate"`
+		MinimumAge     *int    `json:"minimum_age"`
+		NSFWMinimumAge *int    `json:"nsfw_minimum_age"`
+
+		// Serve attachments through signed, CDN-cacheable URLs
+		AttachmentSignedURLs *bool `json:"attachment_signed_urls"`
+
+		// Image upload processing
+		AttachmentStripMetadata *bool   `json:"attachment_strip_metadata"`
+		AttachmentImageFormat   *string `json:"attachment_image_format"`
+		AttachmentImageQuality  *int    `json:"attachment_image_quality"`
+		AttachmentKeepOriginals *bool   `json:"attachment_keep_originals"`
+
+		// Digest emails for inactive users
+		DigestEnabled      *bool `json:"digest_enabled"`
+		DigestInactiveDays *int  `json:"digest_inactive_days"`
+		DigestIntervalDays *int  `json:"digest_interval_days"`
+		DigestHour         *int  `json:"digest_hour"`
+
+		// IANA zone digests are scheduled and reminders tell the time in
+		ServerTimezone *string `json:"server_timezone"`
+
+		// Minutes between refreshes of channel calendar feeds
+		CalendarRefreshMinutes *int `json:"calendar_refresh_minutes"`
+
+		// Public directory of listed servers
+		ServerDirectoryEnabled *bool `json:"server_directory_enabled"`
+
+		// Channels guests may read
+		GuestChannels *[]int `json:"guest_channels"`
+
+		// Network ACL of IPs and CIDRs, the admin lists for admin routes
+		IPAllowlist      *[]string `json:"ip_allowlist"`
+		IPDenylist       *[]string `json:"ip_denylist"`
+		AdminIPAllowlist *[]string `json:"admin_ip_allowlist"`
+		AdminIPDenylist  *[]string `json:"admin_ip_denylist"`
+
+		// Default server quotas, 0 is unlimited
+		ServerMaxChannels   *int `json:"server_max_channels"`
+		ServerMaxMembers    *int `json:"server_max_members"`
+		ServerMaxWebhooks   *int `json:"server_max_webhooks"`
+		ServerUploadQuotaMB *int `json:"server_upload_quota_mb"`
+
+		PasswordPolicy *auth.PasswordPolicy `json:"password_policy"`
+
+		// Anti-abuse challenge on self-registration
+		RegistrationChallenge *struct {
+			Type       *string `json:"type"`
+			SiteKey    *string `json:"site_key"`
+			Secret     *string `json:"secret"`
+			Difficulty *int    `json:"difficulty"`
+		} `json:"registration_challenge"`
+
+		// Single sign-on with an OpenID Connect provider
+		OIDC *struct {
+			Enabled      *bool   `json:"enabled"`
+			Issuer       *string `json:"issuer"`
+			ClientID     *string `json:"client_id"`
+			ClientSecret *string `json:"client_secret"`
+			DisplayName  *string `json:"display_name"`
+			AutoCreate   *bool   `json:"auto_create"`
+			LinkEmail    *bool   `json:"link_email"`
+		} `json:"oidc"`
+
+		// Required when changing security-sensitive settings
+		CurrentPassword string `json:"current_password"`
+
+		// Voice idle disconnect policy in minutes, 0 disables the rule
+		VoiceAloneTimeoutMinutes     *int `json:"voice_alone_timeout_minutes"`
+		VoiceMutedIdleTimeoutMinutes *int `json:"voice_muted_idle_timeout_minutes"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt("user_id")
+
+	proposed := make(map[string]string)
+	if req.GuestModeEnabled != nil {
+		proposed["guest_mode_enabled"] = fmt.Sprintf("%t", *req.GuestModeEnabled)
+	}
+	if req.AutoLoginEnabled != nil {
+		proposed["auto_login_enabled"] = fmt.Sprintf("%t", *req.AutoLoginEnabled)
+	}
+	if req.DefaultUsername != "" {
+		proposed["default_username"] = req.DefaultUsername
+	}
+	if req.DefaultPassword != "" {
+		proposed["default_password"] = req.DefaultPassword
+	}
+	if req.AuthMode != nil {
+		if !service.AuthModes[*req.AuthMode] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auth mode"})
+			return
+		}
+		proposed["auth_mode"] = *req.AuthMode
+	}
+	if req.RegistrationPassword != nil {
+		proposed["registration_password"] = *req.RegistrationPassword
+	}
+	if req.DualApprovalEnabled != nil {
+		proposed["settings_dual_approval_enabled"] = fmt.Sprintf("%t", *req.DualApprovalEnabled)
+	}
+	if req.EmailVerification != nil {
+		if !emailVerificationModes[*req.EmailVerification] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email_verification must be off, restrict or required"})
+			return
+		}
+		if *req.EmailVerification != "off" && s.mailer == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email_verification needs email delivery to be configured"})
+			return
+		}
+		proposed["email_verification"] = *req.EmailVerification
+	}
+	if req.RegistrationApproval != nil {
+		proposed["registration_approval"] = fmt.Sprintf("%t", *req.RegistrationApproval)
+	}
+	if req.AgeGate != nil {
+		if !ageGateModes[*req.AgeGate] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "age_gate must be off, optional or required"})
+			return
+		}
+		proposed["age_gate"] = *req.AgeGate
+	}
+	if req.MinimumAge != nil {
+		if *req.MinimumAge < 0 || *req.MinimumAge > maxAge {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minimum_age must be between 0 and 120"})
+			return
+		}
+		proposed["minimum_age"] = strconv.Itoa(*req.MinimumAge)
+	}
+	if req.NSFWMinimumAge != nil {
+		if *req.NSFWMinimumAge < 1 || *req.NSFWMinimumAge > maxAge {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nsfw_minimum_age must be between 1 and 120"})
+			return
+		}
+		proposed["nsfw_minimum_age"] = strconv.Itoa(*req.NSFWMinimumAge)
+	}
+
+	if req.AttachmentSignedURLs != nil {
+		proposed["attachment_signed_urls"] = fmt.Sprintf("%t", *req.AttachmentSignedURLs)
+	}
+	if req.AttachmentStripMetadata != nil {
+		proposed["attachment_strip_metadata"] = fmt.Sprintf("%t", *req.AttachmentStripMetadata)
+	}
+	if req.AttachmentImageFormat != nil {
+		format := strings.ToLower(*req.AttachmentImageFormat)
+		supported := format == ""
+		for _, candidate := range media.SupportedFormats() {
+			supported = supported || format == candidate
+		}
+		if !supported {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("attachment_image_format must be empty or one of: %s", strings.Join(media.SupportedFormats(), ", "))})
+			return
+		}
+		proposed["attachment_image_format"] = format
+	}
+	if req.AttachmentImageQuality != nil {
+		if *req.AttachmentImageQuality < 1 || *req.AttachmentImageQuality > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_image_quality must be between 1 and 100"})
+			return
+		}
+		proposed["attachment_image_quality"] = strconv.Itoa(*req.AttachmentImageQuality)
+	}
+	if req.AttachmentKeepOriginals != nil {
+		proposed["attachment_keep_originals"] = fmt.Sprintf("%t", *req.AttachmentKeepOriginals)
+	}
+
+	if req.DigestEnabled != nil {
+		proposed["digest_enabled"] = fmt.Sprintf("%t", *req.DigestEnabled)
+	}
+	if req.DigestInactiveDays != nil {
+		if *req.DigestInactiveDays < 1 || *req.DigestInactiveDays > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "digest_inactive_days must be between 1 and 365"})
+			return
+		}
+		proposed["digest_inactive_days"] = strconv.Itoa(*req.DigestInactiveDays)
+	}
+	if req.DigestIntervalDays != nil {
+		if *req.DigestIntervalDays < 1 || *req.DigestIntervalDays > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "digest_interval_days must be between 1 and 90"})
+			return
+		}
+		proposed["digest_interval_days"] = strconv.Itoa(*req.DigestIntervalDays)
+	}
+	if req.DigestHour != nil {
+		if *req.DigestHour < 0 || *req.DigestHour > 23 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "digest_hour must be between 0 and 23"})
+			return
+		}
+		proposed["digest_hour"] = strconv.Itoa(*req.DigestHour)
+	}
+	if req.ServerTimezone != nil {
+		if !validTimezone(*req.ServerTimezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "server_timezone must be an IANA time zone such as Europe/Berlin"})
+			return
+		}
+		proposed["server_timezone"] = *req.ServerTimezone
+	}
+	if req.CalendarRefreshMinutes != nil {
+		if *req.CalendarRefreshMinutes < 5 || *req.CalendarRefreshMinutes > 1440 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "calendar_refresh_minutes must be between 5 and 1440"})
+			return
+		}
+		proposed["calendar_refresh_minutes"] = strconv.Itoa(*req.CalendarRefreshMinutes)
+	}
+	if req.ServerDirectoryEnabled != nil {
+		proposed["server_directory_enabled"] = fmt.Sprintf("%t", *req.ServerDirectoryEnabled)
+	}
+	if req.GuestChannels != nil {
+		channels, ok := s.parseGuestChannels(*req.GuestChannels)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "guest_channels must list public text channels"})
+			return
+		}
+		proposed["guest_channels"] = channels
+	}
+	for key, value := range map[string]*[]string{
+		"ip_allowlist":       req.IPAllowlist,
+		"ip_denylist":        req.IPDenylist,
+		"admin_ip_allowlist": req.AdminIPAllowlist,
+		"admin_ip_denylist":  req.AdminIPDenylist,
+	} {
+		if value == nil {
+			continue
+		}
+		list, err := parseIPList(strings.Join(*value, ","))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": key + ": " + err.Error()})
+			return
+		}
+		proposed[key] = list.String()
+	}
+	for key, value := range map[string]*int{
+		"server_max_channels":    req.ServerMaxChannels,
+		"server_max_members":     req.ServerMaxMembers,
+		"server_max_webhooks":    req.ServerMaxWebhooks,
+		"server_upload_quota_mb": req.ServerUploadQuotaMB,
+	} {
+		if value == nil {
+			continue
+		}
+		if *value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": key + " must not be negative"})
+			return
+		}
+		proposed[key] = strconv.Itoa(*value)
 	}
 
-	log.Printf("✅ [SERVER] Sending response to client: %+v", responseData)
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Message sent successfully",
-		"data":    responseData,
-	})
-}
-
-func (s *Server) handleWebSocket(c *gin.Context) {
-	userID := c.GetInt("user_id")
-	username := c.GetString("username")
-
-	// Upgrade HTTP connection to WebSocket
-	conn, err := websocket.Upgrade(c.Writer, c.Request)
-	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
-		return
+	if req.PasswordPolicy != nil {
+		if req.PasswordPolicy.MinLength < 8 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "password_policy.min_length must be at least 8"})
+			return
+		}
+		for key, value := range passwordPolicySettings(*req.PasswordPolicy) {
+			proposed[key] = value
+		}
 	}
 
-	// Create new client
-	client := websocket.NewClient(conn, s.hub, userID, username)
-
-	// Register client
-	s.clientsMux.Lock()
-	s.clients[userID] = client
-	s.clientsMux.Unlock()
-
-	// Start client
-	client.Start()
-}
-
-func (s *Server) authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Handle WebSocket authentication for both /ws and /voice paths
-		if c.Request.URL.Path == "/ws" || c.Request.URL.Path == "/voice" {
-			// Extract token from query parameter for WebSocket
-			token := c.Query("token")
-			if token == "" {
-				log.Printf("WebSocket auth failed: no token provided for path %s", c.Request.URL.Path)
-				c.AbortWithStatus(http.StatusUnauthorized)
+	if rc := req.RegistrationChallenge; rc != nil {
+		if rc.Type != nil {
+			if !registrationChallengeTypes[*rc.Type] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "registration_challenge.type must be off, pow, hcaptcha or turnstile"})
 				return
 			}
-
-			claims, err := s.auth.ValidateToken(token)
-			if err != nil {
-				log.Printf("WebSocket auth failed: token validation error for path %s: %v", c.Request.URL.Path, err)
-				c.AbortWithStatus(http.StatusUnauthorized)
+			proposed["registration_challenge"] = *rc.Type
+		}
+		if rc.SiteKey != nil {
+			proposed["registration_challenge_site_key"] = strings.TrimSpace(*rc.SiteKey)
+		}
+		if rc.Secret != nil {
+			proposed["registration_challenge_secret"] = strings.TrimSpace(*rc.Secret)
+		}
+		if rc.Difficulty != nil {
+			if *rc.Difficulty < challenge.MinDifficulty || *rc.Difficulty > challenge.MaxDifficulty {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("registration_challenge.difficulty must be between %d and %d", challenge.MinDifficulty, challenge.MaxDifficulty)})
 				return
 			}
-
-			log.Printf("WebSocket auth successful: user %d (%s) for path %s", claims.UserID, claims.Username, c.Request.URL.Path)
-			c.Set("user_id", claims.UserID)
-			c.Set("username", claims.Username)
-			c.Next()
-			return
+			proposed["registration_pow_difficulty"] = strconv.Itoa(*rc.Difficulty)
 		}
 
-		// Extract token from Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid authorization header"})
-			c.Abort()
-			return
+		// A CAPTCHA cannot be verified without its keys
+		effective := func(key string) string {
+			if value, ok := proposed[key]; ok {
+				return value
+			}
+			value, _ := s.db.GetSetting(key)
+			return value
 		}
-
-		token := authHeader[7:]
-		claims, err := s.auth.ValidateToken(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+		if captchaChallenge(effective("registration_challenge")) &&
+			(effective("registration_challenge_site_key") == "" || effective("registration_challenge_secret") == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "registration_challenge needs a site_key and secret for " + effective("registration_challenge")})
 			return
 		}
-
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Next()
 	}
-}
 
-func (s *Server) adminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID := c.GetInt("user_id")
-		var role string
-		err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
-		if err != nil || (role != "super_admin" && role != "admin") {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can access this endpoint"})
-			c.Abort()
-			return
+	if oidc := req.OIDC; oidc != nil {
+		if oidc.Issuer != nil {
+			issuer := strings.TrimRight(strings.TrimSpace(*oidc.Issuer), "/")
+			if issuer != "" && !validOIDCIssuer(issuer) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "oidc.issuer must be an https URL"})
+				return
+			}
+			proposed["oidc_issuer"] = issuer
+		}
+		for key, value := range map[string]*string{
+			"oidc_client_id":     oidc.ClientID,
+			"oidc_client_secret": oidc.ClientSecret,
+			"oidc_display_name":  oidc.DisplayName,
+		} {
+			if value != nil {
+				proposed[key] = strings.TrimSpace(*value)
+			}
+		}
+		for key, value := range map[string]*bool{
+			"oidc_enabled":     oidc.Enabled,
+			"oidc_auto_create": oidc.AutoCreate,
+			"oidc_link_email":  oidc.LinkEmail,
+		} {
+			if value != nil {
+				proposed[key] = fmt.Sprintf("%t", *value)
+			}
 		}
-		c.Next()
 	}
-}
 
-func (s *Server) handleGetSettings(c *gin.Context) {
-	settings, err := s.db.GetAllSettings()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
-		return
-	}
-	c.JSON(http.StatusOK, settings)
-}
+	changes := s.diffSettings(proposed)
 
-func (s *Server) handleUpdateSettings(c *gin.Context) {
-	var req struct {
-		GuestModeEnabled bool   `json:"guest_mode_enabled"`
-		AutoLoginEnabled bool   `json:"auto_login_enabled"`
-		DefaultUsername  string `json:"default_username"`
-		DefaultPassword  string `json:"default_password"`
+	// Admins cannot lock themselves out with the network lists
+	lists := s.networkLists()
+	for _, change := range changes {
+		lists[change.Key] = change.New
 	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !newNetworkACL(lists).allows(clientIP(c.ClientIP()), true) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The network lists would block your own IP"})
 		return
 	}
 
-	// Update settings
-	if err := s.db.SetSetting("guest_mode_enabled", fmt.Sprintf("%t", req.GuestModeEnabled), "Enable guest mode for unauthenticated users"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update guest mode setting"})
-		return
+	// Security-sensitive changes require re-entering the admin's password
+	for _, change := range changes {
+		if sensitiveSettings[change.Key] && !s.confirmAdminPassword(adminID, req.CurrentPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password confirmation required", "code": "reauth_required"})
+			return
+		}
 	}
 
-	if err := s.db.SetSetting("auto_login_enabled", fmt.Sprintf("%t", req.AutoLoginEnabled), "Enable automatic login with default credentials"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update auto login setting"})
-		return
+	// With dual approval on, super-sensitive changes wait for a second admin
+	dualApproval := s.dualApprovalEnabled()
+	pending := make([]gin.H, 0)
+	for _, change := range changes {
+		if dualApproval && superSensitiveSettings[change.Key] {
+			id, err := s.requestSettingApproval(adminID, change)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request approval for " + change.Key})
+				return
+			}
+			pending = append(pending, gin.H{"id": id, "key": change.Key})
+			continue
+		}
+
+		if err := s.applySettingChange(adminID, change); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update " + change.Key})
+			return
+		}
 	}
 
-	if req.DefaultUsername != "" {
-		if err := s.db.SetSetting("default_username", req.DefaultUsername, "Default username for auto login"); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default username"})
+	if req.VoiceAloneTimeoutMinutes != nil {
+		if *req.VoiceAloneTimeoutMinutes < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "voice_alone_timeout_minutes must not be negative"})
+			return
+		}
+		if err := s.db.SetSetting("voice_alone_timeout_minutes", strconv.Itoa(*req.VoiceAloneTimeoutMinutes), "Minutes a user may stay alone in a voice channel before being disconnected (0 disables)"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update voice alone timeout"})
 			return
 		}
 	}
 
-	if req.DefaultPassword != "" {
-		if err := s.db.SetSetting("default_password", req.DefaultPassword, "Default password for auto login"); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default password"})
+	if req.VoiceMutedIdleTimeoutMinutes != nil {
+		if *req.VoiceMutedIdleTimeoutMinutes < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "voice_muted_idle_timeout_minutes must not be negative"})
 			return
 		}
+		if err := s.db.SetSetting("voice_muted_idle_timeout_minutes", strconv.Itoa(*req.VoiceMutedIdleTimeoutMinutes), "Minutes a muted, inactive voice user may stay connected (0 disables)"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update voice muted idle timeout"})
+			return
+		}
+	}
+
+	s.applyVoiceIdlePolicy()
+	s.applyPasswordPolicy()
+	s.applyLoginLockoutPolicy()
+
+	if len(pending) > 0 {
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"message": "Settings updated; some changes are awaiting approval by another admin",
+			"pending": pending,
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -1020,52 +2411,31 @@ func (s *Server) handleUpdateSettings(c *gin.Context) {
 // Admin User Management Handlers
 
 func (s *Server) handleGetUsers(c *gin.Context) {
-	rows, err := s.db.Query(`
-		SELECT id, username, email, role, created_at, updated_at,
-		       (SELECT COUNT(*) FROM messages WHERE user_id = users.id) as message_count,
-		       (SELECT COUNT(*) FROM server_members WHERE user_id = users.id) as server_count
-		FROM users 
-		ORDER BY created_at DESC
-	`)
+	list, err := s.stores().Users.List(c.Request.Context(), c.GetInt("org_id"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 		return
 	}
-	defer rows.Close()
 
 	var users []gin.H
-	for rows.Next() {
-		var user struct {
-			ID           int    `json:"id"`
-			Username     string `json:"username"`
-			Email        string `json:"email"`
-			Role         string `json:"role"`
-			CreatedAt    string `json:"created_at"`
-			UpdatedAt    string `json:"updated_at"`
-			MessageCount int    `json:"message_count"`
-			ServerCount  int    `json:"server_count"`
-		}
-
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt, &user.MessageCount, &user.ServerCount)
-		if err != nil {
-			continue
-		}
-
+	for _, user := range list {
 		// Check if user is online
 		s.clientsMux.RLock()
 		_, isOnline := s.clients[user.ID]
 		s.clientsMux.RUnlock()
 
 		users = append(users, gin.H{
-			"id":            user.ID,
-			"username":      user.Username,
-			"email":         user.Email,
-			"role":          user.Role,
-			"created_at":    user.CreatedAt,
-			"updated_at":    user.UpdatedAt,
-			"message_count": user.MessageCount,
-			"server_count":  user.ServerCount,
-			"is_online":     isOnline,
+			"id":             user.ID,
+			"username":       user.Username,
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"role":           user.Role,
+			"created_at":     user.CreatedAt,
+			"updated_at":     user.UpdatedAt,
+			"message_count":  user.MessageCount,
+			"server_count":   user.ServerCount,
+			"org_id":         user.OrgID,
+			"is_online":      isOnline,
 		})
 	}
 
@@ -1081,6 +2451,10 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 		Email    string `json:"email"`
 		Password string `json:"password" binding:"required"`
 		Role     string `json:"role"`
+
+		// Instance admins may place the user in an organization;
+		// organization admins always create users in their own
+		OrgID *int `json:"org_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1089,7 +2463,7 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 	}
 
 	// Validate password
-	if err := s.auth.ValidatePassword(req.Password); err != nil {
+	if err := s.auth.ValidatePasswordForUser(req.Password, req.Username); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -1100,11 +2474,34 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 	}
 
 	// Validate role
-	if req.Role != "user" && req.Role != "admin" && req.Role != "super_admin" {
+	if !s.roleAssignable(req.Role) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
 		return
 	}
 
+	// Only super admins may create admins and users with custom roles
+	if req.Role != "user" && !s.isSuperAdmin(c.GetInt("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can assign roles other than user"})
+		return
+	}
+
+	orgID := c.GetInt("org_id")
+	if orgID == 0 && req.OrgID != nil && *req.OrgID != 0 {
+		if _, err := s.loadOrganization(*req.OrgID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not found"})
+			return
+		}
+		orgID = *req.OrgID
+	}
+	if orgID != 0 && req.Role == "super_admin" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Super admins cannot belong to an organization"})
+		return
+	}
+	if err := s.checkOrgLimit(orgID, "users"); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization has reached its user limit"})
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := s.auth.HashPassword(req.Password)
 	if err != nil {
@@ -1112,17 +2509,20 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 		return
 	}
 
-	// Insert user
-	result, err := s.db.Exec(
-		"INSERT INTO users (username, email, password_hash, role) VALUES (?, ?, ?, ?)",
-		req.Username, req.Email, hashedPassword, req.Role,
-	)
+	// Insert user; an address an admin enters counts as verified
+	userID, err := s.stores().Users.Create(c.Request.Context(), store.NewUser{
+		Username:      req.Username,
+		Email:         req.Email,
+		PasswordHash:  hashedPassword,
+		Role:          req.Role,
+		OrgID:         orgID,
+		EmailVerified: req.Email != "",
+	})
 	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
-
-	userID, _ := result.LastInsertId()
+	s.syncRoleCapabilities(userID, req.Role, c.GetInt("user_id"))
 
 	// Log the action
 	s.logAdminAction(c.GetInt("user_id"), "create_user", fmt.Sprintf("Created user %s with role %s", req.Username, req.Role))
@@ -1135,12 +2535,17 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 			"username": req.Username,
 			"email":    req.Email,
 			"role":     req.Role,
+			"org_id":   orgID,
 		},
 	})
 }
 
 func (s *Server) handleUpdateUser(c *gin.Context) {
-	userID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
 	var req struct {
 		Username string `json:"username"`
 		Email    string `json:"email"`
@@ -1152,22 +2557,24 @@ func (s *Server) handleUpdateUser(c *gin.Context) {
 		return
 	}
 
-	// Build update query
-	updates := []string{}
-	args := []interface{}{}
-
-	if req.Username != "" {
-		updates = append(updates, "username = ?")
-		args = append(args, req.Username)
-	}
-
-	if req.Email != "" {
-		updates = append(updates, "email = ?")
-		args = append(args, req.Email)
+	if req.Username == "" && req.Email == "" && req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
 	}
 
+	// An address an admin enters counts as verified
+	users := s.stores().Users
+	update := store.UserUpdate{Username: req.Username, Email: req.Email}
 	if req.Password != "" {
-		if err := s.auth.ValidatePassword(req.Password); err != nil {
+		// Check against the new username if it is changing
+		username := req.Username
+		if username == "" {
+			if username, err = users.Username(c.Request.Context(), userID); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+		}
+		if err := s.auth.ValidatePasswordForUser(req.Password, username); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -1178,27 +2585,25 @@ func (s *Server) handleUpdateUser(c *gin.Context) {
 			return
 		}
 
-		updates = append(updates, "password_hash = ?")
-		args = append(args, hashedPassword)
+		// A password change signs the user out of existing sessions
+		update.PasswordHash = hashedPassword
 	}
 
-	if len(updates) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+	if err := users.Update(c.Request.Context(), userID, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	s.bumpUserMemberships(userID)
 
-	updates = append(updates, "updated_at = CURRENT_TIMESTAMP")
-	args = append(args, userID)
-
-	query := fmt.Sprintf("UPDATE users SET %s WHERE id = ?", strings.Join(updates, ", "))
-	_, err := s.db.Exec(query, args...)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
-		return
+	if req.Password != "" {
+		if err := s.revokeRefreshTokens(userID); err != nil {
+			log.Printf("Failed to revoke refresh tokens of user %d: %v", userID, err)
+		}
+		s.disconnectUser(userID, "logout", "Password changed")
 	}
 
 	// Log the action
-	s.logAdminAction(c.GetInt("user_id"), "update_user", fmt.Sprintf("Updated user ID %s", userID))
+	s.logAdminAction(c.GetInt("user_id"), "update_user", fmt.Sprintf("Updated user ID %d", userID))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1207,34 +2612,45 @@ func (s *Server) handleUpdateUser(c *gin.Context) {
 }
 
 func (s *Server) handleDeleteUser(c *gin.Context) {
-	userID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
 
 	// Check if user exists
-	var username string
-	err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	users := s.stores().Users
+	username, err := users.Username(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Delete user (cascade will handle related data)
-	_, err = s.db.Exec("DELETE FROM users WHERE id = ?", userID)
+	// Servers must keep an owner, so their last one cannot be deleted
+	lastOwned, err := users.LastOwnedServers(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
+	if lastOwned > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("User is the last owner of %d server(s); another member must be made an owner first", lastOwned)})
+		return
+	}
 
-	// Disconnect user if online
-	userIDInt, _ := strconv.Atoi(userID)
-	s.clientsMux.Lock()
-	if client, exists := s.clients[userIDInt]; exists {
-		client.Close()
-		delete(s.clients, userIDInt)
+	// Member lists change once the user is gone
+	s.bumpUserMemberships(userID)
+
+	// Delete user (cascade will handle related data)
+	if err := users.Delete(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
 	}
-	s.clientsMux.Unlock()
+
+	// Disconnect user if online
+	s.disconnectUser(userID, "delete", "Account deleted")
 
 	// Log the action
-	s.logAdminAction(c.GetInt("user_id"), "delete_user", fmt.Sprintf("Deleted user %s (ID: %s)", username, userID))
+	s.logAdminAction(c.GetInt("user_id"), "delete_user", fmt.Sprintf("Deleted user %s (ID: %d)", username, userID))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1243,7 +2659,11 @@ func (s *Server) handleDeleteUser(c *gin.Context) {
 }
 
 func (s *Server) handleUpdateUserRole(c *gin.Context) {
-	userID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
 	var req struct {
 		Role string `json:"role" binding:"required"`
 	}
@@ -1254,20 +2674,37 @@ func (s *Server) handleUpdateUserRole(c *gin.Context) {
 	}
 
 	// Validate role
-	if req.Role != "user" && req.Role != "admin" && req.Role != "super_admin" {
+	if !s.roleAssignable(req.Role) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
 		return
 	}
 
-	// Update role
-	_, err := s.db.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", req.Role, userID)
+	// Only super admins may grant or revoke admin and custom roles
+	users := s.stores().Users
+	user, err := users.Get(c.Request.Context(), userID)
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if (req.Role != "user" || user.Role != "user") && !s.isSuperAdmin(c.GetInt("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can assign roles other than user"})
+		return
+	}
+	if user.OrgID != 0 && req.Role == "super_admin" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Super admins cannot belong to an organization"})
+		return
+	}
+
+	// Update role
+	if err := users.SetRole(c.Request.Context(), userID, req.Role); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 		return
 	}
+	s.bumpUserMemberships(userID)
+	s.syncRoleCapabilities(userID, req.Role, c.GetInt("user_id"))
 
 	// Log the action
-	s.logAdminAction(c.GetInt("user_id"), "update_role", fmt.Sprintf("Updated user ID %s role to %s", userID, req.Role))
+	s.logAdminAction(c.GetInt("user_id"), "update_role", fmt.Sprintf("Updated user ID %d role to %s", userID, req.Role))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1288,18 +2725,29 @@ func (s *Server) handleKickUser(c *gin.Context) {
 		return
 	}
 
-	// Disconnect user if online
-	userIDInt, _ := strconv.Atoi(userID)
-	s.clientsMux.Lock()
-	if client, exists := s.clients[userIDInt]; exists {
-		client.Close()
-		delete(s.clients, userIDInt)
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Disconnect user from chat and voice if online, then sign them out so
+	// they cannot reconnect with the tokens they already hold
+	s.disconnectUser(userIDInt, "kick", req.Reason)
+	if err := s.invalidateTokens(userIDInt, req.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out user"})
+		return
 	}
-	s.clientsMux.Unlock()
 
 	// Log the action
 	s.logAdminAction(c.GetInt("user_id"), "kick_user", fmt.Sprintf("Kicked user ID %s. Reason: %s", userID, req.Reason))
 
+	// Let plugins react to the kick
+	s.emitPluginEvent(plugins.EventUserKicked, userIDInt, map[string]interface{}{
+		"moderator_id": c.GetInt("user_id"),
+		"reason":       req.Reason,
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "User kicked successfully",
@@ -1318,29 +2766,21 @@ func (s *Server) handleBanUser(c *gin.Context) {
 		return
 	}
 
-	// Create ban record
-	expiresAt := "NULL"
-	if req.Duration > 0 {
-		expiresAt = fmt.Sprintf("datetime('now', '+%d hours')", req.Duration)
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
 	}
 
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO user_bans (user_id, reason, banned_by, expires_at, created_at) 
-		VALUES (?, ?, ?, %s, CURRENT_TIMESTAMP)
-	`, userID, req.Reason, c.GetInt("user_id"), expiresAt)
+	err = s.services.Moderation.Ban(userIDInt, c.GetInt("user_id"), req.Reason, time.Duration(req.Duration)*time.Hour)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban user"})
 		return
 	}
 
-	// Disconnect user if online
-	userIDInt, _ := strconv.Atoi(userID)
-	s.clientsMux.Lock()
-	if client, exists := s.clients[userIDInt]; exists {
-		client.Close()
-		delete(s.clients, userIDInt)
-	}
-	s.clientsMux.Unlock()
+	// Disconnect user from chat and voice if online; existing tokens are
+	// rejected by authMiddleware while the ban is active
+	s.disconnectUser(userIDInt, "ban", req.Reason)
 
 	// Log the action
 	durationStr := "permanent"
@@ -1349,6 +2789,13 @@ func (s *Server) handleBanUser(c *gin.Context) {
 	}
 	s.logAdminAction(c.GetInt("user_id"), "ban_user", fmt.Sprintf("Banned user ID %s for %s. Reason: %s", userID, durationStr, req.Reason))
 
+	// Let plugins react to the ban
+	s.emitPluginEvent(plugins.EventUserBanned, userIDInt, map[string]interface{}{
+		"moderator_id":   c.GetInt("user_id"),
+		"reason":         req.Reason,
+		"duration_hours": req.Duration,
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "User banned successfully",
@@ -1357,9 +2804,13 @@ func (s *Server) handleBanUser(c *gin.Context) {
 
 func (s *Server) handleUnbanUser(c *gin.Context) {
 	userID := c.Param("id")
-
-	_, err := s.db.Exec("DELETE FROM user_bans WHERE user_id = ?", userID)
+	userIDInt, err := strconv.Atoi(userID)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := s.services.Moderation.Unban(userIDInt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unban user"})
 		return
 	}
@@ -1385,16 +2836,13 @@ func (s *Server) handleMuteUser(c *gin.Context) {
 		return
 	}
 
-	// Create mute record
-	expiresAt := "NULL"
-	if req.Duration > 0 {
-		expiresAt = fmt.Sprintf("datetime('now', '+%d minutes')", req.Duration)
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
 	}
 
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO user_mutes (user_id, reason, muted_by, expires_at, created_at) 
-		VALUES (?, ?, ?, %s, CURRENT_TIMESTAMP)
-	`, userID, req.Reason, c.GetInt("user_id"), expiresAt)
+	err = s.services.Moderation.Mute(userIDInt, c.GetInt("user_id"), req.Reason, time.Duration(req.Duration)*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mute user"})
 		return
@@ -1415,9 +2863,13 @@ func (s *Server) handleMuteUser(c *gin.Context) {
 
 func (s *Server) handleUnmuteUser(c *gin.Context) {
 	userID := c.Param("id")
-
-	_, err := s.db.Exec("DELETE FROM user_mutes WHERE user_id = ?", userID)
+	userIDInt, err := strconv.Atoi(userID)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := s.services.Moderation.Unmute(userIDInt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmute user"})
 		return
 	}
@@ -1444,10 +2896,10 @@ func (s *Server) handleAdminHealth(c *gin.Context) {
 	}
 
 	// Get basic stats
-	var userCount, messageCount, serverCount int
-	s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
-	s.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&messageCount)
-	s.db.QueryRow("SELECT COUNT(*) FROM servers").Scan(&serverCount)
+	stores := s.stores()
+	userCount, _ := stores.Users.Count(c.Request.Context())
+	messageCount, _ := stores.Messages.Count(c.Request.Context())
+	serverCount, _ := stores.Servers.Count(c.Request.Context())
 
 	// Get online users count
 	s.clientsMux.RLock()
@@ -1457,16 +2909,28 @@ func (s *Server) handleAdminHealth(c *gin.Context) {
 	// Get voice statistics
 	voiceStats := s.voiceHub.GetVoiceStats()
 
+	// Latest release from the background check
+	updateStatus := s.updates.Status()
+	latestVersion := ""
+	if updateStatus.Latest != nil {
+		latestVersion = updateStatus.Latest.Version
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
 			"database": gin.H{
-				"status": dbStatus,
-				"type":   "sqlite3",
+				"status":      dbStatus,
+				"type":        "sqlite3",
+				"maintenance": s.maintenance.Status(),
+				"replicas":    s.db.ReplicaStatuses(),
 			},
 			"server": gin.H{
-				"status": "healthy",
-				"uptime": time.Since(time.Now()).String(), // This will be negative, need to track start time
+				"status":           "healthy",
+				"version":          updateStatus.Current,
+				"latest_version":   latestVersion,
+				"update_available": updateStatus.UpdateAvailable,
+				"uptime":           time.Since(time.Now()).String(), // This will be negative, need to track start time
 			},
 			"websocket": gin.H{
 				"status":      "healthy",
@@ -1486,27 +2950,17 @@ func (s *Server) handleAdminHealth(c *gin.Context) {
 
 func (s *Server) handleGetMetrics(c *gin.Context) {
 	// Get user activity metrics
-	var activeUsers, newUsersToday, messagesToday int
-
-	s.db.QueryRow("SELECT COUNT(*) FROM users WHERE updated_at > datetime('now', '-1 day')").Scan(&activeUsers)
-	s.db.QueryRow("SELECT COUNT(*) FROM users WHERE created_at > datetime('now', '-1 day')").Scan(&newUsersToday)
-	s.db.QueryRow("SELECT COUNT(*) FROM messages WHERE created_at > datetime('now', '-1 day')").Scan(&messagesToday)
+	stores := s.stores()
+	activeUsers, _ := stores.Users.CountUpdatedSince(c.Request.Context(), 24*time.Hour)
+	newUsersToday, _ := stores.Users.CountCreatedSince(c.Request.Context(), 24*time.Hour)
+	messagesToday, _ := stores.Messages.CountCreatedSince(c.Request.Context(), 24*time.Hour)
 
 	// Get role distribution
-	rows, err := s.db.Query("SELECT role, COUNT(*) FROM users GROUP BY role")
+	roleDistribution, err := stores.Users.RoleCounts(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role metrics"})
 		return
 	}
-	defer rows.Close()
-
-	roleDistribution := make(map[string]int)
-	for rows.Next() {
-		var role string
-		var count int
-		rows.Scan(&role, &count)
-		roleDistribution[role] = count
-	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1526,12 +2980,16 @@ func (s *Server) handleGetOnlineUsers(c *gin.Context) {
 	s.clientsMux.RLock()
 	onlineUsers := make([]gin.H, 0, len(s.clients))
 	for userID, client := range s.clients {
-		onlineUsers = append(onlineUsers, gin.H{
+		onlineUser := gin.H{
 			"id":           userID,
 			"username":     client.GetUsername(),
-			"ip":           client.GetConnection().RemoteAddr().String(),
+			"ip":           client.GetRemoteIP(),
 			"connected_at": time.Now().Format(time.RFC3339), // We don't track connection time yet
-		})
+		}
+		if location := s.locate(client.GetRemoteIP()); location != nil {
+			onlineUser["location"] = location
+		}
+		onlineUsers = append(onlineUsers, onlineUser)
 	}
 	s.clientsMux.RUnlock()
 
@@ -1550,7 +3008,7 @@ func (s *Server) handleGetUserLatency(c *gin.Context) {
 		latencyData = append(latencyData, gin.H{
 			"id":       userID,
 			"username": client.GetUsername(),
-			"ip":       client.GetConnection().RemoteAddr().String(),
+			"ip":       client.GetRemoteIP(),
 			"latency":  "N/A", // Would be calculated from ping/pong
 		})
 	}
@@ -1563,46 +3021,38 @@ func (s *Server) handleGetUserLatency(c *gin.Context) {
 }
 
 func (s *Server) handleGetAuditLogs(c *gin.Context) {
-	limit := c.DefaultQuery("limit", "50")
-	offset := c.DefaultQuery("offset", "0")
-
-	rows, err := s.db.Query(`
-		SELECT al.id, al.admin_id, u.username as admin_username, al.action, al.details, al.created_at
-		FROM audit_logs al
-		LEFT JOIN users u ON al.admin_id = u.id
-		ORDER BY al.created_at DESC
-		LIMIT ? OFFSET ?
-	`, limit, offset)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	entries, err := s.stores().Audit.List(c.Request.Context(), limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit logs"})
 		return
 	}
-	defer rows.Close()
 
 	var logs []gin.H
-	for rows.Next() {
-		var log struct {
-			ID            int    `json:"id"`
-			AdminID       int    `json:"admin_id"`
-			AdminUsername string `json:"admin_username"`
-			Action        string `json:"action"`
-			Details       string `json:"details"`
-			CreatedAt     string `json:"created_at"`
-		}
-
-		err := rows.Scan(&log.ID, &log.AdminID, &log.AdminUsername, &log.Action, &log.Details, &log.CreatedAt)
-		if err != nil {
-			continue
-		}
-
-		logs = append(logs, gin.H{
+	for _, log := range entries {
+		entry := gin.H{
 			"id":             log.ID,
 			"admin_id":       log.AdminID,
 			"admin_username": log.AdminUsername,
 			"action":         log.Action,
 			"details":        log.Details,
+			"ip":             log.IP,
 			"created_at":     log.CreatedAt,
-		})
+		}
+		if location := s.locate(log.IP); location != nil {
+			entry["location"] = location
+		}
+		logs = append(logs, entry)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -1611,28 +3061,28 @@ func (s *Server) handleGetAuditLogs(c *gin.Context) {
 	})
 }
 
-// Helper method to log admin actions
-func (s *Server) logAdminAction(adminID int, action, details string) {
-	_, err := s.db.Exec(`
-		INSERT INTO audit_logs (admin_id, action, details, created_at) 
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
-	`, adminID, action, details)
+// Helper method to log admin actions. Returns the entry's ID, or 0 when it
+// could not be written. The entry records the IP of the admin's most
+// recently used session, which requests refresh as they come in.
+func (s *Server) logAdminAction(adminID int, action, details string) int64 {
+	id, err := s.stores().Audit.Log(context.Background(), adminID, action, details)
 	if err != nil {
 		log.Printf("Failed to log admin action: %v", err)
+		return 0
 	}
+	return id
 }
 
 func (s *Server) handleGetServerUsers(c *gin.Context) {
-	serverID := c.Param("id")
+	serverID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
 	userID := c.GetInt("user_id")
 
 	// Check if user is a member of this server
-	var isMember bool
-	err := s.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM server_members WHERE user_id = ? AND server_id = ?)",
-		userID, serverID,
-	).Scan(&isMember)
-
+	isMember, err := s.stores().Servers.IsMember(c.Request.Context(), userID, serverID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check server membership"})
 		return
@@ -1643,43 +3093,27 @@ func (s *Server) handleGetServerUsers(c *gin.Context) {
 		return
 	}
 
-	// Get all users who are members of this server
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, u.email, u.role, u.created_at, u.updated_at,
-		       0 as is_online
-		FROM users u
-		INNER JOIN server_members sm ON u.id = sm.user_id
-		WHERE sm.server_id = ?
-		ORDER BY u.username
-	`, serverID)
+	reader := s.reader(c)
+	resource := membersResource(serverID)
+	version, _ := s.resourceVersion(reader, resource)
 
+	// Get all users who are members of this server
+	members, err := store.New(reader).Servers.Members(c.Request.Context(), serverID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get server users"})
 		return
 	}
-	defer rows.Close()
 
 	var users []gin.H
-	for rows.Next() {
-		var user struct {
-			ID        int    `json:"id"`
-			Username  string `json:"username"`
-			Email     string `json:"email"`
-			Role      string `json:"role"`
-			CreatedAt string `json:"created_at"`
-			UpdatedAt string `json:"updated_at"`
-			IsOnline  bool   `json:"is_online"`
-		}
-
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt, &user.IsOnline)
-		if err != nil {
-			continue
-		}
-
+	var online []string
+	for _, user := range members {
 		// Check if user is online by looking at WebSocket clients
 		s.clientsMux.RLock()
 		_, isOnline := s.clients[user.ID]
 		s.clientsMux.RUnlock()
+		if isOnline {
+			online = append(online, strconv.Itoa(user.ID))
+		}
 
 		users = append(users, gin.H{
 			"id":         user.ID,
@@ -1692,6 +3126,13 @@ func (s *Server) handleGetServerUsers(c *gin.Context) {
 		})
 	}
 
+	// Presence is not versioned, so fold the online set into the ETag. The
+	// write time says nothing about presence, so there is no Last-Modified
+	// and If-Modified-Since cannot hide someone coming online.
+	if checkNotModified(c, resourceETag(resource, version, "online="+strings.Join(online, ".")), time.Time{}) {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    users,
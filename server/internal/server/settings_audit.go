@@ -0,0 +1,317 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// settingDescriptions lists the settings writable through the settings API
+var settingDescriptions = map[string]string{
+	"guest_mode_enabled":              "Enable guest mode for unauthenticated users",
+	"guest_channels":                  "Comma-separated IDs of the public text channels guests may read",
+	"auto_login_enabled":              "Enable automatic login with default credentials",
+	"default_username":                "Default username for auto login",
+	"default_password":                "Default password for auto login",
+	"auth_mode":                       "Registration mode: public, open_registration, invite_only or admin_only",
+	"registration_password":           "Password required to register in open_registration mode",
+	"ip_allowlist":                    "Comma-separated IPs and CIDRs allowed to reach the server (empty allows all)",
+	"ip_denylist":                     "Comma-separated IPs and CIDRs refused by the server",
+	"admin_ip_allowlist":              "Comma-separated IPs and CIDRs allowed to reach admin routes (empty allows all)",
+	"admin_ip_denylist":               "Comma-separated IPs and CIDRs refused on admin routes",
+	"registration_challenge":          "Challenge self-registration has to pass: off, pow (proof of work), hcaptcha or turnstile",
+	"registration_challenge_site_key": "Site key of the hCaptcha or Turnstile widget",
+	"registration_challenge_secret":   "Secret key used to verify hCaptcha or Turnstile responses",
+	"registration_pow_difficulty":     "Leading zero bits the registration proof of work needs",
+	"email_verification":              "What unverified users may do: off, restrict (read only) or required (cannot sign in)",
+	"registration_approval":           "Hold new registrations until an admin approves them",
+	"age_gate":                        "Date of birth asked at registration: off, optional or required",
+	"minimum_age":                     "Youngest age allowed to register when a date of birth is given",
+	"nsfw_minimum_age":                "Age below which accounts cannot see NSFW channels",
+	"settings_dual_approval_enabled":  "Require a second admin to approve super-sensitive settings changes",
+	"password_min_length":             "Minimum password length",
+	"password_require_number":         "Require at least one number in passwords",
+	"password_require_special":        "Require at least one special character in passwords",
+	"password_require_upper":          "Require at least one uppercase letter in passwords",
+	"password_require_lower":          "Require at least one lowercase letter in passwords",
+	"password_disallow_username":      "Reject passwords containing the username",
+	"password_block_common":           "Reject passwords from the common passwords list",
+	"password_hash_algorithm":         "Algorithm for new password hashes: bcrypt or argon2id; existing hashes are upgraded at sign-in",
+	"password_argon2_memory_kib":      "Memory in KiB used by each argon2id password hash",
+	"password_argon2_iterations":      "Passes over memory made by each argon2id password hash",
+	"login_backoff_seconds":           "Seconds to wait after a failed sign-in, doubling with each failure (0 disables)",
+	"login_lockout_threshold":         "Failed sign-ins to one username before it is locked out (0 disables)",
+	"login_lockout_ip_threshold":      "Failed sign-ins from one IP before it is locked out (0 disables)",
+	"login_lockout_minutes":           "Minutes a sign-in lockout lasts and failures are remembered",
+	"attachment_signed_urls":          "Serve attachments through signed URLs that a CDN can cache",
+	"attachment_strip_metadata":       "Strip EXIF, GPS and other metadata from uploaded images",
+	"attachment_image_format":         "Re-encode uploaded photos to this format (empty keeps the original)",
+	"attachment_image_quality":        "Quality (1-100) used when re-encoding uploaded photos",
+	"attachment_keep_originals":       "Keep the unprocessed original of uploaded images",
+	"digest_enabled":                  "Email weekly digests to users who have been away",
+	"digest_inactive_days":            "Days without connecting before a user gets digests",
+	"digest_interval_days":            "Minimum days between two digests to the same user",
+	"digest_hour":                     "Hour of the day, in the server timezone, digests are sent",
+	"server_timezone":                 "IANA time zone digests are scheduled and reminders tell the time in",
+	"calendar_refresh_minutes":        "Minutes between refreshes of channel calendar feeds",
+	"server_max_channels":             "Default maximum channels per server (0 is unlimited)",
+	"server_max_members":              "Default maximum members per server (0 is unlimited)",
+	"server_max_webhooks":             "Default maximum incoming webhooks per server (0 is unlimited)",
+	"server_upload_quota_mb":          "Default attachment storage per server in MB (0 is unlimited)",
+	"oidc_enabled":                    "Allow signing in with the OpenID Connect provider",
+	"oidc_issuer":                     "Issuer URL of the OpenID Connect provider",
+	"oidc_client_id":                  "Client ID registered with the OpenID Connect provider",
+	"oidc_client_secret":              "Client secret registered with the OpenID Connect provider",
+	"oidc_display_name":               "Name of the provider on the sign-in button",
+	"oidc_auto_create":                "Create an account on first sign-in with the provider",
+	"oidc_link_email":                 "Link first sign-ins with the provider to the account with the same verified email",
+}
+
+// sensitiveSettings require the admin to re-enter their password
+var sensitiveSettings = map[string]bool{
+	"auth_mode":                      true,
+	"guest_channels":                 true,
+	"registration_password":          true,
+	"guest_mode_enabled":             true,
+	"auto_login_enabled":             true,
+	"default_username":               true,
+	"default_password":               true,
+	"settings_dual_approval_enabled": true,
+	"oidc_enabled":                   true,
+	"oidc_issuer":                    true,
+	"oidc_client_id":                 true,
+	"oidc_client_secret":             true,
+	"oidc_auto_create":               true,
+	"oidc_link_email":                true,
+	"email_verification":             true,
+	"registration_approval":          true,
+	"age_gate":                       true,
+	"minimum_age":                    true,
+	"nsfw_minimum_age":               true,
+	"registration_challenge":         true,
+	"registration_challenge_secret":  true,
+	"ip_allowlist":                   true,
+	"ip_denylist":                    true,
+	"admin_ip_allowlist":             true,
+	"admin_ip_denylist":              true,
+}
+
+// superSensitiveSettings additionally need a second admin's approval when
+// dual approval is enabled
+var superSensitiveSettings = map[string]bool{
+	"auth_mode":                      true,
+	"guest_mode_enabled":             true,
+	"auto_login_enabled":             true,
+	"settings_dual_approval_enabled": true,
+	"oidc_enabled":                   true,
+	"oidc_issuer":                    true,
+	"oidc_link_email":                true,
+}
+
+// secretSettings are never written to audit logs in clear text nor read
+// back from the settings API
+var secretSettings = map[string]bool{
+	"registration_password":         true,
+	"default_password":              true,
+	"oidc_client_secret":            true,
+	"registration_challenge_secret": true,
+}
+
+// settingChange is a change to a single setting
+type settingChange struct {
+	Key string
+	Old string
+	New string
+}
+
+func redactSetting(key, value string) string {
+	if !secretSettings[key] {
+		return value
+	}
+	if value == "" {
+		return "(empty)"
+	}
+	return "[redacted]"
+}
+
+func (change settingChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", change.Key, redactSetting(change.Key, change.Old), redactSetting(change.Key, change.New))
+}
+
+// diffSettings returns the proposed settings whose values actually change, sorted by key
+func (s *Server) diffSettings(proposed map[string]string) []settingChange {
+	changes := make([]settingChange, 0)
+	for key, value := range proposed {
+		current, _ := s.db.GetSetting(key)
+		if current != value {
+			changes = append(changes, settingChange{Key: key, Old: current, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// confirmAdminPassword checks a re-entered password against the admin's account
+func (s *Server) confirmAdminPassword(adminID int, password string) bool {
+	if password == "" {
+		return false
+	}
+	var passwordHash string
+	if err := s.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", adminID).Scan(&passwordHash); err != nil {
+		return false
+	}
+	return s.auth.CheckPassword(password, passwordHash)
+}
+
+func (s *Server) dualApprovalEnabled() bool {
+	enabled, err := s.db.GetSetting("settings_dual_approval_enabled")
+	return err == nil && enabled == "true"
+}
+
+// applySettingChange writes a setting and records a detailed audit entry
+func (s *Server) applySettingChange(adminID int, change settingChange) error {
+	if err := s.db.SetSetting(change.Key, change.New, settingDescriptions[change.Key]); err != nil {
+		return err
+	}
+	s.logAdminAction(adminID, "update_setting", change.String())
+	return nil
+}
+
+// requestSettingApproval queues a super-sensitive change for a second admin
+func (s *Server) requestSettingApproval(adminID int, change settingChange) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO setting_change_requests (key, value, requested_by) VALUES (?, ?, ?)",
+		change.Key, change.New, adminID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	s.logAdminAction(adminID, "request_setting_change", fmt.Sprintf("Requested approval #%d for %s", id, change))
+	return id, nil
+}
+
+func (s *Server) handleGetSettingChangeRequests(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+
+	rows, err := s.db.Query(`
+		SELECT r.id, r.key, r.value, r.requested_by, u.username, r.status, r.created_at
+		FROM setting_change_requests r
+		JOIN users u ON u.id = r.requested_by
+		WHERE r.status = ?
+		ORDER BY r.created_at DESC`,
+		status,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get setting change requests"})
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	requests := make([]gin.H, 0)
+	for rows.Next() {
+		var id, requestedBy int
+		var key, value, username, requestStatus string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &key, &value, &requestedBy, &username, &requestStatus, &createdAt); err != nil {
+			continue
+		}
+		current, _ := s.db.GetSetting(key)
+		requests = append(requests, gin.H{
+			"id":           id,
+			"key":          key,
+			"current":      redactSetting(key, current),
+			"value":        redactSetting(key, value),
+			"requested_by": gin.H{"id": requestedBy, "username": username},
+			"status":       requestStatus,
+			"created_at":   createdAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    requests,
+	})
+}
+
+func (s *Server) handleDecideSettingChange(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID := c.GetInt("user_id")
+		requestID := c.Param("id")
+
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !s.confirmAdminPassword(adminID, req.CurrentPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password confirmation required", "code": "reauth_required"})
+			return
+		}
+
+		var change settingChange
+		var requestedBy int
+		err := s.db.QueryRow(
+			"SELECT key, value, requested_by FROM setting_change_requests WHERE id = ? AND status = 'pending'",
+			requestID,
+		).Scan(&change.Key, &change.New, &requestedBy)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Setting change request not found"})
+			return
+		}
+
+		if requestedBy == adminID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "A different admin must approve this change"})
+			return
+		}
+
+		status := "rejected"
+		if approve {
+			status = "approved"
+		}
+		result, err := s.db.Exec(
+			"UPDATE setting_change_requests SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'",
+			status, adminID, requestID,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update setting change request"})
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Setting change request was already decided"})
+			return
+		}
+
+		if !approve {
+			s.logAdminAction(adminID, "reject_setting_change", fmt.Sprintf("Rejected request #%s for %s", requestID, change.Key))
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Setting change rejected",
+			})
+			return
+		}
+
+		change.Old, _ = s.db.GetSetting(change.Key)
+		if err := s.applySettingChange(adminID, change); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply setting change"})
+			return
+		}
+		s.logAdminAction(adminID, "approve_setting_change", fmt.Sprintf("Approved request #%s requested by user ID %d", requestID, requestedBy))
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Setting change approved",
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettingsReauthAndDualApproval(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"auth_mode", "guest_mode_enabled", "auto_login_enabled", "settings_dual_approval_enabled", "digest_hour"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("auth_mode", "public", settingDescriptions["auth_mode"])
+	_ = db.SetSetting("guest_mode_enabled", "true", settingDescriptions["guest_mode_enabled"])
+	_ = db.SetSetting("auto_login_enabled", "true", settingDescriptions["auto_login_enabled"])
+	_ = db.SetSetting("settings_dual_approval_enabled", "false", settingDescriptions["settings_dual_approval_enabled"])
+	_ = db.SetSetting("digest_hour", "9", settingDescriptions["digest_hour"])
+
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	admins := make([]int, 2)
+	for i := range admins {
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash, role) VALUES (?, '', ?, 'admin')",
+			fmt.Sprintf("settings_admin%d_%d", i, time.Now().UnixNano()), hash)
+		if err != nil {
+			t.Fatalf("Failed to create admin: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		admins[i] = int(id)
+	}
+	requester, approver := admins[0], admins[1]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", id)
+	})
+	router.POST("/settings", s.handleUpdateSettings)
+	router.POST("/settings/changes/:id/approve", s.handleDecideSettingChange(true))
+	router.POST("/settings/changes/:id/reject", s.handleDecideSettingChange(false))
+	request := func(userID int, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-User", strconv.Itoa(userID))
+		router.ServeHTTP(w, r)
+		return w
+	}
+	setting := func(key string) string {
+		value, _ := db.GetSetting(key)
+		return value
+	}
+
+	// Settings left out of the request keep their values and need no password
+	if w := request(requester, "/settings", `{"digest_hour": 7}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected an ordinary change to apply, got %d: %s", w.Code, w.Body.String())
+	}
+	if setting("digest_hour") != "7" || setting("guest_mode_enabled") != "true" || setting("auto_login_enabled") != "true" {
+		t.Errorf("Expected only digest_hour to change, got guest mode %q and auto login %q",
+			setting("guest_mode_enabled"), setting("auto_login_enabled"))
+	}
+
+	// Sensitive settings need the admin's password
+	for _, body := range []string{
+		`{"guest_mode_enabled": false}`,
+		`{"guest_mode_enabled": false, "current_password": "wrong"}`,
+	} {
+		if w := request(requester, "/settings", body); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "reauth_required") {
+			t.Errorf("Expected %s to need the password, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+	if setting("guest_mode_enabled") != "true" {
+		t.Error("Expected guest mode unchanged without the password")
+	}
+	if w := request(requester, "/settings", `{"guest_mode_enabled": false, "current_password": "correct-horse-battery"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the change with the password, got %d: %s", w.Code, w.Body.String())
+	}
+	if setting("guest_mode_enabled") != "false" || setting("auto_login_enabled") != "true" {
+		t.Errorf("Expected only guest mode turned off, got guest mode %q and auto login %q",
+			setting("guest_mode_enabled"), setting("auto_login_enabled"))
+	}
+
+	// With dual approval on, super-sensitive changes wait for another admin
+	_ = db.SetSetting("settings_dual_approval_enabled", "true", settingDescriptions["settings_dual_approval_enabled"])
+	propose := func(mode string) string {
+		w := request(requester, "/settings", fmt.Sprintf(`{"auth_mode": %q, "current_password": "correct-horse-battery"}`, mode))
+		var response struct {
+			Pending []struct {
+				ID  int    `json:"id"`
+				Key string `json:"key"`
+			} `json:"pending"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusAccepted ||
+			len(response.Pending) != 1 || response.Pending[0].Key != "auth_mode" {
+			t.Fatalf("Expected the change to await approval, got %d: %s", w.Code, w.Body.String())
+		}
+		return strconv.Itoa(response.Pending[0].ID)
+	}
+	requestID := propose("invite_only")
+	if setting("auth_mode") != "public" {
+		t.Errorf("Expected auth_mode unchanged until approved, got %q", setting("auth_mode"))
+	}
+
+	approve := "/settings/changes/" + requestID + "/approve"
+	if w := request(requester, approve, `{"current_password": "correct-horse-battery"}`); w.Code != http.StatusForbidden ||
+		!strings.Contains(w.Body.String(), "different admin") {
+		t.Errorf("Expected the requester not to approve their own change, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(approver, approve, `{}`); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "reauth_required") {
+		t.Errorf("Expected the approver to need their password, got %d: %s", w.Code, w.Body.String())
+	}
+	if setting("auth_mode") != "public" {
+		t.Errorf("Expected auth_mode unchanged, got %q", setting("auth_mode"))
+	}
+	if w := request(approver, approve, `{"current_password": "correct-horse-battery"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected another admin to approve, got %d: %s", w.Code, w.Body.String())
+	}
+	if setting("auth_mode") != "invite_only" {
+		t.Errorf("Expected the approved auth_mode applied, got %q", setting("auth_mode"))
+	}
+	if w := request(approver, approve, `{"current_password": "correct-horse-battery"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a decided request not to be decided again, got %d", w.Code)
+	}
+
+	// A rejected change is never applied
+	requestID = propose("admin_only")
+	if w := request(approver, "/settings/changes/"+requestID+"/reject", `{"current_password": "correct-horse-battery"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected another admin to reject, got %d: %s", w.Code, w.Body.String())
+	}
+	if setting("auth_mode") != "invite_only" {
+		t.Errorf("Expected the rejected auth_mode not applied, got %q", setting("auth_mode"))
+	}
+}
+
+func TestGetSettingsRedactsSecrets(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for key := range secretSettings {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+		_ = db.SetSetting(key, "hunter2", settingDescriptions[key])
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/settings", s.handleGetSettings)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/settings", nil))
+	var settings map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &settings); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected the settings, got %d: %s", w.Code, w.Body.String())
+	}
+	for key := range secretSettings {
+		if settings[key] != "[redacted]" {
+			t.Errorf("Expected %s to be redacted, got %q", key, settings[key])
+		}
+	}
+}
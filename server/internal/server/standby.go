@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"fethur/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultStandbyInterval is how often a standby looks for a newer backup
+const defaultStandbyInterval = time.Minute
+
+// StandbySource returns where the primary ships its backups, from
+// FETHUR_STANDBY_SOURCE; set, it makes this server a read-only standby
+func StandbySource() string {
+	return strings.TrimSpace(os.Getenv("FETHUR_STANDBY_SOURCE"))
+}
+
+// StandbyInterval returns how often a standby syncs, from
+// FETHUR_STANDBY_INTERVAL, e.g. 30s
+func StandbyInterval() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("FETHUR_STANDBY_INTERVAL"))
+	if value == "" {
+		return defaultStandbyInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultStandbyInterval, err
+	}
+	if interval < time.Second {
+		return defaultStandbyInterval, fmt.Errorf("interval %s is under a second", interval)
+	}
+	return interval, nil
+}
+
+// inStandby reports whether the server is serving a primary's data
+// read-only
+func (s *Server) inStandby() bool {
+	return s.standby != nil && s.standby.Active()
+}
+
+// standbyWrites are the routes a standby still takes writes on: signing
+// in, so the archive can be browsed, and promotion
+func (s *Server) standbyWrites(route string) bool {
+	switch strings.TrimPrefix(route, s.basePath) {
+	case "/api/auth/login", "/api/auth/refresh", "/api/auth/logout", "/api/auth/2fa/verify", "/api/admin/standby/promote":
+		return true
+	}
+	return false
+}
+
+// standbyMiddleware refuses writes and voice while the server is a
+// standby. Its database is replaced on every sync, so anything written
+// here would be lost.
+func (s *Server) standbyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.inStandby() {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		read := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+		voice := route == s.basePath+"/ws/voice" || route == s.basePath+"/voice"
+		if (read && !voice) || s.standbyWrites(route) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "This server is a read-only standby", "code": "standby"})
+	}
+}
+
+// standbySynced reloads the policies cached from settings after a sync
+// replaced them
+func (s *Server) standbySynced() {
+	s.applyPasswordPolicy()
+	s.applyLoginLockoutPolicy()
+	s.applyVoiceIdlePolicy()
+}
+
+// handleGetStandby reports whether the server is a standby and the
+// backup it last restored
+func (s *Server) handleGetStandby(c *gin.Context) {
+	if s.standby == nil {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"active": false}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.standby.Status()})
+}
+
+// handlePromoteStandby makes a standby the primary: it stops following
+// the old primary, takes writes and starts the background work
+func (s *Server) handlePromoteStandby(c *gin.Context) {
+	if s.standby == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This server is not a standby", "code": "not_standby"})
+		return
+	}
+	if err := s.standby.Promote(c.Request.Context()); errors.Is(err, database.ErrNotStandby) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This server is not a standby", "code": "not_standby"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to promote the standby: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to promote the standby"})
+		return
+	}
+	s.standbySynced()
+	s.startBackgroundWork(context.Background())
+
+	status := s.standby.Status()
+	details := "Promoted the standby to primary"
+	if status.Applied != nil {
+		details += " from backup " + status.Applied.Path
+	}
+	s.logAdminAction(c.GetInt("user_id"), "promote_standby", details)
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+}
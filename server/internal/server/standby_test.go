@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fethur/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStandbyRefusesWrites(t *testing.T) {
+	s := newTestServer(t)
+	s.standby = database.NewStandby(s.db, t.TempDir())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(s.standbyMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/api/channels/:id/messages", ok)
+	router.POST("/api/channels/:id/messages", ok)
+	router.POST("/api/auth/login", ok)
+	router.GET("/ws/voice", ok)
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/channels/1/messages", http.StatusNoContent},
+		{"POST", "/api/channels/1/messages", http.StatusServiceUnavailable},
+		{"POST", "/api/auth/login", http.StatusNoContent},
+		{"GET", "/ws/voice", http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, w.Code, w.Body.String())
+		}
+		if tc.want == http.StatusServiceUnavailable && !strings.Contains(w.Body.String(), `"code":"standby"`) {
+			t.Errorf("%s %s: expected the standby code, got %s", tc.method, tc.path, w.Body.String())
+		}
+	}
+
+	// A primary takes writes
+	s.standby = nil
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/channels/1/messages", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected a primary to take writes, got %d", w.Code)
+	}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"fethur/internal/inbound"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultStarboardEmoji is the reaction that stars a message by default
+const defaultStarboardEmoji = "⭐"
+
+// starboardBotName labels reposts in the starboard channel
+const starboardBotName = "Starboard"
+
+// starboardColor is the embed accent of reposts
+const starboardColor = 0xF5C518
+
+// starboardSettings is a server's starboard configuration
+type starboardSettings struct {
+	ChannelID int    `json:"channel_id"`
+	Emoji     string `json:"emoji"`
+	Threshold int    `json:"threshold"`
+	AllowSelf bool   `json:"allow_self"`
+}
+
+// starredMessage is what a repost shows of the original message
+type starredMessage struct {
+	ID          int64
+	ChannelID   int
+	ChannelName string
+	Private     bool
+	AuthorID    int
+	Author      string
+	Content     string
+	CreatedAt   time.Time
+}
+
+func (s *Server) loadStarboardSettings(serverID int) (*starboardSettings, bool) {
+	var settings starboardSettings
+	err := s.db.QueryRow(
+		"SELECT channel_id, emoji, threshold, allow_self FROM starboard_settings WHERE server_id = ?", serverID,
+	).Scan(&settings.ChannelID, &settings.Emoji, &settings.Threshold, &settings.AllowSelf)
+	if err != nil {
+		return nil, false
+	}
+	return &settings, true
+}
+
+func (s *Server) loadStarredMessage(messageID int64) (*starredMessage, error) {
+	var message starredMessage
+	err := s.db.QueryRow(`
+		SELECT m.id, m.channel_id, c.name, c.private, m.user_id, COALESCE(m.bot_name, u.username), m.content, m.created_at
+		FROM messages m
+		JOIN channels c ON c.id = m.channel_id
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id = ?`, messageID,
+	).Scan(&message.ID, &message.ChannelID, &message.ChannelName, &message.Private, &message.AuthorID,
+		&message.Author, &message.Content, &message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// starboardPost renders the repost of a starred message
+func starboardPost(message *starredMessage, emoji string, stars int) (string, []inbound.Embed) {
+	content := fmt.Sprintf("%s **%d** in #%s", emoji, stars, message.ChannelName)
+	embed := inbound.Embed{
+		Author:      message.Author,
+		Description: truncateRunes(message.Content, 2000),
+		Color:       starboardColor,
+		Footer:      fmt.Sprintf("#%s · message %d", message.ChannelName, message.ID),
+		Timestamp:   message.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	return content, []inbound.Embed{embed}
+}
+
+// updateStarboard reacts to a star being added or removed
+func (s *Server) updateStarboard(event reactionEvent) {
+	settings, ok := s.loadStarboardSettings(event.ServerID)
+	if !ok || event.Emoji != settings.Emoji || event.ChannelID == settings.ChannelID {
+		return
+	}
+	s.syncStarboardEntry(event.ServerID, event.MessageID, settings)
+}
+
+// syncStarboardEntry posts, updates or takes down the repost of a message
+// to match its current stars
+func (s *Server) syncStarboardEntry(serverID int, messageID int64, settings *starboardSettings) {
+	message, err := s.loadStarredMessage(messageID)
+	if err != nil {
+		return
+	}
+	// Private channels stay private
+	if message.Private {
+		return
+	}
+
+	query := "SELECT COUNT(*) FROM message_reactions WHERE message_id = ? AND emoji = ?"
+	args := []interface{}{messageID, settings.Emoji}
+	if !settings.AllowSelf {
+		query += " AND user_id != ?"
+		args = append(args, message.AuthorID)
+	}
+	var stars int
+	if err := s.db.QueryRow(query, args...).Scan(&stars); err != nil {
+		log.Printf("Failed to count stars on message %d: %v", messageID, err)
+		return
+	}
+
+	var postID sql.NullInt64
+	err = s.db.QueryRow("SELECT starboard_message_id FROM starboard_entries WHERE message_id = ?", messageID).Scan(&postID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if stars < settings.Threshold {
+			return
+		}
+		// Claim the entry so concurrent reactions post once
+		result, err := s.db.Exec(
+			"INSERT OR IGNORE INTO starboard_entries (message_id, server_id, stars) VALUES (?, ?, ?)", messageID, serverID, stars,
+		)
+		if err != nil {
+			log.Printf("Failed to record starboard entry for message %d: %v", messageID, err)
+			return
+		}
+		if claimed, _ := result.RowsAffected(); claimed == 0 {
+			return
+		}
+		content, embeds := starboardPost(message, settings.Emoji, stars)
+		id, err := s.postChannelMessage(settings.ChannelID, message.AuthorID, message.Author, starboardBotName, content, embeds)
+		if err != nil {
+			log.Printf("Failed to post message %d to the starboard: %v", messageID, err)
+			_, _ = s.db.Exec("DELETE FROM starboard_entries WHERE message_id = ?", messageID)
+			return
+		}
+		if _, err := s.db.Exec("UPDATE starboard_entries SET starboard_message_id = ? WHERE message_id = ?", id, messageID); err != nil {
+			log.Printf("Failed to link starboard post for message %d: %v", messageID, err)
+		}
+
+	case err != nil:
+		log.Printf("Failed to load starboard entry for message %d: %v", messageID, err)
+
+	case !postID.Valid:
+		// Another reaction is posting it right now
+
+	case stars < settings.Threshold:
+		if _, err := s.db.Exec("DELETE FROM starboard_entries WHERE message_id = ?", messageID); err != nil {
+			log.Printf("Failed to remove starboard entry for message %d: %v", messageID, err)
+			return
+		}
+		s.removeStarboardPost(postID.Int64)
+
+	default:
+		if _, err := s.db.Exec("UPDATE starboard_entries SET stars = ? WHERE message_id = ?", stars, messageID); err != nil {
+			log.Printf("Failed to update starboard entry for message %d: %v", messageID, err)
+		}
+		var postChannelID int
+		if err := s.db.QueryRow("SELECT channel_id FROM messages WHERE id = ?", postID.Int64).Scan(&postChannelID); err != nil {
+			return
+		}
+		content, embeds := starboardPost(message, settings.Emoji, stars)
+		if _, err := s.updateChannelMessage(postChannelID, postID.Int64, 0, content, embeds); err != nil {
+			log.Printf("Failed to update starboard post %d: %v", postID.Int64, err)
+		}
+	}
+}
+
+// removeStarboardPost deletes a repost wherever it was posted
+func (s *Server) removeStarboardPost(postID int64) {
+	var channelID int
+	if err := s.db.QueryRow("SELECT channel_id FROM messages WHERE id = ?", postID).Scan(&channelID); err != nil {
+		return
+	}
+	if err := s.deleteChannelMessage(channelID, postID); err != nil {
+		log.Printf("Failed to delete starboard post %d: %v", postID, err)
+	}
+}
+
+// starboardMessageEdited carries an edit over to the message's repost
+func (s *Server) starboardMessageEdited(messageID int64) {
+	var serverID int
+	if err := s.db.QueryRow("SELECT server_id FROM starboard_entries WHERE message_id = ?", messageID).Scan(&serverID); err != nil {
+		return
+	}
+	if settings, ok := s.loadStarboardSettings(serverID); ok {
+		s.syncStarboardEntry(serverID, messageID, settings)
+	}
+}
+
+// starboardMessageDeleted takes down the repost of a deleted message, and
+// forgets the link when a repost itself is deleted
+func (s *Server) starboardMessageDeleted(messageID int64) {
+	var postID sql.NullInt64
+	err := s.db.QueryRow("SELECT starboard_message_id FROM starboard_entries WHERE message_id = ?", messageID).Scan(&postID)
+	if err == nil {
+		if _, err := s.db.Exec("DELETE FROM starboard_entries WHERE message_id = ?", messageID); err != nil {
+			log.Printf("Failed to remove starboard entry for message %d: %v", messageID, err)
+		}
+		if postID.Valid {
+			s.removeStarboardPost(postID.Int64)
+		}
+	}
+	if _, err := s.db.Exec("DELETE FROM starboard_entries WHERE starboard_message_id = ?", messageID); err != nil {
+		log.Printf("Failed to unlink starboard post %d: %v", messageID, err)
+	}
+}
+
+// handleGetStarboard returns the server's starboard configuration, or null
+// when it has none
+func (s *Server) handleGetStarboard(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	settings, _ := s.loadStarboardSettings(serverID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// handleUpdateStarboard enables the starboard or changes its configuration
+func (s *Server) handleUpdateStarboard(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req starboardSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Emoji == "" {
+		req.Emoji = defaultStarboardEmoji
+	}
+	if !validReactionEmoji(req.Emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emoji"})
+		return
+	}
+	if req.Threshold == 0 {
+		req.Threshold = 3
+	}
+	if req.Threshold < 1 || req.Threshold > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be between 1 and 100"})
+		return
+	}
+	var channelType string
+	var private bool
+	err := s.db.QueryRow(
+		"SELECT channel_type, private FROM channels WHERE id = ? AND server_id = ?", req.ChannelID, serverID,
+	).Scan(&channelType, &private)
+	if err != nil || channelType != "text" || private {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel_id must be a public text channel in this server"})
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO starboard_settings (server_id, channel_id, emoji, threshold, allow_self, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (server_id) DO UPDATE SET channel_id = excluded.channel_id, emoji = excluded.emoji,
+			threshold = excluded.threshold, allow_self = excluded.allow_self, updated_at = CURRENT_TIMESTAMP`,
+		serverID, req.ChannelID, req.Emoji, req.Threshold, req.AllowSelf,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update starboard"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    req,
+	})
+}
+
+// handleDeleteStarboard turns the starboard off; existing reposts stay
+func (s *Server) handleDeleteStarboard(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	for _, query := range []string{
+		"DELETE FROM starboard_entries WHERE server_id = ?",
+		"DELETE FROM starboard_settings WHERE server_id = ?",
+	} {
+		if _, err := s.db.Exec(query, serverID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable starboard"})
+			return
+		}
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Starboard disabled",
+	})
+}
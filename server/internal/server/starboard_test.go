@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStarboard(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "author", "fan", "critic"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("sb%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Stars %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	channels := make(map[string]int64)
+	for _, name := range []string{"general", "starboard"} {
+		result, err := db.Exec("INSERT INTO channels (server_id, name) VALUES (?, ?)", serverID, name)
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[name], _ = result.LastInsertId()
+	}
+	for name, id := range users {
+		role := "member"
+		if name == "owner" {
+			role = "owner"
+		}
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", id, serverID, role); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.PUT("/servers/:id/starboard", s.handleUpdateStarboard)
+		router.PUT("/messages/:messageId", s.handleEditMessage)
+		router.DELETE("/messages/:messageId", s.handleDeleteMessage)
+		router.PUT("/messages/:messageId/reactions/:emoji", s.handleAddReaction)
+		router.DELETE("/messages/:messageId/reactions/:emoji", s.handleRemoveReaction)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	star := url.PathEscape(defaultStarboardEmoji)
+
+	settings := fmt.Sprintf(`{"channel_id":%d,"threshold":2}`, channels["starboard"])
+	if w := request("author", http.MethodPut, fmt.Sprintf("/servers/%d/starboard", serverID), settings); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to configure the starboard, got %d", w.Code)
+	}
+	if w := request("owner", http.MethodPut, fmt.Sprintf("/servers/%d/starboard", serverID), settings); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	messageID, err := s.postChannelMessage(int(channels["general"]), int(users["author"]), "author", "", "A great idea", nil)
+	if err != nil {
+		t.Fatalf("Failed to post message: %v", err)
+	}
+	reactionPath := fmt.Sprintf("/messages/%d/reactions/%s", messageID, star)
+	starboardPost := func() (int64, string, string) {
+		var id int64
+		var content, embeds string
+		err := db.QueryRow(
+			"SELECT id, content, COALESCE(embeds, '') FROM messages WHERE channel_id = ? ORDER BY id DESC LIMIT 1", channels["starboard"],
+		).Scan(&id, &content, &embeds)
+		if err != nil {
+			return 0, "", ""
+		}
+		return id, content, embeds
+	}
+
+	// The author's own star does not count
+	for _, user := range []string{"author", "fan"} {
+		if w := request(user, http.MethodPut, reactionPath, ""); w.Code != http.StatusOK {
+			t.Fatalf("Expected reaction to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if id, _, _ := starboardPost(); id != 0 {
+		t.Fatal("Expected no repost below the threshold")
+	}
+	if w := request("critic", http.MethodPut, reactionPath, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected reaction to succeed, got %d", w.Code)
+	}
+	postID, content, embeds := starboardPost()
+	if postID == 0 || !strings.Contains(content, "**2** in #general") || !strings.Contains(embeds, "A great idea") {
+		t.Fatalf("Expected a repost with two stars, got %q %q", content, embeds)
+	}
+	if reactions := s.messageReactions([]int64{messageID})[messageID]; len(reactions) != 1 || reactions[0]["count"] != 3 {
+		t.Fatalf("Expected three stars on the message, got %v", reactions)
+	}
+
+	// Edits carry over to the repost
+	if w := request("fan", http.MethodPut, fmt.Sprintf("/messages/%d", messageID), `{"content":"Hijacked"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected only the author to edit, got %d", w.Code)
+	}
+	if w := request("author", http.MethodPut, fmt.Sprintf("/messages/%d", messageID), `{"content":"A better idea"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the edit to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, _, embeds := starboardPost(); !strings.Contains(embeds, "A better idea") {
+		t.Fatalf("Expected the repost to follow the edit, got %q", embeds)
+	}
+
+	// Dropping below the threshold takes the repost down
+	if w := request("critic", http.MethodDelete, reactionPath, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected reaction removal to succeed, got %d", w.Code)
+	}
+	if id, _, _ := starboardPost(); id != 0 {
+		t.Fatal("Expected the repost to be removed")
+	}
+	if w := request("critic", http.MethodPut, reactionPath, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected reaction to succeed, got %d", w.Code)
+	}
+	if id, _, _ := starboardPost(); id == 0 {
+		t.Fatal("Expected the message to be reposted")
+	}
+
+	// Deleting the original removes the repost and the link
+	if w := request("fan", http.MethodDelete, fmt.Sprintf("/messages/%d", messageID), ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to delete others' messages, got %d", w.Code)
+	}
+	if w := request("owner", http.MethodDelete, fmt.Sprintf("/messages/%d", messageID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected owners to delete messages, got %d: %s", w.Code, w.Body.String())
+	}
+	if id, _, _ := starboardPost(); id != 0 {
+		t.Fatal("Expected the repost to go with the original")
+	}
+	var entries int
+	if err := db.QueryRow("SELECT COUNT(*) FROM starboard_entries WHERE server_id = ?", serverID).Scan(&entries); err != nil || entries != 0 {
+		t.Fatalf("Expected no starboard entries, found %d (%v)", entries, err)
+	}
+}
+
+func TestValidReactionEmoji(t *testing.T) {
+	for emoji, valid := range map[string]bool{
+		"⭐":           true,
+		"👍🏽":          true,
+		":party_cat:": true,
+		"":            false,
+		"a":           false,
+		":bad name:":  false,
+		"⭐ ⭐":         false,
+	} {
+		if got := validReactionEmoji(emoji); got != valid {
+			t.Errorf("validReactionEmoji(%q) = %v, want %v", emoji, got, valid)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"fethur/internal/voice"
+
+	"github.com/gin-gonic/gin"
+)
+
+// voiceGeneratorType is the channel type of join-to-create channels.
+// Joining one puts the user in a temporary voice channel of their own,
+// which is deleted once everyone has left.
+const voiceGeneratorType = "voice_generator"
+
+// maxVoiceUserLimit bounds a temporary channel's user limit
+const maxVoiceUserLimit = 99
+
+// voiceChannelCount returns how many users are in a voice channel
+func (s *Server) voiceChannelCount(channelID, excludeUserID int64) int {
+	count := 0
+	for _, p := range s.voiceHub.Participants() {
+		if p.ChannelID == channelID && p.UserID != excludeUserID {
+			count++
+		}
+	}
+	return count
+}
+
+// checkVoiceUserLimit refuses joins to a full channel; its owner always
+// gets in
+func (s *Server) checkVoiceUserLimit(userID, channelID int64) error {
+	var limit int
+	var ownerID sql.NullInt64
+	if err := s.db.QueryRow(
+		"SELECT user_limit, temp_owner_id FROM channels WHERE id = ?", channelID,
+	).Scan(&limit, &ownerID); err != nil {
+		return err
+	}
+	if limit > 0 && ownerID.Int64 != userID && s.voiceChannelCount(channelID, userID) >= limit {
+		return voice.ErrChannelFull
+	}
+	return nil
+}
+
+// routeVoiceJoin is the voice hub's join redirect: joins to a generator go
+// to the user's temporary channel, created on the first join
+func (s *Server) routeVoiceJoin(userID, channelID int64) (int64, error) {
+	var serverID int
+	var channelType string
+	if err := s.db.QueryRow(
+		"SELECT server_id, channel_type FROM channels WHERE id = ?", channelID,
+	).Scan(&serverID, &channelType); err != nil {
+		return 0, err
+	}
+	if channelType != voiceGeneratorType {
+		return channelID, nil
+	}
+
+	var existing int64
+	err := s.db.QueryRow(
+		"SELECT id FROM channels WHERE generator_id = ? AND temp_owner_id = ?", channelID, userID,
+	).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return 0, err
+	}
+	// Rooms inherit the generator's privacy
+	result, err := s.db.Exec(`
+		INSERT INTO channels (name, server_id, channel_type, private, access_role_id, temp_owner_id, generator_id)
+		SELECT ?, server_id, 'voice', private, access_role_id, ?, id FROM channels WHERE id = ?`,
+		truncateRunes(fmt.Sprintf("%s's channel", username), 100), userID, channelID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	roomID, _ := result.LastInsertId()
+	if _, err := s.db.Exec(
+		"INSERT OR IGNORE INTO channel_members (channel_id, user_id) VALUES (?, ?)", roomID, userID,
+	); err != nil {
+		log.Printf("Failed to add user %d to voice channel %d: %v", userID, roomID, err)
+	}
+	s.bumpResourceVersion(channelsResource(serverID))
+	log.Printf("Created temporary voice channel %d for user %d", roomID, userID)
+	return roomID, nil
+}
+
+// voiceChannelEmptied deletes a temporary channel once its last user left
+func (s *Server) voiceChannelEmptied(channelID int64) {
+	var serverID int
+	if err := s.db.QueryRow(
+		"SELECT server_id FROM channels WHERE id = ? AND temp_owner_id IS NOT NULL", channelID,
+	).Scan(&serverID); err != nil {
+		return
+	}
+	// Someone may have joined again since
+	if s.voiceChannelCount(channelID, 0) > 0 {
+		return
+	}
+	if err := s.deleteChannel(int(channelID)); err != nil {
+		log.Printf("Failed to delete temporary voice channel %d: %v", channelID, err)
+		return
+	}
+	s.bumpResourceVersion(channelsResource(serverID))
+}
+
+// cleanupTempVoiceChannels deletes temporary channels left behind by a
+// restart; nobody is in voice yet at startup
+func (s *Server) cleanupTempVoiceChannels() {
+	rows, err := s.db.Query("SELECT id, server_id FROM channels WHERE temp_owner_id IS NOT NULL")
+	if err != nil {
+		log.Printf("Failed to list temporary voice channels: %v", err)
+		return
+	}
+	type room struct{ id, serverID int }
+	var rooms []room
+	for rows.Next() {
+		var r room
+		if err := rows.Scan(&r.id, &r.serverID); err == nil {
+			rooms = append(rooms, r)
+		}
+	}
+	_ = rows.Close()
+
+	for _, r := range rooms {
+		if err := s.deleteChannel(r.id); err != nil {
+			log.Printf("Failed to delete temporary voice channel %d: %v", r.id, err)
+			continue
+		}
+		s.bumpResourceVersion(channelsResource(r.serverID))
+	}
+}
+
+// handleUpdateVoiceRoom renames a temporary voice channel or sets its user
+// limit; its owner and server owners and admins may
+func (s *Server) handleUpdateVoiceRoom(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	channelID, err := strconv.Atoi(c.Param("channelId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+	channel, err := s.lookupChannelForUser(userID, channelID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return
+	}
+
+	var name string
+	var limit int
+	var ownerID sql.NullInt64
+	if err := s.db.QueryRow(
+		"SELECT name, user_limit, temp_owner_id FROM channels WHERE id = ?", channelID,
+	).Scan(&name, &limit, &ownerID); err != nil || !ownerID.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Temporary voice channel not found"})
+		return
+	}
+	if int(ownerID.Int64) != userID && !s.canManageChannel(userID, channelID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the channel owner can change it"})
+		return
+	}
+
+	var req struct {
+		Name      *string `json:"name"`
+		UserLimit *int    `json:"user_limit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+	if req.UserLimit != nil {
+		limit = *req.UserLimit
+	}
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-100 characters"})
+		return
+	}
+	if limit < 0 || limit > maxVoiceUserLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_limit must be between 0 and 99"})
+		return
+	}
+
+	if _, err := s.db.Exec("UPDATE channels SET name = ?, user_limit = ?, version = version + 1 WHERE id = ?", name, limit, channelID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+	s.bumpResourceVersion(channelsResource(channel.ServerID))
+	s.markWrite(userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":         channelID,
+			"name":       name,
+			"user_limit": limit,
+			"owner_id":   ownerID.Int64,
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTemporaryVoiceChannels(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "host", "guest"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("tv%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Rooms %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, id := range users {
+		role := "member"
+		if name == "owner" {
+			role = "owner"
+		}
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", id, serverID, role); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	channels := make(map[string]int64)
+	for name, channelType := range map[string]string{"lounge": "voice", "create": voiceGeneratorType} {
+		result, err := db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, ?, ?)", serverID, name, channelType)
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[name], _ = result.LastInsertId()
+	}
+
+	// Generators validate like voice channels
+	if _, err := s.validateVoiceChannel(users["host"], channels["create"]); err != nil {
+		t.Fatalf("Expected the generator to be joinable: %v", err)
+	}
+
+	// Plain voice channels are not redirected
+	if target, err := s.routeVoiceJoin(users["host"], channels["lounge"]); err != nil || target != channels["lounge"] {
+		t.Fatalf("Expected lounge, got %d (%v)", target, err)
+	}
+	room, err := s.routeVoiceJoin(users["host"], channels["create"])
+	if err != nil || room == channels["create"] {
+		t.Fatalf("Expected a new room, got %d (%v)", room, err)
+	}
+	if again, err := s.routeVoiceJoin(users["host"], channels["create"]); err != nil || again != room {
+		t.Fatalf("Expected the host's room to be reused, got %d (%v)", again, err)
+	}
+	var channelType string
+	var ownerID int64
+	if err := db.QueryRow("SELECT channel_type, temp_owner_id FROM channels WHERE id = ?", room).Scan(&channelType, &ownerID); err != nil {
+		t.Fatalf("Failed to load room: %v", err)
+	}
+	if channelType != "voice" || ownerID != users["host"] {
+		t.Fatalf("Expected a voice channel owned by the host, got %s owned by %d", channelType, ownerID)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.PUT("/channels/:channelId/voice-room", s.handleUpdateVoiceRoom)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", fmt.Sprintf("/channels/%d/voice-room", room), strings.NewReader(body)))
+		return w
+	}
+	if w := request("guest", `{"name":"Mine now"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected guests not to rename the room, got %d", w.Code)
+	}
+	if w := request("host", `{"user_limit":100}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an out of range limit to be rejected, got %d", w.Code)
+	}
+	if w := request("host", `{"name":"Study group","user_limit":4}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update room: %d %s", w.Code, w.Body.String())
+	}
+	var name string
+	var limit int
+	if err := db.QueryRow("SELECT name, user_limit FROM channels WHERE id = ?", room).Scan(&name, &limit); err != nil || name != "Study group" || limit != 4 {
+		t.Fatalf("Expected the room to be renamed and limited, got %q %d (%v)", name, limit, err)
+	}
+
+	// Only temporary channels are deleted when they empty
+	s.voiceChannelEmptied(channels["lounge"])
+	s.voiceChannelEmptied(room)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM channels WHERE id IN (?, ?)", channels["lounge"], room).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected only the room to be deleted, %d channels left (%v)", count, err)
+	}
+
+	// Rooms left by a restart are cleaned up
+	room, err = s.routeVoiceJoin(users["guest"], channels["create"])
+	if err != nil {
+		t.Fatalf("Failed to create room: %v", err)
+	}
+	s.cleanupTempVoiceChannels()
+	if err := db.QueryRow("SELECT COUNT(*) FROM channels WHERE id = ?", room).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("Expected leftover rooms to be deleted (%v)", err)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"fethur/internal/auth"
+	"fethur/internal/database"
+	"fethur/internal/resilience"
+	"fethur/internal/service"
+	"fethur/internal/voice"
+	"fethur/internal/websocket"
+)
+
+// newTestServer wires a server around a fresh database of its own, which is
+// removed with the test's temporary directory. The hub is running; tests
+// set any other dependencies they need on the result.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Logf("Error closing database: %v", err)
+		}
+	})
+
+	hub := websocket.NewHub()
+	go hub.Run()
+	s := &Server{
+		db:            db,
+		auth:          auth.NewService(),
+		hub:           hub,
+		voiceHub:      voice.NewVoiceHub(),
+		integrations:  resilience.NewRegistry(),
+		loginFailures: newLoginGuard(),
+		clients:       make(map[int]*websocket.Client),
+	}
+	s.services = service.New(s.db, s.auth)
+	return s
+}
+
+// createTestUser adds a user with a placeholder password and returns their ID
+func createTestUser(t *testing.T, db *database.Database, username string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", username)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// createTestCommunity adds a server owned by ownerID, with the owner and
+// members joined and a #general channel, and returns the server and
+// channel IDs
+func createTestCommunity(t *testing.T, db *database.Database, ownerID int, members ...int) (int, int) {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES ('Test', ?)", ownerID)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner')", ownerID, serverID); err != nil {
+		t.Fatalf("Failed to add owner: %v", err)
+	}
+	for _, userID := range members {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id) VALUES (?, ?)", userID, serverID); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'general')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	id, _ = result.LastInsertId()
+	return serverID, int(id)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/database"
+	"fethur/internal/events"
+	"fethur/internal/push"
+	"fethur/internal/snowflake"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Threads. A reply records the message it answers and the root of its
+// thread; users who follow the root are notified of every reply even when
+// they are not mentioned.
+
+// replyThread returns the root of the thread a reply to messageID belongs
+// to. The message must be in the channel.
+func (s *Server) replyThread(channelID int, messageID int64) (int64, error) {
+	return s.services.Messages.ReplyThread(channelID, messageID)
+}
+
+// followThread makes a user follow a thread, undoing an earlier unfollow
+func (s *Server) followThread(userID int, threadID int64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO thread_follows (user_id, message_id, following) VALUES (?, ?, 1)
+		ON CONFLICT (user_id, message_id) DO UPDATE SET following = 1`,
+		userID, threadID,
+	)
+	return err
+}
+
+// autoFollowThread follows a thread the user takes part in, unless they
+// turned auto-follow off or unfollowed the thread before
+func (s *Server) autoFollowThread(userID int, threadID int64) {
+	var enabled bool
+	if err := s.db.QueryRow("SELECT auto_follow_threads FROM users WHERE id = ?", userID).Scan(&enabled); err != nil || !enabled {
+		return
+	}
+	if _, err := s.db.Exec(
+		"INSERT OR IGNORE INTO thread_follows (user_id, message_id) VALUES (?, ?)", userID, threadID,
+	); err != nil {
+		log.Printf("Failed to follow thread %d for user %d: %v", threadID, userID, err)
+	}
+}
+
+// recordReply follows the thread for the replier and the thread's author,
+// then notifies its followers
+func (s *Server) recordReply(channelID int, threadID, messageID int64, senderID int, content string) {
+	s.autoFollowThread(senderID, threadID)
+	var authorID int
+	var botName string
+	if err := s.db.QueryRow(
+		"SELECT user_id, COALESCE(bot_name, '') FROM messages WHERE id = ?", threadID,
+	).Scan(&authorID, &botName); err == nil && botName == "" {
+		s.autoFollowThread(authorID, threadID)
+	}
+	s.notifyThreadFollowers(channelID, threadID, messageID, senderID, content)
+}
+
+// notifyThreadFollowers tells followers of a thread about a reply. Online
+// followers get a notification frame; offline ones get an offline event
+// and a push. Mentioned users are left to the mention and followers who
+// can no longer see the channel are skipped.
+func (s *Server) notifyThreadFollowers(channelID int, threadID, messageID int64, senderID int, content string) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username FROM thread_follows tf
+		JOIN users u ON u.id = tf.user_id
+		WHERE tf.message_id = ? AND tf.following = 1 AND tf.user_id != ?`,
+		threadID, senderID,
+	)
+	if err != nil {
+		log.Printf("Failed to load followers of thread %d: %v", threadID, err)
+		return
+	}
+	type follower struct {
+		id       int
+		username string
+	}
+	var followers []follower
+	for rows.Next() {
+		var f follower
+		if err := rows.Scan(&f.id, &f.username); err == nil {
+			followers = append(followers, f)
+		}
+	}
+	_ = rows.Close()
+	if len(followers) == 0 {
+		return
+	}
+
+	mentioned := make(map[string]bool)
+	for _, name := range extractMentions(content) {
+		mentioned[strings.ToLower(name)] = true
+	}
+	var sender string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", senderID).Scan(&sender); err != nil {
+		return
+	}
+
+	for _, f := range followers {
+		if mentioned[strings.ToLower(f.username)] {
+			continue
+		}
+		if _, err := s.lookupChannelForUser(f.id, channelID); err != nil {
+			continue
+		}
+
+		s.clientsMux.RLock()
+		client, online := s.clients[f.id]
+		s.clientsMux.RUnlock()
+		if online && s.hub.IsConnected(f.id) {
+			client.Send(&websocket.Message{
+				Type:      string(events.TypeNotification),
+				ChannelID: channelID,
+				Timestamp: time.Now(),
+				Data: gin.H{
+					"kind":       offlineEventThreadReply,
+					"channel_id": channelID,
+					"thread_id":  snowflake.ID(threadID),
+					"message_id": snowflake.ID(messageID),
+					"username":   sender,
+					"excerpt":    excerpt(content, 140),
+				},
+			})
+			continue
+		}
+
+		s.queueOfflineEvent(f.id, offlineEventThreadReply, channelID, messageID)
+		s.sendPush(f.id, push.Notification{
+			Title:       fmt.Sprintf("%s replied to a thread", sender),
+			Body:        excerpt(content, 140),
+			CollapseKey: "thread-" + strconv.FormatInt(threadID, 10),
+			Badge:       -1,
+			Data: map[string]string{
+				"type":       offlineEventThreadReply,
+				"channel_id": strconv.Itoa(channelID),
+				"thread_id":  strconv.FormatInt(threadID, 10),
+				"message_id": strconv.FormatInt(messageID, 10),
+			},
+		})
+	}
+}
+
+// handleFollowThread follows the thread a message belongs to
+func (s *Server) handleFollowThread(c *gin.Context) {
+	s.updateThreadFollow(c, true)
+}
+
+// handleUnfollowThread stops notifications for the thread a message
+// belongs to, including automatic follows
+func (s *Server) handleUnfollowThread(c *gin.Context) {
+	s.updateThreadFollow(c, false)
+}
+
+func (s *Server) updateThreadFollow(c *gin.Context, follow bool) {
+	userID := c.GetInt("user_id")
+	messageID, channel, _, _, ok := s.messageForUser(c)
+	if !ok {
+		return
+	}
+	threadID, err := s.replyThread(channel.ID, messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update thread"})
+		return
+	}
+
+	if follow {
+		err = s.followThread(userID, threadID)
+	} else {
+		_, err = s.db.Exec(`
+			INSERT INTO thread_follows (user_id, message_id, following) VALUES (?, ?, 0)
+			ON CONFLICT (user_id, message_id) DO UPDATE SET following = 0`,
+			userID, threadID,
+		)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update thread"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"thread_id":  snowflake.ID(threadID),
+			"channel_id": channel.ID,
+			"following":  follow,
+		},
+	})
+}
+
+// handleGetFollowedThreads lists the threads the user follows in channels
+// they can still see, most recently active first
+func (s *Server) handleGetFollowedThreads(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	rows, err := s.db.Query(`
+		SELECT m.id, m.channel_id, u.username, m.content,
+			(SELECT COUNT(*) FROM messages r WHERE r.thread_id = m.id AND `+quarantineVisibleSQL("r")+`),
+			COALESCE((SELECT MAX(r.created_at) FROM messages r WHERE r.thread_id = m.id AND `+quarantineVisibleSQL("r")+`), m.created_at) AS last_reply
+		FROM thread_follows tf
+		JOIN messages m ON m.id = tf.message_id
+		JOIN users u ON u.id = m.user_id
+		WHERE tf.user_id = ? AND tf.following = 1 AND `+quarantineVisibleSQL("m")+`
+		ORDER BY last_reply DESC
+		LIMIT 100`,
+		userID, userID, userID, userID, userID, userID, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load threads"})
+		return
+	}
+	followed := make([]gin.H, 0)
+	for rows.Next() {
+		var threadID int64
+		var channelID, replies int
+		var author, content, lastReply string
+		if err := rows.Scan(&threadID, &channelID, &author, &content, &replies, &lastReply); err != nil {
+			continue
+		}
+		lastReplyAt, err := database.ParseTime(lastReply)
+		if err != nil {
+			continue
+		}
+		followed = append(followed, gin.H{
+			"thread_id":     snowflake.ID(threadID),
+			"channel_id":    channelID,
+			"author":        author,
+			"excerpt":       excerpt(content, 140),
+			"replies":       replies,
+			"last_reply_at": lastReplyAt,
+		})
+	}
+	_ = rows.Close()
+
+	threads := make([]gin.H, 0, len(followed))
+	for _, thread := range followed {
+		if _, err := s.lookupChannelForUser(userID, thread["channel_id"].(int)); err == nil {
+			threads = append(threads, thread)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": threads})
+}
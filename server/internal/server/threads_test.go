@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/snowflake"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestThreadFollows(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	names := make(map[string]string)
+	for _, name := range []string{"author", "replier", "follower", "quiet"} {
+		names[name] = fmt.Sprintf("th%s_%d", name, suffix)
+		result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', 'x')", names[name])
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	if _, err := db.Exec("UPDATE users SET auto_follow_threads = 0 WHERE id = ?", users["quiet"]); err != nil {
+		t.Fatalf("Failed to turn off auto-follow: %v", err)
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Threads %d", suffix), users["author"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for _, name := range []string{"author", "replier", "follower", "quiet"} {
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'member')", users[name], serverID); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	channels := make([]int64, 2)
+	for i := range channels {
+		result, err := db.Exec("INSERT INTO channels (server_id, name, channel_type) VALUES (?, ?, 'text')", serverID, fmt.Sprintf("c%d", i))
+		if err != nil {
+			t.Fatalf("Failed to create channel: %v", err)
+		}
+		channels[i], _ = result.LastInsertId()
+	}
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'Question?')", channels[0], users["author"])
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	rootID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", names[user])
+		})
+		router.POST("/channels/:channelId/messages", s.handleSendMessage)
+		router.PUT("/messages/:messageId/follow", s.handleFollowThread)
+		router.DELETE("/messages/:messageId/follow", s.handleUnfollowThread)
+		router.GET("/user/threads", s.handleGetFollowedThreads)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	reply := func(user string, replyTo int64, content string) int64 {
+		t.Helper()
+		w := request(user, "POST", fmt.Sprintf("/channels/%d/messages", channels[0]), fmt.Sprintf(`{"content":%q,"reply_to_id":"%d"}`, content, replyTo))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the reply to be sent, got %d: %s", w.Code, w.Body.String())
+		}
+		var sent struct {
+			Data struct {
+				ID       snowflake.ID `json:"id"`
+				ThreadID snowflake.ID `json:"thread_id"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &sent)
+		if int64(sent.Data.ThreadID) != rootID {
+			t.Errorf("Expected the reply in thread %d, got %d", rootID, sent.Data.ThreadID)
+		}
+		return int64(sent.Data.ID)
+	}
+	queued := func(user string) int {
+		var count int
+		_ = db.QueryRow("SELECT COUNT(*) FROM offline_events WHERE user_id = ? AND event_type = ?", users[user], offlineEventThreadReply).Scan(&count)
+		return count
+	}
+
+	// Replies must answer a message in the same channel
+	w := request("replier", "POST", fmt.Sprintf("/channels/%d/messages", channels[1]), fmt.Sprintf(`{"content":"hi","reply_to_id":%d}`, rootID))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a reply across channels to be refused, got %d", w.Code)
+	}
+
+	// Replying follows the thread for the replier and its author
+	first := reply("replier", rootID, "Answer")
+	if queued("author") != 1 {
+		t.Errorf("Expected the author to be notified of the reply, got %d events", queued("author"))
+	}
+	if queued("replier") != 0 {
+		t.Errorf("Expected no notification for the replier's own reply")
+	}
+
+	// Following any message of the thread follows its root
+	if w := request("follower", "PUT", fmt.Sprintf("/messages/%d/follow", first), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the follow to succeed, got %d", w.Code)
+	}
+	reply("replier", first, "More detail")
+	if queued("follower") != 1 || queued("author") != 2 {
+		t.Errorf("Expected both followers to be notified, got %d and %d", queued("follower"), queued("author"))
+	}
+
+	// Mentioned followers get the mention instead
+	reply("replier", rootID, "@"+names["follower"]+" see above")
+	if queued("follower") != 1 {
+		t.Errorf("Expected the mention to replace the thread notification, got %d", queued("follower"))
+	}
+
+	// Unfollowing sticks even when the user takes part again
+	if w := request("author", "DELETE", fmt.Sprintf("/messages/%d/follow", rootID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the unfollow to succeed, got %d", w.Code)
+	}
+	reply("author", first, "Thanks")
+	reply("replier", rootID, "Welcome")
+	if queued("author") != 3 {
+		t.Errorf("Expected no notifications after unfollowing, got %d events", queued("author"))
+	}
+
+	// Users can turn auto-follow off
+	reply("quiet", rootID, "Me too")
+	var following int
+	_ = db.QueryRow("SELECT COUNT(*) FROM thread_follows WHERE user_id = ?", users["quiet"]).Scan(&following)
+	if following != 0 {
+		t.Errorf("Expected replying without auto-follow not to follow the thread")
+	}
+
+	w = request("follower", "GET", "/user/threads", "")
+	var listed struct {
+		Data []struct {
+			ThreadID snowflake.ID `json:"thread_id"`
+			Replies  int          `json:"replies"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Data) != 1 || int64(listed.Data[0].ThreadID) != rootID || listed.Data[0].Replies != 6 {
+		t.Errorf("Expected the followed thread with 6 replies, got %+v", listed.Data)
+	}
+}
@@ -0,0 +1,717 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ticket statuses
+const (
+	ticketOpen    = "open"
+	ticketClaimed = "claimed"
+	ticketClosed  = "closed"
+)
+
+// ticketBotName labels the messages the ticket system posts
+const ticketBotName = "Tickets"
+
+// maxTranscriptMessages bounds how much of a ticket is kept on close
+const maxTranscriptMessages = 5000
+
+// ticketSettings is a server's ticket configuration
+type ticketSettings struct {
+	SupportRoleID       *int64 `json:"support_role_id"`
+	TranscriptChannelID *int   `json:"transcript_channel_id"`
+	MaxOpen             int    `json:"max_open"`
+}
+
+// ticketInfo is a support ticket
+type ticketInfo struct {
+	ID              int64      `json:"id"`
+	ServerID        int        `json:"server_id"`
+	Number          int        `json:"number"`
+	ChannelID       *int       `json:"channel_id,omitempty"`
+	OpenedBy        int        `json:"opened_by"`
+	OpenedByName    string     `json:"opened_by_username"`
+	Subject         string     `json:"subject"`
+	Status          string     `json:"status"`
+	ClaimedBy       *int       `json:"claimed_by,omitempty"`
+	FirstResponseAt *time.Time `json:"first_response_at,omitempty"`
+	ClosedBy        *int       `json:"closed_by,omitempty"`
+	CloseReason     string     `json:"close_reason,omitempty"`
+	ClosedAt        *time.Time `json:"closed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+const ticketColumns = `
+	t.id, t.server_id, t.number, t.channel_id, t.opened_by, u.username, t.subject, t.status,
+	t.claimed_by, t.first_response_at, t.closed_by, t.close_reason, t.closed_at, t.created_at`
+
+func scanTicket(row rowScanner) (*ticketInfo, error) {
+	var ticket ticketInfo
+	var channelID, claimedBy, closedBy sql.NullInt64
+	var firstResponseAt, closedAt sql.NullTime
+	if err := row.Scan(&ticket.ID, &ticket.ServerID, &ticket.Number, &channelID, &ticket.OpenedBy, &ticket.OpenedByName,
+		&ticket.Subject, &ticket.Status, &claimedBy, &firstResponseAt, &closedBy, &ticket.CloseReason,
+		&closedAt, &ticket.CreatedAt); err != nil {
+		return nil, err
+	}
+	ticket.ChannelID = nullIntPtr(channelID)
+	ticket.ClaimedBy = nullIntPtr(claimedBy)
+	ticket.ClosedBy = nullIntPtr(closedBy)
+	if firstResponseAt.Valid {
+		at := firstResponseAt.Time.UTC()
+		ticket.FirstResponseAt = &at
+	}
+	if closedAt.Valid {
+		at := closedAt.Time.UTC()
+		ticket.ClosedAt = &at
+	}
+	ticket.CreatedAt = ticket.CreatedAt.UTC()
+	return &ticket, nil
+}
+
+func nullIntPtr(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
+
+// loadTicketSettings returns a server's ticket configuration or the defaults
+func (s *Server) loadTicketSettings(serverID int) ticketSettings {
+	settings := ticketSettings{MaxOpen: 1}
+	var supportRoleID, transcriptChannelID sql.NullInt64
+	err := s.db.QueryRow(
+		"SELECT support_role_id, transcript_channel_id, max_open FROM ticket_settings WHERE server_id = ?", serverID,
+	).Scan(&supportRoleID, &transcriptChannelID, &settings.MaxOpen)
+	if err != nil {
+		return settings
+	}
+	if supportRoleID.Valid {
+		settings.SupportRoleID = &supportRoleID.Int64
+	}
+	settings.TranscriptChannelID = nullIntPtr(transcriptChannelID)
+	return settings
+}
+
+// isTicketSupport reports whether a member handles tickets: owners, admins
+// and holders of the support role
+func (s *Server) isTicketSupport(userID int, role string, settings ticketSettings) bool {
+	if role == "owner" || role == "admin" {
+		return true
+	}
+	return settings.SupportRoleID != nil && s.hasServerRole(userID, *settings.SupportRoleID)
+}
+
+// loadTicket loads a ticket in a server
+func (s *Server) loadTicket(serverID int, ticketID string) (*ticketInfo, error) {
+	return scanTicket(s.db.QueryRow(
+		"SELECT "+ticketColumns+" FROM tickets t JOIN users u ON u.id = t.opened_by WHERE t.id = ? AND t.server_id = ?",
+		ticketID, serverID,
+	))
+}
+
+// ticketRequest resolves the server and ticket of a request and checks the
+// user is the opener or support staff
+func (s *Server) ticketRequest(c *gin.Context) (ticket *ticketInfo, support bool, ok bool) {
+	userID := c.GetInt("user_id")
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return nil, false, false
+	}
+	ticket, err := s.loadTicket(serverID, c.Param("ticketId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+		return nil, false, false
+	}
+	support = s.isTicketSupport(userID, role, s.loadTicketSettings(serverID))
+	if !support && ticket.OpenedBy != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+		return nil, false, false
+	}
+	return ticket, support, true
+}
+
+// notifyTicket sends a ticket update to its opener and the server's
+// connected support staff
+func (s *Server) notifyTicket(messageType string, ticket *ticketInfo) {
+	settings := s.loadTicketSettings(ticket.ServerID)
+	var supportRoleID int64
+	if settings.SupportRoleID != nil {
+		supportRoleID = *settings.SupportRoleID
+	}
+	rows, err := s.db.Query(`
+		SELECT user_id FROM server_members
+		WHERE server_id = ? AND (role IN ('owner', 'admin') OR user_id = ?
+			OR user_id IN (SELECT user_id FROM member_roles WHERE role_id = ?))`,
+		ticket.ServerID, ticket.OpenedBy, supportRoleID,
+	)
+	if err != nil {
+		log.Printf("Failed to list ticket recipients for server %d: %v", ticket.ServerID, err)
+		return
+	}
+	var recipients []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			recipients = append(recipients, userID)
+		}
+	}
+	_ = rows.Close()
+
+	message := &websocket.Message{
+		Type:      messageType,
+		Timestamp: time.Now(),
+		Data:      ticket,
+	}
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	for _, userID := range recipients {
+		if client, ok := s.clients[userID]; ok {
+			client.Send(message)
+		}
+	}
+}
+
+// recordTicketResponse marks the first reply in a ticket by someone other
+// than its opener; it is called for every message sent
+func (s *Server) recordTicketResponse(channelID, userID int) {
+	if _, err := s.db.Exec(`
+		UPDATE tickets SET first_response_at = CURRENT_TIMESTAMP
+		WHERE channel_id = ? AND opened_by != ? AND first_response_at IS NULL AND status != 'closed'`,
+		channelID, userID,
+	); err != nil {
+		log.Printf("Failed to record ticket response in channel %d: %v", channelID, err)
+	}
+}
+
+// handleOpenTicket creates a private ticket channel for the user and the
+// support staff
+func (s *Server) handleOpenTicket(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	username := c.GetString("username")
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Subject string `json:"subject" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Subject = strings.TrimSpace(req.Subject)
+	if req.Subject == "" || utf8.RuneCountInString(req.Subject) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subject must be 1-200 characters"})
+		return
+	}
+
+	settings := s.loadTicketSettings(serverID)
+	var open int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM tickets WHERE server_id = ? AND opened_by = ? AND status != 'closed'", serverID, userID,
+	).Scan(&open); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open ticket"})
+		return
+	}
+	if open >= settings.MaxOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("You can have at most %d open tickets", settings.MaxOpen)})
+		return
+	}
+
+	var number int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(number), 0) + 1 FROM tickets WHERE server_id = ?", serverID).Scan(&number); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open ticket"})
+		return
+	}
+
+	var accessRole sql.NullInt64
+	if settings.SupportRoleID != nil {
+		accessRole = sql.NullInt64{Int64: *settings.SupportRoleID, Valid: true}
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO channels (name, server_id, channel_type, private, access_role_id) VALUES (?, ?, 'text', 1, ?)",
+		fmt.Sprintf("ticket-%04d", number), serverID, accessRole,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open ticket"})
+		return
+	}
+	channelID64, _ := result.LastInsertId()
+	channelID := int(channelID64)
+
+	result, err = s.db.Exec(
+		"INSERT INTO tickets (server_id, number, channel_id, opened_by, subject) VALUES (?, ?, ?, ?, ?)",
+		serverID, number, channelID, userID, req.Subject,
+	)
+	if err == nil {
+		_, err = s.db.Exec("INSERT INTO channel_members (channel_id, user_id) VALUES (?, ?)", channelID, userID)
+	}
+	if err != nil {
+		// Another ticket took the number; drop the channel
+		if err := s.deleteChannel(channelID); err != nil {
+			log.Printf("Failed to remove ticket channel %d: %v", channelID, err)
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to open ticket, please retry"})
+		return
+	}
+	ticketID, _ := result.LastInsertId()
+	s.bumpResourceVersion(channelsResource(serverID))
+
+	if _, err := s.postChannelMessage(channelID, userID, username, ticketBotName,
+		fmt.Sprintf("Ticket #%d opened by @%s: %s", number, username, req.Subject), nil); err != nil {
+		log.Printf("Failed to post ticket %d greeting: %v", ticketID, err)
+	}
+
+	ticket, err := s.loadTicket(serverID, strconv.FormatInt(ticketID, 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ticket"})
+		return
+	}
+	s.markWrite(userID)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    ticket,
+	})
+
+	s.notifyTicket("ticket_opened", ticket)
+}
+
+// handleGetTickets lists tickets: all of them for support staff, the
+// user's own otherwise
+func (s *Server) handleGetTickets(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+
+	query := "SELECT " + ticketColumns + " FROM tickets t JOIN users u ON u.id = t.opened_by WHERE t.server_id = ?"
+	args := []interface{}{serverID}
+	switch c.DefaultQuery("status", "open") {
+	case "open":
+		query += " AND t.status != 'closed'"
+	case "closed":
+		query += " AND t.status = 'closed'"
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, closed or all"})
+		return
+	}
+	if !s.isTicketSupport(userID, role, s.loadTicketSettings(serverID)) {
+		query += " AND t.opened_by = ?"
+		args = append(args, userID)
+	}
+
+	rows, err := s.db.Query(query+" ORDER BY t.id DESC LIMIT 100", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tickets"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	tickets := make([]*ticketInfo, 0)
+	for rows.Next() {
+		if ticket, err := scanTicket(rows); err == nil {
+			tickets = append(tickets, ticket)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tickets,
+	})
+}
+
+// handleGetTicket returns one ticket
+func (s *Server) handleGetTicket(c *gin.Context) {
+	ticket, _, ok := s.ticketRequest(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    ticket,
+	})
+}
+
+// handleClaimTicket assigns an open ticket to the support member
+func (s *Server) handleClaimTicket(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ticket, support, ok := s.ticketRequest(c)
+	if !ok {
+		return
+	}
+	if !support {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only support staff can claim tickets"})
+		return
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE tickets SET status = ?, claimed_by = ? WHERE id = ? AND status != 'closed'", ticketClaimed, userID, ticket.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim ticket"})
+		return
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ticket is closed"})
+		return
+	}
+	if ticket.ChannelID != nil {
+		username := c.GetString("username")
+		if _, err := s.postChannelMessage(*ticket.ChannelID, userID, username, ticketBotName,
+			fmt.Sprintf("@%s is handling this ticket", username), nil); err != nil {
+			log.Printf("Failed to announce claim of ticket %d: %v", ticket.ID, err)
+		}
+	}
+
+	ticket, err = s.loadTicket(ticket.ServerID, strconv.FormatInt(ticket.ID, 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ticket"})
+		return
+	}
+	s.markWrite(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    ticket,
+	})
+
+	s.notifyTicket("ticket_updated", ticket)
+}
+
+// handleCloseTicket closes a ticket: the conversation is saved as a
+// transcript and the ticket channel removed
+func (s *Server) handleCloseTicket(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ticket, _, ok := s.ticketRequest(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be at most 500 characters"})
+		return
+	}
+	if ticket.Status == ticketClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ticket is already closed"})
+		return
+	}
+
+	closedBy := c.GetString("username")
+	transcript, err := s.ticketTranscript(ticket, closedBy, req.Reason)
+	if err != nil {
+		log.Printf("Failed to build transcript for ticket %d: %v", ticket.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close ticket"})
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE tickets SET status = 'closed', closed_by = ?, close_reason = ?, closed_at = CURRENT_TIMESTAMP,
+			transcript = ?, channel_id = NULL
+		WHERE id = ? AND status != 'closed'`,
+		userID, req.Reason, transcript, ticket.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close ticket"})
+		return
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ticket is already closed"})
+		return
+	}
+
+	if ticket.ChannelID != nil {
+		if err := s.deleteChannel(*ticket.ChannelID); err != nil {
+			log.Printf("Failed to remove ticket channel: %v", err)
+		}
+		s.bumpResourceVersion(channelsResource(ticket.ServerID))
+	}
+
+	if settings := s.loadTicketSettings(ticket.ServerID); settings.TranscriptChannelID != nil {
+		summary := fmt.Sprintf("Ticket #%d (%s) opened by @%s was closed by @%s", ticket.Number, ticket.Subject, ticket.OpenedByName, closedBy)
+		if req.Reason != "" {
+			summary += ": " + req.Reason
+		}
+		summary += fmt.Sprintf("\nTranscript: /api/servers/%d/tickets/%d/transcript", ticket.ServerID, ticket.ID)
+		if _, err := s.postChannelMessage(*settings.TranscriptChannelID, userID, closedBy, ticketBotName, summary, nil); err != nil {
+			log.Printf("Failed to post transcript of ticket %d: %v", ticket.ID, err)
+		}
+	}
+
+	ticket, err = s.loadTicket(ticket.ServerID, strconv.FormatInt(ticket.ID, 10))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ticket"})
+		return
+	}
+	s.markWrite(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    ticket,
+	})
+
+	s.notifyTicket("ticket_closed", ticket)
+}
+
+// ticketTranscript renders a ticket's conversation as plain text
+func (s *Server) ticketTranscript(ticket *ticketInfo, closedBy, reason string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ticket #%d: %s\n", ticket.Number, ticket.Subject)
+	fmt.Fprintf(&b, "Opened by %s at %s UTC\n", ticket.OpenedByName, ticket.CreatedAt.Format(sqliteTimeLayout))
+	fmt.Fprintf(&b, "Closed by %s at %s UTC", closedBy, time.Now().UTC().Format(sqliteTimeLayout))
+	if reason != "" {
+		fmt.Fprintf(&b, ": %s", reason)
+	}
+	b.WriteString("\n\n")
+	if ticket.ChannelID == nil {
+		return b.String(), nil
+	}
+
+	// The opener keeps the transcript, so quarantined messages are left
+	// out unless they wrote them
+	rows, err := s.db.Query(`
+		SELECT m.created_at, u.username, COALESCE(m.bot_name, ''), m.content,
+			COALESCE((SELECT GROUP_CONCAT(a.filename, ', ') FROM attachments a WHERE a.message_id = m.id), '')
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.channel_id = ? AND (m.quarantined = 0 OR m.user_id = ?)
+		ORDER BY m.id
+		LIMIT ?`, *ticket.ChannelID, ticket.OpenedBy, maxTranscriptMessages)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var createdAt time.Time
+		var author, botName, content, attachments string
+		if err := rows.Scan(&createdAt, &author, &botName, &content, &attachments); err != nil {
+			return "", err
+		}
+		if botName != "" {
+			author = botName
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", createdAt.UTC().Format(sqliteTimeLayout), author, content)
+		if attachments != "" {
+			fmt.Fprintf(&b, "    attachments: %s\n", attachments)
+		}
+	}
+	return b.String(), rows.Err()
+}
+
+// handleGetTicketTranscript returns a closed ticket's transcript as text
+func (s *Server) handleGetTicketTranscript(c *gin.Context) {
+	ticket, _, ok := s.ticketRequest(c)
+	if !ok {
+		return
+	}
+	var transcript sql.NullString
+	if err := s.db.QueryRow("SELECT transcript FROM tickets WHERE id = ?", ticket.ID).Scan(&transcript); err != nil || !transcript.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket has no transcript yet"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%04d.txt"`, ticket.Number))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(transcript.String))
+}
+
+// handleGetTicketSettings returns the server's ticket configuration
+func (s *Server) handleGetTicketSettings(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.loadTicketSettings(serverID),
+	})
+}
+
+// handleUpdateTicketSettings replaces the server's ticket configuration
+func (s *Server) handleUpdateTicketSettings(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req ticketSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxOpen == 0 {
+		req.MaxOpen = 1
+	}
+	if req.MaxOpen < 1 || req.MaxOpen > 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_open must be between 1 and 10"})
+		return
+	}
+
+	var supportRole, transcriptChannel sql.NullInt64
+	if req.SupportRoleID != nil {
+		if _, found := s.findServerRole(serverID, strconv.FormatInt(*req.SupportRoleID, 10)); !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "support_role_id must be a role in this server"})
+			return
+		}
+		supportRole = sql.NullInt64{Int64: *req.SupportRoleID, Valid: true}
+	}
+	if req.TranscriptChannelID != nil {
+		var channelType string
+		err := s.db.QueryRow(
+			"SELECT channel_type FROM channels WHERE id = ? AND server_id = ?", *req.TranscriptChannelID, serverID,
+		).Scan(&channelType)
+		if err != nil || channelType != "text" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "transcript_channel_id must be a text channel in this server"})
+			return
+		}
+		transcriptChannel = sql.NullInt64{Int64: int64(*req.TranscriptChannelID), Valid: true}
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO ticket_settings (server_id, support_role_id, transcript_channel_id, max_open, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (server_id) DO UPDATE SET support_role_id = excluded.support_role_id,
+			transcript_channel_id = excluded.transcript_channel_id, max_open = excluded.max_open, updated_at = CURRENT_TIMESTAMP`,
+		serverID, supportRole, transcriptChannel, req.MaxOpen,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket settings"})
+		return
+	}
+
+	// Open tickets follow the new support role
+	if _, err := s.db.Exec(
+		"UPDATE channels SET access_role_id = ? WHERE id IN (SELECT channel_id FROM tickets WHERE server_id = ? AND channel_id IS NOT NULL)",
+		supportRole, serverID,
+	); err != nil {
+		log.Printf("Failed to update ticket channel access for server %d: %v", serverID, err)
+	}
+	s.bumpResourceVersion(channelsResource(serverID))
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.loadTicketSettings(serverID),
+	})
+}
+
+// handleGetTicketMetrics reports ticket volume and response times over the
+// last days (default 30) for support staff
+func (s *Server) handleGetTicketMetrics(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, role, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	if !s.isTicketSupport(userID, role, s.loadTicketSettings(serverID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+
+	var open, unanswered int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN first_response_at IS NULL THEN 1 ELSE 0 END), 0)
+		FROM tickets WHERE server_id = ? AND status != 'closed'`, serverID,
+	).Scan(&open, &unanswered); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ticket metrics"})
+		return
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days).Format(sqliteTimeLayout)
+	rows, err := s.db.Query(
+		"SELECT created_at, first_response_at, closed_at FROM tickets WHERE server_id = ? AND (created_at >= ? OR closed_at >= ?)",
+		serverID, since, since,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ticket metrics"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	sinceTime := time.Now().AddDate(0, 0, -days)
+	var opened, closed int
+	var firstResponse, resolution []float64
+	for rows.Next() {
+		var createdAt time.Time
+		var firstResponseAt, closedAt sql.NullTime
+		if err := rows.Scan(&createdAt, &firstResponseAt, &closedAt); err != nil {
+			continue
+		}
+		if !createdAt.Before(sinceTime) {
+			opened++
+			if firstResponseAt.Valid {
+				firstResponse = append(firstResponse, firstResponseAt.Time.Sub(createdAt).Seconds())
+			}
+		}
+		if closedAt.Valid && !closedAt.Time.Before(sinceTime) {
+			closed++
+			resolution = append(resolution, closedAt.Time.Sub(createdAt).Seconds())
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"days":           days,
+			"open":           open,
+			"unanswered":     unanswered,
+			"opened":         opened,
+			"closed":         closed,
+			"first_response": durationStats(firstResponse),
+			"resolution":     durationStats(resolution),
+		},
+	})
+}
+
+// durationStats summarizes durations in seconds
+func durationStats(seconds []float64) gin.H {
+	stats := gin.H{"count": len(seconds), "average_seconds": 0, "median_seconds": 0}
+	if len(seconds) == 0 {
+		return stats
+	}
+	sort.Float64s(seconds)
+	var total float64
+	for _, value := range seconds {
+		total += value
+	}
+	median := seconds[len(seconds)/2]
+	if len(seconds)%2 == 0 {
+		median = (seconds[len(seconds)/2-1] + seconds[len(seconds)/2]) / 2
+	}
+	stats["average_seconds"] = int64(total / float64(len(seconds)))
+	stats["median_seconds"] = int64(median)
+	return stats
+}
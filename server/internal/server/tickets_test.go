@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTickets(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "support", "opener", "other"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("tk%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Tickets %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	result, err = db.Exec("INSERT INTO channels (server_id, name) VALUES (?, 'ticket-log')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	logChannelID, _ := result.LastInsertId()
+	if _, err := db.Exec(`
+		INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner'), (?, ?, 'member'), (?, ?, 'member'), (?, ?, 'member')`,
+		users["owner"], serverID, users["support"], serverID, users["opener"], serverID, users["other"], serverID,
+	); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+			c.Set("username", fmt.Sprintf("tk%s_%d", user, suffix))
+		})
+		router.GET("/servers/:id/channels", s.handleGetChannels)
+		router.POST("/servers/:id/roles", s.handleCreateServerRole)
+		router.PUT("/servers/:id/members/:userId/roles/:roleId", s.handleGrantMemberRole)
+		router.GET("/servers/:id/tickets", s.handleGetTickets)
+		router.POST("/servers/:id/tickets", s.handleOpenTicket)
+		router.PUT("/servers/:id/tickets/settings", s.handleUpdateTicketSettings)
+		router.GET("/servers/:id/tickets/metrics", s.handleGetTicketMetrics)
+		router.GET("/servers/:id/tickets/:ticketId", s.handleGetTicket)
+		router.POST("/servers/:id/tickets/:ticketId/claim", s.handleClaimTicket)
+		router.POST("/servers/:id/tickets/:ticketId/close", s.handleCloseTicket)
+		router.GET("/servers/:id/tickets/:ticketId/transcript", s.handleGetTicketTranscript)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	serverPath := fmt.Sprintf("/servers/%d", serverID)
+
+	// Set up a support role
+	if w := request("other", http.MethodPost, serverPath+"/roles", `{"name":"Support"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected member role creation to be forbidden, got %d", w.Code)
+	}
+	w := request("owner", http.MethodPost, serverPath+"/roles", `{"name":"Support","color":"#3366ff"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var role struct {
+		Data serverRoleInfo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &role); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if w := request("owner", http.MethodPut, fmt.Sprintf("%s/members/%d/roles/%d", serverPath, users["support"], role.Data.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected role grant to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	settings := fmt.Sprintf(`{"support_role_id":%d,"transcript_channel_id":%d}`, role.Data.ID, logChannelID)
+	if w := request("owner", http.MethodPut, serverPath+"/tickets/settings", settings); w.Code != http.StatusOK {
+		t.Fatalf("Expected settings update to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = request("opener", http.MethodPost, serverPath+"/tickets", `{"subject":"Cannot upload files"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var opened struct {
+		Data ticketInfo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &opened); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	ticket := opened.Data
+	if ticket.Status != ticketOpen || ticket.Number != 1 || ticket.ChannelID == nil {
+		t.Fatalf("Expected an open ticket with a channel, got %+v", ticket)
+	}
+	if w := request("opener", http.MethodPost, serverPath+"/tickets", `{"subject":"Another"}`); w.Code != http.StatusConflict {
+		t.Fatalf("Expected the open ticket limit to apply, got %d", w.Code)
+	}
+
+	// The ticket channel is private to the opener and support staff
+	channelID := *ticket.ChannelID
+	for _, user := range []string{"opener", "support", "owner"} {
+		if _, err := s.lookupChannelForUser(int(users[user]), channelID); err != nil {
+			t.Fatalf("Expected %s to see the ticket channel: %v", user, err)
+		}
+	}
+	if _, err := s.lookupChannelForUser(int(users["other"]), channelID); err != errChannelNotFound {
+		t.Fatalf("Expected the ticket channel to be hidden from other members, got %v", err)
+	}
+	w = request("other", http.MethodGet, serverPath+"/channels", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "ticket-0001") {
+		t.Fatalf("Expected the channel list to hide the ticket, got %d: %s", w.Code, w.Body.String())
+	}
+	ticketPath := fmt.Sprintf("%s/tickets/%d", serverPath, ticket.ID)
+	if w := request("other", http.MethodGet, ticketPath, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected other members not to see the ticket, got %d", w.Code)
+	}
+
+	// Support answers and claims the ticket
+	if _, err := s.postChannelMessage(channelID, int(users["support"]), "support", "", "Which browser are you using?", nil); err != nil {
+		t.Fatalf("Failed to post reply: %v", err)
+	}
+	s.recordTicketResponse(channelID, int(users["support"]))
+	if w := request("opener", http.MethodPost, ticketPath+"/claim", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the opener not to claim, got %d", w.Code)
+	}
+	if w := request("support", http.MethodPost, ticketPath+"/claim", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"claimed"`) {
+		t.Fatalf("Expected the claim to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := request("opener", http.MethodGet, ticketPath+"/transcript", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected no transcript before close, got %d", w.Code)
+	}
+	if w := request("opener", http.MethodPost, ticketPath+"/close", `{"reason":"Solved"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the close to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var channels int
+	if err := db.QueryRow("SELECT COUNT(*) FROM channels WHERE id = ?", channelID).Scan(&channels); err != nil || channels != 0 {
+		t.Fatalf("Expected the ticket channel to be removed, found %d (%v)", channels, err)
+	}
+	w = request("opener", http.MethodGet, ticketPath+"/transcript", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Which browser are you using?") || !strings.Contains(w.Body.String(), "Solved") {
+		t.Fatalf("Expected the transcript, got %d: %s", w.Code, w.Body.String())
+	}
+	var summary string
+	if err := db.QueryRow("SELECT content FROM messages WHERE channel_id = ? ORDER BY id DESC LIMIT 1", logChannelID).Scan(&summary); err != nil {
+		t.Fatalf("Expected a summary in the transcript channel: %v", err)
+	}
+	if !strings.Contains(summary, "Ticket #1 (Cannot upload files)") {
+		t.Fatalf("Unexpected summary %q", summary)
+	}
+
+	if w := request("opener", http.MethodGet, serverPath+"/tickets/metrics", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected metrics to be for support staff, got %d", w.Code)
+	}
+	w = request("support", http.MethodGet, serverPath+"/tickets/metrics", "")
+	var metrics struct {
+		Data struct {
+			Open          int `json:"open"`
+			Opened        int `json:"opened"`
+			Closed        int `json:"closed"`
+			FirstResponse struct {
+				Count int `json:"count"`
+			} `json:"first_response"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if metrics.Data.Open != 0 || metrics.Data.Opened != 1 || metrics.Data.Closed != 1 || metrics.Data.FirstResponse.Count != 1 {
+		t.Fatalf("Unexpected metrics: %s", w.Body.String())
+	}
+}
+
+func TestDurationStats(t *testing.T) {
+	stats := durationStats([]float64{30, 10, 20, 100})
+	if stats["count"] != 4 || stats["average_seconds"] != int64(40) || stats["median_seconds"] != int64(25) {
+		t.Fatalf("Unexpected stats: %v", stats)
+	}
+}
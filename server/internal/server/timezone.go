@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	// Zone names must load on hosts without zoneinfo
+	_ "time/tzdata"
+)
+
+// defaultDigestHour is when digests go out unless digest_hour says otherwise
+const defaultDigestHour = 9
+
+// validTimezone reports whether name is an IANA zone such as Europe/Berlin
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// serverLocation returns the zone of the server_timezone setting. Digests
+// are scheduled and reminders tell the time in it; the API always answers
+// in UTC.
+func (s *Server) serverLocation() *time.Location {
+	name, _ := s.db.GetSetting("server_timezone")
+	if !validTimezone(name) {
+		return time.UTC
+	}
+	location, _ := time.LoadLocation(name)
+	return location
+}
+
+// localClock renders a time on the server's clock, such as 18:00 CEST
+func (s *Server) localClock(t time.Time) string {
+	return t.In(s.serverLocation()).Format("15:04 MST")
+}
+
+// digestHourNow reports whether it is the digest_hour in the server's
+// timezone
+func (s *Server) digestHourNow(now time.Time) bool {
+	return now.In(s.serverLocation()).Hour() == s.getIntSetting("digest_hour", defaultDigestHour)
+}
+
+// handleGetTime answers with the server's clock, in Unix milliseconds as
+// the WebSocket's clock events carry it, so clients can work out their
+// offset before they connect
+func (s *Server) handleGetTime(c *gin.Context) {
+	now := time.Now()
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"server_time": now.UnixMilli(),
+			"timestamp":   now.UTC(),
+			"timezone":    s.serverLocation().String(),
+		},
+	})
+}
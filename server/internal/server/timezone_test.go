@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerTimezone(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	for _, key := range []string{"server_timezone", "digest_hour"} {
+		previous, _ := db.GetSetting(key)
+		defer func(key string) {
+			_ = db.SetSetting(key, previous, settingDescriptions[key])
+		}(key)
+	}
+	_ = db.SetSetting("server_timezone", "", settingDescriptions["server_timezone"])
+	_ = db.SetSetting("digest_hour", "9", settingDescriptions["digest_hour"])
+
+	start := time.Date(2026, 1, 15, 17, 0, 0, 0, time.UTC)
+	if clock := s.localClock(start); clock != "17:00 UTC" {
+		t.Errorf("Expected UTC without a timezone, got %s", clock)
+	}
+
+	_ = db.SetSetting("server_timezone", "Europe/Berlin", settingDescriptions["server_timezone"])
+	if clock := s.localClock(start); clock != "18:00 CET" {
+		t.Errorf("Expected the Berlin clock, got %s", clock)
+	}
+	if !s.digestHourNow(time.Date(2026, 1, 15, 8, 30, 0, 0, time.UTC)) {
+		t.Error("Expected 08:30 UTC to be the 9 o'clock digest hour in Berlin")
+	}
+	if s.digestHourNow(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)) {
+		t.Error("Expected 09:30 UTC to be past the digest hour in Berlin")
+	}
+
+	for _, name := range []string{"", "Local", "Mars/Olympus"} {
+		if validTimezone(name) {
+			t.Errorf("Expected %q to be refused as a timezone", name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"fethur/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenVersionValid reports whether a token's version matches the user's
+// current token_version
+func (s *Server) tokenVersionValid(userID, tokenVersion int) bool {
+	var current int
+	if err := s.db.QueryRow("SELECT token_version FROM users WHERE id = ?", userID).Scan(&current); err != nil {
+		return false
+	}
+	return current == tokenVersion
+}
+
+// invalidateTokens bumps a user's token version so every JWT issued so far
+// is rejected, revokes their refresh tokens and closes their open
+// connections
+func (s *Server) invalidateTokens(userID int, reason string) error {
+	if _, err := s.db.Exec(
+		"UPDATE users SET token_version = token_version + 1 WHERE id = ?",
+		userID,
+	); err != nil {
+		return err
+	}
+	if err := s.revokeRefreshTokens(userID); err != nil {
+		return err
+	}
+
+	s.disconnectUser(userID, "logout", reason)
+	return nil
+}
+
+// handleLogoutEverywhere signs the current user out of every session
+func (s *Server) handleLogoutEverywhere(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := s.invalidateTokens(userID, "Signed out everywhere"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Signed out of all sessions",
+	})
+}
+
+// handleAdminLogoutUser signs a user out of every session
+func (s *Server) handleAdminLogoutUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := s.invalidateTokens(userID, "Signed out by an administrator"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out user"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "logout_user", fmt.Sprintf("Signed out user %s (ID: %d) everywhere", username, userID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "User signed out of all sessions",
+	})
+}
+
+// handleGetSigningKeys lists the JWT signing keys still verifying tokens
+func (s *Server) handleGetSigningKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.auth.Keys().Info(),
+	})
+}
+
+// handleRotateSigningKey makes a new key sign tokens. Tokens signed by the
+// previous key stay valid until they expire.
+func (s *Server) handleRotateSigningKey(c *gin.Context) {
+	key, err := s.auth.Keys().Rotate()
+	if errors.Is(err, auth.ErrStaticKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate JWT signing key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "rotate_signing_key", fmt.Sprintf("Rotated the JWT signing key to %s", key.ID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.auth.Keys().Info(),
+	})
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Two-factor sign-in with authenticator app codes (TOTP). A user sets up a
+// secret, confirms it with a code to turn it on and gets backup codes.
+// From then on the password alone only returns a challenge token, which
+// /api/auth/2fa/verify trades for a session given a code or an unused
+// backup code.
+
+const (
+	twoFactorIssuer = "Fethur"
+	// maxTwoFactorAttempts is how many codes one user may try per window
+	maxTwoFactorAttempts = 5
+	twoFactorWindow      = 5 * time.Minute
+)
+
+type twoFactorState struct {
+	secret   sql.NullString
+	enabled  bool
+	lastStep int64
+}
+
+func (s *Server) twoFactorState(userID int) (twoFactorState, error) {
+	var state twoFactorState
+	err := s.db.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?", userID,
+	).Scan(&state.secret, &state.enabled, &state.lastStep)
+	return state, err
+}
+
+// checkTOTP accepts an authenticator code once, recording its step so it
+// cannot be replayed
+func (s *Server) checkTOTP(userID int, state twoFactorState, code string) bool {
+	step, ok := auth.ValidateTOTP(state.secret.String, code, time.Now(), state.lastStep)
+	if !ok {
+		return false
+	}
+	result, err := s.db.Exec(
+		"UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step,
+	)
+	if err != nil {
+		log.Printf("Failed to record two-factor step for user %d: %v", userID, err)
+		return false
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1
+}
+
+// useBackupCode spends one of the user's unused backup codes
+func (s *Server) useBackupCode(userID int, code string) bool {
+	result, err := s.db.Exec(
+		"UPDATE user_backup_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, auth.HashBackupCode(code),
+	)
+	if err != nil {
+		log.Printf("Failed to use backup code for user %d: %v", userID, err)
+		return false
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1
+}
+
+// checkSecondFactor accepts either an authenticator or a backup code
+func (s *Server) checkSecondFactor(userID int, state twoFactorState, code string) bool {
+	return s.checkTOTP(userID, state, code) || s.useBackupCode(userID, code)
+}
+
+// allowTwoFactorAttempt limits how fast codes can be guessed for a user
+func (s *Server) allowTwoFactorAttempt(c *gin.Context, userID int) bool {
+	if s.totpAttempts != nil && !s.totpAttempts.allow(strconv.Itoa(userID), time.Now()) {
+		c.Header("Retry-After", strconv.Itoa(int(twoFactorWindow.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later"})
+		return false
+	}
+	return true
+}
+
+// replaceBackupCodes generates a new set of backup codes, voiding the old
+func (s *Server) replaceBackupCodes(userID int) ([]string, error) {
+	codes, err := auth.GenerateBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec("DELETE FROM user_backup_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(
+			"INSERT INTO user_backup_codes (user_id, code_hash) VALUES (?, ?)", userID, auth.HashBackupCode(code),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// handleGetTwoFactor shows whether two-factor sign-in is on
+func (s *Server) handleGetTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	state, err := s.twoFactorState(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var remaining int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM user_backup_codes WHERE user_id = ? AND used_at IS NULL", userID,
+	).Scan(&remaining); err != nil {
+		log.Printf("Failed to count backup codes for user %d: %v", userID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"enabled":                state.enabled,
+			"backup_codes_remaining": remaining,
+		},
+	})
+}
+
+// handleSetupTwoFactor creates an authenticator secret for the user. It
+// takes effect once confirmed with handleEnableTwoFactor.
+func (s *Server) handleSetupTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	state, err := s.twoFactorState(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if state.enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor authentication"})
+		return
+	}
+	if _, err := s.db.Exec(
+		"UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, userID,
+	); err != nil {
+		log.Printf("Failed to store two-factor secret for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"secret":      secret,
+			"otpauth_url": auth.TOTPURL(twoFactorIssuer, c.GetString("username"), secret),
+		},
+	})
+}
+
+// handleEnableTwoFactor turns two-factor sign-in on once the user proves
+// their app has the secret, and returns their backup codes
+func (s *Server) handleEnableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	state, err := s.twoFactorState(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if state.enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if !state.secret.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set up two-factor authentication first"})
+		return
+	}
+	if !s.allowTwoFactorAttempt(c, userID) {
+		return
+	}
+	if !s.checkTOTP(userID, state, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := s.replaceBackupCodes(userID)
+	if err != nil {
+		log.Printf("Failed to create backup codes for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	if _, err := s.db.Exec("UPDATE users SET totp_enabled = 1 WHERE id = ?", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"backup_codes": codes},
+	})
+}
+
+// handleVerifyTwoFactor finishes a sign-in that needs a second factor
+func (s *Server) handleVerifyTwoFactor(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := s.auth.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, sign in again"})
+		return
+	}
+	userID := claims.UserID
+	if !s.allowTwoFactorAttempt(c, userID) {
+		return
+	}
+
+	var username, email, role string
+	var tokenVersion, orgID int
+	if err := s.db.QueryRow(
+		"SELECT username, email, role, token_version, org_id FROM users WHERE id = ?", userID,
+	).Scan(&username, &email, &role, &tokenVersion, &orgID); err != nil || tokenVersion != claims.TokenVersion {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, sign in again"})
+		return
+	}
+	if s.isUserBanned(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+		return
+	}
+	state, err := s.twoFactorState(userID)
+	if err != nil || !state.enabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, sign in again"})
+		return
+	}
+	if !s.checkSecondFactor(userID, state, req.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	s.completeLogin(c, userID, username, email, role, tokenVersion, orgID)
+}
+
+// handleRegenerateBackupCodes replaces the user's backup codes
+func (s *Server) handleRegenerateBackupCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	state, err := s.twoFactorState(userID)
+	if err != nil || !state.enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !s.allowTwoFactorAttempt(c, userID) {
+		return
+	}
+	if !s.checkTOTP(userID, state, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := s.replaceBackupCodes(userID)
+	if err != nil {
+		log.Printf("Failed to replace backup codes for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"backup_codes": codes},
+	})
+}
+
+// disableTwoFactor turns two-factor sign-in off and forgets the secret
+func (s *Server) disableTwoFactor(userID int) error {
+	if _, err := s.db.Exec(
+		"UPDATE users SET totp_enabled = 0, totp_secret = NULL, totp_last_step = 0 WHERE id = ?", userID,
+	); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM user_backup_codes WHERE user_id = ?", userID)
+	return err
+}
+
+// handleDisableTwoFactor turns two-factor sign-in off, given the password
+// and a code
+func (s *Server) handleDisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	var passwordHash string
+	if err := s.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	state, err := s.twoFactorState(userID)
+	if err != nil || !state.enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !s.allowTwoFactorAttempt(c, userID) {
+		return
+	}
+	if !s.auth.CheckPassword(req.Password, passwordHash) || !s.checkSecondFactor(userID, state, req.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		return
+	}
+
+	if err := s.disableTwoFactor(userID); err != nil {
+		log.Printf("Failed to disable two-factor authentication for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// handleAdminResetTwoFactor turns off two-factor sign-in for a user who
+// lost their authenticator and backup codes
+func (s *Server) handleAdminResetTwoFactor(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := s.disableTwoFactor(userID); err != nil {
+		log.Printf("Failed to reset two-factor authentication for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	s.logAdminAction(c.GetInt("user_id"), "reset_two_factor", fmt.Sprintf("Reset two-factor authentication for user %s (ID: %d)", username, userID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication reset",
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	username := fmt.Sprintf("totp_%d", time.Now().UnixNano())
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, '', ?)", username, hash)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", s.handleLogin)
+	router.POST("/auth/2fa/verify", s.handleVerifyTwoFactor)
+	signedIn := router.Group("/", func(c *gin.Context) {
+		c.Set("user_id", int(userID))
+		c.Set("username", username)
+	})
+	signedIn.POST("/auth/2fa/setup", s.handleSetupTwoFactor)
+	signedIn.POST("/auth/2fa/enable", s.handleEnableTwoFactor)
+	signedIn.POST("/auth/2fa/disable", s.handleDisableTwoFactor)
+	post := func(path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		var got map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &got)
+		return w, got
+	}
+	login := func() map[string]interface{} {
+		w, got := post("/auth/login", fmt.Sprintf(`{"username":%q,"password":"correct-horse-battery"}`, username))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected login to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+		return got
+	}
+
+	if got := login(); got["token"] == nil {
+		t.Fatalf("Expected a session without two-factor, got %v", got)
+	}
+
+	// Setting up does nothing until a code confirms it
+	w, setup := post("/auth/2fa/setup", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected setup to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	secret := setup["data"].(map[string]interface{})["secret"].(string)
+	if got := login(); got["token"] == nil {
+		t.Error("Expected two-factor to stay off until confirmed")
+	}
+	if w, _ := post("/auth/2fa/enable", `{"code":"000000x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a wrong code to be refused, got %d", w.Code)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now())
+	w, enabled := post("/auth/2fa/enable", fmt.Sprintf(`{"code":%q}`, code))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected enabling to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	backupCodes := enabled["data"].(map[string]interface{})["backup_codes"].([]interface{})
+
+	// The password alone now only earns a challenge
+	challenge := login()
+	if challenge["token"] != nil || challenge["two_factor_required"] != true {
+		t.Fatalf("Expected a challenge instead of a session, got %v", challenge)
+	}
+	token := challenge["challenge_token"].(string)
+	if _, err := s.auth.ValidateToken(token); err == nil {
+		t.Error("Expected the challenge token not to work as a session")
+	}
+	verify := func(code string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return post("/auth/2fa/verify", fmt.Sprintf(`{"challenge_token":%q,"code":%q}`, token, code))
+	}
+	if w, _ := verify(code); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used code to be refused, got %d", w.Code)
+	}
+	next, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if w, got := verify(next); w.Code != http.StatusOK || got["token"] == nil || got["refresh_token"] == nil {
+		t.Errorf("Expected the code to finish signing in, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Backup codes work once
+	backup := backupCodes[0].(string)
+	if w, _ := verify(strings.ToUpper(backup)); w.Code != http.StatusOK {
+		t.Errorf("Expected a backup code to sign in, got %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := verify(backup); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used backup code to be refused, got %d", w.Code)
+	}
+
+	// Guessing is rate limited per user
+	s.totpAttempts = newRateLimiter(1, time.Minute)
+	verify("111111")
+	if w, _ := verify("222222"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected repeated guesses to be limited, got %d", w.Code)
+	}
+	s.totpAttempts = nil
+
+	if w, _ := post("/auth/2fa/disable", fmt.Sprintf(`{"password":"wrong","code":%q}`, backupCodes[1])); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected disabling to need the password, got %d", w.Code)
+	}
+	if w, _ := post("/auth/2fa/disable", fmt.Sprintf(`{"password":"correct-horse-battery","code":%q}`, backupCodes[2])); w.Code != http.StatusOK {
+		t.Errorf("Expected disabling to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := login(); got["token"] == nil {
+		t.Error("Expected the password to be enough again")
+	}
+	var remaining int
+	_ = db.QueryRow("SELECT COUNT(*) FROM user_backup_codes WHERE user_id = ?", userID).Scan(&remaining)
+	if remaining != 0 {
+		t.Errorf("Expected backup codes to be deleted, %d left", remaining)
+	}
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Per-user API usage. Requests on authenticated routes are counted in
+// memory per user, in minute buckets for the last hour and hour buckets for
+// the last day, so admins can spot abusive clients and see how close real
+// traffic comes to the rate limits. Counts start over when the server
+// restarts.
+
+const maxTopUsers = 100
+
+// usageWindows are the rolling windows usage is reported over
+var usageWindows = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+}
+
+// usageCounts is a user's traffic over some period
+type usageCounts struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"` // responses with a 4xx or 5xx status
+	Messages int64 `json:"messages"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	// PeakPerMinute is the most requests in any one minute
+	PeakPerMinute int64 `json:"peak_requests_per_minute"`
+}
+
+func (u *usageCounts) add(other usageCounts) {
+	u.Requests += other.Requests
+	u.Errors += other.Errors
+	u.Messages += other.Messages
+	u.BytesIn += other.BytesIn
+	u.BytesOut += other.BytesOut
+	if other.PeakPerMinute > u.PeakPerMinute {
+		u.PeakPerMinute = other.PeakPerMinute
+	}
+}
+
+// get returns one of the counts by its JSON name
+func (u usageCounts) get(name string) (int64, bool) {
+	switch name {
+	case "requests":
+		return u.Requests, true
+	case "errors":
+		return u.Errors, true
+	case "messages":
+		return u.Messages, true
+	case "bytes_in":
+		return u.BytesIn, true
+	case "bytes_out":
+		return u.BytesOut, true
+	}
+	return 0, false
+}
+
+type usageBucket struct {
+	start int64 // Unix minute or hour the bucket counts
+	usageCounts
+}
+
+// userUsage holds a user's buckets, indexed by minute and hour
+type userUsage struct {
+	minutes  [60]usageBucket
+	hours    [24]usageBucket
+	lastSeen time.Time
+}
+
+// usageTracker counts requests per user
+type usageTracker struct {
+	mutex     sync.Mutex
+	users     map[int]*userUsage
+	lastPrune time.Time
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{users: make(map[int]*userUsage)}
+}
+
+// record adds traffic to a user's current minute and hour
+func (t *usageTracker) record(userID int, now time.Time, delta usageCounts) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if now.Sub(t.lastPrune) >= time.Hour {
+		for id, usage := range t.users {
+			if now.Sub(usage.lastSeen) >= 24*time.Hour {
+				delete(t.users, id)
+			}
+		}
+		t.lastPrune = now
+	}
+	usage, ok := t.users[userID]
+	if !ok {
+		usage = &userUsage{}
+		t.users[userID] = usage
+	}
+	usage.lastSeen = now
+
+	minute := now.Unix() / 60
+	m := &usage.minutes[minute%60]
+	if m.start != minute {
+		*m = usageBucket{start: minute}
+	}
+	m.add(delta)
+	m.PeakPerMinute = m.Requests
+
+	hour := now.Unix() / 3600
+	h := &usage.hours[hour%24]
+	if h.start != hour {
+		*h = usageBucket{start: hour}
+	}
+	h.add(delta)
+	if m.Requests > h.PeakPerMinute {
+		h.PeakPerMinute = m.Requests
+	}
+}
+
+// window sums a user's traffic over a rolling window, in whole minutes up
+// to an hour and whole hours beyond
+func (t *usageTracker) window(userID int, now time.Time, window time.Duration) usageCounts {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var total usageCounts
+	usage, ok := t.users[userID]
+	if !ok {
+		return total
+	}
+	if window <= time.Hour {
+		first := now.Unix()/60 - int64(window/time.Minute) + 1
+		for _, bucket := range usage.minutes {
+			if bucket.start >= first {
+				total.add(bucket.usageCounts)
+			}
+		}
+		return total
+	}
+	first := now.Unix()/3600 - int64(window/time.Hour) + 1
+	for _, bucket := range usage.hours {
+		if bucket.start >= first {
+			total.add(bucket.usageCounts)
+		}
+	}
+	return total
+}
+
+// userIDs lists the users with traffic in the last day
+func (t *usageTracker) userIDs() []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ids := make([]int, 0, len(t.users))
+	for id := range t.users {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// usageMiddleware counts each request toward the signed-in user's usage
+func (s *Server) usageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		// Every counted request feeds the error rate alert rules watch
+		if s.requests != nil {
+			s.requests.record(time.Now(), c.Writer.Status())
+		}
+
+		userID := c.GetInt("user_id")
+		if s.usage == nil || userID == 0 {
+			return
+		}
+		delta := usageCounts{Requests: 1}
+		if c.Writer.Status() >= 400 {
+			delta.Errors = 1
+		}
+		if c.Request.ContentLength > 0 {
+			delta.BytesIn = c.Request.ContentLength
+		}
+		if size := c.Writer.Size(); size > 0 {
+			delta.BytesOut = int64(size)
+		}
+		s.usage.record(userID, time.Now(), delta)
+	}
+}
+
+// recordMessageUsage counts a message a user sent
+func (s *Server) recordMessageUsage(userID int) {
+	if s.usage != nil {
+		s.usage.record(userID, time.Now(), usageCounts{Messages: 1})
+	}
+}
+
+// handleGetUserUsage shows a user's traffic over each window
+func (s *Server) handleGetUserUsage(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	windows := make(gin.H, len(usageWindows))
+	for name, window := range usageWindows {
+		var counts usageCounts
+		if s.usage != nil {
+			counts = s.usage.window(userID, now, window)
+		}
+		windows[name] = counts
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"user_id":  userID,
+			"username": username,
+			"windows":  windows,
+		},
+	})
+}
+
+// handleGetTopUsers lists the organization's busiest users over a window,
+// ranked by one of the counts
+func (s *Server) handleGetTopUsers(c *gin.Context) {
+	windowName := c.DefaultQuery("window", "1h")
+	window, ok := usageWindows[windowName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be 5m, 1h or 24h"})
+		return
+	}
+	by := c.DefaultQuery("by", "requests")
+	if _, ok := (usageCounts{}).get(by); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by must be requests, errors, messages, bytes_in or bytes_out"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxTopUsers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTopUsers)})
+		return
+	}
+
+	// Only users of the admin's organization are ranked
+	rows, err := s.reader(c).Query("SELECT id, username FROM users WHERE org_id = ?", c.GetInt("org_id"))
+	if err != nil {
+		log.Printf("Failed to list users for usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	usernames := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err == nil {
+			usernames[id] = username
+		}
+	}
+	_ = rows.Close()
+
+	type talker struct {
+		UserID   int    `json:"user_id"`
+		Username string `json:"username"`
+		usageCounts
+	}
+	talkers := make([]talker, 0)
+	if s.usage != nil {
+		now := time.Now()
+		for _, userID := range s.usage.userIDs() {
+			username, ok := usernames[userID]
+			if !ok {
+				continue
+			}
+			counts := s.usage.window(userID, now, window)
+			if value, _ := counts.get(by); value > 0 {
+				talkers = append(talkers, talker{UserID: userID, Username: username, usageCounts: counts})
+			}
+		}
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		a, _ := talkers[i].get(by)
+		b, _ := talkers[j].get(by)
+		if a != b {
+			return a > b
+		}
+		return talkers[i].UserID < talkers[j].UserID
+	})
+	if len(talkers) > limit {
+		talkers = talkers[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"window": windowName,
+			"by":     by,
+			"users":  talkers,
+		},
+	})
+}
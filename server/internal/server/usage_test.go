@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUsageTracker(t *testing.T) {
+	tracker := newUsageTracker()
+	start := time.Date(2025, 7, 28, 20, 0, 30, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		tracker.record(1, start, usageCounts{Requests: 1, BytesOut: 100})
+	}
+	tracker.record(1, start.Add(2*time.Minute), usageCounts{Requests: 1, Errors: 1})
+	tracker.record(1, start.Add(2*time.Minute), usageCounts{Messages: 1})
+	tracker.record(2, start, usageCounts{Requests: 1})
+
+	now := start.Add(2 * time.Minute)
+	got := tracker.window(1, now, 5*time.Minute)
+	want := usageCounts{Requests: 4, Errors: 1, Messages: 1, BytesOut: 300, PeakPerMinute: 3}
+	if got != want {
+		t.Errorf("Expected %+v over five minutes, got %+v", want, got)
+	}
+
+	// Older minutes leave the short window but stay in the day
+	later := start.Add(10 * time.Minute)
+	if got := tracker.window(1, later, 5*time.Minute); got.Requests != 0 {
+		t.Errorf("Expected nothing in the last five minutes, got %+v", got)
+	}
+	if got := tracker.window(1, later, 24*time.Hour); got.Requests != 4 || got.PeakPerMinute != 3 {
+		t.Errorf("Expected the day to keep the traffic and peak, got %+v", got)
+	}
+
+	// A reused bucket starts over: the first minute's three requests give
+	// way to one, next to the later minute's one
+	tracker.record(1, start.Add(time.Hour), usageCounts{Requests: 1})
+	if got := tracker.window(1, start.Add(time.Hour), time.Hour); got.Requests != 2 {
+		t.Errorf("Expected the hour-old minute to be replaced, got %+v", got)
+	}
+
+	// Users quiet for a day are dropped
+	tracker.record(2, start.Add(25*time.Hour), usageCounts{Requests: 1})
+	if len(tracker.userIDs()) != 1 {
+		t.Errorf("Expected idle users to be pruned, got %v", tracker.userIDs())
+	}
+}
+
+func TestTopUsers(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	s.usage = newUsageTracker()
+
+	result, err := db.Exec("INSERT INTO organizations (name, slug) VALUES ('Usage', ?)", fmt.Sprintf("usage-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatalf("Failed to create organization: %v", err)
+	}
+	orgID, _ := result.LastInsertId()
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int)
+	for _, name := range []string{"quiet", "noisy", "elsewhere"} {
+		org := orgID
+		if name == "elsewhere" {
+			org = 0
+		}
+		result, err := db.Exec("INSERT INTO users (username, password_hash, org_id) VALUES (?, 'x', ?)", fmt.Sprintf("usage%s_%d", name, suffix), org)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		users[name] = int(id)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", userID)
+		c.Set("org_id", int(orgID))
+	}, s.usageMiddleware())
+	router.POST("/echo", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/admin/users/:id/usage", s.handleGetUserUsage)
+	router.GET("/admin/usage/top", s.handleGetTopUsers)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", strconv.Itoa(users[user]))
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 5; i++ {
+		request("POST", "/echo", "noisy", "hello")
+		request("POST", "/echo", "elsewhere", "hello")
+	}
+	request("POST", "/echo", "quiet", "")
+
+	var usage struct {
+		Data struct {
+			Windows map[string]usageCounts `json:"windows"`
+		} `json:"data"`
+	}
+	w := request("GET", fmt.Sprintf("/admin/users/%d/usage", users["noisy"]), "quiet", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &usage)
+	if got := usage.Data.Windows["5m"]; got.Requests != 5 || got.BytesIn != 25 || got.BytesOut != 10 {
+		t.Errorf("Expected the middleware to count the requests, got %s", w.Body.String())
+	}
+
+	if w := request("GET", "/admin/usage/top?by=likes", "quiet", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown count to be refused, got %d", w.Code)
+	}
+	var top struct {
+		Data struct {
+			Users []struct {
+				UserID   int   `json:"user_id"`
+				Requests int64 `json:"requests"`
+			} `json:"users"`
+		} `json:"data"`
+	}
+	w = request("GET", "/admin/usage/top?window=5m", "quiet", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &top)
+	if len(top.Data.Users) != 2 || top.Data.Users[0].UserID != users["noisy"] || top.Data.Users[1].UserID != users["quiet"] {
+		t.Errorf("Expected the organization's users, busiest first, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Email verification confirms that users own the address on their
+// account. The email_verification setting decides what unverified users
+// with the user role may do: everything (off), read but not write
+// (restrict), or nothing until they verify (required). Admins and holders
+// of custom roles are trusted and never held back.
+const (
+	emailVerifyPurpose = "email-verify"
+
+	// emailVerificationTTL is how long a link stays valid. Signing keys
+	// retire a day after rotation, so links cannot outlive that.
+	emailVerificationTTL = 24 * time.Hour
+
+	// verificationResendInterval keeps users from flooding an inbox
+	verificationResendInterval = 5 * time.Minute
+)
+
+// emailVerificationModes are the values of the email_verification setting
+var emailVerificationModes = map[string]bool{
+	"off":      true,
+	"restrict": true,
+	"required": true,
+}
+
+// unverifiedRoutes are the requests unverified users may always make, so
+// they can add an address and ask for a new link
+var unverifiedRoutes = map[string]bool{
+	"GET /auth/me":           true,
+	"GET /user/profile":      true,
+	"POST /auth/verify/send": true,
+}
+
+var (
+	errNoEmail               = errors.New("account has no email address")
+	errEmailAlreadyVerified  = errors.New("email address is already verified")
+	errVerificationThrottled = errors.New("a verification email was sent recently")
+	errMailDisabled          = errors.New("email is not configured")
+)
+
+// emailVerificationMode returns the email_verification setting
+func (s *Server) emailVerificationMode() string {
+	mode, _ := s.db.GetSetting("email_verification")
+	if !emailVerificationModes[mode] {
+		return "off"
+	}
+	return mode
+}
+
+// validEmail reports whether address is a bare email address
+func validEmail(address string) bool {
+	parsed, err := netmail.ParseAddress(address)
+	return err == nil && parsed.Address == address
+}
+
+// verificationToken creates a signed, expiring token that verifies the
+// given address of a user. Changing the address invalidates it.
+func (s *Server) verificationToken(userID int, email string, expires time.Time) string {
+	value := fmt.Sprintf("%d.%d", userID, expires.Unix())
+	return value + "." + s.auth.Sign(emailVerifyPurpose, value+"."+email)
+}
+
+// checkVerificationToken returns the user and address a token verifies
+func (s *Server) checkVerificationToken(token string) (int, string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, "", false
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, "", false
+	}
+	var email string
+	if err := s.db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil || email == "" {
+		return 0, "", false
+	}
+	if !s.auth.VerifySignature(emailVerifyPurpose, parts[0]+"."+parts[1]+"."+email, parts[2]) {
+		return 0, "", false
+	}
+	return userID, email, true
+}
+
+// sendVerificationEmail mails a verification link to the user's address
+func (s *Server) sendVerificationEmail(ctx context.Context, userID int) error {
+	if s.mailer == nil {
+		return errMailDisabled
+	}
+	var username, email string
+	var verified bool
+	var sentAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT username, email, email_verified, verification_sent_at FROM users WHERE id = ?", userID,
+	).Scan(&username, &email, &verified, &sentAt)
+	if err != nil {
+		return err
+	}
+	switch {
+	case email == "":
+		return errNoEmail
+	case verified:
+		return errEmailAlreadyVerified
+	case sentAt.Valid && time.Since(sentAt.Time) < verificationResendInterval:
+		return errVerificationThrottled
+	}
+	if _, err := s.db.Exec("UPDATE users SET verification_sent_at = ? WHERE id = ?", time.Now().UTC(), userID); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("token", s.verificationToken(userID, email, time.Now().Add(emailVerificationTTL)))
+	return s.sendEmail(ctx, userID, email, mail.TemplateVerification, map[string]interface{}{
+		"Username":  username,
+		"VerifyURL": s.mailer.URL("/api/auth/verify?" + query.Encode()),
+		"ExpiresIn": "24 hours",
+	})
+}
+
+// needsVerification reports whether the email_verification setting holds
+// a user back
+func (s *Server) needsVerification(userID int) bool {
+	var role string
+	var verified bool
+	if err := s.db.QueryRow("SELECT role, email_verified FROM users WHERE id = ?", userID).Scan(&role, &verified); err != nil {
+		return false
+	}
+	return role == "user" && !verified
+}
+
+// allowUnverified applies the email_verification setting to a request.
+// It answers and returns false when the request is refused; unverified
+// users that may read are marked read_only for the chat socket.
+func (s *Server) allowUnverified(c *gin.Context, userID int) bool {
+	mode := s.emailVerificationMode()
+	if mode == "off" || !s.needsVerification(userID) {
+		return true
+	}
+	route := c.FullPath()
+	if i := strings.Index(route, "/api/"); i >= 0 {
+		route = route[i+len("/api"):]
+	} else if i := strings.LastIndex(route, "/ws"); i >= 0 {
+		route = route[i:]
+	}
+	if unverifiedRoutes[c.Request.Method+" "+route] {
+		return true
+	}
+	if mode == "restrict" && c.Request.Method == http.MethodGet {
+		c.Set("read_only", true)
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address first", "code": "email_unverified"})
+	c.Abort()
+	return false
+}
+
+// handleVerifyEmail confirms an address through a link from a
+// verification email
+func (s *Server) handleVerifyEmail(c *gin.Context) {
+	userID, email, ok := s.checkVerificationToken(c.Query("token"))
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE users SET email_verified = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND email = ?", userID, email,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Your email address is verified",
+	})
+}
+
+// handleSendVerification mails the user a new verification link. A new
+// address may be given, which replaces the unverified one.
+func (s *Server) handleSendVerification(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.GetInt("user_id")
+
+	if email := strings.TrimSpace(req.Email); email != "" {
+		if !validEmail(email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+			return
+		}
+		var current string
+		var verified bool
+		if err := s.db.QueryRow("SELECT email, email_verified FROM users WHERE id = ?", userID).Scan(&current, &verified); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if verified {
+			c.JSON(http.StatusConflict, gin.H{"error": "Your email address is already verified"})
+			return
+		}
+		if email != current {
+			if _, err := s.db.Exec(
+				"UPDATE users SET email = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", email, userID,
+			); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update email"})
+				return
+			}
+		}
+	}
+
+	err := s.sendVerificationEmail(c.Request.Context(), userID)
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Add an email address to verify"})
+		return
+	case errors.Is(err, errEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": "Your email address is already verified"})
+		return
+	case errors.Is(err, errVerificationThrottled):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A verification email was sent recently; try again in a few minutes"})
+		return
+	case errors.Is(err, errMailDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email is not configured on this server"})
+		return
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Verification email sent",
+	})
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmailVerification(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	previous, _ := db.GetSetting("email_verification")
+	defer func() {
+		_ = db.SetSetting("email_verification", previous, settingDescriptions["email_verification"])
+	}()
+
+	username := fmt.Sprintf("verify_%d", time.Now().UnixNano())
+	email := username + "@example.com"
+	hash, _ := s.auth.HashPassword("correct-horse-battery")
+	result, err := db.Exec("INSERT INTO users (username, email, password_hash) VALUES (?, ?, ?)", username, email, hash)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	userID := int(id)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api")
+	api.POST("/auth/login", s.handleLogin)
+	api.GET("/auth/verify", s.handleVerifyEmail)
+	protected := api.Group("/")
+	protected.Use(s.authMiddleware())
+	protected.GET("/auth/me", s.handleGetCurrentUser)
+	protected.GET("/probe", func(c *gin.Context) { c.Status(http.StatusOK) })
+	protected.POST("/probe", func(c *gin.Context) { c.Status(http.StatusOK) })
+	token, err := s.auth.GenerateToken(userID, username, "user")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, r)
+		return w
+	}
+	login := func() *httptest.ResponseRecorder {
+		return request("POST", "/api/auth/login", fmt.Sprintf(`{"username":%q,"password":"correct-horse-battery"}`, username))
+	}
+
+	// With verification off, unverified users are not held back
+	_ = db.SetSetting("email_verification", "off", settingDescriptions["email_verification"])
+	if w := request("POST", "/api/probe", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected writes with verification off, got %d", w.Code)
+	}
+
+	// Restricted users read but do not write
+	_ = db.SetSetting("email_verification", "restrict", settingDescriptions["email_verification"])
+	if w := request("GET", "/api/probe", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected restricted users to read, got %d", w.Code)
+	}
+	if w := request("POST", "/api/probe", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "email_unverified") {
+		t.Errorf("Expected restricted users not to write, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// With verification required, unverified users cannot sign in or use
+	// their sessions beyond asking for a link
+	_ = db.SetSetting("email_verification", "required", settingDescriptions["email_verification"])
+	if w := login(); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "email_unverified") {
+		t.Errorf("Expected the login to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("GET", "/api/probe", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected unverified sessions to be refused, got %d", w.Code)
+	}
+	w := request("GET", "/api/auth/me", "")
+	var me struct {
+		Data struct {
+			EmailVerified bool `json:"email_verified"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &me); err != nil || w.Code != http.StatusOK || me.Data.EmailVerified {
+		t.Errorf("Expected /auth/me to report an unverified email, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Links are bound to the address and expire
+	verify := func(token string) int {
+		return request("GET", "/api/auth/verify?token="+url.QueryEscape(token), "").Code
+	}
+	if code := verify(s.verificationToken(userID, "other@example.com", time.Now().Add(time.Hour))); code != http.StatusForbidden {
+		t.Errorf("Expected a link for another address to be refused, got %d", code)
+	}
+	if code := verify(s.verificationToken(userID, email, time.Now().Add(-time.Minute))); code != http.StatusForbidden {
+		t.Errorf("Expected an expired link to be refused, got %d", code)
+	}
+	if code := verify(s.verificationToken(userID, email, time.Now().Add(time.Hour))); code != http.StatusOK {
+		t.Fatalf("Expected the link to verify the address, got %d", code)
+	}
+
+	if w := login(); w.Code != http.StatusOK {
+		t.Errorf("Expected verified users to sign in, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", "/api/probe", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected verified users to write, got %d", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"fethur/internal/update"
+
+	"github.com/gin-gonic/gin"
+)
+
+// updateCheckInterval is how often the release manifest is fetched
+const updateCheckInterval = 6 * time.Hour
+
+// newUpdateChecker checks the release manifest at FETHUR_UPDATE_URL
+func newUpdateChecker() *update.Checker {
+	return update.NewChecker(os.Getenv("FETHUR_UPDATE_URL"), updateCheckInterval)
+}
+
+// startUpdateChecker looks for new releases in the background so admin
+// health can flag them without waiting on the update URL
+func (s *Server) startUpdateChecker(ctx context.Context) {
+	if !s.updates.Configured() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(updateCheckInterval)
+		defer ticker.Stop()
+		for {
+			status := s.updates.Check(ctx)
+			if status.Error != "" {
+				log.Printf("Update check failed: %s", status.Error)
+			} else if status.UpdateAvailable {
+				log.Printf("Fethur %s is available (running %s)", status.Latest.Version, status.Current)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// handleAdminVersion reports the running version and the latest release
+func (s *Server) handleAdminVersion(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.updates.Check(ctx),
+	})
+}
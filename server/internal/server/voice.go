@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateVoiceChannel is the voice hub's join check: the channel must exist,
+// be a voice or join-to-create channel below its user limit, and belong to
+// a server the user is a member of
+func (s *Server) validateVoiceChannel(userID, channelID int64) (int64, error) {
+	info, err := s.lookupChannelForUser(int(userID), int(channelID))
+	if err != nil {
+		return 0, err
+	}
+
+	if info.ChannelType != "voice" && info.ChannelType != voiceGeneratorType {
+		return 0, fmt.Errorf("channel %d is not a voice channel", channelID)
+	}
+	if err := s.checkVoiceUserLimit(userID, channelID); err != nil {
+		return 0, err
+	}
+
+	return int64(info.ServerID), nil
+}
+
+// validateTextChannel is the WebSocket hub's subscription check
+func (s *Server) validateTextChannel(userID, channelID int) error {
+	if s.isGuest(userID) && !s.guestChannels()[channelID] {
+		return errChannelNotFound
+	}
+	_, err := s.lookupChannelForUser(userID, channelID)
+	return err
+}
+
+func (s *Server) handleGetVoiceStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.voiceHub.GetVoiceStats(),
+	})
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverPaths are handled by the server itself; unknown paths under them
+// are API errors rather than client pages
+var serverPaths = []string{"/api/", "/ws", "/voice", "/health"}
+
+// serveClient serves the web client for every path no route matches,
+// under the base path
+func (s *Server) serveClient(client http.Handler) {
+	s.router.NoRoute(func(c *gin.Context) {
+		rest, ok := strings.CutPrefix(c.Request.URL.Path, s.basePath)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		for _, prefix := range serverPaths {
+			if strings.HasPrefix(rest, prefix) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+				return
+			}
+		}
+
+		request := c.Request.Clone(c.Request.Context())
+		request.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+		client.ServeHTTP(c.Writer, request)
+	})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"fethur/internal/webui"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{router: gin.New(), basePath: "/fethur"}
+	s.router.GET("/fethur/api/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	s.serveClient(webui.New(fstest.MapFS{
+		"index.html": {Data: []byte("<html>app</html>")},
+	}))
+
+	tests := []struct {
+		path, body string
+		code       int
+	}{
+		{"/fethur/api/ping", "pong", http.StatusOK},
+		{"/fethur/", "<html>app</html>", http.StatusOK},
+		{"/fethur", "<html>app</html>", http.StatusOK},
+		{"/fethur/channels/3", "<html>app</html>", http.StatusOK},
+		{"/fethur/api/missing", `{"error":"Not found"}`, http.StatusNotFound},
+		{"/fethur/ws/other", `{"error":"Not found"}`, http.StatusNotFound},
+		{"/fethurish", `{"error":"Not found"}`, http.StatusNotFound},
+		{"/elsewhere", `{"error":"Not found"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.code, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
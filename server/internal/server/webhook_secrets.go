@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// secretRotationGraceHours is how long a rotated webhook secret keeps
+// working, so the other side can be updated without dropping requests
+const secretRotationGraceHours = 24
+
+// secretRotationGrace is secretRotationGraceHours as a SQLite modifier
+var secretRotationGrace = fmt.Sprintf("-%d hours", secretRotationGraceHours)
+
+// signingSecrets returns the secrets outgoing requests are signed with:
+// the current one, and the previous one during its grace period
+func signingSecrets(secret, previous string) []string {
+	if previous == "" {
+		return []string{secret}
+	}
+	return []string{secret, previous}
+}
+
+// deliveryKey identifies a signed incoming request: its body, and its
+// timestamp for formats that send one
+func deliveryKey(header http.Header, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(header.Get(webhooks.TimestampHeader)))
+	sum.Write([]byte("."))
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// recordDelivery remembers a signed delivery and reports whether it is new
+func (s *Server) recordDelivery(webhookID int64, delivery string) bool {
+	if _, err := s.db.Exec(
+		"DELETE FROM webhook_deliveries WHERE webhook_id = ? AND received_at < datetime('now', ?)",
+		webhookID, deliveryRetention,
+	); err != nil {
+		log.Printf("Failed to prune deliveries for webhook %d: %v", webhookID, err)
+	}
+
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO webhook_deliveries (webhook_id, delivery) VALUES (?, ?)",
+		webhookID, delivery,
+	)
+	if err != nil {
+		// Accept the delivery rather than lose it
+		log.Printf("Failed to record delivery for webhook %d: %v", webhookID, err)
+		return true
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1
+}
+
+// forgetDelivery drops a delivery that could not be handled so the sender
+// may retry it
+func (s *Server) forgetDelivery(webhookID int64, delivery string) {
+	if delivery == "" {
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ? AND delivery = ?", webhookID, delivery); err != nil {
+		log.Printf("Failed to forget delivery for webhook %d: %v", webhookID, err)
+	}
+}
+
+// secretRotation is the body of the rotate-secret endpoints. Without a
+// secret one is generated; expire_previous ends the grace period of the
+// old secret at once, for secrets that leaked.
+type secretRotation struct {
+	Secret         string `json:"secret"`
+	ExpirePrevious bool   `json:"expire_previous"`
+}
+
+// bindSecretRotation reads an optional rotation body and fills in the new
+// secret. Only incoming webhooks take a secret chosen by the caller.
+func bindSecretRotation(c *gin.Context, allowCustom bool) (*secretRotation, bool) {
+	var req secretRotation
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if req.Secret != "" && !allowCustom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret is generated by the server"})
+		return nil, false
+	}
+	if len(req.Secret) > 256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret must be at most 256 characters"})
+		return nil, false
+	}
+	if req.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return nil, false
+		}
+		req.Secret = secret
+	}
+	return &req, true
+}
+
+// rotateSecret replaces the secret of a row in one of the webhook tables,
+// keeping the old one for the grace period unless asked not to
+func (s *Server) rotateSecret(table string, id int64, rotation *secretRotation) error {
+	result, err := s.db.Exec(fmt.Sprintf(`
+		UPDATE %s SET previous_secret = CASE WHEN ? THEN '' ELSE secret END,
+			secret = ?, secret_rotated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, table),
+		rotation.ExpirePrevious, rotation.Secret, id,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// respondSecretRotated answers a rotation with the new secret, which is
+// only shown here
+func respondSecretRotated(c *gin.Context, id int64, rotation *secretRotation) {
+	data := gin.H{
+		"id":     id,
+		"secret": rotation.Secret,
+	}
+	if !rotation.ExpirePrevious {
+		data["previous_expires_at"] = time.Now().UTC().Add(secretRotationGraceHours * time.Hour).Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// handleRotateIncomingWebhookSecret gives an incoming webhook a new secret.
+// Until the sending service is updated, its old secret is still accepted.
+func (s *Server) handleRotateIncomingWebhookSecret(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	rotation, ok := bindSecretRotation(c, true)
+	if !ok {
+		return
+	}
+
+	var name, secret string
+	if err := s.db.QueryRow("SELECT name, secret FROM incoming_webhooks WHERE id = ?", id).Scan(&name, &secret); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	// An unsigned webhook has no old secret to honor
+	if secret == "" {
+		rotation.ExpirePrevious = true
+	}
+	if err := s.rotateSecret("incoming_webhooks", id, rotation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "rotate_webhook_secret", fmt.Sprintf("Rotated the secret of webhook %q", name))
+	respondSecretRotated(c, id, rotation)
+}
+
+// handleRotateCommandSecret gives a slash command endpoint a new signing
+// secret; requests are signed with both secrets during the grace period
+func (s *Server) handleRotateCommandSecret(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid command ID"})
+		return
+	}
+	rotation, ok := bindSecretRotation(c, false)
+	if !ok {
+		return
+	}
+
+	var command string
+	if err := s.db.QueryRow("SELECT command FROM command_webhooks WHERE id = ?", id).Scan(&command); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+	if err := s.rotateSecret("command_webhooks", id, rotation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+		return
+	}
+
+	s.logAdminAction(c.GetInt("user_id"), "rotate_command_secret", "Rotated the secret of /"+command)
+	respondSecretRotated(c, id, rotation)
+}
+
+// handleRotateAutomationHookSecret gives a hook created with this API key
+// a new signing secret
+func (s *Server) handleRotateAutomationHookSecret(c *gin.Context) {
+	hookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hook ID"})
+		return
+	}
+	rotation, ok := bindSecretRotation(c, false)
+	if !ok {
+		return
+	}
+
+	var owned int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM automation_hooks WHERE id = ? AND api_key_id = ?", hookID, c.GetInt64("api_key_id"),
+	).Scan(&owned); err != nil || owned == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hook not found"})
+		return
+	}
+	if err := s.rotateSecret("automation_hooks", hookID, rotation); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+		return
+	}
+
+	respondSecretRotated(c, hookID, rotation)
+}
@@ -0,0 +1,515 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fethur/internal/xmpp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// xmppLinkPurpose signs the codes that link an XMPP account to a user
+const xmppLinkPurpose = "xmpp-link"
+
+// xmppLinkTTL is how long a link code stays valid
+const xmppLinkTTL = 15 * time.Minute
+
+// xmppRoomPrefix prefixes channel IDs in room JIDs: channel-12@domain
+const xmppRoomPrefix = "channel-"
+
+// xmppGateway bridges text channels to XMPP multi-user chat rooms. Each
+// channel is a room; XMPP accounts act as the Fethur user they are linked
+// to, and their nickname is always that user's username.
+type xmppGateway struct {
+	s         *Server
+	component *xmpp.Component
+
+	mutex     sync.Mutex
+	occupants map[int]map[string]xmppOccupant // channel ID -> full JID
+}
+
+type xmppOccupant struct {
+	userID   int
+	username string
+}
+
+// EnableXMPP connects to an XMPP server as an external component and
+// starts bridging channels
+func (s *Server) EnableXMPP(config xmpp.Config) error {
+	gateway := &xmppGateway{s: s, occupants: make(map[int]map[string]xmppOccupant)}
+	component, err := xmpp.NewComponent(config, gateway)
+	if err != nil {
+		return err
+	}
+	component.SetGuard(s.integrations.Guard(integrationXMPP, xmppPolicy))
+	gateway.component = component
+	s.xmpp = gateway
+
+	go component.Run(context.Background())
+	return nil
+}
+
+// relayToXMPP sends a channel message to the room's XMPP occupants,
+// including the sender, as MUC reflects messages back
+func (s *Server) relayToXMPP(channelID int, messageID int64, username, content string) {
+	if s.xmpp == nil {
+		return
+	}
+	s.xmpp.mutex.Lock()
+	recipients := make([]string, 0, len(s.xmpp.occupants[channelID]))
+	for jid := range s.xmpp.occupants[channelID] {
+		recipients = append(recipients, jid)
+	}
+	s.xmpp.mutex.Unlock()
+
+	from := s.xmpp.roomJID(channelID) + "/" + username
+	for _, jid := range recipients {
+		if err := s.xmpp.component.Send(xmpp.Message{
+			From: from,
+			To:   jid,
+			ID:   strconv.FormatInt(messageID, 10),
+			Type: "groupchat",
+			Body: content,
+		}); err != nil {
+			log.Printf("Failed to relay message %d to XMPP: %v", messageID, err)
+			return
+		}
+	}
+}
+
+func (g *xmppGateway) roomJID(channelID int) string {
+	return xmppRoomPrefix + strconv.Itoa(channelID) + "@" + g.component.Domain()
+}
+
+// roomChannel returns the channel ID of a room JID
+func (g *xmppGateway) roomChannel(jid xmpp.JID) (int, bool) {
+	id, ok := strings.CutPrefix(jid.Local, xmppRoomPrefix)
+	if !ok {
+		return 0, false
+	}
+	channelID, err := strconv.Atoi(id)
+	return channelID, err == nil && channelID > 0
+}
+
+// linkedUser returns the Fethur user an XMPP account is linked to
+func (g *xmppGateway) linkedUser(jid xmpp.JID) (int, string, bool) {
+	var userID int
+	var username string
+	err := g.s.db.QueryRow(
+		"SELECT u.id, u.username FROM xmpp_links l JOIN users u ON u.id = l.user_id WHERE l.jid = ?",
+		jid.Bare(),
+	).Scan(&userID, &username)
+	return userID, username, err == nil
+}
+
+// HandleMessage posts groupchat messages and runs gateway commands
+func (g *xmppGateway) HandleMessage(message xmpp.Message) {
+	if message.Type == "error" {
+		return
+	}
+	from, to := xmpp.ParseJID(message.From), xmpp.ParseJID(message.To)
+
+	if to.Local == "" {
+		g.handleCommand(message, from)
+		return
+	}
+	channelID, ok := g.roomChannel(to)
+	if !ok {
+		g.bounce(message, xmpp.NewError("cancel", "service-unavailable", "Direct messages are not available through this gateway"))
+		return
+	}
+	if message.Type != "groupchat" {
+		g.bounce(message, xmpp.NewError("modify", "bad-request", "Only groupchat messages can be sent to a room"))
+		return
+	}
+
+	g.mutex.Lock()
+	occupant, joined := g.occupants[channelID][from.String()]
+	g.mutex.Unlock()
+	if !joined {
+		g.bounce(message, xmpp.NewError("modify", "not-acceptable", "Join the room before sending messages"))
+		return
+	}
+	content := strings.TrimSpace(message.Body)
+	if content == "" {
+		return
+	}
+
+	// Access may have been revoked since joining
+	if _, err := g.s.lookupChannelForUser(occupant.userID, channelID); err != nil {
+		g.removeOccupant(channelID, from.String())
+		g.bounce(message, xmpp.NewError("auth", "forbidden", "You are no longer a member of this channel"))
+		return
+	}
+	if _, err := g.s.postChannelMessage(channelID, occupant.userID, occupant.username, "", content, nil); err != nil {
+		log.Printf("Failed to post XMPP message from %s: %v", from.Bare(), err)
+		g.bounce(message, xmpp.NewError("wait", "internal-server-error", ""))
+	}
+}
+
+// handleCommand answers chat messages sent to the gateway itself
+func (g *xmppGateway) handleCommand(message xmpp.Message, from xmpp.JID) {
+	reply := func(text string) {
+		_ = g.component.Send(xmpp.Message{From: g.component.Domain(), To: message.From, Type: "chat", Body: text})
+	}
+
+	fields := strings.Fields(message.Body)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "link" {
+		reply("Send \"link <code>\" with a code from your Fethur account settings to link this address.")
+		return
+	}
+
+	userID, ok := g.s.verifyXMPPLinkCode(fields[1])
+	if !ok {
+		reply("That link code is invalid or has expired.")
+		return
+	}
+	if _, err := g.s.db.Exec("INSERT OR REPLACE INTO xmpp_links (user_id, jid) VALUES (?, ?)", userID, from.Bare()); err != nil {
+		log.Printf("Failed to link XMPP account %s: %v", from.Bare(), err)
+		reply("Linking failed, please try again.")
+		return
+	}
+	log.Printf("Linked XMPP account %s to user %d", from.Bare(), userID)
+	reply(fmt.Sprintf("Linked. Join rooms such as %s to chat.", xmppRoomPrefix+"<channel id>@"+g.component.Domain()))
+}
+
+// HandlePresence joins and leaves rooms
+func (g *xmppGateway) HandlePresence(presence xmpp.Presence) {
+	from, to := xmpp.ParseJID(presence.From), xmpp.ParseJID(presence.To)
+	channelID, ok := g.roomChannel(to)
+	if !ok {
+		return
+	}
+
+	switch presence.Type {
+	case "unavailable":
+		if occupant, ok := g.removeOccupant(channelID, from.String()); ok {
+			g.broadcastPresence(channelID, occupant, "unavailable", from.String())
+		}
+		return
+	case "":
+	default:
+		return
+	}
+
+	reject := func(condition, text string) {
+		_ = g.component.Send(xmpp.Presence{
+			From:  presence.To,
+			To:    presence.From,
+			ID:    presence.ID,
+			Type:  "error",
+			Error: xmpp.NewError("cancel", condition, text),
+		})
+	}
+	userID, username, linked := g.linkedUser(from)
+	if !linked {
+		reject("registration-required", "Link this address to a Fethur account first")
+		return
+	}
+	channel, err := g.s.lookupChannelForUser(userID, channelID)
+	if err != nil || channel.ChannelType == "voice" {
+		reject("item-not-found", "No such room")
+		return
+	}
+
+	occupant := xmppOccupant{userID: userID, username: username}
+	g.mutex.Lock()
+	if g.occupants[channelID] == nil {
+		g.occupants[channelID] = make(map[string]xmppOccupant)
+	}
+	_, rejoin := g.occupants[channelID][from.String()]
+	others := make([]xmppOccupant, 0, len(g.occupants[channelID]))
+	for _, other := range g.occupants[channelID] {
+		if other.userID != userID {
+			others = append(others, other)
+		}
+	}
+	g.occupants[channelID][from.String()] = occupant
+	g.mutex.Unlock()
+
+	// Existing occupants first, then the joiner's own presence
+	room := g.roomJID(channelID)
+	if !rejoin {
+		for _, other := range others {
+			_ = g.component.Send(xmpp.Presence{
+				From:    room + "/" + other.username,
+				To:      presence.From,
+				MUCUser: &xmpp.MUCUser{Item: xmpp.MUCItem{Affiliation: "member", Role: "participant"}},
+			})
+		}
+		g.broadcastPresence(channelID, occupant, "", from.String())
+	}
+
+	statuses := []xmpp.MUCStatus{{Code: 100}, {Code: 110}}
+	if !strings.EqualFold(to.Resource, username) {
+		// The nickname is always the Fethur username
+		statuses = append(statuses, xmpp.MUCStatus{Code: 210})
+	}
+	_ = g.component.Send(xmpp.Presence{
+		From: room + "/" + username,
+		To:   presence.From,
+		ID:   presence.ID,
+		MUCUser: &xmpp.MUCUser{
+			Item:   xmpp.MUCItem{Affiliation: "member", Role: "participant", JID: presence.From},
+			Status: statuses,
+		},
+	})
+}
+
+// broadcastPresence tells a room's other occupants that someone joined
+// or left
+func (g *xmppGateway) broadcastPresence(channelID int, occupant xmppOccupant, presenceType, except string) {
+	g.mutex.Lock()
+	recipients := make([]string, 0)
+	for jid, other := range g.occupants[channelID] {
+		if jid != except && other.userID != occupant.userID {
+			recipients = append(recipients, jid)
+		}
+	}
+	g.mutex.Unlock()
+
+	for _, jid := range recipients {
+		role := "participant"
+		if presenceType == "unavailable" {
+			role = "none"
+		}
+		_ = g.component.Send(xmpp.Presence{
+			From:    g.roomJID(channelID) + "/" + occupant.username,
+			To:      jid,
+			Type:    presenceType,
+			MUCUser: &xmpp.MUCUser{Item: xmpp.MUCItem{Affiliation: "member", Role: role}},
+		})
+	}
+}
+
+func (g *xmppGateway) removeOccupant(channelID int, jid string) (xmppOccupant, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	occupant, ok := g.occupants[channelID][jid]
+	delete(g.occupants[channelID], jid)
+	if len(g.occupants[channelID]) == 0 {
+		delete(g.occupants, channelID)
+	}
+	return occupant, ok
+}
+
+// removeUser drops every room occupancy held by a user's XMPP accounts
+func (g *xmppGateway) removeUser(userID int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for channelID, occupants := range g.occupants {
+		for jid, occupant := range occupants {
+			if occupant.userID == userID {
+				delete(occupants, jid)
+			}
+		}
+		if len(occupants) == 0 {
+			delete(g.occupants, channelID)
+		}
+	}
+}
+
+// HandleIQ answers service discovery so clients can browse rooms
+func (g *xmppGateway) HandleIQ(iq xmpp.IQ) {
+	if iq.Type != "get" && iq.Type != "set" {
+		return
+	}
+	from, to := xmpp.ParseJID(iq.From), xmpp.ParseJID(iq.To)
+
+	var payload interface{}
+	if iq.Type == "get" {
+		switch iq.QueryNamespace() {
+		case xmpp.NSDiscoInfo:
+			payload = g.discoInfo(to)
+		case xmpp.NSDiscoItem:
+			if to.Local == "" {
+				payload = g.discoItems(from)
+			} else {
+				payload = xmpp.DiscoItems{}
+			}
+		}
+	}
+
+	response := xmpp.IQ{From: iq.To, To: iq.From, ID: iq.ID, Type: "result"}
+	if payload == nil {
+		response.Type = "error"
+		response.Error = xmpp.NewError("cancel", "service-unavailable", "")
+	} else {
+		data, err := xml.Marshal(payload)
+		if err != nil {
+			return
+		}
+		response.Payload = data
+	}
+	_ = g.component.Send(response)
+}
+
+func (g *xmppGateway) discoInfo(to xmpp.JID) interface{} {
+	if to.Local == "" {
+		return xmpp.DiscoInfo{
+			Identities: []xmpp.DiscoIdentity{{Category: "conference", Type: "text", Name: "Fethur"}},
+			Features:   []xmpp.DiscoFeature{{Var: xmpp.NSDiscoInfo}, {Var: xmpp.NSDiscoItem}, {Var: xmpp.NSMUC}},
+		}
+	}
+	channelID, ok := g.roomChannel(to)
+	if !ok {
+		return nil
+	}
+	var name string
+	if err := g.s.db.QueryRow(
+		"SELECT name FROM channels WHERE id = ? AND channel_type != 'voice'", channelID,
+	).Scan(&name); err != nil {
+		return nil
+	}
+	return xmpp.DiscoInfo{
+		Identities: []xmpp.DiscoIdentity{{Category: "conference", Type: "text", Name: name}},
+		Features: []xmpp.DiscoFeature{
+			{Var: xmpp.NSMUC}, {Var: "muc_membersonly"}, {Var: "muc_nonanonymous"}, {Var: "muc_persistent"},
+		},
+	}
+}
+
+// discoItems lists the rooms the requesting account can join
+func (g *xmppGateway) discoItems(from xmpp.JID) interface{} {
+	items := xmpp.DiscoItems{Items: []xmpp.DiscoItem{}}
+	userID, _, linked := g.linkedUser(from)
+	if !linked {
+		return items
+	}
+	rows, err := g.s.db.Query(`
+		SELECT c.id, s.name, c.name FROM channels c
+		JOIN servers s ON s.id = c.server_id
+		JOIN server_members sm ON sm.server_id = c.server_id
+		WHERE sm.user_id = ? AND c.channel_type != 'voice' AND `+channelVisibleSQL+`
+		ORDER BY s.name, c.name`, userID)
+	if err != nil {
+		return items
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var channelID int
+		var serverName, channelName string
+		if err := rows.Scan(&channelID, &serverName, &channelName); err == nil {
+			items.Items = append(items.Items, xmpp.DiscoItem{JID: g.roomJID(channelID), Name: serverName + " #" + channelName})
+		}
+	}
+	return items
+}
+
+// bounce returns a message to its sender with an error
+func (g *xmppGateway) bounce(message xmpp.Message, stanzaError *xmpp.Error) {
+	_ = g.component.Send(xmpp.Message{
+		From:  message.To,
+		To:    message.From,
+		ID:    message.ID,
+		Type:  "error",
+		Error: stanzaError,
+	})
+}
+
+// xmppLinkCode creates a signed, expiring code that links the XMPP
+// account that sends it to the gateway to this user
+func (s *Server) xmppLinkCode(userID int, expires time.Time) string {
+	value := fmt.Sprintf("%d.%d", userID, expires.Unix())
+	return value + "." + s.auth.Sign(xmppLinkPurpose, value)
+}
+
+// verifyXMPPLinkCode returns the user a link code was issued for
+func (s *Server) verifyXMPPLinkCode(code string) (int, bool) {
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	value := parts[0] + "." + parts[1]
+	if !s.auth.VerifySignature(xmppLinkPurpose, value, parts[2]) {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, false
+	}
+	return userID, true
+}
+
+// handleGetXMPPLink reports the gateway status and the linked account
+func (s *Server) handleGetXMPPLink(c *gin.Context) {
+	data := gin.H{"enabled": s.xmpp != nil, "jid": nil}
+	if s.xmpp != nil {
+		data["domain"] = s.xmpp.component.Domain()
+		data["connected"] = s.xmpp.component.Connected()
+	}
+
+	var jid string
+	err := s.db.QueryRow("SELECT jid FROM xmpp_links WHERE user_id = ?", c.GetInt("user_id")).Scan(&jid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get XMPP link"})
+		return
+	}
+	if err == nil {
+		data["jid"] = jid
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// handleCreateXMPPLinkCode issues a code the user sends from their XMPP
+// client to prove they control the account
+func (s *Server) handleCreateXMPPLinkCode(c *gin.Context) {
+	if s.xmpp == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The XMPP gateway is disabled"})
+		return
+	}
+
+	expires := time.Now().Add(xmppLinkTTL)
+	code := s.xmppLinkCode(c.GetInt("user_id"), expires)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"code":       code,
+			"send_to":    s.xmpp.component.Domain(),
+			"message":    "link " + code,
+			"expires_at": expires.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// handleDeleteXMPPLink unlinks the user's XMPP account and leaves its rooms
+func (s *Server) handleDeleteXMPPLink(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	result, err := s.db.Exec("DELETE FROM xmpp_links WHERE user_id = ?", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove XMPP link"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No XMPP account is linked"})
+		return
+	}
+	if s.xmpp != nil {
+		s.xmpp.removeUser(userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "XMPP account unlinked",
+	})
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+)
+
+func TestXMPPLinkCode(t *testing.T) {
+	s := &Server{auth: auth.NewService()}
+
+	code := s.xmppLinkCode(42, time.Now().Add(time.Minute))
+	if userID, ok := s.verifyXMPPLinkCode(code); !ok || userID != 42 {
+		t.Fatalf("Expected code to verify for user 42, got %d %v", userID, ok)
+	}
+
+	tampered := "43" + strings.TrimPrefix(code, "42")
+	if _, ok := s.verifyXMPPLinkCode(tampered); ok {
+		t.Error("Expected a code for another user to be rejected")
+	}
+	if _, ok := s.verifyXMPPLinkCode(s.xmppLinkCode(42, time.Now().Add(-time.Minute))); ok {
+		t.Error("Expected an expired code to be rejected")
+	}
+	if _, ok := s.verifyXMPPLinkCode("not-a-code"); ok {
+		t.Error("Expected a malformed code to be rejected")
+	}
+}
@@ -0,0 +1,494 @@
+package server
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/voice"
+	"fethur/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLevel bounds level calculations
+const maxLevel = 1000
+
+// maxXPRewards bounds the role rewards one server can configure
+const maxXPRewards = 50
+
+// xpSettings is a server's XP configuration. Going from level n to n+1
+// takes CurveA*n² + CurveB*n + CurveC XP.
+type xpSettings struct {
+	Enabled                bool `json:"enabled"`
+	MessageXPMin           int  `json:"message_xp_min"`
+	MessageXPMax           int  `json:"message_xp_max"`
+	MessageCooldownSeconds int  `json:"message_cooldown_seconds"`
+	VoiceXPPerMinute       int  `json:"voice_xp_per_minute"`
+	CurveA                 int  `json:"curve_a"`
+	CurveB                 int  `json:"curve_b"`
+	CurveC                 int  `json:"curve_c"`
+}
+
+// xpReward grants a role at a level
+type xpReward struct {
+	Level  int   `json:"level"`
+	RoleID int64 `json:"role_id"`
+}
+
+func defaultXPSettings() xpSettings {
+	return xpSettings{
+		Enabled:                true,
+		MessageXPMin:           15,
+		MessageXPMax:           25,
+		MessageCooldownSeconds: 60,
+		VoiceXPPerMinute:       10,
+		CurveA:                 5,
+		CurveB:                 50,
+		CurveC:                 100,
+	}
+}
+
+// loadXPSettings returns a server's XP configuration or the defaults
+func (s *Server) loadXPSettings(serverID int) xpSettings {
+	settings := defaultXPSettings()
+	_ = s.db.QueryRow(`
+		SELECT enabled, message_xp_min, message_xp_max, message_cooldown_seconds, voice_xp_per_minute, curve_a, curve_b, curve_c
+		FROM xp_settings WHERE server_id = ?`, serverID,
+	).Scan(&settings.Enabled, &settings.MessageXPMin, &settings.MessageXPMax, &settings.MessageCooldownSeconds,
+		&settings.VoiceXPPerMinute, &settings.CurveA, &settings.CurveB, &settings.CurveC)
+	return settings
+}
+
+// level returns the level reached with xp, the XP earned into that level
+// and the XP the next level takes
+func (settings xpSettings) level(xp int64) (level int, into, next int64) {
+	for level < maxLevel {
+		need := int64(settings.CurveA)*int64(level)*int64(level) + int64(settings.CurveB)*int64(level) + int64(settings.CurveC)
+		if xp < need {
+			return level, xp, need
+		}
+		xp -= need
+		level++
+	}
+	return level, xp, 0
+}
+
+// memberXP returns a member's XP, zero for members without any
+func (s *Server) memberXP(serverID, userID int) int64 {
+	var xp int64
+	_ = s.db.QueryRow("SELECT xp FROM member_xp WHERE server_id = ? AND user_id = ?", serverID, userID).Scan(&xp)
+	return xp
+}
+
+// awardMessageXP gives a member XP for a message, at most once per cooldown
+func (s *Server) awardMessageXP(serverID, userID int) {
+	settings := s.loadXPSettings(serverID)
+	if !settings.Enabled {
+		return
+	}
+	amount := settings.MessageXPMin
+	if settings.MessageXPMax > settings.MessageXPMin {
+		amount += rand.Intn(settings.MessageXPMax - settings.MessageXPMin + 1)
+	}
+	now := time.Now().UTC()
+	cutoff := now.Add(-time.Duration(settings.MessageCooldownSeconds) * time.Second).Format(sqliteTimeLayout)
+
+	before := s.memberXP(serverID, userID)
+	// Messages inside the cooldown are counted but earn nothing
+	if _, err := s.db.Exec(`
+		INSERT INTO member_xp (server_id, user_id, xp, messages, message_xp_at, updated_at)
+		VALUES (?, ?, ?, 1, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (server_id, user_id) DO UPDATE SET
+			messages = messages + 1,
+			xp = CASE WHEN message_xp_at IS NULL OR message_xp_at <= ? THEN xp + excluded.xp ELSE xp END,
+			message_xp_at = CASE WHEN message_xp_at IS NULL OR message_xp_at <= ? THEN excluded.message_xp_at ELSE message_xp_at END,
+			updated_at = CURRENT_TIMESTAMP`,
+		serverID, userID, amount, now.Format(sqliteTimeLayout), cutoff, cutoff,
+	); err != nil {
+		log.Printf("Failed to award message XP to user %d: %v", userID, err)
+		return
+	}
+	s.checkLevelUp(serverID, userID, before, settings)
+}
+
+// startXPScheduler awards voice XP every minute
+func (s *Server) startXPScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.awardVoiceXP(s.voiceHub.Participants())
+			}
+		}
+	}()
+}
+
+// awardVoiceXP gives a minute of voice XP to everyone listening in a
+// channel with someone else; deafened users and lone users earn nothing
+func (s *Server) awardVoiceXP(participants []voice.Participant) {
+	listening := make(map[int64]int)
+	for _, p := range participants {
+		if !p.Deafened {
+			listening[p.ChannelID]++
+		}
+	}
+
+	settings := make(map[int]xpSettings)
+	for _, p := range participants {
+		if p.Deafened || listening[p.ChannelID] < 2 {
+			continue
+		}
+		serverID, userID := int(p.ServerID), int(p.UserID)
+		serverSettings, ok := settings[serverID]
+		if !ok {
+			serverSettings = s.loadXPSettings(serverID)
+			settings[serverID] = serverSettings
+		}
+		if !serverSettings.Enabled {
+			continue
+		}
+
+		before := s.memberXP(serverID, userID)
+		if _, err := s.db.Exec(`
+			INSERT INTO member_xp (server_id, user_id, xp, voice_minutes, updated_at) VALUES (?, ?, ?, 1, CURRENT_TIMESTAMP)
+			ON CONFLICT (server_id, user_id) DO UPDATE SET
+				xp = xp + excluded.xp, voice_minutes = voice_minutes + 1, updated_at = CURRENT_TIMESTAMP`,
+			serverID, userID, serverSettings.VoiceXPPerMinute,
+		); err != nil {
+			log.Printf("Failed to award voice XP to user %d: %v", userID, err)
+			continue
+		}
+		s.checkLevelUp(serverID, userID, before, serverSettings)
+	}
+}
+
+// checkLevelUp grants the rewards of a newly reached level and tells the
+// member
+func (s *Server) checkLevelUp(serverID, userID int, before int64, settings xpSettings) {
+	oldLevel, _, _ := settings.level(before)
+	newLevel, _, _ := settings.level(s.memberXP(serverID, userID))
+	if newLevel <= oldLevel {
+		return
+	}
+
+	s.grantLevelRewards(serverID, userID, newLevel)
+
+	s.clientsMux.RLock()
+	client, ok := s.clients[userID]
+	s.clientsMux.RUnlock()
+	if ok {
+		client.Send(&websocket.Message{
+			Type:      string(events.TypeNotification),
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"kind":      "level_up",
+				"server_id": serverID,
+				"level":     newLevel,
+			},
+		})
+	}
+}
+
+// grantLevelRewards gives a member every reward up to their level
+func (s *Server) grantLevelRewards(serverID, userID, level int) {
+	rows, err := s.db.Query(
+		"SELECT role_id FROM xp_role_rewards WHERE server_id = ? AND level <= ?", serverID, level,
+	)
+	if err != nil {
+		log.Printf("Failed to load XP rewards for server %d: %v", serverID, err)
+		return
+	}
+	var roles []int64
+	for rows.Next() {
+		var roleID int64
+		if err := rows.Scan(&roleID); err == nil {
+			roles = append(roles, roleID)
+		}
+	}
+	_ = rows.Close()
+
+	for _, roleID := range roles {
+		if _, err := s.grantMemberRole(serverID, userID, roleID); err != nil {
+			log.Printf("Failed to grant XP reward %d to user %d: %v", roleID, userID, err)
+		}
+	}
+}
+
+// xpEntry is one member's standing
+func (settings xpSettings) xpEntry(rank, userID int, username string, xp int64, messages, voiceMinutes int) gin.H {
+	level, into, next := settings.level(xp)
+	return gin.H{
+		"rank":          rank,
+		"user_id":       userID,
+		"username":      username,
+		"xp":            xp,
+		"level":         level,
+		"level_xp":      into,
+		"next_level_xp": next,
+		"messages":      messages,
+		"voice_minutes": voiceMinutes,
+	}
+}
+
+// handleGetLeaderboard ranks a server's members by XP, with the caller's
+// own standing
+func (s *Server) handleGetLeaderboard(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+	settings := s.loadXPSettings(serverID)
+
+	rows, err := s.db.Query(`
+		SELECT mx.user_id, u.username, mx.xp, mx.messages, mx.voice_minutes
+		FROM member_xp mx
+		JOIN users u ON u.id = mx.user_id
+		JOIN server_members sm ON sm.server_id = mx.server_id AND sm.user_id = mx.user_id
+		WHERE mx.server_id = ?
+		ORDER BY mx.xp DESC, mx.user_id
+		LIMIT ? OFFSET ?`, serverID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get leaderboard"})
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	entries := make([]gin.H, 0)
+	for rows.Next() {
+		var memberID, messages, voiceMinutes int
+		var username string
+		var xp int64
+		if err := rows.Scan(&memberID, &username, &xp, &messages, &voiceMinutes); err != nil {
+			continue
+		}
+		entries = append(entries, settings.xpEntry(offset+len(entries)+1, memberID, username, xp, messages, voiceMinutes))
+	}
+
+	var me gin.H
+	var username string
+	var xp int64
+	var messages, voiceMinutes, rank int
+	err = s.db.QueryRow(`
+		SELECT u.username, mx.xp, mx.messages, mx.voice_minutes,
+			(SELECT COUNT(*) + 1 FROM member_xp o
+				JOIN server_members sm ON sm.server_id = o.server_id AND sm.user_id = o.user_id
+				WHERE o.server_id = mx.server_id AND (o.xp > mx.xp OR (o.xp = mx.xp AND o.user_id < mx.user_id)))
+		FROM member_xp mx
+		JOIN users u ON u.id = mx.user_id
+		WHERE mx.server_id = ? AND mx.user_id = ?`, serverID, userID,
+	).Scan(&username, &xp, &messages, &voiceMinutes, &rank)
+	if err == nil {
+		me = settings.xpEntry(rank, userID, username, xp, messages, voiceMinutes)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"entries": entries,
+			"me":      me,
+		},
+	})
+}
+
+// handleGetXPSettings returns the server's XP configuration
+func (s *Server) handleGetXPSettings(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    s.loadXPSettings(serverID),
+	})
+}
+
+// handleUpdateXPSettings replaces the server's XP configuration
+func (s *Server) handleUpdateXPSettings(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	req := defaultXPSettings()
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case req.MessageXPMin < 0 || req.MessageXPMax < req.MessageXPMin || req.MessageXPMax > 1000:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message XP must satisfy 0 <= min <= max <= 1000"})
+		return
+	case req.MessageCooldownSeconds < 0 || req.MessageCooldownSeconds > 3600:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_cooldown_seconds must be between 0 and 3600"})
+		return
+	case req.VoiceXPPerMinute < 0 || req.VoiceXPPerMinute > 1000:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "voice_xp_per_minute must be between 0 and 1000"})
+		return
+	case req.CurveA < 0 || req.CurveB < 0 || req.CurveC < 1 || req.CurveA > 10000 || req.CurveB > 100000 || req.CurveC > 1000000:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "curve_a and curve_b must not be negative and curve_c must be at least 1"})
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO xp_settings (server_id, enabled, message_xp_min, message_xp_max, message_cooldown_seconds,
+			voice_xp_per_minute, curve_a, curve_b, curve_c, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (server_id) DO UPDATE SET enabled = excluded.enabled, message_xp_min = excluded.message_xp_min,
+			message_xp_max = excluded.message_xp_max, message_cooldown_seconds = excluded.message_cooldown_seconds,
+			voice_xp_per_minute = excluded.voice_xp_per_minute, curve_a = excluded.curve_a, curve_b = excluded.curve_b,
+			curve_c = excluded.curve_c, updated_at = CURRENT_TIMESTAMP`,
+		serverID, req.Enabled, req.MessageXPMin, req.MessageXPMax, req.MessageCooldownSeconds,
+		req.VoiceXPPerMinute, req.CurveA, req.CurveB, req.CurveC,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update XP settings"})
+		return
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    req,
+	})
+}
+
+// loadXPRewards lists a server's role rewards by level
+func (s *Server) loadXPRewards(serverID int) ([]xpReward, error) {
+	rows, err := s.db.Query("SELECT level, role_id FROM xp_role_rewards WHERE server_id = ? ORDER BY level, role_id", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	rewards := make([]xpReward, 0)
+	for rows.Next() {
+		var reward xpReward
+		if err := rows.Scan(&reward.Level, &reward.RoleID); err == nil {
+			rewards = append(rewards, reward)
+		}
+	}
+	return rewards, rows.Err()
+}
+
+// handleGetXPRewards lists the roles granted at each level
+func (s *Server) handleGetXPRewards(c *gin.Context) {
+	serverID, _, ok := s.memberServerID(c)
+	if !ok {
+		return
+	}
+	rewards, err := s.loadXPRewards(serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get XP rewards"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rewards,
+	})
+}
+
+// handleUpdateXPRewards replaces the role rewards. Members who already
+// passed a new reward's level get it on their next level up.
+func (s *Server) handleUpdateXPRewards(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Rewards []xpReward `json:"rewards"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Rewards) > maxXPRewards {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many rewards"})
+		return
+	}
+	for _, reward := range req.Rewards {
+		if reward.Level < 1 || reward.Level > maxLevel {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "level must be between 1 and 1000"})
+			return
+		}
+		if _, found := s.findServerRole(serverID, strconv.FormatInt(reward.RoleID, 10)); !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role_id must be a role in this server"})
+			return
+		}
+	}
+
+	if _, err := s.db.Exec("DELETE FROM xp_role_rewards WHERE server_id = ?", serverID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update XP rewards"})
+		return
+	}
+	for _, reward := range req.Rewards {
+		if _, err := s.db.Exec(
+			"INSERT OR IGNORE INTO xp_role_rewards (server_id, level, role_id) VALUES (?, ?, ?)", serverID, reward.Level, reward.RoleID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update XP rewards"})
+			return
+		}
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	rewards, _ := s.loadXPRewards(serverID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rewards,
+	})
+}
+
+// handleResetMemberXP clears a member's XP and takes back their reward roles
+func (s *Server) handleResetMemberXP(c *gin.Context) {
+	serverID, ok := s.roleServerID(c, true)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if _, err := s.db.Exec("DELETE FROM member_xp WHERE server_id = ? AND user_id = ?", serverID, memberID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset XP"})
+		return
+	}
+	rewards, err := s.loadXPRewards(serverID)
+	if err != nil {
+		log.Printf("Failed to load XP rewards for server %d: %v", serverID, err)
+	}
+	for _, reward := range rewards {
+		if _, err := s.revokeMemberRole(serverID, memberID, reward.RoleID); err != nil {
+			log.Printf("Failed to revoke XP reward %d from user %d: %v", reward.RoleID, memberID, err)
+		}
+	}
+	s.markWrite(c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "XP reset successfully",
+	})
+}
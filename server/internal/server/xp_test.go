@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/voice"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestXPSettingsLevel(t *testing.T) {
+	settings := defaultXPSettings()
+	cases := []struct {
+		xp    int64
+		level int
+		into  int64
+		next  int64
+	}{
+		{0, 0, 0, 100},
+		{99, 0, 99, 100},
+		{100, 1, 0, 155},
+		{254, 1, 154, 155},
+		{255, 2, 0, 220},
+	}
+	for _, tc := range cases {
+		level, into, next := settings.level(tc.xp)
+		if level != tc.level || into != tc.into || next != tc.next {
+			t.Errorf("level(%d) = %d, %d, %d; want %d, %d, %d", tc.xp, level, into, next, tc.level, tc.into, tc.next)
+		}
+	}
+}
+
+func TestXP(t *testing.T) {
+	s := newTestServer(t)
+	db := s.db
+
+	suffix := time.Now().UnixNano()
+	users := make(map[string]int64)
+	for _, name := range []string{"owner", "chatty", "quiet", "deaf"} {
+		result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", fmt.Sprintf("xp%s_%d", name, suffix))
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name], _ = result.LastInsertId()
+	}
+	result, err := db.Exec("INSERT INTO servers (name, owner_id) VALUES (?, ?)", fmt.Sprintf("Levels %d", suffix), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	serverID, _ := result.LastInsertId()
+	for name, id := range users {
+		role := "member"
+		if name == "owner" {
+			role = "owner"
+		}
+		if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)", id, serverID, role); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+	result, err = db.Exec("INSERT INTO server_roles (server_id, name) VALUES (?, 'Regular')", serverID)
+	if err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	roleID, _ := result.LastInsertId()
+
+	gin.SetMode(gin.TestMode)
+	request := func(user, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", int(users[user]))
+		})
+		router.GET("/servers/:id/leaderboard", s.handleGetLeaderboard)
+		router.PUT("/servers/:id/xp/settings", s.handleUpdateXPSettings)
+		router.PUT("/servers/:id/xp/rewards", s.handleUpdateXPRewards)
+		router.DELETE("/servers/:id/xp/members/:userId", s.handleResetMemberXP)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	base := fmt.Sprintf("/servers/%d", serverID)
+
+	if w := request("chatty", "PUT", base+"/xp/settings", `{"enabled":true}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members not to change settings, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/xp/settings", `{"curve_c":0}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a zero curve to be rejected, got %d", w.Code)
+	}
+	settings := `{"enabled":true,"message_xp_min":100,"message_xp_max":100,"message_cooldown_seconds":60,"voice_xp_per_minute":10,"curve_a":0,"curve_b":0,"curve_c":100}`
+	if w := request("owner", "PUT", base+"/xp/settings", settings); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update settings: %d %s", w.Code, w.Body.String())
+	}
+	if w := request("owner", "PUT", base+"/xp/rewards", `{"rewards":[{"level":1,"role_id":999999}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a foreign role to be rejected, got %d", w.Code)
+	}
+	if w := request("owner", "PUT", base+"/xp/rewards", fmt.Sprintf(`{"rewards":[{"level":1,"role_id":%d}]}`, roleID)); w.Code != http.StatusOK {
+		t.Fatalf("Failed to update rewards: %d %s", w.Code, w.Body.String())
+	}
+
+	// The second message falls inside the cooldown
+	s.awardMessageXP(int(serverID), int(users["chatty"]))
+	s.awardMessageXP(int(serverID), int(users["chatty"]))
+	var xp int64
+	var messages int
+	if err := db.QueryRow("SELECT xp, messages FROM member_xp WHERE server_id = ? AND user_id = ?", serverID, users["chatty"]).Scan(&xp, &messages); err != nil {
+		t.Fatalf("Failed to load XP: %v", err)
+	}
+	if xp != 100 || messages != 2 {
+		t.Fatalf("Expected 100 XP over 2 messages, got %d over %d", xp, messages)
+	}
+	if !s.hasServerRole(int(users["chatty"]), roleID) {
+		t.Fatal("Expected the level 1 reward to be granted")
+	}
+
+	// A lone listener earns nothing; the deafened user neither
+	s.awardVoiceXP([]voice.Participant{
+		{UserID: users["quiet"], ChannelID: 1, ServerID: serverID},
+		{UserID: users["deaf"], ChannelID: 1, ServerID: serverID, Deafened: true},
+	})
+	if got := s.memberXP(int(serverID), int(users["quiet"])); got != 0 {
+		t.Fatalf("Expected no XP when alone, got %d", got)
+	}
+	s.awardVoiceXP([]voice.Participant{
+		{UserID: users["quiet"], ChannelID: 1, ServerID: serverID},
+		{UserID: users["chatty"], ChannelID: 1, ServerID: serverID, Muted: true},
+		{UserID: users["deaf"], ChannelID: 1, ServerID: serverID, Deafened: true},
+	})
+	if got := s.memberXP(int(serverID), int(users["quiet"])); got != 10 {
+		t.Fatalf("Expected 10 voice XP, got %d", got)
+	}
+	if got := s.memberXP(int(serverID), int(users["deaf"])); got != 0 {
+		t.Fatalf("Expected no XP while deafened, got %d", got)
+	}
+
+	w := request("quiet", "GET", base+"/leaderboard", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to get leaderboard: %d %s", w.Code, w.Body.String())
+	}
+	var board struct {
+		Data struct {
+			Entries []struct {
+				Rank   int   `json:"rank"`
+				UserID int64 `json:"user_id"`
+				Level  int   `json:"level"`
+			} `json:"entries"`
+			Me struct {
+				Rank int   `json:"rank"`
+				XP   int64 `json:"xp"`
+			} `json:"me"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &board); err != nil {
+		t.Fatalf("Failed to decode leaderboard: %v", err)
+	}
+	if len(board.Data.Entries) != 2 || board.Data.Entries[0].UserID != users["chatty"] || board.Data.Entries[0].Level != 1 {
+		t.Fatalf("Unexpected leaderboard: %s", w.Body.String())
+	}
+	if board.Data.Me.Rank != 2 || board.Data.Me.XP != 10 {
+		t.Fatalf("Unexpected own standing: %+v", board.Data.Me)
+	}
+
+	if w := request("owner", "DELETE", fmt.Sprintf("%s/xp/members/%d", base, users["chatty"]), ""); w.Code != http.StatusOK {
+		t.Fatalf("Failed to reset XP: %d %s", w.Code, w.Body.String())
+	}
+	if s.memberXP(int(serverID), int(users["chatty"])) != 0 || s.hasServerRole(int(users["chatty"]), roleID) {
+		t.Fatal("Expected reset to clear XP and reward roles")
+	}
+}
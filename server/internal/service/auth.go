@@ -0,0 +1,177 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"fethur/internal/auth"
+	"fethur/internal/database"
+)
+
+// User is an account as the sign-in rules see it
+type User struct {
+	ID           int
+	Username     string
+	Email        string
+	Role         string
+	OrgID        int
+	TokenVersion int
+	TwoFactor    bool
+	Pending      bool // registered but not approved by an admin yet
+}
+
+// AuthService checks credentials and creates accounts. Issuing tokens and
+// recording devices is left to the caller.
+type AuthService struct {
+	db         *database.Database
+	auth       *auth.Service
+	orgs       *OrgService
+	moderation *ModerationService
+}
+
+// Authenticate checks a username and password. orgID is the organization
+// the request is addressed to; accounts of other organizations do not exist
+// there. It returns ErrInvalidCredentials, or ErrBanned for a banned
+// account and ErrPendingApproval for an unapproved one with the right
+// password.
+func (a *AuthService) Authenticate(username, password string, orgID int) (*User, error) {
+	var user User
+	var passwordHash string
+	err := a.db.QueryRow(
+		"SELECT id, username, email, password_hash, role, token_version, org_id, totp_enabled, pending_approval FROM users WHERE username = ?",
+		username,
+	).Scan(&user.ID, &user.Username, &user.Email, &passwordHash, &user.Role, &user.TokenVersion, &user.OrgID, &user.TwoFactor, &user.Pending)
+	if err == nil && orgID != 0 && orgID != user.OrgID {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.auth.CheckPassword(password, passwordHash) {
+		return nil, ErrInvalidCredentials
+	}
+	if a.moderation.IsBanned(user.ID) {
+		return nil, ErrBanned
+	}
+	if user.Pending {
+		return nil, ErrPendingApproval
+	}
+	a.upgradeHash(user.ID, password, passwordHash)
+	return &user, nil
+}
+
+// UseInvite counts a use of an organization's invite code, or returns
+// ErrInviteInvalid when the code is unknown, used up or expired. Callers
+// outside this package use it to create accounts in invite_only mode.
+func UseInvite(tx *sql.Tx, orgID int, code string) error {
+	if code == "" {
+		return ErrInviteInvalid
+	}
+	result, err := tx.Exec(`
+		UPDATE registration_invites SET uses = uses + 1
+		WHERE code = ? AND org_id = ? AND (max_uses = 0 OR uses < max_uses)
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		strings.ToLower(strings.TrimSpace(code)), orgID,
+	)
+	if err != nil {
+		return err
+	}
+	if used, _ := result.RowsAffected(); used == 0 {
+		return ErrInviteInvalid
+	}
+	return nil
+}
+
+// upgradeHash rehashes a password that was just checked when its stored
+// hash uses another algorithm or a weaker cost than new hashes. Failing
+// only delays the upgrade to the next sign-in.
+func (a *AuthService) upgradeHash(userID int, password, passwordHash string) {
+	if !a.auth.NeedsRehash(passwordHash) {
+		return
+	}
+	upgraded, err := a.auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", userID, err)
+		return
+	}
+	// A password changed meanwhile is left alone
+	if _, err := a.db.Exec(
+		"UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?", upgraded, userID, passwordHash,
+	); err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", userID, err)
+	}
+}
+
+// AuthModes are the registration modes an instance or organization may use
+var AuthModes = map[string]bool{
+	"public":            true,
+	"open_registration": true,
+	"invite_only":       true,
+	"admin_only":        true,
+}
+
+// Register creates a user account in an organization, following its
+// registration mode: public, open_registration with a shared password,
+// invite_only with an invite code, or admin_only. An invite is used up
+// only if the account is created. With registration_approval on, the
+// account cannot sign in until an admin approves it.
+func (a *AuthService) Register(orgID int, username, password, registrationPassword, inviteCode string) (*User, error) {
+	authMode, err := a.orgs.Setting(orgID, "auth_mode")
+	if err != nil {
+		authMode = "public"
+	}
+	switch authMode {
+	case "admin_only":
+		return nil, ErrRegistrationClosed
+	case "open_registration":
+		expected, err := a.orgs.Setting(orgID, "registration_password")
+		if err != nil || expected != registrationPassword {
+			return nil, ErrRegistrationPassword
+		}
+	}
+
+	if err := a.auth.ValidatePasswordForUser(password, username); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+	passwordHash, err := a.auth.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := a.orgs.CheckLimit(orgID, "users"); err != nil {
+		return nil, err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if authMode == "invite_only" {
+		if err := UseInvite(tx, orgID, inviteCode); err != nil {
+			return nil, err
+		}
+	}
+	approval, _ := a.orgs.Setting(orgID, "registration_approval")
+	pending := approval == "true"
+	result, err := tx.Exec(
+		"INSERT INTO users (username, email, password_hash, role, org_id, pending_approval) VALUES (?, ?, ?, ?, ?, ?)",
+		username, "", passwordHash, "user", orgID, pending,
+	)
+	if err != nil {
+		return nil, ErrUsernameTaken
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	userID, _ := result.LastInsertId()
+	return &User{ID: int(userID), Username: username, Role: "user", OrgID: orgID, Pending: pending}, nil
+}
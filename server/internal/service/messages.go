@@ -0,0 +1,80 @@
+package service
+
+import (
+	"database/sql"
+
+	"fethur/internal/database"
+	"fethur/internal/snowflake"
+)
+
+// Message is a new message to post. ReplyToID and ThreadID are zero for a
+// message that is not a reply.
+type Message struct {
+	ChannelID   int
+	UserID      int
+	Content     string
+	ReplyToID   int64
+	ThreadID    int64
+	Quarantined bool
+}
+
+// MessageService applies the rules of posting messages
+type MessageService struct {
+	db         *database.Database
+	moderation *ModerationService
+	policies   *PolicyService
+}
+
+// CheckPost returns ErrMuted when a user may not post in a server, and
+// ErrPolicyNotAccepted until they acknowledge the current terms and
+// rules. A quarantined user may post, but only moderators see the
+// messages.
+func (m *MessageService) CheckPost(serverID, userID int) (quarantined bool, err error) {
+	if m.moderation.ServerSanctioned(serverID, userID, "mute") {
+		return false, ErrMuted
+	}
+	outstanding, err := m.policies.Outstanding(userID)
+	if err != nil {
+		return false, err
+	}
+	if len(outstanding) > 0 {
+		return false, ErrPolicyNotAccepted
+	}
+	return m.moderation.ServerSanctioned(serverID, userID, "quarantine"), nil
+}
+
+// ReplyThread returns the root of the thread a reply to messageID belongs
+// to. The message must be in the channel, or ErrReplyTarget is returned.
+func (m *MessageService) ReplyThread(channelID int, messageID int64) (int64, error) {
+	var messageChannel int
+	var threadID sql.NullInt64
+	err := m.db.QueryRow("SELECT channel_id, thread_id FROM messages WHERE id = ?", messageID).Scan(&messageChannel, &threadID)
+	if err == sql.ErrNoRows || (err == nil && messageChannel != channelID) {
+		return 0, ErrReplyTarget
+	}
+	if err != nil {
+		return 0, err
+	}
+	if threadID.Valid {
+		return threadID.Int64, nil
+	}
+	return messageID, nil
+}
+
+// Create stores a message under a new snowflake ID and returns the ID.
+// Permission checks are up to the caller; see CheckPost.
+func (m *MessageService) Create(msg Message) (int64, error) {
+	var replyToID, threadID interface{}
+	if msg.ReplyToID != 0 {
+		replyToID, threadID = msg.ReplyToID, msg.ThreadID
+	}
+	id := int64(snowflake.Next())
+	_, err := m.db.Exec(
+		"INSERT INTO messages (id, channel_id, user_id, content, reply_to_id, thread_id, quarantined, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		id, msg.ChannelID, msg.UserID, msg.Content, replyToID, threadID, msg.Quarantined,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
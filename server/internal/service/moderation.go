@@ -0,0 +1,89 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"fethur/internal/database"
+)
+
+// ModerationService applies instance-wide bans and mutes and the actions
+// of server moderation cases
+type ModerationService struct {
+	db *database.Database
+}
+
+// ModerationExpiry returns the SQLite timestamp a timed moderation action
+// expires at, or nil for a permanent one
+func ModerationExpiry(duration time.Duration) interface{} {
+	if duration <= 0 {
+		return nil
+	}
+	return time.Now().UTC().Add(duration).Format("2006-01-02 15:04:05")
+}
+
+// IsBanned reports whether a user currently has an active ban
+func (m *ModerationService) IsBanned(userID int) bool {
+	var banned bool
+	err := m.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_bans
+			WHERE user_id = ? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		)
+	`, userID).Scan(&banned)
+	if err != nil {
+		log.Printf("Failed to check ban status for user %d: %v", userID, err)
+		return false
+	}
+	return banned
+}
+
+// Ban bans a user from the instance for duration, or for good when it is
+// zero, replacing any earlier ban
+func (m *ModerationService) Ban(userID, moderatorID int, reason string, duration time.Duration) error {
+	_, err := m.db.Exec(`
+		INSERT OR REPLACE INTO user_bans (user_id, reason, banned_by, expires_at, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, userID, reason, moderatorID, ModerationExpiry(duration))
+	return err
+}
+
+// Unban lifts a user's ban
+func (m *ModerationService) Unban(userID int) error {
+	_, err := m.db.Exec("DELETE FROM user_bans WHERE user_id = ?", userID)
+	return err
+}
+
+// Mute mutes a user for duration, or for good when it is zero, replacing
+// any earlier mute
+func (m *ModerationService) Mute(userID, moderatorID int, reason string, duration time.Duration) error {
+	_, err := m.db.Exec(`
+		INSERT OR REPLACE INTO user_mutes (user_id, reason, muted_by, expires_at, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, userID, reason, moderatorID, ModerationExpiry(duration))
+	return err
+}
+
+// Unmute lifts a user's mute
+func (m *ModerationService) Unmute(userID int) error {
+	_, err := m.db.Exec("DELETE FROM user_mutes WHERE user_id = ?", userID)
+	return err
+}
+
+// ServerSanctioned reports whether a user is under an active action of a
+// server's moderation cases, such as "mute" or "ban"
+func (m *ModerationService) ServerSanctioned(serverID, userID int, action string) bool {
+	var active bool
+	err := m.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM moderation_case_actions a JOIN moderation_cases mc ON mc.id = a.case_id
+			WHERE mc.server_id = ? AND mc.user_id = ? AND a.action = ? AND a.lifted_at IS NULL
+				AND (a.expires_at IS NULL OR a.expires_at > CURRENT_TIMESTAMP)
+		)`, serverID, userID, action,
+	).Scan(&active)
+	if err != nil {
+		log.Printf("Failed to check %s of user %d in server %d: %v", action, userID, serverID, err)
+		return false
+	}
+	return active
+}
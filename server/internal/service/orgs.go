@@ -0,0 +1,58 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+
+	"fethur/internal/database"
+)
+
+// OrgSettingKeys are the instance settings an organization may override
+var OrgSettingKeys = map[string]bool{
+	"auth_mode":             true,
+	"registration_password": true,
+	"guest_mode_enabled":    true,
+	"registration_approval": true,
+}
+
+// OrgService applies the settings and limits of organizations. Users and
+// servers outside every organization belong to the instance, org ID 0.
+type OrgService struct {
+	db *database.Database
+}
+
+// Setting returns an organization's value of a setting it may override,
+// falling back to the instance setting
+func (o *OrgService) Setting(orgID int, key string) (string, error) {
+	if orgID != 0 && OrgSettingKeys[key] {
+		var value string
+		err := o.db.QueryRow("SELECT value FROM organization_settings WHERE org_id = ? AND key = ?", orgID, key).Scan(&value)
+		if err == nil {
+			return value, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return o.db.GetSetting(key)
+}
+
+// CheckLimit returns ErrOrgLimit when an organization already has as many
+// users or servers as it is allowed. resource is "users" or "servers".
+func (o *OrgService) CheckLimit(orgID int, resource string) error {
+	if orgID == 0 {
+		return nil
+	}
+	var limit, count int
+	err := o.db.QueryRow(fmt.Sprintf(
+		"SELECT max_%s, (SELECT COUNT(*) FROM %s WHERE org_id = organizations.id) FROM organizations WHERE id = ?",
+		resource, resource,
+	), orgID).Scan(&limit, &count)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && count >= limit {
+		return ErrOrgLimit
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"fethur/internal/database"
+)
+
+// PolicyKinds are the documents users acknowledge: the terms of service
+// and the community rules
+var PolicyKinds = map[string]bool{"terms": true, "rules": true}
+
+// Limits on policy documents
+const (
+	maxPolicyTitleLength   = 200
+	maxPolicyContentLength = 100000
+)
+
+// PolicyDocument is a published version of a policy. Content is markdown.
+type PolicyDocument struct {
+	Kind      string    `json:"kind"`
+	Version   int       `json:"version"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedBy int       `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PolicyService keeps the instance's terms of service and rules, and
+// which versions each user has acknowledged. A user must acknowledge the
+// current version of every policy before posting; publishing a new
+// version asks everyone again.
+type PolicyService struct {
+	db *database.Database
+}
+
+// Current returns the version of a policy in force, or ErrNotFound when
+// none has been published or it was retired
+func (p *PolicyService) Current(kind string) (*PolicyDocument, error) {
+	var doc PolicyDocument
+	var createdBy sql.NullInt64
+	var retired bool
+	err := p.db.QueryRow(`
+		SELECT kind, version, title, content, created_by, created_at, retired_at IS NOT NULL
+		FROM policy_documents WHERE kind = ? ORDER BY version DESC LIMIT 1`, kind,
+	).Scan(&doc.Kind, &doc.Version, &doc.Title, &doc.Content, &createdBy, &doc.CreatedAt, &retired)
+	if err == sql.ErrNoRows || retired {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.CreatedBy = int(createdBy.Int64)
+	return &doc, nil
+}
+
+// CurrentAll returns the policies in force, terms first
+func (p *PolicyService) CurrentAll() ([]PolicyDocument, error) {
+	docs := make([]PolicyDocument, 0, len(PolicyKinds))
+	for _, kind := range []string{"terms", "rules"} {
+		doc, err := p.Current(kind)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, *doc)
+	}
+	return docs, nil
+}
+
+// History returns every published version of a policy, newest first
+func (p *PolicyService) History(kind string) ([]PolicyDocument, error) {
+	rows, err := p.db.Query(`
+		SELECT kind, version, title, content, COALESCE(created_by, 0), created_at
+		FROM policy_documents WHERE kind = ? ORDER BY version DESC`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	docs := make([]PolicyDocument, 0)
+	for rows.Next() {
+		var doc PolicyDocument
+		if err := rows.Scan(&doc.Kind, &doc.Version, &doc.Title, &doc.Content, &doc.CreatedBy, &doc.CreatedAt); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// Publish makes a new version of a policy current. Users have to
+// acknowledge it before they post again.
+func (p *PolicyService) Publish(kind, title, content string, by int) (*PolicyDocument, error) {
+	title, content = strings.TrimSpace(title), strings.TrimSpace(content)
+	switch {
+	case !PolicyKinds[kind]:
+		return nil, ErrNotFound
+	case title == "" || len(title) > maxPolicyTitleLength:
+		return nil, &ValidationError{errors.New("title must be 1 to 200 characters")}
+	case content == "" || len(content) > maxPolicyContentLength:
+		return nil, &ValidationError{errors.New("content must be 1 to 100000 characters")}
+	}
+
+	var createdBy interface{}
+	if by != 0 {
+		createdBy = by
+	}
+	// The version is taken in the insert, so two publishes cannot share it
+	if _, err := p.db.Exec(`
+		INSERT INTO policy_documents (kind, version, title, content, created_by)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ? FROM policy_documents WHERE kind = ?`,
+		kind, title, content, createdBy, kind,
+	); err != nil {
+		return nil, err
+	}
+	return p.Current(kind)
+}
+
+// Retire stops asking users to acknowledge a policy until a new version
+// is published
+func (p *PolicyService) Retire(kind string) error {
+	doc, err := p.Current(kind)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec("UPDATE policy_documents SET retired_at = CURRENT_TIMESTAMP WHERE kind = ? AND version = ?", kind, doc.Version)
+	return err
+}
+
+// Acknowledge records that a user accepted a version of a policy. Only the
+// current version can be acknowledged; an older one returns
+// ErrVersionConflict, so a user never accepts text they were not shown.
+func (p *PolicyService) Acknowledge(userID int, kind string, version int) error {
+	doc, err := p.Current(kind)
+	if err != nil {
+		return err
+	}
+	if version != doc.Version {
+		return ErrVersionConflict
+	}
+	_, err = p.db.Exec(
+		"INSERT OR IGNORE INTO policy_acknowledgments (user_id, kind, version) VALUES (?, ?, ?)",
+		userID, kind, version,
+	)
+	return err
+}
+
+// Acknowledged returns when a user acknowledged each version of a policy
+// they accepted, keyed by version
+func (p *PolicyService) Acknowledged(userID int, kind string) (map[int]time.Time, error) {
+	rows, err := p.db.Query("SELECT version, acknowledged_at FROM policy_acknowledgments WHERE user_id = ? AND kind = ?", userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		versions[version] = at
+	}
+	return versions, rows.Err()
+}
+
+// Outstanding returns the policies in force that a user has not
+// acknowledged in their current version
+func (p *PolicyService) Outstanding(userID int) ([]PolicyDocument, error) {
+	docs, err := p.CurrentAll()
+	if err != nil {
+		return nil, err
+	}
+	outstanding := make([]PolicyDocument, 0)
+	for _, doc := range docs {
+		var accepted bool
+		if err := p.db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM policy_acknowledgments WHERE user_id = ? AND kind = ? AND version = ?)",
+			userID, doc.Kind, doc.Version,
+		).Scan(&accepted); err != nil {
+			return nil, err
+		}
+		if !accepted {
+			outstanding = append(outstanding, doc)
+		}
+	}
+	return outstanding, nil
+}
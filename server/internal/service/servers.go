@@ -0,0 +1,124 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"fethur/internal/database"
+)
+
+// Server is a community server. Version counts edits, for optimistic
+// concurrency.
+type Server struct {
+	ID          int64
+	Name        string
+	Description string
+	OwnerID     int
+	OrgID       int
+	Version     int64
+}
+
+// ServerService creates servers and answers membership questions
+type ServerService struct {
+	db   *database.Database
+	orgs *OrgService
+}
+
+// Create creates a server in an organization with its owner as the first
+// member and a #general text channel. It returns ErrOrgLimit when the
+// organization has all the servers it may have.
+func (s *ServerService) Create(ownerID, orgID int, name, description string) (*Server, error) {
+	if err := s.orgs.CheckLimit(orgID, "servers"); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.Exec(
+		"INSERT INTO servers (name, description, owner_id, org_id) VALUES (?, ?, ?, ?)",
+		name, description, ownerID, orgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+	serverID, _ := result.LastInsertId()
+
+	if _, err := tx.Exec(
+		"INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, ?)",
+		ownerID, serverID, "owner",
+	); err != nil {
+		return nil, fmt.Errorf("failed to add server owner: %w", err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO channels (name, server_id, channel_type) VALUES (?, ?, ?)",
+		"general", serverID, "text",
+	); err != nil {
+		return nil, fmt.Errorf("failed to create default channel: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Server{ID: serverID, Name: name, Description: description, OwnerID: ownerID, OrgID: orgID, Version: 1}, nil
+}
+
+// Get loads a server, or returns ErrNotFound
+func (s *ServerService) Get(serverID int) (*Server, error) {
+	server := &Server{}
+	err := s.db.QueryRow(
+		"SELECT id, name, COALESCE(description, ''), owner_id, org_id, version FROM servers WHERE id = ?", serverID,
+	).Scan(&server.ID, &server.Name, &server.Description, &server.OwnerID, &server.OrgID, &server.Version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Update renames a server and replaces its description. version is the
+// version the edit was made against; when the server has changed since,
+// nothing is written and ErrVersionConflict is returned. Permission checks
+// are up to the caller.
+func (s *ServerService) Update(serverID int, name, description string, version int64) (*Server, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		return nil, &ValidationError{Err: errors.New("name must be 1-100 characters")}
+	}
+	if utf8.RuneCountInString(description) > 1000 {
+		return nil, &ValidationError{Err: errors.New("description must be at most 1000 characters")}
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE servers SET name = ?, description = ?, version = version + 1 WHERE id = ? AND version = ?",
+		name, description, serverID, version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		if _, err := s.Get(serverID); err != nil {
+			return nil, err
+		}
+		return nil, ErrVersionConflict
+	}
+	return s.Get(serverID)
+}
+
+// Role returns a user's role in a server; ok is false for non-members
+func (s *ServerService) Role(userID, serverID int) (role string, ok bool) {
+	err := s.db.QueryRow(
+		"SELECT role FROM server_members WHERE user_id = ? AND server_id = ?", userID, serverID,
+	).Scan(&role)
+	return role, err == nil
+}
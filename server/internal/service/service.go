@@ -0,0 +1,72 @@
+// Package service holds Fethur's business rules apart from any transport.
+// The HTTP handlers act through these services, and so can the WebSocket
+// and voice hubs, plugins and command line tools, so that a rule such as
+// "banned users cannot sign in" is written and tested once.
+//
+// Services return the errors declared here, or a *ValidationError for input
+// that breaks a rule; callers turn them into their own responses.
+package service
+
+import (
+	"errors"
+
+	"fethur/internal/auth"
+	"fethur/internal/database"
+)
+
+// Errors returned by the services
+var (
+	ErrInvalidCredentials   = errors.New("invalid username or password")
+	ErrBanned               = errors.New("account is banned")
+	ErrPendingApproval      = errors.New("account is waiting for approval")
+	ErrRegistrationClosed   = errors.New("registration is disabled")
+	ErrRegistrationPassword = errors.New("invalid registration password")
+	ErrInviteInvalid        = errors.New("invalid or expired invite code")
+	ErrUsernameTaken        = errors.New("username already exists")
+	ErrOrgLimit             = errors.New("organization limit reached")
+	ErrMuted                = errors.New("user is muted in this server")
+	ErrPolicyNotAccepted    = errors.New("the current terms and rules have not been acknowledged")
+	ErrReplyTarget          = errors.New("reply_to_id must be a message in this channel")
+	ErrNotFound             = errors.New("not found")
+	ErrVersionConflict      = errors.New("changed since the given version")
+)
+
+// ValidationError is input that breaks a rule. Its message is meant for
+// the user.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Container holds the services, wired to each other
+type Container struct {
+	Auth       *AuthService
+	Messages   *MessageService
+	Servers    *ServerService
+	Moderation *ModerationService
+	Orgs       *OrgService
+	Policies   *PolicyService
+}
+
+// New creates the services on top of the database and the token and
+// password service
+func New(db *database.Database, tokens *auth.Service) *Container {
+	orgs := &OrgService{db: db}
+	moderation := &ModerationService{db: db}
+	policies := &PolicyService{db: db}
+	return &Container{
+		Auth:       &AuthService{db: db, auth: tokens, orgs: orgs, moderation: moderation},
+		Messages:   &MessageService{db: db, moderation: moderation, policies: policies},
+		Servers:    &ServerService{db: db, orgs: orgs},
+		Moderation: moderation,
+		Orgs:       orgs,
+		Policies:   policies,
+	}
+}
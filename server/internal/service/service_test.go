@@ -0,0 +1,352 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fethur/internal/auth"
+	"fethur/internal/database"
+)
+
+func newTestServices(t *testing.T) (*Container, *database.Database) {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.SetSetting("auth_mode", "public", ""); err != nil {
+		t.Fatal(err)
+	}
+	return New(db, auth.NewService()), db
+}
+
+// uniqueName returns a name no earlier call has returned
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+}
+
+func TestAuthenticate(t *testing.T) {
+	services, _ := newTestServices(t)
+	username := uniqueName("svc_login")
+
+	user, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "", "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	got, err := services.Auth.Authenticate(username, "Sturdy-Passw0rd!", 0)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got.ID != user.ID || got.Role != "user" {
+		t.Errorf("Authenticated as %+v, want user %d", got, user.ID)
+	}
+
+	if _, err := services.Auth.Authenticate(username, "wrong", 0); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Wrong password returned %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := services.Auth.Authenticate(uniqueName("svc_nobody"), "Sturdy-Passw0rd!", 0); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Unknown user returned %v, want ErrInvalidCredentials", err)
+	}
+	// Accounts of the instance do not exist inside an organization
+	if _, err := services.Auth.Authenticate(username, "Sturdy-Passw0rd!", 42); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Other organization returned %v, want ErrInvalidCredentials", err)
+	}
+
+	if err := services.Moderation.Ban(user.ID, 1, "spam", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Auth.Authenticate(username, "Sturdy-Passw0rd!", 0); !errors.Is(err, ErrBanned) {
+		t.Errorf("Banned user returned %v, want ErrBanned", err)
+	}
+	// A wrong password does not reveal the ban
+	if _, err := services.Auth.Authenticate(username, "wrong", 0); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Banned user with wrong password returned %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestAuthenticateUpgradesHash(t *testing.T) {
+	services, db := newTestServices(t)
+	username := uniqueName("svc_rehash")
+	user, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "", "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	params := auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	if err := services.Auth.auth.SetPasswordHashing(auth.HashArgon2id, params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Auth.Authenticate(username, "Sturdy-Passw0rd!", 0); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", user.ID).Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("Password was not rehashed with argon2id: %q", hash)
+	}
+	if _, err := services.Auth.Authenticate(username, "Sturdy-Passw0rd!", 0); err != nil {
+		t.Errorf("Authenticate with the upgraded hash failed: %v", err)
+	}
+}
+
+func TestRegisterModes(t *testing.T) {
+	services, db := newTestServices(t)
+
+	if err := db.SetSetting("auth_mode", "admin_only", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Auth.Register(0, uniqueName("svc_closed"), "Sturdy-Passw0rd!", "", ""); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("admin_only returned %v, want ErrRegistrationClosed", err)
+	}
+
+	if err := db.SetSetting("auth_mode", "open_registration", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetSetting("registration_password", "letmein", ""); err != nil {
+		t.Fatal(err)
+	}
+	username := uniqueName("svc_open")
+	if _, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "nope", ""); !errors.Is(err, ErrRegistrationPassword) {
+		t.Errorf("Wrong registration password returned %v, want ErrRegistrationPassword", err)
+	}
+	if _, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "letmein", ""); err != nil {
+		t.Fatalf("Register with registration password failed: %v", err)
+	}
+	if _, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "letmein", ""); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Duplicate username returned %v, want ErrUsernameTaken", err)
+	}
+
+	var invalid *ValidationError
+	if _, err := services.Auth.Register(0, uniqueName("svc_weak"), "a", "letmein", ""); !errors.As(err, &invalid) {
+		t.Errorf("Weak password returned %v, want a ValidationError", err)
+	}
+
+	if err := db.SetSetting("auth_mode", "invite_only", ""); err != nil {
+		t.Fatal(err)
+	}
+	code := strings.ToLower(uniqueName("svc_invite"))
+	if _, err := db.Exec("INSERT INTO registration_invites (code, max_uses) VALUES (?, 1)", code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO registration_invites (code, expires_at) VALUES (?, datetime('now', '-1 hour'))", code+"_old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Auth.Register(0, uniqueName("svc_uninvited"), "Sturdy-Passw0rd!", "", ""); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("Missing invite code returned %v, want ErrInviteInvalid", err)
+	}
+	if _, err := services.Auth.Register(0, uniqueName("svc_expired"), "Sturdy-Passw0rd!", "", code+"_old"); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("Expired invite code returned %v, want ErrInviteInvalid", err)
+	}
+	// A failed registration does not use up the invite
+	if _, err := services.Auth.Register(0, username, "Sturdy-Passw0rd!", "", code); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Duplicate username returned %v, want ErrUsernameTaken", err)
+	}
+	if _, err := services.Auth.Register(0, uniqueName("svc_invited"), "Sturdy-Passw0rd!", "", " "+strings.ToUpper(code)); err != nil {
+		t.Fatalf("Register with invite code failed: %v", err)
+	}
+	if _, err := services.Auth.Register(0, uniqueName("svc_late"), "Sturdy-Passw0rd!", "", code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("Used up invite code returned %v, want ErrInviteInvalid", err)
+	}
+}
+
+func TestCreateServer(t *testing.T) {
+	services, db := newTestServices(t)
+	owner, err := services.Auth.Register(0, uniqueName("svc_owner"), "Sturdy-Passw0rd!", "", "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	created, err := services.Servers.Create(owner.ID, 0, "Service Test", "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if role, ok := services.Servers.Role(owner.ID, int(created.ID)); !ok || role != "owner" {
+		t.Errorf("Owner role is %q (member %v), want owner", role, ok)
+	}
+	var channel string
+	if err := db.QueryRow("SELECT name FROM channels WHERE server_id = ?", created.ID).Scan(&channel); err != nil || channel != "general" {
+		t.Errorf("Default channel is %q (%v), want general", channel, err)
+	}
+
+	slug := fmt.Sprintf("svc-%d", time.Now().UnixNano())
+	result, err := db.Exec("INSERT INTO organizations (slug, name, max_servers) VALUES (?, 'Service Org', 1)", slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orgID, _ := result.LastInsertId()
+	if _, err := services.Servers.Create(owner.ID, int(orgID), "First", ""); err != nil {
+		t.Fatalf("Create in organization failed: %v", err)
+	}
+	if _, err := services.Servers.Create(owner.ID, int(orgID), "Second", ""); !errors.Is(err, ErrOrgLimit) {
+		t.Errorf("Create over the limit returned %v, want ErrOrgLimit", err)
+	}
+}
+
+func TestModerationExpiry(t *testing.T) {
+	services, _ := newTestServices(t)
+	userID := int(time.Now().UnixNano() % 1_000_000_000)
+
+	if ModerationExpiry(0) != nil {
+		t.Error("A permanent action should not expire")
+	}
+	if err := services.Moderation.Ban(userID, 1, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !services.Moderation.IsBanned(userID) {
+		t.Error("Timed ban is not active")
+	}
+	if err := services.Moderation.Ban(userID, 1, "", -time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !services.Moderation.IsBanned(userID) {
+		t.Error("Replacing a ban with a permanent one lifted it")
+	}
+	if err := services.Moderation.Unban(userID); err != nil {
+		t.Fatal(err)
+	}
+	if services.Moderation.IsBanned(userID) {
+		t.Error("Unbanned user is still banned")
+	}
+}
+
+func TestPostingRules(t *testing.T) {
+	services, db := newTestServices(t)
+	owner, err := services.Auth.Register(0, uniqueName("svc_poster"), "Sturdy-Passw0rd!", "", "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	created, err := services.Servers.Create(owner.ID, 0, "Posting", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverID := int(created.ID)
+	var channelID int
+	if err := db.QueryRow("SELECT id FROM channels WHERE server_id = ?", serverID).Scan(&channelID); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := services.Messages.Create(Message{ChannelID: channelID, UserID: owner.ID, Content: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread, err := services.Messages.ReplyThread(channelID, root)
+	if err != nil || thread != root {
+		t.Fatalf("Reply to root joins thread %d (%v), want %d", thread, err, root)
+	}
+	reply, err := services.Messages.Create(Message{ChannelID: channelID, UserID: owner.ID, Content: "reply", ReplyToID: root, ThreadID: thread})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread, err := services.Messages.ReplyThread(channelID, reply); err != nil || thread != root {
+		t.Errorf("Reply to a reply joins thread %d (%v), want %d", thread, err, root)
+	}
+	if _, err := services.Messages.ReplyThread(channelID+1000, root); !errors.Is(err, ErrReplyTarget) {
+		t.Errorf("Reply across channels returned %v, want ErrReplyTarget", err)
+	}
+
+	if quarantined, err := services.Messages.CheckPost(serverID, owner.ID); err != nil || quarantined {
+		t.Fatalf("CheckPost = %v, %v; want a normal post", quarantined, err)
+	}
+	result, err := db.Exec("INSERT INTO moderation_cases (server_id, number, user_id, moderator_id) VALUES (?, 1, ?, ?)", serverID, owner.ID, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caseID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO moderation_case_actions (case_id, action, moderator_id) VALUES (?, 'quarantine', ?)", caseID, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if quarantined, err := services.Messages.CheckPost(serverID, owner.ID); err != nil || !quarantined {
+		t.Errorf("CheckPost = %v, %v; want a quarantined post", quarantined, err)
+	}
+	if _, err := db.Exec("INSERT INTO moderation_case_actions (case_id, action, moderator_id) VALUES (?, 'mute', ?)", caseID, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, owner.ID); !errors.Is(err, ErrMuted) {
+		t.Errorf("Muted user's CheckPost returned %v, want ErrMuted", err)
+	}
+}
+
+func TestPolicyAcknowledgment(t *testing.T) {
+	services, db := newTestServices(t)
+	user, err := services.Auth.Register(0, uniqueName("svc_policy"), "Sturdy-Passw0rd!", "", "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	created, err := services.Servers.Create(user.ID, 0, "Policies", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverID := int(created.ID)
+	// Policies are instance-wide; retire them so other tests can post
+	t.Cleanup(func() {
+		for kind := range PolicyKinds {
+			_ = services.Policies.Retire(kind)
+		}
+	})
+
+	if _, err := services.Policies.Publish("terms", "Terms", "  ", user.ID); err == nil {
+		t.Error("Expected empty content to be refused")
+	}
+	if _, err := services.Policies.Publish("privacy", "Privacy", "Text", user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unknown kind returned %v, want ErrNotFound", err)
+	}
+	first, err := services.Policies.Publish("rules", "Rules", "Be kind.", user.ID)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, user.ID); !errors.Is(err, ErrPolicyNotAccepted) {
+		t.Fatalf("CheckPost before acknowledging returned %v, want ErrPolicyNotAccepted", err)
+	}
+	if err := services.Policies.Acknowledge(user.ID, "rules", first.Version); err != nil {
+		t.Fatalf("Acknowledge failed: %v", err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, user.ID); err != nil {
+		t.Fatalf("CheckPost after acknowledging returned %v", err)
+	}
+
+	// A new version has to be acknowledged again, and the old one no longer counts
+	second, err := services.Policies.Publish("rules", "Rules", "Be kind. No spam.", user.ID)
+	if err != nil || second.Version != first.Version+1 {
+		t.Fatalf("Expected version %d, got %+v (%v)", first.Version+1, second, err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, user.ID); !errors.Is(err, ErrPolicyNotAccepted) {
+		t.Errorf("CheckPost after a new version returned %v, want ErrPolicyNotAccepted", err)
+	}
+	if err := services.Policies.Acknowledge(user.ID, "rules", first.Version); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Acknowledging an old version returned %v, want ErrVersionConflict", err)
+	}
+	if err := services.Policies.Acknowledge(user.ID, "rules", second.Version); err != nil {
+		t.Fatalf("Acknowledge failed: %v", err)
+	}
+	acknowledged, err := services.Policies.Acknowledged(user.ID, "rules")
+	if err != nil || len(acknowledged) != 2 {
+		t.Errorf("Expected both acknowledgments recorded, got %v (%v)", acknowledged, err)
+	}
+
+	// Retired policies stop holding users back
+	if _, err := services.Policies.Publish("terms", "Terms", "Use it well.", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, user.ID); !errors.Is(err, ErrPolicyNotAccepted) {
+		t.Errorf("CheckPost with new terms returned %v, want ErrPolicyNotAccepted", err)
+	}
+	if err := services.Policies.Retire("terms"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Messages.CheckPost(serverID, user.ID); err != nil {
+		t.Errorf("CheckPost with the terms retired returned %v", err)
+	}
+	var history int
+	if err := db.QueryRow("SELECT COUNT(*) FROM policy_documents WHERE kind = 'rules'").Scan(&history); err != nil || history < 2 {
+		t.Errorf("Expected every version kept, got %d (%v)", history, err)
+	}
+}
@@ -0,0 +1,173 @@
+// Package snowflake generates time-ordered 64-bit IDs that several nodes
+// can hand out without coordinating.
+//
+// An ID holds milliseconds since Epoch in its top 41 bits, the node in the
+// next 10 and a per-millisecond sequence in the last 12. IDs from one node
+// always increase, and IDs from different nodes sort by time to within
+// clock skew. Rows created before IDs were generated keep their small
+// auto-increment IDs, which sort before every generated one.
+//
+// IDs travel through the API as decimal strings, since JavaScript numbers
+// cannot hold them exactly. Requests may still send them as numbers.
+package snowflake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+
+	// MaxNode is the highest node number
+	MaxNode = 1<<nodeBits - 1
+
+	maxSequence = 1<<sequenceBits - 1
+	timeShift   = nodeBits + sequenceBits
+)
+
+// Epoch is the start of generated IDs' clocks, 2024-01-01 UTC
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrInvalidNode is returned for a node outside 0 to MaxNode
+var ErrInvalidNode = errors.New("snowflake node must be between 0 and 1023")
+
+// ID is a row ID. Generated and older auto-increment IDs share the type.
+type ID int64
+
+// Parse reads an ID in decimal
+func Parse(s string) (ID, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid ID %q", s)
+	}
+	return ID(id), nil
+}
+
+// String returns the ID in decimal
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// Time returns when a generated ID was made. Older auto-increment IDs
+// have no time and return the zero time.
+func (id ID) Time() time.Time {
+	if id>>timeShift == 0 {
+		return time.Time{}
+	}
+	return Epoch.Add(time.Duration(int64(id)>>timeShift) * time.Millisecond)
+}
+
+// MarshalJSON writes the ID as a decimal string
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + id.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts the ID as a decimal string or, from older
+// clients, a number
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := Parse(s)
+		if err != nil {
+			return err
+		}
+		*id = parsed
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid ID %s", data)
+	}
+	*id = ID(n)
+	return nil
+}
+
+// Int64s converts IDs for use as query arguments
+func Int64s(ids []ID) []int64 {
+	values := make([]int64, len(ids))
+	for i, id := range ids {
+		values[i] = int64(id)
+	}
+	return values
+}
+
+// Generator hands out IDs for one node. It is safe for concurrent use.
+type Generator struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+	now      func() time.Time
+}
+
+// NewGenerator creates a generator for a node. Nodes writing to the same
+// database need different numbers.
+func NewGenerator(node int) (*Generator, error) {
+	if node < 0 || node > MaxNode {
+		return nil, ErrInvalidNode
+	}
+	return &Generator{node: int64(node), now: time.Now}, nil
+}
+
+// Next returns a new ID. If the clock goes backwards it keeps counting
+// from the last time it saw, so IDs never repeat or decrease.
+func (g *Generator) Next() ID {
+	return g.NextAt(g.now())
+}
+
+// NextAt returns a new ID as if made at t, for rows written with a time
+// other than now. Like Next, it never repeats or decreases.
+func (g *Generator) NextAt(t time.Time) ID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := t.Sub(Epoch).Milliseconds()
+	if ms <= g.last {
+		ms = g.last
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// The millisecond is used up; borrow the next one
+			ms++
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = ms
+	return ID(ms<<timeShift | g.node<<sequenceBits | g.sequence)
+}
+
+var (
+	defaultMu           sync.RWMutex
+	defaultGenerator, _ = NewGenerator(0)
+)
+
+// SetNode makes Next generate IDs for a node
+func SetNode(node int) error {
+	g, err := NewGenerator(node)
+	if err != nil {
+		return err
+	}
+	defaultMu.Lock()
+	defaultGenerator = g
+	defaultMu.Unlock()
+	return nil
+}
+
+// Next returns a new ID from the process's generator, node 0 unless
+// SetNode chose another
+func Next() ID {
+	defaultMu.RLock()
+	g := defaultGenerator
+	defaultMu.RUnlock()
+	return g.Next()
+}
@@ -0,0 +1,84 @@
+package snowflake
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGeneratorOrdering(t *testing.T) {
+	g, err := NewGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	seen := make(map[ID]bool)
+	var last ID
+	for i := 0; i < 10000; i++ {
+		id := g.Next()
+		if seen[id] {
+			t.Fatalf("ID %d repeated", id)
+		}
+		if id <= last {
+			t.Fatalf("ID %d does not follow %d", id, last)
+		}
+		seen[id], last = true, id
+	}
+
+	// A clock that steps back does not make IDs go back
+	now = now.Add(-time.Hour)
+	if id := g.Next(); id <= last {
+		t.Errorf("ID %d after a clock step back does not follow %d", id, last)
+	}
+
+	// IDs made for another time carry that time and still never go back
+	past := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fresh, _ := NewGenerator(7)
+	if id := fresh.NextAt(past); !id.Time().Equal(past) {
+		t.Errorf("ID made at %v has time %v", past, id.Time())
+	}
+	if id := g.NextAt(past); id <= last {
+		t.Errorf("ID made in the past %d does not follow %d", id, last)
+	}
+
+	first := ID(time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC).Sub(Epoch).Milliseconds()<<timeShift | 7<<sequenceBits)
+	if !first.Time().Equal(time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Time of %d is %v", first, first.Time())
+	}
+	if !ID(42).Time().IsZero() {
+		t.Error("An auto-increment ID should have no time")
+	}
+
+	if _, err := NewGenerator(MaxNode + 1); err != ErrInvalidNode {
+		t.Errorf("Node %d returned %v", MaxNode+1, err)
+	}
+}
+
+func TestIDJSON(t *testing.T) {
+	id := ID(1<<62 + 1)
+	data, err := json.Marshal(struct {
+		ID ID `json:"id"`
+	}{id})
+	if err != nil || string(data) != `{"id":"4611686018427387905"}` {
+		t.Errorf("Marshal gave %s (%v)", data, err)
+	}
+
+	var got struct {
+		IDs []ID `json:"ids"`
+	}
+	if err := json.Unmarshal([]byte(`{"ids":["4611686018427387905",42]}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.IDs) != 2 || got.IDs[0] != id || got.IDs[1] != 42 {
+		t.Errorf("Unmarshal gave %v", got.IDs)
+	}
+
+	for _, bad := range []string{`"abc"`, `"-1"`, `1.5`, `true`} {
+		var parsed ID
+		if err := json.Unmarshal([]byte(bad), &parsed); err == nil {
+			t.Errorf("%s parsed as %d", bad, parsed)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalBackend stores objects as files under a root directory
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend creates a local backend rooted at dir, creating it if needed
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalBackend{root: dir}, nil
+}
+
+// Name returns the backend name
+func (b *LocalBackend) Name() string {
+	return "local"
+}
+
+func (b *LocalBackend) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(cleaned)), nil
+}
+
+// Put writes an object, replacing any existing one
+func (b *LocalBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see partial objects
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Open opens an object for reading
+func (b *LocalBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Stat returns object metadata
+func (b *LocalBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes an object; deleting a missing object is not an error
+func (b *LocalBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
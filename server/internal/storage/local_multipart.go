@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Multipart uploads are staged under <root>/.multipart/<upload ID>/<part>
+
+func (b *LocalBackend) multipartDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("invalid upload ID: %q", uploadID)
+	}
+	return filepath.Join(b.root, ".multipart", uploadID), nil
+}
+
+// CreateMultipart starts a multipart upload
+func (b *LocalBackend) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := b.path(key); err != nil {
+		return "", err
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(random)
+
+	dir, _ := b.multipartDir(uploadID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores one part, replacing a previous upload of the same part
+func (b *LocalBackend) UploadPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	dir, err := b.multipartDir(uploadID)
+	if err != nil {
+		return Part{}, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return Part{}, ErrNotFound
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return Part{}, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Part{}, err
+	}
+	if size >= 0 && written != size {
+		return Part{}, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(number))); err != nil {
+		return Part{}, err
+	}
+	return Part{Number: number, Size: written, ETag: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// ListParts returns the parts uploaded so far, ordered by number
+func (b *LocalBackend) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	dir, err := b.multipartDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Part, 0, len(entries))
+	for _, entry := range entries {
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		etag, size, err := fileMD5(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{Number: number, Size: size, ETag: etag})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// CompleteMultipart concatenates the given parts into the final object
+func (b *LocalBackend) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, err := b.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	files := make([]*os.File, 0, len(parts))
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	var total int64
+	for _, part := range parts {
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(part.Number)))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("part %d was not uploaded", part.Number)
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		readers = append(readers, file)
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+	}
+
+	if err := b.Put(ctx, key, io.MultiReader(readers...), total, ""); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// AbortMultipart discards a multipart upload and its parts
+func (b *LocalBackend) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	dir, err := b.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func fileMD5(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
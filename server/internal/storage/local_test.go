@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+
+	uploadID, err := backend.CreateMultipart(ctx, "attachments/1/file", "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to create multipart upload: %v", err)
+	}
+
+	// Upload out of order, as a resumed client might
+	if _, err := backend.UploadPart(ctx, "attachments/1/file", uploadID, 2, strings.NewReader("world"), 5); err != nil {
+		t.Fatalf("Failed to upload part 2: %v", err)
+	}
+	if _, err := backend.UploadPart(ctx, "attachments/1/file", uploadID, 1, strings.NewReader("hello "), 6); err != nil {
+		t.Fatalf("Failed to upload part 1: %v", err)
+	}
+
+	parts, err := backend.ListParts(ctx, "attachments/1/file", uploadID)
+	if err != nil {
+		t.Fatalf("Failed to list parts: %v", err)
+	}
+	if len(parts) != 2 || parts[0].Number != 1 || parts[1].Size != 5 {
+		t.Fatalf("Unexpected parts: %+v", parts)
+	}
+
+	if err := backend.CompleteMultipart(ctx, "attachments/1/file", uploadID, parts); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+
+	reader, err := backend.Open(ctx, "attachments/1/file")
+	if err != nil {
+		t.Fatalf("Failed to open object: %v", err)
+	}
+	defer reader.Close()
+
+	content, _ := io.ReadAll(reader)
+	if string(content) != "hello world" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	if _, err := backend.ListParts(ctx, "attachments/1/file", uploadID); err != ErrNotFound {
+		t.Errorf("Expected staged parts to be removed, got %v", err)
+	}
+}
+
+func TestLocalBackendRejectsTraversal(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	if err := backend.Put(context.Background(), "../escape", strings.NewReader("x"), 1, ""); err == nil {
+		t.Error("Expected key with .. to be rejected")
+	}
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO, ...)
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // address the bucket as /bucket/key instead of bucket.host
+}
+
+// S3Backend stores objects in an S3-compatible bucket. Every request,
+// including the server's own, is authenticated with a presigned URL
+// (AWS Signature Version 4).
+type S3Backend struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Backend creates an S3 backend
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("s3 backend requires bucket, access key and secret key")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3.amazonaws.com"
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", config.Endpoint)
+	}
+
+	return &S3Backend{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+// Name returns the backend name
+func (b *S3Backend) Name() string {
+	return "s3"
+}
+
+// objectURL returns the unsigned URL of an object
+func (b *S3Backend) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	escapedKey := awsURIEscape(strings.TrimPrefix(key, "/"), false)
+	if b.config.PathStyle {
+		u.Path = "/" + b.config.Bucket + "/" + strings.TrimPrefix(key, "/")
+		u.RawPath = "/" + awsURIEscape(b.config.Bucket, true) + "/" + escapedKey
+	} else {
+		u.Host = b.config.Bucket + "." + u.Host
+		u.Path = "/" + strings.TrimPrefix(key, "/")
+		u.RawPath = "/" + escapedKey
+	}
+	return &u
+}
+
+// presign builds a SigV4 query-signed request. Headers are included in the
+// signature, so the client must send them unchanged. Params are extra
+// query parameters such as uploadId.
+func (b *S3Backend) presign(method, key string, params, headers map[string]string, expires time.Duration) (*PresignedRequest, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return nil, fmt.Errorf("presign expiry must be between 1s and 7 days")
+	}
+
+	now := b.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), b.config.Region)
+
+	u := b.objectURL(key)
+
+	signed := map[string]string{"host": u.Host}
+	for name, value := range headers {
+		signed[strings.ToLower(name)] = strings.TrimSpace(value)
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	signedHeaders := strings.Join(names, ";")
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    b.config.AccessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(expires / time.Second)),
+		"X-Amz-SignedHeaders": signedHeaders,
+	}
+	for name, value := range params {
+		query[name] = value
+	}
+	canonicalQuery := canonicalQueryString(query)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+b.config.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, b.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+
+	request := &PresignedRequest{
+		Method:  method,
+		URL:     u.String(),
+		Expires: now.Add(expires),
+	}
+	if len(headers) > 0 {
+		request.Headers = make(http.Header)
+		for name, value := range headers {
+			request.Headers.Set(name, value)
+		}
+	}
+	return request, nil
+}
+
+// PresignPut returns a URL the client uploads the object to. The content
+// type and size are part of the signature.
+func (b *S3Backend) PresignPut(key, contentType string, size int64, expires time.Duration) (*PresignedRequest, error) {
+	headers := map[string]string{"Content-Type": contentType}
+	if size > 0 {
+		headers["Content-Length"] = strconv.FormatInt(size, 10)
+	}
+	return b.presign(http.MethodPut, key, nil, headers, expires)
+}
+
+// PresignGet returns a URL the client downloads the object from
+func (b *S3Backend) PresignGet(key string, expires time.Duration) (*PresignedRequest, error) {
+	return b.presign(http.MethodGet, key, nil, nil, expires)
+}
+
+// do performs a server-side request through a short-lived presigned URL
+func (b *S3Backend) do(ctx context.Context, method, key string, params, headers map[string]string, body io.Reader, size int64) (*http.Response, error) {
+	presigned, err := b.presign(method, key, params, headers, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, presigned.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if body != nil && size >= 0 {
+		req.ContentLength = size
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// Put uploads an object
+func (b *S3Backend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	resp, err := b.do(ctx, http.MethodPut, key, nil, map[string]string{"Content-Type": contentType}, r, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Open downloads an object
+func (b *S3Backend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat returns object metadata
+func (b *S3Backend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, nil, nil, nil, -1)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	_ = resp.Body.Close()
+
+	info := ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+// Delete removes an object
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, nil, -1)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func canonicalQueryString(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, awsURIEscape(key, true)+"="+awsURIEscape(query[key], true))
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEscape percent-encodes everything except RFC 3986 unreserved
+// characters, as required by SigV4. Slashes are kept unless encodeSlash is set.
+func awsURIEscape(value string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9'),
+			c == '-', c == '_', c == '.', c == '~':
+			escaped.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type s3InitiateMultipartResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3ListPartsResult struct {
+	IsTruncated          bool `xml:"IsTruncated"`
+	NextPartNumberMarker int  `xml:"NextPartNumberMarker"`
+	Parts                []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+		Size       int64  `xml:"Size"`
+	} `xml:"Part"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+// CreateMultipart starts a multipart upload
+func (b *S3Backend) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	resp, err := b.do(ctx, http.MethodPost, key,
+		map[string]string{"uploads": ""},
+		map[string]string{"Content-Type": contentType},
+		nil, -1)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var result s3InitiateMultipartResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse multipart upload response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("s3 returned no upload ID")
+	}
+	return result.UploadID, nil
+}
+
+func partParams(uploadID string, number int) map[string]string {
+	return map[string]string{
+		"partNumber": strconv.Itoa(number),
+		"uploadId":   uploadID,
+	}
+}
+
+// PresignPart returns a URL the client uploads one part to directly
+func (b *S3Backend) PresignPart(key, uploadID string, number int, size int64, expires time.Duration) (*PresignedRequest, error) {
+	var headers map[string]string
+	if size > 0 {
+		headers = map[string]string{"Content-Length": strconv.FormatInt(size, 10)}
+	}
+	return b.presign(http.MethodPut, key, partParams(uploadID, number), headers, expires)
+}
+
+// UploadPart uploads one part through the server
+func (b *S3Backend) UploadPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	resp, err := b.do(ctx, http.MethodPut, key, partParams(uploadID, number), nil, r, size)
+	if err != nil {
+		return Part{}, err
+	}
+	_ = resp.Body.Close()
+	return Part{Number: number, Size: size, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// ListParts returns the parts uploaded so far, ordered by number
+func (b *S3Backend) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	parts := make([]Part, 0)
+	marker := 0
+	for {
+		params := map[string]string{"uploadId": uploadID}
+		if marker > 0 {
+			params["part-number-marker"] = strconv.Itoa(marker)
+		}
+
+		resp, err := b.do(ctx, http.MethodGet, key, params, nil, nil, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListPartsResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse list parts response: %w", err)
+		}
+
+		for _, part := range result.Parts {
+			parts = append(parts, Part{Number: part.PartNumber, Size: part.Size, ETag: part.ETag})
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker <= marker {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// CompleteMultipart assembles the uploaded parts into the final object
+func (b *S3Backend) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	request := s3CompleteMultipartUpload{}
+	for _, part := range parts {
+		request.Parts = append(request.Parts, s3CompletePart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(ctx, http.MethodPost, key,
+		map[string]string{"uploadId": uploadID},
+		map[string]string{"Content-Type": "application/xml"},
+		bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// S3 may report a failed completion with a 200 status and an Error body
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(payload, []byte("<Error>")) {
+		return fmt.Errorf("s3 failed to complete multipart upload: %s", bytes.TrimSpace(payload))
+	}
+	return nil
+}
+
+// AbortMultipart discards a multipart upload and its parts
+func (b *S3Backend) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, map[string]string{"uploadId": uploadID}, nil, nil, -1)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,71 @@
+// Package storage provides pluggable object storage for attachments.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Backend stores attachment objects by key
+type Backend interface {
+	// Name identifies the backend in logs and health output
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// PresignedRequest is a request a client performs directly against the
+// storage backend
+type PresignedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Expires time.Time   `json:"expires"`
+}
+
+// Presigner is implemented by backends that let clients upload and
+// download directly, so large files never stream through the server
+type Presigner interface {
+	PresignPut(key, contentType string, size int64, expires time.Duration) (*PresignedRequest, error)
+	PresignGet(key string, expires time.Duration) (*PresignedRequest, error)
+}
+
+// Part is one uploaded part of a multipart upload
+type Part struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// MultipartBackend is implemented by backends that support chunked,
+// resumable uploads. Parts are numbered from 1 and may be uploaded in any
+// order; ListParts lets a client resume after an interruption.
+type MultipartBackend interface {
+	CreateMultipart(ctx context.Context, key, contentType string) (uploadID string, err error)
+	UploadPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error)
+	ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// PartPresigner is implemented by multipart backends that let clients
+// upload parts directly
+type PartPresigner interface {
+	PresignPart(key, uploadID string, number int, size int64, expires time.Duration) (*PresignedRequest, error)
+}
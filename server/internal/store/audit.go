@@ -0,0 +1,60 @@
+package store
+
+import "context"
+
+// AuditEntry is an admin action in the audit log
+type AuditEntry struct {
+	ID            int
+	AdminID       int
+	AdminUsername string
+	Action        string
+	Details       string
+	IP            string
+	CreatedAt     string
+}
+
+// AuditStore writes and reads the audit log of admin actions
+type AuditStore struct {
+	db DBTX
+}
+
+// Log records an admin action and returns its ID. The entry records the IP
+// of the admin's most recently used session.
+func (s *AuditStore) Log(ctx context.Context, adminID int, action, details string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_logs (admin_id, action, details, ip, created_at)
+		VALUES (?, ?, ?, (
+			SELECT last_ip FROM user_devices WHERE user_id = ? AND revoked_at IS NULL
+			ORDER BY last_seen DESC, id DESC LIMIT 1
+		), CURRENT_TIMESTAMP)
+	`, adminID, action, details, adminID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// List returns a page of the audit log, newest first
+func (s *AuditStore) List(ctx context.Context, limit, offset int) ([]AuditEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT al.id, al.admin_id, COALESCE(u.username, '') as admin_username, al.action, al.details, COALESCE(al.ip, ''), al.created_at
+		FROM audit_logs al
+		LEFT JOIN users u ON al.admin_id = u.id
+		ORDER BY al.created_at DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.AdminID, &entry.AdminUsername, &entry.Action, &entry.Details, &entry.IP, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
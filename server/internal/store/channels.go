@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ChannelVisibleSQL limits channels c to those the server member sm can
+// see: every public channel, and private channels for owners, admins,
+// holders of the channel's role and members added to the channel. NSFW
+// channels are hidden from members under age, whatever their role.
+const ChannelVisibleSQL = `((c.private = 0 OR sm.role IN ('owner', 'admin')
+	OR EXISTS (SELECT 1 FROM member_roles mr WHERE mr.role_id = c.access_role_id AND mr.user_id = sm.user_id)
+	OR EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = c.id AND cm.user_id = sm.user_id))
+	AND (c.nsfw = 0 OR NOT EXISTS (SELECT 1 FROM users au WHERE au.id = sm.user_id AND au.under_age = 1)))`
+
+// Channel is a channel in a server's channel list. OwnerID is set for
+// temporary voice channels.
+type Channel struct {
+	ID          int
+	Name        string
+	ChannelType string
+	Private     bool
+	Public      bool
+	NSFW        bool
+	UserLimit   int
+	OwnerID     sql.NullInt64
+	CreatedAt   string
+	Version     int64
+}
+
+// NewChannel is a channel to create
+type NewChannel struct {
+	ServerID    int
+	Name        string
+	ChannelType string
+	NSFW        bool
+}
+
+// ChannelStore reads and writes channels
+type ChannelStore struct {
+	db DBTX
+}
+
+// Create creates a channel and returns its ID
+func (s *ChannelStore) Create(ctx context.Context, channel NewChannel) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO channels (name, server_id, channel_type, nsfw) VALUES (?, ?, ?, ?)",
+		channel.Name, channel.ServerID, channel.ChannelType, channel.NSFW,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ListVisible returns the channels of a server a member can see, oldest
+// first
+func (s *ChannelStore) ListVisible(ctx context.Context, userID, serverID int) ([]Channel, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.name, c.channel_type, c.private, c.public, c.nsfw, c.user_limit, c.temp_owner_id, c.created_at, c.version
+		FROM channels c
+		JOIN server_members sm ON c.server_id = sm.server_id AND sm.user_id = ?
+		WHERE c.server_id = ? AND `+ChannelVisibleSQL+`
+		ORDER BY c.created_at ASC`,
+		userID, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []Channel
+	for rows.Next() {
+		var channel Channel
+		if err := rows.Scan(&channel.ID, &channel.Name, &channel.ChannelType, &channel.Private, &channel.Public, &channel.NSFW,
+			&channel.UserLimit, &channel.OwnerID, &channel.CreatedAt, &channel.Version); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// CanView reports whether a user can see a channel
+func (s *ChannelStore) CanView(ctx context.Context, userID, channelID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM channels c
+			JOIN server_members sm ON c.server_id = sm.server_id
+			WHERE c.id = ? AND sm.user_id = ? AND `+ChannelVisibleSQL+`
+		)`, channelID, userID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MessageStore reads and writes messages. Posting goes through the message
+// service, which applies the posting rules.
+type MessageStore struct {
+	db DBTX
+}
+
+// Delete deletes a message outright, without leaving a tombstone
+func (s *MessageStore) Delete(ctx context.Context, messageID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM messages WHERE id = ?", messageID)
+	return err
+}
+
+// Count counts every message
+func (s *MessageStore) Count(ctx context.Context) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM messages")
+}
+
+// CountCreatedSince counts the messages posted within ago
+func (s *MessageStore) CountCreatedSince(ctx context.Context, ago time.Duration) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM messages WHERE created_at > datetime('now', ?)", sinceModifier(ago))
+}
+
+// Revision is an earlier version of an edited message. WrittenAt is when
+// the version was posted or last edited, ReplacedAt when an edit by
+// ReplacedBy replaced it.
+type Revision struct {
+	ID             int64
+	Content        string
+	Embeds         string
+	WrittenAt      string
+	ReplacedAt     string
+	ReplacedBy     sql.NullInt64
+	ReplacedByName string
+}
+
+// Update replaces a message's content and embeds and returns its edit
+// count. An edit by editorID keeps the replaced version as a revision and
+// marks the message edited; editorID 0 refreshes the message without
+// either. Run it in a transaction so the revision and the new version are
+// stored together.
+func (s *MessageStore) Update(ctx context.Context, messageID int64, editorID int, content string, embeds sql.NullString) (int, error) {
+	query := "UPDATE messages SET content = ?, embeds = ? WHERE id = ?"
+	if editorID != 0 {
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO message_revisions (message_id, content, embeds, written_at, replaced_by)
+			SELECT id, content, embeds, COALESCE(edited_at, created_at), ? FROM messages WHERE id = ?`,
+			editorID, messageID,
+		); err != nil {
+			return 0, err
+		}
+		query = "UPDATE messages SET content = ?, embeds = ?, edited_at = CURRENT_TIMESTAMP, edit_count = edit_count + 1 WHERE id = ?"
+	}
+	if _, err := s.db.ExecContext(ctx, query, content, embeds, messageID); err != nil {
+		return 0, err
+	}
+	return count(ctx, s.db, "SELECT edit_count FROM messages WHERE id = ?", messageID)
+}
+
+// Revisions returns the earlier versions of a message, newest first
+func (s *MessageStore) Revisions(ctx context.Context, messageID int64) ([]Revision, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.id, r.content, COALESCE(r.embeds, ''), r.written_at, r.replaced_at, r.replaced_by, COALESCE(u.username, '')
+		FROM message_revisions r
+		LEFT JOIN users u ON u.id = r.replaced_by
+		WHERE r.message_id = ?
+		ORDER BY r.id DESC`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var revision Revision
+		if err := rows.Scan(&revision.ID, &revision.Content, &revision.Embeds, &revision.WrittenAt, &revision.ReplacedAt,
+			&revision.ReplacedBy, &revision.ReplacedByName); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
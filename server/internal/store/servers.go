@@ -0,0 +1,118 @@
+package store
+
+import "context"
+
+// Server is a community server as its members see it
+type Server struct {
+	ID          int
+	Name        string
+	Description string
+	OwnerID     int
+	CreatedAt   string
+	Version     int64
+}
+
+// Member is a user in a server's member list
+type Member struct {
+	ID        int
+	Username  string
+	Email     string
+	Role      string
+	CreatedAt string
+	UpdatedAt string
+}
+
+// ServerStore reads servers and their members
+type ServerStore struct {
+	db DBTX
+}
+
+// Get loads a server, or returns ErrNotFound
+func (s *ServerStore) Get(ctx context.Context, serverID int) (*Server, error) {
+	server := &Server{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, description, owner_id, created_at, version FROM servers WHERE id = ?",
+		serverID,
+	).Scan(&server.ID, &server.Name, &server.Description, &server.OwnerID, &server.CreatedAt, &server.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return server, nil
+}
+
+// ListForUser returns the servers of an organization a user is a member
+// of, newest first
+func (s *ServerStore) ListForUser(ctx context.Context, userID, orgID int) ([]Server, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.name, s.description, s.owner_id, s.created_at, s.version
+		FROM servers s
+		JOIN server_members sm ON s.id = sm.server_id
+		WHERE sm.user_id = ? AND s.org_id = ?
+		ORDER BY s.created_at DESC
+	`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []Server
+	for rows.Next() {
+		var server Server
+		if err := rows.Scan(&server.ID, &server.Name, &server.Description, &server.OwnerID, &server.CreatedAt, &server.Version); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, rows.Err()
+}
+
+// IsMember reports whether a user is a member of a server
+func (s *ServerStore) IsMember(ctx context.Context, userID, serverID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM server_members WHERE user_id = ? AND server_id = ?)",
+		userID, serverID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// MemberRole returns a member's role in a server, or ErrNotFound when the
+// user is not a member
+func (s *ServerStore) MemberRole(ctx context.Context, userID, serverID int) (string, error) {
+	var role string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT role FROM server_members WHERE user_id = ? AND server_id = ?",
+		userID, serverID,
+	).Scan(&role)
+	return role, notFound(err)
+}
+
+// Members returns the members of a server by username
+func (s *ServerStore) Members(ctx context.Context, serverID int) ([]Member, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.email, u.role, u.created_at, u.updated_at
+		FROM users u
+		INNER JOIN server_members sm ON u.id = sm.user_id
+		WHERE sm.server_id = ?
+		ORDER BY u.username
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.ID, &member.Username, &member.Email, &member.Role, &member.CreatedAt, &member.UpdatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// Count counts every server
+func (s *ServerStore) Count(ctx context.Context) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM servers")
+}
@@ -0,0 +1,67 @@
+// Package store holds the SQL behind the HTTP handlers. Each store reads
+// and writes one kind of record through a DBTX, so the same store works on
+// the primary database, a read replica or a transaction, and the handlers
+// never see a query.
+//
+// Stores return ErrNotFound for a missing record and the driver's error
+// for anything else.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when the record asked for does not exist
+var ErrNotFound = errors.New("not found")
+
+// DBTX is what the stores query: a *sql.DB, a *sql.Tx or anything that
+// embeds one, such as *database.Database
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Stores holds the stores, all on the same database
+type Stores struct {
+	Users    *UserStore
+	Messages *MessageStore
+	Servers  *ServerStore
+	Channels *ChannelStore
+	Audit    *AuditStore
+}
+
+// New creates the stores on top of db
+func New(db DBTX) *Stores {
+	return &Stores{
+		Users:    &UserStore{db: db},
+		Messages: &MessageStore{db: db},
+		Servers:  &ServerStore{db: db},
+		Channels: &ChannelStore{db: db},
+		Audit:    &AuditStore{db: db},
+	}
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// count runs a query returning a single count
+func count(ctx context.Context, db DBTX, query string, args ...interface{}) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// sinceModifier is the SQLite datetime modifier going back by ago
+func sinceModifier(ago time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(ago.Seconds()))
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fethur/internal/database"
+)
+
+func newTestStores(t *testing.T) (*Stores, *database.Database) {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "fethur.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db), db
+}
+
+// uniqueName returns a name no earlier call has returned
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+}
+
+func TestUserStore(t *testing.T) {
+	stores, _ := newTestStores(t)
+	ctx := context.Background()
+	username := uniqueName("store_user")
+
+	id, err := stores.Users.Create(ctx, NewUser{Username: username, PasswordHash: "x", Role: "user"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := stores.Users.Create(ctx, NewUser{Username: username, PasswordHash: "x", Role: "user"}); err == nil {
+		t.Error("Expected a taken username to be refused")
+	}
+	userID := int(id)
+
+	if err := stores.Users.SetEmail(ctx, userID, "store@example.com"); err != nil {
+		t.Fatalf("SetEmail failed: %v", err)
+	}
+	user, err := stores.Users.Get(ctx, userID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if user.Username != username || user.Email != "store@example.com" || user.EmailVerified || user.Role != "user" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	// An email set by update counts as verified
+	renamed := username + "_renamed"
+	if err := stores.Users.Update(ctx, userID, UserUpdate{Username: renamed, Email: "new@example.com"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := stores.Users.SetRole(ctx, userID, "admin"); err != nil {
+		t.Fatalf("SetRole failed: %v", err)
+	}
+	if user, err = stores.Users.Get(ctx, userID); err != nil || user.Username != renamed || !user.EmailVerified || user.Role != "admin" {
+		t.Errorf("Expected the update applied, got %+v (%v)", user, err)
+	}
+
+	users, err := stores.Users.List(ctx, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	found := false
+	for _, listed := range users {
+		found = found || listed.ID == userID
+	}
+	if !found {
+		t.Error("Expected the user to be listed")
+	}
+	if roles, err := stores.Users.RoleCounts(ctx); err != nil || roles["admin"] < 1 {
+		t.Errorf("Expected the admin counted, got %v (%v)", roles, err)
+	}
+	if n, err := stores.Users.CountCreatedSince(ctx, time.Hour); err != nil || n < 1 {
+		t.Errorf("Expected the new user counted, got %d (%v)", n, err)
+	}
+
+	if err := stores.Users.Delete(ctx, userID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := stores.Users.Get(ctx, userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound once deleted, got %v", err)
+	}
+	if _, err := stores.Users.Username(ctx, userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound once deleted, got %v", err)
+	}
+}
+
+func TestServerAndChannelStores(t *testing.T) {
+	stores, db := newTestStores(t)
+	ctx := context.Background()
+
+	users := make(map[string]int)
+	for _, name := range []string{"owner", "member", "minor", "outsider"} {
+		id, err := stores.Users.Create(ctx, NewUser{Username: uniqueName("store_" + name), PasswordHash: "x", Role: "user"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		users[name] = int(id)
+	}
+	if _, err := db.Exec("UPDATE users SET under_age = 1 WHERE id = ?", users["minor"]); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO servers (name, description, owner_id) VALUES (?, '', ?)", uniqueName("Store"), users["owner"])
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	serverID := int(id)
+	if _, err := db.Exec("INSERT INTO server_members (user_id, server_id, role) VALUES (?, ?, 'owner'), (?, ?, 'member'), (?, ?, 'member')",
+		users["owner"], serverID, users["member"], serverID, users["minor"], serverID); err != nil {
+		t.Fatalf("Failed to add members: %v", err)
+	}
+
+	if member, err := stores.Servers.IsMember(ctx, users["member"], serverID); err != nil || !member {
+		t.Errorf("Expected a member, got %t (%v)", member, err)
+	}
+	if member, err := stores.Servers.IsMember(ctx, users["outsider"], serverID); err != nil || member {
+		t.Errorf("Expected an outsider, got %t (%v)", member, err)
+	}
+	if role, err := stores.Servers.MemberRole(ctx, users["owner"], serverID); err != nil || role != "owner" {
+		t.Errorf("Expected the owner role, got %q (%v)", role, err)
+	}
+	if _, err := stores.Servers.MemberRole(ctx, users["outsider"], serverID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an outsider, got %v", err)
+	}
+	if server, err := stores.Servers.Get(ctx, serverID); err != nil || server.OwnerID != users["owner"] {
+		t.Errorf("Unexpected server %+v (%v)", server, err)
+	}
+	if servers, err := stores.Servers.ListForUser(ctx, users["member"], 0); err != nil || len(servers) != 1 || servers[0].ID != serverID {
+		t.Errorf("Expected the member's one server, got %+v (%v)", servers, err)
+	}
+	if members, err := stores.Servers.Members(ctx, serverID); err != nil || len(members) != 3 {
+		t.Errorf("Expected three members, got %+v (%v)", members, err)
+	}
+	if n, err := stores.Users.LastOwnedServers(ctx, users["owner"]); err != nil || n != 1 {
+		t.Errorf("Expected the owner to be the last owner of one server, got %d (%v)", n, err)
+	}
+
+	general, err := stores.Channels.Create(ctx, NewChannel{ServerID: serverID, Name: "general", ChannelType: "text"})
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	nsfw, err := stores.Channels.Create(ctx, NewChannel{ServerID: serverID, Name: "after-dark", ChannelType: "text", NSFW: true})
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+
+	for _, tc := range []struct {
+		user    string
+		visible int
+	}{
+		{"member", 2},
+		{"minor", 1},
+		{"outsider", 0},
+	} {
+		channels, err := stores.Channels.ListVisible(ctx, users[tc.user], serverID)
+		if err != nil || len(channels) != tc.visible {
+			t.Errorf("Expected %s to see %d channels, got %+v (%v)", tc.user, tc.visible, channels, err)
+		}
+	}
+	if ok, err := stores.Channels.CanView(ctx, users["member"], int(nsfw)); err != nil || !ok {
+		t.Errorf("Expected the member to see the NSFW channel, got %t (%v)", ok, err)
+	}
+	if ok, err := stores.Channels.CanView(ctx, users["minor"], int(nsfw)); err != nil || ok {
+		t.Errorf("Expected the NSFW channel hidden from the minor, got %t (%v)", ok, err)
+	}
+	if ok, err := stores.Channels.CanView(ctx, users["outsider"], int(general)); err != nil || ok {
+		t.Errorf("Expected the channel hidden from an outsider, got %t (%v)", ok, err)
+	}
+
+	result, err = db.Exec("INSERT INTO messages (channel_id, user_id, content) VALUES (?, ?, 'hello')", general, users["member"])
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	messageID, _ := result.LastInsertId()
+	if n, err := stores.Messages.CountCreatedSince(ctx, time.Hour); err != nil || n < 1 {
+		t.Errorf("Expected the message counted, got %d (%v)", n, err)
+	}
+	if err := stores.Messages.Delete(ctx, messageID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = ?", messageID).Scan(&left); err != nil || left != 0 {
+		t.Errorf("Expected the message deleted, got %d (%v)", left, err)
+	}
+}
+
+func TestStoresInTransaction(t *testing.T) {
+	_, db := newTestStores(t)
+	ctx := context.Background()
+	username := uniqueName("store_tx")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(tx).Users.Create(ctx, NewUser{Username: username, PasswordHash: "x", Role: "user"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&n); err != nil || n != 0 {
+		t.Errorf("Expected the rolled back user gone, got %d (%v)", n, err)
+	}
+}
+
+func TestAuditStore(t *testing.T) {
+	stores, _ := newTestStores(t)
+	ctx := context.Background()
+
+	adminID, err := stores.Users.Create(ctx, NewUser{Username: uniqueName("store_admin"), PasswordHash: "x", Role: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	details := uniqueName("audited")
+	if id, err := stores.Audit.Log(ctx, int(adminID), "test_action", details); err != nil || id == 0 {
+		t.Fatalf("Log failed: %d (%v)", id, err)
+	}
+	entries, err := stores.Audit.List(ctx, 50, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	found := false
+	for _, entry := range entries {
+		if entry.Details == details {
+			found = entry.AdminID == int(adminID) && entry.Action == "test_action" && entry.AdminUsername != ""
+		}
+	}
+	if !found {
+		t.Errorf("Expected the action in the log, got %+v", entries)
+	}
+}
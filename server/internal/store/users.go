@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User is an account as the handlers show it
+type User struct {
+	ID            int
+	Username      string
+	Email         string
+	Role          string
+	OrgID         int
+	EmailVerified bool
+}
+
+// UserSummary is a user as the admin user list shows it
+type UserSummary struct {
+	User
+	CreatedAt    string
+	UpdatedAt    string
+	MessageCount int
+	ServerCount  int
+}
+
+// NewUser is an account to create. PasswordHash must already be hashed.
+type NewUser struct {
+	Username      string
+	Email         string
+	PasswordHash  string
+	Role          string
+	OrgID         int
+	EmailVerified bool
+}
+
+// UserUpdate changes an account; empty fields are left as they are. A new
+// password hash also signs the user out by bumping their token version,
+// and a new email counts as verified.
+type UserUpdate struct {
+	Username     string
+	Email        string
+	PasswordHash string
+}
+
+// UserStore reads and writes accounts
+type UserStore struct {
+	db DBTX
+}
+
+// Get loads a user, or returns ErrNotFound
+func (s *UserStore) Get(ctx context.Context, userID int) (*User, error) {
+	user := &User{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, username, email, role, org_id, email_verified FROM users WHERE id = ?",
+		userID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.EmailVerified)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+// Username returns a user's name, or ErrNotFound
+func (s *UserStore) Username(ctx context.Context, userID int) (string, error) {
+	var username string
+	err := s.db.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	return username, notFound(err)
+}
+
+// List returns the users of an organization, or of every organization for
+// orgID 0, newest first
+func (s *UserStore) List(ctx context.Context, orgID int) ([]UserSummary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, username, email, role, created_at, updated_at,
+		       (SELECT COUNT(*) FROM messages WHERE user_id = users.id) as message_count,
+		       (SELECT COUNT(*) FROM server_members WHERE user_id = users.id) as server_count,
+		       org_id, email_verified
+		FROM users
+		WHERE ? = 0 OR org_id = ?
+		ORDER BY created_at DESC
+	`, orgID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserSummary
+	for rows.Next() {
+		var user UserSummary
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt,
+			&user.MessageCount, &user.ServerCount, &user.OrgID, &user.EmailVerified); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Create creates an account and returns its ID
+func (s *UserStore) Create(ctx context.Context, user NewUser) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO users (username, email, password_hash, role, org_id, email_verified) VALUES (?, ?, ?, ?, ?, ?)",
+		user.Username, user.Email, user.PasswordHash, user.Role, user.OrgID, user.EmailVerified,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// CreateGuest creates a guest account that expires at expiresAt. Its
+// password hash matches no password, so nobody can sign in as the guest.
+func (s *UserStore) CreateGuest(ctx context.Context, username string, orgID int, expiresAt time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO users (username, email, password_hash, role, created_at, org_id, guest_expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		username, "", "$2a$10$guest.user.password.hash.placeholder", "guest", time.Now(), orgID, expiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// SetEmail sets a user's email without verifying it
+func (s *UserStore) SetEmail(ctx context.Context, userID int, email string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET email = ? WHERE id = ?", email, userID)
+	return err
+}
+
+// Update applies the non-empty fields of update to a user
+func (s *UserStore) Update(ctx context.Context, userID int, update UserUpdate) error {
+	var updates []string
+	var args []interface{}
+	if update.Username != "" {
+		updates = append(updates, "username = ?")
+		args = append(args, update.Username)
+	}
+	if update.Email != "" {
+		updates = append(updates, "email = ?", "email_verified = 1")
+		args = append(args, update.Email)
+	}
+	if update.PasswordHash != "" {
+		updates = append(updates, "password_hash = ?", "token_version = token_version + 1")
+		args = append(args, update.PasswordHash)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	updates = append(updates, "updated_at = CURRENT_TIMESTAMP")
+	args = append(args, userID)
+
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = ?", strings.Join(updates, ", "))
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// SetRole changes a user's instance role
+func (s *UserStore) SetRole(ctx context.Context, userID int, role string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", role, userID)
+	return err
+}
+
+// Delete deletes a user; their related records go with them
+func (s *UserStore) Delete(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+	return err
+}
+
+// LastOwnedServers counts the servers a user is the only owner of
+func (s *UserStore) LastOwnedServers(ctx context.Context, userID int) (int, error) {
+	return count(ctx, s.db, `
+		SELECT COUNT(*) FROM server_members sm
+		WHERE sm.user_id = ? AND sm.role = 'owner' AND NOT EXISTS(
+			SELECT 1 FROM server_members o WHERE o.server_id = sm.server_id AND o.role = 'owner' AND o.user_id != sm.user_id
+		)`, userID)
+}
+
+// Count counts every user
+func (s *UserStore) Count(ctx context.Context) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM users")
+}
+
+// CountUpdatedSince counts the users updated within ago
+func (s *UserStore) CountUpdatedSince(ctx context.Context, ago time.Duration) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM users WHERE updated_at > datetime('now', ?)", sinceModifier(ago))
+}
+
+// CountCreatedSince counts the users created within ago
+func (s *UserStore) CountCreatedSince(ctx context.Context, ago time.Duration) (int, error) {
+	return count(ctx, s.db, "SELECT COUNT(*) FROM users WHERE created_at > datetime('now', ?)", sinceModifier(ago))
+}
+
+// RoleCounts counts the users holding each instance role
+func (s *UserStore) RoleCounts(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT role, COUNT(*) FROM users GROUP BY role")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var role string
+		var n int
+		if err := rows.Scan(&role, &n); err != nil {
+			return nil, err
+		}
+		counts[role] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,149 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxBinarySize bounds a downloaded binary
+const maxBinarySize = 512 << 20
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("update public key must be a base64 Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Verify checks a binary's digest against the asset's checksum and
+// signature
+func Verify(asset Asset, digest []byte, key ed25519.PublicKey) error {
+	expected, err := hex.DecodeString(asset.SHA256)
+	if err != nil || len(expected) != sha256.Size {
+		return errors.New("release has no valid sha256 checksum")
+	}
+	if !bytes.Equal(expected, digest) {
+		return errors.New("checksum mismatch")
+	}
+	signature, err := base64.StdEncoding.DecodeString(asset.Signature)
+	if err != nil || !ed25519.Verify(key, digest, signature) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// Download saves the asset next to path as path.new and verifies it. The
+// file is removed when verification fails.
+func Download(ctx context.Context, client *http.Client, asset Asset, key ed25519.PublicKey, path string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("User-Agent", "fethur/"+Version)
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download returned %s", response.Status)
+	}
+
+	// Written next to the binary so the swap is a rename on one filesystem
+	newPath := path + ".new"
+	file, err := os.OpenFile(newPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(response.Body, maxBinarySize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBinarySize {
+		err = errors.New("download is larger than 512 MiB")
+	}
+	if err == nil {
+		err = Verify(asset, hash.Sum(nil), key)
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return "", err
+	}
+	return newPath, nil
+}
+
+// Install replaces the binary at path with newPath, keeping the previous
+// one as path.old. check runs against the installed binary; when it fails
+// the previous binary is put back.
+func Install(newPath, path string, check func(path string) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(newPath, info.Mode().Perm()|0o100); err != nil {
+		return err
+	}
+
+	oldPath := path + ".old"
+	_ = os.Remove(oldPath)
+	if err := os.Rename(path, oldPath); err != nil {
+		return fmt.Errorf("failed to move the current binary aside: %w", err)
+	}
+	if err := os.Rename(newPath, path); err != nil {
+		_ = os.Rename(oldPath, path)
+		return fmt.Errorf("failed to install the new binary: %w", err)
+	}
+
+	if err := check(path); err != nil {
+		if rollbackErr := Rollback(path); rollbackErr != nil {
+			return fmt.Errorf("new binary failed to start (%v) and rollback failed: %w", err, rollbackErr)
+		}
+		return fmt.Errorf("new binary failed to start, previous version restored: %w", err)
+	}
+	return nil
+}
+
+// Rollback puts back the binary kept by the last install
+func Rollback(path string) error {
+	oldPath := path + ".old"
+	if _, err := os.Stat(oldPath); err != nil {
+		return fmt.Errorf("no previous binary at %s", oldPath)
+	}
+	_ = os.Remove(path + ".failed")
+	if err := os.Rename(path, path+".failed"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(oldPath, path); err != nil {
+		return err
+	}
+	_ = os.Remove(path + ".failed")
+	return nil
+}
+
+// Apply downloads, verifies and installs the release for this platform
+func Apply(ctx context.Context, client *http.Client, release *Release, key ed25519.PublicKey, path string, check func(path string) error) error {
+	asset, ok := release.Asset()
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s", release.Version, Platform())
+	}
+	newPath, err := Download(ctx, client, asset, key, path)
+	if err != nil {
+		return err
+	}
+	return Install(newPath, path, check)
+}
@@ -0,0 +1,216 @@
+// Package update checks a release manifest for newer versions of the
+// server and replaces the running binary with a verified download.
+//
+// The manifest at the update URL is JSON:
+//
+//	{
+//	  "version": "v1.4.0",
+//	  "published_at": "2026-10-01T12:00:00Z",
+//	  "notes_url": "https://example.com/releases/v1.4.0",
+//	  "assets": {
+//	    "linux-amd64": {
+//	      "url": "https://example.com/fethur-linux-amd64",
+//	      "sha256": "<hex digest of the binary>",
+//	      "signature": "<base64 Ed25519 signature of the raw digest>"
+//	    }
+//	  }
+//	}
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the running server's version, set at build time with
+// -ldflags "-X fethur/internal/update.Version=v1.2.3"
+var Version = "dev"
+
+// maxManifestSize bounds the release manifest
+const maxManifestSize = 1 << 20
+
+// ErrNotConfigured is returned when no update URL is set
+var ErrNotConfigured = errors.New("no update URL is configured")
+
+// Release describes the latest published version
+type Release struct {
+	Version     string           `json:"version"`
+	PublishedAt string           `json:"published_at,omitempty"`
+	NotesURL    string           `json:"notes_url,omitempty"`
+	Assets      map[string]Asset `json:"assets"`
+}
+
+// Asset is the binary for one platform
+type Asset struct {
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// Platform names the asset for the running OS and architecture
+func Platform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// Asset returns the binary for the running platform
+func (r *Release) Asset() (Asset, bool) {
+	asset, ok := r.Assets[Platform()]
+	return asset, ok && asset.URL != ""
+}
+
+// Fetch downloads and parses the release manifest
+func Fetch(ctx context.Context, client *http.Client, url string) (*Release, error) {
+	if url == "" {
+		return nil, ErrNotConfigured
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "fethur/"+Version)
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("update URL returned %s", response.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxManifestSize)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("invalid release manifest: %w", err)
+	}
+	if _, ok := parseVersion(release.Version); !ok {
+		return nil, fmt.Errorf("invalid release version %q", release.Version)
+	}
+	return &release, nil
+}
+
+// parseVersion reads vMAJOR.MINOR.PATCH, ignoring any pre-release or build
+// suffix
+func parseVersion(version string) ([3]int, bool) {
+	var parts [3]int
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	fields := strings.Split(version, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// Newer reports whether latest is a higher version than current. Builds
+// without a release version, such as "dev", are never offered updates.
+func Newer(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// Status is the outcome of the last check
+type Status struct {
+	Current         string   `json:"current"`
+	Latest          *Release `json:"latest"`
+	UpdateAvailable bool     `json:"update_available"`
+	CheckedAt       string   `json:"checked_at,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// Checker caches the latest release so admin pages do not hit the update
+// URL on every load
+type Checker struct {
+	url    string
+	maxAge time.Duration
+	client *http.Client
+
+	mutex     sync.Mutex
+	latest    *Release
+	checkedAt time.Time
+	err       error
+}
+
+// NewChecker returns a checker for the manifest at url that reuses a
+// result for maxAge
+func NewChecker(url string, maxAge time.Duration) *Checker {
+	return &Checker{
+		url:    url,
+		maxAge: maxAge,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Configured reports whether an update URL is set
+func (c *Checker) Configured() bool {
+	return c.url != ""
+}
+
+// Check fetches the manifest unless the last result is recent enough
+func (c *Checker) Check(ctx context.Context) Status {
+	c.mutex.Lock()
+	stale := c.checkedAt.IsZero() || time.Since(c.checkedAt) >= c.maxAge
+	c.mutex.Unlock()
+	if stale && c.Configured() {
+		release, err := Fetch(ctx, c.client, c.url)
+		c.mutex.Lock()
+		c.checkedAt = time.Now()
+		c.err = err
+		if err == nil {
+			c.latest = release
+		}
+		c.mutex.Unlock()
+	}
+	return c.Status()
+}
+
+// Status returns the last result without checking
+func (c *Checker) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status := Status{Current: Version, Latest: c.latest}
+	if c.latest != nil {
+		status.UpdateAvailable = Newer(c.latest.Version, Version)
+	}
+	if !c.checkedAt.IsZero() {
+		status.CheckedAt = c.checkedAt.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case !c.Configured():
+		status.Error = ErrNotConfigured.Error()
+	case c.err != nil:
+		status.Error = c.err.Error()
+	}
+	return status
+}
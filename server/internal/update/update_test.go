@@ -0,0 +1,143 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		newer           bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"2.0.0", "v1.99.99", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.2.0", "v1.3.0", false},
+		{"v1.2.1-rc.1", "v1.2.0", true},
+		{"v1.2.0", "dev", false},
+		{"latest", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if newer := Newer(tt.latest, tt.current); newer != tt.newer {
+			t.Errorf("Newer(%q, %q) = %v, expected %v", tt.latest, tt.current, newer, tt.newer)
+		}
+	}
+}
+
+// release serves a manifest for binary signed with key
+func release(t *testing.T, version string, binary []byte, key ed25519.PrivateKey) (*httptest.Server, *int) {
+	digest := sha256.Sum256(binary)
+	fetches := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest.json":
+			fetches++
+			_ = json.NewEncoder(w).Encode(Release{
+				Version: version,
+				Assets: map[string]Asset{Platform(): {
+					URL:       server.URL + "/fethur",
+					SHA256:    hex.EncodeToString(digest[:]),
+					Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:])),
+				}},
+			})
+		case "/fethur":
+			_, _ = w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+func TestChecker(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	server, fetches := release(t, "v9.0.0", []byte("binary"), key)
+
+	previous := Version
+	Version = "v1.0.0"
+	defer func() { Version = previous }()
+
+	checker := NewChecker(server.URL+"/latest.json", time.Hour)
+	if status := checker.Status(); status.UpdateAvailable || status.Latest != nil {
+		t.Fatalf("Expected no result before the first check, got %+v", status)
+	}
+	status := checker.Check(context.Background())
+	if !status.UpdateAvailable || status.Latest.Version != "v9.0.0" || status.Error != "" {
+		t.Fatalf("Expected v9.0.0 to be available, got %+v", status)
+	}
+	checker.Check(context.Background())
+	if *fetches != 1 {
+		t.Errorf("Expected the result to be cached, fetched %d times", *fetches)
+	}
+
+	if status := NewChecker("", time.Hour).Check(context.Background()); status.Error != ErrNotConfigured.Error() {
+		t.Errorf("Expected an unconfigured checker to say so, got %q", status.Error)
+	}
+}
+
+func TestApply(t *testing.T) {
+	public, key, _ := ed25519.GenerateKey(rand.Reader)
+	server, _ := release(t, "v2.0.0", []byte("new binary"), key)
+	client := server.Client()
+	latest, err := Fetch(context.Background(), client, server.URL+"/latest.json")
+	if err != nil {
+		t.Fatalf("Failed to fetch release: %v", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fethur")
+	install := func(check func(string) error, key ed25519.PublicKey) error {
+		if err := os.WriteFile(path, []byte("old binary"), 0o755); err != nil {
+			t.Fatalf("Failed to write binary: %v", err)
+		}
+		_ = os.Remove(path + ".old")
+		return Apply(context.Background(), client, latest, key, path, check)
+	}
+	contents := func(name string) string {
+		data, _ := os.ReadFile(name)
+		return string(data)
+	}
+	ok := func(string) error { return nil }
+
+	if err := install(ok, public); err != nil {
+		t.Fatalf("Failed to apply update: %v", err)
+	}
+	if contents(path) != "new binary" || contents(path+".old") != "old binary" {
+		t.Fatalf("Expected the new binary installed and the old one kept, got %q and %q", contents(path), contents(path+".old"))
+	}
+	if err := Rollback(path); err != nil || contents(path) != "old binary" {
+		t.Fatalf("Expected rollback to restore the old binary, got %q (%v)", contents(path), err)
+	}
+
+	// A binary that fails to start is rolled back
+	err = install(func(string) error { return errors.New("exit status 2") }, public)
+	if err == nil || !strings.Contains(err.Error(), "previous version restored") || contents(path) != "old binary" {
+		t.Fatalf("Expected a failed start to roll back, got %q (%v)", contents(path), err)
+	}
+
+	// Releases signed with another key are refused before installing
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := install(ok, other); err == nil || contents(path) != "old binary" {
+		t.Fatalf("Expected a bad signature to be refused, got %q (%v)", contents(path), err)
+	}
+	if _, err := os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Error("Expected the rejected download to be removed")
+	}
+}
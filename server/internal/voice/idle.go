@@ -0,0 +1,240 @@
+package voice
+
+import (
+	"log"
+	"time"
+
+	"fethur/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idle disconnect reasons
+const (
+	IdleReasonAlone     = "alone"
+	IdleReasonMutedIdle = "muted_idle"
+)
+
+// IdlePolicy configures automatic disconnection of idle voice users.
+// A zero timeout disables the corresponding rule.
+type IdlePolicy struct {
+	AloneTimeout     time.Duration // disconnect users alone in a channel for this long
+	MutedIdleTimeout time.Duration // disconnect muted users with no activity for this long
+	WarningLead      time.Duration // how long before disconnecting to send a warning
+	CheckInterval    time.Duration // how often the hub evaluates the policy
+}
+
+// DefaultIdlePolicy returns a policy with both rules disabled
+func DefaultIdlePolicy() IdlePolicy {
+	return IdlePolicy{
+		WarningLead:   time.Minute,
+		CheckInterval: 30 * time.Second,
+	}
+}
+
+// Enabled reports whether any idle rule is active
+func (p IdlePolicy) Enabled() bool {
+	return p.AloneTimeout > 0 || p.MutedIdleTimeout > 0
+}
+
+// idleStats tracks sessions reclaimed by the idle policy
+type idleStats struct {
+	warnings    int
+	disconnects map[string]int // reason -> count
+}
+
+// SetIdlePolicy updates the idle disconnect policy
+func (h *VoiceHub) SetIdlePolicy(policy IdlePolicy) {
+	if policy.CheckInterval <= 0 {
+		policy.CheckInterval = DefaultIdlePolicy().CheckInterval
+	}
+
+	h.mutex.Lock()
+	h.idlePolicy = policy
+	h.mutex.Unlock()
+
+	h.idleTicker.Reset(policy.CheckInterval)
+
+	log.Printf("Voice idle policy updated: alone=%v, muted_idle=%v, warning=%v",
+		policy.AloneTimeout, policy.MutedIdleTimeout, policy.WarningLead)
+}
+
+// IdlePolicy returns the current idle disconnect policy
+func (h *VoiceHub) IdlePolicy() IdlePolicy {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.idlePolicy
+}
+
+// idleCandidate is a client that has crossed a warning or disconnect threshold
+type idleCandidate struct {
+	client  *VoiceClient
+	reason  string
+	elapsed time.Duration
+	timeout time.Duration
+}
+
+// checkIdleClients applies the idle policy to every client in a voice channel
+func (h *VoiceHub) checkIdleClients() {
+	h.mutex.RLock()
+	policy := h.idlePolicy
+	channels := make([]*VoiceChannel, 0, len(h.channels))
+	for _, channel := range h.channels {
+		channels = append(channels, channel)
+	}
+	h.mutex.RUnlock()
+
+	if !policy.Enabled() {
+		return
+	}
+
+	now := time.Now()
+	var candidates []idleCandidate
+
+	for _, channel := range channels {
+		channel.mutex.Lock()
+		if len(channel.Clients) == 1 {
+			if channel.aloneSince.IsZero() {
+				channel.aloneSince = now
+			}
+		} else {
+			channel.aloneSince = time.Time{}
+		}
+		aloneSince := channel.aloneSince
+
+		for _, client := range channel.Clients {
+			client.mutex.RLock()
+			muted := client.isMuted
+			lastActivity := client.lastActivity
+			client.mutex.RUnlock()
+
+			if policy.AloneTimeout > 0 && !aloneSince.IsZero() {
+				candidates = append(candidates, idleCandidate{
+					client:  client,
+					reason:  IdleReasonAlone,
+					elapsed: now.Sub(aloneSince),
+					timeout: policy.AloneTimeout,
+				})
+				continue
+			}
+
+			if policy.MutedIdleTimeout > 0 && muted {
+				candidates = append(candidates, idleCandidate{
+					client:  client,
+					reason:  IdleReasonMutedIdle,
+					elapsed: now.Sub(lastActivity),
+					timeout: policy.MutedIdleTimeout,
+				})
+				continue
+			}
+
+			// Client no longer matches any rule, so clear a pending warning
+			client.mutex.Lock()
+			client.idleWarning = ""
+			client.mutex.Unlock()
+		}
+		channel.mutex.Unlock()
+	}
+
+	for _, candidate := range candidates {
+		h.applyIdleRule(candidate, policy.WarningLead)
+	}
+}
+
+// applyIdleRule warns or disconnects a single idle candidate
+func (h *VoiceHub) applyIdleRule(candidate idleCandidate, warningLead time.Duration) {
+	client := candidate.client
+
+	client.mutex.Lock()
+	warnedFor := client.idleWarning
+	if warnedFor != "" && warnedFor != candidate.reason {
+		// The reason changed since the warning, start over
+		warnedFor = ""
+		client.idleWarning = ""
+	}
+	client.mutex.Unlock()
+
+	if candidate.elapsed >= candidate.timeout {
+		h.disconnectIdleClient(client, candidate.reason)
+		return
+	}
+
+	if warnedFor == "" && candidate.elapsed >= candidate.timeout-warningLead {
+		remaining := candidate.timeout - candidate.elapsed
+
+		client.mutex.Lock()
+		client.idleWarning = candidate.reason
+		channelID := client.channelID
+		serverID := client.serverID
+		client.mutex.Unlock()
+
+		client.sendMessage(&VoiceMessage{
+			Type:      events.TypeIdleWarning,
+			ChannelID: channelID,
+			ServerID:  serverID,
+			UserID:    client.ID,
+			Username:  client.Username,
+			Data: events.IdleWarning{
+				Reason:           candidate.reason,
+				DisconnectInSecs: int(remaining.Seconds()),
+			},
+			Timestamp: time.Now(),
+		})
+
+		h.mutex.Lock()
+		h.idleStats.warnings++
+		h.mutex.Unlock()
+
+		log.Printf("Voice idle warning sent to user %d (%s), disconnect in %v", client.ID, candidate.reason, remaining)
+	}
+}
+
+// disconnectIdleClient removes an idle client from its voice channel
+func (h *VoiceHub) disconnectIdleClient(client *VoiceClient, reason string) {
+	client.mutex.Lock()
+	channelID := client.channelID
+	serverID := client.serverID
+	client.idleWarning = ""
+	client.mutex.Unlock()
+
+	if channelID == 0 {
+		return
+	}
+
+	client.sendMessage(&VoiceMessage{
+		Type:      events.TypeIdleDisconnect,
+		ChannelID: channelID,
+		ServerID:  serverID,
+		UserID:    client.ID,
+		Username:  client.Username,
+		Data:      events.IdleDisconnect{Reason: reason},
+		Timestamp: time.Now(),
+	})
+
+	h.handleLeaveChannelDirectly(client)
+
+	h.mutex.Lock()
+	h.idleStats.disconnects[reason]++
+	h.mutex.Unlock()
+
+	log.Printf("Voice client %d disconnected from channel %d for being idle (%s)", client.ID, channelID, reason)
+}
+
+// idleStatsSnapshot returns idle policy stats for admin reporting. Caller must hold h.mutex.
+func (h *VoiceHub) idleStatsSnapshot() gin.H {
+	disconnects := make(map[string]int, len(h.idleStats.disconnects))
+	total := 0
+	for reason, count := range h.idleStats.disconnects {
+		disconnects[reason] = count
+		total += count
+	}
+
+	return gin.H{
+		"enabled":                   h.idlePolicy.Enabled(),
+		"alone_timeout_secs":        int(h.idlePolicy.AloneTimeout.Seconds()),
+		"muted_idle_timeout_secs":   int(h.idlePolicy.MutedIdleTimeout.Seconds()),
+		"warnings_sent":             h.idleStats.warnings,
+		"reclaimed_sessions":        total,
+		"reclaimed_sessions_reason": disconnects,
+	}
+}
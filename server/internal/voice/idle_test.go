@@ -0,0 +1,115 @@
+package voice
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestClient(hub *VoiceHub, id int64, channel *VoiceChannel) *VoiceClient {
+	client := &VoiceClient{
+		ID:           id,
+		Username:     "user",
+		send:         make(chan []byte, 16),
+		lastActivity: time.Now(),
+		hub:          hub,
+		channelID:    channel.ID,
+	}
+	channel.Clients[id] = client
+	hub.clients[id] = client
+	return client
+}
+
+func drainTypes(client *VoiceClient) []string {
+	var types []string
+	for {
+		select {
+		case raw := <-client.send:
+			var msg VoiceMessage
+			if err := json.Unmarshal(raw, &msg); err == nil {
+				types = append(types, string(msg.Type))
+			}
+		default:
+			return types
+		}
+	}
+}
+
+func TestIdlePolicyAloneWarnsThenDisconnects(t *testing.T) {
+	hub := NewVoiceHub()
+	channel := &VoiceChannel{ID: 1, Clients: make(map[int64]*VoiceClient)}
+	hub.channels[1] = channel
+	client := newTestClient(hub, 1, channel)
+
+	hub.SetIdlePolicy(IdlePolicy{AloneTimeout: time.Minute, WarningLead: 30 * time.Second})
+
+	// Alone for 45s: inside the warning window
+	channel.aloneSince = time.Now().Add(-45 * time.Second)
+	hub.checkIdleClients()
+
+	if types := drainTypes(client); len(types) != 1 || types[0] != "idle-warning" {
+		t.Fatalf("Expected a single idle-warning, got %v", types)
+	}
+
+	// Alone past the timeout: disconnected
+	channel.aloneSince = time.Now().Add(-2 * time.Minute)
+	hub.checkIdleClients()
+
+	if types := drainTypes(client); len(types) != 1 || types[0] != "idle-disconnect" {
+		t.Fatalf("Expected a single idle-disconnect, got %v", types)
+	}
+
+	if client.channelID != 0 {
+		t.Errorf("Expected client to have left the channel, still in %d", client.channelID)
+	}
+
+	if _, exists := hub.channels[1]; exists {
+		t.Error("Expected empty channel to be removed")
+	}
+
+	if got := hub.idleStats.disconnects[IdleReasonAlone]; got != 1 {
+		t.Errorf("Expected 1 reclaimed session, got %d", got)
+	}
+}
+
+func TestIdlePolicyMutedIdle(t *testing.T) {
+	hub := NewVoiceHub()
+	channel := &VoiceChannel{ID: 2, Clients: make(map[int64]*VoiceClient)}
+	hub.channels[2] = channel
+	muted := newTestClient(hub, 1, channel)
+	active := newTestClient(hub, 2, channel)
+
+	hub.SetIdlePolicy(IdlePolicy{MutedIdleTimeout: time.Minute, WarningLead: 10 * time.Second})
+
+	muted.isMuted = true
+	muted.lastActivity = time.Now().Add(-5 * time.Minute)
+	active.lastActivity = time.Now().Add(-5 * time.Minute)
+	hub.checkIdleClients()
+
+	if muted.channelID != 0 {
+		t.Error("Expected muted idle client to be disconnected")
+	}
+	if active.channelID != 2 {
+		t.Error("Expected unmuted client to remain connected")
+	}
+
+	// The remaining client is notified the muted user left
+	types := drainTypes(active)
+	if len(types) != 1 || types[0] != "user-left" {
+		t.Errorf("Expected user-left notification, got %v", types)
+	}
+}
+
+func TestIdlePolicyDisabled(t *testing.T) {
+	hub := NewVoiceHub()
+	channel := &VoiceChannel{ID: 3, Clients: make(map[int64]*VoiceClient)}
+	hub.channels[3] = channel
+	client := newTestClient(hub, 1, channel)
+
+	channel.aloneSince = time.Now().Add(-time.Hour)
+	hub.checkIdleClients()
+
+	if client.channelID != 3 {
+		t.Error("Expected no disconnect when the idle policy is disabled")
+	}
+}
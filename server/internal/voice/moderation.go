@@ -0,0 +1,71 @@
+package voice
+
+import (
+	"log"
+	"time"
+
+	"fethur/internal/events"
+)
+
+// forcedDisconnect is a request from the moderation layer to drop a user from voice
+type forcedDisconnect struct {
+	userID  int64
+	session string // only drop the socket of this login session, if set
+	reason  string
+	action  string
+}
+
+// DisconnectUser force-leaves a user from their voice channel and closes their voice socket.
+// It is safe to call from any goroutine; the work is done on the hub's goroutine.
+func (h *VoiceHub) DisconnectUser(userID int64, action, reason string) {
+	select {
+	case h.kicks <- &forcedDisconnect{userID: userID, action: action, reason: reason}:
+	default:
+		log.Printf("Warning: voice kick channel full, dropping forced disconnect for user %d", userID)
+	}
+}
+
+// DisconnectSession is DisconnectUser for a voice socket opened with one
+// login session; sockets of the user's other sessions stay connected.
+func (h *VoiceHub) DisconnectSession(userID int64, session, action, reason string) {
+	select {
+	case h.kicks <- &forcedDisconnect{userID: userID, session: session, action: action, reason: reason}:
+	default:
+		log.Printf("Warning: voice kick channel full, dropping forced disconnect for user %d", userID)
+	}
+}
+
+// handleForcedDisconnect processes a forced disconnect on the hub goroutine
+func (h *VoiceHub) handleForcedDisconnect(kick *forcedDisconnect) {
+	h.mutex.RLock()
+	client, exists := h.clients[kick.userID]
+	h.mutex.RUnlock()
+
+	if !exists || (kick.session != "" && client.session != kick.session) {
+		return
+	}
+
+	client.mutex.RLock()
+	channelID := client.channelID
+	serverID := client.serverID
+	client.mutex.RUnlock()
+
+	// Tell the client why before the socket goes away
+	client.sendMessage(&VoiceMessage{
+		Type:      events.TypeForceDisconnect,
+		ChannelID: channelID,
+		ServerID:  serverID,
+		UserID:    client.ID,
+		Username:  client.Username,
+		Data:      events.ForceDisconnect{Action: kick.action, Reason: kick.reason},
+		Timestamp: time.Now(),
+	})
+
+	if channelID != 0 {
+		h.handleLeaveChannelDirectly(client)
+	}
+
+	h.handleUnregister(client)
+
+	log.Printf("Voice client %d forcibly disconnected (%s)", kick.userID, kick.action)
+}
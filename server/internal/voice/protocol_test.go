@@ -0,0 +1,132 @@
+package voice
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// protocolVector is a signaling frame a client may send and what the hub
+// makes of it
+type protocolVector struct {
+	Name    string        `json:"name"`
+	Frame   string        `json:"frame"`
+	Message *VoiceMessage `json:"message"` // the decoded frame
+	Error   bool          `json:"error"`   // the frame is refused
+	Reply   string        `json:"reply"`   // type of the frame sent back, if any
+	Relayed string        `json:"relayed"` // type of the frame passed to the peer, if any
+}
+
+func loadProtocolVectors(t testing.TB) []protocolVector {
+	data, err := os.ReadFile("testdata/protocol.json")
+	if err != nil {
+		t.Fatalf("Failed to read protocol vectors: %v", err)
+	}
+	var vectors []protocolVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Failed to parse protocol vectors: %v", err)
+	}
+	return vectors
+}
+
+// handleFrame runs a frame through the hub for user 1 the way readPump
+// and Run would
+func handleFrame(hub *VoiceHub, frame []byte) (*VoiceMessage, error) {
+	message, err := decodeVoiceMessage(frame)
+	if err != nil {
+		return nil, err
+	}
+	message.UserID = 1
+	message.Username = "user"
+	hub.handleMessage(message)
+	return message, nil
+}
+
+func firstType(t *testing.T, client *VoiceClient) string {
+	select {
+	case frame := <-client.send:
+		var message VoiceMessage
+		if err := json.Unmarshal(frame, &message); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+		return string(message.Type)
+	default:
+		return ""
+	}
+}
+
+func TestVoiceProtocolVectors(t *testing.T) {
+	for _, vector := range loadProtocolVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			message, err := decodeVoiceMessage([]byte(vector.Frame))
+			if vector.Error {
+				if err == nil {
+					t.Errorf("Expected the frame to be refused, got %+v", message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode frame: %v", err)
+			}
+			if !reflect.DeepEqual(message, vector.Message) {
+				t.Errorf("Expected %+v, got %+v", vector.Message, message)
+			}
+
+			hub := NewVoiceHub()
+			client := &VoiceClient{ID: 1, Username: "user", send: make(chan []byte, 16), hub: hub}
+			peer := &VoiceClient{ID: 2, Username: "peer", send: make(chan []byte, 16), hub: hub}
+			hub.clients[1], hub.clients[2] = client, peer
+
+			if _, err := handleFrame(hub, []byte(vector.Frame)); err != nil {
+				t.Fatalf("Failed to handle frame: %v", err)
+			}
+			if got := firstType(t, client); got != vector.Reply {
+				t.Errorf("Expected reply %q, got %q", vector.Reply, got)
+			}
+			if got := firstType(t, peer); got != vector.Relayed {
+				t.Errorf("Expected relayed %q, got %q", vector.Relayed, got)
+			}
+		})
+	}
+}
+
+func TestSpeakingRequiresBoolean(t *testing.T) {
+	hub := NewVoiceHub()
+	client := &VoiceClient{ID: 1, Username: "user", send: make(chan []byte, 16), hub: hub}
+	hub.clients[1] = client
+
+	if _, err := handleFrame(hub, []byte(`{"type":"speaking","data":true}`)); err != nil {
+		t.Fatalf("Failed to handle frame: %v", err)
+	}
+	if _, err := handleFrame(hub, []byte(`{"type":"speaking","data":{"speaking":false}}`)); err != nil {
+		t.Fatalf("Failed to handle frame: %v", err)
+	}
+	if !client.isSpeaking {
+		t.Error("Expected a malformed speaking frame to leave the state alone")
+	}
+}
+
+func FuzzVoiceMessage(f *testing.F) {
+	for _, vector := range loadProtocolVectors(f) {
+		f.Add([]byte(vector.Frame))
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		hub := NewVoiceHub()
+		client := &VoiceClient{ID: 1, Username: "user", send: make(chan []byte, 16), hub: hub}
+		hub.clients[1] = client
+
+		// Twice, so frames also meet the state the first one left behind
+		if _, err := handleFrame(hub, frame); err != nil {
+			return
+		}
+		if _, err := handleFrame(hub, frame); err != nil {
+			t.Fatalf("Expected the same frame to decode again: %v", err)
+		}
+	})
+}
@@ -2,12 +2,16 @@ package voice
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"fethur/internal/events"
+	"fethur/internal/wscompress"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -18,9 +22,13 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// VoiceMessage represents a WebRTC signaling message
+// maxVoiceMessageSize is the largest signaling frame a client may send
+const maxVoiceMessageSize = 64 << 10
+
+// VoiceMessage represents a WebRTC signaling message. It is sent as an
+// events.Event envelope, whose fields it shares.
 type VoiceMessage struct {
-	Type      string      `json:"type"`
+	Type      events.Type `json:"type"`
 	ChannelID int64       `json:"channel_id"`
 	ServerID  int64       `json:"server_id"`
 	UserID    int64       `json:"user_id"`
@@ -30,6 +38,39 @@ type VoiceMessage struct {
 	Timestamp time.Time   `json:"timestamp"`
 }
 
+// Event converts the message to the shared event envelope
+func (m VoiceMessage) Event() events.Event {
+	return events.Event{
+		Type:      m.Type,
+		ChannelID: m.ChannelID,
+		ServerID:  m.ServerID,
+		UserID:    m.UserID,
+		Username:  m.Username,
+		TargetID:  m.TargetID,
+		Data:      m.Data,
+		Timestamp: m.Timestamp,
+	}
+}
+
+// VoiceMessageFromEvent converts a shared event envelope to a message
+func VoiceMessageFromEvent(event events.Event) *VoiceMessage {
+	return &VoiceMessage{
+		Type:      event.Type,
+		ChannelID: event.ChannelID,
+		ServerID:  event.ServerID,
+		UserID:    event.UserID,
+		Username:  event.Username,
+		TargetID:  event.TargetID,
+		Data:      event.Data,
+		Timestamp: event.Timestamp,
+	}
+}
+
+// MarshalJSON encodes the message as a versioned event
+func (m VoiceMessage) MarshalJSON() ([]byte, error) {
+	return events.Encode(m.Event())
+}
+
 // VoiceClient represents a connected voice client
 type VoiceClient struct {
 	ID         int64
@@ -46,6 +87,11 @@ type VoiceClient struct {
 	hub        *VoiceHub
 	mutex      sync.RWMutex
 	ready      chan bool // Signal when writePump is ready
+
+	lastActivity time.Time // last non-keepalive message from the client
+	idleWarning  string    // idle reason the client has been warned about
+	compression  wscompress.Config
+	session      string // login session (device) the socket authenticated with
 }
 
 // VoiceChannel represents a voice channel
@@ -55,6 +101,8 @@ type VoiceChannel struct {
 	Name     string
 	Clients  map[int64]*VoiceClient // userID -> client
 	mutex    sync.RWMutex
+
+	aloneSince time.Time // when the channel dropped to a single client
 }
 
 // VoiceHub manages all voice connections
@@ -64,25 +112,104 @@ type VoiceHub struct {
 	register   chan *VoiceClient
 	unregister chan *VoiceClient
 	messages   chan *VoiceMessage
+	kicks      chan *forcedDisconnect
 	mutex      sync.RWMutex
+
+	idlePolicy IdlePolicy
+	idleStats  idleStats
+	idleTicker *time.Ticker
+
+	compression wscompress.Config
+
+	validateChannel ChannelValidator
+	redirectJoin    JoinRedirect
+	channelEmptied  func(channelID int64)
+}
+
+// ErrChannelFull is returned by a ChannelValidator when the channel has
+// reached its user limit
+var ErrChannelFull = errors.New("voice channel is full")
+
+// ChannelValidator checks that a user may join a voice channel and returns
+// the server the channel belongs to
+type ChannelValidator func(userID, channelID int64) (serverID int64, err error)
+
+// JoinRedirect picks the channel a join actually goes to, such as a fresh
+// channel for a join-to-create channel. It runs after validation.
+type JoinRedirect func(userID, channelID int64) (targetID int64, err error)
+
+// SetCompression changes how new voice connections are compressed
+func (h *VoiceHub) SetCompression(config wscompress.Config) {
+	h.mutex.Lock()
+	h.compression = config
+	h.mutex.Unlock()
+}
+
+// Compression returns how new voice connections are compressed
+func (h *VoiceHub) Compression() wscompress.Config {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.compression
+}
+
+// SetChannelValidator installs the check run before a user joins a voice channel
+func (h *VoiceHub) SetChannelValidator(validator ChannelValidator) {
+	h.mutex.Lock()
+	h.validateChannel = validator
+	h.mutex.Unlock()
+}
+
+// SetJoinRedirect installs the hook that can send a join to another channel
+func (h *VoiceHub) SetJoinRedirect(redirect JoinRedirect) {
+	h.mutex.Lock()
+	h.redirectJoin = redirect
+	h.mutex.Unlock()
+}
+
+// SetChannelEmptiedHandler installs a callback run, on its own goroutine,
+// when the last user leaves a voice channel
+func (h *VoiceHub) SetChannelEmptiedHandler(handler func(channelID int64)) {
+	h.mutex.Lock()
+	h.channelEmptied = handler
+	h.mutex.Unlock()
+}
+
+// notifyEmptied runs the emptied handler; callers hold h.mutex
+func (h *VoiceHub) notifyEmptied(channelID int64) {
+	if h.channelEmptied != nil {
+		go h.channelEmptied(channelID)
+	}
 }
 
 // NewVoiceHub creates a new voice hub
 func NewVoiceHub() *VoiceHub {
+	policy := DefaultIdlePolicy()
+
 	return &VoiceHub{
-		clients:    make(map[int64]*VoiceClient),
-		channels:   make(map[int64]*VoiceChannel),
-		register:   make(chan *VoiceClient, 100),
-		unregister: make(chan *VoiceClient, 100),
-		messages:   make(chan *VoiceMessage, 1000),
+		clients:     make(map[int64]*VoiceClient),
+		channels:    make(map[int64]*VoiceChannel),
+		register:    make(chan *VoiceClient, 100),
+		unregister:  make(chan *VoiceClient, 100),
+		messages:    make(chan *VoiceMessage, 1000),
+		kicks:       make(chan *forcedDisconnect, 100),
+		idlePolicy:  policy,
+		compression: wscompress.Default(),
+		idleStats: idleStats{
+			disconnects: make(map[string]int),
+		},
+		idleTicker: time.NewTicker(policy.CheckInterval),
 	}
 }
 
 // Run starts the voice hub
 func (h *VoiceHub) Run() {
 	log.Printf("Voice hub started")
+	defer h.idleTicker.Stop()
+
 	for {
 		select {
+		case <-h.idleTicker.C:
+			h.checkIdleClients()
 		case client := <-h.register:
 			log.Printf("Voice hub: processing register for user %d", client.ID)
 			h.handleRegister(client)
@@ -92,6 +219,9 @@ func (h *VoiceHub) Run() {
 		case message := <-h.messages:
 			log.Printf("Voice hub: processing message type=%s from user=%d", message.Type, message.UserID)
 			h.handleMessage(message)
+		case kick := <-h.kicks:
+			log.Printf("Voice hub: processing forced disconnect for user %d", kick.userID)
+			h.handleForcedDisconnect(kick)
 		}
 	}
 }
@@ -99,7 +229,7 @@ func (h *VoiceHub) Run() {
 // HandleWebSocket handles WebSocket connections for voice
 func (h *VoiceHub) HandleWebSocket(c *gin.Context) {
 	log.Printf("=== VOICE WEBSOCKET CONNECTION ATTEMPT ===")
-	log.Printf("Remote address: %s", c.Request.RemoteAddr)
+	log.Printf("Remote address: %s", c.ClientIP())
 
 	// Extract user info from JWT token
 	userID := c.GetInt("user_id")
@@ -114,26 +244,32 @@ func (h *VoiceHub) HandleWebSocket(c *gin.Context) {
 	}
 
 	// Upgrade to WebSocket
+	compression := h.Compression()
+	upgrader := compression.Upgrader(upgrader)
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade voice WebSocket: %v", err)
 		return
 	}
+	compression.Configure(conn)
 
 	log.Printf("Voice WebSocket upgraded successfully for user %d", userID)
 
 	// Create voice client
 	client := &VoiceClient{
-		ID:         int64(userID),
-		Username:   username,
-		conn:       conn,
-		send:       make(chan []byte, 256),
-		lastSeen:   time.Now(),
-		hub:        h,
-		isMuted:    false,
-		isDeafened: false,
-		isSpeaking: false,
-		ready:      make(chan bool, 1), // Initialize ready channel
+		ID:           int64(userID),
+		Username:     username,
+		conn:         conn,
+		compression:  compression,
+		send:         make(chan []byte, 256),
+		lastSeen:     time.Now(),
+		lastActivity: time.Now(),
+		hub:          h,
+		isMuted:      false,
+		isDeafened:   false,
+		isSpeaking:   false,
+		ready:        make(chan bool, 1), // Initialize ready channel
+		session:      c.GetString("device_id"),
 	}
 
 	log.Printf("Voice client created for user %d", userID)
@@ -204,7 +340,7 @@ func (h *VoiceHub) handleRegister(client *VoiceClient) {
 
 	// Create the connected message
 	connectedMessage := &VoiceMessage{
-		Type:      "connected",
+		Type:      events.TypeConnected,
 		UserID:    client.ID,
 		Username:  client.Username,
 		Timestamp: time.Now(),
@@ -279,7 +415,7 @@ func (h *VoiceHub) handleUnregister(client *VoiceClient) {
 
 		// Notify other clients in channel
 		h.broadcastToChannel(channelID, &VoiceMessage{
-			Type:      "user-left",
+			Type:      events.TypeUserLeft,
 			ChannelID: channelID,
 			UserID:    client.ID,
 			Username:  client.Username,
@@ -290,6 +426,7 @@ func (h *VoiceHub) handleUnregister(client *VoiceClient) {
 		if clientCount == 0 {
 			h.mutex.Lock()
 			delete(h.channels, channelID)
+			h.notifyEmptied(channelID)
 			h.mutex.Unlock()
 			log.Printf("Removed empty voice channel %d", channelID)
 		}
@@ -314,22 +451,26 @@ func (h *VoiceHub) handleUnregister(client *VoiceClient) {
 func (h *VoiceHub) handleMessage(message *VoiceMessage) {
 	log.Printf("Processing voice message: type=%s, user=%d, channel=%d", message.Type, message.UserID, message.ChannelID)
 
+	if message.Type != events.TypePing && message.Type != events.TypePong {
+		h.touchActivity(message.UserID)
+	}
+
 	switch message.Type {
-	case "join-channel":
+	case events.TypeJoinChannel:
 		log.Printf("Handling join-channel for user %d, channel %d", message.UserID, message.ChannelID)
 		h.handleJoinChannel(message)
-	case "leave-channel":
+	case events.TypeLeaveChannel:
 		log.Printf("Handling leave-channel for user %d, channel %d", message.UserID, message.ChannelID)
 		h.handleLeaveChannel(message)
-	case "offer", "answer", "ice-candidate":
+	case events.TypeOffer, events.TypeAnswer, events.TypeICECandidate:
 		h.relayToTarget(message)
-	case "mute", "unmute", "deafen", "undeafen":
+	case events.TypeMute, events.TypeUnmute, events.TypeDeafen, events.TypeUndeafen:
 		h.handleVoiceStateChange(message)
-	case "speaking":
+	case events.TypeSpeaking:
 		h.handleSpeaking(message)
-	case "ping":
+	case events.TypePing:
 		h.handlePing(message)
-	case "pong":
+	case events.TypePong:
 		// Handle pong response (no action needed, just acknowledge)
 		break
 	default:
@@ -337,6 +478,24 @@ func (h *VoiceHub) handleMessage(message *VoiceMessage) {
 	}
 }
 
+// touchActivity records user activity for the idle policy
+func (h *VoiceHub) touchActivity(userID int64) {
+	h.mutex.RLock()
+	client, exists := h.clients[userID]
+	h.mutex.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	client.mutex.Lock()
+	client.lastActivity = time.Now()
+	if client.idleWarning == IdleReasonMutedIdle {
+		client.idleWarning = ""
+	}
+	client.mutex.Unlock()
+}
+
 // handleJoinChannel handles joining a voice channel
 func (h *VoiceHub) handleJoinChannel(message *VoiceMessage) {
 	log.Printf("handleJoinChannel: Starting for user %d, channel %d", message.UserID, message.ChannelID)
@@ -353,6 +512,50 @@ func (h *VoiceHub) handleJoinChannel(message *VoiceMessage) {
 
 	log.Printf("handleJoinChannel: Found client %d, proceeding with join", message.UserID)
 
+	// Validate the channel against the channels table
+	h.mutex.RLock()
+	validate := h.validateChannel
+	redirect := h.redirectJoin
+	h.mutex.RUnlock()
+
+	if validate != nil {
+		serverID, err := validate(message.UserID, message.ChannelID)
+		if err != nil {
+			log.Printf("handleJoinChannel: user %d denied joining channel %d: %v", message.UserID, message.ChannelID, err)
+			code, text := "invalid_channel", "Voice channel not found"
+			if errors.Is(err, ErrChannelFull) {
+				code, text = "channel_full", "Voice channel is full"
+			}
+			client.sendMessage(&VoiceMessage{
+				Type:      events.TypeError,
+				ChannelID: message.ChannelID,
+				UserID:    message.UserID,
+				Username:  message.Username,
+				Data:      events.ErrorData{Code: code, Message: text},
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		message.ServerID = serverID
+	}
+
+	if redirect != nil {
+		targetID, err := redirect(message.UserID, message.ChannelID)
+		if err != nil {
+			log.Printf("handleJoinChannel: failed to route user %d from channel %d: %v", message.UserID, message.ChannelID, err)
+			client.sendMessage(&VoiceMessage{
+				Type:      events.TypeError,
+				ChannelID: message.ChannelID,
+				UserID:    message.UserID,
+				Username:  message.Username,
+				Data:      events.ErrorData{Code: "channel_unavailable", Message: "Could not create a voice channel"},
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		message.ChannelID = targetID
+	}
+
 	// Leave current channel if any (without hub mutex lock)
 	if client.channelID != 0 {
 		log.Printf("handleJoinChannel: User %d leaving current channel %d", message.UserID, client.channelID)
@@ -387,7 +590,7 @@ func (h *VoiceHub) handleJoinChannel(message *VoiceMessage) {
 
 	// Notify other clients in channel
 	h.broadcastToChannel(message.ChannelID, &VoiceMessage{
-		Type:      "user-joined",
+		Type:      events.TypeUserJoined,
 		ChannelID: message.ChannelID,
 		UserID:    message.UserID,
 		Username:  message.Username,
@@ -396,15 +599,15 @@ func (h *VoiceHub) handleJoinChannel(message *VoiceMessage) {
 
 	// Send channel info to joining client
 	channelJoinedMessage := &VoiceMessage{
-		Type:      "channel-joined",
+		Type:      events.TypeChannelJoined,
 		ChannelID: message.ChannelID,
 		UserID:    message.UserID,
 		Username:  message.Username,
-		Data: gin.H{
-			"channel_id":   message.ChannelID,
-			"server_id":    message.ServerID,
-			"channel_name": channel.Name,
-			"clients":      h.getChannelClients(message.ChannelID),
+		Data: events.ChannelJoined{
+			ChannelID:   message.ChannelID,
+			ServerID:    message.ServerID,
+			ChannelName: channel.Name,
+			Clients:     h.getChannelClients(message.ChannelID),
 		},
 		Timestamp: time.Now(),
 	}
@@ -433,11 +636,12 @@ func (h *VoiceHub) handleLeaveChannelDirectly(client *VoiceClient) {
 	// Remove client from channel
 	channel.mutex.Lock()
 	delete(channel.Clients, client.ID)
+	clientCount := len(channel.Clients)
 	channel.mutex.Unlock()
 
 	// Notify other clients in channel
 	h.broadcastToChannel(client.channelID, &VoiceMessage{
-		Type:      "user-left",
+		Type:      events.TypeUserLeft,
 		ChannelID: client.channelID,
 		UserID:    client.ID,
 		Username:  client.Username,
@@ -445,8 +649,11 @@ func (h *VoiceHub) handleLeaveChannelDirectly(client *VoiceClient) {
 	}, client.ID)
 
 	// Remove empty channels
-	if len(channel.Clients) == 0 {
+	if clientCount == 0 {
+		h.mutex.Lock()
 		delete(h.channels, client.channelID)
+		h.notifyEmptied(client.channelID)
+		h.mutex.Unlock()
 		log.Printf("Removed empty voice channel %d", client.channelID)
 	}
 
@@ -459,53 +666,18 @@ func (h *VoiceHub) handleLeaveChannelDirectly(client *VoiceClient) {
 	log.Printf("User %d left voice channel %d", client.ID, client.channelID)
 }
 
-// handleLeaveChannel handles leaving a voice channel
+// handleLeaveChannel handles leaving a voice channel. The work is done by
+// handleLeaveChannelDirectly, which takes the hub lock only briefly so the
+// user-left broadcast can look the channel up.
 func (h *VoiceHub) handleLeaveChannel(message *VoiceMessage) {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-
+	h.mutex.RLock()
 	client, exists := h.clients[message.UserID]
-	if !exists {
-		return
-	}
-
-	if client.channelID == 0 {
-		return
-	}
+	h.mutex.RUnlock()
 
-	channel, exists := h.channels[client.channelID]
 	if !exists {
 		return
 	}
-
-	// Remove from channel
-	channel.mutex.Lock()
-	delete(channel.Clients, client.ID)
-	clientCount := len(channel.Clients)
-	channel.mutex.Unlock()
-
-	// Update client
-	client.mutex.Lock()
-	client.channelID = 0
-	client.serverID = 0
-	client.mutex.Unlock()
-
-	// Notify other clients
-	h.broadcastToChannel(message.ChannelID, &VoiceMessage{
-		Type:      "user-left",
-		ChannelID: message.ChannelID,
-		UserID:    message.UserID,
-		Username:  message.Username,
-		Timestamp: time.Now(),
-	}, message.UserID)
-
-	// Remove empty channels
-	if clientCount == 0 {
-		delete(h.channels, message.ChannelID)
-		log.Printf("Removed empty voice channel %d", message.ChannelID)
-	}
-
-	log.Printf("User %d left voice channel %d", message.UserID, message.ChannelID)
+	h.handleLeaveChannelDirectly(client)
 }
 
 // handleVoiceStateChange handles mute/deafen state changes
@@ -520,13 +692,13 @@ func (h *VoiceHub) handleVoiceStateChange(message *VoiceMessage) {
 
 	client.mutex.Lock()
 	switch message.Type {
-	case "mute":
+	case events.TypeMute:
 		client.isMuted = true
-	case "unmute":
+	case events.TypeUnmute:
 		client.isMuted = false
-	case "deafen":
+	case events.TypeDeafen:
 		client.isDeafened = true
-	case "undeafen":
+	case events.TypeUndeafen:
 		client.isDeafened = false
 	}
 	client.mutex.Unlock()
@@ -552,8 +724,16 @@ func (h *VoiceHub) handleSpeaking(message *VoiceMessage) {
 		return
 	}
 
+	// Messages are handled on the hub's goroutine, so a bad payload must
+	// not be allowed to panic
+	speaking, ok := message.Data.(bool)
+	if !ok {
+		log.Printf("Speaking message from user %d without a boolean state: %v", message.UserID, message.Data)
+		return
+	}
+
 	client.mutex.Lock()
-	client.isSpeaking = message.Data.(bool)
+	client.isSpeaking = speaking
 	client.mutex.Unlock()
 
 	// Broadcast speaking state to channel
@@ -583,7 +763,7 @@ func (h *VoiceHub) handlePing(message *VoiceMessage) {
 
 	// Send pong response
 	client.sendMessage(&VoiceMessage{
-		Type:      "pong",
+		Type:      events.TypePong,
 		ChannelID: channelID,
 		ServerID:  serverID,
 		UserID:    message.UserID,
@@ -636,27 +816,27 @@ func (h *VoiceHub) broadcastToChannel(channelID int64, message *VoiceMessage, ex
 }
 
 // getChannelClients returns client info for a channel
-func (h *VoiceHub) getChannelClients(channelID int64) []gin.H {
+func (h *VoiceHub) getChannelClients(channelID int64) []events.VoiceParticipant {
 	h.mutex.RLock()
 	channel, exists := h.channels[channelID]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return []gin.H{}
+		return []events.VoiceParticipant{}
 	}
 
 	channel.mutex.RLock()
 	defer channel.mutex.RUnlock()
 
-	clients := make([]gin.H, 0, len(channel.Clients))
+	clients := make([]events.VoiceParticipant, 0, len(channel.Clients))
 	for userID, client := range channel.Clients {
 		client.mutex.RLock()
-		clients = append(clients, gin.H{
-			"user_id":     userID,
-			"username":    client.Username,
-			"is_muted":    client.isMuted,
-			"is_deafened": client.isDeafened,
-			"is_speaking": client.isSpeaking,
+		clients = append(clients, events.VoiceParticipant{
+			UserID:     userID,
+			Username:   client.Username,
+			IsMuted:    client.isMuted,
+			IsDeafened: client.isDeafened,
+			IsSpeaking: client.isSpeaking,
 		})
 		client.mutex.RUnlock()
 	}
@@ -676,6 +856,9 @@ func (c *VoiceClient) readPump() {
 		}
 	}()
 
+	// Offers and answers carry whole SDP descriptions, far larger than chat frames
+	c.conn.SetReadLimit(maxVoiceMessageSize)
+
 	for {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
@@ -689,9 +872,15 @@ func (c *VoiceClient) readPump() {
 
 		log.Printf("Voice client %d received message: %s", c.ID, string(messageBytes))
 
-		var message VoiceMessage
-		if err := json.Unmarshal(messageBytes, &message); err != nil {
+		message, err := decodeVoiceMessage(messageBytes)
+		if err != nil {
 			log.Printf("Failed to unmarshal voice message: %v", err)
+			c.sendMessage(&VoiceMessage{
+				Type:      events.TypeError,
+				UserID:    c.ID,
+				Data:      events.ErrorData{Code: "malformed_message", Message: "Malformed message"},
+				Timestamp: time.Now(),
+			})
 			continue
 		}
 
@@ -704,7 +893,7 @@ func (c *VoiceClient) readPump() {
 
 		// Send to hub for processing
 		select {
-		case c.hub.messages <- &message:
+		case c.hub.messages <- message:
 			log.Printf("Voice client %d: message sent to hub successfully", c.ID)
 		default:
 			log.Printf("ERROR: Voice client %d: hub messages channel full", c.ID)
@@ -712,6 +901,16 @@ func (c *VoiceClient) readPump() {
 	}
 }
 
+// decodeVoiceMessage parses a frame sent by a client. Anything but a JSON
+// object with a type is refused, as are frames from a newer event version.
+func decodeVoiceMessage(data []byte) (*VoiceMessage, error) {
+	event, err := events.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return VoiceMessageFromEvent(event), nil
+}
+
 // writePump writes messages to the WebSocket connection
 func (c *VoiceClient) writePump() {
 	ticker := time.NewTicker(30 * time.Second) // Send ping every 30 seconds
@@ -745,6 +944,7 @@ func (c *VoiceClient) writePump() {
 			}
 
 			log.Printf("Voice client %d writing message to WebSocket: %s", c.ID, string(message))
+			c.compression.Prepare(c.conn, len(message))
 			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				log.Printf("Voice client %d write error: %v", c.ID, err)
 				return
@@ -757,7 +957,7 @@ func (c *VoiceClient) writePump() {
 			c.mutex.RUnlock()
 
 			pingMessage := &VoiceMessage{
-				Type:      "ping",
+				Type:      events.TypePing,
 				ChannelID: channelID,
 				ServerID:  c.serverID,
 				UserID:    c.ID,
@@ -787,6 +987,43 @@ func (c *VoiceClient) sendMessage(message *VoiceMessage) {
 	}
 }
 
+// Participant is a user in a voice channel
+type Participant struct {
+	UserID    int64
+	ChannelID int64
+	ServerID  int64
+	Muted     bool
+	Deafened  bool
+}
+
+// Participants returns everyone currently in a voice channel
+func (h *VoiceHub) Participants() []Participant {
+	h.mutex.RLock()
+	channels := make([]*VoiceChannel, 0, len(h.channels))
+	for _, channel := range h.channels {
+		channels = append(channels, channel)
+	}
+	h.mutex.RUnlock()
+
+	var participants []Participant
+	for _, channel := range channels {
+		channel.mutex.RLock()
+		for userID, client := range channel.Clients {
+			client.mutex.RLock()
+			participants = append(participants, Participant{
+				UserID:    userID,
+				ChannelID: channel.ID,
+				ServerID:  channel.ServerID,
+				Muted:     client.isMuted,
+				Deafened:  client.isDeafened,
+			})
+			client.mutex.RUnlock()
+		}
+		channel.mutex.RUnlock()
+	}
+	return participants
+}
+
 // GetVoiceStats returns voice statistics
 func (h *VoiceHub) GetVoiceStats() gin.H {
 	h.mutex.RLock()
@@ -796,6 +1033,7 @@ func (h *VoiceHub) GetVoiceStats() gin.H {
 		"total_clients":  len(h.clients),
 		"total_channels": len(h.channels),
 		"channels":       make([]gin.H, 0, len(h.channels)),
+		"idle":           h.idleStatsSnapshot(),
 	}
 
 	for channelID, channel := range h.channels {
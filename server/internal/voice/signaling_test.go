@@ -0,0 +1,64 @@
+package voice
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJoinRedirectAndEmptiedHandler(t *testing.T) {
+	hub := NewVoiceHub()
+	hub.SetChannelValidator(func(userID, channelID int64) (int64, error) {
+		if channelID == 3 {
+			return 0, ErrChannelFull
+		}
+		return 7, nil
+	})
+	hub.SetJoinRedirect(func(userID, channelID int64) (int64, error) {
+		if channelID == 1 {
+			return 2, nil
+		}
+		return channelID, nil
+	})
+	emptied := make(chan int64, 1)
+	hub.SetChannelEmptiedHandler(func(channelID int64) {
+		emptied <- channelID
+	})
+
+	client := &VoiceClient{ID: 1, Username: "user", send: make(chan []byte, 16), hub: hub}
+	hub.clients[1] = client
+
+	// Joining the generator lands in the channel it redirects to
+	hub.handleJoinChannel(&VoiceMessage{Type: "join-channel", UserID: 1, ChannelID: 1})
+	if client.channelID != 2 || client.serverID != 7 {
+		t.Fatalf("Expected to be in channel 2 of server 7, got channel %d of server %d", client.channelID, client.serverID)
+	}
+	if _, exists := hub.channels[1]; exists {
+		t.Error("Expected no voice channel for the generator itself")
+	}
+
+	// A full channel reports why
+	drainTypes(client)
+	hub.handleJoinChannel(&VoiceMessage{Type: "join-channel", UserID: 1, ChannelID: 3})
+	var message VoiceMessage
+	if err := json.Unmarshal(<-client.send, &message); err != nil || message.Type != "error" {
+		t.Fatalf("Expected an error message, got %+v (%v)", message, err)
+	}
+	if data, _ := message.Data.(map[string]interface{}); data["code"] != "channel_full" {
+		t.Errorf("Expected channel_full, got %v", message.Data)
+	}
+
+	// Leaving the last user empties the channel
+	hub.handleLeaveChannel(&VoiceMessage{Type: "leave-channel", UserID: 1, ChannelID: 2})
+	select {
+	case channelID := <-emptied:
+		if channelID != 2 {
+			t.Errorf("Expected channel 2 to be emptied, got %d", channelID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the emptied handler to run")
+	}
+	if client.channelID != 0 {
+		t.Errorf("Expected the client to have left, still in %d", client.channelID)
+	}
+}
@@ -0,0 +1,113 @@
+// Package webhooks signs and delivers outgoing webhook requests.
+//
+// Every request carries X-Fethur-Timestamp (Unix seconds) and
+// X-Fethur-Signature ("v1=" followed by the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the endpoint's secret). Receivers should
+// recompute the signature and reject timestamps older than a few minutes;
+// fethur/pkg/webhook does both for Go receivers.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"fethur/pkg/webhook"
+)
+
+// Signature headers
+const (
+	TimestampHeader = webhook.TimestampHeader
+	SignatureHeader = webhook.SignatureHeader
+)
+
+// MaxResponseSize bounds how much of a response body is read
+const MaxResponseSize = 64 * 1024
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	return webhook.Sign(timestamp, body, secret)
+}
+
+// Verify checks a signature and that its timestamp is within tolerance
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) bool {
+	return webhook.Verify(timestamp, signature, body, tolerance, secret) == nil
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(bytes), nil
+}
+
+// StatusError is returned when an endpoint answers outside the 2xx range
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("endpoint returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Response is a webhook endpoint's reply
+type Response struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Client delivers signed JSON payloads. For URLs users supply, HTTP should
+// be a fetch.Fetcher client so internal addresses stay out of reach.
+type Client struct {
+	HTTP *http.Client
+}
+
+// NewClient creates a client with a per-request timeout
+func NewClient(timeout time.Duration) *Client {
+	return &Client{HTTP: &http.Client{Timeout: timeout}}
+}
+
+// Post signs payload as JSON and posts it to url. While a secret is being
+// rotated, secrets holds the new and the old one and the request carries a
+// signature for each. Responses outside the 2xx range are returned as a
+// *StatusError.
+func (c *Client) Post(ctx context.Context, url string, secrets []string, payload interface{}) (*Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Fethur-Webhooks/1")
+	webhook.SignRequest(req, body, secrets...)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	return &Response{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: data}, nil
+}
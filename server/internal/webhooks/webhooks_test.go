@@ -0,0 +1,63 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"command":"weather"}`)
+	now := time.Now().Unix()
+	signature := Sign("secret", now, body)
+	timestamp := strconv.FormatInt(now, 10)
+
+	if !Verify("secret", timestamp, signature, body, 5*time.Minute) {
+		t.Error("Expected signature to verify")
+	}
+	if Verify("other", timestamp, signature, body, 5*time.Minute) {
+		t.Error("Expected signature with another secret to be rejected")
+	}
+	if Verify("secret", timestamp, signature, []byte(`{"command":"deploy"}`), 5*time.Minute) {
+		t.Error("Expected signature for another body to be rejected")
+	}
+	old := now - 600
+	if Verify("secret", strconv.FormatInt(old, 10), Sign("secret", old, body), body, 5*time.Minute) {
+		t.Error("Expected a stale timestamp to be rejected")
+	}
+}
+
+func TestClientPost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("secret", r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"text":"ok"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(5 * time.Second)
+	resp, err := client.Post(context.Background(), server.URL, []string{"secret"}, map[string]string{"command": "weather"})
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	if string(resp.Body) != `{"text":"ok"}` || resp.ContentType != "application/json" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	if _, err := client.Post(context.Background(), server.URL, []string{"wrong"}, map[string]string{}); err == nil {
+		t.Error("Expected a rejected request to return an error")
+	}
+
+	// During a rotation the old secret still verifies
+	if _, err := client.Post(context.Background(), server.URL, []string{"new", "secret"}, map[string]string{}); err != nil {
+		t.Errorf("Expected a request signed with the old secret too to verify, got %v", err)
+	}
+}
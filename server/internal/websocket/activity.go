@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"fethur/internal/events"
+)
+
+// MessageTypeActivity carries a transient activity such as uploading a file
+// or recording a voice note. Clients send
+// {"type":"activity","channel_id":5,"data":{"kind":"uploading","active":true}}
+// and repeat it while the activity lasts; members of the channel receive
+// the same frame with the user and the activity's TTL.
+const MessageTypeActivity = string(events.TypeActivity)
+
+// Activity kinds
+const (
+	ActivityTyping    = "typing"
+	ActivityUploading = "uploading"
+	ActivityRecording = "recording"
+)
+
+var activityKinds = map[string]bool{
+	ActivityTyping:    true,
+	ActivityUploading: true,
+	ActivityRecording: true,
+}
+
+// ActivityTTL is how long an activity lasts without being repeated. When it
+// passes the server announces the activity as ended, so a client that
+// vanishes mid-upload does not leave it shown forever.
+const ActivityTTL = 10 * time.Second
+
+// activitySweepInterval is how often the hub looks for expired activities
+const activitySweepInterval = time.Second
+
+// Activity is the payload of an activity frame
+type Activity = events.Activity
+
+// activityKey identifies one activity of one connection
+type activityKey struct {
+	client    *Client
+	channelID int
+	kind      string
+}
+
+// startActivity records an activity until expires and reports whether it
+// is new, as opposed to a repeat of one already shown
+func (h *Hub) startActivity(client *Client, channelID int, kind string, expires time.Time) bool {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+
+	key := activityKey{client: client, channelID: channelID, kind: kind}
+	_, shown := h.activities[key]
+	h.activities[key] = expires
+	return !shown
+}
+
+// stopActivity forgets an activity and reports whether it was shown
+func (h *Hub) stopActivity(client *Client, channelID int, kind string) bool {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+
+	key := activityKey{client: client, channelID: channelID, kind: kind}
+	_, shown := h.activities[key]
+	delete(h.activities, key)
+	return shown
+}
+
+// endActivities forgets a connection's activities, in one channel or in
+// every channel when channelID is 0, and returns the frames announcing
+// their end
+func (h *Hub) endActivities(client *Client, channelID int) []*Message {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+
+	var ended []*Message
+	for key := range h.activities {
+		if key.client == client && (channelID == 0 || key.channelID == channelID) {
+			delete(h.activities, key)
+			ended = append(ended, activityMessage(client, key.channelID, key.kind, false))
+		}
+	}
+	return ended
+}
+
+// expireActivities forgets activities that were not repeated in time and
+// returns the frames announcing their end
+func (h *Hub) expireActivities(now time.Time) []*Message {
+	h.activityMutex.Lock()
+	defer h.activityMutex.Unlock()
+
+	var ended []*Message
+	for key, expires := range h.activities {
+		if !now.Before(expires) {
+			delete(h.activities, key)
+			ended = append(ended, activityMessage(key.client, key.channelID, key.kind, false))
+		}
+	}
+	return ended
+}
+
+func activityMessage(client *Client, channelID int, kind string, active bool) *Message {
+	activity := Activity{Kind: kind, Active: active}
+	if active {
+		activity.TTL = int(ActivityTTL / time.Second)
+	}
+	return &Message{
+		Type:      MessageTypeActivity,
+		ChannelID: channelID,
+		UserID:    client.userID,
+		Username:  client.username,
+		Timestamp: time.Now(),
+		Data:      activity,
+	}
+}
+
+// handleActivity starts, refreshes or stops an activity in a channel the
+// client is subscribed to. Only starts and stops are broadcast; repeats
+// just push the expiry back.
+func (c *Client) handleActivity(message *Message) {
+	data, _ := message.Data.(map[string]interface{})
+	kind, _ := data["kind"].(string)
+	active, ok := data["active"].(bool)
+	if !ok {
+		active = true
+	}
+
+	c.mutex.RLock()
+	subscribed := c.channels[message.ChannelID]
+	c.mutex.RUnlock()
+	if !activityKinds[kind] || !subscribed {
+		log.Printf("User %s sent an invalid %q activity for channel %d", c.username, kind, message.ChannelID)
+		c.Send(&Message{
+			Type:      MessageTypeError,
+			RequestID: message.RequestID,
+			ChannelID: message.ChannelID,
+			Content:   "Invalid activity",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if active {
+		if c.hub.startActivity(c, message.ChannelID, kind, time.Now().Add(ActivityTTL)) {
+			c.hub.broadcast <- activityMessage(c, message.ChannelID, kind, true)
+		}
+		return
+	}
+	if c.hub.stopActivity(c, message.ChannelID, kind) {
+		c.hub.broadcast <- activityMessage(c, message.ChannelID, kind, false)
+	}
+}
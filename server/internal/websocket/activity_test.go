@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// nextActivity reads the next frame sent to a client, failing after a
+// timeout
+func nextActivity(t *testing.T, client *Client) (Message, Activity) {
+	t.Helper()
+	select {
+	case raw := <-client.send:
+		var frame struct {
+			Message
+			Data Activity `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+		return frame.Message, frame.Data
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+	return Message{}, Activity{}
+}
+
+func TestActivityLifecycle(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	watcher := NewClient(nil, hub, 1, "watcher")
+	watcher.SubscribeToChannel(5)
+	hub.register <- watcher
+
+	alice := NewClient(nil, hub, 7, "alice")
+	alice.SubscribeToChannel(5)
+	activity := func(kind string, active bool) *Message {
+		return &Message{Type: MessageTypeActivity, ChannelID: 5, Data: map[string]interface{}{"kind": kind, "active": active}}
+	}
+
+	// Starting an activity is broadcast with its TTL
+	alice.handleActivity(activity(ActivityUploading, true))
+	frame, data := nextActivity(t, watcher)
+	if frame.Type != MessageTypeActivity || frame.UserID != 7 || data.Kind != ActivityUploading || !data.Active || data.TTL != 10 {
+		t.Fatalf("Expected alice's upload to start, got %+v %+v", frame, data)
+	}
+
+	// Repeats only extend it; stopping is broadcast
+	alice.handleActivity(activity(ActivityUploading, true))
+	alice.handleActivity(activity(ActivityUploading, false))
+	if _, data := nextActivity(t, watcher); data.Kind != ActivityUploading || data.Active {
+		t.Errorf("Expected the upload to stop without a repeated start, got %+v", data)
+	}
+
+	// Unknown kinds and channels the client is not in are refused
+	alice.handleActivity(activity("dancing", true))
+	alice.handleActivity(&Message{Type: MessageTypeActivity, ChannelID: 6, Data: map[string]interface{}{"kind": ActivityTyping}})
+	for i := 0; i < 2; i++ {
+		if frame, _ := nextActivity(t, alice); frame.Type != "error" {
+			t.Errorf("Expected an error frame, got %s", frame.Type)
+		}
+	}
+
+	// Activities that are not repeated expire
+	hub.startActivity(alice, 5, ActivityRecording, time.Now())
+	if _, data := nextActivity(t, watcher); data.Kind != ActivityRecording || data.Active {
+		t.Errorf("Expected the recording to expire, got %+v", data)
+	}
+
+	// Disconnecting ends everything the client was doing
+	alice.handleActivity(activity(ActivityTyping, true))
+	nextActivity(t, watcher)
+	hub.register <- alice
+	hub.unregister <- alice
+	if _, data := nextActivity(t, watcher); data.Kind != ActivityTyping || data.Active {
+		t.Errorf("Expected typing to end on disconnect, got %+v", data)
+	}
+}
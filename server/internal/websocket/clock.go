@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"time"
+
+	"fethur/internal/events"
+)
+
+// Clock sync message types
+const (
+	MessageTypeHeartbeat = string(events.TypeHeartbeat)
+	MessageTypePong      = string(events.TypePong)
+	MessageTypeHello     = string(events.TypeHello)
+	MessageTypeClock     = string(events.TypeClock)
+)
+
+// heartbeatPeriod is how often each connection gets a keepalive ping and a
+// clock event
+const heartbeatPeriod = 54 * time.Second
+
+// clockMessage is a frame stamped with the server's clock, echoing the
+// client's time when it sent one
+func clockMessage(messageType string, clientTime int64) *Message {
+	now := time.Now()
+	return &Message{
+		Type:      messageType,
+		Timestamp: now,
+		Data: events.Clock{
+			ServerTime: now.UnixMilli(),
+			ClientTime: clientTime,
+			Interval:   int(heartbeatPeriod / time.Second),
+		},
+	}
+}
+
+// handleHeartbeat answers a heartbeat with a pong carrying the server's
+// clock, so clients can measure the round trip and their offset
+func (c *Client) handleHeartbeat(message *Message) {
+	var clock events.Clock
+	if message.Data != nil {
+		_ = events.DecodeData(message.Data, &clock)
+	}
+	c.Send(clockMessage(MessageTypePong, clock.ClientTime))
+}
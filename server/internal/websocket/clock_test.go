@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"fethur/internal/events"
+)
+
+func TestHeartbeatEchoesClientClock(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	client := NewClient(nil, hub, 1, "clock")
+
+	before := time.Now().UnixMilli()
+	client.receive([]byte(`{"type":"heartbeat","data":{"client_time":1753732800000}}`))
+
+	var pong struct {
+		Type string       `json:"type"`
+		Data events.Clock `json:"data"`
+	}
+	select {
+	case frame := <-client.send:
+		if err := json.Unmarshal(frame, &pong); err != nil {
+			t.Fatalf("Failed to decode pong: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a pong")
+	}
+	if pong.Type != MessageTypePong || pong.Data.ClientTime != 1753732800000 {
+		t.Errorf("Expected a pong echoing the client's time, got %+v", pong)
+	}
+	if pong.Data.ServerTime < before || pong.Data.ServerTime > time.Now().UnixMilli() {
+		t.Errorf("Expected the server's time in the pong, got %d", pong.Data.ServerTime)
+	}
+	if pong.Data.Interval != int(heartbeatPeriod/time.Second) {
+		t.Errorf("Expected the clock interval, got %d", pong.Data.Interval)
+	}
+}
@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"fethur/internal/events"
+	"fethur/internal/snowflake"
+)
+
+// MessageTypeCompacted stands for a burst of events folded into one frame
+const MessageTypeCompacted = string(events.TypeCompacted)
+
+// Reaction message types
+const (
+	MessageTypeReactionAdd    = string(events.TypeReactionAdd)
+	MessageTypeReactionRemove = string(events.TypeReactionRemove)
+)
+
+// Compacted is the payload of a compacted frame
+type Compacted = events.Compacted
+
+// CompactionRule compacts bursts of one event type in a channel. Once more
+// than Threshold events arrived within Window, the rest of the window's
+// events are held back and sent as one compacted frame when it ends. A
+// channel stays compacted for as long as its windows keep overflowing, so
+// low-end clients get one frame per window instead of one per event.
+type CompactionRule struct {
+	Threshold int
+	Window    time.Duration
+}
+
+// compactionFlushInterval is how often the hub sends out held-back bursts;
+// windows end on the next flush after they are over
+const compactionFlushInterval = 250 * time.Millisecond
+
+// DefaultCompaction compacts reactions and presence past 20 events a
+// second in a channel
+func DefaultCompaction() map[string]CompactionRule {
+	rule := CompactionRule{Threshold: 20, Window: time.Second}
+	return map[string]CompactionRule{
+		MessageTypeReactionAdd:    rule,
+		MessageTypeReactionRemove: rule,
+		MessageTypeJoin:           rule,
+		MessageTypeLeave:          rule,
+	}
+}
+
+// ParseCompaction parses comma-separated type=threshold/window rules such
+// as "reaction_add=20/1s,join=10/2s"; "off" disables compaction. Only the
+// optional event types clients can filter out may be compacted, as chat
+// messages must arrive one by one.
+func ParseCompaction(value string) (map[string]CompactionRule, error) {
+	rules := make(map[string]CompactionRule)
+	if strings.TrimSpace(value) == "off" {
+		return rules, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		messageType, spec, ok := strings.Cut(part, "=")
+		threshold, window, ok2 := strings.Cut(spec, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("compaction rule %q is not type=threshold/window", part)
+		}
+		messageType = strings.TrimSpace(messageType)
+		if _, ok := eventCategories[messageType]; !ok {
+			return nil, fmt.Errorf("%s events cannot be compacted", messageType)
+		}
+		rule := CompactionRule{}
+		var err error
+		if rule.Threshold, err = strconv.Atoi(strings.TrimSpace(threshold)); err != nil || rule.Threshold < 1 {
+			return nil, fmt.Errorf("compaction threshold of %s must be a positive number", messageType)
+		}
+		if rule.Window, err = time.ParseDuration(strings.TrimSpace(window)); err != nil || rule.Window <= 0 {
+			return nil, fmt.Errorf("compaction window of %s must be a positive duration", messageType)
+		}
+		rules[messageType] = rule
+	}
+	return rules, nil
+}
+
+// SetCompaction replaces the compaction rules, by event type
+func (h *Hub) SetCompaction(rules map[string]CompactionRule) {
+	h.mutex.Lock()
+	h.compaction = rules
+	h.mutex.Unlock()
+}
+
+func (h *Hub) compactionRule(messageType string) (CompactionRule, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	rule, ok := h.compaction[messageType]
+	return rule, ok
+}
+
+// burstKey identifies the events of one category in one channel, such as
+// reactions added and removed
+type burstKey struct {
+	channelID int
+	category  string
+}
+
+// burst counts the events of a window and holds back those past the
+// threshold
+type burst struct {
+	start      time.Time
+	window     time.Duration
+	seen       int
+	compacting bool
+	held       []*Message
+}
+
+// compact counts a message towards its channel's burst and reports whether
+// it was held back for the next compacted frame. Messages for a limited
+// audience are always delivered as they are. It runs on the hub's
+// goroutine.
+func (h *Hub) compact(message *Message, now time.Time) bool {
+	if message.Audience != nil {
+		return false
+	}
+	rule, ok := h.compactionRule(message.Type)
+	if !ok {
+		return false
+	}
+
+	key := burstKey{channelID: message.ChannelID, category: eventCategories[message.Type]}
+	b := h.bursts[key]
+	if b == nil {
+		b = &burst{start: now, window: rule.Window}
+		h.bursts[key] = b
+	}
+	b.seen++
+	if !b.compacting && b.seen <= rule.Threshold {
+		return false
+	}
+	b.compacting = true
+	b.held = append(b.held, message)
+	return true
+}
+
+// flushCompacted ends the bursts whose window is over and returns a
+// compacted frame for each that held messages back. Channels that are still
+// busy start their next window compacted.
+func (h *Hub) flushCompacted(now time.Time) []*Message {
+	var frames []*Message
+	for key, b := range h.bursts {
+		if now.Sub(b.start) < b.window {
+			continue
+		}
+		if len(b.held) == 0 {
+			delete(h.bursts, key)
+			continue
+		}
+		frames = append(frames, compactedMessage(key.channelID, b.held))
+		h.bursts[key] = &burst{start: now, window: b.window, compacting: true}
+	}
+	return frames
+}
+
+// compactedMessage folds held-back messages into one frame: the net change
+// of each reaction and who joined or left on balance
+func compactedMessage(channelID int, held []*Message) *Message {
+	type reactionKey struct {
+		messageID string
+		emoji     string
+	}
+	data := Compacted{Count: len(held)}
+	types := make(map[string]bool)
+	var reactions []reactionKey
+	deltas := make(map[reactionKey]int)
+	var users []int
+	presence := make(map[int]int)
+
+	for _, message := range held {
+		if !types[message.Type] {
+			types[message.Type] = true
+			data.Types = append(data.Types, message.Type)
+		}
+		switch message.Type {
+		case MessageTypeReactionAdd, MessageTypeReactionRemove:
+			var reaction struct {
+				MessageID snowflake.ID `json:"message_id"`
+				Emoji     string       `json:"emoji"`
+			}
+			raw, _ := json.Marshal(message.Data)
+			if err := json.Unmarshal(raw, &reaction); err != nil {
+				continue
+			}
+			key := reactionKey{messageID: reaction.MessageID.String(), emoji: reaction.Emoji}
+			if _, ok := deltas[key]; !ok {
+				reactions = append(reactions, key)
+			}
+			if message.Type == MessageTypeReactionAdd {
+				deltas[key]++
+			} else {
+				deltas[key]--
+			}
+		case MessageTypeJoin, MessageTypeLeave:
+			if _, ok := presence[message.UserID]; !ok {
+				users = append(users, message.UserID)
+			}
+			if message.Type == MessageTypeJoin {
+				presence[message.UserID]++
+			} else {
+				presence[message.UserID]--
+			}
+		}
+	}
+
+	for _, key := range reactions {
+		if delta := deltas[key]; delta != 0 {
+			data.Reactions = append(data.Reactions, events.ReactionDelta{MessageID: key.messageID, Emoji: key.emoji, Delta: delta})
+		}
+	}
+	for _, userID := range users {
+		switch {
+		case presence[userID] > 0:
+			data.Joined = append(data.Joined, int64(userID))
+		case presence[userID] < 0:
+			data.Left = append(data.Left, int64(userID))
+		}
+	}
+
+	return &Message{
+		Type:      MessageTypeCompacted,
+		ChannelID: channelID,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+}
+
+// filterType is the message type checked against a client's event filter.
+// A compacted frame is filtered like the events it stands for.
+func (m *Message) filterType() string {
+	if data, ok := m.Data.(Compacted); ok && len(data.Types) > 0 {
+		return data.Types[0]
+	}
+	return m.Type
+}
@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCompaction(t *testing.T) {
+	rules, err := ParseCompaction(" reaction_add=5/2s, join=10/500ms,")
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	want := map[string]CompactionRule{
+		"reaction_add":  {Threshold: 5, Window: 2 * time.Second},
+		MessageTypeJoin: {Threshold: 10, Window: 500 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+	if rules, err := ParseCompaction("off"); err != nil || len(rules) != 0 {
+		t.Errorf("Expected off to disable compaction, got %+v (%v)", rules, err)
+	}
+	for _, value := range []string{"text=5/1s", "reaction_add=0/1s", "reaction_add=5/soon", "reaction_add=5", "join"} {
+		if _, err := ParseCompaction(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	hub := NewHub()
+	hub.SetCompaction(map[string]CompactionRule{
+		"reaction_add":    {Threshold: 2, Window: time.Second},
+		"reaction_remove": {Threshold: 2, Window: time.Second},
+		MessageTypeJoin:   {Threshold: 1, Window: time.Second},
+		MessageTypeLeave:  {Threshold: 1, Window: time.Second},
+	})
+	watcher := NewClient(nil, hub, 1, "watcher")
+	quiet := NewClient(nil, hub, 2, "quiet")
+	for _, client := range []*Client{watcher, quiet} {
+		client.SubscribeToChannel(5)
+		hub.clients[client] = true
+	}
+	if err := quiet.SetEventFilter([]string{EventCategoryPresence}); err != nil {
+		t.Fatalf("Failed to set filter: %v", err)
+	}
+
+	start := time.Now()
+	broadcast := func(message *Message) {
+		if !hub.compact(message, start) {
+			hub.deliver(message)
+		}
+	}
+	reaction := func(messageType string, messageID int, emoji string) *Message {
+		return &Message{Type: messageType, ChannelID: 5, Data: map[string]interface{}{"message_id": messageID, "emoji": emoji}}
+	}
+	flush := func(at time.Duration) {
+		for _, message := range hub.flushCompacted(start.Add(at)) {
+			hub.deliver(message)
+		}
+	}
+	compacted := func() Compacted {
+		t.Helper()
+		if len(watcher.send) != 1 {
+			t.Fatalf("Expected one compacted frame, got %d frames", len(watcher.send))
+		}
+		var frame struct {
+			Type string    `json:"type"`
+			Data Compacted `json:"data"`
+		}
+		if err := json.Unmarshal(<-watcher.send, &frame); err != nil || frame.Type != MessageTypeCompacted {
+			t.Fatalf("Expected a compacted frame, got %+v (%v)", frame, err)
+		}
+		return frame.Data
+	}
+
+	// Events up to the threshold go out as they are; the rest wait for the
+	// end of the window
+	broadcast(reaction("reaction_add", 10, "👍"))
+	broadcast(reaction("reaction_add", 10, "👍"))
+	for i := 0; i < 3; i++ {
+		broadcast(reaction("reaction_add", 11, "🎉"))
+	}
+	broadcast(reaction("reaction_remove", 10, "👍"))
+	broadcast(reaction("reaction_add", 12, "👀"))
+	broadcast(reaction("reaction_remove", 12, "👀"))
+	if len(watcher.send) != 2 {
+		t.Fatalf("Expected the first 2 reactions to be delivered, got %d frames", len(watcher.send))
+	}
+	<-watcher.send
+	<-watcher.send
+	flush(500 * time.Millisecond)
+	if len(watcher.send) != 0 {
+		t.Fatal("Expected nothing before the window is over")
+	}
+	flush(time.Second)
+	data := compacted()
+	if data.Count != 6 || !reflect.DeepEqual(data.Types, []string{"reaction_add", "reaction_remove"}) {
+		t.Errorf("Expected 6 reactions folded in, got %+v", data)
+	}
+	if len(data.Reactions) != 2 || data.Reactions[0].MessageID != "11" || data.Reactions[0].Delta != 3 || data.Reactions[1].Delta != -1 {
+		t.Errorf("Expected the net change of each reaction, got %+v", data.Reactions)
+	}
+	if len(quiet.send) != 0 {
+		t.Errorf("Expected clients filtering reactions not to get the compacted frame, got %d frames", len(quiet.send))
+	}
+
+	// A busy channel stays compacted; a quiet window ends the burst
+	broadcast(reaction("reaction_add", 10, "👍"))
+	if len(watcher.send) != 0 {
+		t.Fatal("Expected the next window to start compacted")
+	}
+	flush(2 * time.Second)
+	if data := compacted(); data.Count != 1 {
+		t.Errorf("Expected one reaction in the second window, got %+v", data)
+	}
+	flush(3 * time.Second)
+	broadcast(reaction("reaction_add", 10, "👍"))
+	if len(watcher.send) != 1 {
+		t.Fatal("Expected reactions to be delivered again after a quiet window")
+	}
+	<-watcher.send
+
+	// Presence carries who joined or left on balance
+	broadcast(&Message{Type: MessageTypeJoin, ChannelID: 5, UserID: 7})
+	broadcast(&Message{Type: MessageTypeJoin, ChannelID: 5, UserID: 8})
+	broadcast(&Message{Type: MessageTypeLeave, ChannelID: 5, UserID: 9})
+	broadcast(&Message{Type: MessageTypeLeave, ChannelID: 5, UserID: 8})
+	<-watcher.send
+	<-quiet.send
+	flush(time.Second)
+	if data := compacted(); data.Count != 3 || len(data.Joined) != 0 || !reflect.DeepEqual(data.Left, []int64{9}) {
+		t.Errorf("Expected user 9 to have left on balance, got %+v", data)
+	}
+	if len(quiet.send) != 1 {
+		t.Errorf("Expected clients wanting presence to get the compacted frame, got %d frames", len(quiet.send))
+	}
+
+	// Chat messages and messages for a limited audience are never held back
+	for i := 0; i < 3; i++ {
+		broadcast(&Message{Type: MessageTypeText, ChannelID: 6})
+		broadcast(&Message{Type: "reaction_add", ChannelID: 6, Audience: map[int]bool{1: true}})
+	}
+	if len(hub.bursts) != 1 {
+		t.Errorf("Expected only the presence burst to be tracked, got %d", len(hub.bursts))
+	}
+}
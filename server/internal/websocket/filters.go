@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fethur/internal/events"
+)
+
+// Optional event categories a client can opt out of. Chat messages and
+// replies to the client's own requests are always delivered.
+const (
+	EventCategoryTyping    = "typing"
+	EventCategoryPresence  = "presence"
+	EventCategoryReactions = "reactions"
+	EventCategoryActivity  = "activity"
+)
+
+// Settings message types
+const (
+	MessageTypeSettings    = string(events.TypeSettings)
+	MessageTypeSettingsAck = string(events.TypeSettingsAck)
+)
+
+// eventCategories maps filterable message types to their category
+var eventCategories = map[string]string{
+	MessageTypeTyping:         EventCategoryTyping,
+	MessageTypeStopTyping:     EventCategoryTyping,
+	MessageTypeActivity:       EventCategoryActivity,
+	MessageTypeJoin:           EventCategoryPresence,
+	MessageTypeLeave:          EventCategoryPresence,
+	MessageTypeReactionAdd:    EventCategoryReactions,
+	MessageTypeReactionRemove: EventCategoryReactions,
+}
+
+// EventCategories returns all optional event categories, sorted
+func EventCategories() []string {
+	return []string{EventCategoryActivity, EventCategoryPresence, EventCategoryReactions, EventCategoryTyping}
+}
+
+// ParseEventCategories parses a comma-separated list of wanted categories,
+// as passed in the ?events= handshake parameter
+func ParseEventCategories(value string) ([]string, error) {
+	categories := make([]string, 0)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" {
+			continue
+		}
+		categories = append(categories, part)
+	}
+	if err := validateEventCategories(categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func validateEventCategories(categories []string) error {
+	for _, category := range categories {
+		known := false
+		for _, valid := range EventCategories() {
+			if category == valid {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event category: %s", category)
+		}
+	}
+	return nil
+}
+
+// SetEventFilter restricts delivery of optional events to the given
+// categories. Categories not listed are dropped by the hub for this client.
+func (c *Client) SetEventFilter(wanted []string) error {
+	if err := validateEventCategories(wanted); err != nil {
+		return err
+	}
+
+	filtered := make(map[string]bool)
+	for _, category := range EventCategories() {
+		filtered[category] = true
+	}
+	for _, category := range wanted {
+		delete(filtered, category)
+	}
+
+	c.mutex.Lock()
+	c.filtered = filtered
+	c.mutex.Unlock()
+	return nil
+}
+
+// EventFilter returns the optional categories this client receives, sorted
+func (c *Client) EventFilter() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	wanted := make([]string, 0)
+	for _, category := range EventCategories() {
+		if !c.filtered[category] {
+			wanted = append(wanted, category)
+		}
+	}
+	return wanted
+}
+
+// wantsLocked reports whether a message type passes the client's event
+// filter. The caller must hold c.mutex.
+func (c *Client) wantsLocked(messageType string) bool {
+	category, ok := eventCategories[messageType]
+	if !ok {
+		return true
+	}
+	return !c.filtered[category]
+}
+
+// handleSettings updates per-session settings from a settings frame:
+// {"type":"settings","data":{"events":["typing"]}}
+func (c *Client) handleSettings(message *Message) {
+	reply := &Message{
+		Type:      MessageTypeSettingsAck,
+		RequestID: message.RequestID,
+		Timestamp: time.Now(),
+	}
+
+	var settings events.Settings
+	if message.Data != nil {
+		if err := events.DecodeData(message.Data, &settings); err != nil {
+			reply.Content = "events must be a list of categories"
+			c.send <- messageToBytes(reply)
+			return
+		}
+	}
+	if settings.Events != nil {
+		wanted := make([]string, 0, len(settings.Events))
+		for _, category := range settings.Events {
+			wanted = append(wanted, strings.ToLower(category))
+		}
+
+		if err := c.SetEventFilter(wanted); err != nil {
+			reply.Content = err.Error()
+			c.send <- messageToBytes(reply)
+			return
+		}
+	}
+
+	reply.Data = events.Settings{Events: c.EventFilter()}
+	c.send <- messageToBytes(reply)
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEventCategories(t *testing.T) {
+	categories, err := ParseEventCategories(" Typing, presence,,")
+	if err != nil {
+		t.Fatalf("Failed to parse categories: %v", err)
+	}
+	if !reflect.DeepEqual(categories, []string{"typing", "presence"}) {
+		t.Errorf("Unexpected categories: %v", categories)
+	}
+
+	if _, err := ParseEventCategories("typing,cursors"); err == nil {
+		t.Error("Expected unknown category to be rejected")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	client := NewClient(nil, NewHub(), 1, "mobile")
+
+	if !client.wantsLocked(MessageTypeTyping) || !client.wantsLocked(MessageTypeJoin) {
+		t.Error("Expected all categories to be delivered by default")
+	}
+
+	if err := client.SetEventFilter([]string{EventCategoryPresence}); err != nil {
+		t.Fatalf("Failed to set filter: %v", err)
+	}
+
+	if client.wantsLocked(MessageTypeTyping) || client.wantsLocked(MessageTypeStopTyping) {
+		t.Error("Expected typing events to be filtered")
+	}
+	if !client.wantsLocked(MessageTypeLeave) {
+		t.Error("Expected presence events to be delivered")
+	}
+	if !client.wantsLocked(MessageTypeText) {
+		t.Error("Expected chat messages to always be delivered")
+	}
+	if got := client.EventFilter(); !reflect.DeepEqual(got, []string{EventCategoryPresence}) {
+		t.Errorf("Unexpected effective filter: %v", got)
+	}
+}
+
+func TestDeliverAudience(t *testing.T) {
+	hub := NewHub()
+	author := NewClient(nil, hub, 1, "author")
+	other := NewClient(nil, hub, 2, "other")
+	for _, client := range []*Client{author, other} {
+		client.SubscribeToChannel(5)
+		hub.clients[client] = true
+	}
+
+	hub.deliver(&Message{Type: MessageTypeText, ChannelID: 5, Audience: map[int]bool{1: true}})
+	if len(author.send) != 1 || len(other.send) != 0 {
+		t.Errorf("Expected only the audience to get the message, got %d and %d", len(author.send), len(other.send))
+	}
+	raw := <-author.send
+	if strings.Contains(string(raw), "udience") {
+		t.Errorf("Expected the audience to stay off the wire, got %s", raw)
+	}
+}
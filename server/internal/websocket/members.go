@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"fethur/internal/events"
+)
+
+// Member list message types. Large servers send their member list in
+// chunks: the client asks for the ranges it shows, such as
+// {"type":"request_members","data":{"server_id":1,"ranges":[{"start":0,"end":99}]}},
+// and receives one member_chunk per range as it scrolls.
+const (
+	MessageTypeRequestMembers = string(events.TypeRequestMembers)
+	MessageTypeMemberChunk    = string(events.TypeMemberChunk)
+)
+
+// MemberChunkSize is the most members one range may ask for
+const MemberChunkSize = 100
+
+// maxMemberRanges bounds the ranges of one request
+const maxMemberRanges = 3
+
+// Member list payloads
+type (
+	Member        = events.Member
+	MemberRequest = events.MemberRequest
+	MemberChunk   = events.MemberChunk
+)
+
+// MemberLister returns a server's members in list order for a user who may
+// see them
+type MemberLister func(userID, serverID int) ([]Member, error)
+
+// SetMemberLister installs the source of member lists
+func (h *Hub) SetMemberLister(lister MemberLister) {
+	h.mutex.Lock()
+	h.listMembers = lister
+	h.mutex.Unlock()
+}
+
+// handleRequestMembers answers each requested range of a member list with
+// a chunk, echoing the request ID
+func (c *Client) handleRequestMembers(message *Message) {
+	var request MemberRequest
+	raw, _ := json.Marshal(message.Data)
+	if err := json.Unmarshal(raw, &request); err != nil || !validMemberRanges(request.Ranges) {
+		c.sendMemberChunk(message, MemberChunk{ServerID: request.ServerID, Error: "Invalid member ranges"})
+		return
+	}
+
+	c.hub.mutex.RLock()
+	lister := c.hub.listMembers
+	c.hub.mutex.RUnlock()
+	if lister == nil {
+		c.sendMemberChunk(message, MemberChunk{ServerID: request.ServerID, Error: "Member lists are not available"})
+		return
+	}
+	members, err := lister(c.userID, int(request.ServerID))
+	if err != nil {
+		log.Printf("User %s denied the member list of server %d: %v", c.username, request.ServerID, err)
+		c.sendMemberChunk(message, MemberChunk{ServerID: request.ServerID, Error: "Server not found"})
+		return
+	}
+
+	online := 0
+	for _, member := range members {
+		if member.Online {
+			online++
+		}
+	}
+	for _, r := range request.Ranges {
+		chunk := MemberChunk{
+			ServerID: request.ServerID,
+			Offset:   r.Start,
+			Total:    len(members),
+			Online:   online,
+			Members:  make([]Member, 0),
+		}
+		if r.Start < len(members) {
+			chunk.Members = members[r.Start:min(r.End+1, len(members))]
+		}
+		c.sendMemberChunk(message, chunk)
+	}
+}
+
+func validMemberRanges(ranges []events.MemberRange) bool {
+	if len(ranges) == 0 || len(ranges) > maxMemberRanges {
+		return false
+	}
+	for _, r := range ranges {
+		if r.Start < 0 || r.End < r.Start || r.End-r.Start >= MemberChunkSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) sendMemberChunk(request *Message, chunk MemberChunk) {
+	c.Send(&Message{
+		Type:      MessageTypeMemberChunk,
+		RequestID: request.RequestID,
+		Timestamp: time.Now(),
+		Data:      chunk,
+	})
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMemberChunks(t *testing.T) {
+	hub := NewHub()
+	members := make([]Member, 250)
+	for i := range members {
+		members[i] = Member{UserID: int64(i + 1), Username: fmt.Sprintf("user%d", i+1), Role: "member", Online: i < 40}
+	}
+	hub.SetMemberLister(func(userID, serverID int) ([]Member, error) {
+		if serverID != 3 {
+			return nil, errors.New("not a member")
+		}
+		return members, nil
+	})
+	client := NewClient(nil, hub, 1, "scroller")
+
+	request := func(data string) []MemberChunk {
+		t.Helper()
+		var payload interface{}
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			t.Fatalf("Bad request data: %v", err)
+		}
+		client.handleRequestMembers(&Message{Type: MessageTypeRequestMembers, RequestID: "r1", Data: payload})
+		var chunks []MemberChunk
+		for len(client.send) > 0 {
+			var frame struct {
+				Type      string      `json:"type"`
+				RequestID string      `json:"request_id"`
+				Data      MemberChunk `json:"data"`
+			}
+			if err := json.Unmarshal(<-client.send, &frame); err != nil || frame.Type != MessageTypeMemberChunk || frame.RequestID != "r1" {
+				t.Fatalf("Expected a member chunk answering r1, got %+v (%v)", frame, err)
+			}
+			chunks = append(chunks, frame.Data)
+		}
+		return chunks
+	}
+
+	// Each range gets a chunk with the list's totals; ranges past the end
+	// come back short or empty
+	chunks := request(`{"server_id":3,"ranges":[{"start":0,"end":99},{"start":200,"end":299},{"start":300,"end":309}]}`)
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(chunks))
+	}
+	if c := chunks[0]; c.Offset != 0 || len(c.Members) != 100 || c.Total != 250 || c.Online != 40 || c.Members[99].UserID != 100 {
+		t.Errorf("Unexpected first chunk: offset %d, %d members, %d/%d", c.Offset, len(c.Members), c.Online, c.Total)
+	}
+	if c := chunks[1]; c.Offset != 200 || len(c.Members) != 50 || c.Members[0].UserID != 201 {
+		t.Errorf("Expected the last 50 members, got offset %d with %d", c.Offset, len(c.Members))
+	}
+	if c := chunks[2]; c.Members == nil || len(c.Members) != 0 || c.Error != "" {
+		t.Errorf("Expected an empty chunk past the end, got %+v", c)
+	}
+
+	// Oversized, inverted or too many ranges and foreign servers are refused
+	for _, data := range []string{
+		`{"server_id":3,"ranges":[{"start":0,"end":100}]}`,
+		`{"server_id":3,"ranges":[{"start":10,"end":5}]}`,
+		`{"server_id":3,"ranges":[]}`,
+		`{"server_id":3,"ranges":[{"start":0,"end":9},{"start":10,"end":19},{"start":20,"end":29},{"start":30,"end":39}]}`,
+		`{"server_id":4,"ranges":[{"start":0,"end":9}]}`,
+	} {
+		if chunks := request(data); len(chunks) != 1 || chunks[0].Error == "" || len(chunks[0].Members) != 0 {
+			t.Errorf("Expected %s to be refused, got %+v", data, chunks)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// protocolVector is a frame a client may send and what the server makes of it
+type protocolVector struct {
+	Name    string   `json:"name"`
+	Frame   string   `json:"frame"`
+	Message *Message `json:"message"` // the decoded frame
+	Error   bool     `json:"error"`   // the frame is refused
+	Reply   string   `json:"reply"`   // type of the first frame sent back, if any
+}
+
+func loadProtocolVectors(t testing.TB) []protocolVector {
+	data, err := os.ReadFile("testdata/protocol.json")
+	if err != nil {
+		t.Fatalf("Failed to read protocol vectors: %v", err)
+	}
+	var vectors []protocolVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Failed to parse protocol vectors: %v", err)
+	}
+	return vectors
+}
+
+func TestProtocolVectors(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	for _, vector := range loadProtocolVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			message, err := decodeMessage([]byte(vector.Frame))
+			if vector.Error {
+				if err == nil {
+					t.Fatalf("Expected the frame to be refused, got %+v", message)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Failed to decode frame: %v", err)
+				}
+				if !reflect.DeepEqual(message, vector.Message) {
+					t.Errorf("Expected %+v, got %+v", vector.Message, message)
+				}
+			}
+
+			client := NewClient(nil, hub, 1, "vector")
+			client.receive([]byte(vector.Frame))
+
+			reply := vector.Reply
+			if vector.Error {
+				reply = "error"
+			}
+			if reply == "" {
+				return
+			}
+			select {
+			case frame := <-client.send:
+				var got Message
+				if err := json.Unmarshal(frame, &got); err != nil {
+					t.Fatalf("Failed to decode reply: %v", err)
+				}
+				if got.Type != reply {
+					t.Errorf("Expected a %s reply, got %s", reply, frame)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("Expected a %s reply", reply)
+			}
+		})
+	}
+}
+
+func FuzzReceive(f *testing.F) {
+	for _, vector := range loadProtocolVectors(f) {
+		f.Add([]byte(vector.Frame))
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hub := NewHub()
+	go hub.Run()
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		client := NewClient(nil, hub, 1, "fuzz")
+		client.receive(frame)
+
+		message, err := decodeMessage(frame)
+		if err != nil {
+			return
+		}
+		// Whatever was accepted survives a round trip once the server has
+		// stamped it, as receive does
+		message.Timestamp = time.Now()
+		again, err := decodeMessage(messageToBytes(message))
+		if err != nil {
+			t.Fatalf("Failed to decode re-encoded %+v: %v", message, err)
+		}
+		if again.Type != message.Type || again.ChannelID != message.ChannelID || again.RequestID != message.RequestID {
+			t.Errorf("Expected %+v after a round trip, got %+v", message, again)
+		}
+	})
+}
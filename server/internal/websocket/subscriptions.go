@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"fethur/internal/events"
+)
+
+// Subscription message types
+const (
+	MessageTypeSubscribe      = string(events.TypeSubscribe)
+	MessageTypeUnsubscribe    = string(events.TypeUnsubscribe)
+	MessageTypeSubscribeAck   = string(events.TypeSubscribeAck)
+	MessageTypeUnsubscribeAck = string(events.TypeUnsubscribeAck)
+	MessageTypeSubscriptions  = string(events.TypeSubscriptions)
+)
+
+// SubscriptionAck is the payload of subscribe/unsubscribe acknowledgments
+type SubscriptionAck = events.SubscriptionAck
+
+// subscriptionKey is whose subscriptions are remembered: one login session
+// of a user, so each device restores its own channels. Connections made
+// with an API key have no session and share one set.
+type subscriptionKey struct {
+	userID  int
+	session string
+}
+
+// Subscriptions returns the channel IDs a user's session is subscribed to,
+// sorted ascending. The set outlives individual connections so it can be
+// restored on reconnect.
+func (h *Hub) Subscriptions(userID int, session string) []int {
+	h.subsMutex.RLock()
+	defer h.subsMutex.RUnlock()
+
+	key := subscriptionKey{userID: userID, session: session}
+	channels := make([]int, 0, len(h.subscriptions[key]))
+	for channelID := range h.subscriptions[key] {
+		channels = append(channels, channelID)
+	}
+	sort.Ints(channels)
+	return channels
+}
+
+// IsConnected reports whether a user has at least one registered connection
+func (h *Hub) IsConnected(userID int) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.clients {
+		if client.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ForgetSubscriptions drops the remembered subscriptions of a session that
+// has ended
+func (h *Hub) ForgetSubscriptions(userID int, session string) {
+	h.subsMutex.Lock()
+	defer h.subsMutex.Unlock()
+
+	delete(h.subscriptions, subscriptionKey{userID: userID, session: session})
+}
+
+// rememberSubscription records a subscription change for reconnect restoration
+func (h *Hub) rememberSubscription(key subscriptionKey, channelID int, subscribed bool) {
+	h.subsMutex.Lock()
+	defer h.subsMutex.Unlock()
+
+	if subscribed {
+		if h.subscriptions[key] == nil {
+			h.subscriptions[key] = make(map[int]bool)
+		}
+		h.subscriptions[key][channelID] = true
+		return
+	}
+
+	delete(h.subscriptions[key], channelID)
+	if len(h.subscriptions[key]) == 0 {
+		delete(h.subscriptions, key)
+	}
+}
+
+// sessionKey is the key this client's subscriptions are remembered under
+func (c *Client) sessionKey() subscriptionKey {
+	return subscriptionKey{userID: c.userID, session: c.session}
+}
+
+// subscribe authorizes and adds a channel subscription for this client
+func (c *Client) subscribe(channelID int) error {
+	c.hub.mutex.RLock()
+	authorize := c.hub.authorizeChannel
+	c.hub.mutex.RUnlock()
+
+	if authorize != nil {
+		if err := authorize(c.userID, channelID); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	c.channels[channelID] = true
+	c.mutex.Unlock()
+
+	c.hub.rememberSubscription(c.sessionKey(), channelID, true)
+	return nil
+}
+
+// unsubscribe removes a channel subscription for this client
+func (c *Client) unsubscribe(channelID int) {
+	c.mutex.Lock()
+	delete(c.channels, channelID)
+	c.mutex.Unlock()
+
+	c.hub.rememberSubscription(c.sessionKey(), channelID, false)
+
+	for _, message := range c.hub.endActivities(c, channelID) {
+		c.hub.broadcast <- message
+	}
+}
+
+// handleSubscribe handles an explicit subscribe frame and acknowledges it
+func (c *Client) handleSubscribe(message *Message) {
+	ack := SubscriptionAck{ChannelID: message.ChannelID}
+
+	if err := c.subscribe(message.ChannelID); err != nil {
+		log.Printf("User %s denied subscribing to channel %d: %v", c.username, message.ChannelID, err)
+		ack.Error = "Channel not found"
+	} else {
+		ack.Subscribed = true
+	}
+
+	c.sendAck(MessageTypeSubscribeAck, message, ack)
+
+	if ack.Subscribed {
+		c.broadcastPresence(MessageTypeJoin, message.ChannelID)
+	}
+}
+
+// handleUnsubscribe handles an explicit unsubscribe frame and acknowledges it
+func (c *Client) handleUnsubscribe(message *Message) {
+	c.unsubscribe(message.ChannelID)
+	c.sendAck(MessageTypeUnsubscribeAck, message, SubscriptionAck{ChannelID: message.ChannelID})
+	c.broadcastPresence(MessageTypeLeave, message.ChannelID)
+}
+
+// restoreSubscriptions re-subscribes a freshly connected client to the
+// channels its session was subscribed to before reconnecting
+func (c *Client) restoreSubscriptions() {
+	restored := make([]int, 0)
+	for _, channelID := range c.hub.Subscriptions(c.userID, c.session) {
+		if err := c.subscribe(channelID); err != nil {
+			// Access was lost while disconnected
+			c.hub.rememberSubscription(c.sessionKey(), channelID, false)
+			continue
+		}
+		restored = append(restored, channelID)
+	}
+
+	c.send <- messageToBytes(&Message{
+		Type:      MessageTypeSubscriptions,
+		Timestamp: time.Now(),
+		Data:      events.Subscriptions{Channels: restored},
+	})
+
+	if len(restored) > 0 {
+		log.Printf("Restored %d channel subscriptions for user %s", len(restored), c.username)
+	}
+}
+
+// sendAck replies to a request frame, echoing its request ID
+func (c *Client) sendAck(ackType string, request *Message, ack SubscriptionAck) {
+	c.send <- messageToBytes(&Message{
+		Type:      ackType,
+		RequestID: request.RequestID,
+		ChannelID: ack.ChannelID,
+		Timestamp: time.Now(),
+		Data:      ack,
+	})
+}
+
+// broadcastPresence notifies a channel that this client joined or left it
+func (c *Client) broadcastPresence(messageType string, channelID int) {
+	c.hub.broadcast <- &Message{
+		Type:      messageType,
+		ChannelID: channelID,
+		UserID:    c.userID,
+		Username:  c.username,
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriptionsRestoredOnReconnect(t *testing.T) {
+	hub := NewHub()
+	hub.SetChannelAuthorizer(func(userID, channelID int) error {
+		if channelID == 99 {
+			return errors.New("no access")
+		}
+		return nil
+	})
+
+	first := NewClient(nil, hub, 7, "alice")
+	first.SetSession("laptop")
+	if err := first.subscribe(1); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if err := first.subscribe(2); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if err := first.subscribe(99); err == nil {
+		t.Fatal("Expected subscription to an unauthorized channel to fail")
+	}
+
+	if got := hub.Subscriptions(7, "laptop"); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("Expected subscriptions [1 2], got %v", got)
+	}
+
+	// Another device of the same user starts with its own, empty set
+	phone := NewClient(nil, hub, 7, "alice")
+	phone.SetSession("phone")
+	phone.restoreSubscriptions()
+	if len(phone.channels) != 0 || len(hub.Subscriptions(7, "phone")) != 0 {
+		t.Errorf("Expected another session not to restore the laptop's channels, got %v", phone.channels)
+	}
+	<-phone.send
+
+	// A new connection for the same session picks up the remembered channels
+	second := NewClient(nil, hub, 7, "alice")
+	second.SetSession("laptop")
+	second.restoreSubscriptions()
+
+	if !second.channels[1] || !second.channels[2] {
+		t.Errorf("Expected restored client to be subscribed to 1 and 2, got %v", second.channels)
+	}
+
+	var frame Message
+	if err := json.Unmarshal(<-second.send, &frame); err != nil {
+		t.Fatalf("Failed to decode subscriptions frame: %v", err)
+	}
+	if frame.Type != MessageTypeSubscriptions {
+		t.Errorf("Expected %s frame, got %s", MessageTypeSubscriptions, frame.Type)
+	}
+
+	second.unsubscribe(1)
+	if got := hub.Subscriptions(7, "laptop"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("Expected subscriptions [2] after unsubscribe, got %v", got)
+	}
+
+	// An ended session is forgotten
+	hub.ForgetSubscriptions(7, "laptop")
+	if got := hub.Subscriptions(7, "laptop"); len(got) != 0 {
+		t.Errorf("Expected no subscriptions after the session ended, got %v", got)
+	}
+}
+
+func TestRestoreDropsChannelsThatLostAccess(t *testing.T) {
+	hub := NewHub()
+	allowed := true
+	hub.SetChannelAuthorizer(func(userID, channelID int) error {
+		if !allowed {
+			return errors.New("removed from server")
+		}
+		return nil
+	})
+
+	client := NewClient(nil, hub, 3, "bob")
+	if err := client.subscribe(5); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	allowed = false
+	reconnected := NewClient(nil, hub, 3, "bob")
+	reconnected.restoreSubscriptions()
+
+	if reconnected.channels[5] {
+		t.Error("Expected channel without access not to be restored")
+	}
+	if got := hub.Subscriptions(3, ""); len(got) != 0 {
+		t.Errorf("Expected remembered subscriptions to be cleared, got %v", got)
+	}
+}
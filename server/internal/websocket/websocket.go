@@ -7,6 +7,10 @@ import (
 	"sync"
 	"time"
 
+	"fethur/internal/chaos"
+	"fethur/internal/events"
+	"fethur/internal/wscompress"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -20,22 +24,63 @@ var upgrader = websocket.Upgrader{
 
 // Message types
 const (
-	MessageTypeText       = "text"
-	MessageTypeJoin       = "join"
-	MessageTypeLeave      = "leave"
-	MessageTypeTyping     = "typing"
-	MessageTypeStopTyping = "stop_typing"
+	MessageTypeText       = string(events.TypeText)
+	MessageTypeJoin       = string(events.TypeJoin)
+	MessageTypeLeave      = string(events.TypeLeave)
+	MessageTypeTyping     = string(events.TypeTyping)
+	MessageTypeStopTyping = string(events.TypeStopTyping)
+	MessageTypeCatchUp    = string(events.TypeCatchUp)
+	MessageTypeError      = string(events.TypeError)
 )
 
-// Message represents a WebSocket message
+// Message represents a WebSocket message. It is sent as an events.Event
+// envelope, whose fields it shares.
 type Message struct {
 	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
 	ChannelID int         `json:"channel_id,omitempty"`
 	Content   string      `json:"content,omitempty"`
 	UserID    int         `json:"user_id,omitempty"`
 	Username  string      `json:"username,omitempty"`
 	Timestamp time.Time   `json:"timestamp,omitempty"`
 	Data      interface{} `json:"data,omitempty"`
+
+	// Audience, when set, limits delivery to these users among the
+	// channel's subscribers
+	Audience map[int]bool `json:"-"`
+}
+
+// Event converts the message to the shared event envelope
+func (m Message) Event() events.Event {
+	return events.Event{
+		Type:      events.Type(m.Type),
+		RequestID: m.RequestID,
+		ChannelID: int64(m.ChannelID),
+		Content:   m.Content,
+		UserID:    int64(m.UserID),
+		Username:  m.Username,
+		Timestamp: m.Timestamp,
+		Data:      m.Data,
+	}
+}
+
+// MessageFromEvent converts a shared event envelope to a message
+func MessageFromEvent(event events.Event) *Message {
+	return &Message{
+		Type:      string(event.Type),
+		RequestID: event.RequestID,
+		ChannelID: int(event.ChannelID),
+		Content:   event.Content,
+		UserID:    int(event.UserID),
+		Username:  event.Username,
+		Timestamp: event.Timestamp,
+		Data:      event.Data,
+	}
+}
+
+// MarshalJSON encodes the message as a versioned event
+func (m Message) MarshalJSON() ([]byte, error) {
+	return events.Encode(m.Event())
 }
 
 // Hub manages all WebSocket connections
@@ -45,17 +90,59 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
+
+	authorizeChannel ChannelAuthorizer
+	listMembers      MemberLister
+	compression      wscompress.Config
+
+	subscriptions map[subscriptionKey]map[int]bool // session -> channel IDs, kept across reconnects
+	subsMutex     sync.RWMutex
+
+	activities    map[activityKey]time.Time // shown activities and when they expire
+	activityMutex sync.Mutex
+
+	compaction map[string]CompactionRule // by event type
+	bursts     map[burstKey]*burst       // used on the hub's goroutine only
+}
+
+// ChannelAuthorizer checks that a user may subscribe to a channel
+type ChannelAuthorizer func(userID, channelID int) error
+
+// SetCompression changes how new connections are compressed
+func (h *Hub) SetCompression(config wscompress.Config) {
+	h.mutex.Lock()
+	h.compression = config
+	h.mutex.Unlock()
+}
+
+// Compression returns how new connections are compressed
+func (h *Hub) Compression() wscompress.Config {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.compression
+}
+
+// SetChannelAuthorizer installs the check run before a client joins a channel
+func (h *Hub) SetChannelAuthorizer(authorizer ChannelAuthorizer) {
+	h.mutex.Lock()
+	h.authorizeChannel = authorizer
+	h.mutex.Unlock()
 }
 
 // Client represents a WebSocket client connection
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan []byte
-	userID   int
-	username string
-	channels map[int]bool // channels the user is subscribed to
-	mutex    sync.RWMutex
+	hub         *Hub
+	conn        *websocket.Conn
+	send        chan []byte
+	userID      int
+	username    string
+	remoteIP    string
+	session     string
+	compression wscompress.Config
+	readOnly    bool            // guests only listen
+	channels    map[int]bool    // channels the user is subscribed to
+	filtered    map[string]bool // event categories the client opted out of
+	mutex       sync.RWMutex
 }
 
 func NewHub() *Hub {
@@ -64,10 +151,21 @@ func NewHub() *Hub {
 		broadcast:  make(chan *Message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+
+		subscriptions: make(map[subscriptionKey]map[int]bool),
+		activities:    make(map[activityKey]time.Time),
+		compression:   wscompress.Default(),
+		compaction:    DefaultCompaction(),
+		bursts:        make(map[burstKey]*burst),
 	}
 }
 
 func (h *Hub) Run() {
+	sweep := time.NewTicker(activitySweepInterval)
+	defer sweep.Stop()
+	flush := time.NewTicker(compactionFlushInterval)
+	defer flush.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
@@ -85,40 +183,67 @@ func (h *Hub) Run() {
 			h.mutex.Unlock()
 			log.Printf("Client unregistered: %s (ID: %d)", client.username, client.userID)
 
+			// Whatever the client was doing stops with the connection
+			for _, message := range h.endActivities(client, 0) {
+				h.deliver(message)
+			}
+
 		case message := <-h.broadcast:
-			log.Printf("📡 [WEBSOCKET] Broadcasting message type %s to channel %d", message.Type, message.ChannelID)
-			h.mutex.RLock()
-			clientCount := 0
-			totalClients := len(h.clients)
-			for client := range h.clients {
-				// Check if client is subscribed to the message's channel
-				shouldSend := false
-				client.mutex.RLock()
-				if message.Type == MessageTypeText {
-					// For text messages, only send to clients subscribed to this channel
-					shouldSend = client.channels[message.ChannelID]
-				} else {
-					// For other message types, only send to subscribed clients
-					shouldSend = client.channels[message.ChannelID]
-				}
+			// Bursts past the compaction threshold wait for the next flush
+			if !h.compact(message, time.Now()) {
+				h.deliver(message)
+			}
 
-				if shouldSend {
-					clientCount++
-					select {
-					case client.send <- messageToBytes(message):
-						// Message sent successfully
-					default:
-						log.Printf("❌ [WEBSOCKET] Failed to send message to client %s, closing connection", client.username)
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
-				client.mutex.RUnlock()
+		case now := <-flush.C:
+			for _, message := range h.flushCompacted(now) {
+				h.deliver(message)
+			}
+
+		case now := <-sweep.C:
+			for _, message := range h.expireActivities(now) {
+				h.deliver(message)
+			}
+		}
+	}
+}
+
+// deliver sends a message to the clients subscribed to its channel. It
+// runs on the hub's goroutine.
+func (h *Hub) deliver(message *Message) {
+	log.Printf("📡 [WEBSOCKET] Broadcasting message type %s to channel %d", message.Type, message.ChannelID)
+	h.mutex.RLock()
+	clientCount := 0
+	totalClients := len(h.clients)
+	for client := range h.clients {
+		// Check if client is subscribed to the message's channel
+		shouldSend := false
+		client.mutex.RLock()
+		if message.Type == MessageTypeText {
+			// For text messages, only send to clients subscribed to this channel
+			shouldSend = client.channels[message.ChannelID]
+		} else {
+			// For other message types, only send to subscribed clients
+			shouldSend = client.channels[message.ChannelID]
+		}
+		// Skip event categories the client filtered out
+		shouldSend = shouldSend && client.wantsLocked(message.filterType())
+		shouldSend = shouldSend && (message.Audience == nil || message.Audience[client.userID])
+
+		if shouldSend {
+			clientCount++
+			select {
+			case client.send <- messageToBytes(message):
+				// Message sent successfully
+			default:
+				log.Printf("❌ [WEBSOCKET] Failed to send message to client %s, closing connection", client.username)
+				close(client.send)
+				delete(h.clients, client)
 			}
-			h.mutex.RUnlock()
-			log.Printf("📊 [WEBSOCKET] Broadcasted message to %d/%d clients in channel %d", clientCount, totalClients, message.ChannelID)
 		}
+		client.mutex.RUnlock()
 	}
+	h.mutex.RUnlock()
+	log.Printf("📊 [WEBSOCKET] Broadcasted message to %d/%d clients in channel %d", clientCount, totalClients, message.ChannelID)
 }
 
 func NewClient(conn *websocket.Conn, hub *Hub, userID int, username string) *Client {
@@ -129,13 +254,22 @@ func NewClient(conn *websocket.Conn, hub *Hub, userID int, username string) *Cli
 		userID:   userID,
 		username: username,
 		channels: make(map[int]bool),
+		filtered: make(map[string]bool),
+
+		compression: hub.Compression(),
 	}
 }
 
 func (c *Client) Start() {
+	// The first frame tells the client the server's clock
+	c.Send(clockMessage(MessageTypeHello, 0))
+
 	// Register client with hub
 	c.hub.register <- c
 
+	// Restore channel subscriptions from a previous connection
+	c.restoreSubscriptions()
+
 	// Start goroutines for reading and writing
 	go c.readPump()
 	go c.writePump()
@@ -171,45 +305,85 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Parse message
-		var message Message
-		if err := json.Unmarshal(messageBytes, &message); err != nil {
-			log.Printf("Failed to parse message: %v", err)
-			continue
-		}
+		c.receive(messageBytes)
+	}
+}
 
-		// Add user info to message
-		message.UserID = c.userID
-		message.Username = c.username
-		message.Timestamp = time.Now()
-
-		// Handle different message types
-		switch message.Type {
-		case MessageTypeJoin:
-			c.handleJoinChannel(message.ChannelID)
-		case MessageTypeLeave:
-			c.handleLeaveChannel(message.ChannelID)
-		case MessageTypeText:
-			c.handleTextMessage(&message)
-		case MessageTypeTyping:
-			c.handleTyping(message.ChannelID, true)
-		case MessageTypeStopTyping:
-			c.handleTyping(message.ChannelID, false)
-		case "heartbeat":
-			// Respond to heartbeat with pong
-			response := &Message{
-				Type:      "pong",
-				Timestamp: time.Now(),
-			}
-			c.send <- messageToBytes(response)
-		default:
-			log.Printf("Unknown message type: %s", message.Type)
-		}
+// receive handles one frame read from the connection
+func (c *Client) receive(data []byte) {
+	message, err := decodeMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse message from %s: %v", c.username, err)
+		c.Send(&Message{
+			Type:      MessageTypeError,
+			Content:   "Malformed message",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	// Add user info to message
+	message.UserID = c.userID
+	message.Username = c.username
+	message.Timestamp = time.Now()
+
+	c.handleMessage(message)
+}
+
+// decodeMessage parses a frame sent by a client. Anything but a JSON object
+// with a type is refused.
+func decodeMessage(data []byte) (*Message, error) {
+	event, err := events.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return MessageFromEvent(event), nil
+}
+
+// handleMessage dispatches a decoded frame by its type
+func (c *Client) handleMessage(message *Message) {
+	if c.readOnly && (message.Type == MessageTypeText || message.Type == MessageTypeTyping ||
+		message.Type == MessageTypeStopTyping || message.Type == MessageTypeActivity) {
+		c.Send(&Message{
+			Type:      MessageTypeError,
+			RequestID: message.RequestID,
+			ChannelID: message.ChannelID,
+			Content:   "Guests can only read",
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	switch message.Type {
+	case MessageTypeJoin:
+		c.handleJoinChannel(message.ChannelID)
+	case MessageTypeLeave:
+		c.handleLeaveChannel(message.ChannelID)
+	case MessageTypeSubscribe:
+		c.handleSubscribe(message)
+	case MessageTypeUnsubscribe:
+		c.handleUnsubscribe(message)
+	case MessageTypeSettings:
+		c.handleSettings(message)
+	case MessageTypeText:
+		c.handleTextMessage(message)
+	case MessageTypeTyping:
+		c.handleTyping(message.ChannelID, true)
+	case MessageTypeStopTyping:
+		c.handleTyping(message.ChannelID, false)
+	case MessageTypeActivity:
+		c.handleActivity(message)
+	case MessageTypeRequestMembers:
+		c.handleRequestMembers(message)
+	case MessageTypeHeartbeat:
+		c.handleHeartbeat(message)
+	default:
+		log.Printf("Unknown message type: %s", message.Type)
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(heartbeatPeriod)
 	defer func() {
 		ticker.Stop()
 		if err := c.conn.Close(); err != nil {
@@ -230,17 +404,10 @@ func (c *Client) writePump() {
 				}
 				return
 			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			if _, err := w.Write(message); err != nil {
-				log.Printf("Failed to write message: %v", err)
-				return
+			if chaos.DropFrame() {
+				continue
 			}
-
-			if err := w.Close(); err != nil {
+			if err := c.writeFrame(message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -251,45 +418,55 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+			// Stamped as it is written, unlike frames that wait in the queue
+			if err := c.writeFrame(messageToBytes(clockMessage(MessageTypeClock, 0))); err != nil {
+				return
+			}
 		}
 	}
 }
 
+// writeFrame writes one text frame, compressed as negotiated
+func (c *Client) writeFrame(message []byte) error {
+	c.compression.Prepare(c.conn, len(message))
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		log.Printf("Failed to write message: %v", err)
+		return err
+	}
+	return w.Close()
+}
+
+// handleJoinChannel handles the legacy join frame, which subscribes without an acknowledgment
 func (c *Client) handleJoinChannel(channelID int) {
-	c.mutex.Lock()
-	c.channels[channelID] = true
-	c.mutex.Unlock()
+	if err := c.subscribe(channelID); err != nil {
+		log.Printf("User %s denied joining channel %d: %v", c.username, channelID, err)
+		c.send <- messageToBytes(&Message{
+			Type:      MessageTypeError,
+			ChannelID: channelID,
+			Content:   "Channel not found",
+			Timestamp: time.Now(),
+		})
+		return
+	}
 
 	log.Printf("👥 [WEBSOCKET] User %s joined channel %d", c.username, channelID)
 
 	// Send join notification
-	message := &Message{
-		Type:      MessageTypeJoin,
-		ChannelID: channelID,
-		UserID:    c.userID,
-		Username:  c.username,
-		Timestamp: time.Now(),
-	}
-
-	c.hub.broadcast <- message
+	c.broadcastPresence(MessageTypeJoin, channelID)
 	log.Printf("📡 [WEBSOCKET] Broadcasted join notification for user %s in channel %d", c.username, channelID)
 }
 
+// handleLeaveChannel handles the legacy leave frame
 func (c *Client) handleLeaveChannel(channelID int) {
-	c.mutex.Lock()
-	delete(c.channels, channelID)
-	c.mutex.Unlock()
+	c.unsubscribe(channelID)
 
 	// Send leave notification
-	message := &Message{
-		Type:      MessageTypeLeave,
-		ChannelID: channelID,
-		UserID:    c.userID,
-		Username:  c.username,
-		Timestamp: time.Now(),
-	}
-
-	c.hub.broadcast <- message
+	c.broadcastPresence(MessageTypeLeave, channelID)
 	log.Printf("User %s left channel %d", c.username, channelID)
 }
 
@@ -329,6 +506,15 @@ func (c *Client) UnsubscribeFromChannel(channelID int) {
 	c.mutex.Unlock()
 }
 
+// Send queues a message for delivery to this client only
+func (c *Client) Send(message *Message) {
+	select {
+	case c.send <- messageToBytes(message):
+	default:
+		log.Printf("Send buffer full for client %s, dropping %s message", c.username, message.Type)
+	}
+}
+
 // Getter methods for accessing private fields
 func (c *Client) GetUserID() int {
 	return c.userID
@@ -342,6 +528,36 @@ func (c *Client) GetConnection() *websocket.Conn {
 	return c.conn
 }
 
+// SetRemoteIP records the client's address as seen through trusted proxies
+func (c *Client) SetRemoteIP(ip string) {
+	c.remoteIP = ip
+}
+
+// GetRemoteIP returns the client's address, or the connection's peer when
+// none was recorded
+func (c *Client) GetRemoteIP() string {
+	if c.remoteIP != "" {
+		return c.remoteIP
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// SetSession records the login session (device) the client authenticated with
+func (c *Client) SetSession(session string) {
+	c.session = session
+}
+
+// SetReadOnly stops the client from sending messages, typing or
+// activities, for guests who may only read
+func (c *Client) SetReadOnly(readOnly bool) {
+	c.readOnly = readOnly
+}
+
+// Session returns the client's login session, empty for API keys
+func (c *Client) Session() string {
+	return c.session
+}
+
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
@@ -355,9 +571,17 @@ func messageToBytes(message *Message) []byte {
 	return bytes
 }
 
-// Upgrade upgrades an HTTP connection to WebSocket
-func Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
-	return upgrader.Upgrade(w, r, nil)
+// Upgrade upgrades an HTTP connection to WebSocket, negotiating the hub's
+// compression
+func (h *Hub) Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	compression := h.Compression()
+	upgrader := compression.Upgrader(upgrader)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	compression.Configure(conn)
+	return conn, nil
 }
 
 // BroadcastMessage sends a message to all clients subscribed to a channel
@@ -0,0 +1,22 @@
+//go:build embedclient
+
+package webui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is the built web client, copied here by `make build-single`
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Embedded returns the web client compiled into the binary
+func Embedded() (fs.FS, bool) {
+	client, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil, false
+	}
+	return client, true
+}
@@ -0,0 +1,11 @@
+//go:build !embedclient
+
+package webui
+
+import "io/fs"
+
+// Embedded returns the web client compiled into the binary; this build
+// has none
+func Embedded() (fs.FS, bool) {
+	return nil, false
+}
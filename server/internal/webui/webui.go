@@ -0,0 +1,116 @@
+// Package webui serves the built web client from the server binary, so a
+// small deployment needs no separate frontend host. Build with
+// `make build-single`, which copies the client into dist and compiles with
+// the embedclient tag.
+package webui
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// immutablePrefix holds content-hashed SvelteKit assets, which never change
+// under the same name
+const immutablePrefix = "_app/immutable/"
+
+// fallbacks are the SPA entry points served for unknown paths, in order
+var fallbacks = []string{"200.html", "index.html"}
+
+// Handler serves files from a built client. Paths without a file get the
+// SPA fallback page so client-side routes survive a reload.
+type Handler struct {
+	fsys  fs.FS
+	files sync.Map // name -> *file
+}
+
+// file is a client file held in memory with its validator
+type file struct {
+	content []byte
+	etag    string
+}
+
+// New returns a handler serving fsys
+func New(fsys fs.FS) *Handler {
+	return &Handler{fsys: fsys}
+}
+
+// ServeHTTP serves the file at the request path, relative to the client
+// root, or the fallback page
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, f := h.lookup(r.URL.Path)
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	if strings.HasPrefix(name, immutablePrefix) {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		// Pages and unhashed files are revalidated so deploys show up
+		header.Set("Cache-Control", "no-cache")
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("ETag", f.etag)
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.content))
+}
+
+// lookup finds the file for a request path: the file itself, a prerendered
+// page, or the fallback page
+func (h *Handler) lookup(requestPath string) (string, *file) {
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	candidates := []string{name}
+	if name == "" {
+		candidates = []string{"index.html"}
+	} else if path.Ext(name) == "" {
+		candidates = append(candidates, name+".html", name+"/index.html")
+	}
+	// Missing assets are real 404s, not pages
+	if path.Ext(name) == "" || strings.HasSuffix(name, ".html") {
+		candidates = append(candidates, fallbacks...)
+	}
+
+	for _, candidate := range candidates {
+		if f := h.open(candidate); f != nil {
+			return candidate, f
+		}
+	}
+	return "", nil
+}
+
+// open reads a regular file, caching it with its ETag
+func (h *Handler) open(name string) *file {
+	if cached, ok := h.files.Load(name); ok {
+		return cached.(*file)
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	content, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		return nil
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write(content)
+	f := &file{content: content, etag: fmt.Sprintf(`"%x"`, hash.Sum64())}
+	h.files.Store(name, f)
+	return f
+}
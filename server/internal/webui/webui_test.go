@@ -0,0 +1,65 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandler(t *testing.T) {
+	handler := New(fstest.MapFS{
+		"index.html":                    {Data: []byte("<html>home</html>")},
+		"200.html":                      {Data: []byte("<html>app</html>")},
+		"about.html":                    {Data: []byte("<html>about</html>")},
+		"favicon.png":                   {Data: []byte("png")},
+		"_app/immutable/entry/app.js":   {Data: []byte("console.log(1)")},
+		"_app/immutable/assets/app.css": {Data: []byte("body{}")},
+	})
+	get := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	tests := []struct {
+		path, body, cacheControl string
+		code                     int
+	}{
+		{"/", "<html>home</html>", "no-cache", http.StatusOK},
+		{"/about", "<html>about</html>", "no-cache", http.StatusOK},
+		{"/servers/4/channels/9", "<html>app</html>", "no-cache", http.StatusOK},
+		{"/_app/immutable/entry/app.js", "console.log(1)", "public, max-age=31536000, immutable", http.StatusOK},
+		{"/favicon.png", "png", "no-cache", http.StatusOK},
+		{"/_app/immutable/entry/missing.js", "", "", http.StatusNotFound},
+		{"/../../etc/passwd", "<html>app</html>", "no-cache", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get("GET", tt.path, nil)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.body || w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("%s: got %q with %q", tt.path, w.Body.String(), w.Header().Get("Cache-Control"))
+		}
+	}
+
+	if contentType := get("GET", "/_app/immutable/assets/app.css", nil).Header().Get("Content-Type"); contentType != "text/css; charset=utf-8" {
+		t.Errorf("Expected a CSS content type, got %q", contentType)
+	}
+	etag := get("GET", "/", nil).Header().Get("ETag")
+	if w := get("GET", "/", map[string]string{"If-None-Match": etag}); etag == "" || w.Code != http.StatusNotModified {
+		t.Errorf("Expected a matching ETag to revalidate, got %d", w.Code)
+	}
+	if w := get("POST", "/", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,252 @@
+// Package wordfilter finds listed words in messages and censors them. It
+// ships lists of profanity and slurs per language, which servers combine
+// with words of their own.
+package wordfilter
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Category is how bad a listed word is; servers choose what to do about
+// each category
+type Category string
+
+const (
+	Profanity Category = "profanity"
+	Slur      Category = "slur"
+)
+
+// Categories are the categories a word may be listed in
+var Categories = map[Category]bool{Profanity: true, Slur: true}
+
+// Entry is a listed word. A trailing * also matches longer words that
+// start with it.
+type Entry struct {
+	Word     string   `json:"word"`
+	Category Category `json:"category"`
+}
+
+// Pack describes the lists shipped for a language
+type Pack struct {
+	Language  string `json:"language"`
+	Name      string `json:"name"`
+	Profanity int    `json:"profanity"`
+	Slurs     int    `json:"slurs"`
+}
+
+//go:embed lists/*.txt
+var lists embed.FS
+
+// packs holds the shipped lists by language code
+var packs, packEntries = loadPacks()
+
+// loadPacks parses the embedded lists. Each file is named after its
+// language code, starts with a comment giving the language's name, and
+// lists words one per line under [profanity] and [slur] headings.
+func loadPacks() (map[string]Pack, map[string][]Entry) {
+	files, err := lists.ReadDir("lists")
+	if err != nil {
+		panic(err)
+	}
+	packs := make(map[string]Pack)
+	entries := make(map[string][]Entry)
+	for _, file := range files {
+		data, err := lists.ReadFile(path.Join("lists", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		language := strings.TrimSuffix(file.Name(), ".txt")
+		pack := Pack{Language: language, Name: language}
+		var category Category
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "#"):
+				if i == 0 {
+					pack.Name = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+				}
+			case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+				category = Category(strings.Trim(line, "[]"))
+				if !Categories[category] {
+					panic(fmt.Sprintf("wordfilter: unknown category %q in %s", category, file.Name()))
+				}
+			default:
+				entries[language] = append(entries[language], Entry{Word: line, Category: category})
+				if category == Slur {
+					pack.Slurs++
+				} else {
+					pack.Profanity++
+				}
+			}
+		}
+		packs[language] = pack
+	}
+	return packs, entries
+}
+
+// Packs lists the shipped lists, by language code
+func Packs() []Pack {
+	list := make([]Pack, 0, len(packs))
+	for _, pack := range packs {
+		list = append(list, pack)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Language < list[j].Language })
+	return list
+}
+
+// HasPack reports whether lists are shipped for a language
+func HasPack(language string) bool {
+	_, ok := packs[language]
+	return ok
+}
+
+// PackEntries returns the words shipped for a language
+func PackEntries(language string) []Entry {
+	return packEntries[language]
+}
+
+// NormalizeWord returns a word as it is listed and matched, or "" when it
+// cannot be matched: a single word of letters and digits, optionally
+// ending in *
+func NormalizeWord(word string) string {
+	word = strings.TrimSpace(word)
+	prefix := strings.HasSuffix(word, "*")
+	word = strings.TrimSuffix(word, "*")
+	if word == "" {
+		return ""
+	}
+	for _, r := range word {
+		if !wordRune(r) {
+			return ""
+		}
+	}
+	word = normalize(word)
+	if prefix {
+		word += "*"
+	}
+	return word
+}
+
+// Filter matches a set of listed words
+type Filter struct {
+	exact    map[string]Category
+	prefixes []Entry
+}
+
+// New builds a filter of entries. A word listed twice keeps its worst
+// category.
+func New(entries []Entry) *Filter {
+	f := &Filter{exact: make(map[string]Category)}
+	prefixes := make(map[string]Category)
+	for _, entry := range entries {
+		word := NormalizeWord(entry.Word)
+		if word == "" {
+			continue
+		}
+		target := f.exact
+		if strings.HasSuffix(word, "*") {
+			word, target = strings.TrimSuffix(word, "*"), prefixes
+		}
+		if target[word] != Slur {
+			target[word] = entry.Category
+		}
+	}
+	for word, category := range prefixes {
+		f.prefixes = append(f.prefixes, Entry{Word: word, Category: category})
+	}
+	// Longer prefixes first, so the most specific one decides
+	sort.Slice(f.prefixes, func(i, j int) bool { return len(f.prefixes[i].Word) > len(f.prefixes[j].Word) })
+	return f
+}
+
+// Empty reports whether the filter matches nothing
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.exact) == 0 && len(f.prefixes) == 0)
+}
+
+// Match is a listed word found in a text. Start and End are byte offsets
+// of the word as written.
+type Match struct {
+	Start    int      `json:"-"`
+	End      int      `json:"-"`
+	Word     string   `json:"word"`
+	Category Category `json:"category"`
+}
+
+// Find returns the listed words in text, in order. Words match whole and
+// regardless of case, and common letter substitutions such as 4 for a or
+// $ for s are seen through.
+func (f *Filter) Find(text string) []Match {
+	if f.Empty() {
+		return nil
+	}
+	var matches []Match
+	start := -1
+	for i, r := range text + " " {
+		if wordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		word := text[start:i]
+		if category, ok := f.match(normalize(word)); ok {
+			matches = append(matches, Match{Start: start, End: i, Word: word, Category: category})
+		}
+		start = -1
+	}
+	return matches
+}
+
+func (f *Filter) match(word string) (Category, bool) {
+	if category, ok := f.exact[word]; ok {
+		return category, true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(word, prefix.Word) {
+			return prefix.Category, true
+		}
+	}
+	return "", false
+}
+
+// Censor replaces each matched word with as many asterisks as it has
+// characters
+func Censor(text string, matches []Match) string {
+	if len(matches) == 0 {
+		return text
+	}
+	var censored strings.Builder
+	last := 0
+	for _, match := range matches {
+		censored.WriteString(text[last:match.Start])
+		censored.WriteString(strings.Repeat("*", utf8.RuneCountInString(match.Word)))
+		last = match.End
+	}
+	censored.WriteString(text[last:])
+	return censored.String()
+}
+
+// wordRune reports whether r is part of a word: a letter, a digit, or a
+// symbol commonly standing in for a letter
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@' || r == '$'
+}
+
+// substitutions undoes common letter substitutions
+var substitutions = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// normalize lowercases a word and undoes letter substitutions
+func normalize(word string) string {
+	return substitutions.Replace(strings.ToLower(word))
+}
@@ -0,0 +1,72 @@
+package wordfilter
+
+import "testing"
+
+func TestFindAndCensor(t *testing.T) {
+	f := New([]Entry{
+		{Word: "darn", Category: Profanity},
+		{Word: "heck*", Category: Profanity},
+		{Word: "Zorg", Category: Slur},
+	})
+	for _, tc := range []struct {
+		text     string
+		censored string
+		matches  int
+	}{
+		{"well darn it", "well **** it", 1},
+		{"DARN! what the hecking zorg", "****! what the ******* ****", 3},
+		{"d4rn, z0rg", "****, ****", 2},
+		{"darning a sock in the zorgon", "darning a sock in the zorgon", 0},
+		{"nothing to see here", "nothing to see here", 0},
+	} {
+		matches := f.Find(tc.text)
+		if len(matches) != tc.matches {
+			t.Errorf("Find(%q) found %d words, want %d: %+v", tc.text, len(matches), tc.matches, matches)
+		}
+		if got := Censor(tc.text, matches); got != tc.censored {
+			t.Errorf("Censor(%q) = %q, want %q", tc.text, got, tc.censored)
+		}
+	}
+
+	if matches := f.Find("ZORG"); len(matches) != 1 || matches[0].Category != Slur || matches[0].Word != "ZORG" {
+		t.Errorf("Expected ZORG found as a slur as written, got %+v", matches)
+	}
+}
+
+func TestPacks(t *testing.T) {
+	packs := Packs()
+	if len(packs) == 0 {
+		t.Fatal("Expected lists to be shipped")
+	}
+	for _, pack := range packs {
+		if pack.Name == pack.Language || pack.Profanity == 0 || pack.Slurs == 0 {
+			t.Errorf("Expected %s to be named and have both categories, got %+v", pack.Language, pack)
+		}
+		for _, entry := range PackEntries(pack.Language) {
+			if NormalizeWord(entry.Word) == "" {
+				t.Errorf("Entry %q of %s can never match", entry.Word, pack.Language)
+			}
+		}
+	}
+	if !HasPack("en") || HasPack("xx") {
+		t.Error("Expected only shipped languages to have packs")
+	}
+	if matches := New(PackEntries("en")).Find("what the fucking hell"); len(matches) != 1 {
+		t.Errorf("Expected the English list to match, got %+v", matches)
+	}
+}
+
+func TestNormalizeWord(t *testing.T) {
+	for word, want := range map[string]string{
+		"Darn":      "darn",
+		" heck* ":   "heck*",
+		"Ünïcode":   "ünïcode",
+		"two words": "",
+		"*":         "",
+		"d@rn":      "darn",
+	} {
+		if got := NormalizeWord(word); got != want {
+			t.Errorf("NormalizeWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
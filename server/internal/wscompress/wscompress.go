@@ -0,0 +1,65 @@
+// Package wscompress configures permessage-deflate (RFC 7692) for the chat
+// and voice WebSocket hubs. Compression is negotiated per connection and
+// applied per message: small frames such as typing indicators and voice
+// signaling cost more CPU to deflate than they save on the wire, so only
+// messages above a threshold are compressed.
+package wscompress
+
+import (
+	"compress/flate"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config controls WebSocket compression. Disable it on CPU-constrained
+// hosts such as a Raspberry Pi, where bandwidth is usually not the
+// bottleneck.
+type Config struct {
+	Enabled   bool
+	Level     int // flate level, 1 (fastest) to 9 (smallest)
+	Threshold int // messages shorter than this many bytes are sent uncompressed
+}
+
+// Default compresses messages of 256 bytes or more at the fastest level
+func Default() Config {
+	return Config{
+		Enabled:   true,
+		Level:     flate.BestSpeed,
+		Threshold: 256,
+	}
+}
+
+// Validate checks the level and threshold
+func (c Config) Validate() error {
+	if c.Level < flate.BestSpeed || c.Level > flate.BestCompression {
+		return fmt.Errorf("compression level must be between %d and %d", flate.BestSpeed, flate.BestCompression)
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	return nil
+}
+
+// Upgrader returns a copy of upgrader that negotiates compression when it
+// is enabled
+func (c Config) Upgrader(upgrader websocket.Upgrader) websocket.Upgrader {
+	upgrader.EnableCompression = c.Enabled
+	return upgrader
+}
+
+// Configure sets the compression level of a new connection
+func (c Config) Configure(conn *websocket.Conn) {
+	if !c.Enabled {
+		return
+	}
+	if err := conn.SetCompressionLevel(c.Level); err != nil {
+		_ = conn.SetCompressionLevel(flate.BestSpeed)
+	}
+}
+
+// Prepare decides whether the next message written to conn is compressed.
+// It has no effect when the client did not negotiate compression.
+func (c Config) Prepare(conn *websocket.Conn, size int) {
+	conn.EnableWriteCompression(c.Enabled && size >= c.Threshold)
+}
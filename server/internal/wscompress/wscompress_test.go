@@ -0,0 +1,197 @@
+package wscompress
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingConn counts the bytes read from the wire
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+// pair connects a client to a server using config and returns the server
+// side, the client side and the client's received byte counter
+func pair(tb testing.TB, config Config) (*websocket.Conn, *websocket.Conn, *int64) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := config.Upgrader(websocket.Upgrader{})
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			tb.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		config.Configure(conn)
+		conns <- conn
+	}))
+	tb.Cleanup(server.Close)
+
+	var read int64
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			return countingConn{Conn: conn, read: &read}, err
+		},
+	}
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		tb.Fatalf("Failed to dial: %v", err)
+	}
+	serverConn := <-conns
+	tb.Cleanup(func() {
+		_ = client.Close()
+		_ = serverConn.Close()
+	})
+	return serverConn, client, &read
+}
+
+// send writes message from server to client and returns the bytes it took
+// on the wire
+func send(tb testing.TB, config Config, server, client *websocket.Conn, read *int64, message []byte) int64 {
+	before := atomic.LoadInt64(read)
+	config.Prepare(server, len(message))
+	if err := server.WriteMessage(websocket.TextMessage, message); err != nil {
+		tb.Fatalf("Failed to write: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, received, err := client.ReadMessage()
+	if err != nil || len(received) != len(message) {
+		tb.Fatalf("Failed to read the message back: %v", err)
+	}
+	return atomic.LoadInt64(read) - before
+}
+
+// words vary the content of benchmark messages so batches do not
+// compress unrealistically well
+var words = strings.Fields(`anyone tried the new build on a raspberry pi yet voice felt
+	snappier since update server restarted twice overnight backup finished
+	fine check logs when you get chance meeting moved thursday afternoon
+	deploy pipeline green again thanks looks good merge it`)
+
+// chatMessage is a message event as broadcast by the chat hub
+func chatMessage(id int) []byte {
+	random := rand.New(rand.NewSource(int64(id)))
+	content := make([]string, 8+random.Intn(16))
+	for i := range content {
+		content[i] = words[random.Intn(len(words))]
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":       "message",
+		"channel_id": 12,
+		"user_id":    random.Intn(500),
+		"username":   fmt.Sprintf("user%d", random.Intn(500)),
+		"content":    strings.Join(content, " "),
+		"timestamp":  time.Date(2026, 10, 15, 12, 0, random.Intn(3600), 0, time.UTC),
+		"data": map[string]interface{}{
+			"id":          1000 + id,
+			"reactions":   []interface{}{},
+			"attachments": []interface{}{},
+		},
+	})
+	return data
+}
+
+// payloads are representative messages, from voice signaling to a
+// reconnect catch-up batch
+func payloads() map[string][]byte {
+	batch := make([]json.RawMessage, 0, 50)
+	for i := 0; i < 50; i++ {
+		batch = append(batch, chatMessage(i))
+	}
+	catchUp, _ := json.Marshal(map[string]interface{}{"type": "catch_up", "data": batch})
+	return map[string][]byte{
+		"signal":   []byte(`{"type":"speaking","channel_id":7,"user_id":34,"data":{"speaking":true}}`),
+		"message":  chatMessage(1),
+		"catch_up": catchUp,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		valid  bool
+	}{
+		{Default(), true},
+		{Config{Enabled: true, Level: flate.BestCompression}, true},
+		{Config{Enabled: true, Level: 0}, false},
+		{Config{Enabled: true, Level: 10}, false},
+		{Config{Enabled: true, Level: 1, Threshold: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, expected valid=%v", tt.config, err, tt.valid)
+		}
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	messages := payloads()
+
+	disabled := Config{Enabled: false, Level: flate.BestSpeed}
+	server, client, read := pair(t, disabled)
+	plain := send(t, disabled, server, client, read, messages["catch_up"])
+	if plain < int64(len(messages["catch_up"])) {
+		t.Fatalf("Expected an uncompressed batch to take at least %d bytes, took %d", len(messages["catch_up"]), plain)
+	}
+
+	config := Default()
+	server, client, read = pair(t, config)
+	if compressed := send(t, config, server, client, read, messages["catch_up"]); compressed*4 > plain {
+		t.Errorf("Expected the batch to compress at least 4x, took %d of %d bytes", compressed, plain)
+	}
+	// Below the threshold messages go out as they are
+	signal := messages["signal"]
+	if size := send(t, config, server, client, read, signal); size < int64(len(signal)) {
+		t.Errorf("Expected a small message to be sent uncompressed, took %d of %d bytes", size, len(signal))
+	}
+}
+
+// BenchmarkWrite measures the time to deliver each payload from server to
+// client, including deflate and inflate, and reports its size on the wire.
+// Run with: go test ./internal/wscompress -bench . -benchmem
+func BenchmarkWrite(b *testing.B) {
+	configs := []struct {
+		name   string
+		config Config
+	}{
+		{"off", Config{Enabled: false, Level: flate.BestSpeed}},
+		{"level1", Config{Enabled: true, Level: flate.BestSpeed}},
+		{"level6", Config{Enabled: true, Level: flate.DefaultCompression}},
+		{"level9", Config{Enabled: true, Level: flate.BestCompression}},
+	}
+	messages := payloads()
+	for _, name := range []string{"signal", "message", "catch_up"} {
+		message := messages[name]
+		for _, c := range configs {
+			b.Run(name+"/"+c.name, func(b *testing.B) {
+				server, client, read := pair(b, c.config)
+				var wire int64
+				b.SetBytes(int64(len(message)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					wire += send(b, c.config, server, client, read, message)
+				}
+				b.ReportMetric(float64(wire)/float64(b.N), "wire-B/op")
+			})
+		}
+	}
+}
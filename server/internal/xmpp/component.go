@@ -0,0 +1,280 @@
+package xmpp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"fethur/internal/resilience"
+)
+
+// Config configures the connection to the XMPP server
+type Config struct {
+	Addr   string // the server's component port, e.g. localhost:5347
+	Domain string // the subdomain routed to Fethur, e.g. chat.example.com
+	Secret string // shared component secret
+}
+
+// Handler receives stanzas addressed to the component
+type Handler interface {
+	HandleMessage(message Message)
+	HandlePresence(presence Presence)
+	HandleIQ(iq IQ)
+}
+
+// ErrNotConnected is returned when sending while the stream is down
+var ErrNotConnected = errors.New("xmpp component is not connected")
+
+// reconnectBackoff spaces out reconnection attempts
+var reconnectBackoff = resilience.Backoff{Base: time.Second, Max: time.Minute}
+
+// Component keeps a component stream open and reconnects when it drops
+type Component struct {
+	config  Config
+	handler Handler
+	guard   *resilience.Guard
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	encoder *xml.Encoder
+}
+
+// NewComponent creates a component; call Run to connect
+func NewComponent(config Config, handler Handler) (*Component, error) {
+	if config.Addr == "" || config.Domain == "" || config.Secret == "" {
+		return nil, fmt.Errorf("XMPP component needs an address, domain and secret")
+	}
+	return &Component{config: config, handler: handler}, nil
+}
+
+// Domain returns the component's domain
+func (c *Component) Domain() string {
+	return c.config.Domain
+}
+
+// SetGuard reports connections and disconnections to guard, so the
+// bridge's health is shown with the server's other integrations. Call it
+// before Run.
+func (c *Component) SetGuard(guard *resilience.Guard) {
+	c.guard = guard
+}
+
+// Connected reports whether the stream is up
+func (c *Component) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn != nil
+}
+
+// Run connects and serves stanzas until ctx is cancelled, reconnecting
+// with backoff after failures
+func (c *Component) Run(ctx context.Context) {
+	failures := 0
+	for {
+		started := time.Now()
+		err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			failures = 0
+		}
+		if c.guard != nil {
+			c.guard.Record(err)
+		}
+		backoff := reconnectBackoff.Delay(failures)
+		failures++
+		log.Printf("XMPP component disconnected: %v; reconnecting in %s", err, backoff.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// session runs one connection from handshake to disconnect
+func (c *Component) session(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+	defer func() {
+		c.mutex.Lock()
+		c.conn, c.encoder = nil, nil
+		c.mutex.Unlock()
+		_ = conn.Close()
+	}()
+
+	decoder, err := c.handshake(conn)
+	if err != nil {
+		return err
+	}
+	log.Printf("XMPP component connected as %s", c.config.Domain)
+	if c.guard != nil {
+		c.guard.Record(nil)
+	}
+
+	c.mutex.Lock()
+	c.conn, c.encoder = conn, xml.NewEncoder(conn)
+	c.mutex.Unlock()
+
+	return c.serve(decoder)
+}
+
+// handshake opens the stream and authenticates with the shared secret
+func (c *Component) handshake(conn net.Conn) (*xml.Decoder, error) {
+	_ = conn.SetDeadline(time.Now().Add(15 * time.Second))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	if _, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>",
+		NSComponent, NSStream, xmlEscape(c.config.Domain)); err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(conn)
+	var streamID string
+	for streamID == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "stream" {
+				return nil, fmt.Errorf("unexpected <%s> before stream header", start.Name.Local)
+			}
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					streamID = attr.Value
+				}
+			}
+			if streamID == "" {
+				return nil, fmt.Errorf("stream header has no id")
+			}
+		}
+	}
+
+	digest := sha1.Sum([]byte(streamID + c.config.Secret))
+	if _, err := fmt.Fprintf(conn, "<handshake>%s</handshake>", hex.EncodeToString(digest[:])); err != nil {
+		return nil, err
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "handshake":
+			if err := decoder.Skip(); err != nil {
+				return nil, err
+			}
+			return decoder, nil
+		case "error":
+			return nil, fmt.Errorf("handshake rejected: %s", streamErrorCondition(decoder, start))
+		default:
+			return nil, fmt.Errorf("unexpected <%s> during handshake", start.Name.Local)
+		}
+	}
+}
+
+// serve dispatches stanzas until the stream ends
+func (c *Component) serve(decoder *xml.Decoder) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("stream closed")
+			}
+			return err
+		}
+		switch element := token.(type) {
+		case xml.EndElement:
+			if element.Name.Local == "stream" {
+				return fmt.Errorf("stream closed by server")
+			}
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "message":
+				var message Message
+				if err := decoder.DecodeElement(&message, &element); err != nil {
+					return err
+				}
+				c.handler.HandleMessage(message)
+			case "presence":
+				var presence Presence
+				if err := decoder.DecodeElement(&presence, &element); err != nil {
+					return err
+				}
+				c.handler.HandlePresence(presence)
+			case "iq":
+				var iq IQ
+				if err := decoder.DecodeElement(&iq, &element); err != nil {
+					return err
+				}
+				c.handler.HandleIQ(iq)
+			case "error":
+				return fmt.Errorf("stream error: %s", streamErrorCondition(decoder, element))
+			default:
+				if err := decoder.Skip(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Send writes a stanza to the stream
+func (c *Component) Send(stanza interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.encoder == nil {
+		return ErrNotConnected
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.encoder.Encode(stanza); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// streamErrorCondition reads the condition name of a stream error
+func streamErrorCondition(decoder *xml.Decoder, start xml.StartElement) string {
+	var streamError struct {
+		Conditions []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	}
+	if err := decoder.DecodeElement(&streamError, &start); err != nil || len(streamError.Conditions) == 0 {
+		return "unknown"
+	}
+	return streamError.Conditions[0].XMLName.Local
+}
+
+func xmlEscape(value string) string {
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
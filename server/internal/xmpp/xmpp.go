@@ -0,0 +1,215 @@
+// Package xmpp implements a minimal XMPP external component (XEP-0114), so
+// an existing XMPP server can route stanzas for a subdomain to Fethur.
+package xmpp
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Namespaces used by the gateway
+const (
+	NSComponent = "jabber:component:accept"
+	NSStream    = "http://etherx.jabber.org/streams"
+	NSStanzas   = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	NSMUC       = "http://jabber.org/protocol/muc"
+	NSMUCUser   = "http://jabber.org/protocol/muc#user"
+	NSDiscoInfo = "http://jabber.org/protocol/disco#info"
+	NSDiscoItem = "http://jabber.org/protocol/disco#items"
+)
+
+// JID is a parsed Jabber ID: local@domain/resource
+type JID struct {
+	Local    string
+	Domain   string
+	Resource string
+}
+
+// ParseJID splits a JID into its parts. Local parts and domains are
+// compared case-insensitively, so they are lowercased.
+func ParseJID(value string) JID {
+	var jid JID
+	if i := strings.Index(value, "/"); i >= 0 {
+		jid.Resource = value[i+1:]
+		value = value[:i]
+	}
+	if i := strings.Index(value, "@"); i >= 0 {
+		jid.Local = strings.ToLower(value[:i])
+		value = value[i+1:]
+	}
+	jid.Domain = strings.ToLower(value)
+	return jid
+}
+
+// Bare returns the JID without its resource
+func (j JID) Bare() string {
+	if j.Local == "" {
+		return j.Domain
+	}
+	return j.Local + "@" + j.Domain
+}
+
+// String returns the full JID
+func (j JID) String() string {
+	if j.Resource == "" {
+		return j.Bare()
+	}
+	return j.Bare() + "/" + j.Resource
+}
+
+// Message is a message stanza
+type Message struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Subject string   `xml:"subject,omitempty"`
+	Body    string   `xml:"body,omitempty"`
+	Error   *Error   `xml:"error,omitempty"`
+}
+
+// Presence is a presence stanza
+type Presence struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	MUCUser *MUCUser `xml:"http://jabber.org/protocol/muc#user x,omitempty"`
+	Error   *Error   `xml:"error,omitempty"`
+}
+
+// MUCUser carries occupant details in room presence
+type MUCUser struct {
+	Item   MUCItem     `xml:"item"`
+	Status []MUCStatus `xml:"status,omitempty"`
+}
+
+// MUCItem describes an occupant's affiliation and role
+type MUCItem struct {
+	Affiliation string `xml:"affiliation,attr"`
+	Role        string `xml:"role,attr"`
+	JID         string `xml:"jid,attr,omitempty"`
+}
+
+// MUCStatus is a room status code, e.g. 110 for self-presence
+type MUCStatus struct {
+	Code int `xml:"code,attr"`
+}
+
+// IQ is an info/query stanza. Payload holds the raw child element.
+type IQ struct {
+	XMLName xml.Name `xml:"iq"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+	Payload []byte   `xml:",innerxml"`
+	Error   *Error   `xml:"error,omitempty"`
+}
+
+// Error is a stanza error with a defined condition, e.g. item-not-found
+type Error struct {
+	Type      string `xml:"type,attr"`
+	Condition string `xml:"-"`
+	Text      string `xml:"-"`
+}
+
+// MarshalXML writes the condition and text as namespaced children
+func (e Error) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	start.Attr = []xml.Attr{{Name: xml.Name{Local: "type"}, Value: e.Type}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	condition := xml.StartElement{Name: xml.Name{Space: NSStanzas, Local: e.Condition}}
+	if err := encoder.EncodeToken(condition); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(condition.End()); err != nil {
+		return err
+	}
+	if e.Text != "" {
+		text := xml.StartElement{Name: xml.Name{Space: NSStanzas, Local: "text"}}
+		if err := encoder.EncodeElement(e.Text, text); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// UnmarshalXML reads the first namespaced condition and text
+func (e *Error) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" {
+			e.Type = attr.Value
+		}
+	}
+	var child struct {
+		Inner []struct {
+			XMLName xml.Name
+			Text    string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := decoder.DecodeElement(&child, &start); err != nil {
+		return err
+	}
+	for _, inner := range child.Inner {
+		if inner.XMLName.Local == "text" {
+			e.Text = inner.Text
+		} else if e.Condition == "" {
+			e.Condition = inner.XMLName.Local
+		}
+	}
+	return nil
+}
+
+// NewError creates a stanza error
+func NewError(errorType, condition, text string) *Error {
+	return &Error{Type: errorType, Condition: condition, Text: text}
+}
+
+// DiscoIdentity is a disco#info identity
+type DiscoIdentity struct {
+	Category string `xml:"category,attr"`
+	Type     string `xml:"type,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+}
+
+// DiscoFeature is a disco#info feature
+type DiscoFeature struct {
+	Var string `xml:"var,attr"`
+}
+
+// DiscoInfo is a disco#info result payload
+type DiscoInfo struct {
+	XMLName    xml.Name        `xml:"http://jabber.org/protocol/disco#info query"`
+	Identities []DiscoIdentity `xml:"identity"`
+	Features   []DiscoFeature  `xml:"feature"`
+}
+
+// DiscoItem is a disco#items entry
+type DiscoItem struct {
+	JID  string `xml:"jid,attr"`
+	Name string `xml:"name,attr,omitempty"`
+}
+
+// DiscoItems is a disco#items result payload
+type DiscoItems struct {
+	XMLName xml.Name    `xml:"http://jabber.org/protocol/disco#items query"`
+	Items   []DiscoItem `xml:"item"`
+}
+
+// QueryNamespace returns the namespace of an IQ's payload element
+func (iq IQ) QueryNamespace() string {
+	decoder := xml.NewDecoder(strings.NewReader(string(iq.Payload)))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "error" {
+			return start.Name.Space
+		}
+	}
+}
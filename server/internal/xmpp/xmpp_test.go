@@ -0,0 +1,148 @@
+package xmpp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  JID
+		bare  string
+	}{
+		{"Alice@Example.com/phone", JID{"alice", "example.com", "phone"}, "alice@example.com"},
+		{"channel-3@chat.example.com/Bob/2", JID{"channel-3", "chat.example.com", "Bob/2"}, "channel-3@chat.example.com"},
+		{"chat.example.com", JID{"", "chat.example.com", ""}, "chat.example.com"},
+	}
+	for _, tt := range tests {
+		got := ParseJID(tt.value)
+		if got != tt.want || got.Bare() != tt.bare {
+			t.Errorf("ParseJID(%q) = %+v (%s), want %+v", tt.value, got, got.Bare(), tt.want)
+		}
+	}
+}
+
+func TestErrorRoundTrip(t *testing.T) {
+	data, err := xml.Marshal(Message{To: "a@b", Type: "error", Error: NewError("cancel", "item-not-found", "No such room")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">`) {
+		t.Errorf("Unexpected error encoding: %s", data)
+	}
+
+	var message Message
+	if err := xml.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Error == nil || message.Error.Condition != "item-not-found" || message.Error.Text != "No such room" {
+		t.Errorf("Unexpected decoded error: %+v", message.Error)
+	}
+}
+
+// recordingHandler forwards received stanzas to a channel
+type recordingHandler struct {
+	stanzas chan interface{}
+}
+
+func (h *recordingHandler) HandleMessage(message Message)    { h.stanzas <- message }
+func (h *recordingHandler) HandlePresence(presence Presence) { h.stanzas <- presence }
+func (h *recordingHandler) HandleIQ(iq IQ)                   { h.stanzas <- iq }
+
+func TestComponentSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		reader := bufio.NewReader(conn)
+		decoder := xml.NewDecoder(reader)
+
+		// Wait for the stream header, then expect the handshake digest
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return
+			}
+			if start, ok := token.(xml.StartElement); ok && start.Name.Local == "stream" {
+				break
+			}
+		}
+		_, _ = fmt.Fprint(conn, "<stream:stream xmlns='jabber:component:accept' xmlns:stream='http://etherx.jabber.org/streams' from='chat.example.com' id='abc123'>")
+
+		var handshake struct {
+			Digest string `xml:",chardata"`
+		}
+		if err := decoder.Decode(&handshake); err != nil {
+			return
+		}
+		expected := sha1.Sum([]byte("abc123secret"))
+		if handshake.Digest != hex.EncodeToString(expected[:]) {
+			_, _ = fmt.Fprint(conn, "<stream:error><not-authorized xmlns='urn:ietf:params:xml:ns:xmpp-streams'/></stream:error>")
+			return
+		}
+		_, _ = fmt.Fprint(conn, "<handshake/>")
+		_, _ = fmt.Fprint(conn, "<message from='alice@example.com/phone' to='channel-1@chat.example.com' type='groupchat' id='m1'><body>hi &amp; bye</body></message>")
+
+		var message Message
+		if err := decoder.Decode(&message); err != nil {
+			return
+		}
+		received <- message.Body
+	}()
+
+	handler := &recordingHandler{stanzas: make(chan interface{}, 4)}
+	component, err := NewComponent(Config{Addr: listener.Addr().String(), Domain: "chat.example.com", Secret: "secret"}, handler)
+	if err != nil {
+		t.Fatalf("Failed to create component: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go component.Run(ctx)
+
+	select {
+	case stanza := <-handler.stanzas:
+		message, ok := stanza.(Message)
+		if !ok || message.Body != "hi & bye" || message.Type != "groupchat" || ParseJID(message.From).Resource != "phone" {
+			t.Fatalf("Unexpected stanza: %+v", stanza)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a stanza")
+	}
+
+	if !component.Connected() {
+		t.Fatal("Expected the component to be connected")
+	}
+	if err := component.Send(Message{From: "channel-1@chat.example.com/bob", To: "alice@example.com/phone", Type: "groupchat", Body: "hello <alice>"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	select {
+	case body := <-received:
+		if body != "hello <alice>" {
+			t.Errorf("Unexpected body %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the server to receive the message")
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// User is an account
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role,omitempty"`
+	OrgID    int    `json:"org_id,omitempty"`
+}
+
+// Server is a server the user is a member of
+type Server struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OwnerID     int    `json:"owner_id"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Channel is a text or voice channel of a server
+type Channel struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	ChannelType string `json:"channel_type"`
+	Private     bool   `json:"private"`
+	Public      bool   `json:"public"`
+	UserLimit   int    `json:"user_limit"`
+	OwnerID     *int   `json:"owner_id"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Message is a chat message
+type Message struct {
+	ID        int    `json:"id"`
+	ChannelID int    `json:"channel_id"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	Edited    bool   `json:"edited,omitempty"`
+	ReplyToID *int   `json:"reply_to_id,omitempty"`
+}
+
+// historyEntry is a message as channel history lists it
+type historyEntry struct {
+	ID        int    `json:"id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"createdAt"`
+	AuthorID  int    `json:"authorId"`
+	Author    struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	IsEdited  bool `json:"isEdited"`
+	ReplyToID *int `json:"replyToId"`
+}
+
+// Servers lists the servers the user is a member of
+func (c *Client) Servers(ctx context.Context) ([]Server, error) {
+	var resp struct {
+		Servers []Server `json:"servers"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/servers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Servers, nil
+}
+
+// Channels lists the channels of a server the user can see
+func (c *Client) Channels(ctx context.Context, serverID int) ([]Channel, error) {
+	var resp struct {
+		Channels []Channel `json:"channels"`
+	}
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/api/servers/%d/channels", serverID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Channels, nil
+}
+
+// Messages returns the latest messages of a channel, newest first
+func (c *Client) Messages(ctx context.Context, channelID int) ([]Message, error) {
+	var resp struct {
+		Messages []historyEntry `json:"messages"`
+	}
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/api/channels/%d/messages", channelID), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(resp.Messages))
+	for _, entry := range resp.Messages {
+		messages = append(messages, Message{
+			ID:        entry.ID,
+			ChannelID: channelID,
+			UserID:    entry.AuthorID,
+			Username:  entry.Author.Username,
+			Content:   entry.Content,
+			CreatedAt: entry.CreatedAt,
+			Edited:    entry.IsEdited,
+			ReplyToID: entry.ReplyToID,
+		})
+	}
+	return messages, nil
+}
+
+// SendMessage posts a message to a channel
+func (c *Client) SendMessage(ctx context.Context, channelID int, content string) (*Message, error) {
+	return c.sendMessage(ctx, channelID, map[string]interface{}{"content": content})
+}
+
+// Reply posts a message answering another one, in its thread
+func (c *Client) Reply(ctx context.Context, channelID, replyToID int, content string) (*Message, error) {
+	return c.sendMessage(ctx, channelID, map[string]interface{}{"content": content, "reply_to_id": replyToID})
+}
+
+func (c *Client) sendMessage(ctx context.Context, channelID int, body map[string]interface{}) (*Message, error) {
+	var resp struct {
+		Data struct {
+			ID        int    `json:"id"`
+			UserID    int    `json:"user_id"`
+			Username  string `json:"username"`
+			Content   string `json:"content"`
+			CreatedAt string `json:"created_at"`
+			ReplyToID *int   `json:"reply_to_id"`
+		} `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodPost, fmt.Sprintf("/api/channels/%d/messages", channelID), body, &resp); err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:        resp.Data.ID,
+		ChannelID: channelID,
+		UserID:    resp.Data.UserID,
+		Username:  resp.Data.Username,
+		Content:   resp.Data.Content,
+		CreatedAt: resp.Data.CreatedAt,
+		ReplyToID: resp.Data.ReplyToID,
+	}, nil
+}
+
+// EditMessage replaces the content of one of the user's messages
+func (c *Client) EditMessage(ctx context.Context, messageID int, content string) error {
+	return c.Do(ctx, http.MethodPut, fmt.Sprintf("/api/messages/%d", messageID), map[string]string{"content": content}, nil)
+}
+
+// DeleteMessage deletes a message
+func (c *Client) DeleteMessage(ctx context.Context, messageID int) error {
+	return c.Do(ctx, http.MethodDelete, fmt.Sprintf("/api/messages/%d", messageID), nil, nil)
+}
@@ -0,0 +1,268 @@
+// Package client is a Go SDK for Fethur. It signs in over the REST API,
+// calls the message APIs and follows the WebSocket event stream, which
+// reconnects with backoff and hands events to typed handlers. Bots that do
+// not want to run inside the server as plugins can be built on it:
+//
+//	api := client.New("https://chat.example.com")
+//	if _, err := api.Login(ctx, "bot", "password"); err != nil {
+//		log.Fatal(err)
+//	}
+//	stream := api.Stream()
+//	stream.OnMessage(func(message client.Message) {
+//		if message.Content == "!ping" {
+//			_, _ = api.SendMessage(ctx, message.ChannelID, "pong")
+//		}
+//	})
+//	log.Fatal(stream.Run(ctx))
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTwoFactorRequired is returned by Login for accounts with two-factor
+// sign-in; finish with VerifyTwoFactor
+var ErrTwoFactorRequired = errors.New("two-factor code required")
+
+// APIError is a request the server refused
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string // the server's error message
+	Code       string // machine-readable reason, when the server sends one
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Client calls the REST API as one user. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	apiKey string
+
+	mutex        sync.RWMutex
+	token        string
+	refreshToken string
+	challenge    string
+	user         User
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for REST calls
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// WithTokens signs in with tokens from an earlier session instead of a
+// password. The refresh token may be empty.
+func WithTokens(token, refreshToken string) Option {
+	return func(c *Client) {
+		c.token = token
+		c.refreshToken = refreshToken
+	}
+}
+
+// WithAPIKey authenticates with a personal API key from
+// /api/auth/tokens instead of a session. Keys do not expire unless created
+// to, so bots need not sign in or refresh.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// New creates a client for the server at baseURL, including any base path
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 15 * time.Second},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Token returns the current access token
+func (c *Client) Token() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.token
+}
+
+// RefreshToken returns the current refresh token, to resume the session
+// later with WithTokens
+func (c *Client) RefreshToken() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.refreshToken
+}
+
+// User returns the signed in user, if the client signed in with a password
+func (c *Client) User() User {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.user
+}
+
+// session is the response to a sign-in or refresh
+type session struct {
+	Token             string `json:"token"`
+	RefreshToken      string `json:"refresh_token"`
+	User              *User  `json:"user"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+func (c *Client) startSession(s session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.token = s.Token
+	if s.RefreshToken != "" {
+		c.refreshToken = s.RefreshToken
+	}
+	if s.User != nil {
+		c.user = *s.User
+	}
+	c.challenge = ""
+}
+
+// Login signs in with a username and password. Accounts with two-factor
+// sign-in get ErrTwoFactorRequired and must call VerifyTwoFactor.
+func (c *Client) Login(ctx context.Context, username, password string) (*User, error) {
+	var s session
+	if err := c.send(ctx, http.MethodPost, "/api/auth/login", map[string]string{
+		"username": username,
+		"password": password,
+	}, &s, false); err != nil {
+		return nil, err
+	}
+	if s.TwoFactorRequired {
+		c.mutex.Lock()
+		c.challenge = s.ChallengeToken
+		c.mutex.Unlock()
+		return nil, ErrTwoFactorRequired
+	}
+	c.startSession(s)
+	user := c.User()
+	return &user, nil
+}
+
+// VerifyTwoFactor finishes a sign-in that returned ErrTwoFactorRequired with
+// an authenticator or backup code
+func (c *Client) VerifyTwoFactor(ctx context.Context, code string) (*User, error) {
+	c.mutex.RLock()
+	challenge := c.challenge
+	c.mutex.RUnlock()
+	if challenge == "" {
+		return nil, errors.New("no two-factor sign-in in progress")
+	}
+
+	var s session
+	if err := c.send(ctx, http.MethodPost, "/api/auth/2fa/verify", map[string]string{
+		"challenge_token": challenge,
+		"code":            code,
+	}, &s, false); err != nil {
+		return nil, err
+	}
+	c.startSession(s)
+	user := c.User()
+	return &user, nil
+}
+
+// Refresh trades the refresh token for a new access token. Requests do this
+// on their own when the access token expires.
+func (c *Client) Refresh(ctx context.Context) error {
+	refreshToken := c.RefreshToken()
+	if refreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	var s session
+	if err := c.send(ctx, http.MethodPost, "/api/auth/refresh", map[string]string{
+		"refresh_token": refreshToken,
+	}, &s, false); err != nil {
+		return err
+	}
+	c.startSession(s)
+	return nil
+}
+
+// Do calls an API endpoint with a JSON body and decodes the JSON response
+// into out. It is the escape hatch for endpoints without a method here.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.send(ctx, method, path, body, out, true)
+}
+
+// send calls the API. When retry is set and the access token has expired,
+// the session is refreshed once and the request repeated.
+func (c *Client) send(ctx context.Context, method, path string, body, out interface{}, retry bool) error {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token := c.Token()
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusUnauthorized && retry && token != "" && c.RefreshToken() != "" {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if err := c.Refresh(ctx); err != nil {
+			return err
+		}
+		return c.send(ctx, method, path, body, out, false)
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: failure.Error, Code: failure.Code}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
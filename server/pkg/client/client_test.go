@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer answers the few endpoints the SDK uses. Access token "old"
+// has expired; refreshing it yields "new". API key "fk_bot" is accepted
+// too.
+func fakeServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	var mutex sync.Mutex
+	connections := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"Invalid credentials"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"token":"old","refresh_token":"r1","user":{"id":7,"username":"bot"}}`))
+	})
+	mux.HandleFunc("/api/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"token":"new","refresh_token":"r2"}`))
+	})
+	mux.HandleFunc("/api/servers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" && r.Header.Get("X-API-Key") != "fk_bot" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"servers":[{"id":1,"name":"Home"}]}`))
+	})
+	mux.HandleFunc("/api/channels/5/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"success":true,"data":{"id":40,"channel_id":"5","user_id":7,"username":"bot","content":"hi"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"messages":[{"id":39,"content":"hello","authorId":8,"author":{"id":8,"username":"ada"},"isEdited":true}]}`))
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "new" && r.Header.Get("X-API-Key") != "fk_bot" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		mutex.Lock()
+		connections++
+		first := connections == 1
+		mutex.Unlock()
+
+		// Answer the subscription, announce a message, and drop the first
+		// connection to make the stream reconnect
+		var request Event
+		if err := conn.ReadJSON(&request); err != nil || request.Type != EventSubscribe {
+			t.Errorf("Expected a subscription, got %+v (%v)", request, err)
+			return
+		}
+		_ = conn.WriteJSON(Event{Type: EventSubscribeAck, ChannelID: request.ChannelID, Data: SubscriptionAck{ChannelID: int(request.ChannelID), Subscribed: true}})
+		_ = conn.WriteJSON(Event{Type: EventText, ChannelID: 5, UserID: 8, Username: "ada", Content: "hello", Data: map[string]interface{}{"id": 41}})
+		if first {
+			return
+		}
+		_, _, _ = conn.ReadMessage()
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestClientREST(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	api := New(server.URL + "/")
+	if _, err := api.Login(ctx, "bot", "wrong"); err == nil {
+		t.Fatal("Expected a wrong password to be refused")
+	} else {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Invalid credentials" {
+			t.Errorf("Expected an API error, got %v", err)
+		}
+	}
+
+	user, err := api.Login(ctx, "bot", "secret")
+	if err != nil || user.ID != 7 {
+		t.Fatalf("Expected to sign in as user 7, got %+v (%v)", user, err)
+	}
+
+	// The expired token is refreshed on the first 401
+	servers, err := api.Servers(ctx)
+	if err != nil || len(servers) != 1 || servers[0].Name != "Home" {
+		t.Fatalf("Expected one server, got %+v (%v)", servers, err)
+	}
+	if api.Token() != "new" || api.RefreshToken() != "r2" {
+		t.Errorf("Expected the session to be refreshed, got %s %s", api.Token(), api.RefreshToken())
+	}
+
+	messages, err := api.Messages(ctx, 5)
+	if err != nil || len(messages) != 1 || messages[0].Username != "ada" || messages[0].ChannelID != 5 || !messages[0].Edited {
+		t.Errorf("Unexpected history %+v (%v)", messages, err)
+	}
+	sent, err := api.SendMessage(ctx, 5, "hi")
+	if err != nil || sent.ID != 40 || sent.ChannelID != 5 || sent.Content != "hi" {
+		t.Errorf("Unexpected sent message %+v (%v)", sent, err)
+	}
+}
+
+func TestClientAPIKey(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api := New(server.URL, WithAPIKey("fk_bot"))
+	if servers, err := api.Servers(ctx); err != nil || len(servers) != 1 {
+		t.Fatalf("Expected one server, got %+v (%v)", servers, err)
+	}
+
+	stream := api.Stream()
+	stream.OnConnect(func() {
+		_ = stream.Subscribe(5)
+	})
+	acks := make(chan struct{}, 1)
+	stream.On(EventSubscribeAck, func(Event) {
+		acks <- struct{}{}
+	})
+	done := make(chan error)
+	go func() {
+		done <- stream.Run(ctx)
+	}()
+	select {
+	case <-acks:
+	case err := <-done:
+		t.Fatalf("Expected the key to open the stream, got %v", err)
+	}
+	cancel()
+	<-done
+}
+
+func TestStreamReconnects(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An expired token is refreshed for the handshake as well
+	api := New(server.URL, WithTokens("old", "r1"))
+	stream := api.Stream()
+	stream.SetBackoff(10*time.Millisecond, 20*time.Millisecond)
+
+	connects := 0
+	stream.OnConnect(func() {
+		connects++
+		if err := stream.Subscribe(5); err != nil {
+			t.Errorf("Failed to subscribe: %v", err)
+		}
+	})
+	acks := make(chan SubscriptionAck, 4)
+	stream.On(EventSubscribeAck, func(event Event) {
+		var ack SubscriptionAck
+		if err := DecodeData(event.Data, &ack); err != nil {
+			t.Errorf("Failed to decode ack: %v", err)
+		}
+		acks <- ack
+	})
+	messages := make(chan Message, 4)
+	stream.OnMessage(func(message Message) {
+		messages <- message
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- stream.Run(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ack := <-acks:
+			if ack.ChannelID != 5 || !ack.Subscribed {
+				t.Errorf("Unexpected ack %+v", ack)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for subscription %d", i+1)
+		}
+		select {
+		case message := <-messages:
+			if message.ID != 41 || message.ChannelID != 5 || message.Username != "ada" || message.Content != "hello" {
+				t.Errorf("Unexpected message %+v", message)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for message %d", i+1)
+		}
+	}
+	if connects != 2 {
+		t.Errorf("Expected to reconnect once, connected %d times", connects)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Run to stop cleanly, got %v", err)
+	}
+	if err := stream.Send(Event{Type: EventHeartbeat}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected sending after Run to fail, got %v", err)
+	}
+}
+
+func TestStreamGivesUpOnRevokedSession(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := New(server.URL, WithTokens("old", "r1")).Stream().Run(ctx); err == nil || ctx.Err() != nil {
+		t.Errorf("Expected Run to stop on a revoked session, got %v", err)
+	}
+}
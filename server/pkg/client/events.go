@@ -0,0 +1,48 @@
+package client
+
+import "fethur/internal/events"
+
+// Event is a frame of the event stream; see GET /api/schema/events for
+// every type and payload
+type Event = events.Event
+
+// EventType identifies an event
+type EventType = events.Type
+
+// Payloads of typed events
+type (
+	Activity        = events.Activity
+	SubscriptionAck = events.SubscriptionAck
+	Subscriptions   = events.Subscriptions
+	Settings        = events.Settings
+)
+
+// Chat event types
+const (
+	EventText           = events.TypeText
+	EventMessageUpdate  = events.TypeMessageUpdate
+	EventMessageDelete  = events.TypeMessageDelete
+	EventJoin           = events.TypeJoin
+	EventLeave          = events.TypeLeave
+	EventTyping         = events.TypeTyping
+	EventStopTyping     = events.TypeStopTyping
+	EventSubscribe      = events.TypeSubscribe
+	EventUnsubscribe    = events.TypeUnsubscribe
+	EventSubscribeAck   = events.TypeSubscribeAck
+	EventUnsubscribeAck = events.TypeUnsubscribeAck
+	EventSubscriptions  = events.TypeSubscriptions
+	EventSettings       = events.TypeSettings
+	EventSettingsAck    = events.TypeSettingsAck
+	EventActivity       = events.TypeActivity
+	EventCatchUp        = events.TypeCatchUp
+	EventNotification   = events.TypeNotification
+	EventModeration     = events.TypeModeration
+	EventHeartbeat      = events.TypeHeartbeat
+	EventPong           = events.TypePong
+	EventError          = events.TypeError
+)
+
+// DecodeData converts an event's data into one of the payload types
+func DecodeData(data interface{}, payload interface{}) error {
+	return events.DecodeData(data, payload)
+}
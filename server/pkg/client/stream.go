@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNotConnected is returned when sending on a stream that is not connected
+var ErrNotConnected = errors.New("stream is not connected")
+
+// Handler receives one event
+type Handler func(Event)
+
+// Stream follows the chat WebSocket. Run keeps it connected, reconnecting
+// with exponential backoff, and hands every event to the handlers
+// registered for its type. Handlers run one at a time on the reading
+// goroutine, so a slow handler delays later events.
+type Stream struct {
+	client *Client
+
+	mutex      sync.RWMutex
+	handlers   map[EventType][]Handler
+	catchAll   []Handler
+	onConnect  []func()
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	writeMutex sync.Mutex
+	conn       *websocket.Conn
+}
+
+// Stream creates an event stream signed in as the client's user
+func (c *Client) Stream() *Stream {
+	return &Stream{
+		client:     c,
+		handlers:   make(map[EventType][]Handler),
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+// SetBackoff sets the first and the longest wait between reconnects
+func (s *Stream) SetBackoff(minBackoff, maxBackoff time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.minBackoff, s.maxBackoff = minBackoff, maxBackoff
+}
+
+// On registers a handler for one event type
+func (s *Stream) On(eventType EventType, handler Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[eventType] = append(s.handlers[eventType], handler)
+}
+
+// OnAny registers a handler for every event
+func (s *Stream) OnAny(handler Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.catchAll = append(s.catchAll, handler)
+}
+
+// OnConnect registers a function called after every connect and reconnect,
+// before any event of the new connection is handled
+func (s *Stream) OnConnect(handler func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onConnect = append(s.onConnect, handler)
+}
+
+// OnMessage registers a handler for new chat messages
+func (s *Stream) OnMessage(handler func(Message)) {
+	s.On(EventText, func(event Event) {
+		var data struct {
+			ID        int    `json:"id"`
+			CreatedAt string `json:"created_at"`
+			ReplyToID *int   `json:"reply_to_id"`
+		}
+		_ = DecodeData(event.Data, &data)
+		handler(Message{
+			ID:        data.ID,
+			ChannelID: int(event.ChannelID),
+			UserID:    int(event.UserID),
+			Username:  event.Username,
+			Content:   event.Content,
+			CreatedAt: data.CreatedAt,
+			ReplyToID: data.ReplyToID,
+		})
+	})
+}
+
+// OnActivity registers a handler for activities such as uploading starting
+// and ending
+func (s *Stream) OnActivity(handler func(event Event, activity Activity)) {
+	s.On(EventActivity, func(event Event) {
+		var activity Activity
+		if err := DecodeData(event.Data, &activity); err == nil {
+			handler(event, activity)
+		}
+	})
+}
+
+// Connect opens the WebSocket. Run connects on its own; Connect is for
+// callers that want to know the first connection succeeded before going on.
+func (s *Stream) Connect(ctx context.Context) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	previous := s.conn
+	s.conn = conn
+	s.writeMutex.Unlock()
+	if previous != nil {
+		_ = previous.Close()
+	}
+
+	s.mutex.RLock()
+	onConnect := append([]func(){}, s.onConnect...)
+	s.mutex.RUnlock()
+	for _, handler := range onConnect {
+		handler()
+	}
+	return nil
+}
+
+// errUnauthorized is a handshake refused for the token
+var errUnauthorized = errors.New("websocket handshake unauthorized")
+
+func (s *Stream) dial(ctx context.Context) (*websocket.Conn, error) {
+	target, err := url.Parse(s.client.baseURL + "/ws")
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "https" {
+		target.Scheme = "wss"
+	} else {
+		target.Scheme = "ws"
+	}
+
+	for attempt := 0; ; attempt++ {
+		header := http.Header{}
+		if s.client.apiKey != "" {
+			header.Set("X-API-Key", s.client.apiKey)
+		} else {
+			target.RawQuery = url.Values{"token": {s.client.Token()}}.Encode()
+		}
+		conn, resp, err := websocket.DefaultDialer.DialContext(ctx, target.String(), header)
+		if err == nil {
+			return conn, nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			return nil, err
+		}
+		// The access token expired; refresh it once and try again
+		if attempt > 0 || s.client.RefreshToken() == "" {
+			return nil, errUnauthorized
+		}
+		if err := s.client.Refresh(ctx); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUnauthorized, err)
+		}
+	}
+}
+
+// Run reads events until ctx is done, reconnecting whenever the connection
+// drops. It returns nil once ctx is done, or an error when the server no
+// longer accepts the session.
+func (s *Stream) Run(ctx context.Context) error {
+	failures := 0
+	for {
+		s.writeMutex.Lock()
+		conn := s.conn
+		s.writeMutex.Unlock()
+
+		if conn == nil {
+			if err := s.Connect(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				if errors.Is(err, errUnauthorized) {
+					return err
+				}
+				failures++
+				if !s.wait(ctx, failures) {
+					return nil
+				}
+				continue
+			}
+			failures = 0
+			continue
+		}
+
+		s.read(ctx, conn)
+
+		s.writeMutex.Lock()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		s.writeMutex.Unlock()
+		if ctx.Err() != nil {
+			return nil
+		}
+		failures++
+		if !s.wait(ctx, failures) {
+			return nil
+		}
+	}
+}
+
+// wait sleeps before reconnect attempt n, doubling the backoff with every
+// failure and adding jitter so clients dropped together do not return
+// together. It reports false if ctx was done first.
+func (s *Stream) wait(ctx context.Context, n int) bool {
+	s.mutex.RLock()
+	delay, maxBackoff := s.minBackoff, s.maxBackoff
+	s.mutex.RUnlock()
+	for i := 1; i < n && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// read hands events to handlers until the connection fails or ctx is done
+func (s *Stream) read(ctx context.Context, conn *websocket.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			_ = conn.Close()
+			return
+		}
+		s.dispatch(event)
+	}
+}
+
+func (s *Stream) dispatch(event Event) {
+	s.mutex.RLock()
+	handlers := append(append([]Handler{}, s.handlers[event.Type]...), s.catchAll...)
+	s.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Send writes an event to the server
+func (s *Stream) Send(event Event) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if s.conn == nil {
+		return ErrNotConnected
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	return s.conn.WriteJSON(event)
+}
+
+// Subscribe starts receiving a channel's events. The server answers with
+// EventSubscribeAck and restores subscriptions on its own after reconnects.
+func (s *Stream) Subscribe(channelID int) error {
+	return s.Send(Event{Type: EventSubscribe, ChannelID: int64(channelID)})
+}
+
+// Unsubscribe stops receiving a channel's events
+func (s *Stream) Unsubscribe(channelID int) error {
+	return s.Send(Event{Type: EventUnsubscribe, ChannelID: int64(channelID)})
+}
+
+// Typing shows or hides the user as typing in a channel
+func (s *Stream) Typing(channelID int, typing bool) error {
+	eventType := EventTyping
+	if !typing {
+		eventType = EventStopTyping
+	}
+	return s.Send(Event{Type: eventType, ChannelID: int64(channelID)})
+}
+
+// Close drops the current connection. Run reconnects unless its context is
+// done; cancel that to stop the stream.
+func (s *Stream) Close() error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,172 @@
+// Package webhook verifies the signed requests Fethur sends to outgoing
+// webhooks, slash command endpoints and automation hooks, and signs
+// requests for the plain incoming webhook format.
+//
+// Every request carries X-Fethur-Timestamp (Unix seconds) and
+// X-Fethur-Signature, one or more comma-separated "v1=" values, each the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with a secret. While a
+// secret is being rotated, requests are signed with the old and the new
+// secret, so receivers can switch over at their own pace.
+//
+// A receiver checks the signature and timestamp, and remembers signatures
+// it has accepted so a captured request cannot be replayed:
+//
+//	replays := webhook.NewReplayCache(webhook.DefaultTolerance)
+//	http.HandleFunc("/hooks/fethur", func(w http.ResponseWriter, r *http.Request) {
+//		body, err := webhook.VerifyRequest(r, secret, replays)
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusUnauthorized)
+//			return
+//		}
+//		// handle body
+//	})
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature headers
+const (
+	TimestampHeader = "X-Fethur-Timestamp"
+	SignatureHeader = "X-Fethur-Signature"
+)
+
+// DefaultTolerance is how far a request's timestamp may be from the
+// receiver's clock
+const DefaultTolerance = 5 * time.Minute
+
+// MaxBodySize bounds the bodies VerifyRequest reads
+const MaxBodySize = 1 << 20
+
+// Verification errors
+var (
+	ErrMissingSignature = errors.New("webhook: missing signature")
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrExpired          = errors.New("webhook: timestamp outside tolerance")
+	ErrReplayed         = errors.New("webhook: request already received")
+)
+
+// Sign returns the signature header value for a body sent at timestamp,
+// with one signature per secret
+func Sign(timestamp int64, body []byte, secrets ...string) string {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			signatures = append(signatures, "v1="+hex.EncodeToString(mac(secret, timestamp, body)))
+		}
+	}
+	return strings.Join(signatures, ",")
+}
+
+func mac(secret string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// SignRequest sets the timestamp and signature headers of a request whose
+// body is body
+func SignRequest(req *http.Request, body []byte, secrets ...string) {
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(timestamp, body, secrets...))
+}
+
+// Verify checks that one of the signatures in header matches body under
+// one of the secrets, and that timestamp is within tolerance
+func Verify(timestamp, header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	if timestamp == "" || header == "" {
+		return ErrMissingSignature
+	}
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(sent, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrExpired
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := mac(secret, sent, body)
+		for _, signature := range strings.Split(header, ",") {
+			version, value, _ := strings.Cut(strings.TrimSpace(signature), "=")
+			if version != "v1" {
+				continue
+			}
+			if decoded, err := hex.DecodeString(value); err == nil && hmac.Equal(decoded, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// VerifyRequest reads and verifies a request's body with DefaultTolerance
+// and returns it. The body stays readable for later handlers. With a
+// replay cache, a request whose signature was accepted before is refused
+// with ErrReplayed.
+func VerifyRequest(r *http.Request, secret string, replays *ReplayCache) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	signature := r.Header.Get(SignatureHeader)
+	if err := Verify(r.Header.Get(TimestampHeader), signature, body, DefaultTolerance, secret); err != nil {
+		return nil, err
+	}
+	if replays != nil && !replays.Add(signature) {
+		return nil, ErrReplayed
+	}
+	return body, nil
+}
+
+// ReplayCache remembers accepted signatures for as long as their
+// timestamps stay within tolerance. It is safe for concurrent use.
+type ReplayCache struct {
+	window time.Duration
+
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+// NewReplayCache creates a cache for requests verified with tolerance
+func NewReplayCache(tolerance time.Duration) *ReplayCache {
+	return &ReplayCache{window: 2 * tolerance, seen: make(map[string]time.Time)}
+}
+
+// Add records a signature and reports whether it is new
+func (c *ReplayCache) Add(signature string) bool {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for seen, at := range c.seen {
+		if now.Sub(at) > c.window {
+			delete(c.seen, seen)
+		}
+	}
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+	c.seen[signature] = now
+	return true
+}
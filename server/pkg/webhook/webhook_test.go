@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"message.created"}`)
+	now := time.Now().Unix()
+	timestamp := strconv.FormatInt(now, 10)
+
+	if err := Verify(timestamp, Sign(now, body, "secret"), body, DefaultTolerance, "secret"); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+
+	// Either side of a rotation verifies
+	rotating := Sign(now, body, "new", "old")
+	for _, secret := range []string{"new", "old"} {
+		if err := Verify(timestamp, rotating, body, DefaultTolerance, secret); err != nil {
+			t.Errorf("Expected %s to verify a request signed during rotation, got %v", secret, err)
+		}
+	}
+
+	stale := now - 600
+	for name, tc := range map[string]struct {
+		timestamp, signature string
+		body                 []byte
+		want                 error
+	}{
+		"wrong secret": {timestamp, Sign(now, body, "other"), body, ErrInvalidSignature},
+		"other body":   {timestamp, Sign(now, body, "secret"), []byte(`{}`), ErrInvalidSignature},
+		"stale":        {strconv.FormatInt(stale, 10), Sign(stale, body, "secret"), body, ErrExpired},
+		"unsigned":     {timestamp, "", body, ErrMissingSignature},
+		"bad version":  {timestamp, strings.Replace(Sign(now, body, "secret"), "v1=", "v0=", 1), body, ErrInvalidSignature},
+	} {
+		if err := Verify(tc.timestamp, tc.signature, tc.body, DefaultTolerance, "secret"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`{"content":"deployed"}`)
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader(string(body)))
+	SignRequest(req, body, "secret")
+	replays := NewReplayCache(DefaultTolerance)
+
+	got, err := VerifyRequest(req, "secret", replays)
+	if err != nil || string(got) != string(body) {
+		t.Fatalf("Expected the request to verify, got %q (%v)", got, err)
+	}
+	if again, _ := io.ReadAll(req.Body); string(again) != string(body) {
+		t.Errorf("Expected the body to stay readable, got %q", again)
+	}
+
+	// The same request sent again is a replay
+	replay := httptest.NewRequest("POST", "/hook", strings.NewReader(string(body)))
+	replay.Header = req.Header.Clone()
+	if _, err := VerifyRequest(replay, "secret", replays); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected a replay to be refused, got %v", err)
+	}
+}